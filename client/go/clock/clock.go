@@ -0,0 +1,77 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-8-1 10:12:36
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-8-1 10:12:36
+* @Description: ConcordKV Go client clock
+ */
+
+// Package clock 定义组件计时使用的时钟接口。依赖时间的组件（连接池、智能路由器）通过配置注入时钟，
+// 未配置时使用真实时钟；测试注入testutil.FakeClock，由Advance推进时间并按顺序触发到期的定时器，
+// 不需要等待真实时间。服务端（raftserver/clock）保留一份相同的接口
+package clock
+
+import "time"
+
+// Clock 时钟
+type Clock interface {
+	// Now 当前时间
+	Now() time.Time
+	// NewTimer 创建d之后触发一次的定时器
+	NewTimer(d time.Duration) Timer
+	// NewTicker 创建每隔d触发一次的周期定时器，d必须大于0
+	NewTicker(d time.Duration) Ticker
+	// Sleep 阻塞d
+	Sleep(d time.Duration)
+	// After d之后收到当前时间的通道
+	After(d time.Duration) <-chan time.Time
+}
+
+// Timer 一次性定时器，语义与time.Timer相同
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker 周期定时器，语义与time.Ticker相同
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// Real 返回使用time包的真实时钟
+func Real() Clock {
+	return realClock{}
+}
+
+// Or 返回c，c为nil时返回真实时钟，供组件处理未配置时钟的情况
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real()
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
@@ -0,0 +1,548 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-26 15:52:07
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-26 15:52:07
+* @Description: ConcordKV cluster administration CLI - key-value and cluster commands
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	concord "github.com/concordkv/client/go/pkg"
+)
+
+// runGet 读取键
+func runGet(c *cli, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	if err := parseArgs(fs, args, 1, "get <key>"); err != nil {
+		return err
+	}
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := c.context()
+	defer cancel()
+	key := fs.Arg(0)
+	value, err := client.GetCtx(ctx, key)
+	if err != nil {
+		return fmt.Errorf("读取 %s 失败: %w", key, err)
+	}
+	return c.print(map[string]string{"key": key, "value": value}, func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, value)
+	})
+}
+
+// runSet 写入键
+func runSet(c *cli, args []string) error {
+	fs := flag.NewFlagSet("set", flag.ContinueOnError)
+	ttl := fs.Duration("ttl", 0, "过期时间")
+	if err := parseArgs(fs, args, 2, "set [-ttl 时长] <key> <value>"); err != nil {
+		return err
+	}
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := c.context()
+	defer cancel()
+	key := fs.Arg(0)
+	index, err := client.SetWithTTLCtx(ctx, key, fs.Arg(1), *ttl)
+	if err != nil {
+		return fmt.Errorf("写入 %s 失败: %w", key, err)
+	}
+	return c.print(map[string]interface{}{"key": key, "index": index}, func(tw *tabwriter.Writer) {
+		fmt.Fprintf(tw, "OK（日志索引 %d）\n", index)
+	})
+}
+
+// runDel 删除键
+func runDel(c *cli, args []string) error {
+	fs := flag.NewFlagSet("del", flag.ContinueOnError)
+	if err := parseArgs(fs, args, 1, "del <key>"); err != nil {
+		return err
+	}
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := c.context()
+	defer cancel()
+	key := fs.Arg(0)
+	if err := client.DeleteCtx(ctx, key); err != nil {
+		return fmt.Errorf("删除 %s 失败: %w", key, err)
+	}
+	return c.print(map[string]string{"key": key}, func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, "OK")
+	})
+}
+
+// runScan 按前缀分页扫描，最多输出limit个键
+func runScan(c *cli, args []string) error {
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "键前缀")
+	limit := fs.Int("limit", 100, "最多输出的键数")
+	values := fs.Bool("values", false, "同时输出值")
+	if err := parseArgs(fs, args, 0, "scan [-prefix p] [-limit n] [-values]"); err != nil {
+		return err
+	}
+	if *limit <= 0 {
+		return usagef("-limit 必须大于0")
+	}
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := c.context()
+	defer cancel()
+	var items []concord.ScanItem
+	truncated := false
+	opts := concord.ScanOptions{Prefix: *prefix, WithValues: *values}
+	for {
+		opts.Limit = *limit - len(items)
+		page, err := client.ScanCtx(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("扫描失败: %w", err)
+		}
+		if *values {
+			items = append(items, page.Items...)
+		} else {
+			for _, key := range page.Keys {
+				items = append(items, concord.ScanItem{Key: key})
+			}
+		}
+		if !page.HasMore {
+			break
+		}
+		if len(items) >= *limit {
+			truncated = true
+			break
+		}
+		opts.Cursor = page.Cursor
+	}
+
+	return c.print(map[string]interface{}{"items": items, "truncated": truncated}, func(tw *tabwriter.Writer) {
+		for _, item := range items {
+			if *values {
+				fmt.Fprintf(tw, "%s\t%s\n", item.Key, item.Value)
+			} else {
+				fmt.Fprintln(tw, item.Key)
+			}
+		}
+		if truncated {
+			fmt.Fprintf(tw, "（只显示前 %d 个键，使用 -limit 查看更多）\n", *limit)
+		}
+	})
+}
+
+// runMembers 成员查看与变更
+func runMembers(c *cli, args []string) error {
+	const usage = "members list | add [-dc dc] [-learner] <id> <address> | remove <id> | promote <id>"
+	if len(args) == 0 {
+		return usagef("用法: %s", usage)
+	}
+	fs := flag.NewFlagSet("members "+args[0], flag.ContinueOnError)
+	dc := fs.String("dc", "", "新成员所在的数据中心")
+	learner := fs.Bool("learner", false, "以学习者身份加入，不参与投票")
+
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	ctx, cancel := c.context()
+	defer cancel()
+
+	var members []concord.Member
+	switch args[0] {
+	case "list":
+		if err := parseArgs(fs, args[1:], 0, usage); err != nil {
+			return err
+		}
+		membership, err := client.Members(ctx)
+		if err != nil {
+			return fmt.Errorf("获取成员失败: %w", err)
+		}
+		err = printMembers(c, membership.Servers)
+		if err == nil && membership.Changing && c.output == "table" {
+			fmt.Fprintln(c.stdout, "注意: 有进行中的成员变更")
+		}
+		return err
+	case "add":
+		if err := parseArgs(fs, args[1:], 2, usage); err != nil {
+			return err
+		}
+		member := concord.Member{ID: fs.Arg(0), Address: fs.Arg(1), DataCenter: *dc, IsLearner: *learner}
+		members, err = client.AddMember(ctx, member)
+	case "remove":
+		if err := parseArgs(fs, args[1:], 1, usage); err != nil {
+			return err
+		}
+		members, err = client.RemoveMember(ctx, fs.Arg(0))
+	case "promote":
+		if err := parseArgs(fs, args[1:], 1, usage); err != nil {
+			return err
+		}
+		members, err = client.PromoteLearner(ctx, fs.Arg(0))
+	default:
+		return usagef("未知的子命令 %q，用法: %s", args[0], usage)
+	}
+	if err != nil {
+		return fmt.Errorf("成员变更失败: %w", err)
+	}
+	return printMembers(c, members)
+}
+
+// printMembers 输出成员列表
+func printMembers(c *cli, members []concord.Member) error {
+	if members == nil {
+		members = []concord.Member{}
+	}
+	return c.print(members, func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, "ID\tADDRESS\tDATACENTER\tROLE")
+		for _, m := range members {
+			role := "voter"
+			if m.IsLearner {
+				role = "learner"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", m.ID, m.Address, orDash(m.DataCenter), role)
+		}
+	})
+}
+
+// runTransferLeader 转移领导权
+func runTransferLeader(c *cli, args []string) error {
+	fs := flag.NewFlagSet("transfer-leader", flag.ContinueOnError)
+	if err := parseArgs(fs, args, 1, "transfer-leader <node>"); err != nil {
+		return err
+	}
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := c.context()
+	defer cancel()
+	target := fs.Arg(0)
+	previous, err := client.TransferLeader(ctx, target)
+	if err != nil {
+		return fmt.Errorf("转移领导权失败: %w", err)
+	}
+	return c.print(map[string]string{"previousLeader": previous, "leader": target}, func(tw *tabwriter.Writer) {
+		fmt.Fprintf(tw, "领导权已从 %s 转移到 %s\n", previous, target)
+	})
+}
+
+// snapshotReport 一个节点的快照结果
+type snapshotReport struct {
+	Endpoint string                `json:"endpoint"`
+	Snapshot *concord.SnapshotInfo `json:"snapshot,omitempty"`
+	Error    string                `json:"error,omitempty"`
+}
+
+// runSnapshot 并发地在指定节点（默认所有节点）上创建快照，部分节点失败时退出码为3
+func runSnapshot(c *cli, args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	if err := parseArgs(fs, args, -1, "snapshot [endpoint...]"); err != nil {
+		return err
+	}
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	endpoints := fs.Args()
+	if len(endpoints) == 0 {
+		endpoints = c.endpoints
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+
+	reports := make([]snapshotReport, len(endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func(i int, endpoint string) {
+			defer wg.Done()
+			info, err := client.TriggerSnapshot(ctx, endpoint)
+			reports[i] = snapshotReport{Endpoint: endpoint, Snapshot: info, Error: errorString(err)}
+		}(i, endpoint)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, report := range reports {
+		if report.Error != "" {
+			failed++
+		}
+	}
+	err = c.print(reports, func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, "ENDPOINT\tINDEX\tTERM\tSIZE\tCOUNT\tERROR")
+		for _, r := range reports {
+			if r.Snapshot == nil {
+				fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t%s\n", r.Endpoint, r.Error)
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t\n", r.Endpoint, r.Snapshot.LastSnapshotIndex,
+				r.Snapshot.LastSnapshotTerm, r.Snapshot.SnapshotSize, r.Snapshot.SnapshotCount)
+		}
+	})
+	switch {
+	case err != nil:
+		return err
+	case failed == len(reports):
+		return fmt.Errorf("所有 %d 个节点的快照都失败", failed)
+	case failed > 0:
+		return &partialError{msg: fmt.Sprintf("%d/%d 个节点的快照失败", failed, len(reports))}
+	}
+	return nil
+}
+
+// runBackup 从节点导出备份到文件；未指定-endpoint时依次尝试各节点
+// 先写入临时文件，校验通过后再重命名，失败时不会留下不完整的备份
+func runBackup(c *cli, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	out := fs.String("o", "", "备份文件路径")
+	since := fs.Int64("since", -1, "导出该索引之后的增量备份，默认完整备份")
+	endpoint := fs.String("endpoint", "", "导出备份的节点，默认依次尝试所有节点")
+	const usage = "backup -o <文件> [-since N] [-endpoint e]"
+	if err := parseArgs(fs, args, 0, usage); err != nil {
+		return err
+	}
+	if *out == "" {
+		return usagef("缺少 -o，用法: %s", usage)
+	}
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	endpoints := c.endpoints
+	if *endpoint != "" {
+		endpoints = []string{*endpoint}
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+
+	tmp := *out + ".tmp"
+	var lastErr error
+	for _, e := range endpoints {
+		lastErr = fetchBackup(ctx, client, e, *since, tmp)
+		if lastErr == nil {
+			break
+		}
+		fmt.Fprintf(c.stderr, "从 %s 导出备份失败: %v\n", e, lastErr)
+	}
+	if lastErr != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("导出备份失败: %w", lastErr)
+	}
+
+	header, err := verifyBackup(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("导出的备份无效: %w", err)
+	}
+	if err := os.Rename(tmp, *out); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("保存备份失败: %w", err)
+	}
+	return c.print(map[string]interface{}{"file": *out, "header": header}, func(tw *tabwriter.Writer) {
+		fmt.Fprintf(tw, "已写入 %s（%s，节点 %s，索引 %d，任期 %d，%d 字节）\n",
+			*out, header.Kind, header.NodeID, header.Index, header.Term, header.Size)
+	})
+}
+
+// fetchBackup 从一个节点导出备份到path
+func fetchBackup(ctx context.Context, client *concord.Client, endpoint string, since int64, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := client.Backup(ctx, endpoint, since, f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// backupHeader 备份文件头部，与服务端backup包的格式一致
+type backupHeader struct {
+	Version    int       `json:"version"`
+	Kind       string    `json:"kind"`
+	NodeID     string    `json:"nodeId,omitempty"`
+	Index      uint64    `json:"index"`
+	Term       uint64    `json:"term"`
+	SinceIndex uint64    `json:"sinceIndex,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Size       int64     `json:"size"`
+	Checksum   string    `json:"checksum"`
+}
+
+// backupMagic 备份文件第一行的魔数
+const backupMagic = "CONCORDKV-BACKUP"
+
+// errChecksumMismatch 备份负载的校验和与头部记录的不一致
+var errChecksumMismatch = errors.New("备份校验和不匹配")
+
+// verifyBackup 读取备份头部并校验负载的大小与SHA-256
+func verifyBackup(path string) (*backupHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("读取备份格式行失败: %w", err)
+	}
+	if fields := strings.Fields(line); len(fields) != 2 || fields[0] != backupMagic {
+		return nil, fmt.Errorf("不是ConcordKV备份文件")
+	}
+	line, err = reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("读取备份头部失败: %w", err)
+	}
+	var header backupHeader
+	if err := json.Unmarshal([]byte(line), &header); err != nil {
+		return nil, fmt.Errorf("解析备份头部失败: %w", err)
+	}
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, io.LimitReader(reader, header.Size))
+	if err != nil {
+		return nil, fmt.Errorf("读取备份负载失败: %w", err)
+	}
+	if n != header.Size {
+		return nil, fmt.Errorf("备份不完整: 负载应为 %d 字节，实际 %d 字节", header.Size, n)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != header.Checksum {
+		return nil, errChecksumMismatch
+	}
+	return &header, nil
+}
+
+// runRestore 校验备份文件，并给出以其初始化新节点的命令；恢复在服务端启动时离线进行
+func runRestore(c *cli, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	node := fs.String("node", "node1", "恢复出的节点ID")
+	dataDir := fs.String("data-dir", "data/restored", "新节点的空数据目录")
+	listen := fs.String("listen", ":8080", "新节点的Raft监听地址")
+	api := fs.String("api", ":8081", "新节点的API监听地址")
+	if err := parseArgs(fs, args, 1, "restore [-node id] [-data-dir dir] [-listen addr] [-api addr] <文件>"); err != nil {
+		return err
+	}
+	path := fs.Arg(0)
+	header, err := verifyBackup(path)
+	if err != nil {
+		return fmt.Errorf("备份 %s 无效: %w", path, err)
+	}
+	if header.Kind != "full" {
+		return fmt.Errorf("%s 是增量备份，先用raftserver的backup工具与完整备份合并（backup merge）后再恢复", path)
+	}
+
+	command := fmt.Sprintf("concord_raft -node %s -listen %s -api %s -data-dir %s -restore %s", *node, *listen, *api, *dataDir, path)
+	return c.print(map[string]interface{}{"file": path, "header": header, "command": command}, func(tw *tabwriter.Writer) {
+		fmt.Fprintf(tw, "备份有效: 节点 %s，索引 %d，任期 %d，创建于 %s\n",
+			header.NodeID, header.Index, header.Term, header.CreatedAt.Format(time.RFC3339))
+		fmt.Fprintf(tw, "以该备份启动单节点集群，之后再用 members add 扩容:\n  %s\n", command)
+	})
+}
+
+// runFailover 故障转移状态与人工确认
+func runFailover(c *cli, args []string) error {
+	const usage = "failover status | approve <id> | reject <id> [原因]"
+	if len(args) == 0 {
+		return usagef("用法: %s", usage)
+	}
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	ctx, cancel := c.context()
+	defer cancel()
+
+	switch {
+	case args[0] == "status" && len(args) == 1:
+		stats, err := client.FailoverStats(ctx)
+		if err != nil {
+			return fmt.Errorf("获取故障转移统计失败: %w", err)
+		}
+		pending, err := client.PendingFailovers(ctx)
+		if err != nil {
+			return fmt.Errorf("获取待确认的故障转移失败: %w", err)
+		}
+		if pending == nil {
+			pending = []concord.PendingFailover{}
+		}
+		return c.print(map[string]interface{}{"stats": stats, "pending": pending}, func(tw *tabwriter.Writer) {
+			fmt.Fprintf(tw, "故障转移\t%d（成功 %d，失败 %d，回滚 %d）\n", stats.TotalFailovers,
+				stats.SuccessfulFailovers, stats.FailedFailovers, stats.Rollbacks)
+			fmt.Fprintf(tw, "平均耗时\t%v\n", time.Duration(stats.AverageFailoverTimeMs)*time.Millisecond)
+			fmt.Fprintf(tw, "累计停机\t%v\n", time.Duration(stats.TotalDowntimeMs)*time.Millisecond)
+			fmt.Fprintf(tw, "冷却中\t%v\n", stats.InCooldown)
+			if len(pending) == 0 {
+				fmt.Fprintln(tw, "待确认\t无")
+				return
+			}
+			fmt.Fprintln(tw, "\nID\tFAILED\tTARGET\tCONFIDENCE\tEXPIRES\tREASON")
+			for _, d := range pending {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%.2f\t%s\t%s\n", d.ID, d.FailedDC, d.TargetDC, d.Confidence,
+					d.ExpiresAt.Format(time.RFC3339), d.Reason)
+			}
+		})
+	case args[0] == "approve" && len(args) == 2:
+		if err := client.ApproveFailover(ctx, args[1]); err != nil {
+			return fmt.Errorf("批准故障转移 %s 失败: %w", args[1], err)
+		}
+		return c.print(map[string]string{"id": args[1], "decision": "approved"}, func(tw *tabwriter.Writer) {
+			fmt.Fprintf(tw, "已批准故障转移 %s\n", args[1])
+		})
+	case args[0] == "reject" && (len(args) == 2 || len(args) == 3):
+		reason := ""
+		if len(args) == 3 {
+			reason = args[2]
+		}
+		if err := client.RejectFailover(ctx, args[1], reason); err != nil {
+			return fmt.Errorf("拒绝故障转移 %s 失败: %w", args[1], err)
+		}
+		return c.print(map[string]string{"id": args[1], "decision": "rejected"}, func(tw *tabwriter.Writer) {
+			fmt.Fprintf(tw, "已拒绝故障转移 %s\n", args[1])
+		})
+	}
+	return usagef("用法: %s", usage)
+}
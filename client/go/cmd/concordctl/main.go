@@ -0,0 +1,340 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-26 15:10:42
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-26 15:10:42
+* @Description: ConcordKV cluster administration CLI - main.go
+ */
+
+// concordctl 基于Go客户端的集群查看与运维工具
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	concord "github.com/concordkv/client/go/pkg"
+)
+
+// 退出码，脚本据此区分失败类型
+const (
+	exitOK      = 0 // 成功
+	exitFailure = 1 // 命令失败
+	exitUsage   = 2 // 参数错误
+	exitPartial = 3 // 部分节点失败或节点间状态不一致，可用的结果已输出
+)
+
+// 环境变量，优先级低于命令行参数、高于配置文件
+const (
+	envEndpoints = "CONCORDCTL_ENDPOINTS"
+	envToken     = "CONCORDCTL_TOKEN"
+	envConfig    = "CONCORDCTL_CONFIG"
+)
+
+// defaultConfigFile 用户主目录下的默认配置文件
+const defaultConfigFile = ".concordctl.json"
+
+// usageError 参数错误
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string { return e.msg }
+
+// usagef 构造参数错误
+func usagef(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// partialError 部分节点失败，成功节点的结果已输出
+type partialError struct {
+	msg string
+}
+
+func (e *partialError) Error() string { return e.msg }
+
+// fileConfig 配置文件内容
+type fileConfig struct {
+	Endpoints []string `json:"endpoints"`
+	Token     string   `json:"token"`
+	Output    string   `json:"output"`
+	Timeout   string   `json:"timeout"`
+}
+
+// cli 一次命令执行的全局选项与输出
+type cli struct {
+	endpoints []string
+	token     string
+	output    string // table或json
+	timeout   time.Duration
+	stdout    io.Writer
+	stderr    io.Writer
+}
+
+// command 子命令
+type command struct {
+	name    string
+	summary string
+	run     func(c *cli, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"status", "汇总所有节点的状态，标出任期与领导者不一致的节点", runStatus},
+		{"get", "读取键: get <key>", runGet},
+		{"set", "写入键: set [-ttl 时长] <key> <value>", runSet},
+		{"del", "删除键: del <key>", runDel},
+		{"scan", "按前缀扫描键: scan [-prefix p] [-limit n] [-values]", runScan},
+		{"members", "成员管理: members list | add [-dc dc] [-learner] <id> <address> | remove <id> | promote <id>", runMembers},
+		{"transfer-leader", "转移领导权: transfer-leader <node>", runTransferLeader},
+		{"snapshot", "在节点上立即创建快照: snapshot [endpoint...]，默认所有节点", runSnapshot},
+		{"backup", "导出备份: backup -o <文件> [-since N] [-endpoint e]", runBackup},
+		{"restore", "校验备份文件并给出以其启动新节点的命令: restore [-node id] [-data-dir dir] <文件>", runRestore},
+		{"failover", "故障转移: failover status | approve <id> | reject <id> [原因]", runFailover},
+		{"metrics", "指标变化率: metrics diff [-interval 10s] [-endpoint e] [-match 子串] [-all]", runMetrics},
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run 解析全局选项并执行子命令，返回退出码
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("concordctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	endpoints := fs.String("endpoints", "", "逗号分隔的节点API地址（也可用 "+envEndpoints+" 或配置文件指定）")
+	token := fs.String("token", "", "API访问令牌（也可用 "+envToken+" 指定）")
+	output := fs.String("o", "", "输出格式: table或json，默认table")
+	timeout := fs.Duration("timeout", 0, "每个命令的超时，默认10s")
+	configPath := fs.String("config", "", "配置文件路径（默认 $"+envConfig+" 或 ~/"+defaultConfigFile+"）")
+	fs.Usage = func() { printUsage(stderr, fs) }
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		printUsage(stderr, fs)
+		return exitUsage
+	}
+
+	c, err := newCLI(*endpoints, *token, *output, *timeout, *configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "错误: %v\n", err)
+		return exitUsage
+	}
+	c.stdout, c.stderr = stdout, stderr
+
+	name := fs.Arg(0)
+	if name == "help" {
+		printUsage(stdout, fs)
+		return exitOK
+	}
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		return c.exitCode(cmd.run(c, fs.Args()[1:]))
+	}
+	fmt.Fprintf(stderr, "未知的命令 %q\n\n", name)
+	printUsage(stderr, fs)
+	return exitUsage
+}
+
+// printUsage 输出用法
+func printUsage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "用法: concordctl [全局选项] <命令> [参数]\n\n命令:\n")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n全局选项:\n")
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+	fmt.Fprintf(w, "\n退出码: 0成功，1失败，2参数错误，3部分节点失败或节点间状态不一致\n")
+}
+
+// newCLI 合并命令行参数、环境变量与配置文件，命令行参数优先
+func newCLI(endpoints, token, output string, timeout time.Duration, configPath string) (*cli, error) {
+	file, err := loadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &cli{output: "table", timeout: 10 * time.Second}
+	switch {
+	case endpoints != "":
+		c.endpoints = splitList(endpoints)
+	case os.Getenv(envEndpoints) != "":
+		c.endpoints = splitList(os.Getenv(envEndpoints))
+	default:
+		c.endpoints = file.Endpoints
+	}
+	switch {
+	case token != "":
+		c.token = token
+	case os.Getenv(envToken) != "":
+		c.token = os.Getenv(envToken)
+	default:
+		c.token = file.Token
+	}
+	if output != "" {
+		c.output = output
+	} else if file.Output != "" {
+		c.output = file.Output
+	}
+	if c.output != "table" && c.output != "json" {
+		return nil, fmt.Errorf("无效的输出格式 %q，应为table或json", c.output)
+	}
+	if timeout > 0 {
+		c.timeout = timeout
+	} else if file.Timeout != "" {
+		d, err := time.ParseDuration(file.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("配置文件中的timeout无效: %w", err)
+		}
+		c.timeout = d
+	}
+	return c, nil
+}
+
+// loadConfig 读取配置文件；未指定路径且默认配置文件不存在时返回空配置
+func loadConfig(path string) (*fileConfig, error) {
+	explicit := path != ""
+	if !explicit {
+		path = os.Getenv(envConfig)
+		explicit = path != ""
+	}
+	if !explicit {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return &fileConfig{}, nil
+		}
+		path = filepath.Join(home, defaultConfigFile)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, os.ErrNotExist) {
+			return &fileConfig{}, nil
+		}
+		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+	var config fileConfig
+	if len(bytes.TrimSpace(data)) == 0 {
+		return &config, nil
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("解析配置文件 %s 失败: %w", path, err)
+	}
+	return &config, nil
+}
+
+// splitList 拆分逗号分隔的列表，忽略空项
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// exitCode 输出错误并转换为退出码
+func (c *cli) exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	fmt.Fprintf(c.stderr, "错误: %v\n", err)
+
+	var usage *usageError
+	var partial *partialError
+	switch {
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.As(err, &partial):
+		return exitPartial
+	default:
+		return exitFailure
+	}
+}
+
+// client 创建Go客户端；运维命令不注册会话，失败时只重试一轮其余节点
+func (c *cli) client() (*concord.Client, error) {
+	if len(c.endpoints) == 0 {
+		return nil, usagef("未指定节点，使用 -endpoints、%s 或配置文件", envEndpoints)
+	}
+	return concord.NewClient(concord.Config{
+		Endpoints:      c.endpoints,
+		Token:          c.token,
+		Timeout:        c.timeout,
+		RetryCount:     2,
+		RetryInterval:  200 * time.Millisecond,
+		DisableSession: true,
+		LogLevel:       "error",
+	})
+}
+
+// context 带命令超时的上下文
+func (c *cli) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), c.timeout)
+}
+
+// print 按输出格式输出结果：json输出v，table调用table写表格
+func (c *cli) print(v interface{}, table func(tw *tabwriter.Writer)) error {
+	if c.output == "json" {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+// parseArgs 解析子命令的选项，要求恰好n个位置参数（n < 0时不检查）
+func parseArgs(fs *flag.FlagSet, args []string, n int, usage string) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return usagef("%v，用法: %s", err, usage)
+	}
+	if n >= 0 && fs.NArg() != n {
+		return usagef("用法: %s", usage)
+	}
+	return nil
+}
+
+// errorString 错误描述，nil时为空串
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// sortedKeys 排序后的映射键
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,493 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-26 16:40:12
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-26 16:40:12
+* @Description: ConcordKV cluster administration CLI tests
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeCluster 用httptest模拟的集群，所有节点共享键值与成员状态
+type fakeCluster struct {
+	mu       sync.Mutex
+	data     map[string]string
+	members  []map[string]interface{}
+	pending  map[string]bool
+	scrapes  int
+	requests []string
+	nodes    []*fakeNode
+}
+
+// fakeNode 一个模拟节点，任期与领导者可以单独设置
+type fakeNode struct {
+	id     string
+	term   uint64
+	leader string
+	server *httptest.Server
+}
+
+func newFakeCluster(t *testing.T, n int) *fakeCluster {
+	t.Helper()
+	fc := &fakeCluster{data: make(map[string]string), pending: map[string]bool{"fo-1": true}}
+	for i := 1; i <= n; i++ {
+		node := &fakeNode{id: fmt.Sprintf("node%d", i), term: 3, leader: "node1"}
+		node.server = httptest.NewServer(fc.handler(node))
+		t.Cleanup(node.server.Close)
+		fc.nodes = append(fc.nodes, node)
+		fc.members = append(fc.members, map[string]interface{}{"id": node.id, "address": node.server.URL})
+	}
+	return fc
+}
+
+// endpoints 所有节点的地址，逗号分隔
+func (fc *fakeCluster) endpoints() string {
+	var urls []string
+	for _, node := range fc.nodes {
+		urls = append(urls, node.server.URL)
+	}
+	return strings.Join(urls, ",")
+}
+
+func (fc *fakeCluster) handler(node *fakeNode) http.Handler {
+	writeJSON := func(w http.ResponseWriter, status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+		fc.requests = append(fc.requests, node.id+" "+r.Method+" "+r.URL.Path+" "+r.Header.Get("Authorization"))
+
+		var body map[string]interface{}
+		if r.Body != nil {
+			json.NewDecoder(r.Body).Decode(&body)
+		}
+		str := func(key string) string {
+			s, _ := body[key].(string)
+			return s
+		}
+
+		switch r.URL.Path {
+		case "/api/status":
+			writeJSON(w, 200, map[string]interface{}{
+				"nodeId": node.id, "state": "Follower", "term": node.term, "leader": node.leader,
+				"commitIndex": 42, "lastApplied": 42, "isLeader": node.leader == node.id,
+			})
+		case "/api/get":
+			value, ok := fc.data[r.URL.Query().Get("key")]
+			if !ok {
+				writeJSON(w, 404, map[string]interface{}{"success": false, "error": map[string]string{"code": "KEY_NOT_FOUND", "message": "键不存在"}})
+				return
+			}
+			writeJSON(w, 200, map[string]interface{}{"success": true, "exists": true, "value": value})
+		case "/api/set":
+			fc.data[str("key")] = str("value")
+			writeJSON(w, 200, map[string]interface{}{"success": true, "index": 7})
+		case "/api/delete":
+			delete(fc.data, r.URL.Query().Get("key"))
+			writeJSON(w, 200, map[string]interface{}{"success": true})
+		case "/api/scan":
+			prefix := r.URL.Query().Get("prefix")
+			var keys []string
+			for key := range fc.data {
+				if strings.HasPrefix(key, prefix) && key > r.URL.Query().Get("cursor") {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			// 每页最多两个键，验证分页
+			hasMore := len(keys) > 2
+			if hasMore {
+				keys = keys[:2]
+			}
+			var items []map[string]string
+			for _, key := range keys {
+				items = append(items, map[string]string{"key": key, "value": fc.data[key]})
+			}
+			cursor := ""
+			if hasMore {
+				cursor = keys[len(keys)-1]
+			}
+			writeJSON(w, 200, map[string]interface{}{"success": true, "keys": keys, "items": items, "hasMore": hasMore, "cursor": cursor})
+		case "/api/cluster/config":
+			writeJSON(w, 200, map[string]interface{}{"configuration": map[string]interface{}{"servers": fc.members}})
+		case "/api/cluster/add":
+			fc.members = append(fc.members, map[string]interface{}{"id": str("id"), "address": str("address"), "isLearner": body["learner"]})
+			writeJSON(w, 200, map[string]interface{}{"success": true, "configuration": fc.members})
+		case "/api/cluster/remove":
+			var kept []map[string]interface{}
+			for _, m := range fc.members {
+				if m["id"] != str("id") {
+					kept = append(kept, m)
+				}
+			}
+			fc.members = kept
+			writeJSON(w, 200, map[string]interface{}{"success": true, "configuration": fc.members})
+		case "/api/transfer-leader":
+			previous := node.leader
+			for _, n := range fc.nodes {
+				n.leader = r.URL.Query().Get("target")
+			}
+			writeJSON(w, 200, map[string]interface{}{"success": true, "previousLeader": previous, "leader": r.URL.Query().Get("target")})
+		case "/api/admin/snapshot":
+			writeJSON(w, 200, map[string]interface{}{"success": true, "nodeId": node.id,
+				"snapshot": map[string]interface{}{"lastSnapshotIndex": 42, "lastSnapshotTerm": node.term, "snapshotSize": 128, "snapshotCount": 1}})
+		case "/api/backup":
+			payload := []byte(`{"greeting":"hello"}`)
+			sum := sha256.Sum256(payload)
+			header, _ := json.Marshal(map[string]interface{}{"version": 1, "kind": "full", "nodeId": node.id,
+				"index": 42, "term": node.term, "size": len(payload), "checksum": hex.EncodeToString(sum[:])})
+			fmt.Fprintf(w, "CONCORDKV-BACKUP 1\n%s\n%s", header, payload)
+		case "/api/failover/stats":
+			writeJSON(w, 200, map[string]interface{}{"success": true, "stats": map[string]interface{}{"totalFailovers": 2, "successfulFailovers": 2, "averageFailoverTimeMs": 1500}})
+		case "/api/failover/pending":
+			var decisions []map[string]interface{}
+			for id := range fc.pending {
+				decisions = append(decisions, map[string]interface{}{"id": id, "failedDC": "dc1", "targetDC": "dc2", "reason": "dc1不可达", "confidence": 0.9})
+			}
+			writeJSON(w, 200, map[string]interface{}{"success": true, "decisions": decisions})
+		case "/api/failover/approve", "/api/failover/reject":
+			if !fc.pending[str("id")] {
+				writeJSON(w, 400, map[string]interface{}{"success": false, "error": "没有该决策"})
+				return
+			}
+			delete(fc.pending, str("id"))
+			writeJSON(w, 200, map[string]interface{}{"success": true})
+		case "/api/metrics":
+			// 每次抓取请求计数增加10，连接数不变
+			fc.scrapes++
+			fmt.Fprintf(w, "# HELP concord_requests_total 请求数\n# TYPE concord_requests_total counter\n")
+			fmt.Fprintf(w, "concord_requests_total{method=\"get\"} %d\n", fc.scrapes*10)
+			fmt.Fprintf(w, "# TYPE concord_connections gauge\nconcord_connections 5\n")
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// runCLI 执行命令，返回退出码与输出
+func runCLI(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(append([]string{"-config", os.DevNull}, args...), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestStatus(t *testing.T) {
+	fc := newFakeCluster(t, 3)
+
+	code, out, errOut := runCLI("-endpoints", fc.endpoints(), "status")
+	if code != exitOK {
+		t.Fatalf("退出码应为0，实际 %d: %s", code, errOut)
+	}
+	for _, id := range []string{"node1", "node2", "node3"} {
+		if !strings.Contains(out, id) {
+			t.Errorf("输出应包含 %s:\n%s", id, out)
+		}
+	}
+	if strings.Contains(out, "警告") {
+		t.Errorf("健康的集群不应有警告:\n%s", out)
+	}
+
+	code, out, _ = runCLI("-endpoints", fc.endpoints(), "-o", "json", "status")
+	var report clusterReport
+	if code != exitOK || json.Unmarshal([]byte(out), &report) != nil {
+		t.Fatalf("JSON输出无效（退出码 %d）:\n%s", code, out)
+	}
+	if report.Reachable != 3 || report.Term != 3 || report.Leader != "node1" {
+		t.Errorf("汇总结果错误: %+v", report)
+	}
+}
+
+func TestStatusMinorityDown(t *testing.T) {
+	fc := newFakeCluster(t, 3)
+	fc.nodes[2].server.Close()
+
+	code, out, _ := runCLI("-endpoints", fc.endpoints(), "-timeout", "2s", "status")
+	if code != exitPartial {
+		t.Fatalf("少数节点不可达时退出码应为3，实际 %d", code)
+	}
+	if !strings.Contains(out, "node1") || !strings.Contains(out, "node2") || !strings.Contains(out, "unreachable") {
+		t.Errorf("应输出可达节点并标出不可达节点:\n%s", out)
+	}
+	if !strings.Contains(out, "1 个节点不可达") {
+		t.Errorf("应提示不可达节点数:\n%s", out)
+	}
+}
+
+func TestStatusAllDown(t *testing.T) {
+	fc := newFakeCluster(t, 2)
+	endpoints := fc.endpoints()
+	for _, node := range fc.nodes {
+		node.server.Close()
+	}
+	if code, _, _ := runCLI("-endpoints", endpoints, "-timeout", "2s", "status"); code != exitFailure {
+		t.Errorf("所有节点不可达时退出码应为1，实际 %d", code)
+	}
+}
+
+func TestStatusDisagreement(t *testing.T) {
+	fc := newFakeCluster(t, 3)
+	fc.nodes[2].term = 4
+	fc.nodes[2].leader = "node3"
+
+	code, out, _ := runCLI("-endpoints", fc.endpoints(), "status")
+	if code != exitPartial {
+		t.Fatalf("任期不一致时退出码应为3，实际 %d", code)
+	}
+	if !strings.Contains(out, "任期不一致") || !strings.Contains(out, "领导者不一致") {
+		t.Errorf("应提示任期与领导者不一致:\n%s", out)
+	}
+	if !strings.Contains(out, "4 (!)") || !strings.Contains(out, "node3 (!)") {
+		t.Errorf("应标出与多数节点不一致的值:\n%s", out)
+	}
+}
+
+func TestKeyValueCommands(t *testing.T) {
+	fc := newFakeCluster(t, 3)
+	ep := fc.endpoints()
+
+	if code, out, errOut := runCLI("-endpoints", ep, "set", "-ttl", "1m", "user:1", "alice"); code != exitOK || !strings.Contains(out, "7") {
+		t.Fatalf("set失败（退出码 %d）: %s%s", code, out, errOut)
+	}
+	runCLI("-endpoints", ep, "set", "user:2", "bob")
+	runCLI("-endpoints", ep, "set", "user:3", "carol")
+	runCLI("-endpoints", ep, "set", "other", "x")
+
+	if code, out, _ := runCLI("-endpoints", ep, "get", "user:1"); code != exitOK || strings.TrimSpace(out) != "alice" {
+		t.Errorf("get应输出alice，实际 %q（退出码 %d）", out, code)
+	}
+
+	code, out, _ := runCLI("-endpoints", ep, "-o", "json", "scan", "-prefix", "user:", "-values")
+	var scan struct {
+		Items []struct {
+			Key   string
+			Value string
+		} `json:"items"`
+	}
+	if code != exitOK || json.Unmarshal([]byte(out), &scan) != nil || len(scan.Items) != 3 || scan.Items[2].Value != "carol" {
+		t.Errorf("scan应跨页返回3个键（退出码 %d）:\n%s", code, out)
+	}
+	if code, out, _ := runCLI("-endpoints", ep, "scan", "-prefix", "user:", "-limit", "2"); code != exitOK || !strings.Contains(out, "只显示前 2 个键") {
+		t.Errorf("超过limit时应提示截断（退出码 %d）:\n%s", code, out)
+	}
+
+	if code, _, _ := runCLI("-endpoints", ep, "del", "user:1"); code != exitOK {
+		t.Errorf("del失败，退出码 %d", code)
+	}
+	if code, _, errOut := runCLI("-endpoints", ep, "get", "user:1"); code != exitFailure || !strings.Contains(errOut, "键不存在") {
+		t.Errorf("读取已删除的键应失败，退出码 %d: %s", code, errOut)
+	}
+	if code, _, _ := runCLI("-endpoints", ep, "get"); code != exitUsage {
+		t.Errorf("缺少参数时退出码应为2，实际 %d", code)
+	}
+}
+
+func TestMembersAndTransferLeader(t *testing.T) {
+	fc := newFakeCluster(t, 3)
+	ep := fc.endpoints()
+
+	code, out, _ := runCLI("-endpoints", ep, "members", "add", "-learner", "node4", "127.0.0.1:9004")
+	if code != exitOK || !strings.Contains(out, "node4") || !strings.Contains(out, "learner") {
+		t.Fatalf("添加学习者失败（退出码 %d）:\n%s", code, out)
+	}
+	if code, out, _ := runCLI("-endpoints", ep, "members", "remove", "node4"); code != exitOK || strings.Contains(out, "node4") {
+		t.Errorf("移除成员失败（退出码 %d）:\n%s", code, out)
+	}
+	code, out, _ = runCLI("-endpoints", ep, "-o", "json", "members", "list")
+	var members []map[string]interface{}
+	if code != exitOK || json.Unmarshal([]byte(out), &members) != nil || len(members) != 3 {
+		t.Errorf("members list应返回3个成员（退出码 %d）:\n%s", code, out)
+	}
+
+	if code, out, _ := runCLI("-endpoints", ep, "transfer-leader", "node2"); code != exitOK || !strings.Contains(out, "node1") {
+		t.Errorf("转移领导权失败（退出码 %d）:\n%s", code, out)
+	}
+	if code, _, _ := runCLI("-endpoints", ep, "status"); code != exitOK {
+		t.Errorf("转移之后所有节点应认定同一个领导者，退出码 %d", code)
+	}
+}
+
+func TestSnapshotPartialFailure(t *testing.T) {
+	fc := newFakeCluster(t, 3)
+	ep := fc.endpoints()
+
+	if code, out, _ := runCLI("-endpoints", ep, "snapshot"); code != exitOK || snapshotRows(out) != 3 {
+		t.Errorf("所有节点都应创建快照（退出码 %d）:\n%s", code, out)
+	}
+
+	fc.nodes[1].server.Close()
+	code, out, _ := runCLI("-endpoints", ep, "-timeout", "2s", "snapshot")
+	if code != exitPartial {
+		t.Errorf("部分节点失败时退出码应为3，实际 %d", code)
+	}
+	if snapshotRows(out) != 2 {
+		t.Errorf("应输出成功节点的结果:\n%s", out)
+	}
+}
+
+// snapshotRows 快照表格中成功节点的行数
+func snapshotRows(out string) int {
+	rows := 0
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[1] == "42" {
+			rows++
+		}
+	}
+	return rows
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	fc := newFakeCluster(t, 3)
+	// 第一个节点不可达时从下一个节点导出
+	fc.nodes[0].server.Close()
+	path := filepath.Join(t.TempDir(), "full.backup")
+
+	code, out, errOut := runCLI("-endpoints", fc.endpoints(), "-timeout", "2s", "backup", "-o", path)
+	if code != exitOK || !strings.Contains(out, "node2") {
+		t.Fatalf("导出备份失败（退出码 %d）: %s%s", code, out, errOut)
+	}
+
+	code, out, _ = runCLI("restore", "-node", "node9", path)
+	if code != exitOK || !strings.Contains(out, "-restore "+path) || !strings.Contains(out, "-node node9") {
+		t.Errorf("restore应校验通过并给出启动命令（退出码 %d）:\n%s", code, out)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-2] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, errOut := runCLI("restore", path); code != exitFailure || !strings.Contains(errOut, "校验和") {
+		t.Errorf("被篡改的备份应校验失败（退出码 %d）: %s", code, errOut)
+	}
+}
+
+func TestFailoverCommands(t *testing.T) {
+	fc := newFakeCluster(t, 3)
+	ep := fc.endpoints()
+
+	code, out, _ := runCLI("-endpoints", ep, "failover", "status")
+	if code != exitOK || !strings.Contains(out, "fo-1") || !strings.Contains(out, "1.5s") {
+		t.Fatalf("failover status应列出待确认决策与平均耗时（退出码 %d）:\n%s", code, out)
+	}
+	if code, _, _ := runCLI("-endpoints", ep, "failover", "approve", "fo-1"); code != exitOK {
+		t.Errorf("批准失败，退出码 %d", code)
+	}
+	if code, _, _ := runCLI("-endpoints", ep, "failover", "approve", "fo-1"); code != exitFailure {
+		t.Errorf("重复批准应失败，退出码 %d", code)
+	}
+	if code, out, _ := runCLI("-endpoints", ep, "failover", "status"); code != exitOK || !strings.Contains(out, "待确认") || strings.Contains(out, "fo-1") {
+		t.Errorf("批准后不应再有待确认决策:\n%s", out)
+	}
+}
+
+func TestMetricsDiff(t *testing.T) {
+	fc := newFakeCluster(t, 1)
+
+	code, out, errOut := runCLI("-endpoints", fc.endpoints(), "-o", "json", "metrics", "diff", "-interval", "200ms")
+	if code != exitOK {
+		t.Fatalf("metrics diff失败（退出码 %d）: %s", code, errOut)
+	}
+	var result struct {
+		Seconds float64       `json:"seconds"`
+		Metrics []metricDelta `json:"metrics"`
+	}
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("JSON输出无效: %v\n%s", err, out)
+	}
+	if len(result.Metrics) != 1 {
+		t.Fatalf("只有计数器发生了变化，实际: %+v", result.Metrics)
+	}
+	d := result.Metrics[0]
+	if d.Series != `concord_requests_total{method="get"}` || d.Type != "counter" || d.Delta != 10 {
+		t.Errorf("计数器的变化错误: %+v", d)
+	}
+	if want := 10 / result.Seconds; d.Rate < want*0.99 || d.Rate > want*1.01 {
+		t.Errorf("速率应为 %.2f/s，实际 %.2f/s", want, d.Rate)
+	}
+
+	code, out, _ = runCLI("-endpoints", fc.endpoints(), "metrics", "diff", "-interval", "10ms", "-all")
+	if code != exitOK || !strings.Contains(out, "concord_connections") {
+		t.Errorf("-all应包含没有变化的指标:\n%s", out)
+	}
+}
+
+func TestEndpointSources(t *testing.T) {
+	fc := newFakeCluster(t, 2)
+
+	// 配置文件
+	config := filepath.Join(t.TempDir(), "concordctl.json")
+	data, _ := json.Marshal(map[string]interface{}{"endpoints": strings.Split(fc.endpoints(), ","), "token": "file-token", "output": "json"})
+	if err := os.WriteFile(config, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-config", config, "status"}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("从配置文件读取节点失败（退出码 %d）: %s", code, stderr.String())
+	}
+	if !json.Valid(stdout.Bytes()) {
+		t.Errorf("配置文件指定的输出格式应为json:\n%s", stdout.String())
+	}
+
+	// 环境变量优先于配置文件，命令行参数优先于环境变量
+	t.Setenv(envEndpoints, fc.nodes[1].server.URL)
+	t.Setenv(envToken, "env-token")
+	stdout.Reset()
+	if code := run([]string{"-config", config, "-o", "table", "get", "missing"}, &stdout, &stderr); code != exitFailure {
+		t.Errorf("读取不存在的键应失败，退出码 %d", code)
+	}
+	stdout.Reset()
+	if code := run([]string{"-config", config, "-token", "flag-token", "snapshot"}, &stdout, &stderr); code != exitOK {
+		t.Errorf("snapshot失败，退出码 %d", code)
+	}
+
+	fc.mu.Lock()
+	requests := strings.Join(fc.requests, "\n")
+	fc.mu.Unlock()
+	for _, want := range []string{"node1 GET /api/status Bearer file-token", "node2 GET /api/get Bearer env-token", "node2 POST /api/admin/snapshot Bearer flag-token"} {
+		if !strings.Contains(requests, want) {
+			t.Errorf("应有请求 %q，实际:\n%s", want, requests)
+		}
+	}
+	if strings.Contains(requests, "node1 GET /api/get") {
+		t.Errorf("环境变量指定节点时不应访问配置文件中的节点:\n%s", requests)
+	}
+
+	t.Setenv(envEndpoints, "")
+	if code, _, _ := runCLI("status"); code != exitUsage {
+		t.Errorf("未指定节点时退出码应为2，实际 %d", code)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	if code, _, _ := runCLI(); code != exitUsage {
+		t.Errorf("缺少命令时退出码应为2，实际 %d", code)
+	}
+	if code, _, errOut := runCLI("-endpoints", "127.0.0.1:1", "frobnicate"); code != exitUsage || !strings.Contains(errOut, "未知的命令") {
+		t.Errorf("未知命令的退出码应为2，实际 %d: %s", code, errOut)
+	}
+	if code, out, _ := runCLI("help"); code != exitOK || !strings.Contains(out, "metrics") {
+		t.Errorf("help应输出用法:\n%s", out)
+	}
+}
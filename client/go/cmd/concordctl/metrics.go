@@ -0,0 +1,187 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-26 16:18:45
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-26 16:18:45
+* @Description: ConcordKV cluster administration CLI - metrics diff command
+ */
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// metricSample 一次抓取中的指标值，键为带标签的序列名
+type metricSample struct {
+	values map[string]float64
+	types  map[string]string // 指标名 -> counter/gauge/...
+}
+
+// metricDelta 两次抓取之间一个序列的变化
+type metricDelta struct {
+	Series string  `json:"series"`
+	Type   string  `json:"type"`
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+	Delta  float64 `json:"delta"`
+	Rate   float64 `json:"rate"` // 每秒变化量；计数器在两次抓取之间被重置时为after/间隔
+}
+
+// runMetrics 相隔interval抓取同一节点的Prometheus指标两次，输出各序列的变化量与每秒速率
+func runMetrics(c *cli, args []string) error {
+	const usage = "metrics diff [-interval 10s] [-endpoint e] [-match 子串] [-all]"
+	if len(args) == 0 || args[0] != "diff" {
+		return usagef("用法: %s", usage)
+	}
+	fs := flag.NewFlagSet("metrics diff", flag.ContinueOnError)
+	interval := fs.Duration("interval", 10*time.Second, "两次抓取的间隔")
+	endpoint := fs.String("endpoint", "", "抓取的节点，默认第一个节点")
+	match := fs.String("match", "", "只显示序列名包含该子串的指标")
+	all := fs.Bool("all", false, "同时显示没有变化的指标")
+	if err := parseArgs(fs, args[1:], 0, usage); err != nil {
+		return err
+	}
+	if *interval <= 0 {
+		return usagef("-interval 必须大于0")
+	}
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	target := *endpoint
+	if target == "" {
+		target = c.endpoints[0]
+	}
+	scrape := func() (*metricSample, time.Time, error) {
+		ctx, cancel := c.context()
+		defer cancel()
+		text, err := client.PrometheusMetrics(ctx, target)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("抓取 %s 的指标失败: %w", target, err)
+		}
+		sample, err := parsePrometheus(text)
+		return sample, time.Now(), err
+	}
+
+	before, start, err := scrape()
+	if err != nil {
+		return err
+	}
+	time.Sleep(*interval)
+	after, end, err := scrape()
+	if err != nil {
+		return err
+	}
+
+	deltas := diffMetrics(before, after, end.Sub(start).Seconds(), *match, *all)
+	return c.print(map[string]interface{}{"endpoint": target, "seconds": end.Sub(start).Seconds(), "metrics": deltas},
+		func(tw *tabwriter.Writer) {
+			fmt.Fprintln(tw, "SERIES\tTYPE\tBEFORE\tAFTER\tDELTA\tRATE/s")
+			for _, d := range deltas {
+				rate := "-"
+				if d.Type == "counter" {
+					rate = formatFloat(d.Rate)
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", d.Series, d.Type, formatFloat(d.Before),
+					formatFloat(d.After), formatFloat(d.Delta), rate)
+			}
+		})
+}
+
+// parsePrometheus 解析Prometheus文本格式，忽略注释行与时间戳
+func parsePrometheus(text string) (*metricSample, error) {
+	sample := &metricSample{values: make(map[string]float64), types: make(map[string]string)}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) == 4 && fields[1] == "TYPE" {
+				sample.types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		// 标签值中可能有空格，序列名以最后一个'}'结束
+		series, rest := line, ""
+		if i := strings.LastIndex(line, "}"); i >= 0 {
+			series, rest = line[:i+1], line[i+1:]
+		} else if i := strings.IndexByte(line, ' '); i >= 0 {
+			series, rest = line[:i], line[i:]
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("无法解析指标行 %q", line)
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("无法解析指标行 %q: %w", line, err)
+		}
+		sample.values[series] = value
+	}
+	return sample, scanner.Err()
+}
+
+// metricType 序列所属指标的类型；直方图与摘要的_sum、_count、_bucket序列按计数器处理
+func (s *metricSample) metricType(series string) string {
+	name := series
+	if i := strings.IndexByte(name, '{'); i >= 0 {
+		name = name[:i]
+	}
+	if t, ok := s.types[name]; ok {
+		return t
+	}
+	for _, suffix := range []string{"_sum", "_count", "_bucket"} {
+		if base := strings.TrimSuffix(name, suffix); base != name {
+			if t := s.types[base]; t == "histogram" || t == "summary" {
+				return "counter"
+			}
+		}
+	}
+	return "untyped"
+}
+
+// diffMetrics 计算两次抓取都存在的序列的变化，按序列名排序
+func diffMetrics(before, after *metricSample, seconds float64, match string, all bool) []metricDelta {
+	deltas := []metricDelta{}
+	for _, series := range sortedKeys(after.values) {
+		old, ok := before.values[series]
+		if !ok || !strings.Contains(series, match) {
+			continue
+		}
+		value := after.values[series]
+		d := metricDelta{Series: series, Type: after.metricType(series), Before: old, After: value, Delta: value - old}
+		if d.Delta == 0 && !all {
+			continue
+		}
+		if d.Type == "counter" && seconds > 0 {
+			increase := d.Delta
+			if increase < 0 {
+				increase = value
+			}
+			d.Rate = increase / seconds
+		}
+		deltas = append(deltas, d)
+	}
+	return deltas
+}
+
+// formatFloat 整数值不带小数，其余保留三位小数
+func formatFloat(v float64) string {
+	if v == float64(int64(v)) {
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return strconv.FormatFloat(v, 'f', 3, 64)
+}
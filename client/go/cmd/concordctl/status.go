@@ -0,0 +1,180 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-26 15:34:20
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-26 15:34:20
+* @Description: ConcordKV cluster administration CLI - status command
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+
+	concord "github.com/concordkv/client/go/pkg"
+)
+
+// nodeReport 一个节点在status输出中的一行
+type nodeReport struct {
+	Endpoint string              `json:"endpoint"`
+	Status   *concord.NodeStatus `json:"status,omitempty"`
+	Error    string              `json:"error,omitempty"`
+	// 该节点的任期或领导者与多数节点不一致
+	TermMismatch   bool `json:"termMismatch,omitempty"`
+	LeaderMismatch bool `json:"leaderMismatch,omitempty"`
+}
+
+// clusterReport status命令的汇总结果
+type clusterReport struct {
+	Nodes     []nodeReport `json:"nodes"`
+	Reachable int          `json:"reachable"`
+	Total     int          `json:"total"`
+	Term      uint64       `json:"term"`   // 多数可达节点的任期
+	Leader    string       `json:"leader"` // 多数可达节点认定的领导者
+	Warnings  []string     `json:"warnings,omitempty"`
+}
+
+// runStatus 并发查询所有节点；少数节点不可达时仍输出其余节点，退出码为3
+func runStatus(c *cli, args []string) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	if err := parseArgs(fs, args, 0, "status"); err != nil {
+		return err
+	}
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := c.context()
+	defer cancel()
+	report := buildReport(client.ClusterStatus(ctx))
+
+	err = c.print(report, func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, "ENDPOINT\tNODE\tSTATE\tTERM\tLEADER\tCOMMIT\tAPPLIED\tERROR")
+		for _, node := range report.Nodes {
+			if node.Status == nil {
+				fmt.Fprintf(tw, "%s\t-\tunreachable\t-\t-\t-\t-\t%s\n", node.Endpoint, node.Error)
+				continue
+			}
+			s := node.Status
+			state := s.State
+			if s.Draining {
+				state += "(draining)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t\n", node.Endpoint, s.NodeID, state,
+				mark(strconv.FormatUint(s.Term, 10), node.TermMismatch), mark(orDash(s.Leader), node.LeaderMismatch),
+				s.CommitIndex, s.LastApplied)
+		}
+		tw.Flush()
+		for _, warning := range report.Warnings {
+			fmt.Fprintf(c.stdout, "警告: %s\n", warning)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case report.Reachable == 0:
+		return fmt.Errorf("所有 %d 个节点都不可达", report.Total)
+	case len(report.Warnings) > 0:
+		return &partialError{msg: fmt.Sprintf("%d/%d 个节点可达，存在 %d 条警告", report.Reachable, report.Total, len(report.Warnings))}
+	}
+	return nil
+}
+
+// buildReport 以多数可达节点的任期与领导者为基准，标出不一致的节点
+func buildReport(results []concord.EndpointStatus) *clusterReport {
+	report := &clusterReport{Total: len(results)}
+	terms := make(map[string]int)
+	leaders := make(map[string]int)
+	claimants := 0
+	for _, result := range results {
+		node := nodeReport{Endpoint: result.Endpoint, Status: result.Status, Error: errorString(result.Err)}
+		report.Nodes = append(report.Nodes, node)
+		if result.Status == nil {
+			continue
+		}
+		report.Reachable++
+		terms[strconv.FormatUint(result.Status.Term, 10)]++
+		leaders[result.Status.Leader]++
+		if result.Status.IsLeader {
+			claimants++
+		}
+	}
+	if report.Reachable == 0 {
+		return report
+	}
+
+	term := majority(terms)
+	report.Term, _ = strconv.ParseUint(term, 10, 64)
+	report.Leader = majority(leaders)
+	for i := range report.Nodes {
+		s := report.Nodes[i].Status
+		if s == nil {
+			continue
+		}
+		report.Nodes[i].TermMismatch = s.Term != report.Term
+		report.Nodes[i].LeaderMismatch = s.Leader != report.Leader
+	}
+
+	if unreachable := report.Total - report.Reachable; unreachable > 0 {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("%d 个节点不可达", unreachable))
+	}
+	if len(terms) > 1 {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("节点的任期不一致: %v", countsString(terms)))
+	}
+	if len(leaders) > 1 {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("节点认定的领导者不一致: %v", countsString(leaders)))
+	}
+	if report.Leader == "" {
+		report.Warnings = append(report.Warnings, "集群当前没有领导者")
+	}
+	if claimants > 1 {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("%d 个节点都认为自己是领导者", claimants))
+	}
+	return report
+}
+
+// majority 出现次数最多的值，次数相同时取字典序最小的，使输出稳定
+func majority(counts map[string]int) string {
+	best, bestCount := "", -1
+	for _, value := range sortedKeys(counts) {
+		if counts[value] > bestCount {
+			best, bestCount = value, counts[value]
+		}
+	}
+	return best
+}
+
+// countsString 把计数格式化为 值×次数 的列表
+func countsString(counts map[string]int) string {
+	s := ""
+	for i, value := range sortedKeys(counts) {
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("%s×%d", orDash(value), counts[value])
+	}
+	return s
+}
+
+// mark 与多数节点不一致的值加上标记
+func mark(value string, mismatch bool) string {
+	if mismatch {
+		return value + " (!)"
+	}
+	return value
+}
+
+// orDash 空值显示为"-"
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
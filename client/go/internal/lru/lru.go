@@ -0,0 +1,157 @@
+/*
+* @Author: Lzww0608
+* @Date: 22025-7-2 22:23:37
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-2 22:23:40
+* @Description: ConcordKV Go client - LRU cache
+ */
+
+// Package lru 提供定长的LRU缓存，读写和淘汰均为O(1)
+// 缓存本身不加锁，由调用方负责并发控制
+package lru
+
+// node 双向链表节点
+type node[K comparable, V any] struct {
+	key   K
+	value V
+	prev  *node[K, V]
+	next  *node[K, V]
+}
+
+// Cache LRU缓存，超过容量时淘汰最久未使用的条目
+type Cache[K comparable, V any] struct {
+	capacity int
+	items    map[K]*node[K, V]
+	root     node[K, V] // 哨兵节点：root.next为最近使用，root.prev为最久未使用
+	onEvict  func(key K, value V)
+}
+
+// New 创建LRU缓存，capacity不大于0时不限容量
+// onEvict在条目因容量不足被淘汰时调用，显式删除不会触发
+func New[K comparable, V any](capacity int, onEvict func(key K, value V)) *Cache[K, V] {
+	c := &Cache[K, V]{
+		capacity: capacity,
+		items:    make(map[K]*node[K, V]),
+		onEvict:  onEvict,
+	}
+	c.root.next = &c.root
+	c.root.prev = &c.root
+	return c
+}
+
+// Get 获取条目并标记为最近使用
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	n, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.moveToFront(n)
+	return n.value, true
+}
+
+// Peek 获取条目，不改变使用顺序
+func (c *Cache[K, V]) Peek(key K) (V, bool) {
+	n, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return n.value, true
+}
+
+// Add 写入条目并标记为最近使用，超过容量时淘汰最久未使用的条目，返回是否发生了淘汰
+func (c *Cache[K, V]) Add(key K, value V) bool {
+	if n, ok := c.items[key]; ok {
+		n.value = value
+		c.moveToFront(n)
+		return false
+	}
+
+	n := &node[K, V]{key: key, value: value}
+	c.items[key] = n
+	c.insertFront(n)
+
+	if c.capacity > 0 && len(c.items) > c.capacity {
+		c.evict(c.root.prev)
+		return true
+	}
+	return false
+}
+
+// Remove 删除条目，返回条目是否存在
+func (c *Cache[K, V]) Remove(key K) bool {
+	n, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	c.unlink(n)
+	delete(c.items, key)
+	return true
+}
+
+// RemoveOldest 淘汰最久未使用的条目
+func (c *Cache[K, V]) RemoveOldest() (K, V, bool) {
+	if len(c.items) == 0 {
+		var zeroK K
+		var zeroV V
+		return zeroK, zeroV, false
+	}
+	n := c.root.prev
+	c.evict(n)
+	return n.key, n.value, true
+}
+
+// Range 从最近使用到最久未使用依次遍历条目，f返回false时停止；遍历期间不能修改缓存
+func (c *Cache[K, V]) Range(f func(key K, value V) bool) {
+	for n := c.root.next; n != &c.root; n = n.next {
+		if !f(n.key, n.value) {
+			return
+		}
+	}
+}
+
+// Len 当前条目数
+func (c *Cache[K, V]) Len() int {
+	return len(c.items)
+}
+
+// Purge 清空缓存
+func (c *Cache[K, V]) Purge() {
+	c.items = make(map[K]*node[K, V])
+	c.root.next = &c.root
+	c.root.prev = &c.root
+}
+
+// evict 淘汰节点并通知调用方
+func (c *Cache[K, V]) evict(n *node[K, V]) {
+	c.unlink(n)
+	delete(c.items, n.key)
+	if c.onEvict != nil {
+		c.onEvict(n.key, n.value)
+	}
+}
+
+// insertFront 把节点插入链表头部
+func (c *Cache[K, V]) insertFront(n *node[K, V]) {
+	n.prev = &c.root
+	n.next = c.root.next
+	c.root.next.prev = n
+	c.root.next = n
+}
+
+// unlink 把节点从链表中摘除
+func (c *Cache[K, V]) unlink(n *node[K, V]) {
+	n.prev.next = n.next
+	n.next.prev = n.prev
+	n.prev, n.next = nil, nil
+}
+
+// moveToFront 把节点移到链表头部
+func (c *Cache[K, V]) moveToFront(n *node[K, V]) {
+	if c.root.next == n {
+		return
+	}
+	c.unlink(n)
+	c.insertFront(n)
+}
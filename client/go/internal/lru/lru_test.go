@@ -0,0 +1,68 @@
+/*
+* @Author: Lzww0608
+* @Date: 22025-7-2 22:23:37
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-2 22:23:40
+* @Description: ConcordKV Go client - LRU cache tests
+ */
+
+package lru
+
+import "testing"
+
+// TestCacheEviction 超过容量时只淘汰最久未使用的条目
+func TestCacheEviction(t *testing.T) {
+	var evicted []string
+	c := New[string, int](3, func(key string, value int) { evicted = append(evicted, key) })
+
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3)
+	c.Get("a")     // a变为最近使用
+	c.Peek("b")    // Peek不改变顺序
+	c.Add("c", 30) // 更新已有条目不淘汰
+
+	if !c.Add("d", 4) || len(evicted) != 1 || evicted[0] != "b" {
+		t.Fatalf("应淘汰最久未使用的b，实际 %v", evicted)
+	}
+	if v, ok := c.Get("c"); !ok || v != 30 {
+		t.Errorf("c的值应为30，实际 %v %v", v, ok)
+	}
+
+	var order []string
+	c.Range(func(key string, value int) bool {
+		order = append(order, key)
+		return true
+	})
+	if len(order) != 3 || order[0] != "c" || order[1] != "d" || order[2] != "a" {
+		t.Errorf("使用顺序不正确: %v", order)
+	}
+
+	if !c.Remove("d") || c.Remove("d") || c.Len() != 2 {
+		t.Errorf("删除结果不正确，剩余 %d", c.Len())
+	}
+	if key, _, ok := c.RemoveOldest(); !ok || key != "a" {
+		t.Errorf("应淘汰a，实际 %s", key)
+	}
+	if len(evicted) != 2 {
+		t.Errorf("RemoveOldest应通知淘汰，实际 %v", evicted)
+	}
+
+	c.Purge()
+	if _, _, ok := c.RemoveOldest(); ok || c.Len() != 0 {
+		t.Errorf("清空后缓存应为空")
+	}
+}
+
+// TestCacheUnbounded 容量不大于0时不淘汰
+func TestCacheUnbounded(t *testing.T) {
+	c := New[int, int](0, nil)
+	for i := 0; i < 1000; i++ {
+		if c.Add(i, i) {
+			t.Fatalf("不限容量的缓存不应淘汰")
+		}
+	}
+	if c.Len() != 1000 {
+		t.Errorf("条目数应为1000，实际 %d", c.Len())
+	}
+}
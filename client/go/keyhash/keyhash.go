@@ -0,0 +1,173 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-29 14:06:52
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-29 14:06:52
+* @Description: ConcordKV Go client key hashing
+ */
+
+// Package keyhash 定义键到哈希环位置的映射，分片范围[StartHash, EndHash)都定义在这个哈希空间上。
+// 服务端的分片归属、/api/topology通告的算法与客户端的路由必须使用同一个算法，否则键会被路由到错误的分片。
+// 服务端（raftserver/keyhash）保留一份相同的实现，两者与其他语言的实现都以
+// common/keyhash/test_vectors.json中的测试向量为准
+package keyhash
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupported 不认识的哈希算法或版本
+var ErrUnsupported = errors.New("不支持的键哈希算法")
+
+// Algorithm 键哈希算法，名称与版本共同确定键到哈希值的映射；同名算法的映射变化时版本号增加
+type Algorithm struct {
+	Name    string `json:"algorithm"`
+	Version int    `json:"version"`
+}
+
+// Seed xxhash64/v1使用的种子，固定为0，与其他语言的xxhash64实现的默认值一致
+const Seed uint64 = 0
+
+var (
+	// XXHash64 键的UTF-8字节以种子Seed计算的XXH64
+	XXHash64 = Algorithm{Name: "xxhash64", Version: 1}
+
+	// SHA256Prefix 键的SHA-256摘要的前8字节按大端序解释，早期版本的服务端与客户端使用此算法
+	SHA256Prefix = Algorithm{Name: "sha256-prefix", Version: 1}
+
+	// Canonical 新集群默认使用的算法
+	Canonical = XXHash64
+
+	// Legacy 未通告算法的服务端使用的算法
+	Legacy = SHA256Prefix
+)
+
+// Supported 本实现支持的算法，按优先顺序排列
+func Supported() []Algorithm {
+	return []Algorithm{XXHash64, SHA256Prefix}
+}
+
+// String 算法名/v版本，例如xxhash64/v1
+func (a Algorithm) String() string {
+	return fmt.Sprintf("%s/v%d", a.Name, a.Version)
+}
+
+// IsSupported 本实现是否支持该算法
+func (a Algorithm) IsSupported() bool {
+	for _, supported := range Supported() {
+		if a == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// Sum64 计算键在哈希环上的位置；算法不受支持时panic，外部输入的算法应先经Parse或IsSupported检查
+func (a Algorithm) Sum64(key string) uint64 {
+	switch a {
+	case XXHash64:
+		return xxhash64(key, Seed)
+	case SHA256Prefix:
+		sum := sha256.Sum256([]byte(key))
+		return binary.BigEndian.Uint64(sum[:8])
+	}
+	panic(fmt.Sprintf("%v: %s", ErrUnsupported, a))
+}
+
+// Parse 解析"名称"或"名称/v版本"形式的算法，只有名称时取该算法支持的最新版本
+func Parse(s string) (Algorithm, error) {
+	name, version, hasVersion := strings.Cut(strings.TrimSpace(s), "/")
+	if !hasVersion {
+		for _, supported := range Supported() {
+			if supported.Name == name {
+				return supported, nil
+			}
+		}
+		return Algorithm{}, fmt.Errorf("%w: %q", ErrUnsupported, s)
+	}
+
+	n, err := strconv.Atoi(strings.TrimPrefix(version, "v"))
+	if err != nil {
+		return Algorithm{}, fmt.Errorf("键哈希算法 %q 的版本无效", s)
+	}
+	algorithm := Algorithm{Name: name, Version: n}
+	if !algorithm.IsSupported() {
+		return Algorithm{}, fmt.Errorf("%w: %q", ErrUnsupported, s)
+	}
+	return algorithm, nil
+}
+
+// XXH64的常量
+const (
+	prime1 uint64 = 11400714785074694791
+	prime2 uint64 = 14029467366897019727
+	prime3 uint64 = 1609587929392839161
+	prime4 uint64 = 9650029242287828579
+	prime5 uint64 = 2870177450012600261
+)
+
+// xxhash64 XXH64算法，输入按小端序读取
+func xxhash64(s string, seed uint64) uint64 {
+	b := []byte(s)
+	n := len(b)
+
+	var h uint64
+	if n >= 32 {
+		v1 := seed + prime1 + prime2
+		v2 := seed + prime2
+		v3 := seed
+		v4 := seed - prime1
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = xxhRound(v1, binary.LittleEndian.Uint64(b[0:8]))
+			v2 = xxhRound(v2, binary.LittleEndian.Uint64(b[8:16]))
+			v3 = xxhRound(v3, binary.LittleEndian.Uint64(b[16:24]))
+			v4 = xxhRound(v4, binary.LittleEndian.Uint64(b[24:32]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxhMergeRound(h, v1)
+		h = xxhMergeRound(h, v2)
+		h = xxhMergeRound(h, v3)
+		h = xxhMergeRound(h, v4)
+	} else {
+		h = seed + prime5
+	}
+	h += uint64(n)
+
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxhRound(0, binary.LittleEndian.Uint64(b[:8]))
+		h = bits.RotateLeft64(h, 27)*prime1 + prime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b[:4])) * prime1
+		h = bits.RotateLeft64(h, 23)*prime2 + prime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * prime5
+		h = bits.RotateLeft64(h, 11) * prime1
+	}
+
+	h ^= h >> 33
+	h *= prime2
+	h ^= h >> 29
+	h *= prime3
+	h ^= h >> 32
+	return h
+}
+
+func xxhRound(acc, input uint64) uint64 {
+	acc += input * prime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * prime1
+}
+
+func xxhMergeRound(acc, val uint64) uint64 {
+	acc ^= xxhRound(0, val)
+	return acc*prime1 + prime4
+}
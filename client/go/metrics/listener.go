@@ -0,0 +1,60 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-11 14:05:26
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-11 14:05:26
+* @Description: ConcordKV Go client metrics listener
+ */
+
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Listener 在独立端口上暴露注册表的HTTP服务，路径为/metrics
+type Listener struct {
+	server   *http.Server
+	listener net.Listener
+	done     chan struct{}
+}
+
+// Listen 监听addr并在/metrics路径上暴露registry，addr的端口为0时由系统分配
+func Listen(addr string, registry *Registry) (*Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+
+	l := &Listener{
+		server:   &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second},
+		listener: ln,
+		done:     make(chan struct{}),
+	}
+	go func() {
+		defer close(l.done)
+		l.server.Serve(ln)
+	}()
+	return l, nil
+}
+
+// Addr 实际监听的地址
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Close 停止监听，等待进行中的抓取完成
+func (l *Listener) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := l.server.Shutdown(ctx)
+	<-l.done
+	return err
+}
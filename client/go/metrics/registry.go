@@ -0,0 +1,287 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-11 14:05:26
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-11 14:05:26
+* @Description: ConcordKV Go client metrics registry
+ */
+
+// Package metrics 汇总客户端各组件（路由器、连接池、重试）的指标并以Prometheus文本格式暴露
+// 组件实现Collector并以名称注册到Registry；抓取时依次调用各Collector，同名指标族合并输出。
+// 输出格式与标签名（node_id、dc、shard_id）和服务端的/metrics一致，便于在同一面板中关联
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType Prometheus文本暴露格式的内容类型
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// 各组件统一使用的标签名
+const (
+	LabelNodeID  = "node_id"
+	LabelDC      = "dc"
+	LabelShardID = "shard_id"
+)
+
+// Type 指标类型
+type Type string
+
+const (
+	Counter Type = "counter" // 单调递增的计数器，进程重启时归零
+	Gauge   Type = "gauge"   // 可增可减的瞬时值
+)
+
+// Label 标签名与标签值
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample 指标族中的一个样本
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// Family 同名同类型的一组样本
+type Family struct {
+	Name    string
+	Help    string
+	Type    Type
+	Samples []Sample
+}
+
+// NewCounter 创建计数器指标族
+func NewCounter(name, help string) *Family {
+	return &Family{Name: name, Help: help, Type: Counter}
+}
+
+// NewGauge 创建瞬时值指标族
+func NewGauge(name, help string) *Family {
+	return &Family{Name: name, Help: help, Type: Gauge}
+}
+
+// With 追加一个样本，labels依次为标签名和标签值
+func (f *Family) With(value float64, labels ...string) *Family {
+	if len(labels)%2 != 0 {
+		panic(fmt.Sprintf("指标 %s 的标签必须成对出现", f.Name))
+	}
+
+	sample := Sample{Value: value, Labels: make([]Label, 0, len(labels)/2)}
+	for i := 0; i < len(labels); i += 2 {
+		sample.Labels = append(sample.Labels, Label{Name: labels[i], Value: labels[i+1]})
+	}
+	f.Samples = append(f.Samples, sample)
+	return f
+}
+
+// Collector 提供指标的组件，每次抓取时调用
+// Collect可能被并发调用，返回的指标族归调用方所有
+type Collector interface {
+	Collect() []*Family
+}
+
+// CollectorFunc 把函数适配为Collector
+type CollectorFunc func() []*Family
+
+// Collect 调用f
+func (f CollectorFunc) Collect() []*Family {
+	return f()
+}
+
+// Registry 已注册的Collector，实现http.Handler，以Prometheus文本格式输出所有指标
+type Registry struct {
+	mu          sync.RWMutex
+	constLabels []Label
+	collectors  map[string]Collector
+}
+
+// NewRegistry 创建注册表，constLabels依次为标签名和标签值，附加到每个样本上；
+// 样本自身带有同名标签时以样本的为准
+func NewRegistry(constLabels ...string) *Registry {
+	if len(constLabels)%2 != 0 {
+		panic("固定标签必须成对出现")
+	}
+
+	r := &Registry{collectors: make(map[string]Collector)}
+	for i := 0; i < len(constLabels); i += 2 {
+		r.constLabels = append(r.constLabels, Label{Name: constLabels[i], Value: constLabels[i+1]})
+	}
+	return r
+}
+
+// Register 以名称注册Collector，名称已被使用时返回错误
+func (r *Registry) Register(name string, collector Collector) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.collectors[name]; exists {
+		return fmt.Errorf("指标收集器 %s 已注册", name)
+	}
+	r.collectors[name] = collector
+	return nil
+}
+
+// Replace 以名称注册Collector，替换同名的已有Collector；collector为nil时注销
+func (r *Registry) Replace(name string, collector Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if collector == nil {
+		delete(r.collectors, name)
+		return
+	}
+	r.collectors[name] = collector
+}
+
+// Unregister 注销名称对应的Collector
+func (r *Registry) Unregister(name string) {
+	r.Replace(name, nil)
+}
+
+// Gather 调用所有Collector，按名称合并指标族并排序
+// 同名指标族的类型不一致时保留先收集到的，丢弃后者的样本
+func (r *Registry) Gather() []*Family {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	collectors := make([]Collector, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		collectors = append(collectors, r.collectors[name])
+	}
+	r.mu.RUnlock()
+
+	merged := make(map[string]*Family)
+	for _, collector := range collectors {
+		for _, family := range collector.Collect() {
+			if family == nil {
+				continue
+			}
+			existing, ok := merged[family.Name]
+			if !ok {
+				merged[family.Name] = &Family{Name: family.Name, Help: family.Help, Type: family.Type, Samples: r.withConstLabels(family.Samples)}
+				continue
+			}
+			if existing.Type == family.Type {
+				existing.Samples = append(existing.Samples, r.withConstLabels(family.Samples)...)
+			}
+		}
+	}
+
+	families := make([]*Family, 0, len(merged))
+	for _, family := range merged {
+		families = append(families, family)
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].Name < families[j].Name })
+	return families
+}
+
+// withConstLabels 在样本标签前加上固定标签
+func (r *Registry) withConstLabels(samples []Sample) []Sample {
+	if len(r.constLabels) == 0 {
+		return samples
+	}
+
+	out := make([]Sample, len(samples))
+	for i, sample := range samples {
+		labels := make([]Label, 0, len(r.constLabels)+len(sample.Labels))
+		for _, constLabel := range r.constLabels {
+			if !hasLabel(sample.Labels, constLabel.Name) {
+				labels = append(labels, constLabel)
+			}
+		}
+		out[i] = Sample{Labels: append(labels, sample.Labels...), Value: sample.Value}
+	}
+	return out
+}
+
+func hasLabel(labels []Label, name string) bool {
+	for _, label := range labels {
+		if label.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText 以Prometheus文本暴露格式输出所有指标
+func (r *Registry) WriteText(w io.Writer) error {
+	return WriteText(w, r.Gather())
+}
+
+// ServeHTTP 响应Prometheus的抓取请求
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteText(w)
+}
+
+// WriteText 以Prometheus文本暴露格式输出指标族
+func WriteText(w io.Writer, families []*Family) error {
+	bw := bufio.NewWriter(w)
+	for _, family := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", family.Name, escapeHelp(family.Help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", family.Name, family.Type)
+		for _, sample := range family.Samples {
+			bw.WriteString(family.Name)
+			if len(sample.Labels) > 0 {
+				bw.WriteByte('{')
+				for i, label := range sample.Labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					fmt.Fprintf(bw, "%s=\"%s\"", label.Name, escapeLabelValue(label.Value))
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(formatValue(sample.Value))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// formatValue 按Prometheus文本格式输出样本值
+func formatValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+}
@@ -0,0 +1,290 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-26 14:20:31
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-26 14:20:31
+* @Description: ConcordKV Go client cluster administration API
+ */
+
+package concord
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Member 集群成员
+type Member struct {
+	ID         string `json:"id"`
+	Address    string `json:"address"`
+	DataCenter string `json:"dataCenter,omitempty"`
+	IsLearner  bool   `json:"isLearner,omitempty"`
+}
+
+// NodeStatus 节点的状态（/api/status）
+type NodeStatus struct {
+	NodeID        string   `json:"nodeId"`
+	State         string   `json:"state"`
+	Role          string   `json:"role"`
+	Term          uint64   `json:"term"`
+	Leader        string   `json:"leader"`
+	LastLogIndex  uint64   `json:"lastLogIndex"`
+	CommitIndex   uint64   `json:"commitIndex"`
+	LastApplied   uint64   `json:"lastApplied"`
+	IsLeader      bool     `json:"isLeader"`
+	StorageSize   int64    `json:"storageSize"`
+	Sessions      int      `json:"sessions"`
+	Watchers      int      `json:"watchers"`
+	Configuration []Member `json:"configuration"`
+	Learners      []Member `json:"learners"`
+	Draining      bool     `json:"draining"`
+}
+
+// EndpointStatus 一个节点端点的状态查询结果，Err不为nil时Status为nil
+type EndpointStatus struct {
+	Endpoint string
+	Status   *NodeStatus
+	Err      error
+}
+
+// Membership 集群成员配置（/api/cluster/config）
+type Membership struct {
+	Servers  []Member
+	Changing bool // 是否有进行中的成员变更
+}
+
+// SnapshotInfo 节点的快照指标
+type SnapshotInfo struct {
+	LastSnapshotIndex uint64  `json:"lastSnapshotIndex"`
+	LastSnapshotTerm  uint64  `json:"lastSnapshotTerm"`
+	SnapshotSize      int64   `json:"snapshotSize"`
+	SnapshotDuration  float64 `json:"snapshotDuration"` // 最后快照创建耗时(ms)
+	SnapshotCount     int64   `json:"snapshotCount"`
+}
+
+// PendingFailover 等待人工确认的故障转移决策
+type PendingFailover struct {
+	ID         string    `json:"id"`
+	FailedDC   string    `json:"failedDC"`
+	TargetDC   string    `json:"targetDC"`
+	Reason     string    `json:"reason"`
+	Confidence float64   `json:"confidence"`
+	RiskLevel  int       `json:"riskLevel"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// FailoverStats 故障转移统计，时长以毫秒表示
+type FailoverStats struct {
+	TotalFailovers        int64              `json:"totalFailovers"`
+	SuccessfulFailovers   int64              `json:"successfulFailovers"`
+	FailedFailovers       int64              `json:"failedFailovers"`
+	Rollbacks             int64              `json:"rollbacks"`
+	FailedRollbacks       int64              `json:"failedRollbacks"`
+	AverageFailoverTimeMs int64              `json:"averageFailoverTimeMs"`
+	TotalDowntimeMs       int64              `json:"totalDowntimeMs"`
+	TotalClientImpact     int64              `json:"totalClientImpact"`
+	InCooldown            bool               `json:"inCooldown"`
+	LastFailoverTime      *time.Time         `json:"lastFailoverTime,omitempty"`
+	LastOperation         *FailoverOperation `json:"lastOperation,omitempty"`
+}
+
+// FailoverOperation 最近一次故障转移操作的耗时与影响
+type FailoverOperation struct {
+	ID                string    `json:"id"`
+	Status            string    `json:"status"`
+	FailedDC          string    `json:"failedDC,omitempty"`
+	TargetDC          string    `json:"targetDC"`
+	DetectedAt        time.Time `json:"detectedAt"`
+	DurationMs        int64     `json:"durationMs"`
+	FailoverLatencyMs int64     `json:"failoverLatencyMs"`
+	ServiceDowntimeMs int64     `json:"serviceDowntimeMs"`
+	RecoveryTimeMs    int64     `json:"recoveryTimeMs"`
+	ClientImpactCount int64     `json:"clientImpactCount"`
+}
+
+// NodeStatus 查询单个节点的状态，不重试也不转向其他节点
+func (c *Client) NodeStatus(ctx context.Context, endpoint string) (*NodeStatus, error) {
+	var status NodeStatus
+	if err := c.sendTo(ctx, newConnection(endpoint), http.MethodGet, "/api/status", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// ClusterStatus 并发查询所有配置的节点，按配置顺序返回每个节点的结果；部分节点不可达不影响其余节点
+func (c *Client) ClusterStatus(ctx context.Context) []EndpointStatus {
+	results := make([]EndpointStatus, len(c.config.Endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range c.config.Endpoints {
+		wg.Add(1)
+		go func(i int, endpoint string) {
+			defer wg.Done()
+			status, err := c.NodeStatus(ctx, endpoint)
+			results[i] = EndpointStatus{Endpoint: endpoint, Status: status, Err: err}
+		}(i, endpoint)
+	}
+	wg.Wait()
+	return results
+}
+
+// Members 获取集群成员配置
+func (c *Client) Members(ctx context.Context) (*Membership, error) {
+	var resp struct {
+		Configuration struct {
+			Servers []Member `json:"servers"`
+		} `json:"configuration"`
+		Changing bool `json:"changing"`
+	}
+	if err := c.doRequest(ctx, http.MethodGet, "/api/cluster/config", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &Membership{Servers: resp.Configuration.Servers, Changing: resp.Changing}, nil
+}
+
+// AddMember 添加成员，IsLearner为true时以学习者身份加入；返回变更后的成员列表
+func (c *Client) AddMember(ctx context.Context, member Member) ([]Member, error) {
+	body := map[string]interface{}{
+		"id":         member.ID,
+		"address":    member.Address,
+		"dataCenter": member.DataCenter,
+		"learner":    member.IsLearner,
+	}
+	return c.changeMembers(ctx, "/api/cluster/add", body)
+}
+
+// RemoveMember 移除成员，返回变更后的成员列表
+func (c *Client) RemoveMember(ctx context.Context, id string) ([]Member, error) {
+	return c.changeMembers(ctx, "/api/cluster/remove", map[string]string{"id": id})
+}
+
+// PromoteLearner 把学习者提升为投票成员，返回变更后的成员列表
+func (c *Client) PromoteLearner(ctx context.Context, id string) ([]Member, error) {
+	return c.changeMembers(ctx, "/api/cluster/promote", map[string]string{"id": id})
+}
+
+// changeMembers 成员变更由领导者处理，非领导者错误按重试策略转向领导者
+func (c *Client) changeMembers(ctx context.Context, path string, body interface{}) ([]Member, error) {
+	var resp struct {
+		Configuration []Member `json:"configuration"`
+	}
+	if err := c.doRequest(ctx, http.MethodPost, path, body, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Configuration, nil
+}
+
+// TransferLeader 把领导权转移给target，返回原来的领导者
+func (c *Client) TransferLeader(ctx context.Context, target string) (string, error) {
+	var resp struct {
+		PreviousLeader string `json:"previousLeader"`
+	}
+	path := "/api/transfer-leader?target=" + url.QueryEscape(target)
+	if err := c.doRequest(ctx, http.MethodPost, path, nil, nil, &resp); err != nil {
+		return "", err
+	}
+	return resp.PreviousLeader, nil
+}
+
+// TriggerSnapshot 在指定节点上立即创建快照并压缩日志，返回之后的快照指标
+func (c *Client) TriggerSnapshot(ctx context.Context, endpoint string) (*SnapshotInfo, error) {
+	var resp struct {
+		Snapshot SnapshotInfo `json:"snapshot"`
+	}
+	if err := c.sendTo(ctx, newConnection(endpoint), http.MethodPost, "/api/admin/snapshot", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Snapshot, nil
+}
+
+// Backup 从指定节点导出备份并写入w，返回写入的字节数；sinceIndex >= 0时导出该索引之后的增量备份
+// 增量备份的起点已被快照压缩时服务端返回410，需要重新获取完整备份
+func (c *Client) Backup(ctx context.Context, endpoint string, sinceIndex int64, w io.Writer) (int64, error) {
+	path := "/api/backup"
+	if sinceIndex >= 0 {
+		path += "?sinceIndex=" + strconv.FormatInt(sinceIndex, 10)
+	}
+	body, err := c.fetchRaw(ctx, endpoint, path)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	return io.Copy(w, body)
+}
+
+// PendingFailovers 列出等待人工确认的故障转移决策
+func (c *Client) PendingFailovers(ctx context.Context) ([]PendingFailover, error) {
+	var resp struct {
+		Decisions []PendingFailover `json:"decisions"`
+	}
+	if err := c.doRequest(ctx, http.MethodGet, "/api/failover/pending", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Decisions, nil
+}
+
+// FailoverStats 获取故障转移统计
+func (c *Client) FailoverStats(ctx context.Context) (*FailoverStats, error) {
+	var resp struct {
+		Stats FailoverStats `json:"stats"`
+	}
+	if err := c.doRequest(ctx, http.MethodGet, "/api/failover/stats", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Stats, nil
+}
+
+// ApproveFailover 批准待确认的故障转移决策
+func (c *Client) ApproveFailover(ctx context.Context, id string) error {
+	return c.doRequest(ctx, http.MethodPost, "/api/failover/approve", map[string]string{"id": id}, nil, nil)
+}
+
+// RejectFailover 拒绝待确认的故障转移决策
+func (c *Client) RejectFailover(ctx context.Context, id, reason string) error {
+	return c.doRequest(ctx, http.MethodPost, "/api/failover/reject", map[string]string{"id": id, "reason": reason}, nil, nil)
+}
+
+// PrometheusMetrics 获取指定节点Prometheus文本格式的指标
+func (c *Client) PrometheusMetrics(ctx context.Context, endpoint string) (string, error) {
+	body, err := c.fetchRaw(ctx, endpoint, "/api/metrics?format=prometheus")
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", fmt.Errorf("读取指标失败: %w", err)
+	}
+	return string(data), nil
+}
+
+// fetchRaw 向单个节点发送GET请求，返回非JSON的响应体；非200响应按statusError转换为错误
+func (c *Client) fetchRaw(ctx context.Context, endpoint, path string) (io.ReadCloser, error) {
+	conn := newConnection(endpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, conn.baseURL+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %w", err)
+	}
+	c.authorize(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("%w: %v", ErrConnectionFailed, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return nil, statusError(resp.StatusCode, data)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,83 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-26 14:20:31
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-26 14:20:31
+* @Description: ConcordKV Go client cluster administration API tests
+ */
+
+package concord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestAdminAPI 状态查询逐个节点进行，不可达的节点单独报告；令牌随每个请求发送；备份原样写出
+func TestAdminAPI(t *testing.T) {
+	var authorized atomic.Int64
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		authorized.Add(1)
+		switch r.URL.Path {
+		case "/api/status":
+			json.NewEncoder(w).Encode(map[string]interface{}{"nodeId": "node1", "term": 3, "leader": "node1", "isLeader": true})
+		case "/api/scan":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": true, "keys": []string{"a/1", "a/2"}, "hasMore": true, "cursor": "YS8y",
+				"items": []map[string]interface{}{{"key": "a/1", "value": "x"}, {"key": "a/2", "value": map[string]int{"n": 1}}},
+			})
+		case "/api/backup":
+			w.Write([]byte("CONCORDKV-BACKUP 1\n{}\npayload"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer node.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	client, err := NewClient(Config{Endpoints: []string{node.URL, down.URL}, Token: "secret", RetryCount: 1, DisableSession: true, Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	results := client.ClusterStatus(ctx)
+	if len(results) != 2 || results[0].Err != nil || results[0].Status.Term != 3 || !results[0].Status.IsLeader {
+		t.Fatalf("可达节点的状态 = %+v", results[0])
+	}
+	if results[1].Status != nil || !errors.Is(results[1].Err, ErrConnectionFailed) {
+		t.Fatalf("不可达的节点应返回ErrConnectionFailed: %+v", results[1])
+	}
+
+	page, err := client.ScanCtx(ctx, ScanOptions{Prefix: "a/", WithValues: true})
+	if err != nil || len(page.Keys) != 2 || !page.HasMore || page.Cursor != "YS8y" {
+		t.Fatalf("扫描结果 = %+v, %v", page, err)
+	}
+	if page.Items[0].Value != "x" || page.Items[1].Value != `{"n":1}` {
+		t.Errorf("扫描到的值 = %+v", page.Items)
+	}
+
+	var buf bytes.Buffer
+	if n, err := client.Backup(ctx, node.URL, -1, &buf); err != nil || n != int64(buf.Len()) || buf.String() != "CONCORDKV-BACKUP 1\n{}\npayload" {
+		t.Fatalf("备份内容 = %q (%d), %v", buf.String(), n, err)
+	}
+	if _, err := client.TriggerSnapshot(ctx, node.URL); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("服务端没有快照接口时应返回ErrUnsupported，实际: %v", err)
+	}
+	if n := authorized.Load(); n != 4 {
+		t.Errorf("带令牌的请求数 = %d, 期望 4", n)
+	}
+}
@@ -9,6 +9,7 @@
 package concord
 
 import (
+	"strings"
 	"sync"
 	"time"
 )
@@ -83,6 +84,18 @@ func (c *Cache) Delete(key string) {
 	delete(c.entries, key)
 }
 
+// DeletePrefix 删除以prefix开头的所有缓存条目
+func (c *Cache) DeletePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+		}
+	}
+}
+
 // Clear 清空缓存
 func (c *Cache) Clear() {
 	c.mu.Lock()
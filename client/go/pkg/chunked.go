@@ -0,0 +1,311 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-18 14:06:31
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-18 14:06:31
+* @Description: ConcordKV Go client chunked values
+ */
+
+package concord
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 分块值的存储格式：清单以标记前缀存放在原键下，各块存放在原键加分隔符派生的键下
+// 派生键以\x00开头的后缀紧跟原键排序，按前缀扫描时与清单相邻，可用IsChunkKey过滤
+const (
+	chunkManifestPrefix = "\x00concordkv-chunked\x00"
+	chunkKeySeparator   = "\x00chunk\x00"
+
+	// defaultChunkSize 每块的JSON编码长度上限，低于服务端默认1MB的maxValueSize
+	defaultChunkSize = 768 << 10
+
+	// chunkReadAttempts 读取分块时块缺失（被并发的覆盖写删除）后重新读取清单的次数
+	chunkReadAttempts = 3
+)
+
+var (
+	// ErrChunkCorrupted 分块值的块缺失或校验和不一致
+	ErrChunkCorrupted = errors.New("分块值不完整或已损坏")
+)
+
+// chunkManifest 分块值的清单
+type chunkManifest struct {
+	ID     string `json:"id"`     // 本次写入的块键标识，覆盖写使用新的标识
+	Chunks int    `json:"chunks"` // 块数
+	Size   int    `json:"size"`   // 原值的字节数
+	SHA256 string `json:"sha256"` // 原值的校验和
+}
+
+// IsChunkKey 键是否为分块值的块键，按前缀扫描时可用于跳过块键
+func IsChunkKey(key string) bool {
+	return strings.Contains(key, chunkKeySeparator)
+}
+
+// chunkKey 原键第i块的键
+func chunkKey(key, id string, i int) string {
+	return key + chunkKeySeparator + id + "\x00" + strconv.Itoa(i)
+}
+
+// keys 清单引用的所有块键
+func (m *chunkManifest) keys(key string) []string {
+	keys := make([]string, m.Chunks)
+	for i := range keys {
+		keys[i] = chunkKey(key, m.ID, i)
+	}
+	return keys
+}
+
+// parseManifest 值带有清单标记时解析清单
+func parseManifest(value string) (*chunkManifest, bool) {
+	if !strings.HasPrefix(value, chunkManifestPrefix) {
+		return nil, false
+	}
+	var manifest chunkManifest
+	if err := json.Unmarshal([]byte(value[len(chunkManifestPrefix):]), &manifest); err != nil || manifest.Chunks <= 0 {
+		return nil, false
+	}
+	return &manifest, true
+}
+
+// encodedRuneLen 字符在JSON字符串中编码后的字节数，与encoding/json的转义规则一致或略大
+func encodedRuneLen(r rune, size int) int {
+	switch {
+	case r == utf8.RuneError && size == 1:
+		return 6 // 无效的UTF-8编码为�
+	case r == '"' || r == '\\' || r == '\n' || r == '\r' || r == '\t':
+		return 2
+	case r < 0x20 || r == '<' || r == '>' || r == '&' || r == ' ' || r == ' ':
+		return 6
+	default:
+		return size
+	}
+}
+
+// encodedLen 字符串JSON编码后的字节数（含引号）
+func encodedLen(value string) int {
+	n := 2
+	for i := 0; i < len(value); {
+		r, size := utf8.DecodeRuneInString(value[i:])
+		n += encodedRuneLen(r, size)
+		i += size
+	}
+	return n
+}
+
+// splitChunks 按字符边界把值拆分为JSON编码长度不超过limit的块
+func splitChunks(value string, limit int) []string {
+	var chunks []string
+	start, n := 0, 2
+	for i := 0; i < len(value); {
+		r, size := utf8.DecodeRuneInString(value[i:])
+		runeLen := encodedRuneLen(r, size)
+		if n+runeLen > limit && i > start {
+			chunks = append(chunks, value[start:i])
+			start, n = i, 2
+		}
+		n += runeLen
+		i += size
+	}
+	return append(chunks, value[start:])
+}
+
+// chunkSize 每块的编码长度上限
+func (c *Client) chunkSize() int {
+	if c.config.ChunkSize > 0 {
+		return c.config.ChunkSize
+	}
+	return defaultChunkSize
+}
+
+// getRaw 直接从节点读取键的原始值，不经过客户端缓存
+func (c *Client) getRaw(ctx context.Context, route []*connection, key string) (string, error) {
+	var resp response
+	path := "/api/get?key=" + url.QueryEscape(key)
+	if err := c.doRequestTo(ctx, route, http.MethodGet, path, nil, nil, &resp); err != nil {
+		return "", err
+	}
+	if !resp.Exists {
+		return "", ErrKeyNotFound
+	}
+	return resp.stringValue(), nil
+}
+
+// getChunked 读取键的值，值为分块清单时读取各块并重组
+// 块缺失说明读取期间值被覆盖或删除，重新读取清单后重试
+func (c *Client) getChunked(ctx context.Context, route []*connection, key string) (string, error) {
+	value, err := c.get(ctx, route, key)
+	if err != nil {
+		return "", err
+	}
+
+	for attempt := 0; ; attempt++ {
+		manifest, ok := parseManifest(value)
+		if !ok {
+			return value, nil
+		}
+
+		assembled, err := c.readChunks(ctx, route, key, manifest)
+		if !errors.Is(err, ErrKeyNotFound) || attempt+1 >= chunkReadAttempts {
+			if errors.Is(err, ErrKeyNotFound) {
+				err = fmt.Errorf("%w: 键 %s 的块缺失", ErrChunkCorrupted, key)
+			}
+			return assembled, err
+		}
+
+		// 缓存中的清单可能已过期，直接向节点读取
+		if c.cache != nil {
+			c.cache.Delete(key)
+		}
+		if value, err = c.getRaw(ctx, route, key); err != nil {
+			return "", err
+		}
+	}
+}
+
+// readChunks 并发读取清单中的各块，拼接后校验长度与校验和
+func (c *Client) readChunks(ctx context.Context, route []*connection, key string, manifest *chunkManifest) (string, error) {
+	keys := manifest.keys(key)
+	chunks := make([]string, len(keys))
+	errs := make([]error, len(keys))
+	c.parallel(len(keys), func(i int) {
+		chunks[i], errs[i] = c.getRaw(ctx, route, keys[i])
+	})
+	for _, err := range errs {
+		if err != nil {
+			return "", err
+		}
+	}
+
+	value := strings.Join(chunks, "")
+	sum := sha256.Sum256([]byte(value))
+	if len(value) != manifest.Size || hex.EncodeToString(sum[:]) != manifest.SHA256 {
+		return "", fmt.Errorf("%w: 键 %s 的校验和不一致", ErrChunkCorrupted, key)
+	}
+	return value, nil
+}
+
+// setChunked 写入键的值，超过ChunkSize的值拆分为多块，各块与清单在一个/api/batch中写入；
+// 覆盖分块值时同一批次删除旧的块
+func (c *Client) setChunked(ctx context.Context, route []*connection, key, value string, ttl time.Duration) (uint64, error) {
+	if key == "" || ttl < 0 {
+		return 0, ErrInvalidArgument
+	}
+
+	var stale []string
+	if previous, err := c.getRaw(ctx, route, key); err == nil {
+		if manifest, ok := parseManifest(previous); ok {
+			stale = manifest.keys(key)
+		}
+	} else if !errors.Is(err, ErrKeyNotFound) {
+		return 0, err
+	}
+
+	ttlSeconds := int64(ttl / time.Second)
+	var ops []batchOp
+	if encodedLen(value) <= c.chunkSize() && !strings.HasPrefix(value, chunkManifestPrefix) {
+		if len(stale) == 0 {
+			return c.setWithTTL(ctx, route, key, value, ttl)
+		}
+		ops = append(ops, batchOp{Op: "set", Key: key, Value: value, TTLSeconds: ttlSeconds})
+	} else {
+		id, err := newChunkID()
+		if err != nil {
+			return 0, err
+		}
+		chunks := splitChunks(value, c.chunkSize())
+		for i, chunk := range chunks {
+			ops = append(ops, batchOp{Op: "set", Key: chunkKey(key, id, i), Value: chunk, TTLSeconds: ttlSeconds})
+		}
+
+		sum := sha256.Sum256([]byte(value))
+		manifest, _ := json.Marshal(chunkManifest{ID: id, Chunks: len(chunks), Size: len(value), SHA256: hex.EncodeToString(sum[:])})
+		ops = append(ops, batchOp{Op: "set", Key: key, Value: chunkManifestPrefix + string(manifest), TTLSeconds: ttlSeconds})
+	}
+	for _, staleKey := range stale {
+		ops = append(ops, batchOp{Op: "delete", Key: staleKey})
+	}
+
+	index, err := c.writeAtomic(ctx, route, ops)
+	if err != nil {
+		return 0, err
+	}
+	if c.cache != nil {
+		c.cache.Delete(key)
+	}
+	return index, nil
+}
+
+// deleteChunked 删除键，值为分块清单时同一批次删除各块
+func (c *Client) deleteChunked(ctx context.Context, route []*connection, key string) error {
+	if key == "" {
+		return ErrInvalidArgument
+	}
+
+	previous, err := c.getRaw(ctx, route, key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return c.delete(ctx, route, key)
+	}
+	if err != nil {
+		return err
+	}
+	manifest, ok := parseManifest(previous)
+	if !ok {
+		return c.delete(ctx, route, key)
+	}
+
+	ops := []batchOp{{Op: "delete", Key: key}}
+	for _, chunk := range manifest.keys(key) {
+		ops = append(ops, batchOp{Op: "delete", Key: chunk})
+	}
+	if _, err := c.writeAtomic(ctx, route, ops); err != nil {
+		return err
+	}
+	if c.cache != nil {
+		c.cache.Delete(key)
+	}
+	return nil
+}
+
+// writeAtomic 在一个/api/batch中写入所有操作，服务端将其作为一个日志条目应用，返回该条目的日志索引
+func (c *Client) writeAtomic(ctx context.Context, route []*connection, ops []batchOp) (uint64, error) {
+	if len(ops) > c.config.MaxBatchSize {
+		return 0, fmt.Errorf("%w: 分块值需要 %d 个操作，超过MaxBatchSize %d", ErrValueTooLarge, len(ops), c.config.MaxBatchSize)
+	}
+
+	var resp batchResponse
+	if err := c.doWriteTo(ctx, route, http.MethodPost, "/api/batch", ops, &resp); err != nil {
+		return 0, err
+	}
+	if len(resp.Results) != len(ops) {
+		return 0, fmt.Errorf("批量响应包含%d个结果，请求有%d个操作", len(resp.Results), len(ops))
+	}
+	for i, result := range resp.Results {
+		if !result.Success {
+			return 0, fmt.Errorf("写入 %q 失败: %s", ops[i].Key, result.Error)
+		}
+	}
+	return resp.Index, nil
+}
+
+// newChunkID 生成块键标识
+func newChunkID() (string, error) {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", fmt.Errorf("生成分块标识失败: %w", err)
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
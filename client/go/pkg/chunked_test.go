@@ -0,0 +1,117 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-18 14:06:31
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-18 14:06:31
+* @Description: ConcordKV Go client chunked values tests
+ */
+
+package concord
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestChunkedValueRoundTrip 20MB的值拆分为不超过ChunkSize的块后写入，Get重组出原值；
+// 覆盖为小值与删除时清理旧的块
+func TestChunkedValueRoundTrip(t *testing.T) {
+	node, addr := newFakeKVNode(t, nil, false)
+	client, err := NewClient(Config{Endpoints: []string{addr}, RetryCount: 1, DisableSession: true, ChunkedValues: true, Timeout: time.Minute})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+
+	// 包含需要转义的字符与多字节字符，检验按编码长度拆分
+	value := strings.Repeat("大值<\"\n>0123456789", 20<<20/24)
+	if _, err := client.Set("big", value); err != nil {
+		t.Fatalf("写入大值失败: %v", err)
+	}
+
+	chunks := 0
+	for key, stored := range node.keys() {
+		if !IsChunkKey(key) {
+			continue
+		}
+		chunks++
+		if encoded, _ := json.Marshal(stored); len(encoded) > defaultChunkSize {
+			t.Errorf("块 %q 编码后 %d 字节，超过 %d", key, len(encoded), defaultChunkSize)
+		}
+	}
+	if chunks < 2 {
+		t.Fatalf("大值应被拆分为多块，实际 %d", chunks)
+	}
+	if node.requestCount("/api/set") != 0 {
+		t.Errorf("分块值应通过一个/api/batch写入")
+	}
+
+	got, err := client.Get("big")
+	if err != nil {
+		t.Fatalf("读取大值失败: %v", err)
+	}
+	if got != value {
+		t.Fatalf("重组的值与原值不一致: 长度 %d，期望 %d", len(got), len(value))
+	}
+
+	// 覆盖为小值后旧的块被删除
+	if _, err := client.Set("big", "small"); err != nil {
+		t.Fatalf("覆盖大值失败: %v", err)
+	}
+	if got, err := client.Get("big"); err != nil || got != "small" {
+		t.Fatalf("覆盖后应读到小值: %q %v", got, err)
+	}
+	for key := range node.keys() {
+		if IsChunkKey(key) {
+			t.Fatalf("覆盖后旧的块 %q 应被删除", key)
+		}
+	}
+
+	// 删除分块值同时删除所有块
+	value = strings.Repeat("中等大小的值", 2<<20/18)
+	if _, err := client.Set("big", value); err != nil {
+		t.Fatalf("写入大值失败: %v", err)
+	}
+	if err := client.Delete("big"); err != nil {
+		t.Fatalf("删除大值失败: %v", err)
+	}
+	if keys := node.keys(); len(keys) != 0 {
+		t.Errorf("删除后不应残留任何键，实际 %d 个", len(keys))
+	}
+
+	// 块缺失时返回ErrChunkCorrupted
+	if _, err := client.Set("big", value); err != nil {
+		t.Fatalf("写入大值失败: %v", err)
+	}
+	node.mu.Lock()
+	for key := range node.store {
+		if IsChunkKey(key) {
+			delete(node.store, key)
+			break
+		}
+	}
+	node.mu.Unlock()
+	if _, err := client.Get("big"); !errors.Is(err, ErrChunkCorrupted) {
+		t.Errorf("块缺失时应返回ErrChunkCorrupted: %v", err)
+	}
+}
+
+// TestSplitChunks 拆分在字符边界上进行，每块的编码长度不超过上限，拼接后等于原值
+func TestSplitChunks(t *testing.T) {
+	value := strings.Repeat("a 中\x01&", 100) + "\xff"
+	for _, limit := range []int{8, 16, 100} {
+		chunks := splitChunks(value, limit)
+		if strings.Join(chunks, "") != value {
+			t.Fatalf("上限 %d: 拼接结果与原值不一致", limit)
+		}
+		for _, chunk := range chunks {
+			encoded, _ := json.Marshal(chunk)
+			if len(encoded) > limit {
+				t.Errorf("上限 %d: 块 %q 编码后 %d 字节", limit, chunk, len(encoded))
+			}
+		}
+	}
+}
@@ -9,18 +9,30 @@
 package concord
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/concordkv/client/go/metrics"
 )
 
-// 错误定义
-var (
-	ErrNoEndpoints      = errors.New("没有可用的节点端点")
-	ErrConnectionFailed = errors.New("连接失败")
-	ErrTimeout          = errors.New("请求超时")
-	ErrKeyNotFound      = errors.New("键不存在")
-	ErrInvalidArgument  = errors.New("无效参数")
+// 服务端错误响应体中的错误码
+const (
+	codeNotLeader   = "NOT_LEADER"
+	codeKeyNotFound = "KEY_NOT_FOUND"
+	codeTimeout     = "TIMEOUT"
+	codeConflict    = "CONFLICT"
 )
 
 // Config 客户端配置
@@ -29,31 +41,87 @@ type Config struct {
 	Endpoints []string
 	// 连接超时时间
 	Timeout time.Duration
-	// 重试次数
+	// 重试轮数，未设置RetryPolicy时使用
 	RetryCount int
-	// 重试间隔
+	// 第一次重试前的退避间隔，未设置RetryPolicy时使用
 	RetryInterval time.Duration
+	// 重试策略，为nil时由RetryCount和RetryInterval生成，其余参数取DefaultRetryPolicy的值
+	RetryPolicy *RetryPolicy
 	// 客户端缓存大小
 	CacheSize int
 	// 缓存TTL
 	CacheTTL time.Duration
 	// 是否启用缓存
 	EnableCache bool
+	// 是否禁用客户端会话；启用会话时写请求携带会话与序号，超时重试不会被服务端重复执行
+	DisableSession bool
+	// 批量操作（MGet/MSet/MDelete）的最大并发请求数
+	BatchParallelism int
+	// 单个/api/batch请求包含的最大操作数，超过时拆分为多个请求
+	MaxBatchSize int
+	// 是否启用分块值：Set写入JSON编码超过ChunkSize的值时拆分为多个块键与一个清单，在一个/api/batch中写入，
+	// Get透明地重组；启用后Set与Delete会先读取旧值以清理旧的块。MSet/MGet不拆分
+	ChunkedValues bool
+	// 分块值每块的JSON编码长度上限，默认768KB，应小于服务端的maxValueSize
+	ChunkSize int
+	// 每个节点的最大HTTP连接数，0表示不限制；达到上限时请求等待空闲连接，等待可由ctx取消
+	MaxConnsPerNode int
+	// 客户端指标的监听地址（如 ":9464"），设置时在该地址的/metrics路径上以Prometheus文本格式暴露客户端指标
+	MetricsListenAddr string
+	// 日志级别：debug、info、warn或error，默认warn；设置Logger时忽略
+	LogLevel string
+	// 客户端日志，为nil时按LogLevel输出文本日志到标准错误
+	Logger *slog.Logger
+	// API访问令牌，设置时每个请求带有 Authorization: Bearer 头；管理接口需要管理员令牌
+	Token string
+	// 客户端所在的数据中心与可用区，设置路由器（SetRouter）后读请求优先发往同一可用区、同一数据中心的副本
+	LocalDC   string
+	LocalZone string
+	// 领导者提示的有效期：从NOT_LEADER响应、重定向或领导者变更事件得知的领导者在此期间内直接接收写请求，
+	// 默认30秒，小于0时不缓存
+	LeaderCacheTTL time.Duration
+	// 是否订阅节点的领导者变更事件流（/api/events），选举后主动更新领导者缓存，不必等到下一个NOT_LEADER
+	LeaderEvents bool
 }
 
 // Client ConcordKV客户端
 type Client struct {
-	config Config
-	mu     sync.RWMutex
-	conns  map[string]*connection
-	cache  *Cache
-	closed bool
+	config     Config
+	mu         sync.RWMutex
+	conns      map[string]*connection
+	cache      *Cache
+	closed     bool
+	httpClient *http.Client
+
+	// 客户端会话，首次写请求时注册
+	sessionMu sync.Mutex
+	session   *session
+
+	// 批量操作按路由结果把键分组到节点，未设置时所有键发往配置的节点
+	router KeyRouter
+	// 服务端不支持/api/batch时置1，之后的批量写退回到并发的单键请求
+	batchUnsupported int32
+
+	retryPolicy *RetryPolicy
+	stats       clientStats
+	logger      *slog.Logger
+	// 遇到非领导者错误时调用，刷新拓扑与领导者信息；拓扑感知客户端会设置
+	leaderRefresh func(ctx context.Context) error
+	// 跨操作共享的领导者提示，LeaderCacheTTL小于0时为nil
+	leaders *leaderCache
+	// 领导者变更事件流的停止函数与结束信号，未启用LeaderEvents时为nil
+	stopLeaderEvents context.CancelFunc
+	leaderEventsDone chan struct{}
+
+	// 客户端指标，设置了MetricsListenAddr时由metricsListener对外暴露
+	metrics         *metrics.Registry
+	metricsListener *metrics.Listener
 }
 
 // 内部连接结构
 type connection struct {
 	endpoint string
-	// TODO: 实现实际连接逻辑
+	baseURL  string
 }
 
 // NewClient 创建新的客户端实例
@@ -74,52 +142,158 @@ func NewClient(config Config) (*Client, error) {
 		config.RetryInterval = 500 * time.Millisecond
 	}
 
+	if config.BatchParallelism <= 0 {
+		config.BatchParallelism = 8
+	}
+
+	if config.MaxBatchSize <= 0 {
+		config.MaxBatchSize = 100
+	}
+
+	if config.LeaderCacheTTL == 0 {
+		config.LeaderCacheTTL = defaultLeaderCacheTTL
+	}
+
+	logger, err := newLogger(config)
+	if err != nil {
+		return nil, err
+	}
+
 	client := &Client{
 		config: config,
+		logger: logger,
 		conns:  make(map[string]*connection),
+		httpClient: &http.Client{
+			Timeout: config.Timeout,
+		},
+		retryPolicy: retryPolicyFromConfig(config),
+	}
+	if config.MaxConnsPerNode > 0 {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxConnsPerHost = config.MaxConnsPerNode
+		client.httpClient.Transport = transport
 	}
 
 	// 初始化缓存（如果启用）
 	if config.EnableCache {
 		client.cache = NewCache(config.CacheSize)
 	}
+	if config.LeaderCacheTTL > 0 {
+		client.leaders = &leaderCache{ttl: config.LeaderCacheTTL}
+	}
 
 	// 初始化连接
 	if err := client.initConnections(); err != nil {
 		return nil, err
 	}
 
+	client.metrics = client.newMetricsRegistry()
+	if config.MetricsListenAddr != "" {
+		listener, err := metrics.Listen(config.MetricsListenAddr, client.metrics)
+		if err != nil {
+			return nil, fmt.Errorf("启动指标监听失败: %w", err)
+		}
+		client.metricsListener = listener
+	}
+	client.startLeaderEvents()
+
 	return client, nil
 }
 
 // 初始化所有连接
 func (c *Client) initConnections() error {
-	// TODO: 实现实际连接逻辑
 	for _, endpoint := range c.config.Endpoints {
-		c.conns[endpoint] = &connection{
-			endpoint: endpoint,
-		}
+		c.conns[endpoint] = newConnection(endpoint)
 	}
 	return nil
 }
 
+// newConnection 创建到节点的连接，地址未指定协议时使用http
+func newConnection(endpoint string) *connection {
+	baseURL := strings.TrimRight(endpoint, "/")
+	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
+		baseURL = "http://" + baseURL
+	}
+
+	return &connection{
+		endpoint: endpoint,
+		baseURL:  baseURL,
+	}
+}
+
 // Close 关闭客户端及其所有连接
 func (c *Client) Close() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.closeSession()
 
+	c.mu.Lock()
 	if c.closed {
+		c.mu.Unlock()
 		return nil
 	}
-
 	c.closed = true
-	// TODO: 关闭所有连接
+	c.httpClient.CloseIdleConnections()
+	c.mu.Unlock()
 
+	// 进行中的抓取会读取路由器，在释放锁之后再等待其完成
+	if c.metricsListener != nil {
+		c.metricsListener.Close()
+	}
+	if c.stopLeaderEvents != nil {
+		c.stopLeaderEvents()
+		<-c.leaderEventsDone
+	}
 	return nil
 }
 
 // Get 获取键对应的值
 func (c *Client) Get(key string) (string, error) {
+	return c.GetCtx(context.Background(), key)
+}
+
+// GetCtx 获取键对应的值，ctx取消或超时后立即返回ctx.Err()，正在进行的请求被放弃
+func (c *Client) GetCtx(ctx context.Context, key string) (string, error) {
+	route := c.routeKey(ctx, key, RoutingReadNearest)
+	if c.config.ChunkedValues {
+		return c.getChunked(ctx, route, key)
+	}
+	return c.get(ctx, route, key)
+}
+
+// GetAtLeast 读取已应用到index的值，index通常是之前Set返回的日志索引
+// 请求可由跟随者直接以本地数据响应，节点在读超时内未应用到index时返回ErrTimeout并尝试其他节点，
+// 用于读己之写而不必把所有读请求发往领导者
+func (c *Client) GetAtLeast(key string, index uint64) (string, error) {
+	return c.GetAtLeastCtx(context.Background(), key, index)
+}
+
+// GetAtLeastCtx 同GetAtLeast，ctx语义同GetCtx
+func (c *Client) GetAtLeastCtx(ctx context.Context, key string, index uint64) (string, error) {
+	if key == "" {
+		return "", ErrInvalidArgument
+	}
+
+	// 缓存中的值可能早于index，直接向节点读取
+	route := c.routeKey(ctx, key, RoutingReadNearest)
+	var resp response
+	path := fmt.Sprintf("/api/get?key=%s&consistency=stale&minIndex=%d", url.QueryEscape(key), index)
+	if err := c.doRequestTo(ctx, route, http.MethodGet, path, nil, nil, &resp); err != nil {
+		return "", err
+	}
+	if !resp.Exists {
+		return "", ErrKeyNotFound
+	}
+
+	value := resp.stringValue()
+	if c.config.ChunkedValues {
+		if manifest, ok := parseManifest(value); ok {
+			return c.readChunks(ctx, route, key, manifest)
+		}
+	}
+	return value, nil
+}
+
+// get 获取键对应的值，优先请求route中的节点
+func (c *Client) get(ctx context.Context, route []*connection, key string) (string, error) {
 	if key == "" {
 		return "", ErrInvalidArgument
 	}
@@ -131,34 +305,102 @@ func (c *Client) Get(key string) (string, error) {
 		}
 	}
 
-	// TODO: 实现实际获取逻辑
-	// 为演示目的，返回错误
-	return "", ErrKeyNotFound
+	var resp response
+	path := "/api/get?key=" + url.QueryEscape(key)
+	if err := c.doRequestTo(ctx, route, http.MethodGet, path, nil, nil, &resp); err != nil {
+		return "", err
+	}
+
+	if !resp.Exists {
+		return "", ErrKeyNotFound
+	}
+
+	value := resp.stringValue()
+
+	if c.cache != nil {
+		c.cache.Set(key, value, c.cacheTTL(time.Duration(resp.TTLSeconds)*time.Second))
+	}
+
+	return value, nil
 }
 
-// Set 设置键值对
-func (c *Client) Set(key, value string) error {
-	if key == "" {
-		return ErrInvalidArgument
+// Set 设置键值对，返回写入的日志索引
+// 返回时写入已被提交并应用，将索引传给GetAtLeast可从跟随者读到这次写入
+func (c *Client) Set(key, value string) (uint64, error) {
+	return c.SetWithTTL(key, value, 0)
+}
+
+// SetCtx 设置键值对，ctx语义同GetCtx
+func (c *Client) SetCtx(ctx context.Context, key, value string) (uint64, error) {
+	return c.SetWithTTLCtx(ctx, key, value, 0)
+}
+
+// SetWithTTL 设置带过期时间的键值对，ttl为0表示永不过期，返回值同Set
+// 过期时间以秒为精度，由服务端根据领导者写入日志的时间计算
+func (c *Client) SetWithTTL(key, value string, ttl time.Duration) (uint64, error) {
+	return c.SetWithTTLCtx(context.Background(), key, value, ttl)
+}
+
+// SetWithTTLCtx 设置带过期时间的键值对，ctx语义同GetCtx
+// ctx在请求发出后取消时写入可能已被应用，以相同会话序号重试不会被重复执行
+func (c *Client) SetWithTTLCtx(ctx context.Context, key, value string, ttl time.Duration) (uint64, error) {
+	route := c.routeKey(ctx, key, RoutingWritePrimary)
+	if c.config.ChunkedValues {
+		return c.setChunked(ctx, route, key, value, ttl)
 	}
+	return c.setWithTTL(ctx, route, key, value, ttl)
+}
 
-	// TODO: 实现实际设置逻辑
+// setWithTTL 设置带过期时间的键值对，优先请求route中的节点，返回写入的日志索引
+func (c *Client) setWithTTL(ctx context.Context, route []*connection, key, value string, ttl time.Duration) (uint64, error) {
+	if key == "" || ttl < 0 {
+		return 0, ErrInvalidArgument
+	}
+
+	req := request{
+		Key:        key,
+		Value:      value,
+		TTLSeconds: int64(ttl / time.Second),
+	}
+
+	var resp response
+	if err := c.doWriteTo(ctx, route, http.MethodPost, "/api/set", req, &resp); err != nil {
+		return 0, err
+	}
 
 	// 如果启用了缓存，更新缓存
 	if c.cache != nil {
-		c.cache.Set(key, value, c.config.CacheTTL)
+		c.cache.Set(key, value, c.cacheTTL(ttl))
 	}
 
-	return nil
+	return resp.Index, nil
 }
 
 // Delete 删除键值对
 func (c *Client) Delete(key string) error {
+	return c.DeleteCtx(context.Background(), key)
+}
+
+// DeleteCtx 删除键值对，ctx语义同GetCtx
+func (c *Client) DeleteCtx(ctx context.Context, key string) error {
+	route := c.routeKey(ctx, key, RoutingWritePrimary)
+	if c.config.ChunkedValues {
+		return c.deleteChunked(ctx, route, key)
+	}
+	return c.delete(ctx, route, key)
+}
+
+// delete 删除键值对，优先请求route中的节点
+func (c *Client) delete(ctx context.Context, route []*connection, key string) error {
 	if key == "" {
 		return ErrInvalidArgument
 	}
 
-	// TODO: 实现实际删除逻辑
+	var resp response
+	path := "/api/delete?key=" + url.QueryEscape(key)
+	if err := c.doWriteTo(ctx, route, http.MethodDelete, path, nil, &resp); err != nil {
+		return err
+	}
 
 	// 如果启用了缓存，从缓存中删除
 	if c.cache != nil {
@@ -168,16 +410,417 @@ func (c *Client) Delete(key string) error {
 	return nil
 }
 
+// CASResult 比较并交换操作的结果
+type CASResult struct {
+	// Swapped 是否成功写入新值
+	Swapped bool
+	// Exists 操作后键是否存在
+	Exists bool
+	// Value 操作后的当前值
+	Value string
+	// Version 操作后的当前版本
+	Version uint64
+}
+
+// GetWithVersion 获取键对应的值及其版本，版本可用于CompareAndSwapVersion
+func (c *Client) GetWithVersion(key string) (string, uint64, error) {
+	return c.GetWithVersionCtx(context.Background(), key)
+}
+
+// GetWithVersionCtx 获取键对应的值及其版本，ctx语义同GetCtx
+func (c *Client) GetWithVersionCtx(ctx context.Context, key string) (string, uint64, error) {
+	if key == "" {
+		return "", 0, ErrInvalidArgument
+	}
+
+	var resp response
+	path := "/api/get?key=" + url.QueryEscape(key)
+	if err := c.doRequestTo(ctx, nil, http.MethodGet, path, nil, nil, &resp); err != nil {
+		return "", 0, err
+	}
+
+	if !resp.Exists {
+		return "", 0, ErrKeyNotFound
+	}
+
+	return resp.stringValue(), resp.Version, nil
+}
+
+// CompareAndSwap 当键的当前值等于expected时将其替换为newValue
+// expected为nil表示期望键不存在（即仅在键不存在时创建）；未替换时同时返回当前值与ErrConflict
+func (c *Client) CompareAndSwap(key string, expected *string, newValue string) (*CASResult, error) {
+	return c.CompareAndSwapCtx(context.Background(), key, expected, newValue)
+}
+
+// CompareAndSwapCtx 比较并交换，ctx语义同GetCtx
+func (c *Client) CompareAndSwapCtx(ctx context.Context, key string, expected *string, newValue string) (*CASResult, error) {
+	if key == "" {
+		return nil, ErrInvalidArgument
+	}
+
+	req := casRequest{
+		Key:      key,
+		NewValue: newValue,
+	}
+	if expected != nil {
+		req.ExpectedValue = *expected
+	}
+
+	return c.compareAndSwap(ctx, req)
+}
+
+// CompareAndSwapVersion 当键的当前版本等于expectedVersion时将其替换为newValue
+// expectedVersion为0表示期望键不存在；未替换时同时返回当前值与ErrConflict
+func (c *Client) CompareAndSwapVersion(key string, expectedVersion uint64, newValue string) (*CASResult, error) {
+	return c.CompareAndSwapVersionCtx(context.Background(), key, expectedVersion, newValue)
+}
+
+// CompareAndSwapVersionCtx 按版本比较并交换，ctx语义同GetCtx
+func (c *Client) CompareAndSwapVersionCtx(ctx context.Context, key string, expectedVersion uint64, newValue string) (*CASResult, error) {
+	if key == "" {
+		return nil, ErrInvalidArgument
+	}
+
+	return c.compareAndSwap(ctx, casRequest{
+		Key:             key,
+		ExpectedVersion: &expectedVersion,
+		NewValue:        newValue,
+	})
+}
+
+// compareAndSwap 发送CAS请求并更新缓存，未替换时返回结果与包装了ErrConflict的错误
+func (c *Client) compareAndSwap(ctx context.Context, req casRequest) (*CASResult, error) {
+	var resp response
+	if err := c.doWrite(ctx, http.MethodPost, "/api/cas", req, &resp); err != nil {
+		return nil, err
+	}
+
+	result := &CASResult{
+		Swapped: resp.Swapped,
+		Exists:  resp.Exists,
+		Version: resp.Version,
+	}
+	if resp.Exists {
+		result.Value = resp.stringValue()
+	}
+
+	// 缓存中的值可能已过时，直接失效
+	if c.cache != nil {
+		c.cache.Delete(req.Key)
+	}
+
+	if !result.Swapped {
+		return result, fmt.Errorf("%w: 键 %s 当前版本 %d", ErrConflict, req.Key, result.Version)
+	}
+	return result, nil
+}
+
+// cacheTTL 计算缓存条目的TTL，不超过键本身的剩余存活时间
+func (c *Client) cacheTTL(keyTTL time.Duration) time.Duration {
+	if keyTTL > 0 && (c.config.CacheTTL == 0 || keyTTL < c.config.CacheTTL) {
+		return keyTTL
+	}
+	return c.config.CacheTTL
+}
+
+// doRequest 依次尝试各节点发送请求，失败时按重试策略重试；ctx结束时立即返回ctx.Err()
+func (c *Client) doRequest(ctx context.Context, method, path string, body interface{}, headers map[string]string, out interface{}) error {
+	return c.doRequestTo(ctx, nil, method, path, body, headers, out)
+}
+
+// doRequestTo 与doRequest相同，但先依次尝试route中的节点（路由到的目标节点及其备用节点），再尝试配置的节点
+// 不可重试的错误直接返回；非领导者错误先刷新领导者并立即重试领导者，其余可重试错误换下一个节点，
+// 一轮节点都失败后按重试策略退避
+func (c *Client) doRequestTo(ctx context.Context, route []*connection, method, path string, body interface{}, headers map[string]string, out interface{}) error {
+	conns, err := c.candidates(route)
+	if err != nil {
+		return err
+	}
+
+	var payload []byte
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("序列化请求失败: %w", err)
+		}
+		payload = data
+	}
+
+	policy := c.retryPolicy
+	budget := newRetryBudget(ctx)
+	var lastErr error
+	lastClass := ErrorClassPermanent
+	for round := 0; round < policy.MaxAttempts; round++ {
+		if round > 0 {
+			wait := policy.backoff(round)
+			if !budget.allows(wait) {
+				atomic.AddInt64(&c.stats.budgetExhausted, 1)
+				c.logger.Warn("剩余时间不足，停止重试", "path", path, "error", lastErr)
+				return lastErr
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		queue := append([]*connection(nil), conns...)
+		leaderHops := 0
+		for len(queue) > 0 {
+			conn := queue[0]
+			queue = queue[1:]
+
+			if lastErr != nil {
+				if !budget.allows(0) {
+					atomic.AddInt64(&c.stats.budgetExhausted, 1)
+					c.logger.Warn("剩余时间不足，停止重试", "path", path, "error", lastErr)
+					return lastErr
+				}
+				c.stats.recordRetry(lastClass)
+				c.logger.Debug("重试请求", "path", path, "node", conn.baseURL, "class", lastClass.String(), "error", lastErr)
+			}
+
+			start := time.Now()
+			err := c.sendTo(ctx, conn, method, path, payload, headers, out)
+			budget.lastAttempt = time.Since(start)
+			if err == nil || errors.Is(err, ErrSessionExpired) || errors.Is(err, ErrUnsupported) {
+				return err
+			}
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+
+			lastErr, lastClass = err, ClassifyError(err)
+			if !lastClass.Retryable() {
+				return err
+			}
+			// 缓存的领导者已不再是领导者或无法连接，之后的请求不再优先发往它
+			if lastClass == ErrorClassNotLeader || errors.Is(err, ErrConnectionFailed) {
+				c.forgetLeader(conn)
+			}
+			if lastClass == ErrorClassNotLeader {
+				atomic.AddInt64(&c.stats.leaderDiscoveries, 1)
+			}
+			if lastClass == ErrorClassNotLeader && leaderHops < policy.MaxLeaderHops {
+				if leader := c.locateLeader(ctx, err); leader != nil {
+					leaderHops++
+					queue = append([]*connection{leader}, queue...)
+				}
+			}
+		}
+	}
+
+	return lastErr
+}
+
+// connections 按配置顺序返回所有节点的连接
+func (c *Client) connections() ([]*connection, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return nil, ErrConnectionFailed
+	}
+	conns := make([]*connection, 0, len(c.config.Endpoints))
+	for _, endpoint := range c.config.Endpoints {
+		conns = append(conns, c.conns[endpoint])
+	}
+	return conns, nil
+}
+
+// sendTo 向单个节点发送请求并解析响应，ctx结束时放弃请求并返回ctx.Err()
+func (c *Client) sendTo(ctx context.Context, conn *connection, method, path string, payload []byte, headers map[string]string, out interface{}) error {
+	var reader io.Reader
+	if payload != nil {
+		reader = bytes.NewReader(payload)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, conn.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %w", err)
+	}
+	if payload != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	c.authorize(httpReq)
+	for name, value := range headers {
+		httpReq.Header.Set(name, value)
+	}
+
+	httpResp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return ErrTimeout
+		}
+		return fmt.Errorf("%w: %v", ErrConnectionFailed, err)
+	}
+	defer httpResp.Body.Close()
+
+	// 跟随了非领导者的307重定向：最终响应的节点即领导者，多出的一次往返计入领导者发现
+	if final := httpResp.Request.URL; final.Host != httpReq.URL.Host {
+		atomic.AddInt64(&c.stats.leaderDiscoveries, 1)
+		c.rememberLeader("", c.connectionFor(final.Scheme+"://"+final.Host))
+	}
+
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("读取响应失败: %w", err)
+	}
+
+	if httpResp.StatusCode == http.StatusGone {
+		return fmt.Errorf("%w: %s", ErrSessionExpired, strings.TrimSpace(string(data)))
+	}
+	// 键不存在的404带有错误码，其余404与405表示服务端没有该接口
+	if (httpResp.StatusCode == http.StatusNotFound && errorCode(data) != codeKeyNotFound) || httpResp.StatusCode == http.StatusMethodNotAllowed {
+		return fmt.Errorf("%w: %s %s，状态码: %d", ErrUnsupported, method, path, httpResp.StatusCode)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return statusError(httpResp.StatusCode, data)
+	}
+
+	var base response
+	if err := json.Unmarshal(data, &base); err != nil {
+		return fmt.Errorf("解析响应失败: %w", err)
+	}
+	if err := base.notLeader(); err != nil {
+		return err
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("解析响应失败: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// authorize 配置了访问令牌时为请求加上认证头
+func (c *Client) authorize(req *http.Request) {
+	if c.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.Token)
+	}
+}
+
+// statusError 把非200响应转换为错误，错误类型决定请求是否重试
+func statusError(status int, data []byte) error {
+	var base response
+	if json.Unmarshal(data, &base) == nil {
+		if err := base.notLeader(); err != nil {
+			return err
+		}
+	}
+
+	detail := fmt.Sprintf("状态码: %d, 响应: %s", status, strings.TrimSpace(string(data)))
+	switch {
+	case base.Error.Code == codeKeyNotFound:
+		return ErrKeyNotFound
+	case base.Error.Code == codeTimeout:
+		return fmt.Errorf("%w: %s", ErrTimeout, detail)
+	case base.Error.Code == codeConflict:
+		return fmt.Errorf("%w: %s", ErrConflict, detail)
+	case status == http.StatusRequestEntityTooLarge:
+		return fmt.Errorf("%w: %s", ErrValueTooLarge, detail)
+	case status == http.StatusBadRequest:
+		return fmt.Errorf("%w: %s", ErrInvalidArgument, detail)
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return fmt.Errorf("%w: %s", ErrAccessDenied, detail)
+	case status == http.StatusTooManyRequests || status >= http.StatusInternalServerError:
+		return fmt.Errorf("%w: %s", ErrUnavailable, detail)
+	default:
+		return fmt.Errorf("请求失败，%s", detail)
+	}
+}
+
 // 基本请求结构
 type request struct {
-	Type  string
-	Key   string
-	Value string
+	Type        string `json:"type,omitempty"`
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	TTLSeconds  int64  `json:"ttlSeconds,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Compress    bool   `json:"compress,omitempty"`
+}
+
+// CAS请求结构
+type casRequest struct {
+	Key             string      `json:"key"`
+	ExpectedValue   interface{} `json:"expectedValue"`
+	ExpectedVersion *uint64     `json:"expectedVersion,omitempty"`
+	NewValue        string      `json:"newValue"`
 }
 
 // 基本响应结构
 type response struct {
-	Success bool
-	Value   string
-	Error   string
+	Success    bool            `json:"success"`
+	Exists     bool            `json:"exists"`
+	Swapped    bool            `json:"swapped"`
+	Value      json.RawMessage `json:"value"`
+	Version    uint64          `json:"version"`
+	TTLSeconds int64           `json:"ttlSeconds"`
+	Index      uint64          `json:"index"`
+	// 写入时指定的内容类型与服务端是否压缩存储
+	ContentType string    `json:"contentType"`
+	Compressed  bool      `json:"compressed"`
+	Error       errorBody `json:"error"`
+	Leader      string    `json:"leader"`
+	LeaderAddr  string    `json:"leaderApiAddr"`
+}
+
+// errorBody 服务端的错误信息：{"code", "message", "raftIndex"}，兼容旧版本服务端的字符串格式
+type errorBody struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RaftIndex  uint64 `json:"raftIndex"`
+	Leader     string `json:"leader"`
+	LeaderAddr string `json:"leaderApiAddr"`
+}
+
+// UnmarshalJSON 字符串格式的错误只有描述
+func (e *errorBody) UnmarshalJSON(data []byte) error {
+	var message string
+	if json.Unmarshal(data, &message) == nil {
+		*e = errorBody{Message: message}
+		return nil
+	}
+	type plain errorBody
+	return json.Unmarshal(data, (*plain)(e))
+}
+
+// errorCode 解析响应体中的错误码，响应体不是统一的错误格式时返回空串
+func errorCode(data []byte) string {
+	var base response
+	if json.Unmarshal(data, &base) != nil {
+		return ""
+	}
+	return base.Error.Code
+}
+
+// notLeader 响应表示节点不是领导者时返回*NotLeaderError
+func (r *response) notLeader() error {
+	leader, addr := r.Leader, r.LeaderAddr
+	if r.Error.Code == codeNotLeader {
+		leader, addr = r.Error.Leader, r.Error.LeaderAddr
+	}
+	if leader == "" || r.Success || r.Error.Message == "" {
+		return nil
+	}
+	return &NotLeaderError{LeaderID: NodeID(leader), LeaderAddr: addr}
+}
+
+// stringValue 将响应中的值转换为字符串，非字符串值保留其JSON表示
+func (r *response) stringValue() string {
+	var str string
+	if err := json.Unmarshal(r.Value, &str); err == nil {
+		return str
+	}
+	return string(r.Value)
 }
@@ -0,0 +1,130 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-21 15:20:44
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-21 15:20:44
+* @Description: ConcordKV Go client read-your-writes tests
+ */
+
+package concord
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// laggingNode 写入立即返回日志索引，但本地应用由测试推进，按minIndex等待的读请求在超时后返回504
+type laggingNode struct {
+	index   atomic.Uint64
+	applied atomic.Uint64
+	stale   atomic.Int64 // 带consistency=stale的读请求数
+}
+
+func (n *laggingNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/set":
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "index": n.index.Add(1)})
+	case "/api/get":
+		if r.URL.Query().Get("consistency") == "stale" {
+			n.stale.Add(1)
+		}
+		minIndex, _ := strconv.ParseUint(r.URL.Query().Get("minIndex"), 10, 64)
+		deadline := time.Now().Add(100 * time.Millisecond)
+		for n.applied.Load() < minIndex {
+			if time.Now().After(deadline) {
+				w.WriteHeader(http.StatusGatewayTimeout)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"error": map[string]interface{}{"code": "TIMEOUT", "message": "等待应用超时", "raftIndex": n.applied.Load()},
+				})
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"exists": true, "value": "v", "lastApplied": n.applied.Load()})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// TestGetAtLeastWaitsForWrite Set返回写入的日志索引；GetAtLeast在节点应用到该索引后读到写入，
+// 节点持续落后时返回ErrTimeout
+func TestGetAtLeastWaitsForWrite(t *testing.T) {
+	node := &laggingNode{}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	client, err := NewClient(Config{Endpoints: []string{server.URL}, RetryCount: 1, DisableSession: true, Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+
+	index, err := client.Set("k", "v")
+	if err != nil || index != 1 {
+		t.Fatalf("Set应返回日志索引1，实际 %d %v", index, err)
+	}
+
+	// 等待路径：节点在等待期间应用到index
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		node.applied.Store(index)
+	}()
+	value, err := client.GetAtLeast("k", index)
+	if err != nil || value != "v" {
+		t.Fatalf("节点应用到索引后应读到写入，实际 %q %v", value, err)
+	}
+	if node.stale.Load() == 0 {
+		t.Error("GetAtLeast应允许节点以本地数据响应")
+	}
+
+	// 超时路径：节点没有应用到index
+	if _, err := client.GetAtLeast("k", index+1); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("节点落后时应返回ErrTimeout，实际 %v", err)
+	}
+}
+
+// TestClientTypedErrors CAS未替换、写冲突与非领导者响应转换为可用errors.Is/As判断的错误
+func TestClientTypedErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/cas":
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "swapped": false, "exists": true, "value": "old", "version": 3})
+		case "/api/set":
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": "CONFLICT", "message": "请求序号已被确认"}})
+		case "/api/get":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": "NOT_LEADER", "message": "不是领导者", "leader": "node2"}})
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{Endpoints: []string{server.URL}, RetryCount: 1, DisableSession: true, Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+
+	expected := "expected"
+	result, err := client.CompareAndSwap("k", &expected, "new")
+	if !errors.Is(err, ErrConflict) || result == nil || result.Swapped || result.Value != "old" || result.Version != 3 {
+		t.Fatalf("CAS未替换时应返回当前值与ErrConflict，实际 %+v %v", result, err)
+	}
+
+	if _, err := client.Set("k", "v"); !errors.Is(err, ErrConflict) || ClassifyError(err) != ErrorClassPermanent {
+		t.Fatalf("CONFLICT响应应转换为不可重试的ErrConflict，实际 %v", err)
+	}
+
+	_, err = client.Get("k")
+	var notLeader *NotLeaderError
+	if !errors.Is(err, ErrNotLeader) || !errors.As(err, &notLeader) || notLeader.LeaderID != "node2" {
+		t.Fatalf("NOT_LEADER响应应转换为NotLeaderError，实际 %v", err)
+	}
+}
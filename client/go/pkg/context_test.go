@@ -0,0 +1,142 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-9 10:12:44
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-9 10:12:44
+* @Description: ConcordKV Go client context cancellation tests
+ */
+
+package concord
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newHungServer 创建收到请求后一直挂起的节点，started在每个请求到达时收到通知
+func newHungServer(t *testing.T) (string, <-chan struct{}) {
+	started := make(chan struct{}, 16)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(func() {
+		close(release)
+		server.Close()
+	})
+	return server.URL, started
+}
+
+// expectPromptReturn 等待errCh返回，要求在1秒内以want结束
+func expectPromptReturn(t *testing.T, errCh <-chan error, want error, what string) {
+	t.Helper()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, want) {
+			t.Fatalf("%s应返回%v，实际 %v", what, want, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("%s在ctx结束后没有及时返回", what)
+	}
+}
+
+// TestClientCancelWhileWaitingForConnection 节点连接数耗尽时，等待连接的请求随ctx取消立即返回，
+// 取消进行中请求的ctx同样会放弃该请求
+func TestClientCancelWhileWaitingForConnection(t *testing.T) {
+	addr, started := newHungServer(t)
+	client, err := NewClient(Config{Endpoints: []string{addr}, Timeout: 30 * time.Second, RetryCount: 3, DisableSession: true, MaxConnsPerNode: 1})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+
+	inflightCtx, cancelInflight := context.WithCancel(context.Background())
+	inflight := make(chan error, 1)
+	go func() {
+		_, err := client.GetCtx(inflightCtx, "held")
+		inflight <- err
+	}()
+	<-started
+
+	waitingCtx, cancelWaiting := context.WithCancel(context.Background())
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := client.GetCtx(waitingCtx, "queued")
+		waiting <- err
+	}()
+
+	select {
+	case <-started:
+		t.Fatalf("连接数已达上限，第二个请求不应到达节点")
+	case err := <-waiting:
+		t.Fatalf("第二个请求应等待空闲连接，实际返回 %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancelWaiting()
+	expectPromptReturn(t, waiting, context.Canceled, "等待连接的请求")
+
+	cancelInflight()
+	expectPromptReturn(t, inflight, context.Canceled, "进行中的请求")
+}
+
+// TestClientDeadlineOnHungNode 节点挂起时请求在ctx截止时间返回，不等待Timeout也不继续重试
+func TestClientDeadlineOnHungNode(t *testing.T) {
+	addr, _ := newHungServer(t)
+	client, err := NewClient(Config{Endpoints: []string{addr}, Timeout: 30 * time.Second, RetryCount: 3, DisableSession: true})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() { _, err := client.SetCtx(ctx, "key", "value"); errCh <- err }()
+	expectPromptReturn(t, errCh, context.DeadlineExceeded, "写请求")
+
+	if _, err := client.MGetCtx(ctx, []string{"a", "b"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ctx已结束时批量读取应返回ctx.Err()，实际 %v", err)
+	}
+}
+
+// TestConnectionPoolGetCancel 连接池耗尽时，在等待队列中的Get随ctx取消立即返回
+func TestConnectionPoolGetCancel(t *testing.T) {
+	pool := newFakePool(t)
+	if err := pool.Resize(1); err != nil {
+		t.Fatalf("调整连接池大小失败: %v", err)
+	}
+
+	held, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("获取连接失败: %v", err)
+	}
+	defer pool.Put(held)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		conn, err := pool.Get(ctx)
+		if conn != nil {
+			pool.Put(conn)
+		}
+		errCh <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	expectPromptReturn(t, errCh, context.Canceled, "等待连接的Get")
+	if stats := pool.GetStats(); stats.WaitingRequests != 0 {
+		t.Fatalf("取消后等待者应离开等待队列: %+v", stats)
+	}
+}
@@ -12,12 +12,22 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/concordkv/client/go/clock"
 )
 
+// errPoolExhausted 连接数已达目标大小，不能再创建连接
+var errPoolExhausted = errors.New("连接数已达上限")
+
+// pingReadWait 默认健康检查读取连接的等待时间，对端已关闭的连接会立即返回EOF
+const pingReadWait = time.Millisecond
+
 // ConnectionState 连接状态
 type ConnectionState int
 
@@ -68,6 +78,14 @@ type Connection struct {
 	isPreWarmed bool                   // 是否预热连接
 	metadata    map[string]interface{} // 元数据
 	pool        *ConnectionPool        // 所属连接池引用
+
+	clock       clock.Clock                     // 记录创建与使用时间的时钟，加入连接池时换成连接池的时钟
+	healthCheck func(ctx context.Context) error // 自定义健康检查，由连接工厂设置
+	checking    bool                            // 正在做健康检查，期间不能被取用，由连接池的mu保护
+	released    int32                           // 本次借用是否已归还，保证Release只生效一次
+
+	pipelineConfig *PoolConfig // 管道化与批量处理配置，nil时按请求-响应模式发送
+	pipeline       *pipeline   // 第一次SendRequest时启动
 }
 
 // NewConnection 创建新连接
@@ -80,6 +98,7 @@ func NewConnection(id string, nodeID NodeID, shardID string, address string, tim
 		state:      ConnStateIdle,
 		createdAt:  time.Now(),
 		lastUsedAt: time.Now(),
+		clock:      clock.Real(),
 		maxErrors:  10,
 		timeout:    timeout,
 		keepAlive:  30 * time.Second,
@@ -87,6 +106,16 @@ func NewConnection(id string, nodeID NodeID, shardID string, address string, tim
 	}
 }
 
+// useClock 改用clk计时，创建与最后使用时间从clk的当前时间开始
+func (c *Connection) useClock(clk clock.Clock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.clock = clk
+	c.createdAt = clk.Now()
+	c.lastUsedAt = c.createdAt
+}
+
 // Connect 建立连接
 func (c *Connection) Connect(ctx context.Context) error {
 	c.mu.Lock()
@@ -113,7 +142,7 @@ func (c *Connection) Connect(ctx context.Context) error {
 
 	c.conn = conn
 	c.state = ConnStateActive
-	c.lastUsedAt = time.Now()
+	c.lastUsedAt = c.clock.Now()
 
 	return nil
 }
@@ -130,7 +159,10 @@ func (c *Connection) Close() error {
 	c.state = ConnStateClosing
 
 	var err error
-	if c.conn != nil {
+	if c.pipeline != nil {
+		// 关闭管道时一并关闭底层连接，未完成的请求以ErrPipelineClosed结束
+		c.pipeline.close()
+	} else if c.conn != nil {
 		err = c.conn.Close()
 	}
 
@@ -138,12 +170,110 @@ func (c *Connection) Close() error {
 	return err
 }
 
-// IsHealthy 检查连接是否健康
+// IsHealthy 检查连接是否健康，使用中和空闲的连接都可能健康
 func (c *Connection) IsHealthy() bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	return c.state == ConnStateActive && len(c.errors) < c.maxErrors
+	return (c.state == ConnStateActive || c.state == ConnStateIdle) && len(c.errors) < c.maxErrors
+}
+
+// SetHealthCheck 设置自定义健康检查，替代默认的读取探测；不建立真实网络连接的工厂用它模拟探测结果
+func (c *Connection) SetHealthCheck(check func(ctx context.Context) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.healthCheck = check
+}
+
+// SetPipelineConfig 设置SendRequest使用的管道化与批量处理配置，只在管道启动前生效
+func (c *Connection) SetPipelineConfig(config *PoolConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pipelineConfig = config
+}
+
+// SendRequest 以管道化方式发送请求，不等待之前请求的响应；返回的通道在收到响应或连接失败时得到一个结果
+// 未完成的请求数达到MaxPipelineSize时阻塞，直到有响应返回或ctx结束；未启用管道化时同一时刻只有一个未完成请求
+func (c *Connection) SendRequest(ctx context.Context, payload []byte) (<-chan Response, error) {
+	c.mu.Lock()
+	if c.pipeline == nil {
+		if c.conn == nil || c.state == ConnStateClosing || c.state == ConnStateClosed || c.state == ConnStateError {
+			c.mu.Unlock()
+			return nil, fmt.Errorf("连接 %s 不可用，状态为 %s", c.id, c.state)
+		}
+		c.pipeline = newPipeline(c.conn, c.pipelineConfig)
+	}
+	p := c.pipeline
+	c.lastUsedAt = c.clock.Now()
+	c.mu.Unlock()
+
+	ch, err := p.send(ctx, payload)
+	if err != nil {
+		return nil, fmt.Errorf("连接 %s 发送请求失败: %w", c.id, err)
+	}
+	return ch, nil
+}
+
+// HealthCheck 探测连接是否仍然可用，失败时把连接标记为错误状态
+// 默认在底层TCP连接上做一次带截止时间的读取：超时说明连接仍然存活，EOF等错误说明对端已断开；
+// 只能对空闲连接调用，空闲连接上读到数据同样视为异常。已启动管道的连接由管道的读协程发现断开
+func (c *Connection) HealthCheck(ctx context.Context) error {
+	c.mu.RLock()
+	check, netConn, state, p := c.healthCheck, c.conn, c.state, c.pipeline
+	c.mu.RUnlock()
+
+	var err error
+	switch {
+	case state == ConnStateClosing || state == ConnStateClosed || state == ConnStateError:
+		return fmt.Errorf("连接 %s 状态为 %s", c.id, state)
+	case check != nil:
+		err = check(ctx)
+	case p != nil:
+		err = p.failure()
+	case netConn == nil:
+		err = fmt.Errorf("连接 %s 尚未建立", c.id)
+	default:
+		err = pingConn(ctx, netConn)
+	}
+
+	if err != nil {
+		c.mu.Lock()
+		c.state = ConnStateError
+		c.addError(err)
+		c.mu.Unlock()
+		return fmt.Errorf("连接 %s 健康检查失败: %w", c.id, err)
+	}
+	return nil
+}
+
+// pingConn 在空闲连接上读取一个字节，截止时间内没有数据说明连接正常
+func pingConn(ctx context.Context, conn net.Conn) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(pingReadWait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	var buf [1]byte
+	n, err := conn.Read(buf[:])
+	if n > 0 {
+		return errors.New("空闲连接上收到未预期的数据")
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return nil
+	}
+	if err == nil {
+		err = errors.New("读取返回空结果")
+	}
+	return err
 }
 
 // IsIdle 检查连接是否空闲
@@ -159,9 +289,17 @@ func (c *Connection) MarkUsed() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.lastUsedAt = time.Now()
+	c.lastUsedAt = c.clock.Now()
 	atomic.AddInt64(&c.usageCount, 1)
 	c.state = ConnStateActive
+	atomic.StoreInt32(&c.released, 0)
+}
+
+// Release 把借出的连接归还给所属连接池，同一次借用内重复调用是安全的
+func (c *Connection) Release() {
+	if c.pool != nil {
+		c.pool.Put(c)
+	}
 }
 
 // MarkIdle 标记连接为空闲
@@ -189,7 +327,7 @@ func (c *Connection) GetStats() *ConnectionStats {
 		LastUsedAt:  c.lastUsedAt,
 		UsageCount:  atomic.LoadInt64(&c.usageCount),
 		ErrorCount:  len(c.errors),
-		IdleTime:    time.Since(c.lastUsedAt),
+		IdleTime:    c.clock.Now().Sub(c.lastUsedAt),
 		IsPreWarmed: c.isPreWarmed,
 	}
 }
@@ -220,18 +358,21 @@ type ConnectionStats struct {
 // PoolConfig 连接池配置
 type PoolConfig struct {
 	// 基础配置
-	MinConnections    int           `json:"minConnections"`    // 最小连接数
-	MaxConnections    int           `json:"maxConnections"`    // 最大连接数
-	InitialSize       int           `json:"initialSize"`       // 初始连接数
-	ConnectionTimeout time.Duration `json:"connectionTimeout"` // 连接超时
-	IdleTimeout       time.Duration `json:"idleTimeout"`       // 空闲超时
-	MaxLifetime       time.Duration `json:"maxLifetime"`       // 最大生命周期
+	MinConnections     int           `json:"minConnections"`     // 最小连接数
+	MaxConnections     int           `json:"maxConnections"`     // 最大连接数
+	InitialSize        int           `json:"initialSize"`        // 初始连接数
+	ConnectionTimeout  time.Duration `json:"connectionTimeout"`  // 连接超时
+	IdleTimeout        time.Duration `json:"idleTimeout"`        // 空闲超时
+	MaxIdleConnections int           `json:"maxIdleConnections"` // 最大空闲连接数，超出的连接归还时直接关闭，0表示不限制
+	MaxLifetime        time.Duration `json:"maxLifetime"`        // 最大生命周期
 
 	// 预热配置
 	EnablePreWarm      bool `json:"enablePreWarm"`      // 是否启用预热
 	PreWarmSize        int  `json:"preWarmSize"`        // 预热连接数
 	PreWarmConcurrency int  `json:"preWarmConcurrency"` // 预热并发数
 
+	ShardWarmUpConcurrency int `json:"shardWarmUpConcurrency"` // 分片感知连接池同时在后台预热的节点连接池数
+
 	// 健康检查配置
 	HealthCheckInterval time.Duration `json:"healthCheckInterval"` // 健康检查间隔
 	HealthCheckTimeout  time.Duration `json:"healthCheckTimeout"`  // 健康检查超时
@@ -252,35 +393,40 @@ type PoolConfig struct {
 	EnableBatching   bool          `json:"enableBatching"`   // 是否启用批量处理
 	BatchSize        int           `json:"batchSize"`        // 批量大小
 	BatchTimeout     time.Duration `json:"batchTimeout"`     // 批量超时
+
+	// Clock 连接池计时使用的时钟，包括健康检查、扩缩容与清理的定时器和连接的使用时间；为nil时使用真实时钟，测试中注入testutil.FakeClock
+	Clock clock.Clock `json:"-"`
 }
 
 // DefaultPoolConfig 默认连接池配置
 func DefaultPoolConfig() *PoolConfig {
 	return &PoolConfig{
-		MinConnections:      5,
-		MaxConnections:      100,
-		InitialSize:         10,
-		ConnectionTimeout:   30 * time.Second,
-		IdleTimeout:         5 * time.Minute,
-		MaxLifetime:         1 * time.Hour,
-		EnablePreWarm:       true,
-		PreWarmSize:         5,
-		PreWarmConcurrency:  3,
-		HealthCheckInterval: 30 * time.Second,
-		HealthCheckTimeout:  5 * time.Second,
-		MaxRetries:          3,
-		RetryInterval:       time.Second,
-		EnableAutoScale:     true,
-		ScaleUpThreshold:    0.8,
-		ScaleDownThreshold:  0.3,
-		ScaleUpStep:         5,
-		ScaleDownStep:       2,
-		ScaleInterval:       1 * time.Minute,
-		EnablePipelining:    true,
-		MaxPipelineSize:     10,
-		EnableBatching:      true,
-		BatchSize:           100,
-		BatchTimeout:        10 * time.Millisecond,
+		MinConnections:         5,
+		MaxConnections:         100,
+		InitialSize:            10,
+		ConnectionTimeout:      30 * time.Second,
+		IdleTimeout:            5 * time.Minute,
+		MaxIdleConnections:     20,
+		MaxLifetime:            1 * time.Hour,
+		EnablePreWarm:          true,
+		PreWarmSize:            5,
+		PreWarmConcurrency:     3,
+		ShardWarmUpConcurrency: 8,
+		HealthCheckInterval:    30 * time.Second,
+		HealthCheckTimeout:     5 * time.Second,
+		MaxRetries:             3,
+		RetryInterval:          time.Second,
+		EnableAutoScale:        true,
+		ScaleUpThreshold:       0.8,
+		ScaleDownThreshold:     0.3,
+		ScaleUpStep:            5,
+		ScaleDownStep:          2,
+		ScaleInterval:          1 * time.Minute,
+		EnablePipelining:       true,
+		MaxPipelineSize:        10,
+		EnableBatching:         true,
+		BatchSize:              100,
+		BatchTimeout:           10 * time.Millisecond,
 	}
 }
 
@@ -295,11 +441,20 @@ type ConnectionPool struct {
 	idleConnections []*Connection          // 空闲连接队列
 	activeCount     int64                  // 活跃连接数
 	totalCount      int64                  // 总连接数
-	stats           *PoolStats             // 统计信息
+	targetSize      int64                  // 目标连接数，由Resize调整，不超过MaxConnections
+	stats           *PoolStats             // 统计信息，计数器原子更新，平均与p99等待时间在GetStats中由waitTimes计算
+	waitTimes       latencySamples         // 最近的Get等待时间
 	stopChannel     chan struct{}          // 停止信号
 	isRunning       int64                  // 运行状态
-	waitQueue       chan chan *Connection  // 等待队列
+	waiters         []*connWaiter          // 等待队列，先进先出，由mu保护
 	factory         ConnectionFactory      // 连接工厂
+	clock           clock.Clock            // 计时使用的时钟，来自PoolConfig.Clock
+}
+
+// connWaiter 等待空闲连接的请求
+// 持有mu的一方把等待者移出队列的同时向ch放入一次：归还的连接，或nil表示已为其占用了一个新建连接的名额
+type connWaiter struct {
+	ch chan *Connection // 容量为1，放入不会阻塞
 }
 
 // ConnectionFactory 连接工厂接口
@@ -337,7 +492,8 @@ type PoolStats struct {
 	TotalRequests        int64         `json:"totalRequests"`        // 总请求数
 	SuccessfulRequests   int64         `json:"successfulRequests"`   // 成功请求数
 	FailedRequests       int64         `json:"failedRequests"`       // 失败请求数
-	AverageWaitTime      time.Duration `json:"averageWaitTime"`      // 平均等待时间
+	AverageWaitTime      time.Duration `json:"averageWaitTime"`      // 最近获取连接的平均等待时间
+	P99WaitTime          time.Duration `json:"p99WaitTime"`          // 最近获取连接的p99等待时间
 	AverageUsageTime     time.Duration `json:"averageUsageTime"`     // 平均使用时间
 	ConnectionsCreated   int64         `json:"connectionsCreated"`   // 创建的连接数
 	ConnectionsDestroyed int64         `json:"connectionsDestroyed"` // 销毁的连接数
@@ -363,8 +519,9 @@ func NewConnectionPool(config *PoolConfig, nodeID NodeID, shardID string, addres
 		connections:     make(map[string]*Connection),
 		idleConnections: make([]*Connection, 0, config.MaxConnections),
 		stopChannel:     make(chan struct{}),
-		waitQueue:       make(chan chan *Connection, config.MaxConnections),
 		factory:         factory,
+		clock:           clock.Or(config.Clock),
+		targetSize:      int64(config.MaxConnections),
 		stats: &PoolStats{
 			NodeID:  nodeID,
 			ShardID: shardID,
@@ -426,101 +583,127 @@ func (cp *ConnectionPool) Stop() error {
 }
 
 // Get 获取连接
+// 没有空闲连接且连接数已达目标大小时按先进先出排队，等待队列的长度不超过MaxConnections；
+// 检查空闲连接与进入队列在同一把锁内完成，之后归还的连接一定会交给队首的等待者
 func (cp *ConnectionPool) Get(ctx context.Context) (*Connection, error) {
-	start := time.Now()
+	start := cp.clock.Now()
 	defer func() {
-		waitTime := time.Since(start)
-		cp.updateAverageWaitTime(waitTime)
+		cp.waitTimes.record(cp.clock.Now().Sub(start))
 		atomic.AddInt64(&cp.stats.TotalRequests, 1)
 	}()
 
-	// 尝试从空闲连接获取
-	if conn := cp.getIdleConnection(); conn != nil {
-		conn.MarkUsed()
-		atomic.AddInt64(&cp.activeCount, 1)
-		atomic.AddInt64(&cp.stats.SuccessfulRequests, 1)
-		return conn, nil
-	}
+	canCreate := true
+	for {
+		cp.mu.Lock()
+		// 已有请求在等待时新请求排在它们之后，不抢占归还的连接
+		if len(cp.waiters) == 0 {
+			if conn := cp.getIdleConnectionLocked(); conn != nil {
+				cp.mu.Unlock()
+				return cp.checkout(conn), nil
+			}
 
-	// 尝试创建新连接
-	if atomic.LoadInt64(&cp.totalCount) < int64(cp.config.MaxConnections) {
-		conn, err := cp.createConnection(ctx)
-		if err == nil {
-			conn.MarkUsed()
-			atomic.AddInt64(&cp.activeCount, 1)
-			atomic.AddInt64(&cp.stats.SuccessfulRequests, 1)
-			return conn, nil
+			// 尝试创建新连接，连接数已达目标大小或创建失败时进入等待
+			if canCreate && cp.reserveConnection() {
+				cp.mu.Unlock()
+				conn, err := cp.connectReserved(ctx)
+				if err == nil {
+					return cp.checkout(conn), nil
+				}
+				// 创建期间可能有连接被归还，重新检查后再排队
+				canCreate = false
+				continue
+			}
 		}
+
+		if len(cp.waiters) >= cp.config.MaxConnections {
+			// 等待队列满
+			queueLen := len(cp.waiters)
+			cp.mu.Unlock()
+			atomic.AddInt64(&cp.stats.FailedRequests, 1)
+			return nil, &PoolExhaustedError{Wait: cp.clock.Now().Sub(start), QueueLen: queueLen}
+		}
+
+		waiter := &connWaiter{ch: make(chan *Connection, 1)}
+		cp.waiters = append(cp.waiters, waiter)
+		cp.mu.Unlock()
+		return cp.wait(ctx, waiter)
 	}
+}
 
-	// 等待空闲连接
-	waitChan := make(chan *Connection, 1)
+// 内部方法：等待归还的连接或新建连接的名额
+func (cp *ConnectionPool) wait(ctx context.Context, waiter *connWaiter) (*Connection, error) {
+	atomic.AddInt64(&cp.stats.WaitingRequests, 1)
+	defer atomic.AddInt64(&cp.stats.WaitingRequests, -1)
 
 	select {
-	case cp.waitQueue <- waitChan:
-		// 进入等待队列
-		atomic.AddInt64(&cp.stats.WaitingRequests, 1)
-		defer atomic.AddInt64(&cp.stats.WaitingRequests, -1)
-
-		select {
-		case conn := <-waitChan:
-			if conn != nil {
-				conn.MarkUsed()
-				atomic.AddInt64(&cp.activeCount, 1)
-				atomic.AddInt64(&cp.stats.SuccessfulRequests, 1)
-				return conn, nil
-			}
-			atomic.AddInt64(&cp.stats.FailedRequests, 1)
-			return nil, errors.New("从等待队列获取连接失败")
-		case <-ctx.Done():
-			atomic.AddInt64(&cp.stats.FailedRequests, 1)
-			return nil, ctx.Err()
-		case <-cp.stopChannel:
+	case conn := <-waiter.ch:
+		if conn != nil {
+			return cp.checkout(conn), nil
+		}
+		// 连接被移除后空出了名额，由该请求新建连接
+		conn, err := cp.connectReserved(ctx)
+		if err != nil {
 			atomic.AddInt64(&cp.stats.FailedRequests, 1)
-			return nil, errors.New("连接池已关闭")
+			return nil, fmt.Errorf("新建连接失败: %w", err)
 		}
-	default:
-		// 等待队列满
+		return cp.checkout(conn), nil
+	case <-ctx.Done():
+		cp.abandonWait(waiter)
 		atomic.AddInt64(&cp.stats.FailedRequests, 1)
-		return nil, errors.New("连接池繁忙，等待队列已满")
+		return nil, ctx.Err()
+	case <-cp.stopChannel:
+		cp.abandonWait(waiter)
+		atomic.AddInt64(&cp.stats.FailedRequests, 1)
+		return nil, errors.New("连接池已关闭")
 	}
 }
 
-// Put 归还连接
+// 内部方法：把连接标记为使用中并借出
+func (cp *ConnectionPool) checkout(conn *Connection) *Connection {
+	conn.MarkUsed()
+	atomic.AddInt64(&cp.activeCount, 1)
+	atomic.AddInt64(&cp.stats.SuccessfulRequests, 1)
+	return conn
+}
+
+// Put 归还连接，同一次借用内重复归还会被忽略
 func (cp *ConnectionPool) Put(conn *Connection) {
-	if conn == nil {
+	if conn == nil || !atomic.CompareAndSwapInt32(&conn.released, 0, 1) {
 		return
 	}
 
+	atomic.AddInt64(&cp.activeCount, -1)
+
 	cp.mu.Lock()
 	defer cp.mu.Unlock()
+	cp.releaseLocked(conn)
+}
 
-	// 检查连接健康状态
-	if !conn.IsHealthy() {
-		cp.removeConnection(conn)
-		atomic.AddInt64(&cp.activeCount, -1)
-		return
+// Do 借出一个连接执行fn，结束后总会归还连接
+// fn返回连接级错误（如io.EOF、连接被重置）或发生panic时连接状态不可信，直接关闭而不放回连接池；panic会继续向上传递
+func (cp *ConnectionPool) Do(ctx context.Context, fn func(conn *Connection) error) error {
+	conn, err := cp.Get(ctx)
+	if err != nil {
+		return err
 	}
+	return cp.doWith(conn, fn)
+}
 
-	conn.MarkIdle()
-	atomic.AddInt64(&cp.activeCount, -1)
-
-	// 尝试满足等待的请求
-	select {
-	case waitChan := <-cp.waitQueue:
-		select {
-		case waitChan <- conn:
-			atomic.AddInt64(&cp.activeCount, 1)
+// 内部方法：用借出的连接执行fn并归还连接
+func (cp *ConnectionPool) doWith(conn *Connection, fn func(conn *Connection) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			cp.discard(conn)
+			panic(r)
+		}
+		if isConnectionError(err) {
+			cp.discard(conn)
 			return
-		default:
-			// 等待请求已取消
 		}
-	default:
-		// 没有等待的请求
-	}
+		conn.Release()
+	}()
 
-	// 添加到空闲连接队列
-	cp.idleConnections = append(cp.idleConnections, conn)
+	return fn(conn)
 }
 
 // GetStats 获取统计信息
@@ -528,13 +711,27 @@ func (cp *ConnectionPool) GetStats() *PoolStats {
 	cp.mu.RLock()
 	defer cp.mu.RUnlock()
 
-	stats := *cp.stats
-	stats.TotalConnections = atomic.LoadInt64(&cp.totalCount)
-	stats.ActiveConnections = atomic.LoadInt64(&cp.activeCount)
-	stats.IdleConnections = int64(len(cp.idleConnections))
-	stats.LastUpdate = time.Now()
-
-	return &stats
+	// 计数器由原子操作更新，逐个原子读取
+	averageWait, p99Wait := cp.waitTimes.summary()
+	return &PoolStats{
+		NodeID:               cp.stats.NodeID,
+		ShardID:              cp.stats.ShardID,
+		Address:              cp.stats.Address,
+		TotalConnections:     atomic.LoadInt64(&cp.totalCount),
+		ActiveConnections:    atomic.LoadInt64(&cp.activeCount),
+		IdleConnections:      int64(len(cp.idleConnections)),
+		WaitingRequests:      atomic.LoadInt64(&cp.stats.WaitingRequests),
+		TotalRequests:        atomic.LoadInt64(&cp.stats.TotalRequests),
+		SuccessfulRequests:   atomic.LoadInt64(&cp.stats.SuccessfulRequests),
+		FailedRequests:       atomic.LoadInt64(&cp.stats.FailedRequests),
+		AverageWaitTime:      averageWait,
+		P99WaitTime:          p99Wait,
+		AverageUsageTime:     cp.stats.AverageUsageTime,
+		ConnectionsCreated:   atomic.LoadInt64(&cp.stats.ConnectionsCreated),
+		ConnectionsDestroyed: atomic.LoadInt64(&cp.stats.ConnectionsDestroyed),
+		LastScaleTime:        cp.stats.LastScaleTime,
+		LastUpdate:           cp.clock.Now(),
+	}
 }
 
 // PreWarm 预热连接
@@ -576,7 +773,9 @@ func (cp *ConnectionPool) PreWarm(ctx context.Context) error {
 			}
 
 			conn.isPreWarmed = true
-			cp.Put(conn)
+			cp.mu.Lock()
+			cp.releaseLocked(conn)
+			cp.mu.Unlock()
 		}()
 	}
 
@@ -589,7 +788,7 @@ func (cp *ConnectionPool) PreWarm(ctx context.Context) error {
 	return nil
 }
 
-// Resize 调整连接池大小
+// Resize 调整连接池大小，之后新建的连接不超过newSize，使用中的多余连接在归还时关闭
 func (cp *ConnectionPool) Resize(newSize int) error {
 	if newSize < cp.config.MinConnections {
 		newSize = cp.config.MinConnections
@@ -597,6 +796,7 @@ func (cp *ConnectionPool) Resize(newSize int) error {
 	if newSize > cp.config.MaxConnections {
 		newSize = cp.config.MaxConnections
 	}
+	atomic.StoreInt64(&cp.targetSize, int64(newSize))
 
 	currentSize := int(atomic.LoadInt64(&cp.totalCount))
 
@@ -636,16 +836,28 @@ func (cp *ConnectionPool) initializeConnections(ctx context.Context) error {
 	return nil
 }
 
-// 内部方法：创建连接
+// 内部方法：创建连接，先占用连接名额，保证并发创建时总连接数不超过目标大小
 func (cp *ConnectionPool) createConnection(ctx context.Context) (*Connection, error) {
-	conn, err := cp.factory.CreateConnection(cp.nodeID, cp.shardID, cp.address)
-	if err != nil {
-		return nil, err
+	if !cp.reserveConnection() {
+		return nil, errPoolExhausted
 	}
+	return cp.connectReserved(ctx)
+}
 
-	conn.pool = cp
-
-	if err := conn.Connect(ctx); err != nil {
+// 内部方法：用已占用的名额创建连接，失败时释放名额并交给等待的请求
+func (cp *ConnectionPool) connectReserved(ctx context.Context) (*Connection, error) {
+	conn, err := cp.factory.CreateConnection(cp.nodeID, cp.shardID, cp.address)
+	if err == nil {
+		conn.pool = cp
+		conn.useClock(cp.clock)
+		conn.SetPipelineConfig(cp.config)
+		err = conn.Connect(ctx)
+	}
+	if err != nil {
+		cp.mu.Lock()
+		atomic.AddInt64(&cp.totalCount, -1)
+		cp.signalCapacityLocked()
+		cp.mu.Unlock()
 		return nil, err
 	}
 
@@ -653,40 +865,157 @@ func (cp *ConnectionPool) createConnection(ctx context.Context) (*Connection, er
 	cp.connections[conn.id] = conn
 	cp.mu.Unlock()
 
-	atomic.AddInt64(&cp.totalCount, 1)
 	atomic.AddInt64(&cp.stats.ConnectionsCreated, 1)
 
 	return conn, nil
 }
 
-// 内部方法：获取空闲连接
-func (cp *ConnectionPool) getIdleConnection() *Connection {
-	cp.mu.Lock()
-	defer cp.mu.Unlock()
+// 内部方法：总连接数小于目标大小时占用一个名额
+func (cp *ConnectionPool) reserveConnection() bool {
+	for {
+		total := atomic.LoadInt64(&cp.totalCount)
+		if total >= atomic.LoadInt64(&cp.targetSize) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&cp.totalCount, total, total+1) {
+			return true
+		}
+	}
+}
 
-	if len(cp.idleConnections) == 0 {
-		return nil
+// 内部方法：获取空闲连接（调用方需持有写锁）
+func (cp *ConnectionPool) getIdleConnectionLocked() *Connection {
+	for i := 0; i < len(cp.idleConnections); {
+		// 从队列头取连接，跳过正在做健康检查的连接
+		conn := cp.idleConnections[i]
+		if conn.checking {
+			i++
+			continue
+		}
+		cp.idleConnections = append(cp.idleConnections[:i], cp.idleConnections[i+1:]...)
+
+		// 检查连接是否仍然健康，不健康时继续取下一个
+		if conn.IsHealthy() {
+			return conn
+		}
+		cp.removeConnection(conn)
 	}
 
-	// 从队列头取连接
-	conn := cp.idleConnections[0]
-	cp.idleConnections = cp.idleConnections[1:]
+	return nil
+}
 
-	// 检查连接是否仍然健康
+// 内部方法：把未在使用的连接交给等待的请求或放回空闲队列（调用方需持有写锁）
+func (cp *ConnectionPool) releaseLocked(conn *Connection) {
+	// 检查连接健康状态
 	if !conn.IsHealthy() {
 		cp.removeConnection(conn)
-		return cp.getIdleConnection() // 递归获取下一个
+		return
 	}
 
-	return conn
+	conn.MarkIdle()
+
+	// 缩容后超出目标大小的连接直接关闭，不再放回连接池
+	if atomic.LoadInt64(&cp.totalCount) > atomic.LoadInt64(&cp.targetSize) {
+		cp.removeConnection(conn)
+		return
+	}
+
+	// 优先交给队首的等待者
+	if len(cp.waiters) > 0 {
+		cp.popWaiterLocked().ch <- conn
+		return
+	}
+
+	// 添加到空闲连接队列，空闲连接已满时关闭
+	if cp.config.MaxIdleConnections > 0 && len(cp.idleConnections) >= cp.config.MaxIdleConnections {
+		cp.removeConnection(conn)
+		return
+	}
+	cp.idleConnections = append(cp.idleConnections, conn)
+}
+
+// 内部方法：关闭借出的连接而不放回连接池
+func (cp *ConnectionPool) discard(conn *Connection) {
+	if !atomic.CompareAndSwapInt32(&conn.released, 0, 1) {
+		return
+	}
+	atomic.AddInt64(&cp.activeCount, -1)
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.removeConnection(conn)
+}
+
+// isConnectionError 判断错误是否说明连接本身已不可用
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
 }
 
-// 内部方法：移除连接
+// 内部方法：放弃等待，仍在队列中时移出；已经交给该请求的连接或名额转交给下一个等待者，不会泄漏
+func (cp *ConnectionPool) abandonWait(waiter *connWaiter) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	for i, w := range cp.waiters {
+		if w == waiter {
+			cp.waiters = append(cp.waiters[:i], cp.waiters[i+1:]...)
+			return
+		}
+	}
+
+	// 不在队列中说明已被唤醒，ch中一定有值
+	if conn := <-waiter.ch; conn != nil {
+		cp.releaseLocked(conn)
+		return
+	}
+	atomic.AddInt64(&cp.totalCount, -1)
+	cp.signalCapacityLocked()
+}
+
+// 内部方法：取出队首的等待者（调用方需持有写锁）
+func (cp *ConnectionPool) popWaiterLocked() *connWaiter {
+	waiter := cp.waiters[0]
+	cp.waiters[0] = nil
+	cp.waiters = cp.waiters[1:]
+	return waiter
+}
+
+// 内部方法：连接数低于目标大小时为队首的等待者占用新建连接的名额（调用方需持有写锁）
+func (cp *ConnectionPool) signalCapacityLocked() {
+	for len(cp.waiters) > 0 && cp.reserveConnection() {
+		cp.popWaiterLocked().ch <- nil
+	}
+}
+
+// 内部方法：把空闲连接交给等待的请求（调用方需持有写锁）
+func (cp *ConnectionPool) serveWaitersLocked() {
+	for len(cp.waiters) > 0 {
+		conn := cp.getIdleConnectionLocked()
+		if conn == nil {
+			return
+		}
+		cp.popWaiterLocked().ch <- conn
+	}
+}
+
+// 内部方法：移除连接，已被移除的连接（如健康检查关闭后又被归还）只关闭不重复计数
 func (cp *ConnectionPool) removeConnection(conn *Connection) {
-	delete(cp.connections, conn.id)
 	conn.Close()
+	if _, exists := cp.connections[conn.id]; !exists {
+		return
+	}
+	delete(cp.connections, conn.id)
 	atomic.AddInt64(&cp.totalCount, -1)
 	atomic.AddInt64(&cp.stats.ConnectionsDestroyed, 1)
+	cp.signalCapacityLocked()
 }
 
 // 内部方法：扩容
@@ -696,13 +1025,20 @@ func (cp *ConnectionPool) scaleUp(count int) error {
 
 	for i := 0; i < count; i++ {
 		conn, err := cp.createConnection(ctx)
+		if errors.Is(err, errPoolExhausted) {
+			break
+		}
 		if err != nil {
 			return err
 		}
-		cp.Put(conn)
+		cp.mu.Lock()
+		cp.releaseLocked(conn)
+		cp.mu.Unlock()
 	}
 
-	cp.stats.LastScaleTime = time.Now()
+	cp.mu.Lock()
+	cp.stats.LastScaleTime = cp.clock.Now()
+	cp.mu.Unlock()
 	return nil
 }
 
@@ -719,13 +1055,13 @@ func (cp *ConnectionPool) scaleDown(count int) error {
 		removed++
 	}
 
-	cp.stats.LastScaleTime = time.Now()
+	cp.stats.LastScaleTime = cp.clock.Now()
 	return nil
 }
 
 // 内部方法：健康检查循环
 func (cp *ConnectionPool) healthCheckLoop(ctx context.Context) {
-	ticker := time.NewTicker(cp.config.HealthCheckInterval)
+	ticker := cp.clock.NewTicker(cp.config.HealthCheckInterval)
 	defer ticker.Stop()
 
 	for {
@@ -734,22 +1070,25 @@ func (cp *ConnectionPool) healthCheckLoop(ctx context.Context) {
 			return
 		case <-cp.stopChannel:
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 			cp.performHealthCheck(ctx)
 		}
 	}
 }
 
 // 内部方法：执行健康检查
+// 只探测空闲连接，探测期间连接留在空闲队列中但不会被取用，避免与请求并发读写；
+// 使用中的连接由请求本身发现故障，归还时按健康状态处理
 func (cp *ConnectionPool) performHealthCheck(ctx context.Context) {
-	cp.mu.RLock()
-	connections := make([]*Connection, 0, len(cp.connections))
-	for _, conn := range cp.connections {
-		connections = append(connections, conn)
+	cp.mu.Lock()
+	connections := make([]*Connection, len(cp.idleConnections))
+	copy(connections, cp.idleConnections)
+	for _, conn := range connections {
+		conn.checking = true
 	}
-	cp.mu.RUnlock()
+	cp.mu.Unlock()
 
-	// 并发检查所有连接
+	// 并发检查空闲连接
 	var wg sync.WaitGroup
 	for _, conn := range connections {
 		wg.Add(1)
@@ -759,23 +1098,42 @@ func (cp *ConnectionPool) performHealthCheck(ctx context.Context) {
 		}(conn)
 	}
 	wg.Wait()
+
+	// 移除检查失败的连接
+	cp.mu.Lock()
+	validIdle := cp.idleConnections[:0]
+	for _, conn := range cp.idleConnections {
+		conn.checking = false
+		if !conn.IsHealthy() {
+			cp.removeConnection(conn)
+			continue
+		}
+		validIdle = append(validIdle, conn)
+	}
+	cp.idleConnections = validIdle
+	// 检查期间到达的请求没能取用这些连接，检查结束后交给它们
+	cp.serveWaitersLocked()
+	cp.mu.Unlock()
+
+	// 移除失效连接后不足最小连接数时补充
+	if missing := cp.config.MinConnections - int(atomic.LoadInt64(&cp.totalCount)); missing > 0 {
+		cp.scaleUp(missing)
+	}
 }
 
-// 内部方法：检查单个连接健康状态
+// 内部方法：检查单个连接健康状态，超时为HealthCheckTimeout
 func (cp *ConnectionPool) checkConnectionHealth(ctx context.Context, conn *Connection) {
-	if !conn.IsHealthy() {
-		cp.mu.Lock()
-		cp.removeConnection(conn)
-		cp.mu.Unlock()
+	if cp.config.HealthCheckTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cp.config.HealthCheckTimeout)
+		defer cancel()
 	}
-
-	// TODO: 实现实际的健康检查逻辑
-	// 例如发送ping命令或检查连接状态
+	conn.HealthCheck(ctx)
 }
 
 // 内部方法：自动扩缩容循环
 func (cp *ConnectionPool) autoScaleLoop(ctx context.Context) {
-	ticker := time.NewTicker(cp.config.ScaleInterval)
+	ticker := cp.clock.NewTicker(cp.config.ScaleInterval)
 	defer ticker.Stop()
 
 	for {
@@ -784,7 +1142,7 @@ func (cp *ConnectionPool) autoScaleLoop(ctx context.Context) {
 			return
 		case <-cp.stopChannel:
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 			cp.performAutoScale()
 		}
 	}
@@ -818,7 +1176,7 @@ func (cp *ConnectionPool) performAutoScale() {
 
 // 内部方法：清理循环
 func (cp *ConnectionPool) cleanupLoop(ctx context.Context) {
-	ticker := time.NewTicker(1 * time.Minute) // 每分钟清理一次
+	ticker := cp.clock.NewTicker(1 * time.Minute) // 每分钟清理一次
 	defer ticker.Stop()
 
 	for {
@@ -827,7 +1185,7 @@ func (cp *ConnectionPool) cleanupLoop(ctx context.Context) {
 			return
 		case <-cp.stopChannel:
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 			cp.performCleanup()
 		}
 	}
@@ -838,7 +1196,7 @@ func (cp *ConnectionPool) performCleanup() {
 	cp.mu.Lock()
 	defer cp.mu.Unlock()
 
-	now := time.Now()
+	now := cp.clock.Now()
 
 	// 清理过期的空闲连接
 	validIdle := make([]*Connection, 0, len(cp.idleConnections))
@@ -858,27 +1216,56 @@ func (cp *ConnectionPool) performCleanup() {
 	cp.idleConnections = validIdle
 }
 
-// 内部方法：更新平均等待时间
-func (cp *ConnectionPool) updateAverageWaitTime(waitTime time.Duration) {
-	if cp.stats.AverageWaitTime == 0 {
-		cp.stats.AverageWaitTime = waitTime
-	} else {
-		// 指数移动平均
-		cp.stats.AverageWaitTime = time.Duration(float64(cp.stats.AverageWaitTime)*0.9 + float64(waitTime)*0.1)
+// NodeAddressResolver 把节点ID解析为连接地址
+type NodeAddressResolver interface {
+	Resolve(nodeID NodeID) (string, error)
+}
+
+// StaticNodeResolver 由固定映射表解析节点地址
+type StaticNodeResolver map[NodeID]string
+
+// Resolve 查找节点地址
+func (r StaticNodeResolver) Resolve(nodeID NodeID) (string, error) {
+	address, ok := r[nodeID]
+	if !ok || address == "" {
+		return "", fmt.Errorf("节点 %s 没有配置地址", nodeID)
 	}
+	return address, nil
+}
+
+// shardPoolKey 分片内单个节点的连接池键
+type shardPoolKey struct {
+	shardID string
+	nodeID  NodeID
+}
+
+// String 统计信息中使用的键，格式为 分片ID/节点ID
+func (k shardPoolKey) String() string {
+	return k.shardID + "/" + string(k.nodeID)
 }
 
-// ShardAwareConnectionPool 分片感知连接池
+// ShardAwareConnectionPool 分片感知连接池，为每个分片的每个节点维护独立的连接池
 type ShardAwareConnectionPool struct {
 	mu            sync.RWMutex
 	config        *PoolConfig
-	shardPools    map[string]*ConnectionPool // 分片ID -> 连接池
-	globalPool    *ConnectionPool            // 全局连接池
-	nodeHealthMap map[NodeID]*NodeHealth     // 节点健康状态
-	stats         *ShardPoolStats            // 统计信息
-	stopChannel   chan struct{}              // 停止信号
-	isRunning     int64                      // 运行状态
-	factory       ConnectionFactory          // 连接工厂
+	shardPools    map[shardPoolKey]*ConnectionPool // (分片ID, 节点ID) -> 连接池
+	globalPool    *ConnectionPool                  // 全局连接池
+	nodeHealthMap map[NodeID]*NodeHealth           // 节点健康状态
+	stats         *ShardPoolStats                  // 统计信息
+	stopChannel   chan struct{}                    // 停止信号
+	isRunning     int64                            // 运行状态
+	factory       ConnectionFactory                // 连接工厂
+	resolver      NodeAddressResolver              // 节点地址解析器
+	replicaLB     *RoundRobinLoadBalancer          // 副本节点轮询
+
+	// 后台预热：warming中的连接池正在等待或执行预热，值为true表示预热期间已被移除，预热结束后关闭
+	warming       map[*ConnectionPool]bool
+	warmSemaphore chan struct{}
+	warmCtx       context.Context
+	warmCancel    context.CancelFunc
+	warmTotal     int                       // 已安排预热的连接池数
+	warmCompleted int                       // 已结束预热的连接池数
+	onWarmUp      func(ShardWarmUpProgress) // 每个连接池预热结束时调用
 }
 
 // ShardPoolStats 分片连接池统计信息
@@ -887,13 +1274,13 @@ type ShardPoolStats struct {
 	TotalConnections  int64                  `json:"totalConnections"`  // 总连接数
 	ActiveConnections int64                  `json:"activeConnections"` // 活跃连接数
 	IdleConnections   int64                  `json:"idleConnections"`   // 空闲连接数
-	ShardStats        map[string]*PoolStats  `json:"shardStats"`        // 分片统计
+	ShardStats        map[string]*PoolStats  `json:"shardStats"`        // 各节点连接池统计，键为 分片ID/节点ID
 	NodeStats         map[NodeID]*NodeHealth `json:"nodeStats"`         // 节点统计
 	LastUpdate        time.Time              `json:"lastUpdate"`        // 最后更新时间
 }
 
-// NewShardAwareConnectionPool 创建分片感知连接池
-func NewShardAwareConnectionPool(config *PoolConfig, factory ConnectionFactory) *ShardAwareConnectionPool {
+// NewShardAwareConnectionPool 创建分片感知连接池，resolver用于把路由选中的节点解析为连接地址
+func NewShardAwareConnectionPool(config *PoolConfig, factory ConnectionFactory, resolver NodeAddressResolver) *ShardAwareConnectionPool {
 	if config == nil {
 		config = DefaultPoolConfig()
 	}
@@ -902,12 +1289,24 @@ func NewShardAwareConnectionPool(config *PoolConfig, factory ConnectionFactory)
 		factory = NewDefaultConnectionFactory(config.ConnectionTimeout)
 	}
 
+	warmUpConcurrency := config.ShardWarmUpConcurrency
+	if warmUpConcurrency <= 0 {
+		warmUpConcurrency = 1
+	}
+	warmCtx, warmCancel := context.WithCancel(context.Background())
+
 	return &ShardAwareConnectionPool{
 		config:        config,
-		shardPools:    make(map[string]*ConnectionPool),
+		shardPools:    make(map[shardPoolKey]*ConnectionPool),
 		nodeHealthMap: make(map[NodeID]*NodeHealth),
 		factory:       factory,
+		resolver:      resolver,
+		replicaLB:     NewRoundRobinLoadBalancer(),
 		stopChannel:   make(chan struct{}),
+		warming:       make(map[*ConnectionPool]bool),
+		warmSemaphore: make(chan struct{}, warmUpConcurrency),
+		warmCtx:       warmCtx,
+		warmCancel:    warmCancel,
 		stats: &ShardPoolStats{
 			ShardStats: make(map[string]*PoolStats),
 			NodeStats:  make(map[NodeID]*NodeHealth),
@@ -939,13 +1338,14 @@ func (sacp *ShardAwareConnectionPool) Stop() error {
 	}
 
 	close(sacp.stopChannel)
+	sacp.warmCancel()
 
 	sacp.mu.Lock()
 	defer sacp.mu.Unlock()
 
 	// 停止所有分片连接池
-	for _, pool := range sacp.shardPools {
-		pool.Stop()
+	for key, pool := range sacp.shardPools {
+		sacp.retirePoolLocked(key, pool)
 	}
 
 	// 停止全局连接池
@@ -959,23 +1359,17 @@ func (sacp *ShardAwareConnectionPool) Stop() error {
 // GetConnection 根据分片信息获取连接
 func (sacp *ShardAwareConnectionPool) GetConnection(ctx context.Context, shardInfo *ShardInfo, strategy RoutingStrategy) (*Connection, error) {
 	// 根据策略选择目标节点
-	var targetNode NodeID
-	switch strategy {
-	case RoutingWritePrimary:
-		targetNode = shardInfo.Primary
-	case RoutingReadReplica:
-		if len(shardInfo.Replicas) > 0 {
-			// 简单选择第一个副本
-			targetNode = shardInfo.Replicas[0]
-		} else {
-			targetNode = shardInfo.Primary
-		}
-	default:
-		targetNode = shardInfo.Primary
+	targetNode := shardInfo.Primary
+	if strategy == RoutingReadReplica && len(shardInfo.Replicas) > 0 {
+		// 在副本之间轮询
+		targetNode, _ = sacp.replicaLB.Select(shardInfo.Replicas, shardInfo.ID)
 	}
 
-	// 获取分片连接池
-	pool := sacp.getOrCreateShardPool(shardInfo.ID, targetNode, "")
+	// 获取目标节点的连接池
+	pool, err := sacp.getOrCreateShardPool(shardInfo.ID, targetNode)
+	if err != nil {
+		return nil, err
+	}
 
 	return pool.Get(ctx)
 }
@@ -989,13 +1383,27 @@ func (sacp *ShardAwareConnectionPool) PutConnection(conn *Connection) {
 	conn.pool.Put(conn)
 }
 
+// DoOnShard 按策略借出分片的连接执行fn，语义同ConnectionPool.Do
+func (sacp *ShardAwareConnectionPool) DoOnShard(ctx context.Context, shardInfo *ShardInfo, strategy RoutingStrategy, fn func(conn *Connection) error) error {
+	conn, err := sacp.GetConnection(ctx, shardInfo, strategy)
+	if err != nil {
+		return err
+	}
+	return conn.pool.doWith(conn, fn)
+}
+
 // GetStats 获取统计信息
 func (sacp *ShardAwareConnectionPool) GetStats() *ShardPoolStats {
 	sacp.mu.RLock()
 	defer sacp.mu.RUnlock()
 
+	shards := make(map[string]struct{})
+	for key := range sacp.shardPools {
+		shards[key.shardID] = struct{}{}
+	}
+
 	stats := &ShardPoolStats{
-		TotalShards: len(sacp.shardPools),
+		TotalShards: len(shards),
 		ShardStats:  make(map[string]*PoolStats),
 		NodeStats:   make(map[NodeID]*NodeHealth),
 		LastUpdate:  time.Now(),
@@ -1004,9 +1412,9 @@ func (sacp *ShardAwareConnectionPool) GetStats() *ShardPoolStats {
 	var totalConnections, activeConnections, idleConnections int64
 
 	// 收集分片统计
-	for shardID, pool := range sacp.shardPools {
+	for key, pool := range sacp.shardPools {
 		poolStats := pool.GetStats()
-		stats.ShardStats[shardID] = poolStats
+		stats.ShardStats[key.String()] = poolStats
 
 		totalConnections += poolStats.TotalConnections
 		activeConnections += poolStats.ActiveConnections
@@ -1034,65 +1442,101 @@ func (sacp *ShardAwareConnectionPool) GetStats() *ShardPoolStats {
 	return stats
 }
 
-// AddShard 添加分片
+// AddShard 添加分片，为主节点和各副本节点创建连接池
 func (sacp *ShardAwareConnectionPool) AddShard(shardInfo *ShardInfo) error {
 	sacp.mu.Lock()
 	defer sacp.mu.Unlock()
 
-	if _, exists := sacp.shardPools[shardInfo.ID]; exists {
-		return fmt.Errorf("分片 %s 已存在", shardInfo.ID)
+	nodes := append([]NodeID{shardInfo.Primary}, shardInfo.Replicas...)
+	for _, nodeID := range nodes {
+		if _, exists := sacp.shardPools[shardPoolKey{shardInfo.ID, nodeID}]; exists {
+			return fmt.Errorf("分片 %s 已存在", shardInfo.ID)
+		}
 	}
 
-	// 为主节点创建连接池
-	primaryPool := NewConnectionPool(sacp.config, shardInfo.Primary, shardInfo.ID, "", sacp.factory)
-	sacp.shardPools[shardInfo.ID] = primaryPool
+	created := make([]*ConnectionPool, 0, len(nodes))
+	for _, nodeID := range nodes {
+		pool, err := sacp.createShardPoolLocked(shardInfo.ID, nodeID)
+		if err == nil {
+			created = append(created, pool)
+			if err = pool.Start(context.Background()); err != nil {
+				err = fmt.Errorf("启动节点 %s 的连接池失败: %w", nodeID, err)
+			}
+		}
+		if err != nil {
+			// 回滚已创建的连接池，避免分片处于部分添加的状态
+			for i, p := range created {
+				p.Stop()
+				delete(sacp.shardPools, shardPoolKey{shardInfo.ID, nodes[i]})
+			}
+			return err
+		}
+	}
 
-	// 启动连接池
-	ctx := context.Background()
-	return primaryPool.Start(ctx)
+	return nil
 }
 
-// RemoveShard 移除分片
+// RemoveShard 移除分片，关闭该分片所有节点的连接池
 func (sacp *ShardAwareConnectionPool) RemoveShard(shardID string) error {
 	sacp.mu.Lock()
 	defer sacp.mu.Unlock()
 
-	pool, exists := sacp.shardPools[shardID]
-	if !exists {
+	removed := 0
+	for key, pool := range sacp.shardPools {
+		if key.shardID == shardID {
+			sacp.retirePoolLocked(key, pool)
+			removed++
+		}
+	}
+	if removed == 0 {
 		return fmt.Errorf("分片 %s 不存在", shardID)
 	}
 
-	pool.Stop()
-	delete(sacp.shardPools, shardID)
-
 	return nil
 }
 
-// 内部方法：获取或创建分片连接池
-func (sacp *ShardAwareConnectionPool) getOrCreateShardPool(shardID string, nodeID NodeID, address string) *ConnectionPool {
+// 内部方法：获取或创建分片内节点的连接池
+func (sacp *ShardAwareConnectionPool) getOrCreateShardPool(shardID string, nodeID NodeID) (*ConnectionPool, error) {
+	key := shardPoolKey{shardID, nodeID}
+
 	sacp.mu.RLock()
-	pool, exists := sacp.shardPools[shardID]
+	pool, exists := sacp.shardPools[key]
 	sacp.mu.RUnlock()
 
 	if exists {
-		return pool
+		return pool, nil
 	}
 
 	sacp.mu.Lock()
 	defer sacp.mu.Unlock()
 
 	// 双重检查
-	if pool, exists := sacp.shardPools[shardID]; exists {
-		return pool
+	if pool, exists := sacp.shardPools[key]; exists {
+		return pool, nil
+	}
+
+	pool, err := sacp.createShardPoolLocked(shardID, nodeID)
+	if err != nil {
+		return nil, err
 	}
 
-	// 创建新的连接池
-	pool = NewConnectionPool(sacp.config, nodeID, shardID, address, sacp.factory)
-	sacp.shardPools[shardID] = pool
+	// 启动连接池，初始连接失败时由后续请求按需建立
+	pool.Start(context.Background())
+
+	return pool, nil
+}
 
-	// 启动连接池
-	ctx := context.Background()
-	pool.Start(ctx)
+// 内部方法：解析节点地址并创建连接池（调用方需持有写锁）
+func (sacp *ShardAwareConnectionPool) createShardPoolLocked(shardID string, nodeID NodeID) (*ConnectionPool, error) {
+	if sacp.resolver == nil {
+		return nil, fmt.Errorf("解析节点 %s 地址失败: 未配置节点地址解析器", nodeID)
+	}
+	address, err := sacp.resolver.Resolve(nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("解析节点 %s 地址失败: %w", nodeID, err)
+	}
 
-	return pool
+	pool := NewConnectionPool(sacp.config, nodeID, shardID, address, sacp.factory)
+	sacp.shardPools[shardPoolKey{shardID, nodeID}] = pool
+	return pool, nil
 }
@@ -0,0 +1,844 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-2 22:24:02
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-2 22:24:05
+* @Description: ConcordKV intelligent client - enhanced connection pool tests
+ */
+
+package concord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/concordkv/client/go/testutil"
+)
+
+// observingFactory 创建连接时记录连接池的最大总连接数，新连接在创建前已计入总数
+type observingFactory struct {
+	*DefaultConnectionFactory
+	pool    *ConnectionPool
+	maxSeen int64
+}
+
+func (f *observingFactory) CreateConnection(nodeID NodeID, shardID string, address string) (*Connection, error) {
+	if total := atomic.LoadInt64(&f.pool.totalCount); total > atomic.LoadInt64(&f.maxSeen) {
+		atomic.StoreInt64(&f.maxSeen, total)
+	}
+	return f.DefaultConnectionFactory.CreateConnection(nodeID, shardID, address)
+}
+
+// newTestPool 创建连接到本地监听端口的连接池
+func newTestPool(t *testing.T, config *PoolConfig, factory *observingFactory) *ConnectionPool {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	var pool *ConnectionPool
+	if factory != nil {
+		pool = NewConnectionPool(config, "node1", "shard-0", listener.Addr().String(), factory)
+		factory.pool = pool
+	} else {
+		pool = NewConnectionPool(config, "node1", "shard-0", listener.Addr().String(), nil)
+	}
+	if err := pool.Start(context.Background()); err != nil {
+		t.Fatalf("启动连接池失败: %v", err)
+	}
+	t.Cleanup(func() { pool.Stop() })
+	return pool
+}
+
+// TestConnectionPoolResizeUnderLoad 并发取用连接并反复缩放时，总连接数始终不超过MaxConnections
+func TestConnectionPoolResizeUnderLoad(t *testing.T) {
+	config := DefaultPoolConfig()
+	config.MinConnections = 1
+	config.MaxConnections = 8
+	config.MaxIdleConnections = 2
+	config.InitialSize = 2
+	config.EnablePreWarm = false
+	config.EnableAutoScale = false
+	config.HealthCheckInterval = 0
+	factory := &observingFactory{DefaultConnectionFactory: NewDefaultConnectionFactory(time.Second)}
+	pool := newTestPool(t, config, factory)
+
+	var maxSeen int64
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	// 持续采样总连接数
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if total := pool.GetStats().TotalConnections; total > atomic.LoadInt64(&maxSeen) {
+				atomic.StoreInt64(&maxSeen, total)
+			}
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// 在最小与超过上限的大小之间来回缩放，迫使连接反复关闭和新建
+			pool.Resize(1)
+			time.Sleep(time.Millisecond)
+			pool.Resize(config.MaxConnections + 4)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	var workers sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for j := 0; j < 100; j++ {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+				conn, err := pool.Get(ctx)
+				cancel()
+				if err != nil {
+					continue
+				}
+				if rand.Intn(4) == 0 {
+					time.Sleep(100 * time.Microsecond)
+				}
+				pool.Put(conn)
+			}
+		}()
+	}
+	workers.Wait()
+	close(stop)
+	wg.Wait()
+
+	if seen := atomic.LoadInt64(&factory.maxSeen); seen > atomic.LoadInt64(&maxSeen) {
+		maxSeen = seen
+	}
+	if seen := atomic.LoadInt64(&maxSeen); seen > int64(config.MaxConnections) {
+		t.Fatalf("总连接数达到 %d，超过上限 %d", seen, config.MaxConnections)
+	}
+
+	// 所有连接归还后，缩容到2个连接，并且空闲连接不超过MaxIdleConnections
+	pool.Resize(2)
+	stats := pool.GetStats()
+	if stats.TotalConnections > 2 || stats.IdleConnections > int64(config.MaxIdleConnections) {
+		t.Errorf("缩容后应最多2个连接，实际总数 %d 空闲 %d", stats.TotalConnections, stats.IdleConnections)
+	}
+	if stats.ActiveConnections < 0 {
+		t.Errorf("活跃连接数不应为负数: %d", stats.ActiveConnections)
+	}
+}
+
+// TestConnectionPoolMaxIdle 归还时空闲连接已满则关闭连接
+func TestConnectionPoolMaxIdle(t *testing.T) {
+	config := DefaultPoolConfig()
+	config.MinConnections = 0
+	config.MaxConnections = 10
+	config.MaxIdleConnections = 3
+	config.InitialSize = 0
+	config.EnablePreWarm = false
+	config.EnableAutoScale = false
+	config.HealthCheckInterval = 0
+	pool := newTestPool(t, config, nil)
+
+	conns := make([]*Connection, 0, 8)
+	for i := 0; i < 8; i++ {
+		conn, err := pool.Get(context.Background())
+		if err != nil {
+			t.Fatalf("获取连接失败: %v", err)
+		}
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		pool.Put(conn)
+	}
+
+	stats := pool.GetStats()
+	if stats.IdleConnections != 3 || stats.TotalConnections != 3 || stats.ConnectionsDestroyed != 5 {
+		t.Errorf("期望保留3个空闲连接并关闭5个，实际空闲 %d 总数 %d 关闭 %d",
+			stats.IdleConnections, stats.TotalConnections, stats.ConnectionsDestroyed)
+	}
+	if stats.ActiveConnections != 0 {
+		t.Errorf("活跃连接数应为0，实际 %d", stats.ActiveConnections)
+	}
+}
+
+// waitForPool 等待连接池统计满足条件
+func waitForPool(t *testing.T, pool *ConnectionPool, cond func(stats *PoolStats) bool) *PoolStats {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		stats := pool.GetStats()
+		if cond(stats) {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待连接池状态超时: %+v", stats)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestConnectionPoolHealthCheckDropped 对端关闭的空闲连接在健康检查中被移除，并补充到最小连接数
+func TestConnectionPoolHealthCheckDropped(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	config := DefaultPoolConfig()
+	config.MinConnections = 2
+	config.InitialSize = 2
+	config.EnablePreWarm = false
+	config.EnableAutoScale = false
+	config.HealthCheckInterval = 20 * time.Millisecond
+	config.HealthCheckTimeout = time.Second
+	pool := NewConnectionPool(config, "node1", "shard-0", listener.Addr().String(), nil)
+	if err := pool.Start(context.Background()); err != nil {
+		t.Fatalf("启动连接池失败: %v", err)
+	}
+	defer pool.Stop()
+
+	// 服务端断开初始的两个连接
+	for i := 0; i < 2; i++ {
+		(<-accepted).Close()
+	}
+
+	stats := waitForPool(t, pool, func(stats *PoolStats) bool {
+		return stats.ConnectionsDestroyed >= 2 && stats.TotalConnections >= 2 && stats.ConnectionsCreated >= 4
+	})
+	if stats.TotalConnections != 2 {
+		t.Errorf("补充后应有2个连接，实际 %d", stats.TotalConnections)
+	}
+
+	// 补充的连接仍然存活，后续检查不再移除
+	time.Sleep(3 * config.HealthCheckInterval)
+	if stats := pool.GetStats(); stats.ConnectionsDestroyed != 2 {
+		t.Errorf("存活的连接不应被移除，实际关闭 %d 个", stats.ConnectionsDestroyed)
+	}
+	for i := 0; i < 2; i++ {
+		(<-accepted).Close()
+	}
+}
+
+// fakeConnectionFactory 不建立网络连接的工厂，健康检查结果由测试控制
+type fakeConnectionFactory struct {
+	mu    sync.Mutex
+	next  int
+	dead  map[string]bool
+	conns []*Connection
+}
+
+func (f *fakeConnectionFactory) CreateConnection(nodeID NodeID, shardID string, address string) (*Connection, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.next++
+	conn := NewConnection(fmt.Sprintf("fake-%d", f.next), nodeID, shardID, address, time.Second)
+	conn.state = ConnStateActive // 视为已连接，Connect直接返回
+	conn.SetHealthCheck(func(ctx context.Context) error {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.dead[conn.id] {
+			return errors.New("模拟连接已断开")
+		}
+		return nil
+	})
+	f.conns = append(f.conns, conn)
+	return conn, nil
+}
+
+// kill 把已创建的连接标记为断开
+func (f *fakeConnectionFactory) kill() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		f.dead[conn.id] = true
+	}
+}
+
+// TestConnectionPoolHealthCheckCustom 连接工厂提供的健康检查替代默认的读取探测
+func TestConnectionPoolHealthCheckCustom(t *testing.T) {
+	factory := &fakeConnectionFactory{dead: make(map[string]bool)}
+	clk := testutil.NewFakeClock(time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC))
+
+	config := DefaultPoolConfig()
+	config.MinConnections = 3
+	config.InitialSize = 3
+	config.EnablePreWarm = false
+	config.EnableAutoScale = false
+	config.HealthCheckInterval = 20 * time.Millisecond
+	config.Clock = clk
+	pool := NewConnectionPool(config, "node1", "shard-0", "fake:0", factory)
+	if err := pool.Start(context.Background()); err != nil {
+		t.Fatalf("启动连接池失败: %v", err)
+	}
+	defer pool.Stop()
+
+	// 等待健康检查与清理循环创建定时器；Advance在健康检查循环取走触发后返回，下一次Advance返回时上一次检查已经结束
+	clk.BlockUntil(2)
+	for i := 0; i < 3; i++ {
+		clk.Advance(config.HealthCheckInterval)
+	}
+	if stats := pool.GetStats(); stats.ConnectionsDestroyed != 0 || stats.IdleConnections != 3 {
+		t.Fatalf("健康的连接不应被移除: %+v", stats)
+	}
+
+	factory.kill()
+	clk.Advance(config.HealthCheckInterval)
+	clk.Advance(config.HealthCheckInterval)
+	stats := pool.GetStats()
+	if stats.ConnectionsDestroyed != 3 || stats.TotalConnections != 3 {
+		t.Fatalf("失效的连接应被移除并补充到最小连接数: %+v", stats)
+	}
+	if stats.ConnectionsCreated != 6 {
+		t.Errorf("应新建3个连接替换失效连接，实际共创建 %d 个", stats.ConnectionsCreated)
+	}
+}
+
+// TestConnectionPoolIdleTimeout 每分钟的清理关闭空闲超过IdleTimeout的连接，被使用过的连接从最后使用时间重新计算
+func TestConnectionPoolIdleTimeout(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC))
+
+	config := DefaultPoolConfig()
+	config.MinConnections = 0
+	config.InitialSize = 2
+	config.EnablePreWarm = false
+	config.EnableAutoScale = false
+	config.HealthCheckInterval = 0
+	config.IdleTimeout = 5 * time.Minute
+	config.MaxLifetime = 0
+	config.Clock = clk
+	pool := NewConnectionPool(config, "node1", "shard-0", "fake:0", &fakeConnectionFactory{dead: make(map[string]bool)})
+	if err := pool.Start(context.Background()); err != nil {
+		t.Fatalf("启动连接池失败: %v", err)
+	}
+	defer pool.Stop()
+	clk.BlockUntil(1)
+
+	clk.Advance(3 * time.Minute)
+	conn, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("获取连接失败: %v", err)
+	}
+	pool.Put(conn)
+
+	// 第6分钟的清理关闭未使用的连接，多推进一分钟使该次清理结束
+	clk.Advance(4 * time.Minute)
+	if stats := pool.GetStats(); stats.TotalConnections != 1 || stats.ConnectionsDestroyed != 1 {
+		t.Fatalf("空闲超时的连接应被关闭，使用过的连接应保留: %+v", stats)
+	}
+
+	// 使用过的连接在第3分钟后空闲，第9分钟的清理关闭它
+	clk.Advance(3 * time.Minute)
+	if stats := pool.GetStats(); stats.TotalConnections != 0 || stats.ConnectionsDestroyed != 2 {
+		t.Fatalf("使用过的连接空闲超时后应被关闭: %+v", stats)
+	}
+}
+
+// newFakePool 创建使用fakeConnectionFactory、不做后台维护的连接池
+func newFakePool(t *testing.T) *ConnectionPool {
+	t.Helper()
+
+	config := DefaultPoolConfig()
+	config.MinConnections = 0
+	config.InitialSize = 1
+	config.EnablePreWarm = false
+	config.EnableAutoScale = false
+	config.HealthCheckInterval = 0
+	pool := NewConnectionPool(config, "node1", "shard-0", "fake:0", &fakeConnectionFactory{dead: make(map[string]bool)})
+	if err := pool.Start(context.Background()); err != nil {
+		t.Fatalf("启动连接池失败: %v", err)
+	}
+	t.Cleanup(func() { pool.Stop() })
+	return pool
+}
+
+// TestConnectionPoolDo 回调结束后归还连接，连接级错误时关闭连接
+func TestConnectionPoolDo(t *testing.T) {
+	pool := newFakePool(t)
+
+	var used *Connection
+	if err := pool.Do(context.Background(), func(conn *Connection) error {
+		used = conn
+		return nil
+	}); err != nil {
+		t.Fatalf("执行失败: %v", err)
+	}
+	if stats := pool.GetStats(); stats.ActiveConnections != 0 || stats.IdleConnections != 1 {
+		t.Fatalf("回调结束后连接应放回连接池: %+v", stats)
+	}
+
+	// 业务错误不影响连接
+	businessErr := errors.New("键不存在")
+	if err := pool.Do(context.Background(), func(*Connection) error { return businessErr }); err != businessErr {
+		t.Fatalf("应原样返回回调的错误，实际 %v", err)
+	}
+	if stats := pool.GetStats(); stats.IdleConnections != 1 || stats.ConnectionsDestroyed != 0 {
+		t.Fatalf("业务错误后连接应放回连接池: %+v", stats)
+	}
+
+	// 连接级错误关闭连接
+	if err := pool.Do(context.Background(), func(*Connection) error {
+		return fmt.Errorf("读取响应失败: %w", io.EOF)
+	}); !errors.Is(err, io.EOF) {
+		t.Fatalf("应返回io.EOF，实际 %v", err)
+	}
+	stats := pool.GetStats()
+	if stats.ActiveConnections != 0 || stats.IdleConnections != 0 || stats.TotalConnections != 0 || stats.ConnectionsDestroyed != 1 {
+		t.Fatalf("连接级错误后连接应被关闭: %+v", stats)
+	}
+	if used.IsHealthy() {
+		t.Errorf("被关闭的连接不应健康")
+	}
+}
+
+// TestConnectionPoolDoPanic 回调panic时关闭连接并继续传递panic，连接池不泄漏
+func TestConnectionPoolDoPanic(t *testing.T) {
+	pool := newFakePool(t)
+
+	func() {
+		defer func() {
+			if r := recover(); r != "回调出错" {
+				t.Fatalf("panic应继续向上传递，实际 %v", r)
+			}
+		}()
+		pool.Do(context.Background(), func(*Connection) error {
+			panic("回调出错")
+		})
+	}()
+
+	stats := pool.GetStats()
+	if stats.ActiveConnections != 0 || stats.TotalConnections != 0 || stats.ConnectionsDestroyed != 1 {
+		t.Fatalf("panic后连接应被关闭且不计入活跃连接: %+v", stats)
+	}
+
+	// 连接池仍可正常使用
+	if err := pool.Do(context.Background(), func(*Connection) error { return nil }); err != nil {
+		t.Fatalf("panic后执行失败: %v", err)
+	}
+}
+
+// TestConnectionRelease 重复归还只生效一次
+func TestConnectionRelease(t *testing.T) {
+	pool := newFakePool(t)
+
+	conn, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("获取连接失败: %v", err)
+	}
+	conn.Release()
+	conn.Release()
+	pool.Put(conn)
+
+	stats := pool.GetStats()
+	if stats.ActiveConnections != 0 || stats.IdleConnections != 1 {
+		t.Fatalf("重复归还后应只有1个空闲连接: %+v", stats)
+	}
+
+	// 再次借出后可以再次归还
+	again, err := pool.Get(context.Background())
+	if err != nil || again != conn {
+		t.Fatalf("应再次借出同一连接，实际 %v %v", again, err)
+	}
+	again.Release()
+	if stats := pool.GetStats(); stats.ActiveConnections != 0 || stats.IdleConnections != 1 {
+		t.Fatalf("再次归还后应有1个空闲连接: %+v", stats)
+	}
+}
+
+// TestShardAwareDoOnShard 按策略借出分片主节点的连接并归还
+func TestShardAwareDoOnShard(t *testing.T) {
+	config := DefaultPoolConfig()
+	config.InitialSize = 0
+	config.MinConnections = 0
+	config.EnablePreWarm = false
+	config.EnableAutoScale = false
+	config.HealthCheckInterval = 0
+	resolver := StaticNodeResolver{"node1": "10.0.0.1:9000", "node2": "10.0.0.2:9000"}
+	sacp := NewShardAwareConnectionPool(config, &fakeConnectionFactory{dead: make(map[string]bool)}, resolver)
+	defer sacp.Stop()
+
+	shard := testShard("node1", 1)
+	shard.Replicas = []NodeID{"node2"}
+	if err := sacp.DoOnShard(context.Background(), shard, RoutingWritePrimary, func(conn *Connection) error {
+		if conn.nodeID != "node1" {
+			t.Errorf("写请求应使用主节点连接，实际 %s", conn.nodeID)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("执行失败: %v", err)
+	}
+
+	stats := sacp.GetStats().ShardStats["shard-0/node1"]
+	if stats == nil || stats.ActiveConnections != 0 || stats.IdleConnections != 1 {
+		t.Fatalf("执行结束后连接应放回分片连接池: %+v", stats)
+	}
+}
+
+// newResolvedShardPool 创建使用静态地址表、不做后台维护的分片感知连接池
+func newResolvedShardPool(t *testing.T, resolver NodeAddressResolver) *ShardAwareConnectionPool {
+	t.Helper()
+	config := DefaultPoolConfig()
+	config.InitialSize = 0
+	config.MinConnections = 0
+	config.EnablePreWarm = false
+	config.EnableAutoScale = false
+	config.HealthCheckInterval = 0
+	sacp := NewShardAwareConnectionPool(config, &fakeConnectionFactory{dead: make(map[string]bool)}, resolver)
+	t.Cleanup(func() { sacp.Stop() })
+	return sacp
+}
+
+// TestShardAwareResolvesNodeAddress 每个节点的连接携带解析出的地址，副本之间轮询
+func TestShardAwareResolvesNodeAddress(t *testing.T) {
+	resolver := StaticNodeResolver{
+		"node1": "10.0.0.1:9000",
+		"node2": "10.0.0.2:9000",
+		"node3": "10.0.0.3:9000",
+	}
+	sacp := newResolvedShardPool(t, resolver)
+
+	shard := testShard("node1", 1)
+	shard.Replicas = []NodeID{"node2", "node3"}
+
+	conn, err := sacp.GetConnection(context.Background(), shard, RoutingWritePrimary)
+	if err != nil {
+		t.Fatalf("获取主节点连接失败: %v", err)
+	}
+	if conn.nodeID != "node1" || conn.address != "10.0.0.1:9000" {
+		t.Fatalf("主节点连接地址错误: %s %s", conn.nodeID, conn.address)
+	}
+	conn.Release()
+
+	seen := make(map[NodeID]int)
+	for i := 0; i < 4; i++ {
+		conn, err := sacp.GetConnection(context.Background(), shard, RoutingReadReplica)
+		if err != nil {
+			t.Fatalf("获取副本连接失败: %v", err)
+		}
+		if conn.address != resolver[conn.nodeID] {
+			t.Fatalf("副本 %s 的连接地址应为 %s，实际 %s", conn.nodeID, resolver[conn.nodeID], conn.address)
+		}
+		seen[conn.nodeID]++
+		conn.Release()
+	}
+	if seen["node2"] != 2 || seen["node3"] != 2 {
+		t.Fatalf("读请求应在副本之间轮询: %v", seen)
+	}
+
+	stats := sacp.GetStats()
+	if stats.TotalShards != 1 || len(stats.ShardStats) != 3 {
+		t.Fatalf("应有1个分片、3个节点连接池: %+v", stats)
+	}
+	for _, key := range []string{"shard-0/node1", "shard-0/node2", "shard-0/node3"} {
+		if stats.ShardStats[key] == nil || stats.ShardStats[key].Address != resolver[NodeID(key[len("shard-0/"):])] {
+			t.Fatalf("节点连接池 %s 地址错误: %+v", key, stats.ShardStats[key])
+		}
+	}
+}
+
+// TestShardAwareUnresolvedNode 无法解析地址的节点返回错误而不是用空地址建连
+func TestShardAwareUnresolvedNode(t *testing.T) {
+	sacp := newResolvedShardPool(t, StaticNodeResolver{"node1": "10.0.0.1:9000"})
+
+	shard := testShard("node1", 1)
+	shard.Replicas = []NodeID{"node2"}
+	if _, err := sacp.GetConnection(context.Background(), shard, RoutingReadReplica); err == nil {
+		t.Fatal("未配置地址的副本应返回错误")
+	}
+	if err := sacp.AddShard(shard); err == nil {
+		t.Fatal("分片包含未配置地址的节点时AddShard应失败")
+	}
+	if stats := sacp.GetStats(); len(stats.ShardStats) != 0 {
+		t.Fatalf("AddShard失败后不应残留连接池: %+v", stats.ShardStats)
+	}
+
+	sacp = newResolvedShardPool(t, nil)
+	if _, err := sacp.GetConnection(context.Background(), shard, RoutingWritePrimary); err == nil {
+		t.Fatal("未配置解析器时应返回错误")
+	}
+}
+
+// TestConnectionPoolExhausted 没有空闲连接且等待队列已满时返回PoolExhaustedError
+func TestConnectionPoolExhausted(t *testing.T) {
+	config := DefaultPoolConfig()
+	config.MinConnections = 0
+	config.MaxConnections = 1
+	config.InitialSize = 0
+	config.EnablePreWarm = false
+	config.EnableAutoScale = false
+	config.HealthCheckInterval = 0
+	pool := newTestPool(t, config, nil)
+
+	conn, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("获取连接失败: %v", err)
+	}
+
+	// 第二个请求占满容量为1的等待队列
+	waiting := make(chan error, 1)
+	go func() {
+		c, err := pool.Get(context.Background())
+		if err == nil {
+			pool.Put(c)
+		}
+		waiting <- err
+	}()
+	waitForPool(t, pool, func(stats *PoolStats) bool { return stats.WaitingRequests == 1 })
+
+	_, err = pool.Get(context.Background())
+	var exhausted *PoolExhaustedError
+	if !errors.Is(err, ErrPoolExhausted) || !errors.As(err, &exhausted) || exhausted.QueueLen != 1 {
+		t.Fatalf("等待队列已满时应返回PoolExhaustedError，实际 %v", err)
+	}
+	if ClassifyError(err) != ErrorClassUnavailable {
+		t.Errorf("连接池耗尽应归类为Unavailable，实际 %s", ClassifyError(err))
+	}
+
+	pool.Put(conn)
+	if err := <-waiting; err != nil {
+		t.Fatalf("归还连接后等待的请求应获得连接: %v", err)
+	}
+}
+
+// newWaitTestPool 创建最多maxConns个连接、不做后台维护的连接池
+func newWaitTestPool(t *testing.T, maxConns int) *ConnectionPool {
+	t.Helper()
+
+	config := DefaultPoolConfig()
+	config.MinConnections = 0
+	config.MaxConnections = maxConns
+	config.MaxIdleConnections = maxConns
+	config.InitialSize = 0
+	config.EnablePreWarm = false
+	config.EnableAutoScale = false
+	config.HealthCheckInterval = 0
+	pool := NewConnectionPool(config, "node1", "shard-0", "fake:0", &fakeConnectionFactory{dead: make(map[string]bool)})
+	if err := pool.Start(context.Background()); err != nil {
+		t.Fatalf("启动连接池失败: %v", err)
+	}
+	t.Cleanup(func() { pool.Stop() })
+	return pool
+}
+
+// TestConnectionPoolWaitersFIFO 归还的连接按进入等待队列的顺序交给等待的请求
+func TestConnectionPoolWaitersFIFO(t *testing.T) {
+	pool := newWaitTestPool(t, 3)
+
+	held := make([]*Connection, 3)
+	for i := range held {
+		conn, err := pool.Get(context.Background())
+		if err != nil {
+			t.Fatalf("获取连接失败: %v", err)
+		}
+		held[i] = conn
+	}
+
+	type acquired struct {
+		waiter int
+		conn   *Connection
+	}
+	order := make(chan acquired, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			conn, err := pool.Get(context.Background())
+			if err != nil {
+				t.Errorf("等待者 %d 获取连接失败: %v", i, err)
+			}
+			order <- acquired{waiter: i, conn: conn}
+		}(i)
+		waitForPool(t, pool, func(stats *PoolStats) bool { return stats.WaitingRequests == int64(i+1) })
+	}
+
+	// 每次归还一个连接，由最早进入队列的等待者获得
+	for i := 0; i < 3; i++ {
+		pool.Put(held[i])
+		got := <-order
+		if got.waiter != i {
+			t.Fatalf("第 %d 个获得连接的是等待者 %d", i, got.waiter)
+		}
+		if got.conn != held[i] {
+			t.Errorf("等待者 %d 没有获得刚归还的连接", i)
+		}
+		defer pool.Put(got.conn)
+	}
+}
+
+// TestConnectionPoolWaitStress 短超时的请求与正常请求并发争用连接：
+// 等待者总能得到归还的连接，放弃等待的请求不会泄漏已交给它的连接
+func TestConnectionPoolWaitStress(t *testing.T) {
+	const maxConns = 4
+	pool := newWaitTestPool(t, maxConns)
+
+	var (
+		wg       sync.WaitGroup
+		patient  atomic.Int64
+		timedOut atomic.Int64
+	)
+	deadline := time.Now().Add(300 * time.Millisecond)
+	for g := 0; g < 2*maxConns; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(g)))
+			for time.Now().Before(deadline) {
+				// 一半的请求在等待中途放弃，可能恰好在连接交给它之后
+				timeout := time.Second
+				if g%2 == 1 {
+					timeout = time.Duration(rng.Intn(200)) * time.Microsecond
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				conn, err := pool.Get(ctx)
+				cancel()
+				if err != nil {
+					if g%2 == 0 {
+						t.Errorf("等待足够久的请求失败: %v", err)
+						return
+					}
+					if !errors.Is(err, context.DeadlineExceeded) {
+						t.Errorf("短超时的请求应以超时失败，实际: %v", err)
+						return
+					}
+					timedOut.Add(1)
+					continue
+				}
+				if g%2 == 0 {
+					patient.Add(1)
+				}
+				time.Sleep(time.Duration(rng.Intn(100)) * time.Microsecond)
+				pool.Put(conn)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if patient.Load() == 0 || timedOut.Load() == 0 {
+		t.Fatalf("压力测试没有覆盖两类请求: 成功 %d, 超时 %d", patient.Load(), timedOut.Load())
+	}
+
+	// 所有连接都已归还：没有借出或被放弃的等待者占用的连接
+	stats := pool.GetStats()
+	if stats.ActiveConnections != 0 || stats.WaitingRequests != 0 || stats.TotalConnections > maxConns ||
+		stats.IdleConnections != stats.TotalConnections {
+		t.Fatalf("压力测试后连接池状态不一致: %+v", stats)
+	}
+	pool.mu.RLock()
+	waiters := len(pool.waiters)
+	pool.mu.RUnlock()
+	if waiters != 0 {
+		t.Fatalf("等待队列中残留 %d 个等待者", waiters)
+	}
+
+	held := make([]*Connection, 0, maxConns)
+	for i := 0; i < maxConns; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		conn, err := pool.Get(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("压力测试后第 %d 个连接获取失败: %v", i, err)
+		}
+		held = append(held, conn)
+	}
+	for _, conn := range held {
+		pool.Put(conn)
+	}
+}
+
+// TestConnectionPoolWaitTimeStats 获取连接与读取统计并发进行，等待时间的平均值与p99来自最近的获取
+func TestConnectionPoolWaitTimeStats(t *testing.T) {
+	pool := newWaitTestPool(t, 2)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			stats := pool.GetStats()
+			if stats.P99WaitTime < 0 || stats.AverageWaitTime < 0 {
+				t.Errorf("等待时间不应为负: %v %v", stats.AverageWaitTime, stats.P99WaitTime)
+				return
+			}
+		}
+	}()
+
+	// 两个连接被持有一段时间，第三个请求需要排队等待（等待队列的长度不超过最大连接数）
+	var getters sync.WaitGroup
+	for g := 0; g < 3; g++ {
+		getters.Add(1)
+		go func() {
+			defer getters.Done()
+			for i := 0; i < 10; i++ {
+				conn, err := pool.Get(context.Background())
+				if err != nil {
+					t.Errorf("获取连接失败: %v", err)
+					return
+				}
+				time.Sleep(time.Millisecond)
+				pool.Put(conn)
+			}
+		}()
+	}
+	getters.Wait()
+	close(stop)
+	wg.Wait()
+
+	stats := pool.GetStats()
+	if stats.AverageWaitTime <= 0 {
+		t.Fatalf("排队获取连接后平均等待时间应大于0，实际 %v", stats.AverageWaitTime)
+	}
+	if stats.P99WaitTime < stats.AverageWaitTime || stats.P99WaitTime < time.Millisecond {
+		t.Fatalf("p99等待时间 %v 应不小于平均值 %v 与连接的持有时间", stats.P99WaitTime, stats.AverageWaitTime)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-26 10:12:48
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-26 10:12:48
+* @Description: ConcordKV Go client error definitions
+ */
+
+package concord
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/concordkv/client/go/keyhash"
+)
+
+// 错误定义，客户端、智能路由器与连接池返回的错误都可以用errors.Is判断类别
+var (
+	ErrNoEndpoints      = errors.New("没有可用的节点端点")
+	ErrConnectionFailed = errors.New("连接失败")
+	ErrTimeout          = errors.New("请求超时")
+	ErrKeyNotFound      = errors.New("键不存在")
+	ErrInvalidArgument  = errors.New("无效参数")
+	ErrNotLeader        = errors.New("节点不是领导者")
+	ErrSessionExpired   = errors.New("会话不存在或已过期")
+	ErrUnsupported      = errors.New("服务端不支持该接口")
+	ErrUnavailable      = errors.New("服务暂时不可用")
+	ErrAccessDenied     = errors.New("访问被拒绝")
+	ErrShardMigrating   = errors.New("分片正在迁移")
+	ErrValueTooLarge    = errors.New("值超过大小上限")
+	ErrNoHealthyNodes   = errors.New("没有可用的健康节点")
+	ErrPoolExhausted    = errors.New("连接池繁忙")
+	ErrCircuitOpen      = errors.New("熔断器开启，请求被拒绝")
+	ErrShardNotFound    = errors.New("没有对应的分片")
+	ErrConflict         = errors.New("当前值与期望不符")
+	ErrKeyHashMismatch  = errors.New("不支持服务端的键哈希算法")
+)
+
+// NotLeaderError 节点拒绝请求，因为它不是领导者；服务端知道领导者时一并返回
+type NotLeaderError struct {
+	LeaderID   NodeID // 领导者节点ID，未知时为空
+	LeaderAddr string // 领导者的API地址，未知时为空
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.LeaderID == "" {
+		return ErrNotLeader.Error()
+	}
+	return fmt.Sprintf("%s，当前领导者: %s", ErrNotLeader.Error(), e.LeaderID)
+}
+
+// Is 使errors.Is(err, ErrNotLeader)成立
+func (e *NotLeaderError) Is(target error) bool {
+	return target == ErrNotLeader
+}
+
+// PoolExhaustedError 连接池没有空闲连接且等待队列已满
+type PoolExhaustedError struct {
+	Wait     time.Duration // 放弃前已等待的时间
+	QueueLen int           // 等待队列中的请求数
+}
+
+func (e *PoolExhaustedError) Error() string {
+	return fmt.Sprintf("%s，等待队列已满（%d个请求等待，已等待%v）", ErrPoolExhausted.Error(), e.QueueLen, e.Wait)
+}
+
+// Is 使errors.Is(err, ErrPoolExhausted)成立
+func (e *PoolExhaustedError) Is(target error) bool {
+	return target == ErrPoolExhausted
+}
+
+// CircuitOpenError 节点的熔断器开启，请求没有发出
+type CircuitOpenError struct {
+	NodeID NodeID
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("节点 %s %s", e.NodeID, ErrCircuitOpen.Error())
+}
+
+// Is 使errors.Is(err, ErrCircuitOpen)成立
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// ShardNotFoundError 拓扑中没有覆盖该键的分片
+type ShardNotFoundError struct {
+	Key string
+}
+
+func (e *ShardNotFoundError) Error() string {
+	return fmt.Sprintf("键 %s %s", e.Key, ErrShardNotFound.Error())
+}
+
+// Is 使errors.Is(err, ErrShardNotFound)成立
+func (e *ShardNotFoundError) Is(target error) bool {
+	return target == ErrShardNotFound
+}
+
+// KeyHashMismatchError 服务端通告的键哈希算法都不受支持，客户端无法确定键所在的分片
+type KeyHashMismatchError struct {
+	Advertised keyhash.Algorithm  // 服务端分片使用的算法
+	Previous   *keyhash.Algorithm // 迁移窗口内同时通告的之前的算法，未通告时为nil
+}
+
+func (e *KeyHashMismatchError) Error() string {
+	if e.Previous == nil {
+		return fmt.Sprintf("%s %s，请升级客户端", ErrKeyHashMismatch.Error(), e.Advertised)
+	}
+	return fmt.Sprintf("%s %s（之前的算法为 %s），请升级客户端", ErrKeyHashMismatch.Error(), e.Advertised, *e.Previous)
+}
+
+// Is 使errors.Is(err, ErrKeyHashMismatch)成立
+func (e *KeyHashMismatchError) Is(target error) bool {
+	return target == ErrKeyHashMismatch
+}
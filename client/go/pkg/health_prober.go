@@ -0,0 +1,105 @@
+/*
+* @Author: Lzww0608
+* @Date: 22025-7-2 22:23:37
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-2 22:23:40
+* @Description: ConcordKV intelligent client - node health probers
+ */
+
+package concord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultHealthCheckPath 默认的节点状态接口
+const DefaultHealthCheckPath = "/api/status"
+
+// HealthProber 节点健康探测器，返回探测延迟；超时由ctx控制
+type HealthProber interface {
+	Probe(ctx context.Context, nodeID NodeID, address string) (time.Duration, error)
+}
+
+// ErrNodeDraining 节点正在排空，即将停止，不应再向其发送请求
+var ErrNodeDraining = errors.New("节点正在排空")
+
+// HTTPHealthProber 请求节点的状态接口，返回2xx且未报告draining视为健康
+type HTTPHealthProber struct {
+	Client *http.Client // 为nil时使用http.DefaultClient
+	Path   string       // 状态接口路径，为空时使用DefaultHealthCheckPath
+}
+
+// NewHTTPHealthProber 创建HTTP健康探测器
+func NewHTTPHealthProber() *HTTPHealthProber {
+	return &HTTPHealthProber{Path: DefaultHealthCheckPath}
+}
+
+// Probe 请求节点的状态接口
+func (p *HTTPHealthProber) Probe(ctx context.Context, nodeID NodeID, address string) (time.Duration, error) {
+	path := p.Path
+	if path == "" {
+		path = DefaultHealthCheckPath
+	}
+	url := address
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = "http://" + url
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(url, "/")+path, nil)
+	if err != nil {
+		return 0, fmt.Errorf("创建健康检查请求失败: %w", err)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Since(start), fmt.Errorf("节点 %s 健康检查失败: %w", nodeID, err)
+	}
+	// 状态接口在节点排空期间报告draining；非JSON的响应只按状态码判断
+	var status struct {
+		Draining bool `json:"draining"`
+	}
+	json.NewDecoder(resp.Body).Decode(&status)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	latency := time.Since(start)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return latency, fmt.Errorf("节点 %s 健康检查返回状态码 %d", nodeID, resp.StatusCode)
+	}
+	if status.Draining {
+		return latency, fmt.Errorf("节点 %s: %w", nodeID, ErrNodeDraining)
+	}
+	return latency, nil
+}
+
+// TCPHealthProber 只检查节点端口能否建立TCP连接
+type TCPHealthProber struct{}
+
+// Probe 建立并立即关闭TCP连接
+func (TCPHealthProber) Probe(ctx context.Context, nodeID NodeID, address string) (time.Duration, error) {
+	address = strings.TrimPrefix(strings.TrimPrefix(address, "http://"), "https://")
+
+	var dialer net.Dialer
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	latency := time.Since(start)
+	if err != nil {
+		return latency, fmt.Errorf("节点 %s 连接失败: %w", nodeID, err)
+	}
+	conn.Close()
+	return latency, nil
+}
@@ -0,0 +1,82 @@
+/*
+* @Author: Lzww0608
+* @Date: 22025-7-2 22:23:37
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-2 22:23:40
+* @Description: ConcordKV intelligent client - node health prober tests
+ */
+
+package concord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestHTTPHealthProber 状态接口返回2xx视为健康，其他状态码、报告排空、超时均视为失败
+func TestHTTPHealthProber(t *testing.T) {
+	var status int32 = http.StatusOK
+	var draining int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != DefaultHealthCheckPath {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("slow") != "" {
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+		fmt.Fprintf(w, `{"draining":%v}`, atomic.LoadInt32(&draining) == 1)
+	}))
+	defer server.Close()
+
+	prober := NewHTTPHealthProber()
+	if _, err := prober.Probe(context.Background(), "node1", server.URL); err != nil {
+		t.Fatalf("健康节点探测失败: %v", err)
+	}
+
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	if _, err := prober.Probe(context.Background(), "node1", server.Listener.Addr().String()); err == nil {
+		t.Errorf("返回503时应探测失败")
+	}
+
+	atomic.StoreInt32(&status, http.StatusOK)
+	atomic.StoreInt32(&draining, 1)
+	if _, err := prober.Probe(context.Background(), "node1", server.URL); !errors.Is(err, ErrNodeDraining) {
+		t.Errorf("节点报告draining时应探测失败: %v", err)
+	}
+
+	slow := &HTTPHealthProber{Path: DefaultHealthCheckPath + "?slow=1"}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := slow.Probe(ctx, "node1", server.URL); err == nil || time.Since(start) > time.Second {
+		t.Errorf("探测应在超时后失败，实际耗时 %v 错误 %v", time.Since(start), err)
+	}
+}
+
+// TestTCPHealthProber 端口可连接视为健康
+func TestTCPHealthProber(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	address := listener.Addr().String()
+
+	if _, err := (TCPHealthProber{}).Probe(context.Background(), "node1", address); err != nil {
+		t.Fatalf("端口可连接时探测失败: %v", err)
+	}
+
+	listener.Close()
+	if _, err := (TCPHealthProber{}).Probe(context.Background(), "node1", address); err == nil {
+		t.Errorf("端口关闭后应探测失败")
+	}
+}
@@ -0,0 +1,55 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-29 09:12:40
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-29 09:12:40
+* @Description: ConcordKV Go client recent latency samples
+ */
+
+package concord
+
+import (
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// latencySampleCount 保留的最近耗时样本数
+const latencySampleCount = 256
+
+// latencySamples 最近若干次耗时的环形缓冲，记录只做原子操作，可以在热路径上并发调用；
+// 平均值与分位数在读取统计时才计算
+type latencySamples struct {
+	next    uint64                    // 已记录的样本总数，原子更新
+	samples [latencySampleCount]int64 // 纳秒，原子读写
+}
+
+// record 记录一次耗时，覆盖最旧的样本
+func (s *latencySamples) record(d time.Duration) {
+	slot := (atomic.AddUint64(&s.next, 1) - 1) % latencySampleCount
+	atomic.StoreInt64(&s.samples[slot], int64(d))
+}
+
+// summary 最近样本的平均值与p99，没有样本时均为0
+// 与record并发时个别样本可能是上一轮的旧值，对统计结果影响可以忽略
+func (s *latencySamples) summary() (average, p99 time.Duration) {
+	n := atomic.LoadUint64(&s.next)
+	if n == 0 {
+		return 0, 0
+	}
+	if n > latencySampleCount {
+		n = latencySampleCount
+	}
+
+	values := make([]int64, n)
+	var sum int64
+	for i := range values {
+		values[i] = atomic.LoadInt64(&s.samples[i])
+		sum += values[i]
+	}
+	slices.Sort(values)
+
+	// 不小于99%样本的最小值
+	rank := (len(values)*99+99)/100 - 1
+	return time.Duration(sum / int64(len(values))), time.Duration(values[rank])
+}
@@ -0,0 +1,37 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-29 10:05:18
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-29 10:05:18
+* @Description: ConcordKV Go client recent latency samples tests
+ */
+
+package concord
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLatencySamplesSummary 平均值与p99只统计最近的样本，样本环写满后覆盖最旧的样本
+func TestLatencySamplesSummary(t *testing.T) {
+	var samples latencySamples
+	if average, p99 := samples.summary(); average != 0 || p99 != 0 {
+		t.Fatalf("没有样本时应为0，实际 %v %v", average, p99)
+	}
+
+	for i := 1; i <= 100; i++ {
+		samples.record(time.Duration(i) * time.Millisecond)
+	}
+	if average, p99 := samples.summary(); average != 50500*time.Microsecond || p99 != 99*time.Millisecond {
+		t.Fatalf("1..100ms的平均值与p99应为50.5ms与99ms，实际 %v %v", average, p99)
+	}
+
+	// 写满后旧样本被覆盖，只剩最近的latencySampleCount个1ms样本
+	for i := 0; i < latencySampleCount; i++ {
+		samples.record(time.Millisecond)
+	}
+	if average, p99 := samples.summary(); average != time.Millisecond || p99 != time.Millisecond {
+		t.Fatalf("旧样本应已被覆盖，实际 %v %v", average, p99)
+	}
+}
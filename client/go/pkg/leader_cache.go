@@ -0,0 +1,243 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-31 18:05:26
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-31 18:05:26
+* @Description: ConcordKV Go client leader hint cache and leader-change event stream
+ */
+
+package concord
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 领导者缓存的参数
+const (
+	defaultLeaderCacheTTL = 30 * time.Second
+	// leaderEventIdleTimeout 事件流超过该时间没有收到任何数据（服务端每15秒发送心跳）时重新连接
+	leaderEventIdleTimeout = time.Minute
+)
+
+// leaderCache 跨操作共享的领导者提示，来自NOT_LEADER响应、跟随的重定向与领导者变更事件
+// 写请求先发往缓存的领导者；该节点返回NOT_LEADER、连接失败或提示超过TTL后失效
+type leaderCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	id      NodeID
+	conn    *connection
+	expires time.Time
+}
+
+// get 返回未过期的领导者连接，没有时返回nil
+func (l *leaderCache) get() *connection {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil || time.Now().After(l.expires) {
+		l.id, l.conn = "", nil
+		return nil
+	}
+	return l.conn
+}
+
+// set 记录领导者，重新开始计算TTL
+func (l *leaderCache) set(id NodeID, conn *connection) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.id, l.conn = id, conn
+	l.expires = time.Now().Add(l.ttl)
+}
+
+// invalidate 缓存的领导者是conn时清除，conn为nil时无条件清除
+func (l *leaderCache) invalidate(conn *connection) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn != nil && (conn == nil || l.conn.baseURL == conn.baseURL) {
+		l.id, l.conn = "", nil
+	}
+}
+
+// connectionFor 返回地址对应的连接，优先复用配置的节点的连接
+// 服务端给出的API地址不带协议，按主机与端口匹配配置的节点，因此启用TLS的节点仍使用https
+func (c *Client) connectionFor(addr string) *connection {
+	conn := newConnection(addr)
+	host := strings.TrimPrefix(strings.TrimPrefix(conn.baseURL, "http://"), "https://")
+	explicit := strings.Contains(addr, "://")
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, existing := range c.conns {
+		if existing.baseURL == conn.baseURL {
+			return existing
+		}
+		if !explicit && strings.TrimPrefix(strings.TrimPrefix(existing.baseURL, "http://"), "https://") == host {
+			return existing
+		}
+	}
+	return conn
+}
+
+// rememberLeader 记录得知的领导者
+func (c *Client) rememberLeader(id NodeID, conn *connection) {
+	if c.leaders != nil && conn != nil {
+		c.leaders.set(id, conn)
+	}
+}
+
+// forgetLeader 缓存的领导者是conn时清除
+func (c *Client) forgetLeader(conn *connection) {
+	if c.leaders != nil {
+		c.leaders.invalidate(conn)
+	}
+}
+
+// leaderFirst 把缓存的领导者放在写请求的候选节点之前
+func (c *Client) leaderFirst(route []*connection) []*connection {
+	if c.leaders == nil {
+		return route
+	}
+	leader := c.leaders.get()
+	if leader == nil {
+		return route
+	}
+	atomic.AddInt64(&c.stats.leaderHintHits, 1)
+	return append([]*connection{leader}, route...)
+}
+
+// startLeaderEvents 启用LeaderEvents时在后台订阅领导者变更事件，Close时停止
+func (c *Client) startLeaderEvents() {
+	if !c.config.LeaderEvents || c.leaders == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopLeaderEvents = cancel
+	c.leaderEventsDone = make(chan struct{})
+	go func() {
+		defer close(c.leaderEventsDone)
+		c.followLeaderEvents(ctx)
+	}()
+}
+
+// followLeaderEvents 依次连接各节点的事件流，断开后以RetryInterval为间隔重连，直到ctx结束
+// 事件序号由各节点分别分配，重连同一节点时携带从它收到的最后一个序号，服务端只补发之后的事件
+func (c *Client) followLeaderEvents(ctx context.Context) {
+	since := make(map[string]uint64)
+	for {
+		conns, err := c.connections()
+		if err != nil {
+			return
+		}
+		for _, conn := range conns {
+			seq := since[conn.baseURL]
+			err = c.readLeaderEvents(ctx, conn, &seq)
+			since[conn.baseURL] = seq
+			if ctx.Err() != nil {
+				return
+			}
+			c.logger.Debug("领导者事件流断开", "node", conn.baseURL, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.config.RetryInterval):
+		}
+	}
+}
+
+// readLeaderEvents 订阅一个节点的领导者变更事件，按事件更新领导者缓存，直到连接断开
+func (c *Client) readLeaderEvents(ctx context.Context, conn *connection, since *uint64) error {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	path := "/api/events?follow=true&type=leader_change"
+	if *since > 0 {
+		path += "&since=" + strconv.FormatUint(*since, 10)
+	}
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodGet, conn.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	c.authorize(req)
+
+	// 事件流是长连接，不能使用带整体超时的客户端
+	streamClient := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrConnectionFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("订阅领导者事件失败，状态码: %d", resp.StatusCode)
+	}
+
+	idle := time.AfterFunc(leaderEventIdleTimeout, cancel)
+	defer idle.Stop()
+
+	reader := bufio.NewReader(resp.Body)
+	var eventType, data string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return errors.New("事件流被服务端关闭")
+			}
+			return err
+		}
+		idle.Reset(leaderEventIdleTimeout)
+
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			if eventType == "leader_change" {
+				c.applyLeaderEvent(data, since)
+			}
+			eventType, data = "", ""
+		case strings.HasPrefix(line, "event:"):
+			eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+		}
+	}
+}
+
+// applyLeaderEvent 按领导者变更事件更新缓存：新领导者的API地址已知时直接记录，领导者未知时清除缓存
+func (c *Client) applyLeaderEvent(data string, since *uint64) {
+	var event struct {
+		Seq  uint64 `json:"seq"`
+		Data struct {
+			NewLeaderID   NodeID `json:"newLeaderID"`
+			LeaderAPIAddr string `json:"leaderApiAddr"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		c.logger.Warn("解析领导者变更事件失败", "error", err)
+		return
+	}
+	if event.Seq > *since {
+		*since = event.Seq
+	}
+
+	atomic.AddInt64(&c.stats.leaderEventUpdates, 1)
+	if event.Data.NewLeaderID == "" || event.Data.LeaderAPIAddr == "" {
+		c.leaders.invalidate(nil)
+		return
+	}
+	c.rememberLeader(event.Data.NewLeaderID, c.connectionFor(event.Data.LeaderAPIAddr))
+	c.logger.Debug("领导者变更事件更新领导者缓存", "leader", event.Data.NewLeaderID, "addr", event.Data.LeaderAPIAddr)
+}
@@ -0,0 +1,200 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-31 18:40:13
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-31 18:40:13
+* @Description: ConcordKV Go client leader hint cache tests
+ */
+
+package concord
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeCluster 模拟领导者选举的节点集合：非领导者对写请求返回NOT_LEADER并附带领导者的ID与API地址，
+// 每个节点的/api/events推送写入events的领导者变更事件
+type fakeCluster struct {
+	mu     sync.Mutex
+	leader string
+	nodes  map[string]*httptest.Server
+	writes map[string]*int64
+	events chan string
+	seq    uint64
+}
+
+func newFakeCluster(t *testing.T, ids ...string) *fakeCluster {
+	cluster := &fakeCluster{nodes: make(map[string]*httptest.Server), writes: make(map[string]*int64), events: make(chan string, 8)}
+	for _, id := range ids {
+		id := id
+		cluster.writes[id] = new(int64)
+		cluster.nodes[id] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/set":
+				atomic.AddInt64(cluster.writes[id], 1)
+				leader, addr := cluster.currentLeader()
+				if leader != id {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{
+						"code": "NOT_LEADER", "message": "不是领导者", "leader": leader, "leaderApiAddr": addr,
+					}})
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "index": 1})
+			case "/api/events":
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.WriteHeader(http.StatusOK)
+				w.(http.Flusher).Flush()
+				for {
+					select {
+					case <-r.Context().Done():
+						return
+					case leader := <-cluster.events:
+						fmt.Fprintf(w, "event: leader_change\ndata: %s\n\n", leader)
+						w.(http.Flusher).Flush()
+					}
+				}
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		t.Cleanup(cluster.nodes[id].Close)
+	}
+	return cluster
+}
+
+// currentLeader 当前领导者的ID与不带协议的API地址
+func (fc *fakeCluster) currentLeader() (string, string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.leader, strings.TrimPrefix(fc.nodes[fc.leader].URL, "http://")
+}
+
+// elect 切换领导者，announce为true时推送领导者变更事件
+func (fc *fakeCluster) elect(id string, announce bool) {
+	fc.mu.Lock()
+	fc.leader = id
+	fc.seq++
+	seq := fc.seq
+	fc.mu.Unlock()
+
+	if announce {
+		_, addr := fc.currentLeader()
+		data, _ := json.Marshal(map[string]interface{}{
+			"seq": seq, "type": "leader_change", "data": map[string]interface{}{"newLeaderID": id, "leaderApiAddr": addr},
+		})
+		fc.events <- string(data)
+	}
+}
+
+// counts 各节点收到的写请求数
+func (fc *fakeCluster) counts(ids ...string) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprintf("%s=%d", id, atomic.LoadInt64(fc.writes[id]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// TestLeaderCacheFromNotLeader NOT_LEADER响应中的领导者提示被缓存，之后的写请求直接发往领导者；提示超过TTL后失效
+func TestLeaderCacheFromNotLeader(t *testing.T) {
+	cluster := newFakeCluster(t, "n1", "n2")
+	cluster.elect("n2", false)
+
+	client, err := NewClient(Config{
+		Endpoints: []string{cluster.nodes["n1"].URL, cluster.nodes["n2"].URL}, RetryCount: 1, DisableSession: true,
+		Timeout: time.Second, LeaderCacheTTL: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+
+	steps := []struct {
+		elect string
+		want  string
+	}{
+		{"", "n1=1 n2=1"},   // 第一次写入先请求n1，按提示转向n2
+		{"", "n1=1 n2=2"},   // 之后直接写入缓存的领导者
+		{"n1", "n1=2 n2=3"}, // 选举后缓存的n2返回NOT_LEADER，缓存失效并按提示转向n1
+		{"", "n1=3 n2=3"},
+	}
+	for i, step := range steps {
+		if step.elect != "" {
+			cluster.elect(step.elect, false)
+		}
+		if _, err := client.Set("k", "v"); err != nil {
+			t.Fatalf("第 %d 次写入失败: %v", i+1, err)
+		}
+		if got := cluster.counts("n1", "n2"); got != step.want {
+			t.Fatalf("第 %d 次写入后各节点的请求数 = %s, 期望 %s", i+1, got, step.want)
+		}
+	}
+	if stats := client.GetStats(); stats.LeaderDiscoveries != 2 || stats.LeaderHintHits != 3 {
+		t.Errorf("领导者发现往返 = %d, 缓存命中 = %d, 期望 2 与 3", stats.LeaderDiscoveries, stats.LeaderHintHits)
+	}
+
+	// 领导者提示过期后回到按配置顺序请求
+	cluster.elect("n2", false)
+	time.Sleep(250 * time.Millisecond)
+	if _, err := client.Set("k", "v"); err != nil {
+		t.Fatalf("提示过期后写入失败: %v", err)
+	}
+	if got := cluster.counts("n1", "n2"); got != "n1=4 n2=4" {
+		t.Errorf("提示过期后各节点的请求数 = %s", got)
+	}
+}
+
+// TestLeaderCacheFromEvents 订阅领导者变更事件时，模拟的选举之后下一次写入直接发往新领导者，不经过NOT_LEADER
+func TestLeaderCacheFromEvents(t *testing.T) {
+	cluster := newFakeCluster(t, "n1", "n2")
+	cluster.elect("n2", true)
+
+	client, err := NewClient(Config{
+		Endpoints: []string{cluster.nodes["n1"].URL, cluster.nodes["n2"].URL}, RetryCount: 1, DisableSession: true,
+		Timeout: time.Second, LeaderEvents: true,
+	})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+
+	waitUpdates := func(n int64) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for client.GetStats().LeaderEventUpdates < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("未收到第 %d 个领导者变更事件", n)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	waitUpdates(1)
+	if _, err := client.Set("k", "v"); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	if got := cluster.counts("n1", "n2"); got != "n1=0 n2=1" {
+		t.Fatalf("写入应直接发往n2: %s", got)
+	}
+
+	cluster.elect("n1", true)
+	waitUpdates(2)
+	if _, err := client.Set("k", "v"); err != nil {
+		t.Fatalf("选举后写入失败: %v", err)
+	}
+	if got := cluster.counts("n1", "n2"); got != "n1=1 n2=1" {
+		t.Fatalf("选举后的下一次写入应直接发往新领导者n1: %s", got)
+	}
+	if stats := client.GetStats(); stats.LeaderDiscoveries != 0 {
+		t.Errorf("不应有领导者发现往返: %d", stats.LeaderDiscoveries)
+	}
+}
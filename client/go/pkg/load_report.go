@@ -0,0 +1,116 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-17 14:21:06
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-17 14:21:06
+* @Description: ConcordKV intelligent client - node load reports
+ */
+
+package concord
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DefaultLoadReportPath 默认的分片负载接口
+const DefaultLoadReportPath = "/api/shards/load"
+
+// ShardLoad 分片在统计窗口内的请求速率
+type ShardLoad struct {
+	ShardID    string  `json:"shardId"`
+	QPS        float64 `json:"qps"`
+	ReadQPS    float64 `json:"readQps"`
+	WriteQPS   float64 `json:"writeQps"`
+	WriteRatio float64 `json:"writeRatio"`
+}
+
+// HotKeyLoad 热点键在统计窗口内的估计请求数
+type HotKeyLoad struct {
+	Key     string  `json:"key"`
+	ShardID string  `json:"shardId"`
+	Count   uint64  `json:"count"`
+	QPS     float64 `json:"qps"`
+}
+
+// NodeLoadReport 节点处理的请求按分片统计的负载报告，由服务端按采样估计
+type NodeLoadReport struct {
+	NodeID        NodeID       `json:"nodeId"`
+	WindowSeconds float64      `json:"windowSeconds"`
+	SampleRate    float64      `json:"sampleRate"`
+	Shards        []ShardLoad  `json:"shards"`
+	HotKeys       []HotKeyLoad `json:"hotKeys"`
+}
+
+// TotalQPS 节点在窗口内处理的总请求速率
+func (r *NodeLoadReport) TotalQPS() float64 {
+	var total float64
+	for _, shard := range r.Shards {
+		total += shard.QPS
+	}
+	return total
+}
+
+// LoadReporter 获取节点的负载报告；超时由ctx控制
+type LoadReporter interface {
+	Report(ctx context.Context, nodeID NodeID, address string) (*NodeLoadReport, error)
+}
+
+// HTTPLoadReporter 请求节点的分片负载接口
+type HTTPLoadReporter struct {
+	Client *http.Client // 为nil时使用http.DefaultClient
+	Path   string       // 负载接口路径，为空时使用DefaultLoadReportPath
+	Token  string       // 服务端启用认证时携带的令牌；非管理员令牌的报告不含热点键
+}
+
+// NewHTTPLoadReporter 创建HTTP负载报告获取器
+func NewHTTPLoadReporter() *HTTPLoadReporter {
+	return &HTTPLoadReporter{Path: DefaultLoadReportPath}
+}
+
+// Report 请求节点的分片负载接口
+func (p *HTTPLoadReporter) Report(ctx context.Context, nodeID NodeID, address string) (*NodeLoadReport, error) {
+	path := p.Path
+	if path == "" {
+		path = DefaultLoadReportPath
+	}
+	url := address
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = "http://" + url
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(url, "/")+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建负载报告请求失败: %w", err)
+	}
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("获取节点 %s 的负载报告失败: %w", nodeID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("获取节点 %s 的负载报告返回状态码 %d", nodeID, resp.StatusCode)
+	}
+
+	var report NodeLoadReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("解析节点 %s 的负载报告失败: %w", nodeID, err)
+	}
+	if report.NodeID == "" {
+		report.NodeID = nodeID
+	}
+	return &report, nil
+}
@@ -0,0 +1,118 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-27 10:05:16
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-27 10:05:16
+* @Description: ConcordKV Go client locality-aware replica ordering
+ */
+
+package concord
+
+import (
+	"sort"
+	"time"
+)
+
+// ReplicaLocation 副本所在的数据中心与可用区，由服务端的/api/topology按成员配置给出
+type ReplicaLocation struct {
+	DataCenter string `json:"dataCenter,omitempty"`
+	Zone       string `json:"zone,omitempty"`
+}
+
+// locality 客户端的位置，读请求据此对副本排序
+type locality struct {
+	dc   string
+	zone string
+}
+
+// localityFor 请求的PreferredDC、PreferredZone优先，未设置时使用路由器配置的本地位置
+func (sr *SmartRouter) localityFor(req *RoutingRequest) locality {
+	loc := locality{dc: sr.config.LocalDC, zone: sr.config.LocalZone}
+	if req.PreferredDC != "" {
+		loc.dc = req.PreferredDC
+	}
+	if req.PreferredZone != "" {
+		loc.zone = req.PreferredZone
+	}
+	return loc
+}
+
+// localityRank 副本相对客户端的距离等级，越小越近：
+// 0为同一可用区，1为同一数据中心，之后依次为FallbackDCs中的数据中心，其余数据中心与位置未知的副本排在最后
+func (sr *SmartRouter) localityRank(node NodeID, shard *ShardInfo, loc locality) int {
+	remote := 2 + len(sr.config.FallbackDCs)
+	if shard == nil {
+		return remote
+	}
+	where, ok := shard.Locations[node]
+	if !ok {
+		return remote
+	}
+
+	sameDC := loc.dc != "" && where.DataCenter == loc.dc
+	switch {
+	case loc.zone != "" && where.Zone == loc.zone && (sameDC || loc.dc == "" || where.DataCenter == ""):
+		return 0
+	case sameDC:
+		return 1
+	}
+	for i, dc := range sr.config.FallbackDCs {
+		if where.DataCenter == dc {
+			return 2 + i
+		}
+	}
+	return remote
+}
+
+// orderByLocality 按距离等级排序节点，同一等级内按观测到的平均延迟排序，没有延迟数据的节点排在有数据的之后；
+// 冷启动时没有任何延迟数据，同一等级内保持原有顺序（主节点在前）
+func (sr *SmartRouter) orderByLocality(nodes []NodeID, shard *ShardInfo, loc locality) []NodeID {
+	type candidate struct {
+		node    NodeID
+		rank    int
+		latency time.Duration // 0表示没有延迟数据
+	}
+
+	sr.mu.RLock()
+	candidates := make([]candidate, len(nodes))
+	for i, node := range nodes {
+		candidates[i] = candidate{node: node, rank: sr.localityRank(node, shard, loc)}
+		if health, exists := sr.nodeHealthMap[node]; exists {
+			candidates[i].latency = health.AverageLatency
+		}
+	}
+	sr.mu.RUnlock()
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		if (a.latency == 0) != (b.latency == 0) {
+			return a.latency != 0
+		}
+		return a.latency < b.latency
+	})
+
+	ordered := make([]NodeID, len(candidates))
+	for i, c := range candidates {
+		ordered[i] = c.node
+	}
+	return ordered
+}
+
+// nearestTier 距离等级最小的一组节点，负载均衡只在其中选择
+func (sr *SmartRouter) nearestTier(nodes []NodeID, shard *ShardInfo, loc locality) []NodeID {
+	best := -1
+	var tier []NodeID
+	for _, node := range nodes {
+		rank := sr.localityRank(node, shard, loc)
+		switch {
+		case best < 0 || rank < best:
+			best, tier = rank, []NodeID{node}
+		case rank == best:
+			tier = append(tier, node)
+		}
+	}
+	return tier
+}
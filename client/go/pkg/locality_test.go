@@ -0,0 +1,149 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-27 10:40:52
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-27 10:40:52
+* @Description: ConcordKV Go client locality-aware routing tests
+ */
+
+package concord
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newLocalityRouter 创建路由到多数据中心分片的路由器：主节点node1在dc1，副本node2在dc2，node3在dc3
+func newLocalityRouter(configure func(config *SmartRouterConfig)) (*SmartRouter, *ShardInfo) {
+	shard := testShard("node1", 1)
+	shard.Replicas = []NodeID{"node2", "node3"}
+	shard.Locations = map[NodeID]ReplicaLocation{
+		"node1": {DataCenter: "dc1", Zone: "dc1-a"},
+		"node2": {DataCenter: "dc2", Zone: "dc2-b"},
+		"node3": {DataCenter: "dc3", Zone: "dc3-a"},
+	}
+	cache := NewTopologyCache(nil)
+	cache.Set(shard)
+
+	config := DefaultSmartRouterConfig()
+	config.EnableCache = false
+	config.HealthCheckInterval = 0
+	if configure != nil {
+		configure(config)
+	}
+	return NewSmartRouter(config, cache), shard
+}
+
+// routeRead 路由读请求，返回目标节点与备用节点
+func routeRead(t *testing.T, router *SmartRouter, req RoutingRequest) (NodeID, []NodeID) {
+	t.Helper()
+	req.Key = "k"
+	result, err := router.Route(&req)
+	if err != nil {
+		t.Fatalf("路由失败: %v", err)
+	}
+	return result.TargetNode, result.BackupNodes
+}
+
+// TestLocalityColdStart 没有任何延迟数据时，dc2的客户端读取分布在dc1/dc2/dc3的分片选择dc2的副本
+func TestLocalityColdStart(t *testing.T) {
+	router, _ := newLocalityRouter(func(config *SmartRouterConfig) { config.LocalDC = "dc2" })
+
+	target, backups := routeRead(t, router, RoutingRequest{Strategy: RoutingReadNearest})
+	if target != "node2" {
+		t.Fatalf("应选择同一数据中心的node2，实际 %s", target)
+	}
+	if !slices.Equal(backups, []NodeID{"node1", "node3"}) {
+		t.Errorf("备用节点应保持原有顺序，实际 %v", backups)
+	}
+	if target, _ := routeRead(t, router, RoutingRequest{Strategy: RoutingReadReplica}); target != "node2" {
+		t.Errorf("ReadReplica应选择同一数据中心的副本，实际 %s", target)
+	}
+
+	// 请求指定的数据中心优先于路由器配置
+	if target, _ := routeRead(t, router, RoutingRequest{Strategy: RoutingReadNearest, PreferredDC: "dc3"}); target != "node3" {
+		t.Errorf("PreferredDC为dc3时应选择node3，实际 %s", target)
+	}
+	// 写请求不受位置影响
+	if target, _ := routeRead(t, router, RoutingRequest{Strategy: RoutingWritePrimary}); target != "node1" {
+		t.Errorf("写请求应发往主节点，实际 %s", target)
+	}
+}
+
+// TestLocalityZoneAndFallback 同一可用区优先于同一数据中心，本地没有可用副本时按FallbackDCs的顺序选择
+func TestLocalityZoneAndFallback(t *testing.T) {
+	router, shard := newLocalityRouter(func(config *SmartRouterConfig) {
+		config.LocalDC = "dc2"
+		config.LocalZone = "dc2-a"
+		config.FallbackDCs = []string{"dc3"}
+	})
+	shard.Replicas = append(shard.Replicas, "node4")
+	shard.Locations["node4"] = ReplicaLocation{DataCenter: "dc2", Zone: "dc2-a"}
+
+	target, backups := routeRead(t, router, RoutingRequest{Strategy: RoutingReadNearest})
+	if target != "node4" {
+		t.Fatalf("应选择同一可用区的node4，实际 %s", target)
+	}
+	if !slices.Equal(backups, []NodeID{"node2", "node3", "node1"}) {
+		t.Errorf("备用节点应依次为同一数据中心、FallbackDCs、其余数据中心，实际 %v", backups)
+	}
+
+	for _, node := range []NodeID{"node2", "node4"} {
+		for i := 0; i < router.config.FailureThreshold; i++ {
+			router.UpdateNodeHealth(node, false, 0, nil)
+		}
+	}
+	if target, _ := routeRead(t, router, RoutingRequest{Strategy: RoutingReadNearest}); target != "node3" {
+		t.Errorf("dc2没有健康副本时应按FallbackDCs选择dc3的node3，实际 %s", target)
+	}
+}
+
+// TestLocalityLatencyRefinement 同一等级内按观测到的延迟选择，延迟不会使远程副本排到本地副本之前
+func TestLocalityLatencyRefinement(t *testing.T) {
+	router, shard := newLocalityRouter(func(config *SmartRouterConfig) { config.LocalDC = "dc2" })
+	shard.Replicas = append(shard.Replicas, "node4")
+	shard.Locations["node4"] = ReplicaLocation{DataCenter: "dc2", Zone: "dc2-a"}
+
+	router.UpdateNodeHealth("node2", true, 40*time.Millisecond, nil)
+	router.UpdateNodeHealth("node4", true, 5*time.Millisecond, nil)
+	router.UpdateNodeHealth("node3", true, time.Millisecond, nil)
+
+	target, backups := routeRead(t, router, RoutingRequest{Strategy: RoutingReadNearest})
+	if target != "node4" {
+		t.Fatalf("同一数据中心内应选择延迟更低的node4，实际 %s", target)
+	}
+	if !slices.Equal(backups, []NodeID{"node2", "node3", "node1"}) {
+		t.Errorf("远程副本应按延迟排序，没有延迟数据的排在最后，实际 %v", backups)
+	}
+
+	// 没有配置位置时与原来一样只按延迟选择
+	router.config.LocalDC = ""
+	if target, _ := routeRead(t, router, RoutingRequest{Strategy: RoutingReadNearest}); target != "node3" {
+		t.Errorf("没有配置位置时应选择延迟最低的node3，实际 %s", target)
+	}
+}
+
+// TestClientLocalDC 客户端的LocalDC随路由请求传给路由器，读请求先发往同一数据中心的副本
+func TestClientLocalDC(t *testing.T) {
+	router, _ := newLocalityRouter(nil)
+	for _, node := range []NodeID{"node1", "node2", "node3"} {
+		router.SetNodeAddress(node, "http://"+string(node)+":8081")
+	}
+
+	client, err := NewClient(Config{Endpoints: []string{"node1:8081"}, DisableSession: true, LocalDC: "dc2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetRouter(router)
+
+	route := client.routeKey(context.Background(), "k", RoutingReadNearest)
+	if len(route) != 3 || route[0].baseURL != "http://node2:8081" {
+		t.Fatalf("读请求应先发往dc2的node2，实际 %v", route)
+	}
+	if route := client.routeKey(context.Background(), "k", RoutingWritePrimary); route[0].baseURL != "http://node1:8081" {
+		t.Errorf("写请求应先发往主节点，实际 %s", route[0].baseURL)
+	}
+}
@@ -0,0 +1,45 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-11 16:42:08
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-11 16:42:08
+* @Description: ConcordKV Go client logging
+ */
+
+package concord
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// newLogger 按配置创建客户端日志：注入了Logger时直接使用，否则按LogLevel输出文本日志到标准错误
+func newLogger(config Config) (*slog.Logger, error) {
+	logger := config.Logger
+	if logger == nil {
+		level, err := parseLogLevel(config.LogLevel)
+		if err != nil {
+			return nil, err
+		}
+		logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	}
+	return logger.With("component", "client"), nil
+}
+
+// parseLogLevel 解析日志级别（debug、info、warn、error），空字符串为warn
+func parseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "", "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelWarn, fmt.Errorf("%w: 未知的日志级别 %s", ErrInvalidArgument, s)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-31 16:30:52
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-31 16:30:52
+* @Description: ConcordKV Go client value metadata and compression
+ */
+
+package concord
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// SetOptions 写入时附带的元数据
+type SetOptions struct {
+	// ContentType 值的内容类型，由服务端原样保存并在读取与扫描时返回，最长255字节
+	ContentType string
+	// Compress 请求服务端压缩存储，值的JSON编码超过服务端的compressThreshold时才压缩，读取时透明解压
+	Compress bool
+	// TTL 过期时间，为0表示永不过期
+	TTL time.Duration
+}
+
+// ValueInfo 值及其元数据
+type ValueInfo struct {
+	Value       string
+	ContentType string // 写入时指定的内容类型，未指定时为空
+	Compressed  bool   // 服务端是否压缩存储，Value已解压
+	Version     uint64
+}
+
+// SetWithOptions 按opts写入键值对，返回值同Set
+// 值作为一个整体写入，不按ChunkedValues分块
+func (c *Client) SetWithOptions(key, value string, opts SetOptions) (uint64, error) {
+	return c.SetWithOptionsCtx(context.Background(), key, value, opts)
+}
+
+// SetWithOptionsCtx 按opts写入键值对，ctx语义同GetCtx
+func (c *Client) SetWithOptionsCtx(ctx context.Context, key, value string, opts SetOptions) (uint64, error) {
+	if key == "" || opts.TTL < 0 {
+		return 0, ErrInvalidArgument
+	}
+
+	req := request{
+		Key:         key,
+		Value:       value,
+		TTLSeconds:  int64(opts.TTL / time.Second),
+		ContentType: opts.ContentType,
+		Compress:    opts.Compress,
+	}
+
+	var resp response
+	route := c.routeKey(ctx, key, RoutingWritePrimary)
+	if err := c.doWriteTo(ctx, route, http.MethodPost, "/api/set", req, &resp); err != nil {
+		return 0, err
+	}
+
+	// 缓存中保存原值
+	if c.cache != nil {
+		c.cache.Set(key, value, c.cacheTTL(opts.TTL))
+	}
+
+	return resp.Index, nil
+}
+
+// GetWithMetadata 获取键对应的值、内容类型与版本，不使用缓存
+func (c *Client) GetWithMetadata(key string) (*ValueInfo, error) {
+	return c.GetWithMetadataCtx(context.Background(), key)
+}
+
+// GetWithMetadataCtx 获取键对应的值及其元数据，ctx语义同GetCtx
+func (c *Client) GetWithMetadataCtx(ctx context.Context, key string) (*ValueInfo, error) {
+	if key == "" {
+		return nil, ErrInvalidArgument
+	}
+
+	var resp response
+	route := c.routeKey(ctx, key, RoutingReadNearest)
+	path := "/api/get?key=" + url.QueryEscape(key)
+	if err := c.doRequestTo(ctx, route, http.MethodGet, path, nil, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	if !resp.Exists {
+		return nil, ErrKeyNotFound
+	}
+
+	return &ValueInfo{
+		Value:       resp.stringValue(),
+		ContentType: resp.ContentType,
+		Compressed:  resp.Compressed,
+		Version:     resp.Version,
+	}, nil
+}
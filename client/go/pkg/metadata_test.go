@@ -0,0 +1,87 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-31 16:48:10
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-31 16:48:10
+* @Description: ConcordKV Go client value metadata tests
+ */
+
+package concord
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSetWithOptions 写请求携带内容类型与压缩标志，读取与扫描时返回元数据
+func TestSetWithOptions(t *testing.T) {
+	var mu sync.Mutex
+	var sets []map[string]interface{}
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/set":
+			var req map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&req)
+			mu.Lock()
+			sets = append(sets, req)
+			mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "index": 7, "compressed": req["compress"] == true})
+		case "/api/get":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"key": "doc", "exists": true, "value": "{}", "version": 7, "contentType": "application/json", "compressed": true,
+			})
+		case "/api/scan":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"keys": []string{"doc", "note"},
+				"items": []map[string]interface{}{
+					{"key": "doc", "value": "{}", "contentType": "application/json", "compressed": true},
+					{"key": "note", "value": "hi"},
+				},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer node.Close()
+
+	client, err := NewClient(Config{Endpoints: []string{node.URL}, RetryCount: 1, DisableSession: true, Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+
+	index, err := client.SetWithOptions("doc", "{}", SetOptions{ContentType: "application/json", Compress: true, TTL: time.Minute})
+	if err != nil || index != 7 {
+		t.Fatalf("SetWithOptions = %d, %v", index, err)
+	}
+	if _, err := client.SetWithOptions("note", "hi", SetOptions{}); err != nil {
+		t.Fatalf("SetWithOptions失败: %v", err)
+	}
+	if len(sets) != 2 || sets[0]["contentType"] != "application/json" || sets[0]["compress"] != true || sets[0]["ttlSeconds"] != float64(60) {
+		t.Fatalf("写请求 = %v", sets)
+	}
+	if _, ok := sets[1]["contentType"]; ok {
+		t.Errorf("未指定的选项不应出现在请求中: %v", sets[1])
+	}
+
+	info, err := client.GetWithMetadata("doc")
+	if err != nil {
+		t.Fatalf("GetWithMetadata失败: %v", err)
+	}
+	if *info != (ValueInfo{Value: "{}", ContentType: "application/json", Compressed: true, Version: 7}) {
+		t.Errorf("GetWithMetadata = %+v", info)
+	}
+
+	result, err := client.Scan(ScanOptions{WithValues: true})
+	if err != nil {
+		t.Fatalf("扫描失败: %v", err)
+	}
+	if len(result.Items) != 2 || result.Items[0] != (ScanItem{Key: "doc", Value: "{}", ContentType: "application/json", Compressed: true}) ||
+		result.Items[1] != (ScanItem{Key: "note", Value: "hi"}) {
+		t.Errorf("扫描结果 = %+v", result.Items)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-11 14:05:26
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-11 14:05:26
+* @Description: ConcordKV Go client metrics collectors
+ */
+
+package concord
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/concordkv/client/go/metrics"
+)
+
+// Metrics 获取客户端的指标注册表，已注册客户端重试统计和当前路由器的指标；
+// 应用可在此注册自己创建的连接池等组件
+func (c *Client) Metrics() *metrics.Registry {
+	return c.metrics
+}
+
+// newMetricsRegistry 创建客户端的指标注册表，路由器在抓取时按当前设置的路由器收集
+func (c *Client) newMetricsRegistry() *metrics.Registry {
+	registry := metrics.NewRegistry()
+	registry.Register("client", c)
+	registry.Register("router", metrics.CollectorFunc(func() []*metrics.Family {
+		c.mu.RLock()
+		collector, ok := c.router.(metrics.Collector)
+		c.mu.RUnlock()
+		if !ok {
+			return nil
+		}
+		return collector.Collect()
+	}))
+	return registry
+}
+
+// Collect 实现metrics.Collector，输出按错误分类的重试次数、预算耗尽和领导者刷新次数
+func (c *Client) Collect() []*metrics.Family {
+	retries := metrics.NewCounter("client_retries_total", "Request retries by error class.")
+	for class := ErrorClass(0); class < errorClassCount; class++ {
+		retries.With(float64(atomic.LoadInt64(&c.stats.retries[class])), "class", class.String())
+	}
+
+	return []*metrics.Family{
+		retries,
+		metrics.NewCounter("client_retry_budget_exhausted_total", "Operations that stopped retrying because the context deadline was too close.").
+			With(float64(atomic.LoadInt64(&c.stats.budgetExhausted))),
+		metrics.NewCounter("client_leader_refreshes_total", "Leader refreshes triggered by not-leader errors.").
+			With(float64(atomic.LoadInt64(&c.stats.leaderRefreshes))),
+		metrics.NewCounter("client_leader_discoveries_total", "Extra round trips spent finding the leader (not-leader responses and followed redirects).").
+			With(float64(atomic.LoadInt64(&c.stats.leaderDiscoveries))),
+		metrics.NewCounter("client_leader_hint_hits_total", "Writes sent straight to the cached leader.").
+			With(float64(atomic.LoadInt64(&c.stats.leaderHintHits))),
+		metrics.NewCounter("client_leader_event_updates_total", "Leader cache updates from leader-change events.").
+			With(float64(atomic.LoadInt64(&c.stats.leaderEventUpdates))),
+	}
+}
+
+// Collect 实现metrics.Collector，输出按策略的路由请求数、路由缓存命中情况与各节点的熔断器状态
+func (sr *SmartRouter) Collect() []*metrics.Family {
+	requests := metrics.NewCounter("router_requests_total", "Routing requests by strategy.")
+	for strategy := RoutingStrategy(0); strategy < routingStrategyCount; strategy++ {
+		requests.With(float64(atomic.LoadInt64(&sr.strategyRequests[strategy])), "strategy", strategy.String())
+	}
+
+	families := []*metrics.Family{
+		requests,
+		metrics.NewCounter("router_requests_failed_total", "Routing requests that found no eligible node.").
+			With(float64(atomic.LoadInt64(&sr.stats.FailedRequests))),
+		metrics.NewCounter("router_cache_hits_total", "Routing requests answered from the route cache.").
+			With(float64(atomic.LoadInt64(&sr.stats.CacheHits))),
+		metrics.NewCounter("router_cache_misses_total", "Routing requests not found in the route cache.").
+			With(float64(atomic.LoadInt64(&sr.stats.CacheMisses))),
+		metrics.NewCounter("router_cache_evictions_total", "Route cache entries evicted for capacity.").
+			With(float64(atomic.LoadInt64(&sr.stats.CacheEvictions))),
+		metrics.NewGauge("router_cache_entries", "Entries in the route cache.").
+			With(float64(sr.routeCache.Len())),
+	}
+
+	sr.mu.RLock()
+	nodes := make([]NodeID, 0, len(sr.circuitBreakers))
+	states := make(map[NodeID]CircuitBreakerState, len(sr.circuitBreakers))
+	for nodeID, breaker := range sr.circuitBreakers {
+		nodes = append(nodes, nodeID)
+		states[nodeID] = breaker.GetState()
+	}
+	sr.mu.RUnlock()
+
+	if len(nodes) == 0 {
+		return families
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+
+	circuitOpen := metrics.NewGauge("router_circuit_open", "Whether the node's circuit breaker rejects requests (1) or not (0).")
+	for _, nodeID := range nodes {
+		open := 0.0
+		if states[nodeID] == CircuitOpen {
+			open = 1
+		}
+		circuitOpen.With(open, metrics.LabelNodeID, string(nodeID))
+	}
+	return append(families, circuitOpen)
+}
+
+// Collect 实现metrics.Collector，输出连接池的连接数与请求数，以分片ID和节点ID区分
+func (cp *ConnectionPool) Collect() []*metrics.Family {
+	return poolFamilies([]*PoolStats{cp.GetStats()})
+}
+
+// Collect 实现metrics.Collector，输出每个分片每个节点的连接池指标；全局连接池的分片ID为空
+func (sacp *ShardAwareConnectionPool) Collect() []*metrics.Family {
+	sacp.mu.RLock()
+	pools := make([]*ConnectionPool, 0, len(sacp.shardPools)+1)
+	for _, pool := range sacp.shardPools {
+		pools = append(pools, pool)
+	}
+	if sacp.globalPool != nil {
+		pools = append(pools, sacp.globalPool)
+	}
+	sacp.mu.RUnlock()
+
+	stats := make([]*PoolStats, 0, len(pools))
+	for _, pool := range pools {
+		stats = append(stats, pool.GetStats())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].ShardID != stats[j].ShardID {
+			return stats[i].ShardID < stats[j].ShardID
+		}
+		return stats[i].NodeID < stats[j].NodeID
+	})
+	return poolFamilies(stats)
+}
+
+// poolFamilies 把连接池统计转换为指标族
+func poolFamilies(stats []*PoolStats) []*metrics.Family {
+	active := metrics.NewGauge("pool_active_connections", "Connections checked out of the pool.")
+	idle := metrics.NewGauge("pool_idle_connections", "Connections waiting in the pool.")
+	total := metrics.NewGauge("pool_total_connections", "Connections owned by the pool.")
+	waiting := metrics.NewGauge("pool_waiting_requests", "Requests waiting for a connection.")
+	requests := metrics.NewCounter("pool_requests_total", "Connection requests made to the pool.")
+	failed := metrics.NewCounter("pool_requests_failed_total", "Connection requests the pool could not satisfy.")
+	created := metrics.NewCounter("pool_connections_created_total", "Connections opened by the pool.")
+	destroyed := metrics.NewCounter("pool_connections_destroyed_total", "Connections closed by the pool.")
+
+	for _, s := range stats {
+		labels := []string{metrics.LabelShardID, s.ShardID, metrics.LabelNodeID, string(s.NodeID)}
+		active.With(float64(s.ActiveConnections), labels...)
+		idle.With(float64(s.IdleConnections), labels...)
+		total.With(float64(s.TotalConnections), labels...)
+		waiting.With(float64(s.WaitingRequests), labels...)
+		requests.With(float64(s.TotalRequests), labels...)
+		failed.With(float64(s.FailedRequests), labels...)
+		created.With(float64(s.ConnectionsCreated), labels...)
+		destroyed.With(float64(s.ConnectionsDestroyed), labels...)
+	}
+	return []*metrics.Family{active, idle, total, waiting, requests, failed, created, destroyed}
+}
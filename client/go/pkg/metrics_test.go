@@ -0,0 +1,114 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-11 14:05:26
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-11 14:05:26
+* @Description: ConcordKV Go client metrics collectors tests
+ */
+
+package concord
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scrapeMetrics 抓取客户端指标监听地址上的/metrics
+func scrapeMetrics(t *testing.T, client *Client) string {
+	t.Helper()
+
+	resp, err := http.Get("http://" + client.metricsListener.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatalf("抓取指标失败: %v", err)
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	return buf.String()
+}
+
+// TestClientMetricsListener 客户端在独立端口上暴露路由器按策略的请求数和重试次数；并发路由时计数不丢失
+func TestClientMetricsListener(t *testing.T) {
+	addr, _ := countingServer(t, http.StatusServiceUnavailable, "写请求过多，请稍后重试")
+	client, err := NewClient(Config{
+		Endpoints:         []string{addr},
+		DisableSession:    true,
+		RetryPolicy:       &RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+		MetricsListenAddr: "127.0.0.1:0",
+	})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+
+	router, _ := newTestRouter(time.Minute)
+	client.SetRouter(router)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				router.Route(&RoutingRequest{Key: "k", Strategy: RoutingReadReplica})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := client.Get("a"); err == nil {
+		t.Fatalf("服务端不可用时应返回错误")
+	}
+
+	out := scrapeMetrics(t, client)
+	for _, want := range []string{
+		"# TYPE router_requests_total counter\n",
+		`router_requests_total{strategy="ReadReplica"} 400` + "\n",
+		`router_requests_total{strategy="WritePrimary"} 0` + "\n",
+		`client_retries_total{class="Unavailable"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("输出缺少 %q:\n%s", want, out)
+		}
+	}
+	if stats := router.GetStats(); stats.StrategyStats[RoutingReadReplica] != 400 {
+		t.Errorf("GetStats的策略统计 = %v，期望400", stats.StrategyStats)
+	}
+}
+
+// TestConnectionPoolCollect 连接池指标以分片ID和节点ID区分
+func TestConnectionPoolCollect(t *testing.T) {
+	config := DefaultPoolConfig()
+	config.MinConnections = 1
+	config.MaxConnections = 4
+	config.InitialSize = 1
+	config.EnablePreWarm = false
+	config.EnableAutoScale = false
+	config.HealthCheckInterval = 0
+	pool := newTestPool(t, config, nil)
+
+	conn, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("获取连接失败: %v", err)
+	}
+	defer pool.Put(conn)
+
+	for _, family := range pool.Collect() {
+		if family.Name != "pool_active_connections" {
+			continue
+		}
+		sample := family.Samples[0]
+		if sample.Value != 1 || len(sample.Labels) != 2 ||
+			sample.Labels[0].Name != "shard_id" || sample.Labels[0].Value != "shard-0" ||
+			sample.Labels[1].Name != "node_id" || sample.Labels[1].Value != "node1" {
+			t.Fatalf("pool_active_connections样本不符: %+v", sample)
+		}
+		return
+	}
+	t.Fatalf("缺少pool_active_connections")
+}
@@ -0,0 +1,389 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-8 14:05:27
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-8 14:05:27
+* @Description: ConcordKV Go client batch operations (MGet/MSet/MDelete)
+ */
+
+package concord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// KeyRouter 把键路由到负责的节点，SmartRouter实现了该接口
+type KeyRouter interface {
+	Route(req *RoutingRequest) (*RoutingResult, error)
+}
+
+// SetRouter 设置键的路由器：单键操作先请求路由到的目标节点，失败后重试备用节点；
+// 批量操作按路由结果把键分组，每个节点发送一个请求
+// 路由器需同时实现NodeAddressResolver（如SmartRouter）才能把节点解析为地址；
+// 无法路由或解析地址的键发往配置的节点，由服务端转发
+func (c *Client) SetRouter(router KeyRouter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.router = router
+}
+
+// MultiError 批量操作中失败的键及其错误，其余键的操作已成功
+type MultiError struct {
+	Errors map[string]error
+}
+
+// Error 按键排序列出前几个失败的键
+func (e *MultiError) Error() string {
+	keys := make([]string, 0, len(e.Errors))
+	for key := range e.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	const shown = 3
+	parts := make([]string, 0, shown)
+	for _, key := range keys {
+		if len(parts) == shown {
+			break
+		}
+		parts = append(parts, fmt.Sprintf("%s: %v", key, e.Errors[key]))
+	}
+	msg := fmt.Sprintf("批量操作有%d个键失败: %s", len(keys), strings.Join(parts, "; "))
+	if len(keys) > shown {
+		msg += " ..."
+	}
+	return msg
+}
+
+// Unwrap 返回各键的错误，errors.Is可以匹配其中任意一个
+func (e *MultiError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// multiErrors 并发收集各键的错误
+type multiErrors struct {
+	mu   sync.Mutex
+	errs map[string]error
+}
+
+func (m *multiErrors) add(key string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.errs == nil {
+		m.errs = make(map[string]error)
+	}
+	m.errs[key] = err
+}
+
+// err 没有失败的键时返回nil，否则返回*MultiError
+func (m *multiErrors) err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.errs) == 0 {
+		return nil
+	}
+	return &MultiError{Errors: m.errs}
+}
+
+// keyGroup 路由到同一节点的键，route为目标节点及备用节点，为空时发往配置的节点
+type keyGroup struct {
+	route []*connection
+	keys  []string
+}
+
+// routeKey 返回键的目标节点及备用节点的连接，未设置路由器或无法路由时返回nil
+func (c *Client) routeKey(ctx context.Context, key string, strategy RoutingStrategy) []*connection {
+	c.mu.RLock()
+	router := c.router
+	c.mu.RUnlock()
+	resolver, ok := router.(NodeAddressResolver)
+	if !ok {
+		return nil
+	}
+
+	result, err := router.Route(&RoutingRequest{
+		Key:           key,
+		Strategy:      strategy,
+		ReadOnly:      strategy != RoutingWritePrimary,
+		PreferredDC:   c.config.LocalDC,
+		PreferredZone: c.config.LocalZone,
+		Context:       ctx,
+	})
+	if err != nil {
+		return nil
+	}
+	address, err := resolver.Resolve(result.TargetNode)
+	if err != nil {
+		return nil
+	}
+
+	route := []*connection{newConnection(address)}
+	for _, backup := range result.BackupNodes {
+		if address, err := resolver.Resolve(backup); err == nil {
+			route = append(route, newConnection(address))
+		}
+	}
+	return route
+}
+
+// routeKeys 按路由结果把键分组到目标节点，组内保持键的顺序
+func (c *Client) routeKeys(ctx context.Context, keys []string, strategy RoutingStrategy) []*keyGroup {
+	byAddress := make(map[string]*keyGroup)
+	var groups []*keyGroup
+	for _, key := range keys {
+		route := c.routeKey(ctx, key, strategy)
+		address := ""
+		if len(route) > 0 {
+			address = route[0].baseURL
+		}
+
+		group, ok := byAddress[address]
+		if !ok {
+			group = &keyGroup{route: route}
+			byAddress[address] = group
+			groups = append(groups, group)
+		}
+		group.keys = append(group.keys, key)
+	}
+	return groups
+}
+
+// parallel 以不超过BatchParallelism的并发度执行fn(0..n-1)
+func (c *Client) parallel(n int, fn func(i int)) {
+	sem := make(chan struct{}, c.config.BatchParallelism)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// uniqueKeys 去除重复的键，保持首次出现的顺序
+func uniqueKeys(keys []string) []string {
+	seen := make(map[string]struct{}, len(keys))
+	unique := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		unique = append(unique, key)
+	}
+	return unique
+}
+
+// MGet 批量获取多个键的值，返回存在的键及其值
+// 键按路由结果分组到节点后并发获取；/api/batch只接受写操作，因此读取总是使用单键请求
+// 不存在的键不出现在结果中，也不视为错误；其余失败的键以*MultiError返回，结果中的其他键仍然有效
+func (c *Client) MGet(keys []string) (map[string]string, error) {
+	return c.MGetCtx(context.Background(), keys)
+}
+
+// MGetCtx 批量获取多个键的值，ctx结束后未完成的键以ctx.Err()计入*MultiError
+func (c *Client) MGetCtx(ctx context.Context, keys []string) (map[string]string, error) {
+	type getTask struct {
+		route []*connection
+		key   string
+	}
+
+	var tasks []getTask
+	for _, group := range c.routeKeys(ctx, uniqueKeys(keys), RoutingReadNearest) {
+		for _, key := range group.keys {
+			tasks = append(tasks, getTask{route: group.route, key: key})
+		}
+	}
+
+	values := make(map[string]string, len(tasks))
+	var mu sync.Mutex
+	var errs multiErrors
+	c.parallel(len(tasks), func(i int) {
+		value, err := c.get(ctx, tasks[i].route, tasks[i].key)
+		switch {
+		case errors.Is(err, ErrKeyNotFound):
+		case err != nil:
+			errs.add(tasks[i].key, err)
+		default:
+			mu.Lock()
+			values[tasks[i].key] = value
+			mu.Unlock()
+		}
+	})
+
+	return values, errs.err()
+}
+
+// MSet 批量设置多个键值对
+// 键按路由结果分组到节点，每个节点发送一个/api/batch请求（超过MaxBatchSize时拆分）；
+// 服务端不支持/api/batch时退回到并发的单键请求。失败的键以*MultiError返回，其他键已写入
+func (c *Client) MSet(pairs map[string]string) error {
+	return c.MSetCtx(context.Background(), pairs)
+}
+
+// MSetCtx 批量设置多个键值对，ctx语义同MGetCtx；ctx结束时已发出的写入可能已被应用
+func (c *Client) MSetCtx(ctx context.Context, pairs map[string]string) error {
+	keys := make([]string, 0, len(pairs))
+	for key := range pairs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ops := make(map[string]batchOp, len(keys))
+	for _, key := range keys {
+		ops[key] = batchOp{Op: "set", Key: key, Value: pairs[key]}
+	}
+	return c.writeMulti(ctx, keys, ops)
+}
+
+// MDelete 批量删除多个键，分组与失败处理与MSet相同；删除不存在的键视为成功
+func (c *Client) MDelete(keys []string) error {
+	return c.MDeleteCtx(context.Background(), keys)
+}
+
+// MDeleteCtx 批量删除多个键，ctx语义同MSetCtx
+func (c *Client) MDeleteCtx(ctx context.Context, keys []string) error {
+	keys = uniqueKeys(keys)
+
+	ops := make(map[string]batchOp, len(keys))
+	for _, key := range keys {
+		ops[key] = batchOp{Op: "delete", Key: key}
+	}
+	return c.writeMulti(ctx, keys, ops)
+}
+
+// batchOp /api/batch中的单个操作，与服务端的BatchOperation一致
+type batchOp struct {
+	Op         string `json:"op"`
+	Key        string `json:"key"`
+	Value      string `json:"value"`
+	TTLSeconds int64  `json:"ttlSeconds,omitempty"`
+}
+
+// batchResponse /api/batch的响应，Results与请求中的操作一一对应
+type batchResponse struct {
+	Success bool   `json:"success"`
+	Index   uint64 `json:"index"`
+	Results []struct {
+		Key     string `json:"key"`
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	} `json:"results"`
+}
+
+// batchChunk 发往同一节点的一个/api/batch请求
+type batchChunk struct {
+	route []*connection
+	ops   []batchOp
+}
+
+// writeMulti 按节点分组发送批量写操作，服务端不支持/api/batch的操作改用单键请求
+func (c *Client) writeMulti(ctx context.Context, keys []string, ops map[string]batchOp) error {
+	var chunks []batchChunk
+	for _, group := range c.routeKeys(ctx, keys, RoutingWritePrimary) {
+		for start := 0; start < len(group.keys); start += c.config.MaxBatchSize {
+			end := start + c.config.MaxBatchSize
+			if end > len(group.keys) {
+				end = len(group.keys)
+			}
+			chunk := batchChunk{route: group.route}
+			for _, key := range group.keys[start:end] {
+				chunk.ops = append(chunk.ops, ops[key])
+			}
+			chunks = append(chunks, chunk)
+		}
+	}
+
+	var errs multiErrors
+	var mu sync.Mutex
+	var fallback []batchChunk
+	c.parallel(len(chunks), func(i int) {
+		if !c.writeChunk(ctx, chunks[i], &errs) {
+			mu.Lock()
+			fallback = append(fallback, chunks[i])
+			mu.Unlock()
+		}
+	})
+
+	type writeTask struct {
+		route []*connection
+		op    batchOp
+	}
+	var tasks []writeTask
+	for _, chunk := range fallback {
+		for _, op := range chunk.ops {
+			tasks = append(tasks, writeTask{route: chunk.route, op: op})
+		}
+	}
+	c.parallel(len(tasks), func(i int) {
+		var err error
+		if tasks[i].op.Op == "set" {
+			_, err = c.setWithTTL(ctx, tasks[i].route, tasks[i].op.Key, tasks[i].op.Value, 0)
+		} else {
+			err = c.delete(ctx, tasks[i].route, tasks[i].op.Key)
+		}
+		if err != nil {
+			errs.add(tasks[i].op.Key, err)
+		}
+	})
+
+	return errs.err()
+}
+
+// writeChunk 通过/api/batch发送一组写操作并记录失败的键
+// 服务端不支持/api/batch时返回false，由调用方改用单键请求
+func (c *Client) writeChunk(ctx context.Context, chunk batchChunk, errs *multiErrors) bool {
+	if atomic.LoadInt32(&c.batchUnsupported) == 1 {
+		return false
+	}
+
+	var resp batchResponse
+	err := c.doWriteTo(ctx, chunk.route, http.MethodPost, "/api/batch", chunk.ops, &resp)
+	if errors.Is(err, ErrUnsupported) {
+		atomic.StoreInt32(&c.batchUnsupported, 1)
+		return false
+	}
+	if err == nil && len(resp.Results) != len(chunk.ops) {
+		err = fmt.Errorf("批量响应包含%d个结果，请求有%d个操作", len(resp.Results), len(chunk.ops))
+	}
+	if err != nil {
+		for _, op := range chunk.ops {
+			errs.add(op.Key, err)
+		}
+		return true
+	}
+
+	for i, op := range chunk.ops {
+		if result := resp.Results[i]; !result.Success {
+			errs.add(op.Key, errors.New(result.Error))
+			continue
+		}
+		if c.cache != nil {
+			if op.Op == "set" {
+				c.cache.Set(op.Key, op.Value, c.cacheTTL(0))
+			} else {
+				c.cache.Delete(op.Key)
+			}
+		}
+	}
+	return true
+}
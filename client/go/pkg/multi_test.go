@@ -0,0 +1,245 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-8 14:05:27
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-8 14:05:27
+* @Description: ConcordKV Go client batch operations tests
+ */
+
+package concord
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/concordkv/client/go/keyhash"
+)
+
+// fakeKVNode 模拟单个节点的键值接口，记录每个接口收到的请求数
+type fakeKVNode struct {
+	mu       sync.Mutex
+	store    map[string]string
+	requests map[string]int
+	failKeys map[string]bool // 对这些键的操作返回错误
+	noBatch  bool            // 模拟不支持/api/batch的旧版本服务端
+}
+
+func newFakeKVNode(t *testing.T, failKeys map[string]bool, noBatch bool) (*fakeKVNode, string) {
+	node := &fakeKVNode{
+		store:    make(map[string]string),
+		requests: make(map[string]int),
+		failKeys: failKeys,
+		noBatch:  noBatch,
+	}
+	server := httptest.NewServer(node)
+	t.Cleanup(server.Close)
+	return node, server.URL
+}
+
+func (n *fakeKVNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.requests[r.URL.Path]++
+
+	switch r.URL.Path {
+	case "/api/get":
+		key := r.URL.Query().Get("key")
+		if n.failKeys[key] {
+			http.Error(w, "模拟失败", http.StatusInternalServerError)
+			return
+		}
+		value, exists := n.store[key]
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "exists": exists, "value": value})
+	case "/api/set":
+		var req request
+		json.NewDecoder(r.Body).Decode(&req)
+		if n.failKeys[req.Key] {
+			http.Error(w, "模拟失败", http.StatusInternalServerError)
+			return
+		}
+		n.store[req.Key] = req.Value
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+	case "/api/delete":
+		delete(n.store, r.URL.Query().Get("key"))
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+	case "/api/batch":
+		if n.noBatch {
+			http.NotFound(w, r)
+			return
+		}
+		var ops []batchOp
+		json.NewDecoder(r.Body).Decode(&ops)
+		results := make([]map[string]interface{}, len(ops))
+		for i, op := range ops {
+			results[i] = map[string]interface{}{"op": op.Op, "key": op.Key, "success": !n.failKeys[op.Key]}
+			switch {
+			case n.failKeys[op.Key]:
+				results[i]["error"] = "模拟失败"
+			case op.Op == "set":
+				n.store[op.Key] = op.Value
+			default:
+				delete(n.store, op.Key)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "results": results})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (n *fakeKVNode) requestCount(path string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.requests[path]
+}
+
+func (n *fakeKVNode) keys() map[string]string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	keys := make(map[string]string, len(n.store))
+	for key, value := range n.store {
+		keys[key] = value
+	}
+	return keys
+}
+
+// newShardedClient 创建路由到两个节点的客户端：哈希环下半部分属于node1，上半部分属于node2
+func newShardedClient(t *testing.T, failKeys map[string]bool, noBatch bool) (*Client, *fakeKVNode, *fakeKVNode) {
+	node1, addr1 := newFakeKVNode(t, failKeys, noBatch)
+	node2, addr2 := newFakeKVNode(t, failKeys, noBatch)
+
+	cache := NewTopologyCache(nil)
+	cache.Set(&ShardInfo{ID: "shard-low", Range: ShardRange{StartHash: 0, EndHash: 1 << 63}, Primary: "node1", Version: 1})
+	cache.Set(&ShardInfo{ID: "shard-high", Range: ShardRange{StartHash: 1 << 63, EndHash: 0}, Primary: "node2", Version: 1})
+
+	routerConfig := DefaultSmartRouterConfig()
+	routerConfig.HealthCheckInterval = 0
+	routerConfig.NodeAddresses = map[NodeID]string{"node1": addr1, "node2": addr2}
+
+	client, err := NewClient(Config{Endpoints: []string{addr1}, RetryCount: 1, DisableSession: true, MaxBatchSize: 1000})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	client.SetRouter(NewSmartRouter(routerConfig, cache))
+	return client, node1, node2
+}
+
+func testPairs(n int) map[string]string {
+	pairs := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		pairs[fmt.Sprintf("key-%03d", i)] = fmt.Sprintf("value-%03d", i)
+	}
+	return pairs
+}
+
+// TestMultiShardSplit 键按分片分组，每个节点只收到一个批量请求且只存储自己分片的键
+func TestMultiShardSplit(t *testing.T) {
+	client, node1, node2 := newShardedClient(t, nil, false)
+
+	pairs := testPairs(200)
+	if err := client.MSet(pairs); err != nil {
+		t.Fatalf("MSet失败: %v", err)
+	}
+	if node1.requestCount("/api/batch") != 1 || node2.requestCount("/api/batch") != 1 {
+		t.Fatalf("每个节点应只收到一个批量请求: node1=%d node2=%d", node1.requestCount("/api/batch"), node2.requestCount("/api/batch"))
+	}
+
+	low, high := node1.keys(), node2.keys()
+	if len(low) == 0 || len(high) == 0 || len(low)+len(high) != len(pairs) {
+		t.Fatalf("键应分布在两个节点上: node1=%d node2=%d", len(low), len(high))
+	}
+	for key := range low {
+		if keyhash.Canonical.Sum64(key) >= 1<<63 {
+			t.Fatalf("键 %s 不属于node1的分片", key)
+		}
+	}
+
+	keys := make([]string, 0, len(pairs)+1)
+	for key := range pairs {
+		keys = append(keys, key)
+	}
+	values, err := client.MGet(append(keys, "missing"))
+	if err != nil {
+		t.Fatalf("MGet失败: %v", err)
+	}
+	if len(values) != len(pairs) || values["key-042"] != "value-042" {
+		t.Fatalf("MGet结果不正确: %d 个键", len(values))
+	}
+	expectedReads := len(high)
+	if keyhash.Canonical.Sum64("missing") >= 1<<63 {
+		expectedReads++
+	}
+	if node2.requestCount("/api/get") != expectedReads {
+		t.Fatalf("node2应只收到其分片键的读取: %d != %d", node2.requestCount("/api/get"), expectedReads)
+	}
+
+	if err := client.MDelete(keys); err != nil {
+		t.Fatalf("MDelete失败: %v", err)
+	}
+	if len(node1.keys())+len(node2.keys()) != 0 {
+		t.Fatalf("MDelete后仍有键残留")
+	}
+}
+
+// TestMultiPartialFailure 部分键失败时返回MultiError，其他键的操作仍然生效
+func TestMultiPartialFailure(t *testing.T) {
+	failKeys := map[string]bool{"key-003": true, "key-007": true}
+	client, node1, node2 := newShardedClient(t, failKeys, false)
+
+	pairs := testPairs(10)
+	err := client.MSet(pairs)
+	var multiErr *MultiError
+	if !errors.As(err, &multiErr) {
+		t.Fatalf("应返回MultiError，实际 %v", err)
+	}
+	if len(multiErr.Errors) != 2 || multiErr.Errors["key-003"] == nil || multiErr.Errors["key-007"] == nil {
+		t.Fatalf("失败的键不正确: %v", multiErr)
+	}
+	if stored := len(node1.keys()) + len(node2.keys()); stored != 8 {
+		t.Fatalf("其他8个键应已写入，实际 %d", stored)
+	}
+
+	values, err := client.MGet([]string{"key-001", "key-003", "key-009", "missing"})
+	if !errors.As(err, &multiErr) || len(multiErr.Errors) != 1 || multiErr.Errors["key-003"] == nil {
+		t.Fatalf("MGet应只报告key-003失败: %v", err)
+	}
+	if len(values) != 2 || values["key-001"] != "value-001" || values["key-009"] != "value-009" {
+		t.Fatalf("其他键的结果应仍然有效: %v", values)
+	}
+}
+
+// TestMultiFallbackWithoutBatch 服务端不支持/api/batch时退回到单键请求，之后不再尝试批量接口
+func TestMultiFallbackWithoutBatch(t *testing.T) {
+	node, addr := newFakeKVNode(t, nil, true)
+	client, err := NewClient(Config{Endpoints: []string{addr}, RetryCount: 1, DisableSession: true})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+
+	pairs := testPairs(20)
+	if err := client.MSet(pairs); err != nil {
+		t.Fatalf("MSet失败: %v", err)
+	}
+	if node.requestCount("/api/set") != len(pairs) || len(node.keys()) != len(pairs) {
+		t.Fatalf("应通过单键请求写入所有键: set=%d stored=%d", node.requestCount("/api/set"), len(node.keys()))
+	}
+
+	batches := node.requestCount("/api/batch")
+	if err := client.MDelete([]string{"key-001", "key-002", "key-001"}); err != nil {
+		t.Fatalf("MDelete失败: %v", err)
+	}
+	if node.requestCount("/api/batch") != batches {
+		t.Fatalf("检测到不支持后不应再请求/api/batch")
+	}
+	if node.requestCount("/api/delete") != 2 || len(node.keys()) != len(pairs)-2 {
+		t.Fatalf("应删除去重后的两个键: delete=%d", node.requestCount("/api/delete"))
+	}
+}
@@ -0,0 +1,292 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-2 22:24:02
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-2 22:24:05
+* @Description: ConcordKV intelligent client - connection request pipelining
+ */
+
+package concord
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// 管道化帧格式，请求与响应相同，整数均为大端序：
+//
+//	uint32 帧体长度 | uint32 消息数 | 消息数 × (uint64 序号 | uint32 负载长度 | 负载)
+//
+// 未启用批量处理时每帧只有一条消息；服务端按序号回应，响应可以乱序，也可以合并在同一帧中
+const (
+	frameHeaderSize   = 8        // 帧体长度 + 消息数
+	messageHeaderSize = 12       // 序号 + 负载长度
+	maxFrameSize      = 64 << 20 // 单帧最大长度
+	batchSmallPayload = 4 << 10  // 不超过该大小的请求才会等待合并
+)
+
+// ErrPipelineClosed 连接已关闭，未完成的管道化请求不会再收到响应
+var ErrPipelineClosed = errors.New("管道已关闭")
+
+// Response 管道化请求的响应
+type Response struct {
+	Payload []byte // 响应负载
+	Err     error  // 连接失败时的错误，此时Payload为空
+}
+
+// pipelineMessage 帧中的一条消息
+type pipelineMessage struct {
+	seq     uint64
+	payload []byte
+}
+
+// pipeline 连接上的管道化请求状态：写协程按提交顺序写出请求，读协程按序号把响应交给调用方
+type pipeline struct {
+	conn         net.Conn
+	maxInFlight  int           // 最大未完成请求数
+	batchSize    int           // 单帧最多合并的请求数，1表示不合并
+	batchTimeout time.Duration // 等待更多请求合并的时间
+
+	slots chan struct{}         // 未完成请求的名额，满时提交方阻塞
+	queue chan *pipelineMessage // 待写出的请求
+
+	mu      sync.Mutex
+	nextSeq uint64
+	pending map[uint64]chan Response // 序号 -> 等待响应的调用方
+	err     error                    // 管道失败原因，非nil后不再接受请求
+	done    chan struct{}            // 管道失败时关闭
+}
+
+// newPipeline 按连接池配置在连接上启动管道，config为nil或未启用管道化时按请求-响应模式发送
+func newPipeline(conn net.Conn, config *PoolConfig) *pipeline {
+	maxInFlight, batchSize := 1, 1
+	var batchTimeout time.Duration
+	if config != nil {
+		if config.EnablePipelining && config.MaxPipelineSize > 1 {
+			maxInFlight = config.MaxPipelineSize
+		}
+		// 未完成请求数不超过maxInFlight，更大的批次永远凑不满
+		if config.EnableBatching && config.BatchSize > 1 && config.BatchTimeout > 0 && maxInFlight > 1 {
+			batchSize = config.BatchSize
+			if batchSize > maxInFlight {
+				batchSize = maxInFlight
+			}
+			batchTimeout = config.BatchTimeout
+		}
+	}
+
+	p := &pipeline{
+		conn:         conn,
+		maxInFlight:  maxInFlight,
+		batchSize:    batchSize,
+		batchTimeout: batchTimeout,
+		slots:        make(chan struct{}, maxInFlight),
+		queue:        make(chan *pipelineMessage, maxInFlight),
+		pending:      make(map[uint64]chan Response),
+		done:         make(chan struct{}),
+	}
+
+	go p.writeLoop()
+	go p.readLoop()
+
+	return p
+}
+
+// send 提交请求，未完成请求数达到上限时阻塞直到有响应返回或ctx结束
+func (p *pipeline) send(ctx context.Context, payload []byte) (<-chan Response, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-p.done:
+		return nil, p.failure()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	if p.err != nil {
+		err := p.err
+		p.mu.Unlock()
+		<-p.slots
+		return nil, err
+	}
+	p.nextSeq++
+	msg := &pipelineMessage{seq: p.nextSeq, payload: payload}
+	ch := make(chan Response, 1)
+	p.pending[msg.seq] = ch
+	p.mu.Unlock()
+
+	// 每个排队的请求都占用一个名额，队列容量等于名额数，这里不会阻塞
+	p.queue <- msg
+
+	return ch, nil
+}
+
+// failure 管道失败的原因，正常运行时返回nil
+func (p *pipeline) failure() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// fail 标记管道失败，让所有未完成的请求以err结束并关闭底层连接
+func (p *pipeline) fail(err error) {
+	p.mu.Lock()
+	if p.err != nil {
+		p.mu.Unlock()
+		return
+	}
+	p.err = err
+	pending := p.pending
+	p.pending = make(map[uint64]chan Response)
+	close(p.done)
+	p.mu.Unlock()
+
+	for _, ch := range pending {
+		ch <- Response{Err: err}
+	}
+	p.conn.Close()
+}
+
+// close 关闭管道
+func (p *pipeline) close() {
+	p.fail(ErrPipelineClosed)
+}
+
+// writeLoop 按提交顺序写出请求，启用批量处理时把等待时间内到达的小请求合并为一帧
+func (p *pipeline) writeLoop() {
+	batch := make([]*pipelineMessage, 0, p.batchSize)
+	for {
+		select {
+		case msg := <-p.queue:
+			batch = append(batch[:0], msg)
+			if p.batchSize > 1 && len(msg.payload) <= batchSmallPayload {
+				batch = p.collectBatch(batch)
+			}
+			if err := writeFrame(p.conn, batch); err != nil {
+				p.fail(fmt.Errorf("写入管道请求失败: %w", err))
+				return
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// collectBatch 在batchTimeout内继续收集请求，直到凑满batchSize或遇到大请求
+func (p *pipeline) collectBatch(batch []*pipelineMessage) []*pipelineMessage {
+	timer := time.NewTimer(p.batchTimeout)
+	defer timer.Stop()
+
+	for len(batch) < p.batchSize {
+		select {
+		case msg := <-p.queue:
+			batch = append(batch, msg)
+			if len(msg.payload) > batchSmallPayload {
+				return batch
+			}
+		case <-timer.C:
+			return batch
+		case <-p.done:
+			return batch
+		}
+	}
+	return batch
+}
+
+// readLoop 读取响应帧并按序号交给对应的调用方
+func (p *pipeline) readLoop() {
+	r := bufio.NewReader(p.conn)
+	for {
+		msgs, err := readFrame(r)
+		if err != nil {
+			p.fail(fmt.Errorf("读取管道响应失败: %w", err))
+			return
+		}
+		for _, msg := range msgs {
+			p.deliver(msg)
+		}
+	}
+}
+
+// deliver 把响应交给调用方并释放名额，未知序号的响应直接丢弃
+func (p *pipeline) deliver(msg *pipelineMessage) {
+	p.mu.Lock()
+	ch, ok := p.pending[msg.seq]
+	delete(p.pending, msg.seq)
+	p.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	ch <- Response{Payload: msg.payload}
+	<-p.slots
+}
+
+// writeFrame 把一组消息编码为一帧写出
+func writeFrame(w io.Writer, msgs []*pipelineMessage) error {
+	size := frameHeaderSize
+	for _, msg := range msgs {
+		size += messageHeaderSize + len(msg.payload)
+	}
+	if size-4 > maxFrameSize {
+		return fmt.Errorf("帧长度 %d 超过上限 %d", size-4, maxFrameSize)
+	}
+
+	buf := make([]byte, size)
+	binary.BigEndian.PutUint32(buf[0:], uint32(size-4))
+	binary.BigEndian.PutUint32(buf[4:], uint32(len(msgs)))
+	off := frameHeaderSize
+	for _, msg := range msgs {
+		binary.BigEndian.PutUint64(buf[off:], msg.seq)
+		binary.BigEndian.PutUint32(buf[off+8:], uint32(len(msg.payload)))
+		off += messageHeaderSize
+		off += copy(buf[off:], msg.payload)
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// readFrame 读取并解码一帧
+func readFrame(r io.Reader) ([]*pipelineMessage, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[0:])
+	count := binary.BigEndian.Uint32(header[4:])
+	if size < 4 || size > maxFrameSize || uint64(count)*messageHeaderSize > uint64(size-4) {
+		return nil, fmt.Errorf("帧长度 %d 与消息数 %d 无效", size, count)
+	}
+
+	body := make([]byte, size-4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	msgs := make([]*pipelineMessage, 0, count)
+	for i := uint32(0); i < count; i++ {
+		if len(body) < messageHeaderSize {
+			return nil, errors.New("帧内消息头不完整")
+		}
+		seq := binary.BigEndian.Uint64(body[0:])
+		n := binary.BigEndian.Uint32(body[8:])
+		body = body[messageHeaderSize:]
+		if uint32(len(body)) < n {
+			return nil, errors.New("帧内消息负载不完整")
+		}
+		msgs = append(msgs, &pipelineMessage{seq: seq, payload: body[:n:n]})
+		body = body[n:]
+	}
+	if len(body) != 0 {
+		return nil, errors.New("帧内有多余数据")
+	}
+	return msgs, nil
+}
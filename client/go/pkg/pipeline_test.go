@@ -0,0 +1,391 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-2 22:24:02
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-2 22:24:05
+* @Description: ConcordKV intelligent client - connection request pipelining tests
+ */
+
+package concord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipelineConfig 只启用管道化的连接池配置
+func pipelineConfig(maxPipeline int) *PoolConfig {
+	config := DefaultPoolConfig()
+	config.EnablePipelining = maxPipeline > 1
+	config.MaxPipelineSize = maxPipeline
+	config.EnableBatching = false
+	return config
+}
+
+// newFrameServer 启动进程内服务端，每个连接交给handle处理，返回已连接并设置好管道配置的连接
+func newFrameServer(t *testing.T, config *PoolConfig, handle func(conn net.Conn)) *Connection {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			go handle(conn)
+		}
+	}()
+
+	conn := NewConnection("pipe-1", "node1", "shard-0", listener.Addr().String(), time.Second)
+	conn.SetPipelineConfig(config)
+	if err := conn.Connect(context.Background()); err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// echoServer 原样回写收到的字节，响应帧与请求帧相同
+func echoServer(conn net.Conn) {
+	io.Copy(conn, conn)
+}
+
+// frameRecorder 记录服务端收到的帧，并在收到足够的消息后按逆序回应
+type frameRecorder struct {
+	mu     sync.Mutex
+	frames [][]*pipelineMessage
+}
+
+func (r *frameRecorder) frameSizes() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sizes := make([]int, len(r.frames))
+	for i, f := range r.frames {
+		sizes[i] = len(f)
+	}
+	return sizes
+}
+
+// reverseServer 凑满n条消息后按逆序逐条回应，用于验证响应按序号而不是按顺序匹配
+func (r *frameRecorder) reverseServer(n int) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		var held []*pipelineMessage
+		for {
+			msgs, err := readFrame(conn)
+			if err != nil {
+				return
+			}
+			r.mu.Lock()
+			r.frames = append(r.frames, msgs)
+			r.mu.Unlock()
+
+			held = append(held, msgs...)
+			if len(held) < n {
+				continue
+			}
+			for i := len(held) - 1; i >= 0; i-- {
+				if err := writeFrame(conn, held[i:i+1]); err != nil {
+					return
+				}
+			}
+			held = nil
+		}
+	}
+}
+
+// awaitResponse 等待响应，超时视为测试失败
+func awaitResponse(t *testing.T, ch <-chan Response) Response {
+	t.Helper()
+	select {
+	case resp := <-ch:
+		return resp
+	case <-time.After(2 * time.Second):
+		t.Fatal("等待响应超时")
+		return Response{}
+	}
+}
+
+// TestConnectionSendRequestEcho 管道化请求经回显服务端返回各自的负载
+func TestConnectionSendRequestEcho(t *testing.T) {
+	conn := newFrameServer(t, pipelineConfig(8), echoServer)
+
+	chans := make([]<-chan Response, 20)
+	for i := range chans {
+		ch, err := conn.SendRequest(context.Background(), []byte(fmt.Sprintf("req-%d", i)))
+		if err != nil {
+			t.Fatalf("发送请求 %d 失败: %v", i, err)
+		}
+		chans[i] = ch
+	}
+	for i, ch := range chans {
+		resp := awaitResponse(t, ch)
+		if resp.Err != nil || string(resp.Payload) != fmt.Sprintf("req-%d", i) {
+			t.Fatalf("请求 %d 的响应错误: %q %v", i, resp.Payload, resp.Err)
+		}
+	}
+}
+
+// TestConnectionSendRequestPipelined 请求不等待之前的响应就写出，乱序响应按序号匹配
+func TestConnectionSendRequestPipelined(t *testing.T) {
+	recorder := &frameRecorder{}
+	conn := newFrameServer(t, pipelineConfig(4), recorder.reverseServer(4))
+
+	chans := make([]<-chan Response, 4)
+	for i := range chans {
+		ch, err := conn.SendRequest(context.Background(), []byte(fmt.Sprintf("req-%d", i)))
+		if err != nil {
+			t.Fatalf("发送请求 %d 失败: %v", i, err)
+		}
+		chans[i] = ch
+	}
+
+	// 服务端收到全部4个请求后才回应，说明请求没有等待之前的响应
+	for i, ch := range chans {
+		resp := awaitResponse(t, ch)
+		if resp.Err != nil || string(resp.Payload) != fmt.Sprintf("req-%d", i) {
+			t.Fatalf("请求 %d 的响应错误: %q %v", i, resp.Payload, resp.Err)
+		}
+	}
+	if sizes := recorder.frameSizes(); len(sizes) != 4 {
+		t.Fatalf("未启用批量处理时每个请求应单独成帧: %v", sizes)
+	}
+}
+
+// TestConnectionSendRequestBackpressure 未完成请求达到MaxPipelineSize后提交方阻塞
+func TestConnectionSendRequestBackpressure(t *testing.T) {
+	recorder := &frameRecorder{}
+	conn := newFrameServer(t, pipelineConfig(2), recorder.reverseServer(3))
+
+	first, err := conn.SendRequest(context.Background(), []byte("a"))
+	if err != nil {
+		t.Fatalf("发送请求失败: %v", err)
+	}
+	if _, err := conn.SendRequest(context.Background(), []byte("b")); err != nil {
+		t.Fatalf("发送请求失败: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := conn.SendRequest(ctx, []byte("c")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("超过管道上限时应阻塞直到ctx结束，实际 %v", err)
+	}
+
+	// 服务端凑满3个请求才回应，名额一直被占满，阻塞的请求不会写出
+	time.Sleep(20 * time.Millisecond)
+	if sizes := recorder.frameSizes(); len(sizes) != 2 {
+		t.Fatalf("阻塞的请求不应写出: %v", sizes)
+	}
+
+	select {
+	case resp := <-first:
+		t.Fatalf("服务端尚未回应，不应收到响应: %+v", resp)
+	default:
+	}
+}
+
+// TestConnectionSendRequestSerial 未启用管道化时同一时刻只有一个未完成请求
+func TestConnectionSendRequestSerial(t *testing.T) {
+	conn := newFrameServer(t, pipelineConfig(1), echoServer)
+
+	first, err := conn.SendRequest(context.Background(), []byte("a"))
+	if err != nil {
+		t.Fatalf("发送请求失败: %v", err)
+	}
+	awaitResponse(t, first)
+
+	second, err := conn.SendRequest(context.Background(), []byte("b"))
+	if err != nil {
+		t.Fatalf("发送请求失败: %v", err)
+	}
+	if resp := awaitResponse(t, second); string(resp.Payload) != "b" {
+		t.Fatalf("响应错误: %q %v", resp.Payload, resp.Err)
+	}
+}
+
+// TestConnectionSendRequestBatching 等待时间内提交的小请求合并为一帧
+func TestConnectionSendRequestBatching(t *testing.T) {
+	config := pipelineConfig(8)
+	config.EnableBatching = true
+	config.BatchSize = 8
+	config.BatchTimeout = 100 * time.Millisecond
+
+	recorder := &frameRecorder{}
+	conn := newFrameServer(t, config, recorder.reverseServer(5))
+
+	chans := make([]<-chan Response, 5)
+	for i := range chans {
+		ch, err := conn.SendRequest(context.Background(), []byte(fmt.Sprintf("req-%d", i)))
+		if err != nil {
+			t.Fatalf("发送请求 %d 失败: %v", i, err)
+		}
+		chans[i] = ch
+	}
+	for i, ch := range chans {
+		resp := awaitResponse(t, ch)
+		if resp.Err != nil || string(resp.Payload) != fmt.Sprintf("req-%d", i) {
+			t.Fatalf("请求 %d 的响应错误: %q %v", i, resp.Payload, resp.Err)
+		}
+	}
+	if sizes := recorder.frameSizes(); len(sizes) != 1 || sizes[0] != 5 {
+		t.Fatalf("5个小请求应合并为一帧: %v", sizes)
+	}
+}
+
+// TestConnectionSendRequestClose 关闭连接时未完成的请求以ErrPipelineClosed结束
+func TestConnectionSendRequestClose(t *testing.T) {
+	recorder := &frameRecorder{}
+	conn := newFrameServer(t, pipelineConfig(4), recorder.reverseServer(100))
+
+	ch, err := conn.SendRequest(context.Background(), []byte("a"))
+	if err != nil {
+		t.Fatalf("发送请求失败: %v", err)
+	}
+	conn.Close()
+
+	if resp := awaitResponse(t, ch); !errors.Is(resp.Err, ErrPipelineClosed) {
+		t.Fatalf("关闭连接后未完成请求应失败，实际 %+v", resp)
+	}
+	if _, err := conn.SendRequest(context.Background(), []byte("b")); err == nil {
+		t.Fatal("关闭的连接不应接受新请求")
+	}
+}
+
+// TestConnectionSendRequestPeerClosed 对端断开后未完成请求失败，健康检查随之失败
+func TestConnectionSendRequestPeerClosed(t *testing.T) {
+	conn := newFrameServer(t, pipelineConfig(4), func(conn net.Conn) {
+		readFrame(conn)
+		conn.Close()
+	})
+
+	ch, err := conn.SendRequest(context.Background(), []byte("a"))
+	if err != nil {
+		t.Fatalf("发送请求失败: %v", err)
+	}
+	if resp := awaitResponse(t, ch); resp.Err == nil {
+		t.Fatal("对端断开后未完成请求应失败")
+	}
+	if err := conn.HealthCheck(context.Background()); err == nil {
+		t.Fatal("管道失败后健康检查应失败")
+	}
+}
+
+// delayConn 写入的数据在delay之后才送达对端，模拟网络延迟而不限制吞吐
+type delayConn struct {
+	net.Conn
+	delay  time.Duration
+	chunks chan delayedChunk
+	done   chan struct{}
+	once   sync.Once
+}
+
+type delayedChunk struct {
+	data []byte
+	due  time.Time
+}
+
+func newDelayConn(conn net.Conn, delay time.Duration) *delayConn {
+	dc := &delayConn{
+		Conn:   conn,
+		delay:  delay,
+		chunks: make(chan delayedChunk, 1024),
+		done:   make(chan struct{}),
+	}
+	go dc.deliver()
+	return dc
+}
+
+func (dc *delayConn) Write(b []byte) (int, error) {
+	chunk := delayedChunk{data: append([]byte(nil), b...), due: time.Now().Add(dc.delay)}
+	select {
+	case dc.chunks <- chunk:
+		return len(b), nil
+	case <-dc.done:
+		return 0, net.ErrClosed
+	}
+}
+
+func (dc *delayConn) deliver() {
+	for {
+		select {
+		case chunk := <-dc.chunks:
+			time.Sleep(time.Until(chunk.due))
+			if _, err := dc.Conn.Write(chunk.data); err != nil {
+				return
+			}
+		case <-dc.done:
+			return
+		}
+	}
+}
+
+func (dc *delayConn) Close() error {
+	dc.once.Do(func() { close(dc.done) })
+	return dc.Conn.Close()
+}
+
+// benchmarkSendRequest 在20ms延迟的net.Pipe上连续发送请求，另一协程按提交顺序等待响应
+func benchmarkSendRequest(b *testing.B, config *PoolConfig) {
+	client, server := net.Pipe()
+	go echoServer(server)
+	defer server.Close()
+
+	conn := NewConnection("bench", "node1", "shard-0", "pipe", time.Second)
+	conn.SetPipelineConfig(config)
+	conn.conn = newDelayConn(client, 20*time.Millisecond)
+	conn.state = ConnStateActive
+	defer conn.Close()
+
+	payload := []byte("benchmark-payload")
+	responses := make(chan (<-chan Response), b.N)
+	errs := make(chan error, 1)
+	go func() {
+		for i := 0; i < b.N; i++ {
+			if resp := <-<-responses; resp.Err != nil {
+				errs <- resp.Err
+				return
+			}
+		}
+		errs <- nil
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ch, err := conn.SendRequest(context.Background(), payload)
+		if err != nil {
+			b.Fatalf("发送请求失败: %v", err)
+		}
+		responses <- ch
+	}
+	if err := <-errs; err != nil {
+		b.Fatalf("请求失败: %v", err)
+	}
+}
+
+// BenchmarkConnectionSendRequest 对比请求-响应模式与管道化模式的吞吐
+func BenchmarkConnectionSendRequest(b *testing.B) {
+	b.Run("RequestResponse", func(b *testing.B) {
+		benchmarkSendRequest(b, pipelineConfig(1))
+	})
+	b.Run("Pipelined", func(b *testing.B) {
+		benchmarkSendRequest(b, pipelineConfig(32))
+	})
+	b.Run("PipelinedBatching", func(b *testing.B) {
+		config := pipelineConfig(32)
+		config.EnableBatching = true
+		config.BatchSize = 16
+		config.BatchTimeout = time.Millisecond
+		benchmarkSendRequest(b, config)
+	})
+}
@@ -0,0 +1,68 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-30 17:12:09
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-30 17:12:09
+* @Description: ConcordKV Go client range delete and prefix count
+ */
+
+package concord
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// DeletePrefix 删除以prefix开头的所有键，返回删除的键数
+// 服务端每个请求只删除一批键，方法携带游标重复请求直到范围内的键全部删除；
+// 删除期间写入的键位于游标之后时同样会被删除。分块存储的值的分块键以原键开头，随原键一起删除
+func (c *Client) DeletePrefix(prefix string) (int, error) {
+	return c.DeletePrefixCtx(context.Background(), prefix)
+}
+
+// DeletePrefixCtx 与DeletePrefix相同，ctx结束时停止后续批次，返回已删除的键数
+func (c *Client) DeletePrefixCtx(ctx context.Context, prefix string) (int, error) {
+	if prefix == "" {
+		return 0, ErrInvalidArgument
+	}
+
+	// 缓存中的键无论删除是否完成都可能已失效
+	if c.cache != nil {
+		defer c.cache.DeletePrefix(prefix)
+	}
+
+	deleted := 0
+	body := map[string]interface{}{"prefix": prefix}
+	for {
+		var resp struct {
+			Deleted int    `json:"deleted"`
+			HasMore bool   `json:"hasMore"`
+			Cursor  string `json:"cursor"`
+		}
+		if err := c.doWrite(ctx, http.MethodPost, "/api/delete-range", body, &resp); err != nil {
+			return deleted, err
+		}
+		deleted += resp.Deleted
+		if !resp.HasMore {
+			return deleted, nil
+		}
+		body["cursor"] = resp.Cursor
+	}
+}
+
+// Count 返回以prefix开头且未过期的键数，不传输键或值；分块存储的值的分块键同样计入
+func (c *Client) Count(prefix string) (int, error) {
+	return c.CountCtx(context.Background(), prefix)
+}
+
+// CountCtx 与Count相同，ctx结束时放弃请求
+func (c *Client) CountCtx(ctx context.Context, prefix string) (int, error) {
+	var resp struct {
+		Count int `json:"count"`
+	}
+	if err := c.doRequest(ctx, http.MethodGet, "/api/count?prefix="+url.QueryEscape(prefix), nil, nil, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Count, nil
+}
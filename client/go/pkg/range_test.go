@@ -0,0 +1,83 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-30 17:30:42
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-30 17:30:42
+* @Description: ConcordKV Go client range delete and prefix count tests
+ */
+
+package concord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestDeletePrefix 携带游标重复请求直到删除完成，累计删除数并清理缓存中该前缀下的键
+func TestDeletePrefix(t *testing.T) {
+	var mu sync.Mutex
+	var cursors []string
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/delete-range":
+			var req struct {
+				Prefix string `json:"prefix"`
+				Cursor string `json:"cursor"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			mu.Lock()
+			cursors = append(cursors, req.Cursor)
+			batch := len(cursors)
+			mu.Unlock()
+			if req.Prefix != "tmp/" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			resp := map[string]interface{}{"success": true, "deleted": 3, "hasMore": batch < 3, "cursor": ""}
+			if batch < 3 {
+				resp["cursor"] = string(rune('a' + batch))
+			}
+			json.NewEncoder(w).Encode(resp)
+		case "/api/count":
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "prefix": r.URL.Query().Get("prefix"), "count": 42})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer node.Close()
+
+	client, err := NewClient(Config{Endpoints: []string{node.URL}, RetryCount: 1, DisableSession: true, Timeout: time.Second, EnableCache: true})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+	client.cache.Set("tmp/1", "v", 0)
+	client.cache.Set("tmq", "v", 0)
+
+	deleted, err := client.DeletePrefixCtx(context.Background(), "tmp/")
+	if err != nil || deleted != 9 {
+		t.Fatalf("DeletePrefix = %d, %v, 期望 9", deleted, err)
+	}
+	if len(cursors) != 3 || cursors[0] != "" || cursors[1] != "b" || cursors[2] != "c" {
+		t.Errorf("各批次携带的游标 = %q", cursors)
+	}
+	if _, ok := client.cache.Get("tmp/1"); ok {
+		t.Errorf("前缀下的缓存条目应被删除")
+	}
+	if _, ok := client.cache.Get("tmq"); !ok {
+		t.Errorf("前缀外的缓存条目应保留")
+	}
+
+	if _, err := client.DeletePrefix(""); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("空前缀应返回ErrInvalidArgument，实际: %v", err)
+	}
+	if count, err := client.Count("tmp/"); err != nil || count != 42 {
+		t.Errorf("Count = %d, %v", count, err)
+	}
+}
@@ -0,0 +1,247 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-10 16:20:38
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-10 16:20:38
+* @Description: ConcordKV Go client retry policy
+ */
+
+package concord
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// ErrorClass 请求错误的分类，决定是否重试以及如何重试
+type ErrorClass int
+
+const (
+	ErrorClassPermanent   ErrorClass = iota // 参数错误、权限不足等，重试不会成功
+	ErrorClassConnection                    // 连接被拒绝、连接被重置等，换节点重试
+	ErrorClassTimeout                       // 请求超时，换节点重试
+	ErrorClassNotLeader                     // 节点不是领导者，刷新领导者后立即重试
+	ErrorClassUnavailable                   // 服务端暂时不可用（5xx、写请求过多），换节点或退避后重试
+	errorClassCount
+)
+
+func (ec ErrorClass) String() string {
+	switch ec {
+	case ErrorClassPermanent:
+		return "Permanent"
+	case ErrorClassConnection:
+		return "Connection"
+	case ErrorClassTimeout:
+		return "Timeout"
+	case ErrorClassNotLeader:
+		return "NotLeader"
+	case ErrorClassUnavailable:
+		return "Unavailable"
+	default:
+		return "Unknown"
+	}
+}
+
+// Retryable 该类错误是否值得重试
+func (ec ErrorClass) Retryable() bool {
+	return ec != ErrorClassPermanent
+}
+
+// ClassifyError 对请求错误分类，无法识别的错误视为不可重试
+func ClassifyError(err error) ErrorClass {
+	switch {
+	case errors.Is(err, ErrNotLeader):
+		return ErrorClassNotLeader
+	case errors.Is(err, ErrTimeout):
+		return ErrorClassTimeout
+	case errors.Is(err, ErrConnectionFailed), errors.Is(err, ErrCircuitOpen):
+		return ErrorClassConnection
+	case errors.Is(err, ErrUnavailable), errors.Is(err, ErrNoHealthyNodes), errors.Is(err, ErrPoolExhausted):
+		return ErrorClassUnavailable
+	default:
+		return ErrorClassPermanent
+	}
+}
+
+// RetryPolicy 客户端请求的重试策略
+// 每轮依次尝试候选节点（路由到的目标节点、备用节点、配置的节点），一轮全部失败后指数退避再开始下一轮；
+// 节点不是领导者时先刷新领导者再立即重试领导者，不做退避。ctx带有截止时间时，
+// 剩余时间不足以完成退避和一次请求就不再重试，直接返回最后的错误
+type RetryPolicy struct {
+	MaxAttempts        int           // 最大尝试轮数
+	InitialBackoff     time.Duration // 第一次退避间隔
+	BackoffMultiplier  float64       // 退避倍数
+	MaxBackoffInterval time.Duration // 最大退避间隔
+	EnableJitter       bool          // 是否启用抖动，启用时实际间隔在[间隔/2, 间隔)内随机
+	MaxLeaderHops      int           // 每轮跟随领导者提示的最大次数，防止领导者频繁变更时来回跳转
+}
+
+// DefaultRetryPolicy 默认重试策略
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:        3,
+		InitialBackoff:     100 * time.Millisecond,
+		BackoffMultiplier:  2.0,
+		MaxBackoffInterval: 5 * time.Second,
+		EnableJitter:       true,
+		MaxLeaderHops:      2,
+	}
+}
+
+// retryPolicyFromConfig 未配置RetryPolicy时由RetryCount和RetryInterval生成重试策略
+func retryPolicyFromConfig(config Config) *RetryPolicy {
+	policy := DefaultRetryPolicy()
+	if config.RetryPolicy != nil {
+		*policy = *config.RetryPolicy
+	} else {
+		policy.MaxAttempts = config.RetryCount
+		policy.InitialBackoff = config.RetryInterval
+	}
+
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 1
+	}
+	if policy.BackoffMultiplier < 1 {
+		policy.BackoffMultiplier = 1
+	}
+	if policy.MaxBackoffInterval > 0 && policy.MaxBackoffInterval < policy.InitialBackoff {
+		policy.MaxBackoffInterval = policy.InitialBackoff
+	}
+	if policy.MaxLeaderHops < 0 {
+		policy.MaxLeaderHops = 0
+	}
+	return policy
+}
+
+// backoff 第round轮（从1开始）之前的退避间隔
+func (p *RetryPolicy) backoff(round int) time.Duration {
+	interval := float64(p.InitialBackoff) * math.Pow(p.BackoffMultiplier, float64(round-1))
+	if p.MaxBackoffInterval > 0 && interval > float64(p.MaxBackoffInterval) {
+		interval = float64(p.MaxBackoffInterval)
+	}
+
+	d := time.Duration(interval)
+	if p.EnableJitter && d > 1 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)))
+	}
+	return d
+}
+
+// retryBudget 由ctx截止时间推导的单个操作的重试预算
+type retryBudget struct {
+	deadline    time.Time
+	hasDeadline bool
+	lastAttempt time.Duration // 最近一次请求的耗时，作为下一次请求耗时的估计
+}
+
+func newRetryBudget(ctx context.Context) *retryBudget {
+	deadline, ok := ctx.Deadline()
+	return &retryBudget{deadline: deadline, hasDeadline: ok}
+}
+
+// allows 剩余时间是否足以等待wait后再完成一次请求
+func (b *retryBudget) allows(wait time.Duration) bool {
+	if !b.hasDeadline {
+		return true
+	}
+	return time.Until(b.deadline) > wait+b.lastAttempt
+}
+
+// ClientStats 客户端统计信息
+type ClientStats struct {
+	Retries         map[ErrorClass]int64 // 各类错误引起的重试次数
+	BudgetExhausted int64                // 因ctx剩余时间不足而放弃重试的操作数
+	LeaderRefreshes int64                // 遇到非领导者错误后刷新领导者的次数
+	// LeaderDiscoveries 为找到领导者多花的往返次数：收到的NOT_LEADER响应与跟随的重定向
+	LeaderDiscoveries int64
+	// LeaderHintHits 写请求直接发往缓存的领导者的次数
+	LeaderHintHits int64
+	// LeaderEventUpdates 按领导者变更事件更新领导者缓存的次数
+	LeaderEventUpdates int64
+}
+
+// clientStats 客户端统计计数器
+type clientStats struct {
+	retries         [errorClassCount]int64
+	budgetExhausted int64
+	leaderRefreshes int64
+
+	leaderDiscoveries  int64
+	leaderHintHits     int64
+	leaderEventUpdates int64
+}
+
+func (s *clientStats) recordRetry(class ErrorClass) {
+	atomic.AddInt64(&s.retries[class], 1)
+}
+
+// GetStats 获取客户端统计信息
+func (c *Client) GetStats() *ClientStats {
+	stats := &ClientStats{
+		Retries:         make(map[ErrorClass]int64, errorClassCount),
+		BudgetExhausted: atomic.LoadInt64(&c.stats.budgetExhausted),
+		LeaderRefreshes: atomic.LoadInt64(&c.stats.leaderRefreshes),
+
+		LeaderDiscoveries:  atomic.LoadInt64(&c.stats.leaderDiscoveries),
+		LeaderHintHits:     atomic.LoadInt64(&c.stats.leaderHintHits),
+		LeaderEventUpdates: atomic.LoadInt64(&c.stats.leaderEventUpdates),
+	}
+	for class := ErrorClass(0); class < errorClassCount; class++ {
+		if n := atomic.LoadInt64(&c.stats.retries[class]); n > 0 {
+			stats.Retries[class] = n
+		}
+	}
+	return stats
+}
+
+// candidates 按尝试顺序返回候选节点：路由到的节点在前，其后是配置的节点，按地址去重
+func (c *Client) candidates(route []*connection) ([]*connection, error) {
+	conns, err := c.connections()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{}, len(route)+len(conns))
+	candidates := make([]*connection, 0, len(route)+len(conns))
+	for _, conn := range append(route, conns...) {
+		if _, ok := seen[conn.baseURL]; ok {
+			continue
+		}
+		seen[conn.baseURL] = struct{}{}
+		candidates = append(candidates, conn)
+	}
+	return candidates, nil
+}
+
+// locateLeader 处理非领导者错误：先刷新拓扑/领导者信息，再返回领导者的连接，无法确定领导者时返回nil
+func (c *Client) locateLeader(ctx context.Context, err error) *connection {
+	c.mu.RLock()
+	refresh := c.leaderRefresh
+	resolver, _ := c.router.(NodeAddressResolver)
+	c.mu.RUnlock()
+
+	if refresh != nil {
+		atomic.AddInt64(&c.stats.leaderRefreshes, 1)
+		c.logger.Info("收到非领导者错误，刷新领导者信息", "error", err)
+		refresh(ctx)
+	}
+
+	var notLeader *NotLeaderError
+	if !errors.As(err, &notLeader) {
+		return nil
+	}
+	var leader *connection
+	if notLeader.LeaderAddr != "" {
+		leader = c.connectionFor(notLeader.LeaderAddr)
+	} else if notLeader.LeaderID != "" && resolver != nil {
+		if address, err := resolver.Resolve(notLeader.LeaderID); err == nil {
+			leader = c.connectionFor(address)
+		}
+	}
+	c.rememberLeader(notLeader.LeaderID, leader)
+	return leader
+}
@@ -0,0 +1,300 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-10 16:20:38
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-10 16:20:38
+* @Description: ConcordKV Go client retry policy tests
+ */
+
+package concord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// deadAddress 返回一个已关闭的本地地址，连接会被拒绝
+func deadAddress() string {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	return server.URL
+}
+
+// countingServer 每个请求都以固定状态码和响应体应答，并统计请求数
+func countingServer(t *testing.T, status int, body interface{}) (string, *int64) {
+	var count int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, &count
+}
+
+// TestRetryErrorClassification 按状态码和响应内容区分可重试与不可重试的错误
+func TestRetryErrorClassification(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		class  ErrorClass
+	}{
+		{http.StatusBadRequest, "无效的命令", ErrorClassPermanent},
+		{http.StatusUnauthorized, "未授权", ErrorClassPermanent},
+		{http.StatusForbidden, "无权写入", ErrorClassPermanent},
+		{http.StatusConflict, "冲突", ErrorClassPermanent},
+		{http.StatusTooManyRequests, "请求过多", ErrorClassUnavailable},
+		{http.StatusServiceUnavailable, "写请求过多，请稍后重试", ErrorClassUnavailable},
+		{http.StatusServiceUnavailable, `{"success":false,"error":"不是领导者","leader":"node2"}`, ErrorClassNotLeader},
+		{http.StatusServiceUnavailable, `{"error":{"code":"NOT_LEADER","message":"不是领导者","raftIndex":7,"leader":"node2"}}`, ErrorClassNotLeader},
+		{http.StatusServiceUnavailable, `{"error":{"code":"NOT_LEADER","message":"不是领导者","raftIndex":7}}`, ErrorClassUnavailable},
+		{http.StatusGatewayTimeout, `{"error":{"code":"TIMEOUT","message":"等待命令提交超时","raftIndex":8}}`, ErrorClassTimeout},
+		{http.StatusBadRequest, `{"error":{"code":"INVALID_ARGUMENT","message":"key不能为空"}}`, ErrorClassPermanent},
+	}
+	for _, tt := range tests {
+		if class := ClassifyError(statusError(tt.status, []byte(tt.body))); class != tt.class {
+			t.Errorf("状态码 %d (%s) 应分类为 %s，实际 %s", tt.status, tt.body, tt.class, class)
+		}
+	}
+
+	if err := statusError(http.StatusNotFound, []byte(`{"error":{"code":"KEY_NOT_FOUND","message":"键不存在"}}`)); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("KEY_NOT_FOUND应转换为ErrKeyNotFound，实际 %v", err)
+	}
+
+	for _, err := range []error{ErrConnectionFailed, ErrTimeout, ErrUnavailable} {
+		if !ClassifyError(err).Retryable() {
+			t.Errorf("%v 应可重试", err)
+		}
+	}
+	for _, err := range []error{ErrInvalidArgument, ErrAccessDenied, ErrKeyNotFound, context.Canceled} {
+		if ClassifyError(err).Retryable() {
+			t.Errorf("%v 不应重试", err)
+		}
+	}
+}
+
+// TestRetryBackoff 退避间隔按倍数增长，不超过MaxBackoffInterval，抖动落在[间隔/2, 间隔)内
+func TestRetryBackoff(t *testing.T) {
+	policy := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, BackoffMultiplier: 2, MaxBackoffInterval: time.Second}
+	expected := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, want := range expected {
+		if got := policy.backoff(i + 1); got != want*time.Millisecond {
+			t.Fatalf("第%d轮退避 %v，预期 %v", i+1, got, want*time.Millisecond)
+		}
+	}
+
+	policy.EnableJitter = true
+	for i := 0; i < 100; i++ {
+		if got := policy.backoff(3); got < 200*time.Millisecond || got >= 400*time.Millisecond {
+			t.Fatalf("带抖动的退避 %v 超出范围", got)
+		}
+	}
+}
+
+// TestRetryFailsOverToBackupNode 目标节点拒绝连接时立即换到路由结果中的备用节点
+func TestRetryFailsOverToBackupNode(t *testing.T) {
+	replica, replicaAddr := newFakeKVNode(t, nil, false)
+	replica.store["key"] = "value"
+
+	cache := NewTopologyCache(nil)
+	cache.Set(&ShardInfo{ID: "shard-0", Range: ShardRange{StartHash: 0, EndHash: ^uint64(0)}, Primary: "node1", Replicas: []NodeID{"node2"}, Version: 1})
+	routerConfig := DefaultSmartRouterConfig()
+	routerConfig.HealthCheckInterval = 0
+	routerConfig.NodeAddresses = map[NodeID]string{"node1": deadAddress(), "node2": replicaAddr}
+
+	client, err := NewClient(Config{Endpoints: []string{deadAddress()}, RetryInterval: 5 * time.Second, DisableSession: true})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+	client.SetRouter(NewSmartRouter(routerConfig, cache))
+
+	start := time.Now()
+	value, err := client.Get("key")
+	if err != nil || value != "value" {
+		t.Fatalf("应从备用节点读取成功: %q, %v", value, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("换节点重试不应退避: %v", elapsed)
+	}
+	if retries := client.GetStats().Retries; retries[ErrorClassConnection] != 1 {
+		t.Fatalf("应记录一次连接错误重试: %v", retries)
+	}
+}
+
+// TestRetryNotLeaderFollowsLeader 非领导者错误后立即重试领导者，而不是退避后重试同一节点
+func TestRetryNotLeaderFollowsLeader(t *testing.T) {
+	leader, leaderAddr := newFakeKVNode(t, nil, false)
+
+	t.Run("LeaderAddr", func(t *testing.T) {
+		follower, count := countingServer(t, http.StatusOK, map[string]interface{}{
+			"success": false, "error": "不是领导者", "leader": "node2", "leaderApiAddr": leaderAddr,
+		})
+		client, err := NewClient(Config{Endpoints: []string{follower}, RetryInterval: 5 * time.Second, DisableSession: true})
+		if err != nil {
+			t.Fatalf("创建客户端失败: %v", err)
+		}
+		defer client.Close()
+
+		start := time.Now()
+		if _, err := client.Set("a", "1"); err != nil {
+			t.Fatalf("应重试领导者成功: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("非领导者错误不应退避: %v", elapsed)
+		}
+		if atomic.LoadInt64(count) != 1 || leader.keys()["a"] != "1" {
+			t.Fatalf("应只请求跟随者一次，随后写入领导者: follower=%d", atomic.LoadInt64(count))
+		}
+		if stats := client.GetStats(); stats.Retries[ErrorClassNotLeader] != 1 {
+			t.Fatalf("应记录一次非领导者重试: %+v", stats)
+		}
+	})
+
+	t.Run("ResolveLeaderAndRefresh", func(t *testing.T) {
+		follower, _ := countingServer(t, http.StatusServiceUnavailable, map[string]interface{}{
+			"success": false, "error": "不是领导者", "leader": "node2",
+		})
+		client, err := NewClient(Config{Endpoints: []string{follower}, RetryInterval: 5 * time.Second, DisableSession: true})
+		if err != nil {
+			t.Fatalf("创建客户端失败: %v", err)
+		}
+		defer client.Close()
+		client.SetRouter(resolverOnlyRouter{StaticNodeResolver{"node2": leaderAddr}})
+
+		var refreshed int32
+		client.leaderRefresh = func(ctx context.Context) error {
+			atomic.AddInt32(&refreshed, 1)
+			return nil
+		}
+
+		if err := client.Delete("a"); err != nil {
+			t.Fatalf("应经解析的领导者地址重试成功: %v", err)
+		}
+		if atomic.LoadInt32(&refreshed) != 1 || client.GetStats().LeaderRefreshes != 1 {
+			t.Fatalf("重试前应刷新一次领导者信息: %d", atomic.LoadInt32(&refreshed))
+		}
+	})
+}
+
+// resolverOnlyRouter 不路由任何键，只用于解析节点地址
+type resolverOnlyRouter struct {
+	StaticNodeResolver
+}
+
+func (resolverOnlyRouter) Route(req *RoutingRequest) (*RoutingResult, error) {
+	return nil, errors.New("未配置分片")
+}
+
+// TestRetryPermanentError 权限错误等不可重试的错误直接返回
+func TestRetryPermanentError(t *testing.T) {
+	addr, count := countingServer(t, http.StatusForbidden, "无权写入")
+	client, err := NewClient(Config{Endpoints: []string{addr}, RetryCount: 5, RetryInterval: time.Millisecond, DisableSession: true})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Set("a", "1"); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("应返回ErrAccessDenied，实际 %v", err)
+	}
+	if atomic.LoadInt64(count) != 1 {
+		t.Fatalf("不可重试的错误不应重试: %d 次请求", atomic.LoadInt64(count))
+	}
+	if len(client.GetStats().Retries) != 0 {
+		t.Fatalf("不应记录重试: %v", client.GetStats().Retries)
+	}
+
+	// 键不存在的404带有KEY_NOT_FOUND错误码，不能当作服务端不支持该接口
+	addr, count = countingServer(t, http.StatusNotFound, map[string]interface{}{
+		"error": map[string]interface{}{"code": "KEY_NOT_FOUND", "message": "键不存在"},
+	})
+	missing, err := NewClient(Config{Endpoints: []string{addr}, RetryCount: 5, RetryInterval: time.Millisecond, DisableSession: true})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer missing.Close()
+
+	if _, err := missing.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("应返回ErrKeyNotFound，实际 %v", err)
+	}
+	if atomic.LoadInt64(count) != 1 {
+		t.Fatalf("键不存在不应重试: %d 次请求", atomic.LoadInt64(count))
+	}
+}
+
+// TestRetryBudgetFromDeadline 剩余时间不足以退避时放弃重试，返回最后的错误而不是等到截止时间
+func TestRetryBudgetFromDeadline(t *testing.T) {
+	addr, count := countingServer(t, http.StatusServiceUnavailable, "写请求过多，请稍后重试")
+	client, err := NewClient(Config{
+		Endpoints:      []string{addr},
+		DisableSession: true,
+		RetryPolicy:    &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, BackoffMultiplier: 2},
+	})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := client.GetCtx(ctx, "a"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("应返回最后的错误，实际 %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("预算不足时应立即返回: %v", elapsed)
+	}
+	if atomic.LoadInt64(count) != 1 || client.GetStats().BudgetExhausted != 1 {
+		t.Fatalf("应只尝试一次并记录预算耗尽: requests=%d stats=%+v", atomic.LoadInt64(count), client.GetStats())
+	}
+
+	// 没有截止时间时按MaxAttempts重试
+	client.retryPolicy.InitialBackoff = time.Millisecond
+	if _, err := client.Get("a"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("应返回ErrUnavailable，实际 %v", err)
+	}
+	if atomic.LoadInt64(count) != 6 || client.GetStats().Retries[ErrorClassUnavailable] != 4 {
+		t.Fatalf("应重试到MaxAttempts: requests=%d stats=%+v", atomic.LoadInt64(count), client.GetStats())
+	}
+}
+
+// TestRetryLogging 重试写入注入的日志，未知的日志级别使创建客户端失败
+func TestRetryLogging(t *testing.T) {
+	if _, err := NewClient(Config{Endpoints: []string{deadAddress()}, LogLevel: "verbose"}); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("未知的日志级别应返回ErrInvalidArgument: %v", err)
+	}
+
+	node, nodeAddr := newFakeKVNode(t, nil, false)
+	node.store["key"] = "value"
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client, err := NewClient(Config{Endpoints: []string{deadAddress(), nodeAddr}, DisableSession: true, Logger: logger})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Get("key"); err != nil {
+		t.Fatalf("应从第二个节点读取成功: %v", err)
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &record); err != nil {
+		t.Fatalf("日志应为一条JSON记录: %v, %s", err, buf.String())
+	}
+	if record["msg"] != "重试请求" || record["component"] != "client" || record["class"] != ErrorClassConnection.String() {
+		t.Fatalf("重试日志不正确: %v", record)
+	}
+}
@@ -0,0 +1,234 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-18 10:12:45
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-18 10:12:45
+* @Description: ConcordKV intelligent client - smart router state persistence
+ */
+
+package concord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// routerStateVersion 持久化状态的格式版本，版本不符的状态被丢弃
+const routerStateVersion = 1
+
+// RouterStateStore 保存与读取路由器的节点健康与熔断器状态，使客户端重启后不必重新探测已知故障的节点
+// 没有保存过状态时LoadState返回(nil, nil)
+type RouterStateStore interface {
+	SaveState(ctx context.Context, data []byte) error
+	LoadState(ctx context.Context) ([]byte, error)
+}
+
+// FileRouterStateStore 将状态保存在本地文件中，先写临时文件再重命名，中途崩溃不会留下不完整的状态
+type FileRouterStateStore struct {
+	Path string
+}
+
+// NewFileRouterStateStore 创建文件状态存储
+func NewFileRouterStateStore(path string) *FileRouterStateStore {
+	return &FileRouterStateStore{Path: path}
+}
+
+// SaveState 原子地替换状态文件
+func (s *FileRouterStateStore) SaveState(ctx context.Context, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("创建路由器状态临时文件失败: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入路由器状态失败: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("写入路由器状态失败: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.Path); err != nil {
+		return fmt.Errorf("替换路由器状态文件失败: %w", err)
+	}
+	return nil
+}
+
+// LoadState 读取状态文件，文件不存在时返回(nil, nil)
+func (s *FileRouterStateStore) LoadState(ctx context.Context) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取路由器状态失败: %w", err)
+	}
+	return data, nil
+}
+
+// routerState 持久化的路由器状态
+type routerState struct {
+	Version  int                  `json:"version"`
+	SavedAt  time.Time            `json:"savedAt"`
+	Nodes    []nodeStateRecord    `json:"nodes"`
+	Breakers []breakerStateRecord `json:"breakers"`
+}
+
+// nodeStateRecord 节点的健康状态，计数类统计不持久化
+type nodeStateRecord struct {
+	NodeID         NodeID           `json:"nodeId"`
+	Status         NodeHealthStatus `json:"status"`
+	FailureCount   int              `json:"failureCount"`
+	AverageLatency time.Duration    `json:"averageLatency"`
+	LastError      string           `json:"lastError,omitempty"`
+}
+
+// breakerStateRecord 未处于关闭状态的熔断器
+type breakerStateRecord struct {
+	NodeID NodeID              `json:"nodeId"`
+	State  CircuitBreakerState `json:"state"`
+}
+
+// SetStateStore 设置状态存储，Start时从中恢复状态，运行期间每隔StateSaveInterval及Stop时保存
+func (sr *SmartRouter) SetStateStore(store RouterStateStore) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.stateStore = store
+}
+
+// SaveState 立即将节点健康与熔断器状态写入状态存储，未设置状态存储时不做任何事
+func (sr *SmartRouter) SaveState(ctx context.Context) error {
+	sr.mu.RLock()
+	store := sr.stateStore
+	sr.mu.RUnlock()
+	if store == nil {
+		return nil
+	}
+
+	data, err := sr.marshalState(sr.clock.Now())
+	if err != nil {
+		return err
+	}
+	return store.SaveState(ctx, data)
+}
+
+// RestoreState 从状态存储恢复节点健康与熔断器状态，保存时间早于StateMaxAge的状态被丢弃
+// 恢复的开启或半开熔断器一律进入半开状态，节点恢复后能很快被重新启用
+func (sr *SmartRouter) RestoreState(ctx context.Context) error {
+	sr.mu.RLock()
+	store := sr.stateStore
+	sr.mu.RUnlock()
+	if store == nil {
+		return nil
+	}
+
+	data, err := store.LoadState(ctx)
+	if err != nil || data == nil {
+		return err
+	}
+	return sr.unmarshalState(data, sr.clock.Now())
+}
+
+// 内部方法：序列化节点健康与熔断器状态
+func (sr *SmartRouter) marshalState(now time.Time) ([]byte, error) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	state := routerState{Version: routerStateVersion, SavedAt: now}
+	for nodeID, health := range sr.nodeHealthMap {
+		state.Nodes = append(state.Nodes, nodeStateRecord{
+			NodeID:         nodeID,
+			Status:         health.Status,
+			FailureCount:   health.FailureCount,
+			AverageLatency: health.AverageLatency,
+			LastError:      health.LastError,
+		})
+	}
+	for nodeID, cb := range sr.circuitBreakers {
+		if cbState := cb.GetState(); cbState != CircuitClosed {
+			state.Breakers = append(state.Breakers, breakerStateRecord{NodeID: nodeID, State: cbState})
+		}
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("序列化路由器状态失败: %w", err)
+	}
+	return data, nil
+}
+
+// 内部方法：恢复序列化的状态，过期或版本不符的状态被丢弃
+func (sr *SmartRouter) unmarshalState(data []byte, now time.Time) error {
+	var state routerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("解析路由器状态失败: %w", err)
+	}
+	if state.Version != routerStateVersion {
+		return nil
+	}
+	if sr.config.StateMaxAge > 0 && now.Sub(state.SavedAt) > sr.config.StateMaxAge {
+		return nil
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	for _, record := range state.Nodes {
+		health := sr.nodeHealthLocked(record.NodeID)
+		health.Status = record.Status
+		health.FailureCount = record.FailureCount
+		health.SuccessCount = 0
+		health.AverageLatency = record.AverageLatency
+		health.LastError = record.LastError
+		if record.Status != NodeHealthy {
+			sr.invalidateNodeLocked(record.NodeID)
+		}
+	}
+	for _, record := range state.Breakers {
+		cb := NewCircuitBreaker(sr.config)
+		cb.state = CircuitHalfOpen
+		sr.circuitBreakers[record.NodeID] = cb
+		sr.invalidateNodeLocked(record.NodeID)
+	}
+	return nil
+}
+
+// 内部方法：按StateSaveInterval定期保存状态
+func (sr *SmartRouter) stateSaveLoop(ctx context.Context) {
+	ticker := sr.clock.NewTicker(sr.config.StateSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sr.stopChannel:
+			return
+		case <-ticker.C():
+			sr.saveStateWithTimeout(ctx)
+		}
+	}
+}
+
+// 内部方法：单次保存的超时为NodeTimeout；状态只用于加速恢复，保存失败不影响路由
+func (sr *SmartRouter) saveStateWithTimeout(ctx context.Context) {
+	if sr.config.NodeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sr.config.NodeTimeout)
+		defer cancel()
+	}
+	sr.SaveState(ctx)
+}
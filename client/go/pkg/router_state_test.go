@@ -0,0 +1,122 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-18 10:12:45
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-18 10:12:45
+* @Description: ConcordKV intelligent client - smart router state persistence tests
+ */
+
+package concord
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newStateTestRouter 创建路由键k到shard-0的路由器，主节点为node1，副本为node2与node3
+func newStateTestRouter(store RouterStateStore) *SmartRouter {
+	cache := NewTopologyCache(nil)
+	shard := testShard("node1", 1)
+	shard.Replicas = []NodeID{"node2", "node3"}
+	cache.Set(shard)
+
+	config := DefaultSmartRouterConfig()
+	config.HealthCheckInterval = 0
+	config.StateSaveInterval = 0
+	router := NewSmartRouter(config, cache)
+	router.SetStateStore(store)
+	return router
+}
+
+// TestRouterStateRoundTrip 不健康的节点、平均延迟与开启的熔断器经文件存储恢复；开启的熔断器恢复为半开
+func TestRouterStateRoundTrip(t *testing.T) {
+	store := NewFileRouterStateStore(filepath.Join(t.TempDir(), "router-state.json"))
+	source := newStateTestRouter(store)
+
+	for i := 0; i < source.config.FailureThreshold; i++ {
+		source.UpdateNodeHealth("node1", false, 0, errors.New("连接被拒绝"))
+	}
+	source.UpdateNodeHealth("node2", true, 20*time.Millisecond, nil)
+	for i := 0; i < source.config.MinRequestThreshold; i++ {
+		source.RecordResult("node3", errors.New("超时"), time.Millisecond)
+	}
+	if state := source.GetStats().CircuitBreakerStats["node3"]; state != CircuitOpen {
+		t.Fatalf("node3的熔断器应已开启，实际 %v", state)
+	}
+
+	if err := source.SaveState(context.Background()); err != nil {
+		t.Fatalf("保存状态失败: %v", err)
+	}
+
+	restored := newStateTestRouter(store)
+	if err := restored.RestoreState(context.Background()); err != nil {
+		t.Fatalf("恢复状态失败: %v", err)
+	}
+
+	stats := restored.GetStats()
+	if node1 := stats.NodeStats["node1"]; node1.Status != NodeUnhealthy || node1.LastError != "连接被拒绝" {
+		t.Errorf("node1应恢复为不健康: %+v", node1)
+	}
+	if node2 := stats.NodeStats["node2"]; node2.Status != NodeHealthy || node2.AverageLatency != 20*time.Millisecond {
+		t.Errorf("node2的平均延迟应被恢复: %+v", node2)
+	}
+	if state := stats.CircuitBreakerStats["node3"]; state != CircuitHalfOpen {
+		t.Errorf("开启的熔断器应恢复为半开，实际 %v", state)
+	}
+
+	// 过期、损坏或不存在的状态不影响路由器
+	expired := newStateTestRouter(store)
+	data, _ := source.marshalState(time.Now().Add(-2 * expired.config.StateMaxAge))
+	if err := expired.unmarshalState(data, time.Now()); err != nil {
+		t.Fatalf("过期的状态应被直接丢弃: %v", err)
+	}
+	if _, exists := expired.GetStats().NodeStats["node1"]; exists {
+		t.Errorf("过期的状态不应被恢复")
+	}
+	if err := expired.unmarshalState([]byte("{"), time.Now()); err == nil {
+		t.Errorf("损坏的状态应返回错误")
+	}
+	missing := NewFileRouterStateStore(filepath.Join(t.TempDir(), "missing.json"))
+	if data, err := missing.LoadState(context.Background()); data != nil || err != nil {
+		t.Errorf("状态文件不存在时应返回(nil, nil): %v %v", data, err)
+	}
+}
+
+// TestRestoredUnhealthyNodeSkipped 重启后第一次路由就避开上次运行中不健康的主节点，Stop时保存最终状态
+func TestRestoredUnhealthyNodeSkipped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "router-state.json")
+	store := NewFileRouterStateStore(path)
+
+	previous := newStateTestRouter(store)
+	if err := previous.Start(context.Background()); err != nil {
+		t.Fatalf("启动路由器失败: %v", err)
+	}
+	for i := 0; i < previous.config.FailureThreshold; i++ {
+		previous.UpdateNodeHealth("node1", false, 0, errors.New("节点无响应"))
+	}
+	previous.Stop()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Stop时应保存状态: %v", err)
+	}
+
+	router := newStateTestRouter(store)
+	if err := router.Start(context.Background()); err != nil {
+		t.Fatalf("启动路由器失败: %v", err)
+	}
+	defer router.Stop()
+
+	result, err := router.Route(&RoutingRequest{Key: "k", Strategy: RoutingFailover})
+	if err != nil {
+		t.Fatalf("路由失败: %v", err)
+	}
+	if result.TargetNode == "node1" {
+		t.Errorf("第一次路由不应选择已知不健康的node1")
+	}
+	if _, err := router.Route(&RoutingRequest{Key: "k", Strategy: RoutingWritePrimary}); err == nil {
+		t.Errorf("主节点不健康时写请求应失败")
+	}
+}
@@ -0,0 +1,87 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-26 14:48:05
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-26 14:48:05
+* @Description: ConcordKV Go client prefix scan
+ */
+
+package concord
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ScanOptions 前缀扫描参数
+type ScanOptions struct {
+	Prefix     string // 键前缀，为空时扫描所有键
+	Cursor     string // 上一页返回的游标，为空时从头开始
+	Limit      int    // 每页最多返回的键数，0表示使用服务端默认值
+	WithValues bool   // 同时返回值
+	Stale      bool   // 由收到请求的节点读取本地状态，不转发给领导者
+}
+
+// ScanItem 扫描到的键值对
+type ScanItem struct {
+	Key         string
+	Value       string
+	ContentType string // 写入时指定的内容类型
+	Compressed  bool   // 服务端是否压缩存储，Value已解压
+}
+
+// ScanResult 一页扫描结果，按键的字典序排列
+type ScanResult struct {
+	Keys    []string
+	Items   []ScanItem // 仅在WithValues时返回
+	Cursor  string     // 下一页的游标，没有后续结果时为空
+	HasMore bool
+}
+
+// Scan 按前缀分页扫描键
+func (c *Client) Scan(opts ScanOptions) (*ScanResult, error) {
+	return c.ScanCtx(context.Background(), opts)
+}
+
+// ScanCtx 与Scan相同，ctx结束时放弃请求
+func (c *Client) ScanCtx(ctx context.Context, opts ScanOptions) (*ScanResult, error) {
+	query := url.Values{}
+	query.Set("prefix", opts.Prefix)
+	if opts.Cursor != "" {
+		query.Set("cursor", opts.Cursor)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.WithValues {
+		query.Set("values", "true")
+	}
+	if opts.Stale {
+		query.Set("stale", "true")
+	}
+
+	var resp struct {
+		Keys  []string `json:"keys"`
+		Items []struct {
+			Key         string          `json:"key"`
+			Value       json.RawMessage `json:"value"`
+			ContentType string          `json:"contentType"`
+			Compressed  bool            `json:"compressed"`
+		} `json:"items"`
+		Cursor  string `json:"cursor"`
+		HasMore bool   `json:"hasMore"`
+	}
+	if err := c.doRequest(ctx, http.MethodGet, "/api/scan?"+query.Encode(), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	result := &ScanResult{Keys: resp.Keys, Cursor: resp.Cursor, HasMore: resp.HasMore}
+	for _, item := range resp.Items {
+		value := response{Value: item.Value}
+		result.Items = append(result.Items, ScanItem{Key: item.Key, Value: value.stringValue(), ContentType: item.ContentType, Compressed: item.Compressed})
+	}
+	return result, nil
+}
@@ -0,0 +1,200 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Go client session management
+ */
+
+package concord
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 会话请求头，与服务端约定
+const (
+	sessionIDHeader  = "X-Concord-Session"
+	sessionSeqHeader = "X-Concord-Seq"
+	sessionAckHeader = "X-Concord-Ack"
+)
+
+// session 客户端会话：每个写请求分配递增序号，服务端按(会话, 序号)去重
+// 超时重试使用相同序号，即使前一次尝试已被应用也不会被再次执行
+type session struct {
+	id      string
+	timeout time.Duration
+
+	mu      sync.Mutex
+	nextSeq uint64
+	pending map[uint64]struct{} // 尚未收到响应的序号
+
+	stopCh chan struct{}
+}
+
+// begin 分配序号，并计算可以通知服务端丢弃的已确认序号
+func (s *session) begin() (seq, ack uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextSeq++
+	seq = s.nextSeq
+	s.pending[seq] = struct{}{}
+	return seq, s.ackLocked()
+}
+
+// finish 标记序号已结束，之后可以通知服务端丢弃其缓存的响应
+func (s *session) finish(seq uint64) {
+	s.mu.Lock()
+	delete(s.pending, seq)
+	s.mu.Unlock()
+}
+
+// ack 当前已确认的序号
+func (s *session) ack() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ackLocked()
+}
+
+// ackLocked 小于所有未结束序号的最大序号（调用方需持有锁）
+func (s *session) ackLocked() uint64 {
+	ack := s.nextSeq
+	for seq := range s.pending {
+		if seq-1 < ack {
+			ack = seq - 1
+		}
+	}
+	return ack
+}
+
+// sessionResponse 注册会话的响应
+type sessionResponse struct {
+	SessionID string `json:"sessionId"`
+	TimeoutMs int64  `json:"timeoutMs"`
+}
+
+// ensureSession 获取当前会话，不存在时向服务端注册
+func (c *Client) ensureSession(ctx context.Context) (*session, error) {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+
+	if c.session != nil {
+		return c.session, nil
+	}
+
+	var resp sessionResponse
+	if err := c.doRequest(ctx, http.MethodPost, "/api/session", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.SessionID == "" || resp.TimeoutMs <= 0 {
+		return nil, errors.New("注册会话失败: 服务端响应无效")
+	}
+
+	s := &session{
+		id:      resp.SessionID,
+		timeout: time.Duration(resp.TimeoutMs) * time.Millisecond,
+		pending: make(map[uint64]struct{}),
+		stopCh:  make(chan struct{}),
+	}
+	c.session = s
+	go c.keepAliveLoop(s)
+
+	return s, nil
+}
+
+// dropSession 丢弃已过期的会话，下一次写请求会重新注册
+func (c *Client) dropSession(s *session) {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+
+	if c.session == s {
+		c.session = nil
+		close(s.stopCh)
+	}
+}
+
+// keepAliveLoop 定期刷新会话，避免空闲的客户端因超时丢失会话
+func (c *Client) keepAliveLoop(s *session) {
+	ticker := time.NewTicker(s.timeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			headers := map[string]string{
+				sessionIDHeader:  s.id,
+				sessionAckHeader: strconv.FormatUint(s.ack(), 10),
+			}
+			if err := c.doRequest(context.Background(), http.MethodPost, "/api/session/keepalive", nil, headers, nil); errors.Is(err, ErrSessionExpired) {
+				c.dropSession(s)
+				return
+			}
+		}
+	}
+}
+
+// doWrite 在会话中发送写请求
+// 会话在请求期间过期时，若所有尝试都发生在会话超时之内，则没有尝试被应用过，在新会话中重发是安全的
+func (c *Client) doWrite(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	return c.doWriteTo(ctx, nil, method, path, body, out)
+}
+
+// doWriteTo 与doWrite相同，但先尝试route中的节点
+// 写请求总是由领导者处理，缓存了领导者时先发往它
+func (c *Client) doWriteTo(ctx context.Context, route []*connection, method, path string, body interface{}, out interface{}) error {
+	route = c.leaderFirst(route)
+	if c.config.DisableSession {
+		return c.doRequestTo(ctx, route, method, path, body, nil, out)
+	}
+
+	for renewed := false; ; renewed = true {
+		s, err := c.ensureSession(ctx)
+		if err != nil {
+			return err
+		}
+
+		seq, ack := s.begin()
+		headers := map[string]string{
+			sessionIDHeader:  s.id,
+			sessionSeqHeader: strconv.FormatUint(seq, 10),
+		}
+		if ack > 0 {
+			headers[sessionAckHeader] = strconv.FormatUint(ack, 10)
+		}
+
+		start := time.Now()
+		err = c.doRequestTo(ctx, route, method, path, body, headers, out)
+		s.finish(seq)
+
+		if !errors.Is(err, ErrSessionExpired) {
+			return err
+		}
+		c.dropSession(s)
+
+		if renewed || time.Since(start) >= s.timeout {
+			return err
+		}
+	}
+}
+
+// closeSession 关闭会话，服务端随即丢弃其缓存的响应
+func (c *Client) closeSession() {
+	c.sessionMu.Lock()
+	s := c.session
+	c.sessionMu.Unlock()
+
+	if s == nil {
+		return
+	}
+
+	c.dropSession(s)
+	c.doRequest(context.Background(), http.MethodDelete, "/api/session", nil, map[string]string{sessionIDHeader: s.id}, nil)
+}
@@ -0,0 +1,139 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-20 17:26:03
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-20 17:26:03
+* @Description: ConcordKV intelligent client - shard connection pool warm-up
+ */
+
+package concord
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errWarmUpCancelled 连接池在开始预热前已被移除或分片感知连接池已停止
+var errWarmUpCancelled = errors.New("连接池在预热前已关闭")
+
+// ShardWarmUpProgress 分片连接池的预热进度，每个节点连接池预热结束（成功或失败）时报告一次
+type ShardWarmUpProgress struct {
+	ShardID   string // 分片ID
+	NodeID    NodeID // 节点ID
+	Err       error  // 预热失败的原因，无法解析节点地址时也在此报告
+	Completed int    // 已结束预热的连接池数
+	Total     int    // 已安排预热的连接池数，Completed等于Total时当前没有进行中的预热
+}
+
+// SetWarmUpCallback 设置预热进度回调，回调在预热协程中调用，不应长时间阻塞
+func (sacp *ShardAwareConnectionPool) SetWarmUpCallback(callback func(ShardWarmUpProgress)) {
+	sacp.mu.Lock()
+	defer sacp.mu.Unlock()
+	sacp.onWarmUp = callback
+}
+
+// EnsureShard 按分片信息同步分片的节点连接池：为主节点和各副本节点创建尚不存在的连接池，
+// 在后台按InitialSize与PreWarmSize建立连接（同时预热的连接池数不超过ShardWarmUpConcurrency）；
+// 分片中已不再引用的节点的连接池被关闭。无法解析地址的节点返回错误并报告给预热回调，其余节点照常处理
+func (sacp *ShardAwareConnectionPool) EnsureShard(shardInfo *ShardInfo) error {
+	nodes := append([]NodeID{shardInfo.Primary}, shardInfo.Replicas...)
+	referenced := make(map[NodeID]bool, len(nodes))
+	for _, nodeID := range nodes {
+		referenced[nodeID] = true
+	}
+
+	sacp.mu.Lock()
+	for key, pool := range sacp.shardPools {
+		if key.shardID == shardInfo.ID && !referenced[key.nodeID] {
+			sacp.retirePoolLocked(key, pool)
+		}
+	}
+
+	var created []*ConnectionPool
+	var failed []ShardWarmUpProgress
+	var errs []error
+	for _, nodeID := range nodes {
+		key := shardPoolKey{shardInfo.ID, nodeID}
+		if _, exists := sacp.shardPools[key]; exists {
+			continue
+		}
+		sacp.warmTotal++
+		pool, err := sacp.createShardPoolLocked(shardInfo.ID, nodeID)
+		if err != nil {
+			sacp.warmCompleted++
+			errs = append(errs, err)
+			failed = append(failed, sacp.warmUpProgressLocked(key, err))
+			continue
+		}
+		sacp.warming[pool] = false
+		created = append(created, pool)
+	}
+	callback := sacp.onWarmUp
+	sacp.mu.Unlock()
+
+	for _, pool := range created {
+		go sacp.warmUp(pool)
+	}
+	if callback != nil {
+		for _, progress := range failed {
+			callback(progress)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("同步分片 %s 的连接池失败: %w", shardInfo.ID, errors.Join(errs...))
+	}
+	return nil
+}
+
+// 内部方法：等待预热名额后启动节点连接池，期间被移除的连接池不再启动或在启动后关闭
+func (sacp *ShardAwareConnectionPool) warmUp(pool *ConnectionPool) {
+	err := errWarmUpCancelled
+	select {
+	case sacp.warmSemaphore <- struct{}{}:
+		sacp.mu.RLock()
+		retired := sacp.warming[pool]
+		sacp.mu.RUnlock()
+		if !retired {
+			err = pool.Start(sacp.warmCtx)
+		}
+		<-sacp.warmSemaphore
+	case <-sacp.warmCtx.Done():
+	}
+
+	sacp.mu.Lock()
+	retired := sacp.warming[pool]
+	delete(sacp.warming, pool)
+	sacp.warmCompleted++
+	progress := sacp.warmUpProgressLocked(shardPoolKey{pool.shardID, pool.nodeID}, err)
+	callback := sacp.onWarmUp
+	sacp.mu.Unlock()
+
+	if retired {
+		pool.Stop()
+	}
+	if callback != nil {
+		callback(progress)
+	}
+}
+
+// 内部方法：移除节点连接池，正在预热的连接池由预热协程在结束后关闭（调用方需持有写锁）
+func (sacp *ShardAwareConnectionPool) retirePoolLocked(key shardPoolKey, pool *ConnectionPool) {
+	delete(sacp.shardPools, key)
+	if _, warming := sacp.warming[pool]; warming {
+		sacp.warming[pool] = true
+		return
+	}
+	pool.Stop()
+}
+
+// 内部方法：当前的预热进度（调用方需持有锁）
+func (sacp *ShardAwareConnectionPool) warmUpProgressLocked(key shardPoolKey, err error) ShardWarmUpProgress {
+	return ShardWarmUpProgress{
+		ShardID:   key.shardID,
+		NodeID:    key.nodeID,
+		Err:       err,
+		Completed: sacp.warmCompleted,
+		Total:     sacp.warmTotal,
+	}
+}
@@ -10,15 +10,19 @@ package concord
 
 import (
 	"context"
-	"crypto/md5"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
+	"runtime"
+	"slices"
 	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/concordkv/client/go/clock"
+	"github.com/concordkv/client/go/internal/lru"
+	"github.com/concordkv/client/go/keyhash"
 )
 
 // RoutingStrategy 路由策略
@@ -30,6 +34,7 @@ const (
 	RoutingReadNearest                         // 读请求路由到最近节点
 	RoutingLoadBalance                         // 负载均衡路由
 	RoutingFailover                            // 故障转移路由
+	routingStrategyCount
 )
 
 func (rs RoutingStrategy) String() string {
@@ -51,12 +56,13 @@ func (rs RoutingStrategy) String() string {
 
 // RoutingRequest 路由请求
 type RoutingRequest struct {
-	Key         string          `json:"key"`         // 要路由的键
-	Strategy    RoutingStrategy `json:"strategy"`    // 路由策略
-	ReadOnly    bool            `json:"readOnly"`    // 是否为只读操作
-	PreferredDC string          `json:"preferredDC"` // 首选数据中心
-	Timeout     time.Duration   `json:"timeout"`     // 请求超时
-	Context     context.Context `json:"-"`           // 请求上下文
+	Key           string          `json:"key"`           // 要路由的键
+	Strategy      RoutingStrategy `json:"strategy"`      // 路由策略
+	ReadOnly      bool            `json:"readOnly"`      // 是否为只读操作
+	PreferredDC   string          `json:"preferredDC"`   // 首选数据中心，未设置时使用路由器配置的LocalDC
+	PreferredZone string          `json:"preferredZone"` // 首选可用区，未设置时使用路由器配置的LocalZone
+	Timeout       time.Duration   `json:"timeout"`       // 请求超时
+	Context       context.Context `json:"-"`             // 请求上下文
 }
 
 // RoutingResult 路由结果
@@ -72,6 +78,17 @@ type RoutingResult struct {
 	LoadBalanceID string          `json:"loadBalanceId"` // 负载均衡标识
 }
 
+// routeCacheEntry 路由缓存条目
+type routeCacheEntry struct {
+	result   *RoutingResult
+	cachedAt time.Time // 写入缓存的时间，超过CacheTTL后失效
+}
+
+// targets 判断缓存的路由结果是否以该节点为主节点或目标节点
+func (e *routeCacheEntry) targets(nodeID NodeID) bool {
+	return e.result.TargetNode == nodeID || e.result.PrimaryNode == nodeID
+}
+
 // SmartRouterConfig 智能路由器配置
 type SmartRouterConfig struct {
 	// 基础配置
@@ -79,6 +96,7 @@ type SmartRouterConfig struct {
 	EnableCache     bool            `json:"enableCache"`     // 是否启用缓存
 	CacheSize       int             `json:"cacheSize"`       // 缓存大小
 	CacheTTL        time.Duration   `json:"cacheTTL"`        // 缓存TTL
+	BatchWorkers    int             `json:"batchWorkers"`    // RouteBatch并发路由分组的worker数，为0时使用GOMAXPROCS
 
 	// 负载均衡配置
 	LoadBalanceAlgorithm LoadBalanceAlgorithm `json:"loadBalanceAlgorithm"` // 负载均衡算法
@@ -86,10 +104,25 @@ type SmartRouterConfig struct {
 	StickySession        bool                 `json:"stickySession"`        // 是否启用粘性会话
 
 	// 故障检测配置
-	HealthCheckInterval time.Duration `json:"healthCheckInterval"` // 健康检查间隔
-	FailureThreshold    int           `json:"failureThreshold"`    // 故障阈值
-	RecoveryThreshold   int           `json:"recoveryThreshold"`   // 恢复阈值
-	NodeTimeout         time.Duration `json:"nodeTimeout"`         // 节点超时时间
+	HealthCheckInterval time.Duration     `json:"healthCheckInterval"` // 健康检查间隔
+	FailureThreshold    int               `json:"failureThreshold"`    // 故障阈值
+	RecoveryThreshold   int               `json:"recoveryThreshold"`   // 恢复阈值
+	NodeTimeout         time.Duration     `json:"nodeTimeout"`         // 节点超时时间，也是单次健康探测的超时
+	NodeAddresses       map[NodeID]string `json:"nodeAddresses"`       // 节点ID -> 节点地址，健康检查按地址探测
+
+	// 就近读取配置：ReadNearest与ReadReplica依次优先同一可用区、同一数据中心、FallbackDCs中的数据中心的副本，
+	// 同一等级内按观测到的延迟选择；分片信息没有副本位置时只按延迟选择
+	LocalDC     string   `json:"localDC"`     // 客户端所在的数据中心
+	LocalZone   string   `json:"localZone"`   // 客户端所在的可用区
+	FallbackDCs []string `json:"fallbackDCs"` // 本地数据中心没有可用副本时依次选择的远程数据中心，未列出的排在最后
+
+	// 负载感知配置
+	LoadReportInterval time.Duration `json:"loadReportInterval"` // 拉取节点负载报告的间隔，为0时不拉取
+	OverloadFactor     float64       `json:"overloadFactor"`     // 节点QPS超过各节点均值的倍数时视为过载
+
+	// 状态持久化配置，设置状态存储（SetStateStore）后生效
+	StateSaveInterval time.Duration `json:"stateSaveInterval"` // 定期保存节点健康与熔断器状态的间隔，为0时只在Stop时保存
+	StateMaxAge       time.Duration `json:"stateMaxAge"`       // 启动时丢弃保存时间早于该值的状态，为0时不限制
 
 	// 重试配置
 	MaxRetries         int           `json:"maxRetries"`         // 最大重试次数
@@ -104,6 +137,9 @@ type SmartRouterConfig struct {
 	MinRequestThreshold   int           `json:"minRequestThreshold"`   // 最小请求阈值
 	CircuitOpenTimeout    time.Duration `json:"circuitOpenTimeout"`    // 熔断器开启超时
 	HalfOpenMaxCalls      int           `json:"halfOpenMaxCalls"`      // 半开状态最大调用数
+
+	// Clock 路由器与熔断器计时使用的时钟，为nil时使用真实时钟，测试中注入testutil.FakeClock
+	Clock clock.Clock `json:"-"`
 }
 
 // LoadBalanceAlgorithm 负载均衡算法
@@ -148,6 +184,9 @@ func DefaultSmartRouterConfig() *SmartRouterConfig {
 		FailureThreshold:      3,
 		RecoveryThreshold:     2,
 		NodeTimeout:           5 * time.Second,
+		OverloadFactor:        1.5,
+		StateSaveInterval:     30 * time.Second,
+		StateMaxAge:           10 * time.Minute,
 		MaxRetries:            3,
 		RetryInterval:         100 * time.Millisecond,
 		BackoffMultiplier:     2.0,
@@ -199,6 +238,8 @@ type NodeHealth struct {
 	Weight            int              `json:"weight"`            // 权重
 	ActiveConnections int64            `json:"activeConnections"` // 活跃连接数
 	LastError         string           `json:"lastError"`         // 最后错误信息
+	LoadQPS           float64          `json:"loadQps"`           // 最近一次负载报告中的QPS
+	Overloaded        bool             `json:"overloaded"`        // 是否过载，负载均衡时避开过载节点
 }
 
 // CircuitBreakerState 熔断器状态
@@ -233,7 +274,9 @@ type CircuitBreaker struct {
 	lastFailureTime   time.Time
 	lastSuccessTime   time.Time
 	config            *SmartRouterConfig
-	halfOpenCallCount int64
+	halfOpenCallCount int64 // 半开状态下已放行的调用数
+	halfOpenSuccesses int64 // 半开状态下成功的调用数
+	clock             clock.Clock
 }
 
 // NewCircuitBreaker 创建新的熔断器
@@ -241,18 +284,19 @@ func NewCircuitBreaker(config *SmartRouterConfig) *CircuitBreaker {
 	return &CircuitBreaker{
 		state:  CircuitClosed,
 		config: config,
+		clock:  clock.Or(config.Clock),
 	}
 }
 
 // Call 执行调用（通过熔断器）
 func (cb *CircuitBreaker) Call(fn func() error) error {
 	if !cb.AllowRequest() {
-		return errors.New("熔断器开启，请求被拒绝")
+		return ErrCircuitOpen
 	}
 
-	start := time.Now()
+	start := cb.clock.Now()
 	err := fn()
-	latency := time.Since(start)
+	latency := cb.clock.Now().Sub(start)
 
 	if err != nil {
 		cb.OnFailure(latency)
@@ -273,14 +317,36 @@ func (cb *CircuitBreaker) AllowRequest() bool {
 		return true
 	case CircuitOpen:
 		// 检查是否可以进入半开状态
-		if time.Since(cb.lastFailureTime) > cb.config.CircuitOpenTimeout {
+		if cb.clock.Now().Sub(cb.lastFailureTime) > cb.config.CircuitOpenTimeout {
 			cb.state = CircuitHalfOpen
-			cb.halfOpenCallCount = 0
+			cb.halfOpenCallCount = 1
+			cb.halfOpenSuccesses = 0
 			return true
 		}
 		return false
 	case CircuitHalfOpen:
-		// 半开状态下限制调用数量
+		// 半开状态下限制放行的调用数量
+		if cb.halfOpenCallCount < int64(cb.config.HalfOpenMaxCalls) {
+			cb.halfOpenCallCount++
+			return true
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// Available 检查熔断器当前是否可能放行请求，不占用半开状态的调用名额
+func (cb *CircuitBreaker) Available() bool {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	switch cb.state {
+	case CircuitClosed:
+		return true
+	case CircuitOpen:
+		return cb.clock.Now().Sub(cb.lastFailureTime) > cb.config.CircuitOpenTimeout
+	case CircuitHalfOpen:
 		return cb.halfOpenCallCount < int64(cb.config.HalfOpenMaxCalls)
 	default:
 		return false
@@ -294,13 +360,16 @@ func (cb *CircuitBreaker) OnSuccess(latency time.Duration) {
 
 	atomic.AddInt64(&cb.successCount, 1)
 	atomic.AddInt64(&cb.requestCount, 1)
-	cb.lastSuccessTime = time.Now()
+	cb.lastSuccessTime = cb.clock.Now()
 
 	if cb.state == CircuitHalfOpen {
-		cb.halfOpenCallCount++
-		if cb.halfOpenCallCount >= int64(cb.config.HalfOpenMaxCalls) {
+		cb.halfOpenSuccesses++
+		if cb.halfOpenSuccesses >= int64(cb.config.HalfOpenMaxCalls) {
+			// 恢复后重新统计故障率，避免熔断前的失败导致再次熔断
 			cb.state = CircuitClosed
-			cb.failureCount = 0
+			atomic.StoreInt64(&cb.failureCount, 0)
+			atomic.StoreInt64(&cb.successCount, 0)
+			atomic.StoreInt64(&cb.requestCount, 0)
 		}
 	}
 }
@@ -312,7 +381,7 @@ func (cb *CircuitBreaker) OnFailure(latency time.Duration) {
 
 	atomic.AddInt64(&cb.failureCount, 1)
 	atomic.AddInt64(&cb.requestCount, 1)
-	cb.lastFailureTime = time.Now()
+	cb.lastFailureTime = cb.clock.Now()
 
 	if cb.state == CircuitHalfOpen {
 		cb.state = CircuitOpen
@@ -349,14 +418,21 @@ type SmartRouter struct {
 	mu                 sync.RWMutex
 	config             *SmartRouterConfig
 	topologyCache      *TopologyCache
-	nodeHealthMap      map[NodeID]*NodeHealth     // 节点健康状态映射
-	circuitBreakers    map[NodeID]*CircuitBreaker // 节点熔断器映射
-	routeCache         map[string]*RoutingResult  // 路由结果缓存
-	loadBalancer       LoadBalancer               // 负载均衡器
-	consistentHashRing *ConsistentHashRing        // 一致性哈希环
-	stats              *SmartRouterStats          // 统计信息
-	stopChannel        chan struct{}              // 停止信号
-	isRunning          int64                      // 运行状态
+	nodeHealthMap      map[NodeID]*NodeHealth               // 节点健康状态映射
+	circuitBreakers    map[NodeID]*CircuitBreaker           // 节点熔断器映射
+	routeCache         *lru.Cache[string, *routeCacheEntry] // 路由结果缓存，超过CacheSize时淘汰最久未使用的结果
+	loadBalancer       LoadBalancer                         // 负载均衡器
+	consistentHashRing *ConsistentHashRing                  // 一致性哈希环
+	nodeAddresses      map[NodeID]string                    // 节点ID -> 节点地址
+	healthProber       HealthProber                         // 健康探测器
+	loadReporter       LoadReporter                         // 负载报告获取器
+	stateStore         RouterStateStore                     // 节点健康与熔断器状态的存储
+	stats              *SmartRouterStats                    // 统计信息
+	strategyRequests   [routingStrategyCount]int64          // 各策略的请求数，原子更新
+	latencyMu          sync.Mutex                           // 保护stats.AverageLatency
+	stopChannel        chan struct{}                        // 停止信号
+	isRunning          int64                                // 运行状态
+	clock              clock.Clock                          // 计时使用的时钟，来自SmartRouterConfig.Clock
 }
 
 // LoadBalancer 负载均衡器接口
@@ -385,7 +461,7 @@ func NewRoundRobinLoadBalancer() *RoundRobinLoadBalancer {
 // Select 选择节点
 func (rrlb *RoundRobinLoadBalancer) Select(nodes []NodeID, key string) (NodeID, error) {
 	if len(nodes) == 0 {
-		return "", errors.New("没有可用节点")
+		return "", ErrNoHealthyNodes
 	}
 
 	rrlb.mu.Lock()
@@ -421,35 +497,63 @@ func (rrlb *RoundRobinLoadBalancer) GetStats() map[NodeID]int64 {
 }
 
 // ConsistentHashRing 一致性哈希环 (复用raftserver实现)
+// 每个节点按权重×virtualNodes个虚拟节点分布在环上，权重越大拥有的哈希空间越多
 type ConsistentHashRing struct {
 	mu           sync.RWMutex
 	ring         map[uint64]NodeID   // 哈希环
 	sortedHashes []uint64            // 排序的哈希值
-	virtualNodes int                 // 虚拟节点数
-	hashFunc     func(string) uint64 // 哈希函数
+	virtualNodes int                 // 权重为1时的虚拟节点数
+	weights      map[NodeID]int      // 各节点的权重
+	points       map[NodeID][]uint64 // 各节点的虚拟节点哈希，用于移除节点
+	hashFunc     func(string) uint64 // 哈希函数，与分片使用同一套键哈希算法
 }
 
 // NewConsistentHashRing 创建一致性哈希环
 func NewConsistentHashRing(virtualNodes int) *ConsistentHashRing {
+	if virtualNodes <= 0 {
+		virtualNodes = 1
+	}
 	return &ConsistentHashRing{
 		ring:         make(map[uint64]NodeID),
 		virtualNodes: virtualNodes,
-		hashFunc:     md5Hash,
+		weights:      make(map[NodeID]int),
+		points:       make(map[NodeID][]uint64),
+		hashFunc:     keyhash.Canonical.Sum64,
 	}
 }
 
-// AddNode 添加节点
+// AddNode 以权重1添加节点
 func (chr *ConsistentHashRing) AddNode(nodeID NodeID) {
+	chr.AddNodeWithWeight(nodeID, 1)
+}
+
+// AddNodeWithWeight 以指定权重添加节点，节点已存在时按新权重重新分布其虚拟节点
+func (chr *ConsistentHashRing) AddNodeWithWeight(nodeID NodeID, weight int) error {
+	if weight <= 0 {
+		return fmt.Errorf("节点 %s 的权重必须为正数: %d", nodeID, weight)
+	}
+
 	chr.mu.Lock()
 	defer chr.mu.Unlock()
 
-	for i := 0; i < chr.virtualNodes; i++ {
-		virtualKey := fmt.Sprintf("%s#%d", nodeID, i)
-		hash := chr.hashFunc(virtualKey)
+	chr.removeLocked(nodeID)
+
+	replicas := weight * chr.virtualNodes
+	points := make([]uint64, 0, replicas)
+	for i := 0; i < replicas; i++ {
+		hash := chr.hashFunc(fmt.Sprintf("%s#%d", nodeID, i))
+		// 哈希冲突时由ID较小的节点拥有该位置，结果与添加顺序无关
+		if owner, ok := chr.ring[hash]; ok && owner <= nodeID {
+			continue
+		}
 		chr.ring[hash] = nodeID
+		points = append(points, hash)
 	}
+	chr.weights[nodeID] = weight
+	chr.points[nodeID] = points
 
 	chr.updateSortedHashes()
+	return nil
 }
 
 // RemoveNode 移除节点
@@ -457,13 +561,25 @@ func (chr *ConsistentHashRing) RemoveNode(nodeID NodeID) {
 	chr.mu.Lock()
 	defer chr.mu.Unlock()
 
-	for i := 0; i < chr.virtualNodes; i++ {
-		virtualKey := fmt.Sprintf("%s#%d", nodeID, i)
-		hash := chr.hashFunc(virtualKey)
-		delete(chr.ring, hash)
+	if chr.removeLocked(nodeID) {
+		chr.updateSortedHashes()
 	}
+}
 
-	chr.updateSortedHashes()
+// removeLocked 从环上删除节点拥有的虚拟节点，返回节点是否存在
+func (chr *ConsistentHashRing) removeLocked(nodeID NodeID) bool {
+	points, ok := chr.points[nodeID]
+	if !ok {
+		return false
+	}
+	for _, hash := range points {
+		if chr.ring[hash] == nodeID {
+			delete(chr.ring, hash)
+		}
+	}
+	delete(chr.points, nodeID)
+	delete(chr.weights, nodeID)
+	return true
 }
 
 // GetNode 获取键对应的节点
@@ -471,22 +587,83 @@ func (chr *ConsistentHashRing) GetNode(key string) (NodeID, error) {
 	chr.mu.RLock()
 	defer chr.mu.RUnlock()
 
-	if len(chr.ring) == 0 {
+	if len(chr.sortedHashes) == 0 {
 		return "", errors.New("哈希环为空")
 	}
 
-	hash := chr.hashFunc(key)
+	return chr.ring[chr.sortedHashes[chr.search(key)]], nil
+}
+
+// GetNodes 沿顺时针方向获取键对应的n个不同节点，用于副本放置；节点数不足n时返回全部节点
+func (chr *ConsistentHashRing) GetNodes(key string, n int) ([]NodeID, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("节点数必须为正数: %d", n)
+	}
+
+	chr.mu.RLock()
+	defer chr.mu.RUnlock()
+
+	if len(chr.sortedHashes) == 0 {
+		return nil, errors.New("哈希环为空")
+	}
+	if n > len(chr.points) {
+		n = len(chr.points)
+	}
+
+	nodes := make([]NodeID, 0, n)
+	seen := make(map[NodeID]bool, n)
+	start := chr.search(key)
+	for i := 0; i < len(chr.sortedHashes) && len(nodes) < n; i++ {
+		node := chr.ring[chr.sortedHashes[(start+i)%len(chr.sortedHashes)]]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil
+}
+
+// Distribution 各节点拥有的哈希空间比例，总和为1
+// 每个虚拟节点拥有从前一个虚拟节点（不含）到自身（含）的区间
+func (chr *ConsistentHashRing) Distribution() map[NodeID]float64 {
+	chr.mu.RLock()
+	defer chr.mu.RUnlock()
+
+	result := make(map[NodeID]float64, len(chr.points))
+	count := len(chr.sortedHashes)
+	if count == 0 {
+		return result
+	}
+	if count == 1 {
+		result[chr.ring[chr.sortedHashes[0]]] = 1
+		return result
+	}
+
+	for i, hash := range chr.sortedHashes {
+		// 第一个虚拟节点的区间跨过环的起点，无符号减法自然回绕
+		prev := chr.sortedHashes[(i+count-1)%count]
+		result[chr.ring[hash]] += float64(hash-prev) / math.Exp2(64)
+	}
+	return result
+}
+
+// Weight 获取节点的权重，节点不存在时返回0
+func (chr *ConsistentHashRing) Weight(nodeID NodeID) int {
+	chr.mu.RLock()
+	defer chr.mu.RUnlock()
+	return chr.weights[nodeID]
+}
 
-	// 使用二分查找找到第一个大于等于hash的节点
+// search 二分查找第一个大于等于键哈希的虚拟节点位置，超过末尾时回到开头
+func (chr *ConsistentHashRing) search(key string) int {
+	hash := chr.hashFunc(key)
 	idx := sort.Search(len(chr.sortedHashes), func(i int) bool {
 		return chr.sortedHashes[i] >= hash
 	})
-
 	if idx == len(chr.sortedHashes) {
 		idx = 0 // 环形，回到开头
 	}
-
-	return chr.ring[chr.sortedHashes[idx]], nil
+	return idx
 }
 
 // updateSortedHashes 更新排序的哈希值切片
@@ -495,17 +672,7 @@ func (chr *ConsistentHashRing) updateSortedHashes() {
 	for hash := range chr.ring {
 		chr.sortedHashes = append(chr.sortedHashes, hash)
 	}
-	sort.Slice(chr.sortedHashes, func(i, j int) bool {
-		return chr.sortedHashes[i] < chr.sortedHashes[j]
-	})
-}
-
-// md5Hash MD5哈希函数
-func md5Hash(key string) uint64 {
-	hasher := md5.New()
-	hasher.Write([]byte(key))
-	hash := hasher.Sum(nil)
-	return binary.BigEndian.Uint64(hash[:8])
+	slices.Sort(chr.sortedHashes)
 }
 
 // SmartRouterStats 智能路由器统计信息
@@ -515,6 +682,8 @@ type SmartRouterStats struct {
 	FailedRequests      int64                          `json:"failedRequests"`      // 失败请求数
 	CacheHits           int64                          `json:"cacheHits"`           // 缓存命中数
 	CacheMisses         int64                          `json:"cacheMisses"`         // 缓存未命中数
+	CacheEvictions      int64                          `json:"cacheEvictions"`      // 因容量不足淘汰的缓存条目数
+	CacheSize           int                            `json:"cacheSize"`           // 当前缓存条目数
 	AverageLatency      time.Duration                  `json:"averageLatency"`      // 平均延迟
 	NodeStats           map[NodeID]*NodeHealth         `json:"nodeStats"`           // 节点统计
 	StrategyStats       map[RoutingStrategy]int64      `json:"strategyStats"`       // 策略统计
@@ -533,15 +702,25 @@ func NewSmartRouter(config *SmartRouterConfig, topologyCache *TopologyCache) *Sm
 		topologyCache:      topologyCache,
 		nodeHealthMap:      make(map[NodeID]*NodeHealth),
 		circuitBreakers:    make(map[NodeID]*CircuitBreaker),
-		routeCache:         make(map[string]*RoutingResult),
+		nodeAddresses:      make(map[NodeID]string),
+		healthProber:       NewHTTPHealthProber(),
+		loadReporter:       NewHTTPLoadReporter(),
 		consistentHashRing: NewConsistentHashRing(100), // 100个虚拟节点
 		stopChannel:        make(chan struct{}),
+		clock:              clock.Or(config.Clock),
 		stats: &SmartRouterStats{
 			NodeStats:           make(map[NodeID]*NodeHealth),
 			StrategyStats:       make(map[RoutingStrategy]int64),
 			CircuitBreakerStats: make(map[NodeID]CircuitBreakerState),
 		},
 	}
+	sr.routeCache = lru.New(config.CacheSize, func(string, *routeCacheEntry) {
+		atomic.AddInt64(&sr.stats.CacheEvictions, 1)
+	})
+	for nodeID, address := range config.NodeAddresses {
+		sr.nodeAddresses[nodeID] = address
+		sr.nodeHealthLocked(nodeID)
+	}
 
 	// 创建负载均衡器
 	switch config.LoadBalanceAlgorithm {
@@ -560,36 +739,66 @@ func (sr *SmartRouter) Start(ctx context.Context) error {
 		return errors.New("智能路由器已经在运行")
 	}
 
+	// 恢复上次运行保存的状态，状态只用于加速恢复，读取或解析失败时从初始状态开始
+	restoreCtx := ctx
+	if sr.config.NodeTimeout > 0 {
+		var cancel context.CancelFunc
+		restoreCtx, cancel = context.WithTimeout(ctx, sr.config.NodeTimeout)
+		defer cancel()
+	}
+	sr.RestoreState(restoreCtx)
+
+	sr.mu.RLock()
+	store := sr.stateStore
+	sr.mu.RUnlock()
+	if store != nil && sr.config.StateSaveInterval > 0 {
+		go sr.stateSaveLoop(ctx)
+	}
+
 	// 启动健康检查
 	if sr.config.HealthCheckInterval > 0 {
 		go sr.healthCheckLoop(ctx)
 	}
 
+	// 启动负载报告拉取
+	if sr.config.LoadReportInterval > 0 {
+		go sr.loadReportLoop(ctx)
+	}
+
 	return nil
 }
 
-// Stop 停止智能路由器
+// Stop 停止智能路由器，设置了状态存储时保存最终状态
 func (sr *SmartRouter) Stop() {
 	if atomic.CompareAndSwapInt64(&sr.isRunning, 1, 0) {
 		close(sr.stopChannel)
+		sr.saveStateWithTimeout(context.Background())
 	}
 }
 
 // Route 执行路由
 func (sr *SmartRouter) Route(req *RoutingRequest) (*RoutingResult, error) {
-	start := time.Now()
+	if req.Context != nil {
+		if err := req.Context.Err(); err != nil {
+			return nil, fmt.Errorf("路由请求已取消: %w", err)
+		}
+	}
+
+	start := sr.clock.Now()
 	defer func() {
-		latency := time.Since(start)
+		latency := sr.clock.Now().Sub(start)
 		atomic.AddInt64(&sr.stats.TotalRequests, 1)
 		sr.updateAverageLatency(latency)
-		sr.stats.StrategyStats[req.Strategy]++
+		if req.Strategy >= 0 && req.Strategy < routingStrategyCount {
+			atomic.AddInt64(&sr.strategyRequests[req.Strategy], 1)
+		}
 	}()
 
 	// 检查缓存
 	if sr.config.EnableCache {
 		cacheKey := sr.generateCacheKey(req)
 		if cachedResult, ok := sr.getFromCache(cacheKey); ok {
-			cachedResult.Latency = time.Since(start)
+			cachedResult.Latency = sr.clock.Now().Sub(start)
 			cachedResult.Cached = true
 			atomic.AddInt64(&sr.stats.CacheHits, 1)
 			return cachedResult, nil
@@ -597,35 +806,21 @@ func (sr *SmartRouter) Route(req *RoutingRequest) (*RoutingResult, error) {
 		atomic.AddInt64(&sr.stats.CacheMisses, 1)
 	}
 
-	// 获取分片信息
-	shardInfo, err := sr.topologyCache.GetByKey(req.Key)
-	if err != nil || shardInfo == nil {
-		return nil, fmt.Errorf("获取分片信息失败: %w", err)
+	// 获取分片信息：按键的哈希查找覆盖它的分片
+	shardInfo, ok := sr.topologyCache.GetByKey(req.Key)
+	if !ok || shardInfo == nil {
+		return nil, fmt.Errorf("获取分片信息失败: %w", &ShardNotFoundError{Key: req.Key})
 	}
 
 	// 执行路由逻辑
-	result := &RoutingResult{
-		PrimaryNode:  shardInfo.Primary,
-		ReplicaNodes: make([]NodeID, len(shardInfo.Replicas)),
-		ShardInfo:    shardInfo,
-		Strategy:     req.Strategy,
-		Latency:      time.Since(start),
-		Cached:       false,
-	}
-	copy(result.ReplicaNodes, shardInfo.Replicas)
-
-	// 根据策略选择目标节点
-	targetNode, backupNodes, err := sr.selectTargetNode(result, req)
+	result, err := sr.routeShard(shardInfo, req, start)
 	if err != nil {
 		atomic.AddInt64(&sr.stats.FailedRequests, 1)
 		return nil, err
 	}
 
-	result.TargetNode = targetNode
-	result.BackupNodes = backupNodes
-
-	// 缓存结果
-	if sr.config.EnableCache {
+	// 缓存结果；分片有节点熔断时不缓存，半开探测需要每次经熔断器放行，恢复后也能及时切回
+	if sr.config.EnableCache && sr.circuitsClosed(append([]NodeID{result.PrimaryNode}, result.ReplicaNodes...)) {
 		cacheKey := sr.generateCacheKey(req)
 		sr.addToCache(cacheKey, result)
 	}
@@ -634,51 +829,144 @@ func (sr *SmartRouter) Route(req *RoutingRequest) (*RoutingResult, error) {
 	return result, nil
 }
 
-// RouteBatch 批量路由
-func (sr *SmartRouter) RouteBatch(requests []*RoutingRequest) (map[string]*RoutingResult, error) {
-	results := make(map[string]*RoutingResult)
-	errors := make(map[string]error)
+// BatchRoutingResult 批量路由结果，每个键要么出现在Results中，要么出现在Errors中
+type BatchRoutingResult struct {
+	Results map[string]*RoutingResult `json:"results"` // 路由成功的键；同一分组的键共享同一个结果，调用方不应修改
+	Errors  map[string]error          `json:"-"`       // 路由失败的键及其错误，如*ShardNotFoundError、ErrNoHealthyNodes、*CircuitOpenError
+	Groups  int                       `json:"groups"`  // 按分片与路由参数划分的分组数，即实际执行的路由次数
+}
 
-	// 并发处理批量请求
-	var wg sync.WaitGroup
-	resultChan := make(chan struct {
-		key    string
-		result *RoutingResult
-		err    error
-	}, len(requests))
+// Err 没有失败的键时返回nil，否则返回包含各键错误的*MultiError
+func (r *BatchRoutingResult) Err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	return &MultiError{Errors: r.Errors}
+}
 
-	for _, req := range requests {
-		wg.Add(1)
-		go func(r *RoutingRequest) {
-			defer wg.Done()
-			result, err := sr.Route(r)
-			resultChan <- struct {
-				key    string
-				result *RoutingResult
-				err    error
-			}{r.Key, result, err}
-		}(req)
-	}
-
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
+// batchGroupKey 决定路由结果的参数，这些参数相同且落在同一分片的键共享一次路由
+type batchGroupKey struct {
+	shardID  string
+	strategy RoutingStrategy
+	readOnly bool
+	dc       string
+	zone     string
+}
 
-	// 收集结果
-	for item := range resultChan {
-		if item.err != nil {
-			errors[item.key] = item.err
-		} else {
-			results[item.key] = item.result
+// batchGroup 批量路由中的一个分组，req为组内第一个请求
+type batchGroup struct {
+	shardID string
+	req     *RoutingRequest
+	keys    []string
+	result  *RoutingResult
+	err     error
+}
+
+// RouteBatch 批量路由：先在一次拓扑遍历中按分片把键分组，再由最多BatchWorkers个worker并发路由各分组，
+// 同一分组的键共享一次分片查找与节点选择。重复的键只路由第一次出现的请求；批量路由不读写单键的路由缓存
+// 部分键失败时返回的结果中其余键仍然有效，error为BatchRoutingResult.Err()
+func (sr *SmartRouter) RouteBatch(requests []*RoutingRequest) (*BatchRoutingResult, error) {
+	batch := &BatchRoutingResult{
+		Results: make(map[string]*RoutingResult, len(requests)),
+		Errors:  make(map[string]error),
+	}
+
+	keys := make([]string, len(requests))
+	for i, req := range requests {
+		keys[i] = req.Key
+	}
+	shardIDs, shards := sr.topologyCache.GetByKeys(keys)
+
+	seen := make(map[string]struct{}, len(requests))
+	index := make(map[batchGroupKey]*batchGroup, len(shards))
+	var groups []*batchGroup
+	for i, req := range requests {
+		if _, dup := seen[req.Key]; dup {
+			continue
+		}
+		seen[req.Key] = struct{}{}
+		if req.Strategy >= 0 && req.Strategy < routingStrategyCount {
+			atomic.AddInt64(&sr.strategyRequests[req.Strategy], 1)
+		}
+
+		if req.Context != nil {
+			if err := req.Context.Err(); err != nil {
+				batch.Errors[req.Key] = fmt.Errorf("路由请求已取消: %w", err)
+				continue
+			}
+		}
+		if shardIDs[i] == "" {
+			batch.Errors[req.Key] = fmt.Errorf("获取分片信息失败: %w", &ShardNotFoundError{Key: req.Key})
+			continue
+		}
+
+		key := batchGroupKey{
+			shardID:  shardIDs[i],
+			strategy: req.Strategy,
+			readOnly: req.ReadOnly,
+			dc:       req.PreferredDC,
+			zone:     req.PreferredZone,
+		}
+		group, ok := index[key]
+		if !ok {
+			group = &batchGroup{shardID: shardIDs[i], req: req}
+			index[key] = group
+			groups = append(groups, group)
+		}
+		group.keys = append(group.keys, req.Key)
+	}
+	atomic.AddInt64(&sr.stats.TotalRequests, int64(len(seen)))
+
+	sr.routeGroups(groups, shards)
+
+	for _, group := range groups {
+		for _, key := range group.keys {
+			if group.err != nil {
+				batch.Errors[key] = group.err
+			} else {
+				batch.Results[key] = group.result
+			}
 		}
 	}
+	batch.Groups = len(groups)
+	return batch, batch.Err()
+}
+
+// 内部方法：以不超过BatchWorkers（为0时为GOMAXPROCS）的并发度路由各分组
+func (sr *SmartRouter) routeGroups(groups []*batchGroup, shards map[string]*ShardInfo) {
+	workers := sr.config.BatchWorkers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(groups) {
+		workers = len(groups)
+	}
 
-	if len(errors) > 0 {
-		return results, fmt.Errorf("批量路由有%d个失败", len(errors))
+	next := int64(-1)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := atomic.AddInt64(&next, 1); i < int64(len(groups)); i = atomic.AddInt64(&next, 1) {
+				sr.routeGroup(groups[i], shards[groups[i].shardID])
+			}
+		}()
 	}
+	wg.Wait()
+}
 
-	return results, nil
+// 内部方法：按分组的第一个请求路由，结果由组内所有键共享
+func (sr *SmartRouter) routeGroup(group *batchGroup, shardInfo *ShardInfo) {
+	start := sr.clock.Now()
+	group.result, group.err = sr.routeShard(shardInfo, group.req, start)
+	sr.updateAverageLatency(sr.clock.Now().Sub(start))
+
+	if group.err != nil {
+		atomic.AddInt64(&sr.stats.FailedRequests, int64(len(group.keys)))
+		return
+	}
+	atomic.AddInt64(&sr.stats.SuccessfulRequests, int64(len(group.keys)))
 }
 
 // GetStats 获取统计信息
@@ -693,11 +981,13 @@ func (sr *SmartRouter) GetStats() *SmartRouterStats {
 		FailedRequests:      atomic.LoadInt64(&sr.stats.FailedRequests),
 		CacheHits:           atomic.LoadInt64(&sr.stats.CacheHits),
 		CacheMisses:         atomic.LoadInt64(&sr.stats.CacheMisses),
-		AverageLatency:      sr.stats.AverageLatency,
+		CacheEvictions:      atomic.LoadInt64(&sr.stats.CacheEvictions),
+		CacheSize:           sr.routeCache.Len(),
+		AverageLatency:      sr.averageLatency(),
 		NodeStats:           make(map[NodeID]*NodeHealth),
 		StrategyStats:       make(map[RoutingStrategy]int64),
 		CircuitBreakerStats: make(map[NodeID]CircuitBreakerState),
-		LastUpdate:          time.Now(),
+		LastUpdate:          sr.clock.Now(),
 	}
 
 	// 复制节点统计
@@ -707,8 +997,10 @@ func (sr *SmartRouter) GetStats() *SmartRouterStats {
 	}
 
 	// 复制策略统计
-	for k, v := range sr.stats.StrategyStats {
-		statsCopy.StrategyStats[k] = v
+	for strategy := RoutingStrategy(0); strategy < routingStrategyCount; strategy++ {
+		if n := atomic.LoadInt64(&sr.strategyRequests[strategy]); n > 0 {
+			statsCopy.StrategyStats[strategy] = n
+		}
 	}
 
 	// 复制熔断器统计
@@ -724,18 +1016,8 @@ func (sr *SmartRouter) UpdateNodeHealth(nodeID NodeID, isHealthy bool, latency t
 	sr.mu.Lock()
 	defer sr.mu.Unlock()
 
-	health, exists := sr.nodeHealthMap[nodeID]
-	if !exists {
-		health = &NodeHealth{
-			NodeID:        nodeID,
-			Status:        NodeHealthy,
-			Weight:        1,
-			LastCheckTime: time.Now(),
-		}
-		sr.nodeHealthMap[nodeID] = health
-	}
-
-	health.LastCheckTime = time.Now()
+	health := sr.nodeHealthLocked(nodeID)
+	health.LastCheckTime = sr.clock.Now()
 	health.TotalRequests++
 
 	if isHealthy {
@@ -756,7 +1038,12 @@ func (sr *SmartRouter) UpdateNodeHealth(nodeID NodeID, isHealthy bool, latency t
 			health.LastError = err.Error()
 		}
 
-		if health.FailureCount >= sr.config.FailureThreshold {
+		// 正在排空的节点即将停止，不必等到连续失败FailureThreshold次
+		if health.FailureCount >= sr.config.FailureThreshold || errors.Is(err, ErrNodeDraining) {
+			// 节点转为不健康时，以它为目标的缓存结果立即失效，不必等待TTL
+			if health.Status != NodeUnhealthy {
+				sr.invalidateNodeLocked(nodeID)
+			}
 			health.Status = NodeUnhealthy
 		}
 	}
@@ -772,56 +1059,273 @@ func (sr *SmartRouter) UpdateNodeHealth(nodeID NodeID, isHealthy bool, latency t
 	}
 }
 
+// RecordResult 记录对节点的一次实际请求结果，客户端在每次请求完成后调用，为熔断器提供数据
+// 非领导者、键不存在、CAS冲突等错误说明节点正常响应，计为成功；
+// 连接池耗尽、熔断拒绝等在本地失败的请求没有到达节点，不计入；其余错误计为节点故障
+func (sr *SmartRouter) RecordResult(nodeID NodeID, err error, latency time.Duration) {
+	if !sr.config.CircuitBreakerEnabled {
+		return
+	}
+	if err != nil {
+		if notSent(err) {
+			return
+		}
+		if nodeResponded(err) {
+			err = nil
+		}
+	}
+
+	sr.ensureCircuitBreakers([]NodeID{nodeID})
+	sr.mu.RLock()
+	cb := sr.circuitBreakers[nodeID]
+	sr.mu.RUnlock()
+
+	if err == nil {
+		cb.OnSuccess(latency)
+		return
+	}
+
+	wasOpen := cb.GetState() == CircuitOpen
+	cb.OnFailure(latency)
+	if !wasOpen && cb.GetState() == CircuitOpen {
+		// 熔断后以该节点为目标的缓存结果立即失效
+		sr.InvalidateNode(nodeID)
+	}
+}
+
+// notSent 请求是否在客户端本地就失败了：连接池耗尽、熔断拒绝或没有可用节点
+func notSent(err error) bool {
+	return errors.Is(err, ErrPoolExhausted) || errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrNoHealthyNodes)
+}
+
+// nodeResponded 错误是否来自节点的正常响应：非领导者与键不存在、CAS冲突等业务错误
+func nodeResponded(err error) bool {
+	for _, target := range []error{ErrNotLeader, ErrKeyNotFound, ErrConflict, ErrInvalidArgument,
+		ErrAccessDenied, ErrValueTooLarge, ErrSessionExpired, ErrShardMigrating} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetNodeAddress 设置节点地址，之后的健康检查会探测该节点
+func (sr *SmartRouter) SetNodeAddress(nodeID NodeID, address string) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	sr.nodeAddresses[nodeID] = address
+	sr.nodeHealthLocked(nodeID)
+}
+
+// Resolve 查找节点地址，实现NodeAddressResolver
+func (sr *SmartRouter) Resolve(nodeID NodeID) (string, error) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	address, ok := sr.nodeAddresses[nodeID]
+	if !ok || address == "" {
+		return "", fmt.Errorf("节点 %s 没有配置地址", nodeID)
+	}
+	return address, nil
+}
+
+// SetHealthProber 替换健康探测器，默认请求节点的状态接口
+func (sr *SmartRouter) SetHealthProber(prober HealthProber) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.healthProber = prober
+}
+
+// SetLoadReporter 设置负载报告获取器，为nil时不再拉取负载报告
+func (sr *SmartRouter) SetLoadReporter(reporter LoadReporter) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.loadReporter = reporter
+}
+
+// UpdateNodeLoad 更新节点的QPS并重新判定各节点是否过载
+// 至少两个节点报告过负载时才有可比较的均值；节点是否过载发生变化时驱逐以它为目标的缓存结果
+func (sr *SmartRouter) UpdateNodeLoad(nodeID NodeID, qps float64) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	sr.nodeHealthLocked(nodeID).LoadQPS = qps
+
+	var total float64
+	reported := 0
+	for _, health := range sr.nodeHealthMap {
+		if health.LoadQPS > 0 {
+			total += health.LoadQPS
+			reported++
+		}
+	}
+
+	for id, health := range sr.nodeHealthMap {
+		overloaded := reported >= 2 && sr.config.OverloadFactor > 0 &&
+			health.LoadQPS > sr.config.OverloadFactor*total/float64(reported)
+		if overloaded != health.Overloaded {
+			health.Overloaded = overloaded
+			sr.invalidateNodeLocked(id)
+		}
+	}
+}
+
+// InvalidateNode 驱逐以该节点为主节点或目标节点的缓存路由结果
+func (sr *SmartRouter) InvalidateNode(nodeID NodeID) int {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.invalidateNodeLocked(nodeID)
+}
+
+// InvalidateShard 驱逐该分片的缓存路由结果，分片的主节点或副本变更后调用
+func (sr *SmartRouter) InvalidateShard(shardID string) int {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	return sr.invalidateLocked(func(entry *routeCacheEntry) bool {
+		return entry.result.ShardInfo != nil && entry.result.ShardInfo.ID == shardID
+	})
+}
+
+// InvalidateCache 清空路由缓存
+func (sr *SmartRouter) InvalidateCache() {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.routeCache.Purge()
+}
+
+// OnTopologyEvent 实现TopologyEventListener，分片变更时驱逐其缓存的路由结果
+func (sr *SmartRouter) OnTopologyEvent(event TopologyEvent) {
+	switch event.Type {
+	case EventShardAdded, EventShardRemoved, EventShardUpdated, EventShardMigration:
+		sr.InvalidateShard(event.ShardID)
+	case EventTopologyBatch:
+		for _, update := range event.Events {
+			sr.OnTopologyEvent(update)
+		}
+	}
+}
+
+// 内部方法：获取节点健康信息，不存在时按健康状态创建（调用方需持有写锁）
+func (sr *SmartRouter) nodeHealthLocked(nodeID NodeID) *NodeHealth {
+	health, exists := sr.nodeHealthMap[nodeID]
+	if !exists {
+		health = &NodeHealth{
+			NodeID:        nodeID,
+			Status:        NodeHealthy,
+			Weight:        1,
+			LastCheckTime: sr.clock.Now(),
+		}
+		sr.nodeHealthMap[nodeID] = health
+	}
+	return health
+}
+
+// 内部方法：驱逐以节点为目标的缓存结果（调用方需持有写锁）
+func (sr *SmartRouter) invalidateNodeLocked(nodeID NodeID) int {
+	return sr.invalidateLocked(func(entry *routeCacheEntry) bool {
+		return entry.targets(nodeID)
+	})
+}
+
+// 内部方法：驱逐满足条件的缓存结果（调用方需持有写锁）
+func (sr *SmartRouter) invalidateLocked(match func(entry *routeCacheEntry) bool) int {
+	var keys []string
+	sr.routeCache.Range(func(key string, entry *routeCacheEntry) bool {
+		if match(entry) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	for _, key := range keys {
+		sr.routeCache.Remove(key)
+	}
+	return len(keys)
+}
+
+// 内部方法：在已查到的分片内按策略选择目标节点，再经目标节点的熔断器放行
+func (sr *SmartRouter) routeShard(shardInfo *ShardInfo, req *RoutingRequest, start time.Time) (*RoutingResult, error) {
+	result := &RoutingResult{
+		PrimaryNode:  shardInfo.Primary,
+		ReplicaNodes: make([]NodeID, len(shardInfo.Replicas)),
+		ShardInfo:    shardInfo,
+		Strategy:     req.Strategy,
+		Latency:      sr.clock.Now().Sub(start),
+		Cached:       false,
+	}
+	copy(result.ReplicaNodes, shardInfo.Replicas)
+
+	if sr.config.CircuitBreakerEnabled {
+		sr.ensureCircuitBreakers(append([]NodeID{result.PrimaryNode}, result.ReplicaNodes...))
+	}
+
+	targetNode, backupNodes, err := sr.selectTargetNode(result, req)
+	if err == nil {
+		targetNode, backupNodes, err = sr.admitTargetNode(targetNode, backupNodes, req.Strategy)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result.TargetNode = targetNode
+	result.BackupNodes = backupNodes
+	return result, nil
+}
+
 // 内部方法：选择目标节点
 func (sr *SmartRouter) selectTargetNode(result *RoutingResult, req *RoutingRequest) (NodeID, []NodeID, error) {
 	allNodes := append([]NodeID{result.PrimaryNode}, result.ReplicaNodes...)
 	availableNodes := sr.filterHealthyNodes(allNodes)
 
 	if len(availableNodes) == 0 {
-		return "", nil, errors.New("没有可用的健康节点")
+		return "", nil, ErrNoHealthyNodes
 	}
 
 	var targetNode NodeID
 	var err error
+	loc := sr.localityFor(req)
 
 	switch req.Strategy {
 	case RoutingWritePrimary:
 		// 写请求必须路由到主节点
-		if sr.isNodeHealthy(result.PrimaryNode) {
+		if sr.isNodeAvailable(result.PrimaryNode) {
 			targetNode = result.PrimaryNode
 		} else {
-			return "", nil, errors.New("主节点不可用")
+			return "", nil, fmt.Errorf("%w: 主节点不可用", ErrNoHealthyNodes)
 		}
 
 	case RoutingReadReplica:
 		// 读请求优先路由到副本节点
 		healthyReplicas := sr.filterHealthyNodes(result.ReplicaNodes)
 		if len(healthyReplicas) > 0 {
-			targetNode, err = sr.loadBalancer.Select(healthyReplicas, req.Key)
-		} else if sr.isNodeHealthy(result.PrimaryNode) {
+			// 只在距离最近的一组副本之间负载均衡
+			targetNode, err = sr.loadBalancer.Select(sr.nearestTier(healthyReplicas, result.ShardInfo, loc), req.Key)
+		} else if sr.isNodeAvailable(result.PrimaryNode) {
 			targetNode = result.PrimaryNode
 		} else {
-			return "", nil, errors.New("没有可用的副本节点")
+			return "", nil, fmt.Errorf("%w: 没有可用的副本节点", ErrNoHealthyNodes)
 		}
 
 	case RoutingReadNearest:
-		// 路由到延迟最低的节点
-		targetNode = sr.selectNearestNode(availableNodes)
+		// 路由到最近的节点：先按所在位置，再按延迟
+		availableNodes = sr.orderByLocality(availableNodes, result.ShardInfo, loc)
+		targetNode = availableNodes[0]
 
 	case RoutingLoadBalance:
-		// 负载均衡选择
-		targetNode, err = sr.loadBalancer.Select(availableNodes, req.Key)
+		// 负载均衡选择，有其他节点可选时避开过载节点
+		targetNode, err = sr.loadBalancer.Select(sr.filterOverloadedNodes(availableNodes), req.Key)
 
 	case RoutingFailover:
 		// 故障转移，尝试主节点，失败则选择副本
-		if sr.isNodeHealthy(result.PrimaryNode) {
+		if sr.isNodeAvailable(result.PrimaryNode) {
 			targetNode = result.PrimaryNode
 		} else {
 			healthyReplicas := sr.filterHealthyNodes(result.ReplicaNodes)
 			if len(healthyReplicas) > 0 {
 				targetNode, err = sr.loadBalancer.Select(healthyReplicas, req.Key)
 			} else {
-				return "", nil, errors.New("所有节点都不可用")
+				return "", nil, fmt.Errorf("%w: 所有节点都不可用", ErrNoHealthyNodes)
 			}
 		}
 
@@ -833,7 +1337,10 @@ func (sr *SmartRouter) selectTargetNode(result *RoutingResult, req *RoutingReque
 		return "", nil, err
 	}
 
-	// 生成备用节点列表
+	// 生成备用节点列表，读请求的备用节点同样由近及远
+	if req.Strategy == RoutingReadReplica {
+		availableNodes = sr.orderByLocality(availableNodes, result.ShardInfo, loc)
+	}
 	backupNodes := make([]NodeID, 0)
 	for _, node := range availableNodes {
 		if node != targetNode {
@@ -844,6 +1351,74 @@ func (sr *SmartRouter) selectTargetNode(result *RoutingResult, req *RoutingReque
 	return targetNode, backupNodes, nil
 }
 
+// 内部方法：为节点按需创建熔断器
+func (sr *SmartRouter) ensureCircuitBreakers(nodes []NodeID) {
+	sr.mu.RLock()
+	missing := false
+	for _, node := range nodes {
+		if _, exists := sr.circuitBreakers[node]; !exists {
+			missing = true
+			break
+		}
+	}
+	sr.mu.RUnlock()
+	if !missing {
+		return
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	for _, node := range nodes {
+		if _, exists := sr.circuitBreakers[node]; !exists {
+			sr.circuitBreakers[node] = NewCircuitBreaker(sr.config)
+		}
+	}
+}
+
+// 内部方法：经熔断器放行目标节点，被拒绝时依次尝试备用节点（写请求只能发往主节点）
+func (sr *SmartRouter) admitTargetNode(targetNode NodeID, backupNodes []NodeID, strategy RoutingStrategy) (NodeID, []NodeID, error) {
+	candidates := []NodeID{targetNode}
+	if strategy != RoutingWritePrimary {
+		candidates = append(candidates, backupNodes...)
+	}
+
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	for _, node := range candidates {
+		if cb, exists := sr.circuitBreakers[node]; exists && !cb.AllowRequest() {
+			continue
+		}
+		if node == targetNode {
+			return targetNode, backupNodes, nil
+		}
+
+		// 被熔断器拒绝的原目标节点不再作为备用节点
+		remaining := make([]NodeID, 0, len(backupNodes))
+		for _, backup := range backupNodes {
+			if backup != node {
+				remaining = append(remaining, backup)
+			}
+		}
+		return node, remaining, nil
+	}
+
+	return "", nil, fmt.Errorf("没有可放行的节点: %w", &CircuitOpenError{NodeID: targetNode})
+}
+
+// 内部方法：检查节点的熔断器是否都处于关闭状态
+func (sr *SmartRouter) circuitsClosed(nodes []NodeID) bool {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	for _, node := range nodes {
+		if cb, exists := sr.circuitBreakers[node]; exists && cb.GetState() != CircuitClosed {
+			return false
+		}
+	}
+	return true
+}
+
 // 内部方法：过滤健康节点
 func (sr *SmartRouter) filterHealthyNodes(nodes []NodeID) []NodeID {
 	sr.mu.RLock()
@@ -858,69 +1433,70 @@ func (sr *SmartRouter) filterHealthyNodes(nodes []NodeID) []NodeID {
 	return healthyNodes
 }
 
-// 内部方法：检查节点是否健康
-func (sr *SmartRouter) isNodeHealthy(nodeID NodeID) bool {
-	health, exists := sr.nodeHealthMap[nodeID]
-	if !exists {
-		return true // 未知节点默认为健康
-	}
+// 内部方法：过滤过载节点，所有节点都过载时原样返回
+func (sr *SmartRouter) filterOverloadedNodes(nodes []NodeID) []NodeID {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
 
-	// 检查熔断器状态
-	if cb, exists := sr.circuitBreakers[nodeID]; exists {
-		if cb.GetState() == CircuitOpen {
-			return false
+	remaining := make([]NodeID, 0, len(nodes))
+	for _, node := range nodes {
+		if health, exists := sr.nodeHealthMap[node]; !exists || !health.Overloaded {
+			remaining = append(remaining, node)
 		}
 	}
-
-	return health.Status == NodeHealthy || health.Status == NodeRecovering
-}
-
-// 内部方法：选择最近的节点
-func (sr *SmartRouter) selectNearestNode(nodes []NodeID) NodeID {
-	if len(nodes) == 0 {
-		return ""
+	if len(remaining) == 0 {
+		return nodes
 	}
+	return remaining
+}
 
+// 内部方法：加锁检查节点是否健康
+func (sr *SmartRouter) isNodeAvailable(nodeID NodeID) bool {
 	sr.mu.RLock()
 	defer sr.mu.RUnlock()
+	return sr.isNodeHealthy(nodeID)
+}
 
-	nearestNode := nodes[0]
-	minLatency := time.Duration(math.MaxInt64)
+// 内部方法：检查节点是否健康（调用方需持有锁）
+func (sr *SmartRouter) isNodeHealthy(nodeID NodeID) bool {
+	health, exists := sr.nodeHealthMap[nodeID]
+	if !exists {
+		return true // 未知节点默认为健康
+	}
 
-	for _, node := range nodes {
-		if health, exists := sr.nodeHealthMap[node]; exists {
-			if health.AverageLatency < minLatency {
-				minLatency = health.AverageLatency
-				nearestNode = node
-			}
-		}
+	// 检查熔断器状态
+	if cb, exists := sr.circuitBreakers[nodeID]; exists && !cb.Available() {
+		return false
 	}
 
-	return nearestNode
+	return health.Status == NodeHealthy || health.Status == NodeRecovering
 }
 
 // 内部方法：生成缓存键
 func (sr *SmartRouter) generateCacheKey(req *RoutingRequest) string {
-	return fmt.Sprintf("%s:%s:%t", req.Key, req.Strategy.String(), req.ReadOnly)
+	return fmt.Sprintf("%s:%s:%t:%s:%s", req.Key, req.Strategy.String(), req.ReadOnly, req.PreferredDC, req.PreferredZone)
 }
 
 // 内部方法：从缓存获取
 func (sr *SmartRouter) getFromCache(key string) (*RoutingResult, bool) {
-	sr.mu.RLock()
-	defer sr.mu.RUnlock()
+	// 命中时需要调整LRU顺序，因此持有写锁
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
 
-	result, exists := sr.routeCache[key]
+	entry, exists := sr.routeCache.Peek(key)
 	if !exists {
 		return nil, false
 	}
 
-	// 检查TTL
-	if time.Since(result.Latency) > sr.config.CacheTTL {
+	// 检查TTL，过期条目直接删除
+	if sr.config.CacheTTL > 0 && sr.clock.Now().Sub(entry.cachedAt) > sr.config.CacheTTL {
+		sr.routeCache.Remove(key)
 		return nil, false
 	}
+	sr.routeCache.Get(key)
 
 	// 返回副本
-	resultCopy := *result
+	resultCopy := *entry.result
 	return &resultCopy, true
 }
 
@@ -929,26 +1505,14 @@ func (sr *SmartRouter) addToCache(key string, result *RoutingResult) {
 	sr.mu.Lock()
 	defer sr.mu.Unlock()
 
-	// 检查缓存大小限制
-	if len(sr.routeCache) >= sr.config.CacheSize {
-		// 简单清理：删除一半的缓存
-		for k := range sr.routeCache {
-			delete(sr.routeCache, k)
-			if len(sr.routeCache) <= sr.config.CacheSize/2 {
-				break
-			}
-		}
-	}
-
-	// 添加到缓存
+	// 添加到缓存，超过容量时淘汰最久未使用的结果
 	resultCopy := *result
-	resultCopy.Latency = time.Now().Sub(time.Now()) // 记录缓存时间
-	sr.routeCache[key] = &resultCopy
+	sr.routeCache.Add(key, &routeCacheEntry{result: &resultCopy, cachedAt: sr.clock.Now()})
 }
 
 // 内部方法：健康检查循环
 func (sr *SmartRouter) healthCheckLoop(ctx context.Context) {
-	ticker := time.NewTicker(sr.config.HealthCheckInterval)
+	ticker := sr.clock.NewTicker(sr.config.HealthCheckInterval)
 	defer ticker.Stop()
 
 	for {
@@ -957,49 +1521,118 @@ func (sr *SmartRouter) healthCheckLoop(ctx context.Context) {
 			return
 		case <-sr.stopChannel:
 			return
-		case <-ticker.C:
-			sr.performHealthCheck()
+		case <-ticker.C():
+			sr.performHealthCheck(ctx)
 		}
 	}
 }
 
 // 内部方法：执行健康检查
-func (sr *SmartRouter) performHealthCheck() {
+// 没有地址的节点无法探测，其健康状态只由应用调用UpdateNodeHealth更新
+func (sr *SmartRouter) performHealthCheck(ctx context.Context) {
 	sr.mu.RLock()
-	nodes := make([]NodeID, 0, len(sr.nodeHealthMap))
-	for nodeID := range sr.nodeHealthMap {
-		nodes = append(nodes, nodeID)
+	prober := sr.healthProber
+	addresses := make(map[NodeID]string, len(sr.nodeAddresses))
+	for nodeID, address := range sr.nodeAddresses {
+		addresses[nodeID] = address
 	}
 	sr.mu.RUnlock()
 
+	if prober == nil {
+		return
+	}
+
 	// 并发检查所有节点
 	var wg sync.WaitGroup
-	for _, nodeID := range nodes {
+	for nodeID, address := range addresses {
 		wg.Add(1)
-		go func(nid NodeID) {
+		go func(nid NodeID, addr string) {
 			defer wg.Done()
-			sr.checkNodeHealth(nid)
-		}(nodeID)
+			sr.checkNodeHealth(ctx, prober, nid, addr)
+		}(nodeID, address)
 	}
 	wg.Wait()
 }
 
-// 内部方法：检查单个节点健康状态
-func (sr *SmartRouter) checkNodeHealth(nodeID NodeID) {
-	start := time.Now()
+// 内部方法：检查单个节点健康状态，单次探测的超时为NodeTimeout
+func (sr *SmartRouter) checkNodeHealth(ctx context.Context, prober HealthProber, nodeID NodeID, address string) {
+	if sr.config.NodeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sr.config.NodeTimeout)
+		defer cancel()
+	}
 
-	// TODO: 实现实际的健康检查逻辑
-	// 这里应该发送ping请求或健康检查请求到节点
+	latency, err := prober.Probe(ctx, nodeID, address)
+	if err != nil {
+		// 失败的探测延迟不计入平均延迟
+		sr.UpdateNodeHealth(nodeID, false, 0, err)
+		return
+	}
+	sr.UpdateNodeHealth(nodeID, true, latency, nil)
+}
 
-	// 模拟健康检查
-	latency := time.Since(start)
-	isHealthy := true // 暂时默认为健康
+// 内部方法：负载报告拉取循环
+func (sr *SmartRouter) loadReportLoop(ctx context.Context) {
+	ticker := sr.clock.NewTicker(sr.config.LoadReportInterval)
+	defer ticker.Stop()
 
-	sr.UpdateNodeHealth(nodeID, isHealthy, latency, nil)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sr.stopChannel:
+			return
+		case <-ticker.C():
+			sr.performLoadReport(ctx)
+		}
+	}
+}
+
+// 内部方法：并发拉取有地址的节点的负载报告，拉取失败的节点保留上一次的QPS
+func (sr *SmartRouter) performLoadReport(ctx context.Context) {
+	sr.mu.RLock()
+	reporter := sr.loadReporter
+	addresses := make(map[NodeID]string, len(sr.nodeAddresses))
+	for nodeID, address := range sr.nodeAddresses {
+		addresses[nodeID] = address
+	}
+	sr.mu.RUnlock()
+
+	if reporter == nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for nodeID, address := range addresses {
+		wg.Add(1)
+		go func(nid NodeID, addr string) {
+			defer wg.Done()
+			sr.fetchNodeLoad(ctx, reporter, nid, addr)
+		}(nodeID, address)
+	}
+	wg.Wait()
+}
+
+// 内部方法：拉取单个节点的负载报告，单次请求的超时为NodeTimeout
+func (sr *SmartRouter) fetchNodeLoad(ctx context.Context, reporter LoadReporter, nodeID NodeID, address string) {
+	if sr.config.NodeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sr.config.NodeTimeout)
+		defer cancel()
+	}
+
+	report, err := reporter.Report(ctx, nodeID, address)
+	if err != nil {
+		return
+	}
+	sr.UpdateNodeLoad(nodeID, report.TotalQPS())
 }
 
 // 内部方法：更新平均延迟
 func (sr *SmartRouter) updateAverageLatency(latency time.Duration) {
+	sr.latencyMu.Lock()
+	defer sr.latencyMu.Unlock()
+
 	if sr.stats.AverageLatency == 0 {
 		sr.stats.AverageLatency = latency
 	} else {
@@ -1007,3 +1640,10 @@ func (sr *SmartRouter) updateAverageLatency(latency time.Duration) {
 		sr.stats.AverageLatency = time.Duration(float64(sr.stats.AverageLatency)*0.9 + float64(latency)*0.1)
 	}
 }
+
+// 内部方法：读取平均延迟
+func (sr *SmartRouter) averageLatency() time.Duration {
+	sr.latencyMu.Lock()
+	defer sr.latencyMu.Unlock()
+	return sr.stats.AverageLatency
+}
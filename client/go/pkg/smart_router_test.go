@@ -0,0 +1,733 @@
+/*
+* @Author: Lzww0608
+* @Date: 22025-7-2 22:23:37
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-2 22:23:40
+* @Description: ConcordKV intelligent client - smart router tests
+ */
+
+package concord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/concordkv/client/go/keyhash"
+)
+
+// newTestRouter 创建路由键k到shard-0的路由器，主节点为node1
+func newTestRouter(ttl time.Duration) (*SmartRouter, *TopologyCache) {
+	cache := NewTopologyCache(nil)
+	cache.Set(testShard("node1", 1))
+
+	config := DefaultSmartRouterConfig()
+	config.CacheTTL = ttl
+	config.HealthCheckInterval = 0
+	return NewSmartRouter(config, cache), cache
+}
+
+// routeWrite 路由写请求，返回目标节点及是否来自缓存
+func routeWrite(t *testing.T, router *SmartRouter) (NodeID, bool) {
+	t.Helper()
+
+	result, err := router.Route(&RoutingRequest{Key: "k", Strategy: RoutingWritePrimary})
+	if err != nil {
+		t.Fatalf("路由失败: %v", err)
+	}
+	return result.TargetNode, result.Cached
+}
+
+// TestSmartRouterCacheTTL 主节点变更前缓存的路由结果在TTL之后不再返回
+func TestSmartRouterCacheTTL(t *testing.T) {
+	router, cache := newTestRouter(50 * time.Millisecond)
+
+	if target, cached := routeWrite(t, router); target != "node1" || cached {
+		t.Fatalf("首次路由应指向node1且不来自缓存，实际 %s cached=%v", target, cached)
+	}
+
+	cache.Set(testShard("node2", 2))
+	if target, cached := routeWrite(t, router); target != "node1" || !cached {
+		t.Fatalf("TTL之内应返回缓存结果，实际 %s cached=%v", target, cached)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if target, cached := routeWrite(t, router); target != "node2" || cached {
+		t.Fatalf("TTL之后应重新路由到新主节点，实际 %s cached=%v", target, cached)
+	}
+	if target, cached := routeWrite(t, router); target != "node2" || !cached {
+		t.Fatalf("重新路由的结果应被缓存，实际 %s cached=%v", target, cached)
+	}
+}
+
+// TestSmartRouterInvalidation 节点转为不健康或分片变更时立即驱逐缓存的路由结果
+func TestSmartRouterInvalidation(t *testing.T) {
+	router, cache := newTestRouter(time.Hour)
+
+	routeWrite(t, router)
+	cache.Set(testShard("node2", 2))
+
+	// 未达到故障阈值时节点仍健康，缓存不受影响
+	failure := errors.New("连接被拒绝")
+	for i := 1; i < router.config.FailureThreshold; i++ {
+		router.UpdateNodeHealth("node1", false, 0, failure)
+	}
+	if target, cached := routeWrite(t, router); target != "node1" || !cached {
+		t.Fatalf("节点仍健康时应返回缓存结果，实际 %s cached=%v", target, cached)
+	}
+
+	router.UpdateNodeHealth("node1", false, 0, failure)
+	if target, cached := routeWrite(t, router); target != "node2" || cached {
+		t.Fatalf("主节点不健康后不应返回缓存结果，实际 %s cached=%v", target, cached)
+	}
+
+	// 拓扑事件驱逐该分片的缓存结果
+	cache.Set(testShard("node3", 3))
+	router.OnTopologyEvent(TopologyEvent{Type: EventShardMigration, ShardID: "shard-0", Version: 3})
+	if target, cached := routeWrite(t, router); target != "node3" || cached {
+		t.Fatalf("分片变更后应重新路由，实际 %s cached=%v", target, cached)
+	}
+
+	if evicted := router.InvalidateNode("node3"); evicted != 1 {
+		t.Errorf("应驱逐1个以node3为目标的结果，实际 %d", evicted)
+	}
+}
+
+// TestSmartRouterCacheEviction 超过CacheSize时淘汰最久未使用的路由结果
+func TestSmartRouterCacheEviction(t *testing.T) {
+	cache := NewTopologyCache(nil)
+	cache.Set(testShard("node1", 1))
+
+	config := DefaultSmartRouterConfig()
+	config.CacheSize = 2
+	config.HealthCheckInterval = 0
+	router := NewSmartRouter(config, cache)
+
+	route := func(key string) bool {
+		result, err := router.Route(&RoutingRequest{Key: key, Strategy: RoutingWritePrimary})
+		if err != nil {
+			t.Fatalf("路由失败: %v", err)
+		}
+		return result.Cached
+	}
+
+	route("a")
+	route("b")
+	route("a") // a变为最近使用
+	route("c") // 淘汰b
+
+	if !route("a") || !route("c") {
+		t.Errorf("最近使用的a和c应仍在缓存中")
+	}
+	if route("b") {
+		t.Errorf("最久未使用的b应已被淘汰")
+	}
+
+	stats := router.GetStats()
+	if stats.CacheEvictions != 2 || stats.CacheSize != 2 {
+		t.Errorf("期望淘汰2次且缓存2个条目，实际淘汰 %d 次、缓存 %d 个", stats.CacheEvictions, stats.CacheSize)
+	}
+}
+
+// zipfKeys 生成服从Zipf分布的键序列，少数热点键占大部分访问
+func zipfKeys(n int, keySpace uint64) []string {
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, keySpace-1)
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", zipf.Uint64())
+	}
+	return keys
+}
+
+// deleteHalfCache 旧版路由缓存的淘汰策略：写满后随机删除一半条目，仅用于基准对比
+type deleteHalfCache struct {
+	capacity int
+	entries  map[string]struct{}
+}
+
+func (c *deleteHalfCache) access(key string) bool {
+	if _, ok := c.entries[key]; ok {
+		return true
+	}
+	if len(c.entries) >= c.capacity {
+		for k := range c.entries {
+			delete(c.entries, k)
+			if len(c.entries) <= c.capacity/2 {
+				break
+			}
+		}
+	}
+	c.entries[key] = struct{}{}
+	return false
+}
+
+// BenchmarkRouteCacheZipf 比较Zipf访问模式下LRU与旧版删除一半策略的命中率
+func BenchmarkRouteCacheZipf(b *testing.B) {
+	const (
+		keySpace  = 100000
+		cacheSize = 1000
+	)
+	keys := zipfKeys(1<<16, keySpace)
+
+	b.Run("lru", func(b *testing.B) {
+		cache := NewTopologyCache(nil)
+		cache.Set(testShard("node1", 1))
+		config := DefaultSmartRouterConfig()
+		config.CacheSize = cacheSize
+		config.HealthCheckInterval = 0
+		router := NewSmartRouter(config, cache)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			router.Route(&RoutingRequest{Key: keys[i%len(keys)], Strategy: RoutingWritePrimary})
+		}
+		stats := router.GetStats()
+		b.ReportMetric(float64(stats.CacheHits)/float64(stats.CacheHits+stats.CacheMisses), "hit-ratio")
+	})
+
+	b.Run("delete-half", func(b *testing.B) {
+		cache := &deleteHalfCache{capacity: cacheSize, entries: make(map[string]struct{})}
+		hits := 0
+		for i := 0; i < b.N; i++ {
+			if cache.access(keys[i%len(keys)]) {
+				hits++
+			}
+		}
+		b.ReportMetric(float64(hits)/float64(b.N), "hit-ratio")
+	})
+}
+
+// mockProber 可切换各节点探测结果的健康探测器
+type mockProber struct {
+	mu     sync.Mutex
+	down   map[NodeID]bool
+	probes map[NodeID]string // 节点 -> 最近一次探测的地址
+}
+
+func (p *mockProber) Probe(ctx context.Context, nodeID NodeID, address string) (time.Duration, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := ctx.Deadline(); !ok {
+		return 0, errors.New("探测缺少超时")
+	}
+	p.probes[nodeID] = address
+	if p.down[nodeID] {
+		return 0, errors.New("节点无响应")
+	}
+	return time.Millisecond, nil
+}
+
+func (p *mockProber) setDown(nodeID NodeID, down bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.down[nodeID] = down
+}
+
+// nodeStatus 读取节点健康状态
+func nodeStatus(router *SmartRouter, nodeID NodeID) NodeHealthStatus {
+	return router.GetStats().NodeStats[nodeID].Status
+}
+
+// TestSmartRouterHealthProbe 停止响应的节点在FailureThreshold个检查周期内转为不健康，恢复后重新变为健康
+func TestSmartRouterHealthProbe(t *testing.T) {
+	const interval = 20 * time.Millisecond
+
+	config := DefaultSmartRouterConfig()
+	config.HealthCheckInterval = interval
+	config.NodeAddresses = map[NodeID]string{"node1": "127.0.0.1:18081"}
+	router := NewSmartRouter(config, NewTopologyCache(nil))
+	router.SetNodeAddress("node2", "127.0.0.1:18082")
+
+	prober := &mockProber{down: make(map[NodeID]bool), probes: make(map[NodeID]string)}
+	router.SetHealthProber(prober)
+
+	if err := router.Start(context.Background()); err != nil {
+		t.Fatalf("启动路由器失败: %v", err)
+	}
+	defer router.Stop()
+
+	time.Sleep(2 * interval)
+	prober.setDown("node1", true)
+	stoppedAt := time.Now()
+
+	deadline := stoppedAt.Add(time.Duration(config.FailureThreshold+2) * interval)
+	for nodeStatus(router, "node1") != NodeUnhealthy {
+		if time.Now().After(deadline) {
+			t.Fatalf("节点停止响应 %v 后仍为 %v", time.Since(stoppedAt), nodeStatus(router, "node1"))
+		}
+		time.Sleep(interval / 4)
+	}
+	if status := nodeStatus(router, "node2"); status != NodeHealthy {
+		t.Errorf("node2应保持健康，实际 %v", status)
+	}
+
+	prober.setDown("node1", false)
+	deadline = time.Now().Add(time.Duration(config.RecoveryThreshold+2) * interval)
+	for nodeStatus(router, "node1") != NodeHealthy {
+		if time.Now().After(deadline) {
+			t.Fatalf("节点恢复后仍为 %v", nodeStatus(router, "node1"))
+		}
+		time.Sleep(interval / 4)
+	}
+
+	prober.mu.Lock()
+	defer prober.mu.Unlock()
+	if prober.probes["node1"] != "127.0.0.1:18081" || prober.probes["node2"] != "127.0.0.1:18082" {
+		t.Errorf("探测地址不正确: %v", prober.probes)
+	}
+}
+
+// TestSmartRouterCircuitBreaker 故障率超过阈值后节点不再被选中，半开探测成功后重新启用
+func TestSmartRouterCircuitBreaker(t *testing.T) {
+	const openTimeout = 50 * time.Millisecond
+
+	cache := NewTopologyCache(nil)
+	shard := testShard("node1", 1)
+	shard.Replicas = []NodeID{"node2"}
+	cache.Set(shard)
+
+	config := DefaultSmartRouterConfig()
+	config.HealthCheckInterval = 0
+	config.MinRequestThreshold = 4
+	config.FailureRateThreshold = 0.5
+	config.CircuitOpenTimeout = openTimeout
+	config.HalfOpenMaxCalls = 2
+	router := NewSmartRouter(config, cache)
+
+	route := func(strategy RoutingStrategy) (NodeID, []NodeID, error) {
+		result, err := router.Route(&RoutingRequest{Key: "k", Strategy: strategy})
+		if err != nil {
+			return "", nil, err
+		}
+		return result.TargetNode, result.BackupNodes, nil
+	}
+
+	if target, _, _ := route(RoutingFailover); target != "node1" {
+		t.Fatalf("熔断前应路由到主节点，实际 %s", target)
+	}
+
+	// 故障率 3/4 超过阈值，熔断器开启
+	failure := errors.New("请求超时")
+	router.RecordResult("node1", nil, time.Millisecond)
+	for i := 0; i < 3; i++ {
+		router.RecordResult("node1", failure, time.Millisecond)
+	}
+	if state := router.GetStats().CircuitBreakerStats["node1"]; state != CircuitOpen {
+		t.Fatalf("熔断器应开启，实际 %v", state)
+	}
+
+	if target, backups, err := route(RoutingFailover); err != nil || target != "node2" || len(backups) != 0 {
+		t.Fatalf("熔断后应改用副本且不把熔断节点作为备用，实际 %s %v %v", target, backups, err)
+	}
+	var open *CircuitOpenError
+	if _, _, err := route(RoutingWritePrimary); !errors.As(err, &open) || open.NodeID != "node1" {
+		t.Fatalf("主节点熔断时写请求应返回node1的CircuitOpenError，实际 %v", err)
+	}
+
+	// 开启超时后进入半开状态，只放行HalfOpenMaxCalls个请求
+	time.Sleep(openTimeout + 10*time.Millisecond)
+	for i := 0; i < config.HalfOpenMaxCalls; i++ {
+		if target, _, _ := route(RoutingFailover); target != "node1" {
+			t.Fatalf("半开状态第 %d 个请求应探测主节点，实际 %s", i+1, target)
+		}
+	}
+	if target, _, _ := route(RoutingFailover); target != "node2" {
+		t.Fatalf("半开状态名额用完后应改用副本，实际 %s", target)
+	}
+
+	for i := 0; i < config.HalfOpenMaxCalls; i++ {
+		router.RecordResult("node1", nil, time.Millisecond)
+	}
+	if state := router.GetStats().CircuitBreakerStats["node1"]; state != CircuitClosed {
+		t.Fatalf("探测成功后熔断器应关闭，实际 %v", state)
+	}
+	for i := 0; i < 3; i++ {
+		if target, _, _ := route(RoutingFailover); target != "node1" {
+			t.Fatalf("恢复后应重新路由到主节点，实际 %s", target)
+		}
+	}
+
+	// 恢复后重新统计故障率，一次失败不会再次熔断
+	router.RecordResult("node1", failure, time.Millisecond)
+	if state := router.GetStats().CircuitBreakerStats["node1"]; state != CircuitClosed {
+		t.Errorf("恢复后单次失败不应熔断，实际 %v", state)
+	}
+}
+
+// TestSmartRouterTypedErrors 路由错误可用errors.Is/As判断；节点正常响应的业务错误与本地错误不触发熔断
+func TestSmartRouterTypedErrors(t *testing.T) {
+	cache := NewTopologyCache(nil)
+	config := DefaultSmartRouterConfig()
+	config.HealthCheckInterval = 0
+	config.MinRequestThreshold = 4
+	config.FailureRateThreshold = 0.5
+	router := NewSmartRouter(config, cache)
+
+	_, err := router.Route(&RoutingRequest{Key: "k", Strategy: RoutingWritePrimary})
+	var notFound *ShardNotFoundError
+	if !errors.Is(err, ErrShardNotFound) || !errors.As(err, &notFound) || notFound.Key != "k" {
+		t.Fatalf("没有分片时应返回ShardNotFoundError，实际 %v", err)
+	}
+
+	cache.Set(testShard("node1", 1))
+	for _, err := range []error{ErrKeyNotFound, fmt.Errorf("%w: 版本 3", ErrConflict), &NotLeaderError{LeaderID: "node2"},
+		&PoolExhaustedError{QueueLen: 8}, ErrCircuitOpen} {
+		router.RecordResult("node1", err, time.Millisecond)
+	}
+	if state := router.GetStats().CircuitBreakerStats["node1"]; state != CircuitClosed {
+		t.Fatalf("业务错误与本地错误不应触发熔断，实际 %v", state)
+	}
+
+	for i := 0; i < config.FailureThreshold; i++ {
+		router.UpdateNodeHealth("node1", false, 0, errors.New("连接被拒绝"))
+	}
+	_, err = router.Route(&RoutingRequest{Key: "k", Strategy: RoutingWritePrimary})
+	if !errors.Is(err, ErrNoHealthyNodes) || ClassifyError(err) != ErrorClassUnavailable {
+		t.Fatalf("主节点不健康时应返回ErrNoHealthyNodes，实际 %v", err)
+	}
+}
+
+// TestConsistentHashRingWeights 哈希空间按权重分配，移除后以不同权重重新加入时环保持一致
+func TestConsistentHashRingWeights(t *testing.T) {
+	ring := NewConsistentHashRing(200)
+	ring.AddNode("node1")
+	ring.AddNode("node2")
+	if err := ring.AddNodeWithWeight("node3", 2); err != nil {
+		t.Fatalf("添加节点失败: %v", err)
+	}
+	if err := ring.AddNodeWithWeight("node4", 0); err == nil {
+		t.Fatalf("权重为0时应返回错误")
+	}
+
+	expected := map[NodeID]float64{"node1": 0.25, "node2": 0.25, "node3": 0.5}
+	assertDistribution(t, ring, expected, 0.05)
+
+	// 以不同权重重新加入后与直接按该权重构建的环完全相同
+	ring.RemoveNode("node3")
+	ring.AddNodeWithWeight("node3", 1)
+	ring.RemoveNode("node1")
+	ring.AddNodeWithWeight("node1", 3)
+
+	fresh := NewConsistentHashRing(200)
+	fresh.AddNodeWithWeight("node1", 3)
+	fresh.AddNode("node2")
+	fresh.AddNode("node3")
+	if !slices.Equal(ring.sortedHashes, fresh.sortedHashes) || len(ring.sortedHashes) != len(ring.ring) {
+		t.Fatalf("重新加入节点后排序哈希不一致: %d / %d / %d", len(ring.sortedHashes), len(fresh.sortedHashes), len(ring.ring))
+	}
+	if !slices.IsSorted(ring.sortedHashes) {
+		t.Fatalf("排序哈希未排序")
+	}
+	assertDistribution(t, ring, map[NodeID]float64{"node1": 0.6, "node2": 0.2, "node3": 0.2}, 0.05)
+	if ring.Weight("node1") != 3 || ring.Weight("node4") != 0 {
+		t.Errorf("权重不正确: %d %d", ring.Weight("node1"), ring.Weight("node4"))
+	}
+
+	// 按分布比例统计实际键的落点
+	counts := make(map[NodeID]int)
+	for i := 0; i < 20000; i++ {
+		node, err := ring.GetNode(fmt.Sprintf("key-%d", i))
+		if err != nil {
+			t.Fatalf("获取节点失败: %v", err)
+		}
+		counts[node]++
+	}
+	for node, share := range ring.Distribution() {
+		if got := float64(counts[node]) / 20000; math.Abs(got-share) > 0.03 {
+			t.Errorf("节点 %s 的键比例 %.3f 与哈希空间比例 %.3f 相差过大", node, got, share)
+		}
+	}
+}
+
+// assertDistribution 校验各节点拥有的哈希空间比例在容差范围内且总和为1
+func assertDistribution(t *testing.T, ring *ConsistentHashRing, expected map[NodeID]float64, tolerance float64) {
+	t.Helper()
+
+	distribution := ring.Distribution()
+	var total float64
+	for node, share := range distribution {
+		total += share
+		if math.Abs(share-expected[node]) > tolerance {
+			t.Errorf("节点 %s 的哈希空间比例 %.3f，期望 %.3f±%.2f", node, share, expected[node], tolerance)
+		}
+	}
+	if len(distribution) != len(expected) || math.Abs(total-1) > 1e-9 {
+		t.Errorf("分布不完整: %v", distribution)
+	}
+}
+
+// TestConsistentHashRingGetNodes 顺时针返回n个不同节点，第一个与GetNode一致，节点不足时返回全部
+func TestConsistentHashRingGetNodes(t *testing.T) {
+	ring := NewConsistentHashRing(50)
+	if _, err := ring.GetNodes("k", 2); err == nil {
+		t.Fatalf("空环应返回错误")
+	}
+	for i := 1; i <= 5; i++ {
+		ring.AddNode(NodeID(fmt.Sprintf("node%d", i)))
+	}
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		nodes, err := ring.GetNodes(key, 3)
+		if err != nil || len(nodes) != 3 {
+			t.Fatalf("应返回3个节点: %v, %v", nodes, err)
+		}
+		if first, _ := ring.GetNode(key); nodes[0] != first {
+			t.Fatalf("第一个副本应为GetNode的结果: %s != %s", nodes[0], first)
+		}
+		if nodes[0] == nodes[1] || nodes[1] == nodes[2] || nodes[0] == nodes[2] {
+			t.Fatalf("副本节点重复: %v", nodes)
+		}
+	}
+
+	if nodes, _ := ring.GetNodes("k", 10); len(nodes) != 5 {
+		t.Errorf("节点不足时应返回全部5个节点，实际 %v", nodes)
+	}
+	if _, err := ring.GetNodes("k", 0); err == nil {
+		t.Errorf("n为0时应返回错误")
+	}
+}
+
+// BenchmarkConsistentHashGetNode 1千与10万个虚拟节点下查找键所属节点的耗时
+func BenchmarkConsistentHashGetNode(b *testing.B) {
+	for _, c := range []struct{ nodes, virtualNodes int }{{10, 100}, {100, 1000}} {
+		b.Run(fmt.Sprintf("vnodes=%d", c.nodes*c.virtualNodes), func(b *testing.B) {
+			ring := NewConsistentHashRing(c.virtualNodes)
+			for i := 0; i < c.nodes; i++ {
+				ring.AddNode(NodeID(fmt.Sprintf("node%d", i)))
+			}
+			keys := make([]string, 1024)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("user:%d", i)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := ring.GetNode(keys[i%len(keys)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestSmartRouterLoadBias 负载报告显示主节点过载时负载均衡避开它，负载回落后重新参与均衡
+func TestSmartRouterLoadBias(t *testing.T) {
+	var mu sync.Mutex
+	qps := map[NodeID]float64{"node1": 900, "node2": 100, "node3": 100}
+	servers := make(map[NodeID]*httptest.Server)
+	for nodeID := range qps {
+		nodeID := nodeID
+		servers[nodeID] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			json.NewEncoder(w).Encode(NodeLoadReport{Shards: []ShardLoad{{ShardID: "shard-0", QPS: qps[nodeID]}}})
+		}))
+		defer servers[nodeID].Close()
+	}
+
+	cache := NewTopologyCache(nil)
+	shard := testShard("node1", 1)
+	shard.Replicas = []NodeID{"node2", "node3"}
+	cache.Set(shard)
+
+	config := DefaultSmartRouterConfig()
+	config.EnableCache = false
+	config.HealthCheckInterval = 0
+	router := NewSmartRouter(config, cache)
+	for nodeID, server := range servers {
+		router.SetNodeAddress(nodeID, server.URL)
+	}
+
+	targets := func() map[NodeID]int {
+		counts := make(map[NodeID]int)
+		for i := 0; i < 30; i++ {
+			result, err := router.Route(&RoutingRequest{Key: "k", Strategy: RoutingLoadBalance})
+			if err != nil {
+				t.Fatalf("路由失败: %v", err)
+			}
+			counts[result.TargetNode]++
+		}
+		return counts
+	}
+
+	router.performLoadReport(context.Background())
+	if stats := router.GetStats().NodeStats["node1"]; !stats.Overloaded || stats.LoadQPS != 900 {
+		t.Fatalf("node1应被判定为过载: %+v", stats)
+	}
+	if counts := targets(); counts["node1"] != 0 || counts["node2"] == 0 || counts["node3"] == 0 {
+		t.Errorf("负载均衡应避开过载的主节点: %v", counts)
+	}
+	if result, _ := router.Route(&RoutingRequest{Key: "k", Strategy: RoutingWritePrimary}); result.TargetNode != "node1" {
+		t.Errorf("写请求仍应发往主节点: %s", result.TargetNode)
+	}
+
+	mu.Lock()
+	qps["node1"] = 120
+	mu.Unlock()
+	router.performLoadReport(context.Background())
+	if counts := targets(); counts["node1"] == 0 {
+		t.Errorf("负载回落后主节点应重新参与均衡: %v", counts)
+	}
+}
+
+// newBatchRouter 创建路由到16个分片的路由器，并返回均匀分布在各分片上的写请求
+func newBatchRouter(keys int, configure func(shards []*ShardInfo)) (*SmartRouter, *TopologyCache, []*RoutingRequest) {
+	shards := ringShards(16, 0)
+	if configure != nil {
+		configure(shards)
+	}
+	cache := NewTopologyCache(nil)
+	cache.Merge(shards, true)
+
+	config := DefaultSmartRouterConfig()
+	config.HealthCheckInterval = 0
+	config.BatchWorkers = 4
+	router := NewSmartRouter(config, cache)
+
+	requests := make([]*RoutingRequest, keys)
+	for i := range requests {
+		requests[i] = &RoutingRequest{Key: fmt.Sprintf("user:%06d", i), Strategy: RoutingWritePrimary}
+	}
+	return router, cache, requests
+}
+
+// TestRouteBatchGroups 批量路由按分片分组，每个分片只查找一次拓扑，失败的键带有各自的错误
+func TestRouteBatchGroups(t *testing.T) {
+	router, cache, requests := newBatchRouter(1000, func(shards []*ShardInfo) {
+		shards[3].Primary = "node9"
+	})
+	for i := 0; i < router.config.FailureThreshold; i++ {
+		router.UpdateNodeHealth("node9", false, 0, nil)
+	}
+	cache.EvictShard("shard-5")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cancelled := &RoutingRequest{Key: "cancelled", Strategy: RoutingWritePrimary, Context: ctx}
+	requests = append(requests, cancelled, requests[0])
+
+	before := cache.GetStats().TotalRequests
+	batch, err := router.RouteBatch(requests)
+	var multi *MultiError
+	if !errors.As(err, &multi) || len(multi.Errors) != len(batch.Errors) {
+		t.Fatalf("部分键失败时应返回*MultiError，实际 %v", err)
+	}
+
+	if len(batch.Results)+len(batch.Errors) != len(requests)-1 {
+		t.Fatalf("每个不重复的键都应有结果或错误，实际 %d+%d", len(batch.Results), len(batch.Errors))
+	}
+	if batch.Groups != 15 {
+		t.Errorf("应按缓存中的15个分片分组，实际 %d", batch.Groups)
+	}
+
+	notFound := 0
+	for key, err := range batch.Errors {
+		shard := shardOf(key)
+		switch {
+		case key == "cancelled":
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("已取消的请求应返回context.Canceled，实际 %v", err)
+			}
+		case shard == 3:
+			if !errors.Is(err, ErrNoHealthyNodes) {
+				t.Errorf("主节点不可用的键 %s 应返回ErrNoHealthyNodes，实际 %v", key, err)
+			}
+		case shard == 5:
+			var shardErr *ShardNotFoundError
+			if !errors.As(err, &shardErr) || shardErr.Key != key {
+				t.Errorf("没有分片的键 %s 应返回*ShardNotFoundError，实际 %v", key, err)
+			}
+			notFound++
+		default:
+			t.Errorf("键 %s 不应失败: %v", key, err)
+		}
+	}
+	for key, result := range batch.Results {
+		if !result.ShardInfo.Range.Contains(keyhash.Canonical.Sum64(key)) || result.TargetNode != "node1" {
+			t.Errorf("键 %s 路由到了错误的分片 %s 或节点 %s", key, result.ShardInfo.ID, result.TargetNode)
+		}
+	}
+
+	// 每个分片一次查找，找不到分片的键各计一次
+	if lookups := cache.GetStats().TotalRequests - before; lookups != int64(15+notFound) {
+		t.Errorf("拓扑查找次数应为%d，实际 %d", 15+notFound, lookups)
+	}
+	if stats := router.GetStats(); stats.TotalRequests != int64(len(requests)-1) {
+		t.Errorf("路由请求数应按不重复的键计算，实际 %d", stats.TotalRequests)
+	}
+}
+
+// shardOf 返回键在ringShards(16, 0)中所在分片的序号
+func shardOf(key string) int {
+	return int(keyhash.Canonical.Sum64(key) / (^uint64(0)/16 + 1))
+}
+
+// routeBatchPerKey 旧版批量路由：每个请求一个goroutine，各自独立调用Route
+func routeBatchPerKey(sr *SmartRouter, requests []*RoutingRequest) map[string]*RoutingResult {
+	type item struct {
+		key    string
+		result *RoutingResult
+	}
+	items := make(chan item, len(requests))
+	var wg sync.WaitGroup
+	for _, req := range requests {
+		wg.Add(1)
+		go func(r *RoutingRequest) {
+			defer wg.Done()
+			result, _ := sr.Route(r)
+			items <- item{r.Key, result}
+		}(req)
+	}
+	wg.Wait()
+	close(items)
+
+	results := make(map[string]*RoutingResult)
+	for it := range items {
+		if it.result != nil {
+			results[it.key] = it.result
+		}
+	}
+	return results
+}
+
+// BenchmarkRouteBatch 比较10k个键分布在16个分片时，按分片分组与逐键路由的内存分配与拓扑查找次数
+func BenchmarkRouteBatch(b *testing.B) {
+	const keys = 10000
+
+	b.Run("grouped", func(b *testing.B) {
+		router, cache, requests := newBatchRouter(keys, nil)
+		before := cache.GetStats().TotalRequests
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := router.RouteBatch(requests); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(cache.GetStats().TotalRequests-before)/float64(b.N), "lookups/op")
+	})
+
+	b.Run("per-key", func(b *testing.B) {
+		router, cache, requests := newBatchRouter(keys, nil)
+		before := cache.GetStats().TotalRequests
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if results := routeBatchPerKey(router, requests); len(results) != keys {
+				b.Fatalf("路由成功%d个键，应为%d", len(results), keys)
+			}
+		}
+		b.ReportMetric(float64(cache.GetStats().TotalRequests-before)/float64(b.N), "lookups/op")
+	})
+}
@@ -9,11 +9,23 @@
 package concord
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/concordkv/client/go/internal/lru"
+	"github.com/concordkv/client/go/keyhash"
 )
 
 // NodeID 节点标识符 (复用raftserver类型)
@@ -81,6 +93,8 @@ type ShardInfo struct {
 	CreatedAt time.Time         `json:"createdAt"` // 创建时间
 	UpdatedAt time.Time         `json:"updatedAt"` // 更新时间
 	Metadata  map[string]string `json:"metadata"`  // 元数据
+	// 主节点与副本所在的数据中心与可用区，就近读取据此选择副本；旧版本服务端不返回
+	Locations map[NodeID]ReplicaLocation `json:"locations,omitempty"`
 }
 
 // TopologyConfig 拓扑感知配置
@@ -100,6 +114,7 @@ type TopologyConfig struct {
 	EnableEventStream  bool          `json:"enableEventStream"`  // 是否启用事件流
 	EventStreamTimeout time.Duration `json:"eventStreamTimeout"` // 事件流超时
 	ReconnectInterval  time.Duration `json:"reconnectInterval"`  // 重连间隔
+	ListenerQueueSize  int           `json:"listenerQueueSize"`  // 每个监听器排队等待投递的事件上限，队列满时丢弃最早的事件
 
 	// 版本控制配置
 	EnableVersionCheck bool `json:"enableVersionCheck"` // 是否启用版本检查
@@ -119,6 +134,7 @@ func DefaultTopologyConfig() *TopologyConfig {
 		EnableEventStream:  true,
 		EventStreamTimeout: 60 * time.Second,
 		ReconnectInterval:  5 * time.Second,
+		ListenerQueueSize:  defaultListenerQueueSize,
 		EnableVersionCheck: true,
 		VersionTolerance:   10,
 	}
@@ -133,25 +149,35 @@ type TopologyCacheEntry struct {
 }
 
 // TopologyCache 拓扑缓存管理器
+// 键按哈希落在缓存的分片范围内查找分片，不为每个访问过的键保存映射，内存只随分片数增长
 type TopologyCache struct {
-	mu         sync.RWMutex
-	config     *TopologyConfig
-	entries    map[string]*TopologyCacheEntry // 分片ID -> 缓存条目
-	keyToShard map[string]string              // 键 -> 分片ID的快速查找表
-	version    int64                          // 全局版本号
-	stats      *TopologyCacheStats            // 统计信息
-
-	// LRU管理
-	lruList    *cacheNode            // LRU双链表头
-	lruTail    *cacheNode            // LRU双链表尾
-	entryNodes map[string]*cacheNode // 分片ID -> LRU节点映射
+	mu      sync.RWMutex
+	config  *TopologyConfig
+	entries *lru.Cache[string, *TopologyCacheEntry] // 分片ID -> 缓存条目，超过MaxCacheSize时淘汰最久未使用的分片
+	index   shardRangeIndex                         // 按起始哈希排序的分片范围，缓存的分片变化后重建
+	version int64                                   // 全局版本号
+	keyHash keyhash.Algorithm                       // 分片范围所在哈希空间的算法，由服务端通告
+
+	// 统计：热路径上只原子更新原始计数与耗时样本，命中率、平均与p99延迟在GetStats中计算
+	requests   int64          // 总请求数，原子更新
+	hits       int64          // 命中数，原子更新
+	misses     int64          // 未命中数，原子更新
+	evictions  int64          // 驱逐次数，原子更新
+	latency    latencySamples // 最近的Get/GetByKey耗时
+	lastUpdate time.Time      // 最近一次写入分片的时间（由mu保护）
 }
 
-// cacheNode LRU双链表节点
-type cacheNode struct {
+// shardSpan 分片范围索引中的一段，first与last均包含在内
+type shardSpan struct {
+	first   uint64
+	last    uint64
 	shardID string
-	prev    *cacheNode
-	next    *cacheNode
+}
+
+// shardRangeIndex 按起始哈希排序的分片范围索引，跨越0点的环形范围拆成两段，按哈希查找为O(log 分片数)
+type shardRangeIndex struct {
+	spans   []shardSpan
+	maxLast []uint64 // maxLast[i] 为spans[0..i]中最大的last，拓扑变更期间范围重叠时据此向前查找
 }
 
 // TopologyCacheStats 拓扑缓存统计信息
@@ -163,7 +189,8 @@ type TopologyCacheStats struct {
 	CurrentSize    int       `json:"currentSize"`    // 当前缓存大小
 	LastUpdate     time.Time `json:"lastUpdate"`     // 最后更新时间
 	HitRatio       float64   `json:"hitRatio"`       // 命中率
-	AverageLatency float64   `json:"averageLatency"` // 平均延迟(毫秒)
+	AverageLatency float64   `json:"averageLatency"` // 最近查找的平均延迟(毫秒)
+	P99Latency     float64   `json:"p99Latency"`     // 最近查找的p99延迟(毫秒)
 }
 
 // NewTopologyCache 创建新的拓扑缓存
@@ -173,49 +200,49 @@ func NewTopologyCache(config *TopologyConfig) *TopologyCache {
 	}
 
 	cache := &TopologyCache{
-		config:     config,
-		entries:    make(map[string]*TopologyCacheEntry),
-		keyToShard: make(map[string]string),
-		version:    1,
-		stats:      &TopologyCacheStats{},
-		entryNodes: make(map[string]*cacheNode),
+		config:  config,
+		version: 0,
+		keyHash: keyhash.Canonical,
 	}
-
-	// 初始化LRU双链表
-	cache.lruList = &cacheNode{}
-	cache.lruTail = &cacheNode{}
-	cache.lruList.next = cache.lruTail
-	cache.lruTail.prev = cache.lruList
+	cache.entries = lru.New(config.MaxCacheSize, func(shardID string, _ *TopologyCacheEntry) {
+		cache.forgetShard(shardID)
+	})
 
 	return cache
 }
 
 // Get 从缓存获取分片信息
 func (tc *TopologyCache) Get(shardID string) (*ShardInfo, bool) {
+	start := time.Now()
+	defer func() { tc.latency.record(time.Since(start)) }()
+
+	return tc.get(shardID)
+}
+
+// 内部方法：获取分片信息并计入命中统计，不记录耗时
+func (tc *TopologyCache) get(shardID string) (*ShardInfo, bool) {
 	tc.mu.Lock()
 	defer tc.mu.Unlock()
 
-	atomic.AddInt64(&tc.stats.TotalRequests, 1)
+	atomic.AddInt64(&tc.requests, 1)
 
-	entry, exists := tc.entries[shardID]
+	entry, exists := tc.entries.Peek(shardID)
 	if !exists {
-		atomic.AddInt64(&tc.stats.CacheMisses, 1)
+		atomic.AddInt64(&tc.misses, 1)
 		return nil, false
 	}
 
-	// 检查过期
-	if tc.config.CacheTTL > 0 && time.Since(entry.Timestamp) > tc.config.CacheTTL {
-		tc.evictEntry(shardID)
-		atomic.AddInt64(&tc.stats.CacheMisses, 1)
+	// 检查过期：过期条目视为未命中但保留，服务端不可达时仍可作为旧数据使用
+	if tc.expired(entry) {
+		atomic.AddInt64(&tc.misses, 1)
 		return nil, false
 	}
 
 	// 更新访问计数和LRU位置
 	atomic.AddInt64(&entry.AccessCount, 1)
-	tc.moveToFront(shardID)
+	tc.entries.Get(shardID)
 
-	atomic.AddInt64(&tc.stats.CacheHits, 1)
-	tc.updateHitRatio()
+	atomic.AddInt64(&tc.hits, 1)
 
 	// 返回副本
 	shardCopy := *entry.ShardInfo
@@ -227,48 +254,83 @@ func (tc *TopologyCache) Set(shardInfo *ShardInfo) {
 	tc.mu.Lock()
 	defer tc.mu.Unlock()
 
-	shardID := shardInfo.ID
-
-	// 检查容量限制
-	if len(tc.entries) >= tc.config.MaxCacheSize && tc.entries[shardID] == nil {
-		tc.evictOldest()
-	}
-
-	// 创建缓存条目
+	// 创建缓存条目，超过容量时淘汰最久未使用的分片
 	entry := &TopologyCacheEntry{
 		ShardInfo:   shardInfo,
 		Timestamp:   time.Now(),
 		Version:     shardInfo.Version,
 		AccessCount: 0,
 	}
-
-	tc.entries[shardID] = entry
-	tc.addToLRU(shardID)
-
-	// 更新统计信息
-	tc.stats.CurrentSize = len(tc.entries)
-	tc.stats.LastUpdate = time.Now()
+	tc.entries.Add(shardInfo.ID, entry)
+	tc.reindex()
+	tc.lastUpdate = time.Now()
 }
 
-// GetByKey 根据键获取对应的分片信息
+// GetByKey 根据键的哈希查找覆盖它的未过期分片，与Get一样计入统计并更新LRU位置
 func (tc *TopologyCache) GetByKey(key string) (*ShardInfo, bool) {
+	start := time.Now()
+	defer func() { tc.latency.record(time.Since(start)) }()
+
 	tc.mu.RLock()
-	shardID, exists := tc.keyToShard[key]
+	shardID, exists := tc.index.find(tc.keyHash.Sum64(key), func(shardID string) bool {
+		entry, ok := tc.entries.Peek(shardID)
+		return ok && !tc.expired(entry)
+	})
 	tc.mu.RUnlock()
 
 	if !exists {
+		atomic.AddInt64(&tc.requests, 1)
+		atomic.AddInt64(&tc.misses, 1)
 		return nil, false
 	}
 
-	return tc.Get(shardID)
+	return tc.get(shardID)
 }
 
-// SetKeyMapping 设置键到分片的映射
-func (tc *TopologyCache) SetKeyMapping(key, shardID string) {
-	tc.mu.Lock()
-	defer tc.mu.Unlock()
+// GetByKeys 在一次索引遍历中查找各键所在的未过期分片，返回与keys一一对应的分片ID（找不到时为空串）及涉及的分片信息；
+// 每个分片只按Get计入一次统计并更新一次LRU位置，索引中找不到分片的键各计一次未命中
+func (tc *TopologyCache) GetByKeys(keys []string) ([]string, map[string]*ShardInfo) {
+	shardIDs := make([]string, len(keys))
+	accept := func(shardID string) bool {
+		entry, ok := tc.entries.Peek(shardID)
+		return ok && !tc.expired(entry)
+	}
+
+	tc.mu.RLock()
+	for i, key := range keys {
+		shardIDs[i], _ = tc.index.find(tc.keyHash.Sum64(key), accept)
+	}
+	tc.mu.RUnlock()
 
-	tc.keyToShard[key] = shardID
+	shards := make(map[string]*ShardInfo)
+	for _, shardID := range shardIDs {
+		if _, looked := shards[shardID]; looked || shardID == "" {
+			continue
+		}
+		// 遍历索引后分片可能已被驱逐或过期，此时以nil标记，对应的键视为找不到分片
+		shard, _ := tc.get(shardID)
+		shards[shardID] = shard
+	}
+
+	misses := int64(0)
+	for i, shardID := range shardIDs {
+		switch {
+		case shardID == "":
+			misses++
+		case shards[shardID] == nil:
+			shardIDs[i] = ""
+		}
+	}
+	for shardID, shard := range shards {
+		if shard == nil {
+			delete(shards, shardID)
+		}
+	}
+	if misses > 0 {
+		atomic.AddInt64(&tc.requests, misses)
+		atomic.AddInt64(&tc.misses, misses)
+	}
+	return shardIDs, shards
 }
 
 // EvictShard 驱逐指定分片
@@ -277,6 +339,7 @@ func (tc *TopologyCache) EvictShard(shardID string) {
 	defer tc.mu.Unlock()
 
 	tc.evictEntry(shardID)
+	tc.reindex()
 }
 
 // Clear 清空缓存
@@ -284,15 +347,8 @@ func (tc *TopologyCache) Clear() {
 	tc.mu.Lock()
 	defer tc.mu.Unlock()
 
-	tc.entries = make(map[string]*TopologyCacheEntry)
-	tc.keyToShard = make(map[string]string)
-	tc.entryNodes = make(map[string]*cacheNode)
-
-	// 重置LRU链表
-	tc.lruList.next = tc.lruTail
-	tc.lruTail.prev = tc.lruList
-
-	tc.stats.CurrentSize = 0
+	tc.entries.Purge()
+	tc.index = shardRangeIndex{}
 }
 
 // GetStats 获取缓存统计信息
@@ -300,8 +356,24 @@ func (tc *TopologyCache) GetStats() *TopologyCacheStats {
 	tc.mu.RLock()
 	defer tc.mu.RUnlock()
 
-	statsCopy := *tc.stats
-	return &statsCopy
+	// 请求数先于命中与未命中计数增加，后读取请求数保证命中率不超过1
+	hits := atomic.LoadInt64(&tc.hits)
+	misses := atomic.LoadInt64(&tc.misses)
+	stats := &TopologyCacheStats{
+		TotalRequests: atomic.LoadInt64(&tc.requests),
+		CacheHits:     hits,
+		CacheMisses:   misses,
+		EvictionCount: atomic.LoadInt64(&tc.evictions),
+		CurrentSize:   tc.entries.Len(),
+		LastUpdate:    tc.lastUpdate,
+	}
+	if stats.TotalRequests > 0 {
+		stats.HitRatio = float64(stats.CacheHits) / float64(stats.TotalRequests)
+	}
+	average, p99 := tc.latency.summary()
+	stats.AverageLatency = float64(average.Microseconds()) / 1000
+	stats.P99Latency = float64(p99.Microseconds()) / 1000
+	return stats
 }
 
 // UpdateVersion 更新全局版本号
@@ -314,63 +386,225 @@ func (tc *TopologyCache) UpdateVersion(version int64) {
 		// 检查版本容差，清理过期缓存
 		if tc.config.EnableVersionCheck {
 			tc.cleanupOldVersions(version)
+			tc.reindex()
 		}
 	}
 }
 
-// 内部方法：驱逐最旧的缓存条目
-func (tc *TopologyCache) evictOldest() {
-	if tc.lruTail.prev == tc.lruList {
-		return // 空链表
+// Version 获取全局版本号，尚未获取过拓扑时为0
+func (tc *TopologyCache) Version() int64 {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	return tc.version
+}
+
+// KeyHash 分片范围所在哈希空间的算法
+func (tc *TopologyCache) KeyHash() keyhash.Algorithm {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	return tc.keyHash
+}
+
+// SetKeyHash 设置服务端通告的键哈希算法，返回算法是否变化；algorithm必须受本实现支持
+func (tc *TopologyCache) SetKeyHash(algorithm keyhash.Algorithm) bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if tc.keyHash == algorithm {
+		return false
 	}
+	tc.keyHash = algorithm
+	return true
+}
 
-	oldestNode := tc.lruTail.prev
-	tc.evictEntry(oldestNode.shardID)
+// HashKey 按服务端通告的算法计算键在哈希环上的位置
+func (tc *TopologyCache) HashKey(key string) uint64 {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	return tc.keyHash.Sum64(key)
 }
 
-// 内部方法：驱逐指定条目
-func (tc *TopologyCache) evictEntry(shardID string) {
-	delete(tc.entries, shardID)
-	tc.removeFromLRU(shardID)
+// Merge 合并从服务端获取的分片信息，只替换版本号比缓存新的分片，返回被替换的分片数
+// 获取成功说明其余分片在该版本仍然有效，刷新它们的缓存时间；full为true时驱逐服务端已不存在的分片
+func (tc *TopologyCache) Merge(shards []*ShardInfo, full bool) int {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	now := time.Now()
+	updated := 0
+	present := make(map[string]struct{}, len(shards))
+	for _, shardInfo := range shards {
+		present[shardInfo.ID] = struct{}{}
+		if tc.setIfNewer(shardInfo, now) {
+			updated++
+		}
+	}
 
-	// 清理键映射
-	for key, sid := range tc.keyToShard {
-		if sid == shardID {
-			delete(tc.keyToShard, key)
+	var removed []string
+	tc.entries.Range(func(shardID string, entry *TopologyCacheEntry) bool {
+		if _, ok := present[shardID]; full && !ok {
+			removed = append(removed, shardID)
+		} else {
+			entry.Timestamp = now
 		}
+		return true
+	})
+	for _, shardID := range removed {
+		tc.evictEntry(shardID)
 	}
+	tc.reindex()
+	tc.lastUpdate = now
+	return updated
+}
+
+// SetIfNewer 分片信息的版本号比缓存新时写入缓存
+func (tc *TopologyCache) SetIfNewer(shardInfo *ShardInfo) bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
 
-	atomic.AddInt64(&tc.stats.EvictionCount, 1)
-	tc.stats.CurrentSize = len(tc.entries)
+	if !tc.setIfNewer(shardInfo, time.Now()) {
+		return false
+	}
+	tc.reindex()
+	tc.lastUpdate = time.Now()
+	return true
 }
 
-// 内部方法：添加到LRU链表头部
-func (tc *TopologyCache) addToLRU(shardID string) {
-	node := &cacheNode{shardID: shardID}
-	node.next = tc.lruList.next
-	node.prev = tc.lruList
-	tc.lruList.next.prev = node
-	tc.lruList.next = node
+// EvictShardIfOlder 缓存的分片版本号比version旧时驱逐该分片
+func (tc *TopologyCache) EvictShardIfOlder(shardID string, version int64) bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
 
-	tc.entryNodes[shardID] = node
+	if entry, exists := tc.entries.Peek(shardID); !exists || entry.Version >= version {
+		return false
+	}
+	tc.evictEntry(shardID)
+	tc.reindex()
+	return true
 }
 
-// 内部方法：从LRU链表移除
-func (tc *TopologyCache) removeFromLRU(shardID string) {
-	node, exists := tc.entryNodes[shardID]
+// GetByHash 查找哈希值所在的分片；allowStale为true时也返回已过期的条目
+func (tc *TopologyCache) GetByHash(hash uint64, allowStale bool) (*ShardInfo, bool) {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+
+	var found *TopologyCacheEntry
+	tc.index.find(hash, func(shardID string) bool {
+		entry, ok := tc.entries.Peek(shardID)
+		if ok && (allowStale || !tc.expired(entry)) {
+			found = entry
+		}
+		return found != nil
+	})
+	if found == nil {
+		return nil, false
+	}
+	shardCopy := *found.ShardInfo
+	return &shardCopy, true
+}
+
+// Peek 获取分片信息（包括已过期的条目），不计入统计也不更新LRU位置
+func (tc *TopologyCache) Peek(shardID string) (*ShardInfo, bool) {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+
+	entry, exists := tc.entries.Peek(shardID)
 	if !exists {
-		return
+		return nil, false
+	}
+	shardCopy := *entry.ShardInfo
+	return &shardCopy, true
+}
+
+// All 获取缓存中的所有分片信息（包括已过期的条目）
+func (tc *TopologyCache) All() map[string]*ShardInfo {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+
+	shards := make(map[string]*ShardInfo, tc.entries.Len())
+	tc.entries.Range(func(shardID string, entry *TopologyCacheEntry) bool {
+		shardCopy := *entry.ShardInfo
+		shards[shardID] = &shardCopy
+		return true
+	})
+	return shards
+}
+
+// Size 获取缓存的分片数
+func (tc *TopologyCache) Size() int {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	return tc.entries.Len()
+}
+
+// 内部方法：版本号比缓存新时写入分片信息（调用方需持有写锁）
+func (tc *TopologyCache) setIfNewer(shardInfo *ShardInfo, now time.Time) bool {
+	if entry, exists := tc.entries.Peek(shardInfo.ID); exists && entry.Version >= shardInfo.Version {
+		return false
+	}
+	tc.entries.Add(shardInfo.ID, &TopologyCacheEntry{
+		ShardInfo: shardInfo,
+		Timestamp: now,
+		Version:   shardInfo.Version,
+	})
+	return true
+}
+
+// 内部方法：判断条目是否超过TTL
+func (tc *TopologyCache) expired(entry *TopologyCacheEntry) bool {
+	return tc.config.CacheTTL > 0 && time.Since(entry.Timestamp) > tc.config.CacheTTL
+}
+
+// 内部方法：驱逐指定条目
+func (tc *TopologyCache) evictEntry(shardID string) {
+	if tc.entries.Remove(shardID) {
+		tc.forgetShard(shardID)
 	}
+}
 
-	node.prev.next = node.next
-	node.next.prev = node.prev
-	delete(tc.entryNodes, shardID)
+// 内部方法：分片被驱逐后更新统计（调用方需持有写锁），索引由修改缓存的方法统一重建
+func (tc *TopologyCache) forgetShard(shardID string) {
+	atomic.AddInt64(&tc.evictions, 1)
 }
 
-// 内部方法：将节点移到链表头部
-func (tc *TopologyCache) moveToFront(shardID string) {
-	tc.removeFromLRU(shardID)
-	tc.addToLRU(shardID)
+// 内部方法：按缓存中的分片重建范围索引（调用方需持有写锁）
+func (tc *TopologyCache) reindex() {
+	spans := make([]shardSpan, 0, tc.entries.Len()+1)
+	tc.entries.Range(func(shardID string, entry *TopologyCacheEntry) bool {
+		r := entry.ShardInfo.Range
+		switch {
+		case r.StartHash < r.EndHash:
+			spans = append(spans, shardSpan{first: r.StartHash, last: r.EndHash - 1, shardID: shardID})
+		case r.StartHash > r.EndHash:
+			// 跨越0点的范围拆成 [StartHash, 最大值] 与 [0, EndHash) 两段
+			spans = append(spans, shardSpan{first: r.StartHash, last: ^uint64(0), shardID: shardID})
+			if r.EndHash > 0 {
+				spans = append(spans, shardSpan{first: 0, last: r.EndHash - 1, shardID: shardID})
+			}
+		}
+		return true
+	})
+	sort.Slice(spans, func(i, j int) bool { return spans[i].first < spans[j].first })
+
+	maxLast := make([]uint64, len(spans))
+	for i, span := range spans {
+		maxLast[i] = span.last
+		if i > 0 && maxLast[i-1] > span.last {
+			maxLast[i] = maxLast[i-1]
+		}
+	}
+	tc.index = shardRangeIndex{spans: spans, maxLast: maxLast}
+}
+
+// find 查找包含hash且accept接受的分片，范围重叠时优先起始哈希较大（较窄）的分片
+func (idx *shardRangeIndex) find(hash uint64, accept func(shardID string) bool) (string, bool) {
+	i := sort.Search(len(idx.spans), func(i int) bool { return idx.spans[i].first > hash }) - 1
+	for ; i >= 0 && idx.maxLast[i] >= hash; i-- {
+		if span := idx.spans[i]; span.last >= hash && accept(span.shardID) {
+			return span.shardID, true
+		}
+	}
+	return "", false
 }
 
 // 内部方法：清理旧版本缓存
@@ -378,22 +612,20 @@ func (tc *TopologyCache) cleanupOldVersions(currentVersion int64) {
 	tolerance := int64(tc.config.VersionTolerance)
 	minVersion := currentVersion - tolerance
 
-	for shardID, entry := range tc.entries {
+	var stale []string
+	tc.entries.Range(func(shardID string, entry *TopologyCacheEntry) bool {
 		if entry.Version < minVersion {
-			tc.evictEntry(shardID)
+			stale = append(stale, shardID)
 		}
+		return true
+	})
+	for _, shardID := range stale {
+		tc.evictEntry(shardID)
 	}
 }
 
-// 内部方法：更新命中率
-func (tc *TopologyCache) updateHitRatio() {
-	total := atomic.LoadInt64(&tc.stats.TotalRequests)
-	hits := atomic.LoadInt64(&tc.stats.CacheHits)
-
-	if total > 0 {
-		tc.stats.HitRatio = float64(hits) / float64(total)
-	}
-}
+// maxReconnectBackoff 事件流重连的最长退避时间
+const maxReconnectBackoff = time.Minute
 
 // TopologyEventSubscriber 拓扑事件订阅器
 type TopologyEventSubscriber struct {
@@ -404,7 +636,13 @@ type TopologyEventSubscriber struct {
 	stopChannel   chan struct{}
 	reconnectChan chan struct{}
 	isRunning     int64
-	listeners     []TopologyEventListener
+	listeners     []*topologySubscription // 写时复制，通知时不持有mu
+	logger        *slog.Logger
+
+	// 事件流连接：client为空时不连接服务端，只处理PublishEvent发布的事件
+	client      *Client
+	refresh     func(ctx context.Context) error // 版本差距过大时重新获取完整拓扑
+	lastVersion int64                           // 事件流中收到的最新版本号
 }
 
 // TopologyEvent 拓扑变更事件
@@ -415,6 +653,9 @@ type TopologyEvent struct {
 	Version   int64             `json:"version"`   // 版本号
 	Timestamp time.Time         `json:"timestamp"` // 时间戳
 	Source    string            `json:"source"`    // 事件源
+
+	// Events 批量事件中的各分片事件，仅EventTopologyBatch使用；监听器对一批变更只收到一次通知
+	Events []TopologyEvent `json:"events,omitempty"`
 }
 
 // TopologyEventType 拓扑事件类型
@@ -428,6 +669,7 @@ const (
 	EventNodeAdded                               // 节点添加
 	EventNodeRemoved                             // 节点删除
 	EventNodeUpdated                             // 节点更新
+	EventTopologyBatch                           // 批量变更，Events中为合并后的各分片事件
 )
 
 func (t TopologyEventType) String() string {
@@ -446,6 +688,8 @@ func (t TopologyEventType) String() string {
 		return "NodeRemoved"
 	case EventNodeUpdated:
 		return "NodeUpdated"
+	case EventTopologyBatch:
+		return "TopologyBatch"
 	default:
 		return "Unknown"
 	}
@@ -464,7 +708,7 @@ func NewTopologyEventSubscriber(config *TopologyConfig, cache *TopologyCache) *T
 		eventChannel:  make(chan TopologyEvent, 1000),
 		stopChannel:   make(chan struct{}),
 		reconnectChan: make(chan struct{}, 1),
-		listeners:     make([]TopologyEventListener, 0),
+		logger:        slog.Default(),
 	}
 }
 
@@ -483,32 +727,70 @@ func (tes *TopologyEventSubscriber) Start(ctx context.Context) error {
 	return nil
 }
 
-// Stop 停止事件订阅器
+// Stop 停止事件订阅器，并取消所有监听器的订阅
 func (tes *TopologyEventSubscriber) Stop() {
 	if atomic.CompareAndSwapInt64(&tes.isRunning, 1, 0) {
 		close(tes.stopChannel)
 	}
+
+	tes.mu.Lock()
+	listeners := tes.listeners
+	tes.listeners = nil
+	tes.mu.Unlock()
+
+	for _, sub := range listeners {
+		sub.stop()
+	}
 }
 
 // AddListener 添加事件监听器
+// 每个监听器的事件由独立的协程按顺序投递，阻塞的监听器只会积压自己的队列，panic会被恢复并记录
 func (tes *TopologyEventSubscriber) AddListener(listener TopologyEventListener) {
+	size := 0
+	if tes.config != nil {
+		size = tes.config.ListenerQueueSize
+	}
+	sub := newTopologySubscription(listener, size, tes.logger)
+
 	tes.mu.Lock()
 	defer tes.mu.Unlock()
 
-	tes.listeners = append(tes.listeners, listener)
+	listeners := make([]*topologySubscription, 0, len(tes.listeners)+1)
+	tes.listeners = append(append(listeners, tes.listeners...), sub)
 }
 
-// RemoveListener 移除事件监听器
+// RemoveListener 移除事件监听器，返回后监听器不会再收到新的事件，可以在监听器自己的回调中调用
 func (tes *TopologyEventSubscriber) RemoveListener(listener TopologyEventListener) {
 	tes.mu.Lock()
-	defer tes.mu.Unlock()
+	var removed *topologySubscription
+	listeners := make([]*topologySubscription, 0, len(tes.listeners))
+	for _, sub := range tes.listeners {
+		if removed == nil && sameTopologyListener(sub.listener, listener) {
+			removed = sub
+			continue
+		}
+		listeners = append(listeners, sub)
+	}
+	tes.listeners = listeners
+	tes.mu.Unlock()
+
+	if removed != nil {
+		removed.stop()
+	}
+}
+
+// DroppedEvents 监听器处理过慢、事件队列满时被丢弃的事件数，监听器未注册时返回0
+func (tes *TopologyEventSubscriber) DroppedEvents(listener TopologyEventListener) int64 {
+	tes.mu.RLock()
+	defer tes.mu.RUnlock()
 
-	for i, l := range tes.listeners {
-		if l == listener {
-			tes.listeners = append(tes.listeners[:i], tes.listeners[i+1:]...)
-			break
+	var dropped int64
+	for _, sub := range tes.listeners {
+		if sameTopologyListener(sub.listener, listener) {
+			dropped += atomic.LoadInt64(&sub.dropped)
 		}
 	}
+	return dropped
 }
 
 // PublishEvent 发布拓扑事件
@@ -543,39 +825,227 @@ func (tes *TopologyEventSubscriber) eventLoop(ctx context.Context) {
 }
 
 // 内部方法：事件流循环
+// 每次连接从已知的最新版本开始，服务端先补发错过的事件；连接断开后经reconnectChan按指数退避重连
 func (tes *TopologyEventSubscriber) eventStreamLoop(ctx context.Context) {
+	if tes.client == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-tes.stopChannel:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	failures := 0
+	tes.requestReconnect()
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-tes.stopChannel:
-			return
 		case <-tes.reconnectChan:
-			// 重连逻辑
-			time.Sleep(tes.config.ReconnectInterval)
-		default:
-			// TODO: 实现实际的事件流连接逻辑
-			// 这里应该连接到raftserver的事件流接口
-			time.Sleep(time.Second)
+		}
+
+		// 连接错误只影响退避时间，期间缓存仍由定期刷新兜底
+		if connected, _ := tes.streamEvents(ctx); connected {
+			failures = 0
+		} else {
+			failures++
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(tes.reconnectBackoff(failures)):
+		}
+		tes.requestReconnect()
+	}
+}
+
+// 内部方法：请求重新连接事件流
+func (tes *TopologyEventSubscriber) requestReconnect() {
+	select {
+	case tes.reconnectChan <- struct{}{}:
+	default:
+	}
+}
+
+// 内部方法：第n次连续连接失败后的退避时间，从ReconnectInterval开始翻倍，最长maxReconnectBackoff
+func (tes *TopologyEventSubscriber) reconnectBackoff(failures int) time.Duration {
+	backoff := tes.config.ReconnectInterval
+	for i := 0; i < failures && backoff < maxReconnectBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxReconnectBackoff {
+		backoff = maxReconnectBackoff
+	}
+	return backoff
+}
+
+// 内部方法：重连时使用的版本号，取事件流与缓存中较新的一个
+func (tes *TopologyEventSubscriber) resumeVersion() int64 {
+	version := atomic.LoadInt64(&tes.lastVersion)
+	if cached := tes.cache.Version(); cached > version {
+		version = cached
+	}
+	return version
+}
+
+// 内部方法：依次尝试各节点建立事件流并读取到连接断开，connected表示曾成功建立连接
+func (tes *TopologyEventSubscriber) streamEvents(ctx context.Context) (connected bool, err error) {
+	conns, err := tes.client.connections()
+	if err != nil {
+		return false, err
+	}
+
+	path := "/api/topology/events?sinceVersion=" + strconv.FormatInt(tes.resumeVersion(), 10)
+	for _, conn := range conns {
+		var body io.ReadCloser
+		streamCtx, cancel := context.WithCancel(ctx)
+
+		// 超过EventStreamTimeout没有收到任何数据（包括心跳）时视为连接已失效
+		onData := func() {}
+		if timeout := tes.config.EventStreamTimeout; timeout > 0 {
+			idle := time.AfterFunc(timeout, cancel)
+			defer idle.Stop()
+			onData = func() { idle.Reset(timeout) }
+		}
+
+		body, err = tes.openStream(streamCtx, conn.baseURL+path)
+		if err == nil {
+			err = tes.readStream(streamCtx, body, onData)
+			body.Close()
+		}
+		cancel()
+
+		if body != nil {
+			return true, err
+		}
+	}
+
+	return false, err
+}
+
+// 内部方法：建立事件流连接
+func (tes *TopologyEventSubscriber) openStream(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建事件流请求失败: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	// 事件流是长连接，不能使用带整体超时的客户端，也不占用MaxConnsPerNode限制的连接
+	streamClient := &http.Client{}
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrConnectionFailed, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("订阅拓扑事件失败，状态码: %d, 响应: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	return resp.Body, nil
+}
+
+// 内部方法：逐个解析SSE事件直到连接断开，未以空行结束的事件被丢弃
+func (tes *TopologyEventSubscriber) readStream(ctx context.Context, body io.Reader, onData func()) error {
+	reader := bufio.NewReader(body)
+
+	var eventType string
+	var data strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return errors.New("事件流被服务端关闭")
+			}
+			return fmt.Errorf("读取事件流失败: %w", err)
+		}
+		onData()
+
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			if eventType != "" || data.Len() > 0 {
+				if err := tes.dispatchStreamEvent(ctx, eventType, data.String()); err != nil {
+					return err
+				}
+			}
+			eventType = ""
+			data.Reset()
+		case strings.HasPrefix(line, ":"):
+			// 心跳注释
+		case strings.HasPrefix(line, "event:"):
+			eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+}
+
+// 内部方法：处理事件流中的一个事件
+func (tes *TopologyEventSubscriber) dispatchStreamEvent(ctx context.Context, eventType, data string) error {
+	switch eventType {
+	case "topology":
+		var event TopologyEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("解析拓扑事件失败: %w", err)
+		}
+		tes.advanceVersion(event.Version)
+		tes.PublishEvent(event)
+	case "topology-batch":
+		// 服务端合并窗口内的多个分片事件，作为一个批量事件处理
+		var event TopologyEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("解析批量拓扑事件失败: %w", err)
+		}
+		event.Type = EventTopologyBatch
+		tes.advanceVersion(event.Version)
+		tes.PublishEvent(event)
+	case "resync":
+		// 错过的事件已无法补发，重新获取完整拓扑后继续接收新事件
+		if tes.refresh == nil {
+			return errors.New("拓扑事件需要重新同步，但未设置刷新方法")
+		}
+		if err := tes.refresh(ctx); err != nil {
+			return fmt.Errorf("重新同步拓扑失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// 内部方法：记录事件流中收到的最新版本号
+func (tes *TopologyEventSubscriber) advanceVersion(version int64) {
+	for {
+		last := atomic.LoadInt64(&tes.lastVersion)
+		if version <= last || atomic.CompareAndSwapInt64(&tes.lastVersion, last, version) {
+			return
 		}
 	}
 }
 
 // 内部方法：处理拓扑事件
+// 批量事件先按版本顺序应用其中所有分片的变更，再以该批量事件通知监听器一次
 func (tes *TopologyEventSubscriber) handleEvent(event TopologyEvent) {
-	// 更新缓存
-	switch event.Type {
-	case EventShardAdded, EventShardUpdated:
-		if event.ShardInfo != nil {
-			tes.cache.Set(event.ShardInfo)
-		}
-	case EventShardRemoved:
-		tes.cache.EvictShard(event.ShardID)
-	case EventShardMigration:
-		// 分片迁移时更新分片信息
-		if event.ShardInfo != nil {
-			tes.cache.Set(event.ShardInfo)
+	if event.Type == EventTopologyBatch {
+		updates := make([]TopologyEvent, len(event.Events))
+		copy(updates, event.Events)
+		sort.SliceStable(updates, func(i, j int) bool { return updates[i].Version < updates[j].Version })
+		for _, update := range updates {
+			tes.applyEvent(update)
 		}
+		event.Events = updates
+	} else {
+		tes.applyEvent(event)
 	}
 
 	// 更新缓存版本
@@ -583,19 +1053,29 @@ func (tes *TopologyEventSubscriber) handleEvent(event TopologyEvent) {
 
 	// 通知监听器
 	tes.mu.RLock()
-	listeners := make([]TopologyEventListener, len(tes.listeners))
-	copy(listeners, tes.listeners)
+	listeners := tes.listeners
 	tes.mu.RUnlock()
 
-	for _, listener := range listeners {
-		go func(l TopologyEventListener) {
-			defer func() {
-				if r := recover(); r != nil {
-					// 记录错误，但不影响其他监听器
-				}
-			}()
-			l.OnTopologyEvent(event)
-		}(listener)
+	for _, sub := range listeners {
+		sub.publish(event)
+	}
+}
+
+// 内部方法：把一个分片事件应用到缓存
+func (tes *TopologyEventSubscriber) applyEvent(event TopologyEvent) {
+	// 重连补发或重新同步后可能收到比缓存旧的事件，只接受版本更新的变更
+	switch event.Type {
+	case EventShardAdded, EventShardUpdated:
+		if event.ShardInfo != nil {
+			tes.cache.SetIfNewer(event.ShardInfo)
+		}
+	case EventShardRemoved:
+		tes.cache.EvictShardIfOlder(event.ShardID, event.Version)
+	case EventShardMigration:
+		// 分片迁移时更新分片信息
+		if event.ShardInfo != nil {
+			tes.cache.SetIfNewer(event.ShardInfo)
+		}
 	}
 }
 
@@ -608,6 +1088,39 @@ type TopologyAwareClient struct {
 	mu              sync.RWMutex
 	isInitialized   bool
 	stopChannel     chan struct{}
+
+	// 按拓扑维护的分片连接池，poolSyncMu保证按事件同步时读取的是缓存中最新的分片信息
+	shardPool  *ShardAwareConnectionPool
+	poolSyncMu sync.Mutex
+}
+
+// shardPoolSync 随拓扑事件同步分片连接池
+type shardPoolSync struct {
+	client *TopologyAwareClient
+}
+
+// OnTopologyEvent 分片添加、更新、迁移或删除后按缓存中的分片信息同步连接池
+func (s shardPoolSync) OnTopologyEvent(event TopologyEvent) {
+	if event.Type == EventTopologyBatch {
+		for _, update := range event.Events {
+			s.OnTopologyEvent(update)
+		}
+		return
+	}
+
+	switch event.Type {
+	case EventShardAdded, EventShardRemoved, EventShardUpdated, EventShardMigration:
+	default:
+		return
+	}
+
+	shardID := event.ShardID
+	if shardID == "" && event.ShardInfo != nil {
+		shardID = event.ShardInfo.ID
+	}
+	if shardID != "" {
+		s.client.syncShardPool(shardID)
+	}
 }
 
 // NewTopologyAwareClient 创建新的拓扑感知客户端
@@ -636,6 +1149,23 @@ func NewTopologyAwareClient(baseConfig Config, topologyConfig *TopologyConfig) (
 		stopChannel:     make(chan struct{}),
 	}
 
+	// 事件订阅器连接服务端的事件流，版本差距过大时重新获取完整拓扑
+	eventSubscriber.client = baseClient
+	eventSubscriber.logger = baseClient.logger
+	eventSubscriber.refresh = func(ctx context.Context) error {
+		return client.refreshTopologySince(ctx, 0)
+	}
+
+	// 分片变更后同步连接池
+	eventSubscriber.AddListener(shardPoolSync{client: client})
+
+	// 请求遇到非领导者错误时先刷新拓扑，再按新的领导者重试
+	baseClient.leaderRefresh = func(ctx context.Context) error {
+		ctx, cancel := client.updateContext(ctx)
+		defer cancel()
+		return client.refreshTopology(ctx)
+	}
+
 	return client, nil
 }
 
@@ -648,16 +1178,21 @@ func (tac *TopologyAwareClient) Initialize(ctx context.Context) error {
 		return nil
 	}
 
+	// 初始化拓扑信息，事件订阅从获取到的版本开始
+	if err := tac.refreshTopology(ctx); err != nil {
+		return fmt.Errorf("初始化拓扑信息失败: %w", err)
+	}
+
+	// 为所有已知分片创建连接池并在后台预热
+	if tac.shardPool != nil {
+		tac.warmShardPools(ctx, tac.shardPool)
+	}
+
 	// 启动事件订阅器
 	if err := tac.eventSubscriber.Start(ctx); err != nil {
 		return fmt.Errorf("启动事件订阅器失败: %w", err)
 	}
 
-	// 初始化拓扑信息
-	if err := tac.refreshTopology(ctx); err != nil {
-		return fmt.Errorf("初始化拓扑信息失败: %w", err)
-	}
-
 	// 启动定期刷新
 	if tac.config.RefreshInterval > 0 {
 		go tac.refreshLoop(ctx)
@@ -672,13 +1207,13 @@ func (tac *TopologyAwareClient) Close() error {
 	tac.mu.Lock()
 	defer tac.mu.Unlock()
 
+	// 停止事件订阅器，未初始化时也要让监听器的投递协程退出
+	tac.eventSubscriber.Stop()
+
 	if !tac.isInitialized {
 		return nil
 	}
 
-	// 停止事件订阅器
-	tac.eventSubscriber.Stop()
-
 	// 停止刷新循环
 	close(tac.stopChannel)
 
@@ -691,30 +1226,71 @@ func (tac *TopologyAwareClient) Close() error {
 	return nil
 }
 
+// SetShardPool 设置按拓扑维护的分片连接池：Initialize时为所有已知分片的主节点与副本节点创建连接池并在后台预热，
+// 之后随分片添加、删除与主节点变更同步；已初始化时立即为缓存中的分片创建。连接池的启动与停止仍由调用方负责
+func (tac *TopologyAwareClient) SetShardPool(pool *ShardAwareConnectionPool) {
+	tac.mu.Lock()
+	defer tac.mu.Unlock()
+
+	tac.shardPool = pool
+	if tac.isInitialized && pool != nil {
+		tac.warmShardPools(context.Background(), pool)
+	}
+}
+
 // GetShardInfo 获取键对应的分片信息
 func (tac *TopologyAwareClient) GetShardInfo(key string) (*ShardInfo, error) {
+	return tac.GetShardInfoCtx(context.Background(), key)
+}
+
+// GetShardInfoCtx 获取键对应的分片信息，缓存未命中时从服务端获取，ctx结束时放弃获取
+// 键所在的分片正在拆分或合并时刷新拓扑后重试，最多MaxRetries次，仍在迁移时返回ErrShardMigrating
+func (tac *TopologyAwareClient) GetShardInfoCtx(ctx context.Context, key string) (*ShardInfo, error) {
 	// 首先尝试从缓存获取
-	if shardInfo, ok := tac.cache.GetByKey(key); ok {
-		return shardInfo, nil
+	shardInfo, ok := tac.cache.GetByKey(key)
+	var err error
+	if !ok {
+		shardInfo, err = tac.fetchShardInfoFromServer(ctx, key)
 	}
 
-	// 缓存未命中，从服务端获取
-	shardInfo, err := tac.fetchShardInfoFromServer(key)
+	for attempt := 0; err == nil && shardInfo.State == ShardStateMigrating; attempt++ {
+		if attempt >= tac.config.MaxRetries {
+			return nil, fmt.Errorf("%w: 键 %s 所在的分片 %s", ErrShardMigrating, key, shardInfo.ID)
+		}
+		// 第一次立即刷新：事件可能晚于服务端完成迁移
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(tac.config.RetryInterval):
+			}
+		}
+		shardInfo, err = tac.fetchShardInfoFromServer(ctx, key)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	// 更新缓存
-	tac.cache.Set(shardInfo)
-	tac.cache.SetKeyMapping(key, shardInfo.ID)
-
 	return shardInfo, nil
 }
 
 // GetAllShards 获取所有分片信息
+// 返回缓存中的分片，缓存为空时先从服务端获取
 func (tac *TopologyAwareClient) GetAllShards() (map[string]*ShardInfo, error) {
-	// TODO: 实现从服务端获取所有分片信息的逻辑
-	return nil, fmt.Errorf("暂未实现")
+	return tac.GetAllShardsCtx(context.Background())
+}
+
+// GetAllShardsCtx 获取所有分片信息，ctx语义同GetShardInfoCtx
+func (tac *TopologyAwareClient) GetAllShardsCtx(ctx context.Context) (map[string]*ShardInfo, error) {
+	if tac.cache.Size() == 0 {
+		ctx, cancel := tac.updateContext(ctx)
+		defer cancel()
+		if err := tac.refreshTopologySince(ctx, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	return tac.cache.All(), nil
 }
 
 // RefreshTopology 刷新拓扑信息
@@ -737,33 +1313,182 @@ func (tac *TopologyAwareClient) RemoveTopologyEventListener(listener TopologyEve
 	tac.eventSubscriber.RemoveListener(listener)
 }
 
+// DroppedTopologyEvents 监听器处理过慢时被丢弃的拓扑事件数
+func (tac *TopologyAwareClient) DroppedTopologyEvents(listener TopologyEventListener) int64 {
+	return tac.eventSubscriber.DroppedEvents(listener)
+}
+
+// 内部方法：为所有分片创建连接池，无法解析地址的节点通过连接池的预热回调报告
+func (tac *TopologyAwareClient) warmShardPools(ctx context.Context, pool *ShardAwareConnectionPool) {
+	shards, err := tac.GetAllShardsCtx(ctx)
+	if err != nil {
+		return
+	}
+
+	tac.poolSyncMu.Lock()
+	defer tac.poolSyncMu.Unlock()
+	for _, shardInfo := range shards {
+		pool.EnsureShard(shardInfo)
+	}
+}
+
+// 内部方法：按缓存中的分片信息同步分片的连接池，分片已不在缓存中时关闭其连接池
+func (tac *TopologyAwareClient) syncShardPool(shardID string) {
+	tac.mu.RLock()
+	pool := tac.shardPool
+	tac.mu.RUnlock()
+	if pool == nil {
+		return
+	}
+
+	tac.poolSyncMu.Lock()
+	defer tac.poolSyncMu.Unlock()
+	if shardInfo, ok := tac.cache.Peek(shardID); ok {
+		pool.EnsureShard(shardInfo)
+	} else {
+		pool.RemoveShard(shardID)
+	}
+}
+
+// topologyResponse 服务端拓扑接口的响应
+type topologyResponse struct {
+	Version  int64        `json:"version"`  // 全局版本号
+	Shards   []*ShardInfo `json:"shards"`   // 版本号比请求的sinceVersion新的分片
+	Complete bool         `json:"complete"` // 返回了全部分片，不在其中的分片已被合并或删除
+
+	// 分片范围所在哈希空间的算法，迁移窗口内PreviousKeyHash为之前的算法；旧版本的服务端不返回
+	KeyHash         *keyhash.Algorithm `json:"keyHash,omitempty"`
+	PreviousKeyHash *keyhash.Algorithm `json:"previousKeyHash,omitempty"`
+}
+
+// negotiateKeyHash 选择与服务端一致的键哈希算法：优先使用分片所用的算法，
+// 迁移窗口内不支持新算法时退回之前的算法；未通告算法的服务端使用keyhash.Legacy
+func (resp *topologyResponse) negotiateKeyHash() (keyhash.Algorithm, error) {
+	if resp.KeyHash == nil {
+		return keyhash.Legacy, nil
+	}
+	if resp.KeyHash.IsSupported() {
+		return *resp.KeyHash, nil
+	}
+	if resp.PreviousKeyHash != nil && resp.PreviousKeyHash.IsSupported() {
+		return *resp.PreviousKeyHash, nil
+	}
+	return keyhash.Algorithm{}, &KeyHashMismatchError{Advertised: *resp.KeyHash, Previous: resp.PreviousKeyHash}
+}
+
+// 内部方法：拓扑请求的超时上下文，parent先结束时随之结束
+func (tac *TopologyAwareClient) updateContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if tac.config.UpdateTimeout > 0 {
+		return context.WithTimeout(parent, tac.config.UpdateTimeout)
+	}
+	return context.WithCancel(parent)
+}
+
 // 内部方法：从服务端获取分片信息
-func (tac *TopologyAwareClient) fetchShardInfoFromServer(key string) (*ShardInfo, error) {
-	// TODO: 实现实际的服务端通信逻辑
-	// 这里应该调用raftserver的API获取分片信息
-
-	// 暂时返回模拟数据
-	shardInfo := &ShardInfo{
-		ID:        "shard-1",
-		Range:     ShardRange{StartHash: 0, EndHash: 1000},
-		Primary:   NodeID("node-1"),
-		Replicas:  []NodeID{NodeID("node-2"), NodeID("node-3")},
-		State:     ShardStateActive,
-		Version:   1,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-		Metadata:  make(map[string]string),
+// 服务端不可达时退回到缓存中已过期的分片信息
+func (tac *TopologyAwareClient) fetchShardInfoFromServer(parent context.Context, key string) (*ShardInfo, error) {
+	ctx, cancel := tac.updateContext(parent)
+	defer cancel()
+
+	// 键所在的分片可能已被驱逐，增量获取无法取回，因此获取完整拓扑
+	err := tac.refreshTopologySince(ctx, 0)
+	if errors.Is(err, ErrKeyHashMismatch) {
+		return nil, err
+	}
+	if shardInfo, ok := tac.cache.GetByHash(tac.cache.HashKey(key), err != nil); ok {
+		return shardInfo, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return shardInfo, nil
+	return nil, &ShardNotFoundError{Key: key}
 }
 
-// 内部方法：刷新拓扑信息
+// 内部方法：刷新拓扑信息，按缓存的全局版本号增量获取
 func (tac *TopologyAwareClient) refreshTopology(ctx context.Context) error {
-	// TODO: 实现从服务端批量获取拓扑信息的逻辑
+	since := tac.cache.Version()
+	if tac.cache.Size() == 0 {
+		since = 0
+	}
+	return tac.refreshTopologySince(ctx, since)
+}
+
+// 内部方法：获取版本号比since新的分片并合并到缓存，since为0时获取完整拓扑
+func (tac *TopologyAwareClient) refreshTopologySince(ctx context.Context, since int64) error {
+	resp, err := tac.fetchTopology(ctx, since)
+	if err != nil {
+		return err
+	}
+	// 无法按服务端的算法计算键的哈希时不使用这份拓扑，避免把键路由到错误的分片
+	algorithm, err := resp.negotiateKeyHash()
+	if err != nil {
+		return err
+	}
+
+	cached := tac.cache.Size() > 0
+	changed := tac.cache.SetKeyHash(algorithm)
+	tac.cache.Merge(resp.Shards, since == 0 || resp.Complete)
+	tac.cache.UpdateVersion(resp.Version)
+	if changed && cached {
+		// 已缓存的路由中，同一个键可能落到另一个分片，通知监听器各分片的路由都已变化
+		tac.eventSubscriber.PublishEvent(keyHashChangedEvent(tac.cache.All(), resp.Version))
+	}
 	return nil
 }
 
+// keyHashChangedEvent 键哈希算法变化后，以所有分片的更新事件组成的批量事件
+func keyHashChangedEvent(shards map[string]*ShardInfo, version int64) TopologyEvent {
+	now := time.Now()
+	batch := TopologyEvent{Type: EventTopologyBatch, Version: version, Timestamp: now, Source: "keyHash"}
+	for id, shardInfo := range shards {
+		batch.Events = append(batch.Events, TopologyEvent{
+			Type:      EventShardUpdated,
+			ShardID:   id,
+			ShardInfo: shardInfo,
+			Version:   version,
+			Timestamp: now,
+			Source:    "keyHash",
+		})
+	}
+	return batch
+}
+
+// 内部方法：请求服务端的拓扑接口，每轮依次尝试所有节点，失败后按RetryInterval重试MaxRetries次
+func (tac *TopologyAwareClient) fetchTopology(ctx context.Context, since int64) (*topologyResponse, error) {
+	conns, err := tac.Client.connections()
+	if err != nil {
+		return nil, err
+	}
+
+	path := "/api/topology?sinceVersion=" + strconv.FormatInt(since, 10)
+
+	var lastErr error
+	for attempt := 0; attempt <= tac.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("获取拓扑信息失败: %w (最后错误: %v)", ctx.Err(), lastErr)
+			case <-time.After(tac.config.RetryInterval):
+			}
+		}
+
+		for _, conn := range conns {
+			var resp topologyResponse
+			if err := tac.Client.sendTo(ctx, conn, http.MethodGet, path, nil, nil, &resp); err != nil {
+				if ctx.Err() != nil {
+					return nil, fmt.Errorf("获取拓扑信息失败: %w", ctx.Err())
+				}
+				lastErr = err
+				continue
+			}
+			return &resp, nil
+		}
+	}
+
+	return nil, fmt.Errorf("获取拓扑信息失败: %w", lastErr)
+}
+
 // 内部方法：定期刷新循环
 func (tac *TopologyAwareClient) refreshLoop(ctx context.Context) {
 	ticker := time.NewTicker(tac.config.RefreshInterval)
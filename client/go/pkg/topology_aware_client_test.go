@@ -0,0 +1,1001 @@
+/*
+* @Author: Lzww0608
+* @Date: 22025-7-2 22:23:37
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-2 22:23:40
+* @Description: ConcordKV intelligent client - topology aware module tests
+ */
+
+package concord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/concordkv/client/go/keyhash"
+)
+
+// topologyScript 模拟服务端的拓扑接口，事件流的每次连接按顺序执行一段脚本
+type topologyScript struct {
+	mu          sync.Mutex
+	topology    func(sinceVersion string) (int64, []*ShardInfo)
+	streams     []func(w http.ResponseWriter, r *http.Request)
+	sinces      []string // 每次事件流连接携带的sinceVersion
+	fullFetches int
+	complete    bool // 拓扑接口的响应是否标记为包含全部分片
+
+	// 拓扑接口通告的键哈希算法，keyHash为nil时不通告（模拟旧版本的服务端）
+	keyHash         *keyhash.Algorithm
+	previousKeyHash *keyhash.Algorithm
+}
+
+func (ts *topologyScript) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	since := r.URL.Query().Get("sinceVersion")
+
+	ts.mu.Lock()
+	switch r.URL.Path {
+	case "/api/topology":
+		if since == "0" {
+			ts.fullFetches++
+		}
+		version, shards := ts.topology(since)
+		response := map[string]interface{}{"success": true, "version": version, "shards": shards, "complete": ts.complete}
+		if ts.keyHash != nil {
+			response["keyHash"] = ts.keyHash
+		}
+		if ts.previousKeyHash != nil {
+			response["previousKeyHash"] = ts.previousKeyHash
+		}
+		ts.mu.Unlock()
+		json.NewEncoder(w).Encode(response)
+	case "/api/topology/events":
+		n := len(ts.sinces)
+		ts.sinces = append(ts.sinces, since)
+		ts.mu.Unlock()
+		if n >= len(ts.streams) {
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		ts.streams[n](w, r)
+	default:
+		ts.mu.Unlock()
+		http.NotFound(w, r)
+	}
+}
+
+// connections 事件流连接携带的sinceVersion
+func (ts *topologyScript) connections() []string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return append([]string(nil), ts.sinces...)
+}
+
+// writeTopologyEvent 写出一个完整的拓扑事件
+func writeTopologyEvent(w http.ResponseWriter, eventType TopologyEventType, primary string, version int64) {
+	event := TopologyEvent{
+		Type:      eventType,
+		ShardID:   "shard-0",
+		ShardInfo: testShard(primary, version),
+		Version:   version,
+	}
+	data, _ := json.Marshal(event)
+	fmt.Fprintf(w, "event: topology\ndata: %s\n\n", data)
+	w.(http.Flusher).Flush()
+}
+
+// testShard 覆盖整个哈希环的分片
+func testShard(primary string, version int64) *ShardInfo {
+	return &ShardInfo{
+		ID:      "shard-0",
+		Range:   ShardRange{StartHash: 0, EndHash: ^uint64(0)},
+		Primary: NodeID(primary),
+		Version: version,
+	}
+}
+
+// newScriptedTopologyClient 创建连接到脚本服务端的拓扑感知客户端
+func newScriptedTopologyClient(t *testing.T, script *topologyScript) *TopologyAwareClient {
+	t.Helper()
+
+	client := newUninitializedTopologyClient(t, script)
+	if err := client.Initialize(context.Background()); err != nil {
+		t.Fatalf("初始化客户端失败: %v", err)
+	}
+	return client
+}
+
+// newUninitializedTopologyClient 创建连接到脚本服务端、尚未初始化的拓扑感知客户端
+func newUninitializedTopologyClient(t *testing.T, script *topologyScript) *TopologyAwareClient {
+	t.Helper()
+
+	server := httptest.NewServer(script)
+	t.Cleanup(server.Close)
+
+	config := DefaultTopologyConfig()
+	config.RefreshInterval = 0
+	config.RetryInterval = 10 * time.Millisecond
+	config.ReconnectInterval = 10 * time.Millisecond
+	config.EventStreamTimeout = time.Second
+
+	client, err := NewTopologyAwareClient(Config{
+		Endpoints:      []string{strings.TrimPrefix(server.URL, "http://")},
+		RetryCount:     1,
+		DisableSession: true,
+	}, config)
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+// waitForPrimary 等待缓存中的分片主节点与版本号达到期望值
+func waitForPrimary(t *testing.T, client *TopologyAwareClient, primary string, version int64) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		shards, _ := client.GetAllShards()
+		if shard := shards["shard-0"]; shard != nil && string(shard.Primary) == primary && shard.Version == version {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	shards, _ := client.GetAllShards()
+	t.Fatalf("期望主节点 %s@%d，实际 %+v", primary, version, shards["shard-0"])
+}
+
+// TestTopologyEventStreamReconnect 连接中途断开后以最新版本重连，服务端补发错过的事件
+func TestTopologyEventStreamReconnect(t *testing.T) {
+	script := &topologyScript{
+		topology: func(string) (int64, []*ShardInfo) {
+			return 10, []*ShardInfo{testShard("node1", 10)}
+		},
+		streams: []func(w http.ResponseWriter, r *http.Request){
+			func(w http.ResponseWriter, r *http.Request) {
+				writeTopologyEvent(w, EventShardUpdated, "node2", 11)
+				// 事件写到一半时断开，未结束的事件应被丢弃
+				fmt.Fprint(w, "event: topology\ndata: {\"type\":2,\"shardId\":\"shard-0\",\"version\":12")
+			},
+			func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, ": keepalive\n\n")
+				writeTopologyEvent(w, EventShardMigration, "node3", 12)
+				<-r.Context().Done()
+			},
+		},
+	}
+	client := newScriptedTopologyClient(t, script)
+
+	waitForPrimary(t, client, "node3", 12)
+	if sinces := script.connections(); len(sinces) != 2 || sinces[0] != "10" || sinces[1] != "11" {
+		t.Fatalf("重连应携带最新版本号，实际 %v", sinces)
+	}
+}
+
+// TestTopologyEventStreamResync 版本差距过大时重新获取完整拓扑，之后收到的旧事件不覆盖缓存
+func TestTopologyEventStreamResync(t *testing.T) {
+	script := &topologyScript{}
+	script.topology = func(string) (int64, []*ShardInfo) {
+		if script.fullFetches > 1 {
+			return 20, []*ShardInfo{testShard("node5", 20)}
+		}
+		return 10, []*ShardInfo{testShard("node1", 10)}
+	}
+	script.streams = []func(w http.ResponseWriter, r *http.Request){
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "event: resync\ndata: {\"type\":\"resync\",\"reason\":\"compacted\",\"version\":20}\n\n")
+			writeTopologyEvent(w, EventShardUpdated, "node4", 15)
+			writeTopologyEvent(w, EventShardRemoved, "", 16)
+			<-r.Context().Done()
+		},
+	}
+	client := newScriptedTopologyClient(t, script)
+
+	waitForPrimary(t, client, "node5", 20)
+
+	// 旧版本的更新与删除事件都不应生效
+	time.Sleep(50 * time.Millisecond)
+	waitForPrimary(t, client, "node5", 20)
+}
+
+// TestTopologyEventStreamBackoff 连接失败时退避时间翻倍，服务端恢复后重新建立事件流
+func TestTopologyEventStreamBackoff(t *testing.T) {
+	subscriber := NewTopologyEventSubscriber(&TopologyConfig{ReconnectInterval: time.Second}, NewTopologyCache(nil))
+	for failures, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if got := subscriber.reconnectBackoff(failures); got != want {
+			t.Errorf("失败 %d 次后退避 %v，期望 %v", failures, got, want)
+		}
+	}
+	if got := subscriber.reconnectBackoff(100); got != maxReconnectBackoff {
+		t.Errorf("退避时间应不超过 %v，实际 %v", maxReconnectBackoff, got)
+	}
+
+	unavailable := func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "服务暂不可用", http.StatusServiceUnavailable)
+	}
+	script := &topologyScript{
+		topology: func(string) (int64, []*ShardInfo) {
+			return 10, []*ShardInfo{testShard("node1", 10)}
+		},
+		streams: []func(w http.ResponseWriter, r *http.Request){
+			unavailable,
+			unavailable,
+			func(w http.ResponseWriter, r *http.Request) {
+				writeTopologyEvent(w, EventShardUpdated, "node2", 11)
+				<-r.Context().Done()
+			},
+		},
+	}
+	client := newScriptedTopologyClient(t, script)
+
+	waitForPrimary(t, client, "node2", 11)
+	if sinces := script.connections(); len(sinces) != 3 {
+		t.Fatalf("期望3次连接，实际 %v", sinces)
+	}
+}
+
+// batchRecorder 记录收到的拓扑事件
+type batchRecorder struct {
+	mu     sync.Mutex
+	events []TopologyEvent
+}
+
+func (r *batchRecorder) OnTopologyEvent(event TopologyEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *batchRecorder) received() []TopologyEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]TopologyEvent(nil), r.events...)
+}
+
+// TestTopologyEventStreamBatch 批量事件中的全部变更应用到缓存后只通知监听器一次；
+// 同一分片的多个更新按版本顺序应用，顺序颠倒时旧版本不覆盖新版本
+func TestTopologyEventStreamBatch(t *testing.T) {
+	added := &ShardInfo{ID: "shard-1", Range: ShardRange{StartHash: 1 << 63, EndHash: ^uint64(0)}, Primary: "node3", Version: 12}
+	script := &topologyScript{
+		topology: func(string) (int64, []*ShardInfo) {
+			return 10, []*ShardInfo{testShard("node1", 10)}
+		},
+		streams: []func(w http.ResponseWriter, r *http.Request){
+			func(w http.ResponseWriter, r *http.Request) {
+				batch := TopologyEvent{Version: 12, Events: []TopologyEvent{
+					{Type: EventShardUpdated, ShardID: "shard-0", ShardInfo: testShard("node3", 12), Version: 12},
+					{Type: EventShardAdded, ShardID: "shard-1", ShardInfo: added, Version: 12},
+					{Type: EventShardUpdated, ShardID: "shard-0", ShardInfo: testShard("node2", 11), Version: 11},
+				}}
+				data, _ := json.Marshal(batch)
+				fmt.Fprintf(w, "event: topology-batch\ndata: %s\n\n", data)
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+			},
+		},
+	}
+	client := newUninitializedTopologyClient(t, script)
+	recorder := &batchRecorder{}
+	client.eventSubscriber.AddListener(recorder)
+	if err := client.Initialize(context.Background()); err != nil {
+		t.Fatalf("初始化客户端失败: %v", err)
+	}
+
+	waitForPrimary(t, client, "node3", 12)
+	if shard, ok := client.cache.Get("shard-1"); !ok || shard.Primary != "node3" {
+		t.Fatalf("批量事件中添加的分片未写入缓存: %+v", shard)
+	}
+	if version := client.cache.Version(); version != 12 {
+		t.Errorf("缓存版本 = %d, 期望 12", version)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(recorder.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	events := recorder.received()
+	if len(events) != 1 || events[0].Type != EventTopologyBatch || len(events[0].Events) != 3 {
+		t.Fatalf("监听器应只收到一个包含3个变更的批量事件，实际 %+v", events)
+	}
+	if events[0].Events[0].Version != 11 {
+		t.Errorf("批量事件中的变更应按版本顺序排列: %+v", events[0].Events)
+	}
+}
+
+// newListenerTestSubscriber 创建不连接服务端的事件订阅器，监听器的panic日志被丢弃
+func newListenerTestSubscriber(t *testing.T, queueSize int) *TopologyEventSubscriber {
+	t.Helper()
+
+	subscriber := NewTopologyEventSubscriber(&TopologyConfig{ListenerQueueSize: queueSize}, NewTopologyCache(nil))
+	subscriber.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	if err := subscriber.Start(context.Background()); err != nil {
+		t.Fatalf("启动事件订阅器失败: %v", err)
+	}
+	t.Cleanup(subscriber.Stop)
+	return subscriber
+}
+
+// publishNodeEvents 发布版本为1到count的节点事件，这类事件不修改缓存
+func publishNodeEvents(subscriber *TopologyEventSubscriber, count int) {
+	for version := int64(1); version <= int64(count); version++ {
+		subscriber.PublishEvent(TopologyEvent{Type: EventNodeUpdated, Version: version})
+	}
+}
+
+// waitEvents 等待记录器收到count个事件
+func waitEvents(t *testing.T, recorder *batchRecorder, count int) []TopologyEvent {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		events := recorder.received()
+		if len(events) >= count {
+			return events
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待 %d 个事件超时，实际收到 %d 个", count, len(events))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// panickingTopologyListener 每个事件都panic
+type panickingTopologyListener struct {
+	calls int64
+}
+
+func (l *panickingTopologyListener) OnTopologyEvent(event TopologyEvent) {
+	atomic.AddInt64(&l.calls, 1)
+	panic("topology event")
+}
+
+// TestTopologyListenerPanic 监听器panic被恢复，后续事件照常投递给它和其他监听器
+func TestTopologyListenerPanic(t *testing.T) {
+	subscriber := newListenerTestSubscriber(t, 0)
+	panicking := &panickingTopologyListener{}
+	recorder := &batchRecorder{}
+	subscriber.AddListener(panicking)
+	subscriber.AddListener(recorder)
+
+	publishNodeEvents(subscriber, 5)
+
+	events := waitEvents(t, recorder, 5)
+	for i, event := range events {
+		if event.Version != int64(i+1) {
+			t.Fatalf("事件应按发布顺序投递: %+v", events)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&panicking.calls) < 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("panic后的事件没有继续投递，回调次数为 %d", atomic.LoadInt64(&panicking.calls))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// blockingTopologyListener 回调阻塞直到release关闭，记录最后收到的版本
+type blockingTopologyListener struct {
+	release   chan struct{}
+	delivered int64
+	last      int64
+}
+
+func (l *blockingTopologyListener) OnTopologyEvent(event TopologyEvent) {
+	<-l.release
+	atomic.AddInt64(&l.delivered, 1)
+	atomic.StoreInt64(&l.last, event.Version)
+}
+
+// TestTopologyListenerBlocking 阻塞的监听器不影响其他监听器，队列满时丢弃最早的事件并计数
+func TestTopologyListenerBlocking(t *testing.T) {
+	const queueSize, count = 2, 10
+	subscriber := newListenerTestSubscriber(t, queueSize)
+	blocking := &blockingTopologyListener{release: make(chan struct{})}
+	recorder := &batchRecorder{}
+	subscriber.AddListener(blocking)
+	subscriber.AddListener(recorder)
+
+	// 逐个发布，正常的监听器跟得上，不会丢弃事件
+	for version := int64(1); version <= count; version++ {
+		subscriber.PublishEvent(TopologyEvent{Type: EventNodeUpdated, Version: version})
+		waitEvents(t, recorder, int(version))
+	}
+
+	// 一个事件阻塞在回调中，队列中最多再排queueSize个，其余被丢弃
+	if dropped := subscriber.DroppedEvents(blocking); dropped < count-1-queueSize {
+		t.Fatalf("阻塞的监听器丢弃了 %d 个事件，期望至少 %d 个", dropped, count-1-queueSize)
+	}
+	if dropped := subscriber.DroppedEvents(recorder); dropped != 0 {
+		t.Fatalf("正常的监听器丢弃了 %d 个事件", dropped)
+	}
+
+	close(blocking.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&blocking.delivered)+subscriber.DroppedEvents(blocking) != count {
+		if time.Now().After(deadline) {
+			t.Fatalf("投递 %d 个、丢弃 %d 个，共发布 %d 个",
+				atomic.LoadInt64(&blocking.delivered), subscriber.DroppedEvents(blocking), count)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if last := atomic.LoadInt64(&blocking.last); last != count {
+		t.Errorf("丢弃最早的事件后应收到最新的事件，最后收到版本 %d", last)
+	}
+}
+
+// selfRemovingTopologyListener 第一次收到事件时在回调中取消订阅
+type selfRemovingTopologyListener struct {
+	subscriber *TopologyEventSubscriber
+	calls      int64
+}
+
+func (l *selfRemovingTopologyListener) OnTopologyEvent(event TopologyEvent) {
+	atomic.AddInt64(&l.calls, 1)
+	l.subscriber.RemoveListener(l)
+}
+
+// TestTopologyListenerRemoveDuringDispatch 在回调中或并发地增删监听器不会死锁，取消订阅后不再收到事件
+func TestTopologyListenerRemoveDuringDispatch(t *testing.T) {
+	subscriber := newListenerTestSubscriber(t, 0)
+	self := &selfRemovingTopologyListener{subscriber: subscriber}
+	recorder := &batchRecorder{}
+	subscriber.AddListener(self)
+	subscriber.AddListener(recorder)
+
+	done := make(chan struct{})
+	var churn sync.WaitGroup
+	churn.Add(1)
+	go func() {
+		defer churn.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			listener := &batchRecorder{}
+			subscriber.AddListener(listener)
+			subscriber.RemoveListener(listener)
+		}
+	}()
+
+	publishNodeEvents(subscriber, 20)
+	waitEvents(t, recorder, 20)
+	close(done)
+	churn.Wait()
+
+	if calls := atomic.LoadInt64(&self.calls); calls != 1 {
+		t.Fatalf("回调中取消订阅后仍收到事件，回调 %d 次", calls)
+	}
+}
+
+// TestTopologySplitRouting 分片拆分后，拆分点两侧的键路由到各自的分片；
+// 键所在的分片处于迁移状态时刷新拓扑重试，迁移一直未完成时返回ErrShardMigrating
+func TestTopologySplitRouting(t *testing.T) {
+	const splitHash = uint64(1) << 63
+	var below, above string
+	for i := 0; below == "" || above == ""; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if keyhash.Canonical.Sum64(key) < splitHash {
+			below = key
+		} else {
+			above = key
+		}
+	}
+
+	splitShards := func(state ShardState, version int64) []*ShardInfo {
+		return []*ShardInfo{
+			{ID: "shard-0", Range: ShardRange{StartHash: 0, EndHash: splitHash}, Primary: "node1", State: state, Version: version},
+			{ID: "shard-1", Range: ShardRange{StartHash: splitHash, EndHash: ^uint64(0)}, Primary: "node1", State: state, Version: version},
+		}
+	}
+
+	// 拆分后第一次获取到的分片仍在迁移，之后完成迁移；stuck时一直处于迁移状态
+	phase, fetches, stuck := 0, 0, false
+	script := &topologyScript{complete: true, keyHash: &keyhash.Canonical}
+	script.topology = func(string) (int64, []*ShardInfo) {
+		switch {
+		case phase == 0:
+			return 1, []*ShardInfo{testShard("node1", 1)}
+		case stuck:
+			return 10, splitShards(ShardStateMigrating, 10)
+		}
+		fetches++
+		if fetches == 1 {
+			return 2, splitShards(ShardStateMigrating, 2)
+		}
+		return 3, splitShards(ShardStateActive, 3)
+	}
+	client := newScriptedTopologyClient(t, script)
+
+	for _, key := range []string{below, above} {
+		if shard, err := client.GetShardInfo(key); err != nil || shard.ID != "shard-0" {
+			t.Fatalf("拆分前键 %s 应在shard-0: %+v, %v", key, shard, err)
+		}
+	}
+
+	script.mu.Lock()
+	phase = 1
+	script.mu.Unlock()
+	if err := client.RefreshTopology(context.Background()); err != nil {
+		t.Fatalf("刷新拓扑失败: %v", err)
+	}
+
+	shard, err := client.GetShardInfo(below)
+	if err != nil || shard.ID != "shard-0" || shard.State != ShardStateActive || shard.Range.EndHash != splitHash {
+		t.Fatalf("拆分点之前的键应在迁移完成后路由到左分片: %+v, %v", shard, err)
+	}
+	shard, err = client.GetShardInfo(above)
+	if err != nil || shard.ID != "shard-1" || shard.State != ShardStateActive {
+		t.Fatalf("拆分点之后的键应路由到右分片: %+v, %v", shard, err)
+	}
+	if shards, _ := client.GetAllShards(); len(shards) != 2 {
+		t.Errorf("缓存中应有两个分片: %+v", shards)
+	}
+
+	script.mu.Lock()
+	stuck = true
+	script.mu.Unlock()
+	if err := client.RefreshTopology(context.Background()); err != nil {
+		t.Fatalf("刷新拓扑失败: %v", err)
+	}
+	if _, err := client.GetShardInfo(above); !errors.Is(err, ErrShardMigrating) {
+		t.Fatalf("迁移一直未完成时应返回ErrShardMigrating: %v", err)
+	}
+}
+
+// countingFactory 记录建立的连接数
+type countingFactory struct {
+	fakeConnectionFactory
+	created int64
+}
+
+func (f *countingFactory) CreateConnection(nodeID NodeID, shardID string, address string) (*Connection, error) {
+	atomic.AddInt64(&f.created, 1)
+	return f.fakeConnectionFactory.CreateConnection(nodeID, shardID, address)
+}
+
+// TestTopologyShardPoolWarmUp 初始化时为所有分片的节点预热连接池，预热后的第一次取用不再建立连接；
+// 主节点变更与分片删除后关闭不再引用的节点连接池
+func TestTopologyShardPoolWarmUp(t *testing.T) {
+	const shardCount = 4
+	shards := make([]*ShardInfo, 0, shardCount)
+	step := ^uint64(0) / shardCount
+	for i := 0; i < shardCount; i++ {
+		shards = append(shards, &ShardInfo{
+			ID:       fmt.Sprintf("shard-%d", i),
+			Range:    ShardRange{StartHash: uint64(i) * step, EndHash: uint64(i+1) * step},
+			Primary:  "node1",
+			Replicas: []NodeID{"node2"},
+			Version:  10,
+		})
+	}
+
+	release := make(chan struct{})
+	script := &topologyScript{
+		topology: func(string) (int64, []*ShardInfo) { return 10, shards },
+		streams: []func(w http.ResponseWriter, r *http.Request){
+			func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-release:
+				case <-r.Context().Done():
+					return
+				}
+				moved := *shards[1]
+				moved.Primary, moved.Version = "node3", 11
+				for _, event := range []TopologyEvent{
+					{Type: EventShardUpdated, ShardID: "shard-1", ShardInfo: &moved, Version: 11},
+					{Type: EventShardRemoved, ShardID: "shard-3", Version: 12},
+				} {
+					data, _ := json.Marshal(event)
+					fmt.Fprintf(w, "event: topology\ndata: %s\n\n", data)
+				}
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+			},
+		},
+	}
+
+	config := DefaultPoolConfig()
+	config.InitialSize = 0
+	config.MinConnections = 0
+	config.PreWarmSize = 2
+	config.ShardWarmUpConcurrency = 2
+	config.EnableAutoScale = false
+	config.HealthCheckInterval = 0
+	factory := &countingFactory{fakeConnectionFactory: fakeConnectionFactory{dead: make(map[string]bool)}}
+	resolver := StaticNodeResolver{"node1": "10.0.0.1:9000", "node2": "10.0.0.2:9000", "node3": "10.0.0.3:9000"}
+	pool := NewShardAwareConnectionPool(config, factory, resolver)
+	if err := pool.Start(context.Background()); err != nil {
+		t.Fatalf("启动分片连接池失败: %v", err)
+	}
+	t.Cleanup(func() { pool.Stop() })
+
+	progress := make(chan ShardWarmUpProgress, 64)
+	pool.SetWarmUpCallback(func(p ShardWarmUpProgress) { progress <- p })
+	waitWarmUp := func(total int) {
+		t.Helper()
+		for {
+			select {
+			case p := <-progress:
+				if p.Err != nil {
+					t.Fatalf("预热 %s/%s 失败: %v", p.ShardID, p.NodeID, p.Err)
+				}
+				if p.Completed == total && p.Total == total {
+					return
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("等待 %d 个连接池预热超时", total)
+			}
+		}
+	}
+
+	client := newUninitializedTopologyClient(t, script)
+	client.SetShardPool(pool)
+	if err := client.Initialize(context.Background()); err != nil {
+		t.Fatalf("初始化客户端失败: %v", err)
+	}
+	waitWarmUp(2 * shardCount)
+
+	dialed := atomic.LoadInt64(&factory.created)
+	if dialed != 2*shardCount*int64(config.PreWarmSize) {
+		t.Fatalf("应为每个节点连接池预热 %d 个连接，共建立 %d 个", config.PreWarmSize, dialed)
+	}
+	for _, shard := range shards {
+		for _, strategy := range []RoutingStrategy{RoutingWritePrimary, RoutingReadReplica} {
+			conn, err := pool.GetConnection(context.Background(), shard, strategy)
+			if err != nil {
+				t.Fatalf("获取分片 %s 的连接失败: %v", shard.ID, err)
+			}
+			conn.Release()
+		}
+	}
+	if n := atomic.LoadInt64(&factory.created); n != dialed {
+		t.Fatalf("预热后的第一次取用不应建立连接，新建了 %d 个", n-dialed)
+	}
+
+	// shard-1的主节点改为node3，shard-3被删除
+	close(release)
+	waitWarmUp(2*shardCount + 1)
+	want := map[string]bool{
+		"shard-0/node1": true, "shard-0/node2": true,
+		"shard-1/node3": true, "shard-1/node2": true,
+		"shard-2/node1": true, "shard-2/node2": true,
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		stats := pool.GetStats().ShardStats
+		matched := len(stats) == len(want)
+		for key := range stats {
+			matched = matched && want[key]
+		}
+		if matched {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("连接池应与拓扑一致，实际 %v", stats)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	dialed = atomic.LoadInt64(&factory.created)
+	conn, err := pool.GetConnection(context.Background(), &ShardInfo{ID: "shard-1", Primary: "node3"}, RoutingWritePrimary)
+	if err != nil {
+		t.Fatalf("获取新主节点的连接失败: %v", err)
+	}
+	conn.Release()
+	if n := atomic.LoadInt64(&factory.created); n != dialed {
+		t.Fatalf("新主节点的连接池应已预热，新建了 %d 个连接", n-dialed)
+	}
+}
+
+// keyHashShards 在splitHash处拆分的两个分片，以及两种算法下分别落在拆分点两侧的键
+func keyHashShards(splitHash uint64) ([]*ShardInfo, string) {
+	var key string
+	for i := 0; key == ""; i++ {
+		candidate := fmt.Sprintf("key-%d", i)
+		if (keyhash.Canonical.Sum64(candidate) < splitHash) != (keyhash.Legacy.Sum64(candidate) < splitHash) {
+			key = candidate
+		}
+	}
+	return []*ShardInfo{
+		{ID: "shard-low", Range: ShardRange{StartHash: 0, EndHash: splitHash}, Primary: "node1", Version: 1},
+		{ID: "shard-high", Range: ShardRange{StartHash: splitHash, EndHash: 0}, Primary: "node1", Version: 1},
+	}, key
+}
+
+// TestTopologyKeyHashNegotiation 按服务端通告的键哈希算法查找分片：未通告时使用旧算法，
+// 迁移窗口内不支持新算法时退回之前的算法，都不支持时初始化失败而不是按错误的算法路由
+func TestTopologyKeyHashNegotiation(t *testing.T) {
+	const splitHash = uint64(1) << 63
+	shards, key := keyHashShards(splitHash)
+	owner := func(algorithm keyhash.Algorithm) string {
+		if algorithm.Sum64(key) < splitHash {
+			return "shard-low"
+		}
+		return "shard-high"
+	}
+	unknown := keyhash.Algorithm{Name: "xxhash64", Version: 2}
+
+	cases := []struct {
+		name     string
+		keyHash  *keyhash.Algorithm
+		previous *keyhash.Algorithm
+		want     keyhash.Algorithm
+	}{
+		{name: "规范算法", keyHash: &keyhash.Canonical, want: keyhash.Canonical},
+		{name: "未通告算法", want: keyhash.Legacy},
+		{name: "迁移窗口", keyHash: &unknown, previous: &keyhash.Legacy, want: keyhash.Legacy},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			script := &topologyScript{complete: true, keyHash: c.keyHash, previousKeyHash: c.previous}
+			script.topology = func(string) (int64, []*ShardInfo) { return 1, shards }
+			client := newScriptedTopologyClient(t, script)
+
+			if got := client.cache.KeyHash(); got != c.want {
+				t.Fatalf("应使用 %s，实际 %s", c.want, got)
+			}
+			shard, err := client.GetShardInfo(key)
+			if err != nil || shard.ID != owner(c.want) {
+				t.Fatalf("键 %s 应属于 %s，实际 %v %v", key, owner(c.want), shard, err)
+			}
+		})
+	}
+
+	// 不支持服务端通告的任何算法：初始化失败，不缓存无法正确路由的拓扑
+	script := &topologyScript{complete: true, keyHash: &unknown}
+	script.topology = func(string) (int64, []*ShardInfo) { return 1, shards }
+	client := newUninitializedTopologyClient(t, script)
+	err := client.Initialize(context.Background())
+	var mismatch *KeyHashMismatchError
+	if !errors.Is(err, ErrKeyHashMismatch) || !errors.As(err, &mismatch) || mismatch.Advertised != unknown {
+		t.Fatalf("应返回KeyHashMismatchError，实际 %v", err)
+	}
+	if size := client.cache.Size(); size != 0 {
+		t.Fatalf("不应缓存无法路由的拓扑，实际缓存了 %d 个分片", size)
+	}
+	if _, err := client.GetShardInfo(key); !errors.Is(err, ErrKeyHashMismatch) {
+		t.Fatalf("查找分片应返回ErrKeyHashMismatch，实际 %v", err)
+	}
+}
+
+// TestTopologyKeyHashChange 服务端更换键哈希算法后，刷新拓扑即按新算法查找分片，并以批量事件通知各分片的路由已变化
+func TestTopologyKeyHashChange(t *testing.T) {
+	const splitHash = uint64(1) << 63
+	shards, key := keyHashShards(splitHash)
+
+	script := &topologyScript{complete: true, keyHash: &keyhash.Legacy}
+	script.topology = func(string) (int64, []*ShardInfo) { return 1, shards }
+	client := newUninitializedTopologyClient(t, script)
+	recorder := &batchRecorder{}
+	client.eventSubscriber.AddListener(recorder)
+	if err := client.Initialize(context.Background()); err != nil {
+		t.Fatalf("初始化客户端失败: %v", err)
+	}
+	before, err := client.GetShardInfo(key)
+	if err != nil {
+		t.Fatalf("查找分片失败: %v", err)
+	}
+
+	script.mu.Lock()
+	script.keyHash, script.previousKeyHash = &keyhash.Canonical, &keyhash.Legacy
+	script.mu.Unlock()
+	if err := client.RefreshTopology(context.Background()); err != nil {
+		t.Fatalf("刷新拓扑失败: %v", err)
+	}
+
+	after, err := client.GetShardInfo(key)
+	if err != nil || after.ID == before.ID {
+		t.Fatalf("更换算法后键 %s 应属于另一个分片，之前 %s，之后 %v %v", key, before.ID, after, err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		events := recorder.received()
+		if len(events) == 1 && events[0].Type == EventTopologyBatch && len(events[0].Events) == len(shards) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("监听器应收到一个包含所有分片的批量事件，实际 %+v", events)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// ringShards 把哈希环等分为n个分片，最后一个分片以0点为终点；offset使所有分片整体偏移，令一个分片跨越0点
+func ringShards(n int, offset uint64) []*ShardInfo {
+	step := ^uint64(0)/uint64(n) + 1
+	shards := make([]*ShardInfo, 0, n)
+	for i := 0; i < n; i++ {
+		shards = append(shards, &ShardInfo{
+			ID:      fmt.Sprintf("shard-%d", i),
+			Range:   ShardRange{StartHash: uint64(i)*step + offset, EndHash: uint64(i+1)*step + offset},
+			Primary: "node1",
+			Version: 1,
+		})
+	}
+	return shards
+}
+
+// TestTopologyCacheWrappingRange 按哈希查找分片时正确处理跨越0点的范围，结果与逐个分片检查Contains一致
+func TestTopologyCacheWrappingRange(t *testing.T) {
+	cache := NewTopologyCache(nil)
+	shards := ringShards(8, 1<<60)
+	cache.Merge(shards, true)
+
+	owner := func(hash uint64) string {
+		for _, shard := range shards {
+			if shard.Range.Contains(hash) {
+				return shard.ID
+			}
+		}
+		return ""
+	}
+
+	wrap := shards[len(shards)-1]
+	if wrap.Range.StartHash <= wrap.Range.EndHash {
+		t.Fatalf("最后一个分片应跨越0点: %+v", wrap.Range)
+	}
+	hashes := []uint64{0, 1, wrap.Range.EndHash - 1, wrap.Range.EndHash, wrap.Range.StartHash - 1, wrap.Range.StartHash, ^uint64(0)}
+	for _, shard := range shards {
+		hashes = append(hashes, shard.Range.StartHash, shard.Range.EndHash-1)
+	}
+	for _, hash := range hashes {
+		shard, ok := cache.GetByHash(hash, false)
+		if !ok || shard.ID != owner(hash) {
+			t.Fatalf("哈希 %#x 应属于 %s，实际 %v %v", hash, owner(hash), shard, ok)
+		}
+	}
+
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		shard, ok := cache.GetByKey(key)
+		if !ok || shard.ID != owner(keyhash.Canonical.Sum64(key)) {
+			t.Fatalf("键 %s 应属于 %s，实际 %v %v", key, owner(keyhash.Canonical.Sum64(key)), shard, ok)
+		}
+	}
+
+	// 跨越0点的分片拆分后，0点两侧分别由两个分片负责
+	cache.Merge([]*ShardInfo{
+		{ID: wrap.ID, Range: ShardRange{StartHash: wrap.Range.StartHash, EndHash: 0}, Primary: "node1", Version: 2},
+		{ID: "shard-low", Range: ShardRange{StartHash: 0, EndHash: wrap.Range.EndHash}, Primary: "node2", Version: 2},
+	}, false)
+	if shard, ok := cache.GetByHash(^uint64(0), false); !ok || shard.ID != wrap.ID {
+		t.Fatalf("哈希最大值应属于 %s，实际 %v", wrap.ID, shard)
+	}
+	if shard, ok := cache.GetByHash(0, false); !ok || shard.ID != "shard-low" {
+		t.Fatalf("哈希0应属于shard-low，实际 %v", shard)
+	}
+
+	// 分片被驱逐后不再命中
+	cache.EvictShard("shard-low")
+	if shard, ok := cache.GetByHash(0, true); ok {
+		t.Fatalf("驱逐后不应命中: %v", shard)
+	}
+}
+
+// TestTopologyCacheStatsConcurrent 查找、更新与读取统计并发进行：计数互相一致，命中率不超过1，延迟来自最近的查找
+func TestTopologyCacheStatsConcurrent(t *testing.T) {
+	const lookups = 2000
+
+	cache := NewTopologyCache(nil)
+	shards := ringShards(8, 0)
+	cache.Merge(shards, true)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for r := 0; r < 2; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				stats := cache.GetStats()
+				if stats.HitRatio < 0 || stats.HitRatio > 1 {
+					t.Errorf("命中率超出范围: %v", stats.HitRatio)
+					return
+				}
+				if stats.CacheHits+stats.CacheMisses > stats.TotalRequests {
+					t.Errorf("命中 %d 与未命中 %d 之和超过总请求数 %d", stats.CacheHits, stats.CacheMisses, stats.TotalRequests)
+					return
+				}
+			}
+		}()
+	}
+
+	var lookupers sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		lookupers.Add(1)
+		go func(g int) {
+			defer lookupers.Done()
+			for i := 0; i < lookups; i++ {
+				switch i % 4 {
+				case 0:
+					cache.Get(fmt.Sprintf("missing-%d", g))
+				case 1:
+					cache.Get(shards[i%len(shards)].ID)
+				case 2:
+					cache.SetIfNewer(&ShardInfo{ID: shards[g].ID, Range: shards[g].Range, Primary: "node1", Version: int64(i)})
+				default:
+					cache.GetByKey(fmt.Sprintf("key-%d", i))
+				}
+			}
+		}(g)
+	}
+	lookupers.Wait()
+	close(stop)
+	wg.Wait()
+
+	stats := cache.GetStats()
+	if stats.TotalRequests != stats.CacheHits+stats.CacheMisses {
+		t.Fatalf("总请求数 %d 应等于命中 %d 与未命中 %d 之和", stats.TotalRequests, stats.CacheHits, stats.CacheMisses)
+	}
+	if want := int64(4 * lookups * 3 / 4); stats.TotalRequests != want {
+		t.Fatalf("总请求数为 %d，期望 %d", stats.TotalRequests, want)
+	}
+	if want := int64(4 * lookups / 4); stats.CacheMisses != want {
+		t.Fatalf("未命中数为 %d，期望 %d", stats.CacheMisses, want)
+	}
+	if stats.CurrentSize != len(shards) {
+		t.Fatalf("缓存大小为 %d，期望 %d", stats.CurrentSize, len(shards))
+	}
+	// 个别慢查找可以把平均值拉到p99之上，这里只检查已记录延迟，两者的计算由TestLatencySamplesSummary覆盖
+	if stats.AverageLatency <= 0 || stats.P99Latency <= 0 {
+		t.Fatalf("平均延迟 %vms 与p99延迟 %vms 应大于0", stats.AverageLatency, stats.P99Latency)
+	}
+	if stats.LastUpdate.IsZero() {
+		t.Fatal("更新缓存后LastUpdate应已设置")
+	}
+}
+
+// BenchmarkTopologyCacheGetByKey 访问100万个不同的键：查找耗时与键的数量无关，缓存占用的内存不随访问过的键增长
+func BenchmarkTopologyCacheGetByKey(b *testing.B) {
+	const distinctKeys = 1000000
+
+	cache := NewTopologyCache(nil)
+	cache.Merge(ringShards(256, 1<<60), true)
+	keys := make([]string, distinctKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("user:%08d", i)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := cache.GetByKey(keys[i%distinctKeys]); !ok {
+			b.Fatalf("键 %s 没有对应的分片", keys[i%distinctKeys])
+		}
+	}
+	b.StopTimer()
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc))/(1<<20), "heap-MiB")
+	runtime.KeepAlive(keys)
+}
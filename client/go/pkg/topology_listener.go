@@ -0,0 +1,109 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-30 11:05:37
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-30 11:05:37
+* @Description: ConcordKV Go client topology event listener queues
+ */
+
+package concord
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultListenerQueueSize 每个拓扑事件监听器队列的默认容量
+const defaultListenerQueueSize = 256
+
+// topologySubscription 一个拓扑事件监听器的事件队列与投递协程
+// 订阅器只向队列投递事件，不等待监听器处理；队列满时丢弃最早的事件并计数
+type topologySubscription struct {
+	listener TopologyEventListener
+	events   chan TopologyEvent
+	logger   *slog.Logger
+	dropped  int64
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// newTopologySubscription 创建监听器的事件队列并启动投递协程
+func newTopologySubscription(listener TopologyEventListener, size int, logger *slog.Logger) *topologySubscription {
+	if size <= 0 {
+		size = defaultListenerQueueSize
+	}
+	sub := &topologySubscription{
+		listener: listener,
+		events:   make(chan TopologyEvent, size),
+		logger:   logger,
+		stopCh:   make(chan struct{}),
+	}
+	go sub.run()
+	return sub
+}
+
+// run 按入队顺序投递事件，直到订阅被取消
+func (s *topologySubscription) run() {
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case event := <-s.events:
+			// 取消订阅与出队同时发生时，不再投递
+			select {
+			case <-s.stopCh:
+				return
+			default:
+			}
+			s.deliver(event)
+		}
+	}
+}
+
+// publish 把事件加入队列，队列满时丢弃最早的事件，订阅取消后的事件直接丢弃
+func (s *topologySubscription) publish(event TopologyEvent) {
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case s.events <- event:
+			return
+		default:
+		}
+		select {
+		case <-s.events:
+			atomic.AddInt64(&s.dropped, 1)
+		default:
+		}
+	}
+}
+
+// deliver 调用监听器，恢复并记录其中的panic，不影响后续事件的投递
+func (s *topologySubscription) deliver(event TopologyEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error("拓扑事件监听器panic", "listener", fmt.Sprintf("%T", s.listener),
+				"event", event.Type.String(), "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+	s.listener.OnTopologyEvent(event)
+}
+
+// stop 取消订阅，排队中的事件不再投递，正在执行的回调返回后投递协程退出
+func (s *topologySubscription) stop() {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+}
+
+// sameTopologyListener 比较两个监听器是否为同一个，类型不可比较的监听器视为不同，避免比较时panic
+func sameTopologyListener(a, b TopologyEventListener) bool {
+	ta := reflect.TypeOf(a)
+	if ta != reflect.TypeOf(b) || !ta.Comparable() {
+		return false
+	}
+	return a == b
+}
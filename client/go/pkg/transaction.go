@@ -9,6 +9,7 @@
 package concord
 
 import (
+	"context"
 	"errors"
 	"sync"
 )
@@ -64,6 +65,15 @@ func generateTxnID() string {
 
 // Get 在事务中获取键值
 func (t *Transaction) Get(key string) (string, error) {
+	return t.GetCtx(context.Background(), key)
+}
+
+// GetCtx 在事务中获取键值，ctx已结束时返回ctx.Err()且不记录操作
+func (t *Transaction) GetCtx(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
@@ -86,6 +96,15 @@ func (t *Transaction) Get(key string) (string, error) {
 
 // Set 在事务中设置键值
 func (t *Transaction) Set(key, value string) error {
+	return t.SetCtx(context.Background(), key, value)
+}
+
+// SetCtx 同Set，ctx已结束时返回ctx.Err()且不记录操作
+func (t *Transaction) SetCtx(ctx context.Context, key, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
@@ -108,6 +127,15 @@ func (t *Transaction) Set(key, value string) error {
 
 // Delete 在事务中删除键
 func (t *Transaction) Delete(key string) error {
+	return t.DeleteCtx(context.Background(), key)
+}
+
+// DeleteCtx 同Delete，ctx已结束时返回ctx.Err()且不记录操作
+func (t *Transaction) DeleteCtx(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
@@ -129,6 +157,15 @@ func (t *Transaction) Delete(key string) error {
 
 // Commit 提交事务
 func (t *Transaction) Commit() error {
+	return t.CommitCtx(context.Background())
+}
+
+// CommitCtx 提交事务，ctx已结束时返回ctx.Err()，事务保持未提交状态，可以重试或中止
+func (t *Transaction) CommitCtx(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
@@ -146,6 +183,11 @@ func (t *Transaction) Commit() error {
 
 // Abort 中止事务
 func (t *Transaction) Abort() error {
+	return t.AbortCtx(context.Background())
+}
+
+// AbortCtx 中止事务；中止用于清理，ctx已结束时仍然执行
+func (t *Transaction) AbortCtx(ctx context.Context) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
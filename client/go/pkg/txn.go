@@ -0,0 +1,224 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-19 15:32:08
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-19 15:32:08
+* @Description: ConcordKV Go client multi-key transactions
+ */
+
+package concord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// TxnCompare 事务的比较条件，通过CompareVersion或CompareValue创建
+type TxnCompare struct {
+	Key     string  `json:"key"`
+	Target  string  `json:"target"`
+	Op      string  `json:"op"`
+	Version uint64  `json:"version,omitempty"`
+	Value   *string `json:"value,omitempty"`
+}
+
+// CompareVersion 比较键的版本，op为=、!=、<、>；不存在的键版本为0
+func CompareVersion(key, op string, version uint64) TxnCompare {
+	return TxnCompare{Key: key, Target: "version", Op: op, Version: version}
+}
+
+// CompareValue 比较键的值，op为=、!=、<、>；键不存在时条件不成立
+func CompareValue(key, op, value string) TxnCompare {
+	return TxnCompare{Key: key, Target: "value", Op: op, Value: &value}
+}
+
+// TxnOperation 事务分支中的操作，通过GetOp、PutOp与DeleteOp创建
+type TxnOperation struct {
+	Op         string `json:"op"`
+	Key        string `json:"key"`
+	Value      string `json:"value"`
+	TTLSeconds int64  `json:"ttlSeconds,omitempty"`
+}
+
+// GetOp 读取键
+func GetOp(key string) TxnOperation {
+	return TxnOperation{Op: "get", Key: key}
+}
+
+// PutOp 写入键
+func PutOp(key, value string) TxnOperation {
+	return TxnOperation{Op: "set", Key: key, Value: value}
+}
+
+// PutOpWithTTL 写入带过期时间的键
+func PutOpWithTTL(key, value string, ttl time.Duration) TxnOperation {
+	return TxnOperation{Op: "set", Key: key, Value: value, TTLSeconds: int64(ttl / time.Second)}
+}
+
+// DeleteOp 删除键
+func DeleteOp(key string) TxnOperation {
+	return TxnOperation{Op: "delete", Key: key}
+}
+
+// TxnOpResponse 所执行分支中单个操作的结果
+type TxnOpResponse struct {
+	// Type 操作类型: GET、SET、DELETE
+	Type string
+	Key  string
+	// Exists GET为键是否存在，DELETE为删除前键是否存在
+	Exists bool
+	// Value GET读取到的值
+	Value string
+	// Version GET读取到的版本或SET写入后的版本
+	Version uint64
+}
+
+// TxnResponse 事务的执行结果
+type TxnResponse struct {
+	// Succeeded 比较条件是否全部成立，成立时执行了Then中的操作，否则执行了Else中的操作
+	Succeeded bool
+	// Responses 所执行分支中各操作的结果，与操作的顺序一致
+	Responses []TxnOpResponse
+	// Index 事务所在的日志索引
+	Index uint64
+}
+
+// ErrTxnBuilder 事务构造顺序不正确，If、Then、Else必须按顺序各调用至多一次
+var ErrTxnBuilder = errors.New("事务构造顺序不正确")
+
+// Txn 多键事务，条件与两个分支作为一个日志条目提交并在服务端原子地求值与执行：
+//
+//	resp, err := client.Txn().
+//		If(CompareVersion("lock", "=", 0)).
+//		Then(PutOp("lock", "owner")).
+//		Else(GetOp("lock")).
+//		Commit(ctx)
+//
+// 事务中的键需路由到同一节点；值按原样读写，不处理ChunkedValues写入的分块值
+type Txn struct {
+	client  *Client
+	compare []TxnCompare
+	success []TxnOperation
+	failure []TxnOperation
+	stage   int // 0: 未调用 1: 已调用If 2: 已调用Then 3: 已调用Else
+	err     error
+}
+
+// Txn 创建多键事务
+func (c *Client) Txn() *Txn {
+	return &Txn{client: c}
+}
+
+// advance 推进构造阶段，顺序错误时记录错误，由Commit返回
+func (t *Txn) advance(stage int, name string) bool {
+	if t.err != nil {
+		return false
+	}
+	if t.stage >= stage {
+		t.err = fmt.Errorf("%w: %s不能在此时调用", ErrTxnBuilder, name)
+		return false
+	}
+	t.stage = stage
+	return true
+}
+
+// If 添加比较条件，所有条件成立时执行Then中的操作
+func (t *Txn) If(cmps ...TxnCompare) *Txn {
+	if t.advance(1, "If") {
+		t.compare = append(t.compare, cmps...)
+	}
+	return t
+}
+
+// Then 条件成立时执行的操作
+func (t *Txn) Then(ops ...TxnOperation) *Txn {
+	if t.advance(2, "Then") {
+		t.success = append(t.success, ops...)
+	}
+	return t
+}
+
+// Else 条件不成立时执行的操作
+func (t *Txn) Else(ops ...TxnOperation) *Txn {
+	if t.advance(3, "Else") {
+		t.failure = append(t.failure, ops...)
+	}
+	return t
+}
+
+// txnResult /api/txn的响应
+type txnResult struct {
+	Succeeded bool `json:"succeeded"`
+	Responses []struct {
+		Type    string          `json:"type"`
+		Key     string          `json:"key"`
+		Exists  bool            `json:"exists"`
+		Value   json.RawMessage `json:"value"`
+		Version uint64          `json:"version"`
+	} `json:"responses"`
+	Index uint64 `json:"index"`
+}
+
+// Commit 提交事务，ctx语义同GetCtx
+func (t *Txn) Commit(ctx context.Context) (*TxnResponse, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+
+	keys := make([]string, 0, len(t.compare)+len(t.success)+len(t.failure))
+	for _, cmp := range t.compare {
+		keys = append(keys, cmp.Key)
+	}
+	var written []string
+	for _, op := range append(append([]TxnOperation(nil), t.success...), t.failure...) {
+		keys = append(keys, op.Key)
+		if op.Op != "get" {
+			written = append(written, op.Key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: 事务不能为空", ErrInvalidArgument)
+	}
+	for _, key := range keys {
+		if key == "" {
+			return nil, ErrInvalidArgument
+		}
+	}
+
+	c := t.client
+	groups := c.routeKeys(ctx, uniqueKeys(keys), RoutingWritePrimary)
+	if len(groups) > 1 {
+		return nil, fmt.Errorf("%w: 事务中的键路由到 %d 个不同的节点", ErrInvalidArgument, len(groups))
+	}
+
+	req := struct {
+		Compare []TxnCompare   `json:"compare"`
+		Success []TxnOperation `json:"success"`
+		Failure []TxnOperation `json:"failure"`
+	}{t.compare, t.success, t.failure}
+
+	var resp txnResult
+	if err := c.doWriteTo(ctx, groups[0].route, http.MethodPost, "/api/txn", req, &resp); err != nil {
+		return nil, err
+	}
+
+	// 无论执行了哪个分支，缓存中被写入的键都可能已过时
+	if c.cache != nil {
+		for _, key := range written {
+			c.cache.Delete(key)
+		}
+	}
+
+	result := &TxnResponse{Succeeded: resp.Succeeded, Index: resp.Index, Responses: make([]TxnOpResponse, len(resp.Responses))}
+	for i, r := range resp.Responses {
+		result.Responses[i] = TxnOpResponse{Type: r.Type, Key: r.Key, Exists: r.Exists, Version: r.Version}
+		if r.Exists && len(r.Value) > 0 {
+			result.Responses[i].Value = (&response{Value: r.Value}).stringValue()
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,103 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-19 15:32:08
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-19 15:32:08
+* @Description: ConcordKV Go client multi-key transactions tests
+ */
+
+package concord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestTxnCommit 事务的条件与两个分支在一个/api/txn请求中发送，响应解析为各操作的结果
+func TestTxnCommit(t *testing.T) {
+	var received map[string][]map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/txn" || r.Method != http.MethodPost {
+			t.Errorf("期望POST /api/txn，实际 %s %s", r.Method, r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"succeeded": false,
+			"branch":    "failure",
+			"responses": []map[string]interface{}{
+				{"type": "GET", "key": "lock", "exists": true, "value": "other", "version": 7},
+				{"type": "GET", "key": "missing", "exists": false},
+			},
+			"index": 42,
+		})
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{Endpoints: []string{server.URL}, RetryCount: 1, DisableSession: true})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+
+	resp, err := client.Txn().
+		If(CompareVersion("lock", "=", 0), CompareValue("flag", "=", "")).
+		Then(PutOp("lock", "owner"), DeleteOp("stale")).
+		Else(GetOp("lock"), GetOp("missing")).
+		Commit(context.Background())
+	if err != nil {
+		t.Fatalf("提交事务失败: %v", err)
+	}
+
+	if len(received["compare"]) != 2 || len(received["success"]) != 2 || len(received["failure"]) != 2 {
+		t.Fatalf("请求体不正确: %v", received)
+	}
+	if value, ok := received["compare"][1]["value"]; !ok || value != "" {
+		t.Errorf("与空字符串比较时应保留value字段: %v", received["compare"][1])
+	}
+	if op := received["success"][0]; op["op"] != "set" || op["key"] != "lock" || op["value"] != "owner" {
+		t.Errorf("写入操作编码不正确: %v", op)
+	}
+
+	if resp.Succeeded || resp.Index != 42 || len(resp.Responses) != 2 {
+		t.Fatalf("响应解析不正确: %+v", resp)
+	}
+	if r := resp.Responses[0]; !r.Exists || r.Value != "other" || r.Version != 7 {
+		t.Errorf("GET结果不正确: %+v", r)
+	}
+	if r := resp.Responses[1]; r.Exists || r.Value != "" {
+		t.Errorf("不存在的键应返回Exists=false: %+v", r)
+	}
+}
+
+// TestTxnBuilderErrors 顺序错误与空事务在Commit时返回错误，不发送请求
+func TestTxnBuilderErrors(t *testing.T) {
+	addr, count := countingServer(t, http.StatusOK, map[string]interface{}{"success": true})
+	client, err := NewClient(Config{Endpoints: []string{addr}, RetryCount: 1, DisableSession: true})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	if _, err := client.Txn().Then(PutOp("k", "v")).If(CompareVersion("k", "=", 0)).Commit(ctx); !errors.Is(err, ErrTxnBuilder) {
+		t.Errorf("Then之后调用If应返回ErrTxnBuilder: %v", err)
+	}
+	if _, err := client.Txn().Else(GetOp("k")).Else(GetOp("k")).Commit(ctx); !errors.Is(err, ErrTxnBuilder) {
+		t.Errorf("重复调用Else应返回ErrTxnBuilder: %v", err)
+	}
+	if _, err := client.Txn().Commit(ctx); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("空事务应返回ErrInvalidArgument: %v", err)
+	}
+	if _, err := client.Txn().Then(GetOp("")).Commit(ctx); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("空键应返回ErrInvalidArgument: %v", err)
+	}
+	if *count != 0 {
+		t.Errorf("构造错误时不应发送请求，实际 %d 次", *count)
+	}
+}
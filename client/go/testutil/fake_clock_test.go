@@ -0,0 +1,113 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-8-1 11:02:44
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-8-1 11:02:44
+* @Description: ConcordKV Go client fake clock tests
+ */
+
+package testutil_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/concordkv/client/go/testutil"
+)
+
+var epoch = time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC)
+
+// TestFakeClockTimers 定时器在Advance到达到期时间时触发，停止的定时器不触发
+func TestFakeClockTimers(t *testing.T) {
+	clk := testutil.NewFakeClock(epoch)
+
+	fired := clk.NewTimer(time.Second)
+	stopped := clk.NewTimer(time.Second)
+	after := clk.After(3 * time.Second)
+	if !stopped.Stop() {
+		t.Fatal("未触发的定时器Stop应返回true")
+	}
+
+	clk.Advance(999 * time.Millisecond)
+	select {
+	case <-fired.C():
+		t.Fatal("定时器提前触发")
+	default:
+	}
+
+	clk.Advance(time.Millisecond)
+	if got := <-fired.C(); !got.Equal(epoch.Add(time.Second)) {
+		t.Errorf("触发时间 = %v", got)
+	}
+	select {
+	case <-stopped.C():
+		t.Fatal("已停止的定时器不应触发")
+	default:
+	}
+	if fired.Stop() {
+		t.Error("已触发的定时器Stop应返回false")
+	}
+
+	fired.Reset(time.Second)
+	clk.Advance(5 * time.Second)
+	if got := <-after; !got.Equal(epoch.Add(3 * time.Second)) {
+		t.Errorf("After的触发时间 = %v", got)
+	}
+	if got := <-fired.C(); !got.Equal(epoch.Add(2 * time.Second)) {
+		t.Errorf("重置后的触发时间 = %v", got)
+	}
+	if got := clk.Now(); !got.Equal(epoch.Add(6 * time.Second)) {
+		t.Errorf("Now = %v", got)
+	}
+}
+
+// TestFakeClockTicker 周期定时器的每次触发都交给接收方，Advance在接收方取走后才继续
+func TestFakeClockTicker(t *testing.T) {
+	clk := testutil.NewFakeClock(epoch)
+	ticker := clk.NewTicker(10 * time.Millisecond)
+
+	ticks := make(chan time.Time, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		received := 0
+		for tick := range ticker.C() {
+			ticks <- tick
+			if received++; received == 5 {
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	clk.Advance(35 * time.Millisecond)
+	for i := 1; i <= 3; i++ {
+		if got := <-ticks; !got.Equal(epoch.Add(time.Duration(i) * 10 * time.Millisecond)) {
+			t.Fatalf("第 %d 次触发时间 = %v", i, got)
+		}
+	}
+
+	// 接收方在第5次触发后停止，之后的Advance不会阻塞
+	clk.Advance(time.Second)
+	<-done
+	if len(ticks) != 2 {
+		t.Errorf("停止后不应再触发，收到 %d 次", len(ticks))
+	}
+}
+
+// TestFakeClockSleep Sleep在其他协程推进时钟后返回，BlockUntil等待定时器创建
+func TestFakeClockSleep(t *testing.T) {
+	clk := testutil.NewFakeClock(epoch)
+
+	woke := make(chan time.Time)
+	go func() {
+		clk.Sleep(time.Minute)
+		woke <- clk.Now()
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+	if got := <-woke; !got.Equal(epoch.Add(time.Minute)) {
+		t.Errorf("Sleep返回时的时间 = %v", got)
+	}
+}
@@ -0,0 +1,110 @@
+#!/usr/bin/env python3
+# ConcordKV键哈希测试向量生成脚本
+# 独立于Go实现的XXH64与SHA-256前缀实现，生成test_vectors.json；
+# 服务端(raftserver/keyhash)与客户端(client/go/keyhash)的测试读取该文件校验各自的实现
+
+import hashlib
+import json
+import os
+import struct
+
+MASK = (1 << 64) - 1
+P1 = 11400714785074694791
+P2 = 14029467366897019727
+P3 = 1609587929392839161
+P4 = 9650029242287828579
+P5 = 2870177450012600261
+
+# XXH64官方实现给出的参考值，用于确认本脚本的实现正确
+REFERENCE = {
+    b"": 0xEF46DB3751D8E999,
+    b"a": 0xD24EC4F1A98C6E5B,
+    b"as": 0x1C330FB2D66BE179,
+    b"asd": 0x631C37CE72A97393,
+    b"asdf": 0x415872F599CEA71E,
+    b"Call me Ishmael. Some years ago--never mind how long precisely-": 0x02A2E85470D6FD96,
+}
+
+KEYS = [
+    "", "a", "abc", "user:1", "user:00000042", "order/2025/07/29/0001", "key-0", "key-1",
+    "a" * 7, "b" * 8, "c" * 31, "d" * 32, "e" * 33, "f" * 64,
+    "The quick brown fox jumps over the lazy dog",
+    "用户:张三", "键-分片-0", "emoji-🔑", "tab\tand\nnewline",
+    "Call me Ishmael. Some years ago--never mind how long precisely-",
+]
+
+
+def rotl(x, r):
+    return ((x << r) | (x >> (64 - r))) & MASK
+
+
+def xxh_round(acc, value):
+    return rotl((acc + value * P2) & MASK, 31) * P1 & MASK
+
+
+def xxh_merge_round(acc, value):
+    return ((acc ^ xxh_round(0, value)) * P1 + P4) & MASK
+
+
+def xxhash64(data, seed=0):
+    n, p = len(data), 0
+    if n >= 32:
+        v = [(seed + P1 + P2) & MASK, (seed + P2) & MASK, seed, (seed - P1) & MASK]
+        while n - p >= 32:
+            for j in range(4):
+                v[j] = xxh_round(v[j], struct.unpack_from("<Q", data, p + 8 * j)[0])
+            p += 32
+        h = (rotl(v[0], 1) + rotl(v[1], 7) + rotl(v[2], 12) + rotl(v[3], 18)) & MASK
+        for x in v:
+            h = xxh_merge_round(h, x)
+    else:
+        h = (seed + P5) & MASK
+    h = (h + n) & MASK
+
+    while n - p >= 8:
+        h ^= xxh_round(0, struct.unpack_from("<Q", data, p)[0])
+        h = (rotl(h, 27) * P1 + P4) & MASK
+        p += 8
+    if n - p >= 4:
+        h ^= struct.unpack_from("<I", data, p)[0] * P1 & MASK
+        h = (rotl(h, 23) * P2 + P3) & MASK
+        p += 4
+    while p < n:
+        h ^= data[p] * P5 & MASK
+        h = rotl(h, 11) * P1 & MASK
+        p += 1
+
+    h ^= h >> 33
+    h = h * P2 & MASK
+    h ^= h >> 29
+    h = h * P3 & MASK
+    h ^= h >> 32
+    return h
+
+
+def sha256_prefix(data):
+    return int.from_bytes(hashlib.sha256(data).digest()[:8], "big")
+
+
+def vectors(fn):
+    return [{"key": key, "hash": "%016x" % fn(key.encode("utf-8"))} for key in KEYS]
+
+
+def main():
+    for data, want in REFERENCE.items():
+        assert xxhash64(data) == want, (data, hex(xxhash64(data)))
+
+    doc = {
+        "description": "ConcordKV键哈希测试向量：key按UTF-8编码，hash为64位哈希值的16位小写十六进制。每个实现都应对全部向量给出相同的结果",
+        "algorithms": [
+            {"algorithm": "xxhash64", "version": 1, "seed": 0, "vectors": vectors(xxhash64)},
+            {"algorithm": "sha256-prefix", "version": 1, "vectors": vectors(sha256_prefix)},
+        ],
+    }
+    path = os.path.join(os.path.dirname(os.path.abspath(__file__)), "test_vectors.json")
+    with open(path, "w", encoding="utf-8") as f:
+        f.write(json.dumps(doc, ensure_ascii=False, indent=2) + "\n")
+
+
+if __name__ == "__main__":
+    main()
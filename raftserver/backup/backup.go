@@ -0,0 +1,332 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-24 10:40:05
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-24 10:40:05
+* @Description: ConcordKV Raft consensus server - backup.go
+ */
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// 备份文件格式：第一行为魔数与格式版本，第二行为JSON头部，之后是头部中Size字节的负载；
+// 完整备份的负载为状态机快照，增量备份的负载为日志条目的JSON数组，Checksum为负载的SHA-256
+const (
+	// FormatVersion 当前备份格式版本
+	FormatVersion = 1
+
+	magic = "CONCORDKV-BACKUP"
+
+	// maxHeaderSize 头部一行的最大长度
+	maxHeaderSize = 64 << 10
+)
+
+// ErrChecksumMismatch 备份负载的校验和与头部记录的不一致
+var ErrChecksumMismatch = errors.New("备份校验和不匹配")
+
+// Kind 备份类型
+type Kind string
+
+const (
+	KindFull        Kind = "full"        // 已应用状态的完整快照
+	KindIncremental Kind = "incremental" // SinceIndex之后的日志条目
+)
+
+// Header 备份头部
+type Header struct {
+	Version    int           `json:"version"`
+	Kind       Kind          `json:"kind"`
+	NodeID     raft.NodeID   `json:"nodeId,omitempty"`     // 生成备份的节点
+	Index      raft.LogIndex `json:"index"`                // 备份包含的最后一个已应用日志索引
+	Term       raft.Term     `json:"term"`                 // Index处日志的任期
+	SinceIndex raft.LogIndex `json:"sinceIndex,omitempty"` // 增量备份的起点（不含）
+	CreatedAt  time.Time     `json:"createdAt"`
+	Size       int64         `json:"size"`     // 负载字节数
+	Checksum   string        `json:"checksum"` // 负载的SHA-256（十六进制）
+}
+
+// Backup 解析后的备份
+type Backup struct {
+	Header
+	Snapshot []byte          // 完整备份：状态机快照
+	Entries  []raft.LogEntry // 增量备份：(SinceIndex, Index]的日志条目
+}
+
+// NewFull 由节点在已应用索引处创建的快照生成完整备份
+func NewFull(nodeID raft.NodeID, snapshot *raft.Snapshot) *Backup {
+	return &Backup{
+		Header: Header{
+			Version:   FormatVersion,
+			Kind:      KindFull,
+			NodeID:    nodeID,
+			Index:     snapshot.LastIncludedIndex,
+			Term:      snapshot.LastIncludedTerm,
+			CreatedAt: time.Now(),
+		},
+		Snapshot: snapshot.Data,
+	}
+}
+
+// NewIncremental 生成since之后直到index的增量备份
+func NewIncremental(nodeID raft.NodeID, since raft.LogIndex, entries []raft.LogEntry, index raft.LogIndex, term raft.Term) *Backup {
+	return &Backup{
+		Header: Header{
+			Version:    FormatVersion,
+			Kind:       KindIncremental,
+			NodeID:     nodeID,
+			Index:      index,
+			Term:       term,
+			SinceIndex: since,
+			CreatedAt:  time.Now(),
+		},
+		Entries: entries,
+	}
+}
+
+// payload 序列化备份负载
+func (b *Backup) payload() ([]byte, error) {
+	switch b.Kind {
+	case KindFull:
+		return b.Snapshot, nil
+	case KindIncremental:
+		entries := b.Entries
+		if entries == nil {
+			entries = []raft.LogEntry{}
+		}
+		return json.Marshal(entries)
+	default:
+		return nil, fmt.Errorf("未知的备份类型 %q", b.Kind)
+	}
+}
+
+// WriteTo 按备份格式写出，头部中的Size与Checksum按负载重新计算
+func (b *Backup) WriteTo(w io.Writer) (int64, error) {
+	payload, err := b.payload()
+	if err != nil {
+		return 0, err
+	}
+
+	header := b.Header
+	header.Version = FormatVersion
+	header.Size = int64(len(payload))
+	header.Checksum = checksum(payload)
+	headerData, err := json.Marshal(header)
+	if err != nil {
+		return 0, fmt.Errorf("序列化备份头部失败: %w", err)
+	}
+
+	var written int64
+	for _, part := range [][]byte{[]byte(fmt.Sprintf("%s %d\n", magic, FormatVersion)), headerData, []byte("\n"), payload} {
+		n, err := w.Write(part)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Read 读取并校验备份
+func Read(r io.Reader) (*Backup, error) {
+	reader := bufio.NewReader(r)
+
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, fmt.Errorf("读取备份格式行失败: %w", err)
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 2 || fields[0] != magic {
+		return nil, fmt.Errorf("不是ConcordKV备份文件")
+	}
+	version, err := strconv.Atoi(fields[1])
+	if err != nil || version < 1 || version > FormatVersion {
+		return nil, fmt.Errorf("不支持的备份格式版本 %s", fields[1])
+	}
+
+	line, err = readLine(reader)
+	if err != nil {
+		return nil, fmt.Errorf("读取备份头部失败: %w", err)
+	}
+	var header Header
+	if err := json.Unmarshal([]byte(line), &header); err != nil {
+		return nil, fmt.Errorf("解析备份头部失败: %w", err)
+	}
+	if header.Size < 0 {
+		return nil, fmt.Errorf("备份负载大小 %d 无效", header.Size)
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(reader, header.Size))
+	if err != nil {
+		return nil, fmt.Errorf("读取备份负载失败: %w", err)
+	}
+	if int64(len(payload)) != header.Size {
+		return nil, fmt.Errorf("备份不完整: 负载应为 %d 字节，实际 %d 字节", header.Size, len(payload))
+	}
+	if checksum(payload) != header.Checksum {
+		return nil, ErrChecksumMismatch
+	}
+
+	b := &Backup{Header: header}
+	switch header.Kind {
+	case KindFull:
+		b.Snapshot = payload
+	case KindIncremental:
+		if err := json.Unmarshal(payload, &b.Entries); err != nil {
+			return nil, fmt.Errorf("解析增量备份的日志条目失败: %w", err)
+		}
+		if err := b.checkEntries(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("未知的备份类型 %q", header.Kind)
+	}
+	return b, nil
+}
+
+// ReadFile 读取并校验备份文件
+func ReadFile(path string) (*Backup, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	b, err := Read(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return b, nil
+}
+
+// WriteFile 把备份写入文件，先写临时文件再重命名，中断时不会留下不完整的备份
+func (b *Backup) WriteFile(path string) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := b.WriteTo(file); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Merge 把完整备份与依次衔接的增量备份合并为一个完整备份：在完整备份的快照上重放增量中的日志条目
+// 每个增量的SinceIndex必须等于前一个备份的Index；成员变更条目不影响状态机，重放时跳过
+func Merge(full *Backup, increments ...*Backup) (*Backup, error) {
+	if full.Kind != KindFull {
+		return nil, fmt.Errorf("合并的第一个备份必须是完整备份，实际为 %s", full.Kind)
+	}
+	if len(increments) == 0 {
+		return full, nil
+	}
+
+	machine := statemachine.NewKVStateMachine()
+	if err := machine.RestoreSnapshot(full.Snapshot); err != nil {
+		return nil, fmt.Errorf("恢复完整备份失败: %w", err)
+	}
+
+	index, term := full.Index, full.Term
+	for i, inc := range increments {
+		if inc.Kind != KindIncremental {
+			return nil, fmt.Errorf("第 %d 个增量备份的类型为 %s", i+1, inc.Kind)
+		}
+		if inc.SinceIndex != index {
+			return nil, fmt.Errorf("第 %d 个增量备份从索引 %d 开始，与前一个备份的索引 %d 不衔接", i+1, inc.SinceIndex, index)
+		}
+		for j := range inc.Entries {
+			entry := &inc.Entries[j]
+			if entry.Type == raft.EntryConfiguration {
+				continue
+			}
+			if err := machine.Apply(entry); err != nil {
+				return nil, fmt.Errorf("重放日志条目 %d 失败: %w", entry.Index, err)
+			}
+		}
+		index, term = inc.Index, inc.Term
+	}
+
+	data, err := machine.CreateSnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("序列化合并后的状态失败: %w", err)
+	}
+
+	merged := NewFull(full.NodeID, &raft.Snapshot{LastIncludedIndex: index, LastIncludedTerm: term, Data: data})
+	merged.CreatedAt = increments[len(increments)-1].CreatedAt
+	return merged, nil
+}
+
+// RaftSnapshot 把完整备份转换为以servers为成员的快照，用于BootstrapFromSnapshot
+func (b *Backup) RaftSnapshot(servers []raft.Server) (*raft.Snapshot, error) {
+	if b.Kind != KindFull {
+		return nil, fmt.Errorf("只能从完整备份恢复，实际为 %s 备份", b.Kind)
+	}
+	return &raft.Snapshot{
+		LastIncludedIndex: b.Index,
+		LastIncludedTerm:  b.Term,
+		Configuration:     raft.Configuration{Servers: servers},
+		Data:              b.Snapshot,
+	}, nil
+}
+
+// checkEntries 检查增量备份的日志条目连续地覆盖(SinceIndex, Index]
+func (b *Backup) checkEntries() error {
+	if raft.LogIndex(len(b.Entries)) != b.Index-b.SinceIndex {
+		return fmt.Errorf("增量备份应包含 %d 个日志条目，实际 %d 个", b.Index-b.SinceIndex, len(b.Entries))
+	}
+	for i, entry := range b.Entries {
+		if want := b.SinceIndex + raft.LogIndex(i) + 1; entry.Index != want {
+			return fmt.Errorf("增量备份的第 %d 个日志条目索引为 %d，应为 %d", i+1, entry.Index, want)
+		}
+	}
+	return nil
+}
+
+// readLine 读取一行（不含换行符），超过maxHeaderSize时返回错误
+func readLine(reader *bufio.Reader) (string, error) {
+	var line bytes.Buffer
+	for {
+		part, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line.Write(part)
+		if line.Len() > maxHeaderSize {
+			return "", fmt.Errorf("行长度超过 %d 字节", maxHeaderSize)
+		}
+		if !isPrefix {
+			return line.String(), nil
+		}
+	}
+}
+
+// checksum 负载的SHA-256（十六进制）
+func checksum(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
@@ -0,0 +1,123 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-24 10:40:05
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-24 10:40:05
+* @Description: ConcordKV Raft consensus server - backup_test.go
+ */
+package backup_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"raftserver/backup"
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// setEntry 构造SET命令的日志条目
+func setEntry(t *testing.T, index raft.LogIndex, key, value string) raft.LogEntry {
+	t.Helper()
+	data, err := json.Marshal(statemachine.Command{Type: "SET", Key: key, Value: value})
+	if err != nil {
+		t.Fatalf("序列化命令失败: %v", err)
+	}
+	return raft.LogEntry{Index: index, Term: 2, Timestamp: time.Now(), Type: raft.EntryNormal, Data: data}
+}
+
+// roundTrip 写出后重新读取备份
+func roundTrip(t *testing.T, b *backup.Backup) *backup.Backup {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatalf("写出备份失败: %v", err)
+	}
+	read, err := backup.Read(&buf)
+	if err != nil {
+		t.Fatalf("读取备份失败: %v", err)
+	}
+	return read
+}
+
+// TestBackupFormat 完整与增量备份写出后读取一致，负载被篡改或截断时读取失败
+func TestBackupFormat(t *testing.T) {
+	machine := statemachine.NewKVStateMachine()
+	first := setEntry(t, 1, "a", "1")
+	if err := machine.Apply(&first); err != nil {
+		t.Fatal(err)
+	}
+	data, err := machine.CreateSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	full := roundTrip(t, backup.NewFull("node1", &raft.Snapshot{LastIncludedIndex: 1, LastIncludedTerm: 2, Data: data}))
+	if full.Kind != backup.KindFull || full.Index != 1 || full.Term != 2 || full.NodeID != "node1" || !bytes.Equal(full.Snapshot, data) {
+		t.Fatalf("完整备份读取后不一致: %+v", full.Header)
+	}
+
+	entries := []raft.LogEntry{setEntry(t, 2, "b", "2"), setEntry(t, 3, "a", "3")}
+	inc := roundTrip(t, backup.NewIncremental("node1", 1, entries, 3, 2))
+	if inc.Kind != backup.KindIncremental || inc.SinceIndex != 1 || inc.Index != 3 || len(inc.Entries) != 2 {
+		t.Fatalf("增量备份读取后不一致: %+v", inc.Header)
+	}
+
+	var buf bytes.Buffer
+	if _, err := full.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	corrupted := append([]byte(nil), encoded...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	if _, err := backup.Read(bytes.NewReader(corrupted)); !errors.Is(err, backup.ErrChecksumMismatch) {
+		t.Errorf("负载被篡改时应返回ErrChecksumMismatch，实际: %v", err)
+	}
+	if _, err := backup.Read(bytes.NewReader(encoded[:len(encoded)-1])); err == nil {
+		t.Errorf("负载被截断时应读取失败")
+	}
+	if _, err := backup.Read(bytes.NewReader([]byte("CONCORDKV-BACKUP 99\n{}\n"))); err == nil {
+		t.Errorf("不支持的格式版本应读取失败")
+	}
+}
+
+// TestMergeIncrements 在完整备份上依次重放衔接的增量，增量不衔接时返回错误
+func TestMergeIncrements(t *testing.T) {
+	machine := statemachine.NewKVStateMachine()
+	first := setEntry(t, 1, "a", "1")
+	machine.Apply(&first)
+	data, _ := machine.CreateSnapshot()
+	full := backup.NewFull("node1", &raft.Snapshot{LastIncludedIndex: 1, LastIncludedTerm: 1, Data: data})
+
+	inc1 := backup.NewIncremental("node1", 1, []raft.LogEntry{
+		setEntry(t, 2, "b", "2"),
+		{Index: 3, Term: 2, Type: raft.EntryConfiguration},
+	}, 3, 2)
+	inc2 := backup.NewIncremental("node1", 3, []raft.LogEntry{setEntry(t, 4, "a", "4")}, 4, 2)
+
+	if _, err := backup.Merge(full, inc2); err == nil {
+		t.Fatalf("增量不衔接时应合并失败")
+	}
+
+	merged, err := backup.Merge(full, inc1, inc2)
+	if err != nil {
+		t.Fatalf("合并备份失败: %v", err)
+	}
+	if merged.Kind != backup.KindFull || merged.Index != 4 || merged.Term != 2 {
+		t.Fatalf("合并后的头部 = %+v", merged.Header)
+	}
+
+	restored := statemachine.NewKVStateMachine()
+	if err := restored.RestoreSnapshot(merged.Snapshot); err != nil {
+		t.Fatalf("恢复合并后的快照失败: %v", err)
+	}
+	for key, want := range map[string]string{"a": "4", "b": "2"} {
+		if got, ok := restored.Get(key); !ok || got != want {
+			t.Errorf("键 %s = %v, 期望 %s", key, got, want)
+		}
+	}
+}
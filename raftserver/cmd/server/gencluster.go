@@ -0,0 +1,203 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-22 09:40:12
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-22 09:40:12
+* @Description: ConcordKV Raft consensus server - gencluster.go
+ */
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// 生成的本地集群使用较短的超时，选举超时为心跳间隔的10倍
+	genElectionTimeoutMs   = 1000
+	genHeartbeatIntervalMs = 100
+	genHost                = "127.0.0.1"
+)
+
+// dcSpec 数据中心及其节点数
+type dcSpec struct {
+	Name  string
+	Nodes int
+}
+
+// clusterNodeFile 生成的节点配置文件，字段顺序即文件中的顺序
+type clusterNodeFile struct {
+	Server clusterNodeConfig `yaml:"server"`
+}
+
+type clusterNodeConfig struct {
+	NodeID            string          `yaml:"nodeId"`
+	ListenAddr        string          `yaml:"listenAddr"`
+	APIAddr           string          `yaml:"apiAddr"`
+	DataDir           string          `yaml:"dataDir"`
+	ElectionTimeout   int             `yaml:"electionTimeout"`
+	HeartbeatInterval int             `yaml:"heartbeatInterval"`
+	DataCenter        string          `yaml:"dataCenter"`
+	Peers             []string        `yaml:"peers"`
+	PeerAPIAddrs      []string        `yaml:"peerApiAddrs"`
+	PeerDataCenters   []string        `yaml:"peerDataCenters,omitempty"`
+	MultiDC           *clusterMultiDC `yaml:"multiDC,omitempty"`
+}
+
+type clusterMultiDC struct {
+	Enabled      bool   `yaml:"enabled"`
+	CommitPolicy string `yaml:"commitPolicy"`
+}
+
+// generatedFile 生成的配置文件名与内容
+type generatedFile struct {
+	Name string
+	Data []byte
+}
+
+// parseDCSpec 解析数据中心划分，格式：dc1:3,dc2:2
+func parseDCSpec(spec string) ([]dcSpec, error) {
+	var result []dcSpec
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("dcs条目 %q 格式错误，应为 dc:节点数", item)
+		}
+		name := strings.TrimSpace(parts[0])
+		count, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || count <= 0 {
+			return nil, fmt.Errorf("dcs条目 %q 的节点数无效", item)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("dcs参数中数据中心重复: %s", name)
+		}
+		seen[name] = true
+		result = append(result, dcSpec{Name: name, Nodes: count})
+	}
+	return result, nil
+}
+
+// generateClusterConfigs 生成nodes个节点的配置文件：节点按dcs的顺序依次分配到各数据中心，
+// 第i个节点的Raft端口为basePort+2(i-1)，API端口为其后一个。所有文件的peers、peerApiAddrs、
+// peerDataCenters相同，多个数据中心时启用multiDC
+func generateClusterConfigs(nodes, basePort int, dcs []dcSpec) ([]generatedFile, error) {
+	if len(dcs) == 0 {
+		dcs = []dcSpec{{Name: "dc1", Nodes: nodes}}
+	}
+	total := 0
+	for _, dc := range dcs {
+		total += dc.Nodes
+	}
+	if nodes == 0 {
+		nodes = total
+	}
+	if nodes <= 0 {
+		return nil, fmt.Errorf("节点数必须大于0")
+	}
+	if total != nodes {
+		return nil, fmt.Errorf("dcs中的节点数之和 %d 与 -gen-cluster %d 不一致", total, nodes)
+	}
+	if basePort <= 0 || basePort+2*nodes-1 > 65535 {
+		return nil, fmt.Errorf("起始端口 %d 无效，%d个节点需要 %d 个连续端口", basePort, nodes, 2*nodes)
+	}
+
+	ids := make([]string, 0, nodes)
+	nodeDC := make([]string, 0, nodes)
+	var peers, peerAPIs, peerDCs []string
+	for _, dc := range dcs {
+		for j := 0; j < dc.Nodes; j++ {
+			i := len(ids)
+			id := fmt.Sprintf("node%d", i+1)
+			ids = append(ids, id)
+			nodeDC = append(nodeDC, dc.Name)
+			peers = append(peers, fmt.Sprintf("%s=%s:%d", id, genHost, basePort+2*i))
+			peerAPIs = append(peerAPIs, fmt.Sprintf("%s=%s:%d", id, genHost, basePort+2*i+1))
+			peerDCs = append(peerDCs, fmt.Sprintf("%s=%s", id, dc.Name))
+		}
+	}
+
+	var multiDC *clusterMultiDC
+	if len(dcs) > 1 {
+		multiDC = &clusterMultiDC{Enabled: true, CommitPolicy: "majority"}
+	} else {
+		peerDCs = nil
+	}
+
+	files := make([]generatedFile, 0, nodes)
+	for i, id := range ids {
+		file := clusterNodeFile{Server: clusterNodeConfig{
+			NodeID:            id,
+			ListenAddr:        fmt.Sprintf("%s:%d", genHost, basePort+2*i),
+			APIAddr:           fmt.Sprintf("%s:%d", genHost, basePort+2*i+1),
+			DataDir:           filepath.ToSlash(filepath.Join("data", id)),
+			ElectionTimeout:   genElectionTimeoutMs,
+			HeartbeatInterval: genHeartbeatIntervalMs,
+			DataCenter:        nodeDC[i],
+			Peers:             peers,
+			PeerAPIAddrs:      peerAPIs,
+			PeerDataCenters:   peerDCs,
+			MultiDC:           multiDC,
+		}}
+
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "# ConcordKV 本地集群节点配置，由 -gen-cluster 生成，各节点的成员列表需保持一致\n")
+		fmt.Fprintf(&buf, "# 启动: raftserver -config %s.yaml\n", id)
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(file); err != nil {
+			return nil, fmt.Errorf("生成 %s 的配置失败: %w", id, err)
+		}
+		encoder.Close()
+		files = append(files, generatedFile{Name: id + ".yaml", Data: buf.Bytes()})
+	}
+	return files, nil
+}
+
+// runGenCluster 按-gen-cluster、-base-port、-dcs在-out目录中写入各节点的配置文件，不覆盖已有文件
+// 退出码：0 成功，2 参数无效或写入失败
+func runGenCluster() int {
+	var specs []dcSpec
+	if *dcs != "" {
+		parsed, err := parseDCSpec(*dcs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+		specs = parsed
+	}
+
+	files, err := generateClusterConfigs(*genCluster, *basePort, specs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "生成集群配置失败: %v\n", err)
+		return 2
+	}
+
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "创建目录失败: %v\n", err)
+		return 2
+	}
+	for _, file := range files {
+		path := filepath.Join(*outDir, file.Name)
+		if _, err := os.Stat(path); err == nil {
+			fmt.Fprintf(os.Stderr, "%s 已存在，请删除后重试或使用 -out 指定其他目录\n", path)
+			return 2
+		}
+	}
+	for _, file := range files {
+		path := filepath.Join(*outDir, file.Name)
+		if err := os.WriteFile(path, file.Data, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "写入 %s 失败: %v\n", path, err)
+			return 2
+		}
+		fmt.Println(path)
+	}
+	return 0
+}
@@ -0,0 +1,76 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-22 09:40:12
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-22 09:40:12
+* @Description: ConcordKV Raft consensus server - gencluster_test.go
+ */
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"raftserver/raft"
+	"raftserver/server"
+)
+
+// TestGenClusterConfigsLoadConsistently 生成的各节点配置通过校验，成员列表一致，节点按-dcs分配到数据中心
+func TestGenClusterConfigsLoadConsistently(t *testing.T) {
+	specs, err := parseDCSpec("dc1:3,dc2:2")
+	if err != nil {
+		t.Fatalf("解析dcs失败: %v", err)
+	}
+	files, err := generateClusterConfigs(5, 8000, specs)
+	if err != nil {
+		t.Fatalf("生成集群配置失败: %v", err)
+	}
+	if len(files) != 5 {
+		t.Fatalf("期望5个配置文件，实际 %d", len(files))
+	}
+
+	dir := t.TempDir()
+	configs := make([]*server.ServerConfig, 0, len(files))
+	for _, file := range files {
+		path := filepath.Join(dir, file.Name)
+		if err := os.WriteFile(path, file.Data, 0644); err != nil {
+			t.Fatalf("写入配置文件失败: %v", err)
+		}
+		config, err := server.LoadServerConfig(path)
+		if err != nil {
+			t.Fatalf("生成的配置未通过校验: %v", err)
+		}
+		configs = append(configs, config)
+	}
+
+	expectedDC := []raft.DataCenterID{"dc1", "dc1", "dc1", "dc2", "dc2"}
+	for i, config := range configs {
+		if !reflect.DeepEqual(config.Peers, configs[0].Peers) || !reflect.DeepEqual(config.PeerAPIAddrs, configs[0].PeerAPIAddrs) ||
+			!reflect.DeepEqual(config.PeerDataCenters, configs[0].PeerDataCenters) {
+			t.Errorf("%s 的成员信息与node1不一致", config.NodeID)
+		}
+		if config.DataCenter != expectedDC[i] || config.MultiDCConfig == nil || !config.MultiDCConfig.Enabled {
+			t.Errorf("%s 的数据中心配置不符: dc=%s multiDC=%+v", config.NodeID, config.DataCenter, config.MultiDCConfig)
+		}
+		if config.Peers[config.NodeID] != config.ListenAddr || config.PeerAPIAddrs[config.NodeID] != config.APIAddr {
+			t.Errorf("%s 的监听地址与成员列表不一致", config.NodeID)
+		}
+	}
+	if configs[4].ListenAddr != "127.0.0.1:8008" || configs[4].APIAddr != "127.0.0.1:8009" {
+		t.Errorf("端口分配不符: %s %s", configs[4].ListenAddr, configs[4].APIAddr)
+	}
+
+	for _, spec := range []string{"dc1", "dc1:0", "dc1:2,dc1:1", ":3"} {
+		if _, err := parseDCSpec(spec); err == nil {
+			t.Errorf("期望解析 %q 失败", spec)
+		}
+	}
+	if _, err := generateClusterConfigs(4, 8000, specs); err == nil {
+		t.Error("节点数与dcs之和不一致时应失败")
+	}
+	if _, err := generateClusterConfigs(3, 65534, nil); err == nil {
+		t.Error("端口超出范围时应失败")
+	}
+}
@@ -11,23 +11,50 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
 	"raftserver/raft"
 	"raftserver/server"
+	"raftserver/storage"
+	"raftserver/transport"
 )
 
 var (
-	configPath = flag.String("config", "config/server.yaml", "配置文件路径")
-	nodeID     = flag.String("node", "", "节点ID")
-	listenAddr = flag.String("listen", "", "监听地址")
-	apiAddr    = flag.String("api", "", "API服务器地址")
-	peers      = flag.String("peers", "", "集群节点列表，用逗号分隔")
-	help       = flag.Bool("help", false, "显示帮助信息")
+	configPath    = flag.String("config", "config/server.yaml", "配置文件路径")
+	nodeID        = flag.String("node", "", "节点ID")
+	listenAddr    = flag.String("listen", "", "监听地址")
+	apiAddr       = flag.String("api", "", "API服务器地址")
+	peers         = flag.String("peers", "", "集群节点列表，格式：node1=host:port,node2=host:port")
+	peerAPIs      = flag.String("peer-apis", "", "集群节点API地址列表，格式：node1=host:port,node2=host:port")
+	join          = flag.Bool("join", false, "以非投票成员身份加入已有集群，等待领导者通过/api/cluster/add添加本节点")
+	leaseRead     = flag.Bool("lease-read", false, "启用基于租约的线性一致读（依赖节点间时钟漂移有界）")
+	preVote       = flag.Bool("pre-vote", true, "启用预投票，避免分区恢复的节点打断稳定的领导者")
+	forwardWrites = flag.Bool("forward-writes", false, "跟随者把客户端写请求经Raft传输层转发给领导者，而不是重定向客户端")
+	dataDir       = flag.String("data-dir", "", "数据目录，指定后任期、投票与日志持久化到磁盘")
+	storageKind   = flag.String("storage", "", "存储后端：memory、wal、file（指定-data-dir时默认 wal，否则默认 memory）")
+	allowVolatile = flag.Bool("allow-volatile", false, "允许使用内存存储，重启后任期、投票与日志全部丢失，仅用于测试")
+	syncPolicy    = flag.String("sync-policy", "", "WAL刷盘策略：always、interval、group（默认 always）")
+	transportKind = flag.String("transport", "", "节点间传输层：http、grpc（默认 http），集群内所有节点必须一致")
+	tlsCert       = flag.String("tls-cert", "", "节点证书文件，CN或SAN需包含节点ID")
+	tlsKey        = flag.String("tls-key", "", "节点私钥文件")
+	tlsCA         = flag.String("tls-ca", "", "CA证书文件，用于校验节点与客户端证书")
+	tlsClientAuth = flag.String("tls-client-auth", "", "API服务器的客户端证书校验模式：none、request、require（默认 none）")
+	apiToken      = flag.String("api-token", "", "API访问令牌，指定后请求需携带 Authorization: Bearer <token>")
+	sessionTTL    = flag.Duration("session-timeout", 0, "客户端会话的空闲超时（默认 1m）")
+	drainTimeout  = flag.Duration("drain-timeout", 0, "SIGTERM或/api/admin/drain触发排空到停止的最长时间（默认 30s）")
+	verifyStorage = flag.Bool("verify-storage", false, "只读地检查数据目录中日志的完整性后退出，不启动节点")
+	restoreFile   = flag.String("restore", "", "从备份文件初始化空的数据目录，以单节点集群启动")
+	genCluster    = flag.Int("gen-cluster", 0, "生成N个节点的本地集群配置文件后退出，不启动节点")
+	basePort      = flag.Int("base-port", 8000, "-gen-cluster的起始端口，每个节点依次占用Raft与API两个端口")
+	dcs           = flag.String("dcs", "", "-gen-cluster的数据中心划分，格式：dc1:3,dc2:2（默认全部节点属于dc1）")
+	outDir        = flag.String("out", ".", "-gen-cluster生成配置文件的目录")
+	help          = flag.Bool("help", false, "显示帮助信息")
 )
 
 func main() {
@@ -38,19 +65,18 @@ func main() {
 		os.Exit(0)
 	}
 
-	log.Printf("启动ConcordKV Raft服务器...")
-
-	var srv *server.Server
-	var err error
+	if *verifyStorage {
+		os.Exit(runVerifyStorage())
+	}
 
-	// 如果提供了命令行参数，使用参数创建服务器
-	if *nodeID != "" || *listenAddr != "" || *apiAddr != "" || *peers != "" {
-		srv, err = createServerFromFlags()
-	} else {
-		// 否则从配置文件创建服务器
-		srv, err = server.NewServer(*configPath)
+	if isFlagSet("gen-cluster") {
+		os.Exit(runGenCluster())
 	}
 
+	log.Printf("启动ConcordKV Raft服务器...")
+
+	// 命令行参数总是覆盖配置文件中的对应项，未指定-node时以配置文件为基础
+	srv, err := createServerFromFlags()
 	if err != nil {
 		log.Fatalf("创建服务器失败: %v", err)
 	}
@@ -62,53 +88,292 @@ func main() {
 
 	// 设置信号处理
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	log.Printf("服务器已启动，按 Ctrl+C 停止")
+	log.Printf("服务器已启动，按 Ctrl+C 停止，发送 SIGHUP 重新加载TLS证书")
 
-	// 等待信号
-	<-sigChan
+	// 等待信号，SIGHUP时重新加载证书后继续运行；SIGTERM先排空再停止，SIGINT直接停止
+	// 通过/api/admin/drain排空时，服务器停止后进程随之退出
+wait:
+	for {
+		select {
+		case sig := <-sigChan:
+			switch sig {
+			case syscall.SIGHUP:
+				if err := srv.ReloadTLS(); err != nil {
+					log.Printf("重新加载TLS证书失败: %v", err)
+				}
+			case syscall.SIGTERM:
+				log.Printf("收到SIGTERM，排空后关闭服务器...")
+				// 与/api/admin/drain使用同一个排空时限，-drain-timeout已覆盖配置文件中的drainTimeout
+				if err := srv.Shutdown(0); err != nil {
+					log.Printf("停止服务器失败: %v", err)
+				}
+				break wait
+			default:
+				log.Printf("收到停止信号，正在关闭服务器...")
+				if err := srv.Stop(); err != nil {
+					log.Printf("停止服务器失败: %v", err)
+				}
+				break wait
+			}
+		case <-srv.Drained():
+			break wait
+		}
+	}
 
-	log.Printf("收到停止信号，正在关闭服务器...")
+	log.Printf("服务器已关闭")
+}
 
-	// 停止服务器
-	if err := srv.Stop(); err != nil {
-		log.Printf("停止服务器失败: %v", err)
+// runVerifyStorage 检查-data-dir或配置文件中数据目录的完整性并打印报告
+// 退出码：0 可以启动（日志完整，或只有启动时会被截断的尾部），1 存在无法自动恢复的损坏，2 检查失败
+func runVerifyStorage() int {
+	dir := *dataDir
+	if dir == "" {
+		config, err := server.LoadServerConfig(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "加载配置失败: %v\n", err)
+			return 2
+		}
+		dir = config.DataDir
+	}
+	if dir == "" {
+		fmt.Fprintf(os.Stderr, "未指定数据目录，请使用 -data-dir 或在配置文件中设置 dataDir\n")
+		return 2
 	}
 
-	log.Printf("服务器已关闭")
+	report, err := storage.Verify(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "检查数据目录失败: %v\n", err)
+		return 2
+	}
+	fmt.Print(report)
+	if !report.Startable() {
+		return 1
+	}
+	return 0
 }
 
 // createServerFromFlags 从命令行参数创建服务器
 func createServerFromFlags() (*server.Server, error) {
-	if *nodeID == "" {
-		return nil, fmt.Errorf("必须指定节点ID")
+	config, err := buildConfigFromFlags()
+	if err != nil {
+		return nil, err
 	}
 
-	listenAddr := getOrDefault(*listenAddr, ":8080")
+	return server.NewServerWithConfig(config)
+}
+
+// buildConfigFromFlags 根据命令行参数构建服务器配置
+// 显式指定了-config或未指定-node时以配置文件为基础，命令行参数覆盖文件中的对应项；-config为空时只使用命令行参数
+func buildConfigFromFlags() (*server.ServerConfig, error) {
+	var config *server.ServerConfig
 
-	config := &server.ServerConfig{
-		NodeID:            raft.NodeID(*nodeID),
-		ListenAddr:        listenAddr,
-		APIAddr:           getOrDefault(*apiAddr, "127.0.0.1:8081"),
-		ElectionTimeout:   5 * time.Second,
-		HeartbeatInterval: 1 * time.Second,
-		MaxLogEntries:     100,
-		SnapshotThreshold: 1000,
-		Peers:             make(map[raft.NodeID]string),
+	fromFile := *configPath != "" && (isFlagSet("config") || *nodeID == "")
+	if fromFile {
+		fileConfig, err := server.LoadServerConfig(*configPath)
+		if err != nil {
+			return nil, err
+		}
+		config = fileConfig
+	} else {
+		if *nodeID == "" {
+			return nil, fmt.Errorf("必须指定节点ID")
+		}
+
+		config = &server.ServerConfig{
+			NodeID:             raft.NodeID(*nodeID),
+			ListenAddr:         ":8080",
+			APIAddr:            "127.0.0.1:8081",
+			ElectionTimeout:    5 * time.Second,
+			HeartbeatInterval:  1 * time.Second,
+			MaxLogEntries:      100,
+			MaxInflightBatches: raft.DefaultMaxInflightBatches,
+			SnapshotThreshold:  1000,
+			EnablePreVote:      true,
+			Peers:              make(map[raft.NodeID]string),
+		}
 	}
 
-	// 在单节点模式下，将自己添加到peers列表
-	config.Peers[raft.NodeID(*nodeID)] = listenAddr
+	if *nodeID != "" {
+		config.NodeID = raft.NodeID(*nodeID)
+	}
+	if *apiAddr != "" {
+		config.APIAddr = *apiAddr
+	}
 
-	// 解析peers参数
+	// 解析peers参数，格式：node1=host:port,node2=host:port
 	if *peers != "" {
-		// 简化的peers解析，格式：node1:addr1,node2:addr2
-		// 实际实现中应该更严格
-		log.Printf("警告：peers参数解析功能尚未完全实现，请使用配置文件")
+		parsed, err := parsePeers(*peers, config.NodeID)
+		if err != nil {
+			return nil, err
+		}
+		config.Peers = parsed
+
+		// 未指定监听地址时使用peers中本节点的地址
+		if *listenAddr == "" {
+			config.ListenAddr = parsed[config.NodeID]
+		}
 	}
 
-	return server.NewServerWithConfig(config)
+	if *listenAddr != "" {
+		config.ListenAddr = *listenAddr
+	}
+
+	if *join {
+		config.Join = true
+	}
+	if *leaseRead {
+		config.EnableLeaseRead = true
+	}
+	if isFlagSet("pre-vote") {
+		config.EnablePreVote = *preVote
+	}
+	if *forwardWrites {
+		config.ForwardWrites = true
+	}
+	if *dataDir != "" {
+		config.DataDir = *dataDir
+	}
+	if *storageKind != "" {
+		backend, err := storage.ParseBackend(*storageKind)
+		if err != nil {
+			return nil, err
+		}
+		config.Storage = backend
+	}
+	if *allowVolatile {
+		config.AllowVolatile = true
+	}
+	if *restoreFile != "" {
+		config.RestoreFrom = *restoreFile
+	}
+	if *syncPolicy != "" {
+		policy, err := storage.ParseSyncPolicy(*syncPolicy)
+		if err != nil {
+			return nil, err
+		}
+		config.SyncPolicy = policy
+	}
+	if *transportKind != "" {
+		kind, err := transport.ParseKind(*transportKind)
+		if err != nil {
+			return nil, err
+		}
+		config.Transport = kind
+	}
+	if *tlsCert != "" {
+		config.TLS.CertFile = *tlsCert
+	}
+	if *tlsKey != "" {
+		config.TLS.KeyFile = *tlsKey
+	}
+	if *tlsCA != "" {
+		config.TLS.CAFile = *tlsCA
+	}
+	if *tlsClientAuth != "" {
+		mode, err := transport.ParseClientAuthMode(*tlsClientAuth)
+		if err != nil {
+			return nil, err
+		}
+		config.TLS.ClientAuth = mode
+	}
+	if *apiToken != "" {
+		config.APIToken = *apiToken
+	}
+	if *sessionTTL > 0 {
+		config.SessionTimeout = *sessionTTL
+	}
+	if *drainTimeout > 0 {
+		config.DrainTimeout = *drainTimeout
+	}
+
+	// 解析节点API地址，用于将请求重定向到领导者
+	if *peerAPIs != "" {
+		parsed, err := parseNodeAddrs(*peerAPIs, "peer-apis")
+		if err != nil {
+			return nil, err
+		}
+		config.PeerAPIAddrs = parsed
+	}
+
+	if len(config.Peers) == 0 {
+		// 在单节点模式下，将自己添加到peers列表
+		config.Peers = map[raft.NodeID]string{config.NodeID: config.ListenAddr}
+	} else if addr, ok := config.Peers[config.NodeID]; ok && *listenAddr != "" && addr != config.ListenAddr {
+		log.Printf("警告：peers中本节点地址 %s 与监听地址 %s 不一致", addr, config.ListenAddr)
+	}
+
+	// 与配置文件经过相同的一致性检查
+	if err := config.Validate(); err != nil {
+		if fromFile {
+			return nil, fmt.Errorf("命令行参数与配置文件 %s: %w", *configPath, err)
+		}
+		return nil, fmt.Errorf("命令行参数: %w", err)
+	}
+
+	return config, nil
+}
+
+// parsePeers 解析集群节点列表，格式：node1=host:port,node2=host:port
+// 为避免与地址中的端口分隔符混淆，不支持node1:host:port形式
+func parsePeers(spec string, localID raft.NodeID) (map[raft.NodeID]string, error) {
+	result, err := parseNodeAddrs(spec, "peers")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := result[localID]; !ok {
+		return nil, fmt.Errorf("peers参数中未包含本节点 %s", localID)
+	}
+
+	return result, nil
+}
+
+// parseNodeAddrs 解析 nodeID=host:port 形式的逗号分隔列表，name用于错误信息
+func parseNodeAddrs(spec, name string) (map[raft.NodeID]string, error) {
+	result := make(map[raft.NodeID]string)
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return nil, fmt.Errorf("%s参数包含空条目: %q", name, spec)
+		}
+
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s条目 %q 格式错误，应为 nodeID=host:port", name, item)
+		}
+
+		id := strings.TrimSpace(parts[0])
+		addr := strings.TrimSpace(parts[1])
+		if id == "" {
+			return nil, fmt.Errorf("%s条目 %q 缺少节点ID", name, item)
+		}
+
+		if _, port, err := net.SplitHostPort(addr); err != nil || port == "" {
+			return nil, fmt.Errorf("%s条目 %q 的地址无效，应为 host:port", name, item)
+		}
+
+		if _, exists := result[raft.NodeID(id)]; exists {
+			return nil, fmt.Errorf("%s参数中节点ID重复: %s", name, id)
+		}
+
+		result[raft.NodeID(id)] = addr
+	}
+
+	return result, nil
+}
+
+// isFlagSet 判断命令行参数是否被显式设置
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
 
 // getOrDefault 获取值或默认值
@@ -134,20 +399,88 @@ func printUsage() {
 	fmt.Printf("  -api string\n")
 	fmt.Printf("        API服务器地址\n")
 	fmt.Printf("  -peers string\n")
-	fmt.Printf("        集群节点列表，用逗号分隔\n")
+	fmt.Printf("        集群节点列表，格式：node1=host:port,node2=host:port\n")
+	fmt.Printf("        与-config同时使用时覆盖配置文件中的节点列表\n")
+	fmt.Printf("  -peer-apis string\n")
+	fmt.Printf("        集群节点API地址列表，用于将写请求重定向到领导者\n")
+	fmt.Printf("  -pre-vote\n")
+	fmt.Printf("        启用预投票 (默认 true)，使用 -pre-vote=false 关闭\n")
+	fmt.Printf("  -forward-writes\n")
+	fmt.Printf("        跟随者把客户端写请求经Raft传输层转发给领导者，等待提交后响应，而不是重定向客户端\n")
+	fmt.Printf("  -data-dir string\n")
+	fmt.Printf("        数据目录，指定后任期、投票与日志持久化到磁盘，重启后可恢复\n")
+	fmt.Printf("  -verify-storage\n")
+	fmt.Printf("        只读地检查数据目录（-data-dir或配置文件中的dataDir）中日志的完整性后退出，不启动节点\n")
+	fmt.Printf("        退出码：0 可以启动，1 存在无法自动恢复的损坏（需从快照或其他副本恢复），2 检查失败\n")
+	fmt.Printf("  -restore string\n")
+	fmt.Printf("        从备份文件（GET /api/backup导出，可用backup工具合并增量）初始化空的数据目录，以单节点集群启动\n")
+	fmt.Printf("        数据目录中已有数据时忽略；恢复后通过成员变更加入其他节点\n")
+	fmt.Printf("  -gen-cluster int\n")
+	fmt.Printf("        生成N个节点的本地集群配置文件（node1.yaml ... nodeN.yaml）后退出，各文件的peers等成员信息一致\n")
+	fmt.Printf("  -base-port int\n")
+	fmt.Printf("        -gen-cluster的起始端口，第i个节点的Raft端口为 base-port+2(i-1)，API端口为其后一个 (默认 8000)\n")
+	fmt.Printf("  -dcs string\n")
+	fmt.Printf("        -gen-cluster的数据中心划分，格式：dc1:3,dc2:2，节点依次分配，多个数据中心时启用multiDC\n")
+	fmt.Printf("  -out string\n")
+	fmt.Printf("        -gen-cluster生成配置文件的目录 (默认 \".\")\n")
+	fmt.Printf("  -storage string\n")
+	fmt.Printf("        存储后端：memory（仅用于测试）、wal（单文件WAL）、file（分段日志文件，自动导入已有的WAL数据）\n")
+	fmt.Printf("  -allow-volatile\n")
+	fmt.Printf("        允许使用内存存储启动；未指定时缺少-data-dir或使用memory存储将拒绝启动\n")
+	fmt.Printf("  -sync-policy string\n")
+	fmt.Printf("        WAL刷盘策略：always（每次追加fsync）、interval（定期fsync）、group（合并并发追加后fsync）\n")
+	fmt.Printf("  -transport string\n")
+	fmt.Printf("        节点间传输层：http（HTTP+JSON）或 grpc（gRPC+Protobuf），集群内所有节点必须一致\n")
+	fmt.Printf("  -tls-cert string / -tls-key string / -tls-ca string\n")
+	fmt.Printf("        节点证书、私钥与CA，指定后节点间通信双向校验证书，API服务器启用HTTPS\n")
+	fmt.Printf("        节点证书的CN或SAN需包含节点ID；发送 SIGHUP 重新加载证书\n")
+	fmt.Printf("  -tls-client-auth string\n")
+	fmt.Printf("        API服务器的客户端证书校验模式：none、request（提供时校验）、require（必须提供）\n")
+	fmt.Printf("  -api-token string\n")
+	fmt.Printf("        API访问令牌，指定后请求需携带 Authorization: Bearer <token>\n")
+	fmt.Printf("  -session-timeout duration\n")
+	fmt.Printf("        客户端会话的空闲超时，超时的会话在所有副本上清理（默认 1m）\n")
+	fmt.Printf("  -drain-timeout duration\n")
+	fmt.Printf("        SIGTERM或/api/admin/drain触发排空到停止的最长时间（默认 30s）\n")
 	fmt.Printf("  -help\n")
 	fmt.Printf("        显示帮助信息\n\n")
 	fmt.Printf("示例:\n")
 	fmt.Printf("  # 使用配置文件启动\n")
 	fmt.Printf("  %s -config config/node1.yaml\n\n", filepath.Base(os.Args[0]))
 	fmt.Printf("  # 使用命令行参数启动\n")
-	fmt.Printf("  %s -node node1 -listen :8080 -api :8081\n\n", filepath.Base(os.Args[0]))
+	fmt.Printf("  %s -node node1 -listen :8080 -api :8081 -data-dir data/node1 -storage file\n\n", filepath.Base(os.Args[0]))
+	fmt.Printf("  # 启动三节点集群中的一个节点\n")
+	fmt.Printf("  %s -node node1 -api :8081 -data-dir data/node1 -peers node1=127.0.0.1:8080,node2=127.0.0.1:9080,node3=127.0.0.1:10080\n\n", filepath.Base(os.Args[0]))
+	fmt.Printf("  # 生成两个数据中心共5个节点的本地集群配置，再分别以 -config nodeN.yaml 启动\n")
+	fmt.Printf("  %s -gen-cluster 5 -base-port 8000 -dcs dc1:3,dc2:2 -out cluster\n\n", filepath.Base(os.Args[0]))
+	fmt.Printf("  # 从备份恢复为单节点集群\n")
+	fmt.Printf("  %s -node node1 -listen :8080 -api :8081 -data-dir data/restored -restore full.backup\n\n", filepath.Base(os.Args[0]))
+	fmt.Printf("  # 以新节点身份加入已有集群，随后向领导者发送 POST /api/cluster/add\n")
+	fmt.Printf("  %s -node node4 -api :11081 -join -data-dir data/node4 -peers node1=127.0.0.1:8080,node2=127.0.0.1:9080,node3=127.0.0.1:10080,node4=127.0.0.1:11080\n\n", filepath.Base(os.Args[0]))
 	fmt.Printf("API 端点:\n")
-	fmt.Printf("  GET  /api/get?key=<key>     - 获取键值\n")
-	fmt.Printf("  POST /api/set               - 设置键值\n")
+	fmt.Printf("  GET  /api/get?key=<key>     - 获取键值（consistency=linearizable走ReadIndex，consistency=stale读本地）\n")
+	fmt.Printf("  POST /api/set               - 设置键值（跟随者返回307重定向，?forward=true时转发到领导者）\n")
 	fmt.Printf("  DEL  /api/delete?key=<key>  - 删除键值\n")
+	fmt.Printf("  POST /api/delete-range      - 按前缀或[start,end)分批删除键\n")
 	fmt.Printf("  GET  /api/keys              - 获取所有键\n")
-	fmt.Printf("  GET  /api/status            - 获取节点状态\n")
-	fmt.Printf("  GET  /api/metrics           - 获取详细指标\n")
+	fmt.Printf("  POST /api/batch             - 批量写入/删除\n")
+	fmt.Printf("  GET  /api/scan?prefix=<p>   - 按前缀分页扫描键\n")
+	fmt.Printf("  GET  /api/count?prefix=<p>  - 统计前缀下的键数\n")
+	fmt.Printf("  POST /api/cas               - 比较并交换\n")
+	fmt.Printf("  POST /api/txn               - 多键事务\n")
+	fmt.Printf("  GET  /api/watch?prefix=<p>&fromIndex=<i> - 以SSE流推送键的变更事件（put/delete/resync）\n")
+	fmt.Printf("  POST /api/session           - 注册客户端会话，写请求携带 X-Concord-Session 与 X-Concord-Seq 后重试不会重复执行\n")
+	fmt.Printf("  POST /api/session/keepalive - 刷新会话，避免空闲超时\n")
+	fmt.Printf("  DEL  /api/session           - 关闭会话\n")
+	fmt.Printf("  POST /api/transfer-leader?target=<node> - 将领导权转移给指定节点\n")
+	fmt.Printf("  POST /api/admin/drain       - 排空节点：拒绝新请求(503)，等待进行中的请求并转移领导权后停止\n")
+	fmt.Printf("  POST /api/cluster/add       - 添加服务器（新节点需以-join启动，learner为true时以学习者身份加入）\n")
+	fmt.Printf("  POST /api/cluster/promote   - 将追上日志的学习者提升为投票成员\n")
+	fmt.Printf("  POST /api/cluster/remove    - 移除服务器\n")
+	fmt.Printf("  GET  /api/status            - 获取节点状态（role为voter或learner，排空期间draining为true）\n")
+	fmt.Printf("  GET  /api/health            - 就绪检查：追上启动时的提交索引且未排空时返回200，否则返回503\n")
+	fmt.Printf("  GET  /api/metrics           - 获取详细指标（含各跟随者复制进度，?format=prometheus输出Prometheus格式）\n")
 	fmt.Printf("  GET  /api/logs              - 获取调试日志\n")
+	fmt.Printf("  GET  /api/backup            - 导出已应用状态的一致快照（?sinceIndex=<i>导出之后的日志作为增量，已压缩时返回410）\n")
+	fmt.Printf("  GET  /api/events?since=<seq>&type=<t> - 获取节点事件日志（状态/领导者/快照/成员变更，?follow=true以SSE流推送）\n")
 }
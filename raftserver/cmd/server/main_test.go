@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/server"
+	"raftserver/transport"
+)
+
+func TestParsePeersSingleNode(t *testing.T) {
+	peers, err := parsePeers("node1=127.0.0.1:8080", "node1")
+	if err != nil {
+		t.Fatalf("解析单节点失败: %v", err)
+	}
+
+	if len(peers) != 1 || peers["node1"] != "127.0.0.1:8080" {
+		t.Errorf("单节点解析结果错误: %v", peers)
+	}
+}
+
+func TestParsePeersFiveNodes(t *testing.T) {
+	items := make([]string, 0, 5)
+	for i := 1; i <= 5; i++ {
+		items = append(items, fmt.Sprintf("node%d=10.0.0.%d:%d", i, i, 8080+i))
+	}
+
+	peers, err := parsePeers(strings.Join(items, ", "), "node3")
+	if err != nil {
+		t.Fatalf("解析五节点失败: %v", err)
+	}
+
+	if len(peers) != 5 {
+		t.Fatalf("期望5个节点，实际: %d", len(peers))
+	}
+
+	for i := 1; i <= 5; i++ {
+		id := raft.NodeID(fmt.Sprintf("node%d", i))
+		expected := fmt.Sprintf("10.0.0.%d:%d", i, 8080+i)
+		if peers[id] != expected {
+			t.Errorf("节点 %s 地址错误，期望: %s, 实际: %s", id, expected, peers[id])
+		}
+	}
+}
+
+func TestParsePeersMalformed(t *testing.T) {
+	cases := map[string]string{
+		"colon form":      "node1:127.0.0.1:8080",
+		"missing id":      "=127.0.0.1:8080",
+		"missing port":    "node1=127.0.0.1",
+		"empty entry":     "node1=127.0.0.1:8080,,node2=127.0.0.1:8081",
+		"trailing comma":  "node1=127.0.0.1:8080,",
+		"duplicate id":    "node1=127.0.0.1:8080,node1=127.0.0.1:8081",
+		"local not found": "node2=127.0.0.1:8081,node3=127.0.0.1:8082",
+	}
+
+	for name, spec := range cases {
+		if _, err := parsePeers(spec, "node1"); err == nil {
+			t.Errorf("%s: 期望解析 %q 失败", name, spec)
+		}
+	}
+}
+
+// TestBuildConfigFromFlagsValidates 命令行参数构建的配置与配置文件经过相同的一致性检查
+func TestBuildConfigFromFlagsValidates(t *testing.T) {
+	defer flag.Set("node", "")
+	defer flag.Set("peers", "")
+	flag.Set("node", "node1")
+	flag.Set("peers", "node1=127.0.0.1:8080,node2=127.0.0.1:8080")
+
+	_, err := buildConfigFromFlags()
+	var configErr *server.ConfigError
+	if !errors.As(err, &configErr) || configErr.Field != "server.peers" {
+		t.Fatalf("期望server.peers的配置错误，实际 %v", err)
+	}
+	if !strings.Contains(err.Error(), "命令行参数") {
+		t.Errorf("错误应指出来自命令行参数，实际 %q", err.Error())
+	}
+
+	flag.Set("peers", "node1=127.0.0.1:8080,node2=127.0.0.1:8082")
+	if _, err := buildConfigFromFlags(); err != nil {
+		t.Fatalf("有效的命令行参数被拒绝: %v", err)
+	}
+}
+
+// freeAddr 返回一个当前空闲的本地地址
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("分配端口失败: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// writeServerConfig 写入单节点内存存储的配置文件，返回文件路径与API地址
+func writeServerConfig(t *testing.T) (string, string) {
+	t.Helper()
+	listen, api := freeAddr(t), freeAddr(t)
+	content := fmt.Sprintf(`server:
+  nodeId: node1
+  listenAddr: "%s"
+  apiAddr: "%s"
+  electionTimeout: 150
+  heartbeatInterval: 30
+  storage: memory
+  allowVolatile: true
+  peers:
+    - "node1=%s"
+`, listen, api, listen)
+	path := filepath.Join(t.TempDir(), "node1.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("写入配置文件失败: %v", err)
+	}
+	return path, api
+}
+
+// setFlags 设置命令行参数，测试结束时恢复默认值
+// flag包无法撤销参数“已设置”的状态，-config恢复为空，之后的测试仍只使用命令行参数
+func setFlags(t *testing.T, values map[string]string) {
+	t.Helper()
+	for name, value := range values {
+		name := name
+		reset := flag.Lookup(name).DefValue
+		if name == "config" {
+			reset = ""
+		}
+		t.Cleanup(func() { flag.Set(name, reset) })
+		if err := flag.Set(name, value); err != nil {
+			t.Fatalf("设置参数 -%s 失败: %v", name, err)
+		}
+	}
+}
+
+// TestConfigFileWithAPIToken 同时指定-config与-api-token时，节点以配置文件启动且API要求令牌
+func TestConfigFileWithAPIToken(t *testing.T) {
+	path, api := writeServerConfig(t)
+	setFlags(t, map[string]string{"config": path, "api-token": "s3cret"})
+
+	srv, err := createServerFromFlags()
+	if err != nil {
+		t.Fatalf("创建服务器失败: %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("启动服务器失败: %v", err)
+	}
+	defer srv.Stop()
+
+	get := func(token string) int {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+api+"/api/get?key=a", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			resp, err := http.DefaultClient.Do(req)
+			if err == nil {
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("请求API失败: %v", err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if code := get(""); code != http.StatusUnauthorized {
+		t.Fatalf("未携带令牌的请求应返回401，实际 %d", code)
+	}
+	if code := get("s3cret"); code == http.StatusUnauthorized {
+		t.Fatal("携带正确令牌的请求被拒绝")
+	}
+}
+
+// TestConfigFileFlagOverrides 同时指定-config时，命令行参数覆盖配置文件中的对应项，其余配置取自文件
+func TestConfigFileFlagOverrides(t *testing.T) {
+	cases := []struct {
+		name  string
+		flags map[string]string
+		check func(*server.ServerConfig) bool
+	}{
+		{"transport", map[string]string{"transport": "grpc"}, func(c *server.ServerConfig) bool {
+			return c.Transport == transport.KindGRPC
+		}},
+		{"tls", map[string]string{"tls-cert": "node1.crt", "tls-key": "node1.key", "tls-ca": "ca.crt", "tls-client-auth": "require"}, func(c *server.ServerConfig) bool {
+			return c.TLS == transport.TLSConfig{CertFile: "node1.crt", KeyFile: "node1.key", CAFile: "ca.crt", ClientAuth: transport.ClientAuthRequire}
+		}},
+		{"session-timeout", map[string]string{"session-timeout": "90s"}, func(c *server.ServerConfig) bool {
+			return c.SessionTimeout == 90*time.Second
+		}},
+		{"drain-timeout", map[string]string{"drain-timeout": "45s"}, func(c *server.ServerConfig) bool {
+			return c.DrainTimeout == 45*time.Second
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path, api := writeServerConfig(t)
+			flags := map[string]string{"config": path}
+			for name, value := range tc.flags {
+				flags[name] = value
+			}
+			setFlags(t, flags)
+
+			config, err := buildConfigFromFlags()
+			if err != nil {
+				t.Fatalf("构建配置失败: %v", err)
+			}
+			if config.NodeID != "node1" || config.APIAddr != api {
+				t.Fatalf("未以配置文件为基础: %+v", config)
+			}
+			if !tc.check(config) {
+				t.Fatalf("参数 %v 未覆盖配置文件: %+v", tc.flags, config)
+			}
+		})
+	}
+}
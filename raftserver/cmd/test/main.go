@@ -42,6 +42,27 @@ func main() {
 	log.Printf("测试完成!")
 }
 
+// apiError 服务器统一的错误响应体
+type apiError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RaftIndex uint64 `json:"raftIndex"`
+}
+
+// responseError 把非200响应转换为错误，响应体为统一错误格式时返回其错误码与描述
+func responseError(op string, resp *http.Response) (*apiError, error) {
+	body, _ := io.ReadAll(resp.Body)
+
+	var envelope struct {
+		Error *apiError `json:"error"`
+	}
+	if json.Unmarshal(body, &envelope) != nil || envelope.Error == nil {
+		return nil, fmt.Errorf("%s请求失败，状态码: %d, 响应: %s", op, resp.StatusCode, string(body))
+	}
+	return envelope.Error, fmt.Errorf("%s请求失败，状态码: %d, 错误码: %s, 描述: %s, 日志索引: %d",
+		op, resp.StatusCode, envelope.Error.Code, envelope.Error.Message, envelope.Error.RaftIndex)
+}
+
 // checkStatus 检查服务器状态
 func checkStatus(serverAddr string) error {
 	resp, err := http.Get(serverAddr + "/api/status")
@@ -50,6 +71,11 @@ func checkStatus(serverAddr string) error {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		_, err := responseError("状态", resp)
+		return err
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return fmt.Errorf("读取响应失败: %w", err)
@@ -135,6 +161,39 @@ func testKVOperations(serverAddr string) error {
 
 	log.Printf("DELETE操作成功")
 
+	// 测试BATCH操作
+	log.Printf("测试BATCH操作...")
+	batchOps := []map[string]interface{}{
+		{"op": "set", "key": "batch_key1", "value": "batch_value1"},
+		{"op": "set", "key": "batch_key2", "value": 2},
+		{"op": "delete", "key": "key2"},
+	}
+	if err := testBatch(serverAddr, batchOps); err != nil {
+		return fmt.Errorf("BATCH操作失败: %w", err)
+	}
+
+	// 验证批量写入成功
+	time.Sleep(time.Millisecond * 100)
+	value, exists, err = testGet(serverAddr, "batch_key1")
+	if err != nil {
+		return fmt.Errorf("验证批量写入失败: %w", err)
+	}
+
+	if !exists || value != "batch_value1" {
+		return fmt.Errorf("批量写入失败，期望: batch_value1, 实际: %v", value)
+	}
+
+	_, exists, err = testGet(serverAddr, "key2")
+	if err != nil {
+		return fmt.Errorf("验证批量删除失败: %w", err)
+	}
+
+	if exists {
+		return fmt.Errorf("批量删除失败，键仍然存在")
+	}
+
+	log.Printf("BATCH操作成功")
+
 	return nil
 }
 
@@ -157,8 +216,8 @@ func testSet(serverAddr, key string, value interface{}) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("SET请求失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
+		_, err := responseError("SET", resp)
+		return err
 	}
 
 	var result map[string]interface{}
@@ -182,8 +241,11 @@ func testGet(serverAddr, key string) (interface{}, bool, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, false, fmt.Errorf("GET请求失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
+		apiErr, err := responseError("GET", resp)
+		if apiErr != nil && apiErr.Code == "KEY_NOT_FOUND" {
+			return nil, false, nil
+		}
+		return nil, false, err
 	}
 
 	var result map[string]interface{}
@@ -191,16 +253,11 @@ func testGet(serverAddr, key string) (interface{}, bool, error) {
 		return nil, false, fmt.Errorf("解析GET响应失败: %w", err)
 	}
 
-	exists, ok := result["exists"].(bool)
-	if !ok {
+	if exists, ok := result["exists"].(bool); !ok || !exists {
 		return nil, false, fmt.Errorf("无效的响应格式")
 	}
 
-	if exists {
-		return result["value"], true, nil
-	}
-
-	return nil, false, nil
+	return result["value"], true, nil
 }
 
 // testDelete 测试DELETE操作
@@ -218,8 +275,8 @@ func testDelete(serverAddr, key string) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("DELETE请求失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
+		_, err := responseError("DELETE", resp)
+		return err
 	}
 
 	var result map[string]interface{}
@@ -234,6 +291,37 @@ func testDelete(serverAddr, key string) error {
 	return nil
 }
 
+// testBatch 测试BATCH操作
+func testBatch(serverAddr string, ops []map[string]interface{}) error {
+	reqJSON, err := json.Marshal(ops)
+	if err != nil {
+		return fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	resp, err := http.Post(serverAddr+"/api/batch", "application/json", bytes.NewBuffer(reqJSON))
+	if err != nil {
+		return fmt.Errorf("发送BATCH请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		_, err := responseError("BATCH", resp)
+		return err
+	}
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("解析BATCH响应失败: %w", err)
+	}
+
+	if success, ok := result["success"].(bool); !ok || !success {
+		return fmt.Errorf("BATCH操作失败: %+v", result)
+	}
+
+	log.Printf("BATCH操作提交于日志索引: %v", result["index"])
+	return nil
+}
+
 // testKeys 测试获取所有键
 func testKeys(serverAddr string) ([]interface{}, error) {
 	resp, err := http.Get(serverAddr + "/api/keys")
@@ -243,8 +331,8 @@ func testKeys(serverAddr string) ([]interface{}, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("KEYS请求失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
+		_, err := responseError("KEYS", resp)
+		return nil, err
 	}
 
 	var result map[string]interface{}
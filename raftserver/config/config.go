@@ -325,6 +325,34 @@ func (c *Config) Exists(path string) bool {
 	return ok
 }
 
+// Section 获取指定路径的配置段，路径为空时返回根配置段；路径不存在或不是配置段时返回false
+func (c *Config) Section(path string) (map[string]interface{}, bool) {
+	if path == "" {
+		c.mutex.RLock()
+		defer c.mutex.RUnlock()
+		return c.data, true
+	}
+
+	val, ok := c.get(path)
+	if !ok {
+		return nil, false
+	}
+
+	switch v := val.(type) {
+	case map[string]interface{}:
+		return v, true
+	case map[interface{}]interface{}:
+		section := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			if ks, ok := k.(string); ok {
+				section[ks] = item
+			}
+		}
+		return section, true
+	}
+	return nil, false
+}
+
 // Watch 监听配置变更
 func (c *Config) Watch(path string, callback WatchCallback) {
 	c.mutex.Lock()
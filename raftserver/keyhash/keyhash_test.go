@@ -0,0 +1,86 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-29 14:40:13
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-29 14:40:13
+* @Description: ConcordKV Raft consensus server - keyhash_test.go
+ */
+package keyhash_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+
+	"raftserver/keyhash"
+)
+
+// vectorFile 与客户端及其他语言的实现共用的测试向量
+const vectorFile = "../../common/keyhash/test_vectors.json"
+
+// TestVectors 每个支持的算法对全部测试向量给出相同的哈希值
+func TestVectors(t *testing.T) {
+	data, err := os.ReadFile(vectorFile)
+	if err != nil {
+		t.Fatalf("读取测试向量失败: %v", err)
+	}
+	var doc struct {
+		Algorithms []struct {
+			keyhash.Algorithm
+			Vectors []struct {
+				Key  string `json:"key"`
+				Hash string `json:"hash"`
+			} `json:"vectors"`
+		} `json:"algorithms"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("解析测试向量失败: %v", err)
+	}
+
+	covered := make(map[keyhash.Algorithm]bool)
+	for _, set := range doc.Algorithms {
+		if !set.IsSupported() {
+			t.Fatalf("测试向量中的算法 %s 不受支持", set.Algorithm)
+		}
+		covered[set.Algorithm] = true
+		for _, vector := range set.Vectors {
+			want, err := strconv.ParseUint(vector.Hash, 16, 64)
+			if err != nil {
+				t.Fatalf("%s 的哈希值 %q 无效", set.Algorithm, vector.Hash)
+			}
+			if got := set.Sum64(vector.Key); got != want {
+				t.Errorf("%s(%q) = %016x，期望 %016x", set.Algorithm, vector.Key, got, want)
+			}
+		}
+	}
+	for _, algorithm := range keyhash.Supported() {
+		if !covered[algorithm] {
+			t.Errorf("算法 %s 没有测试向量", algorithm)
+		}
+	}
+}
+
+// TestParse 只有名称时取最新版本，未知的名称或版本返回ErrUnsupported
+func TestParse(t *testing.T) {
+	for input, want := range map[string]keyhash.Algorithm{
+		"xxhash64":        keyhash.XXHash64,
+		"xxhash64/v1":     keyhash.XXHash64,
+		"sha256-prefix/1": keyhash.SHA256Prefix,
+		" sha256-prefix ": keyhash.SHA256Prefix,
+	} {
+		got, err := keyhash.Parse(input)
+		if err != nil || got != want {
+			t.Errorf("Parse(%q) = %v, %v，期望 %v", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", "md5", "xxhash64/v2"} {
+		if _, err := keyhash.Parse(input); !errors.Is(err, keyhash.ErrUnsupported) {
+			t.Errorf("Parse(%q) 应返回ErrUnsupported，实际 %v", input, err)
+		}
+	}
+	if _, err := keyhash.Parse("xxhash64/vx"); err == nil {
+		t.Error("版本无效时应返回错误")
+	}
+}
@@ -0,0 +1,61 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-26 11:02:37
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-26 11:02:37
+* @Description: ConcordKV Raft consensus server - kverrors.go
+ */
+
+// Package kverrors 定义各层共用的错误值
+// raft、状态机、复制路由器与API处理器返回的错误都包装这些值，调用方用errors.Is/errors.As判断类别
+package kverrors
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNoHealthyNodes 候选节点都不可用
+	ErrNoHealthyNodes = errors.New("没有可用的健康节点")
+	// ErrNotLeader 节点不是领导者，不能处理该请求
+	ErrNotLeader = errors.New("不是领导者")
+	// ErrShardNotFound 分片不存在
+	ErrShardNotFound = errors.New("分片不存在")
+	// ErrTimeout 请求在截止时间之前没有完成
+	ErrTimeout = errors.New("请求超时")
+	// ErrConflict 当前状态与请求的期望不符，例如CAS的期望值或会话请求序号
+	ErrConflict = errors.New("当前值与期望不符")
+)
+
+// NotLeaderError 节点不是领导者，知道领导者时一并返回
+type NotLeaderError struct {
+	LeaderID   string // 领导者节点ID，未知时为空
+	LeaderAddr string // 领导者的API地址，未知时为空
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.LeaderID == "" {
+		return ErrNotLeader.Error()
+	}
+	return fmt.Sprintf("%s，当前领导者: %s", ErrNotLeader.Error(), e.LeaderID)
+}
+
+// Is 使errors.Is(err, ErrNotLeader)成立
+func (e *NotLeaderError) Is(target error) bool {
+	return target == ErrNotLeader
+}
+
+// ShardNotFoundError 没有覆盖该键的分片
+type ShardNotFoundError struct {
+	Key string
+}
+
+func (e *ShardNotFoundError) Error() string {
+	return fmt.Sprintf("键 %s 所在的%s", e.Key, ErrShardNotFound.Error())
+}
+
+// Is 使errors.Is(err, ErrShardNotFound)成立
+func (e *ShardNotFoundError) Is(target error) bool {
+	return target == ErrShardNotFound
+}
@@ -0,0 +1,40 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-26 11:02:37
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-26 11:02:37
+* @Description: ConcordKV Raft consensus server - kverrors_test.go
+ */
+package kverrors_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"raftserver/kverrors"
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// TestTypedErrorsMatchSentinels 包装后的类型化错误仍可用errors.Is/errors.As判断
+func TestTypedErrorsMatchSentinels(t *testing.T) {
+	err := fmt.Errorf("提交失败: %w", &kverrors.NotLeaderError{LeaderID: "node2", LeaderAddr: "127.0.0.1:8082"})
+	var notLeader *kverrors.NotLeaderError
+	if !errors.Is(err, kverrors.ErrNotLeader) || !errors.Is(err, raft.ErrNotLeader) || !errors.As(err, &notLeader) {
+		t.Fatalf("NotLeaderError应匹配ErrNotLeader: %v", err)
+	}
+	if notLeader.LeaderID != "node2" || notLeader.LeaderAddr != "127.0.0.1:8082" {
+		t.Errorf("NotLeaderError = %+v", notLeader)
+	}
+
+	err = fmt.Errorf("路由失败: %w", &kverrors.ShardNotFoundError{Key: "user:1"})
+	var shardErr *kverrors.ShardNotFoundError
+	if !errors.Is(err, kverrors.ErrShardNotFound) || !errors.Is(err, statemachine.ErrShardNotFound) || !errors.As(err, &shardErr) || shardErr.Key != "user:1" {
+		t.Fatalf("ShardNotFoundError应匹配ErrShardNotFound: %v", err)
+	}
+
+	if errors.Is(err, kverrors.ErrNotLeader) || errors.Is(kverrors.ErrTimeout, kverrors.ErrConflict) {
+		t.Errorf("不同类别的错误不应互相匹配")
+	}
+}
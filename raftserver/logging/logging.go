@@ -0,0 +1,235 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-11 16:42:08
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-11 16:42:08
+* @Description: ConcordKV structured logging - logging.go
+ */
+
+// Package logging 分级、结构化的日志接口，默认实现基于log/slog
+// 各组件通过With附加component、node_id、dc等字段，JSON格式下可按字段关联多个节点的日志
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// 各组件统一使用的字段名
+const (
+	FieldComponent = "component"
+	FieldNodeID    = "node_id"
+	FieldDC        = "dc"
+	FieldTerm      = "term"
+	FieldError     = "error"
+)
+
+// Logger 分级日志接口，keyvals依次为字段名和字段值
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+
+	// With 返回附加了字段的Logger
+	With(keyvals ...interface{}) Logger
+}
+
+// Level 日志级别
+type Level int
+
+const (
+	LevelDebug Level = Level(slog.LevelDebug)
+	LevelInfo  Level = Level(slog.LevelInfo)
+	LevelWarn  Level = Level(slog.LevelWarn)
+	LevelError Level = Level(slog.LevelError)
+)
+
+func (l Level) String() string {
+	return strings.ToLower(slog.Level(l).String())
+}
+
+// ParseLevel 解析日志级别（debug、info、warn、error），空字符串为info
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("未知的日志级别: %s", s)
+	}
+}
+
+// Format 日志输出格式
+type Format string
+
+const (
+	FormatText Format = "text" // key=value文本
+	FormatJSON Format = "json" // 每行一个JSON对象
+)
+
+// ParseFormat 解析日志格式，空字符串为text
+func ParseFormat(s string) (Format, error) {
+	switch Format(strings.ToLower(strings.TrimSpace(s))) {
+	case "", FormatText:
+		return FormatText, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	default:
+		return FormatText, fmt.Errorf("未知的日志格式: %s", s)
+	}
+}
+
+// Options 创建Logger的选项
+type Options struct {
+	Level  Level
+	Format Format
+	// Output 日志输出，为nil时写到标准库log包当前的输出（log.SetOutput对其生效）
+	Output io.Writer
+}
+
+// New 按选项创建基于slog的Logger
+func New(opts Options) Logger {
+	output := opts.Output
+	if output == nil {
+		output = stdLogWriter{}
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: slog.Level(opts.Level)}
+	var handler slog.Handler
+	if opts.Format == FormatJSON {
+		handler = slog.NewJSONHandler(output, handlerOpts)
+	} else {
+		handler = slog.NewTextHandler(output, handlerOpts)
+	}
+	return NewSlog(slog.New(handler))
+}
+
+// NewSlog 把slog.Logger适配为Logger
+func NewSlog(logger *slog.Logger) Logger {
+	return &slogLogger{logger: logger}
+}
+
+// slogLogger 基于slog的Logger实现
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l *slogLogger) Debug(msg string, keyvals ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelDebug, msg, keyvals...)
+}
+
+func (l *slogLogger) Info(msg string, keyvals ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelInfo, msg, keyvals...)
+}
+
+func (l *slogLogger) Warn(msg string, keyvals ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelWarn, msg, keyvals...)
+}
+
+func (l *slogLogger) Error(msg string, keyvals ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelError, msg, keyvals...)
+}
+
+func (l *slogLogger) With(keyvals ...interface{}) Logger {
+	return &slogLogger{logger: l.logger.With(keyvals...)}
+}
+
+// stdLogWriter 写到标准库log包当前的输出，测试中log.SetOutput(io.Discard)可静默各组件的日志
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) {
+	return log.Writer().Write(p)
+}
+
+// nopLogger 丢弃所有日志
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+func (n nopLogger) With(...interface{}) Logger { return n }
+
+// Nop 返回丢弃所有日志的Logger
+func Nop() Logger {
+	return nopLogger{}
+}
+
+var defaultLogger atomic.Value // loggerHolder
+
+// loggerHolder 使atomic.Value始终存储同一具体类型
+type loggerHolder struct {
+	logger Logger
+}
+
+func init() {
+	defaultLogger.Store(loggerHolder{New(Options{Level: LevelInfo, Format: FormatText})})
+}
+
+// Default 获取默认Logger，组件未注入Logger时使用
+func Default() Logger {
+	return defaultLogger.Load().(loggerHolder).logger
+}
+
+// SetDefault 设置默认Logger，只影响之后创建的组件
+func SetDefault(logger Logger) {
+	if logger == nil {
+		logger = Nop()
+	}
+	defaultLogger.Store(loggerHolder{logger})
+}
+
+// Component 以logger（为nil时使用默认Logger）为基础，附加组件名和其他字段
+func Component(logger Logger, component string, keyvals ...interface{}) Logger {
+	if logger == nil {
+		logger = Default()
+	}
+	return logger.With(append([]interface{}{FieldComponent, component}, keyvals...)...)
+}
+
+// WithFunc 返回每次输出时调用fn取得字段值的Logger，用于任期等随时间变化的字段
+func WithFunc(logger Logger, key string, fn func() interface{}) Logger {
+	return &funcLogger{Logger: logger, key: key, fn: fn}
+}
+
+// funcLogger 每次输出时追加动态字段
+type funcLogger struct {
+	Logger
+	key string
+	fn  func() interface{}
+}
+
+func (l *funcLogger) Debug(msg string, keyvals ...interface{}) {
+	l.Logger.Debug(msg, l.append(keyvals)...)
+}
+
+func (l *funcLogger) Info(msg string, keyvals ...interface{}) {
+	l.Logger.Info(msg, l.append(keyvals)...)
+}
+
+func (l *funcLogger) Warn(msg string, keyvals ...interface{}) {
+	l.Logger.Warn(msg, l.append(keyvals)...)
+}
+
+func (l *funcLogger) Error(msg string, keyvals ...interface{}) {
+	l.Logger.Error(msg, l.append(keyvals)...)
+}
+
+func (l *funcLogger) With(keyvals ...interface{}) Logger {
+	return &funcLogger{Logger: l.Logger.With(keyvals...), key: l.key, fn: l.fn}
+}
+
+func (l *funcLogger) append(keyvals []interface{}) []interface{} {
+	return append([]interface{}{l.key, l.fn()}, keyvals...)
+}
@@ -0,0 +1,104 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-11 16:42:08
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-11 16:42:08
+* @Description: ConcordKV structured logging - logging_test.go
+ */
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// decodeLines 把JSON日志按行解析
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("日志不是合法的JSON: %v, %s", err, line)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// TestJSONFields JSON日志包含组件、节点、DC和动态任期字段
+func TestJSONFields(t *testing.T) {
+	var buf bytes.Buffer
+	term := uint64(3)
+	logger := Component(New(Options{Level: LevelInfo, Format: FormatJSON, Output: &buf}), "raft", FieldNodeID, "n1", FieldDC, "dc1")
+	logger = WithFunc(logger, FieldTerm, func() interface{} { return term })
+
+	logger.Info("成为领导者", "peer", "n2")
+	term = 4
+	logger.With("target_dc", "dc2").Warn("复制失败")
+
+	records := decodeLines(t, &buf)
+	if len(records) != 2 {
+		t.Fatalf("期望2条日志，实际 %d", len(records))
+	}
+	first := records[0]
+	if first["msg"] != "成为领导者" || first["level"] != "INFO" || first[FieldComponent] != "raft" ||
+		first[FieldNodeID] != "n1" || first[FieldDC] != "dc1" || first[FieldTerm] != float64(3) || first["peer"] != "n2" {
+		t.Errorf("第一条日志字段不正确: %v", first)
+	}
+	second := records[1]
+	if second["level"] != "WARN" || second[FieldTerm] != float64(4) || second["target_dc"] != "dc2" {
+		t.Errorf("任期应在输出时取值: %v", second)
+	}
+}
+
+// TestLevelFilter 低于配置级别的日志被丢弃
+func TestLevelFilter(t *testing.T) {
+	level, err := ParseLevel("WARN")
+	if err != nil || level != LevelWarn {
+		t.Fatalf("解析日志级别失败: %v, %v", level, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("未知的日志级别应解析失败")
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Errorf("未知的日志格式应解析失败")
+	}
+
+	var buf bytes.Buffer
+	logger := New(Options{Level: level, Output: &buf})
+	logger.Debug("调试")
+	logger.Info("信息")
+	logger.Error("错误", FieldError, "boom")
+
+	out := buf.String()
+	if strings.Contains(out, "调试") || strings.Contains(out, "信息") {
+		t.Errorf("低于warn的日志不应输出: %s", out)
+	}
+	if !strings.Contains(out, "level=ERROR") || !strings.Contains(out, "error=boom") {
+		t.Errorf("错误日志缺失: %s", out)
+	}
+}
+
+// TestDefault 未注入Logger的组件使用默认Logger
+func TestDefault(t *testing.T) {
+	previous := Default()
+	defer SetDefault(previous)
+
+	var buf bytes.Buffer
+	SetDefault(New(Options{Format: FormatJSON, Output: &buf}))
+	Component(nil, "wal").Info("从WAL恢复记录")
+
+	records := decodeLines(t, &buf)
+	if len(records) != 1 || records[0][FieldComponent] != "wal" {
+		t.Fatalf("默认Logger未生效: %v", records)
+	}
+
+	SetDefault(nil)
+	Default().Error("丢弃")
+}
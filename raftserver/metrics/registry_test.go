@@ -0,0 +1,117 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-11 10:32:17
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-11 10:32:17
+* @Description: ConcordKV metrics registry - registry_test.go
+ */
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRegistryMergesFamilies 不同收集器的同名指标族合并输出，固定标签加在样本标签之前
+func TestRegistryMergesFamilies(t *testing.T) {
+	registry := NewRegistry(LabelNodeID, "n1")
+	registry.Register("a", CollectorFunc(func() []*Family {
+		return []*Family{NewCounter("requests_total", "Requests.").With(3, "strategy", "primary")}
+	}))
+	registry.Register("b", CollectorFunc(func() []*Family {
+		return []*Family{
+			NewCounter("requests_total", "Requests.").With(5, "strategy", "replica"),
+			// 类型不一致的同名指标族被丢弃
+			NewGauge("requests_total", "Requests.").With(7),
+			// 样本自带node_id时不覆盖
+			NewGauge("lag_seconds", "Lag.").With(0.25, LabelNodeID, "n2", LabelDC, "dc1"),
+		}
+	}))
+
+	if err := registry.Register("a", CollectorFunc(func() []*Family { return nil })); err == nil {
+		t.Fatalf("重复注册同名收集器应返回错误")
+	}
+
+	var buf bytes.Buffer
+	if err := registry.WriteText(&buf); err != nil {
+		t.Fatalf("输出指标失败: %v", err)
+	}
+
+	want := strings.Join([]string{
+		"# HELP lag_seconds Lag.",
+		"# TYPE lag_seconds gauge",
+		`lag_seconds{node_id="n2",dc="dc1"} 0.25`,
+		"# HELP requests_total Requests.",
+		"# TYPE requests_total counter",
+		`requests_total{node_id="n1",strategy="primary"} 3`,
+		`requests_total{node_id="n1",strategy="replica"} 5`,
+		"",
+	}, "\n")
+	if got := buf.String(); got != want {
+		t.Fatalf("输出不符:\n%s\n期望:\n%s", got, want)
+	}
+
+	registry.Unregister("b")
+	if families := registry.Gather(); len(families) != 1 || len(families[0].Samples) != 1 {
+		t.Fatalf("注销后应只剩一个样本: %+v", families)
+	}
+}
+
+// TestWriteTextEscaping 标签值和帮助文本按文本格式转义，特殊浮点值按约定输出
+func TestWriteTextEscaping(t *testing.T) {
+	families := []*Family{
+		NewGauge("g", "line1\nline2 \\ end").
+			With(math.NaN(), "k", `a"b\c`+"\n").
+			With(math.Inf(1)).
+			With(1e21),
+	}
+
+	var buf bytes.Buffer
+	WriteText(&buf, families)
+
+	want := strings.Join([]string{
+		`# HELP g line1\nline2 \\ end`,
+		"# TYPE g gauge",
+		`g{k="a\"b\\c\n"} NaN`,
+		"g +Inf",
+		"g 1000000000000000000000",
+		"",
+	}, "\n")
+	if got := buf.String(); got != want {
+		t.Fatalf("输出不符:\n%s\n期望:\n%s", got, want)
+	}
+}
+
+// TestRegistryServeHTTP 以Prometheus文本格式响应抓取请求，只接受GET和HEAD
+func TestRegistryServeHTTP(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("c", CollectorFunc(func() []*Family {
+		return []*Family{NewGauge("up", "Up.").With(1)}
+	}))
+	ts := httptest.NewServer(registry)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("抓取失败: %v", err)
+	}
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != ContentType || !strings.Contains(buf.String(), "up 1\n") {
+		t.Fatalf("响应不符: %s %q", resp.Header.Get("Content-Type"), buf.String())
+	}
+
+	resp, err = http.Post(ts.URL, "text/plain", nil)
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("POST状态码 = %d，期望405", resp.StatusCode)
+	}
+}
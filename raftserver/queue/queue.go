@@ -0,0 +1,311 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-6 09:12:40
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-6 09:12:40
+* @Description: ConcordKV 有界队列，队列满时按溢出策略处理新元素
+ */
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OverflowPolicy 队列满时的溢出策略
+type OverflowPolicy string
+
+const (
+	// BlockWithTimeout 等待消费者腾出空间，超过BlockTimeout仍无空间时丢弃新元素
+	BlockWithTimeout OverflowPolicy = "block-with-timeout"
+
+	// DropOldest 丢弃最早入队的元素，为新元素腾出空间
+	DropOldest OverflowPolicy = "drop-oldest"
+
+	// DropNewest 丢弃新元素
+	DropNewest OverflowPolicy = "drop-newest"
+
+	// Coalesce 与排队中键相同的元素合并（如同一DC或分片的事件），
+	// 没有可合并的元素且队列已满时按DropOldest处理
+	Coalesce OverflowPolicy = "coalesce"
+)
+
+// defaultBlockTimeout BlockWithTimeout策略未配置等待时间时的默认值
+const defaultBlockTimeout = time.Second
+
+var (
+	// ErrFull 队列已满，新元素被丢弃
+	ErrFull = errors.New("队列已满")
+
+	// ErrClosed 队列已关闭
+	ErrClosed = errors.New("队列已关闭")
+)
+
+// ParsePolicy 解析溢出策略，为空时返回空策略，由Config.WithDefaults填充默认值
+func ParsePolicy(s string) (OverflowPolicy, error) {
+	switch policy := OverflowPolicy(s); policy {
+	case "", BlockWithTimeout, DropOldest, DropNewest, Coalesce:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("未知的队列溢出策略 %q，可选值：block-with-timeout、drop-oldest、drop-newest、coalesce", s)
+	}
+}
+
+// Config 队列配置
+type Config struct {
+	Capacity     int            `json:"capacity"`
+	Policy       OverflowPolicy `json:"policy"`
+	BlockTimeout time.Duration  `json:"blockTimeout"` // 仅BlockWithTimeout策略使用
+}
+
+// WithDefaults 用defaults填充未设置的字段，无法识别的策略视为未设置
+func (c Config) WithDefaults(defaults Config) Config {
+	if c.Capacity <= 0 {
+		c.Capacity = defaults.Capacity
+	}
+	if policy, err := ParsePolicy(string(c.Policy)); err != nil || policy == "" {
+		c.Policy = defaults.Policy
+	}
+	if c.BlockTimeout <= 0 {
+		c.BlockTimeout = defaults.BlockTimeout
+	}
+	return c
+}
+
+// Options 队列的合并与丢弃回调
+type Options struct {
+	// Key 返回元素的合并键，Coalesce策略下键相同的排队元素被合并；为nil时不合并
+	Key func(item interface{}) string
+
+	// Merge 合并排队中的元素与新元素，返回值替换排队中的元素；为nil时新元素直接替换
+	Merge func(queued, item interface{}) interface{}
+
+	// OnDrop 已入队的元素被挤出队列时调用（DropOldest与Coalesce策略），调用时不持有队列的锁
+	// 被拒绝的新元素不会触发回调，而是由Enqueue返回ErrFull
+	OnDrop func(item interface{})
+}
+
+// Stats 队列统计
+type Stats struct {
+	Name      string         `json:"name"`
+	Policy    OverflowPolicy `json:"policy"`
+	Capacity  int            `json:"capacity"`
+	Depth     int            `json:"depth"`     // 当前排队的元素数
+	Enqueued  int64          `json:"enqueued"`  // 作为新元素入队的总数
+	Dropped   int64          `json:"dropped"`   // 因队列满被丢弃的总数
+	Coalesced int64          `json:"coalesced"` // 合并进排队元素的总数
+}
+
+// Queue 有界FIFO队列，可在多个生产者与消费者之间共享
+// 消费者在select中等待Ready()，被唤醒后用TryDequeue取出元素
+type Queue struct {
+	name    string
+	config  Config
+	options Options
+
+	mu     sync.Mutex
+	buf    []interface{} // 环形缓冲区
+	head   int
+	count  int
+	closed bool
+
+	ready chan struct{} // 容量为1，队列非空时发出信号
+	space chan struct{} // 容量为1，出队后发出信号，唤醒阻塞的生产者
+	done  chan struct{} // 关闭时关闭
+
+	enqueued  int64
+	dropped   int64
+	coalesced int64
+}
+
+// New 创建有界队列，Capacity小于1时视为1，未设置策略时使用DropNewest
+func New(name string, config Config, options Options) *Queue {
+	config = config.WithDefaults(Config{Capacity: 1, Policy: DropNewest, BlockTimeout: defaultBlockTimeout})
+	return &Queue{
+		name:    name,
+		config:  config,
+		options: options,
+		buf:     make([]interface{}, config.Capacity),
+		ready:   make(chan struct{}, 1),
+		space:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// Enqueue 把元素加入队列，队列满时按溢出策略处理
+// 新元素被丢弃时返回ErrFull，队列关闭后返回ErrClosed
+func (q *Queue) Enqueue(item interface{}) error {
+	var timer *time.Timer
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return ErrClosed
+		}
+
+		if q.coalesceLocked(item) {
+			q.mu.Unlock()
+			return nil
+		}
+
+		if q.count < len(q.buf) {
+			q.pushLocked(item)
+			q.mu.Unlock()
+			return nil
+		}
+
+		switch q.config.Policy {
+		case DropOldest, Coalesce:
+			evicted := q.popLocked()
+			q.dropped++
+			q.pushLocked(item)
+			q.mu.Unlock()
+			if q.options.OnDrop != nil {
+				q.options.OnDrop(evicted)
+			}
+			return nil
+		case BlockWithTimeout:
+			q.mu.Unlock()
+		default:
+			q.dropped++
+			q.mu.Unlock()
+			return ErrFull
+		}
+
+		if timer == nil {
+			timer = time.NewTimer(q.config.BlockTimeout)
+			defer timer.Stop()
+		}
+		select {
+		case <-q.space:
+		case <-q.done:
+			return ErrClosed
+		case <-timer.C:
+			q.mu.Lock()
+			q.dropped++
+			q.mu.Unlock()
+			return ErrFull
+		}
+	}
+}
+
+// coalesceLocked Coalesce策略下把元素合并进键相同的排队元素，合并成功时返回true（调用方需持有q.mu）
+func (q *Queue) coalesceLocked(item interface{}) bool {
+	if q.config.Policy != Coalesce || q.options.Key == nil {
+		return false
+	}
+
+	key := q.options.Key(item)
+	for i := 0; i < q.count; i++ {
+		pos := (q.head + i) % len(q.buf)
+		if q.options.Key(q.buf[pos]) != key {
+			continue
+		}
+		if q.options.Merge != nil {
+			q.buf[pos] = q.options.Merge(q.buf[pos], item)
+		} else {
+			q.buf[pos] = item
+		}
+		q.coalesced++
+		return true
+	}
+	return false
+}
+
+// pushLocked 把元素加入队尾并通知消费者，队列必须有空间（调用方需持有q.mu）
+func (q *Queue) pushLocked(item interface{}) {
+	q.buf[(q.head+q.count)%len(q.buf)] = item
+	q.count++
+	q.enqueued++
+	signal(q.ready)
+}
+
+// popLocked 取出队首元素，队列必须非空（调用方需持有q.mu）
+func (q *Queue) popLocked() interface{} {
+	item := q.buf[q.head]
+	q.buf[q.head] = nil
+	q.head = (q.head + 1) % len(q.buf)
+	q.count--
+	return item
+}
+
+// signal 非阻塞地发出信号，已有未取走的信号时合并
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// TryDequeue 非阻塞地取出队首元素，队列为空时返回false
+// 取出后仍有元素时重新发出Ready信号，以便唤醒其他消费者
+func (q *Queue) TryDequeue() (interface{}, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.count == 0 {
+		return nil, false
+	}
+	item := q.popLocked()
+	signal(q.space)
+	if q.count > 0 {
+		signal(q.ready)
+	}
+	return item, true
+}
+
+// Dequeue 取出队首元素，队列为空时等待，stop关闭后返回false
+func (q *Queue) Dequeue(stop <-chan struct{}) (interface{}, bool) {
+	for {
+		if item, ok := q.TryDequeue(); ok {
+			return item, true
+		}
+		select {
+		case <-q.ready:
+		case <-stop:
+			return nil, false
+		}
+	}
+}
+
+// Ready 队列可能非空时收到信号的通道，消费者收到信号后应调用TryDequeue
+func (q *Queue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+// Len 当前排队的元素数
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.count
+}
+
+// Close 关闭队列，之后的Enqueue返回ErrClosed，阻塞中的生产者立即返回；已排队的元素仍可取出
+func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.done)
+}
+
+// Stats 获取队列统计
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return Stats{
+		Name:      q.name,
+		Policy:    q.config.Policy,
+		Capacity:  len(q.buf),
+		Depth:     q.count,
+		Enqueued:  q.enqueued,
+		Dropped:   q.dropped,
+		Coalesced: q.coalesced,
+	}
+}
@@ -0,0 +1,192 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-6 09:12:40
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-6 09:12:40
+* @Description: ConcordKV 有界队列测试
+ */
+package queue_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"raftserver/queue"
+)
+
+// event 测试用事件，Key相同的事件可以合并
+type event struct {
+	Key   string
+	Count int
+}
+
+func drain(q *queue.Queue) []interface{} {
+	var items []interface{}
+	for {
+		item, ok := q.TryDequeue()
+		if !ok {
+			return items
+		}
+		items = append(items, item)
+	}
+}
+
+// TestDropNewest 队列满时拒绝新元素
+func TestDropNewest(t *testing.T) {
+	q := queue.New("test", queue.Config{Capacity: 2, Policy: queue.DropNewest}, queue.Options{})
+	q.Enqueue(1)
+	q.Enqueue(2)
+	if err := q.Enqueue(3); !errors.Is(err, queue.ErrFull) {
+		t.Fatalf("队列满时应返回ErrFull，实际 %v", err)
+	}
+
+	if items := drain(q); len(items) != 2 || items[0] != 1 || items[1] != 2 {
+		t.Fatalf("应保留最早的元素: %v", items)
+	}
+	if stats := q.Stats(); stats.Enqueued != 2 || stats.Dropped != 1 || stats.Depth != 0 || stats.Capacity != 2 {
+		t.Fatalf("统计不正确: %+v", stats)
+	}
+}
+
+// TestDropOldest 队列满时挤出最早的元素并回调
+func TestDropOldest(t *testing.T) {
+	var evicted []interface{}
+	q := queue.New("test", queue.Config{Capacity: 2, Policy: queue.DropOldest}, queue.Options{
+		OnDrop: func(item interface{}) { evicted = append(evicted, item) },
+	})
+	for i := 1; i <= 4; i++ {
+		if err := q.Enqueue(i); err != nil {
+			t.Fatalf("入队失败: %v", err)
+		}
+	}
+
+	if items := drain(q); len(items) != 2 || items[0] != 3 || items[1] != 4 {
+		t.Fatalf("应保留最新的元素: %v", items)
+	}
+	if len(evicted) != 2 || evicted[0] != 1 || evicted[1] != 2 {
+		t.Fatalf("被挤出的元素不正确: %v", evicted)
+	}
+	if stats := q.Stats(); stats.Enqueued != 4 || stats.Dropped != 2 {
+		t.Fatalf("统计不正确: %+v", stats)
+	}
+}
+
+// TestCoalesce 键相同的元素合并，队列满且无可合并元素时挤出最早的元素
+func TestCoalesce(t *testing.T) {
+	q := queue.New("test", queue.Config{Capacity: 2, Policy: queue.Coalesce}, queue.Options{
+		Key: func(item interface{}) string { return item.(*event).Key },
+		Merge: func(queued, item interface{}) interface{} {
+			merged := *queued.(*event)
+			merged.Count += item.(*event).Count
+			return &merged
+		},
+	})
+	q.Enqueue(&event{Key: "dc1", Count: 1})
+	q.Enqueue(&event{Key: "dc2", Count: 1})
+	for i := 0; i < 5; i++ {
+		if err := q.Enqueue(&event{Key: "dc1", Count: 1}); err != nil {
+			t.Fatalf("合并失败: %v", err)
+		}
+	}
+
+	stats := q.Stats()
+	if stats.Depth != 2 || stats.Enqueued != 2 || stats.Coalesced != 5 || stats.Dropped != 0 {
+		t.Fatalf("统计不正确: %+v", stats)
+	}
+
+	q.Enqueue(&event{Key: "dc3", Count: 1})
+	items := drain(q)
+	if len(items) != 2 || items[0].(*event).Key != "dc2" || items[1].(*event).Key != "dc3" {
+		t.Fatalf("应挤出最早的元素: %+v", items)
+	}
+	if stats := q.Stats(); stats.Dropped != 1 {
+		t.Fatalf("统计不正确: %+v", stats)
+	}
+}
+
+// TestBlockWithTimeout 队列满时等待消费者腾出空间，超时后丢弃新元素
+func TestBlockWithTimeout(t *testing.T) {
+	q := queue.New("test", queue.Config{Capacity: 1, Policy: queue.BlockWithTimeout, BlockTimeout: 50 * time.Millisecond}, queue.Options{})
+	q.Enqueue(1)
+
+	start := time.Now()
+	if err := q.Enqueue(2); !errors.Is(err, queue.ErrFull) || time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("应等待超时后返回ErrFull，实际 %v（等待 %v）", err, time.Since(start))
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.TryDequeue()
+	}()
+	if err := q.Enqueue(3); err != nil {
+		t.Fatalf("消费者腾出空间后应入队成功: %v", err)
+	}
+	if item, ok := q.Dequeue(nil); !ok || item != 3 {
+		t.Fatalf("出队元素不正确: %v", item)
+	}
+	if stats := q.Stats(); stats.Enqueued != 2 || stats.Dropped != 1 {
+		t.Fatalf("统计不正确: %+v", stats)
+	}
+}
+
+// TestCloseWakesProducers 关闭队列时阻塞的生产者立即返回，已排队的元素仍可取出
+func TestCloseWakesProducers(t *testing.T) {
+	q := queue.New("test", queue.Config{Capacity: 1, Policy: queue.BlockWithTimeout, BlockTimeout: time.Hour}, queue.Options{})
+	q.Enqueue(1)
+
+	result := make(chan error, 1)
+	go func() { result <- q.Enqueue(2) }()
+	time.Sleep(10 * time.Millisecond)
+	q.Close()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, queue.ErrClosed) {
+			t.Fatalf("应返回ErrClosed，实际 %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("关闭队列后生产者仍在阻塞")
+	}
+	if item, ok := q.TryDequeue(); !ok || item != 1 {
+		t.Fatalf("关闭后应仍可取出已排队的元素: %v", item)
+	}
+}
+
+// TestReadySignalsEachConsumer 多个消费者等待时，每个元素都能唤醒一个消费者
+func TestReadySignalsEachConsumer(t *testing.T) {
+	q := queue.New("test", queue.Config{Capacity: 10}, queue.Options{})
+	stop := make(chan struct{})
+	defer close(stop)
+
+	got := make(chan interface{}, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			if item, ok := q.Dequeue(stop); ok {
+				got <- item
+			}
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		q.Enqueue(i)
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-got:
+		case <-time.After(time.Second):
+			t.Fatalf("只有 %d 个消费者被唤醒", i)
+		}
+	}
+}
+
+// TestParsePolicy 只接受已知的溢出策略，未设置时使用默认值
+func TestParsePolicy(t *testing.T) {
+	if _, err := queue.ParsePolicy("drop-all"); err == nil {
+		t.Fatalf("未知的溢出策略应报错")
+	}
+	config := queue.Config{Policy: "drop-all"}.WithDefaults(queue.Config{Capacity: 10, Policy: queue.Coalesce, BlockTimeout: time.Second})
+	if config.Capacity != 10 || config.Policy != queue.Coalesce || config.BlockTimeout != time.Second {
+		t.Fatalf("默认值填充不正确: %+v", config)
+	}
+}
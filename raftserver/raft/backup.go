@@ -0,0 +1,110 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-24 10:12:48
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-24 10:12:48
+* @Description: ConcordKV Raft consensus server - backup.go
+ */
+package raft
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrLogCompacted 请求的日志条目已被快照压缩，只能重新获取完整备份
+	ErrLogCompacted = errors.New("请求的日志已被快照压缩")
+
+	// ErrStorageNotEmpty 从备份引导的存储中已有任期、日志或快照
+	ErrStorageNotEmpty = errors.New("存储中已有数据")
+)
+
+// Backup 在已应用的最后一个索引处创建状态机快照用于备份
+// 与takeSnapshot使用相同的状态机快照，但不保存快照也不截断日志；期间暂停应用日志，复制与提交不受影响
+func (n *Node) Backup() (*Snapshot, error) {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.RLock()
+	index := n.lastApplied
+	term, err := n.termAt(index)
+	servers := append([]Server(nil), n.config.Servers...)
+	n.mu.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("获取备份边界 %d 的任期失败: %w", index, err)
+	}
+
+	data, err := n.stateMachine.CreateSnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("序列化状态机失败: %w", err)
+	}
+
+	return &Snapshot{
+		LastIncludedIndex: index,
+		LastIncludedTerm:  term,
+		Configuration:     Configuration{Servers: servers},
+		Data:              data,
+	}, nil
+}
+
+// BackupEntries 获取since之后直到已应用的最后一个索引的日志条目，用于增量备份，返回条目与最后一个条目的索引和任期
+// since之后的条目已被快照压缩时返回ErrLogCompacted
+func (n *Node) BackupEntries(since LogIndex) ([]LogEntry, LogIndex, Term, error) {
+	// 持有applyMu期间不会创建或安装快照，读取的日志不会被压缩
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	last := n.lastApplied
+	if since > last {
+		return nil, 0, 0, fmt.Errorf("起始索引 %d 超过已应用的索引 %d", since, last)
+	}
+	if since < n.snapshotMetrics.LastSnapshotIndex {
+		return nil, 0, 0, fmt.Errorf("%w: 起始索引 %d 早于快照 %d", ErrLogCompacted, since, n.snapshotMetrics.LastSnapshotIndex)
+	}
+
+	term, err := n.termAt(last)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("获取索引 %d 的任期失败: %w", last, err)
+	}
+	if since == last {
+		return nil, last, term, nil
+	}
+
+	entries, err := n.storage.GetLogEntries(since+1, last)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("读取日志 %d-%d 失败: %w", since+1, last, err)
+	}
+	if LogIndex(len(entries)) != last-since {
+		return nil, 0, 0, fmt.Errorf("%w: 日志 %d-%d 不完整", ErrLogCompacted, since+1, last)
+	}
+	return entries, last, term, nil
+}
+
+// BootstrapFromSnapshot 用备份的快照初始化空存储，之后以该存储创建的节点从快照恢复状态机，
+// 日志从快照之后的索引继续；snapshot.Configuration为恢复后的集群成员
+func BootstrapFromSnapshot(storage Storage, snapshot *Snapshot) error {
+	term, err := storage.GetCurrentTerm()
+	if err != nil {
+		return fmt.Errorf("读取当前任期失败: %w", err)
+	}
+	existing, _ := storage.GetSnapshot()
+	if term != 0 || storage.GetLastLogIndex() != 0 || (existing != nil && existing.LastIncludedIndex != 0) {
+		return ErrStorageNotEmpty
+	}
+	if len(snapshot.Configuration.Servers) == 0 {
+		return fmt.Errorf("快照中没有集群成员")
+	}
+
+	if err := storage.SaveSnapshot(snapshot); err != nil {
+		return fmt.Errorf("保存快照失败: %w", err)
+	}
+	// 任期不能小于快照边界的任期，否则新选出的领导者写入的条目任期会倒退
+	if err := storage.SaveCurrentTerm(snapshot.LastIncludedTerm); err != nil {
+		return fmt.Errorf("保存当前任期失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-24 10:12:48
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-24 10:12:48
+* @Description: ConcordKV Raft consensus server - backup_test.go
+ */
+package raft_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/storage"
+)
+
+// TestBackupEntriesCompacted 增量备份返回起点之后已应用的日志，起点之后的日志被快照压缩后返回ErrLogCompacted
+func TestBackupEntriesCompacted(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	_, leader, stop := newMemClusterWithConfig(t, time.Millisecond, func(config *raft.Config) {
+		config.SnapshotThreshold = 20
+		config.SnapshotDir = t.TempDir()
+	})
+	defer stop()
+
+	propose := func(n int) raft.LogIndex {
+		var last raft.LogIndex
+		for i := 0; i < n; i++ {
+			index, err := leader.ProposeWithIndex([]byte(fmt.Sprintf(`{"type":"SET","key":"k%d","value":"v"}`, i)))
+			if err != nil {
+				t.Fatalf("提议失败: %v", err)
+			}
+			last = index
+		}
+		waitApplied(t, leader, last, 5*time.Second)
+		return last
+	}
+
+	last := propose(5)
+	snapshot, err := leader.Backup()
+	if err != nil {
+		t.Fatalf("创建备份失败: %v", err)
+	}
+	if snapshot.LastIncludedIndex < last || len(snapshot.Configuration.Servers) == 0 {
+		t.Fatalf("备份的索引 = %d, 应不小于 %d", snapshot.LastIncludedIndex, last)
+	}
+	since := snapshot.LastIncludedIndex
+
+	last = propose(3)
+	entries, index, _, err := leader.BackupEntries(since)
+	if err != nil {
+		t.Fatalf("获取增量日志失败: %v", err)
+	}
+	if index < last || raft.LogIndex(len(entries)) != index-since || entries[0].Index != since+1 {
+		t.Fatalf("增量日志覆盖 %d 个条目到 %d, 期望从 %d 连续到 %d", len(entries), index, since+1, last)
+	}
+
+	propose(60)
+	deadline := time.Now().Add(5 * time.Second)
+	for leader.LastSnapshotIndex() <= since {
+		if time.Now().After(deadline) {
+			t.Fatalf("领导者未创建快照")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, _, _, err := leader.BackupEntries(since); !errors.Is(err, raft.ErrLogCompacted) {
+		t.Fatalf("日志被压缩后应返回ErrLogCompacted，实际: %v", err)
+	}
+}
+
+// TestBootstrapFromSnapshotRequiresEmptyStorage 只能用备份初始化空存储
+func TestBootstrapFromSnapshotRequiresEmptyStorage(t *testing.T) {
+	snapshot := &raft.Snapshot{
+		LastIncludedIndex: 10,
+		LastIncludedTerm:  3,
+		Configuration:     raft.Configuration{Servers: []raft.Server{{ID: "node1"}}},
+	}
+
+	store := storage.NewMemoryStorage()
+	if err := raft.BootstrapFromSnapshot(store, snapshot); err != nil {
+		t.Fatalf("初始化空存储失败: %v", err)
+	}
+	if term, _ := store.GetCurrentTerm(); term != 3 || store.GetLastLogIndex() != 10 {
+		t.Fatalf("初始化后任期 = %d, 最后日志索引 = %d", term, store.GetLastLogIndex())
+	}
+
+	if err := raft.BootstrapFromSnapshot(store, snapshot); !errors.Is(err, raft.ErrStorageNotEmpty) {
+		t.Fatalf("存储非空时应返回ErrStorageNotEmpty，实际: %v", err)
+	}
+}
@@ -0,0 +1,346 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-23 15:06:42
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-23 15:06:42
+* @Description: ConcordKV Raft consensus server - catchup_throttle.go
+ */
+package raft
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCatchUpLaggingEntries 跟随者落后超过该条目数时，发给它的追加日志按追赶流量限速
+	DefaultCatchUpLaggingEntries = 1000
+
+	// defaultCatchUpAdjustInterval 动态调整速率的默认间隔
+	defaultCatchUpAdjustInterval = time.Second
+
+	// minCatchUpRateFactor 连续减半后速率不低于配置速率的1/64
+	minCatchUpRateFactor = 1.0 / 64
+
+	// catchUpRecoveryStep 提交延迟恢复正常后，每个调整间隔恢复配置速率的1/8
+	catchUpRecoveryStep = 1.0 / 8
+
+	// catchUpEntryOverhead 估算追加日志条目大小时每个条目除数据外的开销(字节)
+	catchUpEntryOverhead = 32
+
+	// catchUpRateWindow 统计各跟随者追赶速率的窗口
+	catchUpRateWindow = time.Second
+)
+
+// CatchUpConfig 追赶复制限速配置：领导者向远远落后的跟随者批量发送日志、发送快照块时按字节限速，
+// 避免长时间下线的跟随者重新加入时占满磁盘与跨DC链路，影响前台请求的延迟。速率为0表示不限制该项
+type CatchUpConfig struct {
+	PeerBytesPerSec    int64            `json:"peerBytesPerSec"`    // 每个跟随者的默认速率(字节/秒)
+	PeerLimits         map[NodeID]int64 `json:"peerLimits"`         // 按跟随者覆盖PeerBytesPerSec
+	GlobalBytesPerSec  int64            `json:"globalBytesPerSec"`  // 所有跟随者追赶流量的总速率
+	CrossDCBytesPerSec int64            `json:"crossDCBytesPerSec"` // 与领导者不在同一数据中心的跟随者共享的总速率
+	BurstBytes         int64            `json:"burstBytes"`         // 令牌桶容量，为0时为各桶1秒的速率
+
+	// LaggingEntries 落后超过该条目数的跟随者视为正在追赶，为0时使用DefaultCatchUpLaggingEntries；
+	// 快照总是按追赶流量限速
+	LaggingEntries int `json:"laggingEntries"`
+
+	// CommitLatencyThreshold 本地提交延迟超过该值时所有追赶速率减半，恢复正常后逐步回到配置速率；为0时不动态调整
+	CommitLatencyThreshold time.Duration `json:"commitLatencyThreshold"`
+
+	// AdjustInterval 两次调整速率的最小间隔，为0时为1秒
+	AdjustInterval time.Duration `json:"adjustInterval"`
+}
+
+// CatchUpMetrics 追赶复制限速的汇总指标（仅领导者）
+type CatchUpMetrics struct {
+	RateFactor      float64 `json:"rateFactor"`      // 当前速率占配置速率的比例，提交延迟过高时减半
+	CommitLatencyMs float64 `json:"commitLatencyMs"` // 最近一次推进提交索引时观察到的提交延迟(ms)
+	ThrottledMillis int64   `json:"throttledMillis"` // 所有跟随者因限速等待的累计时间(ms)
+}
+
+// tokenBucket 按字节计的令牌桶，允许透支：透支的部分由之后的请求等待补足
+type tokenBucket struct {
+	base   float64 // 配置速率(字节/秒)
+	rate   float64 // 当前速率，为base乘以调整系数
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(bytesPerSec, burst int64, now time.Time) *tokenBucket {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = bytesPerSec
+	}
+	return &tokenBucket{
+		base:   float64(bytesPerSec),
+		rate:   float64(bytesPerSec),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// reserve 取出n字节的令牌，返回需要等待的时间
+func (b *tokenBucket) reserve(n int64, now time.Time) time.Duration {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// peerCatchUp 单个跟随者的追赶限速状态
+type peerCatchUp struct {
+	bucket      *tokenBucket // 未限制该跟随者的速率时为nil
+	throttled   time.Duration
+	windowStart time.Time
+	windowBytes int64
+	lastSend    time.Time
+	rate        float64 // 最近一个完整统计窗口的速率(字节/秒)
+}
+
+// catchUpThrottle 追赶复制限速器，发送前按跟随者、全局与跨DC三类令牌桶中最长的等待时间等待
+type catchUpThrottle struct {
+	mu            sync.Mutex
+	config        CatchUpConfig
+	global        *tokenBucket
+	crossDC       *tokenBucket
+	peers         map[NodeID]*peerCatchUp
+	factor        float64
+	slow          bool // 本调整间隔内提交延迟超过阈值
+	lastAdjust    time.Time
+	commitLatency time.Duration
+	throttled     time.Duration
+}
+
+// newCatchUpThrottle 创建追赶复制限速器，未配置时返回nil
+func newCatchUpThrottle(config *CatchUpConfig) *catchUpThrottle {
+	if config == nil {
+		return nil
+	}
+
+	now := time.Now()
+	t := &catchUpThrottle{
+		config:  *config,
+		global:  newTokenBucket(config.GlobalBytesPerSec, config.BurstBytes, now),
+		crossDC: newTokenBucket(config.CrossDCBytesPerSec, config.BurstBytes, now),
+		peers:   make(map[NodeID]*peerCatchUp),
+		factor:  1,
+	}
+	if t.config.LaggingEntries <= 0 {
+		t.config.LaggingEntries = DefaultCatchUpLaggingEntries
+	}
+	if t.config.AdjustInterval <= 0 {
+		t.config.AdjustInterval = defaultCatchUpAdjustInterval
+	}
+	return t
+}
+
+// lagging 跟随者落后的条目数是否达到追赶限速的标准
+func (t *catchUpThrottle) lagging(lag LogIndex) bool {
+	return lag > LogIndex(t.config.LaggingEntries)
+}
+
+// peerLocked 获取跟随者的限速状态（调用方需持有t.mu）
+func (t *catchUpThrottle) peerLocked(id NodeID, now time.Time) *peerCatchUp {
+	p, ok := t.peers[id]
+	if !ok {
+		limit := t.config.PeerBytesPerSec
+		if v, ok := t.config.PeerLimits[id]; ok {
+			limit = v
+		}
+		p = &peerCatchUp{bucket: newTokenBucket(limit, t.config.BurstBytes, now), windowStart: now}
+		if p.bucket != nil {
+			p.bucket.rate = p.bucket.base * t.factor
+		}
+		t.peers[id] = p
+	}
+	return p
+}
+
+// wait 为发往跟随者的bytes字节追赶流量取得令牌，需要等待时阻塞直到令牌补足；stop关闭时返回false
+func (t *catchUpThrottle) wait(id NodeID, crossDC bool, bytes int64, stop, done <-chan struct{}) bool {
+	now := time.Now()
+
+	t.mu.Lock()
+	t.adjustLocked(now)
+	p := t.peerLocked(id, now)
+
+	var delay time.Duration
+	for _, b := range []*tokenBucket{p.bucket, t.global, t.crossDCBucket(crossDC)} {
+		if b == nil {
+			continue
+		}
+		if d := b.reserve(bytes, now); d > delay {
+			delay = d
+		}
+	}
+
+	p.record(bytes, now.Add(delay))
+	p.throttled += delay
+	t.throttled += delay
+	t.mu.Unlock()
+
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	case <-done:
+		return false
+	}
+}
+
+// crossDCBucket 跨DC跟随者共享的令牌桶，同DC的跟随者返回nil
+func (t *catchUpThrottle) crossDCBucket(crossDC bool) *tokenBucket {
+	if !crossDC {
+		return nil
+	}
+	return t.crossDC
+}
+
+// record 统计在at时刻发出的字节数，每个完整窗口结束时更新速率
+func (p *peerCatchUp) record(bytes int64, at time.Time) {
+	if elapsed := at.Sub(p.windowStart); elapsed >= catchUpRateWindow {
+		p.rate = float64(p.windowBytes) / elapsed.Seconds()
+		p.windowStart = at
+		p.windowBytes = 0
+	}
+	p.windowBytes += bytes
+	p.lastSend = at
+}
+
+// currentRate 最近的追赶速率，超过两个窗口没有发送时为0
+func (p *peerCatchUp) currentRate(now time.Time) float64 {
+	if now.Sub(p.lastSend) > 2*catchUpRateWindow {
+		return 0
+	}
+	return p.rate
+}
+
+// observeCommitLatency 记录推进提交索引时的提交延迟，超过阈值时在下一次调整中将速率减半
+func (t *catchUpThrottle) observeCommitLatency(latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.commitLatency = latency
+	if t.config.CommitLatencyThreshold > 0 && latency > t.config.CommitLatencyThreshold {
+		t.slow = true
+	}
+	t.adjustLocked(time.Now())
+}
+
+// adjustLocked 每个调整间隔最多调整一次：期间提交延迟过高时速率减半，否则逐步恢复（调用方需持有t.mu）
+func (t *catchUpThrottle) adjustLocked(now time.Time) {
+	if t.config.CommitLatencyThreshold <= 0 || now.Sub(t.lastAdjust) < t.config.AdjustInterval {
+		return
+	}
+
+	factor := t.factor
+	if t.slow {
+		factor /= 2
+		if factor < minCatchUpRateFactor {
+			factor = minCatchUpRateFactor
+		}
+	} else if factor < 1 {
+		factor += catchUpRecoveryStep
+		if factor > 1 {
+			factor = 1
+		}
+	}
+	t.slow = false
+
+	if factor == t.factor {
+		return
+	}
+	t.factor = factor
+	t.lastAdjust = now
+
+	for _, b := range []*tokenBucket{t.global, t.crossDC} {
+		if b != nil {
+			b.rate = b.base * factor
+		}
+	}
+	for _, p := range t.peers {
+		if p.bucket != nil {
+			p.bucket.rate = p.bucket.base * factor
+		}
+	}
+}
+
+// peerStats 跟随者当前的追赶速率(字节/秒)与因限速等待的累计时间
+func (t *catchUpThrottle) peerStats(id NodeID) (float64, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.peers[id]
+	if !ok {
+		return 0, 0
+	}
+	return p.currentRate(time.Now()), p.throttled
+}
+
+// metrics 获取汇总指标
+func (t *catchUpThrottle) metrics() *CatchUpMetrics {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return &CatchUpMetrics{
+		RateFactor:      t.factor,
+		CommitLatencyMs: float64(t.commitLatency.Microseconds()) / 1000,
+		ThrottledMillis: t.throttled.Milliseconds(),
+	}
+}
+
+// throttleCatchUp 发送追赶流量前按限速等待，未配置限速时立即返回；节点停止或stop关闭时返回false
+func (n *Node) throttleCatchUp(followerID NodeID, bytes int64, stop <-chan struct{}) bool {
+	if n.catchUp == nil {
+		return true
+	}
+
+	n.mu.RLock()
+	crossDC := n.peerDataCenterLocked(followerID) != n.localDataCenterLocked()
+	n.mu.RUnlock()
+
+	return n.catchUp.wait(followerID, crossDC, bytes, stop, n.ctx.Done())
+}
+
+// peerDataCenterLocked 跟随者所在的数据中心，成员配置中未标注时视为与领导者在同一DC（调用方需持有锁）
+func (n *Node) peerDataCenterLocked(id NodeID) DataCenterID {
+	for _, server := range n.config.Servers {
+		if server.ID == id && server.DataCenter != "" {
+			return server.DataCenter
+		}
+	}
+	if learner, ok := n.learners[id]; ok && learner.DataCenter != "" {
+		return learner.DataCenter
+	}
+	return n.localDataCenterLocked()
+}
+
+// entriesSize 估算追加日志条目的字节数
+func entriesSize(entries []LogEntry) int64 {
+	var size int64
+	for _, entry := range entries {
+		size += int64(len(entry.Data)) + catchUpEntryOverhead
+	}
+	return size
+}
@@ -0,0 +1,170 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-23 15:06:42
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-23 15:06:42
+* @Description: ConcordKV Raft consensus server - catchup_throttle_test.go
+ */
+package raft_test
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/transport"
+)
+
+// TestCatchUpThrottleBoundsForegroundLatency 跟随者下线期间写入10万条日志，恢复后按限速追赶，
+// 追赶期间前台写入的提交延迟保持在上限之内，复制进度报告追赶速率与限速等待时间
+func TestCatchUpThrottleBoundsForegroundLatency(t *testing.T) {
+	if testing.Short() {
+		t.Skip("追赶10万条日志耗时较长")
+	}
+
+	const (
+		entries      = 100000
+		batchSize    = 1000
+		bytesPerSec  = 4 << 20
+		latencyBound = 200 * time.Millisecond
+	)
+
+	c := newChaosCluster(t, 3, func(config *raft.Config) {
+		config.MaxLogEntries = 512
+		// 预投票避免隔离期间不断增加任期的跟随者恢复后推翻领导者
+		config.EnablePreVote = true
+		config.CatchUp = &raft.CatchUpConfig{PeerBytesPerSec: bytesPerSec, BurstBytes: 64 << 10}
+	})
+	leader := c.waitLeader(t, c.ids, 5*time.Second)
+	lagging := others(c.ids, leader.GetID())[0]
+
+	isolate := c.chaos.Isolate(lagging)
+	var last raft.LogIndex
+	for i := 0; i < entries; i += batchSize {
+		batch := make([][]byte, batchSize)
+		for j := range batch {
+			batch[j] = []byte(fmt.Sprintf(`{"type":"SET","key":"bulk-%06d","value":"v"}`, i+j))
+		}
+		indexes, err := leader.ProposeBatch(batch)
+		if err != nil {
+			t.Fatalf("批量提议失败: %v", err)
+		}
+		last = indexes[len(indexes)-1]
+		waitCommitted(t, leader, last)
+	}
+
+	c.chaos.Heal(isolate)
+	start := time.Now()
+
+	// 跟随者追赶期间持续写入，记录每次写入的提交延迟
+	var latencies []time.Duration
+	var sawRate bool
+	deadline := time.Now().Add(60 * time.Second)
+	for i := 0; ; i++ {
+		progress := leader.GetMetrics().Replication[lagging]
+		if progress.MatchIndex >= last {
+			break
+		}
+		if progress.CatchUpRate > 0 {
+			sawRate = true
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("跟随者追赶超时，已复制到 %d/%d", progress.MatchIndex, last)
+		}
+
+		begin := time.Now()
+		if err := c.set(leader, fmt.Sprintf("fg-%d", i), "v", 5*time.Second); err != nil {
+			t.Fatalf("追赶期间前台写入失败: %v", err)
+		}
+		latencies = append(latencies, time.Since(begin))
+		time.Sleep(5 * time.Millisecond)
+	}
+	elapsed := time.Since(start)
+
+	if len(latencies) == 0 {
+		t.Fatal("追赶期间没有前台写入")
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	p99 := latencies[len(latencies)*99/100]
+	if p99 > latencyBound {
+		t.Errorf("追赶期间前台写入的p99提交延迟 %v 超过 %v（共 %d 次写入）", p99, latencyBound, len(latencies))
+	}
+
+	// 每个条目至少40字节，按限速追赶10万条日志不会快于1秒
+	if elapsed < time.Second {
+		t.Errorf("追赶耗时 %v，限速没有生效", elapsed)
+	}
+	progress := leader.GetMetrics().Replication[lagging]
+	if progress.ThrottledMillis == 0 {
+		t.Errorf("追赶流量应有限速等待: %+v", progress)
+	}
+	if !sawRate {
+		t.Errorf("追赶期间复制进度应报告追赶速率")
+	}
+	if other := leader.GetMetrics().Replication[others(c.ids, leader.GetID(), lagging)[0]]; other.ThrottledMillis != 0 {
+		t.Errorf("没有落后的跟随者不应被限速: %+v", other)
+	}
+}
+
+// TestCatchUpRateAdaptsToCommitLatency 提交延迟超过阈值时追赶速率逐次减半，恢复正常后逐步回到配置速率
+func TestCatchUpRateAdaptsToCommitLatency(t *testing.T) {
+	c := newChaosCluster(t, 3, func(config *raft.Config) {
+		config.CatchUp = &raft.CatchUpConfig{
+			PeerBytesPerSec:        1 << 20,
+			CommitLatencyThreshold: 15 * time.Millisecond,
+			AdjustInterval:         40 * time.Millisecond,
+		}
+	})
+	leader := c.waitLeader(t, c.ids, 5*time.Second)
+	if m := leader.GetMetrics().CatchUp; m == nil || m.RateFactor != 1 {
+		t.Fatalf("初始速率比例应为1: %+v", m)
+	}
+
+	// 追加日志延迟25~30ms，每次提交都超过阈值
+	delay := c.chaos.Delay(25*time.Millisecond, 30*time.Millisecond, transport.MessageAppendEntries)
+	for i := 0; i < 10; i++ {
+		if err := c.set(leader, fmt.Sprintf("slow-%d", i), "v", time.Second); err != nil {
+			t.Fatalf("写入失败: %v", err)
+		}
+	}
+	m := leader.GetMetrics().CatchUp
+	if m.RateFactor > 0.25 {
+		t.Fatalf("提交延迟持续超过阈值后速率比例应至少减半两次，实际 %v", m.RateFactor)
+	}
+	if m.CommitLatencyMs < 15 {
+		t.Fatalf("应报告超过阈值的提交延迟，实际 %vms", m.CommitLatencyMs)
+	}
+
+	// 延迟恢复后每个调整间隔恢复1/8，经过中间值逐步回到配置速率
+	c.chaos.Heal(delay)
+	deadline := time.Now().Add(5 * time.Second)
+	steps := make(map[float64]bool)
+	for i := 0; leader.GetMetrics().CatchUp.RateFactor < 1; i++ {
+		if time.Now().After(deadline) {
+			t.Fatalf("速率比例未恢复: %v", leader.GetMetrics().CatchUp.RateFactor)
+		}
+		if err := c.set(leader, fmt.Sprintf("fast-%d", i), "v", time.Second); err != nil {
+			t.Fatalf("写入失败: %v", err)
+		}
+		steps[leader.GetMetrics().CatchUp.RateFactor] = true
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(steps) < 4 {
+		t.Fatalf("速率应逐步恢复，只观察到 %d 个不同的速率比例", len(steps))
+	}
+}
+
+// waitCommitted 等待领导者提交到index
+func waitCommitted(t *testing.T, leader *raft.Node, index raft.LogIndex) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for leader.GetMetrics().CommitIndex < index {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待提交到 %d 超时", index)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
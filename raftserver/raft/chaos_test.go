@@ -0,0 +1,318 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-12 10:15:42
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-12 10:15:42
+* @Description: ConcordKV Raft consensus server - chaos_test.go
+ */
+package raft_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/raft"
+	"raftserver/statemachine"
+	"raftserver/storage"
+	"raftserver/transport"
+)
+
+const (
+	chaosElectionTimeout   = 150 * time.Millisecond
+	chaosHeartbeatInterval = 30 * time.Millisecond
+)
+
+// chaosCluster 经ChaosTransport连接的进程内集群
+type chaosCluster struct {
+	chaos    *transport.Chaos
+	ids      []raft.NodeID
+	nodes    map[raft.NodeID]*raft.Node
+	machines map[raft.NodeID]*statemachine.KVStateMachine
+	seq      int
+}
+
+// newChaosCluster 创建并启动n个节点的集群，configure可调整各节点配置，测试结束时停止所有节点
+func newChaosCluster(tb testing.TB, n int, configure func(config *raft.Config)) *chaosCluster {
+	tb.Helper()
+
+	c := &chaosCluster{
+		chaos:    transport.NewChaos(1),
+		nodes:    make(map[raft.NodeID]*raft.Node),
+		machines: make(map[raft.NodeID]*statemachine.KVStateMachine),
+	}
+	network := transport.NewMemoryNetwork()
+
+	servers := make([]raft.Server, 0, n)
+	for i := 1; i <= n; i++ {
+		id := raft.NodeID(fmt.Sprintf("node%d", i))
+		c.ids = append(c.ids, id)
+		servers = append(servers, raft.Server{ID: id, Address: string(id)})
+	}
+
+	for _, id := range c.ids {
+		config := &raft.Config{
+			NodeID:            id,
+			ElectionTimeout:   chaosElectionTimeout,
+			HeartbeatInterval: chaosHeartbeatInterval,
+			MaxLogEntries:     16,
+			Servers:           servers,
+			Logger:            logging.Nop(),
+		}
+		if configure != nil {
+			configure(config)
+		}
+
+		memory := network.Transport(id)
+		machine := statemachine.NewKVStateMachine()
+		node, err := raft.NewNode(config, c.chaos.Wrap(id, memory), storage.NewMemoryStorage(), machine)
+		if err != nil {
+			tb.Fatalf("创建节点 %s 失败: %v", id, err)
+		}
+		memory.SetHandler(node)
+		c.nodes[id] = node
+		c.machines[id] = machine
+	}
+
+	for _, id := range c.ids {
+		if err := c.nodes[id].Start(); err != nil {
+			tb.Fatalf("启动节点 %s 失败: %v", id, err)
+		}
+	}
+	tb.Cleanup(func() {
+		c.chaos.HealAll()
+		for _, node := range c.nodes {
+			node.Stop()
+		}
+	})
+	return c
+}
+
+// stableLeader 找出members中得到其余成员一致承认的领导者
+func (c *chaosCluster) stableLeader(members []raft.NodeID) *raft.Node {
+	for _, id := range members {
+		node := c.nodes[id]
+		metrics := node.GetMetrics()
+		if metrics.State != raft.Leader {
+			continue
+		}
+		agreed := true
+		for _, other := range members {
+			if other == id {
+				continue
+			}
+			m := c.nodes[other].GetMetrics()
+			if m.LeaderID != id || m.CurrentTerm != metrics.CurrentTerm {
+				agreed = false
+				break
+			}
+		}
+		if agreed {
+			return node
+		}
+	}
+	return nil
+}
+
+// waitLeader 等待members选出一致承认的领导者
+func (c *chaosCluster) waitLeader(tb testing.TB, members []raft.NodeID, timeout time.Duration) *raft.Node {
+	tb.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if leader := c.stableLeader(members); leader != nil {
+			return leader
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	tb.Fatalf("等待 %v 选出领导者超时", members)
+	return nil
+}
+
+// set 通过领导者写入键，返回nil表示写入已被应用（即已向客户端确认）
+func (c *chaosCluster) set(leader *raft.Node, key, value string, timeout time.Duration) error {
+	c.seq++
+	cmd := statemachine.Command{Type: "SET", Key: key, Value: value, RequestID: fmt.Sprintf("chaos-%d", c.seq)}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+
+	machine := c.machines[leader.GetID()]
+	waiter := machine.RegisterWaiter(context.Background(), cmd.RequestID)
+	if _, err := leader.ProposeWithIndex(data); err != nil {
+		machine.CancelWaiter(cmd.RequestID)
+		return err
+	}
+	select {
+	case result := <-waiter:
+		return result.Err
+	case <-time.After(timeout):
+		machine.CancelWaiter(cmd.RequestID)
+		return fmt.Errorf("等待 %s 应用超时", key)
+	}
+}
+
+// others 返回ids中除exclude之外的节点
+func others(ids []raft.NodeID, exclude ...raft.NodeID) []raft.NodeID {
+	skip := make(map[raft.NodeID]bool, len(exclude))
+	for _, id := range exclude {
+		skip[id] = true
+	}
+	var rest []raft.NodeID
+	for _, id := range ids {
+		if !skip[id] {
+			rest = append(rest, id)
+		}
+	}
+	return rest
+}
+
+// TestChaosLeaderElectionUnderPartition 领导者落在少数派一侧时多数派选出新领导者，
+// 旧领导者的写入无法提交；分区恢复后旧领导者退位，全集群承认新领导者
+func TestChaosLeaderElectionUnderPartition(t *testing.T) {
+	c := newChaosCluster(t, 5, nil)
+	oldLeader := c.waitLeader(t, c.ids, 5*time.Second)
+	oldTerm := oldLeader.GetMetrics().CurrentTerm
+
+	minority := []raft.NodeID{oldLeader.GetID(), others(c.ids, oldLeader.GetID())[0]}
+	majority := others(c.ids, minority...)
+	partition := c.chaos.Partition(minority, majority)
+
+	newLeader := c.waitLeader(t, majority, 5*time.Second)
+	if term := newLeader.GetMetrics().CurrentTerm; term <= oldTerm {
+		t.Fatalf("新领导者的任期 %d 应大于旧任期 %d", term, oldTerm)
+	}
+	if err := c.set(newLeader, "majority", "ok", 2*time.Second); err != nil {
+		t.Fatalf("多数派写入失败: %v", err)
+	}
+	if oldLeader.IsLeader() {
+		if err := c.set(oldLeader, "minority", "lost", 3*chaosElectionTimeout); err == nil {
+			t.Fatalf("少数派的写入不应被提交")
+		}
+	}
+
+	if !c.chaos.Heal(partition) {
+		t.Fatalf("撤销分区规则失败")
+	}
+	leader := c.waitLeader(t, c.ids, 5*time.Second)
+	if leader.GetID() == oldLeader.GetID() && leader.GetMetrics().CurrentTerm <= oldTerm {
+		t.Fatalf("分区恢复后旧领导者不应以旧任期继续领导")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, id := range c.ids {
+		for {
+			if _, ok := c.machines[id].Get("majority"); ok {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("节点 %s 未应用多数派的写入", id)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if _, ok := c.machines[id].Get("minority"); ok {
+			t.Errorf("节点 %s 应用了未提交的少数派写入", id)
+		}
+	}
+	if stats := c.chaos.Stats(); stats.Dropped == 0 {
+		t.Errorf("分区期间应有消息被丢弃: %+v", stats)
+	}
+}
+
+// TestChaosNoLostAcknowledgedWrites 在丢包、延迟、重复投递和领导者分区下，
+// 已确认的写入在分区恢复后出现在所有节点上
+func TestChaosNoLostAcknowledgedWrites(t *testing.T) {
+	c := newChaosCluster(t, 3, nil)
+	leader := c.waitLeader(t, c.ids, 5*time.Second)
+
+	c.chaos.DropPercent(10, transport.MessageAppendEntries)
+	c.chaos.Delay(time.Millisecond, 5*time.Millisecond)
+	c.chaos.Duplicate(20, transport.MessageAppendEntries, transport.MessageVote)
+
+	acked := make(map[string]string)
+	write := func(leader *raft.Node, from, to int, timeout time.Duration) {
+		for i := from; i < to; i++ {
+			key, value := fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)
+			if err := c.set(leader, key, value, timeout); err == nil {
+				acked[key] = value
+			}
+		}
+	}
+
+	write(leader, 0, 30, time.Second)
+
+	// 领导者被隔离期间继续向它写入，这些写入不会得到确认
+	isolate := c.chaos.Isolate(leader.GetID())
+	write(leader, 30, 35, chaosElectionTimeout)
+	newLeader := c.waitLeader(t, others(c.ids, leader.GetID()), 5*time.Second)
+	write(newLeader, 35, 65, time.Second)
+	c.chaos.Heal(isolate)
+
+	leader = c.waitLeader(t, c.ids, 5*time.Second)
+	write(leader, 65, 80, time.Second)
+	if len(acked) < 40 {
+		t.Fatalf("确认的写入过少: %d", len(acked))
+	}
+
+	c.chaos.HealAll()
+	deadline := time.Now().Add(10 * time.Second)
+	for _, id := range c.ids {
+		for key, value := range acked {
+			for {
+				got, ok := c.machines[id].Get(key)
+				if ok && got == value {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("节点 %s 丢失已确认的写入 %s: %v, %v", id, key, got, ok)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+	if stats := c.chaos.Stats(); stats.Duplicated == 0 || stats.Delayed == 0 {
+		t.Errorf("应有消息被延迟和重复投递: %+v", stats)
+	}
+}
+
+// TestChaosPreVoteTermInflation 被隔离的跟随者启用预投票时不会抬高任期，恢复后不打断领导者；
+// 关闭预投票时它不断发起选举抬高任期，恢复后迫使领导者退位
+func TestChaosPreVoteTermInflation(t *testing.T) {
+	for _, preVote := range []bool{true, false} {
+		t.Run(fmt.Sprintf("preVote=%v", preVote), func(t *testing.T) {
+			c := newChaosCluster(t, 3, func(config *raft.Config) {
+				config.EnablePreVote = preVote
+			})
+			leader := c.waitLeader(t, c.ids, 5*time.Second)
+			term := leader.GetMetrics().CurrentTerm
+
+			follower := c.nodes[others(c.ids, leader.GetID())[0]]
+			isolate := c.chaos.Isolate(follower.GetID())
+			time.Sleep(10 * chaosElectionTimeout)
+
+			isolatedTerm := follower.GetMetrics().CurrentTerm
+			if preVote && isolatedTerm != term {
+				t.Fatalf("启用预投票时被隔离节点的任期不应变化: %d -> %d", term, isolatedTerm)
+			}
+			if !preVote && isolatedTerm <= term+1 {
+				t.Fatalf("关闭预投票时被隔离节点应不断抬高任期: %d -> %d", term, isolatedTerm)
+			}
+
+			c.chaos.Heal(isolate)
+			time.Sleep(5 * chaosElectionTimeout)
+			current := c.waitLeader(t, c.ids, 5*time.Second)
+			currentTerm := current.GetMetrics().CurrentTerm
+
+			if preVote && (current.GetID() != leader.GetID() || currentTerm != term) {
+				t.Fatalf("启用预投票时恢复的节点不应打断领导者: %s@%d -> %s@%d", leader.GetID(), term, current.GetID(), currentTerm)
+			}
+			if !preVote && currentTerm < isolatedTerm {
+				t.Fatalf("关闭预投票时集群任期应被抬高到至少 %d，实际 %d", isolatedTerm, currentTerm)
+			}
+		})
+	}
+}
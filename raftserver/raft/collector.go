@@ -0,0 +1,69 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-11 10:32:17
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-11 10:32:17
+* @Description: ConcordKV Raft consensus - collector.go
+ */
+package raft
+
+import (
+	"sort"
+
+	"raftserver/metrics"
+)
+
+// Collect 实现metrics.Collector，输出节点状态、选举与快照计数，领导者附带各跟随者的复制进度
+// 节点ID由注册表的固定标签提供，跟随者以peer标签区分
+func (n *Node) Collect() []*metrics.Family {
+	m := n.GetMetrics()
+
+	isLeader := 0.0
+	if m.State == Leader {
+		isLeader = 1
+	}
+
+	families := []*metrics.Family{
+		metrics.NewGauge("raft_term", "Current Raft term.").With(float64(m.CurrentTerm)),
+		metrics.NewGauge("raft_commit_index", "Highest log index known to be committed.").With(float64(m.CommitIndex)),
+		metrics.NewGauge("raft_last_applied", "Highest log index applied to the state machine.").With(float64(m.LastApplied)),
+		metrics.NewGauge("raft_is_leader", "Whether this node is the leader (1) or not (0).").With(isLeader),
+		metrics.NewCounter("raft_elections_total", "Elections started by this node.").With(float64(m.ElectionCount)),
+		metrics.NewCounter("raft_snapshots_created_total", "Snapshots taken locally.").With(float64(m.Snapshot.SnapshotCount)),
+		metrics.NewCounter("raft_snapshots_installed_total", "Snapshots installed from the leader.").With(float64(m.Snapshot.InstalledCount)),
+		metrics.NewCounter("raft_snapshots_sent_total", "Snapshots sent to followers.").With(float64(m.Snapshot.SentCount)),
+		metrics.NewCounter("raft_read_index_rounds_total", "ReadIndex confirmation rounds, excluding lease reads.").With(float64(m.ReadIndex.Rounds)),
+		metrics.NewCounter("raft_lease_reads_total", "Reads served under the leader lease.").With(float64(m.ReadIndex.LeaseReads)),
+		metrics.NewCounter("raft_read_index_failures_total", "Linearizable reads that failed.").With(float64(m.ReadIndex.Failures)),
+		metrics.NewCounter("raft_commit_delayed_entries_total", "Entries that reached a majority but waited for cross-DC acknowledgment.").With(float64(m.Commit.DelayedCommits)),
+	}
+
+	if len(m.Replication) == 0 {
+		return families
+	}
+
+	peers := make([]NodeID, 0, len(m.Replication))
+	for id := range m.Replication {
+		peers = append(peers, id)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+
+	matchIndex := metrics.NewGauge("raft_replication_match_index", "Highest log index known to be replicated on the peer.")
+	lagEntries := metrics.NewGauge("raft_replication_lag_entries", "Number of log entries the peer is behind the leader.")
+	lagSeconds := metrics.NewGauge("raft_replication_lag_seconds", "Estimated replication lag of the peer.")
+	catchUpRate := metrics.NewGauge("raft_replication_catchup_rate_bytes", "Recent catch-up replication rate to the peer in bytes per second.")
+	throttled := metrics.NewCounter("raft_replication_throttled_seconds_total", "Time catch-up replication to the peer spent waiting for the rate limiter.")
+	for _, id := range peers {
+		progress := m.Replication[id]
+		matchIndex.With(float64(progress.MatchIndex), "peer", string(id))
+		lagEntries.With(float64(progress.LagEntries), "peer", string(id))
+		lagSeconds.With(float64(progress.LagMillis)/1000, "peer", string(id))
+		catchUpRate.With(progress.CatchUpRate, "peer", string(id))
+		throttled.With(float64(progress.ThrottledMillis)/1000, "peer", string(id))
+	}
+	families = append(families, matchIndex, lagEntries, lagSeconds, catchUpRate, throttled)
+	if m.CatchUp != nil {
+		families = append(families, metrics.NewGauge("raft_catchup_rate_factor", "Fraction of the configured catch-up rate currently allowed.").With(m.CatchUp.RateFactor))
+	}
+	return families
+}
@@ -0,0 +1,71 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-11 10:32:17
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-11 10:32:17
+* @Description: ConcordKV Raft consensus - collector_test.go
+ */
+package raft_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"raftserver/metrics"
+)
+
+// TestNodeCollect 领导者输出提交索引、选举次数和各跟随者的复制进度，节点ID来自注册表的固定标签
+func TestNodeCollect(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	leader, stop := newMemCluster(t, 5*time.Millisecond, 0)
+	defer stop()
+
+	index, err := leader.ProposeWithIndex([]byte(`{"type":"SET","key":"k","value":"v"}`))
+	if err != nil {
+		t.Fatalf("提议失败: %v", err)
+	}
+	waitApplied(t, leader, index, 5*time.Second)
+
+	registry := metrics.NewRegistry(metrics.LabelNodeID, string(leader.GetID()))
+	registry.Register("raft", leader)
+
+	var buf bytes.Buffer
+	if err := registry.WriteText(&buf); err != nil {
+		t.Fatalf("输出指标失败: %v", err)
+	}
+	text := buf.String()
+
+	node := fmt.Sprintf("node_id=%q", string(leader.GetID()))
+	for _, want := range []string{
+		"# TYPE raft_commit_index gauge\n",
+		fmt.Sprintf("raft_is_leader{%s} 1\n", node),
+		"# TYPE raft_elections_total counter\n",
+		fmt.Sprintf("raft_replication_match_index{%s,peer=", node),
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("输出缺少 %q:\n%s", want, text)
+		}
+	}
+
+	// 提交索引与节点指标一致，选举次数至少为1
+	for _, family := range registry.Gather() {
+		switch family.Name {
+		case "raft_commit_index":
+			if family.Samples[0].Value < float64(index) {
+				t.Errorf("raft_commit_index = %v，期望不小于 %d", family.Samples[0].Value, index)
+			}
+		case "raft_elections_total":
+			if family.Samples[0].Value < 1 {
+				t.Errorf("领导者的raft_elections_total = %v，期望至少为1", family.Samples[0].Value)
+			}
+		}
+	}
+}
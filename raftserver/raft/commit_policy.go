@@ -0,0 +1,128 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-5 15:40:21
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-5 15:40:21
+* @Description: ConcordKV Raft consensus server - commit_policy.go
+ */
+package raft
+
+import (
+	"fmt"
+	"time"
+)
+
+// CommitPolicy 多数据中心模式下领导者推进提交索引的仲裁策略
+type CommitPolicy string
+
+const (
+	// CommitPolicyMajority 所有投票成员的多数派确认即可提交
+	CommitPolicyMajority CommitPolicy = "majority"
+
+	// CommitPolicyMajorityPlusRemote 多数派确认，且至少一个非本地DC的跟随者已复制该条目
+	// 本地DC整体丢失时已确认的写入仍保存在其他DC
+	CommitPolicyMajorityPlusRemote CommitPolicy = "majority-plus-remote"
+
+	// CommitPolicyPerDCMajority 每个拥有投票成员的DC内都需多数派确认
+	CommitPolicyPerDCMajority CommitPolicy = "per-dc-majority"
+)
+
+// ParseCommitPolicy 解析提交策略，为空时使用majority
+func ParseCommitPolicy(s string) (CommitPolicy, error) {
+	switch policy := CommitPolicy(s); policy {
+	case "":
+		return CommitPolicyMajority, nil
+	case CommitPolicyMajority, CommitPolicyMajorityPlusRemote, CommitPolicyPerDCMajority:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("未知的提交策略 %q，可选值：majority、majority-plus-remote、per-dc-majority", s)
+	}
+}
+
+// CommitPolicyMetrics 提交策略指标
+type CommitPolicyMetrics struct {
+	Policy         CommitPolicy `json:"policy"`         // 当前生效的提交策略
+	DelayedCommits int64        `json:"delayedCommits"` // 已获多数派确认但等待跨DC确认的日志条目数
+	DelayedWait    float64      `json:"delayedWait"`    // 等待跨DC确认的累计时间(ms)
+}
+
+// commitPolicy 当前生效的提交策略，未启用多数据中心模式时总是majority
+func (n *Node) commitPolicy() CommitPolicy {
+	if n.config.MultiDC == nil || !n.config.MultiDC.Enabled || n.config.MultiDC.CommitPolicy == "" {
+		return CommitPolicyMajority
+	}
+	return n.config.MultiDC.CommitPolicy
+}
+
+// localDataCenterLocked 领导者所在的数据中心，成员配置中未标注时使用LocalDataCenter（调用方需持有锁）
+func (n *Node) localDataCenterLocked() DataCenterID {
+	return n.config.localDataCenter()
+}
+
+// commitQuorumLocked 检查index是否获得所有投票成员的多数派确认，以及是否满足提交策略（调用方需持有锁）
+// 未标注数据中心的成员视为与领导者在同一DC，学习者的确认不计入
+func (n *Node) commitQuorumLocked(index LogIndex, policy CommitPolicy) (bool, bool) {
+	localDC := n.localDataCenterLocked()
+
+	acked := 1 // 领导者自己
+	dcTotal := map[DataCenterID]int{localDC: 1}
+	dcAcked := map[DataCenterID]int{localDC: 1}
+	for _, server := range n.config.Servers {
+		if server.ID == n.id || server.IsLearner {
+			continue
+		}
+		dc := server.DataCenter
+		if dc == "" {
+			dc = localDC
+		}
+		dcTotal[dc]++
+		if n.matchIndex[server.ID] >= index {
+			acked++
+			dcAcked[dc]++
+		}
+	}
+
+	majority := acked >= n.voterCountLocked()/2+1
+	switch policy {
+	case CommitPolicyMajorityPlusRemote:
+		// 没有远程DC的投票成员时退化为majority
+		if len(dcTotal) == 1 {
+			return majority, true
+		}
+		return majority, acked > dcAcked[localDC]
+	case CommitPolicyPerDCMajority:
+		for dc, total := range dcTotal {
+			if dcAcked[dc] < total/2+1 {
+				return majority, false
+			}
+		}
+	}
+	return majority, true
+}
+
+// recordCommitDelayLocked 记录已获多数派确认、因提交策略等待跨DC确认的条目（调用方需持有写锁）
+func (n *Node) recordCommitDelayLocked(index LogIndex) {
+	from := n.commitIndex
+	if n.commitDelayedIndex > from {
+		from = n.commitDelayedIndex
+	}
+	if index <= from {
+		return
+	}
+
+	if n.commitDelayedSince.IsZero() {
+		n.commitDelayedSince = n.clock.Now()
+	}
+	n.commitMetrics.DelayedCommits += int64(index - from)
+	n.commitDelayedIndex = index
+	n.updateMetricsLocked()
+}
+
+// finishCommitDelayLocked 提交索引推进后，等待中的条目全部提交时累计等待时间（调用方需持有写锁）
+func (n *Node) finishCommitDelayLocked() {
+	if n.commitDelayedSince.IsZero() || n.commitIndex < n.commitDelayedIndex {
+		return
+	}
+	n.commitMetrics.DelayedWait += float64(n.clock.Now().Sub(n.commitDelayedSince).Microseconds()) / 1000
+	n.commitDelayedSince = time.Time{}
+}
@@ -0,0 +1,156 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-5 15:40:21
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-5 15:40:21
+* @Description: ConcordKV Raft consensus server - commit_policy_test.go
+ */
+package raft_test
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+)
+
+// newCommitPolicyCluster 创建node1、node2位于dc1，node3位于dc2的集群，只有node1发起选举
+func newCommitPolicyCluster(t *testing.T, policy raft.CommitPolicy) (*memNetwork, *raft.Node, func()) {
+	t.Helper()
+
+	servers := []raft.Server{
+		{ID: "node1", DataCenter: "dc1"},
+		{ID: "node2", DataCenter: "dc1"},
+		{ID: "node3", DataCenter: "dc2"},
+	}
+	network, leader, stop := newMemClusterWithConfig(t, 2*time.Millisecond, func(config *raft.Config) {
+		config.Servers = servers
+		local := raft.DataCenterID("dc1")
+		if config.NodeID == "node3" {
+			local = "dc2"
+		}
+		if config.NodeID != "node1" {
+			config.ElectionTimeout = time.Hour
+		}
+		config.MultiDC = &raft.MultiDCConfig{
+			Enabled:             true,
+			LocalDataCenter:     &raft.DataCenterConfig{ID: local},
+			HealthCheckInterval: time.Hour,
+			CommitPolicy:        policy,
+		}
+	})
+	if leader.GetID() != "node1" {
+		stop()
+		t.Fatalf("领导者应为node1，实际为 %s", leader.GetID())
+	}
+	return network, leader, stop
+}
+
+// partition 丢弃进出指定节点的所有请求
+func partition(network *memNetwork, ids ...raft.NodeID) {
+	isolated := make(map[raft.NodeID]bool, len(ids))
+	for _, id := range ids {
+		isolated[id] = true
+	}
+	network.setIntercept(func(from, to raft.NodeID, req interface{}) error {
+		if isolated[from] || isolated[to] {
+			return errors.New("网络分区")
+		}
+		return nil
+	})
+}
+
+// assertNotCommitted 等待若干心跳周期，确认index仍未提交
+func assertNotCommitted(t *testing.T, leader *raft.Node, index raft.LogIndex) {
+	t.Helper()
+
+	time.Sleep(100 * time.Millisecond)
+	if commit := leader.GetMetrics().CommitIndex; commit >= index {
+		t.Fatalf("条目 %d 不应被提交，commitIndex = %d", index, commit)
+	}
+}
+
+// TestCommitPolicyMajorityPlusRemote 远程DC的跟随者全部分区时，本地DC的多数派不能提交，恢复后提交并统计等待
+func TestCommitPolicyMajorityPlusRemote(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	network, leader, stop := newCommitPolicyCluster(t, raft.CommitPolicyMajorityPlusRemote)
+	defer stop()
+
+	// 选举后的空条目可能先被本地DC确认而短暂等待，只统计分区之后的增量
+	before := leader.GetMetrics().Commit
+	partition(network, "node3")
+	index, err := leader.ProposeWithIndex([]byte(`{"type":"SET","key":"k","value":"v"}`))
+	if err != nil {
+		t.Fatalf("提议失败: %v", err)
+	}
+	assertNotCommitted(t, leader, index)
+
+	commit := leader.GetMetrics().Commit
+	if commit.Policy != raft.CommitPolicyMajorityPlusRemote || commit.DelayedCommits-before.DelayedCommits != 1 {
+		t.Fatalf("提交策略指标不正确: %+v", commit)
+	}
+
+	network.setIntercept(nil)
+	waitApplied(t, leader, index, 5*time.Second)
+	if commit := leader.GetMetrics().Commit; commit.DelayedCommits-before.DelayedCommits != 1 || commit.DelayedWait-before.DelayedWait < 50 {
+		t.Fatalf("恢复后应累计等待时间: %+v", commit)
+	}
+}
+
+// TestCommitPolicyMajorityIgnoresRemote majority策略下本地DC的多数派即可提交
+func TestCommitPolicyMajorityIgnoresRemote(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	network, leader, stop := newCommitPolicyCluster(t, "")
+	defer stop()
+
+	partition(network, "node3")
+	index, err := leader.ProposeWithIndex([]byte(`{"type":"SET","key":"k","value":"v"}`))
+	if err != nil {
+		t.Fatalf("提议失败: %v", err)
+	}
+	waitApplied(t, leader, index, 5*time.Second)
+
+	if commit := leader.GetMetrics().Commit; commit.Policy != raft.CommitPolicyMajority || commit.DelayedCommits != 0 {
+		t.Fatalf("提交策略指标不正确: %+v", commit)
+	}
+}
+
+// TestCommitPolicyPerDCMajority per-dc-majority策略下每个DC都需多数派确认，跨DC的多数派不足以提交
+func TestCommitPolicyPerDCMajority(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	network, leader, stop := newCommitPolicyCluster(t, raft.CommitPolicyPerDCMajority)
+	defer stop()
+
+	// node1与node3构成全局多数派，但dc1的2个成员中只有1个确认
+	partition(network, "node2")
+	index, err := leader.ProposeWithIndex([]byte(`{"type":"SET","key":"k","value":"v"}`))
+	if err != nil {
+		t.Fatalf("提议失败: %v", err)
+	}
+	assertNotCommitted(t, leader, index)
+
+	network.setIntercept(nil)
+	waitApplied(t, leader, index, 5*time.Second)
+}
+
+// TestCommitPolicyRejectsUnknown 只接受已知的提交策略，空值为majority
+func TestCommitPolicyRejectsUnknown(t *testing.T) {
+	if _, err := raft.ParseCommitPolicy("quorum"); err == nil {
+		t.Fatalf("未知的提交策略应报错")
+	}
+	if policy, err := raft.ParseCommitPolicy(""); err != nil || policy != raft.CommitPolicyMajority {
+		t.Fatalf("空策略应为majority，实际 %q, %v", policy, err)
+	}
+}
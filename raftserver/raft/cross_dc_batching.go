@@ -0,0 +1,154 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-5 20:18:36
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-5 20:18:36
+* @Description: ConcordKV 跨数据中心复制的自适应批次大小
+ */
+
+package raft
+
+import "time"
+
+const (
+	// 跨DC复制批次大小(条目数)的默认初始值与调整范围
+	defaultCrossDCBatchSize    = 100
+	defaultCrossDCMinBatchSize = 10
+	defaultCrossDCMaxBatchSize = 10000
+
+	// batchSampleWindow 拟合传播延迟与带宽使用的最近样本数
+	batchSampleWindow = 16
+)
+
+// batchSample 一个成功批次的发送字节数与往返延迟
+type batchSample struct {
+	bytes float64
+	rtt   time.Duration
+}
+
+// adaptiveBatchSizer 按测得的往返延迟与带宽调整单个DC的复制批次大小
+// 每个DC同时只有一个批次在途，往返延迟 = 传播延迟 + 字节数/带宽，对最近的样本做最小二乘拟合得到两者，
+// 批次大小朝 带宽×传播延迟 对应的条目数调整（每次至多翻倍），失败或超时时减半
+type adaptiveBatchSizer struct {
+	minSize int
+	maxSize int
+	size    int
+
+	samples [batchSampleWindow]batchSample
+	count   int
+	next    int
+
+	bytesPerEntry float64       // 平滑的每条目字节数（压缩后）
+	rtt           time.Duration // 平滑的往返延迟
+	propagation   time.Duration // 拟合的传播延迟
+	bytesPerSec   float64       // 拟合的带宽，0表示样本不足以拟合
+}
+
+// newAdaptiveBatchSizer 创建批次大小控制器，initial限制在[minSize, maxSize]内
+func newAdaptiveBatchSizer(minSize, maxSize, initial int) *adaptiveBatchSizer {
+	s := &adaptiveBatchSizer{minSize: minSize, maxSize: maxSize}
+	s.size = s.clamp(initial)
+	return s
+}
+
+func (s *adaptiveBatchSizer) clamp(size int) int {
+	if size < s.minSize {
+		return s.minSize
+	}
+	if size > s.maxSize {
+		return s.maxSize
+	}
+	return size
+}
+
+// observe 记录一个成功批次，entries与bytes为批次的条目数和发送字节数
+// 未填满的批次受限于待复制的条目数，只作为拟合样本，不调整批次大小
+func (s *adaptiveBatchSizer) observe(entries, bytes int, rtt time.Duration) {
+	if entries <= 0 || rtt <= 0 {
+		return
+	}
+
+	perEntry := float64(bytes) / float64(entries)
+	if s.bytesPerEntry == 0 {
+		s.bytesPerEntry = perEntry
+		s.rtt = rtt
+	} else {
+		s.bytesPerEntry += (perEntry - s.bytesPerEntry) / 8
+		s.rtt += (rtt - s.rtt) / 8
+	}
+
+	s.samples[s.next] = batchSample{bytes: float64(bytes), rtt: rtt}
+	s.next = (s.next + 1) % batchSampleWindow
+	if s.count < batchSampleWindow {
+		s.count++
+	}
+	s.fit()
+
+	if entries < s.size || s.bytesPerEntry <= 0 {
+		return
+	}
+
+	// 尚无估计时翻倍，使样本的字节数出现差异以便拟合
+	target := 2 * s.size
+	if s.bytesPerSec > 0 {
+		target = int(s.bytesPerSec * s.propagation.Seconds() / s.bytesPerEntry)
+	}
+	if target > 2*s.size {
+		target = 2 * s.size
+	}
+	s.size = s.clamp(target)
+}
+
+// fit 对窗口内的样本拟合 往返延迟 = 传播延迟 + 字节数/带宽
+// 样本字节数差异过小或拟合结果不合理时保留上一次的估计
+func (s *adaptiveBatchSizer) fit() {
+	if s.count < 2 {
+		return
+	}
+
+	var sumX, sumY, minX, maxX float64
+	for i := 0; i < s.count; i++ {
+		x := s.samples[i].bytes
+		sumX += x
+		sumY += s.samples[i].rtt.Seconds()
+		if i == 0 || x < minX {
+			minX = x
+		}
+		if x > maxX {
+			maxX = x
+		}
+	}
+	n := float64(s.count)
+	meanX, meanY := sumX/n, sumY/n
+	if maxX-minX < meanX/4 {
+		return
+	}
+
+	var sxx, sxy float64
+	for i := 0; i < s.count; i++ {
+		dx := s.samples[i].bytes - meanX
+		sxx += dx * dx
+		sxy += dx * (s.samples[i].rtt.Seconds() - meanY)
+	}
+	slope := sxy / sxx
+	if slope <= 0 {
+		return
+	}
+
+	intercept := meanY - slope*meanX
+	if intercept < 0 {
+		intercept = 0
+	}
+	s.bytesPerSec = 1 / slope
+	s.propagation = time.Duration(intercept * float64(time.Second))
+}
+
+// backoff 批次失败或超时，批次大小减半
+func (s *adaptiveBatchSizer) backoff() {
+	s.size = s.clamp(s.size / 2)
+}
+
+// bandwidth 拟合的带宽(字节/秒)，样本不足时为0
+func (s *adaptiveBatchSizer) bandwidth() float64 {
+	return s.bytesPerSec
+}
@@ -0,0 +1,146 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-5 20:18:36
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-5 20:18:36
+* @Description: ConcordKV 跨数据中心复制的自适应批次大小测试
+ */
+package raft_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+)
+
+// simLink 模拟的跨DC链路：每个批次耗时 传播延迟 + 字节数/带宽
+type simLink struct {
+	latency   time.Duration
+	bandwidth float64 // 字节/秒
+}
+
+// simTransport 按目标节点的链路模拟延迟与带宽的传输层，只接收压缩追加请求
+type simTransport struct {
+	links map[raft.NodeID]simLink
+}
+
+func (t *simTransport) SendCompressedAppendEntries(ctx context.Context, target raft.NodeID, req *raft.CompressedAppendEntriesRequest) (*raft.CompressedAppendEntriesResponse, error) {
+	link := t.links[target]
+	delay := link.latency + time.Duration(float64(len(req.CompressedData))/link.bandwidth*float64(time.Second))
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return &raft.CompressedAppendEntriesResponse{Success: true, BatchID: req.BatchID, ProcessedCount: req.BatchSize}, nil
+}
+
+func (t *simTransport) SendVoteRequest(ctx context.Context, target raft.NodeID, req *raft.VoteRequest) (*raft.VoteResponse, error) {
+	return nil, fmt.Errorf("不支持")
+}
+
+func (t *simTransport) SendAppendEntries(ctx context.Context, target raft.NodeID, req *raft.AppendEntriesRequest) (*raft.AppendEntriesResponse, error) {
+	return nil, fmt.Errorf("不支持")
+}
+
+func (t *simTransport) SendInstallSnapshot(ctx context.Context, target raft.NodeID, req *raft.InstallSnapshotRequest) (*raft.InstallSnapshotResponse, error) {
+	return nil, fmt.Errorf("不支持")
+}
+
+func (t *simTransport) SendTimeoutNow(ctx context.Context, target raft.NodeID, req *raft.TimeoutNowRequest) (*raft.TimeoutNowResponse, error) {
+	return nil, fmt.Errorf("不支持")
+}
+
+func (t *simTransport) Start() error      { return nil }
+func (t *simTransport) Stop() error       { return nil }
+func (t *simTransport) LocalAddr() string { return "node1" }
+
+// TestCrossDCAdaptiveBatchSize 带宽相同时，高延迟DC的批次收敛到更大的大小（批次大小随 带宽×往返延迟 增长）
+func TestCrossDCAdaptiveBatchSize(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	const bandwidth = 1 << 20 // 1MiB/s
+	transport := &simTransport{links: map[raft.NodeID]simLink{
+		"near": {latency: 2 * time.Millisecond, bandwidth: bandwidth},
+		"far":  {latency: 40 * time.Millisecond, bandwidth: bandwidth},
+	}}
+	config := &raft.Config{
+		NodeID: "node1",
+		Servers: []raft.Server{
+			{ID: "node1", DataCenter: "dc1"},
+			{ID: "near", DataCenter: "dc-near"},
+			{ID: "far", DataCenter: "dc-far"},
+		},
+		MultiDC: &raft.MultiDCConfig{
+			Enabled:             true,
+			LocalDataCenter:     &raft.DataCenterConfig{ID: "dc1"},
+			CrossDCCompression:  raft.CompressionNone,
+			CrossDCMinBatchSize: 5,
+			CrossDCMaxBatchSize: 5000,
+		},
+	}
+	manager := raft.NewCrossDCReplicationManager("node1", config, transport)
+	if err := manager.Start(); err != nil {
+		t.Fatalf("启动跨DC复制管理器失败: %v", err)
+	}
+	defer manager.Stop()
+
+	if stats := manager.GetReplicationStats(); stats.DCStats["dc-near"].BatchSize != 100 || stats.DCStats["dc-far"].BatchSize != 100 {
+		t.Fatalf("初始批次大小应为100: near=%d far=%d", stats.DCStats["dc-near"].BatchSize, stats.DCStats["dc-far"].BatchSize)
+	}
+
+	// 持续写入超过链路带宽的日志，使批次总能填满
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		var index raft.LogIndex
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			entries := make([]raft.LogEntry, 20)
+			for i := range entries {
+				index++
+				entries[i] = raft.LogEntry{Index: index, Term: 1, Data: []byte(fmt.Sprintf("%068d", index))}
+			}
+			manager.ReplicateEntries(entries)
+		}
+	}()
+
+	// 每条目约100字节：near的 带宽×延迟 约20条，far约400条
+	var near, far *raft.DCReplicationStat
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := manager.GetReplicationStats()
+		near, far = stats.DCStats["dc-near"], stats.DCStats["dc-far"]
+		if far.BatchSize >= 300 && near.BatchSize <= 60 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("批次大小未收敛: near=%+v far=%+v", near, far)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if far.BatchSize < 4*near.BatchSize {
+		t.Fatalf("高延迟DC的批次应明显更大: near=%d far=%d", near.BatchSize, far.BatchSize)
+	}
+	if far.RoundTripTime <= near.RoundTripTime || near.Bandwidth <= 0 || far.Bandwidth <= 0 {
+		t.Fatalf("往返延迟或带宽统计不正确: near=%+v far=%+v", near, far)
+	}
+	if status := manager.GetDCReplicationStatus(); status["dc-far"].BatchSize < 100 {
+		t.Fatalf("复制状态中的批次大小 = %d", status["dc-far"].BatchSize)
+	}
+}
@@ -12,10 +12,33 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
+	"hash/crc32"
+	"io"
 	"sync"
 	"time"
+
+	"raftserver/logging"
+	"raftserver/queue"
+)
+
+// defaultReplicationQueueConfig 复制批次队列的默认配置，队列满时对调用方形成背压
+var defaultReplicationQueueConfig = queue.Config{Capacity: 1000, Policy: queue.BlockWithTimeout, BlockTimeout: time.Second}
+
+// 跨DC复制批次的压缩算法
+const (
+	CompressionNone = "none" // 不压缩，负载为序列化后的原始数据
+	CompressionGzip = "gzip" // gzip压缩，默认算法
+)
+
+var (
+	// ErrChecksumMismatch 复制批次的负载无法解码或校验和不匹配，发送方应重新发送该批次
+	ErrChecksumMismatch = errors.New("复制批次校验和不匹配")
+
+	// ErrUnsupportedCompression 不支持的压缩算法
+	ErrUnsupportedCompression = errors.New("不支持的压缩算法")
 )
 
 // CrossDCReplicationManager 跨数据中心复制管理器
@@ -26,17 +49,22 @@ type CrossDCReplicationManager struct {
 	nodeID    NodeID
 	config    *Config
 	transport Transport
-	logger    *log.Logger
+	logger    logging.Logger
 
 	// 复制状态
 	targetDCs        map[DataCenterID]*DCReplicationTarget
-	replicationQueue chan *ReplicationBatch
+	replicationQueue *queue.Queue
 
 	// 性能优化
-	compressionEnabled bool
-	batchSize          int
-	batchTimeout       time.Duration
-	maxRetries         int
+	compression  string // 批次压缩算法
+	batchSize    int    // 各DC批次大小的初始值，之后按测得的往返延迟与带宽自适应调整
+	minBatchSize int
+	maxBatchSize int
+	batchTimeout time.Duration
+	maxRetries   int
+
+	// raftState 返回发送批次时的任期、前一条目任期与提交索引，为nil时均按0发送
+	raftState func(prevLogIndex LogIndex) (Term, Term, LogIndex)
 
 	// 控制
 	ctx    context.Context
@@ -71,16 +99,25 @@ type DCReplicationTarget struct {
 	// 批量缓冲
 	PendingEntries []LogEntry
 	LastBatchSent  time.Time
+
+	// BatchSize 当前生效的批次大小(条目数)
+	BatchSize int
+
+	sizer         *adaptiveBatchSizer // 批次大小控制器
+	inflight      bool                // 是否有批次在途（含等待重试），每个DC同时只发送一个批次
+	lastQueuedIdx LogIndex            // 已加入待复制缓冲的最大索引
 }
 
 // ReplicationBatch 复制批次
 type ReplicationBatch struct {
-	TargetDC       DataCenterID
-	Entries        []LogEntry
-	CompressedData []byte
-	Checksum       uint32
-	CreatedAt      time.Time
-	RetryCount     int
+	TargetDC        DataCenterID
+	Entries         []LogEntry
+	CompressedData  []byte // 压缩后的负载，压缩算法为none时即序列化数据
+	OriginalSize    int    // 压缩前的字节数
+	CompressionType string
+	Checksum        uint32 // 压缩前数据的CRC32
+	CreatedAt       time.Time
+	RetryCount      int
 }
 
 // CrossDCReplicationStats 跨DC复制统计
@@ -104,6 +141,13 @@ type CrossDCReplicationStats struct {
 
 	// 按DC统计
 	DCStats map[DataCenterID]*DCReplicationStat
+
+	// 复制批次队列统计
+	Queue queue.Stats
+
+	// 压缩前后的累计字节数，用于计算CompressionRatio
+	originalBytes   int64
+	compressedBytes int64
 }
 
 // DCReplicationStat 单个DC复制统计
@@ -114,6 +158,11 @@ type DCReplicationStat struct {
 	AverageLatency    time.Duration
 	ErrorCount        int64
 	LastSuccessTime   time.Time
+
+	// 自适应批次：当前生效的批次大小(条目数)、平滑往返延迟与估计带宽(字节/秒)
+	BatchSize     int
+	RoundTripTime time.Duration
+	Bandwidth     float64
 }
 
 // CompressedAppendEntriesRequest 压缩的AppendEntries请求
@@ -166,25 +215,51 @@ type CompressedAppendEntriesResponse struct {
 func NewCrossDCReplicationManager(nodeID NodeID, config *Config, transport Transport) *CrossDCReplicationManager {
 	ctx, cancel := context.WithCancel(context.Background())
 
+	compression := CompressionGzip
+	minBatchSize, maxBatchSize := defaultCrossDCMinBatchSize, defaultCrossDCMaxBatchSize
+	if config.MultiDC != nil {
+		if config.MultiDC.CrossDCCompression != "" {
+			compression = config.MultiDC.CrossDCCompression
+		}
+		if config.MultiDC.CrossDCMinBatchSize > 0 {
+			minBatchSize = config.MultiDC.CrossDCMinBatchSize
+		}
+		if config.MultiDC.CrossDCMaxBatchSize > 0 {
+			maxBatchSize = config.MultiDC.CrossDCMaxBatchSize
+		}
+	}
+	if maxBatchSize < minBatchSize {
+		maxBatchSize = minBatchSize
+	}
+
 	manager := &CrossDCReplicationManager{
-		nodeID:             nodeID,
-		config:             config,
-		transport:          transport,
-		logger:             log.New(log.Writer(), fmt.Sprintf("[cross-dc-%s] ", nodeID), log.LstdFlags),
-		targetDCs:          make(map[DataCenterID]*DCReplicationTarget),
-		replicationQueue:   make(chan *ReplicationBatch, 1000),
-		compressionEnabled: true,
-		batchSize:          100,
-		batchTimeout:       time.Millisecond * 50,
-		maxRetries:         3,
-		ctx:                ctx,
-		cancel:             cancel,
-		stopCh:             make(chan struct{}),
+		nodeID:       nodeID,
+		config:       config,
+		transport:    transport,
+		logger:       config.ComponentLogger("cross-dc-replication"),
+		targetDCs:    make(map[DataCenterID]*DCReplicationTarget),
+		compression:  compression,
+		batchSize:    defaultCrossDCBatchSize,
+		minBatchSize: minBatchSize,
+		maxBatchSize: maxBatchSize,
+		batchTimeout: time.Millisecond * 50,
+		maxRetries:   3,
+		ctx:          ctx,
+		cancel:       cancel,
+		stopCh:       make(chan struct{}),
 		stats: &CrossDCReplicationStats{
 			DCStats: make(map[DataCenterID]*DCReplicationStat),
 		},
 	}
 
+	var queueConfig queue.Config
+	if config.MultiDC != nil {
+		queueConfig = config.MultiDC.ReplicationQueue
+	}
+	manager.replicationQueue = queue.New("cross-dc-replication",
+		queueConfig.WithDefaults(defaultReplicationQueueConfig),
+		queue.Options{OnDrop: func(item interface{}) { manager.requeueBatch(item.(*ReplicationBatch)) }})
+
 	// 初始化目标DC
 	if config.MultiDC != nil && config.MultiDC.Enabled {
 		manager.initializeTargetDCs()
@@ -212,6 +287,7 @@ func (m *CrossDCReplicationManager) initializeTargetDCs() {
 			isPrimary = dcConfig.IsPrimary
 		}
 
+		sizer := newAdaptiveBatchSizer(m.minBatchSize, m.maxBatchSize, m.batchSize)
 		target := &DCReplicationTarget{
 			DataCenter:          dcID,
 			Nodes:               nodes,
@@ -221,20 +297,22 @@ func (m *CrossDCReplicationManager) initializeTargetDCs() {
 			IsConnected:         true,
 			PendingEntries:      make([]LogEntry, 0),
 			RetryBackoff:        time.Millisecond * 100,
+			BatchSize:           sizer.size,
+			sizer:               sizer,
 		}
 
 		m.targetDCs[dcID] = target
 
 		// 初始化统计信息
-		m.stats.DCStats[dcID] = &DCReplicationStat{}
+		m.stats.DCStats[dcID] = &DCReplicationStat{BatchSize: sizer.size}
 
-		m.logger.Printf("初始化复制目标: DC=%s, 节点数=%d, 主DC=%v", dcID, len(nodes), isPrimary)
+		m.logger.Info("初始化复制目标", "target_dc", dcID, "nodes", len(nodes), "primary", isPrimary)
 	}
 }
 
 // Start 启动跨DC复制管理器
 func (m *CrossDCReplicationManager) Start() error {
-	m.logger.Printf("启动跨DC复制管理器")
+	m.logger.Info("启动跨DC复制管理器")
 
 	// 启动复制工作线程
 	m.wg.Add(3)
@@ -247,71 +325,116 @@ func (m *CrossDCReplicationManager) Start() error {
 
 // Stop 停止跨DC复制管理器
 func (m *CrossDCReplicationManager) Stop() error {
-	m.logger.Printf("停止跨DC复制管理器")
+	m.logger.Info("停止跨DC复制管理器")
 
 	// 发送停止信号
 	close(m.stopCh)
 	m.cancel()
 
+	// 唤醒阻塞在入队上的调用方，之后的入队失败，批次留在待复制缓冲中
+	m.replicationQueue.Close()
+
 	// 等待工作线程结束
 	m.wg.Wait()
 
-	// 关闭队列
-	close(m.replicationQueue)
-
 	return nil
 }
 
 // ReplicateEntries 复制日志条目到其他数据中心
+// 条目先加入各DC的待复制缓冲，没有批次在途时立即按该DC当前的批次大小切出一个批次发送，
+// 其余条目在在途批次完成后继续发送
 func (m *CrossDCReplicationManager) ReplicateEntries(entries []LogEntry) error {
 	if len(entries) == 0 {
 		return nil
 	}
+	if m.ctx.Err() != nil {
+		return fmt.Errorf("复制管理器已停止")
+	}
 
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	// 为每个目标DC创建复制批次
 	for dcID, target := range m.targetDCs {
 		// 检查是否需要复制到此DC
 		if !m.shouldReplicateToDC(target, entries) {
 			continue
 		}
 
-		// 创建复制批次
-		batch := &ReplicationBatch{
-			TargetDC:   dcID,
-			Entries:    make([]LogEntry, len(entries)),
-			CreatedAt:  time.Now(),
-			RetryCount: 0,
-		}
-		copy(batch.Entries, entries)
-
-		// 如果启用压缩，压缩数据
-		if m.compressionEnabled {
-			if err := m.compressBatch(batch); err != nil {
-				m.logger.Printf("压缩批次失败: %v", err)
-				m.stats.mu.Lock()
-				m.stats.CompressionErrors++
-				m.stats.mu.Unlock()
-				continue
+		target.mu.Lock()
+		for _, entry := range entries {
+			if entry.Index > target.lastQueuedIdx && entry.Index > target.LastReplicatedIndex {
+				target.PendingEntries = append(target.PendingEntries, entry)
+				target.lastQueuedIdx = entry.Index
 			}
 		}
+		target.mu.Unlock()
 
-		// 发送到复制队列
-		select {
-		case m.replicationQueue <- batch:
-			m.logger.Printf("添加复制批次到队列: DC=%s, 条目数=%d", dcID, len(entries))
-		case <-m.ctx.Done():
-			return fmt.Errorf("复制管理器已停止")
-		default:
-			m.logger.Printf("复制队列已满，跳过批次: DC=%s", dcID)
-		}
+		m.dispatchBatch(dcID, target)
 	}
 
 	return nil
 }
 
+// dispatchBatch 目标DC没有批次在途时，从待复制缓冲切出不超过当前批次大小的条目，压缩后加入复制队列
+func (m *CrossDCReplicationManager) dispatchBatch(dcID DataCenterID, target *DCReplicationTarget) {
+	target.mu.Lock()
+	if target.inflight || len(target.PendingEntries) == 0 {
+		target.mu.Unlock()
+		return
+	}
+
+	n := len(target.PendingEntries)
+	if n > target.BatchSize {
+		n = target.BatchSize
+	}
+	batch := &ReplicationBatch{
+		TargetDC:   dcID,
+		Entries:    append([]LogEntry(nil), target.PendingEntries[:n]...),
+		CreatedAt:  time.Now(),
+		RetryCount: 0,
+	}
+	target.PendingEntries = append(target.PendingEntries[:0], target.PendingEntries[n:]...)
+	target.LastBatchSent = time.Now()
+	target.inflight = true
+	target.mu.Unlock()
+
+	// 序列化并压缩数据
+	if err := m.compressBatch(batch); err != nil {
+		m.logger.Error("压缩批次失败", logging.FieldError, err)
+		m.stats.mu.Lock()
+		m.stats.CompressionErrors++
+		m.stats.mu.Unlock()
+
+		target.mu.Lock()
+		target.inflight = false
+		target.mu.Unlock()
+		return
+	}
+
+	// 发送到复制队列，未能入队时放回缓冲，由批处理循环稍后重试
+	if err := m.replicationQueue.Enqueue(batch); err != nil {
+		m.logger.Warn("复制队列入队失败，暂缓批次", "target_dc", dcID, logging.FieldError, err)
+		m.requeueBatch(batch)
+		return
+	}
+	m.logger.Debug("添加复制批次到队列", "target_dc", dcID, "entries", len(batch.Entries))
+}
+
+// requeueBatch 把未能发送的批次放回目标DC待复制缓冲的头部，并允许切出下一个批次
+func (m *CrossDCReplicationManager) requeueBatch(batch *ReplicationBatch) {
+	m.mu.RLock()
+	target, exists := m.targetDCs[batch.TargetDC]
+	m.mu.RUnlock()
+	if !exists {
+		return
+	}
+
+	target.mu.Lock()
+	target.PendingEntries = append(batch.Entries, target.PendingEntries...)
+	target.inflight = false
+	target.mu.Unlock()
+}
+
 // shouldReplicateToDC 判断是否应该复制到指定DC
 func (m *CrossDCReplicationManager) shouldReplicateToDC(target *DCReplicationTarget, entries []LogEntry) bool {
 	target.mu.RLock()
@@ -336,65 +459,192 @@ func (m *CrossDCReplicationManager) shouldReplicateToDC(target *DCReplicationTar
 	return true
 }
 
-// compressBatch 压缩复制批次
+// compressBatch 序列化并压缩复制批次，校验和基于压缩前的数据计算
 func (m *CrossDCReplicationManager) compressBatch(batch *ReplicationBatch) error {
-	// 序列化日志条目
-	data, err := m.serializeEntries(batch.Entries)
+	data := encodeLogEntries(batch.Entries)
+	compressed, err := compressPayload(m.compression, data)
 	if err != nil {
-		return fmt.Errorf("序列化条目失败: %w", err)
+		return err
 	}
 
-	// 使用gzip压缩
-	var compressed bytes.Buffer
-	gzipWriter := gzip.NewWriter(&compressed)
+	batch.CompressedData = compressed
+	batch.OriginalSize = len(data)
+	batch.CompressionType = m.compression
+	batch.Checksum = crc32.ChecksumIEEE(data)
 
-	if _, err := gzipWriter.Write(data); err != nil {
-		gzipWriter.Close()
-		return fmt.Errorf("压缩数据失败: %w", err)
-	}
+	// 更新压缩比统计（压缩后/压缩前的累计字节数）
+	m.stats.mu.Lock()
+	m.stats.originalBytes += int64(len(data))
+	m.stats.compressedBytes += int64(len(compressed))
+	m.stats.CompressionRatio = float64(m.stats.compressedBytes) / float64(m.stats.originalBytes)
+	m.stats.mu.Unlock()
+
+	m.logger.Debug("批次压缩完成", "compression", m.compression, "bytes", len(data), "compressed_bytes", len(compressed))
+
+	return nil
+}
 
-	if err := gzipWriter.Close(); err != nil {
-		return fmt.Errorf("关闭压缩器失败: %w", err)
+// DecompressEntries 解压请求负载并校验CRC32，还原其中的日志条目
+// 负载无法解压、解码或校验和不匹配时返回ErrChecksumMismatch
+func DecompressEntries(req *CompressedAppendEntriesRequest) ([]LogEntry, error) {
+	compression := req.CompressionType
+	if !req.IsCompressed {
+		compression = CompressionNone
 	}
 
-	batch.CompressedData = compressed.Bytes()
-	batch.Checksum = m.calculateChecksum(data)
+	data, err := decompressPayload(compression, req.CompressedData, req.OriginalSize)
+	if err != nil {
+		if errors.Is(err, ErrUnsupportedCompression) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: 解压失败: %v", ErrChecksumMismatch, err)
+	}
+	if len(data) != req.OriginalSize {
+		return nil, fmt.Errorf("%w: 原始大小 %d, 实际 %d", ErrChecksumMismatch, req.OriginalSize, len(data))
+	}
+	if checksum := crc32.ChecksumIEEE(data); checksum != req.Checksum {
+		return nil, fmt.Errorf("%w: 期望 %08x, 实际 %08x", ErrChecksumMismatch, req.Checksum, checksum)
+	}
 
-	// 更新压缩比统计
-	compressionRatio := float64(len(batch.CompressedData)) / float64(len(data))
-	m.stats.mu.Lock()
-	m.stats.CompressionRatio = (m.stats.CompressionRatio + compressionRatio) / 2.0
-	m.stats.mu.Unlock()
+	entries, err := decodeLogEntries(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrChecksumMismatch, err)
+	}
+	return entries, nil
+}
 
-	m.logger.Printf("批次压缩完成: 原始大小=%d, 压缩后=%d, 压缩比=%.2f",
-		len(data), len(batch.CompressedData), compressionRatio)
+// CompressEntries 序列化日志条目并按指定算法压缩，填入请求的负载、原始大小、校验和与条目数
+// 与DecompressEntries互逆，供CrossDCReplicationManager之外的发送方构造压缩请求
+func CompressEntries(req *CompressedAppendEntriesRequest, entries []LogEntry, compression string) error {
+	data := encodeLogEntries(entries)
+	compressed, err := compressPayload(compression, data)
+	if err != nil {
+		return err
+	}
 
+	req.IsCompressed = compression != CompressionNone
+	req.CompressedData = compressed
+	req.OriginalSize = len(data)
+	req.CompressionType = compression
+	req.Checksum = crc32.ChecksumIEEE(data)
+	req.BatchSize = len(entries)
 	return nil
 }
 
-// serializeEntries 序列化日志条目
-func (m *CrossDCReplicationManager) serializeEntries(entries []LogEntry) ([]byte, error) {
-	// 简单的序列化实现，实际项目中可以使用protobuf或其他高效格式
-	var buffer bytes.Buffer
+// compressPayload 按指定算法压缩数据
+func compressPayload(compression string, data []byte) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		var compressed bytes.Buffer
+		gzipWriter := gzip.NewWriter(&compressed)
+		if _, err := gzipWriter.Write(data); err != nil {
+			gzipWriter.Close()
+			return nil, fmt.Errorf("压缩数据失败: %w", err)
+		}
+		if err := gzipWriter.Close(); err != nil {
+			return nil, fmt.Errorf("关闭压缩器失败: %w", err)
+		}
+		return compressed.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCompression, compression)
+	}
+}
+
+// decompressPayload 按指定算法解压数据，解压结果超过originalSize时停止读取
+func decompressPayload(compression string, data []byte, originalSize int) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+
+		// 多读一个字节以发现超出声明大小的负载
+		decompressed, err := io.ReadAll(io.LimitReader(gzipReader, int64(originalSize)+1))
+		if err != nil {
+			return nil, err
+		}
+		return decompressed, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCompression, compression)
+	}
+}
 
+// 序列化格式，整数均为大端序：
+//
+//	uint32 条目数 | 条目数 × (uint64 索引 | uint64 任期 | int64 时间戳纳秒 | uint32 类型 | uint32 数据长度 | 数据)
+//
+// 零值时间戳按0编码
+const logEntryHeaderSize = 32
+
+// encodeLogEntries 序列化日志条目
+func encodeLogEntries(entries []LogEntry) []byte {
+	size := 4
 	for _, entry := range entries {
-		// 写入条目数据（这里简化处理）
-		entryData := fmt.Sprintf("%d:%d:%d:%s\n",
-			entry.Index, entry.Term, entry.Type, string(entry.Data))
-		buffer.WriteString(entryData)
+		size += logEntryHeaderSize + len(entry.Data)
 	}
 
-	return buffer.Bytes(), nil
+	buf := make([]byte, size)
+	binary.BigEndian.PutUint32(buf, uint32(len(entries)))
+	off := 4
+	for _, entry := range entries {
+		var timestamp int64
+		if !entry.Timestamp.IsZero() {
+			timestamp = entry.Timestamp.UnixNano()
+		}
+		binary.BigEndian.PutUint64(buf[off:], uint64(entry.Index))
+		binary.BigEndian.PutUint64(buf[off+8:], uint64(entry.Term))
+		binary.BigEndian.PutUint64(buf[off+16:], uint64(timestamp))
+		binary.BigEndian.PutUint32(buf[off+24:], uint32(entry.Type))
+		binary.BigEndian.PutUint32(buf[off+28:], uint32(len(entry.Data)))
+		off += logEntryHeaderSize
+		off += copy(buf[off:], entry.Data)
+	}
+	return buf
 }
 
-// calculateChecksum 计算校验和
-func (m *CrossDCReplicationManager) calculateChecksum(data []byte) uint32 {
-	// 简单的校验和实现
-	var checksum uint32
-	for _, b := range data {
-		checksum += uint32(b)
+// decodeLogEntries 反序列化日志条目
+func decodeLogEntries(data []byte) ([]LogEntry, error) {
+	if len(data) < 4 {
+		return nil, errors.New("条目数缺失")
+	}
+	count := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if uint64(count)*logEntryHeaderSize > uint64(len(data)) {
+		return nil, fmt.Errorf("条目数 %d 超出负载长度", count)
 	}
-	return checksum
+
+	entries := make([]LogEntry, count)
+	for i := range entries {
+		if len(data) < logEntryHeaderSize {
+			return nil, errors.New("条目头不完整")
+		}
+		n := binary.BigEndian.Uint32(data[28:])
+		if uint64(len(data)-logEntryHeaderSize) < uint64(n) {
+			return nil, errors.New("条目数据不完整")
+		}
+
+		entry := &entries[i]
+		entry.Index = LogIndex(binary.BigEndian.Uint64(data))
+		entry.Term = Term(binary.BigEndian.Uint64(data[8:]))
+		if timestamp := int64(binary.BigEndian.Uint64(data[16:])); timestamp != 0 {
+			entry.Timestamp = time.Unix(0, timestamp)
+		}
+		entry.Type = EntryType(binary.BigEndian.Uint32(data[24:]))
+		if n > 0 {
+			entry.Data = append([]byte(nil), data[logEntryHeaderSize:logEntryHeaderSize+n]...)
+		}
+		data = data[logEntryHeaderSize+n:]
+	}
+	if len(data) != 0 {
+		return nil, errors.New("负载末尾有多余数据")
+	}
+	return entries, nil
 }
 
 // batchProcessingLoop 批量处理循环
@@ -415,47 +665,13 @@ func (m *CrossDCReplicationManager) batchProcessingLoop() {
 	}
 }
 
-// processPendingBatches 处理待处理的批次
+// processPendingBatches 处理待处理的批次，补发因队列已满而暂缓的条目
 func (m *CrossDCReplicationManager) processPendingBatches() {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
 	for dcID, target := range m.targetDCs {
-		target.mu.Lock()
-		if len(target.PendingEntries) > 0 {
-			// 创建批次
-			batch := &ReplicationBatch{
-				TargetDC:   dcID,
-				Entries:    make([]LogEntry, len(target.PendingEntries)),
-				CreatedAt:  time.Now(),
-				RetryCount: 0,
-			}
-			copy(batch.Entries, target.PendingEntries)
-
-			// 清空待处理条目
-			target.PendingEntries = target.PendingEntries[:0]
-			target.LastBatchSent = time.Now()
-
-			target.mu.Unlock()
-
-			// 压缩并发送
-			if m.compressionEnabled {
-				if err := m.compressBatch(batch); err != nil {
-					m.logger.Printf("压缩待处理批次失败: %v", err)
-					continue
-				}
-			}
-
-			// 发送到队列
-			select {
-			case m.replicationQueue <- batch:
-				m.logger.Printf("发送待处理批次: DC=%s, 条目数=%d", dcID, len(batch.Entries))
-			default:
-				m.logger.Printf("队列已满，丢弃待处理批次: DC=%s", dcID)
-			}
-		} else {
-			target.mu.Unlock()
-		}
+		m.dispatchBatch(dcID, target)
 	}
 }
 
@@ -467,23 +683,32 @@ func (m *CrossDCReplicationManager) replicationWorkerLoop() {
 		select {
 		case <-m.stopCh:
 			return
-		case batch := <-m.replicationQueue:
-			if batch == nil {
-				return // 队列已关闭
+		case <-m.replicationQueue.Ready():
+			item, ok := m.replicationQueue.TryDequeue()
+			if !ok {
+				continue
 			}
-			m.processBatch(batch)
+			batch := item.(*ReplicationBatch)
+			// 每个DC同时只有一个批次在途，不同DC的批次并发发送，避免高延迟DC阻塞其他DC
+			m.wg.Add(1)
+			go func() {
+				defer m.wg.Done()
+				m.processBatch(batch)
+			}()
 		}
 	}
 }
 
-// processBatch 处理复制批次
+// processBatch 处理复制批次，按发送结果调整目标DC的批次大小
 func (m *CrossDCReplicationManager) processBatch(batch *ReplicationBatch) {
 	startTime := time.Now()
 
 	// 获取目标DC的节点列表
+	m.mu.RLock()
 	target, exists := m.targetDCs[batch.TargetDC]
+	m.mu.RUnlock()
 	if !exists {
-		m.logger.Printf("目标DC不存在: %s", batch.TargetDC)
+		m.logger.Warn("目标DC不存在", "target_dc", batch.TargetDC)
 		return
 	}
 
@@ -495,11 +720,13 @@ func (m *CrossDCReplicationManager) processBatch(batch *ReplicationBatch) {
 	// 尝试发送到目标DC的节点
 	success := false
 	for _, nodeID := range nodes {
+		sendStart := time.Now()
 		if err := m.sendBatchToNode(batch, nodeID); err != nil {
-			m.logger.Printf("发送批次到节点失败: DC=%s, 节点=%s, 错误=%v",
-				batch.TargetDC, nodeID, err)
+			m.logger.Warn("发送批次到节点失败", "target_dc", batch.TargetDC, "peer", nodeID, logging.FieldError, err)
+			m.adjustBatchSize(target, batch, 0)
 			continue
 		}
+		m.adjustBatchSize(target, batch, time.Since(sendStart))
 		success = true
 		break // 成功发送到一个节点即可
 	}
@@ -507,58 +734,136 @@ func (m *CrossDCReplicationManager) processBatch(batch *ReplicationBatch) {
 	// 更新统计信息
 	m.updateReplicationStats(batch, success, time.Since(startTime))
 
-	// 如果失败且重试次数未达上限，重新入队
+	// 如果失败且重试次数未达上限，重新入队，批次仍视为在途以保证各DC的复制顺序
 	if !success && batch.RetryCount < m.maxRetries {
 		batch.RetryCount++
 
 		// 指数退避
 		retryDelay := time.Duration(1<<batch.RetryCount) * time.Millisecond * 100
 		time.AfterFunc(retryDelay, func() {
-			select {
-			case m.replicationQueue <- batch:
-				m.logger.Printf("重试复制批次: DC=%s, 重试次数=%d",
-					batch.TargetDC, batch.RetryCount)
-			case <-m.ctx.Done():
-				// 管理器已停止
+			if m.ctx.Err() != nil {
+				return // 管理器已停止
+			}
+			if err := m.replicationQueue.Enqueue(batch); err != nil {
+				m.logger.Warn("重试批次入队失败，放回待复制缓冲", "target_dc", batch.TargetDC, logging.FieldError, err)
+				m.requeueBatch(batch)
+				return
 			}
+			m.logger.Info("重试复制批次", "target_dc", batch.TargetDC, "retries", batch.RetryCount)
 		})
+		return
+	}
+
+	// 批次完成（成功或放弃重试），发送下一个批次
+	target.mu.Lock()
+	target.inflight = false
+	target.mu.Unlock()
+	m.dispatchBatch(batch.TargetDC, target)
+}
+
+// adjustBatchSize 按一次发送的结果调整目标DC的批次大小，rtt为0表示发送失败或超时
+func (m *CrossDCReplicationManager) adjustBatchSize(target *DCReplicationTarget, batch *ReplicationBatch, rtt time.Duration) {
+	target.mu.Lock()
+	if rtt > 0 {
+		target.sizer.observe(len(batch.Entries), len(batch.CompressedData), rtt)
+	} else {
+		target.sizer.backoff()
 	}
+	target.BatchSize = target.sizer.size
+	size, smoothedRTT, bandwidth := target.sizer.size, target.sizer.rtt, target.sizer.bandwidth()
+	target.mu.Unlock()
+
+	m.stats.mu.Lock()
+	if dcStat := m.stats.DCStats[batch.TargetDC]; dcStat != nil {
+		dcStat.BatchSize = size
+		dcStat.RoundTripTime = smoothedRTT
+		if bandwidth > 0 {
+			dcStat.Bandwidth = bandwidth
+		}
+	}
+	m.stats.mu.Unlock()
 }
 
-// sendBatchToNode 发送批次到指定节点
+// sendBatchToNode 发送批次到指定节点，传输层不支持压缩请求时退化为普通的追加日志请求
 func (m *CrossDCReplicationManager) sendBatchToNode(batch *ReplicationBatch, nodeID NodeID) error {
 	// 构造压缩的AppendEntries请求
 	req := &CompressedAppendEntriesRequest{
-		Term:            1, // 实际应该从Raft状态获取
 		LeaderID:        m.nodeID,
-		PrevLogIndex:    0, // 实际应该计算
-		PrevLogTerm:     0, // 实际应该计算
-		LeaderCommit:    0, // 实际应该从Raft状态获取
-		IsCompressed:    m.compressionEnabled,
+		PrevLogIndex:    batch.Entries[0].Index - 1,
+		IsCompressed:    batch.CompressionType != CompressionNone,
 		CompressedData:  batch.CompressedData,
-		OriginalSize:    len(batch.Entries),
-		CompressionType: "gzip",
+		OriginalSize:    batch.OriginalSize,
+		CompressionType: batch.CompressionType,
 		Checksum:        batch.Checksum,
-		BatchID:         fmt.Sprintf("%s-%d", batch.TargetDC, time.Now().UnixNano()),
+		BatchID:         fmt.Sprintf("%s-%d", batch.TargetDC, batch.CreatedAt.UnixNano()),
 		BatchSize:       len(batch.Entries),
-		SequenceNum:     1,
+		SequenceNum:     batch.RetryCount + 1,
 		SourceDC:        m.config.MultiDC.LocalDataCenter.ID,
 		TargetDC:        batch.TargetDC,
 		Priority:        m.getReplicationPriority(batch.TargetDC),
 	}
+	if m.raftState != nil {
+		req.Term, req.PrevLogTerm, req.LeaderCommit = m.raftState(req.PrevLogIndex)
+	}
 
-	// 发送请求（这里简化处理，实际需要实现网络发送）
 	ctx, cancel := context.WithTimeout(m.ctx, time.Second*5)
 	defer cancel()
 
-	// 模拟网络发送
-	_ = ctx
-	_ = req
+	var success bool
+	var err error
+	if sender, ok := m.transport.(CompressedAppendEntriesSender); ok {
+		var resp *CompressedAppendEntriesResponse
+		if resp, err = sender.SendCompressedAppendEntries(ctx, nodeID, req); err == nil {
+			success = resp.Success
+		}
+	} else {
+		var resp *AppendEntriesResponse
+		resp, err = m.transport.SendAppendEntries(ctx, nodeID, &AppendEntriesRequest{
+			Term:         req.Term,
+			LeaderID:     req.LeaderID,
+			PrevLogIndex: req.PrevLogIndex,
+			PrevLogTerm:  req.PrevLogTerm,
+			Entries:      batch.Entries,
+			LeaderCommit: req.LeaderCommit,
+		})
+		if err == nil {
+			success = resp.Success
+		}
+	}
+
+	if err != nil {
+		m.stats.mu.Lock()
+		switch {
+		case errors.Is(err, ErrChecksumMismatch):
+			m.stats.CompressionErrors++
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			m.stats.TimeoutErrors++
+		default:
+			m.stats.NetworkErrors++
+		}
+		m.stats.mu.Unlock()
+		return err
+	}
+	if !success {
+		return fmt.Errorf("节点拒绝批次 %s", req.BatchID)
+	}
+
+	last := batch.Entries[len(batch.Entries)-1]
+	m.mu.RLock()
+	target := m.targetDCs[batch.TargetDC]
+	m.mu.RUnlock()
+	target.mu.Lock()
+	if last.Index > target.LastReplicatedIndex {
+		target.LastReplicatedIndex = last.Index
+		target.LastReplicatedTerm = last.Term
+		target.ReplicationLag = time.Since(batch.CreatedAt)
+	}
+	target.LastHeartbeat = time.Now()
+	target.mu.Unlock()
 
-	m.logger.Printf("发送压缩批次到节点: 节点=%s, 批次ID=%s, 压缩=%v, 大小=%d",
-		nodeID, req.BatchID, req.IsCompressed, len(req.CompressedData))
+	m.logger.Debug("发送压缩批次到节点", "peer", nodeID, "batch_id", req.BatchID, "compression", req.CompressionType, "bytes", len(req.CompressedData))
 
-	return nil // 模拟成功
+	return nil
 }
 
 // getReplicationPriority 获取复制优先级
@@ -643,13 +948,13 @@ func (m *CrossDCReplicationManager) checkDCHealth() {
 		timeSinceLastHeartbeat := time.Since(target.LastHeartbeat)
 		if timeSinceLastHeartbeat > time.Minute*2 { // 2分钟无心跳认为不健康
 			if target.IsConnected {
-				m.logger.Printf("DC %s 连接状态变为不健康", dcID)
+				m.logger.Warn("DC连接状态变为不健康", "target_dc", dcID)
 				target.IsConnected = false
 				target.FailureCount++
 			}
 		} else {
 			if !target.IsConnected {
-				m.logger.Printf("DC %s 连接状态恢复健康", dcID)
+				m.logger.Info("DC连接状态恢复健康", "target_dc", dcID)
 				target.IsConnected = true
 				target.FailureCount = 0
 				target.RetryBackoff = time.Millisecond * 100 // 重置退避时间
@@ -677,6 +982,7 @@ func (m *CrossDCReplicationManager) GetReplicationStats() *CrossDCReplicationSta
 		CompressionErrors:      m.stats.CompressionErrors,
 		TimeoutErrors:          m.stats.TimeoutErrors,
 		DCStats:                make(map[DataCenterID]*DCReplicationStat),
+		Queue:                  m.replicationQueue.Stats(),
 	}
 
 	// 复制DC统计
@@ -688,6 +994,9 @@ func (m *CrossDCReplicationManager) GetReplicationStats() *CrossDCReplicationSta
 			AverageLatency:    dcStat.AverageLatency,
 			ErrorCount:        dcStat.ErrorCount,
 			LastSuccessTime:   dcStat.LastSuccessTime,
+			BatchSize:         dcStat.BatchSize,
+			RoundTripTime:     dcStat.RoundTripTime,
+			Bandwidth:         dcStat.Bandwidth,
 		}
 	}
 
@@ -713,6 +1022,7 @@ func (m *CrossDCReplicationManager) GetDCReplicationStatus() map[DataCenterID]*D
 			LastHeartbeat:       target.LastHeartbeat,
 			FailureCount:        target.FailureCount,
 			RetryBackoff:        target.RetryBackoff,
+			BatchSize:           target.BatchSize,
 		}
 		target.mu.RUnlock()
 	}
@@ -733,9 +1043,57 @@ func (m *CrossDCReplicationManager) UpdateDCHeartbeat(dcID DataCenterID) {
 	target.mu.Lock()
 	target.LastHeartbeat = time.Now()
 	if !target.IsConnected {
-		m.logger.Printf("DC %s 心跳恢复", dcID)
+		m.logger.Info("DC心跳恢复", "target_dc", dcID)
 		target.IsConnected = true
 		target.FailureCount = 0
 	}
 	target.mu.Unlock()
 }
+
+// crossDCReplicationState 返回跨DC复制批次使用的任期、前一条目任期与提交索引
+func (n *Node) crossDCReplicationState(prevLogIndex LogIndex) (Term, Term, LogIndex) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	prevLogTerm, err := n.termAt(prevLogIndex)
+	if err != nil {
+		n.logger.Error("获取索引的任期失败", "index", prevLogIndex, logging.FieldError, err)
+	}
+	return n.getCurrentTerm(), prevLogTerm, n.commitIndex
+}
+
+// HandleCompressedAppendEntries 处理跨数据中心复制的压缩追加请求，解压并校验负载后按普通追加请求处理
+// 负载校验失败时返回ErrChecksumMismatch，发送方应重新发送该批次
+func (n *Node) HandleCompressedAppendEntries(req *CompressedAppendEntriesRequest) (*CompressedAppendEntriesResponse, error) {
+	start := time.Now()
+	entries, err := DecompressEntries(req)
+	if err != nil {
+		n.logger.Warn("拒绝复制批次", "batch_id", req.BatchID, logging.FieldError, err)
+		return nil, err
+	}
+	decompressionTime := time.Since(start)
+
+	resp := n.HandleAppendEntries(&AppendEntriesRequest{
+		Term:         req.Term,
+		LeaderID:     req.LeaderID,
+		PrevLogIndex: req.PrevLogIndex,
+		PrevLogTerm:  req.PrevLogTerm,
+		Entries:      entries,
+		LeaderCommit: req.LeaderCommit,
+	})
+
+	result := &CompressedAppendEntriesResponse{
+		Term:              resp.Term,
+		Success:           resp.Success,
+		ConflictIndex:     resp.ConflictIndex,
+		ConflictTerm:      resp.ConflictTerm,
+		ProcessingTime:    time.Since(start),
+		DecompressionTime: decompressionTime,
+		BatchID:           req.BatchID,
+	}
+	if resp.Success {
+		result.ProcessedCount = len(entries)
+		result.LastProcessedIndex = req.PrevLogIndex + LogIndex(len(entries))
+	}
+	return result, nil
+}
@@ -9,11 +9,11 @@
 package raft
 
 import (
-	"fmt"
-	"log"
 	"sort"
 	"sync"
 	"time"
+
+	"raftserver/logging"
 )
 
 // DCElectionState 数据中心选举状态
@@ -38,7 +38,7 @@ type DCRaftExtension struct {
 	// 基础配置
 	config *Config
 	nodeID NodeID
-	logger *log.Logger
+	logger logging.Logger
 
 	// 数据中心状态
 	localDataCenter DataCenterID
@@ -99,7 +99,7 @@ type LatencyStats struct {
 
 // NewDCRaftExtension 创建数据中心感知的Raft扩展
 func NewDCRaftExtension(config *Config, nodeID NodeID) *DCRaftExtension {
-	logger := log.New(log.Writer(), fmt.Sprintf("[dc-raft-%s] ", nodeID), log.LstdFlags)
+	logger := config.ComponentLogger("dc-raft")
 
 	// 确定本地数据中心
 	var localDC DataCenterID = "default"
@@ -198,7 +198,7 @@ func (ext *DCRaftExtension) ShouldStartElection() bool {
 
 	// 如果主数据中心长时间没有响应，允许辅助数据中心开始选举
 	if timeSinceLastPrimaryHeartbeat > maxLatency*3 {
-		ext.logger.Printf("主数据中心长时间无响应，辅助数据中心开始选举")
+		ext.logger.Warn("主数据中心长时间无响应，辅助数据中心开始选举")
 		return true
 	}
 
@@ -283,7 +283,7 @@ func (ext *DCRaftExtension) performAsyncReplication() {
 	ext.asyncReplicationManager.pendingEntries = ext.asyncReplicationManager.pendingEntries[:0]
 
 	// 执行异步复制（这里需要实际的网络发送逻辑）
-	ext.logger.Printf("执行异步复制，条目数量: %d", len(entries))
+	ext.logger.Debug("执行异步复制", "entries", len(entries))
 
 	// TODO: 实现实际的跨数据中心网络发送逻辑
 }
@@ -316,7 +316,7 @@ func (ext *DCRaftExtension) getNodeDataCenter(nodeID NodeID) DataCenterID {
 
 // Start 启动数据中心扩展
 func (ext *DCRaftExtension) Start() error {
-	ext.logger.Printf("启动数据中心感知Raft扩展，本地DC: %s", ext.localDataCenter)
+	ext.logger.Info("启动数据中心感知Raft扩展")
 
 	// 启动异步复制管理器
 	if ext.asyncReplicationManager != nil {
@@ -333,7 +333,7 @@ func (ext *DCRaftExtension) Start() error {
 
 // Stop 停止数据中心扩展
 func (ext *DCRaftExtension) Stop() error {
-	ext.logger.Printf("停止数据中心感知Raft扩展")
+	ext.logger.Info("停止数据中心感知Raft扩展")
 
 	// 停止异步复制管理器
 	if ext.asyncReplicationManager != nil {
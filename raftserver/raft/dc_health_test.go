@@ -0,0 +1,88 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-4 23:12:40
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-4 23:12:40
+* @Description: ConcordKV Raft consensus server - dc_health_test.go
+ */
+package raft_test
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+)
+
+// waitDCHealth 等待观察节点上的DC健康状态与指标满足条件
+func waitDCHealth(t *testing.T, node *raft.Node, desc string, ok func(status map[raft.NodeID]*raft.NodeHealthStatus, metrics *raft.DCMetrics) bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		status := node.GetDCHealthStatus()["dc2"]
+		metrics := node.GetDCMetrics()
+		if ok(status, metrics) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待%s超时: node3=%+v, 健康节点数=%v, 延迟=%v", desc, status["node3"],
+				metrics.HealthyNodesPerDC["dc2"], metrics.CrossDCLatencies["dc2"])
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestDCHealthCheckerProbesRemoteNodes 远程节点停止响应探测后被标记为不健康，恢复后重新变为健康，DC指标随之更新
+func TestDCHealthCheckerProbesRemoteNodes(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	servers := []raft.Server{
+		{ID: "node1", DataCenter: "dc1"},
+		{ID: "node2", DataCenter: "dc2"},
+		{ID: "node3", DataCenter: "dc2"},
+	}
+	network, _, stop := newMemClusterWithConfig(t, 2*time.Millisecond, func(config *raft.Config) {
+		config.Servers = servers
+		local := raft.DataCenterID("dc2")
+		if config.NodeID == "node1" {
+			local = "dc1"
+		}
+		config.MultiDC = &raft.MultiDCConfig{
+			Enabled:             true,
+			LocalDataCenter:     &raft.DataCenterConfig{ID: local},
+			HealthCheckInterval: 20 * time.Millisecond,
+			HealthCheckTimeout:  50 * time.Millisecond,
+		}
+	})
+	defer stop()
+	observer := network.nodes["node1"]
+
+	waitDCHealth(t, observer, "测量到往返延迟", func(status map[raft.NodeID]*raft.NodeHealthStatus, metrics *raft.DCMetrics) bool {
+		return status["node3"].LatencyMs >= 4 && status["node2"].LatencyMs >= 4 &&
+			metrics.CrossDCLatencies["dc2"] >= 4*time.Millisecond && metrics.HealthyNodesPerDC["dc2"] == 2
+	})
+
+	// node3 停止响应
+	network.setIntercept(func(from, to raft.NodeID, req interface{}) error {
+		if from == "node3" || to == "node3" {
+			return errors.New("网络分区")
+		}
+		return nil
+	})
+	waitDCHealth(t, observer, "node3变为不健康", func(status map[raft.NodeID]*raft.NodeHealthStatus, metrics *raft.DCMetrics) bool {
+		return !status["node3"].IsHealthy && status["node3"].ErrorCount >= 3 &&
+			status["node2"].IsHealthy && metrics.HealthyNodesPerDC["dc2"] == 1
+	})
+
+	// node3 恢复
+	network.setIntercept(nil)
+	waitDCHealth(t, observer, "node3恢复为健康", func(status map[raft.NodeID]*raft.NodeHealthStatus, metrics *raft.DCMetrics) bool {
+		return status["node3"].IsHealthy && status["node3"].ErrorCount == 0 && metrics.HealthyNodesPerDC["dc2"] == 2
+	})
+}
@@ -10,7 +10,10 @@ package raft
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"raftserver/logging"
 )
 
 // startElection 开始选举过程
@@ -19,36 +22,30 @@ func (n *Node) startElection() {
 	currentTerm := n.getCurrentTerm()
 	lastLogIndex := n.storage.GetLastLogIndex()
 	lastLogTerm := n.storage.GetLastLogTerm()
-	servers := n.config.Servers
+	servers := n.votersLocked()
+	leadershipTransfer := n.transferElection
 	n.mu.RUnlock()
 
-	n.logger.Printf("=== 开始选举调试信息 ===")
-	n.logger.Printf("当前任期: %d", currentTerm)
-	n.logger.Printf("服务器列表长度: %d", len(servers))
-	for i, server := range servers {
-		n.logger.Printf("服务器[%d]: ID=%s, Address=%s", i, server.ID, server.Address)
-	}
-	n.logger.Printf("=== 选举调试信息结束 ===")
-
-	n.logger.Printf("开始选举，任期: %d，服务器列表: %+v", currentTerm, servers)
+	n.logger.Info("开始选举", "servers", len(servers))
 
 	// 创建投票请求
 	req := &VoteRequest{
-		Term:         currentTerm,
-		CandidateID:  n.id,
-		LastLogIndex: lastLogIndex,
-		LastLogTerm:  lastLogTerm,
+		Term:               currentTerm,
+		CandidateID:        n.id,
+		LastLogIndex:       lastLogIndex,
+		LastLogTerm:        lastLogTerm,
+		LeadershipTransfer: leadershipTransfer,
 	}
 
 	// 投票计数
 	voteCount := 1 // 自己投票给自己
 	majority := len(servers)/2 + 1
 
-	n.logger.Printf("开始选举投票，集群大小: %d，需要票数: %d", len(servers), majority)
+	n.logger.Debug("开始选举投票", "cluster_size", len(servers), "majority", majority)
 
 	// 检查是否为单节点集群
 	if len(servers) == 1 {
-		n.logger.Printf("单节点集群，直接成为领导者")
+		n.logger.Info("单节点集群，直接成为领导者")
 		n.becomeLeader()
 		return
 	}
@@ -71,7 +68,7 @@ func (n *Node) startElection() {
 
 			resp, err := n.transport.SendVoteRequest(ctx, serverID, req)
 			if err != nil {
-				n.logger.Printf("发送投票请求到 %s 失败: %v", serverID, err)
+				n.logger.Warn("发送投票请求失败", "peer", serverID, logging.FieldError, err)
 				return
 			}
 
@@ -80,7 +77,7 @@ func (n *Node) startElection() {
 
 			// 检查响应任期
 			if resp.Term > currentTerm {
-				n.logger.Printf("收到更高任期 %d，转为跟随者", resp.Term)
+				n.logger.Info("收到更高任期，转为跟随者", "new_term", resp.Term)
 				n.becomeFollower(resp.Term, "")
 				return
 			}
@@ -88,7 +85,7 @@ func (n *Node) startElection() {
 			// 统计投票
 			if resp.VoteGranted {
 				voteCount++
-				n.logger.Printf("收到来自 %s 的投票，当前票数: %d/%d", serverID, voteCount, majority)
+				n.logger.Debug("收到投票", "peer", serverID, "votes", voteCount, "majority", majority)
 
 				// 检查是否获得多数票
 				if voteCount >= majority {
@@ -98,12 +95,12 @@ func (n *Node) startElection() {
 					n.mu.RUnlock()
 
 					if stillCandidate {
-						n.logger.Printf("获得多数票 (%d/%d)，成为领导者", voteCount, majority)
+						n.logger.Info("获得多数票，成为领导者", "votes", voteCount, "majority", majority)
 						n.becomeLeader()
 					}
 				}
 			} else {
-				n.logger.Printf("来自 %s 的投票被拒绝", serverID)
+				n.logger.Debug("投票被拒绝", "peer", serverID)
 			}
 		}(server.ID)
 	}
@@ -121,13 +118,26 @@ func (n *Node) sendHeartbeats() {
 
 	currentTerm := n.getCurrentTerm()
 	servers := n.config.Servers
+	voters := n.voterCountLocked()
+	isLearner := make(map[NodeID]bool, len(n.learners))
+	for _, server := range servers {
+		if server.IsLearner {
+			isLearner[server.ID] = true
+		}
+	}
+	for _, learner := range n.learners {
+		servers = append(servers[:len(servers):len(servers)], learner)
+		isLearner[learner.ID] = true
+	}
 	commitIndex := n.commitIndex
 	n.mu.RUnlock()
 
-	n.logger.Printf("发送心跳，任期: %d", currentTerm)
+	n.logger.Debug("发送心跳")
 
 	// 并发发送心跳到所有跟随者
 	var wg sync.WaitGroup
+	var acks atomic.Int32
+	roundStart := n.clock.Now()
 
 	for _, server := range servers {
 		if server.ID == n.id {
@@ -135,79 +145,70 @@ func (n *Node) sendHeartbeats() {
 		}
 
 		wg.Add(1)
-		go func(serverID NodeID) {
+		go func(serverID NodeID, voter bool) {
 			defer wg.Done()
-			n.sendAppendEntriesToFollower(serverID, currentTerm, commitIndex)
-		}(server.ID)
+			if n.sendAppendEntriesToFollower(serverID, currentTerm, commitIndex) && voter {
+				acks.Add(1)
+			}
+		}(server.ID, !isLearner[server.ID])
 	}
 
 	wg.Wait()
-}
 
-// sendAppendEntriesToFollower 向跟随者发送追加日志请求
-func (n *Node) sendAppendEntriesToFollower(followerID NodeID, term Term, leaderCommit LogIndex) {
-	n.mu.RLock()
-	nextIndex := n.nextIndex[followerID]
-	n.mu.RUnlock()
+	// 多数派确认本轮心跳后延长领导者租约
+	if int(acks.Load())+1 >= voters/2+1 {
+		n.extendLease(currentTerm, roundStart)
+	}
+}
 
-	// 获取前一个日志条目信息
-	var prevLogIndex LogIndex
-	var prevLogTerm Term
+// sendAppendEntriesToFollower 向跟随者发送心跳，返回跟随者是否确认了本任期的领导者
+// 日志条目与快照由复制协程发送，心跳以已确认的matchIndex为前提，不会因日志不一致被拒绝
+func (n *Node) sendAppendEntriesToFollower(followerID NodeID, term Term, leaderCommit LogIndex) bool {
+	n.mu.Lock()
+	req := n.heartbeatRequestLocked(followerID, term, leaderCommit)
 
-	if nextIndex > 1 {
-		prevLogIndex = nextIndex - 1
-		if entry, err := n.storage.GetLogEntry(prevLogIndex); err == nil {
-			prevLogTerm = entry.Term
-		} else {
-			n.logger.Printf("获取日志条目 %d 失败: %v", prevLogIndex, err)
-			return
-		}
+	// 顺便唤醒复制协程，使失败后暂停的复制得以重试
+	if r, ok := n.replicators[followerID]; ok {
+		r.paused = false
+		r.notify()
 	}
+	n.mu.Unlock()
 
-	// 获取要发送的日志条目
-	var entries []LogEntry
-	lastLogIndex := n.storage.GetLastLogIndex()
-
-	if nextIndex <= lastLogIndex {
-		// 计算要发送的条目数量（限制在配置的最大值内）
-		endIndex := nextIndex + LogIndex(n.config.MaxLogEntries) - 1
-		if endIndex > lastLogIndex {
-			endIndex = lastLogIndex
-		}
+	ctx, cancel := context.WithTimeout(n.ctx, time.Second*5)
+	defer cancel()
 
-		logEntries, err := n.storage.GetLogEntries(nextIndex, endIndex)
-		if err != nil {
-			n.logger.Printf("获取日志条目 [%d:%d] 失败: %v", nextIndex, endIndex, err)
-			return
-		}
-		entries = logEntries
+	resp, err := n.transport.SendAppendEntries(ctx, followerID, req)
+	if err != nil {
+		n.logger.Debug("发送心跳失败", "peer", followerID, logging.FieldError, err)
+		return false
 	}
 
-	// 创建追加日志请求
+	// 处理响应
+	n.handleHeartbeatResponse(followerID, req, resp)
+
+	return resp.Term == term
+}
+
+// heartbeatRequestLocked 构造只携带提交索引的心跳请求（调用方需持有锁）
+func (n *Node) heartbeatRequestLocked(followerID NodeID, term Term, leaderCommit LogIndex) *AppendEntriesRequest {
 	req := &AppendEntriesRequest{
 		Term:         term,
 		LeaderID:     n.id,
-		PrevLogIndex: prevLogIndex,
-		PrevLogTerm:  prevLogTerm,
-		Entries:      entries,
 		LeaderCommit: leaderCommit,
 	}
 
-	ctx, cancel := context.WithTimeout(n.ctx, time.Second*5)
-	defer cancel()
-
-	resp, err := n.transport.SendAppendEntries(ctx, followerID, req)
-	if err != nil {
-		n.logger.Printf("发送追加日志到 %s 失败: %v", followerID, err)
-		return
+	// matchIndex已被快照压缩时退化为从头开始的前提，跟随者据此不会推进提交索引
+	matchIndex := n.matchIndex[followerID]
+	if matchTerm, err := n.termAt(matchIndex); err == nil {
+		req.PrevLogIndex = matchIndex
+		req.PrevLogTerm = matchTerm
 	}
 
-	// 处理响应
-	n.handleAppendEntriesResponse(followerID, req, resp)
+	return req
 }
 
-// handleAppendEntriesResponse 处理追加日志响应
-func (n *Node) handleAppendEntriesResponse(followerID NodeID, req *AppendEntriesRequest, resp *AppendEntriesResponse) {
+// handleHeartbeatResponse 处理心跳响应
+func (n *Node) handleHeartbeatResponse(followerID NodeID, req *AppendEntriesRequest, resp *AppendEntriesResponse) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
@@ -218,44 +219,21 @@ func (n *Node) handleAppendEntriesResponse(followerID NodeID, req *AppendEntries
 
 	// 检查响应任期
 	if resp.Term > req.Term {
-		n.logger.Printf("收到更高任期 %d，转为跟随者", resp.Term)
-		n.becomeFollower(resp.Term, "")
+		n.logger.Info("收到更高任期，转为跟随者", "new_term", resp.Term)
+		n.becomeFollowerLocked(resp.Term, "")
 		return
 	}
 
 	if resp.Success {
-		// 成功追加日志
-		if len(req.Entries) > 0 {
-			// 更新 nextIndex 和 matchIndex
-			newMatchIndex := req.PrevLogIndex + LogIndex(len(req.Entries))
-			n.matchIndex[followerID] = newMatchIndex
-			n.nextIndex[followerID] = newMatchIndex + 1
-
-			n.logger.Printf("成功向 %s 复制日志，matchIndex: %d", followerID, newMatchIndex)
-
-			// 尝试推进 commitIndex
-			n.tryAdvanceCommitIndex()
-		}
-	} else {
-		// 日志不一致，需要回退
-		if resp.ConflictTerm != 0 {
-			// 使用冲突优化
-			conflictIndex := n.findConflictIndex(resp.ConflictTerm, resp.ConflictIndex)
-			n.nextIndex[followerID] = conflictIndex
-		} else {
-			// 简单回退
-			if n.nextIndex[followerID] > 1 {
-				n.nextIndex[followerID]--
-			}
-		}
-
-		n.logger.Printf("日志不一致，回退 %s 的 nextIndex 到 %d", followerID, n.nextIndex[followerID])
+		n.lastAppendTime[followerID] = n.clock.Now()
 	}
 }
 
 // tryAdvanceCommitIndex 尝试推进提交索引
+// 多数据中心模式下除多数派外还需满足配置的提交策略
 func (n *Node) tryAdvanceCommitIndex() {
 	lastLogIndex := n.storage.GetLastLogIndex()
+	policy := n.commitPolicy()
 
 	// 从最后一个日志索引开始，向前检查每个索引
 	for index := lastLogIndex; index > n.commitIndex; index-- {
@@ -269,30 +247,36 @@ func (n *Node) tryAdvanceCommitIndex() {
 			continue // 只能提交当前任期的日志
 		}
 
-		// 统计已复制此索引的节点数量
-		replicatedCount := 1 // 领导者自己
-		for _, serverID := range n.getFollowerIDs() {
-			if n.matchIndex[serverID] >= index {
-				replicatedCount++
-			}
+		// 检查是否达到多数，以及提交策略要求的跨DC确认
+		majority, satisfied := n.commitQuorumLocked(index, policy)
+		if !majority {
+			continue
+		}
+		if !satisfied {
+			n.recordCommitDelayLocked(index)
+			continue
 		}
 
-		// 检查是否达到多数
-		majority := len(n.config.Servers)/2 + 1
-		if replicatedCount >= majority {
-			// 可以安全提交
-			n.commitIndex = index
-			n.logger.Printf("推进 commitIndex 到 %d", index)
-
-			// 应用已提交的日志
-			go n.applyCommittedLogs()
-			break
+		// 可以安全提交
+		n.commitIndex = index
+		n.finishCommitDelayLocked()
+		if n.catchUp != nil && !entry.Timestamp.IsZero() {
+			n.catchUp.observeCommitLatency(n.clock.Now().Sub(entry.Timestamp))
 		}
+		n.notifyCommitLocked(index)
+		n.logger.Debug("推进commitIndex", "commit_index", index)
+
+		// 应用已提交的日志
+		go n.applyCommittedLogs()
+		break
 	}
 }
 
 // applyCommittedLogs 应用已提交的日志到状态机
 func (n *Node) applyCommittedLogs() {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
 	n.mu.Lock()
 	commitIndex := n.commitIndex
 	lastApplied := n.lastApplied
@@ -301,31 +285,34 @@ func (n *Node) applyCommittedLogs() {
 	for index := lastApplied + 1; index <= commitIndex; index++ {
 		entry, err := n.storage.GetLogEntry(index)
 		if err != nil {
-			n.logger.Printf("获取日志条目 %d 失败: %v", index, err)
+			n.logger.Error("获取日志条目失败", "index", index, logging.FieldError, err)
 			break
 		}
 
 		// 如果是配置变更条目，特殊处理
+		var result interface{}
 		if entry.Type == EntryConfiguration {
 			if err := n.applyConfigurationChange(entry); err != nil {
-				n.logger.Printf("应用配置变更 %d 失败: %v", index, err)
+				n.logger.Error("应用配置变更失败", "index", index, logging.FieldError, err)
 				break
 			}
 		} else {
 			// 普通日志条目应用到状态机
-			if err := n.stateMachine.Apply(entry); err != nil {
-				n.logger.Printf("应用日志条目 %d 到状态机失败: %v", index, err)
+			if result, err = n.applyToStateMachine(entry); err != nil {
+				n.logger.Error("应用日志条目到状态机失败", "index", index, logging.FieldError, err)
 				break
 			}
 		}
 
 		n.mu.Lock()
 		n.lastApplied = index
+		n.resolveApplyWaiterLocked(entry, result)
 		n.mu.Unlock()
 
-		n.logger.Printf("成功应用日志条目 %d 到状态机", index)
+		n.logger.Debug("应用日志条目到状态机", "index", index)
 	}
 
+	n.maybeTakeSnapshot()
 	n.updateMetrics()
 }
 
@@ -350,13 +337,55 @@ func (n *Node) findConflictIndex(conflictTerm Term, conflictIndex LogIndex) LogI
 	return conflictIndex
 }
 
-// getFollowerIDs 获取所有跟随者ID
+// getFollowerIDs 获取所有投票跟随者的ID，学习者不参与领导者确认与领导权交接
 func (n *Node) getFollowerIDs() []NodeID {
 	var followers []NodeID
 	for _, server := range n.config.Servers {
-		if server.ID != n.id {
+		if server.ID != n.id && !server.IsLearner {
 			followers = append(followers, server.ID)
 		}
 	}
 	return followers
 }
+
+// votersLocked 获取配置中的投票成员（调用方需持有锁）
+func (n *Node) votersLocked() []Server {
+	voters := make([]Server, 0, len(n.config.Servers))
+	for _, server := range n.config.Servers {
+		if !server.IsLearner {
+			voters = append(voters, server)
+		}
+	}
+	return voters
+}
+
+// voterCountLocked 配置中投票成员的数量，多数派按此计算（调用方需持有锁）
+func (n *Node) voterCountLocked() int {
+	count := 0
+	for _, server := range n.config.Servers {
+		if !server.IsLearner {
+			count++
+		}
+	}
+	return count
+}
+
+// isVoterLocked 检查节点是否为配置中的投票成员（调用方需持有锁）
+func (n *Node) isVoterLocked(id NodeID) bool {
+	for _, server := range n.config.Servers {
+		if server.ID == id {
+			return !server.IsLearner
+		}
+	}
+	return false
+}
+
+// isLearnerLocked 检查节点是否为配置中的学习者（调用方需持有锁）
+func (n *Node) isLearnerLocked(id NodeID) bool {
+	for _, server := range n.config.Servers {
+		if server.ID == id {
+			return server.IsLearner
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-30 10:24:16
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-30 10:24:16
+* @Description: ConcordKV Raft consensus server - events.go
+ */
+package raft
+
+import (
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"sync"
+
+	"raftserver/logging"
+	"raftserver/queue"
+)
+
+// defaultEventQueueCapacity 每个监听器事件队列的默认容量
+const defaultEventQueueCapacity = 256
+
+// eventSubscription 一个监听器的事件队列与投递协程
+// 节点只向队列投递事件，不等待监听器处理；队列满时丢弃最早的事件，被丢弃的数量可通过Node.DroppedEvents查询
+type eventSubscription struct {
+	listener EventListener
+	events   *queue.Queue
+	logger   logging.Logger
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// newEventSubscription 创建监听器的事件队列并启动投递协程
+func newEventSubscription(listener EventListener, capacity int, logger logging.Logger) *eventSubscription {
+	if capacity <= 0 {
+		capacity = defaultEventQueueCapacity
+	}
+	sub := &eventSubscription{
+		listener: listener,
+		events:   queue.New("raft-events", queue.Config{Capacity: capacity, Policy: queue.DropOldest}, queue.Options{}),
+		logger:   logger.With("listener", fmt.Sprintf("%T", listener)),
+		stopCh:   make(chan struct{}),
+	}
+	go sub.run()
+	return sub
+}
+
+// run 按入队顺序投递事件，直到订阅被取消
+// 协程不计入节点的等待组，阻塞的监听器不会拖住Stop
+func (s *eventSubscription) run() {
+	for {
+		event, ok := s.events.Dequeue(s.stopCh)
+		if !ok {
+			return
+		}
+		// 取消订阅与出队同时发生时，不再投递
+		select {
+		case <-s.stopCh:
+			return
+		default:
+		}
+		s.deliver(event)
+	}
+}
+
+// accepts 监听器是否实现了事件对应的可选接口
+func (s *eventSubscription) accepts(event interface{}) bool {
+	switch event.(type) {
+	case SnapshotEvent:
+		_, ok := s.listener.(SnapshotEventListener)
+		return ok
+	case ConfigChangeEvent:
+		_, ok := s.listener.(ConfigChangeEventListener)
+		return ok
+	case CommitEvent:
+		_, ok := s.listener.(CommitEventListener)
+		return ok
+	}
+	return true
+}
+
+// publish 把事件加入队列，订阅取消后的事件直接丢弃
+func (s *eventSubscription) publish(event interface{}) {
+	_ = s.events.Enqueue(event)
+}
+
+// deliver 调用监听器的回调，恢复并记录回调中的panic，不影响后续事件的投递
+func (s *eventSubscription) deliver(event interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error("事件监听器panic", "event", fmt.Sprintf("%T", event), "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+
+	switch e := event.(type) {
+	case StateChangeEvent:
+		s.listener.OnStateChange(e)
+	case LeaderChangeEvent:
+		s.listener.OnLeaderChange(e)
+	case SnapshotEvent:
+		s.listener.(SnapshotEventListener).OnSnapshot(e)
+	case ConfigChangeEvent:
+		s.listener.(ConfigChangeEventListener).OnConfigChange(e)
+	case CommitEvent:
+		s.listener.(CommitEventListener).OnCommit(e)
+	}
+}
+
+// dropped 因队列满被丢弃的事件数
+func (s *eventSubscription) dropped() int64 {
+	return s.events.Stats().Dropped
+}
+
+// stop 取消订阅，排队中的事件不再投递，正在执行的回调返回后投递协程退出
+func (s *eventSubscription) stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+		s.events.Close()
+	})
+}
+
+// sameListener 比较两个监听器是否为同一个，类型不可比较的监听器视为不同，避免比较时panic
+func sameListener(a, b EventListener) bool {
+	ta := reflect.TypeOf(a)
+	if ta != reflect.TypeOf(b) || !ta.Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// AddEventListener 添加事件监听器，同一监听器添加多次会收到多份事件
+func (n *Node) AddEventListener(listener EventListener) {
+	sub := newEventSubscription(listener, n.config.EventQueueCapacity, n.logger)
+
+	n.eventMu.Lock()
+	defer n.eventMu.Unlock()
+	// 写时复制，正在分发的事件使用旧的切片
+	listeners := make([]*eventSubscription, 0, len(n.eventListeners)+1)
+	n.eventListeners = append(append(listeners, n.eventListeners...), sub)
+}
+
+// RemoveEventListener 移除事件监听器，返回监听器是否已注册
+// 返回后监听器不会再收到新的事件，可以在监听器自己的回调中调用（OnCommit除外）
+func (n *Node) RemoveEventListener(listener EventListener) bool {
+	n.eventMu.Lock()
+	var removed []*eventSubscription
+	listeners := make([]*eventSubscription, 0, len(n.eventListeners))
+	for _, sub := range n.eventListeners {
+		if sameListener(sub.listener, listener) {
+			removed = append(removed, sub)
+		} else {
+			listeners = append(listeners, sub)
+		}
+	}
+	n.eventListeners = listeners
+	n.eventMu.Unlock()
+
+	for _, sub := range removed {
+		sub.stop()
+	}
+	return len(removed) > 0
+}
+
+// DroppedEvents 监听器处理过慢、事件队列满时被丢弃的事件数，监听器未注册时返回0
+func (n *Node) DroppedEvents(listener EventListener) int64 {
+	var dropped int64
+	for _, sub := range n.eventSubscriptions() {
+		if sameListener(sub.listener, listener) {
+			dropped += sub.dropped()
+		}
+	}
+	return dropped
+}
+
+// eventSubscriptions 当前的订阅列表，调用方不能修改返回的切片
+func (n *Node) eventSubscriptions() []*eventSubscription {
+	n.eventMu.Lock()
+	defer n.eventMu.Unlock()
+	return n.eventListeners
+}
+
+// publishEvent 把事件加入每个关心它的监听器的队列，不等待监听器处理
+func (n *Node) publishEvent(event interface{}) {
+	for _, sub := range n.eventSubscriptions() {
+		if sub.accepts(event) {
+			sub.publish(event)
+		}
+	}
+}
+
+// stopEventListeners 节点停止时取消所有订阅
+func (n *Node) stopEventListeners() {
+	n.eventMu.Lock()
+	listeners := n.eventListeners
+	n.eventListeners = nil
+	n.eventMu.Unlock()
+
+	for _, sub := range listeners {
+		sub.stop()
+	}
+}
@@ -0,0 +1,337 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-5 10:12:47
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-5 10:12:47
+* @Description: ConcordKV Raft consensus server - events_test.go
+ */
+package raft_test
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/statemachine"
+	"raftserver/storage"
+)
+
+// recordingListener 记录收到的所有节点事件
+type recordingListener struct {
+	mu            sync.Mutex
+	snapshots     []raft.SnapshotEvent
+	configChanges []raft.ConfigChangeEvent
+}
+
+func (l *recordingListener) OnStateChange(event raft.StateChangeEvent)   {}
+func (l *recordingListener) OnLeaderChange(event raft.LeaderChangeEvent) {}
+
+func (l *recordingListener) OnSnapshot(event raft.SnapshotEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.snapshots = append(l.snapshots, event)
+}
+
+func (l *recordingListener) OnConfigChange(event raft.ConfigChangeEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.configChanges = append(l.configChanges, event)
+}
+
+// waitFor 等待条件在持有监听器锁时成立
+func (l *recordingListener) waitFor(t *testing.T, desc string, ok func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.Lock()
+		done := ok()
+		l.mu.Unlock()
+		if done {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待%s超时", desc)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestSnapshotAndConfigChangeEvents 实现可选监听接口的监听器收到快照创建与成员变更事件
+func TestSnapshotAndConfigChangeEvents(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	network, leader, stop := newMemClusterWithConfig(t, 2*time.Millisecond, func(config *raft.Config) {
+		config.SnapshotThreshold = 10
+	})
+	defer stop()
+
+	listener := &recordingListener{}
+	leader.AddEventListener(listener)
+
+	var last raft.LogIndex
+	for i := 0; i < 15; i++ {
+		index, err := leader.ProposeWithIndex([]byte(fmt.Sprintf(`{"type":"SET","key":"k%d","value":"v"}`, i)))
+		if err != nil {
+			t.Fatalf("提议失败: %v", err)
+		}
+		last = index
+	}
+	waitApplied(t, leader, last, 5*time.Second)
+
+	listener.waitFor(t, "快照创建事件", func() bool { return len(listener.snapshots) > 0 })
+	listener.mu.Lock()
+	snapshot := listener.snapshots[0]
+	listener.mu.Unlock()
+	if snapshot.Installed || snapshot.NodeID != leader.GetID() || snapshot.LastIncludedIndex == 0 || snapshot.Size == 0 {
+		t.Fatalf("快照事件不正确: %+v", snapshot)
+	}
+
+	var removed raft.NodeID
+	for id := range network.nodes {
+		if id != leader.GetID() {
+			removed = id
+			break
+		}
+	}
+	if err := leader.RemoveServer(removed); err != nil {
+		t.Fatalf("移除服务器失败: %v", err)
+	}
+
+	listener.waitFor(t, "成员变更事件", func() bool { return len(listener.configChanges) > 0 })
+	listener.mu.Lock()
+	defer listener.mu.Unlock()
+	change := listener.configChanges[0]
+	if change.Type != raft.RemoveServer || change.Server.ID != removed || len(change.Servers) != 2 || change.Index == 0 {
+		t.Fatalf("成员变更事件不正确: %+v", change)
+	}
+}
+
+// proposeEntries 逐个提议count个SET条目，每个条目应用后再提议下一个，使每次达到快照阈值都单独创建快照
+func proposeEntries(t *testing.T, leader *raft.Node, prefix string, count int) {
+	t.Helper()
+
+	for i := 0; i < count; i++ {
+		index, err := leader.ProposeWithIndex([]byte(fmt.Sprintf(`{"type":"SET","key":"%s%d","value":"v"}`, prefix, i)))
+		if err != nil {
+			t.Fatalf("提议失败: %v", err)
+		}
+		waitApplied(t, leader, index, 5*time.Second)
+	}
+}
+
+// panickingListener 每个回调都panic，包括持锁同步分发的OnCommit
+type panickingListener struct {
+	calls atomic.Int64
+}
+
+func (l *panickingListener) OnStateChange(event raft.StateChangeEvent) {
+	l.calls.Add(1)
+	panic("state change")
+}
+
+func (l *panickingListener) OnLeaderChange(event raft.LeaderChangeEvent) {
+	l.calls.Add(1)
+	panic("leader change")
+}
+
+func (l *panickingListener) OnSnapshot(event raft.SnapshotEvent) {
+	l.calls.Add(1)
+	panic("snapshot")
+}
+
+func (l *panickingListener) OnCommit(event raft.CommitEvent) {
+	l.calls.Add(1)
+	panic("commit")
+}
+
+// TestEventListenerPanicRecovered 监听器panic被恢复，节点继续工作，后续事件照常投递给它和其他监听器
+func TestEventListenerPanicRecovered(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	_, leader, stop := newMemClusterWithConfig(t, 2*time.Millisecond, func(config *raft.Config) {
+		config.SnapshotThreshold = 10
+	})
+	defer stop()
+
+	panicking := &panickingListener{}
+	recording := &recordingListener{}
+	leader.AddEventListener(panicking)
+	leader.AddEventListener(recording)
+
+	proposeEntries(t, leader, "k", 35)
+
+	recording.waitFor(t, "多个快照事件", func() bool { return len(recording.snapshots) >= 2 })
+	deadline := time.Now().Add(5 * time.Second)
+	for panicking.calls.Load() < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("panic后的事件没有继续投递，回调次数为 %d", panicking.calls.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if !leader.IsLeader() {
+		t.Fatalf("监听器panic后节点不再是领导者")
+	}
+	proposeEntries(t, leader, "after", 5)
+}
+
+// blockingListener 快照回调阻塞直到release关闭
+type blockingListener struct {
+	release   chan struct{}
+	delivered atomic.Int64
+}
+
+func (l *blockingListener) OnStateChange(event raft.StateChangeEvent)   {}
+func (l *blockingListener) OnLeaderChange(event raft.LeaderChangeEvent) {}
+
+func (l *blockingListener) OnSnapshot(event raft.SnapshotEvent) {
+	<-l.release
+	l.delivered.Add(1)
+}
+
+// TestEventListenerBlocking 阻塞的监听器不影响节点与其他监听器，队列满时丢弃最早的事件并计数
+func TestEventListenerBlocking(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	const capacity = 2
+	_, leader, stop := newMemClusterWithConfig(t, 2*time.Millisecond, func(config *raft.Config) {
+		config.SnapshotThreshold = 5
+		config.EventQueueCapacity = capacity
+	})
+	defer stop()
+
+	blocking := &blockingListener{release: make(chan struct{})}
+	recording := &recordingListener{}
+	leader.AddEventListener(blocking)
+	leader.AddEventListener(recording)
+
+	proposeEntries(t, leader, "k", 60)
+
+	// 一个事件阻塞在回调中，队列中最多再排capacity个，其余被丢弃
+	const minSnapshots = capacity + 3
+	recording.waitFor(t, "快照事件", func() bool { return len(recording.snapshots) >= minSnapshots })
+	if dropped := leader.DroppedEvents(blocking); dropped == 0 {
+		t.Fatalf("阻塞的监听器没有丢弃事件")
+	}
+	if dropped := leader.DroppedEvents(recording); dropped != 0 {
+		t.Fatalf("正常的监听器丢弃了 %d 个事件", dropped)
+	}
+
+	close(blocking.release)
+	recording.mu.Lock()
+	total := int64(len(recording.snapshots))
+	recording.mu.Unlock()
+	deadline := time.Now().Add(5 * time.Second)
+	for blocking.delivered.Load()+leader.DroppedEvents(blocking) != total {
+		if time.Now().After(deadline) {
+			t.Fatalf("投递 %d 个、丢弃 %d 个，快照事件共 %d 个",
+				blocking.delivered.Load(), leader.DroppedEvents(blocking), total)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// selfRemovingListener 第一次收到快照事件时在回调中取消订阅
+type selfRemovingListener struct {
+	node    *raft.Node
+	calls   atomic.Int64
+	removed atomic.Bool
+}
+
+func (l *selfRemovingListener) OnStateChange(event raft.StateChangeEvent)   {}
+func (l *selfRemovingListener) OnLeaderChange(event raft.LeaderChangeEvent) {}
+
+func (l *selfRemovingListener) OnSnapshot(event raft.SnapshotEvent) {
+	l.calls.Add(1)
+	l.removed.Store(l.node.RemoveEventListener(l))
+}
+
+// TestRemoveEventListenerDuringDispatch 在回调中或并发地增删监听器不会死锁，取消订阅后不再收到事件
+// 使用单节点集群：增删监听器的循环占满CPU时，领导者也不会因为心跳延迟而失去领导权
+func TestRemoveEventListenerDuringDispatch(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	network := &memNetwork{
+		nodes: make(map[raft.NodeID]*raft.Node),
+		links: make(map[[2]raft.NodeID]*memLink),
+	}
+	config := &raft.Config{
+		NodeID:            "node1",
+		ElectionTimeout:   50 * time.Millisecond,
+		HeartbeatInterval: 10 * time.Millisecond,
+		MaxLogEntries:     16,
+		SnapshotThreshold: 5,
+		Servers:           []raft.Server{{ID: "node1"}},
+	}
+	leader, err := raft.NewNode(config, &memTransport{id: "node1", network: network},
+		storage.NewMemoryStorage(), statemachine.NewKVStateMachine())
+	if err != nil {
+		t.Fatalf("创建节点失败: %v", err)
+	}
+	network.nodes["node1"] = leader
+	if err := leader.Start(); err != nil {
+		t.Fatalf("启动节点失败: %v", err)
+	}
+	defer leader.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !leader.IsLeader() {
+		if time.Now().After(deadline) {
+			t.Fatal("等待单节点成为领导者超时")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	self := &selfRemovingListener{node: leader}
+	recording := &recordingListener{}
+	leader.AddEventListener(self)
+	leader.AddEventListener(recording)
+
+	done := make(chan struct{})
+	var churn sync.WaitGroup
+	churn.Add(1)
+	go func() {
+		defer churn.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			listener := &recordingListener{}
+			leader.AddEventListener(listener)
+			if !leader.RemoveEventListener(listener) {
+				t.Errorf("移除刚添加的监听器失败")
+				return
+			}
+			runtime.Gosched()
+		}
+	}()
+
+	proposeEntries(t, leader, "k", 40)
+	recording.waitFor(t, "多个快照事件", func() bool { return len(recording.snapshots) >= 3 })
+	close(done)
+	churn.Wait()
+
+	if calls := self.calls.Load(); calls != 1 || !self.removed.Load() {
+		t.Fatalf("回调中取消订阅后仍收到事件：回调 %d 次，移除结果 %v", calls, self.removed.Load())
+	}
+	if leader.RemoveEventListener(self) {
+		t.Fatalf("重复移除监听器应返回false")
+	}
+}
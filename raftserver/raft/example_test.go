@@ -0,0 +1,149 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-28 10:20:05
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-28 10:20:05
+* @Description: ConcordKV Raft consensus server - example_test.go
+ */
+package raft_test
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/raft"
+	"raftserver/storage"
+	"raftserver/transport"
+)
+
+// counterMachine 把每条命令解析为整数并累加的状态机，ApplyWithResult返回累加后的值
+type counterMachine struct {
+	mu    sync.Mutex
+	value int64
+}
+
+func (m *counterMachine) Apply(entry *raft.LogEntry) error {
+	_, err := m.ApplyWithResult(entry)
+	return err
+}
+
+// ApplyWithResult 跳过空条目等非普通条目；无法解析的命令不影响计数，错误作为结果返回给提议者
+func (m *counterMachine) ApplyWithResult(entry *raft.LogEntry) (interface{}, error) {
+	if entry.Type != raft.EntryNormal {
+		return nil, nil
+	}
+	delta, err := strconv.ParseInt(string(entry.Data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("无效的增量 %q", entry.Data), nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.value += delta
+	return m.value, nil
+}
+
+func (m *counterMachine) CreateSnapshot() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return []byte(strconv.FormatInt(m.value, 10)), nil
+}
+
+func (m *counterMachine) RestoreSnapshot(data []byte) error {
+	value, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.value = value
+	return nil
+}
+
+// Value 当前的计数
+func (m *counterMachine) Value() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.value
+}
+
+// startCounterNode 启动以counterMachine为状态机的单节点集群，等待其成为领导者
+func startCounterNode(machine *counterMachine) (*raft.Node, error) {
+	network := transport.NewMemoryNetwork()
+	memory := network.Transport("node1")
+	config := &raft.Config{
+		NodeID:            "node1",
+		ElectionTimeout:   50 * time.Millisecond,
+		HeartbeatInterval: 10 * time.Millisecond,
+		Servers:           []raft.Server{{ID: "node1", Address: "node1"}},
+		Logger:            logging.Nop(),
+	}
+
+	node, err := raft.NewNode(config, memory, storage.NewMemoryStorage(), machine)
+	if err != nil {
+		return nil, err
+	}
+	memory.SetHandler(node)
+	if err := node.Start(); err != nil {
+		return nil, err
+	}
+	for !node.IsLeader() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	return node, nil
+}
+
+// 以自定义状态机嵌入Raft：Apply在命令应用后返回状态机的结果
+func ExampleNode_Apply() {
+	node, err := startCounterNode(&counterMachine{})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer node.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, command := range []string{"5", "-2", "ten"} {
+		result, err := node.Apply(ctx, []byte(command))
+		if err != nil {
+			fmt.Println("提议失败:", err)
+			return
+		}
+		fmt.Println(result)
+	}
+	// Output:
+	// 5
+	// 3
+	// 无效的增量 "ten"
+}
+
+// Propose只等待条目追加到本地日志，Barrier返回时之前提议的条目都已应用
+func ExampleNode_Barrier() {
+	machine := &counterMachine{}
+	node, err := startCounterNode(machine)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer node.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 1; i <= 100; i++ {
+		if _, err := node.Propose(ctx, []byte(strconv.Itoa(i))); err != nil {
+			fmt.Println("提议失败:", err)
+			return
+		}
+	}
+	if err := node.Barrier(ctx); err != nil {
+		fmt.Println("等待应用失败:", err)
+		return
+	}
+	fmt.Println(machine.Value())
+	// Output: 5050
+}
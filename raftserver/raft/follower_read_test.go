@@ -0,0 +1,73 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-21 15:20:44
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-21 15:20:44
+* @Description: ConcordKV Raft consensus server - follower_read_test.go
+ */
+package raft_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/transport"
+)
+
+// TestWaitAppliedOnLaggingFollower 收不到追加请求的跟随者等待应用到写入的索引时超时，
+// 恢复后追上并返回，之后读到该写入；跟随者报告最近得知的领导者提交索引
+func TestWaitAppliedOnLaggingFollower(t *testing.T) {
+	c := newChaosCluster(t, 3, func(config *raft.Config) { config.EnablePreVote = true })
+	leader := c.waitLeader(t, c.ids, 5*time.Second)
+	follower := c.nodes[others(c.ids, leader.GetID())[0]]
+
+	if err := c.set(leader, "warm", "1", 2*time.Second); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	waitCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := follower.WaitApplied(waitCtx, leader.LastApplied()); err != nil {
+		t.Fatalf("跟随者未追上领导者: %v", err)
+	}
+
+	rule := c.chaos.Isolate(follower.GetID(), transport.MessageAppendEntries)
+	if err := c.set(leader, "k", "v", 2*time.Second); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	index := leader.LastApplied()
+
+	// 超时路径：跟随者落后，等待在超时后返回当前的lastApplied
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	applied, err := follower.WaitApplied(ctx, index)
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) || applied >= index {
+		t.Fatalf("落后的跟随者应等待超时，实际 applied=%d err=%v", applied, err)
+	}
+	if _, exists := c.machines[follower.GetID()].Get("k"); exists {
+		t.Fatal("落后的跟随者不应已有新写入")
+	}
+
+	// 等待路径：恢复复制后跟随者应用到index再返回
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		_, err := follower.WaitApplied(ctx, index)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	c.chaos.Heal(rule)
+
+	if err := <-done; err != nil {
+		t.Fatalf("恢复后等待应用失败: %v", err)
+	}
+	if value, exists := c.machines[follower.GetID()].Get("k"); !exists || value != "v" {
+		t.Errorf("等待返回后应读到写入，实际 %v %v", value, exists)
+	}
+	if commit := follower.LeaderCommitIndex(); commit < index {
+		t.Errorf("跟随者得知的领导者提交索引 = %d, 期望至少 %d", commit, index)
+	}
+}
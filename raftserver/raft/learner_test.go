@@ -0,0 +1,183 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-23 10:20:36
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-23 10:20:36
+* @Description: ConcordKV Raft consensus server - learner_test.go
+ */
+package raft_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/transport"
+)
+
+// markLearners 返回把learners标记为学习者的configure函数，每个节点得到独立的成员列表
+func markLearners(learners ...raft.NodeID) func(config *raft.Config) {
+	skip := make(map[raft.NodeID]bool, len(learners))
+	for _, id := range learners {
+		skip[id] = true
+	}
+	return func(config *raft.Config) {
+		servers := append([]raft.Server(nil), config.Servers...)
+		for i := range servers {
+			servers[i].IsLearner = skip[servers[i].ID]
+		}
+		config.Servers = servers
+	}
+}
+
+// TestLearnerQuorum 三个投票成员加两个学习者：学习者不计入提交仲裁，不投票也不发起选举
+func TestLearnerQuorum(t *testing.T) {
+	c := newChaosCluster(t, 5, markLearners("node4", "node5"))
+	voters, learners := c.ids[:3], c.ids[3:]
+	leader := c.waitLeader(t, c.ids, 5*time.Second)
+	if leader.GetRole() != raft.RoleVoter {
+		t.Fatalf("学习者 %s 成为了领导者", leader.GetID())
+	}
+	for _, id := range learners {
+		if role := c.nodes[id].GetRole(); role != raft.RoleLearner {
+			t.Fatalf("节点 %s 的角色应为learner，实际 %s", id, role)
+		}
+	}
+
+	// 学习者全部隔离时，三个投票成员中的多数派仍能提交
+	isolated := []transport.RuleID{c.chaos.Isolate("node4"), c.chaos.Isolate("node5")}
+	terms := map[raft.NodeID]raft.Term{"node4": c.nodes["node4"].GetMetrics().CurrentTerm, "node5": c.nodes["node5"].GetMetrics().CurrentTerm}
+	for i := 0; i < 5; i++ {
+		if err := c.set(leader, fmt.Sprintf("a-%d", i), "v", time.Second); err != nil {
+			t.Fatalf("隔离学习者后写入失败: %v", err)
+		}
+	}
+	time.Sleep(5 * chaosElectionTimeout)
+	for _, id := range learners {
+		if m := c.nodes[id].GetMetrics(); m.State != raft.Follower || m.CurrentTerm != terms[id] {
+			t.Fatalf("被隔离的学习者 %s 不应发起选举: state=%s term=%d->%d", id, m.State, terms[id], m.CurrentTerm)
+		}
+	}
+	for _, rule := range isolated {
+		c.chaos.Heal(rule)
+	}
+
+	// 两个投票跟随者被隔离时，领导者加两个学习者虽占五个节点的多数也不能提交
+	followers := others(voters, leader.GetID())
+	isolated = []transport.RuleID{c.chaos.Isolate(followers[0]), c.chaos.Isolate(followers[1])}
+	commitIndex := leader.GetMetrics().CommitIndex
+	if err := c.set(leader, "b", "v", 3*chaosElectionTimeout); err == nil {
+		t.Fatal("只有领导者与学习者确认时不应提交")
+	}
+	if got := leader.GetMetrics().CommitIndex; got != commitIndex {
+		t.Fatalf("提交索引不应推进: %d -> %d", commitIndex, got)
+	}
+	for _, rule := range isolated {
+		c.chaos.Heal(rule)
+	}
+	leader = c.waitLeader(t, c.ids, 5*time.Second)
+
+	// 领导者被隔离后新领导者只能来自投票成员
+	isolate := c.chaos.Isolate(leader.GetID())
+	newLeader := c.waitLeader(t, others(c.ids, leader.GetID()), 5*time.Second)
+	if newLeader.GetRole() != raft.RoleVoter {
+		t.Fatalf("学习者 %s 成为了领导者", newLeader.GetID())
+	}
+	if err := c.set(newLeader, "c", "v", time.Second); err != nil {
+		t.Fatalf("新领导者写入失败: %v", err)
+	}
+	c.chaos.Heal(isolate)
+
+	// 学习者收到全部日志
+	for _, id := range learners {
+		waitValue(t, c, id, "c", "v")
+	}
+}
+
+// TestPromoteLearnerWithEntriesInFlight 持续写入期间提升学习者：已确认的写入不丢失，提升后学习者计入提交仲裁
+func TestPromoteLearnerWithEntriesInFlight(t *testing.T) {
+	c := newChaosCluster(t, 4, markLearners("node4"))
+	leader := c.waitLeader(t, c.ids, 5*time.Second)
+
+	if err := leader.PromoteLearner(others(c.ids[:3], leader.GetID())[0]); !errors.Is(err, raft.ErrNotLearner) {
+		t.Fatalf("提升投票成员应返回ErrNotLearner，实际 %v", err)
+	}
+
+	var (
+		acked = make(map[string]string)
+		stop  = make(chan struct{})
+		wg    sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			key, value := fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)
+			if err := c.set(leader, key, value, time.Second); err == nil {
+				acked[key] = value
+			}
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if err := leader.PromoteLearner("node4"); err != nil {
+		t.Fatalf("提升学习者失败: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	if len(acked) == 0 {
+		t.Fatal("提升期间没有写入得到确认")
+	}
+	for _, id := range c.ids {
+		deadline := time.Now().Add(5 * time.Second)
+		for c.nodes[id].GetRole() != raft.RoleVoter {
+			if time.Now().After(deadline) {
+				t.Fatalf("节点 %s 未应用提升，配置为 %+v", id, c.nodes[id].GetConfiguration().Servers)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	for key, value := range acked {
+		waitValue(t, c, "node4", key, value)
+	}
+
+	// 四个投票成员需要三个确认：隔离一个原投票跟随者后，领导者、另一跟随者与node4仍能提交
+	followers := others(c.ids[:3], leader.GetID())
+	isolate := c.chaos.Isolate(followers[0])
+	if err := c.set(leader, "after-promote", "v", time.Second); err != nil {
+		t.Fatalf("提升后写入失败: %v", err)
+	}
+
+	// 再隔离node4后只剩两个投票成员，不足多数
+	c.chaos.Isolate("node4")
+	if err := c.set(leader, "no-quorum", "v", 3*chaosElectionTimeout); err == nil {
+		t.Fatal("四个投票成员中只有两个确认时不应提交")
+	}
+	c.chaos.Heal(isolate)
+}
+
+// waitValue 等待节点应用键值
+func waitValue(t *testing.T, c *chaosCluster, id raft.NodeID, key, value string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if got, ok := c.machines[id].Get(key); ok && got == value {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("节点 %s 未应用 %s=%s", id, key, value)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
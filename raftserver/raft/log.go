@@ -0,0 +1,32 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-11 16:42:08
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-11 16:42:08
+* @Description: ConcordKV Raft consensus - log.go
+ */
+package raft
+
+import "raftserver/logging"
+
+// ComponentLogger 以配置中注入的Logger（未注入时为默认Logger）为基础，附加组件名、节点ID及节点所在的DC
+func (c *Config) ComponentLogger(component string) logging.Logger {
+	keyvals := []interface{}{logging.FieldNodeID, string(c.NodeID)}
+	if dc := c.localDataCenter(); dc != "" {
+		keyvals = append(keyvals, logging.FieldDC, string(dc))
+	}
+	return logging.Component(c.Logger, component, keyvals...)
+}
+
+// localDataCenter 本节点所在的数据中心，成员配置中未标注时使用MultiDC.LocalDataCenter
+func (c *Config) localDataCenter() DataCenterID {
+	for _, server := range c.Servers {
+		if server.ID == c.NodeID && server.DataCenter != "" {
+			return server.DataCenter
+		}
+	}
+	if c.MultiDC != nil && c.MultiDC.LocalDataCenter != nil {
+		return c.MultiDC.LocalDataCenter.ID
+	}
+	return ""
+}
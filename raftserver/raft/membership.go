@@ -8,9 +8,13 @@
 package raft
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
+
+	"raftserver/logging"
 )
 
 // MembershipChangeType 成员变更类型
@@ -21,6 +25,8 @@ const (
 	AddServer MembershipChangeType = iota
 	// RemoveServer 移除服务器
 	RemoveServer
+	// PromoteLearner 将学习者提升为投票成员
+	PromoteLearner
 )
 
 // MembershipChange 成员变更请求
@@ -46,117 +52,420 @@ type JointConfiguration struct {
 	State            ConfigurationState `json:"state"`
 }
 
+// 成员变更错误定义
+var (
+	ErrConfigChangeInProgress = errors.New("已有未完成的成员变更")
+	ErrLearnerCatchUpTimeout  = errors.New("新节点追赶日志超时")
+	ErrNotLearner             = errors.New("服务器不是学习者")
+)
+
+const (
+	// learnerCatchUpTimeoutFactor 学习者追赶日志的超时时间（选举超时的倍数）
+	learnerCatchUpTimeoutFactor = 10
+	// configApplyTimeoutFactor 等待配置变更生效的超时时间（选举超时的倍数）
+	configApplyTimeoutFactor = 2
+	// membershipPollInterval 等待学习者追赶及配置生效时的轮询间隔
+	membershipPollInterval = 10 * time.Millisecond
+)
+
 // AddServer 添加服务器到集群
+// 新服务器先作为不参与投票的学习者追赶日志，追上后再通过配置变更日志成为正式成员；
+// server.IsLearner为true时直接以学习者身份加入配置，不改变提交仲裁，无需等待追赶
 func (n *Node) AddServer(server Server) error {
-	n.mu.Lock()
-	defer n.mu.Unlock()
+	if server.ID == "" || server.Address == "" {
+		return fmt.Errorf("服务器ID和地址不能为空")
+	}
 
+	n.mu.Lock()
 	if n.state != Leader {
+		n.mu.Unlock()
 		return ErrNotLeader
 	}
 
 	// 检查服务器是否已存在
-	for _, s := range n.config.Servers {
-		if s.ID == server.ID {
-			return fmt.Errorf("服务器 %s 已存在", server.ID)
-		}
+	if n.hasServerLocked(server.ID) {
+		n.mu.Unlock()
+		return fmt.Errorf("服务器 %s 已存在", server.ID)
 	}
 
-	n.logger.Printf("开始添加服务器: %s (%s)", server.ID, server.Address)
+	// 同一时间只允许一个成员变更
+	if n.configChangePendingLocked() {
+		n.mu.Unlock()
+		return ErrConfigChangeInProgress
+	}
 
-	// 创建成员变更命令
-	change := MembershipChange{
-		Type:   AddServer,
-		Server: server,
+	term := n.getCurrentTerm()
+	if server.IsLearner {
+		n.mu.Unlock()
+		return n.commitConfigChange(MembershipChange{Type: AddServer, Server: server}, term)
 	}
 
-	data, err := json.Marshal(change)
-	if err != nil {
-		return fmt.Errorf("序列化成员变更失败: %w", err)
+	n.learners[server.ID] = server
+	n.nextIndex[server.ID] = n.storage.GetLastLogIndex() + 1
+	n.matchIndex[server.ID] = 0
+	n.startReplicatorLocked(server.ID)
+	n.mu.Unlock()
+
+	n.addTransportPeer(server)
+
+	defer func() {
+		n.mu.Lock()
+		delete(n.learners, server.ID)
+		n.mu.Unlock()
+	}()
+
+	n.logger.Info("开始添加服务器，先作为学习者追赶日志", "server", server.ID, "address", server.Address)
+
+	if err := n.waitLearnerCatchUp(server.ID, term); err != nil {
+		n.mu.Lock()
+		delete(n.nextIndex, server.ID)
+		delete(n.matchIndex, server.ID)
+		delete(n.lastAppendTime, server.ID)
+		n.stopReplicatorLocked(server.ID)
+		n.mu.Unlock()
+		n.removeTransportPeer(server.ID)
+		return err
 	}
 
-	// 创建配置变更日志条目
-	entry := &LogEntry{
-		Index:     n.storage.GetLastLogIndex() + 1,
-		Term:      n.getCurrentTerm(),
-		Timestamp: time.Now(),
-		Type:      EntryConfiguration,
-		Data:      data,
+	return n.commitConfigChange(MembershipChange{Type: AddServer, Server: server}, term)
+}
+
+// PromoteLearner 将已追上日志的学习者提升为投票成员
+// 先按轮次等待学习者追上领导者的日志，再通过配置变更日志使其参与投票与提交仲裁
+func (n *Node) PromoteLearner(serverID NodeID) error {
+	n.mu.Lock()
+	if n.state != Leader {
+		n.mu.Unlock()
+		return ErrNotLeader
 	}
 
-	// 保存到本地日志
-	if err := n.storage.SaveLogEntries([]LogEntry{*entry}); err != nil {
-		return fmt.Errorf("保存配置变更日志失败: %w", err)
+	var learner *Server
+	for i := range n.config.Servers {
+		if n.config.Servers[i].ID == serverID {
+			learner = &n.config.Servers[i]
+			break
+		}
+	}
+	if learner == nil {
+		n.mu.Unlock()
+		return fmt.Errorf("服务器 %s 不存在", serverID)
+	}
+	if !learner.IsLearner {
+		n.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrNotLearner, serverID)
+	}
+
+	// 同一时间只允许一个成员变更
+	if n.configChangePendingLocked() {
+		n.mu.Unlock()
+		return ErrConfigChangeInProgress
 	}
 
-	n.logger.Printf("已提议添加服务器 %s 的配置变更，日志索引: %d", server.ID, entry.Index)
+	term := n.getCurrentTerm()
+	server := *learner
+	n.mu.Unlock()
 
-	// 复制到跟随者
-	go n.sendHeartbeats()
+	n.logger.Info("开始提升学习者，等待其追上日志", "server", serverID)
 
-	return nil
+	if err := n.waitLearnerCatchUp(serverID, term); err != nil {
+		return err
+	}
+
+	server.IsLearner = false
+	return n.commitConfigChange(MembershipChange{Type: PromoteLearner, Server: server}, term)
+}
+
+// commitConfigChange 提议配置变更并等待其提交、应用
+func (n *Node) commitConfigChange(change MembershipChange, term Term) error {
+	index, err := n.proposeConfigChange(change)
+	if err != nil {
+		return err
+	}
+
+	n.logger.Info("已提议配置变更", "type", change.Type, "server", change.Server.ID, "learner", change.Server.IsLearner, "index", index)
+
+	return n.waitConfigApplied(change, index, term)
 }
 
 // RemoveServer 从集群中移除服务器
+// 移除领导者自身时，领导者在提交该配置变更后退位
 func (n *Node) RemoveServer(serverID NodeID) error {
 	n.mu.Lock()
-	defer n.mu.Unlock()
-
 	if n.state != Leader {
+		n.mu.Unlock()
 		return ErrNotLeader
 	}
 
 	// 查找要移除的服务器
 	var serverToRemove *Server
-	for _, s := range n.config.Servers {
-		if s.ID == serverID {
-			serverToRemove = &s
+	for i := range n.config.Servers {
+		if n.config.Servers[i].ID == serverID {
+			serverToRemove = &n.config.Servers[i]
 			break
 		}
 	}
 
 	if serverToRemove == nil {
+		n.mu.Unlock()
 		return fmt.Errorf("服务器 %s 不存在", serverID)
 	}
 
-	// 不能移除自己（领导者）
-	if serverID == n.id {
-		return fmt.Errorf("不能移除当前领导者")
+	if len(n.config.Servers) == 1 {
+		n.mu.Unlock()
+		return fmt.Errorf("不能移除集群中的最后一个服务器")
 	}
 
-	n.logger.Printf("开始移除服务器: %s (%s)", serverID, serverToRemove.Address)
+	// 同一时间只允许一个成员变更
+	if n.configChangePendingLocked() {
+		n.mu.Unlock()
+		return ErrConfigChangeInProgress
+	}
 
-	// 创建成员变更命令
+	term := n.getCurrentTerm()
 	change := MembershipChange{
 		Type:   RemoveServer,
 		Server: *serverToRemove,
 	}
+	n.mu.Unlock()
+
+	n.logger.Info("开始移除服务器", "server", serverID, "address", change.Server.Address)
+
+	return n.commitConfigChange(change, term)
+}
+
+// waitLearnerCatchUp 按轮次等待学习者追赶日志
+// 每轮复制到轮次开始时领导者的最后索引，某一轮在一个选举超时内完成即认为已追上
+func (n *Node) waitLearnerCatchUp(learnerID NodeID, term Term) error {
+	deadline := n.clock.Now().Add(n.config.ElectionTimeout * learnerCatchUpTimeoutFactor)
+	roundTarget := n.storage.GetLastLogIndex()
+	roundStart := n.clock.Now()
+
+	for {
+		n.mu.RLock()
+		stillLeader := n.state == Leader && n.getCurrentTerm() == term
+		matchIndex := n.matchIndex[learnerID]
+		n.mu.RUnlock()
+
+		if !stillLeader {
+			return fmt.Errorf("学习者 %s 追赶日志期间失去领导者身份", learnerID)
+		}
+
+		if matchIndex >= roundTarget {
+			if n.clock.Now().Sub(roundStart) < n.config.ElectionTimeout {
+				n.logger.Info("学习者已追赶上日志", "server", learnerID, "match_index", matchIndex)
+				return nil
+			}
+
+			// 本轮耗时过长，期间可能产生了大量新日志，开始新一轮
+			roundTarget = n.storage.GetLastLogIndex()
+			roundStart = n.clock.Now()
+			continue
+		}
+
+		lastLogIndex := n.storage.GetLastLogIndex()
+
+		if n.clock.Now().After(deadline) {
+			return fmt.Errorf("%w: %s 已复制到 %d，领导者为 %d", ErrLearnerCatchUpTimeout, learnerID, matchIndex, lastLogIndex)
+		}
+
+		n.notifyReplicator(learnerID)
+		n.clock.Sleep(membershipPollInterval)
+	}
+}
 
+// proposeConfigChange 将配置变更追加到日志并开始复制
+func (n *Node) proposeConfigChange(change MembershipChange) (LogIndex, error) {
 	data, err := json.Marshal(change)
 	if err != nil {
-		return fmt.Errorf("序列化成员变更失败: %w", err)
+		return 0, fmt.Errorf("序列化成员变更失败: %w", err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.state != Leader {
+		return 0, ErrNotLeader
 	}
 
 	// 创建配置变更日志条目
 	entry := &LogEntry{
 		Index:     n.storage.GetLastLogIndex() + 1,
 		Term:      n.getCurrentTerm(),
-		Timestamp: time.Now(),
+		Timestamp: n.clock.Now(),
 		Type:      EntryConfiguration,
 		Data:      data,
 	}
 
 	// 保存到本地日志
 	if err := n.storage.SaveLogEntries([]LogEntry{*entry}); err != nil {
-		return fmt.Errorf("保存配置变更日志失败: %w", err)
+		return 0, fmt.Errorf("保存配置变更日志失败: %w", err)
+	}
+
+	if n.voterCountLocked() == 1 {
+		// 旧配置的投票成员只有自己，多数派即自己，立即提交
+		n.commitIndex = entry.Index
+		n.notifyCommitLocked(entry.Index)
+		go n.applyCommittedLogs()
 	}
+	// 唤醒复制协程，学习者与新成员也需要收到该条目
+	n.notifyReplicatorsLocked()
 
-	n.logger.Printf("已提议移除服务器 %s 的配置变更，日志索引: %d", serverID, entry.Index)
+	return entry.Index, nil
+}
 
-	// 复制到跟随者
-	go n.sendHeartbeats()
+// waitConfigApplied 等待配置变更提交并应用
+func (n *Node) waitConfigApplied(change MembershipChange, index LogIndex, term Term) error {
+	deadline := n.clock.Now().Add(n.config.ElectionTimeout * configApplyTimeoutFactor)
+
+	for {
+		n.mu.RLock()
+		var applied bool
+		switch change.Type {
+		case AddServer:
+			applied = n.hasServerLocked(change.Server.ID)
+		case RemoveServer:
+			applied = !n.hasServerLocked(change.Server.ID)
+		case PromoteLearner:
+			applied = n.isVoterLocked(change.Server.ID)
+		}
+		stillLeader := n.state == Leader && n.getCurrentTerm() == term
+		n.mu.RUnlock()
 
-	return nil
+		// 移除领导者自身时，应用后会退位，因此先检查是否已应用
+		if applied {
+			return nil
+		}
+
+		if !stillLeader {
+			return fmt.Errorf("配置变更 %d 提交前失去领导者身份，结果未知", index)
+		}
+
+		if n.clock.Now().After(deadline) {
+			return fmt.Errorf("等待配置变更 %d 提交超时", index)
+		}
+
+		n.clock.Sleep(membershipPollInterval)
+	}
+}
+
+// handOffLeadership 被移除的领导者退位后，将提交索引同步给剩余成员并触发其中一个立即选举
+func (n *Node) handOffLeadership(term Term, commitIndex LogIndex, followers []NodeID) {
+	if len(followers) == 0 {
+		return
+	}
+
+	n.mu.RLock()
+	successor := followers[0]
+	for _, id := range followers[1:] {
+		if n.matchIndex[id] > n.matchIndex[successor] {
+			successor = id
+		}
+	}
+	n.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(n.ctx, n.config.ElectionTimeout)
+	defer cancel()
+
+	for _, id := range followers {
+		// 仅携带提交索引的心跳，日志已在提交本次变更时复制
+		n.mu.RLock()
+		req := n.heartbeatRequestLocked(id, term, commitIndex)
+		n.mu.RUnlock()
+
+		if _, err := n.transport.SendAppendEntries(ctx, id, req); err != nil {
+			n.logger.Warn("同步提交索引失败", "peer", id, logging.FieldError, err)
+		}
+	}
+
+	if _, err := n.transport.SendTimeoutNow(ctx, successor, &TimeoutNowRequest{Term: term, LeaderID: n.id}); err != nil {
+		n.logger.Warn("通知继任者发起选举失败", "peer", successor, logging.FieldError, err)
+		return
+	}
+
+	n.logger.Info("已将领导权交接给继任者", "peer", successor)
+}
+
+// hasServerLocked 检查服务器是否在当前配置中（调用方需持有锁）
+func (n *Node) hasServerLocked(serverID NodeID) bool {
+	for _, s := range n.config.Servers {
+		if s.ID == serverID {
+			return true
+		}
+	}
+	return false
+}
+
+// configChangePendingLocked 检查是否有学习者正在追赶或存在未应用的配置变更（调用方需持有锁）
+func (n *Node) configChangePendingLocked() bool {
+	if len(n.learners) > 0 {
+		return true
+	}
+
+	lastIndex := n.storage.GetLastLogIndex()
+	for i := n.lastApplied + 1; i <= lastIndex; i++ {
+		entry, err := n.storage.GetLogEntry(i)
+		if err != nil {
+			continue
+		}
+
+		if entry.Type == EntryConfiguration {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addTransportPeer 若传输层支持动态对端，则注册服务器地址
+func (n *Node) addTransportPeer(server Server) {
+	if pm, ok := n.transport.(PeerManager); ok && server.ID != n.id {
+		pm.AddPeer(server.ID, server.Address)
+	}
+}
+
+// removeTransportPeer 若传输层支持动态对端，则注销服务器地址
+func (n *Node) removeTransportPeer(serverID NodeID) {
+	if pm, ok := n.transport.(PeerManager); ok && serverID != n.id {
+		pm.RemovePeer(serverID)
+	}
+}
+
+// MemberRole 节点在集群配置中的角色
+type MemberRole string
+
+const (
+	// RoleVoter 投票成员
+	RoleVoter MemberRole = "voter"
+	// RoleLearner 学习者，只接收日志与快照
+	RoleLearner MemberRole = "learner"
+	// RoleNone 不在集群配置中（等待加入或已被移除）
+	RoleNone MemberRole = "none"
+)
+
+// GetRole 获取本节点在当前集群配置中的角色
+func (n *Node) GetRole() MemberRole {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	switch {
+	case n.isVoterLocked(n.id):
+		return RoleVoter
+	case n.isLearnerLocked(n.id):
+		return RoleLearner
+	}
+	return RoleNone
+}
+
+// GetLearners 获取正在追赶日志的学习者列表
+func (n *Node) GetLearners() []Server {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	learners := make([]Server, 0, len(n.learners))
+	for _, server := range n.learners {
+		learners = append(learners, server)
+	}
+	return learners
 }
 
 // applyConfigurationChange 应用配置变更
@@ -169,14 +478,26 @@ func (n *Node) applyConfigurationChange(entry *LogEntry) error {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
+	var err error
 	switch change.Type {
 	case AddServer:
-		return n.applyAddServer(change.Server)
+		err = n.applyAddServer(change.Server)
 	case RemoveServer:
-		return n.applyRemoveServer(change.Server.ID)
+		err = n.applyRemoveServer(change.Server.ID)
+	case PromoteLearner:
+		err = n.applyPromoteLearner(change.Server.ID)
 	default:
 		return fmt.Errorf("未知的成员变更类型: %d", change.Type)
 	}
+
+	if err != nil {
+		return err
+	}
+	if entry.Index > n.configIndex {
+		n.configIndex = entry.Index
+		n.notifyConfigChange(change, entry.Index)
+	}
+	return nil
 }
 
 // applyAddServer 应用添加服务器
@@ -184,21 +505,54 @@ func (n *Node) applyAddServer(server Server) error {
 	// 检查服务器是否已存在
 	for _, s := range n.config.Servers {
 		if s.ID == server.ID {
-			n.logger.Printf("服务器 %s 已存在，跳过添加", server.ID)
+			n.logger.Info("服务器已存在，跳过添加", "server", server.ID)
 			return nil
 		}
 	}
 
 	// 添加到配置
 	n.config.Servers = append(n.config.Servers, server)
+	n.addTransportPeer(server)
 
-	// 如果是领导者，初始化新服务器的状态
+	// 如果是领导者，初始化新服务器的状态（作为学习者时已有的复制进度保留）
 	if n.state == Leader {
-		n.nextIndex[server.ID] = n.storage.GetLastLogIndex() + 1
-		n.matchIndex[server.ID] = 0
+		if _, ok := n.nextIndex[server.ID]; !ok {
+			n.nextIndex[server.ID] = n.storage.GetLastLogIndex() + 1
+			n.matchIndex[server.ID] = 0
+		}
+		n.startReplicatorLocked(server.ID)
+	}
+
+	n.logger.Info("成功添加服务器", "server", server.ID, "address", server.Address, "learner", server.IsLearner)
+	return nil
+}
+
+// applyPromoteLearner 应用学习者提升，复制进度保留，之后其确认计入提交仲裁
+func (n *Node) applyPromoteLearner(serverID NodeID) error {
+	// 复制新的成员列表，已在锁外使用旧列表的协程不受影响
+	servers := make([]Server, len(n.config.Servers))
+	copy(servers, n.config.Servers)
+
+	found := false
+	for i := range servers {
+		if servers[i].ID == serverID {
+			servers[i].IsLearner = false
+			found = true
+			break
+		}
+	}
+	if !found {
+		n.logger.Info("服务器不存在，跳过提升", "server", serverID)
+		return nil
 	}
 
-	n.logger.Printf("成功添加服务器: %s (%s)", server.ID, server.Address)
+	n.config.Servers = servers
+	n.logger.Info("学习者已提升为投票成员", "server", serverID)
+
+	// 提升后多数派可能已经确认了更多条目
+	if n.state == Leader {
+		n.tryAdvanceCommitIndex()
+	}
 	return nil
 }
 
@@ -217,7 +571,7 @@ func (n *Node) applyRemoveServer(serverID NodeID) error {
 	}
 
 	if !found {
-		n.logger.Printf("服务器 %s 不存在，跳过移除", serverID)
+		n.logger.Info("服务器不存在，跳过移除", "server", serverID)
 		return nil
 	}
 
@@ -228,16 +582,23 @@ func (n *Node) applyRemoveServer(serverID NodeID) error {
 	if n.state == Leader {
 		delete(n.nextIndex, serverID)
 		delete(n.matchIndex, serverID)
+		delete(n.lastAppendTime, serverID)
+		n.stopReplicatorLocked(serverID)
 	}
+	n.removeTransportPeer(serverID)
 
-	// 如果移除的是自己，转为跟随者并停止
+	// 如果移除的是自己，转为跟随者；不在配置中的节点不会再发起选举
 	if serverID == n.id {
-		n.logger.Printf("自己被移除，转为跟随者")
-		n.becomeFollower(n.getCurrentTerm(), "")
-		// 在实际实现中，这里可能需要优雅关闭
+		if n.state == Leader {
+			// 退位前通知剩余成员提交本次变更，并让日志最新的成员立即发起选举
+			go n.handOffLeadership(n.getCurrentTerm(), n.commitIndex, n.getFollowerIDs())
+		}
+
+		n.logger.Info("自己被移除，转为跟随者")
+		n.becomeFollowerLocked(n.getCurrentTerm(), "")
 	}
 
-	n.logger.Printf("成功移除服务器: %s", serverID)
+	n.logger.Info("成功移除服务器", "server", serverID)
 	return nil
 }
 
@@ -254,24 +615,36 @@ func (n *Node) GetConfiguration() Configuration {
 	}
 }
 
-// IsConfigurationChanging 检查是否正在进行配置变更
-func (n *Node) IsConfigurationChanging() bool {
-	// 简化实现：检查最后几个日志条目是否有配置变更
-	lastIndex := n.storage.GetLastLogIndex()
+// ClusterView 同一时刻观察到的任期、领导者与集群配置
+type ClusterView struct {
+	Term        Term     // 当前任期
+	Leader      NodeID   // 当前领导者，选举期间为空
+	Servers     []Server // 正式成员
+	ConfigIndex LogIndex // 配置所在的日志索引，配置变更后单调递增
+}
 
-	// 检查最近的几个条目
-	for i := lastIndex; i > 0 && i > lastIndex-5; i-- {
-		entry, err := n.storage.GetLogEntry(i)
-		if err != nil {
-			continue
-		}
+// GetClusterView 在同一把锁下获取任期、领导者和集群配置
+func (n *Node) GetClusterView() ClusterView {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
 
-		if entry.Type == EntryConfiguration && i > n.commitIndex {
-			return true // 有未提交的配置变更
-		}
+	servers := make([]Server, len(n.config.Servers))
+	copy(servers, n.config.Servers)
+
+	return ClusterView{
+		Term:        n.getCurrentTerm(),
+		Leader:      n.leader,
+		Servers:     servers,
+		ConfigIndex: n.configIndex,
 	}
+}
 
-	return false
+// IsConfigurationChanging 检查是否正在进行配置变更
+func (n *Node) IsConfigurationChanging() bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return n.configChangePendingLocked()
 }
 
 // validateConfiguration 验证配置的有效性
@@ -10,11 +10,14 @@ package raft
 import (
 	"context"
 	"fmt"
-	"log"
 	"math/rand"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"raftserver/clock"
+	"raftserver/logging"
+	"raftserver/queue"
 )
 
 // Node Raft节点
@@ -22,7 +25,8 @@ type Node struct {
 	// 基本信息
 	id     NodeID
 	config *Config
-	logger *log.Logger
+	logger logging.Logger
+	clock  clock.Clock // 选举与心跳定时器、租约和各类超时使用的时钟
 
 	// 组件
 	transport    Transport
@@ -40,14 +44,27 @@ type Node struct {
 	commitIndex LogIndex  // 已知已提交的最高日志索引
 	lastApplied LogIndex  // 已应用到状态机的最高日志索引
 
+	// leaderCommit 跟随者最近一次从领导者的追加请求中得知的提交索引
+	leaderCommit LogIndex
+
+	// bootCommit 节点启动后第一次得知的集群提交进度，bootCommitKnown为false时尚未得知
+	bootCommit      LogIndex
+	bootCommitKnown bool
+
 	// 领导者状态（选举后重新初始化）
 	nextIndex  map[NodeID]LogIndex // 对于每个服务器，要发送的下一个日志条目索引
 	matchIndex map[NodeID]LogIndex // 对于每个服务器，已知已复制的最高日志索引
 
+	// 复制进度（仅领导者）
+	lastAppendTime map[NodeID]time.Time   // 对于每个服务器，最后一次追加日志成功的时间
+	replicators    map[NodeID]*replicator // 对于每个服务器，流水线复制协程的状态
+
 	// 时间相关
-	lastHeartbeat   time.Time    // 最后收到心跳的时间
-	electionTimer   *time.Timer  // 选举超时定时器
-	heartbeatTicker *time.Ticker // 心跳定时器
+	lastHeartbeat     time.Time     // 最后收到心跳的时间
+	lastLeaderContact time.Time     // 最后一次收到当前领导者消息的时间（不受选举定时器重置影响）
+	electionTimer     clock.Timer   // 选举超时定时器，创建后只通过Stop/Reset调整，主循环始终等待同一个通道
+	electionDeadline  atomic.Value  // time.Time，当前选举超时的截止时间，早于它触发的超时已被重置，直接丢弃
+	roleChangeCh      chan struct{} // 角色变化时通知主循环启停心跳定时器，心跳定时器只由主循环持有
 
 	// 控制
 	ctx        context.Context    // 上下文
@@ -56,10 +73,42 @@ type Node struct {
 	wg         sync.WaitGroup     // 等待组
 
 	// 事件
-	eventListeners []EventListener
+	eventMu        sync.Mutex           // 保护eventListeners，事件可能在持有或不持有mu时产生
+	eventListeners []*eventSubscription // 写时复制，分发时不持有eventMu
 
 	// 指标
-	metrics atomic.Value // *Metrics
+	metrics      atomic.Value // *Metrics
+	elections    atomic.Int64 // 发起选举的次数，只增不减
+	lastElection atomic.Int64 // 最近一次发起选举的Unix时间
+
+	// 快照
+	applyMu           sync.Mutex                   // 串行化日志应用与快照安装
+	snapshotMetrics   SnapshotMetrics              // 快照指标（由mu保护）
+	snapshotTransfers map[NodeID]*snapshotTransfer // 向各跟随者发送快照的状态（仅领导者）
+	snapshotRecv      *snapshotReceiver            // 正在从领导者接收的快照
+
+	// 领导权转移
+	transferTarget NodeID // 正在转移领导权的目标节点，为空表示没有转移
+
+	// 成员变更
+	learners    map[NodeID]Server // 正在追赶日志、尚未成为正式成员的服务器（仅领导者）
+	configIndex LogIndex          // 当前配置所在的日志索引，来自快照时为快照索引（由mu保护）
+
+	// 提议者等待条目被应用，按日志索引登记（由mu保护）
+	applyWaiters map[LogIndex]*applyWaiter
+
+	// 线性一致读
+	leaseStart       time.Time        // 最近一次被多数派确认的心跳轮次开始时间
+	readIndexMetrics ReadIndexMetrics // ReadIndex指标（由mu保护）
+	transferElection bool             // 本次选举由TimeoutNow触发
+
+	// 提交策略
+	commitMetrics      CommitPolicyMetrics // 提交策略指标（由mu保护）
+	commitDelayedIndex LogIndex            // 已获多数派确认、等待跨DC确认的最高索引
+	commitDelayedSince time.Time           // 开始等待跨DC确认的时间，没有等待中的条目时为零值
+
+	// 预投票
+	preVoting bool // 是否正在进行预投票
 
 	// 数据中心感知扩展 ⭐ 新增
 	dcExtension      *DCRaftExtension                  // DC感知Raft扩展
@@ -68,8 +117,20 @@ type Node struct {
 
 	// 跨DC复制管理器 ⭐ 新增
 	crossDCReplication *CrossDCReplicationManager // 跨DC复制管理器
+
+	// 追赶复制限速，未配置时为nil
+	catchUp *catchUpThrottle
 }
 
+const (
+	// 远程数据中心健康检查的默认探测间隔与超时
+	defaultDCHealthCheckInterval = 5 * time.Second
+	defaultDCHealthCheckTimeout  = 2 * time.Second
+
+	// dcHealthMaxFailures 连续探测失败多少次后把节点标记为不健康
+	dcHealthMaxFailures = 3
+)
+
 // DCHealthChecker DC健康检查器
 type DCHealthChecker struct {
 	mu            sync.RWMutex
@@ -78,11 +139,20 @@ type DCHealthChecker struct {
 	healthStatus  map[NodeID]*NodeHealthStatus
 	checkInterval time.Duration
 	timeout       time.Duration
-	logger        *log.Logger
+	transport     Transport       // 实现Pinger时主动探测节点，否则只根据收到的心跳判断
+	metrics       *DCMetrics      // 检查结果汇总到的DC指标
+	ctx           context.Context // 节点停止时取消正在进行的探测
+	logger        logging.Logger
 	stopCh        chan struct{}
 	wg            sync.WaitGroup
 }
 
+// dcProbeResult 一次探测的结果
+type dcProbeResult struct {
+	rtt time.Duration
+	err error
+}
+
 // NodeHealthStatus 节点健康状态
 type NodeHealthStatus struct {
 	IsHealthy     bool
@@ -125,31 +195,50 @@ func NewNode(config *Config, transport Transport, storage Storage, stateMachine
 	ctx, cancel := context.WithCancel(context.Background())
 
 	node := &Node{
-		id:           config.NodeID,
-		config:       config,
-		logger:       log.New(log.Writer(), fmt.Sprintf("[raft-%s] ", config.NodeID), log.LstdFlags),
-		transport:    transport,
-		storage:      storage,
-		stateMachine: stateMachine,
-		state:        Follower,
-		nextIndex:    make(map[NodeID]LogIndex),
-		matchIndex:   make(map[NodeID]LogIndex),
-		ctx:          ctx,
-		cancel:       cancel,
-		shutdownCh:   make(chan struct{}),
+		id:                config.NodeID,
+		config:            config,
+		logger:            config.ComponentLogger("raft"),
+		clock:             clock.Or(config.Clock),
+		transport:         transport,
+		storage:           storage,
+		stateMachine:      stateMachine,
+		state:             Follower,
+		nextIndex:         make(map[NodeID]LogIndex),
+		matchIndex:        make(map[NodeID]LogIndex),
+		lastAppendTime:    make(map[NodeID]time.Time),
+		replicators:       make(map[NodeID]*replicator),
+		snapshotTransfers: make(map[NodeID]*snapshotTransfer),
+		learners:          make(map[NodeID]Server),
+		applyWaiters:      make(map[LogIndex]*applyWaiter),
+		catchUp:           newCatchUpThrottle(config.CatchUp),
+		ctx:               ctx,
+		cancel:            cancel,
+		shutdownCh:        make(chan struct{}),
+		roleChangeCh:      make(chan struct{}, 1),
 
 		// 初始化DC相关组件 ⭐ 新增
 		dcHealthCheckers: make(map[DataCenterID]*DCHealthChecker),
 	}
+	node.commitMetrics.Policy = node.commitPolicy()
+	// 每条日志带上输出时的任期
+	node.logger = logging.WithFunc(node.logger, logging.FieldTerm, func() interface{} { return uint64(node.getCurrentTerm()) })
 
 	// 初始化DC扩展 ⭐ 新增
 	if config.MultiDC != nil && config.MultiDC.Enabled {
+		policy, err := ParseCommitPolicy(string(config.MultiDC.CommitPolicy))
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		config.MultiDC.CommitPolicy = policy
+
 		node.dcExtension = NewDCRaftExtension(config, config.NodeID)
 		node.initializeDCMetrics()
 		node.initializeDCHealthCheckers()
 
 		// 初始化跨DC复制管理器 ⭐ 新增
 		node.crossDCReplication = NewCrossDCReplicationManager(config.NodeID, config, transport)
+		node.crossDCReplication.raftState = node.crossDCReplicationState
 	}
 
 	// 从存储恢复状态
@@ -199,6 +288,15 @@ func (n *Node) initializeDCHealthCheckers() {
 		dcNodes[server.DataCenter] = append(dcNodes[server.DataCenter], server.ID)
 	}
 
+	checkInterval := n.config.MultiDC.HealthCheckInterval
+	if checkInterval <= 0 {
+		checkInterval = defaultDCHealthCheckInterval
+	}
+	timeout := n.config.MultiDC.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = defaultDCHealthCheckTimeout
+	}
+
 	// 为每个数据中心创建健康检查器
 	for dc, nodes := range dcNodes {
 		if dc == n.dcMetrics.LocalDataCenter {
@@ -209,9 +307,12 @@ func (n *Node) initializeDCHealthCheckers() {
 			dataCenter:    dc,
 			nodes:         nodes,
 			healthStatus:  make(map[NodeID]*NodeHealthStatus),
-			checkInterval: time.Second * 5, // 5秒检查间隔
-			timeout:       time.Second * 2, // 2秒超时
-			logger:        log.New(log.Writer(), fmt.Sprintf("[dc-health-%s] ", dc), log.LstdFlags),
+			checkInterval: checkInterval,
+			timeout:       timeout,
+			transport:     n.transport,
+			metrics:       n.dcMetrics,
+			ctx:           n.ctx,
+			logger:        n.config.ComponentLogger("dc-health").With("target_dc", string(dc)),
 			stopCh:        make(chan struct{}),
 		}
 
@@ -224,6 +325,7 @@ func (n *Node) initializeDCHealthCheckers() {
 		}
 
 		n.dcHealthCheckers[dc] = checker
+		n.dcMetrics.HealthyNodesPerDC[dc] = len(nodes)
 	}
 }
 
@@ -245,7 +347,7 @@ func (n *Node) startDCComponents() error {
 	// 启动DC健康检查器
 	for dc, checker := range n.dcHealthCheckers {
 		if err := checker.start(); err != nil {
-			n.logger.Printf("启动DC健康检查器失败 [%s]: %v", dc, err)
+			n.logger.Error("启动DC健康检查器失败", "target_dc", dc, logging.FieldError, err)
 			// 继续启动其他检查器，不因为一个失败而终止
 		}
 	}
@@ -267,7 +369,7 @@ func (n *Node) stopDCComponents() {
 	// 停止DC健康检查器
 	for dc, checker := range n.dcHealthCheckers {
 		checker.stop()
-		n.logger.Printf("已停止DC健康检查器 [%s]", dc)
+		n.logger.Info("已停止DC健康检查器", "target_dc", dc)
 	}
 }
 
@@ -301,7 +403,7 @@ func (n *Node) updateNodeHealthFromHeartbeat(nodeID NodeID) {
 		}
 	}
 
-	if nodeDC == "" || nodeDC == n.dcMetrics.LocalDataCenter {
+	if nodeDC == "" || n.dcMetrics == nil || nodeDC == n.dcMetrics.LocalDataCenter {
 		return // 本地节点或未知节点
 	}
 
@@ -378,7 +480,7 @@ func (n *Node) GetDCHealthStatus() map[DataCenterID]map[NodeID]*NodeHealthStatus
 
 // Start 启动节点
 func (n *Node) Start() error {
-	n.logger.Printf("启动Raft节点 %s", n.id)
+	n.logger.Info("启动Raft节点")
 
 	// 启动传输层
 	if err := n.transport.Start(); err != nil {
@@ -400,7 +502,7 @@ func (n *Node) Start() error {
 
 // Stop 停止节点
 func (n *Node) Stop() error {
-	n.logger.Printf("停止Raft节点 %s", n.id)
+	n.logger.Info("停止Raft节点")
 
 	// 发送关闭信号
 	close(n.shutdownCh)
@@ -411,65 +513,81 @@ func (n *Node) Stop() error {
 	// 等待goroutine结束
 	n.wg.Wait()
 
-	// 停止定时器
-	if n.electionTimer != nil {
-		n.electionTimer.Stop()
-	}
-	if n.heartbeatTicker != nil {
-		n.heartbeatTicker.Stop()
-	}
+	// 停止选举定时器，心跳定时器随主循环退出停止
+	n.electionTimer.Stop()
 
 	// 停止DC相关组件 ⭐ 新增
 	n.stopDCComponents()
 
+	// 取消事件订阅，阻塞中的监听器返回后投递协程退出
+	n.stopEventListeners()
+
+	// 清理未接收完的快照，通知仍在等待的提议者
+	n.mu.Lock()
+	n.discardSnapshotReceiverLocked()
+	n.failApplyWaitersLocked(ErrProposalUnknown)
+	n.mu.Unlock()
+
 	// 停止传输层
 	if err := n.transport.Stop(); err != nil {
-		n.logger.Printf("停止传输层失败: %v", err)
+		n.logger.Error("停止传输层失败", logging.FieldError, err)
 	}
 
 	// 关闭存储
 	if err := n.storage.Close(); err != nil {
-		n.logger.Printf("关闭存储失败: %v", err)
+		n.logger.Error("关闭存储失败", logging.FieldError, err)
 	}
 
 	return nil
 }
 
-// run 主循环
+// run 主循环，阻塞在一组固定的通道上，空闲时不占用CPU
+// 心跳定时器只在主循环内创建和停止，角色变化经roleChangeCh通知；不是领导者时等待一个永不触发的通道
 func (n *Node) run() {
 	defer n.wg.Done()
 
+	never := make(chan time.Time)
+	var heartbeatTicker clock.Ticker
+	var heartbeatC <-chan time.Time = never
+	defer func() {
+		if heartbeatTicker != nil {
+			heartbeatTicker.Stop()
+		}
+	}()
+
 	for {
 		select {
 		case <-n.shutdownCh:
 			return
 		case <-n.ctx.Done():
 			return
-		case <-n.electionTimer.C:
+		case <-n.electionTimer.C():
 			n.handleElectionTimeout()
-		default:
-			// 检查心跳定时器是否存在
-			if n.heartbeatTicker != nil {
-				select {
-				case <-n.shutdownCh:
-					return
-				case <-n.ctx.Done():
-					return
-				case <-n.electionTimer.C:
-					n.handleElectionTimeout()
-				case <-n.heartbeatTicker.C:
-					n.sendHeartbeats()
-				case <-time.After(time.Millisecond * 10):
-					// 避免阻塞
-				}
-			} else {
-				// 如果没有心跳定时器，短暂休眠避免CPU占用过高
-				time.Sleep(time.Millisecond * 10)
+		case <-n.roleChangeCh:
+			isLeader := n.IsLeader()
+			switch {
+			case isLeader && heartbeatTicker == nil:
+				heartbeatTicker = n.clock.NewTicker(n.config.HeartbeatInterval)
+				heartbeatC = heartbeatTicker.C()
+			case !isLeader && heartbeatTicker != nil:
+				heartbeatTicker.Stop()
+				heartbeatTicker = nil
+				heartbeatC = never
 			}
+		case <-heartbeatC:
+			n.sendHeartbeats()
 		}
 	}
 }
 
+// notifyRoleChange 通知主循环按当前角色启停心跳定时器，未处理的通知会合并
+func (n *Node) notifyRoleChange() {
+	select {
+	case n.roleChangeCh <- struct{}{}:
+	default:
+	}
+}
+
 // restoreState 从存储恢复状态
 func (n *Node) restoreState() error {
 	// 恢复当前任期
@@ -486,10 +604,15 @@ func (n *Node) restoreState() error {
 	}
 	n.votedFor.Store(votedFor)
 
-	// 初始化commitIndex和lastApplied
-	lastLogIndex := n.storage.GetLastLogIndex()
-	n.commitIndex = lastLogIndex
-	n.lastApplied = lastLogIndex
+	// 恢复快照
+	if err := n.restoreFromSnapshot(); err != nil {
+		return err
+	}
+
+	// 初始化commitIndex和lastApplied：状态机只恢复到快照，
+	// 持久化日志中快照之后的条目在得知提交进度后重新应用
+	n.commitIndex = n.snapshotMetrics.LastSnapshotIndex
+	n.lastApplied = n.snapshotMetrics.LastSnapshotIndex
 
 	return nil
 }
@@ -530,27 +653,32 @@ func (n *Node) becomeFollower(term Term, leader NodeID) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
+	n.becomeFollowerLocked(term, leader)
+}
+
+// becomeFollowerLocked 转换为跟随者（调用方需持有写锁）
+func (n *Node) becomeFollowerLocked(term Term, leader NodeID) {
 	oldState := n.state
+	oldLeader := n.leader
 	n.state = Follower
 	n.leader = leader
+	n.leaseStart = time.Time{}
+	n.transferElection = false
+	n.stopReplicatorsLocked()
 
 	if term > n.getCurrentTerm() {
 		if err := n.setCurrentTerm(term); err != nil {
-			n.logger.Printf("设置任期失败: %v", err)
+			n.logger.Error("设置任期失败", logging.FieldError, err)
 		}
 		if err := n.setVotedFor(""); err != nil {
-			n.logger.Printf("清除投票状态失败: %v", err)
+			n.logger.Error("清除投票状态失败", logging.FieldError, err)
 		}
 	}
 
 	n.resetElectionTimer()
+	n.notifyRoleChange()
 
-	if n.heartbeatTicker != nil {
-		n.heartbeatTicker.Stop()
-		n.heartbeatTicker = nil
-	}
-
-	n.logger.Printf("转换为跟随者，任期: %d，领导者: %s", term, leader)
+	n.logger.Info("转换为跟随者", "leader", leader)
 
 	// 记录DC心跳 ⭐ 新增
 	if leader != "" {
@@ -559,8 +687,11 @@ func (n *Node) becomeFollower(term Term, leader NodeID) {
 
 	// 触发状态变更事件
 	n.notifyStateChange(oldState, n.state, term)
+	if leader != "" && leader != oldLeader {
+		n.notifyLeaderChange(oldLeader, leader, term)
+	}
 
-	n.updateMetrics()
+	n.updateMetricsLocked()
 }
 
 // becomeCandidate 转换为候选人
@@ -574,24 +705,22 @@ func (n *Node) becomeCandidate() {
 
 	// 增加任期
 	newTerm := n.getCurrentTerm() + 1
-	n.logger.Printf("准备设置新任期: %d", newTerm)
 	if err := n.setCurrentTerm(newTerm); err != nil {
-		n.logger.Printf("设置任期失败: %v", err)
+		n.logger.Error("设置任期失败", logging.FieldError, err)
 		return
 	}
-	n.logger.Printf("成功设置新任期: %d", newTerm)
+	n.elections.Add(1)
+	n.lastElection.Store(n.clock.Now().Unix())
 
 	// 投票给自己
-	n.logger.Printf("准备投票给自己: %s", n.id)
 	if err := n.setVotedFor(n.id); err != nil {
-		n.logger.Printf("投票给自己失败: %v", err)
+		n.logger.Error("投票给自己失败", logging.FieldError, err)
 		return
 	}
-	n.logger.Printf("成功投票给自己: %s", n.id)
 
 	n.resetElectionTimer()
 
-	n.logger.Printf("转换为候选人，任期: %d", newTerm)
+	n.logger.Info("转换为候选人")
 
 	// 更新DC指标 ⭐ 新增
 	if n.dcMetrics != nil {
@@ -601,23 +730,12 @@ func (n *Node) becomeCandidate() {
 		n.dcMetrics.mu.Unlock()
 	}
 
-	n.logger.Printf("DEBUG: 准备触发状态变更事件")
 	// 触发状态变更事件
 	n.notifyStateChange(oldState, n.state, newTerm)
+	n.updateMetricsLocked()
 
-	n.logger.Printf("DEBUG: 准备更新指标")
-	// 手动更新指标，避免死锁
-	n.updateMetricsUnsafe(newTerm, n.state, n.leader)
-
-	n.logger.Printf("DEBUG: 准备开始选举，启动 startElection goroutine")
 	// 开始选举
-	go func() {
-		n.logger.Printf("DEBUG: startElection goroutine 已启动")
-		n.startElection()
-		n.logger.Printf("DEBUG: startElection goroutine 已完成")
-	}()
-
-	n.logger.Printf("DEBUG: becomeCandidate 函数即将结束")
+	go n.startElection()
 }
 
 // becomeLeader 转换为领导者
@@ -629,25 +747,50 @@ func (n *Node) becomeLeader() {
 	n.state = Leader
 	n.leader = n.id
 
+	n.transferElection = false
+	n.commitDelayedIndex, n.commitDelayedSince = 0, time.Time{}
+
 	// 初始化领导者状态
 	lastLogIndex := n.storage.GetLastLogIndex()
 	for _, server := range n.config.Servers {
 		if server.ID != n.id {
 			n.nextIndex[server.ID] = lastLogIndex + 1
 			n.matchIndex[server.ID] = 0
+			delete(n.lastAppendTime, server.ID)
 		}
 	}
 
-	// 停止选举定时器
-	if n.electionTimer != nil {
-		n.electionTimer.Stop()
+	// 领导者拥有所有已提交的条目，启动后首次当选时以本地最后一条日志作为启动时的提交进度
+	if !n.bootCommitKnown {
+		n.bootCommit, n.bootCommitKnown = lastLogIndex, true
+	}
+
+	// 追加本任期的空条目，使之前任期的日志尽快提交，ReadIndex也依赖本任期已有提交
+	noop := LogEntry{
+		Index:     lastLogIndex + 1,
+		Term:      n.getCurrentTerm(),
+		Timestamp: n.clock.Now(),
+		Type:      EntryNoop,
+	}
+	if err := n.storage.SaveLogEntries([]LogEntry{noop}); err != nil {
+		n.logger.Error("追加空条目失败", logging.FieldError, err)
+	} else if n.voterCountLocked() == 1 {
+		n.commitIndex = noop.Index
+		n.notifyCommitLocked(noop.Index)
+		go n.applyCommittedLogs()
+	}
+
+	// 为每个跟随者启动流水线复制
+	for _, server := range n.config.Servers {
+		n.startReplicatorLocked(server.ID)
 	}
 
-	// 启动心跳定时器
-	n.heartbeatTicker = time.NewTicker(n.config.HeartbeatInterval)
+	// 停止选举定时器，由主循环启动心跳定时器
+	n.stopElectionTimer()
+	n.notifyRoleChange()
 
 	currentTerm := n.getCurrentTerm()
-	n.logger.Printf("成为领导者，任期: %d", currentTerm)
+	n.logger.Info("成为领导者")
 
 	// 手动更新指标，避免在锁内调用可能阻塞的方法
 	metrics := &Metrics{
@@ -656,6 +799,9 @@ func (n *Node) becomeLeader() {
 		LeaderID:    n.leader,
 		CommitIndex: n.commitIndex,
 		LastApplied: n.lastApplied,
+		Snapshot:    n.snapshotMetrics,
+		ReadIndex:   n.readIndexMetrics,
+		Commit:      n.commitMetrics,
 	}
 	n.metrics.Store(metrics)
 
@@ -670,32 +816,76 @@ func (n *Node) becomeLeader() {
 	go n.sendHeartbeats()
 }
 
-// resetElectionTimer 重置选举定时器
+// resetElectionTimer 重置选举定时器（调用方需持有写锁，NewNode中除外）
 func (n *Node) resetElectionTimer() {
-	if n.electionTimer != nil {
-		n.electionTimer.Stop()
-	}
-
 	// 随机化选举超时时间（150%-300%）
 	timeout := n.config.ElectionTimeout + time.Duration(rand.Int63n(int64(n.config.ElectionTimeout)))
-	n.electionTimer = time.NewTimer(timeout)
-	n.lastHeartbeat = time.Now()
+	n.electionDeadline.Store(n.clock.Now().Add(timeout))
+
+	if n.electionTimer == nil {
+		n.electionTimer = n.clock.NewTimer(timeout)
+	} else {
+		n.stopElectionTimer()
+		n.electionTimer.Reset(timeout)
+	}
+	n.lastHeartbeat = n.clock.Now()
+}
+
+// stopElectionTimer 停止选举定时器，并丢弃已触发但还未被主循环取走的超时
+func (n *Node) stopElectionTimer() {
+	if !n.electionTimer.Stop() {
+		select {
+		case <-n.electionTimer.C():
+		default:
+		}
+	}
 }
 
 // handleElectionTimeout 处理选举超时
 func (n *Node) handleElectionTimeout() {
+	// 主循环取走超时之前定时器已被重置
+	if deadline, ok := n.electionDeadline.Load().(time.Time); ok && n.clock.Now().Before(deadline) {
+		return
+	}
+
 	n.mu.RLock()
 	state := n.state
+	voter := n.isVoterLocked(n.id)
 	n.mu.RUnlock()
 
 	if state != Leader {
+		// 不在集群配置中的节点（等待加入或已被移除）与学习者不发起选举
+		if !voter {
+			n.mu.Lock()
+			n.resetElectionTimer()
+			n.mu.Unlock()
+			return
+		}
+
 		// 使用DC感知选举逻辑 ⭐ 修改
 		if n.shouldStartDCElection() {
-			n.logger.Printf("选举超时，开始新的选举")
+			n.mu.Lock()
+			n.transferElection = false
+			n.mu.Unlock()
+
+			if n.config.EnablePreVote {
+				// 重置定时器，预投票失败时等待下一次超时再尝试
+				n.mu.Lock()
+				n.resetElectionTimer()
+				n.mu.Unlock()
+
+				n.logger.Info("选举超时，开始预投票")
+				go n.startPreVote()
+				return
+			}
+
+			n.logger.Info("选举超时，开始新的选举")
 			n.becomeCandidate()
 		} else {
-			n.logger.Printf("DC优先级选举阻止本次选举")
+			n.logger.Info("DC优先级选举阻止本次选举")
+			n.mu.Lock()
 			n.resetElectionTimer() // 重置定时器，等待下次检查
+			n.mu.Unlock()
 		}
 	}
 }
@@ -705,36 +895,42 @@ func (n *Node) updateMetrics() {
 	n.mu.RLock()
 	defer n.mu.RUnlock()
 
+	n.updateMetricsLocked()
+}
+
+// updateMetricsLocked 更新指标（调用方需持有锁）
+func (n *Node) updateMetricsLocked() {
 	metrics := &Metrics{
 		CurrentTerm: n.getCurrentTerm(),
 		State:       n.state,
 		LeaderID:    n.leader,
 		CommitIndex: n.commitIndex,
 		LastApplied: n.lastApplied,
+		Snapshot:    n.snapshotMetrics,
+		ReadIndex:   n.readIndexMetrics,
+		Commit:      n.commitMetrics,
 	}
 
 	n.metrics.Store(metrics)
 }
 
-// updateMetricsUnsafe 不安全的指标更新（已持有锁时使用） ⭐ 新增
-func (n *Node) updateMetricsUnsafe(term Term, state NodeState, leader NodeID) {
-	// 简化的指标更新，避免类型依赖
-	n.logger.Printf("更新指标: 任期=%d, 状态=%s, 领导者=%s", term, state, leader)
-}
-
-// GetMetrics 获取指标
+// GetMetrics 获取指标，领导者附带各跟随者的复制进度
 func (n *Node) GetMetrics() *Metrics {
+	var metrics Metrics
 	if m := n.metrics.Load(); m != nil {
-		return m.(*Metrics)
+		metrics = *m.(*Metrics)
 	}
-	return &Metrics{}
-}
-
-// AddEventListener 添加事件监听器
-func (n *Node) AddEventListener(listener EventListener) {
-	n.mu.Lock()
-	defer n.mu.Unlock()
-	n.eventListeners = append(n.eventListeners, listener)
+	metrics.ElectionCount = n.elections.Load()
+	metrics.LastElectionTime = n.lastElection.Load()
+	metrics.Replication = n.replicationProgress()
+	metrics.SnapshotTransfers = n.snapshotTransferProgress()
+	if n.catchUp != nil && metrics.State == Leader {
+		metrics.CatchUp = n.catchUp.metrics()
+	}
+	if n.crossDCReplication != nil {
+		metrics.Queues = []queue.Stats{n.crossDCReplication.replicationQueue.Stats()}
+	}
+	return &metrics
 }
 
 // notifyStateChange 通知状态变更
@@ -744,12 +940,10 @@ func (n *Node) notifyStateChange(oldState, newState NodeState, term Term) {
 		OldState: oldState,
 		NewState: newState,
 		Term:     term,
-		Time:     time.Now().Unix(),
+		Time:     n.clock.Now().Unix(),
 	}
 
-	for _, listener := range n.eventListeners {
-		go listener.OnStateChange(event)
-	}
+	n.publishEvent(event)
 }
 
 // notifyLeaderChange 通知领导者变更
@@ -759,12 +953,55 @@ func (n *Node) notifyLeaderChange(oldLeader, newLeader NodeID, term Term) {
 		OldLeaderID: oldLeader,
 		NewLeaderID: newLeader,
 		Term:        term,
-		Time:        time.Now().Unix(),
+		Time:        n.clock.Now().Unix(),
+	}
+
+	n.publishEvent(event)
+}
+
+// notifySnapshot 通知快照创建或安装（调用方需持有锁）
+func (n *Node) notifySnapshot(installed bool, index LogIndex, term Term, size int64) {
+	event := SnapshotEvent{
+		NodeID:            n.id,
+		Installed:         installed,
+		LastIncludedIndex: index,
+		LastIncludedTerm:  term,
+		Size:              size,
+		Time:              n.clock.Now().Unix(),
+	}
+
+	n.publishEvent(event)
+}
+
+// notifyCommitLocked 通知领导者推进了提交索引（调用方需持有锁）
+// 与其他事件不同，提交事件在持锁时同步分发（回调中的panic同样被恢复），保证监听器先于应用结果看到提交
+func (n *Node) notifyCommitLocked(index LogIndex) {
+	event := CommitEvent{
+		NodeID:      n.id,
+		Term:        n.getCurrentTerm(),
+		CommitIndex: index,
+		Time:        n.clock.Now(),
 	}
 
-	for _, listener := range n.eventListeners {
-		go listener.OnLeaderChange(event)
+	for _, sub := range n.eventSubscriptions() {
+		if sub.accepts(event) {
+			sub.deliver(event)
+		}
+	}
+}
+
+// notifyConfigChange 通知成员变更已应用（调用方需持有锁）
+func (n *Node) notifyConfigChange(change MembershipChange, index LogIndex) {
+	event := ConfigChangeEvent{
+		NodeID:  n.id,
+		Type:    change.Type,
+		Server:  change.Server,
+		Index:   index,
+		Servers: append([]Server(nil), n.config.Servers...),
+		Time:    n.clock.Now().Unix(),
 	}
+
+	n.publishEvent(event)
 }
 
 // IsLeader 是否为领导者
@@ -797,7 +1034,7 @@ func (n *Node) GetID() NodeID {
 
 // start 启动DC健康检查器
 func (checker *DCHealthChecker) start() error {
-	checker.logger.Printf("启动DC健康检查器 [%s]", checker.dataCenter)
+	checker.logger.Info("启动DC健康检查器")
 
 	checker.wg.Add(1)
 	go checker.healthCheckLoop()
@@ -807,7 +1044,7 @@ func (checker *DCHealthChecker) start() error {
 
 // stop 停止DC健康检查器
 func (checker *DCHealthChecker) stop() {
-	checker.logger.Printf("停止DC健康检查器 [%s]", checker.dataCenter)
+	checker.logger.Info("停止DC健康检查器")
 
 	close(checker.stopCh)
 	checker.wg.Wait()
@@ -830,12 +1067,20 @@ func (checker *DCHealthChecker) healthCheckLoop() {
 	}
 }
 
-// performHealthCheck 执行健康检查
+// performHealthCheck 执行健康检查，结果汇总到DC指标的HealthyNodesPerDC和CrossDCLatencies
+// 传输层实现Pinger时主动探测各节点，否则只能在超过3倍超时没有收到心跳时把节点标记为不健康
 func (checker *DCHealthChecker) performHealthCheck() {
+	var probes map[NodeID]dcProbeResult
+	if pinger, ok := checker.transport.(Pinger); ok {
+		probes = checker.probeNodes(pinger)
+	}
+
 	checker.mu.Lock()
-	defer checker.mu.Unlock()
 
 	now := time.Now()
+	healthy := 0
+	var totalRTT time.Duration
+	measured := 0
 
 	for _, nodeID := range checker.nodes {
 		status := checker.healthStatus[nodeID]
@@ -843,16 +1088,84 @@ func (checker *DCHealthChecker) performHealthCheck() {
 			continue
 		}
 
-		// 检查是否超时
-		timeSinceLastHeartbeat := now.Sub(status.LastHeartbeat)
-		if timeSinceLastHeartbeat > checker.timeout*3 { // 3倍超时时间
+		if probes != nil {
+			result := probes[nodeID]
+			checker.applyProbeLocked(nodeID, status, result)
+			if result.err == nil {
+				totalRTT += result.rtt
+				measured++
+			}
+		} else if now.Sub(status.LastHeartbeat) > checker.timeout*3 { // 3倍超时时间
 			if status.IsHealthy {
-				checker.logger.Printf("节点 %s 健康状态变为不健康", nodeID)
+				checker.logger.Warn("节点健康状态变为不健康", "peer", nodeID)
 				status.IsHealthy = false
 				status.ErrorCount++
 			}
 		}
 
 		status.LastCheck = now
+		if status.IsHealthy {
+			healthy++
+		}
+	}
+
+	checker.mu.Unlock()
+
+	if checker.metrics == nil {
+		return
+	}
+	checker.metrics.mu.Lock()
+	checker.metrics.HealthyNodesPerDC[checker.dataCenter] = healthy
+	if measured > 0 {
+		checker.metrics.CrossDCLatencies[checker.dataCenter] = totalRTT / time.Duration(measured)
+	}
+	checker.metrics.mu.Unlock()
+}
+
+// probeNodes 并发探测数据中心内的所有节点
+func (checker *DCHealthChecker) probeNodes(pinger Pinger) map[NodeID]dcProbeResult {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[NodeID]dcProbeResult, len(checker.nodes))
+
+	for _, nodeID := range checker.nodes {
+		wg.Add(1)
+		go func(nodeID NodeID) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(checker.ctx, checker.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := pinger.Ping(ctx, nodeID)
+			rtt := time.Since(start)
+
+			mu.Lock()
+			results[nodeID] = dcProbeResult{rtt: rtt, err: err}
+			mu.Unlock()
+		}(nodeID)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// applyProbeLocked 根据探测结果更新节点状态：成功时记录往返延迟并恢复健康，
+// 连续失败dcHealthMaxFailures次后标记为不健康（调用方需持有checker.mu）
+func (checker *DCHealthChecker) applyProbeLocked(nodeID NodeID, status *NodeHealthStatus, result dcProbeResult) {
+	if result.err == nil {
+		status.LatencyMs = result.rtt.Milliseconds()
+		status.ErrorCount = 0
+		if !status.IsHealthy {
+			checker.logger.Info("节点探测成功，恢复为健康", "peer", nodeID, "rtt", result.rtt)
+			status.IsHealthy = true
+		}
+		return
+	}
+
+	status.ErrorCount++
+	if status.IsHealthy && status.ErrorCount >= dcHealthMaxFailures {
+		checker.logger.Warn("节点连续探测失败，健康状态变为不健康", "peer", nodeID, "failures", status.ErrorCount, logging.FieldError, result.err)
+		status.IsHealthy = false
 	}
 }
@@ -0,0 +1,196 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-4 22:31:06
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-4 22:31:06
+* @Description: ConcordKV Raft consensus server - node_test.go
+ */
+package raft_test
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/statemachine"
+	"raftserver/storage"
+	"raftserver/testutil"
+)
+
+// TestHeartbeatIntervalStable 领导者按HeartbeatInterval稳定发送心跳，跟随者不发送心跳
+// 心跳定时器由注入的FakeClock驱动：时钟每前进一个HeartbeatInterval，领导者向每个跟随者恰好发送一次心跳，
+// 结果不受调度延迟影响（真实时钟下一轮心跳的RPC超过间隔时，time.Ticker会丢弃错过的触发）
+func TestHeartbeatIntervalStable(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	const interval = 20 * time.Millisecond
+	fake := testutil.NewFakeClock(time.Unix(1700000000, 0))
+
+	network := &memNetwork{
+		nodes:   make(map[raft.NodeID]*raft.Node),
+		links:   make(map[[2]raft.NodeID]*memLink),
+		latency: time.Millisecond,
+	}
+	servers := []raft.Server{{ID: "node1"}, {ID: "node2"}, {ID: "node3"}}
+	for _, server := range servers {
+		config := &raft.Config{
+			NodeID:            server.ID,
+			ElectionTimeout:   100 * time.Millisecond,
+			HeartbeatInterval: interval,
+			MaxLogEntries:     16,
+			Servers:           servers,
+			Clock:             fake,
+		}
+		node, err := raft.NewNode(config, &memTransport{id: server.ID, network: network},
+			storage.NewMemoryStorage(), statemachine.NewKVStateMachine())
+		if err != nil {
+			t.Fatalf("创建节点 %s 失败: %v", server.ID, err)
+		}
+		network.nodes[server.ID] = node
+	}
+	for _, node := range network.nodes {
+		if err := node.Start(); err != nil {
+			t.Fatalf("启动节点失败: %v", err)
+		}
+		defer node.Stop()
+	}
+
+	// 推进时钟直到选出领导者并提交其任期的第一条日志
+	var leader *raft.Node
+	deadline := time.Now().Add(10 * time.Second)
+	for leader == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("等待领导者选举超时")
+		}
+		fake.Advance(interval)
+		time.Sleep(2 * time.Millisecond)
+		for _, node := range network.nodes {
+			if node.IsLeader() && node.GetMetrics().LastApplied > 0 {
+				leader = node
+			}
+		}
+	}
+
+	var mu sync.Mutex
+	var sent int
+	var foreign []raft.NodeID
+	var target raft.NodeID
+	for id := range network.nodes {
+		if id != leader.GetID() {
+			target = id
+			break
+		}
+	}
+	network.setIntercept(func(from, to raft.NodeID, req interface{}) error {
+		ae, ok := req.(*raft.AppendEntriesRequest)
+		if !ok || len(ae.Entries) > 0 {
+			return nil
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if from != leader.GetID() {
+			foreign = append(foreign, from)
+		} else if to == target {
+			sent++
+		}
+		return nil
+	})
+	defer network.setIntercept(nil)
+
+	heartbeats := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return sent
+	}
+
+	const samples = 50
+	for i := 1; i <= samples; i++ {
+		fake.Advance(interval)
+		deadline := time.Now().Add(5 * time.Second)
+		for heartbeats() < i {
+			if time.Now().After(deadline) {
+				t.Fatalf("时钟前进 %d 个心跳间隔后只发送了 %d 次心跳", i, heartbeats())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// 留出时间暴露多余的心跳
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if sent != samples {
+		t.Fatalf("时钟前进 %d 个心跳间隔，发送了 %d 次心跳", samples, sent)
+	}
+	if len(foreign) > 0 {
+		t.Fatalf("非领导者发送了心跳: %v", foreign)
+	}
+}
+
+// TestIdleNodeMainLoopBlocks 空闲节点的主循环阻塞在select上，没有轮询或休眠唤醒
+func TestIdleNodeMainLoopBlocks(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	network := &memNetwork{
+		nodes: make(map[raft.NodeID]*raft.Node),
+		links: make(map[[2]raft.NodeID]*memLink),
+	}
+	config := &raft.Config{
+		NodeID:            "idle",
+		ElectionTimeout:   time.Hour,
+		HeartbeatInterval: time.Minute,
+		Servers:           []raft.Server{{ID: "idle"}, {ID: "peer"}},
+	}
+	node, err := raft.NewNode(config, &memTransport{id: "idle", network: network},
+		storage.NewMemoryStorage(), statemachine.NewKVStateMachine())
+	if err != nil {
+		t.Fatalf("创建节点失败: %v", err)
+	}
+	if err := node.Start(); err != nil {
+		t.Fatalf("启动节点失败: %v", err)
+	}
+	defer node.Stop()
+
+	for i := 0; i < 20; i++ {
+		time.Sleep(10 * time.Millisecond)
+
+		state, found := mainLoopState()
+		if !found {
+			t.Fatalf("没有找到主循环goroutine")
+		}
+		if state != "select" {
+			t.Fatalf("第%d次采样时主循环处于 %q 状态，期望阻塞在select上", i, state)
+		}
+	}
+}
+
+// mainLoopState 返回raft主循环goroutine的等待状态，如 "select"、"sleep"、"runnable"
+func mainLoopState() (string, bool) {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if !bytes.Contains(stack, []byte("raft.(*Node).run(")) {
+			continue
+		}
+		// goroutine 首行形如 "goroutine 18 [select, 2 minutes]:"
+		header := stack[:bytes.IndexByte(stack, '\n')]
+		start, end := bytes.IndexByte(header, '['), bytes.IndexByte(header, ']')
+		state := header[start+1 : end]
+		if comma := bytes.IndexByte(state, ','); comma >= 0 {
+			state = state[:comma]
+		}
+		return string(state), true
+	}
+	return "", false
+}
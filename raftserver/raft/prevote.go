@@ -0,0 +1,157 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - prevote.go
+ */
+package raft
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"raftserver/logging"
+)
+
+// startPreVote 预投票阶段：在不增加任何节点任期的前提下确认自己能够赢得选举
+// 只有获得多数派预投票后才真正成为候选人，避免分区恢复的节点以更高任期打断稳定的领导者
+func (n *Node) startPreVote() {
+	n.mu.Lock()
+	if n.preVoting || n.state == Leader {
+		n.mu.Unlock()
+		return
+	}
+	n.preVoting = true
+
+	currentTerm := n.getCurrentTerm()
+	req := &VoteRequest{
+		Term:         currentTerm + 1, // 若赢得选举将使用的任期，本地任期不变
+		CandidateID:  n.id,
+		LastLogIndex: n.storage.GetLastLogIndex(),
+		LastLogTerm:  n.storage.GetLastLogTerm(),
+		PreVote:      true,
+	}
+	servers := n.votersLocked()
+	n.mu.Unlock()
+
+	defer func() {
+		n.mu.Lock()
+		n.preVoting = false
+		n.mu.Unlock()
+	}()
+
+	majority := len(servers)/2 + 1
+	n.logger.Info("开始预投票", "prevote_term", req.Term, "majority", majority)
+
+	granted := 1 // 自己
+	if granted >= majority {
+		n.becomeCandidate()
+		return
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	higherTerm := Term(0)
+
+	for _, server := range servers {
+		if server.ID == n.id {
+			continue
+		}
+
+		wg.Add(1)
+		go func(serverID NodeID) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(n.ctx, time.Second*2)
+			defer cancel()
+
+			resp, err := n.transport.SendVoteRequest(ctx, serverID, req)
+			if err != nil {
+				n.logger.Warn("发送预投票请求失败", "peer", serverID, logging.FieldError, err)
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if resp.Term > currentTerm && resp.Term > higherTerm {
+				higherTerm = resp.Term
+			}
+			if resp.VoteGranted {
+				granted++
+			}
+		}(server.ID)
+	}
+
+	wg.Wait()
+
+	// 发现更高任期时直接跟随，不会打断任何人
+	if higherTerm > 0 && granted < majority {
+		n.logger.Info("预投票发现更高任期，转为跟随者", "new_term", higherTerm)
+		n.becomeFollower(higherTerm, "")
+		return
+	}
+
+	if granted < majority {
+		n.logger.Info("预投票未获得多数派，保持任期", "votes", granted, "majority", majority)
+		return
+	}
+
+	// 预投票期间任期或状态可能已改变（例如收到了领导者心跳）
+	n.mu.RLock()
+	unchanged := n.state != Leader && n.getCurrentTerm() == currentTerm
+	n.mu.RUnlock()
+
+	if !unchanged {
+		return
+	}
+
+	n.logger.Info("预投票获得多数派，开始正式选举", "votes", granted, "majority", majority)
+	n.becomeCandidate()
+}
+
+// handlePreVoteLocked 处理预投票请求，不修改本地任期及投票状态（调用方需持有写锁）
+func (n *Node) handlePreVoteLocked(req *VoteRequest) *VoteResponse {
+	currentTerm := n.getCurrentTerm()
+	reject := &VoteResponse{
+		Term:        currentTerm,
+		VoteGranted: false,
+	}
+
+	if req.Term < currentTerm {
+		return reject
+	}
+
+	// 仍能收到领导者心跳时拒绝，领导者与学习者也拒绝
+	if n.state == Leader || n.isLearnerLocked(n.id) {
+		return reject
+	}
+	if n.leader != "" && n.clock.Now().Sub(n.lastLeaderContact) < n.config.ElectionTimeout {
+		n.logger.Debug("拒绝预投票：仍能收到领导者的心跳", "leader", n.leader)
+		return reject
+	}
+
+	if !n.isLogUpToDate(req.LastLogIndex, req.LastLogTerm) {
+		n.logger.Debug("拒绝预投票：候选人日志不够新", "candidate", req.CandidateID)
+		return reject
+	}
+
+	n.logger.Info("同意预投票", "candidate", req.CandidateID, "prevote_term", req.Term)
+	return &VoteResponse{
+		Term:        currentTerm,
+		VoteGranted: true,
+	}
+}
+
+// isLogUpToDate 检查候选人日志是否至少和本地一样新
+func (n *Node) isLogUpToDate(lastLogIndex LogIndex, lastLogTerm Term) bool {
+	localIndex := n.storage.GetLastLogIndex()
+	localTerm := n.storage.GetLastLogTerm()
+
+	if lastLogTerm != localTerm {
+		return lastLogTerm > localTerm
+	}
+	return lastLogIndex >= localIndex
+}
@@ -0,0 +1,106 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - progress.go
+ */
+package raft
+
+import (
+	"time"
+)
+
+// ReplicationProgress 领导者视角下单个跟随者的复制进度
+type ReplicationProgress struct {
+	MatchIndex      LogIndex  `json:"matchIndex"`               // 已知已复制的最高日志索引
+	NextIndex       LogIndex  `json:"nextIndex"`                // 下一个要发送的日志索引
+	LastAppendTime  time.Time `json:"lastAppendTime,omitempty"` // 最后一次追加日志成功的时间
+	InflightEntries int       `json:"inflightEntries"`          // 已发送但未收到响应的条目数
+	LagEntries      int64     `json:"lagEntries"`               // 落后领导者的条目数
+	LagMillis       int64     `json:"lagMillis"`                // 估算的复制延迟(ms)
+	CatchUpRate     float64   `json:"catchUpRate"`              // 最近的追赶流量速率(字节/秒)，未在追赶时为0
+	ThrottledMillis int64     `json:"throttledMillis"`          // 追赶流量因限速等待的累计时间(ms)
+	Learner         bool      `json:"learner"`                  // 是否为学习者
+}
+
+// peerProgress 在锁内复制的跟随者原始进度
+type peerProgress struct {
+	id             NodeID
+	matchIndex     LogIndex
+	nextIndex      LogIndex
+	lastAppendTime time.Time
+	inflight       int
+	learner        bool
+}
+
+// replicationProgress 获取各跟随者的复制进度，非领导者返回nil
+// 只在锁内复制原始数据，延迟估算涉及存储读取，在锁外完成
+func (n *Node) replicationProgress() map[NodeID]ReplicationProgress {
+	n.mu.RLock()
+	if n.state != Leader {
+		n.mu.RUnlock()
+		return nil
+	}
+
+	peers := make([]peerProgress, 0, len(n.config.Servers)+len(n.learners))
+	for _, server := range n.config.Servers {
+		if server.ID == n.id {
+			continue
+		}
+		peers = append(peers, n.peerProgressLocked(server.ID, server.IsLearner))
+	}
+	for id := range n.learners {
+		peers = append(peers, n.peerProgressLocked(id, true))
+	}
+	n.mu.RUnlock()
+
+	lastLogIndex := n.storage.GetLastLogIndex()
+	now := n.clock.Now()
+
+	result := make(map[NodeID]ReplicationProgress, len(peers))
+	for _, p := range peers {
+		progress := ReplicationProgress{
+			MatchIndex:      p.matchIndex,
+			NextIndex:       p.nextIndex,
+			LastAppendTime:  p.lastAppendTime,
+			InflightEntries: p.inflight,
+			Learner:         p.learner,
+		}
+		if n.catchUp != nil {
+			rate, throttled := n.catchUp.peerStats(p.id)
+			progress.CatchUpRate = rate
+			progress.ThrottledMillis = throttled.Milliseconds()
+		}
+
+		if lastLogIndex > p.matchIndex {
+			progress.LagEntries = int64(lastLogIndex - p.matchIndex)
+
+			// 以跟随者缺失的第一个条目的写入时间估算延迟；该条目已被压缩时退化为距上次成功追加的时间
+			if entry, err := n.storage.GetLogEntry(p.matchIndex + 1); err == nil && !entry.Timestamp.IsZero() {
+				progress.LagMillis = now.Sub(entry.Timestamp).Milliseconds()
+			} else if !p.lastAppendTime.IsZero() {
+				progress.LagMillis = now.Sub(p.lastAppendTime).Milliseconds()
+			}
+		}
+
+		result[p.id] = progress
+	}
+
+	return result
+}
+
+// peerProgressLocked 复制单个跟随者的进度（调用方需持有锁）
+func (n *Node) peerProgressLocked(id NodeID, learner bool) peerProgress {
+	progress := peerProgress{
+		id:             id,
+		matchIndex:     n.matchIndex[id],
+		nextIndex:      n.nextIndex[id],
+		lastAppendTime: n.lastAppendTime[id],
+		learner:        learner,
+	}
+	if r, ok := n.replicators[id]; ok {
+		progress.inflight = r.inflightEntries()
+	}
+	return progress
+}
@@ -0,0 +1,140 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-28 09:42:17
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-28 09:42:17
+* @Description: ConcordKV Raft consensus server - propose.go
+ */
+package raft
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrProposalDropped 提议的条目在提交前被截断或被其他任期的条目取代，不会被应用
+	ErrProposalDropped = errors.New("提议已被丢弃")
+
+	// ErrProposalUnknown 无法确定提议是否被应用：条目被安装的快照覆盖，或节点已停止
+	ErrProposalUnknown = errors.New("无法确定提议是否被应用")
+)
+
+// applyWaiter 等待提议被应用的调用方，只有该索引处应用的条目属于提议时的任期才算成功
+type applyWaiter struct {
+	term Term
+	done chan applyOutcome // 缓冲为1，应用协程通知时不会阻塞
+}
+
+// applyOutcome 提议的应用结果
+type applyOutcome struct {
+	result interface{}
+	err    error
+}
+
+// Propose 提议data，条目追加到本地日志后返回其索引；不是领导者时返回ErrNotLeader
+// 返回时条目尚未提交，需要知道条目是否被应用及其结果时使用Apply
+func (n *Node) Propose(ctx context.Context, data []byte) (LogIndex, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return n.ProposeWithIndex(data)
+}
+
+// Apply 提议data并等待条目提交和应用，返回状态机的结果（状态机未实现ResultStateMachine时为nil）
+// 领导权变更后条目被截断或被其他任期的条目取代时返回ErrProposalDropped；
+// ctx结束时返回ctx.Err()，此时条目仍可能在之后被应用
+func (n *Node) Apply(ctx context.Context, data []byte) (interface{}, error) {
+	return n.proposeAndWait(ctx, EntryNormal, data)
+}
+
+// Barrier 提议一个空条目并等待它被应用，返回时在它之前提议的所有条目都已应用到本地状态机
+func (n *Node) Barrier(ctx context.Context) error {
+	_, err := n.proposeAndWait(ctx, EntryNoop, nil)
+	return err
+}
+
+// proposeAndWait 追加一个条目并在同一次加锁中登记等待者，条目被应用、丢弃或ctx结束后返回
+func (n *Node) proposeAndWait(ctx context.Context, entryType EntryType, data []byte) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	indexes, err := n.proposeLocked(entryType, [][]byte{data})
+	if err != nil {
+		n.mu.Unlock()
+		return nil, err
+	}
+	index := indexes[0]
+	waiter := &applyWaiter{term: n.getCurrentTerm(), done: make(chan applyOutcome, 1)}
+	if previous, exists := n.applyWaiters[index]; exists {
+		previous.done <- applyOutcome{err: ErrProposalDropped}
+	}
+	n.applyWaiters[index] = waiter
+	n.mu.Unlock()
+
+	select {
+	case outcome := <-waiter.done:
+		return outcome.result, outcome.err
+	case <-ctx.Done():
+		n.mu.Lock()
+		if n.applyWaiters[index] == waiter {
+			delete(n.applyWaiters, index)
+		}
+		n.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// applyToStateMachine 把条目应用到状态机，状态机实现ResultStateMachine时返回其结果
+func (n *Node) applyToStateMachine(entry *LogEntry) (interface{}, error) {
+	if machine, ok := n.stateMachine.(ResultStateMachine); ok {
+		return machine.ApplyWithResult(entry)
+	}
+	return nil, n.stateMachine.Apply(entry)
+}
+
+// resolveApplyWaiterLocked 条目应用后通知等待该索引的提议者（调用方需持有写锁）
+// 该索引处应用的条目来自其他任期时，说明提议的条目已被取代
+func (n *Node) resolveApplyWaiterLocked(entry *LogEntry, result interface{}) {
+	waiter, exists := n.applyWaiters[entry.Index]
+	if !exists {
+		return
+	}
+	delete(n.applyWaiters, entry.Index)
+
+	if waiter.term != entry.Term {
+		waiter.done <- applyOutcome{err: ErrProposalDropped}
+		return
+	}
+	waiter.done <- applyOutcome{result: result}
+}
+
+// dropApplyWaitersFromLocked 日志从index开始被截断后，通知等待这些条目的提议者（调用方需持有写锁）
+func (n *Node) dropApplyWaitersFromLocked(index LogIndex) {
+	for waitIndex, waiter := range n.applyWaiters {
+		if waitIndex >= index {
+			delete(n.applyWaiters, waitIndex)
+			waiter.done <- applyOutcome{err: ErrProposalDropped}
+		}
+	}
+}
+
+// abandonApplyWaitersThroughLocked 安装快照后不再逐条应用index及之前的条目，无法得知提议是否包含在快照中（调用方需持有写锁）
+func (n *Node) abandonApplyWaitersThroughLocked(index LogIndex) {
+	for waitIndex, waiter := range n.applyWaiters {
+		if waitIndex <= index {
+			delete(n.applyWaiters, waitIndex)
+			waiter.done <- applyOutcome{err: ErrProposalUnknown}
+		}
+	}
+}
+
+// failApplyWaitersLocked 以err通知所有等待中的提议者（调用方需持有写锁）
+func (n *Node) failApplyWaitersLocked(err error) {
+	for index, waiter := range n.applyWaiters {
+		delete(n.applyWaiters, index)
+		waiter.done <- applyOutcome{err: err}
+	}
+}
@@ -0,0 +1,244 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-28 10:48:31
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-28 10:48:31
+* @Description: ConcordKV Raft consensus server - propose_test.go
+ */
+package raft_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/raft"
+	"raftserver/storage"
+	"raftserver/transport"
+)
+
+// counterCluster 以counterMachine为状态机、经ChaosTransport连接的进程内集群
+type counterCluster struct {
+	chaos    *transport.Chaos
+	ids      []raft.NodeID
+	nodes    map[raft.NodeID]*raft.Node
+	machines map[raft.NodeID]*counterMachine
+	stopped  map[raft.NodeID]bool
+}
+
+// newCounterCluster 创建并启动三节点集群，测试结束时停止尚未停止的节点
+func newCounterCluster(t *testing.T) *counterCluster {
+	t.Helper()
+
+	c := &counterCluster{
+		chaos:    transport.NewChaos(1),
+		nodes:    make(map[raft.NodeID]*raft.Node),
+		machines: make(map[raft.NodeID]*counterMachine),
+		stopped:  make(map[raft.NodeID]bool),
+	}
+	network := transport.NewMemoryNetwork()
+
+	var servers []raft.Server
+	for i := 1; i <= 3; i++ {
+		id := raft.NodeID(fmt.Sprintf("node%d", i))
+		c.ids = append(c.ids, id)
+		servers = append(servers, raft.Server{ID: id, Address: string(id)})
+	}
+	for _, id := range c.ids {
+		config := &raft.Config{
+			NodeID:            id,
+			ElectionTimeout:   chaosElectionTimeout,
+			HeartbeatInterval: chaosHeartbeatInterval,
+			MaxLogEntries:     16,
+			Servers:           servers,
+			Logger:            logging.Nop(),
+		}
+		memory := network.Transport(id)
+		machine := &counterMachine{}
+		node, err := raft.NewNode(config, c.chaos.Wrap(id, memory), storage.NewMemoryStorage(), machine)
+		if err != nil {
+			t.Fatalf("创建节点 %s 失败: %v", id, err)
+		}
+		memory.SetHandler(node)
+		c.nodes[id] = node
+		c.machines[id] = machine
+	}
+
+	for _, id := range c.ids {
+		if err := c.nodes[id].Start(); err != nil {
+			t.Fatalf("启动节点 %s 失败: %v", id, err)
+		}
+	}
+	t.Cleanup(func() {
+		c.chaos.HealAll()
+		for id, node := range c.nodes {
+			if !c.stopped[id] {
+				node.Stop()
+			}
+		}
+	})
+	return c
+}
+
+// waitLeader 等待members中出现领导者，且其本任期的空条目已被应用
+func (c *counterCluster) waitLeader(t *testing.T, members []raft.NodeID) *raft.Node {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, id := range members {
+			if node := c.nodes[id]; node.IsLeader() {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				err := node.Barrier(ctx)
+				cancel()
+				if err == nil {
+					return node
+				}
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("等待 %v 选出领导者超时", members)
+	return nil
+}
+
+// applyAsync 在后台调用Apply，返回接收错误的通道
+func applyAsync(ctx context.Context, node *raft.Node, data string) <-chan error {
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := node.Apply(ctx, []byte(data))
+		errCh <- err
+	}()
+	return errCh
+}
+
+// TestApplyReturnsResult Apply等待条目应用并返回状态机的结果，跟随者上调用返回ErrNotLeader
+func TestApplyReturnsResult(t *testing.T) {
+	c := newCounterCluster(t)
+	leader := c.waitLeader(t, c.ids)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i, want := range []int64{7, 10} {
+		result, err := leader.Apply(ctx, []byte(strconv.Itoa(7-4*i)))
+		if err != nil {
+			t.Fatalf("Apply失败: %v", err)
+		}
+		if result != want {
+			t.Fatalf("第%d次Apply的结果为 %v，期望 %d", i+1, result, want)
+		}
+	}
+
+	for _, id := range c.ids {
+		if id == leader.GetID() {
+			continue
+		}
+		if _, err := c.nodes[id].Apply(ctx, []byte("1")); !errors.Is(err, raft.ErrNotLeader) {
+			t.Errorf("跟随者 %s 上Apply应返回ErrNotLeader，实际 %v", id, err)
+		}
+		if _, err := c.nodes[id].Propose(ctx, []byte("1")); !errors.Is(err, raft.ErrNotLeader) {
+			t.Errorf("跟随者 %s 上Propose应返回ErrNotLeader，实际 %v", id, err)
+		}
+	}
+}
+
+// TestBarrierAppliesEarlierProposals Barrier返回时之前通过Propose追加的条目都已应用
+func TestBarrierAppliesEarlierProposals(t *testing.T) {
+	c := newCounterCluster(t)
+	leader := c.waitLeader(t, c.ids)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var last raft.LogIndex
+	for i := 0; i < 200; i++ {
+		index, err := leader.Propose(ctx, []byte("1"))
+		if err != nil {
+			t.Fatalf("Propose失败: %v", err)
+		}
+		if index <= last {
+			t.Fatalf("Propose返回的索引 %d 没有递增（上一个为 %d）", index, last)
+		}
+		last = index
+	}
+	if err := leader.Barrier(ctx); err != nil {
+		t.Fatalf("Barrier失败: %v", err)
+	}
+	if value := c.machines[leader.GetID()].Value(); value != 200 {
+		t.Fatalf("Barrier返回时计数为 %d，期望 200", value)
+	}
+	if applied := leader.LastApplied(); applied <= last {
+		t.Fatalf("Barrier返回时只应用到 %d，Propose的最后一个索引为 %d", applied, last)
+	}
+}
+
+// TestApplyDroppedAfterLeadershipChange 被隔离的领导者追加的条目在新领导者产生后被截断，Apply返回ErrProposalDropped
+func TestApplyDroppedAfterLeadershipChange(t *testing.T) {
+	c := newCounterCluster(t)
+	old := c.waitLeader(t, c.ids)
+
+	c.chaos.Isolate(old.GetID())
+	dropped := applyAsync(context.Background(), old, "1000")
+
+	rest := others(c.ids, old.GetID())
+	leader := c.waitLeader(t, rest)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if result, err := leader.Apply(ctx, []byte("1")); err != nil || result != int64(1) {
+		t.Fatalf("新领导者Apply的结果为 %v, %v，期望 1", result, err)
+	}
+
+	c.chaos.HealAll()
+	select {
+	case err := <-dropped:
+		if !errors.Is(err, raft.ErrProposalDropped) {
+			t.Fatalf("被取代的提议应返回ErrProposalDropped，实际 %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("旧领导者的条目被截断后Apply仍未返回")
+	}
+
+	// 旧领导者追上新领导者的日志，被丢弃的命令没有应用到任何状态机
+	if err := leader.Barrier(ctx); err != nil {
+		t.Fatalf("Barrier失败: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for c.machines[old.GetID()].Value() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("旧领导者的计数为 %d，期望 1", c.machines[old.GetID()].Value())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestApplyContextAndStop 条目无法提交时Apply随ctx结束返回，节点停止时返回ErrProposalUnknown
+func TestApplyContextAndStop(t *testing.T) {
+	c := newCounterCluster(t)
+	leader := c.waitLeader(t, c.ids)
+	c.chaos.Isolate(leader.GetID())
+	pending := applyAsync(context.Background(), leader, "1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := leader.Apply(ctx, []byte("1")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("无法提交时Apply应随ctx超时返回，实际 %v", err)
+	}
+	if err := leader.Barrier(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ctx已结束时Barrier应立即返回ctx.Err()，实际 %v", err)
+	}
+
+	c.stopped[leader.GetID()] = true
+	leader.Stop()
+
+	select {
+	case err := <-pending:
+		if !errors.Is(err, raft.ErrProposalUnknown) {
+			t.Fatalf("节点停止后Apply应返回ErrProposalUnknown，实际 %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("节点停止后Apply仍未返回")
+	}
+}
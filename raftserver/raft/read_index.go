@@ -0,0 +1,243 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - read_index.go
+ */
+package raft
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLeadershipLost 确认领导者身份期间失去领导权
+var ErrLeadershipLost = errors.New("确认领导者身份失败")
+
+// readIndexPollInterval 等待本任期提交及状态机应用时的轮询间隔
+const readIndexPollInterval = 2 * time.Millisecond
+
+// ReadIndexMetrics 线性一致读指标
+type ReadIndexMetrics struct {
+	Rounds         int64   `json:"rounds"`         // ReadIndex确认轮次（不含租约读）
+	LeaseReads     int64   `json:"leaseReads"`     // 通过租约直接服务的读次数
+	Failures       int64   `json:"failures"`       // 失败次数
+	LastLatency    float64 `json:"lastLatency"`    // 最近一次延迟(ms)
+	AverageLatency float64 `json:"averageLatency"` // 平均延迟(ms)
+}
+
+// ReadIndex 执行ReadIndex协议，返回可安全读取的索引
+// 返回时本地状态机已应用到该索引，调用方可直接读取本地状态机
+func (n *Node) ReadIndex(ctx context.Context) (LogIndex, error) {
+	start := n.clock.Now()
+
+	index, lease, err := n.readIndex(ctx)
+	n.recordReadIndex(n.clock.Now().Sub(start), lease, err)
+	if err != nil {
+		return 0, err
+	}
+
+	return index, nil
+}
+
+// readIndex ReadIndex协议主体，lease表示是否通过租约完成确认
+func (n *Node) readIndex(ctx context.Context) (LogIndex, bool, error) {
+	// 1. 等待本任期内至少提交一个条目，保证commitIndex不落后于前任领导者
+	var term Term
+	var readIndex LogIndex
+	for {
+		n.mu.RLock()
+		if n.state != Leader {
+			n.mu.RUnlock()
+			return 0, false, ErrNotLeader
+		}
+
+		term = n.getCurrentTerm()
+		readIndex = n.commitIndex
+		commitTerm, err := n.termAt(readIndex)
+		n.mu.RUnlock()
+
+		if err == nil && commitTerm == term {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, false, ctx.Err()
+		case <-n.clock.After(readIndexPollInterval):
+		}
+	}
+
+	// 2. 通过租约或一轮心跳确认自己仍是领导者
+	lease := n.config.EnableLeaseRead && n.leaseValid(term)
+	if !lease {
+		if err := n.confirmLeadership(ctx, term); err != nil {
+			return 0, false, err
+		}
+	}
+
+	// 3. 等待状态机应用到readIndex
+	for {
+		n.mu.RLock()
+		lastApplied := n.lastApplied
+		n.mu.RUnlock()
+
+		if lastApplied >= readIndex {
+			return readIndex, lease, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, lease, ctx.Err()
+		case <-n.clock.After(readIndexPollInterval):
+		}
+	}
+}
+
+// WaitApplied 等待本地状态机应用到index，返回此时的lastApplied
+// 不要求本节点是领导者，跟随者读取本地状态机前可据此实现读己之写
+func (n *Node) WaitApplied(ctx context.Context, index LogIndex) (LogIndex, error) {
+	for {
+		lastApplied := n.LastApplied()
+		if lastApplied >= index {
+			return lastApplied, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return lastApplied, ctx.Err()
+		case <-n.clock.After(readIndexPollInterval):
+		}
+	}
+}
+
+// LastApplied 已应用到本地状态机的最高日志索引
+func (n *Node) LastApplied() LogIndex {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.lastApplied
+}
+
+// LeaderCommitIndex 已知的领导者提交索引：领导者返回自己的提交索引，
+// 跟随者返回最近一次收到的追加请求中的提交索引，尚未收到时为0
+func (n *Node) LeaderCommitIndex() LogIndex {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.state == Leader {
+		return n.commitIndex
+	}
+	return n.leaderCommit
+}
+
+// BootCommitIndex 节点启动后第一次得知的集群提交进度：第一个追加请求中领导者的提交索引，
+// 本节点先当选领导者时为当选时的最后一条日志索引；尚未得知时第二个返回值为false。
+// 重启的节点应用到该索引之前，本地状态可能远远落后于集群
+func (n *Node) BootCommitIndex() (LogIndex, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.bootCommit, n.bootCommitKnown
+}
+
+// confirmLeadership 向所有投票跟随者发送一轮心跳，多数派确认后返回
+func (n *Node) confirmLeadership(ctx context.Context, term Term) error {
+	n.mu.RLock()
+	followers := n.getFollowerIDs()
+	commitIndex := n.commitIndex
+	majority := n.voterCountLocked()/2 + 1
+	n.mu.RUnlock()
+
+	roundStart := n.clock.Now()
+	acks := 1 // 领导者自己
+	if acks >= majority {
+		n.extendLease(term, roundStart)
+		return nil
+	}
+
+	ackCh := make(chan bool, len(followers))
+	var wg sync.WaitGroup
+	for _, id := range followers {
+		wg.Add(1)
+		go func(followerID NodeID) {
+			defer wg.Done()
+			ackCh <- n.sendAppendEntriesToFollower(followerID, term, commitIndex)
+		}(id)
+	}
+	go func() {
+		wg.Wait()
+		close(ackCh)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ack, ok := <-ackCh:
+			if !ok {
+				return ErrLeadershipLost
+			}
+			if ack {
+				acks++
+				if acks >= majority {
+					n.extendLease(term, roundStart)
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// leaseValid 检查领导者租约是否仍然有效
+// 跟随者在最小选举超时内不会投票给其他候选人，因此租约时长取选举超时的九成以容忍时钟漂移
+func (n *Node) leaseValid(term Term) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if n.state != Leader || n.getCurrentTerm() != term || n.leaseStart.IsZero() || n.transferTarget != "" {
+		return false
+	}
+
+	return n.clock.Now().Sub(n.leaseStart) < n.config.ElectionTimeout*9/10
+}
+
+// extendLease 多数派确认后以本轮心跳开始时间延长租约
+func (n *Node) extendLease(term Term, roundStart time.Time) {
+	if !n.config.EnableLeaseRead {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.state == Leader && n.getCurrentTerm() == term && roundStart.After(n.leaseStart) {
+		n.leaseStart = roundStart
+	}
+}
+
+// recordReadIndex 记录线性一致读指标
+func (n *Node) recordReadIndex(latency time.Duration, lease bool, err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	m := &n.readIndexMetrics
+	if err != nil {
+		m.Failures++
+		n.updateMetricsLocked()
+		return
+	}
+
+	ms := float64(latency.Microseconds()) / 1000
+	if lease {
+		m.LeaseReads++
+	} else {
+		m.Rounds++
+	}
+
+	total := m.Rounds + m.LeaseReads
+	m.AverageLatency += (ms - m.AverageLatency) / float64(total)
+	m.LastLatency = ms
+
+	n.updateMetricsLocked()
+}
@@ -0,0 +1,400 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - replicator.go
+ */
+package raft
+
+import (
+	"context"
+	"time"
+
+	"raftserver/logging"
+)
+
+// DefaultMaxInflightBatches 默认每个跟随者允许的在途追加日志批次数
+const DefaultMaxInflightBatches = 4
+
+// inflightBatch 已发送但尚未收到响应的追加日志批次
+type inflightBatch struct {
+	seq       uint64   // 批次序号，区分同一范围的重传
+	lastIndex LogIndex // 批次最后一个条目的索引
+	entries   int      // 批次条目数
+}
+
+// replicator 领导者为单个跟随者维护的流水线复制状态（除通道外的字段由n.mu保护）
+// 探测状态下同一时刻只有一个批次在途，用于定位与跟随者日志的匹配点；
+// 收到成功响应后进入流水线状态，最多保持MaxInflightBatches个批次在途，
+// nextIndex在发送时即乐观推进。批次可能乱序到达跟随者：若被拒绝仅因更早的批次尚未到达，
+// 只重传该批次及其后的部分；真正的日志冲突则回退nextIndex、丢弃所有在途批次并重新探测
+type replicator struct {
+	followerID NodeID
+	term       Term
+	probing    bool            // 是否处于探测状态
+	paused     bool            // 发送失败后暂停，直到下一次心跳恢复
+	inflight   []inflightBatch // 按发送顺序排列的在途批次
+	nextSeq    uint64          // 下一个批次序号
+	generation uint64          // 每次回退nextIndex后递增
+	dispatched chan struct{}   // 最近一个批次开始发送时关闭，使批次按顺序交给传输层
+	notifyCh   chan struct{}   // 有新日志或需要重试时唤醒
+	stopCh     chan struct{}   // 关闭复制协程
+}
+
+// inflightEntries 在途条目总数（调用方需持有锁）
+func (r *replicator) inflightEntries() int {
+	total := 0
+	for _, batch := range r.inflight {
+		total += batch.entries
+	}
+	return total
+}
+
+// removeBatch 移除已收到响应的批次，批次已被丢弃时返回false（调用方需持有锁）
+func (r *replicator) removeBatch(seq uint64) bool {
+	for i, batch := range r.inflight {
+		if batch.seq == seq {
+			r.inflight = append(r.inflight[:i], r.inflight[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// pendingBefore 是否还有结束于index及之前的在途批次（调用方需持有锁）
+func (r *replicator) pendingBefore(index LogIndex) bool {
+	for _, batch := range r.inflight {
+		if batch.lastIndex <= index {
+			return true
+		}
+	}
+	return false
+}
+
+// dropFrom 丢弃结束于index之后的在途批次（调用方需持有锁）
+func (r *replicator) dropFrom(index LogIndex) {
+	kept := r.inflight[:0]
+	for _, batch := range r.inflight {
+		if batch.lastIndex <= index {
+			kept = append(kept, batch)
+		}
+	}
+	r.inflight = kept
+}
+
+// maxInflightBatches 获取流水线窗口大小
+func (n *Node) maxInflightBatches() int {
+	if n.config.MaxInflightBatches <= 0 {
+		return DefaultMaxInflightBatches
+	}
+	return n.config.MaxInflightBatches
+}
+
+// startReplicatorLocked 为跟随者启动复制协程（调用方需持有写锁且为领导者）
+func (n *Node) startReplicatorLocked(followerID NodeID) {
+	if followerID == n.id {
+		return
+	}
+	if _, ok := n.replicators[followerID]; ok {
+		return
+	}
+
+	r := &replicator{
+		followerID: followerID,
+		term:       n.getCurrentTerm(),
+		probing:    true,
+		notifyCh:   make(chan struct{}, 1),
+		stopCh:     make(chan struct{}),
+	}
+	n.replicators[followerID] = r
+
+	go n.runReplicator(r)
+	r.notify()
+}
+
+// stopReplicatorLocked 停止跟随者的复制协程（调用方需持有写锁）
+func (n *Node) stopReplicatorLocked(followerID NodeID) {
+	if r, ok := n.replicators[followerID]; ok {
+		close(r.stopCh)
+		delete(n.replicators, followerID)
+	}
+	delete(n.snapshotTransfers, followerID)
+}
+
+// stopReplicatorsLocked 停止所有复制协程（调用方需持有写锁）
+func (n *Node) stopReplicatorsLocked() {
+	for id := range n.replicators {
+		n.stopReplicatorLocked(id)
+	}
+}
+
+// notifyReplicatorsLocked 唤醒所有复制协程（调用方需持有锁）
+func (n *Node) notifyReplicatorsLocked() {
+	for _, r := range n.replicators {
+		r.notify()
+	}
+}
+
+// notifyReplicator 唤醒指定跟随者的复制协程
+func (n *Node) notifyReplicator(followerID NodeID) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if r, ok := n.replicators[followerID]; ok {
+		r.notify()
+	}
+}
+
+// notify 非阻塞地唤醒复制协程
+func (r *replicator) notify() {
+	select {
+	case r.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// runReplicator 复制协程主循环
+func (n *Node) runReplicator(r *replicator) {
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case <-n.ctx.Done():
+			return
+		case <-r.notifyCh:
+			n.replicate(r)
+		}
+	}
+}
+
+// activeLocked 复制协程是否仍然有效（调用方需持有锁）
+func (n *Node) activeLocked(r *replicator) bool {
+	return n.state == Leader && n.getCurrentTerm() == r.term && n.replicators[r.followerID] == r
+}
+
+// replicate 在窗口允许的范围内持续发送追加日志批次
+func (n *Node) replicate(r *replicator) {
+	for {
+		n.mu.Lock()
+		if !n.activeLocked(r) {
+			n.mu.Unlock()
+			return
+		}
+
+		if r.paused {
+			n.mu.Unlock()
+			return
+		}
+
+		window := n.maxInflightBatches()
+		if r.probing {
+			window = 1
+		}
+		if len(r.inflight) >= window {
+			n.mu.Unlock()
+			return
+		}
+
+		nextIndex := n.nextIndex[r.followerID]
+		snapshotIndex := n.snapshotMetrics.LastSnapshotIndex
+
+		// 跟随者需要的日志已被快照压缩，等在途批次结束后改为发送快照
+		if snapshotIndex > 0 && nextIndex <= snapshotIndex {
+			if len(r.inflight) > 0 {
+				n.mu.Unlock()
+				return
+			}
+			if t := n.snapshotTransfers[r.followerID]; t != nil && t.active {
+				n.mu.Unlock()
+				return
+			}
+			n.mu.Unlock()
+
+			installed := n.sendSnapshotToFollower(r.followerID, r.term, r.stopCh)
+			if !installed {
+				// 发送中断时等待下一次心跳唤醒，从跟随者已确认的偏移续传
+				n.mu.Lock()
+				r.paused = true
+				n.mu.Unlock()
+			}
+
+			if !installed {
+				return
+			}
+			continue
+		}
+
+		// 探测状态下即使没有新日志，也要确认跟随者是否拥有nextIndex之前的日志（如新加入的学习者）
+		lastLogIndex := n.storage.GetLastLogIndex()
+		probe := r.probing && n.matchIndex[r.followerID]+1 < nextIndex
+		if nextIndex > lastLogIndex && !probe {
+			n.mu.Unlock()
+			return
+		}
+
+		prevLogIndex := nextIndex - 1
+		prevLogTerm, err := n.termAt(prevLogIndex)
+		if err != nil {
+			n.mu.Unlock()
+			n.logger.Error("获取日志条目失败", "index", prevLogIndex, logging.FieldError, err)
+			return
+		}
+		generation := r.generation
+		leaderCommit := n.commitIndex
+		catchingUp := n.catchUp != nil && n.catchUp.lagging(lastLogIndex-n.matchIndex[r.followerID])
+		n.mu.Unlock()
+
+		var entries []LogEntry
+		if nextIndex <= lastLogIndex {
+			// 计算要发送的条目数量（限制在配置的最大值内）
+			endIndex := nextIndex + LogIndex(n.config.MaxLogEntries) - 1
+			if endIndex > lastLogIndex {
+				endIndex = lastLogIndex
+			}
+
+			entries, err = n.storage.GetLogEntries(nextIndex, endIndex)
+			if err != nil || len(entries) == 0 {
+				n.logger.Error("获取日志条目失败", "from", nextIndex, "to", endIndex, logging.FieldError, err)
+				return
+			}
+
+			// 远远落后的跟随者批量追赶日志时限速，等待期间到达的响应可能使本批次失效，由下面的检查重新计算
+			if catchingUp && !n.throttleCatchUp(r.followerID, entriesSize(entries), r.stopCh) {
+				return
+			}
+		}
+
+		n.mu.Lock()
+		// 读取日志期间收到了拒绝响应或其他批次已发出，重新计算
+		if !n.activeLocked(r) || r.generation != generation || n.nextIndex[r.followerID] != nextIndex {
+			n.mu.Unlock()
+			continue
+		}
+
+		batch := inflightBatch{seq: r.nextSeq, lastIndex: prevLogIndex + LogIndex(len(entries)), entries: len(entries)}
+		r.nextSeq++
+		r.inflight = append(r.inflight, batch)
+		prev := r.dispatched
+		dispatched := make(chan struct{})
+		r.dispatched = dispatched
+		n.nextIndex[r.followerID] = batch.lastIndex + 1
+		n.mu.Unlock()
+
+		req := &AppendEntriesRequest{
+			Term:         r.term,
+			LeaderID:     n.id,
+			PrevLogIndex: prevLogIndex,
+			PrevLogTerm:  prevLogTerm,
+			Entries:      entries,
+			LeaderCommit: leaderCommit,
+		}
+
+		go n.sendReplicationBatch(r, batch.seq, req, prev, dispatched)
+	}
+}
+
+// sendReplicationBatch 等前一个批次开始发送后发送本批次并处理响应，响应可能乱序到达
+func (n *Node) sendReplicationBatch(r *replicator, seq uint64, req *AppendEntriesRequest, prev, dispatched chan struct{}) {
+	ctx, cancel := context.WithTimeout(n.ctx, time.Second*5)
+	defer cancel()
+
+	if prev != nil {
+		select {
+		case <-prev:
+		case <-ctx.Done():
+		}
+	}
+	close(dispatched)
+
+	resp, err := n.transport.SendAppendEntries(ctx, r.followerID, req)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	lastIndex := req.PrevLogIndex + LogIndex(len(req.Entries))
+	pending := r.removeBatch(seq)
+
+	if !n.activeLocked(r) {
+		return
+	}
+
+	if err != nil {
+		n.logger.Debug("发送追加日志失败", "peer", r.followerID, logging.FieldError, err)
+		// 后续批次的前提已不成立，从已确认的位置重新探测；由下一次心跳唤醒重试，避免空转
+		if pending {
+			n.rewindReplicatorLocked(r, n.matchIndex[r.followerID]+1)
+			r.paused = true
+		}
+		return
+	}
+
+	if resp.Term > req.Term {
+		n.logger.Info("收到更高任期，转为跟随者", "new_term", resp.Term)
+		n.becomeFollowerLocked(resp.Term, "")
+		return
+	}
+
+	if resp.Success {
+		n.lastAppendTime[r.followerID] = n.clock.Now()
+
+		// 成功响应即使来自已丢弃的批次也说明跟随者日志与领导者一致
+		if lastIndex > n.matchIndex[r.followerID] {
+			n.matchIndex[r.followerID] = lastIndex
+			n.logger.Debug("复制日志成功", "peer", r.followerID, "match_index", lastIndex)
+			n.tryAdvanceCommitIndex()
+		}
+
+		if pending {
+			r.probing = false
+		}
+		if n.nextIndex[r.followerID] <= lastIndex {
+			n.nextIndex[r.followerID] = lastIndex + 1
+		}
+		r.notify()
+		return
+	}
+
+	// 已丢弃批次的拒绝无需处理
+	if !pending {
+		return
+	}
+
+	// 跟随者日志较短且更早的批次仍在途：本批次先于前序批次到达，只重传本批次及其后的部分
+	if resp.ConflictTerm == 0 && resp.ConflictIndex > n.matchIndex[r.followerID] && r.pendingBefore(req.PrevLogIndex) {
+		r.dropFrom(req.PrevLogIndex)
+		if n.nextIndex[r.followerID] > req.PrevLogIndex+1 {
+			n.nextIndex[r.followerID] = req.PrevLogIndex + 1
+		}
+		r.notify()
+		return
+	}
+
+	// 日志不一致，根据本批次的前提回退nextIndex
+	nextIndex := req.PrevLogIndex
+	if resp.ConflictTerm != 0 {
+		// 使用冲突优化
+		nextIndex = n.findConflictIndex(resp.ConflictTerm, resp.ConflictIndex)
+	} else if resp.ConflictIndex > 0 && resp.ConflictIndex <= req.PrevLogIndex {
+		// 跟随者日志较短，直接跳到其日志末尾
+		nextIndex = resp.ConflictIndex
+	}
+	if nextIndex <= n.matchIndex[r.followerID] {
+		nextIndex = n.matchIndex[r.followerID] + 1
+	}
+	if nextIndex < 1 {
+		nextIndex = 1
+	}
+
+	n.rewindReplicatorLocked(r, nextIndex)
+	n.logger.Debug("日志不一致，回退nextIndex", "peer", r.followerID, "next_index", nextIndex)
+	r.notify()
+}
+
+// rewindReplicatorLocked 回退nextIndex并丢弃所有在途批次，回到探测状态（调用方需持有写锁）
+func (n *Node) rewindReplicatorLocked(r *replicator, nextIndex LogIndex) {
+	r.generation++
+	r.inflight = nil
+	r.probing = true
+	n.nextIndex[r.followerID] = nextIndex
+}
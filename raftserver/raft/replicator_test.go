@@ -0,0 +1,299 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - replicator_test.go
+ */
+package raft_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/statemachine"
+	"raftserver/storage"
+)
+
+// memNetwork 进程内网络，按固定单程延迟投递RPC，用于模拟高延迟链路
+// 同一方向的链路像TCP流一样按发送顺序投递
+type memNetwork struct {
+	mu      sync.Mutex
+	nodes   map[raft.NodeID]*raft.Node
+	links   map[[2]raft.NodeID]*memLink
+	latency time.Duration // 单程延迟
+
+	// intercept 在投递前检查请求，返回错误表示该请求丢失；可以修改请求以模拟损坏
+	intercept func(from, to raft.NodeID, req interface{}) error
+}
+
+// memLink 单向链路，保证请求按发送顺序被处理
+type memLink struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	next    uint64 // 下一个发送序号
+	serving uint64 // 当前允许处理的序号
+}
+
+// memTransport 绑定到memNetwork的传输层
+type memTransport struct {
+	id      raft.NodeID
+	network *memNetwork
+}
+
+// check 调用拦截函数判断请求是否被投递
+func (nw *memNetwork) check(from, to raft.NodeID, req interface{}) error {
+	nw.mu.Lock()
+	intercept := nw.intercept
+	nw.mu.Unlock()
+
+	if intercept == nil {
+		return nil
+	}
+	return intercept(from, to, req)
+}
+
+// setIntercept 设置请求拦截函数，nil表示全部投递
+func (nw *memNetwork) setIntercept(intercept func(from, to raft.NodeID, req interface{}) error) {
+	nw.mu.Lock()
+	nw.intercept = intercept
+	nw.mu.Unlock()
+}
+
+// call 经过单程延迟后在目标节点上按链路顺序执行handle，再经过单程延迟返回
+func (t *memTransport) call(ctx context.Context, target raft.NodeID, req interface{}, handle func(node *raft.Node)) error {
+	t.network.mu.Lock()
+	node, ok := t.network.nodes[target]
+	key := [2]raft.NodeID{t.id, target}
+	link, exists := t.network.links[key]
+	if !exists {
+		link = &memLink{}
+		link.cond = sync.NewCond(&link.mu)
+		t.network.links[key] = link
+	}
+	latency := t.network.latency
+	t.network.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("未知节点 %s", target)
+	}
+	if err := t.network.check(t.id, target, req); err != nil {
+		return err
+	}
+
+	link.mu.Lock()
+	seq := link.next
+	link.next++
+	link.mu.Unlock()
+
+	time.Sleep(latency)
+
+	link.mu.Lock()
+	for link.serving != seq {
+		link.cond.Wait()
+	}
+	if ctx.Err() == nil {
+		handle(node)
+	}
+	link.serving++
+	link.cond.Broadcast()
+	link.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	time.Sleep(latency)
+	return nil
+}
+
+func (t *memTransport) SendVoteRequest(ctx context.Context, target raft.NodeID, req *raft.VoteRequest) (*raft.VoteResponse, error) {
+	var resp *raft.VoteResponse
+	err := t.call(ctx, target, req, func(node *raft.Node) { resp = node.HandleVoteRequest(req) })
+	return resp, err
+}
+
+func (t *memTransport) SendAppendEntries(ctx context.Context, target raft.NodeID, req *raft.AppendEntriesRequest) (*raft.AppendEntriesResponse, error) {
+	var resp *raft.AppendEntriesResponse
+	err := t.call(ctx, target, req, func(node *raft.Node) { resp = node.HandleAppendEntries(req) })
+	return resp, err
+}
+
+func (t *memTransport) SendInstallSnapshot(ctx context.Context, target raft.NodeID, req *raft.InstallSnapshotRequest) (*raft.InstallSnapshotResponse, error) {
+	var resp *raft.InstallSnapshotResponse
+	err := t.call(ctx, target, req, func(node *raft.Node) { resp = node.HandleInstallSnapshot(req) })
+	return resp, err
+}
+
+func (t *memTransport) SendTimeoutNow(ctx context.Context, target raft.NodeID, req *raft.TimeoutNowRequest) (*raft.TimeoutNowResponse, error) {
+	var resp *raft.TimeoutNowResponse
+	err := t.call(ctx, target, req, func(node *raft.Node) { resp = node.HandleTimeoutNow(req) })
+	return resp, err
+}
+
+// memPing 探测请求，经过与其他RPC相同的链路延迟和拦截
+type memPing struct{}
+
+func (t *memTransport) Ping(ctx context.Context, target raft.NodeID) error {
+	return t.call(ctx, target, memPing{}, func(node *raft.Node) {})
+}
+
+func (t *memTransport) Start() error      { return nil }
+func (t *memTransport) Stop() error       { return nil }
+func (t *memTransport) LocalAddr() string { return string(t.id) }
+
+// newMemCluster 创建三节点进程内集群并等待选出领导者
+func newMemCluster(tb testing.TB, latency time.Duration, maxInflight int) (*raft.Node, func()) {
+	tb.Helper()
+
+	_, leader, stop := newMemClusterWithConfig(tb, latency, func(config *raft.Config) {
+		config.MaxInflightBatches = maxInflight
+	})
+	return leader, stop
+}
+
+// newMemClusterWithConfig 创建三节点进程内集群，configure可调整各节点配置，返回网络、领导者与停止函数
+func newMemClusterWithConfig(tb testing.TB, latency time.Duration, configure func(config *raft.Config)) (*memNetwork, *raft.Node, func()) {
+	tb.Helper()
+
+	network := &memNetwork{
+		nodes:   make(map[raft.NodeID]*raft.Node),
+		links:   make(map[[2]raft.NodeID]*memLink),
+		latency: latency,
+	}
+
+	servers := []raft.Server{{ID: "node1"}, {ID: "node2"}, {ID: "node3"}}
+	for _, server := range servers {
+		config := &raft.Config{
+			NodeID:            server.ID,
+			ElectionTimeout:   20 * latency,
+			HeartbeatInterval: 4 * latency,
+			MaxLogEntries:     16,
+			Servers:           servers,
+		}
+		if configure != nil {
+			configure(config)
+		}
+
+		node, err := raft.NewNode(config, &memTransport{id: server.ID, network: network},
+			storage.NewMemoryStorage(), statemachine.NewKVStateMachine())
+		if err != nil {
+			tb.Fatalf("创建节点 %s 失败: %v", server.ID, err)
+		}
+		network.nodes[server.ID] = node
+	}
+
+	for _, node := range network.nodes {
+		if err := node.Start(); err != nil {
+			tb.Fatalf("启动节点失败: %v", err)
+		}
+	}
+
+	stop := func() {
+		for _, node := range network.nodes {
+			node.Stop()
+		}
+	}
+
+	deadline := time.Now().Add(100 * latency)
+	for time.Now().Before(deadline) {
+		for _, node := range network.nodes {
+			if node.IsLeader() && node.GetMetrics().LastApplied > 0 {
+				return network, node, stop
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stop()
+	tb.Fatalf("等待领导者选举超时")
+	return nil, nil, nil
+}
+
+// waitApplied 等待领导者应用到指定索引
+func waitApplied(tb testing.TB, leader *raft.Node, index raft.LogIndex, timeout time.Duration) {
+	tb.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for leader.GetMetrics().LastApplied < index {
+		if time.Now().After(deadline) {
+			tb.Fatalf("等待应用到 %d 超时，当前为 %d", index, leader.GetMetrics().LastApplied)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+// TestPipelinedReplicationCommits 流水线复制下大量提议全部提交，且跟随者进度一致
+func TestPipelinedReplicationCommits(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	leader, stop := newMemCluster(t, 5*time.Millisecond, raft.DefaultMaxInflightBatches)
+	defer stop()
+
+	var last raft.LogIndex
+	for i := 0; i < 200; i++ {
+		index, err := leader.ProposeWithIndex([]byte(fmt.Sprintf(`{"type":"SET","key":"k%d","value":"v"}`, i)))
+		if err != nil {
+			t.Fatalf("提议失败: %v", err)
+		}
+		last = index
+	}
+
+	waitApplied(t, leader, last, 10*time.Second)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		caughtUp := true
+		for id, progress := range leader.GetMetrics().Replication {
+			if progress.MatchIndex != last || progress.InflightEntries != 0 {
+				caughtUp = false
+				if time.Now().After(deadline) {
+					t.Fatalf("跟随者 %s 复制进度异常: %+v，期望matchIndex %d", id, progress, last)
+				}
+			}
+		}
+		if caughtUp {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// BenchmarkReplicationPipeline 比较50ms RTT下有无流水线时的提交吞吐
+func BenchmarkReplicationPipeline(b *testing.B) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	for _, inflight := range []int{1, raft.DefaultMaxInflightBatches} {
+		b.Run(fmt.Sprintf("inflight=%d", inflight), func(b *testing.B) {
+			leader, stop := newMemCluster(b, 25*time.Millisecond, inflight)
+			defer stop()
+
+			data := []byte(`{"type":"SET","key":"bench","value":"v"}`)
+
+			b.ResetTimer()
+			start := time.Now()
+
+			var last raft.LogIndex
+			for i := 0; i < b.N; i++ {
+				index, err := leader.ProposeWithIndex(data)
+				if err != nil {
+					b.Fatalf("提议失败: %v", err)
+				}
+				last = index
+			}
+			waitApplied(b, leader, last, time.Minute)
+
+			b.StopTimer()
+			b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "entries/s")
+		})
+	}
+}
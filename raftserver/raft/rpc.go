@@ -8,8 +8,8 @@
 package raft
 
 import (
-	"fmt"
-	"time"
+	"raftserver/kverrors"
+	"raftserver/logging"
 )
 
 // HandleVoteRequest 处理投票请求
@@ -19,11 +19,25 @@ func (n *Node) HandleVoteRequest(req *VoteRequest) *VoteResponse {
 
 	currentTerm := n.getCurrentTerm()
 
-	n.logger.Printf("收到来自 %s 的投票请求，任期: %d", req.CandidateID, req.Term)
+	if req.PreVote {
+		return n.handlePreVoteLocked(req)
+	}
+
+	n.logger.Info("收到投票请求", "candidate", req.CandidateID, "candidate_term", req.Term)
 
 	// 1. 如果候选人任期小于当前任期，拒绝投票
 	if req.Term < currentTerm {
-		n.logger.Printf("拒绝投票：候选人任期 %d 小于当前任期 %d", req.Term, currentTerm)
+		n.logger.Info("拒绝投票：候选人任期小于当前任期", "candidate_term", req.Term)
+		return &VoteResponse{
+			Term:        currentTerm,
+			VoteGranted: false,
+		}
+	}
+
+	// 启用租约读时，跟随者在选举超时内收到过领导者心跳则拒绝投票，保证领导者租约有效
+	if n.config.EnableLeaseRead && !req.LeadershipTransfer && n.state == Follower &&
+		n.leader != "" && n.leader != req.CandidateID && n.clock.Now().Sub(n.lastLeaderContact) < n.config.ElectionTimeout {
+		n.logger.Info("拒绝投票：领导者的租约仍然有效", "leader", n.leader)
 		return &VoteResponse{
 			Term:        currentTerm,
 			VoteGranted: false,
@@ -32,17 +46,25 @@ func (n *Node) HandleVoteRequest(req *VoteRequest) *VoteResponse {
 
 	// 2. 如果候选人任期大于当前任期，转为跟随者
 	if req.Term > currentTerm {
-		n.logger.Printf("收到更高任期 %d，转为跟随者", req.Term)
-		n.becomeFollower(req.Term, "")
+		n.logger.Info("收到更高任期，转为跟随者", "new_term", req.Term)
+		n.becomeFollowerLocked(req.Term, "")
 		currentTerm = req.Term
 	}
 
-	// 3. 检查投票条件
+	// 3. 检查投票条件，学习者不参与投票
+	if n.isLearnerLocked(n.id) {
+		n.logger.Info("拒绝投票：本节点是学习者", "candidate", req.CandidateID)
+		return &VoteResponse{
+			Term:        currentTerm,
+			VoteGranted: false,
+		}
+	}
+
 	votedFor := n.getVotedFor()
 
 	// 如果已经投票给其他候选人，拒绝投票
 	if votedFor != "" && votedFor != req.CandidateID {
-		n.logger.Printf("拒绝投票：已投票给 %s", votedFor)
+		n.logger.Info("拒绝投票：已投票给其他候选人", "voted_for", votedFor)
 		return &VoteResponse{
 			Term:        currentTerm,
 			VoteGranted: false,
@@ -61,8 +83,7 @@ func (n *Node) HandleVoteRequest(req *VoteRequest) *VoteResponse {
 	}
 
 	if !logUpToDate {
-		n.logger.Printf("拒绝投票：候选人日志不够新 (候选人: term=%d, index=%d; 自己: term=%d, index=%d)",
-			req.LastLogTerm, req.LastLogIndex, lastLogTerm, lastLogIndex)
+		n.logger.Info("拒绝投票：候选人日志不够新", "candidate_last_term", req.LastLogTerm, "candidate_last_index", req.LastLogIndex, "last_log_term", lastLogTerm, "last_log_index", lastLogIndex)
 		return &VoteResponse{
 			Term:        currentTerm,
 			VoteGranted: false,
@@ -71,7 +92,7 @@ func (n *Node) HandleVoteRequest(req *VoteRequest) *VoteResponse {
 
 	// 5. 投票给候选人
 	if err := n.setVotedFor(req.CandidateID); err != nil {
-		n.logger.Printf("保存投票状态失败: %v", err)
+		n.logger.Error("保存投票状态失败", logging.FieldError, err)
 		return &VoteResponse{
 			Term:        currentTerm,
 			VoteGranted: false,
@@ -81,7 +102,7 @@ func (n *Node) HandleVoteRequest(req *VoteRequest) *VoteResponse {
 	// 重置选举定时器（收到了有效的候选人请求）
 	n.resetElectionTimer()
 
-	n.logger.Printf("投票给候选人 %s", req.CandidateID)
+	n.logger.Info("投票给候选人", "candidate", req.CandidateID)
 
 	return &VoteResponse{
 		Term:        currentTerm,
@@ -107,15 +128,21 @@ func (n *Node) HandleAppendEntries(req *AppendEntriesRequest) *AppendEntriesResp
 	// 如果请求的任期更高，更新当前任期并转为跟随者
 	if req.Term > currentTerm {
 		if err := n.setCurrentTerm(req.Term); err != nil {
-			n.logger.Printf("设置任期失败: %v", err)
+			n.logger.Error("设置任期失败", logging.FieldError, err)
 		}
 		if err := n.setVotedFor(""); err != nil {
-			n.logger.Printf("清除投票状态失败: %v", err)
+			n.logger.Error("清除投票状态失败", logging.FieldError, err)
 		}
-		n.becomeFollower(req.Term, req.LeaderID)
+		n.becomeFollowerLocked(req.Term, req.LeaderID)
 	} else if n.state != Follower {
 		// 如果任期相同但不是跟随者，转为跟随者
-		n.becomeFollower(req.Term, req.LeaderID)
+		n.becomeFollowerLocked(req.Term, req.LeaderID)
+	} else if n.leader != req.LeaderID {
+		// 跟随者首次得知（或更换）本任期的领导者
+		oldLeader := n.leader
+		n.leader = req.LeaderID
+		n.notifyLeaderChange(oldLeader, req.LeaderID, req.Term)
+		n.updateMetricsLocked()
 	}
 
 	// 记录DC心跳（无论是否同步复制） ⭐ 新增
@@ -130,23 +157,36 @@ func (n *Node) HandleAppendEntries(req *AppendEntriesRequest) *AppendEntriesResp
 
 	// 重置选举定时器
 	n.resetElectionTimer()
-	n.lastHeartbeat = time.Now()
+	n.lastHeartbeat = n.clock.Now()
+	n.lastLeaderContact = n.lastHeartbeat
+	if req.LeaderCommit > n.leaderCommit {
+		n.leaderCommit = req.LeaderCommit
+	}
+	if !n.bootCommitKnown {
+		n.bootCommit, n.bootCommitKnown = req.LeaderCommit, true
+	}
 
 	// 检查日志一致性
 	if !n.checkLogConsistency(req.PrevLogIndex, req.PrevLogTerm) {
-		return &AppendEntriesResponse{
+		resp := &AppendEntriesResponse{
 			Term:    req.Term,
 			Success: false,
 		}
+
+		// 本地日志比prevLogIndex短时，告知领导者直接从本地末尾之后开始发送
+		if lastLogIndex := n.storage.GetLastLogIndex(); req.PrevLogIndex > lastLogIndex {
+			resp.ConflictIndex = lastLogIndex + 1
+		}
+		return resp
 	}
 
 	// 如果有新条目，添加到日志
 	if len(req.Entries) > 0 {
-		n.logger.Printf("收到 %d 个新日志条目，从索引 %d 开始", len(req.Entries), req.Entries[0].Index)
+		n.logger.Debug("收到新日志条目", "entries", len(req.Entries), "first_index", req.Entries[0].Index)
 
 		// 删除冲突的条目并添加新条目
 		if err := n.appendNewEntries(req.Entries); err != nil {
-			n.logger.Printf("添加新条目失败: %v", err)
+			n.logger.Error("添加新条目失败", logging.FieldError, err)
 			return &AppendEntriesResponse{
 				Term:    req.Term,
 				Success: false,
@@ -154,11 +194,12 @@ func (n *Node) HandleAppendEntries(req *AppendEntriesRequest) *AppendEntriesResp
 		}
 	}
 
-	// 更新提交索引
-	if req.LeaderCommit > n.commitIndex {
+	// 更新提交索引，只能提交已确认与领导者一致的部分
+	lastNewIndex := req.PrevLogIndex + LogIndex(len(req.Entries))
+	if req.LeaderCommit > n.commitIndex && lastNewIndex > n.commitIndex {
 		oldCommitIndex := n.commitIndex
-		n.commitIndex = min(req.LeaderCommit, n.storage.GetLastLogIndex())
-		n.logger.Printf("更新commitIndex从 %d 到 %d", oldCommitIndex, n.commitIndex)
+		n.commitIndex = min(req.LeaderCommit, lastNewIndex)
+		n.logger.Debug("更新commitIndex", "old_commit_index", oldCommitIndex, "commit_index", n.commitIndex)
 
 		// 异步应用新提交的日志
 		go n.applyCommittedLogs()
@@ -172,16 +213,22 @@ func (n *Node) HandleAppendEntries(req *AppendEntriesRequest) *AppendEntriesResp
 
 // HandleInstallSnapshot 处理安装快照请求
 func (n *Node) HandleInstallSnapshot(req *InstallSnapshotRequest) *InstallSnapshotResponse {
+	// 先于mu获取applyMu，避免与正在进行的日志应用交错
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
 	currentTerm := n.getCurrentTerm()
 
-	n.logger.Printf("收到来自 %s 的安装快照请求，任期: %d", req.LeaderID, req.Term)
+	if req.Offset == 0 {
+		n.logger.Info("收到安装快照请求", "leader", req.LeaderID, "leader_term", req.Term)
+	}
 
 	// 1. 如果领导者任期小于当前任期，拒绝请求
 	if req.Term < currentTerm {
-		n.logger.Printf("拒绝安装快照：领导者任期 %d 小于当前任期 %d", req.Term, currentTerm)
+		n.logger.Info("拒绝安装快照：领导者任期小于当前任期", "leader_term", req.Term)
 		return &InstallSnapshotResponse{
 			Term: currentTerm,
 		}
@@ -189,102 +236,169 @@ func (n *Node) HandleInstallSnapshot(req *InstallSnapshotRequest) *InstallSnapsh
 
 	// 2. 转为跟随者
 	if req.Term >= currentTerm {
-		n.becomeFollower(req.Term, req.LeaderID)
+		n.becomeFollowerLocked(req.Term, req.LeaderID)
 	}
 
 	// 重置选举定时器
 	n.resetElectionTimer()
+	n.lastHeartbeat = n.clock.Now()
+	n.lastLeaderContact = n.lastHeartbeat
 
-	// 3. 如果这是第一个块，创建新的快照
-	if req.Offset == 0 {
-		n.logger.Printf("开始接收快照，lastIncludedIndex: %d, lastIncludedTerm: %d",
-			req.LastIncludedIndex, req.LastIncludedTerm)
-		// 这里应该初始化快照接收状态
-		// 为了简化，我们假设快照是一次性发送的
-	}
-
-	// 4. 如果是最后一个块，安装快照
-	if req.Done {
-		snapshot := &Snapshot{
-			LastIncludedIndex: req.LastIncludedIndex,
-			LastIncludedTerm:  req.LastIncludedTerm,
-			Data:              req.Data,
+	// 3. 已应用的状态比快照更新，无需接收和安装
+	if req.LastIncludedIndex <= n.lastApplied {
+		n.logger.Info("忽略过期快照", "last_included_index", req.LastIncludedIndex, "last_applied", n.lastApplied)
+		n.discardSnapshotReceiverLocked()
+		return &InstallSnapshotResponse{
+			Term:      req.Term,
+			Installed: true,
 		}
+	}
 
-		// 保存快照
-		if err := n.storage.SaveSnapshot(snapshot); err != nil {
-			n.logger.Printf("保存快照失败: %v", err)
-			return &InstallSnapshotResponse{
-				Term: req.Term,
-			}
+	// 4. 按偏移接收快照块，收齐并通过整体校验后才安装
+	data, offset, complete := n.receiveSnapshotChunkLocked(req)
+	if !complete {
+		return &InstallSnapshotResponse{
+			Term:   req.Term,
+			Offset: offset,
 		}
+	}
 
-		// 恢复状态机
-		if err := n.stateMachine.RestoreSnapshot(snapshot.Data); err != nil {
-			n.logger.Printf("恢复状态机快照失败: %v", err)
-			return &InstallSnapshotResponse{
-				Term: req.Term,
-			}
+	// 5. 若本地存在与快照边界一致的条目则保留其后的日志，否则丢弃整个日志
+	if term, err := n.termAt(req.LastIncludedIndex); err != nil || term != req.LastIncludedTerm {
+		if err := n.storage.TruncateLog(0); err != nil {
+			n.logger.Error("清空日志失败", logging.FieldError, err)
 		}
+		n.dropApplyWaitersFromLocked(req.LastIncludedIndex + 1)
+	}
 
-		// 更新状态
-		n.commitIndex = req.LastIncludedIndex
-		n.lastApplied = req.LastIncludedIndex
+	// 采用快照中的集群配置（被压缩的日志中可能包含成员变更）
+	if len(req.Configuration.Servers) > 0 {
+		n.config.Servers = req.Configuration.Servers
+		n.configIndex = req.LastIncludedIndex
+		for _, server := range n.config.Servers {
+			n.addTransportPeer(server)
+		}
+	}
+
+	snapshot := &Snapshot{
+		LastIncludedIndex: req.LastIncludedIndex,
+		LastIncludedTerm:  req.LastIncludedTerm,
+		Configuration:     Configuration{Servers: n.config.Servers},
+		Data:              data,
+	}
 
-		// 截断日志（删除快照包含的条目）
-		if err := n.storage.TruncateLog(req.LastIncludedIndex); err != nil {
-			n.logger.Printf("截断日志失败: %v", err)
+	// 恢复状态机
+	if err := n.stateMachine.RestoreSnapshot(snapshot.Data); err != nil {
+		n.logger.Error("恢复状态机快照失败", logging.FieldError, err)
+		return &InstallSnapshotResponse{
+			Term: req.Term,
 		}
+	}
 
-		n.logger.Printf("成功安装快照，commitIndex: %d, lastApplied: %d",
-			n.commitIndex, n.lastApplied)
+	// 保存快照，存储层会丢弃被快照覆盖的日志条目
+	if err := n.storage.SaveSnapshot(snapshot); err != nil {
+		n.logger.Error("保存快照失败", logging.FieldError, err)
+		return &InstallSnapshotResponse{
+			Term: req.Term,
+		}
+	}
 
-		n.updateMetrics()
+	// 更新状态
+	if n.commitIndex < req.LastIncludedIndex {
+		n.commitIndex = req.LastIncludedIndex
 	}
+	n.lastApplied = req.LastIncludedIndex
+	n.abandonApplyWaitersThroughLocked(req.LastIncludedIndex)
+
+	n.snapshotMetrics.LastSnapshotIndex = req.LastIncludedIndex
+	n.snapshotMetrics.LastSnapshotTerm = req.LastIncludedTerm
+	n.snapshotMetrics.SnapshotSize = int64(len(data))
+	n.snapshotMetrics.InstalledCount++
+
+	n.logger.Info("成功安装快照", "commit_index", n.commitIndex, "last_applied", n.lastApplied)
+
+	n.updateMetricsLocked()
+	n.notifySnapshot(true, req.LastIncludedIndex, req.LastIncludedTerm, int64(len(data)))
 
 	return &InstallSnapshotResponse{
-		Term: req.Term,
+		Term:      req.Term,
+		Offset:    int64(len(data)),
+		Installed: true,
 	}
 }
 
-// Propose 提议新的日志条目（仅限领导者）
-func (n *Node) Propose(data []byte) error {
+// ProposeWithIndex 提议新的日志条目并返回其日志索引（仅限领导者）
+func (n *Node) ProposeWithIndex(data []byte) (LogIndex, error) {
+	indexes, err := n.ProposeBatch([][]byte{data})
+	if err != nil {
+		return 0, err
+	}
+	return indexes[0], nil
+}
+
+// ProposeBatch 将多个提议作为连续的日志条目一次性追加并返回各自的日志索引（仅限领导者）
+// 所有条目通过一次存储写入保存，并只唤醒一次复制
+func (n *Node) ProposeBatch(data [][]byte) ([]LogIndex, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
+	return n.proposeLocked(EntryNormal, data)
+}
+
+// proposeLocked 以entryType追加一组日志条目并唤醒复制（调用方需持有写锁）
+func (n *Node) proposeLocked(entryType EntryType, data [][]byte) ([]LogIndex, error) {
 	if n.state != Leader {
-		return ErrNotLeader
+		return nil, ErrNotLeader
+	}
+
+	// 领导权转移期间拒绝新的提议
+	if n.transferTarget != "" {
+		return nil, ErrTransferInProgress
 	}
 
 	// 创建新的日志条目
-	entry := &LogEntry{
-		Index:     n.storage.GetLastLogIndex() + 1,
-		Term:      n.getCurrentTerm(),
-		Timestamp: time.Now(),
-		Type:      EntryNormal,
-		Data:      data,
+	firstIndex := n.storage.GetLastLogIndex() + 1
+	term := n.getCurrentTerm()
+	now := n.clock.Now()
+
+	entries := make([]LogEntry, len(data))
+	indexes := make([]LogIndex, len(data))
+	for i, d := range data {
+		entries[i] = LogEntry{
+			Index:     firstIndex + LogIndex(i),
+			Term:      term,
+			Timestamp: now,
+			Type:      entryType,
+			Data:      d,
+		}
+		indexes[i] = entries[i].Index
 	}
 
 	// 保存到本地日志
-	if err := n.storage.SaveLogEntries([]LogEntry{*entry}); err != nil {
-		return err
+	if err := n.storage.SaveLogEntries(entries); err != nil {
+		return nil, err
 	}
 
-	n.logger.Printf("提议新的日志条目，索引: %d", entry.Index)
+	lastIndex := indexes[len(indexes)-1]
+	n.logger.Debug("提议新的日志条目", "first_index", firstIndex, "last_index", lastIndex)
 
-	// 在单节点集群中，立即提交并应用日志
-	if len(n.config.Servers) == 1 {
-		n.commitIndex = entry.Index
-		n.logger.Printf("单节点集群，立即提交日志条目 %d", entry.Index)
+	// 只有一个投票成员时立即提交并应用日志，学习者仍由复制协程追赶
+	if n.voterCountLocked() == 1 {
+		n.commitIndex = lastIndex
+		n.notifyCommitLocked(lastIndex)
+		n.logger.Debug("单节点集群，立即提交日志条目", "index", lastIndex)
 
 		// 异步应用日志
 		go n.applyCommittedLogs()
-	} else {
-		// 多节点集群，复制到跟随者
-		go n.sendHeartbeats()
 	}
+	// 唤醒复制协程
+	n.notifyReplicatorsLocked()
 
-	return nil
+	return indexes, nil
 }
 
 // min 返回两个值中的较小值
@@ -297,7 +411,8 @@ func min(a, b LogIndex) LogIndex {
 
 // 错误定义
 var (
-	ErrNotLeader = fmt.Errorf("不是领导者")
+	// ErrNotLeader 与kverrors.ErrNotLeader是同一个值，上层可直接用kverrors判断
+	ErrNotLeader = kverrors.ErrNotLeader
 )
 
 // checkLogConsistency 检查日志一致性 ⭐ 新增
@@ -306,20 +421,25 @@ func (n *Node) checkLogConsistency(prevLogIndex LogIndex, prevLogTerm Term) bool
 
 	// 如果 prevLogIndex 大于本地最后一个日志索引，拒绝
 	if prevLogIndex > lastLogIndex {
-		n.logger.Printf("日志不一致：prevLogIndex %d 大于 lastLogIndex %d", prevLogIndex, lastLogIndex)
+		n.logger.Debug("日志不一致：prevLogIndex大于lastLogIndex", "prev_log_index", prevLogIndex, "last_log_index", lastLogIndex)
 		return false
 	}
 
+	// 快照包含的条目均已提交，必然一致
+	if prevLogIndex < n.snapshotMetrics.LastSnapshotIndex {
+		return true
+	}
+
 	// 如果 prevLogIndex > 0，检查 prevLogTerm 是否匹配
 	if prevLogIndex > 0 {
-		prevEntry, err := n.storage.GetLogEntry(prevLogIndex)
+		term, err := n.termAt(prevLogIndex)
 		if err != nil {
-			n.logger.Printf("获取前一个日志条目失败: %v", err)
+			n.logger.Error("获取前一个日志条目失败", logging.FieldError, err)
 			return false
 		}
 
-		if prevEntry.Term != prevLogTerm {
-			n.logger.Printf("日志不一致：prevLogTerm %d != %d", prevEntry.Term, prevLogTerm)
+		if term != prevLogTerm {
+			n.logger.Debug("日志不一致：prevLogTerm不匹配", "local_term", term, "prev_log_term", prevLogTerm)
 			return false
 		}
 	}
@@ -339,11 +459,12 @@ func (n *Node) appendNewEntries(entries []LogEntry) error {
 			existingEntry, err := n.storage.GetLogEntry(index)
 			if err == nil && existingEntry.Term != entry.Term {
 				// 发现冲突，删除这个索引及之后的所有条目
-				n.logger.Printf("发现日志冲突在索引 %d，删除后续条目", index)
+				n.logger.Info("发现日志冲突，删除后续条目", "index", index)
 				if err := n.storage.TruncateLog(index - 1); err != nil {
-					n.logger.Printf("截断日志失败: %v", err)
+					n.logger.Error("截断日志失败", logging.FieldError, err)
 					return err
 				}
+				n.dropApplyWaitersFromLocked(index)
 				break
 			}
 		}
@@ -351,10 +472,10 @@ func (n *Node) appendNewEntries(entries []LogEntry) error {
 
 	// 保存新的日志条目
 	if err := n.storage.SaveLogEntries(entries); err != nil {
-		n.logger.Printf("保存日志条目失败: %v", err)
+		n.logger.Error("保存日志条目失败", logging.FieldError, err)
 		return err
 	}
 
-	n.logger.Printf("成功追加 %d 个日志条目", len(entries))
+	n.logger.Debug("追加日志条目", "entries", len(entries))
 	return nil
 }
@@ -0,0 +1,364 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - snapshot_transfer.go
+ */
+package raft
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"time"
+
+	"raftserver/logging"
+)
+
+// DefaultSnapshotChunkSize 默认快照分块大小
+const DefaultSnapshotChunkSize = 1 << 20
+
+// maxSnapshotChunkRetries 连续多少个块没有进展后放弃本次发送，等待下一次心跳重试
+const maxSnapshotChunkRetries = 3
+
+var snapshotCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// SnapshotTransferProgress 领导者向单个跟随者发送快照的进度
+type SnapshotTransferProgress struct {
+	LastIncludedIndex LogIndex  `json:"lastIncludedIndex"`       // 快照最后包含的索引
+	BytesSent         int64     `json:"bytesSent"`               // 跟随者已确认接收的字节数
+	TotalBytes        int64     `json:"totalBytes"`              // 快照总字节数
+	Active            bool      `json:"active"`                  // 是否正在发送（否则等待续传）
+	StartTime         time.Time `json:"startTime"`               // 开始发送的时间
+	LastChunkTime     time.Time `json:"lastChunkTime,omitempty"` // 最后一次收到块确认的时间
+}
+
+// snapshotTransfer 领导者侧的快照发送状态（由mu保护）
+// 发送中断后保留，下一次向同一跟随者发送同一快照时从已确认的偏移继续
+type snapshotTransfer struct {
+	lastIncludedIndex LogIndex
+	lastIncludedTerm  Term
+	hash              string // 整个快照的SHA-256
+	total             int64
+	offset            int64 // 跟随者已确认接收的字节数
+	active            bool
+	startTime         time.Time
+	lastChunkTime     time.Time
+}
+
+// snapshotReceiver 跟随者侧正在接收的快照（由mu保护）
+// 块按偏移顺序写入临时文件，收齐并通过整体校验后原子重命名再安装
+type snapshotReceiver struct {
+	leaderID          NodeID
+	lastIncludedIndex LogIndex
+	lastIncludedTerm  Term
+	hash              string
+	total             int64
+	offset            int64 // 已写入的字节数
+	path              string
+	file              *os.File
+}
+
+// snapshotChunkSize 获取快照分块大小
+func (n *Node) snapshotChunkSize() int64 {
+	if n.config.SnapshotChunkSize <= 0 {
+		return DefaultSnapshotChunkSize
+	}
+	return int64(n.config.SnapshotChunkSize)
+}
+
+// snapshotDir 获取接收快照时存放临时文件的目录
+func (n *Node) snapshotDir() string {
+	if n.config.SnapshotDir == "" {
+		return os.TempDir()
+	}
+	return n.config.SnapshotDir
+}
+
+// snapshotHash 计算快照数据的SHA-256
+func snapshotHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sendSnapshotToFollower 分块向落后于快照边界的跟随者发送快照，跟随者安装成功时返回true
+// 每个块由跟随者确认接收位置，领导者总是从跟随者确认的偏移继续发送，因此中断后可以续传；
+// 配置追赶复制限速时每个块发送前按限速等待，stop关闭时中断发送
+func (n *Node) sendSnapshotToFollower(followerID NodeID, term Term, stop <-chan struct{}) bool {
+	snapshot, err := n.storage.GetSnapshot()
+	if err != nil {
+		n.logger.Error("获取快照失败，无法发送", "peer", followerID, logging.FieldError, err)
+		return false
+	}
+	total := int64(len(snapshot.Data))
+
+	n.mu.Lock()
+	t := n.snapshotTransfers[followerID]
+	if t == nil || t.lastIncludedIndex != snapshot.LastIncludedIndex || t.lastIncludedTerm != snapshot.LastIncludedTerm {
+		t = &snapshotTransfer{
+			lastIncludedIndex: snapshot.LastIncludedIndex,
+			lastIncludedTerm:  snapshot.LastIncludedTerm,
+			hash:              snapshotHash(snapshot.Data),
+			total:             total,
+			startTime:         n.clock.Now(),
+		}
+		n.snapshotTransfers[followerID] = t
+		n.logger.Info("发送快照", "peer", followerID, "last_included_index", snapshot.LastIncludedIndex, "bytes", total)
+	} else {
+		n.logger.Info("续传快照", "peer", followerID, "last_included_index", snapshot.LastIncludedIndex, "offset", t.offset, "bytes", total)
+	}
+	t.active = true
+	offset := t.offset
+	hash := t.hash
+	n.mu.Unlock()
+
+	defer func() {
+		n.mu.Lock()
+		t.active = false
+		n.mu.Unlock()
+	}()
+
+	chunkSize := n.snapshotChunkSize()
+	retries := 0
+
+	for {
+		end := offset + chunkSize
+		if end > total {
+			end = total
+		}
+		chunk := snapshot.Data[offset:end]
+
+		req := &InstallSnapshotRequest{
+			Term:              term,
+			LeaderID:          n.id,
+			LastIncludedIndex: snapshot.LastIncludedIndex,
+			LastIncludedTerm:  snapshot.LastIncludedTerm,
+			Configuration:     snapshot.Configuration,
+			Offset:            offset,
+			Data:              chunk,
+			Done:              end == total,
+			TotalSize:         total,
+			ChunkChecksum:     crc32.Checksum(chunk, snapshotCRCTable),
+			SnapshotHash:      hash,
+		}
+
+		if !n.throttleCatchUp(followerID, int64(len(chunk)), stop) {
+			return false
+		}
+
+		ctx, cancel := context.WithTimeout(n.ctx, time.Second*10)
+		resp, err := n.transport.SendInstallSnapshot(ctx, followerID, req)
+		cancel()
+		if err != nil {
+			n.logger.Warn("发送快照块失败", "peer", followerID, "offset", offset, logging.FieldError, err)
+			return false
+		}
+
+		n.mu.Lock()
+		if n.state != Leader || n.getCurrentTerm() != term {
+			n.mu.Unlock()
+			return false
+		}
+
+		if resp.Term > term {
+			n.logger.Info("收到更高任期，转为跟随者", "new_term", resp.Term)
+			n.becomeFollowerLocked(resp.Term, "")
+			n.mu.Unlock()
+			return false
+		}
+
+		if resp.Installed {
+			n.snapshotMetrics.SentCount++
+			if n.snapshotTransfers[followerID] == t {
+				delete(n.snapshotTransfers, followerID)
+			}
+			if n.matchIndex[followerID] < snapshot.LastIncludedIndex {
+				n.matchIndex[followerID] = snapshot.LastIncludedIndex
+			}
+			n.nextIndex[followerID] = snapshot.LastIncludedIndex + 1
+			n.tryAdvanceCommitIndex()
+			n.mu.Unlock()
+
+			n.logger.Info("跟随者已安装快照", "peer", followerID, "last_included_index", snapshot.LastIncludedIndex, "duration", n.clock.Now().Sub(t.startTime))
+			return true
+		}
+
+		// 跟随者的接收位置没有前进：块校验失败、整体校验失败或需要从头开始
+		next := resp.Offset
+		if next < 0 || next > total {
+			next = 0
+		}
+		if next <= offset {
+			retries++
+		} else {
+			retries = 0
+		}
+		t.offset = next
+		t.lastChunkTime = n.clock.Now()
+		n.mu.Unlock()
+
+		if retries >= maxSnapshotChunkRetries {
+			n.logger.Warn("发送快照连续没有进展，停止本次发送", "peer", followerID, "retries", retries, "offset", next)
+			return false
+		}
+		offset = next
+	}
+}
+
+// snapshotTransferProgress 获取各跟随者的快照发送进度，非领导者或没有发送时返回nil
+func (n *Node) snapshotTransferProgress() map[NodeID]SnapshotTransferProgress {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if n.state != Leader || len(n.snapshotTransfers) == 0 {
+		return nil
+	}
+
+	result := make(map[NodeID]SnapshotTransferProgress, len(n.snapshotTransfers))
+	for id, t := range n.snapshotTransfers {
+		result[id] = SnapshotTransferProgress{
+			LastIncludedIndex: t.lastIncludedIndex,
+			BytesSent:         t.offset,
+			TotalBytes:        t.total,
+			Active:            t.active,
+			StartTime:         t.startTime,
+			LastChunkTime:     t.lastChunkTime,
+		}
+	}
+	return result
+}
+
+// receiveSnapshotChunkLocked 接收一个快照块，返回跟随者下一个期望的偏移；
+// 收到最后一块并通过整体校验时返回完整的快照数据（调用方需持有写锁）
+func (n *Node) receiveSnapshotChunkLocked(req *InstallSnapshotRequest) ([]byte, int64, bool) {
+	r := n.snapshotRecv
+	if r != nil && (r.leaderID != req.LeaderID || r.lastIncludedIndex != req.LastIncludedIndex ||
+		r.lastIncludedTerm != req.LastIncludedTerm || r.hash != req.SnapshotHash) {
+		// 领导者开始发送另一个快照，丢弃未完成的部分
+		n.discardSnapshotReceiverLocked()
+		r = nil
+	}
+
+	if r == nil {
+		if req.Offset != 0 {
+			// 没有对应的部分快照（如本节点重启过），要求领导者从头发送
+			return nil, 0, false
+		}
+
+		var err error
+		if r, err = n.newSnapshotReceiver(req); err != nil {
+			n.logger.Error("创建快照临时文件失败", logging.FieldError, err)
+			return nil, 0, false
+		}
+		n.snapshotRecv = r
+	}
+
+	// 重复或越过的块，告知领导者实际的接收位置
+	if req.Offset != r.offset {
+		return nil, r.offset, false
+	}
+
+	if crc32.Checksum(req.Data, snapshotCRCTable) != req.ChunkChecksum {
+		n.logger.Warn("快照块校验失败", "offset", req.Offset)
+		return nil, r.offset, false
+	}
+
+	if r.offset+int64(len(req.Data)) > r.total {
+		n.logger.Warn("快照块超出快照大小，丢弃已接收的部分", "bytes", r.total)
+		n.discardSnapshotReceiverLocked()
+		return nil, 0, false
+	}
+
+	if _, err := r.file.Write(req.Data); err != nil {
+		n.logger.Error("写入快照临时文件失败", logging.FieldError, err)
+		n.discardSnapshotReceiverLocked()
+		return nil, 0, false
+	}
+	r.offset += int64(len(req.Data))
+
+	if !req.Done {
+		return nil, r.offset, false
+	}
+
+	data, err := n.finishSnapshotReceiverLocked()
+	if err != nil {
+		n.logger.Error("完成快照接收失败，需要重新发送", logging.FieldError, err)
+		return nil, 0, false
+	}
+	return data, r.total, true
+}
+
+// newSnapshotReceiver 为新的快照创建临时文件
+func (n *Node) newSnapshotReceiver(req *InstallSnapshotRequest) (*snapshotReceiver, error) {
+	dir := n.snapshotDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(dir, fmt.Sprintf("snapshot-%s-%d-*.part", n.id, req.LastIncludedIndex))
+	if err != nil {
+		return nil, err
+	}
+
+	n.logger.Info("开始接收快照", "leader", req.LeaderID, "last_included_index", req.LastIncludedIndex, "bytes", req.TotalSize)
+
+	return &snapshotReceiver{
+		leaderID:          req.LeaderID,
+		lastIncludedIndex: req.LastIncludedIndex,
+		lastIncludedTerm:  req.LastIncludedTerm,
+		hash:              req.SnapshotHash,
+		total:             req.TotalSize,
+		path:              file.Name(),
+		file:              file,
+	}, nil
+}
+
+// finishSnapshotReceiverLocked 落盘并原子重命名已收齐的快照，校验整体哈希后读出数据（调用方需持有写锁）
+func (n *Node) finishSnapshotReceiverLocked() ([]byte, error) {
+	r := n.snapshotRecv
+	defer n.discardSnapshotReceiverLocked()
+
+	if r.offset != r.total {
+		return nil, fmt.Errorf("快照不完整: %d/%d 字节", r.offset, r.total)
+	}
+	if err := r.file.Sync(); err != nil {
+		return nil, fmt.Errorf("刷盘失败: %w", err)
+	}
+	if err := r.file.Close(); err != nil {
+		return nil, fmt.Errorf("关闭临时文件失败: %w", err)
+	}
+	r.file = nil
+
+	final := filepath.Join(filepath.Dir(r.path), fmt.Sprintf("snapshot-%s-%d.snap", n.id, r.lastIncludedIndex))
+	if err := os.Rename(r.path, final); err != nil {
+		return nil, fmt.Errorf("重命名快照文件失败: %w", err)
+	}
+	r.path = final
+
+	data, err := os.ReadFile(final)
+	if err != nil {
+		return nil, fmt.Errorf("读取快照文件失败: %w", err)
+	}
+	if hash := snapshotHash(data); hash != r.hash {
+		return nil, fmt.Errorf("快照哈希不匹配: 期望 %s, 实际 %s", r.hash, hash)
+	}
+	return data, nil
+}
+
+// discardSnapshotReceiverLocked 丢弃正在接收的快照及其临时文件（调用方需持有写锁）
+func (n *Node) discardSnapshotReceiverLocked() {
+	r := n.snapshotRecv
+	if r == nil {
+		return
+	}
+	if r.file != nil {
+		r.file.Close()
+	}
+	os.Remove(r.path)
+	n.snapshotRecv = nil
+}
@@ -0,0 +1,150 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - snapshot_transfer_test.go
+ */
+package raft_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+)
+
+// TestSnapshotTransferResumes 落后的跟随者通过分块快照追上领导者：
+// 丢失的块从跟随者确认的偏移续传而不是从头开始，校验失败的块被拒绝后重传
+func TestSnapshotTransferResumes(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	const chunkSize = 1024
+
+	network, leader, stop := newMemClusterWithConfig(t, 2*time.Millisecond, func(config *raft.Config) {
+		config.SnapshotThreshold = 20
+		config.SnapshotChunkSize = chunkSize
+		config.SnapshotDir = t.TempDir()
+	})
+	defer stop()
+
+	var lagging raft.NodeID
+	for id := range network.nodes {
+		if id != leader.GetMetrics().LeaderID {
+			lagging = id
+			break
+		}
+	}
+
+	// 隔离一个跟随者，期间领导者写入足够多的数据并压缩日志
+	errDropped := errors.New("请求丢失")
+	network.setIntercept(func(from, to raft.NodeID, req interface{}) error {
+		if from == lagging || to == lagging {
+			return errDropped
+		}
+		return nil
+	})
+
+	value := strings.Repeat("v", 200)
+	var last raft.LogIndex
+	for i := 0; i < 100; i++ {
+		index, err := leader.ProposeWithIndex([]byte(fmt.Sprintf(`{"type":"SET","key":"k%03d","value":"%s"}`, i, value)))
+		if err != nil {
+			t.Fatalf("提议失败: %v", err)
+		}
+		last = index
+	}
+	waitApplied(t, leader, last, 10*time.Second)
+
+	// 恢复连接：每个偏移的块第一次发送时丢失或被损坏，之后正常投递
+	// 按跟随者的接收逻辑记录已确认的偏移：完好的块恰好从该偏移开始时被接收；
+	// 故障之后跟随者收到的第一个块应从已确认的偏移续传，而不是从0开始
+	type resume struct {
+		acked, offset int64
+	}
+	var mu sync.Mutex
+	attempts := make(map[int64]int)
+	var (
+		transfer string // 领导者与快照哈希，领导者发送另一个快照时跟随者从头接收
+		acked    int64
+		total    int64
+		faulted  bool
+		resumes  []resume
+	)
+	network.setIntercept(func(from, to raft.NodeID, req interface{}) error {
+		snapshotReq, ok := req.(*raft.InstallSnapshotRequest)
+		if !ok || to != lagging {
+			return nil
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if id := string(snapshotReq.LeaderID) + "/" + snapshotReq.SnapshotHash; id != transfer {
+			transfer, acked, faulted = id, 0, false
+		}
+		if faulted {
+			resumes = append(resumes, resume{acked: acked, offset: snapshotReq.Offset})
+			faulted = false
+		}
+
+		attempts[snapshotReq.Offset]++
+		if attempts[snapshotReq.Offset] == 1 && snapshotReq.Offset/chunkSize%3 == 1 {
+			faulted = true
+			return errDropped
+		}
+		if attempts[snapshotReq.Offset] == 1 && snapshotReq.Offset/chunkSize%3 == 2 {
+			corrupted := *snapshotReq
+			corrupted.Data = append([]byte(nil), snapshotReq.Data...)
+			corrupted.Data[0] ^= 0xff
+			*snapshotReq = corrupted
+			faulted = true
+			return nil
+		}
+
+		if snapshotReq.Offset == acked {
+			acked += int64(len(snapshotReq.Data))
+		}
+		total = snapshotReq.TotalSize
+		return nil
+	})
+
+	waitApplied(t, network.nodes[lagging], last, 20*time.Second)
+
+	metrics := network.nodes[lagging].GetMetrics()
+	if metrics.Snapshot.InstalledCount == 0 {
+		t.Fatalf("跟随者应通过安装快照追上领导者")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if total < 10*chunkSize {
+		t.Fatalf("快照太小(%d 字节)，无法覆盖分块场景", total)
+	}
+	if len(resumes) < 2 {
+		t.Fatalf("期望多次丢失或损坏的块之后续传，实际 %d 次", len(resumes))
+	}
+	for _, r := range resumes {
+		if r.offset != r.acked {
+			t.Errorf("故障后应从已确认的偏移 %d 续传，实际从 %d 发送（全部续传 %+v）", r.acked, r.offset, resumes)
+			break
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(leader.GetMetrics().SnapshotTransfers) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("安装完成后仍保留发送进度: %+v", leader.GetMetrics().SnapshotTransfers)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - transfer.go
+ */
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 领导权转移错误定义
+var (
+	ErrTransferInProgress = errors.New("领导权转移正在进行中")
+	ErrTransferTimeout    = errors.New("领导权转移超时")
+	ErrUnknownTransferee  = errors.New("目标节点不在集群配置中")
+)
+
+// transferPollInterval 等待目标追赶日志及完成选举时的轮询间隔
+const transferPollInterval = 10 * time.Millisecond
+
+// TransferLeadership 将领导权转移给指定节点
+// 转移期间拒绝新的提议；若在一个选举超时内未完成，则恢复正常服务并返回ErrTransferTimeout
+func (n *Node) TransferLeadership(target NodeID) error {
+	n.mu.Lock()
+	if n.state != Leader {
+		n.mu.Unlock()
+		return ErrNotLeader
+	}
+
+	if target == n.id {
+		n.mu.Unlock()
+		return fmt.Errorf("节点 %s 已经是领导者", target)
+	}
+
+	if !n.hasServerLocked(target) {
+		n.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrUnknownTransferee, target)
+	}
+	if n.isLearnerLocked(target) {
+		n.mu.Unlock()
+		return fmt.Errorf("%w: %s 是学习者，需先提升为投票成员", ErrUnknownTransferee, target)
+	}
+
+	if n.transferTarget != "" {
+		inFlight := n.transferTarget
+		n.mu.Unlock()
+		return fmt.Errorf("%w: 目标 %s", ErrTransferInProgress, inFlight)
+	}
+
+	n.transferTarget = target
+	n.leaseStart = time.Time{} // 目标将立即发起选举，原租约不再可靠
+	term := n.getCurrentTerm()
+	n.mu.Unlock()
+
+	defer func() {
+		n.mu.Lock()
+		n.transferTarget = ""
+		n.mu.Unlock()
+	}()
+
+	n.logger.Info("开始转移领导权", "target", target)
+	deadline := n.clock.Now().Add(n.config.ElectionTimeout)
+
+	// 1. 确保目标的日志与领导者一致
+	for {
+		n.mu.RLock()
+		stillLeader := n.state == Leader && n.getCurrentTerm() == term
+		matchIndex := n.matchIndex[target]
+		n.mu.RUnlock()
+
+		if !stillLeader {
+			return fmt.Errorf("领导权转移期间失去领导者身份")
+		}
+
+		if matchIndex >= n.storage.GetLastLogIndex() {
+			break
+		}
+
+		if n.clock.Now().After(deadline) {
+			n.logger.Warn("等待目标追赶日志超时，放弃领导权转移", "target", target)
+			return ErrTransferTimeout
+		}
+
+		n.notifyReplicator(target)
+		n.clock.Sleep(transferPollInterval)
+	}
+
+	// 2. 通知目标立即发起选举
+	ctx, cancel := context.WithDeadline(n.ctx, deadline)
+	defer cancel()
+
+	resp, err := n.transport.SendTimeoutNow(ctx, target, &TimeoutNowRequest{
+		Term:     term,
+		LeaderID: n.id,
+	})
+	if err != nil {
+		return fmt.Errorf("发送TimeoutNow到 %s 失败: %w", target, err)
+	}
+	if !resp.Success {
+		return fmt.Errorf("节点 %s 拒绝发起选举，任期: %d", target, resp.Term)
+	}
+
+	// 3. 等待目标当选（收到更高任期后本节点会转为跟随者）
+	for n.clock.Now().Before(deadline) {
+		n.mu.RLock()
+		state := n.state
+		n.mu.RUnlock()
+
+		if state != Leader {
+			n.logger.Info("领导权已转移", "target", target)
+			return nil
+		}
+
+		n.clock.Sleep(transferPollInterval)
+	}
+
+	n.logger.Warn("等待目标当选超时，恢复正常服务", "target", target)
+	return ErrTransferTimeout
+}
+
+// HandleTimeoutNow 处理TimeoutNow请求，立即发起选举
+func (n *Node) HandleTimeoutNow(req *TimeoutNowRequest) *TimeoutNowResponse {
+	n.mu.RLock()
+	currentTerm := n.getCurrentTerm()
+	state := n.state
+	voter := n.isVoterLocked(n.id)
+	n.mu.RUnlock()
+
+	n.logger.Info("收到TimeoutNow请求", "leader", req.LeaderID, "leader_term", req.Term)
+
+	if req.Term < currentTerm || state == Leader || !voter {
+		return &TimeoutNowResponse{
+			Term:    currentTerm,
+			Success: false,
+		}
+	}
+
+	// 跳过选举超时等待及DC优先级检查，直接成为候选人
+	n.mu.Lock()
+	n.transferElection = true
+	n.mu.Unlock()
+	n.becomeCandidate()
+
+	return &TimeoutNowResponse{
+		Term:    req.Term,
+		Success: true,
+	}
+}
+
+// GetTransferTarget 获取正在进行的领导权转移目标，为空表示没有转移
+func (n *Node) GetTransferTarget() NodeID {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.transferTarget
+}
@@ -10,6 +10,10 @@ package raft
 import (
 	"context"
 	"time"
+
+	"raftserver/clock"
+	"raftserver/logging"
+	"raftserver/queue"
 )
 
 // NodeState 代表Raft节点的状态
@@ -65,6 +69,8 @@ const (
 	EntryConfiguration
 	// EntrySnapshot 快照条目
 	EntrySnapshot
+	// EntryNoop 领导者当选后追加的空条目，用于提交之前任期的日志
+	EntryNoop
 )
 
 // VoteRequest 投票请求
@@ -73,6 +79,12 @@ type VoteRequest struct {
 	CandidateID  NodeID   `json:"candidateId"`  // 候选人ID
 	LastLogIndex LogIndex `json:"lastLogIndex"` // 候选人最后日志索引
 	LastLogTerm  Term     `json:"lastLogTerm"`  // 候选人最后日志任期号
+
+	// LeadershipTransfer 由领导权转移触发的选举，跟随者无需等待原领导者租约到期
+	LeadershipTransfer bool `json:"leadershipTransfer,omitempty"`
+
+	// PreVote 预投票请求，Term为候选人赢得选举后将使用的任期，接收方不修改自身状态
+	PreVote bool `json:"preVote,omitempty"`
 }
 
 // VoteResponse 投票响应
@@ -99,20 +111,54 @@ type AppendEntriesResponse struct {
 	ConflictTerm  Term     `json:"conflictTerm"`  // 冲突任期
 }
 
+// TimeoutNowRequest 领导权转移时要求目标立即发起选举的请求
+type TimeoutNowRequest struct {
+	Term     Term   `json:"term"`     // 领导者任期号
+	LeaderID NodeID `json:"leaderId"` // 领导者ID
+}
+
+// TimeoutNowResponse TimeoutNow响应
+type TimeoutNowResponse struct {
+	Term    Term `json:"term"`    // 当前任期号
+	Success bool `json:"success"` // 是否已发起选举
+}
+
+// ForwardProposeRequest 跟随者代客户端转发给领导者的写请求，命令内容由上层解析
+type ForwardProposeRequest struct {
+	From    NodeID `json:"from"`    // 转发写请求的节点
+	Token   string `json:"token"`   // 幂等令牌，领导者对同一令牌只提议一次
+	Command []byte `json:"command"` // 序列化的客户端命令
+}
+
+// ForwardProposeResponse 领导者对转发写请求的响应
+type ForwardProposeResponse struct {
+	Index     LogIndex `json:"index"`               // 命令的日志索引
+	Result    []byte   `json:"result,omitempty"`    // 序列化的应用结果
+	ErrorCode string   `json:"errorCode,omitempty"` // 失败时的错误码
+	Error     string   `json:"error,omitempty"`     // 失败时的错误信息
+	LeaderID  NodeID   `json:"leaderId,omitempty"`  // 接收方不是领导者时已知的领导者
+}
+
 // InstallSnapshotRequest 安装快照请求
 type InstallSnapshotRequest struct {
-	Term              Term     `json:"term"`              // 领导者任期号
-	LeaderID          NodeID   `json:"leaderId"`          // 领导者ID
-	LastIncludedIndex LogIndex `json:"lastIncludedIndex"` // 快照最后包含的索引
-	LastIncludedTerm  Term     `json:"lastIncludedTerm"`  // 快照最后包含的任期
-	Offset            int64    `json:"offset"`            // 块在快照中的偏移量
-	Data              []byte   `json:"data"`              // 快照数据块
-	Done              bool     `json:"done"`              // 是否为最后一块
+	Term              Term          `json:"term"`              // 领导者任期号
+	LeaderID          NodeID        `json:"leaderId"`          // 领导者ID
+	LastIncludedIndex LogIndex      `json:"lastIncludedIndex"` // 快照最后包含的索引
+	LastIncludedTerm  Term          `json:"lastIncludedTerm"`  // 快照最后包含的任期
+	Configuration     Configuration `json:"configuration"`     // 快照包含的集群配置
+	Offset            int64         `json:"offset"`            // 块在快照中的偏移量
+	Data              []byte        `json:"data"`              // 快照数据块
+	Done              bool          `json:"done"`              // 是否为最后一块
+	TotalSize         int64         `json:"totalSize"`         // 快照总字节数
+	ChunkChecksum     uint32        `json:"chunkChecksum"`     // 数据块的CRC32(Castagnoli)
+	SnapshotHash      string        `json:"snapshotHash"`      // 整个快照的SHA-256，安装前校验
 }
 
 // InstallSnapshotResponse 安装快照响应
 type InstallSnapshotResponse struct {
-	Term Term `json:"term"` // 当前任期号
+	Term      Term  `json:"term"`      // 当前任期号
+	Offset    int64 `json:"offset"`    // 跟随者已接收的字节数，领导者从该偏移继续发送
+	Installed bool  `json:"installed"` // 快照是否已安装（或跟随者已有更新的状态）
 }
 
 // Configuration 集群配置
@@ -145,10 +191,11 @@ func (r ReplicaType) String() string {
 }
 
 type Server struct {
-	ID          NodeID       `json:"id"`          // 服务器ID
-	Address     string       `json:"address"`     // 服务器地址
-	DataCenter  DataCenterID `json:"dataCenter"`  // 数据中心标识
-	ReplicaType ReplicaType  `json:"replicaType"` // 副本类型
+	ID          NodeID       `json:"id"`                  // 服务器ID
+	Address     string       `json:"address"`             // 服务器地址
+	DataCenter  DataCenterID `json:"dataCenter"`          // 数据中心标识
+	ReplicaType ReplicaType  `json:"replicaType"`         // 副本类型
+	IsLearner   bool         `json:"isLearner,omitempty"` // 学习者：接收日志与快照，不投票、不发起选举、不计入提交仲裁
 }
 
 // Snapshot 快照结构
@@ -170,6 +217,9 @@ type Transport interface {
 	// SendInstallSnapshot 发送安装快照请求
 	SendInstallSnapshot(ctx context.Context, target NodeID, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error)
 
+	// SendTimeoutNow 发送TimeoutNow请求（领导权转移）
+	SendTimeoutNow(ctx context.Context, target NodeID, req *TimeoutNowRequest) (*TimeoutNowResponse, error)
+
 	// Start 启动传输层
 	Start() error
 
@@ -180,6 +230,33 @@ type Transport interface {
 	LocalAddr() string
 }
 
+// CompressedAppendEntriesSender 支持发送跨数据中心压缩追加请求的传输层
+type CompressedAppendEntriesSender interface {
+	// SendCompressedAppendEntries 发送压缩的追加日志请求
+	SendCompressedAppendEntries(ctx context.Context, target NodeID, req *CompressedAppendEntriesRequest) (*CompressedAppendEntriesResponse, error)
+}
+
+// Pinger 支持轻量探测请求的传输层，DC健康检查器用它主动探测远程数据中心的节点并测量往返延迟
+type Pinger interface {
+	// Ping 探测目标节点是否可达，不经过Raft状态机
+	Ping(ctx context.Context, target NodeID) error
+}
+
+// WriteForwarder 支持把客户端写请求转发给领导者的传输层
+type WriteForwarder interface {
+	// ForwardPropose 把写请求转发给目标节点提议，等待命令被应用后返回
+	ForwardPropose(ctx context.Context, target NodeID, req *ForwardProposeRequest) (*ForwardProposeResponse, error)
+}
+
+// PeerManager 支持动态增删对端地址的传输层（成员变更时使用）
+type PeerManager interface {
+	// AddPeer 添加或更新对端地址
+	AddPeer(id NodeID, addr string)
+
+	// RemovePeer 移除对端地址
+	RemovePeer(id NodeID)
+}
+
 // Storage 存储接口
 type Storage interface {
 	// SaveCurrentTerm 保存当前任期号
@@ -234,6 +311,16 @@ type StateMachine interface {
 	RestoreSnapshot(data []byte) error
 }
 
+// ResultStateMachine 应用日志条目后返回结果的状态机，Node.Apply把结果交给提议者
+// 实现该接口后节点只调用ApplyWithResult，不再调用Apply；与Apply一样会收到EntryNoop等非普通条目
+type ResultStateMachine interface {
+	StateMachine
+
+	// ApplyWithResult 应用日志条目并返回结果；返回错误时该条目稍后重新应用，
+	// 命令本身的失败（如余额不足）应作为结果返回
+	ApplyWithResult(entry *LogEntry) (interface{}, error)
+}
+
 // DataCenterConfig 数据中心配置
 type DataCenterConfig struct {
 	// ID 数据中心标识
@@ -274,6 +361,26 @@ type MultiDCConfig struct {
 
 	// MaxCrossDCLatency 最大跨数据中心延迟容忍度
 	MaxCrossDCLatency time.Duration `json:"maxCrossDCLatency"`
+
+	// CrossDCCompression 跨数据中心复制批次的压缩算法（gzip或none），为空时使用gzip
+	CrossDCCompression string `json:"crossDCCompression"`
+
+	// CrossDCMinBatchSize、CrossDCMaxBatchSize 跨DC复制批次大小(条目数)的自适应调整范围，为0时分别使用10和10000
+	CrossDCMinBatchSize int `json:"crossDCMinBatchSize"`
+	CrossDCMaxBatchSize int `json:"crossDCMaxBatchSize"`
+
+	// ReplicationQueue 跨DC复制批次队列，默认容量1000，队列满时最多等待1秒（block-with-timeout），
+	// 未能入队或被挤出队列的批次放回待复制缓冲稍后重发
+	ReplicationQueue queue.Config `json:"replicationQueue"`
+
+	// HealthCheckInterval 探测远程数据中心节点的间隔，为0时使用5秒
+	HealthCheckInterval time.Duration `json:"healthCheckInterval"`
+
+	// HealthCheckTimeout 单次探测的超时时间，为0时使用2秒
+	HealthCheckTimeout time.Duration `json:"healthCheckTimeout"`
+
+	// CommitPolicy 领导者推进提交索引的仲裁策略，为空时使用majority
+	CommitPolicy CommitPolicy `json:"commitPolicy"`
 }
 
 // Config Raft配置
@@ -290,14 +397,42 @@ type Config struct {
 	// MaxLogEntries 单次追加的最大日志条目数
 	MaxLogEntries int
 
+	// MaxInflightBatches 每个跟随者允许同时在途的追加日志批次数，为1时不使用流水线
+	MaxInflightBatches int
+
 	// SnapshotThreshold 触发快照的日志条目数阈值
 	SnapshotThreshold int
 
+	// SnapshotChunkSize 发送快照时的分块大小(字节)，默认DefaultSnapshotChunkSize
+	SnapshotChunkSize int
+
+	// SnapshotDir 接收快照时存放临时文件的目录，默认为系统临时目录
+	SnapshotDir string
+
 	// Servers 集群服务器列表
 	Servers []Server
 
+	// EnablePreVote 启用预投票，避免分区恢复的节点打断稳定的领导者
+	EnablePreVote bool
+
+	// EnableLeaseRead 启用基于租约的线性一致读
+	// 依赖各节点时钟漂移有界：跟随者在选举超时内收到过领导者心跳时拒绝投票
+	EnableLeaseRead bool
+
 	// MultiDC 多数据中心配置
 	MultiDC *MultiDCConfig `json:"multiDC,omitempty"`
+
+	// CatchUp 追赶复制限速配置，为nil时不限速
+	CatchUp *CatchUpConfig `json:"catchUp,omitempty"`
+
+	// EventQueueCapacity 每个事件监听器排队等待投递的事件上限，为0时使用256，队列满时丢弃最早的事件
+	EventQueueCapacity int `json:"eventQueueCapacity"`
+
+	// Logger 节点及其组件使用的日志，为nil时使用logging.Default()
+	Logger logging.Logger `json:"-"`
+
+	// Clock 节点及其组件计时使用的时钟，为nil时使用真实时钟，测试中注入testutil.FakeClock
+	Clock clock.Clock `json:"-"`
 }
 
 // LoadMetrics 负载指标统计 - 扩展Raft指标系统支持负载均衡
@@ -344,11 +479,33 @@ type Metrics struct {
 	// 日志指标
 	LogEntryCount int64 `json:"logEntryCount"` // 日志条目数
 
+	// 快照指标
+	Snapshot SnapshotMetrics `json:"snapshot"` // 快照指标
+
+	// 线性一致读指标
+	ReadIndex ReadIndexMetrics `json:"readIndex"` // ReadIndex指标
+
+	// 提交策略指标
+	Commit CommitPolicyMetrics `json:"commit"` // 提交策略与等待跨DC确认的统计
+
+	// 复制进度（仅领导者）
+	Replication map[NodeID]ReplicationProgress `json:"replication,omitempty"` // 各跟随者复制进度
+
+	// 追赶复制限速（仅配置CatchUp的领导者）
+	CatchUp *CatchUpMetrics `json:"catchUp,omitempty"` // 动态调整后的速率比例与限速等待时间
+
+	// 快照发送进度（仅领导者）
+	SnapshotTransfers map[NodeID]SnapshotTransferProgress `json:"snapshotTransfers,omitempty"` // 向各跟随者发送快照的进度
+
+	// 内部队列（启用多数据中心时为跨DC复制批次队列）
+	Queues []queue.Stats `json:"queues,omitempty"` // 队列深度与入队、丢弃、合并计数
+
 	// 负载指标
 	Load LoadMetrics `json:"load"` // 负载指标
 }
 
 // EventListener 事件监听器接口
+// 每个监听器的事件由独立的协程按产生顺序投递，监听器阻塞只会积压自己的事件队列，panic会被恢复并记录
 type EventListener interface {
 	// OnStateChange 状态变更事件
 	OnStateChange(event StateChangeEvent)
@@ -374,3 +531,49 @@ type LeaderChangeEvent struct {
 	Term        Term   `json:"term"`        // 任期
 	Time        int64  `json:"time"`        // 事件时间戳
 }
+
+// SnapshotEventListener 快照事件监听器，EventListener可选实现
+type SnapshotEventListener interface {
+	// OnSnapshot 本地创建快照或从领导者安装快照
+	OnSnapshot(event SnapshotEvent)
+}
+
+// ConfigChangeEventListener 成员变更事件监听器，EventListener可选实现
+type ConfigChangeEventListener interface {
+	// OnConfigChange 成员变更日志被应用
+	OnConfigChange(event ConfigChangeEvent)
+}
+
+// CommitEventListener 提交事件监听器，EventListener可选实现
+type CommitEventListener interface {
+	// OnCommit 领导者推进了提交索引，在持有节点锁时同步调用，实现必须立即返回且不能调用节点的方法
+	OnCommit(event CommitEvent)
+}
+
+// SnapshotEvent 快照事件
+type SnapshotEvent struct {
+	NodeID            NodeID   `json:"nodeID"`            // 节点ID
+	Installed         bool     `json:"installed"`         // true为从领导者安装，false为本地创建
+	LastIncludedIndex LogIndex `json:"lastIncludedIndex"` // 快照包含的最后日志索引
+	LastIncludedTerm  Term     `json:"lastIncludedTerm"`  // 快照包含的最后日志任期
+	Size              int64    `json:"size"`              // 快照大小(字节)
+	Time              int64    `json:"time"`              // 事件时间戳
+}
+
+// CommitEvent 提交事件，Time精确到纳秒，用于统计请求等待多数派确认的时间
+type CommitEvent struct {
+	NodeID      NodeID    `json:"nodeID"`      // 节点ID
+	Term        Term      `json:"term"`        // 任期
+	CommitIndex LogIndex  `json:"commitIndex"` // 推进后的提交索引
+	Time        time.Time `json:"time"`        // 推进提交索引的时间
+}
+
+// ConfigChangeEvent 成员变更事件
+type ConfigChangeEvent struct {
+	NodeID  NodeID               `json:"nodeID"`  // 节点ID
+	Type    MembershipChangeType `json:"type"`    // 变更类型
+	Server  Server               `json:"server"`  // 被添加或移除的服务器
+	Index   LogIndex             `json:"index"`   // 成员变更日志索引
+	Servers []Server             `json:"servers"` // 变更后的成员
+	Time    int64                `json:"time"`    // 事件时间戳
+}
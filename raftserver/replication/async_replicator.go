@@ -10,11 +10,14 @@ package replication
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"sort"
 	"sync"
 	"time"
 
+	"raftserver/clock"
+	"raftserver/logging"
 	"raftserver/raft"
 )
 
@@ -24,16 +27,17 @@ type AsyncReplicationConfig struct {
 	Enabled bool `json:"enabled"`
 
 	// 批量传输配置
-	BatchSize            int  `json:"batchSize"`
-	BatchTimeoutMs       int  `json:"batchTimeoutMs"`
-	MaxBatchMemoryMB     int  `json:"maxBatchMemoryMB"`
+	BatchSize            int  `json:"batchSize"`          // 单个批次的最大条目数，缓冲区攒满后立即发送
+	BatchTimeoutMs       int  `json:"batchTimeoutMs"`     // 刷新间隔，未攒满的缓冲区每隔该时间发送一次
+	MaxBatchMemoryMB     int  `json:"maxBatchMemoryMB"`   // 每个DC缓冲区的最大数据量，超出后拒绝新条目
+	MaxInFlightBatches   int  `json:"maxInFlightBatches"` // 每个DC同时在途的最大批次数
 	CompressionEnabled   bool `json:"compressionEnabled"`
 	CompressionThreshold int  `json:"compressionThreshold"`
 
 	// 延迟和重试配置
 	MaxReplicationDelayMs int `json:"maxReplicationDelayMs"`
-	RetryAttempts         int `json:"retryAttempts"`
-	RetryBackoffMs        int `json:"retryBackoffMs"`
+	RetryAttempts         int `json:"retryAttempts"`  // 发送失败后的最大重试次数
+	RetryBackoffMs        int `json:"retryBackoffMs"` // 两次重试之间的等待时间
 	HealthCheckIntervalMs int `json:"healthCheckIntervalMs"`
 
 	// 监控和告警配置
@@ -42,10 +46,26 @@ type AsyncReplicationConfig struct {
 	EnableMetrics      bool    `json:"enableMetrics"`
 	EnableAlerts       bool    `json:"enableAlerts"`
 
+	// 复制延迟告警阈值，DataCenterLagThresholds中配置的DC使用各自的阈值
+	LagThresholds           LagThresholds                       `json:"lagThresholds"`
+	DataCenterLagThresholds map[raft.DataCenterID]LagThresholds `json:"dataCenterLagThresholds"`
+
+	// 暂停复制期间每个DC缓冲区的最大条目数，超出后拒绝新条目，为0时只受MaxBatchMemoryMB限制
+	MaxPausedEntries int `json:"maxPausedEntries"`
+
 	// 数据中心优先级配置
 	DataCenterPriorities map[raft.DataCenterID]int `json:"dataCenterPriorities"`
 }
 
+// LagThresholds 复制延迟告警阈值，分别按未确认的条目数和最早的未确认条目的等待时间判定，取较高的级别
+// 为0的阈值不检查
+type LagThresholds struct {
+	WarnEntries     int64 `json:"warnEntries"`
+	CriticalEntries int64 `json:"criticalEntries"`
+	WarnMs          int   `json:"warnMs"`
+	CriticalMs      int   `json:"criticalMs"`
+}
+
 // DefaultAsyncReplicationConfig 默认异步复制配置
 func DefaultAsyncReplicationConfig() *AsyncReplicationConfig {
 	return &AsyncReplicationConfig{
@@ -53,6 +73,7 @@ func DefaultAsyncReplicationConfig() *AsyncReplicationConfig {
 		BatchSize:             100,
 		BatchTimeoutMs:        50,
 		MaxBatchMemoryMB:      64,
+		MaxInFlightBatches:    4,
 		CompressionEnabled:    true,
 		CompressionThreshold:  1024,
 		MaxReplicationDelayMs: 5000,
@@ -63,13 +84,22 @@ func DefaultAsyncReplicationConfig() *AsyncReplicationConfig {
 		ErrorRateThreshold:    0.05,
 		EnableMetrics:         true,
 		EnableAlerts:          true,
-		DataCenterPriorities:  make(map[raft.DataCenterID]int),
+		LagThresholds: LagThresholds{
+			WarnEntries:     10000,
+			CriticalEntries: 100000,
+			WarnMs:          5000,
+			CriticalMs:      30000,
+		},
+		DataCenterLagThresholds: make(map[raft.DataCenterID]LagThresholds),
+		MaxPausedEntries:        1000000,
+		DataCenterPriorities:    make(map[raft.DataCenterID]int),
 	}
 }
 
 // 枚举定义
 type ConnectionState int
 type BatchStatus int
+type LagLevel int
 
 const (
 	// 连接状态
@@ -86,6 +116,42 @@ const (
 	BatchRetrying
 )
 
+// 复制延迟告警级别
+const (
+	LagNormal LagLevel = iota
+	LagWarning
+	LagCritical
+)
+
+var lagLevelNames = map[LagLevel]string{
+	LagNormal:   "normal",
+	LagWarning:  "warning",
+	LagCritical: "critical",
+}
+
+func (l LagLevel) String() string {
+	if name, ok := lagLevelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("LagLevel(%d)", int(l))
+}
+
+// MarshalText 在JSON中以名称表示告警级别
+func (l LagLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// LagAlert 目标DC的复制延迟告警级别变化，Level为LagNormal时表示告警解除
+type LagAlert struct {
+	DataCenter raft.DataCenterID `json:"dataCenter"`
+	Level      LagLevel          `json:"level"`
+	Previous   LagLevel          `json:"previous"`
+	LagEntries int64             `json:"lagEntries"` // 未确认的条目数
+	LagMs      int64             `json:"lagMs"`      // 最早的未确认条目已等待的时间
+	Paused     bool              `json:"paused"`
+	Time       time.Time         `json:"time"`
+}
+
 // AsyncReplicationTarget 异步复制目标
 type AsyncReplicationTarget struct {
 	mu sync.RWMutex
@@ -96,13 +162,22 @@ type AsyncReplicationTarget struct {
 	IsPrimary  bool
 	Priority   int
 
-	// 复制状态跟踪
+	// 复制状态跟踪，LastReplicatedIndex之前的条目都已被目标DC确认
 	LastReplicatedIndex raft.LogIndex
 	LastReplicatedTerm  raft.Term
-	ReplicationLag      time.Duration
+	ReplicationLag      time.Duration // 最近确认的条目从提交复制到被确认的耗时
 	IsHealthy           bool
 	LastHealthCheck     time.Time
 
+	// 复制延迟告警，每次健康检查时更新
+	LagEntries int64         // 已加入缓冲区尚未确认的条目数
+	PendingLag time.Duration // 最早的未确认条目已等待的时间
+	LagLevel   LagLevel
+
+	// 人工暂停：暂停期间条目继续缓冲但不发送，恢复后按索引顺序发出
+	Paused   bool
+	PausedAt time.Time
+
 	// 网络状态
 	ConnectionState ConnectionState
 	FailureCount    int64
@@ -111,8 +186,16 @@ type AsyncReplicationTarget struct {
 
 	// 批量缓冲
 	PendingEntries   []raft.LogEntry
+	PendingBatches   int // 已发出尚未确认的批次数
 	LastBatchSent    time.Time
 	TotalBytesQueued int64
+
+	pendingTimes    []time.Time              // PendingEntries中每个条目加入缓冲区的时间
+	inflight        []*AsyncReplicationBatch // 在途批次，按StartIndex排序
+	lastQueuedIndex raft.LogIndex            // 已加入过缓冲区的最大索引
+	nextNode        int                      // 轮询发送的下一个节点
+	flushCh         chan struct{}            // 缓冲区攒满或在途批次完成时通知刷新
+	stopCh          chan struct{}            // 目标被暂停时关闭，结束刷新协程（由AsyncReplicator.mu保护）
 }
 
 // AsyncReplicationBatch 异步复制批次
@@ -138,6 +221,8 @@ type AsyncReplicationBatch struct {
 	AttemptCount int
 	LastAttempt  time.Time
 	Status       BatchStatus
+
+	queuedAt time.Time // 批次中最早的条目加入缓冲区的时间
 }
 
 // AsyncReplicationMetrics 异步复制指标
@@ -169,16 +254,31 @@ type AsyncReplicationMetrics struct {
 
 	// 按DC分组指标
 	DCMetrics map[raft.DataCenterID]*DCAsyncMetrics
+
+	totalAttempts    int64     // 发送尝试次数，包括重试
+	failedAttempts   int64     // 失败的发送尝试次数
+	timeoutAttempts  int64     // 超时的发送尝试次数
+	throughputMark   int64     // 上次计算吞吐量时的已复制条目数
+	throughputMarkAt time.Time // 上次计算吞吐量的时间
 }
 
 type DCAsyncMetrics struct {
+	BatchesSent       int64
 	EntriesReplicated int64
+	BytesTransferred  int64
 	AverageLatency    time.Duration
+	SuccessRate       float64
 	ErrorCount        int64
 	LastUpdateTime    time.Time
+	LagWarnings       int64 // 复制延迟进入警告级别的次数
+	LagCriticals      int64 // 复制延迟进入严重级别的次数
+
+	attempts int64 // 发送尝试次数，包括重试
 }
 
 // AsyncReplicator 异步复制管理器
+// 每个目标DC有独立的缓冲区和刷新协程：缓冲区攒满BatchSize或每隔BatchTimeoutMs切出批次，
+// 通过Transport发送给目标DC中的节点，批次可以并发在途，确认乱序到达时按索引顺序推进LastReplicatedIndex
 type AsyncReplicator struct {
 	mu sync.RWMutex
 
@@ -188,14 +288,16 @@ type AsyncReplicator struct {
 	raftConfig *raft.Config
 	transport  raft.Transport
 	storage    raft.Storage
-	logger     *log.Logger
+	logger     logging.Logger
+	clock      clock.Clock
 
 	// 复制状态管理
 	replicationTargets map[raft.DataCenterID]*AsyncReplicationTarget
-	pendingBatches     chan *AsyncReplicationBatch
+	suspendedTargets   map[raft.DataCenterID]*AsyncReplicationTarget // 故障转移后暂停复制的DC
 
 	// 监控和统计
-	metrics *AsyncReplicationMetrics
+	metrics      *AsyncReplicationMetrics
+	alertHandler func(alert LagAlert) // 复制延迟告警级别变化时调用，EnableAlerts为false时不调用
 
 	// 控制流
 	ctx     context.Context
@@ -205,9 +307,30 @@ type AsyncReplicator struct {
 	stopCh  chan struct{}
 }
 
-// NewAsyncReplicator 创建异步复制管理器
+// errReplicatorStopped 复制管理器停止时放弃在途批次
+var errReplicatorStopped = errors.New("异步复制管理器已停止")
+
+// errBatchPending 接收端缓存了批次但之前的条目尚未到达，批次需要重发直到被确认
+// 重试耗尽时条目放回缓冲区，不把目标DC标记为不健康
+var errBatchPending = errors.New("复制批次等待之前的批次被确认")
+
+var (
+	// ErrReplicationBackpressure 目标DC的缓冲区已满，调用方应稍后重试或减缓写入
+	ErrReplicationBackpressure = errors.New("异步复制缓冲区已满")
+	// ErrReplicationTargetNotFound 不是当前的复制目标，包括因故障转移暂停复制的DC
+	ErrReplicationTargetNotFound = errors.New("复制目标不存在")
+)
+
+// NewAsyncReplicator 使用默认配置创建异步复制管理器
 func NewAsyncReplicator(nodeID raft.NodeID, raftConfig *raft.Config, transport raft.Transport, storage raft.Storage) *AsyncReplicator {
-	config := DefaultAsyncReplicationConfig()
+	return NewAsyncReplicatorWithConfig(nodeID, nil, raftConfig, transport, storage)
+}
+
+// NewAsyncReplicatorWithConfig 创建异步复制管理器，config为nil时使用默认配置
+func NewAsyncReplicatorWithConfig(nodeID raft.NodeID, config *AsyncReplicationConfig, raftConfig *raft.Config, transport raft.Transport, storage raft.Storage) *AsyncReplicator {
+	if config == nil {
+		config = DefaultAsyncReplicationConfig()
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 
 	replicator := &AsyncReplicator{
@@ -216,9 +339,10 @@ func NewAsyncReplicator(nodeID raft.NodeID, raftConfig *raft.Config, transport r
 		raftConfig:         raftConfig,
 		transport:          transport,
 		storage:            storage,
-		logger:             log.New(log.Writer(), fmt.Sprintf("[async-replicator-%s] ", nodeID), log.LstdFlags),
+		logger:             raftConfig.ComponentLogger("async-replicator"),
+		clock:              clock.Or(raftConfig.Clock),
 		replicationTargets: make(map[raft.DataCenterID]*AsyncReplicationTarget),
-		pendingBatches:     make(chan *AsyncReplicationBatch, 1000),
+		suspendedTargets:   make(map[raft.DataCenterID]*AsyncReplicationTarget),
 		ctx:                ctx,
 		cancel:             cancel,
 		stopCh:             make(chan struct{}),
@@ -235,14 +359,15 @@ func NewAsyncReplicator(nodeID raft.NodeID, raftConfig *raft.Config, transport r
 func (ar *AsyncReplicator) initializeComponents() {
 	// 初始化指标收集器
 	ar.metrics = &AsyncReplicationMetrics{
-		DCMetrics: make(map[raft.DataCenterID]*DCAsyncMetrics),
+		DCMetrics:        make(map[raft.DataCenterID]*DCAsyncMetrics),
+		throughputMarkAt: ar.clock.Now(),
 	}
 }
 
 // initializeTargets 初始化复制目标
 func (ar *AsyncReplicator) initializeTargets() {
 	if ar.raftConfig.MultiDC == nil || !ar.raftConfig.MultiDC.Enabled {
-		ar.logger.Printf("多DC未启用，跳过异步复制目标初始化")
+		ar.logger.Info("多DC未启用，跳过异步复制目标初始化")
 		return
 	}
 
@@ -263,9 +388,15 @@ func (ar *AsyncReplicator) initializeTargets() {
 			priority = 3 // 默认优先级
 		}
 
+		isPrimary := false
+		if dcConfig, exists := ar.raftConfig.MultiDC.DataCenters[dcID]; exists {
+			isPrimary = dcConfig.IsPrimary
+		}
+
 		target := &AsyncReplicationTarget{
 			DataCenter:          dcID,
 			Nodes:               nodes,
+			IsPrimary:           isPrimary,
 			Priority:            priority,
 			LastReplicatedIndex: 0,
 			LastReplicatedTerm:  0,
@@ -273,16 +404,18 @@ func (ar *AsyncReplicator) initializeTargets() {
 			ConnectionState:     ConnectionHealthy,
 			PendingEntries:      make([]raft.LogEntry, 0),
 			RetryBackoff:        time.Duration(ar.config.RetryBackoffMs) * time.Millisecond,
+			flushCh:             make(chan struct{}, 1),
+			stopCh:              make(chan struct{}),
 		}
 
 		ar.replicationTargets[dcID] = target
 
 		// 初始化DC指标
 		ar.metrics.DCMetrics[dcID] = &DCAsyncMetrics{
-			LastUpdateTime: time.Now(),
+			LastUpdateTime: ar.clock.Now(),
 		}
 
-		ar.logger.Printf("初始化异步复制目标: DC=%s, 节点数=%d, 优先级=%d", dcID, len(nodes), priority)
+		ar.logger.Info("初始化异步复制目标", "target_dc", dcID, "nodes", len(nodes), "priority", priority)
 	}
 }
 
@@ -295,47 +428,49 @@ func (ar *AsyncReplicator) Start() error {
 		return fmt.Errorf("异步复制管理器已在运行")
 	}
 
-	ar.logger.Printf("启动异步复制管理器")
+	ar.logger.Info("启动异步复制管理器")
 
-	// 启动工作线程
-	ar.wg.Add(2)
-	go ar.replicationWorkerLoop()
+	// 启动工作线程，每个目标DC一个刷新协程
+	ar.wg.Add(2 + len(ar.replicationTargets))
+	go ar.healthCheckLoop()
 	go ar.metricsCollectionLoop()
+	for _, target := range ar.replicationTargets {
+		go ar.flushLoop(target, target.stopCh)
+	}
 
 	ar.running = true
-	ar.logger.Printf("异步复制管理器启动成功")
+	ar.logger.Info("异步复制管理器启动成功")
 
 	return nil
 }
 
-// Stop 停止异步复制管理器
+// Stop 停止异步复制管理器，在途批次被放弃，未确认的条目不会再发送
 func (ar *AsyncReplicator) Stop() error {
 	ar.mu.Lock()
-	defer ar.mu.Unlock()
-
 	if !ar.running {
+		ar.mu.Unlock()
 		return nil
 	}
 
-	ar.logger.Printf("停止异步复制管理器")
+	ar.logger.Info("停止异步复制管理器")
 
 	// 发送停止信号
 	close(ar.stopCh)
 	ar.cancel()
+	ar.running = false
+	ar.mu.Unlock()
 
-	// 等待工作线程结束
+	// 等待工作线程和在途批次结束（不持有锁，健康检查需要读锁）
 	ar.wg.Wait()
 
-	// 关闭队列
-	close(ar.pendingBatches)
-
-	ar.running = false
-	ar.logger.Printf("异步复制管理器已停止")
+	ar.logger.Info("异步复制管理器已停止")
 
 	return nil
 }
 
-// ReplicateAsync 异步复制日志条目
+// ReplicateAsync 把日志条目加入每个目标DC的复制缓冲区，由刷新协程分批发送
+// 已加入过缓冲区的条目会被跳过；某个DC的缓冲区超过MaxBatchMemoryMB，或暂停期间超过MaxPausedEntries时，
+// 该DC拒绝本次条目并返回ErrReplicationBackpressure
 func (ar *AsyncReplicator) ReplicateAsync(entries []raft.LogEntry) error {
 	if len(entries) == 0 {
 		return nil
@@ -344,22 +479,114 @@ func (ar *AsyncReplicator) ReplicateAsync(entries []raft.LogEntry) error {
 	ar.mu.RLock()
 	defer ar.mu.RUnlock()
 
-	// 为每个目标DC创建异步复制批次
+	select {
+	case <-ar.ctx.Done():
+		return errReplicatorStopped
+	default:
+	}
+
+	var full []raft.DataCenterID
 	for dcID, target := range ar.replicationTargets {
-		if !ar.shouldReplicateToDC(target, entries) {
-			continue
+		if !ar.enqueueEntries(target, entries) {
+			ar.logger.Warn("异步复制缓冲区已满", "target_dc", dcID)
+			full = append(full, dcID)
 		}
+	}
 
-		batch := ar.createReplicationBatch(dcID, entries, target.Priority)
+	if len(full) > 0 {
+		return fmt.Errorf("%w: DC %v", ErrReplicationBackpressure, full)
+	}
+	return nil
+}
 
-		select {
-		case ar.pendingBatches <- batch:
-			ar.logger.Printf("已加入异步复制队列: DC=%s, 条目数=%d", dcID, len(entries))
-		case <-ar.ctx.Done():
-			return fmt.Errorf("异步复制管理器已停止")
-		default:
-			ar.logger.Printf("警告: 异步复制队列已满, DC=%s", dcID)
+// PauseReplication 暂停向目标DC发送批次，用于目标DC降级时停止向其施压
+// 已在途的批次继续完成，新条目继续加入缓冲区，直到达到MaxPausedEntries
+func (ar *AsyncReplicator) PauseReplication(dcID raft.DataCenterID) error {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+
+	target, exists := ar.replicationTargets[dcID]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrReplicationTargetNotFound, dcID)
+	}
+
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	if !target.Paused {
+		target.Paused = true
+		target.PausedAt = ar.clock.Now()
+		ar.logger.Warn("暂停向DC复制", "target_dc", dcID, "pending_entries", len(target.PendingEntries))
+	}
+	return nil
+}
+
+// ResumeReplication 恢复向目标DC发送批次，暂停期间缓冲的条目按索引顺序发出
+func (ar *AsyncReplicator) ResumeReplication(dcID raft.DataCenterID) error {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+
+	target, exists := ar.replicationTargets[dcID]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrReplicationTargetNotFound, dcID)
+	}
+
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	if target.Paused {
+		ar.logger.Info("恢复向DC复制", "target_dc", dcID, "pending_entries", len(target.PendingEntries), "paused_for", ar.clock.Now().Sub(target.PausedAt))
+		target.Paused = false
+		target.PausedAt = time.Time{}
+		target.notifyFlush()
+	}
+	return nil
+}
+
+// SetLagAlertHandler 设置复制延迟告警级别变化时的回调，在健康检查协程中调用
+func (ar *AsyncReplicator) SetLagAlertHandler(handler func(alert LagAlert)) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	ar.alertHandler = handler
+}
+
+// UpdateTargets 故障转移后更新复制目标：暂停向failedDC复制，primaryDC成为新的主DC
+// 被暂停的DC保留缓冲区中的条目，之后作为primaryDC传入时恢复复制；暂停期间的条目由一致性恢复补齐
+// failedDC为空时只切换主DC
+func (ar *AsyncReplicator) UpdateTargets(failedDC, primaryDC raft.DataCenterID) error {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	if failedDC != "" && failedDC == primaryDC {
+		return fmt.Errorf("故障DC不能作为新的主DC: %s", failedDC)
+	}
+
+	if target, exists := ar.suspendedTargets[primaryDC]; exists {
+		delete(ar.suspendedTargets, primaryDC)
+		target.stopCh = make(chan struct{})
+		ar.replicationTargets[primaryDC] = target
+		if ar.running {
+			ar.wg.Add(1)
+			go ar.flushLoop(target, target.stopCh)
 		}
+		ar.logger.Info("恢复向DC复制", "target_dc", primaryDC)
+	}
+
+	if target, exists := ar.replicationTargets[failedDC]; exists {
+		delete(ar.replicationTargets, failedDC)
+		close(target.stopCh)
+		ar.suspendedTargets[failedDC] = target
+		ar.logger.Warn("暂停向故障DC复制", "target_dc", failedDC)
+	}
+
+	for dcID, target := range ar.replicationTargets {
+		target.mu.Lock()
+		target.IsPrimary = dcID == primaryDC
+		target.mu.Unlock()
+	}
+	for _, target := range ar.suspendedTargets {
+		target.mu.Lock()
+		target.IsPrimary = false
+		target.mu.Unlock()
 	}
 
 	return nil
@@ -373,9 +600,32 @@ func (ar *AsyncReplicator) GetReplicationStatus() map[raft.DataCenterID]*AsyncRe
 	status := make(map[raft.DataCenterID]*AsyncReplicationTarget)
 	for dcID, target := range ar.replicationTargets {
 		// 创建副本避免并发访问问题
-		targetCopy := &AsyncReplicationTarget{}
-		*targetCopy = *target
-		status[dcID] = targetCopy
+		target.mu.RLock()
+		status[dcID] = &AsyncReplicationTarget{
+			DataCenter:          target.DataCenter,
+			Nodes:               append([]raft.NodeID(nil), target.Nodes...),
+			IsPrimary:           target.IsPrimary,
+			Priority:            target.Priority,
+			LastReplicatedIndex: target.LastReplicatedIndex,
+			LastReplicatedTerm:  target.LastReplicatedTerm,
+			ReplicationLag:      target.ReplicationLag,
+			IsHealthy:           target.IsHealthy,
+			LastHealthCheck:     target.LastHealthCheck,
+			LagEntries:          target.LagEntries,
+			PendingLag:          target.PendingLag,
+			LagLevel:            target.LagLevel,
+			Paused:              target.Paused,
+			PausedAt:            target.PausedAt,
+			ConnectionState:     target.ConnectionState,
+			FailureCount:        target.FailureCount,
+			LastSuccessTime:     target.LastSuccessTime,
+			RetryBackoff:        target.RetryBackoff,
+			PendingEntries:      append([]raft.LogEntry(nil), target.PendingEntries...),
+			PendingBatches:      target.PendingBatches,
+			LastBatchSent:       target.LastBatchSent,
+			TotalBytesQueued:    target.TotalBytesQueued,
+		}
+		target.mu.RUnlock()
 	}
 
 	return status
@@ -387,11 +637,27 @@ func (ar *AsyncReplicator) GetMetrics() *AsyncReplicationMetrics {
 	defer ar.metrics.mu.RUnlock()
 
 	// 创建指标副本
-	metricsCopy := &AsyncReplicationMetrics{}
-	*metricsCopy = *ar.metrics
-	metricsCopy.DCMetrics = make(map[raft.DataCenterID]*DCAsyncMetrics)
+	m := ar.metrics
+	metricsCopy := &AsyncReplicationMetrics{
+		TotalBatchesSent:       m.TotalBatchesSent,
+		TotalEntriesReplicated: m.TotalEntriesReplicated,
+		TotalBytesTransferred:  m.TotalBytesTransferred,
+		ReplicationThroughput:  m.ReplicationThroughput,
+		AverageLatency:         m.AverageLatency,
+		P95Latency:             m.P95Latency,
+		P99Latency:             m.P99Latency,
+		MaxLatency:             m.MaxLatency,
+		SuccessRate:            m.SuccessRate,
+		ErrorRate:              m.ErrorRate,
+		TimeoutRate:            m.TimeoutRate,
+		CompressionRatio:       m.CompressionRatio,
+		NetworkUtilization:     m.NetworkUtilization,
+		CPUUsage:               m.CPUUsage,
+		MemoryUsage:            m.MemoryUsage,
+		DCMetrics:              make(map[raft.DataCenterID]*DCAsyncMetrics),
+	}
 
-	for dcID, dcMetrics := range ar.metrics.DCMetrics {
+	for dcID, dcMetrics := range m.DCMetrics {
 		dcMetricsCopy := &DCAsyncMetrics{}
 		*dcMetricsCopy = *dcMetrics
 		metricsCopy.DCMetrics[dcID] = dcMetricsCopy
@@ -401,24 +667,161 @@ func (ar *AsyncReplicator) GetMetrics() *AsyncReplicationMetrics {
 }
 
 // 内部方法实现
-func (ar *AsyncReplicator) shouldReplicateToDC(target *AsyncReplicationTarget, entries []raft.LogEntry) bool {
-	if !target.IsHealthy {
-		return false
+
+// enqueueEntries 把尚未加入过缓冲区的条目追加到目标DC的缓冲区，缓冲区已满时返回false
+func (ar *AsyncReplicator) enqueueEntries(target *AsyncReplicationTarget, entries []raft.LogEntry) bool {
+	target.mu.Lock()
+	defer target.mu.Unlock()
+
+	var size int64
+	first, count := len(entries), 0
+	for i, entry := range entries {
+		if entry.Index > target.lastQueuedIndex {
+			if first == len(entries) {
+				first = i
+			}
+			size += int64(len(entry.Data))
+			count++
+		}
+	}
+	if first == len(entries) {
+		return true
 	}
 
-	if len(entries) == 0 {
+	limit := int64(ar.config.MaxBatchMemoryMB) << 20
+	if limit > 0 && target.TotalBytesQueued+size > limit {
+		return false
+	}
+	if target.Paused && ar.config.MaxPausedEntries > 0 && len(target.PendingEntries)+count > ar.config.MaxPausedEntries {
 		return false
 	}
 
-	lastEntry := entries[len(entries)-1]
-	return lastEntry.Index > target.LastReplicatedIndex
+	now := ar.clock.Now()
+	for _, entry := range entries[first:] {
+		if entry.Index <= target.lastQueuedIndex {
+			continue
+		}
+		target.PendingEntries = append(target.PendingEntries, entry)
+		target.pendingTimes = append(target.pendingTimes, now)
+		target.lastQueuedIndex = entry.Index
+	}
+	target.TotalBytesQueued += size
+
+	if len(target.PendingEntries) >= ar.batchSize() {
+		target.notifyFlush()
+	}
+	return true
+}
+
+// notifyFlush 通知刷新协程，已有未处理的通知时直接返回
+func (target *AsyncReplicationTarget) notifyFlush() {
+	select {
+	case target.flushCh <- struct{}{}:
+	default:
+	}
+}
+
+// batchSize 单个批次的最大条目数
+func (ar *AsyncReplicator) batchSize() int {
+	if ar.config.BatchSize > 0 {
+		return ar.config.BatchSize
+	}
+	return 1
+}
+
+// flushInterval 未攒满的缓冲区的刷新间隔
+func (ar *AsyncReplicator) flushInterval() time.Duration {
+	if ar.config.BatchTimeoutMs > 0 {
+		return time.Duration(ar.config.BatchTimeoutMs) * time.Millisecond
+	}
+	return 50 * time.Millisecond
+}
+
+// flushLoop 目标DC的刷新协程：缓冲区攒满时发送整批，每隔刷新间隔把剩余条目全部发出
+func (ar *AsyncReplicator) flushLoop(target *AsyncReplicationTarget, targetStopCh <-chan struct{}) {
+	defer ar.wg.Done()
+
+	ticker := ar.clock.NewTicker(ar.flushInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-target.flushCh:
+			ar.flushTarget(target, false)
+		case <-ticker.C():
+			ar.flushTarget(target, true)
+		case <-targetStopCh:
+			return
+		case <-ar.stopCh:
+			return
+		}
+	}
+}
+
+// flushTarget 把缓冲区切成批次并发送，force为false时只发送攒满的批次；在途批次达到上限或目标DC被暂停时停止
+func (ar *AsyncReplicator) flushTarget(target *AsyncReplicationTarget, force bool) {
+	batchSize := ar.batchSize()
+	maxInFlight := ar.config.MaxInFlightBatches
+	if maxInFlight <= 0 {
+		maxInFlight = 1
+	}
+
+	for {
+		target.mu.Lock()
+		n := len(target.PendingEntries)
+		if n == 0 || target.Paused || (!force && n < batchSize) || len(target.inflight) >= maxInFlight {
+			target.mu.Unlock()
+			return
+		}
+		if n > batchSize {
+			n = batchSize
+		}
+
+		entries := append([]raft.LogEntry(nil), target.PendingEntries[:n]...)
+		queuedAt := target.pendingTimes[0]
+		target.PendingEntries = append(target.PendingEntries[:0], target.PendingEntries[n:]...)
+		target.pendingTimes = append(target.pendingTimes[:0], target.pendingTimes[n:]...)
+
+		batch := ar.createReplicationBatch(target.DataCenter, entries, target.Priority)
+		batch.queuedAt = queuedAt
+		batch.Status = BatchInProgress
+		target.TotalBytesQueued -= int64(batch.OriginalSize)
+		target.LastBatchSent = ar.clock.Now()
+		target.insertInflight(batch)
+		target.mu.Unlock()
+
+		ar.wg.Add(1)
+		go ar.sendBatch(target, batch)
+	}
+}
+
+// insertInflight 按StartIndex顺序插入在途批次（调用方需持有target.mu）
+func (target *AsyncReplicationTarget) insertInflight(batch *AsyncReplicationBatch) {
+	i := sort.Search(len(target.inflight), func(i int) bool {
+		return target.inflight[i].StartIndex > batch.StartIndex
+	})
+	target.inflight = append(target.inflight, nil)
+	copy(target.inflight[i+1:], target.inflight[i:])
+	target.inflight[i] = batch
+	target.updatePendingBatches()
+}
+
+// updatePendingBatches 统计尚未确认的在途批次（调用方需持有target.mu）
+func (target *AsyncReplicationTarget) updatePendingBatches() {
+	pending := 0
+	for _, b := range target.inflight {
+		if b.Status != BatchCompleted {
+			pending++
+		}
+	}
+	target.PendingBatches = pending
 }
 
 func (ar *AsyncReplicator) createReplicationBatch(dcID raft.DataCenterID, entries []raft.LogEntry, priority int) *AsyncReplicationBatch {
 	batch := &AsyncReplicationBatch{
-		BatchID:      fmt.Sprintf("batch-%d-%s", time.Now().UnixNano(), dcID),
+		BatchID:      fmt.Sprintf("batch-%d-%s-%d", ar.clock.Now().UnixNano(), dcID, entries[0].Index),
 		TargetDC:     dcID,
-		CreatedAt:    time.Now(),
+		CreatedAt:    ar.clock.Now(),
 		Priority:     priority,
 		Entries:      entries,
 		StartIndex:   entries[0].Index,
@@ -435,24 +838,292 @@ func (ar *AsyncReplicator) createReplicationBatch(dcID raft.DataCenterID, entrie
 	return batch
 }
 
+// SendEntries 把日志条目直接发送到目标DC的节点，不经过缓冲区，也不推进复制进度，供一致性修复使用
+// 失败后每隔RetryBackoffMs重试，最多重试RetryAttempts次
+func (ar *AsyncReplicator) SendEntries(dcID raft.DataCenterID, entries []raft.LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	ar.mu.RLock()
+	target, exists := ar.replicationTargets[dcID]
+	ar.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("复制目标不存在: %s", dcID)
+	}
+
+	batch := ar.createReplicationBatch(dcID, entries, 1)
+	retryDelay := time.Duration(ar.config.RetryBackoffMs) * time.Millisecond
+
+	var err error
+	for attempt := 0; attempt <= ar.config.RetryAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ar.clock.After(retryDelay):
+			case <-ar.stopCh:
+				return errReplicatorStopped
+			}
+		}
+
+		batch.AttemptCount++
+		batch.LastAttempt = ar.clock.Now()
+		if err = ar.sendBatchToNode(batch, target.pickNode()); err == nil || errors.Is(err, errReplicatorStopped) {
+			return err
+		}
+	}
+	return err
+}
+
+// sendBatch 发送批次，失败后每隔RetryBackoffMs重试，最多重试RetryAttempts次
+func (ar *AsyncReplicator) sendBatch(target *AsyncReplicationTarget, batch *AsyncReplicationBatch) {
+	defer ar.wg.Done()
+
+	retryDelay := time.Duration(ar.config.RetryBackoffMs) * time.Millisecond
+
+	var err error
+	for attempt := 0; attempt <= ar.config.RetryAttempts; attempt++ {
+		if attempt > 0 {
+			target.mu.Lock()
+			batch.Status = BatchRetrying
+			target.mu.Unlock()
+			select {
+			case <-ar.clock.After(retryDelay):
+			case <-ar.stopCh:
+				return
+			}
+		}
+
+		batch.AttemptCount++
+		batch.LastAttempt = ar.clock.Now()
+
+		start := ar.clock.Now()
+		err = ar.sendBatchToNode(batch, target.pickNode())
+		ar.recordAttempt(batch, err, ar.clock.Now().Sub(start))
+		if err == nil {
+			break
+		}
+		if errors.Is(err, errReplicatorStopped) {
+			return
+		}
+		if errors.Is(err, errBatchPending) {
+			ar.logger.Debug("复制批次等待之前的批次", "batch_id", batch.BatchID, "target_dc", batch.TargetDC, "attempt", batch.AttemptCount)
+			continue
+		}
+		ar.logger.Warn("发送复制批次失败", "batch_id", batch.BatchID, "target_dc", batch.TargetDC, "attempt", batch.AttemptCount, logging.FieldError, err)
+	}
+
+	ar.completeBatch(target, batch, err)
+}
+
+// pickNode 在目标DC的节点之间轮询选择接收节点
+func (target *AsyncReplicationTarget) pickNode() raft.NodeID {
+	target.mu.Lock()
+	defer target.mu.Unlock()
+
+	if len(target.Nodes) == 0 {
+		return ""
+	}
+	node := target.Nodes[target.nextNode%len(target.Nodes)]
+	target.nextNode++
+	return node
+}
+
+// sendBatchToNode 把批次发送给目标节点：传输层支持时发送压缩的追加请求，由目标DC的接收端确认，
+// 否则作为普通的AppendEntries请求发送
+func (ar *AsyncReplicator) sendBatchToNode(batch *AsyncReplicationBatch, nodeID raft.NodeID) error {
+	if nodeID == "" {
+		return fmt.Errorf("DC %s 没有可用节点", batch.TargetDC)
+	}
+
+	req := &raft.AppendEntriesRequest{
+		Term:         batch.Entries[len(batch.Entries)-1].Term,
+		LeaderID:     ar.nodeID,
+		PrevLogIndex: batch.StartIndex - 1,
+		Entries:      batch.Entries,
+		LeaderCommit: batch.EndIndex,
+	}
+	if ar.storage != nil {
+		if term, err := ar.storage.GetCurrentTerm(); err == nil && term > req.Term {
+			req.Term = term
+		}
+		if req.PrevLogIndex > 0 {
+			if prev, err := ar.storage.GetLogEntry(req.PrevLogIndex); err == nil && prev != nil {
+				req.PrevLogTerm = prev.Term
+			}
+		}
+	}
+
+	timeout := time.Duration(ar.config.MaxReplicationDelayMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ar.ctx, timeout)
+	defer cancel()
+
+	if sender, ok := ar.transport.(raft.CompressedAppendEntriesSender); ok {
+		return ar.sendCompressedBatch(ctx, sender, batch, nodeID, req)
+	}
+
+	resp, err := ar.transport.SendAppendEntries(ctx, nodeID, req)
+	if err != nil {
+		if ar.ctx.Err() != nil {
+			return errReplicatorStopped
+		}
+		return fmt.Errorf("发送到节点 %s 失败: %w", nodeID, err)
+	}
+	if resp == nil || !resp.Success {
+		return fmt.Errorf("节点 %s 拒绝复制批次 [%d, %d]", nodeID, batch.StartIndex, batch.EndIndex)
+	}
+	return nil
+}
+
+// sendCompressedBatch 把批次压缩后发送给目标DC的接收端
+// 接收端的LastProcessedIndex达到批次末尾才算确认，批次被缓存等待之前的条目时返回errBatchPending
+func (ar *AsyncReplicator) sendCompressedBatch(ctx context.Context, sender raft.CompressedAppendEntriesSender,
+	batch *AsyncReplicationBatch, nodeID raft.NodeID, base *raft.AppendEntriesRequest) error {
+	compression := raft.CompressionNone
+	if ar.config.CompressionEnabled && batch.OriginalSize >= ar.config.CompressionThreshold {
+		compression = raft.CompressionGzip
+	}
+
+	req := &raft.CompressedAppendEntriesRequest{
+		Term:         base.Term,
+		LeaderID:     base.LeaderID,
+		PrevLogIndex: base.PrevLogIndex,
+		PrevLogTerm:  base.PrevLogTerm,
+		LeaderCommit: base.LeaderCommit,
+		BatchID:      batch.BatchID,
+		SequenceNum:  batch.AttemptCount,
+		TargetDC:     batch.TargetDC,
+		Priority:     batch.Priority,
+	}
+	if ar.raftConfig.MultiDC != nil && ar.raftConfig.MultiDC.LocalDataCenter != nil {
+		req.SourceDC = ar.raftConfig.MultiDC.LocalDataCenter.ID
+	}
+	if err := raft.CompressEntries(req, batch.Entries, compression); err != nil {
+		return fmt.Errorf("压缩复制批次失败: %w", err)
+	}
+	batch.CompressedData = req.CompressedData
+	batch.Checksum = req.Checksum
+	batch.CompressionRatio = float64(len(req.CompressedData)) / float64(req.OriginalSize)
+
+	resp, err := sender.SendCompressedAppendEntries(ctx, nodeID, req)
+	if err != nil {
+		if ar.ctx.Err() != nil {
+			return errReplicatorStopped
+		}
+		return fmt.Errorf("发送到节点 %s 失败: %w", nodeID, err)
+	}
+	switch {
+	case resp == nil || !resp.Success:
+		return fmt.Errorf("节点 %s 拒绝复制批次 [%d, %d]", nodeID, batch.StartIndex, batch.EndIndex)
+	case resp.LastProcessedIndex < batch.EndIndex:
+		return fmt.Errorf("%w: 节点 %s 已处理到 %d，批次 [%d, %d]", errBatchPending, nodeID, resp.LastProcessedIndex, batch.StartIndex, batch.EndIndex)
+	}
+	return nil
+}
+
+// completeBatch 记录批次结果
+// 成功时按索引顺序推进LastReplicatedIndex，之前的批次尚未确认时等待它们；
+// 重试耗尽时把条目放回缓冲区头部等待下次刷新，不是在等待之前的批次时把目标DC标记为不健康
+func (ar *AsyncReplicator) completeBatch(target *AsyncReplicationTarget, batch *AsyncReplicationBatch, err error) {
+	target.mu.Lock()
+	defer target.mu.Unlock()
+
+	now := ar.clock.Now()
+	if err != nil {
+		batch.Status = BatchFailed
+		target.removeInflight(batch)
+
+		target.requeue(batch)
+		if errors.Is(err, errBatchPending) {
+			// 目标DC在线，只是之前的批次尚未送达，条目随之后的刷新重新发送
+			ar.logger.Debug("复制批次未被确认，条目放回缓冲区", "batch_id", batch.BatchID, "target_dc", batch.TargetDC, "start_index", batch.StartIndex, "end_index", batch.EndIndex)
+			target.notifyFlush()
+			return
+		}
+
+		target.FailureCount++
+		target.IsHealthy = false
+		target.ConnectionState = ConnectionFailed
+		ar.logger.Warn("复制批次重试耗尽，条目放回缓冲区", "batch_id", batch.BatchID, "target_dc", batch.TargetDC, "start_index", batch.StartIndex, "end_index", batch.EndIndex)
+		return
+	}
+
+	batch.Status = BatchCompleted
+	for len(target.inflight) > 0 && target.inflight[0].Status == BatchCompleted {
+		done := target.inflight[0]
+		if len(target.PendingEntries) > 0 && target.PendingEntries[0].Index < done.StartIndex {
+			// 更早的条目发送失败后放回了缓冲区，等它们被确认
+			break
+		}
+		target.inflight = target.inflight[1:]
+		if done.EndIndex > target.LastReplicatedIndex {
+			target.LastReplicatedIndex = done.EndIndex
+			target.LastReplicatedTerm = done.Entries[len(done.Entries)-1].Term
+			target.ReplicationLag = now.Sub(done.queuedAt)
+		}
+	}
+	target.updatePendingBatches()
+	target.LastSuccessTime = now
+	target.FailureCount = 0
+	target.IsHealthy = true
+	target.ConnectionState = ConnectionHealthy
+
+	// 在途名额已释放，继续发送缓冲区中攒满的批次
+	target.notifyFlush()
+}
+
+// requeue 把发送失败的批次条目按索引顺序放回缓冲区（调用方需持有target.mu）
+func (target *AsyncReplicationTarget) requeue(batch *AsyncReplicationBatch) {
+	i := sort.Search(len(target.PendingEntries), func(i int) bool {
+		return target.PendingEntries[i].Index > batch.StartIndex
+	})
+
+	entries := make([]raft.LogEntry, 0, len(target.PendingEntries)+len(batch.Entries))
+	entries = append(entries, target.PendingEntries[:i]...)
+	entries = append(entries, batch.Entries...)
+	target.PendingEntries = append(entries, target.PendingEntries[i:]...)
+
+	times := make([]time.Time, 0, len(target.pendingTimes)+len(batch.Entries))
+	times = append(times, target.pendingTimes[:i]...)
+	for range batch.Entries {
+		times = append(times, batch.queuedAt)
+	}
+	target.pendingTimes = append(times, target.pendingTimes[i:]...)
+
+	target.TotalBytesQueued += int64(batch.OriginalSize)
+}
+
+// removeInflight 移除在途批次（调用方需持有target.mu）
+func (target *AsyncReplicationTarget) removeInflight(batch *AsyncReplicationBatch) {
+	for i, b := range target.inflight {
+		if b == batch {
+			target.inflight = append(target.inflight[:i], target.inflight[i+1:]...)
+			break
+		}
+	}
+	target.updatePendingBatches()
+}
+
 // 工作线程循环
-func (ar *AsyncReplicator) replicationWorkerLoop() {
+func (ar *AsyncReplicator) healthCheckLoop() {
 	defer ar.wg.Done()
-	ar.logger.Printf("复制工作循环已启动")
+	ar.logger.Debug("健康检查循环已启动")
 
-	ticker := time.NewTicker(100 * time.Millisecond)
+	interval := time.Duration(ar.config.HealthCheckIntervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := ar.clock.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case batch := <-ar.pendingBatches:
-			if batch != nil {
-				ar.processBatch(batch)
-			}
-		case <-ticker.C:
+		case <-ticker.C():
 			ar.performHealthChecks()
 		case <-ar.stopCh:
-			ar.logger.Printf("复制工作循环已停止")
+			ar.logger.Debug("健康检查循环已停止")
 			return
 		}
 	}
@@ -460,70 +1131,133 @@ func (ar *AsyncReplicator) replicationWorkerLoop() {
 
 func (ar *AsyncReplicator) metricsCollectionLoop() {
 	defer ar.wg.Done()
-	ar.logger.Printf("指标收集循环已启动")
+	ar.logger.Debug("指标收集循环已启动")
 
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := ar.clock.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			ar.updateMetrics()
 		case <-ar.stopCh:
-			ar.logger.Printf("指标收集循环已停止")
+			ar.logger.Debug("指标收集循环已停止")
 			return
 		}
 	}
 }
 
-func (ar *AsyncReplicator) processBatch(batch *AsyncReplicationBatch) {
-	start := time.Now()
-	ar.logger.Printf("处理复制批次: %s, DC=%s", batch.BatchID, batch.TargetDC)
-
-	target, exists := ar.replicationTargets[batch.TargetDC]
-	if !exists {
-		ar.logger.Printf("目标DC不存在: %s", batch.TargetDC)
-		return
-	}
-
-	// 模拟异步复制处理
-	batch.Status = BatchInProgress
-	batch.AttemptCount++
-	batch.LastAttempt = time.Now()
-
-	// 更新目标状态
-	target.mu.Lock()
-	if len(batch.Entries) > 0 {
-		target.LastReplicatedIndex = batch.EndIndex
-		target.LastReplicatedTerm = batch.Entries[len(batch.Entries)-1].Term
-	}
-	target.LastSuccessTime = time.Now()
-	target.IsHealthy = true
-	target.mu.Unlock()
-
-	// 更新指标
-	ar.updateBatchMetrics(batch, time.Since(start))
-
-	batch.Status = BatchCompleted
-	ar.logger.Printf("批次处理完成: %s, 延迟=%v", batch.BatchID, time.Since(start))
-}
-
+// performHealthChecks 最早的未确认条目等待超过MaxReplicationDelayMs的目标DC标记为降级（暂停复制的DC除外），
+// 并按告警阈值更新各DC的复制延迟级别，级别变化时记录告警
 func (ar *AsyncReplicator) performHealthChecks() {
+	maxDelay := time.Duration(ar.config.MaxReplicationDelayMs) * time.Millisecond
+
+	ar.mu.RLock()
+	handler := ar.alertHandler
+	var alerts []LagAlert
 	for dcID, target := range ar.replicationTargets {
 		target.mu.Lock()
-		target.LastHealthCheck = time.Now()
+		now := ar.clock.Now()
+		target.LastHealthCheck = now
 
-		// 简单的健康检查逻辑
-		if time.Since(target.LastSuccessTime) > time.Duration(ar.config.MaxReplicationDelayMs)*time.Millisecond {
+		oldest := time.Time{}
+		if len(target.inflight) > 0 {
+			oldest = target.inflight[0].queuedAt
+		}
+		if len(target.pendingTimes) > 0 && (oldest.IsZero() || target.pendingTimes[0].Before(oldest)) {
+			oldest = target.pendingTimes[0]
+		}
+		delayed := !oldest.IsZero() && now.Sub(oldest) > maxDelay
+
+		switch {
+		case target.ConnectionState == ConnectionFailed || target.Paused:
+			// 等待重新发送成功；暂停期间的积压是人工操作造成的，不视为降级
+		case delayed && target.ConnectionState != ConnectionDegraded:
 			target.IsHealthy = false
 			target.ConnectionState = ConnectionDegraded
-		} else {
+			ar.logger.Warn("复制延迟超过阈值", "target_dc", dcID, "max_delay", maxDelay)
+		case !delayed && target.ConnectionState == ConnectionDegraded:
 			target.IsHealthy = true
 			target.ConnectionState = ConnectionHealthy
 		}
+
+		target.PendingLag = 0
+		if !oldest.IsZero() {
+			target.PendingLag = now.Sub(oldest)
+		}
+		target.LagEntries = int64(len(target.PendingEntries))
+		for _, batch := range target.inflight {
+			target.LagEntries += int64(len(batch.Entries))
+		}
+		if level := ar.lagThresholds(dcID).level(target.LagEntries, target.PendingLag); level != target.LagLevel {
+			alerts = append(alerts, LagAlert{
+				DataCenter: dcID,
+				Level:      level,
+				Previous:   target.LagLevel,
+				LagEntries: target.LagEntries,
+				LagMs:      target.PendingLag.Milliseconds(),
+				Paused:     target.Paused,
+				Time:       now,
+			})
+			target.LagLevel = level
+		}
 		target.mu.Unlock()
+	}
+	ar.mu.RUnlock()
 
-		ar.logger.Printf("健康检查: DC=%s, 健康状态=%t", dcID, target.IsHealthy)
+	for _, alert := range alerts {
+		ar.recordLagAlert(alert)
+		if handler != nil && ar.config.EnableAlerts {
+			handler(alert)
+		}
+	}
+}
+
+// lagThresholds 目标DC的告警阈值，未单独配置时使用LagThresholds
+func (ar *AsyncReplicator) lagThresholds(dcID raft.DataCenterID) LagThresholds {
+	if thresholds, ok := ar.config.DataCenterLagThresholds[dcID]; ok {
+		return thresholds
+	}
+	return ar.config.LagThresholds
+}
+
+// level 按未确认的条目数与等待时间计算告警级别
+func (t LagThresholds) level(entries int64, lag time.Duration) LagLevel {
+	ms := lag.Milliseconds()
+	switch {
+	case t.CriticalEntries > 0 && entries >= t.CriticalEntries,
+		t.CriticalMs > 0 && ms >= int64(t.CriticalMs):
+		return LagCritical
+	case t.WarnEntries > 0 && entries >= t.WarnEntries,
+		t.WarnMs > 0 && ms >= int64(t.WarnMs):
+		return LagWarning
+	default:
+		return LagNormal
+	}
+}
+
+// recordLagAlert 记录告警级别变化的日志，并累计进入各级别的次数
+func (ar *AsyncReplicator) recordLagAlert(alert LagAlert) {
+	fields := []interface{}{"target_dc", alert.DataCenter, "level", alert.Level, "previous", alert.Previous,
+		"lag_entries", alert.LagEntries, "lag_ms", alert.LagMs, "paused", alert.Paused}
+	switch alert.Level {
+	case LagCritical:
+		ar.logger.Error("复制延迟达到严重级别", fields...)
+	case LagWarning:
+		ar.logger.Warn("复制延迟达到警告级别", fields...)
+	default:
+		ar.logger.Info("复制延迟告警解除", fields...)
+	}
+
+	ar.metrics.mu.Lock()
+	defer ar.metrics.mu.Unlock()
+	if dcMetrics := ar.metrics.DCMetrics[alert.DataCenter]; dcMetrics != nil {
+		switch alert.Level {
+		case LagCritical:
+			dcMetrics.LagCriticals++
+		case LagWarning:
+			dcMetrics.LagWarnings++
+		}
 	}
 }
 
@@ -532,31 +1266,67 @@ func (ar *AsyncReplicator) updateMetrics() {
 	defer ar.metrics.mu.Unlock()
 
 	// 更新总体指标
-	ar.metrics.ReplicationThroughput = float64(ar.metrics.TotalEntriesReplicated) / time.Since(time.Now().Add(-5*time.Second)).Seconds()
+	now := ar.clock.Now()
+	if elapsed := now.Sub(ar.metrics.throughputMarkAt).Seconds(); elapsed > 0 {
+		ar.metrics.ReplicationThroughput = float64(ar.metrics.TotalEntriesReplicated-ar.metrics.throughputMark) / elapsed
+	}
+	ar.metrics.throughputMark = ar.metrics.TotalEntriesReplicated
+	ar.metrics.throughputMarkAt = now
 
 	// 更新DC指标
-	for dcID, dcMetrics := range ar.metrics.DCMetrics {
-		dcMetrics.LastUpdateTime = time.Now()
-		ar.logger.Printf("DC指标更新: %s, 复制条目数=%d", dcID, dcMetrics.EntriesReplicated)
+	for _, dcMetrics := range ar.metrics.DCMetrics {
+		dcMetrics.LastUpdateTime = now
 	}
 }
 
-func (ar *AsyncReplicator) updateBatchMetrics(batch *AsyncReplicationBatch, latency time.Duration) {
+// recordAttempt 记录一次发送尝试，成功时累计批次、条目、字节数和延迟；等待之前批次的尝试既不算成功也不算失败
+func (ar *AsyncReplicator) recordAttempt(batch *AsyncReplicationBatch, err error, latency time.Duration) {
+	if errors.Is(err, errReplicatorStopped) || errors.Is(err, errBatchPending) {
+		return
+	}
+
 	ar.metrics.mu.Lock()
 	defer ar.metrics.mu.Unlock()
 
-	ar.metrics.TotalBatchesSent++
-	ar.metrics.TotalEntriesReplicated += int64(len(batch.Entries))
-	ar.metrics.TotalBytesTransferred += int64(batch.OriginalSize)
+	m := ar.metrics
+	m.totalAttempts++
+	dcMetrics := m.DCMetrics[batch.TargetDC]
+	if dcMetrics != nil {
+		dcMetrics.attempts++
+		dcMetrics.LastUpdateTime = ar.clock.Now()
+	}
+
+	if err != nil {
+		m.failedAttempts++
+		if errors.Is(err, context.DeadlineExceeded) {
+			m.timeoutAttempts++
+		}
+		if dcMetrics != nil {
+			dcMetrics.ErrorCount++
+		}
+	} else {
+		m.TotalBatchesSent++
+		m.TotalEntriesReplicated += int64(len(batch.Entries))
+		m.TotalBytesTransferred += int64(batch.OriginalSize)
+
+		// 更新延迟指标
+		m.AverageLatency += (latency - m.AverageLatency) / time.Duration(m.TotalBatchesSent)
+		if latency > m.MaxLatency {
+			m.MaxLatency = latency
+		}
 
-	// 更新延迟指标
-	if latency > ar.metrics.MaxLatency {
-		ar.metrics.MaxLatency = latency
+		if dcMetrics != nil {
+			dcMetrics.BatchesSent++
+			dcMetrics.EntriesReplicated += int64(len(batch.Entries))
+			dcMetrics.BytesTransferred += int64(batch.OriginalSize)
+			dcMetrics.AverageLatency += (latency - dcMetrics.AverageLatency) / time.Duration(dcMetrics.BatchesSent)
+		}
 	}
 
-	// 更新DC指标
-	if dcMetrics, exists := ar.metrics.DCMetrics[batch.TargetDC]; exists {
-		dcMetrics.EntriesReplicated += int64(len(batch.Entries))
-		dcMetrics.AverageLatency = (dcMetrics.AverageLatency + latency) / 2
+	m.SuccessRate = float64(m.totalAttempts-m.failedAttempts) / float64(m.totalAttempts)
+	m.ErrorRate = 1 - m.SuccessRate
+	m.TimeoutRate = float64(m.timeoutAttempts) / float64(m.totalAttempts)
+	if dcMetrics != nil {
+		dcMetrics.SuccessRate = float64(dcMetrics.BatchesSent) / float64(dcMetrics.attempts)
 	}
 }
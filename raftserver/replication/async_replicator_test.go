@@ -0,0 +1,429 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-6-28 11:51:08
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-6-28 11:51:11
+* @Description: ConcordKV 异步复制管理器测试
+ */
+package replication_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/replication"
+)
+
+// mockTransport 记录发出的AppendEntries请求，由handle决定每个请求的结果
+type mockTransport struct {
+	mu       sync.Mutex
+	requests []*raft.AppendEntriesRequest
+	targets  []raft.NodeID
+	handle   func(req *raft.AppendEntriesRequest) error
+}
+
+func (t *mockTransport) SendAppendEntries(ctx context.Context, target raft.NodeID, req *raft.AppendEntriesRequest) (*raft.AppendEntriesResponse, error) {
+	t.mu.Lock()
+	t.requests = append(t.requests, req)
+	t.targets = append(t.targets, target)
+	handle := t.handle
+	t.mu.Unlock()
+
+	if handle != nil {
+		if err := handle(req); err != nil {
+			return nil, err
+		}
+	}
+	return &raft.AppendEntriesResponse{Term: req.Term, Success: true}, nil
+}
+
+func (t *mockTransport) setHandle(handle func(req *raft.AppendEntriesRequest) error) {
+	t.mu.Lock()
+	t.handle = handle
+	t.mu.Unlock()
+}
+
+func (t *mockTransport) sent() ([]*raft.AppendEntriesRequest, []raft.NodeID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*raft.AppendEntriesRequest(nil), t.requests...), append([]raft.NodeID(nil), t.targets...)
+}
+
+func (t *mockTransport) SendVoteRequest(ctx context.Context, target raft.NodeID, req *raft.VoteRequest) (*raft.VoteResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (t *mockTransport) SendInstallSnapshot(ctx context.Context, target raft.NodeID, req *raft.InstallSnapshotRequest) (*raft.InstallSnapshotResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (t *mockTransport) SendTimeoutNow(ctx context.Context, target raft.NodeID, req *raft.TimeoutNowRequest) (*raft.TimeoutNowResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (t *mockTransport) Start() error      { return nil }
+func (t *mockTransport) Stop() error       { return nil }
+func (t *mockTransport) LocalAddr() string { return "mock" }
+
+// newTestReplicator 创建本地DC为dc1、远端DC dc2有两个节点的异步复制管理器
+func newTestReplicator(t *testing.T, config *replication.AsyncReplicationConfig, transport *mockTransport) *replication.AsyncReplicator {
+	t.Helper()
+
+	raftConfig := &raft.Config{
+		NodeID: "n1",
+		Servers: []raft.Server{
+			{ID: "n1", DataCenter: "dc1"},
+			{ID: "n2", DataCenter: "dc2"},
+			{ID: "n3", DataCenter: "dc2"},
+		},
+		MultiDC: &raft.MultiDCConfig{
+			Enabled:         true,
+			LocalDataCenter: &raft.DataCenterConfig{ID: "dc1", IsPrimary: true},
+		},
+	}
+
+	ar := replication.NewAsyncReplicatorWithConfig("n1", config, raftConfig, transport, nil)
+	if err := ar.Start(); err != nil {
+		t.Fatalf("启动异步复制管理器失败: %v", err)
+	}
+	t.Cleanup(func() { ar.Stop() })
+	return ar
+}
+
+// testConfig 刷新间隔很长的配置，批次只在缓冲区攒满时发出
+func testConfig(batchSize int) *replication.AsyncReplicationConfig {
+	config := replication.DefaultAsyncReplicationConfig()
+	config.BatchSize = batchSize
+	config.BatchTimeoutMs = 60000
+	config.RetryAttempts = 2
+	config.RetryBackoffMs = 5
+	return config
+}
+
+func makeEntries(from, to int) []raft.LogEntry {
+	entries := make([]raft.LogEntry, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		entries = append(entries, raft.LogEntry{
+			Index: raft.LogIndex(i),
+			Term:  1,
+			Data:  []byte(fmt.Sprintf("entry-%d", i)),
+		})
+	}
+	return entries
+}
+
+// waitForTarget 等待dc2的复制状态满足条件
+func waitForTarget(t *testing.T, ar *replication.AsyncReplicator, cond func(target *replication.AsyncReplicationTarget) bool) *replication.AsyncReplicationTarget {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		target := ar.GetReplicationStatus()["dc2"]
+		if cond(target) {
+			return target
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待复制状态超时: %+v", target)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestAsyncReplicatorBatchesEntries 条目按BatchSize分批发送，剩余条目在刷新间隔到达时发出
+func TestAsyncReplicatorBatchesEntries(t *testing.T) {
+	transport := &mockTransport{}
+	config := testConfig(3)
+	config.BatchTimeoutMs = 20
+	ar := newTestReplicator(t, config, transport)
+
+	entries := makeEntries(1, 7)
+	if err := ar.ReplicateAsync(entries[:4]); err != nil {
+		t.Fatalf("复制失败: %v", err)
+	}
+	if err := ar.ReplicateAsync(entries[2:]); err != nil {
+		t.Fatalf("复制失败: %v", err)
+	}
+
+	target := waitForTarget(t, ar, func(target *replication.AsyncReplicationTarget) bool {
+		return target.LastReplicatedIndex == 7
+	})
+	if target.PendingBatches != 0 || len(target.PendingEntries) != 0 || target.TotalBytesQueued != 0 {
+		t.Fatalf("全部确认后不应有待发送的数据: %+v", target)
+	}
+	if target.ReplicationLag <= 0 || target.LastSuccessTime.IsZero() || !target.IsHealthy {
+		t.Fatalf("确认后应更新复制延迟和成功时间: %+v", target)
+	}
+
+	// 批次并发在途，按起始索引排序后检查
+	requests, nodes := transport.sent()
+	sort.Slice(requests, func(i, j int) bool { return requests[i].PrevLogIndex < requests[j].PrevLogIndex })
+	for _, node := range nodes {
+		if node != "n2" && node != "n3" {
+			t.Fatalf("批次应发送给dc2的节点，实际 %s", node)
+		}
+	}
+	next := raft.LogIndex(1)
+	var bytes int64
+	for _, req := range requests {
+		if len(req.Entries) == 0 || len(req.Entries) > 3 {
+			t.Fatalf("批次大小应在1到3之间: %d", len(req.Entries))
+		}
+		if req.PrevLogIndex != next-1 || req.Entries[0].Index != next {
+			t.Fatalf("批次应按索引顺序且不重复: prev=%d first=%d", req.PrevLogIndex, req.Entries[0].Index)
+		}
+		next += raft.LogIndex(len(req.Entries))
+		for _, entry := range req.Entries {
+			bytes += int64(len(entry.Data))
+		}
+	}
+	if next != 8 {
+		t.Fatalf("应恰好发送7个条目，实际发送到 %d", next-1)
+	}
+
+	metrics := ar.GetMetrics()
+	if metrics.TotalEntriesReplicated != 7 || metrics.TotalBatchesSent != int64(len(requests)) {
+		t.Fatalf("指标应反映实际发送: %+v", metrics)
+	}
+	if metrics.TotalBytesTransferred != bytes || metrics.SuccessRate != 1 {
+		t.Fatalf("字节数应为 %d、成功率应为1: %+v", bytes, metrics)
+	}
+	dc := metrics.DCMetrics["dc2"]
+	if dc == nil || dc.EntriesReplicated != 7 || dc.BytesTransferred != bytes || dc.AverageLatency <= 0 {
+		t.Fatalf("DC指标错误: %+v", dc)
+	}
+}
+
+// TestAsyncReplicatorRetryExhaustion 重试耗尽后条目放回缓冲区，目标DC恢复后重新发送
+func TestAsyncReplicatorRetryExhaustion(t *testing.T) {
+	transport := &mockTransport{}
+	transport.setHandle(func(req *raft.AppendEntriesRequest) error {
+		return errors.New("网络不可达")
+	})
+	ar := newTestReplicator(t, testConfig(2), transport)
+
+	if err := ar.ReplicateAsync(makeEntries(1, 2)); err != nil {
+		t.Fatalf("复制失败: %v", err)
+	}
+
+	target := waitForTarget(t, ar, func(target *replication.AsyncReplicationTarget) bool {
+		return target.ConnectionState == replication.ConnectionFailed
+	})
+	if target.IsHealthy || target.LastReplicatedIndex != 0 || target.PendingBatches != 0 {
+		t.Fatalf("重试耗尽后目标应不健康且未推进复制索引: %+v", target)
+	}
+	if len(target.PendingEntries) != 2 || target.PendingEntries[0].Index != 1 {
+		t.Fatalf("失败批次的条目应放回缓冲区: %+v", target.PendingEntries)
+	}
+	if requests, _ := transport.sent(); len(requests) != 3 {
+		t.Fatalf("应发送1次并重试2次，实际 %d 次", len(requests))
+	}
+
+	metrics := ar.GetMetrics()
+	if metrics.TotalBatchesSent != 0 || metrics.TotalBytesTransferred != 0 || metrics.SuccessRate != 0 {
+		t.Fatalf("失败的发送不应计入传输量: %+v", metrics)
+	}
+	if dc := metrics.DCMetrics["dc2"]; dc == nil || dc.ErrorCount != 3 {
+		t.Fatalf("DC错误数应为3: %+v", dc)
+	}
+
+	// 目标DC恢复后，新条目让缓冲区攒满，失败的条目按顺序一起重新发送
+	transport.setHandle(nil)
+	if err := ar.ReplicateAsync(makeEntries(3, 4)); err != nil {
+		t.Fatalf("复制失败: %v", err)
+	}
+	target = waitForTarget(t, ar, func(target *replication.AsyncReplicationTarget) bool {
+		return target.LastReplicatedIndex == 4
+	})
+	if !target.IsHealthy || target.ConnectionState != replication.ConnectionHealthy {
+		t.Fatalf("发送成功后目标应恢复健康: %+v", target)
+	}
+	if metrics := ar.GetMetrics(); metrics.TotalEntriesReplicated != 4 || metrics.SuccessRate <= 0 || metrics.SuccessRate >= 1 {
+		t.Fatalf("恢复后的指标错误: %+v", metrics)
+	}
+}
+
+// TestAsyncReplicatorOutOfOrderAck 后发的批次先被确认时，复制索引等之前的批次确认后才推进
+func TestAsyncReplicatorOutOfOrderAck(t *testing.T) {
+	release := make(chan struct{})
+	acked := make(chan raft.LogIndex, 4)
+	transport := &mockTransport{}
+	transport.setHandle(func(req *raft.AppendEntriesRequest) error {
+		if req.Entries[0].Index == 1 {
+			<-release
+		}
+		acked <- req.Entries[len(req.Entries)-1].Index
+		return nil
+	})
+	ar := newTestReplicator(t, testConfig(2), transport)
+	var once sync.Once
+	releaseFirst := func() { once.Do(func() { close(release) }) }
+	t.Cleanup(releaseFirst)
+
+	if err := ar.ReplicateAsync(makeEntries(1, 4)); err != nil {
+		t.Fatalf("复制失败: %v", err)
+	}
+
+	select {
+	case index := <-acked:
+		if index != 4 {
+			t.Fatalf("第二个批次应先被确认，实际 %d", index)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("等待第二个批次确认超时")
+	}
+
+	// 第二个批次已确认，但第一个批次仍在途，复制索引不能越过它
+	time.Sleep(20 * time.Millisecond)
+	target := ar.GetReplicationStatus()["dc2"]
+	if target.LastReplicatedIndex != 0 || target.PendingBatches != 1 {
+		t.Fatalf("之前的批次未确认时不应推进复制索引: %+v", target)
+	}
+
+	releaseFirst()
+	target = waitForTarget(t, ar, func(target *replication.AsyncReplicationTarget) bool {
+		return target.LastReplicatedIndex == 4
+	})
+	if target.PendingBatches != 0 {
+		t.Fatalf("全部确认后不应有在途批次: %+v", target)
+	}
+}
+
+// TestAsyncReplicatorBufferLimit 缓冲区超过MaxBatchMemoryMB时拒绝新条目
+func TestAsyncReplicatorBufferLimit(t *testing.T) {
+	transport := &mockTransport{}
+	config := testConfig(1000)
+	config.MaxBatchMemoryMB = 1
+	ar := newTestReplicator(t, config, transport)
+
+	big := []raft.LogEntry{{Index: 1, Term: 1, Data: make([]byte, 600<<10)}}
+	if err := ar.ReplicateAsync(big); err != nil {
+		t.Fatalf("缓冲区未满时复制失败: %v", err)
+	}
+	big[0].Index = 2
+	if err := ar.ReplicateAsync(big); err == nil {
+		t.Fatal("缓冲区超过上限时应返回错误")
+	}
+	if target := ar.GetReplicationStatus()["dc2"]; len(target.PendingEntries) != 1 {
+		t.Fatalf("被拒绝的条目不应进入缓冲区: %+v", target.PendingEntries)
+	}
+}
+
+// TestAsyncReplicatorPauseResume 暂停期间条目只缓冲不发送，超过MaxPausedEntries时拒绝；恢复后按索引顺序发出
+func TestAsyncReplicatorPauseResume(t *testing.T) {
+	transport := &mockTransport{}
+	config := testConfig(2)
+	config.MaxPausedEntries = 8
+	ar := newTestReplicator(t, config, transport)
+
+	if err := ar.PauseReplication("dc2"); err != nil {
+		t.Fatalf("暂停复制失败: %v", err)
+	}
+	if err := ar.ReplicateAsync(makeEntries(1, 6)); err != nil {
+		t.Fatalf("暂停期间缓冲区未满时复制失败: %v", err)
+	}
+	if err := ar.ReplicateAsync(makeEntries(7, 10)); !errors.Is(err, replication.ErrReplicationBackpressure) {
+		t.Fatalf("暂停期间超过MaxPausedEntries应返回ErrReplicationBackpressure: %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if requests, _ := transport.sent(); len(requests) != 0 {
+		t.Fatalf("暂停期间不应发送批次，实际 %d 个", len(requests))
+	}
+	target := ar.GetReplicationStatus()["dc2"]
+	if !target.Paused || target.PausedAt.IsZero() || len(target.PendingEntries) != 6 {
+		t.Fatalf("暂停状态或缓冲区不正确: %+v", target)
+	}
+
+	if err := ar.ResumeReplication("dc2"); err != nil {
+		t.Fatalf("恢复复制失败: %v", err)
+	}
+	target = waitForTarget(t, ar, func(target *replication.AsyncReplicationTarget) bool {
+		return target.LastReplicatedIndex == 6
+	})
+	if target.Paused || len(target.PendingEntries) != 0 {
+		t.Fatalf("恢复后应发出全部缓冲的条目: %+v", target)
+	}
+
+	requests, _ := transport.sent()
+	sort.Slice(requests, func(i, j int) bool { return requests[i].PrevLogIndex < requests[j].PrevLogIndex })
+	next := raft.LogIndex(1)
+	for _, req := range requests {
+		if req.Entries[0].Index != next {
+			t.Fatalf("恢复后应按索引顺序发送且不重复: 期望 %d，实际 %d", next, req.Entries[0].Index)
+		}
+		next += raft.LogIndex(len(req.Entries))
+	}
+
+	// 恢复后不再受MaxPausedEntries限制
+	if err := ar.ReplicateAsync(makeEntries(7, 16)); err != nil {
+		t.Fatalf("恢复后复制失败: %v", err)
+	}
+	waitForTarget(t, ar, func(target *replication.AsyncReplicationTarget) bool {
+		return target.LastReplicatedIndex == 16
+	})
+
+	if err := ar.PauseReplication("dc9"); !errors.Is(err, replication.ErrReplicationTargetNotFound) {
+		t.Errorf("暂停不存在的DC应返回ErrReplicationTargetNotFound: %v", err)
+	}
+	if err := ar.ResumeReplication("dc9"); !errors.Is(err, replication.ErrReplicationTargetNotFound) {
+		t.Errorf("恢复不存在的DC应返回ErrReplicationTargetNotFound: %v", err)
+	}
+}
+
+// TestAsyncReplicatorLagAlerts 未确认的条目数越过DC的告警阈值时依次产生警告、严重告警，积压清空后告警解除
+func TestAsyncReplicatorLagAlerts(t *testing.T) {
+	transport := &mockTransport{}
+	config := testConfig(2)
+	config.HealthCheckIntervalMs = 5
+	config.LagThresholds = replication.LagThresholds{WarnEntries: 1000}
+	config.DataCenterLagThresholds = map[raft.DataCenterID]replication.LagThresholds{
+		"dc2": {WarnEntries: 3, CriticalEntries: 5},
+	}
+	ar := newTestReplicator(t, config, transport)
+
+	alerts := make(chan replication.LagAlert, 16)
+	ar.SetLagAlertHandler(func(alert replication.LagAlert) { alerts <- alert })
+	expect := func(level, previous replication.LagLevel) replication.LagAlert {
+		t.Helper()
+		select {
+		case alert := <-alerts:
+			if alert.DataCenter != "dc2" || alert.Level != level || alert.Previous != previous {
+				t.Fatalf("期望告警 %s -> %s，实际 %+v", previous, level, alert)
+			}
+			return alert
+		case <-time.After(2 * time.Second):
+			t.Fatalf("等待 %s 告警超时", level)
+		}
+		return replication.LagAlert{}
+	}
+
+	ar.PauseReplication("dc2")
+	ar.ReplicateAsync(makeEntries(1, 3))
+	if alert := expect(replication.LagWarning, replication.LagNormal); alert.LagEntries != 3 || !alert.Paused {
+		t.Fatalf("告警内容不正确: %+v", alert)
+	}
+	ar.ReplicateAsync(makeEntries(4, 6))
+	expect(replication.LagCritical, replication.LagWarning)
+
+	target := ar.GetReplicationStatus()["dc2"]
+	if target.LagLevel != replication.LagCritical || target.LagEntries != 6 || target.PendingLag <= 0 {
+		t.Fatalf("复制状态中的延迟不正确: %+v", target)
+	}
+	if target.ConnectionState != replication.ConnectionHealthy {
+		t.Fatalf("暂停期间的积压不应标记为降级: %+v", target)
+	}
+
+	ar.ResumeReplication("dc2")
+	expect(replication.LagNormal, replication.LagCritical)
+
+	dc := ar.GetMetrics().DCMetrics["dc2"]
+	if dc.LagWarnings != 1 || dc.LagCriticals != 1 {
+		t.Errorf("告警次数不正确: %+v", dc)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-11 10:32:17
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-11 10:32:17
+* @Description: ConcordKV replication - collector.go
+ */
+package replication
+
+import (
+	"sort"
+
+	"raftserver/metrics"
+	"raftserver/raft"
+)
+
+// Collect 实现metrics.Collector，按目标DC输出异步复制的延迟、进度、告警与暂停状态以及累计发送量
+func (ar *AsyncReplicator) Collect() []*metrics.Family {
+	status := ar.GetReplicationStatus()
+	dcMetrics := ar.GetMetrics().DCMetrics
+
+	dcs := make([]raft.DataCenterID, 0, len(status))
+	for dcID := range status {
+		dcs = append(dcs, dcID)
+	}
+	sort.Slice(dcs, func(i, j int) bool { return dcs[i] < dcs[j] })
+
+	lag := metrics.NewGauge("replication_lag_seconds", "Time from queueing to acknowledgment of the latest entry replicated to the data center.")
+	lastIndex := metrics.NewGauge("replication_last_replicated_index", "Highest log index acknowledged by the data center.")
+	pending := metrics.NewGauge("replication_pending_batches", "Batches sent to the data center and not yet acknowledged.")
+	healthy := metrics.NewGauge("replication_target_healthy", "Whether the data center is considered healthy (1) or not (0).")
+	lagEntries := metrics.NewGauge("replication_lag_entries", "Log entries queued for the data center and not yet acknowledged.")
+	pendingLag := metrics.NewGauge("replication_pending_lag_seconds", "Time the oldest unacknowledged entry has been waiting for the data center.")
+	lagLevel := metrics.NewGauge("replication_lag_level", "Replication lag alert level of the data center: 0 normal, 1 warning, 2 critical.")
+	paused := metrics.NewGauge("replication_paused", "Whether replication to the data center is paused by an operator (1) or not (0).")
+	alerts := metrics.NewCounter("replication_lag_alerts_total", "Times the replication lag of the data center crossed into the alert level.")
+	batches := metrics.NewCounter("replication_batches_sent_total", "Batches acknowledged by the data center.")
+	entries := metrics.NewCounter("replication_entries_replicated_total", "Log entries acknowledged by the data center.")
+	bytes := metrics.NewCounter("replication_bytes_transferred_total", "Uncompressed bytes acknowledged by the data center.")
+	failures := metrics.NewCounter("replication_errors_total", "Failed batch send attempts to the data center.")
+
+	for _, dcID := range dcs {
+		target := status[dcID]
+		dc := string(dcID)
+		lag.With(target.ReplicationLag.Seconds(), metrics.LabelDC, dc)
+		lastIndex.With(float64(target.LastReplicatedIndex), metrics.LabelDC, dc)
+		pending.With(float64(target.PendingBatches), metrics.LabelDC, dc)
+		healthy.With(boolValue(target.IsHealthy), metrics.LabelDC, dc)
+		lagEntries.With(float64(target.LagEntries), metrics.LabelDC, dc)
+		pendingLag.With(target.PendingLag.Seconds(), metrics.LabelDC, dc)
+		lagLevel.With(float64(target.LagLevel), metrics.LabelDC, dc)
+		paused.With(boolValue(target.Paused), metrics.LabelDC, dc)
+
+		if m, ok := dcMetrics[dcID]; ok {
+			batches.With(float64(m.BatchesSent), metrics.LabelDC, dc)
+			entries.With(float64(m.EntriesReplicated), metrics.LabelDC, dc)
+			bytes.With(float64(m.BytesTransferred), metrics.LabelDC, dc)
+			failures.With(float64(m.ErrorCount), metrics.LabelDC, dc)
+			alerts.With(float64(m.LagWarnings), metrics.LabelDC, dc, "level", LagWarning.String())
+			alerts.With(float64(m.LagCriticals), metrics.LabelDC, dc, "level", LagCritical.String())
+		}
+	}
+
+	return []*metrics.Family{lag, lastIndex, pending, healthy, lagEntries, pendingLag, lagLevel, paused, batches, entries, bytes, failures, alerts}
+}
+
+// Collect 实现metrics.Collector，输出读写分离路由器按请求类型和路由规则的累计请求数
+func (rwr *ReadWriteRouter) Collect() []*metrics.Family {
+	m := rwr.GetMetrics()
+
+	requests := metrics.NewCounter("rw_router_requests_total", "Requests routed by the read/write router.").
+		With(float64(m.ReadRequests), "type", "read").
+		With(float64(m.WriteRequests), "type", "write")
+	staleReads := metrics.NewCounter("rw_router_stale_reads_total", "Reads routed to a replica whose data may be stale.").
+		With(float64(m.StaleReadCount))
+
+	routes := make([]string, 0, len(m.RouteStats))
+	for routeID := range m.RouteStats {
+		routes = append(routes, routeID)
+	}
+	sort.Strings(routes)
+
+	routeRequests := metrics.NewCounter("rw_router_route_requests_total", "Requests matched by the routing rule.")
+	routeErrors := metrics.NewCounter("rw_router_route_errors_total", "Requests matched by the routing rule that failed to route.")
+	for _, routeID := range routes {
+		stats := m.RouteStats[routeID]
+		routeRequests.With(float64(stats.RequestCount), "route", routeID)
+		routeErrors.With(float64(stats.ErrorCount), "route", routeID)
+	}
+
+	return []*metrics.Family{requests, staleReads, routeRequests, routeErrors}
+}
+
+// Collect 实现metrics.Collector，输出故障转移的累计次数与当前状态
+func (fc *FailoverCoordinator) Collect() []*metrics.Family {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	return []*metrics.Family{
+		metrics.NewCounter("failover_operations_total", "Failover operations started.").With(float64(fc.totalFailovers)),
+		metrics.NewCounter("failover_succeeded_total", "Failover operations that completed.").With(float64(fc.successfulFailovers)),
+		metrics.NewCounter("failover_failed_total", "Failover operations that failed.").With(float64(fc.failedFailovers)),
+		metrics.NewCounter("failover_rollbacks_total", "Failover operations rolled back.").With(float64(fc.rollbacks)),
+		metrics.NewCounter("failover_downtime_seconds_total", "Service downtime accumulated across completed failovers.").With(fc.totalDowntime.Seconds()),
+		metrics.NewCounter("failover_client_impact_total", "Requests that failed to route during completed failovers.").With(float64(fc.totalClientImpact)),
+		metrics.NewGauge("failover_in_progress", "Whether a failover is being executed (1) or not (0).").With(boolValue(fc.currentOperation != nil)),
+		metrics.NewGauge("failover_pending_decisions", "Failover decisions waiting for manual approval.").With(float64(len(fc.pendingDecisions))),
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
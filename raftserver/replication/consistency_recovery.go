@@ -10,12 +10,20 @@ package replication
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"raftserver/clock"
+	"raftserver/logging"
+	"raftserver/queue"
 	"raftserver/raft"
+	"raftserver/statemachine"
 )
 
 // ConsistencyRecoveryConfig 一致性恢复配置
@@ -37,11 +45,35 @@ type ConsistencyRecoveryConfig struct {
 	ConsistencyThreshold   float64       `json:"consistencyThreshold"`
 	MaxInconsistencyWindow time.Duration `json:"maxInconsistencyWindow"`
 
+	// 状态摘要比较：按StateDigestBuckets个桶比较各DC已应用的状态，每次验证最多记录MaxDivergentKeys个分歧的键
+	StateDigestBuckets int `json:"stateDigestBuckets"`
+	MaxDivergentKeys   int `json:"maxDivergentKeys"`
+
 	// 性能优化配置
 	EnableParallelRecovery bool `json:"enableParallelRecovery"`
 	EnableIncrementalSync  bool `json:"enableIncrementalSync"`
 	EnableCompressionSync  bool `json:"enableCompressionSync"`
 	SyncBandwidthLimitMBps int  `json:"syncBandwidthLimitMBps"`
+
+	// RepairQueue 修复队列，默认容量1000，队列满时最多等待1秒（block-with-timeout）
+	RepairQueue queue.Config `json:"repairQueue"`
+
+	// Logger 恢复器使用的日志，为nil时使用logging.Default()
+	Logger logging.Logger `json:"-"`
+
+	// Clock 恢复器计时使用的时钟，为nil时使用真实时钟，测试中注入testutil.FakeClock
+	Clock clock.Clock `json:"-"`
+}
+
+// defaultRepairQueueConfig 修复队列的默认配置
+var defaultRepairQueueConfig = queue.Config{Capacity: 1000, Policy: queue.BlockWithTimeout, BlockTimeout: time.Second}
+
+// repairQueueOptions coalesce策略下同一DC同一日志索引的不一致合并，保留较新的记录
+var repairQueueOptions = queue.Options{
+	Key: func(item interface{}) string {
+		inconsistency := item.(*DataInconsistency)
+		return fmt.Sprintf("%s/%d", inconsistency.TargetDC, inconsistency.LogIndex)
+	},
 }
 
 // DefaultConsistencyRecoveryConfig 默认配置
@@ -58,10 +90,13 @@ func DefaultConsistencyRecoveryConfig() *ConsistencyRecoveryConfig {
 		VerificationInterval:        time.Minute * 2,
 		ConsistencyThreshold:        0.99,
 		MaxInconsistencyWindow:      time.Minute * 30,
+		StateDigestBuckets:          statemachine.DefaultDigestBuckets,
+		MaxDivergentKeys:            100,
 		EnableParallelRecovery:      true,
 		EnableIncrementalSync:       true,
 		EnableCompressionSync:       true,
 		SyncBandwidthLimitMBps:      100,
+		RepairQueue:                 defaultRepairQueueConfig,
 	}
 }
 
@@ -97,7 +132,8 @@ type DataInconsistency struct {
 	ActualEntry   *raft.LogEntry
 
 	// 差异详情
-	Severity        int // 1-5, 5最严重
+	Keys            []string // 已应用状态分歧的键，由状态摘要比较发现
+	Severity        int      // 1-5, 5最严重
 	ImpactLevel     string
 	Description     string
 	ConflictDetails map[string]interface{}
@@ -189,6 +225,123 @@ type DCConsistencyStatus struct {
 	RecoveryInProgress bool
 }
 
+// LogDigestFetcher 获取目标DC中某个节点在[start, end]范围内的日志摘要
+// 返回的摘要按索引递增，对端日志在范围内结束时只返回已有的部分
+type LogDigestFetcher interface {
+	FetchLogDigests(ctx context.Context, dcID raft.DataCenterID, start, end raft.LogIndex) ([]raft.LogDigest, error)
+}
+
+// HTTPLogDigestFetcher 通过raftserver的 GET /api/log/digest 与 GET /api/digest 获取日志摘要和状态摘要，依次尝试目标DC的各个API地址
+type HTTPLogDigestFetcher struct {
+	Endpoints map[raft.DataCenterID][]string // DC -> API地址（host:port或完整URL）
+	Token     string                         // 服务端启用鉴权时携带的Bearer令牌
+	Client    *http.Client                   // 为nil时使用http.DefaultClient
+}
+
+// FetchLogDigests 实现LogDigestFetcher
+func (f *HTTPLogDigestFetcher) FetchLogDigests(ctx context.Context, dcID raft.DataCenterID, start, end raft.LogIndex) ([]raft.LogDigest, error) {
+	var result struct {
+		Digests []raft.LogDigest `json:"digests"`
+	}
+	path := fmt.Sprintf("/api/log/digest?start=%d&end=%d", start, end)
+	if err := f.get(ctx, dcID, path, &result); err != nil {
+		return nil, fmt.Errorf("获取DC %s 的日志摘要失败: %w", dcID, err)
+	}
+	return result.Digests, nil
+}
+
+// FetchStateDigest 实现StateDigestFetcher
+func (f *HTTPLogDigestFetcher) FetchStateDigest(ctx context.Context, dcID raft.DataCenterID, buckets int) (*statemachine.StateDigest, error) {
+	return f.fetchStateDigest(ctx, dcID, fmt.Sprintf("/api/digest?buckets=%d", buckets))
+}
+
+// FetchBucketKeys 实现StateDigestFetcher
+func (f *HTTPLogDigestFetcher) FetchBucketKeys(ctx context.Context, dcID raft.DataCenterID, buckets int, selected []int) (*statemachine.StateDigest, error) {
+	parts := make([]string, len(selected))
+	for i, bucket := range selected {
+		parts[i] = strconv.Itoa(bucket)
+	}
+	return f.fetchStateDigest(ctx, dcID, fmt.Sprintf("/api/digest?buckets=%d&bucket=%s", buckets, strings.Join(parts, ",")))
+}
+
+func (f *HTTPLogDigestFetcher) fetchStateDigest(ctx context.Context, dcID raft.DataCenterID, path string) (*statemachine.StateDigest, error) {
+	var result struct {
+		Digest *statemachine.StateDigest `json:"digest"`
+	}
+	if err := f.get(ctx, dcID, path, &result); err != nil {
+		return nil, fmt.Errorf("获取DC %s 的状态摘要失败: %w", dcID, err)
+	}
+	if result.Digest == nil {
+		return nil, fmt.Errorf("DC %s 的响应中没有状态摘要", dcID)
+	}
+	return result.Digest, nil
+}
+
+// get 依次请求目标DC的各个API地址，解析第一个成功的响应
+func (f *HTTPLogDigestFetcher) get(ctx context.Context, dcID raft.DataCenterID, path string, result interface{}) error {
+	endpoints := f.Endpoints[dcID]
+	if len(endpoints) == 0 {
+		return fmt.Errorf("DC %s 没有配置API地址", dcID)
+	}
+
+	var lastErr error
+	for _, endpoint := range endpoints {
+		if lastErr = f.fetch(ctx, endpoint, path, result); lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+// fetch 从单个API地址获取JSON响应
+func (f *HTTPLogDigestFetcher) fetch(ctx context.Context, endpoint, path string, result interface{}) error {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	url := strings.TrimRight(endpoint, "/") + path
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if f.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.Token)
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s 返回状态 %d", endpoint, resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("解析 %s 的响应失败: %w", endpoint, err)
+	}
+	return nil
+}
+
+// StateDigestFetcher 获取目标DC中某个节点的状态摘要
+type StateDigestFetcher interface {
+	// FetchStateDigest 获取按buckets个桶计算的各桶摘要
+	FetchStateDigest(ctx context.Context, dcID raft.DataCenterID, buckets int) (*statemachine.StateDigest, error)
+	// FetchBucketKeys 获取选中的桶中各键的摘要
+	FetchBucketKeys(ctx context.Context, dcID raft.DataCenterID, buckets int, selected []int) (*statemachine.StateDigest, error)
+}
+
+// StateDigestSource 本地状态机的摘要，由statemachine.KVStateMachine实现
+type StateDigestSource interface {
+	StateDigest(buckets int) *statemachine.StateDigest
+	BucketKeys(buckets int, selected []int) *statemachine.StateDigest
+}
+
 // ConsistencyRecovery 数据一致性恢复器
 type ConsistencyRecovery struct {
 	mu sync.RWMutex
@@ -196,22 +349,29 @@ type ConsistencyRecovery struct {
 	// 基础配置
 	nodeID raft.NodeID
 	config *ConsistencyRecoveryConfig
-	logger *log.Logger
+	logger logging.Logger
+	clock  clock.Clock
 
 	// 集成组件
 	storage         raft.Storage
 	asyncReplicator *AsyncReplicator
 	readWriteRouter *ReadWriteRouter
 	failureDetector *DCFailureDetector
+	digestFetcher   LogDigestFetcher // 未设置时不做条目级比较
+	stateSource     StateDigestSource
+	stateFetcher    StateDigestFetcher // 与stateSource同时设置时验证各DC已应用的状态
 
 	// 一致性状态跟踪
 	lastConsistencyCheck time.Time
 	currentSnapshot      *ConsistencySnapshot
 	inconsistencies      map[string]*DataInconsistency
 	recoveryOperations   map[string]*RecoveryOperation
+	divergentKeys        map[raft.DataCenterID]int               // 最近一次状态摘要比较中各DC分歧的键数
+	stateInconsistencies map[raft.DataCenterID]map[string]string // 各DC分歧的键已记录的不一致ID
 
-	// 修复队列
-	repairQueue      chan *DataInconsistency
+	// 修复队列，持有cr.mu时发现的不一致先记入pendingRepairs，释放锁后再入队
+	repairQueue      *queue.Queue
+	pendingRepairs   []*DataInconsistency
 	activeRepairs    map[string]*RecoveryOperation
 	completedRepairs []*RecoveryOperation
 
@@ -247,21 +407,24 @@ func NewConsistencyRecovery(
 	recovery := &ConsistencyRecovery{
 		nodeID:          nodeID,
 		config:          config,
-		logger:          log.New(log.Writer(), "[consistency-recovery] ", log.LstdFlags),
+		logger:          logging.Component(config.Logger, "consistency-recovery", logging.FieldNodeID, string(nodeID)),
+		clock:           clock.Or(config.Clock),
 		storage:         storage,
 		asyncReplicator: asyncReplicator,
 		readWriteRouter: readWriteRouter,
 		failureDetector: failureDetector,
 
-		inconsistencies:    make(map[string]*DataInconsistency),
-		recoveryOperations: make(map[string]*RecoveryOperation),
-		activeRepairs:      make(map[string]*RecoveryOperation),
-		completedRepairs:   make([]*RecoveryOperation, 0),
+		inconsistencies:      make(map[string]*DataInconsistency),
+		recoveryOperations:   make(map[string]*RecoveryOperation),
+		divergentKeys:        make(map[raft.DataCenterID]int),
+		stateInconsistencies: make(map[raft.DataCenterID]map[string]string),
+		activeRepairs:        make(map[string]*RecoveryOperation),
+		completedRepairs:     make([]*RecoveryOperation, 0),
 
 		ctx:         ctx,
 		cancel:      cancel,
 		stopCh:      make(chan struct{}),
-		repairQueue: make(chan *DataInconsistency, 1000),
+		repairQueue: queue.New("consistency-repairs", config.RepairQueue.WithDefaults(defaultRepairQueueConfig), repairQueueOptions),
 	}
 
 	recovery.initializeComponents()
@@ -272,7 +435,7 @@ func NewConsistencyRecovery(
 func (cr *ConsistencyRecovery) initializeComponents() {
 	// 初始化一致性快照
 	cr.currentSnapshot = &ConsistencySnapshot{
-		Timestamp:            time.Now(),
+		Timestamp:            cr.clock.Now(),
 		DCConsistencyStatus:  make(map[raft.DataCenterID]*DCConsistencyStatus),
 		InconsistencyDetails: make([]*DataInconsistency, 0),
 	}
@@ -284,12 +447,27 @@ func (cr *ConsistencyRecovery) initializeComponents() {
 			cr.currentSnapshot.DCConsistencyStatus[dcID] = &DCConsistencyStatus{
 				DataCenter:   dcID,
 				IsConsistent: true,
-				LastSyncTime: time.Now(),
+				LastSyncTime: cr.clock.Now(),
 			}
 		}
 	}
 
-	cr.logger.Printf("初始化一致性恢复器，监控 %d 个DC", len(cr.currentSnapshot.DCConsistencyStatus))
+	cr.logger.Info("初始化一致性恢复器", "dcs", len(cr.currentSnapshot.DCConsistencyStatus))
+}
+
+// SetLogDigestFetcher 设置获取远端日志摘要的方式，未设置时只按复制进度判断DC是否一致
+func (cr *ConsistencyRecovery) SetLogDigestFetcher(fetcher LogDigestFetcher) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.digestFetcher = fetcher
+}
+
+// SetStateDigestFetcher 设置本地状态摘要与获取远端状态摘要的方式，未设置时验证阶段不比较已应用的状态
+func (cr *ConsistencyRecovery) SetStateDigestFetcher(local StateDigestSource, fetcher StateDigestFetcher) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.stateSource = local
+	cr.stateFetcher = fetcher
 }
 
 // Start 启动一致性恢复器
@@ -301,7 +479,7 @@ func (cr *ConsistencyRecovery) Start() error {
 		return fmt.Errorf("一致性恢复器已在运行")
 	}
 
-	cr.logger.Printf("启动数据一致性恢复器")
+	cr.logger.Info("启动数据一致性恢复器")
 
 	// 启动各个工作循环
 	cr.wg.Add(4)
@@ -323,11 +501,11 @@ func (cr *ConsistencyRecovery) Stop() error {
 		return nil
 	}
 
-	cr.logger.Printf("停止数据一致性恢复器")
+	cr.logger.Info("停止数据一致性恢复器")
 
 	cr.cancel()
 	close(cr.stopCh)
-	close(cr.repairQueue)
+	cr.repairQueue.Close()
 	cr.wg.Wait()
 
 	cr.running = false
@@ -337,29 +515,30 @@ func (cr *ConsistencyRecovery) Stop() error {
 // consistencyCheckLoop 一致性检查循环
 func (cr *ConsistencyRecovery) consistencyCheckLoop() {
 	defer cr.wg.Done()
-	cr.logger.Printf("一致性检查循环已启动")
+	cr.logger.Debug("一致性检查循环已启动")
 
-	ticker := time.NewTicker(cr.config.DifferenceDetectionInterval)
+	ticker := cr.clock.NewTicker(cr.config.DifferenceDetectionInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			cr.performConsistencyCheck()
 		case <-cr.stopCh:
-			cr.logger.Printf("一致性检查循环已停止")
+			cr.logger.Debug("一致性检查循环已停止")
 			return
 		}
 	}
 }
 
-// performConsistencyCheck 执行一致性检查
+// performConsistencyCheck 执行一致性检查，发现的不一致在释放锁后加入修复队列
 func (cr *ConsistencyRecovery) performConsistencyCheck() {
 	cr.mu.Lock()
+	defer cr.flushRepairs() // 在释放锁之后执行
 	defer cr.mu.Unlock()
 
-	cr.logger.Printf("开始执行一致性检查")
-	startTime := time.Now()
+	cr.logger.Debug("开始执行一致性检查")
+	startTime := cr.clock.Now()
 
 	// 获取本地最新日志索引
 	localLastIndex := cr.storage.GetLastLogIndex()
@@ -370,23 +549,39 @@ func (cr *ConsistencyRecovery) performConsistencyCheck() {
 	cr.currentSnapshot.LocalDC = cr.getLocalDC()
 
 	// 检查各个DC的一致性
-	inconsistentDCs := make([]raft.DataCenterID, 0)
-	totalInconsistencies := 0
-
 	if cr.asyncReplicator != nil {
 		targets := cr.asyncReplicator.GetReplicationStatus()
 		for dcID, target := range targets {
 			dcStatus := cr.checkDCConsistency(dcID, target, localLastIndex, localLastTerm)
+			// 状态摘要比较发现的分歧在下次验证确认消除之前保留
+			if n := cr.divergentKeys[dcID]; n > 0 {
+				dcStatus.IsConsistent = false
+				dcStatus.InconsistencyCount += n
+			}
 			cr.currentSnapshot.DCConsistencyStatus[dcID] = dcStatus
+		}
+	}
 
-			if !dcStatus.IsConsistent {
-				inconsistentDCs = append(inconsistentDCs, dcID)
-				totalInconsistencies += dcStatus.InconsistencyCount
-			}
+	cr.updateGlobalConsistency()
+
+	cr.lastConsistencyCheck = startTime
+	duration := cr.clock.Now().Sub(startTime)
+
+	cr.logger.Info("一致性检查完成", "dcs", len(cr.currentSnapshot.DCConsistencyStatus), "inconsistent_dcs", len(cr.currentSnapshot.InconsistentDCs), "score", cr.currentSnapshot.ConsistencyScore, "duration", duration)
+}
+
+// updateGlobalConsistency 按各DC的状态更新全局一致性、不一致的DC与一致性分数（调用方需持有cr.mu）
+func (cr *ConsistencyRecovery) updateGlobalConsistency() {
+	inconsistentDCs := make([]raft.DataCenterID, 0)
+	totalInconsistencies := 0
+	for dcID, status := range cr.currentSnapshot.DCConsistencyStatus {
+		if !status.IsConsistent {
+			inconsistentDCs = append(inconsistentDCs, dcID)
+			totalInconsistencies += status.InconsistencyCount
 		}
 	}
+	sort.Slice(inconsistentDCs, func(i, j int) bool { return inconsistentDCs[i] < inconsistentDCs[j] })
 
-	// 更新全局一致性状态
 	cr.currentSnapshot.GlobalConsistency = len(inconsistentDCs) == 0
 	cr.currentSnapshot.InconsistentDCs = inconsistentDCs
 	cr.currentSnapshot.TotalInconsistencies = totalInconsistencies
@@ -396,15 +591,6 @@ func (cr *ConsistencyRecovery) performConsistencyCheck() {
 		consistentDCs := len(cr.currentSnapshot.DCConsistencyStatus) - len(inconsistentDCs)
 		cr.currentSnapshot.ConsistencyScore = float64(consistentDCs) / float64(len(cr.currentSnapshot.DCConsistencyStatus))
 	}
-
-	cr.lastConsistencyCheck = startTime
-	duration := time.Since(startTime)
-
-	cr.logger.Printf("一致性检查完成: 总DC=%d, 不一致DC=%d, 一致性分数=%.2f, 耗时=%v",
-		len(cr.currentSnapshot.DCConsistencyStatus),
-		len(inconsistentDCs),
-		cr.currentSnapshot.ConsistencyScore,
-		duration)
 }
 
 // checkDCConsistency 检查DC一致性
@@ -416,7 +602,7 @@ func (cr *ConsistencyRecovery) checkDCConsistency(
 ) *DCConsistencyStatus {
 	status := &DCConsistencyStatus{
 		DataCenter:   dcID,
-		LastSyncTime: time.Now(),
+		LastSyncTime: cr.clock.Now(),
 		LogIndex:     target.LastReplicatedIndex,
 		LogTerm:      target.LastReplicatedTerm,
 	}
@@ -446,18 +632,44 @@ func (cr *ConsistencyRecovery) checkDCConsistency(
 }
 
 // detectSpecificInconsistencies 检测具体的不一致问题
+// 从目标DC获取扫描窗口内的日志摘要并与本地条目比较：对端没有的条目记为缺失，
+// 任期不同记为冲突，任期相同但校验和不同记为损坏
 func (cr *ConsistencyRecovery) detectSpecificInconsistencies(
 	dcID raft.DataCenterID,
 	target *AsyncReplicationTarget,
 	localLastIndex raft.LogIndex,
 	localLastTerm raft.Term,
 ) {
+	if cr.digestFetcher == nil {
+		cr.logger.Debug("未设置日志摘要获取方式，跳过条目级比较", "target_dc", dcID)
+		return
+	}
+
 	// 扫描日志窗口，查找具体的不一致
 	scanStart := target.LastReplicatedIndex
 	scanEnd := localLastIndex
 	if scanEnd-scanStart > raft.LogIndex(cr.config.LogIndexScanWindow) {
 		scanStart = scanEnd - raft.LogIndex(cr.config.LogIndexScanWindow)
 	}
+	if scanStart == 0 {
+		scanStart = 1
+	}
+	if scanStart > scanEnd {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(cr.ctx, cr.config.RepairTimeout)
+	defer cancel()
+
+	digests, err := cr.digestFetcher.FetchLogDigests(ctx, dcID, scanStart, scanEnd)
+	if err != nil {
+		cr.logger.Warn("获取日志摘要失败", "target_dc", dcID, logging.FieldError, err)
+		return
+	}
+	remote := make(map[raft.LogIndex]raft.LogDigest, len(digests))
+	for _, digest := range digests {
+		remote[digest.Index] = digest
+	}
 
 	for index := scanStart; index <= scanEnd; index++ {
 		localEntry, err := cr.storage.GetLogEntry(index)
@@ -465,53 +677,89 @@ func (cr *ConsistencyRecovery) detectSpecificInconsistencies(
 			continue
 		}
 
-		// 这里应该从目标DC获取对应的日志条目进行比较
-		// 为了简化，我们创建一个模拟的不一致记录
-		inconsistency := &DataInconsistency{
-			ID:            fmt.Sprintf("inconsistency-%s-%d-%d", dcID, index, time.Now().Unix()),
-			Type:          MissingEntries,
-			DetectedAt:    time.Now(),
-			SourceDC:      cr.getLocalDC(),
-			TargetDC:      dcID,
-			LogIndex:      index,
-			LogTerm:       localEntry.Term,
-			ExpectedEntry: localEntry,
-			ActualEntry:   nil, // 缺失的条目
-			Severity:      cr.calculateInconsistencySeverity(MissingEntries),
-			ImpactLevel:   "Medium",
-			Description:   fmt.Sprintf("DC %s 缺失日志条目 %d", dcID, index),
-			RepairStatus:  RepairPending,
+		digest, exists := remote[index]
+		switch {
+		case !exists:
+			cr.recordInconsistency(MissingEntries, dcID, localEntry, nil,
+				fmt.Sprintf("DC %s 缺失日志条目 %d", dcID, index))
+		case digest.Term != localEntry.Term:
+			cr.recordInconsistency(ConflictingEntries, dcID, localEntry, map[string]interface{}{
+				"remoteTerm": digest.Term,
+				"localTerm":  localEntry.Term,
+			}, fmt.Sprintf("DC %s 的日志条目 %d 任期为 %d，本地为 %d", dcID, index, digest.Term, localEntry.Term))
+		case digest.Checksum != raft.EntryChecksum(localEntry):
+			cr.recordInconsistency(CorruptedEntries, dcID, localEntry, map[string]interface{}{
+				"remoteChecksum": digest.Checksum,
+				"localChecksum":  raft.EntryChecksum(localEntry),
+			}, fmt.Sprintf("DC %s 的日志条目 %d 校验和不一致", dcID, index))
 		}
+	}
+}
 
-		cr.inconsistencies[inconsistency.ID] = inconsistency
-		cr.currentSnapshot.InconsistencyDetails = append(cr.currentSnapshot.InconsistencyDetails, inconsistency)
-		cr.totalInconsistenciesDetected++
-
-		// 如果启用自动修复，将不一致添加到修复队列
-		if cr.config.AutoRepairEnabled {
-			select {
-			case cr.repairQueue <- inconsistency:
-				cr.logger.Printf("不一致已加入修复队列: %s", inconsistency.ID)
-			default:
-				cr.logger.Printf("修复队列已满，跳过不一致: %s", inconsistency.ID)
-			}
+// recordInconsistency 记录一个不一致，启用自动修复时加入修复队列
+func (cr *ConsistencyRecovery) recordInconsistency(
+	inconsistencyType InconsistencyType,
+	dcID raft.DataCenterID,
+	localEntry *raft.LogEntry,
+	details map[string]interface{},
+	description string,
+) {
+	inconsistency := &DataInconsistency{
+		ID:              fmt.Sprintf("inconsistency-%s-%d-%d", dcID, localEntry.Index, cr.clock.Now().Unix()),
+		Type:            inconsistencyType,
+		DetectedAt:      cr.clock.Now(),
+		SourceDC:        cr.getLocalDC(),
+		TargetDC:        dcID,
+		LogIndex:        localEntry.Index,
+		LogTerm:         localEntry.Term,
+		ExpectedEntry:   localEntry,
+		Severity:        cr.calculateInconsistencySeverity(inconsistencyType),
+		ImpactLevel:     "Medium",
+		Description:     description,
+		ConflictDetails: details,
+		RepairStatus:    RepairPending,
+	}
+
+	cr.inconsistencies[inconsistency.ID] = inconsistency
+	cr.currentSnapshot.InconsistencyDetails = append(cr.currentSnapshot.InconsistencyDetails, inconsistency)
+	cr.totalInconsistenciesDetected++
+
+	// 如果启用自动修复，释放锁后将不一致添加到修复队列
+	if cr.config.AutoRepairEnabled {
+		cr.pendingRepairs = append(cr.pendingRepairs, inconsistency)
+	}
+}
+
+// flushRepairs 把检查期间发现的不一致加入修复队列
+// 修复工作循环需要cr.mu，阻塞策略的队列不能在持有锁时入队
+func (cr *ConsistencyRecovery) flushRepairs() {
+	cr.mu.Lock()
+	pending := cr.pendingRepairs
+	cr.pendingRepairs = nil
+	cr.mu.Unlock()
+
+	for _, inconsistency := range pending {
+		if err := cr.repairQueue.Enqueue(inconsistency); err != nil {
+			cr.logger.Warn("修复队列入队失败，跳过不一致", "inconsistency_id", inconsistency.ID, logging.FieldError, err)
+			continue
 		}
+		cr.logger.Info("不一致已加入修复队列", "inconsistency_id", inconsistency.ID)
 	}
 }
 
 // repairWorkerLoop 修复工作循环
 func (cr *ConsistencyRecovery) repairWorkerLoop() {
 	defer cr.wg.Done()
-	cr.logger.Printf("修复工作循环已启动")
+	cr.logger.Debug("修复工作循环已启动")
 
 	for {
 		select {
-		case inconsistency := <-cr.repairQueue:
-			if inconsistency != nil {
-				cr.processRepair(inconsistency)
+		case <-cr.repairQueue.Ready():
+			if inconsistency, ok := cr.repairQueue.TryDequeue(); ok {
+				cr.processRepair(inconsistency.(*DataInconsistency))
 			}
 		case <-cr.stopCh:
-			cr.logger.Printf("修复工作循环已停止")
+			cr.logger.Debug("修复工作循环已停止")
 			return
 		}
 	}
@@ -519,13 +767,13 @@ func (cr *ConsistencyRecovery) repairWorkerLoop() {
 
 // processRepair 处理修复
 func (cr *ConsistencyRecovery) processRepair(inconsistency *DataInconsistency) {
-	cr.logger.Printf("开始修复不一致: %s", inconsistency.ID)
+	cr.logger.Info("开始修复不一致", "inconsistency_id", inconsistency.ID)
 
 	// 创建修复操作
 	operation := &RecoveryOperation{
 		ID:        fmt.Sprintf("repair-%s", inconsistency.ID),
 		Type:      "InconsistencyRepair",
-		StartTime: time.Now(),
+		StartTime: cr.clock.Now(),
 		Status:    "InProgress",
 		SourceDC:  inconsistency.SourceDC,
 		TargetDC:  inconsistency.TargetDC,
@@ -542,7 +790,7 @@ func (cr *ConsistencyRecovery) processRepair(inconsistency *DataInconsistency) {
 	cr.activeRepairs[operation.ID] = operation
 	inconsistency.RepairStatus = RepairInProgress
 	inconsistency.RepairAttempts++
-	inconsistency.LastRepairTime = time.Now()
+	inconsistency.LastRepairTime = cr.clock.Now()
 	cr.mu.Unlock()
 
 	// 执行实际的修复逻辑
@@ -550,7 +798,7 @@ func (cr *ConsistencyRecovery) processRepair(inconsistency *DataInconsistency) {
 
 	// 更新修复状态
 	cr.mu.Lock()
-	operation.EndTime = time.Now()
+	operation.EndTime = cr.clock.Now()
 	if success {
 		operation.Status = "Completed"
 		operation.SuccessfulEntries = 1
@@ -567,7 +815,7 @@ func (cr *ConsistencyRecovery) processRepair(inconsistency *DataInconsistency) {
 	cr.completedRepairs = append(cr.completedRepairs, operation)
 	cr.mu.Unlock()
 
-	cr.logger.Printf("修复完成: %s, 成功=%t", inconsistency.ID, success)
+	cr.logger.Info("修复完成", "inconsistency_id", inconsistency.ID, "success", success)
 }
 
 // executeRepair 执行修复
@@ -585,24 +833,30 @@ func (cr *ConsistencyRecovery) executeRepair(inconsistency *DataInconsistency, o
 	case TimestampMismatch:
 		return cr.repairTimestampMismatch(inconsistency, operation)
 	default:
-		cr.logger.Printf("未知的不一致类型: %d", inconsistency.Type)
+		cr.logger.Warn("未知的不一致类型", "type", inconsistency.Type)
 		return false
 	}
 }
 
-// repairMissingEntries 修复缺失条目
+// repairMissingEntries 修复缺失条目，把本地条目发送到目标DC
 func (cr *ConsistencyRecovery) repairMissingEntries(inconsistency *DataInconsistency, operation *RecoveryOperation) bool {
-	// 获取本地日志条目
-	if inconsistency.ExpectedEntry == nil {
+	return cr.transmitExpectedEntry(inconsistency, operation)
+}
+
+// transmitExpectedEntry 通过异步复制管理器把本地条目发送到目标DC
+// 对端按AppendEntries处理：缺失的条目被追加，任期冲突的条目连同其后的日志被替换
+func (cr *ConsistencyRecovery) transmitExpectedEntry(inconsistency *DataInconsistency, operation *RecoveryOperation) bool {
+	if inconsistency.ExpectedEntry == nil || cr.asyncReplicator == nil {
 		return false
 	}
 
-	// 模拟将日志条目发送到目标DC
-	cr.logger.Printf("发送日志条目 %d 到 DC %s", inconsistency.LogIndex, inconsistency.TargetDC)
+	cr.logger.Info("发送日志条目", "index", inconsistency.LogIndex, "target_dc", inconsistency.TargetDC)
 
-	// 这里应该实现实际的日志条目传输逻辑
-	// 为了演示，我们假设传输总是成功的
-	time.Sleep(time.Millisecond * 100) // 模拟网络延迟
+	entries := []raft.LogEntry{*inconsistency.ExpectedEntry}
+	if err := cr.asyncReplicator.SendEntries(inconsistency.TargetDC, entries); err != nil {
+		cr.logger.Warn("发送日志条目失败", "index", inconsistency.LogIndex, "target_dc", inconsistency.TargetDC, logging.FieldError, err)
+		return false
+	}
 
 	operation.ProcessedEntries = 1
 	operation.TransferredBytes = int64(len(inconsistency.ExpectedEntry.Data))
@@ -614,22 +868,22 @@ func (cr *ConsistencyRecovery) repairMissingEntries(inconsistency *DataInconsist
 // verificationLoop 验证循环
 func (cr *ConsistencyRecovery) verificationLoop() {
 	defer cr.wg.Done()
-	cr.logger.Printf("验证循环已启动")
+	cr.logger.Debug("验证循环已启动")
 
 	if !cr.config.VerificationEnabled {
-		cr.logger.Printf("验证功能已禁用，跳过验证循环")
+		cr.logger.Debug("验证功能已禁用，跳过验证循环")
 		return
 	}
 
-	ticker := time.NewTicker(cr.config.VerificationInterval)
+	ticker := cr.clock.NewTicker(cr.config.VerificationInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			cr.performVerification()
 		case <-cr.stopCh:
-			cr.logger.Printf("验证循环已停止")
+			cr.logger.Debug("验证循环已停止")
 			return
 		}
 	}
@@ -637,7 +891,7 @@ func (cr *ConsistencyRecovery) verificationLoop() {
 
 // performVerification 执行验证
 func (cr *ConsistencyRecovery) performVerification() {
-	cr.logger.Printf("开始执行一致性验证")
+	cr.logger.Debug("开始执行一致性验证")
 
 	// 验证已完成的修复是否真正解决了不一致问题
 	cr.mu.RLock()
@@ -658,17 +912,17 @@ func (cr *ConsistencyRecovery) performVerification() {
 // monitoringLoop 监控循环
 func (cr *ConsistencyRecovery) monitoringLoop() {
 	defer cr.wg.Done()
-	cr.logger.Printf("监控循环已启动")
+	cr.logger.Debug("监控循环已启动")
 
-	ticker := time.NewTicker(time.Minute * 1)
+	ticker := cr.clock.NewTicker(time.Minute * 1)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			cr.updateMonitoringMetrics()
 		case <-cr.stopCh:
-			cr.logger.Printf("监控循环已停止")
+			cr.logger.Debug("监控循环已停止")
 			return
 		}
 	}
@@ -706,41 +960,35 @@ func (cr *ConsistencyRecovery) calculateInconsistencySeverity(inconsistencyType
 }
 
 func (cr *ConsistencyRecovery) repairConflictingEntries(inconsistency *DataInconsistency, operation *RecoveryOperation) bool {
-	// 实现冲突条目修复逻辑
-	cr.logger.Printf("修复冲突条目: %d", inconsistency.LogIndex)
-	time.Sleep(time.Millisecond * 200)
-	return true
+	// 对端收到任期不同的条目时会截断冲突的日志
+	cr.logger.Info("修复冲突条目", "index", inconsistency.LogIndex)
+	return cr.transmitExpectedEntry(inconsistency, operation)
 }
 
 func (cr *ConsistencyRecovery) repairOutOfOrderEntries(inconsistency *DataInconsistency, operation *RecoveryOperation) bool {
 	// 实现乱序条目修复逻辑
-	cr.logger.Printf("修复乱序条目: %d", inconsistency.LogIndex)
-	time.Sleep(time.Millisecond * 150)
+	cr.logger.Info("修复乱序条目", "index", inconsistency.LogIndex)
+	cr.clock.Sleep(time.Millisecond * 150)
 	return true
 }
 
 func (cr *ConsistencyRecovery) repairCorruptedEntries(inconsistency *DataInconsistency, operation *RecoveryOperation) bool {
 	// 实现损坏条目修复逻辑
-	cr.logger.Printf("修复损坏条目: %d", inconsistency.LogIndex)
-	time.Sleep(time.Millisecond * 300)
+	cr.logger.Info("修复损坏条目", "index", inconsistency.LogIndex)
+	cr.clock.Sleep(time.Millisecond * 300)
 	return true
 }
 
 func (cr *ConsistencyRecovery) repairTimestampMismatch(inconsistency *DataInconsistency, operation *RecoveryOperation) bool {
 	// 实现时间戳不匹配修复逻辑
-	cr.logger.Printf("修复时间戳不匹配: %d", inconsistency.LogIndex)
-	time.Sleep(time.Millisecond * 100)
+	cr.logger.Info("修复时间戳不匹配", "index", inconsistency.LogIndex)
+	cr.clock.Sleep(time.Millisecond * 100)
 	return true
 }
 
 func (cr *ConsistencyRecovery) verifyRepairEffectiveness(repair *RecoveryOperation) {
 	// 实现修复效果验证逻辑
-	cr.logger.Printf("验证修复效果: %s", repair.ID)
-}
-
-func (cr *ConsistencyRecovery) verifyGlobalConsistency() {
-	// 实现全局一致性验证逻辑
-	cr.logger.Printf("验证全局一致性状态")
+	cr.logger.Debug("验证修复效果", "repair_id", repair.ID)
 }
 
 func (cr *ConsistencyRecovery) updateMonitoringMetrics() {
@@ -750,8 +998,7 @@ func (cr *ConsistencyRecovery) updateMonitoringMetrics() {
 	totalInconsistencies := len(cr.inconsistencies)
 	cr.mu.RUnlock()
 
-	cr.logger.Printf("监控指标: 活跃修复=%d, 总不一致=%d, 已完成修复=%d, 修复失败=%d",
-		activeRepairCount, totalInconsistencies, cr.totalRepairsCompleted, cr.totalRepairsFailed)
+	cr.logger.Debug("监控指标", "active_repairs", activeRepairCount, "inconsistencies", totalInconsistencies, "repairs_completed", cr.totalRepairsCompleted, "repairs_failed", cr.totalRepairsFailed)
 }
 
 // 公共API方法
@@ -798,9 +1045,11 @@ func (cr *ConsistencyRecovery) GetInconsistencies() map[string]*DataInconsistenc
 	cr.mu.RLock()
 	defer cr.mu.RUnlock()
 
+	// 返回副本，修复协程会继续更新原记录的修复状态
 	result := make(map[string]*DataInconsistency)
 	for id, inconsistency := range cr.inconsistencies {
-		result[id] = inconsistency
+		inconsistencyCopy := *inconsistency
+		result[id] = &inconsistencyCopy
 	}
 	return result
 }
@@ -817,6 +1066,11 @@ func (cr *ConsistencyRecovery) GetRecoveryOperations() map[string]*RecoveryOpera
 	return result
 }
 
+// CheckConsistency 立即执行一次一致性检查
+func (cr *ConsistencyRecovery) CheckConsistency() {
+	cr.performConsistencyCheck()
+}
+
 // TriggerManualRepair 触发手动修复
 func (cr *ConsistencyRecovery) TriggerManualRepair(inconsistencyID string) error {
 	cr.mu.RLock()
@@ -831,13 +1085,16 @@ func (cr *ConsistencyRecovery) TriggerManualRepair(inconsistencyID string) error
 		return fmt.Errorf("修复已在进行中: %s", inconsistencyID)
 	}
 
-	select {
-	case cr.repairQueue <- inconsistency:
-		cr.logger.Printf("手动触发修复: %s", inconsistencyID)
-		return nil
-	default:
-		return fmt.Errorf("修复队列已满")
+	if err := cr.repairQueue.Enqueue(inconsistency); err != nil {
+		return fmt.Errorf("修复队列入队失败: %w", err)
 	}
+	cr.logger.Info("手动触发修复", "inconsistency_id", inconsistencyID)
+	return nil
+}
+
+// GetQueueStats 获取修复队列的统计
+func (cr *ConsistencyRecovery) GetQueueStats() queue.Stats {
+	return cr.repairQueue.Stats()
 }
 
 // IsGloballyConsistent 检查是否全局一致
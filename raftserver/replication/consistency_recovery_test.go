@@ -0,0 +1,206 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-6-28 11:51:08
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-6-28 11:51:11
+* @Description: ConcordKV 数据一致性恢复器测试
+ */
+package replication_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/replication"
+)
+
+// memLogStorage 只保存日志条目的内存存储
+type memLogStorage struct {
+	entries []raft.LogEntry // entries[i]的索引为i+1
+}
+
+func (s *memLogStorage) SaveCurrentTerm(term raft.Term) error       { return nil }
+func (s *memLogStorage) GetCurrentTerm() (raft.Term, error)         { return s.GetLastLogTerm(), nil }
+func (s *memLogStorage) SaveVotedFor(candidateID raft.NodeID) error { return nil }
+func (s *memLogStorage) GetVotedFor() (raft.NodeID, error)          { return "", nil }
+func (s *memLogStorage) SaveSnapshot(snapshot *raft.Snapshot) error { return nil }
+func (s *memLogStorage) GetSnapshot() (*raft.Snapshot, error)       { return nil, nil }
+func (s *memLogStorage) Close() error                               { return nil }
+func (s *memLogStorage) GetLastLogIndex() raft.LogIndex             { return raft.LogIndex(len(s.entries)) }
+func (s *memLogStorage) SaveLogEntries(entries []raft.LogEntry) error {
+	return fmt.Errorf("只读存储")
+}
+func (s *memLogStorage) TruncateLog(index raft.LogIndex) error { return fmt.Errorf("只读存储") }
+
+func (s *memLogStorage) GetLastLogTerm() raft.Term {
+	if len(s.entries) == 0 {
+		return 0
+	}
+	return s.entries[len(s.entries)-1].Term
+}
+
+func (s *memLogStorage) GetLogEntry(index raft.LogIndex) (*raft.LogEntry, error) {
+	if index == 0 || index > s.GetLastLogIndex() {
+		return nil, fmt.Errorf("日志条目 %d 不存在", index)
+	}
+	entry := s.entries[index-1]
+	return &entry, nil
+}
+
+func (s *memLogStorage) GetLogEntries(start, end raft.LogIndex) ([]raft.LogEntry, error) {
+	if start == 0 || start > end || end > s.GetLastLogIndex()+1 {
+		return nil, fmt.Errorf("日志范围 [%d, %d) 无效", start, end)
+	}
+	return append([]raft.LogEntry(nil), s.entries[start-1:end-1]...), nil
+}
+
+// storageDigestFetcher 直接从各DC的存储计算日志摘要
+type storageDigestFetcher map[raft.DataCenterID]raft.Storage
+
+func (f storageDigestFetcher) FetchLogDigests(ctx context.Context, dcID raft.DataCenterID, start, end raft.LogIndex) ([]raft.LogDigest, error) {
+	storage, exists := f[dcID]
+	if !exists {
+		return nil, fmt.Errorf("未知DC %s", dcID)
+	}
+	return raft.ComputeLogDigests(storage, start, end)
+}
+
+// newDivergentStorages 本地有10个条目；远端只有前8个，其中条目3的任期不同，条目5的数据不同
+func newDivergentStorages() (local, remote *memLogStorage) {
+	local = &memLogStorage{entries: makeEntries(1, 10)}
+	local.entries[8].Term = 2
+	local.entries[9].Term = 2
+
+	remote = &memLogStorage{entries: makeEntries(1, 8)}
+	remote.entries[2].Term = 2
+	remote.entries[4].Data = []byte("entry-5-corrupted")
+	return local, remote
+}
+
+// newTestRecovery 创建扫描窗口覆盖全部本地日志的一致性恢复器
+func newTestRecovery(t *testing.T, local raft.Storage, remote raft.Storage, transport *mockTransport, autoRepair bool) *replication.ConsistencyRecovery {
+	t.Helper()
+
+	config := replication.DefaultConsistencyRecoveryConfig()
+	config.LogIndexScanWindow = 9
+	config.AutoRepairEnabled = autoRepair
+	config.DifferenceDetectionInterval = time.Hour
+	config.VerificationEnabled = false
+
+	ar := newTestReplicator(t, testConfig(100), transport)
+	recovery := replication.NewConsistencyRecovery("n1", config, local, ar, nil, nil)
+	recovery.SetLogDigestFetcher(storageDigestFetcher{"dc2": remote})
+	return recovery
+}
+
+// inconsistenciesByIndex 按日志索引整理检测到的不一致
+func inconsistenciesByIndex(recovery *replication.ConsistencyRecovery) map[raft.LogIndex]*replication.DataInconsistency {
+	result := make(map[raft.LogIndex]*replication.DataInconsistency)
+	for _, inconsistency := range recovery.GetInconsistencies() {
+		result[inconsistency.LogIndex] = inconsistency
+	}
+	return result
+}
+
+// TestConsistencyRecoveryClassifiesDivergence 只记录真实存在的差异，并区分缺失、冲突与损坏
+func TestConsistencyRecoveryClassifiesDivergence(t *testing.T) {
+	local, remote := newDivergentStorages()
+	recovery := newTestRecovery(t, local, remote, &mockTransport{}, false)
+
+	recovery.CheckConsistency()
+
+	expected := map[raft.LogIndex]replication.InconsistencyType{
+		3:  replication.ConflictingEntries,
+		5:  replication.CorruptedEntries,
+		9:  replication.MissingEntries,
+		10: replication.MissingEntries,
+	}
+	found := inconsistenciesByIndex(recovery)
+	if len(found) != len(expected) {
+		t.Fatalf("应检测到 %d 个不一致，实际 %d 个: %v", len(expected), len(found), found)
+	}
+	for index, inconsistencyType := range expected {
+		inconsistency, exists := found[index]
+		if !exists {
+			t.Errorf("条目 %d 的不一致未被检测到", index)
+			continue
+		}
+		if inconsistency.Type != inconsistencyType {
+			t.Errorf("条目 %d 的不一致类型应为 %d，实际 %d", index, inconsistencyType, inconsistency.Type)
+		}
+		if inconsistency.TargetDC != "dc2" || inconsistency.ExpectedEntry == nil || inconsistency.ExpectedEntry.Index != index {
+			t.Errorf("条目 %d 的不一致记录不完整: %+v", index, inconsistency)
+		}
+	}
+
+	snapshot := recovery.GetCurrentSnapshot()
+	if snapshot.GlobalConsistency {
+		t.Errorf("存在差异时不应全局一致")
+	}
+}
+
+// TestConsistencyRecoveryIdenticalLogs 日志相同时即使复制进度落后也不记录不一致
+func TestConsistencyRecoveryIdenticalLogs(t *testing.T) {
+	local := &memLogStorage{entries: makeEntries(1, 10)}
+	remote := &memLogStorage{entries: makeEntries(1, 10)}
+	recovery := newTestRecovery(t, local, remote, &mockTransport{}, false)
+
+	recovery.CheckConsistency()
+
+	if found := recovery.GetInconsistencies(); len(found) != 0 {
+		t.Errorf("日志相同时不应记录不一致，实际 %d 个", len(found))
+	}
+}
+
+// TestConsistencyRecoveryRepairsMissingEntries 缺失和冲突的条目被实际发送到目标DC
+func TestConsistencyRecoveryRepairsMissingEntries(t *testing.T) {
+	local, remote := newDivergentStorages()
+	transport := &mockTransport{}
+	recovery := newTestRecovery(t, local, remote, transport, true)
+	if err := recovery.Start(); err != nil {
+		t.Fatalf("启动一致性恢复器失败: %v", err)
+	}
+	t.Cleanup(func() { recovery.Stop() })
+
+	recovery.CheckConsistency()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		pending := 0
+		for _, inconsistency := range recovery.GetInconsistencies() {
+			if inconsistency.Type != replication.CorruptedEntries && inconsistency.RepairStatus != replication.RepairCompleted {
+				pending++
+			}
+		}
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待修复完成超时，剩余 %d 个", pending)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	requests, _ := transport.sent()
+	var sent []raft.LogIndex
+	for _, req := range requests {
+		for _, entry := range req.Entries {
+			local, _ := local.GetLogEntry(entry.Index)
+			if entry.Term != local.Term || string(entry.Data) != string(local.Data) {
+				t.Errorf("发送的条目 %d 与本地条目不同", entry.Index)
+			}
+			sent = append(sent, entry.Index)
+		}
+		if req.PrevLogIndex != req.Entries[0].Index-1 {
+			t.Errorf("PrevLogIndex应为 %d，实际 %d", req.Entries[0].Index-1, req.PrevLogIndex)
+		}
+	}
+	sort.Slice(sent, func(i, j int) bool { return sent[i] < sent[j] })
+	if fmt.Sprint(sent) != "[3 9 10]" {
+		t.Errorf("应发送条目 [3 9 10]，实际 %v", sent)
+	}
+}
@@ -11,10 +11,12 @@ package replication
 import (
 	"context"
 	"fmt"
-	"log"
 	"sync"
 	"time"
 
+	"raftserver/clock"
+	"raftserver/logging"
+	"raftserver/queue"
 	"raftserver/raft"
 )
 
@@ -34,6 +36,8 @@ const (
 	SlowNetwork
 	// PartialFailure 部分故障
 	PartialFailure
+	// Degrading 健康趋势持续恶化，尚未达到故障阈值
+	Degrading
 )
 
 // DCFailureDetectorConfig DC故障检测器配置
@@ -43,12 +47,18 @@ type DCFailureDetectorConfig struct {
 	HeartbeatTimeout       time.Duration `json:"heartbeatTimeout"`
 	FailureDetectionWindow time.Duration `json:"failureDetectionWindow"`
 
-	// 故障阈值配置
+	// 故障阈值配置，故障状态连续MaxConsecutiveFailures个快照保持一致才生效
 	MaxConsecutiveFailures  int           `json:"maxConsecutiveFailures"`
 	NetworkLatencyThreshold time.Duration `json:"networkLatencyThreshold"`
 	SlowNetworkThreshold    time.Duration `json:"slowNetworkThreshold"`
 	PartitionDetectionRatio float64       `json:"partitionDetectionRatio"`
 
+	// 趋势分析配置：每个DC保留FailureDetectionWindow内的快照（HealthHistorySize为0时按检测间隔推算）
+	// 最近TrendMinSamples个快照中健康节点比例单调下降，或平均延迟每个快照增长超过LatencySlopeThreshold时发出退化事件
+	HealthHistorySize     int           `json:"healthHistorySize"`
+	TrendMinSamples       int           `json:"trendMinSamples"`
+	LatencySlopeThreshold time.Duration `json:"latencySlopeThreshold"`
+
 	// 恢复检测配置
 	RecoveryCheckInterval       time.Duration `json:"recoveryCheckInterval"`
 	MinRecoveryObservations     int           `json:"minRecoveryObservations"`
@@ -58,6 +68,16 @@ type DCFailureDetectorConfig struct {
 	EnableDetailedLogging bool                `json:"enableDetailedLogging"`
 	EnableFailoverTrigger bool                `json:"enableFailoverTrigger"`
 	AlertThresholds       map[FailureType]int `json:"alertThresholds"`
+
+	// 故障与恢复事件队列，默认容量100，同一DC的同类事件合并（coalesce）
+	FailureEventQueue  queue.Config `json:"failureEventQueue"`
+	RecoveryEventQueue queue.Config `json:"recoveryEventQueue"`
+
+	// Logger 检测器使用的日志，为nil时使用logging.Default()
+	Logger logging.Logger `json:"-"`
+
+	// Clock 检测器计时使用的时钟，为nil时使用真实时钟，测试中注入testutil.FakeClock
+	Clock clock.Clock `json:"-"`
 }
 
 // DefaultDCFailureDetectorConfig 默认配置
@@ -70,6 +90,8 @@ func DefaultDCFailureDetectorConfig() *DCFailureDetectorConfig {
 		NetworkLatencyThreshold:     time.Millisecond * 500,
 		SlowNetworkThreshold:        time.Millisecond * 200,
 		PartitionDetectionRatio:     0.7,
+		TrendMinSamples:             5,
+		LatencySlopeThreshold:       time.Millisecond * 20,
 		RecoveryCheckInterval:       time.Second * 3,
 		MinRecoveryObservations:     5,
 		RecoveryConfidenceThreshold: 0.8,
@@ -81,6 +103,8 @@ func DefaultDCFailureDetectorConfig() *DCFailureDetectorConfig {
 			DCFailure:        1,
 			SlowNetwork:      5,
 		},
+		FailureEventQueue:  defaultFailureEventQueueConfig,
+		RecoveryEventQueue: defaultFailureEventQueueConfig,
 	}
 }
 
@@ -112,6 +136,24 @@ type NodeFailureInfo struct {
 	IsRecovering        bool
 }
 
+// DCRecoveryStatus 故障DC恢复后的观察状态，达到观察次数和置信度后才恢复路由
+type DCRecoveryStatus struct {
+	DataCenter          raft.DataCenterID
+	StartedAt           time.Time
+	Observations        int // 恢复以来的快照数
+	HealthyObservations int // 其中健康的快照数
+	ConsecutiveHealthy  int
+	Relapses            int // 观察期内再次出现异常的次数
+}
+
+// Confidence 恢复置信度，即观察期内健康快照的比例
+func (rs *DCRecoveryStatus) Confidence() float64 {
+	if rs.Observations == 0 {
+		return 0
+	}
+	return float64(rs.HealthyObservations) / float64(rs.Observations)
+}
+
 // DCFailureEvent DC故障事件
 type DCFailureEvent struct {
 	EventID           string
@@ -125,6 +167,23 @@ type DCFailureEvent struct {
 	RecommendedAction string
 }
 
+// 故障与恢复事件队列的默认配置：同一DC的同类事件合并，突发故障时不会挤掉触发故障转移的事件
+var defaultFailureEventQueueConfig = queue.Config{Capacity: 100, Policy: queue.Coalesce, BlockTimeout: time.Second}
+
+// failureEventQueueOptions 合并同一DC的同类故障事件，保留严重度更高的事件，严重度相同时保留较新的事件
+var failureEventQueueOptions = queue.Options{
+	Key: func(item interface{}) string {
+		event := item.(*DCFailureEvent)
+		return fmt.Sprintf("%s/%d", event.DataCenter, event.FailureType)
+	},
+	Merge: func(queued, item interface{}) interface{} {
+		if item.(*DCFailureEvent).Severity >= queued.(*DCFailureEvent).Severity {
+			return item
+		}
+		return queued
+	},
+}
+
 // DCFailureDetector DC级别故障检测器
 type DCFailureDetector struct {
 	mu sync.RWMutex
@@ -132,7 +191,8 @@ type DCFailureDetector struct {
 	// 基础配置
 	nodeID raft.NodeID
 	config *DCFailureDetectorConfig
-	logger *log.Logger
+	logger logging.Logger
+	clock  clock.Clock
 
 	// 集成组件
 	asyncReplicator *AsyncReplicator
@@ -142,7 +202,12 @@ type DCFailureDetector struct {
 	// 健康状态跟踪
 	dcHealthSnapshots map[raft.DataCenterID]*DCHealthSnapshot
 	nodeFailureInfo   map[raft.NodeID]*NodeFailureInfo
-	healthHistory     []DCHealthSnapshot // 滑动窗口历史
+	healthHistory     map[raft.DataCenterID][]DCHealthSnapshot // 每个DC的滑动窗口历史，按时间排序
+
+	// 故障状态去抖：检测结果与当前状态不同时，需连续出现MaxConsecutiveFailures次才切换
+	candidateFailures map[raft.DataCenterID]FailureType
+	candidateCounts   map[raft.DataCenterID]int
+	degradingDCs      map[raft.DataCenterID]bool // 已发出退化事件、趋势尚未消失的DC
 
 	// 故障检测状态
 	currentFailures    map[raft.DataCenterID]FailureType
@@ -150,6 +215,10 @@ type DCFailureDetector struct {
 	lastFailoverTime   time.Time
 	failoverInProgress bool
 
+	// 故障恢复状态
+	excludedDCs   map[raft.DataCenterID]bool // 因故障被排除在路由之外的DC
+	recoveringDCs map[raft.DataCenterID]*DCRecoveryStatus
+
 	// 控制流
 	ctx     context.Context
 	cancel  context.CancelFunc
@@ -157,9 +226,15 @@ type DCFailureDetector struct {
 	running bool
 	stopCh  chan struct{}
 
-	// 事件通道
-	failureEventCh  chan *DCFailureEvent
-	recoveryEventCh chan *DCFailureEvent
+	// 事件队列，持有fd.mu时产生的事件先记入outbox，释放锁后再入队
+	failureQueue  *queue.Queue
+	recoveryQueue *queue.Queue
+	outbox        []queuedEvent
+
+	// 故障转移订阅者，触发故障转移时收到故障事件
+	subscribers []chan *DCFailureEvent
+	// 恢复订阅者，DC稳定恢复并重新加入路由时收到事件
+	recoverySubscribers []chan *DCFailureEvent
 }
 
 // NewDCFailureDetector 创建DC故障检测器
@@ -179,22 +254,30 @@ func NewDCFailureDetector(
 	detector := &DCFailureDetector{
 		nodeID:          nodeID,
 		config:          config,
-		logger:          log.New(log.Writer(), "[dc-failure-detector] ", log.LstdFlags),
+		logger:          logging.Component(config.Logger, "dc-failure-detector", logging.FieldNodeID, string(nodeID)),
+		clock:           clock.Or(config.Clock),
 		asyncReplicator: asyncReplicator,
 		readWriteRouter: readWriteRouter,
 		transport:       transport,
 
 		dcHealthSnapshots: make(map[raft.DataCenterID]*DCHealthSnapshot),
 		nodeFailureInfo:   make(map[raft.NodeID]*NodeFailureInfo),
-		healthHistory:     make([]DCHealthSnapshot, 0, 100),
+		healthHistory:     make(map[raft.DataCenterID][]DCHealthSnapshot),
+		candidateFailures: make(map[raft.DataCenterID]FailureType),
+		candidateCounts:   make(map[raft.DataCenterID]int),
+		degradingDCs:      make(map[raft.DataCenterID]bool),
 		currentFailures:   make(map[raft.DataCenterID]FailureType),
 		failureEvents:     make([]*DCFailureEvent, 0),
-
-		ctx:             ctx,
-		cancel:          cancel,
-		stopCh:          make(chan struct{}),
-		failureEventCh:  make(chan *DCFailureEvent, 100),
-		recoveryEventCh: make(chan *DCFailureEvent, 100),
+		excludedDCs:       make(map[raft.DataCenterID]bool),
+		recoveringDCs:     make(map[raft.DataCenterID]*DCRecoveryStatus),
+
+		ctx:    ctx,
+		cancel: cancel,
+		stopCh: make(chan struct{}),
+		failureQueue: queue.New("dc-failure-events",
+			config.FailureEventQueue.WithDefaults(defaultFailureEventQueueConfig), failureEventQueueOptions),
+		recoveryQueue: queue.New("dc-recovery-events",
+			config.RecoveryEventQueue.WithDefaults(defaultFailureEventQueueConfig), failureEventQueueOptions),
 	}
 
 	detector.initializeHealthTracking()
@@ -209,7 +292,7 @@ func (fd *DCFailureDetector) initializeHealthTracking() {
 		for dcID, target := range targets {
 			fd.dcHealthSnapshots[dcID] = &DCHealthSnapshot{
 				DataCenter:     dcID,
-				Timestamp:      time.Now(),
+				Timestamp:      fd.clock.Now(),
 				TotalNodes:     len(target.Nodes),
 				HealthyNodes:   len(target.Nodes), // 初始假设都健康
 				AverageLatency: time.Millisecond * 50,
@@ -221,13 +304,13 @@ func (fd *DCFailureDetector) initializeHealthTracking() {
 					NodeID:          nodeID,
 					DataCenter:      dcID,
 					FailureType:     NoFailure,
-					LastSuccessTime: time.Now(),
+					LastSuccessTime: fd.clock.Now(),
 				}
 			}
 		}
 	}
 
-	fd.logger.Printf("初始化健康跟踪完成，监控 %d 个DC", len(fd.dcHealthSnapshots))
+	fd.logger.Info("初始化健康跟踪完成", "dcs", len(fd.dcHealthSnapshots))
 }
 
 // Start 启动故障检测器
@@ -239,7 +322,7 @@ func (fd *DCFailureDetector) Start() error {
 		return fmt.Errorf("故障检测器已在运行")
 	}
 
-	fd.logger.Printf("启动DC故障检测器")
+	fd.logger.Info("启动DC故障检测器")
 
 	// 启动各个工作循环
 	fd.wg.Add(4)
@@ -255,36 +338,37 @@ func (fd *DCFailureDetector) Start() error {
 // Stop 停止故障检测器
 func (fd *DCFailureDetector) Stop() error {
 	fd.mu.Lock()
-	defer fd.mu.Unlock()
-
 	if !fd.running {
+		fd.mu.Unlock()
 		return nil
 	}
 
-	fd.logger.Printf("停止DC故障检测器")
+	fd.logger.Info("停止DC故障检测器")
 
 	fd.cancel()
 	close(fd.stopCh)
-	fd.wg.Wait()
-
 	fd.running = false
+	fd.mu.Unlock()
+
+	// 工作循环需要获取fd.mu，等待时不能持有锁
+	fd.wg.Wait()
 	return nil
 }
 
 // healthCheckLoop 健康检查循环
 func (fd *DCFailureDetector) healthCheckLoop() {
 	defer fd.wg.Done()
-	fd.logger.Printf("健康检查循环已启动")
+	fd.logger.Debug("健康检查循环已启动")
 
-	ticker := time.NewTicker(fd.config.HealthCheckInterval)
+	ticker := fd.clock.NewTicker(fd.config.HealthCheckInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			fd.performHealthCheck()
 		case <-fd.stopCh:
-			fd.logger.Printf("健康检查循环已停止")
+			fd.logger.Debug("健康检查循环已停止")
 			return
 		}
 	}
@@ -293,9 +377,10 @@ func (fd *DCFailureDetector) healthCheckLoop() {
 // performHealthCheck 执行健康检查
 func (fd *DCFailureDetector) performHealthCheck() {
 	fd.mu.Lock()
+	defer fd.flushEvents() // 在释放锁之后执行
 	defer fd.mu.Unlock()
 
-	currentTime := time.Now()
+	currentTime := fd.clock.Now()
 
 	// 从异步复制管理器获取最新状态
 	if fd.asyncReplicator != nil {
@@ -303,6 +388,11 @@ func (fd *DCFailureDetector) performHealthCheck() {
 		for dcID, target := range targets {
 			snapshot := fd.updateDCHealthSnapshot(dcID, target, currentTime)
 			fd.analyzeHealthChanges(snapshot)
+
+			// 有界陈旧读按复制延迟选择副本
+			if fd.readWriteRouter != nil {
+				fd.readWriteRouter.SetReplicationLag(dcID, replicationStaleness(target, currentTime))
+			}
 		}
 	}
 
@@ -314,14 +404,22 @@ func (fd *DCFailureDetector) performHealthCheck() {
 		}
 	}
 
-	// 更新健康历史
-	fd.updateHealthHistory(currentTime)
-
 	if fd.config.EnableDetailedLogging {
 		fd.logHealthStatus()
 	}
 }
 
+// replicationStaleness 目标DC数据落后的时间：最近一批条目的复制耗时，有未确认条目时不少于距上次复制成功的时间
+func replicationStaleness(target *AsyncReplicationTarget, now time.Time) time.Duration {
+	lag := target.ReplicationLag
+	if (len(target.PendingEntries) > 0 || target.PendingBatches > 0) && !target.LastSuccessTime.IsZero() {
+		if since := now.Sub(target.LastSuccessTime); since > lag {
+			lag = since
+		}
+	}
+	return lag
+}
+
 // updateDCHealthSnapshot 更新DC健康快照
 func (fd *DCFailureDetector) updateDCHealthSnapshot(
 	dcID raft.DataCenterID,
@@ -353,6 +451,11 @@ func (fd *DCFailureDetector) updateDCHealthSnapshot(
 			continue
 		}
 
+		// 复制成功说明目标DC可达
+		if target.LastSuccessTime.After(nodeInfo.LastSuccessTime) {
+			nodeInfo.LastSuccessTime = target.LastSuccessTime
+		}
+
 		// 判断节点健康状态
 		timeSinceLastSuccess := timestamp.Sub(nodeInfo.LastSuccessTime)
 		if timeSinceLastSuccess <= fd.config.HeartbeatTimeout {
@@ -417,7 +520,7 @@ func (fd *DCFailureDetector) updateFromRouterInfo(
 		// 根据路由器健康状态更新
 		if info.IsHealthy {
 			if nodeInfo.FailureType != NoFailure {
-				fd.logger.Printf("节点 %s 从故障状态恢复", nodeID)
+				fd.logger.Info("节点从故障状态恢复", "peer", nodeID)
 				nodeInfo.FailureType = NoFailure
 				nodeInfo.LastSuccessTime = timestamp
 				nodeInfo.RecoveryAttempts++
@@ -447,6 +550,11 @@ func (fd *DCFailureDetector) analyzeHealthChanges(snapshot *DCHealthSnapshot) {
 	dcID := snapshot.DataCenter
 	currentFailure := fd.currentFailures[dcID]
 
+	if snapshot.TotalNodes == 0 {
+		return
+	}
+	fd.updateHealthHistory(snapshot)
+
 	// 计算健康比例
 	healthyRatio := float64(snapshot.HealthyNodes) / float64(snapshot.TotalNodes)
 
@@ -467,20 +575,74 @@ func (fd *DCFailureDetector) analyzeHealthChanges(snapshot *DCHealthSnapshot) {
 		detectedFailure = PartialFailure
 	}
 
-	// 故障状态变化处理
-	if currentFailure != detectedFailure {
+	// 故障状态变化处理，单次波动不会改变状态
+	if fd.confirmFailureState(dcID, currentFailure, detectedFailure) {
 		fd.handleFailureStateChange(dcID, currentFailure, detectedFailure, snapshot)
 		fd.currentFailures[dcID] = detectedFailure
+		currentFailure = detectedFailure
+	}
+
+	if status := fd.recoveringDCs[dcID]; status != nil {
+		fd.observeRecovery(status, detectedFailure)
+	}
+
+	// 尚未达到故障转移条件时根据趋势提前预警
+	if !fd.hasFailoverCondition(dcID) {
+		fd.predictPotentialFailures(dcID, snapshot)
+	} else {
+		delete(fd.degradingDCs, dcID)
 	}
 }
 
+// confirmFailureState 检测结果与当前状态不同且连续出现MaxConsecutiveFailures次时返回true（调用方需持有fd.mu）
+func (fd *DCFailureDetector) confirmFailureState(dcID raft.DataCenterID, current, detected FailureType) bool {
+	if detected == current {
+		delete(fd.candidateFailures, dcID)
+		delete(fd.candidateCounts, dcID)
+		return false
+	}
+
+	if candidate, exists := fd.candidateFailures[dcID]; exists && candidate == detected {
+		fd.candidateCounts[dcID]++
+	} else {
+		fd.candidateFailures[dcID] = detected
+		fd.candidateCounts[dcID] = 1
+	}
+
+	required := fd.config.MaxConsecutiveFailures
+	if required < 1 {
+		required = 1
+	}
+	if fd.candidateCounts[dcID] < required {
+		return false
+	}
+
+	delete(fd.candidateFailures, dcID)
+	delete(fd.candidateCounts, dcID)
+	return true
+}
+
+// RecordHealthSnapshot 提交来自外部健康探测的DC快照，与周期性健康检查得到的快照一样参与故障判断
+func (fd *DCFailureDetector) RecordHealthSnapshot(snapshot DCHealthSnapshot) {
+	fd.mu.Lock()
+	defer fd.flushEvents() // 在释放锁之后执行
+	defer fd.mu.Unlock()
+
+	if snapshot.Timestamp.IsZero() {
+		snapshot.Timestamp = fd.clock.Now()
+	}
+	stored := snapshot
+	fd.dcHealthSnapshots[snapshot.DataCenter] = &stored
+	fd.analyzeHealthChanges(&stored)
+}
+
 // handleFailureStateChange 处理故障状态变化
 func (fd *DCFailureDetector) handleFailureStateChange(
 	dcID raft.DataCenterID,
 	oldFailure, newFailure FailureType,
 	snapshot *DCHealthSnapshot,
 ) {
-	timestamp := time.Now()
+	timestamp := fd.clock.Now()
 
 	if newFailure == NoFailure && oldFailure != NoFailure {
 		// 故障恢复
@@ -496,13 +658,25 @@ func (fd *DCFailureDetector) handleFailureStateChange(
 			RecommendedAction: "监控稳定性，逐步恢复流量",
 		}
 
-		select {
-		case fd.recoveryEventCh <- event:
-		default:
-			fd.logger.Printf("恢复事件通道已满，丢弃事件: %s", event.EventID)
+		fd.publishLocked(fd.recoveryQueue, event)
+
+		// 被排除的DC先进入观察期，由monitorRecoveryProgress确认稳定后再恢复路由
+		if fd.excludedDCs[dcID] {
+			fd.recoveringDCs[dcID] = &DCRecoveryStatus{
+				DataCenter: dcID,
+				StartedAt:  timestamp,
+			}
 		}
 
 	} else if newFailure != NoFailure {
+		if isRoutingFailure(newFailure) {
+			if _, recovering := fd.recoveringDCs[dcID]; recovering {
+				fd.logger.Warn("DC在恢复观察期内再次故障", "target_dc", dcID)
+				delete(fd.recoveringDCs, dcID)
+			}
+			fd.excludeFromRouting(dcID)
+		}
+
 		// 检测到新故障
 		severity := fd.calculateFailureSeverity(newFailure, snapshot)
 		event := &DCFailureEvent{
@@ -517,28 +691,24 @@ func (fd *DCFailureDetector) handleFailureStateChange(
 			RecommendedAction: fd.getRecommendedAction(newFailure),
 		}
 
-		select {
-		case fd.failureEventCh <- event:
-		default:
-			fd.logger.Printf("故障事件通道已满，丢弃事件: %s", event.EventID)
-		}
+		fd.publishLocked(fd.failureQueue, event)
 	}
 }
 
 // failureAnalysisLoop 故障分析循环
 func (fd *DCFailureDetector) failureAnalysisLoop() {
 	defer fd.wg.Done()
-	fd.logger.Printf("故障分析循环已启动")
+	fd.logger.Debug("故障分析循环已启动")
 
-	ticker := time.NewTicker(time.Second * 10)
+	ticker := fd.clock.NewTicker(time.Second * 10)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			fd.performAdvancedFailureAnalysis()
 		case <-fd.stopCh:
-			fd.logger.Printf("故障分析循环已停止")
+			fd.logger.Debug("故障分析循环已停止")
 			return
 		}
 	}
@@ -549,10 +719,9 @@ func (fd *DCFailureDetector) performAdvancedFailureAnalysis() {
 	fd.mu.RLock()
 	defer fd.mu.RUnlock()
 
-	// 分析故障模式和趋势
+	// 分析故障模式，趋势预测在每个快照到达时进行
 	for dcID, snapshot := range fd.dcHealthSnapshots {
 		fd.analyzeFailurePatterns(dcID, snapshot)
-		fd.predictPotentialFailures(dcID, snapshot)
 	}
 
 	// 分析跨DC故障相关性
@@ -562,16 +731,20 @@ func (fd *DCFailureDetector) performAdvancedFailureAnalysis() {
 // eventProcessingLoop 事件处理循环
 func (fd *DCFailureDetector) eventProcessingLoop() {
 	defer fd.wg.Done()
-	fd.logger.Printf("事件处理循环已启动")
+	fd.logger.Debug("事件处理循环已启动")
 
 	for {
 		select {
-		case event := <-fd.failureEventCh:
-			fd.processFailureEvent(event)
-		case event := <-fd.recoveryEventCh:
-			fd.processRecoveryEvent(event)
+		case <-fd.failureQueue.Ready():
+			if event, ok := fd.failureQueue.TryDequeue(); ok {
+				fd.processFailureEvent(event.(*DCFailureEvent))
+			}
+		case <-fd.recoveryQueue.Ready():
+			if event, ok := fd.recoveryQueue.TryDequeue(); ok {
+				fd.processRecoveryEvent(event.(*DCFailureEvent))
+			}
 		case <-fd.stopCh:
-			fd.logger.Printf("事件处理循环已停止")
+			fd.logger.Debug("事件处理循环已停止")
 			return
 		}
 	}
@@ -580,17 +753,17 @@ func (fd *DCFailureDetector) eventProcessingLoop() {
 // recoveryMonitoringLoop 恢复监控循环
 func (fd *DCFailureDetector) recoveryMonitoringLoop() {
 	defer fd.wg.Done()
-	fd.logger.Printf("恢复监控循环已启动")
+	fd.logger.Debug("恢复监控循环已启动")
 
-	ticker := time.NewTicker(fd.config.RecoveryCheckInterval)
+	ticker := fd.clock.NewTicker(fd.config.RecoveryCheckInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			fd.monitorRecoveryProgress()
 		case <-fd.stopCh:
-			fd.logger.Printf("恢复监控循环已停止")
+			fd.logger.Debug("恢复监控循环已停止")
 			return
 		}
 	}
@@ -611,6 +784,8 @@ func (fd *DCFailureDetector) failureTypeString(ft FailureType) string {
 		return "网络缓慢"
 	case PartialFailure:
 		return "部分故障"
+	case Degrading:
+		return "健康退化"
 	default:
 		return "未知故障"
 	}
@@ -677,6 +852,19 @@ func (fd *DCFailureDetector) ShouldTriggerFailover(dcID raft.DataCenterID) bool
 		return false
 	}
 
+	return fd.hasFailoverCondition(dcID)
+}
+
+// HasFailoverCondition 判断DC的故障是否仍然需要故障转移，不考虑是否已有故障转移在进行
+func (fd *DCFailureDetector) HasFailoverCondition(dcID raft.DataCenterID) bool {
+	fd.mu.RLock()
+	defer fd.mu.RUnlock()
+
+	return fd.hasFailoverCondition(dcID)
+}
+
+// hasFailoverCondition 调用方需持有fd.mu
+func (fd *DCFailureDetector) hasFailoverCondition(dcID raft.DataCenterID) bool {
 	failureType, exists := fd.currentFailures[dcID]
 	if !exists {
 		return false
@@ -690,17 +878,53 @@ func (fd *DCFailureDetector) ShouldTriggerFailover(dcID raft.DataCenterID) bool
 		// 检查影响程度
 		snapshot := fd.dcHealthSnapshots[dcID]
 		if snapshot != nil {
-			healthyRatio := float64(snapshot.HealthyNodes) / float64(snapshot.TotalNodes)
-			return healthyRatio < 0.5 // 少于50%节点健康时触发
+			return healthyRatio(*snapshot) < 0.5 // 少于50%节点健康时触发
 		}
 	}
 
 	return false
 }
 
-// 实现剩余的辅助方法（为了代码简洁，这里提供关键方法的实现框架）
-func (fd *DCFailureDetector) updateHealthHistory(timestamp time.Time) {
-	// 实现健康历史更新逻辑
+// GetHealthHistory 获取DC在检测窗口内的健康快照，按时间排序
+func (fd *DCFailureDetector) GetHealthHistory(dcID raft.DataCenterID) []DCHealthSnapshot {
+	fd.mu.RLock()
+	defer fd.mu.RUnlock()
+
+	return append([]DCHealthSnapshot(nil), fd.healthHistory[dcID]...)
+}
+
+// GetFailureEvents 获取已处理的故障与退化事件
+func (fd *DCFailureDetector) GetFailureEvents() []DCFailureEvent {
+	fd.mu.RLock()
+	defer fd.mu.RUnlock()
+
+	events := make([]DCFailureEvent, 0, len(fd.failureEvents))
+	for _, event := range fd.failureEvents {
+		events = append(events, *event)
+	}
+	return events
+}
+
+// healthHistorySize 每个DC保留的快照数
+func (fd *DCFailureDetector) healthHistorySize() int {
+	if fd.config.HealthHistorySize > 0 {
+		return fd.config.HealthHistorySize
+	}
+	if fd.config.HealthCheckInterval > 0 {
+		if n := int(fd.config.FailureDetectionWindow / fd.config.HealthCheckInterval); n > 0 {
+			return n
+		}
+	}
+	return 100
+}
+
+// updateHealthHistory 把快照副本加入DC的滑动窗口，超出窗口的旧快照被丢弃（调用方需持有fd.mu）
+func (fd *DCFailureDetector) updateHealthHistory(snapshot *DCHealthSnapshot) {
+	history := append(fd.healthHistory[snapshot.DataCenter], *snapshot)
+	if size := fd.healthHistorySize(); len(history) > size {
+		history = append(history[:0:0], history[len(history)-size:]...)
+	}
+	fd.healthHistory[snapshot.DataCenter] = history
 }
 
 func (fd *DCFailureDetector) logHealthStatus() {
@@ -749,6 +973,8 @@ func (fd *DCFailureDetector) calculateRecoveryImpact(snapshot *DCHealthSnapshot)
 func (fd *DCFailureDetector) getRecommendedAction(failureType FailureType) string {
 	// 实现推荐操作建议
 	switch failureType {
+	case Degrading:
+		return "关注DC健康趋势，提前准备故障转移"
 	case DCFailure:
 		return "立即启动故障转移，切换到备用DC"
 	case NetworkPartition:
@@ -764,21 +990,130 @@ func (fd *DCFailureDetector) getRecommendedAction(failureType FailureType) strin
 	}
 }
 
+// analyzeFailurePatterns 统计窗口内健康状态的抖动次数，频繁抖动说明网络不稳定（调用方需持有fd.mu读锁）
 func (fd *DCFailureDetector) analyzeFailurePatterns(dcID raft.DataCenterID, snapshot *DCHealthSnapshot) {
-	// 实现故障模式分析
+	history := fd.healthHistory[dcID]
+	flaps := 0
+	for i := 1; i < len(history); i++ {
+		if (history[i].UnhealthyNodes > 0) != (history[i-1].UnhealthyNodes > 0) {
+			flaps++
+		}
+	}
+
+	if flaps > 0 && fd.config.EnableDetailedLogging {
+		fd.logger.Warn("DC健康状态抖动", "target_dc", dcID, "snapshots", len(history), "flaps", flaps)
+	}
 }
 
+// predictPotentialFailures 最近的快照呈持续恶化趋势时发出低严重度的退化事件，趋势消失前不重复发出（调用方需持有fd.mu）
 func (fd *DCFailureDetector) predictPotentialFailures(dcID raft.DataCenterID, snapshot *DCHealthSnapshot) {
-	// 实现潜在故障预测
+	reason := fd.degradationTrend(dcID)
+	if reason == "" {
+		delete(fd.degradingDCs, dcID)
+		return
+	}
+	if fd.degradingDCs[dcID] {
+		return
+	}
+	fd.degradingDCs[dcID] = true
+
+	timestamp := fd.clock.Now()
+	event := &DCFailureEvent{
+		EventID:           fmt.Sprintf("degrading-%s-%d", dcID, timestamp.UnixNano()),
+		DataCenter:        dcID,
+		FailureType:       Degrading,
+		Severity:          1,
+		DetectedAt:        timestamp,
+		Description:       fmt.Sprintf("DC %s 健康退化: %s", dcID, reason),
+		AffectedNodes:     fd.getAffectedNodes(dcID),
+		EstimatedImpact:   fd.calculateFailureImpact(Degrading, snapshot),
+		RecommendedAction: fd.getRecommendedAction(Degrading),
+	}
+
+	fd.publishLocked(fd.failureQueue, event)
+}
+
+// degradationTrend 检查最近TrendMinSamples个快照的趋势，返回退化原因，没有退化时返回空串
+func (fd *DCFailureDetector) degradationTrend(dcID raft.DataCenterID) string {
+	samples := fd.config.TrendMinSamples
+	if samples < 2 {
+		samples = 2
+	}
+	history := fd.healthHistory[dcID]
+	if len(history) < samples {
+		return ""
+	}
+	window := history[len(history)-samples:]
+	first, last := window[0], window[len(window)-1]
+
+	// 健康节点比例单调下降
+	declining := healthyRatio(last) < healthyRatio(first)
+	for i := 1; i < len(window) && declining; i++ {
+		if healthyRatio(window[i]) > healthyRatio(window[i-1]) {
+			declining = false
+		}
+	}
+	if declining {
+		return fmt.Sprintf("健康节点比例在 %d 个快照内从 %.2f 降至 %.2f", samples, healthyRatio(first), healthyRatio(last))
+	}
+
+	// 平均延迟增长过快
+	if fd.config.LatencySlopeThreshold > 0 {
+		slope := (last.AverageLatency - first.AverageLatency) / time.Duration(samples-1)
+		if slope > fd.config.LatencySlopeThreshold {
+			return fmt.Sprintf("平均延迟每个快照增长 %v，从 %v 升至 %v", slope, first.AverageLatency, last.AverageLatency)
+		}
+	}
+
+	return ""
+}
+
+// healthyRatio 快照中健康节点的比例
+func healthyRatio(snapshot DCHealthSnapshot) float64 {
+	if snapshot.TotalNodes == 0 {
+		return 0
+	}
+	return float64(snapshot.HealthyNodes) / float64(snapshot.TotalNodes)
 }
 
 func (fd *DCFailureDetector) analyzeCrossDCFailureCorrelation() {
 	// 实现跨DC故障相关性分析
 }
 
+// queuedEvent 持有fd.mu时产生、等待入队的事件
+type queuedEvent struct {
+	queue *queue.Queue
+	event *DCFailureEvent
+}
+
+// publishLocked 记录待入队的事件，由flushEvents在释放fd.mu后入队，
+// 避免阻塞策略的队列在持有锁时等待需要同一把锁的消费者（调用方需持有fd.mu）
+func (fd *DCFailureDetector) publishLocked(q *queue.Queue, event *DCFailureEvent) {
+	fd.outbox = append(fd.outbox, queuedEvent{queue: q, event: event})
+}
+
+// flushEvents 把publishLocked记录的事件加入事件队列，队列满时按队列的溢出策略处理
+func (fd *DCFailureDetector) flushEvents() {
+	fd.mu.Lock()
+	pending := fd.outbox
+	fd.outbox = nil
+	fd.mu.Unlock()
+
+	for _, p := range pending {
+		if err := p.queue.Enqueue(p.event); err != nil {
+			fd.logger.Warn("事件队列入队失败，丢弃事件", "queue", p.queue.Stats().Name, "event_id", p.event.EventID, logging.FieldError, err)
+		}
+	}
+}
+
+// GetQueueStats 获取故障与恢复事件队列的统计
+func (fd *DCFailureDetector) GetQueueStats() []queue.Stats {
+	return []queue.Stats{fd.failureQueue.Stats(), fd.recoveryQueue.Stats()}
+}
+
 func (fd *DCFailureDetector) processFailureEvent(event *DCFailureEvent) {
 	// 实现故障事件处理
-	fd.logger.Printf("处理故障事件: %s - %s", event.EventID, event.Description)
+	fd.logger.Warn("处理故障事件", "event_id", event.EventID, "description", event.Description)
 
 	// 记录事件
 	fd.mu.Lock()
@@ -787,27 +1122,156 @@ func (fd *DCFailureDetector) processFailureEvent(event *DCFailureEvent) {
 
 	// 触发故障转移（如果需要）
 	if fd.ShouldTriggerFailover(event.DataCenter) {
-		fd.triggerFailover(event.DataCenter, event.FailureType)
+		fd.triggerFailover(event)
 	}
 }
 
 func (fd *DCFailureDetector) processRecoveryEvent(event *DCFailureEvent) {
 	// 实现恢复事件处理
-	fd.logger.Printf("处理恢复事件: %s - %s", event.EventID, event.Description)
+	fd.logger.Info("处理恢复事件", "event_id", event.EventID, "description", event.Description)
 }
 
+// isRoutingFailure 判断故障是否使DC无法承担请求，需要排除在路由之外
+func isRoutingFailure(failureType FailureType) bool {
+	return failureType == DCFailure || failureType == NetworkPartition
+}
+
+// excludeFromRouting 把故障DC移出读路由（调用方需持有fd.mu）
+func (fd *DCFailureDetector) excludeFromRouting(dcID raft.DataCenterID) {
+	fd.excludedDCs[dcID] = true
+	if fd.readWriteRouter == nil {
+		return
+	}
+	if err := fd.readWriteRouter.ExcludeDC(dcID); err != nil {
+		fd.logger.Error("排除DC失败", "target_dc", dcID, logging.FieldError, err)
+	}
+}
+
+// observeRecovery 记录恢复观察期内的一次健康检查结果（调用方需持有fd.mu）
+func (fd *DCFailureDetector) observeRecovery(status *DCRecoveryStatus, detected FailureType) {
+	status.Observations++
+	if detected == NoFailure {
+		status.HealthyObservations++
+		status.ConsecutiveHealthy++
+		return
+	}
+
+	if status.ConsecutiveHealthy > 0 {
+		status.Relapses++
+		fd.logger.Warn("DC恢复观察期内出现故障，重新计数", "target_dc", status.DataCenter, "failure_type", fd.failureTypeString(detected))
+	}
+	status.ConsecutiveHealthy = 0
+}
+
+// monitorRecoveryProgress 连续健康次数达到MinRecoveryObservations且置信度达到RecoveryConfidenceThreshold的DC视为稳定恢复，
+// 重新加入读路由并通知恢复订阅者
 func (fd *DCFailureDetector) monitorRecoveryProgress() {
-	// 实现恢复进度监控
+	required := fd.config.MinRecoveryObservations
+	if required < 1 {
+		required = 1
+	}
+
+	fd.mu.Lock()
+	var stable []*DCFailureEvent
+	for dcID, status := range fd.recoveringDCs {
+		if status.ConsecutiveHealthy < required || status.Confidence() < fd.config.RecoveryConfidenceThreshold {
+			continue
+		}
+
+		delete(fd.recoveringDCs, dcID)
+		delete(fd.excludedDCs, dcID)
+
+		timestamp := fd.clock.Now()
+		stable = append(stable, &DCFailureEvent{
+			EventID:     fmt.Sprintf("stable-%s-%d", dcID, timestamp.UnixNano()),
+			DataCenter:  dcID,
+			FailureType: NoFailure,
+			Severity:    1,
+			DetectedAt:  timestamp,
+			Description: fmt.Sprintf("DC %s 已稳定恢复: 观察 %d 次, 连续健康 %d 次, 置信度 %.2f, 反复 %d 次",
+				dcID, status.Observations, status.ConsecutiveHealthy, status.Confidence(), status.Relapses),
+			AffectedNodes:     fd.getAffectedNodes(dcID),
+			RecommendedAction: "恢复DC流量",
+		})
+	}
+	subscribers := fd.recoverySubscribers
+	fd.mu.Unlock()
+
+	for _, event := range stable {
+		fd.logger.Info(event.Description, "event_id", event.EventID, "target_dc", event.DataCenter)
+
+		if fd.readWriteRouter != nil {
+			if err := fd.readWriteRouter.RestoreDC(event.DataCenter); err != nil {
+				fd.logger.Error("恢复DC路由失败", "target_dc", event.DataCenter, logging.FieldError, err)
+			}
+		}
+
+		for _, ch := range subscribers {
+			select {
+			case ch <- event:
+			default:
+				fd.logger.Warn("恢复订阅者通道已满，丢弃事件", "event_id", event.EventID)
+			}
+		}
+	}
+}
+
+// GetRecoveryStatus 获取处于恢复观察期的DC状态
+func (fd *DCFailureDetector) GetRecoveryStatus() map[raft.DataCenterID]DCRecoveryStatus {
+	fd.mu.RLock()
+	defer fd.mu.RUnlock()
+
+	result := make(map[raft.DataCenterID]DCRecoveryStatus, len(fd.recoveringDCs))
+	for dcID, status := range fd.recoveringDCs {
+		result[dcID] = *status
+	}
+	return result
+}
+
+// SubscribeRecovery 订阅DC稳定恢复事件，返回的通道在DC重新加入路由后收到事件
+func (fd *DCFailureDetector) SubscribeRecovery() <-chan *DCFailureEvent {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	ch := make(chan *DCFailureEvent, 16)
+	fd.recoverySubscribers = append(fd.recoverySubscribers, ch)
+	return ch
 }
 
-func (fd *DCFailureDetector) triggerFailover(dcID raft.DataCenterID, failureType FailureType) {
-	// 实现故障转移触发逻辑
-	fd.logger.Printf("触发故障转移: DC=%s, 故障类型=%s", dcID, fd.failureTypeString(failureType))
+func (fd *DCFailureDetector) triggerFailover(event *DCFailureEvent) {
+	fd.logger.Warn("触发故障转移", "target_dc", event.DataCenter, "failure_type", fd.failureTypeString(event.FailureType))
 
 	fd.mu.Lock()
 	fd.failoverInProgress = true
-	fd.lastFailoverTime = time.Now()
+	fd.lastFailoverTime = fd.clock.Now()
+	subscribers := fd.subscribers
 	fd.mu.Unlock()
 
-	// 这里会集成到故障转移协调器
+	// 通知故障转移协调器等订阅者
+	for _, ch := range subscribers {
+		select {
+		case ch <- event:
+		default:
+			fd.logger.Warn("订阅者通道已满，丢弃故障事件", "event_id", event.EventID)
+		}
+	}
+}
+
+// Subscribe 订阅需要故障转移的故障事件，每次触发故障转移时订阅者收到对应事件
+// 订阅者处理完故障转移后应调用MarkFailoverComplete，否则不会再触发新的故障转移
+func (fd *DCFailureDetector) Subscribe() <-chan *DCFailureEvent {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	ch := make(chan *DCFailureEvent, 16)
+	fd.subscribers = append(fd.subscribers, ch)
+	return ch
+}
+
+// MarkFailoverComplete 标记故障转移已结束，之后的故障可以再次触发故障转移
+func (fd *DCFailureDetector) MarkFailoverComplete() {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	fd.failoverInProgress = false
 }
@@ -0,0 +1,303 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-4 17:05:26
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-4 17:05:26
+* @Description: ConcordKV DC故障检测器健康历史、趋势预测与恢复监控测试
+ */
+package replication_test
+
+import (
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/replication"
+)
+
+// newScriptedDetector 创建只接收手动快照的检测器，周期性健康检查不会产生快照，router可以为nil
+func newScriptedDetector(t *testing.T, router *replication.ReadWriteRouter, configure func(*replication.DCFailureDetectorConfig)) *replication.DCFailureDetector {
+	t.Helper()
+
+	config := replication.DefaultDCFailureDetectorConfig()
+	config.HealthCheckInterval = 20 * time.Millisecond
+	config.EnableFailoverTrigger = false
+	if configure != nil {
+		configure(config)
+	}
+
+	detector := replication.NewDCFailureDetector("n1", config, nil, router, nil)
+	if err := detector.Start(); err != nil {
+		t.Fatalf("启动故障检测器失败: %v", err)
+	}
+	t.Cleanup(func() { detector.Stop() })
+	return detector
+}
+
+// scriptedSnapshot 构造共4个节点、指定健康节点数和平均延迟的快照
+func scriptedSnapshot(dcID raft.DataCenterID, healthy int, latency time.Duration) replication.DCHealthSnapshot {
+	return replication.DCHealthSnapshot{
+		DataCenter:     dcID,
+		TotalNodes:     4,
+		HealthyNodes:   healthy,
+		UnhealthyNodes: 4 - healthy,
+		AverageLatency: latency,
+	}
+}
+
+// failureEventsOfType 等待一段时间后返回指定类型的已处理事件
+func failureEventsOfType(detector *replication.DCFailureDetector, failureType replication.FailureType) []replication.DCFailureEvent {
+	time.Sleep(50 * time.Millisecond)
+
+	var events []replication.DCFailureEvent
+	for _, event := range detector.GetFailureEvents() {
+		if event.FailureType == failureType {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// TestDetectorSuppressesSingleBlip 单个故障快照不会改变DC的故障状态
+func TestDetectorSuppressesSingleBlip(t *testing.T) {
+	detector := newScriptedDetector(t, nil, nil)
+
+	for _, healthy := range []int{4, 4, 0, 4, 4, 0, 0, 4} {
+		detector.RecordHealthSnapshot(scriptedSnapshot("dc2", healthy, 10*time.Millisecond))
+	}
+
+	if failure := detector.GetCurrentFailures()["dc2"]; failure != replication.NoFailure {
+		t.Fatalf("短暂波动后故障状态 = %v，期望NoFailure", failure)
+	}
+	if events := failureEventsOfType(detector, replication.DCFailure); len(events) != 0 {
+		t.Fatalf("短暂波动产生了 %d 个故障事件", len(events))
+	}
+}
+
+// TestDetectorConfirmsSustainedFailure 连续MaxConsecutiveFailures个故障快照后才切换状态，恢复同样需要确认
+func TestDetectorConfirmsSustainedFailure(t *testing.T) {
+	detector := newScriptedDetector(t, nil, nil)
+
+	for i := 0; i < 2; i++ {
+		detector.RecordHealthSnapshot(scriptedSnapshot("dc2", 0, 10*time.Millisecond))
+	}
+	if failure := detector.GetCurrentFailures()["dc2"]; failure != replication.NoFailure {
+		t.Fatalf("未达到确认次数时故障状态 = %v", failure)
+	}
+
+	detector.RecordHealthSnapshot(scriptedSnapshot("dc2", 0, 10*time.Millisecond))
+	if failure := detector.GetCurrentFailures()["dc2"]; failure != replication.DCFailure {
+		t.Fatalf("持续故障后状态 = %v，期望DCFailure", failure)
+	}
+	if events := failureEventsOfType(detector, replication.DCFailure); len(events) != 1 {
+		t.Fatalf("DCFailure事件数 = %d，期望1", len(events))
+	}
+
+	// 单个健康快照不足以认定恢复
+	detector.RecordHealthSnapshot(scriptedSnapshot("dc2", 4, 10*time.Millisecond))
+	if failure := detector.GetCurrentFailures()["dc2"]; failure != replication.DCFailure {
+		t.Fatalf("单个健康快照后状态 = %v，期望仍为DCFailure", failure)
+	}
+	for i := 0; i < 2; i++ {
+		detector.RecordHealthSnapshot(scriptedSnapshot("dc2", 4, 10*time.Millisecond))
+	}
+	if failure := detector.GetCurrentFailures()["dc2"]; failure != replication.NoFailure {
+		t.Fatalf("持续健康后状态 = %v，期望NoFailure", failure)
+	}
+}
+
+// TestDetectorEmitsDegradingOnLatencyTrend 延迟持续上升时在达到故障阈值前发出一次退化事件
+func TestDetectorEmitsDegradingOnLatencyTrend(t *testing.T) {
+	detector := newScriptedDetector(t, nil, nil)
+
+	for _, latency := range []time.Duration{10, 40, 70, 100, 130, 160, 190} {
+		detector.RecordHealthSnapshot(scriptedSnapshot("dc2", 4, latency*time.Millisecond))
+	}
+
+	events := failureEventsOfType(detector, replication.Degrading)
+	if len(events) != 1 {
+		t.Fatalf("退化事件数 = %d，期望1", len(events))
+	}
+	if events[0].DataCenter != "dc2" || events[0].Severity != 1 {
+		t.Fatalf("退化事件 = %+v", events[0])
+	}
+	if failure := detector.GetCurrentFailures()["dc2"]; failure != replication.NoFailure {
+		t.Fatalf("退化事件不应改变故障状态，当前 = %v", failure)
+	}
+}
+
+// TestDetectorEmitsDegradingOnHealthyRatioDecline 健康节点比例单调下降时发出退化事件
+func TestDetectorEmitsDegradingOnHealthyRatioDecline(t *testing.T) {
+	detector := newScriptedDetector(t, nil, func(config *replication.DCFailureDetectorConfig) {
+		config.PartitionDetectionRatio = 0.2
+	})
+
+	for _, healthy := range []int{4, 4, 4, 3, 3, 2} {
+		detector.RecordHealthSnapshot(scriptedSnapshot("dc2", healthy, 10*time.Millisecond))
+	}
+
+	if events := failureEventsOfType(detector, replication.Degrading); len(events) != 1 {
+		t.Fatalf("退化事件数 = %d，期望1", len(events))
+	}
+}
+
+// TestDetectorStableHistoryHasNoDegrading 健康平稳时不发出退化事件
+func TestDetectorStableHistoryHasNoDegrading(t *testing.T) {
+	detector := newScriptedDetector(t, nil, nil)
+
+	for _, latency := range []time.Duration{30, 10, 30, 10, 30, 10, 30} {
+		detector.RecordHealthSnapshot(scriptedSnapshot("dc2", 4, latency*time.Millisecond))
+	}
+
+	if events := failureEventsOfType(detector, replication.Degrading); len(events) != 0 {
+		t.Fatalf("平稳历史产生了 %d 个退化事件", len(events))
+	}
+}
+
+// TestDetectorHealthHistoryIsBounded 健康历史只保留窗口内最新的快照
+func TestDetectorHealthHistoryIsBounded(t *testing.T) {
+	detector := newScriptedDetector(t, nil, func(config *replication.DCFailureDetectorConfig) {
+		config.HealthHistorySize = 5
+	})
+
+	for i := 1; i <= 12; i++ {
+		detector.RecordHealthSnapshot(scriptedSnapshot("dc2", 4, time.Duration(i)*time.Millisecond))
+	}
+
+	history := detector.GetHealthHistory("dc2")
+	if len(history) != 5 {
+		t.Fatalf("健康历史长度 = %d，期望5", len(history))
+	}
+	for i, snapshot := range history {
+		if want := time.Duration(8+i) * time.Millisecond; snapshot.AverageLatency != want {
+			t.Fatalf("history[%d].AverageLatency = %v，期望 %v", i, snapshot.AverageLatency, want)
+		}
+	}
+	if other := detector.GetHealthHistory("dc3"); len(other) != 0 {
+		t.Fatalf("未知DC的健康历史长度 = %d", len(other))
+	}
+}
+
+// newRecoveryTestRouter 创建本地DC为dc1、主DC为dc2、另有dc3的路由器
+func newRecoveryTestRouter() *replication.ReadWriteRouter {
+	raftConfig := &raft.Config{
+		NodeID: "n1",
+		Servers: []raft.Server{
+			{ID: "n1", DataCenter: "dc1"},
+			{ID: "n2", DataCenter: "dc2"},
+			{ID: "n3", DataCenter: "dc3"},
+		},
+		MultiDC: &raft.MultiDCConfig{
+			Enabled:         true,
+			LocalDataCenter: &raft.DataCenterConfig{ID: "dc1"},
+		},
+	}
+
+	config := replication.DefaultReadWriteRouterConfig()
+	config.PrimaryDC = "dc2"
+	return replication.NewReadWriteRouterWithConfig("n1", config, raftConfig)
+}
+
+// recordSnapshots 依次提交n个dc3快照
+func recordSnapshots(detector *replication.DCFailureDetector, healthy, n int) {
+	for i := 0; i < n; i++ {
+		detector.RecordHealthSnapshot(scriptedSnapshot("dc3", healthy, 10*time.Millisecond))
+	}
+}
+
+// waitReadReplica 等待dc3的读路由状态变为want
+func waitReadReplica(t *testing.T, router *replication.ReadWriteRouter, want bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for router.IsReadReplica("dc3") != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待dc3读路由状态变为 %t 超时", want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestDetectorRestoresDCAfterStableRecovery 故障DC恢复后先观察，反复故障时保持排除，稳定后才重新加入读路由
+func TestDetectorRestoresDCAfterStableRecovery(t *testing.T) {
+	router := newRecoveryTestRouter()
+	detector := newScriptedDetector(t, router, func(config *replication.DCFailureDetectorConfig) {
+		config.RecoveryCheckInterval = 10 * time.Millisecond
+		config.MinRecoveryObservations = 4
+	})
+	recovered := detector.SubscribeRecovery()
+
+	if !router.IsReadReplica("dc3") {
+		t.Fatalf("初始时dc3应是读副本")
+	}
+
+	// 故障确认后排除在读路由之外
+	recordSnapshots(detector, 0, 3)
+	if router.IsReadReplica("dc3") {
+		t.Fatalf("dc3故障后仍是读副本")
+	}
+
+	// 恢复后在观察期内再次故障
+	recordSnapshots(detector, 4, 3)
+	if _, recovering := detector.GetRecoveryStatus()["dc3"]; !recovering {
+		t.Fatalf("dc3恢复后应进入观察期")
+	}
+	recordSnapshots(detector, 0, 3)
+	if _, recovering := detector.GetRecoveryStatus()["dc3"]; recovering {
+		t.Fatalf("dc3再次故障后不应处于观察期")
+	}
+
+	// 再次恢复，连续健康次数未达到MinRecoveryObservations时保持排除
+	recordSnapshots(detector, 4, 3)
+	recordSnapshots(detector, 4, 2)
+	time.Sleep(50 * time.Millisecond)
+	if router.IsReadReplica("dc3") {
+		t.Fatalf("观察期未结束时dc3不应恢复读路由")
+	}
+
+	recordSnapshots(detector, 4, 1)
+	waitReadReplica(t, router, true)
+
+	select {
+	case event := <-recovered:
+		if event.DataCenter != "dc3" {
+			t.Fatalf("恢复事件DC = %s，期望dc3", event.DataCenter)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("未收到dc3的恢复事件")
+	}
+	if _, recovering := detector.GetRecoveryStatus()["dc3"]; recovering {
+		t.Fatalf("dc3稳定后不应处于观察期")
+	}
+}
+
+// TestDetectorRecoveryRequiresConfidence 观察期内的短暂异常降低置信度，需要更多健康检查才能恢复
+func TestDetectorRecoveryRequiresConfidence(t *testing.T) {
+	router := newRecoveryTestRouter()
+	detector := newScriptedDetector(t, router, func(config *replication.DCFailureDetectorConfig) {
+		config.RecoveryCheckInterval = 10 * time.Millisecond
+		config.MinRecoveryObservations = 2
+		config.RecoveryConfidenceThreshold = 0.8
+	})
+
+	recordSnapshots(detector, 0, 3)
+	recordSnapshots(detector, 4, 3)
+
+	// 单个异常快照不会再次确认故障，但计入观察期
+	recordSnapshots(detector, 0, 1)
+	recordSnapshots(detector, 4, 2)
+	time.Sleep(50 * time.Millisecond)
+	status, recovering := detector.GetRecoveryStatus()["dc3"]
+	if !recovering {
+		t.Fatalf("置信度 3/4 时dc3应仍处于观察期")
+	}
+	if status.Relapses != 1 || status.ConsecutiveHealthy != 2 {
+		t.Fatalf("观察状态 = %+v，期望反复1次、连续健康2次", status)
+	}
+	if router.IsReadReplica("dc3") {
+		t.Fatalf("置信度不足时dc3不应恢复读路由")
+	}
+
+	recordSnapshots(detector, 4, 1)
+	waitReadReplica(t, router, true)
+}
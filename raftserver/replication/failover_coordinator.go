@@ -10,11 +10,14 @@ package replication
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"sync"
 	"time"
 
+	"raftserver/clock"
+	"raftserver/logging"
+	"raftserver/queue"
 	"raftserver/raft"
 )
 
@@ -32,6 +35,12 @@ type FailoverCoordinatorConfig struct {
 	CooldownPeriodMs     int     `json:"cooldownPeriodMs"`
 	FailbackDelayMs      int     `json:"failbackDelayMs"`
 
+	// AutoFailbackEnabled 原主DC稳定恢复FailbackDelayMs后自动切回
+	AutoFailbackEnabled bool `json:"autoFailbackEnabled"`
+
+	// StateFilePath 非空时每次故障转移结束后把最近的故障转移记录写入该文件，重启后据此恢复冷却期和频率限制
+	StateFilePath string `json:"stateFilePath"`
+
 	// 数据一致性要求
 	RequireDataConsistency    bool  `json:"requireDataConsistency"`
 	MaxDataLossThreshold      int64 `json:"maxDataLossThreshold"` // 最大可接受数据丢失条目数
@@ -42,6 +51,15 @@ type FailoverCoordinatorConfig struct {
 	EnableFailoverMetrics bool     `json:"enableFailoverMetrics"`
 	AlertOnFailover       bool     `json:"alertOnFailover"`
 	NotificationChannels  []string `json:"notificationChannels"`
+
+	// FailureEventQueue 待处理故障事件的队列，默认容量100，同一DC的同类事件合并（coalesce）
+	FailureEventQueue queue.Config `json:"failureEventQueue"`
+
+	// Logger 协调器使用的日志，为nil时使用logging.Default()
+	Logger logging.Logger `json:"-"`
+
+	// Clock 协调器计时使用的时钟，为nil时使用真实时钟，测试中注入testutil.FakeClock
+	Clock clock.Clock `json:"-"`
 }
 
 // DefaultFailoverCoordinatorConfig 默认配置
@@ -62,9 +80,21 @@ func DefaultFailoverCoordinatorConfig() *FailoverCoordinatorConfig {
 		EnableFailoverMetrics:      true,
 		AlertOnFailover:            true,
 		NotificationChannels:       []string{"log", "metrics"},
+		FailureEventQueue:          defaultFailureEventQueueConfig,
 	}
 }
 
+// ErrDecisionNotFound 待确认的决策不存在，可能已被处理或已过期
+var ErrDecisionNotFound = errors.New("待确认的故障转移决策不存在")
+
+// 决策状态
+const (
+	DecisionPending  = "Pending"
+	DecisionApproved = "Approved"
+	DecisionRejected = "Rejected"
+	DecisionExpired  = "Expired"
+)
+
 // FailoverStrategy 故障转移策略
 type FailoverStrategy int
 
@@ -113,10 +143,12 @@ type FailoverOperation struct {
 	FailedDC      raft.DataCenterID
 	FailureType   FailureType
 	TriggerReason string
+	IsFailback    bool // 切回已恢复的原主DC，此时FailedDC为空
 
 	// 目标信息
-	TargetDC  raft.DataCenterID
-	BackupDCs []raft.DataCenterID
+	TargetDC          raft.DataCenterID
+	BackupDCs         []raft.DataCenterID
+	PreviousPrimaryDC raft.DataCenterID // 故障转移前的主DC，回滚时恢复
 
 	// 执行阶段
 	CurrentPhase FailoverPhase
@@ -129,15 +161,34 @@ type FailoverOperation struct {
 	DataLossCount        int64
 	ConsistencyVerified  bool
 
-	// 性能指标
-	FailoverLatency   time.Duration
+	// 性能指标：停机时间从故障DC被判定故障（DetectedAt）计到验证阶段确认新主DC可用，
+	// 客户端影响为同一窗口内路由失败的请求数
+	DetectedAt        time.Time
+	FailoverLatency   time.Duration // 操作开始到新主DC可用
 	ServiceDowntime   time.Duration
-	RecoveryTime      time.Duration
+	RecoveryTime      time.Duration // DetectedAt到操作完成
 	ClientImpactCount int64
 
+	// 故障被处理时读写路由器的累计路由失败数
+	failedRoutesBaseline int64
+
 	// 错误和警告
 	Errors   []string
 	Warnings []string
+
+	// 回滚栈，修改了系统状态的阶段登记对应的撤销操作
+	undoStack []failoverUndo
+}
+
+// failoverUndo 阶段登记的撤销操作
+type failoverUndo struct {
+	description string
+	undo        func() error
+}
+
+// registerUndo 登记撤销操作，回滚时按登记的逆序执行
+func (op *FailoverOperation) registerUndo(description string, undo func() error) {
+	op.undoStack = append(op.undoStack, failoverUndo{description: description, undo: undo})
 }
 
 // PhaseRecord 阶段记录
@@ -155,6 +206,7 @@ type PhaseRecord struct {
 // FailoverDecision 故障转移决策
 type FailoverDecision struct {
 	// 决策信息
+	ID             string
 	ShouldFailover bool
 	Strategy       FailoverStrategy
 	TargetDC       raft.DataCenterID
@@ -172,6 +224,15 @@ type FailoverDecision struct {
 	ExpectedDowntime time.Duration
 	DataLossRisk     float64
 	ImpactAssessment string
+
+	// 人工确认
+	Status       string    // 需要人工确认时为Pending，之后为Approved、Rejected或Expired
+	ExpiresAt    time.Time // 超过该时间仍未确认的决策自动过期
+	RejectReason string
+	ResolvedAt   time.Time
+
+	// 处理故障事件时读写路由器的累计路由失败数，等待确认期间失败的请求也计入客户端影响
+	failedRoutesBaseline int64
 }
 
 // LoadMetrics 负载指标
@@ -190,7 +251,8 @@ type FailoverCoordinator struct {
 	// 基础配置
 	nodeID raft.NodeID
 	config *FailoverCoordinatorConfig
-	logger *log.Logger
+	logger logging.Logger
+	clock  clock.Clock
 
 	// 集成组件
 	failureDetector     *DCFailureDetector
@@ -198,24 +260,31 @@ type FailoverCoordinator struct {
 	readWriteRouter     *ReadWriteRouter
 	asyncReplicator     *AsyncReplicator
 
+	// 验证阶段的附加检查，例如向新主DC发送探测写请求
+	verifier func(operation *FailoverOperation) error
+
 	// 故障转移状态
 	currentOperation *FailoverOperation
 	operationHistory []*FailoverOperation
-	lastFailoverTime time.Time
+	lastFailoverTime time.Time // 冷却期由它推算
 	failoverCount    int
-	isInCooldown     bool
 
 	// 决策引擎状态
 	pendingDecisions []*FailoverDecision
 	decisionHistory  []*FailoverDecision
 	manualOverride   bool
+	decisionSeq      int64
 
 	// 监控统计
 	totalFailovers      int64
 	successfulFailovers int64
 	failedFailovers     int64
-	averageFailoverTime time.Duration
+	rollbacks           int64
+	failedRollbacks     int64
+	averageFailoverTime time.Duration // 成功完成的操作的平均耗时
 	totalDowntime       time.Duration
+	totalClientImpact   int64
+	suppressedFailovers int64 // 冷却期内跳过或决策不执行故障转移的故障事件数
 
 	// 控制流
 	ctx     context.Context
@@ -225,9 +294,11 @@ type FailoverCoordinator struct {
 	stopCh  chan struct{}
 
 	// 事件通道
-	failureEventCh chan *DCFailureEvent
-	decisionCh     chan *FailoverDecision
-	operationCh    chan *FailoverOperation
+	failureQueue         *queue.Queue
+	decisionCh           chan *FailoverDecision
+	operationCh          chan *FailoverOperation
+	failureSubscription  <-chan *DCFailureEvent // 故障检测器触发故障转移时推送的事件
+	recoverySubscription <-chan *DCFailureEvent // 故障检测器确认DC稳定恢复时推送的事件
 }
 
 // NewFailoverCoordinator 创建故障转移协调器
@@ -248,7 +319,8 @@ func NewFailoverCoordinator(
 	coordinator := &FailoverCoordinator{
 		nodeID:              nodeID,
 		config:              config,
-		logger:              log.New(log.Writer(), "[failover-coordinator] ", log.LstdFlags),
+		logger:              logging.Component(config.Logger, "failover-coordinator", logging.FieldNodeID, string(nodeID)),
+		clock:               clock.Or(config.Clock),
 		failureDetector:     failureDetector,
 		consistencyRecovery: consistencyRecovery,
 		readWriteRouter:     readWriteRouter,
@@ -258,35 +330,38 @@ func NewFailoverCoordinator(
 		pendingDecisions: make([]*FailoverDecision, 0),
 		decisionHistory:  make([]*FailoverDecision, 0),
 
-		ctx:            ctx,
-		cancel:         cancel,
-		stopCh:         make(chan struct{}),
-		failureEventCh: make(chan *DCFailureEvent, 100),
-		decisionCh:     make(chan *FailoverDecision, 50),
-		operationCh:    make(chan *FailoverOperation, 50),
+		ctx:    ctx,
+		cancel: cancel,
+		stopCh: make(chan struct{}),
+		failureQueue: queue.New("failover-failure-events",
+			config.FailureEventQueue.WithDefaults(defaultFailureEventQueueConfig), failureEventQueueOptions),
+		decisionCh:  make(chan *FailoverDecision, 50),
+		operationCh: make(chan *FailoverOperation, 50),
 	}
 
 	coordinator.initializeComponents()
+	coordinator.loadPersistedState()
 	return coordinator
 }
 
 // initializeComponents 初始化组件
 func (fc *FailoverCoordinator) initializeComponents() {
-	fc.logger.Printf("初始化故障转移协调器")
+	fc.logger.Info("初始化故障转移协调器")
 
 	// 初始化决策引擎
 	fc.initializeDecisionEngine()
 
-	// 设置故障检测器的故障转移触发回调
+	// 订阅故障检测器触发的故障转移事件
 	if fc.failureDetector != nil {
-		// 这里可以设置回调函数，当故障检测器检测到需要故障转移时调用
+		fc.failureSubscription = fc.failureDetector.Subscribe()
+		fc.recoverySubscription = fc.failureDetector.SubscribeRecovery()
 	}
 }
 
 // initializeDecisionEngine 初始化决策引擎
 func (fc *FailoverCoordinator) initializeDecisionEngine() {
 	// 初始化决策引擎的规则和阈值
-	fc.logger.Printf("初始化故障转移决策引擎")
+	fc.logger.Debug("初始化故障转移决策引擎")
 }
 
 // Start 启动故障转移协调器
@@ -298,7 +373,7 @@ func (fc *FailoverCoordinator) Start() error {
 		return fmt.Errorf("故障转移协调器已在运行")
 	}
 
-	fc.logger.Printf("启动故障转移协调器")
+	fc.logger.Info("启动故障转移协调器")
 
 	// 启动各个工作循环
 	fc.wg.Add(4)
@@ -307,6 +382,11 @@ func (fc *FailoverCoordinator) Start() error {
 	go fc.operationExecutionLoop()
 	go fc.monitoringLoop()
 
+	if fc.failureSubscription != nil || fc.recoverySubscription != nil {
+		fc.wg.Add(1)
+		go fc.subscriptionLoop()
+	}
+
 	fc.running = true
 	return nil
 }
@@ -321,7 +401,7 @@ func (fc *FailoverCoordinator) Stop() error {
 	}
 	fc.mu.RUnlock()
 
-	fc.logger.Printf("停止故障转移协调器")
+	fc.logger.Info("停止故障转移协调器")
 
 	// 2. 先取消context
 	fc.cancel()
@@ -338,27 +418,47 @@ func (fc *FailoverCoordinator) Stop() error {
 	fc.mu.Unlock()
 
 	// 6. 最后关闭通道
-	close(fc.failureEventCh)
+	fc.failureQueue.Close()
 	close(fc.decisionCh)
 	close(fc.operationCh)
 
-	fc.logger.Printf("故障转移协调器已停止")
+	fc.logger.Info("故障转移协调器已停止")
 	return nil
 }
 
 // eventProcessingLoop 事件处理循环
 func (fc *FailoverCoordinator) eventProcessingLoop() {
 	defer fc.wg.Done()
-	fc.logger.Printf("事件处理循环已启动")
+	fc.logger.Debug("事件处理循环已启动")
+
+	for {
+		select {
+		case <-fc.failureQueue.Ready():
+			if event, ok := fc.failureQueue.TryDequeue(); ok {
+				fc.processFailureEvent(event.(*DCFailureEvent))
+			}
+		case <-fc.stopCh:
+			fc.logger.Debug("事件处理循环已停止")
+			return
+		}
+	}
+}
+
+// subscriptionLoop 把故障检测器推送的事件转入事件处理循环，DC恢复事件用于安排切回
+func (fc *FailoverCoordinator) subscriptionLoop() {
+	defer fc.wg.Done()
 
 	for {
 		select {
-		case event := <-fc.failureEventCh:
-			if event != nil {
-				fc.processFailureEvent(event)
+		case event := <-fc.recoverySubscription:
+			fc.logger.Info("收到DC恢复事件", "event_id", event.EventID)
+			fc.scheduleFailback(event.DataCenter)
+		case event := <-fc.failureSubscription:
+			fc.logger.Info("收到故障检测器事件", "event_id", event.EventID)
+			if err := fc.failureQueue.Enqueue(event); err != nil {
+				fc.logger.Warn("故障事件入队失败，丢弃事件", "event_id", event.EventID, logging.FieldError, err)
 			}
 		case <-fc.stopCh:
-			fc.logger.Printf("事件处理循环已停止")
 			return
 		}
 	}
@@ -366,20 +466,22 @@ func (fc *FailoverCoordinator) eventProcessingLoop() {
 
 // processFailureEvent 处理故障事件
 func (fc *FailoverCoordinator) processFailureEvent(event *DCFailureEvent) {
-	fc.logger.Printf("处理故障事件: %s - %s", event.EventID, event.Description)
+	fc.logger.Info("处理故障事件", "event_id", event.EventID, "description", event.Description)
 
 	// 如果当前正在执行故障转移，跳过
 	fc.mu.RLock()
 	if fc.currentOperation != nil {
 		fc.mu.RUnlock()
-		fc.logger.Printf("当前正在执行故障转移，跳过事件: %s", event.EventID)
+		fc.logger.Info("当前正在执行故障转移，跳过事件", "event_id", event.EventID)
 		return
 	}
 	fc.mu.RUnlock()
 
 	// 检查冷却期
 	if fc.isInCooldownPeriod() {
-		fc.logger.Printf("在冷却期内，跳过故障转移: %s", event.EventID)
+		fc.logger.Info("在冷却期内，跳过故障转移", "event_id", event.EventID)
+		fc.recordSuppressed()
+		fc.releaseFailureDetector()
 		return
 	}
 
@@ -389,23 +491,39 @@ func (fc *FailoverCoordinator) processFailureEvent(event *DCFailureEvent) {
 	if decision.ShouldFailover {
 		select {
 		case fc.decisionCh <- decision:
-			fc.logger.Printf("故障转移决策已创建: 目标DC=%s, 置信度=%.2f",
-				decision.TargetDC, decision.Confidence)
+			fc.logger.Info("故障转移决策已创建", "target_dc", decision.TargetDC, "confidence", decision.Confidence)
 		default:
-			fc.logger.Printf("决策通道已满，丢弃决策")
+			fc.logger.Warn("决策通道已满，丢弃决策")
 		}
 	} else {
-		fc.logger.Printf("决策不执行故障转移: 置信度=%.2f", decision.Confidence)
+		fc.logger.Info("决策不执行故障转移", "confidence", decision.Confidence)
+		fc.recordSuppressed()
+		fc.releaseFailureDetector()
 	}
 }
 
+// recordSuppressed 记录一个没有引发故障转移的故障事件
+func (fc *FailoverCoordinator) recordSuppressed() {
+	fc.mu.Lock()
+	fc.suppressedFailovers++
+	fc.mu.Unlock()
+}
+
 // makeFailoverDecision 制定故障转移决策
 func (fc *FailoverCoordinator) makeFailoverDecision(event *DCFailureEvent) *FailoverDecision {
+	fc.mu.Lock()
+	fc.decisionSeq++
+	id := fmt.Sprintf("decision-%d-%d", fc.clock.Now().Unix(), fc.decisionSeq)
+	fc.mu.Unlock()
+
 	decision := &FailoverDecision{
-		DecisionTime:    time.Now(),
+		ID:              id,
+		DecisionTime:    fc.clock.Now(),
 		FailureEvidence: []*DCFailureEvent{event},
 		HealthMetrics:   make(map[raft.DataCenterID]*DCHealthSnapshot),
 		LoadMetrics:     make(map[raft.DataCenterID]*LoadMetrics),
+
+		failedRoutesBaseline: fc.failedRoutes(),
 	}
 
 	// 收集健康指标
@@ -492,7 +610,7 @@ func (fc *FailoverCoordinator) executeDecisionLogic(decision *FailoverDecision,
 // decisionMakingLoop 决策制定循环
 func (fc *FailoverCoordinator) decisionMakingLoop() {
 	defer fc.wg.Done()
-	fc.logger.Printf("决策制定循环已启动")
+	fc.logger.Debug("决策制定循环已启动")
 
 	for {
 		select {
@@ -501,7 +619,7 @@ func (fc *FailoverCoordinator) decisionMakingLoop() {
 				fc.processDecision(decision)
 			}
 		case <-fc.stopCh:
-			fc.logger.Printf("决策制定循环已停止")
+			fc.logger.Debug("决策制定循环已停止")
 			return
 		}
 	}
@@ -509,21 +627,23 @@ func (fc *FailoverCoordinator) decisionMakingLoop() {
 
 // processDecision 处理决策
 func (fc *FailoverCoordinator) processDecision(decision *FailoverDecision) {
-	fc.logger.Printf("处理故障转移决策: 应该故障转移=%t, 目标DC=%s",
-		decision.ShouldFailover, decision.TargetDC)
+	fc.logger.Info("处理故障转移决策", "should_failover", decision.ShouldFailover, "target_dc", decision.TargetDC)
 
 	fc.mu.Lock()
 	fc.decisionHistory = append(fc.decisionHistory, decision)
+	manualOverride := fc.manualOverride
 	fc.mu.Unlock()
 
 	if !decision.ShouldFailover {
 		return
 	}
 
-	// 如果需要手动确认
-	if fc.config.ManualConfirmationRequired && !fc.manualOverride {
-		fc.logger.Printf("需要手动确认故障转移，等待确认...")
+	// 如果需要手动确认，等待ApproveDecision或RejectDecision
+	if fc.config.ManualConfirmationRequired && !manualOverride {
+		fc.logger.Warn("需要手动确认故障转移，等待确认", "decision_id", decision.ID)
 		fc.mu.Lock()
+		decision.Status = DecisionPending
+		decision.ExpiresAt = decision.DecisionTime.Add(time.Duration(fc.config.FailoverTimeoutMs) * time.Millisecond)
 		fc.pendingDecisions = append(fc.pendingDecisions, decision)
 		fc.mu.Unlock()
 		return
@@ -534,27 +654,34 @@ func (fc *FailoverCoordinator) processDecision(decision *FailoverDecision) {
 
 	select {
 	case fc.operationCh <- operation:
-		fc.logger.Printf("故障转移操作已创建: %s", operation.ID)
+		fc.logger.Info("故障转移操作已创建", "operation_id", operation.ID)
 	default:
-		fc.logger.Printf("操作通道已满，丢弃操作")
+		fc.logger.Warn("操作通道已满，丢弃操作")
 	}
 }
 
 // createFailoverOperation 创建故障转移操作
 func (fc *FailoverCoordinator) createFailoverOperation(decision *FailoverDecision) *FailoverOperation {
 	operation := &FailoverOperation{
-		ID:            fmt.Sprintf("failover-%d", time.Now().Unix()),
+		ID:            fmt.Sprintf("failover-%d", fc.clock.Now().Unix()),
 		Strategy:      decision.Strategy,
-		StartTime:     time.Now(),
+		StartTime:     fc.clock.Now(),
 		Status:        "Created",
 		FailedDC:      decision.FailureEvidence[0].DataCenter,
 		FailureType:   decision.FailureEvidence[0].FailureType,
 		TriggerReason: decision.FailureEvidence[0].Description,
+		DetectedAt:    decision.FailureEvidence[0].DetectedAt,
 		TargetDC:      decision.TargetDC,
 		CurrentPhase:  PhaseDetection,
 		PhaseHistory:  make([]PhaseRecord, 0),
 		Errors:        make([]string, 0),
 		Warnings:      make([]string, 0),
+
+		failedRoutesBaseline: decision.failedRoutesBaseline,
+	}
+	// 事件未记录检测时间时从操作开始计算停机时间
+	if operation.DetectedAt.IsZero() {
+		operation.DetectedAt = operation.StartTime
 	}
 
 	// 记录当前日志索引
@@ -569,7 +696,7 @@ func (fc *FailoverCoordinator) createFailoverOperation(decision *FailoverDecisio
 // operationExecutionLoop 操作执行循环
 func (fc *FailoverCoordinator) operationExecutionLoop() {
 	defer fc.wg.Done()
-	fc.logger.Printf("操作执行循环已启动")
+	fc.logger.Debug("操作执行循环已启动")
 
 	for {
 		select {
@@ -578,7 +705,7 @@ func (fc *FailoverCoordinator) operationExecutionLoop() {
 				fc.executeFailoverOperation(operation)
 			}
 		case <-fc.stopCh:
-			fc.logger.Printf("操作执行循环已停止")
+			fc.logger.Debug("操作执行循环已停止")
 			return
 		}
 	}
@@ -586,7 +713,7 @@ func (fc *FailoverCoordinator) operationExecutionLoop() {
 
 // executeFailoverOperation 执行故障转移操作
 func (fc *FailoverCoordinator) executeFailoverOperation(operation *FailoverOperation) {
-	fc.logger.Printf("开始执行故障转移操作: %s", operation.ID)
+	fc.logger.Info("开始执行故障转移操作", "operation_id", operation.ID)
 
 	fc.mu.Lock()
 	fc.currentOperation = operation
@@ -610,6 +737,11 @@ func (fc *FailoverCoordinator) executeFailoverOperation(operation *FailoverOpera
 		}
 	}
 
+	// 失败时撤销已执行的切换，避免系统停留在半切换状态
+	if !success {
+		fc.rollbackFailoverOperation(operation)
+	}
+
 	// 完成操作
 	fc.completeFailoverOperation(operation, success)
 }
@@ -618,13 +750,13 @@ func (fc *FailoverCoordinator) executeFailoverOperation(operation *FailoverOpera
 func (fc *FailoverCoordinator) executePhase(operation *FailoverOperation, phase FailoverPhase) bool {
 	phaseRecord := PhaseRecord{
 		Phase:     phase,
-		StartTime: time.Now(),
+		StartTime: fc.clock.Now(),
 		Status:    "InProgress",
 		Errors:    make([]string, 0),
 	}
 
 	operation.CurrentPhase = phase
-	fc.logger.Printf("执行故障转移阶段: %s - %s", operation.ID, fc.phaseString(phase))
+	fc.logger.Info("执行故障转移阶段", "operation_id", operation.ID, "phase", fc.phaseString(phase))
 
 	var success bool
 	switch phase {
@@ -645,7 +777,7 @@ func (fc *FailoverCoordinator) executePhase(operation *FailoverOperation, phase
 		phaseRecord.Errors = append(phaseRecord.Errors, "未知阶段")
 	}
 
-	phaseRecord.EndTime = time.Now()
+	phaseRecord.EndTime = fc.clock.Now()
 	phaseRecord.Duration = phaseRecord.EndTime.Sub(phaseRecord.StartTime)
 	if success {
 		phaseRecord.Status = "Completed"
@@ -656,25 +788,72 @@ func (fc *FailoverCoordinator) executePhase(operation *FailoverOperation, phase
 	operation.PhaseHistory = append(operation.PhaseHistory, phaseRecord)
 
 	if !success {
-		fc.logger.Printf("故障转移阶段失败: %s - %s", operation.ID, fc.phaseString(phase))
+		fc.logger.Error("故障转移阶段失败", "operation_id", operation.ID, "phase", fc.phaseString(phase))
 	}
 
 	return success
 }
 
+// rollbackFailoverOperation 按逆序执行已登记的撤销操作，记录为回滚阶段
+func (fc *FailoverCoordinator) rollbackFailoverOperation(operation *FailoverOperation) {
+	if len(operation.undoStack) == 0 {
+		return
+	}
+
+	record := PhaseRecord{
+		Phase:     PhaseRollback,
+		StartTime: fc.clock.Now(),
+		Details:   fmt.Sprintf("回滚 %d 个操作", len(operation.undoStack)),
+		Errors:    make([]string, 0),
+	}
+	operation.CurrentPhase = PhaseRollback
+	fc.logger.Info("执行故障转移阶段", "operation_id", operation.ID, "phase", fc.phaseString(PhaseRollback))
+
+	for i := len(operation.undoStack) - 1; i >= 0; i-- {
+		step := operation.undoStack[i]
+		if err := step.undo(); err != nil {
+			msg := fmt.Sprintf("回滚失败: %s: %v", step.description, err)
+			record.Errors = append(record.Errors, msg)
+			operation.Errors = append(operation.Errors, msg)
+			fc.logger.Error("回滚失败，系统可能处于半切换状态，需要人工介入", "operation_id", operation.ID, logging.FieldError, msg)
+		}
+	}
+	operation.undoStack = nil
+
+	record.EndTime = fc.clock.Now()
+	record.Duration = record.EndTime.Sub(record.StartTime)
+	if len(record.Errors) == 0 {
+		record.Status = "Completed"
+	} else {
+		record.Status = "Failed"
+	}
+	operation.PhaseHistory = append(operation.PhaseHistory, record)
+
+	fc.mu.Lock()
+	fc.rollbacks++
+	if len(record.Errors) > 0 {
+		fc.failedRollbacks++
+	}
+	fc.mu.Unlock()
+}
+
 // 各个阶段的具体实现
 func (fc *FailoverCoordinator) executeDetectionPhase(operation *FailoverOperation, record *PhaseRecord) bool {
 	record.Details = "验证故障检测结果"
 
+	// 切回操作没有故障DC，目标DC的健康状态在决策阶段验证
+	if operation.IsFailback {
+		return true
+	}
+
 	// 再次验证故障状态
 	if fc.failureDetector != nil {
-		if !fc.failureDetector.ShouldTriggerFailover(operation.FailedDC) {
+		if !fc.failureDetector.HasFailoverCondition(operation.FailedDC) {
 			record.Errors = append(record.Errors, "故障状态已恢复，取消故障转移")
 			return false
 		}
 	}
 
-	time.Sleep(time.Millisecond * 100) // 模拟检测时间
 	return true
 }
 
@@ -689,7 +868,6 @@ func (fc *FailoverCoordinator) executeDecisionPhase(operation *FailoverOperation
 		}
 	}
 
-	time.Sleep(time.Millisecond * 200)
 	return true
 }
 
@@ -704,26 +882,50 @@ func (fc *FailoverCoordinator) executePreparationPhase(operation *FailoverOperat
 		}
 	}
 
-	time.Sleep(time.Millisecond * 500)
 	return true
 }
 
 func (fc *FailoverCoordinator) executeExecutionPhase(operation *FailoverOperation, record *PhaseRecord) bool {
 	record.Details = "执行路由切换"
 
-	// 执行实际的路由切换
+	// 把写路由切换到目标DC
 	if fc.readWriteRouter != nil {
-		// 这里应该调用路由器的故障转移方法
-		fc.logger.Printf("切换路由从 %s 到 %s", operation.FailedDC, operation.TargetDC)
+		previousPrimary := fc.readWriteRouter.GetPrimaryDC()
+		operation.PreviousPrimaryDC = previousPrimary
+
+		fc.logger.Info("切换路由", "from_dc", previousPrimary, "target_dc", operation.TargetDC)
+		if err := fc.readWriteRouter.PromotePrimaryDC(operation.TargetDC); err != nil {
+			record.Errors = append(record.Errors, fmt.Sprintf("切换主DC失败: %v", err))
+			return false
+		}
+		operation.registerUndo(fmt.Sprintf("恢复主DC %s", previousPrimary), func() error {
+			return fc.readWriteRouter.PromotePrimaryDC(previousPrimary)
+		})
 	}
 
-	// 更新异步复制配置
+	// 停止向故障DC复制，目标DC成为新的主DC
 	if fc.asyncReplicator != nil {
-		// 这里应该更新复制目标
-		fc.logger.Printf("更新异步复制配置")
+		var previousPrimary raft.DataCenterID
+		for dcID, target := range fc.asyncReplicator.GetReplicationStatus() {
+			if target.IsPrimary {
+				previousPrimary = dcID
+			}
+		}
+
+		fc.logger.Info("更新异步复制配置")
+		if err := fc.asyncReplicator.UpdateTargets(operation.FailedDC, operation.TargetDC); err != nil {
+			record.Errors = append(record.Errors, fmt.Sprintf("更新复制目标失败: %v", err))
+			return false
+		}
+		operation.registerUndo(fmt.Sprintf("恢复向 %s 复制", operation.FailedDC), func() error {
+			// 先恢复故障DC的复制，再还原原来的主复制目标
+			if err := fc.asyncReplicator.UpdateTargets("", operation.FailedDC); err != nil {
+				return err
+			}
+			return fc.asyncReplicator.UpdateTargets("", previousPrimary)
+		})
 	}
 
-	time.Sleep(time.Second * 1) // 模拟切换时间
 	return true
 }
 
@@ -738,6 +940,27 @@ func (fc *FailoverCoordinator) executeVerificationPhase(operation *FailoverOpera
 		}
 	}
 
+	// 验证写路由已切换到目标DC
+	if fc.readWriteRouter != nil {
+		if primaryDC := fc.readWriteRouter.GetPrimaryDC(); primaryDC != operation.TargetDC {
+			record.Errors = append(record.Errors, fmt.Sprintf("主DC未切换: 当前=%s, 期望=%s", primaryDC, operation.TargetDC))
+			return false
+		}
+	}
+
+	fc.mu.RLock()
+	verifier := fc.verifier
+	fc.mu.RUnlock()
+	if verifier != nil {
+		if err := verifier(operation); err != nil {
+			record.Errors = append(record.Errors, fmt.Sprintf("附加验证失败: %v", err))
+			return false
+		}
+	}
+
+	// 新主DC已确认可用，停机到此结束
+	fc.recordServiceRestored(operation)
+
 	// 验证数据一致性
 	if fc.consistencyRecovery != nil {
 		operation.ConsistencyVerified = fc.consistencyRecovery.IsGloballyConsistent()
@@ -746,27 +969,44 @@ func (fc *FailoverCoordinator) executeVerificationPhase(operation *FailoverOpera
 		}
 	}
 
-	time.Sleep(time.Millisecond * 300)
 	return true
 }
 
+// recordServiceRestored 记录新主DC确认可用时的故障转移延迟、停机时间和期间路由失败的请求数
+func (fc *FailoverCoordinator) recordServiceRestored(operation *FailoverOperation) {
+	now := fc.clock.Now()
+	operation.FailoverLatency = now.Sub(operation.StartTime)
+	operation.ServiceDowntime = now.Sub(operation.DetectedAt)
+	if impact := fc.failedRoutes() - operation.failedRoutesBaseline; impact > 0 {
+		operation.ClientImpactCount = impact
+	}
+}
+
+// failedRoutes 读写路由器累计路由失败的请求数，未配置路由器时为0
+func (fc *FailoverCoordinator) failedRoutes() int64 {
+	if fc.readWriteRouter == nil {
+		return 0
+	}
+	return fc.readWriteRouter.FailedRoutes()
+}
+
 func (fc *FailoverCoordinator) executeCompletionPhase(operation *FailoverOperation, record *PhaseRecord) bool {
 	record.Details = "完成故障转移"
 
 	// 更新统计信息
-	operation.EndTime = time.Now()
+	operation.EndTime = fc.clock.Now()
 	operation.Duration = operation.EndTime.Sub(operation.StartTime)
+	operation.RecoveryTime = operation.EndTime.Sub(operation.DetectedAt)
 	operation.Progress = 1.0
 
 	// 记录故障转移完成
 	fc.mu.Lock()
-	fc.lastFailoverTime = time.Now()
+	fc.lastFailoverTime = fc.clock.Now()
 	fc.failoverCount++
 	fc.totalFailovers++
 	fc.successfulFailovers++
 	fc.mu.Unlock()
 
-	time.Sleep(time.Millisecond * 100)
 	return true
 }
 
@@ -774,14 +1014,20 @@ func (fc *FailoverCoordinator) executeCompletionPhase(operation *FailoverOperati
 func (fc *FailoverCoordinator) completeFailoverOperation(operation *FailoverOperation, success bool) {
 	if success {
 		operation.Status = "Completed"
-		fc.logger.Printf("故障转移操作成功完成: %s, 耗时=%v",
-			operation.ID, operation.Duration)
+		fc.mu.Lock()
+		// successfulFailovers已在完成阶段计入本次操作
+		fc.averageFailoverTime += (operation.Duration - fc.averageFailoverTime) / time.Duration(fc.successfulFailovers)
+		fc.totalDowntime += operation.ServiceDowntime
+		fc.totalClientImpact += operation.ClientImpactCount
+		fc.mu.Unlock()
+		fc.logger.Info("故障转移操作成功完成", "operation_id", operation.ID, "duration", operation.Duration,
+			"downtime", operation.ServiceDowntime, "client_impact", operation.ClientImpactCount)
 	} else {
 		operation.Status = "Failed"
 		fc.mu.Lock()
 		fc.failedFailovers++
 		fc.mu.Unlock()
-		fc.logger.Printf("故障转移操作失败: %s", operation.ID)
+		fc.logger.Error("故障转移操作失败", "operation_id", operation.ID)
 	}
 
 	fc.mu.Lock()
@@ -789,55 +1035,192 @@ func (fc *FailoverCoordinator) completeFailoverOperation(operation *FailoverOper
 	fc.operationHistory = append(fc.operationHistory, operation)
 	fc.mu.Unlock()
 
-	// 触发冷却期
-	if success {
-		fc.startCooldownPeriod()
+	// 成功的故障转移在完成阶段更新了lastFailoverTime，持久化后重启也处于冷却期
+	fc.persistState()
+
+	fc.releaseFailureDetector()
+}
+
+// scheduleFailback 恢复的DC是最近一次故障转移的故障DC时，FailbackDelayMs后把主DC切回该DC
+func (fc *FailoverCoordinator) scheduleFailback(dcID raft.DataCenterID) {
+	if !fc.config.AutoFailbackEnabled {
+		return
+	}
+
+	fc.mu.RLock()
+	var lastOperation *FailoverOperation
+	for i := len(fc.operationHistory) - 1; i >= 0; i-- {
+		if op := fc.operationHistory[i]; op.Status == "Completed" {
+			lastOperation = op
+			break
+		}
+	}
+	fc.mu.RUnlock()
+
+	if lastOperation == nil || lastOperation.IsFailback || lastOperation.FailedDC != dcID {
+		return
+	}
+
+	delay := time.Duration(fc.config.FailbackDelayMs) * time.Millisecond
+	fc.logger.Info("DC已恢复，稍后切回主DC", "target_dc", dcID, "delay", delay)
+
+	fc.wg.Add(1)
+	go func() {
+		defer fc.wg.Done()
+
+		select {
+		case <-fc.clock.After(delay):
+		case <-fc.stopCh:
+			return
+		}
+		fc.startFailback(dcID, lastOperation.TargetDC)
+	}()
+}
+
+// startFailback 确认切回条件仍然成立后创建切回操作
+func (fc *FailoverCoordinator) startFailback(dcID, currentPrimary raft.DataCenterID) {
+	if fc.failureDetector != nil && !fc.failureDetector.IsHealthy(dcID) {
+		fc.logger.Warn("DC再次不健康，取消切回", "target_dc", dcID)
+		return
+	}
+	if fc.readWriteRouter != nil {
+		if primaryDC := fc.readWriteRouter.GetPrimaryDC(); primaryDC != currentPrimary {
+			fc.logger.Info("主DC已变更，取消切回", "primary_dc", primaryDC, "target_dc", dcID)
+			return
+		}
+	}
+
+	fc.mu.RLock()
+	busy := fc.currentOperation != nil
+	cooldown := fc.inCooldown()
+	fc.mu.RUnlock()
+	if busy || cooldown {
+		fc.logger.Info("正在故障转移或处于冷却期，取消切回", "target_dc", dcID)
+		return
+	}
+
+	operation := &FailoverOperation{
+		ID:            fmt.Sprintf("failback-%d", fc.clock.Now().Unix()),
+		Strategy:      GracefulFailover,
+		StartTime:     fc.clock.Now(),
+		Status:        "Created",
+		TriggerReason: fmt.Sprintf("DC %s 已稳定恢复，切回主DC", dcID),
+		IsFailback:    true,
+		TargetDC:      dcID,
+		CurrentPhase:  PhaseDetection,
+		PhaseHistory:  make([]PhaseRecord, 0),
+		Errors:        make([]string, 0),
+		Warnings:      make([]string, 0),
+	}
+	// 切回是计划内切换，停机时间和客户端影响从操作开始计算
+	operation.DetectedAt = operation.StartTime
+	operation.failedRoutesBaseline = fc.failedRoutes()
+
+	select {
+	case fc.operationCh <- operation:
+		fc.logger.Info("切回操作已创建", "operation_id", operation.ID)
+	case <-fc.stopCh:
+	}
+}
+
+// releaseFailureDetector 通知故障检测器本次故障转移已处理完，允许再次触发
+func (fc *FailoverCoordinator) releaseFailureDetector() {
+	if fc.failureDetector != nil {
+		fc.failureDetector.MarkFailoverComplete()
 	}
 }
 
 // monitoringLoop 监控循环
 func (fc *FailoverCoordinator) monitoringLoop() {
 	defer fc.wg.Done()
-	fc.logger.Printf("监控循环已启动")
+	fc.logger.Debug("监控循环已启动")
 
-	ticker := time.NewTicker(time.Minute * 1)
+	ticker := fc.clock.NewTicker(time.Minute * 1)
 	defer ticker.Stop()
 
+	expiryTicker := fc.clock.NewTicker(fc.pendingExpiryInterval())
+	defer expiryTicker.Stop()
+
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			fc.updateMonitoringMetrics()
+		case <-expiryTicker.C():
+			fc.expirePendingDecisions()
 		case <-fc.stopCh:
-			fc.logger.Printf("监控循环已停止")
+			fc.logger.Debug("监控循环已停止")
 			return
 		}
 	}
 }
 
+// pendingExpiryInterval 检查待确认决策是否过期的间隔
+func (fc *FailoverCoordinator) pendingExpiryInterval() time.Duration {
+	interval := time.Duration(fc.config.FailoverTimeoutMs) * time.Millisecond / 10
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	if interval > time.Second {
+		interval = time.Second
+	}
+	return interval
+}
+
+// expirePendingDecisions 待确认超过FailoverTimeoutMs的决策标记为过期
+func (fc *FailoverCoordinator) expirePendingDecisions() {
+	now := fc.clock.Now()
+
+	fc.mu.Lock()
+	remaining := fc.pendingDecisions[:0]
+	expired := 0
+	for _, decision := range fc.pendingDecisions {
+		if now.Before(decision.ExpiresAt) {
+			remaining = append(remaining, decision)
+			continue
+		}
+		decision.Status = DecisionExpired
+		decision.ResolvedAt = now
+		expired++
+		fc.logger.Warn("待确认的故障转移决策已过期", "decision_id", decision.ID)
+	}
+	for i := len(remaining); i < len(fc.pendingDecisions); i++ {
+		fc.pendingDecisions[i] = nil
+	}
+	fc.pendingDecisions = remaining
+	fc.mu.Unlock()
+
+	if expired > 0 {
+		fc.releaseFailureDetector()
+	}
+}
+
+// takePendingDecision 从待确认列表中取出决策（调用方需持有fc.mu）
+func (fc *FailoverCoordinator) takePendingDecision(decisionID string) *FailoverDecision {
+	for i, decision := range fc.pendingDecisions {
+		if decision.ID == decisionID {
+			fc.pendingDecisions = append(fc.pendingDecisions[:i], fc.pendingDecisions[i+1:]...)
+			return decision
+		}
+	}
+	return nil
+}
+
 // 辅助方法实现
 func (fc *FailoverCoordinator) isInCooldownPeriod() bool {
 	fc.mu.RLock()
 	defer fc.mu.RUnlock()
 
-	if !fc.isInCooldown {
+	return fc.inCooldown()
+}
+
+// inCooldown 距上次成功的故障转移不足CooldownPeriodMs（调用方需持有fc.mu）
+func (fc *FailoverCoordinator) inCooldown() bool {
+	if fc.lastFailoverTime.IsZero() {
 		return false
 	}
 
 	cooldownDuration := time.Duration(fc.config.CooldownPeriodMs) * time.Millisecond
-	return time.Since(fc.lastFailoverTime) < cooldownDuration
-}
-
-func (fc *FailoverCoordinator) startCooldownPeriod() {
-	fc.mu.Lock()
-	defer fc.mu.Unlock()
-
-	fc.isInCooldown = true
-	go func() {
-		time.Sleep(time.Duration(fc.config.CooldownPeriodMs) * time.Millisecond)
-		fc.mu.Lock()
-		fc.isInCooldown = false
-		fc.mu.Unlock()
-	}()
+	return fc.clock.Now().Sub(fc.lastFailoverTime) < cooldownDuration
 }
 
 func (fc *FailoverCoordinator) isFailoverFrequencyExceeded() bool {
@@ -845,7 +1228,7 @@ func (fc *FailoverCoordinator) isFailoverFrequencyExceeded() bool {
 	defer fc.mu.RUnlock()
 
 	// 检查过去一小时的故障转移次数
-	oneHourAgo := time.Now().Add(-time.Hour)
+	oneHourAgo := fc.clock.Now().Add(-failoverFrequencyWindow)
 	count := 0
 	for _, op := range fc.operationHistory {
 		if op.StartTime.After(oneHourAgo) && op.Status == "Completed" {
@@ -896,12 +1279,12 @@ func (fc *FailoverCoordinator) selectTargetDC(failedDC raft.DataCenterID, decisi
 
 	// 如果没有健康指标，使用默认的备用DC列表
 	if len(decision.HealthMetrics) == 0 {
-		fc.logger.Printf("没有健康指标数据，使用默认备用DC")
+		fc.logger.Info("没有健康指标数据，使用默认备用DC")
 		// 在测试环境中，提供一些默认的备用DC
 		defaultDCs := []raft.DataCenterID{"dc2", "dc3", "dc4"}
 		for _, dcID := range defaultDCs {
 			if dcID != failedDC {
-				fc.logger.Printf("选择默认备用DC: %s", dcID)
+				fc.logger.Info("选择默认备用DC", "target_dc", dcID)
 				return dcID
 			}
 		}
@@ -920,7 +1303,7 @@ func (fc *FailoverCoordinator) selectTargetDC(failedDC raft.DataCenterID, decisi
 		}
 
 		ratio := fc.calculateHealthyRatio(dcID, decision.HealthMetrics)
-		fc.logger.Printf("评估DC %s 健康比率: %.2f", dcID, ratio)
+		fc.logger.Debug("评估DC健康比率", "target_dc", dcID, "ratio", ratio)
 
 		if ratio > bestRatio {
 			bestRatio = ratio
@@ -930,14 +1313,14 @@ func (fc *FailoverCoordinator) selectTargetDC(failedDC raft.DataCenterID, decisi
 
 	// 如果没有找到合适的DC，返回错误处理
 	if bestDC == "" {
-		fc.logger.Printf("警告：没有找到合适的目标DC，使用应急DC")
+		fc.logger.Warn("没有找到合适的目标DC，使用应急DC")
 		if failedDC != "emergency-dc" {
 			return "emergency-dc"
 		}
 		return "fallback-dc"
 	}
 
-	fc.logger.Printf("选择目标DC: %s (健康比率: %.2f)", bestDC, bestRatio)
+	fc.logger.Info("选择目标DC", "target_dc", bestDC, "ratio", bestRatio)
 	return bestDC
 }
 
@@ -1000,42 +1383,134 @@ func (fc *FailoverCoordinator) updateMonitoringMetrics() {
 	currentOp := fc.currentOperation
 	fc.mu.RUnlock()
 
-	fc.logger.Printf("故障转移监控: 总操作=%d, 成功=%d, 失败=%d, 当前操作=%v",
-		totalOps, fc.successfulFailovers, fc.failedFailovers, currentOp != nil)
+	fc.logger.Debug("故障转移监控", "operations", totalOps, "succeeded", fc.successfulFailovers, "failed", fc.failedFailovers, "in_progress", currentOp != nil)
 }
 
 // 公共API方法
 
 // TriggerManualFailover 触发手动故障转移
 func (fc *FailoverCoordinator) TriggerManualFailover(failedDC, targetDC raft.DataCenterID, reason string) error {
-	fc.mu.Lock()
-	defer fc.mu.Unlock()
+	fc.mu.RLock()
+	busy := fc.currentOperation != nil
+	fc.mu.RUnlock()
 
-	if fc.currentOperation != nil {
+	if busy {
 		return fmt.Errorf("当前正在执行故障转移操作")
 	}
 
 	// 创建手动故障转移事件 - 使用DCFailure类型以获得高置信度
 	event := &DCFailureEvent{
-		EventID:           fmt.Sprintf("manual-failover-%d", time.Now().Unix()),
+		EventID:           fmt.Sprintf("manual-failover-%d", fc.clock.Now().Unix()),
 		DataCenter:        failedDC,
 		FailureType:       DCFailure, // 手动触发时使用DCFailure类型
 		Severity:          5,         // 手动故障转移通常是最高优先级
-		DetectedAt:        time.Now(),
+		DetectedAt:        fc.clock.Now(),
 		Description:       fmt.Sprintf("手动触发故障转移: %s", reason),
 		RecommendedAction: "执行手动故障转移",
 	}
 
-	fc.manualOverride = true
-	defer func() { fc.manualOverride = false }()
+	// 阻塞策略的队列入队时等待事件处理循环，不能持有fc.mu
+	if err := fc.failureQueue.Enqueue(event); err != nil {
+		return fmt.Errorf("故障事件入队失败: %w", err)
+	}
+	fc.logger.Info("手动故障转移已触发", "failed_dc", failedDC, "target_dc", targetDC)
+	return nil
+}
+
+// GetQueueStats 获取故障事件队列以及故障检测器、一致性恢复器事件队列的统计
+func (fc *FailoverCoordinator) GetQueueStats() []queue.Stats {
+	stats := []queue.Stats{fc.failureQueue.Stats()}
+	if fc.failureDetector != nil {
+		stats = append(stats, fc.failureDetector.GetQueueStats()...)
+	}
+	if fc.consistencyRecovery != nil {
+		stats = append(stats, fc.consistencyRecovery.GetQueueStats())
+	}
+	return stats
+}
+
+// SetFailoverVerifier 设置验证阶段的附加检查，返回错误时故障转移失败并回滚
+func (fc *FailoverCoordinator) SetFailoverVerifier(verifier func(operation *FailoverOperation) error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.verifier = verifier
+}
+
+// GetPendingDecisions 获取等待人工确认的故障转移决策
+func (fc *FailoverCoordinator) GetPendingDecisions() []*FailoverDecision {
+	fc.expirePendingDecisions()
+
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	decisions := make([]*FailoverDecision, 0, len(fc.pendingDecisions))
+	for _, decision := range fc.pendingDecisions {
+		decisionCopy := *decision
+		decisions = append(decisions, &decisionCopy)
+	}
+	return decisions
+}
+
+// ApproveDecision 批准待确认的决策，按决策创建故障转移操作并交给执行循环
+func (fc *FailoverCoordinator) ApproveDecision(decisionID string) error {
+	fc.expirePendingDecisions()
+
+	fc.mu.Lock()
+	decision := fc.takePendingDecision(decisionID)
+	if decision == nil {
+		fc.mu.Unlock()
+		return ErrDecisionNotFound
+	}
+	operation := fc.createFailoverOperation(decision)
 
 	select {
-	case fc.failureEventCh <- event:
-		fc.logger.Printf("手动故障转移已触发: %s -> %s", failedDC, targetDC)
-		return nil
+	case fc.operationCh <- operation:
 	default:
-		return fmt.Errorf("事件通道已满")
+		// 放回待确认列表，稍后可再次批准
+		fc.pendingDecisions = append(fc.pendingDecisions, decision)
+		fc.mu.Unlock()
+		return fmt.Errorf("操作通道已满")
+	}
+	decision.Status = DecisionApproved
+	decision.ResolvedAt = fc.clock.Now()
+	fc.mu.Unlock()
+
+	fc.logger.Info("故障转移决策已批准", "decision_id", decisionID, "operation_id", operation.ID)
+	return nil
+}
+
+// RejectDecision 拒绝待确认的决策，拒绝原因记录在决策历史中
+func (fc *FailoverCoordinator) RejectDecision(decisionID, reason string) error {
+	fc.expirePendingDecisions()
+
+	fc.mu.Lock()
+	decision := fc.takePendingDecision(decisionID)
+	if decision == nil {
+		fc.mu.Unlock()
+		return ErrDecisionNotFound
+	}
+	decision.Status = DecisionRejected
+	decision.RejectReason = reason
+	decision.ResolvedAt = fc.clock.Now()
+	fc.mu.Unlock()
+
+	fc.logger.Info("故障转移决策已拒绝", "decision_id", decisionID, "reason", reason)
+	fc.releaseFailureDetector()
+	return nil
+}
+
+// GetDecisionHistory 获取决策历史
+func (fc *FailoverCoordinator) GetDecisionHistory() []*FailoverDecision {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	history := make([]*FailoverDecision, 0, len(fc.decisionHistory))
+	for _, decision := range fc.decisionHistory {
+		decisionCopy := *decision
+		history = append(history, &decisionCopy)
 	}
+	return history
 }
 
 // GetCurrentOperation 获取当前故障转移操作
@@ -1059,6 +1534,11 @@ func (fc *FailoverCoordinator) GetCurrentOperation() *FailoverOperation {
 		TargetDC:     fc.currentOperation.TargetDC,
 		CurrentPhase: fc.currentOperation.CurrentPhase,
 		Progress:     fc.currentOperation.Progress,
+
+		DetectedAt:        fc.currentOperation.DetectedAt,
+		FailoverLatency:   fc.currentOperation.FailoverLatency,
+		ServiceDowntime:   fc.currentOperation.ServiceDowntime,
+		ClientImpactCount: fc.currentOperation.ClientImpactCount,
 	}
 }
 
@@ -1081,9 +1561,13 @@ func (fc *FailoverCoordinator) GetFailoverStats() map[string]interface{} {
 		"totalFailovers":      fc.totalFailovers,
 		"successfulFailovers": fc.successfulFailovers,
 		"failedFailovers":     fc.failedFailovers,
+		"rollbacks":           fc.rollbacks,
+		"failedRollbacks":     fc.failedRollbacks,
 		"averageFailoverTime": fc.averageFailoverTime,
 		"totalDowntime":       fc.totalDowntime,
-		"isInCooldown":        fc.isInCooldown,
+		"totalClientImpact":   fc.totalClientImpact,
+		"suppressedFailovers": fc.suppressedFailovers,
+		"isInCooldown":        fc.inCooldown(),
 		"lastFailoverTime":    fc.lastFailoverTime,
 	}
 }
@@ -0,0 +1,651 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-3 16:20:12
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-3 16:20:12
+* @Description: ConcordKV 故障转移协调器测试
+ */
+package replication_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"raftserver/queue"
+	"raftserver/raft"
+	"raftserver/replication"
+	"raftserver/testutil"
+)
+
+// partitionTransport 发往down中节点的请求直接失败，其余请求交给mockTransport
+type partitionTransport struct {
+	*mockTransport
+
+	mu   sync.Mutex
+	down map[raft.NodeID]bool
+}
+
+func (t *partitionTransport) SendAppendEntries(ctx context.Context, target raft.NodeID, req *raft.AppendEntriesRequest) (*raft.AppendEntriesResponse, error) {
+	t.mu.Lock()
+	down := t.down[target]
+	t.mu.Unlock()
+
+	if down {
+		return nil, errors.New("节点不可达")
+	}
+	return t.mockTransport.SendAppendEntries(ctx, target, req)
+}
+
+func (t *partitionTransport) setDown(nodes ...raft.NodeID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.down = make(map[raft.NodeID]bool)
+	for _, node := range nodes {
+		t.down[node] = true
+	}
+}
+
+// failoverTestCluster 本地DC为dc1，主DC为dc2（n2、n3），备用DC为dc3（n4）
+type failoverTestCluster struct {
+	transport   *partitionTransport
+	replicator  *replication.AsyncReplicator
+	router      *replication.ReadWriteRouter
+	detector    *replication.DCFailureDetector
+	coordinator *replication.FailoverCoordinator
+}
+
+// configure用于调整故障转移协调器配置
+func newFailoverTestCluster(t *testing.T, configure ...func(config *replication.FailoverCoordinatorConfig)) *failoverTestCluster {
+	t.Helper()
+
+	raftConfig := &raft.Config{
+		NodeID: "n1",
+		Servers: []raft.Server{
+			{ID: "n1", DataCenter: "dc1"},
+			{ID: "n2", DataCenter: "dc2"},
+			{ID: "n3", DataCenter: "dc2"},
+			{ID: "n4", DataCenter: "dc3"},
+		},
+		MultiDC: &raft.MultiDCConfig{
+			Enabled:         true,
+			LocalDataCenter: &raft.DataCenterConfig{ID: "dc1"},
+		},
+	}
+
+	transport := &partitionTransport{mockTransport: &mockTransport{}}
+
+	replicatorConfig := replication.DefaultAsyncReplicationConfig()
+	replicatorConfig.BatchSize = 1
+	replicatorConfig.BatchTimeoutMs = 10
+	replicatorConfig.RetryAttempts = 0
+	replicator := replication.NewAsyncReplicatorWithConfig("n1", replicatorConfig, raftConfig, transport, nil)
+
+	routerConfig := replication.DefaultReadWriteRouterConfig()
+	routerConfig.PrimaryDC = "dc2"
+	router := replication.NewReadWriteRouterWithConfig("n1", routerConfig, raftConfig)
+
+	detectorConfig := replication.DefaultDCFailureDetectorConfig()
+	detectorConfig.HealthCheckInterval = 20 * time.Millisecond
+	detectorConfig.HeartbeatTimeout = 200 * time.Millisecond
+	detectorConfig.EnableDetailedLogging = false
+	detectorConfig.RecoveryCheckInterval = 20 * time.Millisecond
+	detectorConfig.MinRecoveryObservations = 3
+	detector := replication.NewDCFailureDetector("n1", detectorConfig, replicator, router, transport)
+
+	coordinatorConfig := replication.DefaultFailoverCoordinatorConfig()
+	coordinatorConfig.RequireDataConsistency = false
+	for _, fn := range configure {
+		fn(coordinatorConfig)
+	}
+	coordinator := replication.NewFailoverCoordinator("n1", coordinatorConfig, detector, nil, router, replicator)
+
+	cluster := &failoverTestCluster{
+		transport:   transport,
+		replicator:  replicator,
+		router:      router,
+		detector:    detector,
+		coordinator: coordinator,
+	}
+
+	if err := replicator.Start(); err != nil {
+		t.Fatalf("启动异步复制管理器失败: %v", err)
+	}
+	t.Cleanup(func() { replicator.Stop() })
+
+	return cluster
+}
+
+// startFailover 启动故障检测器和故障转移协调器
+func (c *failoverTestCluster) startFailover(t *testing.T) {
+	t.Helper()
+
+	if err := c.detector.Start(); err != nil {
+		t.Fatalf("启动故障检测器失败: %v", err)
+	}
+	t.Cleanup(func() { c.detector.Stop() })
+
+	if err := c.coordinator.Start(); err != nil {
+		t.Fatalf("启动故障转移协调器失败: %v", err)
+	}
+	t.Cleanup(func() { c.coordinator.Stop() })
+}
+
+// replicateContinuously 持续写入日志条目，使健康的DC不断产生复制成功记录
+func (c *failoverTestCluster) replicateContinuously(t *testing.T) {
+	t.Helper()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for i := 1; ; i++ {
+			c.replicator.ReplicateAsync(makeEntries(i, i))
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(done)
+		<-stopped
+	})
+}
+
+// TestFailoverCoordinatorSwitchesRoutesOnDCFailure 主DC故障后检测器通知协调器，写路由和复制目标切换到健康DC并进入冷却期
+func TestFailoverCoordinatorSwitchesRoutesOnDCFailure(t *testing.T) {
+	cluster := newFailoverTestCluster(t)
+	cluster.replicateContinuously(t)
+	cluster.startFailover(t)
+
+	if primary := cluster.router.GetPrimaryDC(); primary != "dc2" {
+		t.Fatalf("初始主DC = %s, 期望 dc2", primary)
+	}
+
+	// dc2的全部节点不可达
+	cluster.transport.setDown("n2", "n3")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := cluster.coordinator.GetFailoverStats()
+		if stats["isInCooldown"] == true {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待故障转移完成超时: %+v", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	history := cluster.coordinator.GetOperationHistory()
+	if len(history) != 1 {
+		t.Fatalf("故障转移操作数 = %d, 期望 1", len(history))
+	}
+	operation := history[0]
+	if operation.Status != "Completed" || operation.FailedDC != "dc2" || operation.TargetDC != "dc3" {
+		t.Fatalf("故障转移操作 = %s %s -> %s, 期望 Completed dc2 -> dc3",
+			operation.Status, operation.FailedDC, operation.TargetDC)
+	}
+
+	if primary := cluster.router.GetPrimaryDC(); primary != "dc3" {
+		t.Fatalf("故障转移后主DC = %s, 期望 dc3", primary)
+	}
+	decision, err := cluster.router.RouteRequest(replication.RequestTypeWrite, "key", replication.ReadConsistencyStrong)
+	if err != nil {
+		t.Fatalf("路由写请求失败: %v", err)
+	}
+	if decision.TargetDC != "dc3" || decision.TargetNode != "n4" {
+		t.Fatalf("写请求路由到 %s/%s, 期望 dc3/n4", decision.TargetDC, decision.TargetNode)
+	}
+
+	status := cluster.replicator.GetReplicationStatus()
+	if _, exists := status["dc2"]; exists {
+		t.Fatalf("故障DC dc2 仍是复制目标")
+	}
+	if target := status["dc3"]; target == nil || !target.IsPrimary {
+		t.Fatalf("dc3 应成为主复制目标: %+v", target)
+	}
+
+	// 冷却期内再次检测到故障不会触发新的故障转移
+	if err := cluster.coordinator.TriggerManualFailover("dc3", "dc1", "冷却期测试"); err != nil {
+		t.Fatalf("触发手动故障转移失败: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := len(cluster.coordinator.GetOperationHistory()); got != 1 {
+		t.Fatalf("冷却期内执行了新的故障转移, 操作数 = %d", got)
+	}
+	if primary := cluster.router.GetPrimaryDC(); primary != "dc3" {
+		t.Fatalf("冷却期内主DC被切换为 %s", primary)
+	}
+}
+
+// TestFailoverCoordinatorFailsBackAfterRecovery 原主DC稳定恢复后重新加入读路由，FailbackDelayMs后切回为主DC
+func TestFailoverCoordinatorFailsBackAfterRecovery(t *testing.T) {
+	cluster := newFailoverTestCluster(t, func(config *replication.FailoverCoordinatorConfig) {
+		config.AutoFailbackEnabled = true
+		config.FailbackDelayMs = 50
+		config.CooldownPeriodMs = 10
+	})
+	cluster.replicateContinuously(t)
+	cluster.startFailover(t)
+
+	cluster.transport.setDown("n2", "n3")
+	waitPrimaryDC(t, cluster.router, "dc3")
+	if cluster.router.IsReadReplica("dc2") {
+		t.Fatalf("故障DC dc2 仍是读副本")
+	}
+
+	// dc2的复制已暂停，由外部探测提交健康快照
+	cluster.transport.setDown()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			cluster.detector.RecordHealthSnapshot(replication.DCHealthSnapshot{
+				DataCenter:     "dc2",
+				TotalNodes:     2,
+				HealthyNodes:   2,
+				AverageLatency: 10 * time.Millisecond,
+			})
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+
+	waitPrimaryDC(t, cluster.router, "dc2")
+	if !cluster.router.IsReadReplica("dc2") {
+		t.Fatalf("切回后dc2应是读副本")
+	}
+
+	history := waitOperations(t, cluster.coordinator, 2)
+	failback := history[len(history)-1]
+	if !failback.IsFailback || failback.Status != "Completed" || failback.TargetDC != "dc2" {
+		t.Fatalf("切回操作 = %+v", failback)
+	}
+	if target := cluster.replicator.GetReplicationStatus()["dc2"]; target == nil || !target.IsPrimary {
+		t.Fatalf("切回后dc2应恢复为主复制目标: %+v", target)
+	}
+}
+
+// waitPrimaryDC 等待路由器的主DC变为want
+func waitPrimaryDC(t *testing.T, router *replication.ReadWriteRouter, want raft.DataCenterID) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for router.GetPrimaryDC() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待主DC变为 %s 超时, 当前 = %s", want, router.GetPrimaryDC())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestAsyncReplicatorUpdateTargetsResumesSuspendedDC 暂停的DC再次成为主DC时恢复复制
+func TestAsyncReplicatorUpdateTargetsResumesSuspendedDC(t *testing.T) {
+	cluster := newFailoverTestCluster(t)
+
+	if err := cluster.replicator.UpdateTargets("dc2", "dc2"); err == nil {
+		t.Fatalf("故障DC作为新主DC应返回错误")
+	}
+	if err := cluster.replicator.UpdateTargets("dc2", "dc3"); err != nil {
+		t.Fatalf("更新复制目标失败: %v", err)
+	}
+	if _, exists := cluster.replicator.GetReplicationStatus()["dc2"]; exists {
+		t.Fatalf("dc2 应被暂停")
+	}
+
+	if err := cluster.replicator.UpdateTargets("", "dc2"); err != nil {
+		t.Fatalf("恢复复制目标失败: %v", err)
+	}
+	status := cluster.replicator.GetReplicationStatus()
+	if target := status["dc2"]; target == nil || !target.IsPrimary {
+		t.Fatalf("dc2 应恢复为主复制目标: %+v", target)
+	}
+	if status["dc3"].IsPrimary {
+		t.Fatalf("dc3 不应再是主复制目标")
+	}
+
+	// 恢复后的DC重新收到复制条目
+	if err := cluster.replicator.ReplicateAsync(makeEntries(1, 1)); err != nil {
+		t.Fatalf("复制失败: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, targets := cluster.transport.sent()
+		for _, target := range targets {
+			if target == "n2" || target == "n3" {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("恢复后未向dc2发送条目: %v", targets)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestFailoverCoordinatorRollsBackOnVerificationFailure 验证阶段失败时回滚主DC和复制目标
+func TestFailoverCoordinatorRollsBackOnVerificationFailure(t *testing.T) {
+	cluster := newFailoverTestCluster(t)
+	cluster.replicateContinuously(t)
+
+	verified := make(chan raft.DataCenterID, 1)
+	cluster.coordinator.SetFailoverVerifier(func(operation *replication.FailoverOperation) error {
+		verified <- cluster.router.GetPrimaryDC()
+		return errors.New("探测写入失败")
+	})
+	cluster.startFailover(t)
+
+	cluster.transport.setDown("n2", "n3")
+
+	var history []*replication.FailoverOperation
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		history = cluster.coordinator.GetOperationHistory()
+		if len(history) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待故障转移结束超时")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 验证时路由已经切换过
+	if primary := <-verified; primary != "dc3" {
+		t.Fatalf("验证阶段主DC = %s, 期望 dc3", primary)
+	}
+
+	operation := history[0]
+	if operation.Status != "Failed" {
+		t.Fatalf("故障转移状态 = %s, 期望 Failed", operation.Status)
+	}
+	if operation.PreviousPrimaryDC != "dc2" {
+		t.Fatalf("PreviousPrimaryDC = %s, 期望 dc2", operation.PreviousPrimaryDC)
+	}
+	if len(operation.Errors) != 0 {
+		t.Fatalf("回滚不应出错: %v", operation.Errors)
+	}
+	last := operation.PhaseHistory[len(operation.PhaseHistory)-1]
+	if last.Phase != replication.PhaseRollback || last.Status != "Completed" {
+		t.Fatalf("最后阶段 = %d/%s, 期望完成的回滚阶段", last.Phase, last.Status)
+	}
+
+	if primary := cluster.router.GetPrimaryDC(); primary != "dc2" {
+		t.Fatalf("回滚后主DC = %s, 期望 dc2", primary)
+	}
+	status := cluster.replicator.GetReplicationStatus()
+	if _, exists := status["dc2"]; !exists {
+		t.Fatalf("回滚后 dc2 应恢复为复制目标")
+	}
+	for dcID, target := range status {
+		if target.IsPrimary {
+			t.Fatalf("回滚后 %s 不应是主复制目标", dcID)
+		}
+	}
+
+	stats := cluster.coordinator.GetFailoverStats()
+	if stats["rollbacks"] != int64(1) || stats["failedRollbacks"] != int64(0) {
+		t.Fatalf("回滚统计 = %v/%v, 期望 1/0", stats["rollbacks"], stats["failedRollbacks"])
+	}
+	if stats["isInCooldown"] == true {
+		t.Fatalf("失败的故障转移不应进入冷却期")
+	}
+}
+
+// waitPendingDecision 等待出现一个待确认的决策
+func waitPendingDecision(t *testing.T, coordinator *replication.FailoverCoordinator) *replication.FailoverDecision {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if pending := coordinator.GetPendingDecisions(); len(pending) > 0 {
+			return pending[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待待确认决策超时")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func requireManualConfirmation(config *replication.FailoverCoordinatorConfig) {
+	config.ManualConfirmationRequired = true
+}
+
+// TestFailoverCoordinatorApproveDecision 需要人工确认时决策等待批准，批准后才切换路由
+func TestFailoverCoordinatorApproveDecision(t *testing.T) {
+	cluster := newFailoverTestCluster(t, requireManualConfirmation)
+	cluster.replicateContinuously(t)
+	cluster.startFailover(t)
+
+	cluster.transport.setDown("n2", "n3")
+	decision := waitPendingDecision(t, cluster.coordinator)
+	if decision.ID == "" || decision.Status != replication.DecisionPending || decision.TargetDC != "dc3" {
+		t.Fatalf("待确认决策不正确: %+v", decision)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if primary := cluster.router.GetPrimaryDC(); primary != "dc2" {
+		t.Fatalf("批准前主DC被切换为 %s", primary)
+	}
+
+	if err := cluster.coordinator.ApproveDecision("unknown"); !errors.Is(err, replication.ErrDecisionNotFound) {
+		t.Fatalf("批准不存在的决策应返回ErrDecisionNotFound, 实际 %v", err)
+	}
+	if err := cluster.coordinator.ApproveDecision(decision.ID); err != nil {
+		t.Fatalf("批准决策失败: %v", err)
+	}
+	if err := cluster.coordinator.ApproveDecision(decision.ID); !errors.Is(err, replication.ErrDecisionNotFound) {
+		t.Fatalf("重复批准应返回ErrDecisionNotFound, 实际 %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for cluster.router.GetPrimaryDC() != "dc3" {
+		if time.Now().After(deadline) {
+			t.Fatalf("批准后主DC未切换")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pending := cluster.coordinator.GetPendingDecisions(); len(pending) != 0 {
+		t.Fatalf("批准后仍有待确认决策: %d", len(pending))
+	}
+}
+
+// TestFailoverCoordinatorRejectAndExpireDecision 拒绝的决策记录原因，未确认的决策超时后过期
+func TestFailoverCoordinatorRejectAndExpireDecision(t *testing.T) {
+	cluster := newFailoverTestCluster(t, requireManualConfirmation, func(config *replication.FailoverCoordinatorConfig) {
+		config.FailoverTimeoutMs = 300
+	})
+	cluster.replicateContinuously(t)
+	cluster.startFailover(t)
+
+	cluster.transport.setDown("n2", "n3")
+	rejected := waitPendingDecision(t, cluster.coordinator)
+	if err := cluster.coordinator.RejectDecision(rejected.ID, "计划内维护"); err != nil {
+		t.Fatalf("拒绝决策失败: %v", err)
+	}
+
+	// dc3故障后dc2恢复，检测器再次触发故障转移，这次不处理等待过期
+	cluster.transport.setDown("n4")
+	expired := waitPendingDecision(t, cluster.coordinator)
+	if expired.ID == rejected.ID {
+		t.Fatalf("被拒绝的决策仍在待确认列表中")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(cluster.coordinator.GetPendingDecisions()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("待确认决策未过期")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := cluster.coordinator.ApproveDecision(expired.ID); !errors.Is(err, replication.ErrDecisionNotFound) {
+		t.Fatalf("批准过期决策应返回ErrDecisionNotFound, 实际 %v", err)
+	}
+
+	statuses := make(map[string]*replication.FailoverDecision)
+	for _, decision := range cluster.coordinator.GetDecisionHistory() {
+		statuses[decision.ID] = decision
+	}
+	if d := statuses[rejected.ID]; d == nil || d.Status != replication.DecisionRejected || d.RejectReason != "计划内维护" {
+		t.Fatalf("决策历史中的拒绝记录不正确: %+v", d)
+	}
+	if d := statuses[expired.ID]; d == nil || d.Status != replication.DecisionExpired {
+		t.Fatalf("决策历史中的过期记录不正确: %+v", d)
+	}
+	if primary := cluster.router.GetPrimaryDC(); primary != "dc2" {
+		t.Fatalf("未批准的决策不应切换主DC, 当前 %s", primary)
+	}
+}
+
+// TestFailoverEventBurstCoalesced 突发的故障事件按DC合并，队列满时挤掉最早的事件而不是新事件
+func TestFailoverEventBurstCoalesced(t *testing.T) {
+	config := replication.DefaultFailoverCoordinatorConfig()
+	config.FailureEventQueue.Capacity = 2
+	coordinator := replication.NewFailoverCoordinator("n1", config, nil, nil, nil, nil)
+
+	// 协调器未启动，事件留在队列中
+	for i := 0; i < 5; i++ {
+		if err := coordinator.TriggerManualFailover("dc2", "dc1", "burst"); err != nil {
+			t.Fatalf("同一DC的事件应被合并: %v", err)
+		}
+	}
+	for _, dc := range []raft.DataCenterID{"dc3", "dc4"} {
+		if err := coordinator.TriggerManualFailover(dc, "dc1", "burst"); err != nil {
+			t.Fatalf("队列满时新事件不应被丢弃: %v", err)
+		}
+	}
+
+	stats := coordinator.GetQueueStats()
+	if len(stats) != 1 {
+		t.Fatalf("未配置故障检测器与一致性恢复器时只有一个队列: %+v", stats)
+	}
+	if q := stats[0]; q.Policy != queue.Coalesce || q.Depth != 2 || q.Enqueued != 3 || q.Coalesced != 4 || q.Dropped != 1 {
+		t.Fatalf("队列统计不正确: %+v", q)
+	}
+}
+
+// TestFailoverCoordinatorMeasuresDowntime 停机时间从故障被判定计到新主DC验证可用，期间路由失败的请求计为客户端影响，
+// 平均耗时与累计停机时间跨操作累积
+func TestFailoverCoordinatorMeasuresDowntime(t *testing.T) {
+	raftConfig := &raft.Config{
+		NodeID: "n1",
+		Servers: []raft.Server{
+			{ID: "n1", DataCenter: "dc1"},
+			{ID: "n2", DataCenter: "dc2"},
+			{ID: "n3", DataCenter: "dc2"},
+			{ID: "n4", DataCenter: "dc3"},
+		},
+		MultiDC: &raft.MultiDCConfig{
+			Enabled:         true,
+			LocalDataCenter: &raft.DataCenterConfig{ID: "dc1"},
+		},
+	}
+	routerConfig := replication.DefaultReadWriteRouterConfig()
+	routerConfig.PrimaryDC = "dc2"
+	router := replication.NewReadWriteRouterWithConfig("n1", routerConfig, raftConfig)
+
+	clock := testutil.NewFakeClock(time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC))
+	config := replication.DefaultFailoverCoordinatorConfig()
+	config.RequireDataConsistency = false
+	config.Clock = clock
+	requireManualConfirmation(config)
+	coordinator := replication.NewFailoverCoordinator("n1", config, nil, nil, router, nil)
+
+	// 验证阶段推进时钟，模拟切换后确认新主DC可用所需的时间
+	steps := make(chan time.Duration, 2)
+	steps <- time.Second
+	steps <- 3 * time.Second
+	coordinator.SetFailoverVerifier(func(operation *replication.FailoverOperation) error {
+		clock.Advance(<-steps)
+		return nil
+	})
+	if err := coordinator.Start(); err != nil {
+		t.Fatalf("启动故障转移协调器失败: %v", err)
+	}
+	t.Cleanup(func() { coordinator.Stop() })
+
+	waitOperations := func(n int) *replication.FailoverOperation {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if history := coordinator.GetOperationHistory(); len(history) == n {
+				return history[n-1]
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("等待第 %d 次故障转移结束超时", n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// dc2故障：等待确认的4秒内3个写请求路由失败
+	detectedAt := clock.Now()
+	if err := coordinator.TriggerManualFailover("dc2", "dc3", "测试"); err != nil {
+		t.Fatalf("触发故障转移失败: %v", err)
+	}
+	decision := waitPendingDecision(t, coordinator)
+	for _, node := range []raft.NodeID{"n2", "n3"} {
+		if err := router.SetLearner(node, true); err != nil {
+			t.Fatalf("设置学习者失败: %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := router.RouteRequest(replication.RequestTypeWrite, "key", replication.ReadConsistencyStrong); err == nil {
+			t.Fatalf("主DC没有投票成员时写请求应路由失败")
+		}
+	}
+	clock.Advance(4 * time.Second)
+	if err := coordinator.ApproveDecision(decision.ID); err != nil {
+		t.Fatalf("批准决策失败: %v", err)
+	}
+
+	operation := waitOperations(1)
+	if operation.Status != "Completed" || !operation.DetectedAt.Equal(detectedAt) {
+		t.Fatalf("故障转移状态 = %s, DetectedAt = %v", operation.Status, operation.DetectedAt)
+	}
+	if operation.ServiceDowntime != 5*time.Second || operation.FailoverLatency != time.Second ||
+		operation.Duration != time.Second || operation.RecoveryTime != 5*time.Second {
+		t.Fatalf("停机 %v, 切换延迟 %v, 耗时 %v, 恢复 %v, 期望 5s/1s/1s/5s",
+			operation.ServiceDowntime, operation.FailoverLatency, operation.Duration, operation.RecoveryTime)
+	}
+	if operation.ClientImpactCount != 3 {
+		t.Fatalf("客户端影响 = %d, 期望 3", operation.ClientImpactCount)
+	}
+
+	// 冷却期后切回dc2，本次没有失败的请求
+	for _, node := range []raft.NodeID{"n2", "n3"} {
+		router.SetLearner(node, false)
+	}
+	clock.Advance(2 * time.Minute)
+	if err := coordinator.TriggerManualFailover("dc3", "dc2", "测试"); err != nil {
+		t.Fatalf("触发故障转移失败: %v", err)
+	}
+	if err := coordinator.ApproveDecision(waitPendingDecision(t, coordinator).ID); err != nil {
+		t.Fatalf("批准决策失败: %v", err)
+	}
+	operation = waitOperations(2)
+	if operation.ServiceDowntime != 3*time.Second || operation.ClientImpactCount != 0 {
+		t.Fatalf("第二次停机 %v, 客户端影响 %d, 期望 3s/0", operation.ServiceDowntime, operation.ClientImpactCount)
+	}
+
+	stats := coordinator.GetFailoverStats()
+	if stats["successfulFailovers"] != int64(2) || stats["averageFailoverTime"] != 2*time.Second ||
+		stats["totalDowntime"] != 8*time.Second || stats["totalClientImpact"] != int64(3) {
+		t.Fatalf("故障转移统计不正确: %v", stats)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * @Author: Lzww0608
+ * @Date: 2025-7-4 15:32:10
+ * @LastEditors: Lzww0608
+ * @LastEditTime: 2025-7-4 15:32:10
+ * @Description: ConcordKV 故障转移协调器状态持久化 - 重启后保持冷却期和频率限制
+ */
+
+package replication
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"raftserver/logging"
+)
+
+// failoverFrequencyWindow MaxFailoverFrequency的统计窗口，窗口内的操作记录会被持久化
+const failoverFrequencyWindow = time.Hour
+
+// failoverState 持久化的故障转移状态
+type failoverState struct {
+	LastFailoverTime time.Time            `json:"lastFailoverTime"`
+	Operations       []*FailoverOperation `json:"operations"` // 统计窗口内的故障转移操作
+}
+
+// loadPersistedState 从StateFilePath恢复上次故障转移时间和最近的操作记录
+// 文件不存在表示没有发生过故障转移；文件损坏时记录日志并从空状态开始
+func (fc *FailoverCoordinator) loadPersistedState() {
+	if fc.config.StateFilePath == "" {
+		return
+	}
+
+	state, err := readFailoverState(fc.config.StateFilePath)
+	if err != nil {
+		fc.logger.Warn("加载故障转移状态失败，冷却期和频率限制从空状态开始", logging.FieldError, err)
+		return
+	}
+	if state == nil {
+		return
+	}
+
+	fc.mu.Lock()
+	fc.lastFailoverTime = state.LastFailoverTime
+	fc.operationHistory = append(fc.operationHistory, state.Operations...)
+	fc.mu.Unlock()
+
+	fc.logger.Info("已加载故障转移状态", "last_failover", state.LastFailoverTime, "operations", len(state.Operations))
+}
+
+// persistState 把上次故障转移时间和统计窗口内的操作记录写入StateFilePath
+func (fc *FailoverCoordinator) persistState() {
+	if fc.config.StateFilePath == "" {
+		return
+	}
+
+	windowStart := fc.clock.Now().Add(-failoverFrequencyWindow)
+
+	fc.mu.RLock()
+	state := &failoverState{LastFailoverTime: fc.lastFailoverTime}
+	for _, op := range fc.operationHistory {
+		if op.StartTime.After(windowStart) {
+			state.Operations = append(state.Operations, op)
+		}
+	}
+	data, err := json.Marshal(state)
+	fc.mu.RUnlock()
+
+	if err == nil {
+		err = writeFailoverState(fc.config.StateFilePath, data)
+	}
+	if err != nil {
+		fc.logger.Warn("保存故障转移状态失败，重启后冷却期可能失效", logging.FieldError, err)
+	}
+}
+
+// readFailoverState 读取状态文件，文件不存在时返回nil
+func readFailoverState(path string) (*failoverState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state failoverState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("解析状态文件 %s 失败: %w", path, err)
+	}
+	return &state, nil
+}
+
+// writeFailoverState 先写临时文件并落盘，再原子替换状态文件
+func writeFailoverState(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
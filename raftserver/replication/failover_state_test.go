@@ -0,0 +1,170 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-4 15:32:10
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-4 15:32:10
+* @Description: ConcordKV 故障转移状态持久化测试
+ */
+package replication_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"raftserver/replication"
+	"raftserver/testutil"
+)
+
+// startStandaloneCoordinator 启动不连接其他组件的协调器，手动故障转移会直接完成
+func startStandaloneCoordinator(t *testing.T, config *replication.FailoverCoordinatorConfig) *replication.FailoverCoordinator {
+	t.Helper()
+
+	coordinator := replication.NewFailoverCoordinator("n1", config, nil, nil, nil, nil)
+	if err := coordinator.Start(); err != nil {
+		t.Fatalf("启动故障转移协调器失败: %v", err)
+	}
+	t.Cleanup(func() { coordinator.Stop() })
+	return coordinator
+}
+
+// waitOperations 等待操作历史达到n条
+func waitOperations(t *testing.T, coordinator *replication.FailoverCoordinator, n int) []*replication.FailoverOperation {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		history := coordinator.GetOperationHistory()
+		if len(history) >= n {
+			return history
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待故障转移操作超时: %d/%d", len(history), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitSuppressed 等待协调器累计跳过n个故障事件
+func waitSuppressed(t *testing.T, coordinator *replication.FailoverCoordinator, n int64) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		suppressed := coordinator.GetFailoverStats()["suppressedFailovers"].(int64)
+		if suppressed >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待故障事件被跳过超时: %d/%d", suppressed, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestFailoverCooldownSurvivesRestart 重启后的协调器从状态文件恢复冷却期，不会立即再次故障转移
+func TestFailoverCooldownSurvivesRestart(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC))
+	config := replication.DefaultFailoverCoordinatorConfig()
+	config.StateFilePath = filepath.Join(t.TempDir(), "failover", "state.json")
+	config.Clock = clk
+
+	first := startStandaloneCoordinator(t, config)
+	if err := first.TriggerManualFailover("dc1", "dc2", "首次故障转移"); err != nil {
+		t.Fatalf("触发故障转移失败: %v", err)
+	}
+	if op := waitOperations(t, first, 1)[0]; op.Status != "Completed" {
+		t.Fatalf("首次故障转移状态 = %s", op.Status)
+	}
+	first.Stop()
+
+	if _, err := os.Stat(config.StateFilePath); err != nil {
+		t.Fatalf("状态文件未写入: %v", err)
+	}
+
+	// 模拟重启：在同一状态文件上创建新的协调器
+	restarted := startStandaloneCoordinator(t, config)
+	if stats := restarted.GetFailoverStats(); stats["isInCooldown"] != true {
+		t.Fatalf("重启后应处于冷却期: %+v", stats)
+	}
+	history := restarted.GetOperationHistory()
+	if len(history) != 1 || history[0].Status != "Completed" || history[0].FailedDC != "dc1" {
+		t.Fatalf("重启后恢复的操作记录不正确: %+v", history)
+	}
+
+	clk.Advance(time.Duration(config.CooldownPeriodMs)*time.Millisecond - time.Millisecond)
+	if err := restarted.TriggerManualFailover("dc2", "dc3", "冷却期内的故障"); err != nil {
+		t.Fatalf("触发故障转移失败: %v", err)
+	}
+	waitSuppressed(t, restarted, 1)
+	if got := len(restarted.GetOperationHistory()); got != 1 {
+		t.Fatalf("冷却期内执行了新的故障转移, 操作数 = %d", got)
+	}
+
+	// 冷却期结束后的故障照常处理
+	clk.Advance(time.Millisecond)
+	if err := restarted.TriggerManualFailover("dc2", "dc3", "冷却期后的故障"); err != nil {
+		t.Fatalf("触发故障转移失败: %v", err)
+	}
+	if op := waitOperations(t, restarted, 2)[1]; op.FailedDC != "dc2" || op.Status != "Completed" {
+		t.Fatalf("冷却期后的故障转移不正确: %+v", op)
+	}
+}
+
+// TestFailoverFrequencyLimitSurvivesRestart 重启后仍按持久化的操作记录限制每小时的故障转移次数
+func TestFailoverFrequencyLimitSurvivesRestart(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC))
+	config := replication.DefaultFailoverCoordinatorConfig()
+	config.StateFilePath = filepath.Join(t.TempDir(), "state.json")
+	config.CooldownPeriodMs = 1
+	config.MaxFailoverFrequency = 1
+	config.Clock = clk
+
+	first := startStandaloneCoordinator(t, config)
+	if err := first.TriggerManualFailover("dc1", "dc2", "首次故障转移"); err != nil {
+		t.Fatalf("触发故障转移失败: %v", err)
+	}
+	waitOperations(t, first, 1)
+	first.Stop()
+
+	restarted := startStandaloneCoordinator(t, config)
+	clk.Advance(10 * time.Millisecond) // 冷却期已过
+	if stats := restarted.GetFailoverStats(); stats["isInCooldown"] != false {
+		t.Fatalf("冷却期应已结束: %+v", stats)
+	}
+
+	if err := restarted.TriggerManualFailover("dc2", "dc3", "超出频率限制"); err != nil {
+		t.Fatalf("触发故障转移失败: %v", err)
+	}
+	waitSuppressed(t, restarted, 1)
+	if got := len(restarted.GetOperationHistory()); got != 1 {
+		t.Fatalf("超出每小时故障转移次数时执行了新的故障转移, 操作数 = %d", got)
+	}
+
+	// 首次故障转移移出一小时的窗口后不再受限
+	clk.Advance(time.Hour)
+	if err := restarted.TriggerManualFailover("dc2", "dc3", "窗口滑过后的故障"); err != nil {
+		t.Fatalf("触发故障转移失败: %v", err)
+	}
+	if op := waitOperations(t, restarted, 2)[1]; op.FailedDC != "dc2" || op.Status != "Completed" {
+		t.Fatalf("窗口滑过后的故障转移不正确: %+v", op)
+	}
+}
+
+// TestFailoverCorruptStateFileIgnored 状态文件损坏时协调器从空状态启动
+func TestFailoverCorruptStateFileIgnored(t *testing.T) {
+	config := replication.DefaultFailoverCoordinatorConfig()
+	config.StateFilePath = filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(config.StateFilePath, []byte("{not json"), 0644); err != nil {
+		t.Fatalf("写入状态文件失败: %v", err)
+	}
+
+	coordinator := startStandaloneCoordinator(t, config)
+	if stats := coordinator.GetFailoverStats(); stats["isInCooldown"] != false {
+		t.Fatalf("损坏的状态文件不应产生冷却期: %+v", stats)
+	}
+	if got := len(coordinator.GetOperationHistory()); got != 0 {
+		t.Fatalf("操作数 = %d, 期望 0", got)
+	}
+}
@@ -0,0 +1,309 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-4 21:48:12
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-4 21:48:12
+* @Description: ConcordKV 滑动窗口延迟直方图
+ */
+package replication
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"raftserver/raft"
+)
+
+const (
+	// DefaultLatencyWindow 延迟直方图默认的滑动窗口
+	DefaultLatencyWindow = 5 * time.Minute
+
+	// 桶按2的幂划分区间，每个区间再线性分为4个子桶（HDR风格），覆盖1µs到约67s，相对误差不超过25%
+	latencyHistogramMin        = time.Microsecond
+	latencyHistogramOctaves    = 26
+	latencyHistogramSubBuckets = 4
+
+	// latencyWindowSlots 滑动窗口划分的时间片数，过期时间片整片丢弃
+	latencyWindowSlots = 10
+)
+
+// latencyBucketBounds 各桶的上界（包含），超过最后一个上界的样本落入溢出桶
+var latencyBucketBounds = buildLatencyBucketBounds()
+
+func buildLatencyBucketBounds() []time.Duration {
+	bounds := []time.Duration{latencyHistogramMin}
+	for octave := 0; octave < latencyHistogramOctaves; octave++ {
+		base := latencyHistogramMin << octave
+		for sub := 1; sub <= latencyHistogramSubBuckets; sub++ {
+			bounds = append(bounds, base+base*time.Duration(sub)/latencyHistogramSubBuckets)
+		}
+	}
+	return bounds
+}
+
+// latencyBucketIndex 延迟所在桶的下标，溢出桶的下标为len(latencyBucketBounds)
+func latencyBucketIndex(latency time.Duration) int {
+	return sort.Search(len(latencyBucketBounds), func(i int) bool {
+		return latencyBucketBounds[i] >= latency
+	})
+}
+
+// latencyWindowSlot 滑动窗口中的一个时间片
+type latencyWindowSlot struct {
+	epoch  int64 // 时间片序号，即 UnixNano / slotWidth
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+// LatencyHistogram 滑动窗口延迟直方图，只统计最近一个窗口内的样本，旧的异常值会随窗口滑动过期
+type LatencyHistogram struct {
+	mu        sync.Mutex
+	slotWidth time.Duration
+	slots     []latencyWindowSlot
+}
+
+// NewLatencyHistogram 创建延迟直方图，window<=0时使用DefaultLatencyWindow
+func NewLatencyHistogram(window time.Duration) *LatencyHistogram {
+	if window <= 0 {
+		window = DefaultLatencyWindow
+	}
+	slotWidth := window / latencyWindowSlots
+	if slotWidth <= 0 {
+		slotWidth = 1
+	}
+
+	return &LatencyHistogram{
+		slotWidth: slotWidth,
+		slots:     make([]latencyWindowSlot, latencyWindowSlots),
+	}
+}
+
+// Window 直方图的滑动窗口
+func (h *LatencyHistogram) Window() time.Duration {
+	return h.slotWidth * latencyWindowSlots
+}
+
+// Observe 记录一次延迟
+func (h *LatencyHistogram) Observe(latency time.Duration, now time.Time) {
+	if latency < 0 {
+		latency = 0
+	}
+	epoch := now.UnixNano() / int64(h.slotWidth)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	slot := &h.slots[epoch%latencyWindowSlots]
+	if slot.counts == nil {
+		slot.counts = make([]uint64, len(latencyBucketBounds)+1)
+	}
+	if slot.epoch != epoch {
+		// 时间片已过期，复用前清空
+		for i := range slot.counts {
+			slot.counts[i] = 0
+		}
+		slot.epoch = epoch
+		slot.count = 0
+		slot.sum = 0
+	}
+
+	if slot.count == 0 || latency < slot.min {
+		slot.min = latency
+	}
+	if slot.count == 0 || latency > slot.max {
+		slot.max = latency
+	}
+	slot.counts[latencyBucketIndex(latency)]++
+	slot.count++
+	slot.sum += latency
+}
+
+// Snapshot 合并窗口内的时间片，窗口实际覆盖最近 (slots-1)~slots 个时间片宽度
+func (h *LatencyHistogram) Snapshot(now time.Time) *LatencyHistogramSnapshot {
+	epoch := now.UnixNano() / int64(h.slotWidth)
+
+	snapshot := &LatencyHistogramSnapshot{
+		Bounds: append([]time.Duration(nil), latencyBucketBounds...),
+		Counts: make([]uint64, len(latencyBucketBounds)+1),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := range h.slots {
+		slot := &h.slots[i]
+		if slot.count == 0 || slot.epoch > epoch || slot.epoch <= epoch-latencyWindowSlots {
+			continue
+		}
+
+		for bucket, count := range slot.counts {
+			snapshot.Counts[bucket] += count
+		}
+		if snapshot.Count == 0 || slot.min < snapshot.Min {
+			snapshot.Min = slot.min
+		}
+		if snapshot.Count == 0 || slot.max > snapshot.Max {
+			snapshot.Max = slot.max
+		}
+		snapshot.Count += slot.count
+		snapshot.Sum += slot.sum
+	}
+	return snapshot
+}
+
+// LatencyHistogramSnapshot 延迟直方图在某一时刻的窗口统计
+type LatencyHistogramSnapshot struct {
+	Bounds []time.Duration // 各桶上界（包含）
+	Counts []uint64        // 各桶样本数（非累计），最后一个是没有上界的溢出桶
+	Count  uint64
+	Sum    time.Duration
+	Min    time.Duration
+	Max    time.Duration
+}
+
+// Mean 窗口内的平均延迟
+func (s *LatencyHistogramSnapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// Quantile 估计q分位数(0<=q<=1)，在样本所在桶内线性插值，结果限制在观测到的最小值和最大值之间
+func (s *LatencyHistogramSnapshot) Quantile(q float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return s.Min
+	}
+	if q >= 1 {
+		return s.Max
+	}
+
+	rank := q * float64(s.Count)
+	var cumulative uint64
+	for i, count := range s.Counts {
+		if count == 0 {
+			continue
+		}
+		if float64(cumulative+count) < rank {
+			cumulative += count
+			continue
+		}
+
+		lower, upper := time.Duration(0), s.Max
+		if i > 0 {
+			lower = s.Bounds[i-1]
+		}
+		if i < len(s.Bounds) && s.Bounds[i] < upper {
+			upper = s.Bounds[i]
+		}
+		if lower < s.Min {
+			lower = s.Min
+		}
+		if upper < lower {
+			return lower
+		}
+
+		fraction := (rank - float64(cumulative)) / float64(count)
+		return lower + time.Duration(fraction*float64(upper-lower))
+	}
+	return s.Max
+}
+
+// Percentiles 窗口内的样本数、平均延迟与常用分位数
+func (s *LatencyHistogramSnapshot) Percentiles() LatencyPercentiles {
+	return LatencyPercentiles{
+		Count: int64(s.Count),
+		Mean:  s.Mean(),
+		P50:   s.Quantile(0.50),
+		P95:   s.Quantile(0.95),
+		P99:   s.Quantile(0.99),
+	}
+}
+
+// LatencyPercentiles 滑动窗口内的延迟分位数
+type LatencyPercentiles struct {
+	Count int64
+	Mean  time.Duration
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+}
+
+// RouterLatencyHistograms 路由延迟直方图快照，按请求类型和目标DC分别统计
+type RouterLatencyHistograms struct {
+	Window        time.Duration
+	Overall       *LatencyHistogramSnapshot
+	ByRequestType map[RequestType]*LatencyHistogramSnapshot
+	ByDC          map[raft.DataCenterID]*LatencyHistogramSnapshot
+}
+
+// routerLatencyHistograms 路由器的延迟直方图，按需为新的请求类型和DC创建直方图
+type routerLatencyHistograms struct {
+	mu      sync.Mutex
+	window  time.Duration
+	overall *LatencyHistogram
+	byType  map[RequestType]*LatencyHistogram
+	byDC    map[raft.DataCenterID]*LatencyHistogram
+}
+
+func newRouterLatencyHistograms(window time.Duration) *routerLatencyHistograms {
+	overall := NewLatencyHistogram(window)
+	return &routerLatencyHistograms{
+		window:  overall.Window(),
+		overall: overall,
+		byType:  make(map[RequestType]*LatencyHistogram),
+		byDC:    make(map[raft.DataCenterID]*LatencyHistogram),
+	}
+}
+
+// observe 记录一次路由延迟，路由失败时dcID为空，只计入总体和请求类型
+func (r *routerLatencyHistograms) observe(requestType RequestType, dcID raft.DataCenterID, latency time.Duration, now time.Time) {
+	r.mu.Lock()
+	byType := r.byType[requestType]
+	if byType == nil {
+		byType = NewLatencyHistogram(r.window)
+		r.byType[requestType] = byType
+	}
+	var byDC *LatencyHistogram
+	if dcID != "" {
+		byDC = r.byDC[dcID]
+		if byDC == nil {
+			byDC = NewLatencyHistogram(r.window)
+			r.byDC[dcID] = byDC
+		}
+	}
+	r.mu.Unlock()
+
+	r.overall.Observe(latency, now)
+	byType.Observe(latency, now)
+	if byDC != nil {
+		byDC.Observe(latency, now)
+	}
+}
+
+// snapshot 复制所有直方图在now时刻的窗口统计
+func (r *routerLatencyHistograms) snapshot(now time.Time) *RouterLatencyHistograms {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshot := &RouterLatencyHistograms{
+		Window:        r.window,
+		Overall:       r.overall.Snapshot(now),
+		ByRequestType: make(map[RequestType]*LatencyHistogramSnapshot, len(r.byType)),
+		ByDC:          make(map[raft.DataCenterID]*LatencyHistogramSnapshot, len(r.byDC)),
+	}
+	for requestType, histogram := range r.byType {
+		snapshot.ByRequestType[requestType] = histogram.Snapshot(now)
+	}
+	for dcID, histogram := range r.byDC {
+		snapshot.ByDC[dcID] = histogram.Snapshot(now)
+	}
+	return snapshot
+}
@@ -0,0 +1,175 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-4 21:48:12
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-4 21:48:12
+* @Description: ConcordKV 滑动窗口延迟直方图测试
+ */
+package replication_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/replication"
+)
+
+// assertQuantile 检查分位数与期望值的相对误差不超过tolerance
+func assertQuantile(t *testing.T, snapshot *replication.LatencyHistogramSnapshot, q float64, want time.Duration, tolerance float64) {
+	t.Helper()
+
+	got := snapshot.Quantile(q)
+	if diff := math.Abs(float64(got-want)) / float64(want); diff > tolerance {
+		t.Errorf("P%v = %v, 期望 %v (相对误差 %.3f > %.3f)", q*100, got, want, diff, tolerance)
+	}
+}
+
+// TestLatencyHistogramUniformPercentiles 1ms~1000ms均匀分布的分位数
+func TestLatencyHistogramUniformPercentiles(t *testing.T) {
+	histogram := replication.NewLatencyHistogram(time.Minute)
+	now := time.Now()
+	for i := 1; i <= 1000; i++ {
+		histogram.Observe(time.Duration(i)*time.Millisecond, now)
+	}
+
+	snapshot := histogram.Snapshot(now)
+	if snapshot.Count != 1000 || snapshot.Min != time.Millisecond || snapshot.Max != time.Second {
+		t.Fatalf("快照 Count=%d Min=%v Max=%v", snapshot.Count, snapshot.Min, snapshot.Max)
+	}
+	if mean := snapshot.Mean(); mean != 500500*time.Microsecond {
+		t.Fatalf("平均延迟 = %v, 期望 500.5ms", mean)
+	}
+	assertQuantile(t, snapshot, 0.50, 500*time.Millisecond, 0.03)
+	assertQuantile(t, snapshot, 0.95, 950*time.Millisecond, 0.03)
+	assertQuantile(t, snapshot, 0.99, 990*time.Millisecond, 0.03)
+}
+
+// TestLatencyHistogramExponentialPercentiles 均值10ms的指数分布的分位数
+func TestLatencyHistogramExponentialPercentiles(t *testing.T) {
+	const samples = 10000
+	mean := float64(10 * time.Millisecond)
+	quantile := func(q float64) time.Duration {
+		return time.Duration(-mean * math.Log(1-q))
+	}
+
+	histogram := replication.NewLatencyHistogram(time.Minute)
+	now := time.Now()
+	for i := 0; i < samples; i++ {
+		histogram.Observe(quantile((float64(i)+0.5)/samples), now)
+	}
+
+	snapshot := histogram.Snapshot(now)
+	for _, q := range []float64{0.50, 0.95, 0.99} {
+		assertQuantile(t, snapshot, q, quantile(q), 0.05)
+	}
+}
+
+// TestLatencyHistogramWindowExpiresOutliers 窗口滑过之后旧的异常值不再影响分位数
+func TestLatencyHistogramWindowExpiresOutliers(t *testing.T) {
+	histogram := replication.NewLatencyHistogram(time.Minute)
+	start := time.Now()
+
+	for i := 0; i < 100; i++ {
+		histogram.Observe(time.Millisecond, start)
+	}
+	histogram.Observe(10*time.Second, start)
+	histogram.Observe(10*time.Second, start)
+
+	if p99 := histogram.Snapshot(start).Quantile(0.99); p99 < time.Second {
+		t.Fatalf("异常值还在窗口内时 P99 = %v, 期望秒级", p99)
+	}
+
+	later := start.Add(time.Minute + 10*time.Second)
+	for i := 0; i < 100; i++ {
+		histogram.Observe(2*time.Millisecond, later)
+	}
+
+	snapshot := histogram.Snapshot(later)
+	if snapshot.Count != 100 || snapshot.Max != 2*time.Millisecond {
+		t.Fatalf("窗口滑过后 Count=%d Max=%v, 期望只剩新样本", snapshot.Count, snapshot.Max)
+	}
+	if p99 := snapshot.Quantile(0.99); p99 != 2*time.Millisecond {
+		t.Fatalf("窗口滑过后 P99 = %v, 期望 2ms", p99)
+	}
+	if empty := histogram.Snapshot(later.Add(2 * time.Minute)); empty.Count != 0 || empty.Quantile(0.99) != 0 {
+		t.Fatalf("所有样本过期后 Count=%d", empty.Count)
+	}
+}
+
+// TestRouterLatencyPercentilesByTypeAndDC 路由延迟按请求类型和目标DC分别统计
+func TestRouterLatencyPercentilesByTypeAndDC(t *testing.T) {
+	router := newRecoveryTestRouter()
+
+	for i := 0; i < 30; i++ {
+		routeRead(t, router, "key", replication.ReadConsistencyEventual)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := router.RouteRequest(replication.RequestTypeWrite, "key", replication.ReadConsistencyStrong); err != nil {
+			t.Fatalf("路由写请求失败: %v", err)
+		}
+	}
+
+	metrics := router.GetMetrics()
+	if metrics.P50Latency <= 0 || metrics.P99Latency < metrics.P95Latency || metrics.P95Latency < metrics.P50Latency {
+		t.Fatalf("总体分位数 P50=%v P95=%v P99=%v", metrics.P50Latency, metrics.P95Latency, metrics.P99Latency)
+	}
+	if reads := metrics.RequestTypeLatencies[replication.RequestTypeRead]; reads.Count != 30 || reads.P99 <= 0 {
+		t.Fatalf("读请求延迟统计 = %+v", reads)
+	}
+	if writes := metrics.RequestTypeLatencies[replication.RequestTypeWrite]; writes.Count != 10 || writes.P99 <= 0 {
+		t.Fatalf("写请求延迟统计 = %+v", writes)
+	}
+
+	wantDC := map[raft.DataCenterID]int64{"dc1": 30, "dc2": 10}
+	for dcID, want := range wantDC {
+		if got := metrics.DCLatencyPercentiles[dcID]; got.Count != want || metrics.DCLatencies[dcID] != got.Mean {
+			t.Fatalf("%s 延迟统计 = %+v, DCLatencies=%v, 期望 %d 个样本", dcID, got, metrics.DCLatencies[dcID], want)
+		}
+	}
+
+	histograms := router.GetLatencyHistograms()
+	if histograms.Window != replication.DefaultLatencyWindow || histograms.Overall.Count != 40 {
+		t.Fatalf("直方图 Window=%v Count=%d", histograms.Window, histograms.Overall.Count)
+	}
+}
+
+// TestRouterThroughputFromRequestDelta 吞吐量按指标周期内的请求增量计算，没有新请求时回落为0
+func TestRouterThroughputFromRequestDelta(t *testing.T) {
+	raftConfig := &raft.Config{
+		NodeID:  "n1",
+		Servers: []raft.Server{{ID: "n1", DataCenter: "dc1"}},
+		MultiDC: &raft.MultiDCConfig{
+			Enabled:         true,
+			LocalDataCenter: &raft.DataCenterConfig{ID: "dc1"},
+		},
+	}
+	config := replication.DefaultReadWriteRouterConfig()
+	config.PrimaryDC = "dc1"
+	config.HealthCheckIntervalMs = int(time.Hour / time.Millisecond)
+	config.MetricsIntervalMs = 20
+	router := replication.NewReadWriteRouterWithConfig("n1", config, raftConfig)
+
+	if err := router.Start(); err != nil {
+		t.Fatalf("启动路由器失败: %v", err)
+	}
+	defer router.Stop()
+
+	for i := 0; i < 50; i++ {
+		routeRead(t, router, "key", replication.ReadConsistencyEventual)
+	}
+
+	waitThroughput := func(desc string, ok func(rps float64) bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !ok(router.GetMetrics().ThroughputRPS) {
+			if time.Now().After(deadline) {
+				t.Fatalf("等待%s超时, ThroughputRPS = %v", desc, router.GetMetrics().ThroughputRPS)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitThroughput("吞吐量大于0", func(rps float64) bool { return rps > 0 })
+	waitThroughput("吞吐量回落为0", func(rps float64) bool { return rps == 0 })
+}
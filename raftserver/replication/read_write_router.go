@@ -11,10 +11,11 @@ package replication
 import (
 	"context"
 	"fmt"
-	"log"
 	"sync"
 	"time"
 
+	"raftserver/kverrors"
+	"raftserver/logging"
 	"raftserver/raft"
 )
 
@@ -29,6 +30,7 @@ type ReadWriteRouterConfig struct {
 	MaxReadLatencyMs      int             `json:"maxReadLatencyMs"`
 	ReadReplicaCount      int             `json:"readReplicaCount"`
 	EnableReadReplication bool            `json:"enableReadReplication"`
+	LearnerReads          bool            `json:"learnerReads"` // 最终一致读优先路由到所选DC中健康的学习者，没有时使用其他节点
 
 	// 写路由策略
 	WriteRoutingStrategy  RoutingStrategy   `json:"writeRoutingStrategy"`
@@ -49,6 +51,7 @@ type ReadWriteRouterConfig struct {
 	// 监控配置
 	EnableMetrics     bool `json:"enableMetrics"`
 	MetricsIntervalMs int  `json:"metricsIntervalMs"`
+	LatencyWindowMs   int  `json:"latencyWindowMs"` // 延迟分位数统计的滑动窗口，<=0时使用DefaultLatencyWindow
 }
 
 // DefaultReadWriteRouterConfig 默认读写分离路由配置
@@ -71,6 +74,7 @@ func DefaultReadWriteRouterConfig() *ReadWriteRouterConfig {
 		EnableLinearizability: false,
 		EnableMetrics:         true,
 		MetricsIntervalMs:     5000,
+		LatencyWindowMs:       int(DefaultLatencyWindow / time.Millisecond),
 	}
 }
 
@@ -121,7 +125,7 @@ type ReadWriteRouter struct {
 	nodeID     raft.NodeID
 	config     *ReadWriteRouterConfig
 	raftConfig *raft.Config
-	logger     *log.Logger
+	logger     logging.Logger
 
 	// 数据中心管理
 	localDC      raft.DataCenterID
@@ -129,6 +133,8 @@ type ReadWriteRouter struct {
 	dataCenters  map[raft.DataCenterID]*DataCenterInfo
 	readReplicas map[raft.DataCenterID][]raft.NodeID
 	writeTargets map[raft.DataCenterID][]raft.NodeID
+	excludedDCs  map[raft.DataCenterID]bool // 故障期间排除在读路由之外的DC，由RestoreDC恢复
+	learners     map[raft.NodeID]bool       // 学习者节点，不作为写目标
 
 	// 路由状态
 	routingTable  *RoutingTable
@@ -137,6 +143,7 @@ type ReadWriteRouter struct {
 
 	// 监控统计
 	metrics *RouterMetrics
+	latency *routerLatencyHistograms
 
 	// 控制流
 	ctx     context.Context
@@ -193,6 +200,7 @@ type RoutingTable struct {
 }
 
 // Route 路由定义
+// 路由表中的路由只在routingTable.mu写锁下修改，请求路由时使用按请求解析出的副本
 type Route struct {
 	// 路由信息
 	ID       string
@@ -232,6 +240,11 @@ type LoadBalancer struct {
 	errorRates map[raft.NodeID]float64
 }
 
+// HealthProbe 探测单个节点是否可达，raft传输层的raft.Pinger实现了该接口
+type HealthProbe interface {
+	Ping(ctx context.Context, target raft.NodeID) error
+}
+
 // HealthChecker 健康检查器
 type HealthChecker struct {
 	mu sync.RWMutex
@@ -241,9 +254,10 @@ type HealthChecker struct {
 	dcHealth   map[raft.DataCenterID]*DCHealthInfo
 
 	// 检查配置
+	probe         HealthProbe // 未设置时不探测，节点保持初始的健康状态
 	checkInterval time.Duration
-	timeout       time.Duration
-	retryCount    int
+	timeout       time.Duration // 单次探测的超时
+	retryCount    int           // 每次检查最多探测的次数，全部失败时节点标记为不健康
 }
 
 // RouterMetrics 路由器指标
@@ -254,14 +268,17 @@ type RouterMetrics struct {
 	TotalRequests  int64
 	ReadRequests   int64
 	WriteRequests  int64
-	RoutingLatency time.Duration
+	RoutingLatency time.Duration // 滑动窗口内的平均路由延迟
 
 	// 成功率统计
 	SuccessfulRoutes int64
 	FailedRoutes     int64
 	RetryCount       int64
 
-	// DC统计
+	// 路由规则统计，按路由ID
+	RouteStats map[string]RouteStats
+
+	// DC统计，DCLatencies为滑动窗口内路由到该DC的平均路由延迟
 	DCRequestCounts map[raft.DataCenterID]int64
 	DCLatencies     map[raft.DataCenterID]time.Duration
 	DCSuccessRates  map[raft.DataCenterID]float64
@@ -272,11 +289,18 @@ type RouterMetrics struct {
 	LinearizableReads int64
 	StaleReadCount    int64
 
-	// 性能统计
-	AverageLatency time.Duration
-	P95Latency     time.Duration
-	P99Latency     time.Duration
-	ThroughputRPS  float64
+	// 性能统计，延迟均值和分位数由滑动窗口内的路由延迟直方图计算
+	AverageLatency       time.Duration
+	P50Latency           time.Duration
+	P95Latency           time.Duration
+	P99Latency           time.Duration
+	RequestTypeLatencies map[RequestType]LatencyPercentiles
+	DCLatencyPercentiles map[raft.DataCenterID]LatencyPercentiles
+	ThroughputRPS        float64 // 最近一个指标周期内的请求速率
+
+	// 上次计算吞吐量时的采样点
+	throughputSampledAt    time.Time
+	throughputSampledTotal int64
 
 	// 错误统计
 	RoutingErrors     int64
@@ -295,16 +319,20 @@ type RouteStats struct {
 type NodeHealthInfo struct {
 	IsHealthy    bool
 	LastCheck    time.Time
-	ResponseTime time.Duration
-	ErrorCount   int64
-	Availability float64
+	ResponseTime time.Duration // 最近一次成功探测的往返延迟
+	ErrorCount   int64         // 累计失败的检查次数
+	Availability float64       // 成功的检查占全部检查的比例
+
+	checks    int64
+	successes int64
 }
 
 type DCHealthInfo struct {
-	IsHealthy      bool
+	IsHealthy      bool // 至少一个节点健康
+	IsDegraded     bool // 部分节点不健康
 	HealthyNodes   int
 	TotalNodes     int
-	AverageLatency time.Duration
+	AverageLatency time.Duration // 健康节点探测延迟的平均值
 	LastUpdate     time.Time
 }
 
@@ -313,7 +341,7 @@ type RoutingDecision struct {
 	RequestType RequestType
 	TargetNode  raft.NodeID
 	TargetDC    raft.DataCenterID
-	Route       *Route
+	Route       *Route // 本次请求解析出的路由副本，修改它不影响路由表
 	Latency     time.Duration
 	Consistency ReadConsistencyLevel
 	CreatedAt   time.Time
@@ -321,16 +349,25 @@ type RoutingDecision struct {
 
 // NewReadWriteRouter 创建读写分离路由器
 func NewReadWriteRouter(nodeID raft.NodeID, raftConfig *raft.Config) *ReadWriteRouter {
-	config := DefaultReadWriteRouterConfig()
+	return NewReadWriteRouterWithConfig(nodeID, nil, raftConfig)
+}
+
+// NewReadWriteRouterWithConfig 创建读写分离路由器，config为nil时使用默认配置
+func NewReadWriteRouterWithConfig(nodeID raft.NodeID, config *ReadWriteRouterConfig, raftConfig *raft.Config) *ReadWriteRouter {
+	if config == nil {
+		config = DefaultReadWriteRouterConfig()
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 
 	router := &ReadWriteRouter{
 		nodeID:       nodeID,
 		config:       config,
 		raftConfig:   raftConfig,
-		logger:       log.New(log.Writer(), fmt.Sprintf("[read-write-router-%s] ", nodeID), log.LstdFlags),
+		logger:       raftConfig.ComponentLogger("read-write-router"),
 		dataCenters:  make(map[raft.DataCenterID]*DataCenterInfo),
 		readReplicas: make(map[raft.DataCenterID][]raft.NodeID),
+		excludedDCs:  make(map[raft.DataCenterID]bool),
+		learners:     make(map[raft.NodeID]bool),
 		writeTargets: make(map[raft.DataCenterID][]raft.NodeID),
 		ctx:          ctx,
 		cancel:       cancel,
@@ -374,16 +411,18 @@ func (rwr *ReadWriteRouter) initializeComponents() {
 
 	// 初始化指标收集器
 	rwr.metrics = &RouterMetrics{
-		DCRequestCounts: make(map[raft.DataCenterID]int64),
-		DCLatencies:     make(map[raft.DataCenterID]time.Duration),
-		DCSuccessRates:  make(map[raft.DataCenterID]float64),
+		DCRequestCounts:     make(map[raft.DataCenterID]int64),
+		DCLatencies:         make(map[raft.DataCenterID]time.Duration),
+		DCSuccessRates:      make(map[raft.DataCenterID]float64),
+		throughputSampledAt: time.Now(),
 	}
+	rwr.latency = newRouterLatencyHistograms(time.Duration(rwr.config.LatencyWindowMs) * time.Millisecond)
 }
 
 // discoverDataCenters 发现数据中心
 func (rwr *ReadWriteRouter) discoverDataCenters() {
 	if rwr.raftConfig.MultiDC == nil || !rwr.raftConfig.MultiDC.Enabled {
-		rwr.logger.Printf("多DC未启用，跳过数据中心发现")
+		rwr.logger.Info("多DC未启用，跳过数据中心发现")
 		return
 	}
 
@@ -397,6 +436,9 @@ func (rwr *ReadWriteRouter) discoverDataCenters() {
 	dcNodes := make(map[raft.DataCenterID][]raft.NodeID)
 	for _, server := range rwr.raftConfig.Servers {
 		dcNodes[server.DataCenter] = append(dcNodes[server.DataCenter], server.ID)
+		if server.IsLearner {
+			rwr.learners[server.ID] = true
+		}
 	}
 
 	// 初始化数据中心信息
@@ -428,7 +470,7 @@ func (rwr *ReadWriteRouter) discoverDataCenters() {
 			rwr.readReplicas[dcID] = nodes
 		}
 		if isPrimary {
-			rwr.writeTargets[dcID] = nodes
+			rwr.writeTargets[dcID] = rwr.votersOf(nodes)
 		}
 
 		// 初始化健康信息
@@ -450,8 +492,7 @@ func (rwr *ReadWriteRouter) discoverDataCenters() {
 			}
 		}
 
-		rwr.logger.Printf("发现数据中心: DC=%s, 节点数=%d, 主DC=%t, 本地=%t",
-			dcID, len(nodes), isPrimary, isLocal)
+		rwr.logger.Info("发现数据中心", "target_dc", dcID, "nodes", len(nodes), "primary", isPrimary, "local", isLocal)
 	}
 
 	// 创建默认路由
@@ -462,7 +503,7 @@ func (rwr *ReadWriteRouter) discoverDataCenters() {
 func (rwr *ReadWriteRouter) createDefaultRoutes() {
 	// 默认读路由：就近原则
 	readRoute := &Route{
-		ID:             "default-read",
+		ID:             defaultReadRouteID,
 		Type:           RequestTypeRead,
 		Pattern:        "*",
 		Priority:       100,
@@ -474,12 +515,10 @@ func (rwr *ReadWriteRouter) createDefaultRoutes() {
 		CreatedAt:      time.Now(),
 	}
 
-	// 设置读路由目标
-	if rwr.config.PreferLocalDC {
+	// 设置读路由目标，本地DC不可读时退回到所有读副本
+	if nodes, exists := rwr.readReplicas[rwr.localDC]; rwr.config.PreferLocalDC && exists {
 		readRoute.TargetDCs = []raft.DataCenterID{rwr.localDC}
-		if nodes, exists := rwr.readReplicas[rwr.localDC]; exists {
-			readRoute.TargetNodes = nodes
-		}
+		readRoute.TargetNodes = nodes
 	} else {
 		for dcID := range rwr.readReplicas {
 			readRoute.TargetDCs = append(readRoute.TargetDCs, dcID)
@@ -487,11 +526,11 @@ func (rwr *ReadWriteRouter) createDefaultRoutes() {
 	}
 
 	rwr.routingTable.defaultReadRoute = readRoute
-	rwr.routingTable.readRoutes["default-read"] = readRoute
+	rwr.routingTable.readRoutes[defaultReadRouteID] = readRoute
 
 	// 默认写路由：主DC
 	writeRoute := &Route{
-		ID:             "default-write",
+		ID:             defaultWriteRouteID,
 		Type:           RequestTypeWrite,
 		Pattern:        "*",
 		Priority:       100,
@@ -508,10 +547,9 @@ func (rwr *ReadWriteRouter) createDefaultRoutes() {
 	}
 
 	rwr.routingTable.defaultWriteRoute = writeRoute
-	rwr.routingTable.writeRoutes["default-write"] = writeRoute
+	rwr.routingTable.writeRoutes[defaultWriteRouteID] = writeRoute
 
-	rwr.logger.Printf("创建默认路由 - 读路由目标DC数=%d, 写路由目标DC=%s",
-		len(readRoute.TargetDCs), rwr.primaryDC)
+	rwr.logger.Info("创建默认路由", "read_dcs", len(readRoute.TargetDCs), "write_dc", rwr.primaryDC)
 }
 
 // Start 启动读写分离路由器
@@ -523,7 +561,7 @@ func (rwr *ReadWriteRouter) Start() error {
 		return fmt.Errorf("读写分离路由器已在运行")
 	}
 
-	rwr.logger.Printf("启动读写分离路由器")
+	rwr.logger.Info("启动读写分离路由器")
 
 	// 启动工作线程
 	rwr.wg.Add(2)
@@ -531,7 +569,7 @@ func (rwr *ReadWriteRouter) Start() error {
 	go rwr.metricsCollectionLoop()
 
 	rwr.running = true
-	rwr.logger.Printf("读写分离路由器启动成功")
+	rwr.logger.Info("读写分离路由器启动成功")
 
 	return nil
 }
@@ -539,23 +577,23 @@ func (rwr *ReadWriteRouter) Start() error {
 // Stop 停止读写分离路由器
 func (rwr *ReadWriteRouter) Stop() error {
 	rwr.mu.Lock()
-	defer rwr.mu.Unlock()
-
 	if !rwr.running {
+		rwr.mu.Unlock()
 		return nil
 	}
 
-	rwr.logger.Printf("停止读写分离路由器")
+	rwr.logger.Info("停止读写分离路由器")
 
 	// 发送停止信号
 	close(rwr.stopCh)
 	rwr.cancel()
+	rwr.running = false
+	rwr.mu.Unlock()
 
-	// 等待工作线程结束
+	// 等待工作线程结束，健康检查需要获取rwr.mu，因此在释放锁之后等待
 	rwr.wg.Wait()
 
-	rwr.running = false
-	rwr.logger.Printf("读写分离路由器已停止")
+	rwr.logger.Info("读写分离路由器已停止")
 
 	return nil
 }
@@ -563,8 +601,9 @@ func (rwr *ReadWriteRouter) Stop() error {
 // RouteRequest 路由请求
 func (rwr *ReadWriteRouter) RouteRequest(requestType RequestType, key string, consistency ReadConsistencyLevel) (*RoutingDecision, error) {
 	start := time.Now()
+	var routedDC raft.DataCenterID
 	defer func() {
-		rwr.updateRoutingMetrics(requestType, time.Since(start))
+		rwr.updateRoutingMetrics(requestType, routedDC, time.Since(start))
 	}()
 
 	rwr.mu.RLock()
@@ -583,9 +622,18 @@ func (rwr *ReadWriteRouter) RouteRequest(requestType RequestType, key string, co
 	}
 
 	// 负载均衡选择节点
-	targetNode, targetDC, err := rwr.selectTargetNode(route)
+	targetNode, targetDC, err := rwr.selectTargetNode(route, consistency)
 	if err != nil {
-		return nil, fmt.Errorf("节点选择失败: %v", err)
+		rwr.recordRouteResult(route.ID, 0, false)
+		return nil, fmt.Errorf("节点选择失败: %w", err)
+	}
+
+	// 有界陈旧读由存在复制延迟的副本处理时计为陈旧读
+	if requestType == RequestTypeRead && consistency == ReadConsistencyBounded &&
+		targetDC != rwr.primaryDC && rwr.replicationLag(targetDC) > 0 {
+		rwr.metrics.mu.Lock()
+		rwr.metrics.StaleReadCount++
+		rwr.metrics.mu.Unlock()
 	}
 
 	// 创建路由决策
@@ -599,41 +647,119 @@ func (rwr *ReadWriteRouter) RouteRequest(requestType RequestType, key string, co
 		CreatedAt:   time.Now(),
 	}
 
-	rwr.logger.Printf("路由决策: 类型=%d, 目标节点=%s, 目标DC=%s, 延迟=%v",
-		requestType, targetNode, targetDC, decision.Latency)
+	rwr.recordRouteResult(route.ID, decision.Latency, true)
+	routedDC = targetDC
+
+	rwr.logger.Debug("路由决策", "route", route.ID, "type", requestType, "target", targetNode, "target_dc", targetDC, "latency", decision.Latency)
 
 	return decision, nil
 }
 
 // 内部方法实现
+
+// selectReadRoute 选择匹配key的自定义读路由，目标DC都不可读时使用默认读路由
+// 返回路由副本，调用方可以修改而不影响路由表
 func (rwr *ReadWriteRouter) selectReadRoute(key string, consistency ReadConsistencyLevel) *Route {
-	// 简化实现：返回默认读路由
-	route := rwr.routingTable.defaultReadRoute
+	rwr.routingTable.mu.RLock()
+	route := rwr.matchCustomRoute(rwr.routingTable.readRoutes, key, rwr.hasReadableTarget)
+	if route == nil {
+		route = rwr.routingTable.defaultReadRoute
+	}
+	if route == nil {
+		rwr.routingTable.mu.RUnlock()
+		return nil
+	}
+	routeCopy := rwr.resolveRoute(route)
+	rwr.routingTable.mu.RUnlock()
 
-	// 根据一致性要求调整路由
-	if consistency == ReadConsistencyStrong || consistency == ReadConsistencyLinearizable {
+	switch consistency {
+	case ReadConsistencyStrong, ReadConsistencyLinearizable:
 		// 强一致性读必须路由到主DC
-		route.TargetDCs = []raft.DataCenterID{rwr.primaryDC}
-		if nodes, exists := rwr.writeTargets[rwr.primaryDC]; exists {
-			route.TargetNodes = nodes
+		rwr.routeToPrimary(routeCopy)
+	case ReadConsistencyBounded:
+		rwr.applyStalenessBound(routeCopy)
+	}
+
+	return routeCopy
+}
+
+// routeToPrimary 把路由副本的目标改为主DC
+func (rwr *ReadWriteRouter) routeToPrimary(route *Route) {
+	route.TargetDCs = []raft.DataCenterID{rwr.primaryDC}
+	route.TargetNodes = rwr.writeTargets[rwr.primaryDC]
+	route.Strategy = RoutingPrimaryDC
+}
+
+// applyStalenessBound 只保留复制延迟低于StaleReadThresholdMs的目标DC，没有满足条件的副本时退回主DC（调用方需持有rwr.mu）
+func (rwr *ReadWriteRouter) applyStalenessBound(route *Route) {
+	threshold := time.Duration(rwr.config.StaleReadThresholdMs) * time.Millisecond
+
+	fresh := make([]raft.DataCenterID, 0, len(route.TargetDCs))
+	for _, dcID := range route.TargetDCs {
+		if dcID == rwr.primaryDC || rwr.replicationLag(dcID) < threshold {
+			fresh = append(fresh, dcID)
 		}
 	}
 
-	route.LastUsed = time.Now()
-	route.UseCount++
+	if len(fresh) == 0 {
+		rwr.routeToPrimary(route)
+		return
+	}
+	if len(fresh) != len(route.TargetDCs) {
+		route.TargetDCs = fresh
+		route.TargetNodes = nil
+	}
+}
+
+// replicationLag DC最近上报的复制延迟，主DC没有延迟（调用方需持有rwr.mu）
+func (rwr *ReadWriteRouter) replicationLag(dcID raft.DataCenterID) time.Duration {
+	if dcID == rwr.primaryDC {
+		return 0
+	}
 
-	return route
+	dcInfo, exists := rwr.dataCenters[dcID]
+	if !exists {
+		return 0
+	}
+	dcInfo.mu.RLock()
+	defer dcInfo.mu.RUnlock()
+
+	return dcInfo.ReplicationLag
 }
 
+// SetReplicationLag 更新DC的复制延迟，有界陈旧读据此选择副本
+func (rwr *ReadWriteRouter) SetReplicationLag(dcID raft.DataCenterID, lag time.Duration) error {
+	rwr.mu.RLock()
+	defer rwr.mu.RUnlock()
+
+	dcInfo, exists := rwr.dataCenters[dcID]
+	if !exists {
+		return fmt.Errorf("数据中心不存在: %s", dcID)
+	}
+
+	dcInfo.mu.Lock()
+	dcInfo.ReplicationLag = lag
+	dcInfo.LastSyncTime = time.Now()
+	dcInfo.mu.Unlock()
+	return nil
+}
+
+// selectWriteRoute 选择匹配key的自定义写路由，写请求总是路由到主DC
 func (rwr *ReadWriteRouter) selectWriteRoute(key string) *Route {
-	// 写请求总是路由到主DC
-	route := rwr.routingTable.defaultWriteRoute
-	route.LastUsed = time.Now()
-	route.UseCount++
-	return route
+	rwr.routingTable.mu.RLock()
+	defer rwr.routingTable.mu.RUnlock()
+
+	route := rwr.matchCustomRoute(rwr.routingTable.writeRoutes, key, nil)
+	if route == nil {
+		route = rwr.routingTable.defaultWriteRoute
+	}
+	if route == nil {
+		return nil
+	}
+	return rwr.resolveRoute(route)
 }
 
-func (rwr *ReadWriteRouter) selectTargetNode(route *Route) (raft.NodeID, raft.DataCenterID, error) {
+func (rwr *ReadWriteRouter) selectTargetNode(route *Route, consistency ReadConsistencyLevel) (raft.NodeID, raft.DataCenterID, error) {
 	if len(route.TargetDCs) == 0 {
 		return "", "", fmt.Errorf("路由没有目标DC")
 	}
@@ -642,7 +768,7 @@ func (rwr *ReadWriteRouter) selectTargetNode(route *Route) (raft.NodeID, raft.Da
 	bestDC := rwr.selectBestDC(route.TargetDCs, route.Strategy)
 
 	// 在选定DC中负载均衡选择节点
-	targetNode, err := rwr.loadBalanceNode(bestDC, route.Type)
+	targetNode, err := rwr.loadBalanceNode(bestDC, route.Type, consistency)
 	if err != nil {
 		return "", "", err
 	}
@@ -697,7 +823,7 @@ func (rwr *ReadWriteRouter) selectLowestLatencyDC(targetDCs []raft.DataCenterID)
 	return bestDC
 }
 
-func (rwr *ReadWriteRouter) loadBalanceNode(dcID raft.DataCenterID, requestType RequestType) (raft.NodeID, error) {
+func (rwr *ReadWriteRouter) loadBalanceNode(dcID raft.DataCenterID, requestType RequestType, consistency ReadConsistencyLevel) (raft.NodeID, error) {
 	var nodes []raft.NodeID
 
 	if requestType == RequestTypeRead {
@@ -711,7 +837,7 @@ func (rwr *ReadWriteRouter) loadBalanceNode(dcID raft.DataCenterID, requestType
 	}
 
 	if len(nodes) == 0 {
-		return "", fmt.Errorf("DC %s 没有可用节点", dcID)
+		return "", fmt.Errorf("%w: DC %s 没有可用节点", kverrors.ErrNoHealthyNodes, dcID)
 	}
 
 	// 过滤健康节点
@@ -723,7 +849,14 @@ func (rwr *ReadWriteRouter) loadBalanceNode(dcID raft.DataCenterID, requestType
 	}
 
 	if len(healthyNodes) == 0 {
-		return "", fmt.Errorf("DC %s 没有健康节点", dcID)
+		return "", fmt.Errorf("%w: DC %s 没有健康节点", kverrors.ErrNoHealthyNodes, dcID)
+	}
+
+	// 最终一致读可以由落后的学习者处理，把读负载从投票成员上移开
+	if requestType == RequestTypeRead && consistency == ReadConsistencyEventual && rwr.config.LearnerReads {
+		if learners := rwr.learnersOf(healthyNodes); len(learners) > 0 {
+			healthyNodes = learners
+		}
 	}
 
 	// 负载均衡选择
@@ -771,28 +904,29 @@ func (rwr *ReadWriteRouter) getExpectedLatency(dcID raft.DataCenterID) time.Dura
 	return 50 * time.Millisecond // 默认延迟
 }
 
-func (rwr *ReadWriteRouter) updateRoutingMetrics(requestType RequestType, latency time.Duration) {
+// updateRoutingMetrics 更新请求计数并把路由延迟计入延迟直方图，路由失败时dcID为空
+func (rwr *ReadWriteRouter) updateRoutingMetrics(requestType RequestType, dcID raft.DataCenterID, latency time.Duration) {
 	rwr.metrics.mu.Lock()
-	defer rwr.metrics.mu.Unlock()
-
 	rwr.metrics.TotalRequests++
 	if requestType == RequestTypeRead {
 		rwr.metrics.ReadRequests++
 	} else {
 		rwr.metrics.WriteRequests++
 	}
-
-	// 更新延迟统计
-	rwr.metrics.RoutingLatency = (rwr.metrics.RoutingLatency + latency) / 2
-	if latency > rwr.metrics.P99Latency {
-		rwr.metrics.P99Latency = latency
+	if dcID == "" {
+		rwr.metrics.FailedRoutes++
+	} else {
+		rwr.metrics.SuccessfulRoutes++
 	}
+	rwr.metrics.mu.Unlock()
+
+	rwr.latency.observe(requestType, dcID, latency, time.Now())
 }
 
 // 工作线程循环
 func (rwr *ReadWriteRouter) healthCheckLoop() {
 	defer rwr.wg.Done()
-	rwr.logger.Printf("健康检查循环已启动")
+	rwr.logger.Debug("健康检查循环已启动")
 
 	ticker := time.NewTicker(rwr.healthChecker.checkInterval)
 	defer ticker.Stop()
@@ -802,7 +936,7 @@ func (rwr *ReadWriteRouter) healthCheckLoop() {
 		case <-ticker.C:
 			rwr.performHealthChecks()
 		case <-rwr.stopCh:
-			rwr.logger.Printf("健康检查循环已停止")
+			rwr.logger.Debug("健康检查循环已停止")
 			return
 		}
 	}
@@ -810,7 +944,7 @@ func (rwr *ReadWriteRouter) healthCheckLoop() {
 
 func (rwr *ReadWriteRouter) metricsCollectionLoop() {
 	defer rwr.wg.Done()
-	rwr.logger.Printf("指标收集循环已启动")
+	rwr.logger.Debug("指标收集循环已启动")
 
 	ticker := time.NewTicker(time.Duration(rwr.config.MetricsIntervalMs) * time.Millisecond)
 	defer ticker.Stop()
@@ -820,56 +954,213 @@ func (rwr *ReadWriteRouter) metricsCollectionLoop() {
 		case <-ticker.C:
 			rwr.updateMetrics()
 		case <-rwr.stopCh:
-			rwr.logger.Printf("指标收集循环已停止")
+			rwr.logger.Debug("指标收集循环已停止")
 			return
 		}
 	}
 }
 
+// SetHealthProbe 设置健康检查使用的探测方式，之后每个检查间隔探测所有节点
+func (rwr *ReadWriteRouter) SetHealthProbe(probe HealthProbe) {
+	rwr.mu.Lock()
+	defer rwr.mu.Unlock()
+
+	rwr.healthChecker.probe = probe
+}
+
+// nodeProbeResult 一次健康检查中单个节点的探测结果
+type nodeProbeResult struct {
+	rtt time.Duration
+	err error
+}
+
+// performHealthChecks 并发探测所有节点，更新节点与DC的健康状态，并把探测延迟交给按延迟负载均衡使用
+// 不健康的节点不再被选为路由目标，再次探测成功后恢复
 func (rwr *ReadWriteRouter) performHealthChecks() {
+	rwr.mu.RLock()
+	probe := rwr.healthChecker.probe
+	var nodes []raft.NodeID
+	for _, dcInfo := range rwr.dataCenters {
+		nodes = append(nodes, dcInfo.Nodes...)
+	}
+	rwr.mu.RUnlock()
+
+	if probe == nil {
+		return
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[raft.NodeID]nodeProbeResult, len(nodes))
+	for _, nodeID := range nodes {
+		wg.Add(1)
+		go func(nodeID raft.NodeID) {
+			defer wg.Done()
+			rtt, err := rwr.probeNode(probe, nodeID)
+			mu.Lock()
+			results[nodeID] = nodeProbeResult{rtt: rtt, err: err}
+			mu.Unlock()
+		}(nodeID)
+	}
+	wg.Wait()
+
+	if rwr.ctx.Err() != nil {
+		return
+	}
+	rwr.applyProbeResults(results)
+}
+
+// probeNode 探测节点，失败时重试，最多探测retryCount次；返回成功那次探测的往返延迟
+func (rwr *ReadWriteRouter) probeNode(probe HealthProbe, nodeID raft.NodeID) (time.Duration, error) {
+	attempts := rwr.healthChecker.retryCount
+	if attempts < 1 {
+		attempts = 1
+	}
+	timeout := rwr.healthChecker.timeout
+	if timeout <= 0 {
+		timeout = rwr.healthChecker.checkInterval
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts && rwr.ctx.Err() == nil; attempt++ {
+		ctx, cancel := context.WithTimeout(rwr.ctx, timeout)
+		start := time.Now()
+		err = probe.Ping(ctx, nodeID)
+		rtt := time.Since(start)
+		cancel()
+		if err == nil {
+			return rtt, nil
+		}
+	}
+	if err == nil {
+		err = rwr.ctx.Err()
+	}
+	return 0, err
+}
+
+// applyProbeResults 按探测结果更新节点与DC的健康状态和延迟
+func (rwr *ReadWriteRouter) applyProbeResults(results map[raft.NodeID]nodeProbeResult) {
+	rwr.mu.Lock()
+	defer rwr.mu.Unlock()
+
+	now := time.Now()
+	latencies := make(map[raft.NodeID]time.Duration, len(results))
 	for dcID, dcInfo := range rwr.dataCenters {
 		healthyCount := 0
+		var totalRTT time.Duration
 
 		for _, nodeID := range dcInfo.Nodes {
-			// 简单的健康检查逻辑
+			result, probed := results[nodeID]
 			nodeHealth := rwr.healthChecker.nodeHealth[nodeID]
-			nodeHealth.LastCheck = time.Now()
+			if !probed || nodeHealth == nil {
+				continue
+			}
 
-			// 模拟健康检查结果（实际应该是网络检查）
-			nodeHealth.IsHealthy = true
-			nodeHealth.ResponseTime = dcInfo.Latency
-			nodeHealth.Availability = 0.99
+			nodeHealth.LastCheck = now
+			nodeHealth.checks++
+			if result.err == nil {
+				nodeHealth.successes++
+				nodeHealth.ResponseTime = result.rtt
+				latencies[nodeID] = result.rtt
+				if !nodeHealth.IsHealthy {
+					rwr.logger.Info("节点探测成功，恢复为健康", "peer", nodeID, "rtt", result.rtt)
+				}
+				nodeHealth.IsHealthy = true
+			} else {
+				nodeHealth.ErrorCount++
+				if nodeHealth.IsHealthy {
+					rwr.logger.Warn("节点探测失败，不再作为路由目标", "peer", nodeID, logging.FieldError, result.err)
+				}
+				nodeHealth.IsHealthy = false
+			}
+			nodeHealth.Availability = float64(nodeHealth.successes) / float64(nodeHealth.checks)
 
 			if nodeHealth.IsHealthy {
 				healthyCount++
+				totalRTT += nodeHealth.ResponseTime
 			}
 		}
 
-		// 更新DC健康状态
+		// 更新DC健康状态：部分节点不健康时降级，全部不健康时DC不健康
 		dcHealth := rwr.healthChecker.dcHealth[dcID]
+		degraded := healthyCount > 0 && healthyCount < len(dcInfo.Nodes)
+		if degraded && !dcHealth.IsDegraded {
+			rwr.logger.Warn("DC降级", "target_dc", dcID, "healthy_nodes", healthyCount, "nodes", len(dcInfo.Nodes))
+		}
 		dcHealth.HealthyNodes = healthyCount
+		dcHealth.TotalNodes = len(dcInfo.Nodes)
 		dcHealth.IsHealthy = healthyCount > 0
-		dcHealth.LastUpdate = time.Now()
+		dcHealth.IsDegraded = degraded
+		dcHealth.LastUpdate = now
+		if healthyCount > 0 {
+			dcHealth.AverageLatency = totalRTT / time.Duration(healthyCount)
+		}
 
+		dcInfo.mu.Lock()
 		dcInfo.IsHealthy = dcHealth.IsHealthy
+		dcInfo.LastPing = now
+		if healthyCount > 0 {
+			dcInfo.Latency = dcHealth.AverageLatency
+		} else {
+			dcInfo.FailureCount++
+		}
+		dcInfo.mu.Unlock()
+
+		rwr.logger.Debug("健康检查", "target_dc", dcID, "healthy_nodes", healthyCount, "nodes", len(dcInfo.Nodes))
+	}
+
+	rwr.loadBalancer.mu.Lock()
+	for nodeID, latency := range latencies {
+		rwr.loadBalancer.latencyMap[nodeID] = latency
+	}
+	for nodeID := range results {
+		if nodeHealth := rwr.healthChecker.nodeHealth[nodeID]; nodeHealth != nil {
+			rwr.loadBalancer.errorRates[nodeID] = 1 - nodeHealth.Availability
+		}
+	}
+	rwr.loadBalancer.mu.Unlock()
+}
 
-		rwr.logger.Printf("健康检查: DC=%s, 健康节点=%d/%d",
-			dcID, healthyCount, len(dcInfo.Nodes))
+// GetNodeHealth 获取各节点健康状态的副本
+func (rwr *ReadWriteRouter) GetNodeHealth() map[raft.NodeID]NodeHealthInfo {
+	rwr.mu.RLock()
+	defer rwr.mu.RUnlock()
+
+	health := make(map[raft.NodeID]NodeHealthInfo, len(rwr.healthChecker.nodeHealth))
+	for nodeID, info := range rwr.healthChecker.nodeHealth {
+		health[nodeID] = *info
+	}
+	return health
+}
+
+// GetDCHealth 获取各DC健康状态的副本
+func (rwr *ReadWriteRouter) GetDCHealth() map[raft.DataCenterID]DCHealthInfo {
+	rwr.mu.RLock()
+	defer rwr.mu.RUnlock()
+
+	health := make(map[raft.DataCenterID]DCHealthInfo, len(rwr.healthChecker.dcHealth))
+	for dcID, info := range rwr.healthChecker.dcHealth {
+		health[dcID] = *info
 	}
+	return health
 }
 
 func (rwr *ReadWriteRouter) updateMetrics() {
 	rwr.metrics.mu.Lock()
 	defer rwr.metrics.mu.Unlock()
 
-	// 计算吞吐量
-	rwr.metrics.ThroughputRPS = float64(rwr.metrics.TotalRequests) /
-		time.Since(time.Now().Add(-time.Duration(rwr.config.MetricsIntervalMs)*time.Millisecond)).Seconds()
+	// 按上次采样以来的请求增量计算吞吐量
+	now := time.Now()
+	if elapsed := now.Sub(rwr.metrics.throughputSampledAt).Seconds(); elapsed > 0 {
+		rwr.metrics.ThroughputRPS = float64(rwr.metrics.TotalRequests-rwr.metrics.throughputSampledTotal) / elapsed
+	}
+	rwr.metrics.throughputSampledAt = now
+	rwr.metrics.throughputSampledTotal = rwr.metrics.TotalRequests
 
 	// 更新DC统计
 	for dcID := range rwr.dataCenters {
 		if count, exists := rwr.metrics.DCRequestCounts[dcID]; exists {
-			rwr.logger.Printf("DC指标: %s, 请求数=%d", dcID, count)
+			rwr.logger.Debug("DC指标", "target_dc", dcID, "requests", count)
 		}
 	}
 }
@@ -882,6 +1173,7 @@ func (rwr *ReadWriteRouter) GetMetrics() *RouterMetrics {
 	// 创建指标副本
 	metricsCopy := &RouterMetrics{}
 	*metricsCopy = *rwr.metrics
+	metricsCopy.RouteStats = rwr.routeStatsSnapshot()
 	metricsCopy.DCRequestCounts = make(map[raft.DataCenterID]int64)
 	metricsCopy.DCLatencies = make(map[raft.DataCenterID]time.Duration)
 	metricsCopy.DCSuccessRates = make(map[raft.DataCenterID]float64)
@@ -889,16 +1181,229 @@ func (rwr *ReadWriteRouter) GetMetrics() *RouterMetrics {
 	for dcID, count := range rwr.metrics.DCRequestCounts {
 		metricsCopy.DCRequestCounts[dcID] = count
 	}
-	for dcID, latency := range rwr.metrics.DCLatencies {
-		metricsCopy.DCLatencies[dcID] = latency
-	}
 	for dcID, rate := range rwr.metrics.DCSuccessRates {
 		metricsCopy.DCSuccessRates[dcID] = rate
 	}
 
+	// 延迟统计由直方图在当前时刻的窗口计算
+	histograms := rwr.latency.snapshot(time.Now())
+	overall := histograms.Overall.Percentiles()
+	metricsCopy.RoutingLatency = overall.Mean
+	metricsCopy.AverageLatency = overall.Mean
+	metricsCopy.P50Latency = overall.P50
+	metricsCopy.P95Latency = overall.P95
+	metricsCopy.P99Latency = overall.P99
+	metricsCopy.RequestTypeLatencies = make(map[RequestType]LatencyPercentiles, len(histograms.ByRequestType))
+	for requestType, histogram := range histograms.ByRequestType {
+		metricsCopy.RequestTypeLatencies[requestType] = histogram.Percentiles()
+	}
+	metricsCopy.DCLatencyPercentiles = make(map[raft.DataCenterID]LatencyPercentiles, len(histograms.ByDC))
+	for dcID, histogram := range histograms.ByDC {
+		percentiles := histogram.Percentiles()
+		metricsCopy.DCLatencyPercentiles[dcID] = percentiles
+		metricsCopy.DCLatencies[dcID] = percentiles.Mean
+	}
+
 	return metricsCopy
 }
 
+// FailedRoutes 累计路由失败的请求数
+func (rwr *ReadWriteRouter) FailedRoutes() int64 {
+	rwr.metrics.mu.RLock()
+	defer rwr.metrics.mu.RUnlock()
+	return rwr.metrics.FailedRoutes
+}
+
+// GetLatencyHistograms 获取路由延迟直方图在当前时刻的窗口统计
+func (rwr *ReadWriteRouter) GetLatencyHistograms() *RouterLatencyHistograms {
+	return rwr.latency.snapshot(time.Now())
+}
+
+// PromotePrimaryDC 把dcID提升为主DC，写目标和默认路由在同一把锁内一次性切换
+// 未启用多读副本时，原主DC不再作为读副本
+func (rwr *ReadWriteRouter) PromotePrimaryDC(dcID raft.DataCenterID) error {
+	rwr.mu.Lock()
+	defer rwr.mu.Unlock()
+
+	newPrimary, exists := rwr.dataCenters[dcID]
+	if !exists {
+		return fmt.Errorf("数据中心不存在: %s", dcID)
+	}
+
+	oldPrimaryDC := rwr.primaryDC
+	if oldPrimaryDC == dcID {
+		return nil
+	}
+
+	if oldPrimary, exists := rwr.dataCenters[oldPrimaryDC]; exists {
+		oldPrimary.mu.Lock()
+		oldPrimary.IsPrimary = false
+		oldPrimary.mu.Unlock()
+	}
+	newPrimary.mu.Lock()
+	newPrimary.IsPrimary = true
+	nodes := append([]raft.NodeID(nil), newPrimary.Nodes...)
+	newPrimary.mu.Unlock()
+
+	rwr.primaryDC = dcID
+	rwr.writeTargets = map[raft.DataCenterID][]raft.NodeID{dcID: rwr.votersOf(nodes)}
+	if !rwr.config.EnableReadReplication {
+		delete(rwr.readReplicas, oldPrimaryDC)
+	}
+	if !rwr.excludedDCs[dcID] {
+		rwr.readReplicas[dcID] = nodes
+	}
+
+	rwr.routingTable.mu.Lock()
+	rwr.createDefaultRoutes()
+	rwr.routingTable.mu.Unlock()
+
+	rwr.logger.Info("主DC切换", "from_dc", oldPrimaryDC, "primary_dc", dcID)
+	return nil
+}
+
+// ExcludeDC 把故障DC移出读路由，直到RestoreDC确认它已稳定恢复
+func (rwr *ReadWriteRouter) ExcludeDC(dcID raft.DataCenterID) error {
+	rwr.mu.Lock()
+	defer rwr.mu.Unlock()
+
+	if _, exists := rwr.dataCenters[dcID]; !exists {
+		return fmt.Errorf("数据中心不存在: %s", dcID)
+	}
+	if rwr.excludedDCs[dcID] {
+		return nil
+	}
+
+	rwr.excludedDCs[dcID] = true
+	delete(rwr.readReplicas, dcID)
+
+	rwr.routingTable.mu.Lock()
+	rwr.createDefaultRoutes()
+	rwr.routingTable.mu.Unlock()
+
+	rwr.logger.Warn("DC已排除在读路由之外", "target_dc", dcID)
+	return nil
+}
+
+// RestoreDC 把恢复稳定的DC重新加入读副本
+func (rwr *ReadWriteRouter) RestoreDC(dcID raft.DataCenterID) error {
+	rwr.mu.Lock()
+	defer rwr.mu.Unlock()
+
+	dcInfo, exists := rwr.dataCenters[dcID]
+	if !exists {
+		return fmt.Errorf("数据中心不存在: %s", dcID)
+	}
+	if !rwr.excludedDCs[dcID] {
+		return nil
+	}
+
+	delete(rwr.excludedDCs, dcID)
+	if dcID == rwr.primaryDC || rwr.config.EnableReadReplication {
+		dcInfo.mu.RLock()
+		rwr.readReplicas[dcID] = append([]raft.NodeID(nil), dcInfo.Nodes...)
+		dcInfo.mu.RUnlock()
+	}
+
+	rwr.routingTable.mu.Lock()
+	rwr.createDefaultRoutes()
+	rwr.routingTable.mu.Unlock()
+
+	rwr.logger.Info("DC已恢复读路由", "target_dc", dcID)
+	return nil
+}
+
+// SetLearner 在学习者加入或被提升为投票成员后更新节点角色，学习者不作为写目标
+func (rwr *ReadWriteRouter) SetLearner(nodeID raft.NodeID, learner bool) error {
+	rwr.mu.Lock()
+	defer rwr.mu.Unlock()
+
+	var nodeDC raft.DataCenterID
+	found := false
+	for dcID, dcInfo := range rwr.dataCenters {
+		dcInfo.mu.RLock()
+		for _, id := range dcInfo.Nodes {
+			if id == nodeID {
+				nodeDC, found = dcID, true
+			}
+		}
+		dcInfo.mu.RUnlock()
+	}
+	if !found {
+		return fmt.Errorf("节点不存在: %s", nodeID)
+	}
+	if rwr.learners[nodeID] == learner {
+		return nil
+	}
+
+	if learner {
+		rwr.learners[nodeID] = true
+	} else {
+		delete(rwr.learners, nodeID)
+	}
+
+	if nodeDC == rwr.primaryDC {
+		dcInfo := rwr.dataCenters[nodeDC]
+		dcInfo.mu.RLock()
+		rwr.writeTargets[nodeDC] = rwr.votersOf(dcInfo.Nodes)
+		dcInfo.mu.RUnlock()
+
+		rwr.routingTable.mu.Lock()
+		rwr.createDefaultRoutes()
+		rwr.routingTable.mu.Unlock()
+	}
+
+	rwr.logger.Info("节点角色变更", "node", nodeID, "target_dc", nodeDC, "learner", learner)
+	return nil
+}
+
+// IsLearner 判断节点当前是否为学习者
+func (rwr *ReadWriteRouter) IsLearner(nodeID raft.NodeID) bool {
+	rwr.mu.RLock()
+	defer rwr.mu.RUnlock()
+
+	return rwr.learners[nodeID]
+}
+
+// votersOf 过滤掉学习者，返回新切片（调用方需持有rwr.mu）
+func (rwr *ReadWriteRouter) votersOf(nodes []raft.NodeID) []raft.NodeID {
+	voters := make([]raft.NodeID, 0, len(nodes))
+	for _, nodeID := range nodes {
+		if !rwr.learners[nodeID] {
+			voters = append(voters, nodeID)
+		}
+	}
+	return voters
+}
+
+// learnersOf 返回nodes中的学习者（调用方需持有rwr.mu）
+func (rwr *ReadWriteRouter) learnersOf(nodes []raft.NodeID) []raft.NodeID {
+	var learners []raft.NodeID
+	for _, nodeID := range nodes {
+		if rwr.learners[nodeID] {
+			learners = append(learners, nodeID)
+		}
+	}
+	return learners
+}
+
+// IsReadReplica 判断DC当前是否承担读请求
+func (rwr *ReadWriteRouter) IsReadReplica(dcID raft.DataCenterID) bool {
+	rwr.mu.RLock()
+	defer rwr.mu.RUnlock()
+
+	_, exists := rwr.readReplicas[dcID]
+	return exists
+}
+
+// GetPrimaryDC 获取当前主DC
+func (rwr *ReadWriteRouter) GetPrimaryDC() raft.DataCenterID {
+	rwr.mu.RLock()
+	defer rwr.mu.RUnlock()
+
+	return rwr.primaryDC
+}
+
 // GetDataCenterInfo 获取数据中心信息
 func (rwr *ReadWriteRouter) GetDataCenterInfo() map[raft.DataCenterID]*DataCenterInfo {
 	rwr.mu.RLock()
@@ -906,9 +1411,28 @@ func (rwr *ReadWriteRouter) GetDataCenterInfo() map[raft.DataCenterID]*DataCente
 
 	dcInfoCopy := make(map[raft.DataCenterID]*DataCenterInfo)
 	for dcID, dcInfo := range rwr.dataCenters {
-		infoCopy := &DataCenterInfo{}
-		*infoCopy = *dcInfo
-		dcInfoCopy[dcID] = infoCopy
+		// 逐字段复制，避免复制锁并与SetReplicationLag等并发更新冲突
+		dcInfo.mu.RLock()
+		dcInfoCopy[dcID] = &DataCenterInfo{
+			ID:                dcInfo.ID,
+			Region:            dcInfo.Region,
+			Nodes:             append([]raft.NodeID(nil), dcInfo.Nodes...),
+			IsPrimary:         dcInfo.IsPrimary,
+			IsLocal:           dcInfo.IsLocal,
+			Latency:           dcInfo.Latency,
+			Bandwidth:         dcInfo.Bandwidth,
+			IsHealthy:         dcInfo.IsHealthy,
+			LastPing:          dcInfo.LastPing,
+			FailureCount:      dcInfo.FailureCount,
+			ActiveConnections: dcInfo.ActiveConnections,
+			RequestsPerSecond: dcInfo.RequestsPerSecond,
+			CPUUsage:          dcInfo.CPUUsage,
+			MemoryUsage:       dcInfo.MemoryUsage,
+			ReplicationLag:    dcInfo.ReplicationLag,
+			LastSyncTime:      dcInfo.LastSyncTime,
+			ConsistencyLevel:  dcInfo.ConsistencyLevel,
+		}
+		dcInfo.mu.RUnlock()
 	}
 
 	return dcInfoCopy
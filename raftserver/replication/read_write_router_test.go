@@ -0,0 +1,304 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-4 21:03:44
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-4 21:03:44
+* @Description: ConcordKV 读写分离路由器有界陈旧读测试
+ */
+package replication_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"raftserver/kverrors"
+	"raftserver/raft"
+	"raftserver/replication"
+)
+
+// newBoundedTestRouter 创建本地DC为dc1、主DC为dc2、另有dc3的路由器，陈旧读阈值为100ms
+func newBoundedTestRouter(preferLocal bool) *replication.ReadWriteRouter {
+	raftConfig := &raft.Config{
+		NodeID: "n1",
+		Servers: []raft.Server{
+			{ID: "n1", DataCenter: "dc1"},
+			{ID: "n2", DataCenter: "dc2"},
+			{ID: "n3", DataCenter: "dc3"},
+		},
+		MultiDC: &raft.MultiDCConfig{
+			Enabled:         true,
+			LocalDataCenter: &raft.DataCenterConfig{ID: "dc1"},
+		},
+	}
+
+	config := replication.DefaultReadWriteRouterConfig()
+	config.PrimaryDC = "dc2"
+	config.PreferLocalDC = preferLocal
+	config.StaleReadThresholdMs = 100
+	return replication.NewReadWriteRouterWithConfig("n1", config, raftConfig)
+}
+
+// setLag 设置DC的复制延迟
+func setLag(t *testing.T, router *replication.ReadWriteRouter, dcID raft.DataCenterID, lag time.Duration) {
+	t.Helper()
+
+	if err := router.SetReplicationLag(dcID, lag); err != nil {
+		t.Fatalf("设置 %s 复制延迟失败: %v", dcID, err)
+	}
+}
+
+// TestRouterBoundedReadFallsBackToPrimary 本地副本延迟超过阈值后有界陈旧读改由主DC处理
+func TestRouterBoundedReadFallsBackToPrimary(t *testing.T) {
+	router := newBoundedTestRouter(true)
+
+	steps := []struct {
+		lag        time.Duration
+		wantDC     raft.DataCenterID
+		wantStales int64
+	}{
+		{lag: 0, wantDC: "dc1", wantStales: 0},
+		{lag: 50 * time.Millisecond, wantDC: "dc1", wantStales: 1},
+		{lag: 99 * time.Millisecond, wantDC: "dc1", wantStales: 2},
+		{lag: 100 * time.Millisecond, wantDC: "dc2", wantStales: 2},
+		{lag: 5 * time.Second, wantDC: "dc2", wantStales: 2},
+		{lag: 10 * time.Millisecond, wantDC: "dc1", wantStales: 3},
+	}
+	for _, step := range steps {
+		setLag(t, router, "dc1", step.lag)
+
+		if decision := routeRead(t, router, "key", replication.ReadConsistencyBounded); decision.TargetDC != step.wantDC {
+			t.Fatalf("延迟 %v 时有界陈旧读路由到 %s, 期望 %s", step.lag, decision.TargetDC, step.wantDC)
+		}
+		if stale := router.GetMetrics().StaleReadCount; stale != step.wantStales {
+			t.Fatalf("延迟 %v 时陈旧读计数 = %d, 期望 %d", step.lag, stale, step.wantStales)
+		}
+	}
+
+	// 最终一致性读不受复制延迟影响，也不计入陈旧读
+	setLag(t, router, "dc1", 5*time.Second)
+	if decision := routeRead(t, router, "key", replication.ReadConsistencyEventual); decision.TargetDC != "dc1" {
+		t.Fatalf("最终一致性读路由到 %s, 期望 dc1", decision.TargetDC)
+	}
+	if stale := router.GetMetrics().StaleReadCount; stale != 3 {
+		t.Fatalf("最终一致性读改变了陈旧读计数: %d", stale)
+	}
+}
+
+// TestRouterBoundedReadSkipsLaggingReplicas 有多个读副本时只在延迟未超过阈值的副本中选择
+func TestRouterBoundedReadSkipsLaggingReplicas(t *testing.T) {
+	router := newBoundedTestRouter(false)
+
+	setLag(t, router, "dc1", time.Second)
+	setLag(t, router, "dc3", 20*time.Millisecond)
+	for i := 0; i < 20; i++ {
+		decision := routeRead(t, router, "key", replication.ReadConsistencyBounded)
+		if decision.TargetDC == "dc1" {
+			t.Fatalf("有界陈旧读路由到延迟超过阈值的 dc1")
+		}
+		for _, dcID := range decision.Route.TargetDCs {
+			if dcID == "dc1" {
+				t.Fatalf("有界陈旧读的候选DC包含 dc1: %v", decision.Route.TargetDCs)
+			}
+		}
+	}
+
+	// 主DC的延迟记录不影响它作为有界陈旧读的后备
+	setLag(t, router, "dc3", time.Second)
+	setLag(t, router, "dc2", time.Second)
+	if decision := routeRead(t, router, "key", replication.ReadConsistencyBounded); decision.TargetDC != "dc2" {
+		t.Fatalf("所有副本延迟超过阈值时路由到 %s, 期望主DC dc2", decision.TargetDC)
+	}
+
+	if err := router.SetReplicationLag("dc9", time.Second); err == nil {
+		t.Fatalf("未知DC应返回错误")
+	}
+}
+
+// TestRouterLearnerReads 最终一致读优先由学习者处理，写与强一致读不路由到学习者；提升后学习者成为写目标
+func TestRouterLearnerReads(t *testing.T) {
+	raftConfig := &raft.Config{
+		NodeID: "n1",
+		Servers: []raft.Server{
+			{ID: "n1", DataCenter: "dc1"},
+			{ID: "n2", DataCenter: "dc1"},
+			{ID: "n3", DataCenter: "dc1", IsLearner: true},
+		},
+		MultiDC: &raft.MultiDCConfig{
+			Enabled:         true,
+			LocalDataCenter: &raft.DataCenterConfig{ID: "dc1"},
+		},
+	}
+	config := replication.DefaultReadWriteRouterConfig()
+	config.LearnerReads = true
+	router := replication.NewReadWriteRouterWithConfig("n1", config, raftConfig)
+
+	if !router.IsLearner("n3") || router.IsLearner("n1") {
+		t.Fatalf("学习者识别错误")
+	}
+	for i := 0; i < 6; i++ {
+		if decision := routeRead(t, router, "key", replication.ReadConsistencyEventual); decision.TargetNode != "n3" {
+			t.Fatalf("最终一致读路由到 %s, 期望学习者 n3", decision.TargetNode)
+		}
+		if decision := routeRead(t, router, "key", replication.ReadConsistencyStrong); decision.TargetNode == "n3" {
+			t.Fatalf("强一致读路由到了学习者")
+		}
+		decision, err := router.RouteRequest(replication.RequestTypeWrite, "key", replication.ReadConsistencyStrong)
+		if err != nil {
+			t.Fatalf("写路由失败: %v", err)
+		}
+		if decision.TargetNode == "n3" {
+			t.Fatalf("写请求路由到了学习者")
+		}
+	}
+
+	// 提升后n3成为普通副本，与其他节点轮流承担读写
+	if err := router.SetLearner("n3", false); err != nil {
+		t.Fatalf("更新学习者角色失败: %v", err)
+	}
+	writes := make(map[raft.NodeID]bool)
+	for i := 0; i < 6; i++ {
+		decision, err := router.RouteRequest(replication.RequestTypeWrite, "key", replication.ReadConsistencyStrong)
+		if err != nil {
+			t.Fatalf("写路由失败: %v", err)
+		}
+		writes[decision.TargetNode] = true
+	}
+	if !writes["n3"] || len(writes) != 3 {
+		t.Fatalf("提升后写目标应包含全部三个节点，实际 %v", writes)
+	}
+
+	if err := router.SetLearner("n9", true); err == nil {
+		t.Fatalf("未知节点应返回错误")
+	}
+}
+
+// TestRouterNoHealthyNodes 主DC没有写目标时写路由失败，错误可用errors.Is判断为kverrors.ErrNoHealthyNodes
+func TestRouterNoHealthyNodes(t *testing.T) {
+	router := newBoundedTestRouter(false)
+	if err := router.SetLearner("n2", true); err != nil {
+		t.Fatalf("更新学习者角色失败: %v", err)
+	}
+
+	_, err := router.RouteRequest(replication.RequestTypeWrite, "key", replication.ReadConsistencyStrong)
+	if !errors.Is(err, kverrors.ErrNoHealthyNodes) {
+		t.Fatalf("主DC只有学习者时写路由应返回ErrNoHealthyNodes，实际: %v", err)
+	}
+	if failed := router.FailedRoutes(); failed != 1 {
+		t.Errorf("失败的路由数 = %d, 期望 1", failed)
+	}
+}
+
+// stubProbe 按节点配置探测延迟的健康探测，hang的节点直到探测超时才返回
+type stubProbe struct {
+	mu     sync.Mutex
+	delays map[raft.NodeID]time.Duration
+	hang   map[raft.NodeID]bool
+}
+
+func (p *stubProbe) Ping(ctx context.Context, target raft.NodeID) error {
+	p.mu.Lock()
+	delay, hang := p.delays[target], p.hang[target]
+	p.mu.Unlock()
+
+	if hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *stubProbe) setHang(id raft.NodeID, hang bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hang[id] = hang
+}
+
+// waitReadTarget 等待最终一致读路由到want，返回等待的时间
+func waitReadTarget(t *testing.T, router *replication.ReadWriteRouter, want raft.NodeID, timeout time.Duration) time.Duration {
+	t.Helper()
+
+	start := time.Now()
+	for {
+		decision, err := router.RouteRequest(replication.RequestTypeRead, "key", replication.ReadConsistencyEventual)
+		if err == nil && decision.TargetNode == want {
+			return time.Since(start)
+		}
+		if time.Since(start) > timeout {
+			t.Fatalf("%v 内读请求未路由到 %s，最近一次为 %v %v", timeout, want, decision, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestRouterHealthCheckExcludesTimedOutNode 健康检查以探测延迟选择最低延迟节点；节点探测超时后两个检查间隔内不再被选中，恢复后重新使用
+func TestRouterHealthCheckExcludesTimedOutNode(t *testing.T) {
+	const interval = 100 * time.Millisecond
+
+	raftConfig := &raft.Config{
+		NodeID: "n1",
+		Servers: []raft.Server{
+			{ID: "n1", DataCenter: "dc1"},
+			{ID: "n2", DataCenter: "dc1"},
+			{ID: "n3", DataCenter: "dc1"},
+		},
+		MultiDC: &raft.MultiDCConfig{
+			Enabled:         true,
+			LocalDataCenter: &raft.DataCenterConfig{ID: "dc1"},
+		},
+	}
+	config := replication.DefaultReadWriteRouterConfig()
+	config.LoadBalancingMethod = replication.LoadBalanceLeastLatency
+	config.HealthCheckIntervalMs = int(interval / time.Millisecond)
+	config.RetryTimeoutMs = 20
+	config.RetryAttempts = 2
+	router := replication.NewReadWriteRouterWithConfig("n1", config, raftConfig)
+
+	probe := &stubProbe{
+		delays: map[raft.NodeID]time.Duration{"n1": 8 * time.Millisecond, "n2": time.Millisecond, "n3": 4 * time.Millisecond},
+		hang:   make(map[raft.NodeID]bool),
+	}
+	router.SetHealthProbe(probe)
+	if err := router.Start(); err != nil {
+		t.Fatalf("启动路由器失败: %v", err)
+	}
+	defer router.Stop()
+
+	// 第一次检查后按探测延迟选择n2
+	waitReadTarget(t, router, "n2", 3*interval)
+	if health := router.GetNodeHealth()["n2"]; !health.IsHealthy || health.ResponseTime <= 0 || health.Availability != 1 {
+		t.Fatalf("n2健康信息不符: %+v", health)
+	}
+
+	// n2探测超时后两个检查间隔内改为延迟次低的n3
+	probe.setHang("n2", true)
+	if elapsed := waitReadTarget(t, router, "n3", 3*interval); elapsed > 2*interval {
+		t.Fatalf("探测超时的节点 %v 后才被排除，应在两个检查间隔内", elapsed)
+	}
+	for i := 0; i < 10; i++ {
+		if decision := routeRead(t, router, "key", replication.ReadConsistencyEventual); decision.TargetNode == "n2" {
+			t.Fatalf("探测超时的节点仍被选中")
+		}
+	}
+	health := router.GetNodeHealth()["n2"]
+	if health.IsHealthy || health.ErrorCount == 0 || health.Availability >= 1 {
+		t.Fatalf("n2应被标记为不健康: %+v", health)
+	}
+	if dc := router.GetDCHealth()["dc1"]; !dc.IsHealthy || !dc.IsDegraded || dc.HealthyNodes != 2 || dc.TotalNodes != 3 {
+		t.Fatalf("dc1应降级为2/3个健康节点: %+v", dc)
+	}
+
+	// 恢复后重新成为最低延迟节点
+	probe.setHang("n2", false)
+	waitReadTarget(t, router, "n2", 3*interval)
+	if dc := router.GetDCHealth()["dc1"]; dc.IsDegraded || dc.HealthyNodes != 3 {
+		t.Fatalf("n2恢复后dc1不应再降级: %+v", dc)
+	}
+}
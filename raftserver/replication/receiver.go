@@ -0,0 +1,377 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-8-3 10:12:40
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-8-3 10:12:40
+* @Description: ConcordKV 跨DC复制接收端 - 校验、去重并按顺序应用其他DC发来的复制批次
+ */
+
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"raftserver/clock"
+	"raftserver/logging"
+	"raftserver/raft"
+)
+
+var (
+	// ErrReplicationSourceRejected 批次的源DC、目标DC或发送节点不在允许的范围内
+	ErrReplicationSourceRejected = errors.New("拒绝未授权的复制来源")
+	// ErrReplicationBatchInvalid 批次中的条目与声明的位置不连续
+	ErrReplicationBatchInvalid = errors.New("复制批次无效")
+)
+
+// ReplicationApplier 把其他DC的条目应用到本集群，高水位需要持久化，重启或切换领导者后不会重复应用
+type ReplicationApplier interface {
+	// ReplicatedIndex 源DC已应用的最后一个条目的索引与任期，尚未应用过时返回0
+	ReplicatedIndex(source raft.DataCenterID) (raft.LogIndex, raft.Term, error)
+	// ApplyReplicated 应用紧接高水位的一段条目，返回后高水位推进到最后一个条目；已应用过的条目被跳过
+	ApplyReplicated(ctx context.Context, source raft.DataCenterID, entries []raft.LogEntry) error
+}
+
+// ReceiverConfig 跨DC复制接收端配置
+type ReceiverConfig struct {
+	// LocalDataCenter 本集群所在的DC，目标DC不同的批次被拒绝
+	LocalDataCenter raft.DataCenterID `json:"localDataCenter"`
+
+	// Sources 允许发送复制批次的源DC及其节点，节点列表为空时接受该DC的任意节点
+	// 只校验请求中声明的身份，链路本身的认证依赖节点间的TLS
+	Sources map[raft.DataCenterID][]raft.NodeID `json:"sources"`
+
+	MaxBufferedBatches int           `json:"maxBufferedBatches"` // 每个源DC缓存的乱序批次上限
+	DedupCacheSize     int           `json:"dedupCacheSize"`     // 每个源DC记住的已处理批次数，用于识别重复投递
+	ApplyTimeout       time.Duration `json:"applyTimeout"`       // 应用一段条目的超时时间
+}
+
+// DefaultReceiverConfig 默认接收端配置，LocalDataCenter与Sources需要调用方填写
+func DefaultReceiverConfig() *ReceiverConfig {
+	return &ReceiverConfig{
+		Sources:            make(map[raft.DataCenterID][]raft.NodeID),
+		MaxBufferedBatches: 64,
+		DedupCacheSize:     1024,
+		ApplyTimeout:       5 * time.Second,
+	}
+}
+
+// InboundStats 来自一个源DC的复制统计
+type InboundStats struct {
+	SourceDC           raft.DataCenterID `json:"sourceDC"`
+	LastProcessedIndex raft.LogIndex     `json:"lastProcessedIndex"`
+	Batches            uint64            `json:"batches"`           // 收到的批次数，包括重复投递
+	AppliedEntries     uint64            `json:"appliedEntries"`    // 应用到本集群的条目数
+	DuplicateBatches   uint64            `json:"duplicateBatches"`  // 重复投递或条目全部已应用过的批次数
+	OutOfOrderBatches  uint64            `json:"outOfOrderBatches"` // 先于之前的批次到达、被缓存的批次数
+	BufferedBatches    int               `json:"bufferedBatches"`   // 当前缓存中等待空洞填上的批次数
+	Conflicts          uint64            `json:"conflicts"`         // 前一条目任期不匹配的批次数
+	Rejected           uint64            `json:"rejected"`          // 校验失败的批次数
+	LastBatchTime      time.Time         `json:"lastBatchTime"`
+}
+
+// ReplicationReceiver 跨DC复制接收端，实现transport.CompressedAppendEntriesHandler
+// 每个源DC的批次串行处理：按(batchID, sequenceNum)识别重复投递，紧接高水位的批次立即应用，
+// 先于之前的批次到达的批次缓存到空洞填上；响应中的LastProcessedIndex是源DC的高水位，
+// 发送方据此确认不大于它的条目
+type ReplicationReceiver struct {
+	nodeID  raft.NodeID
+	config  *ReceiverConfig
+	applier ReplicationApplier
+	logger  logging.Logger
+	clock   clock.Clock
+
+	mu      sync.Mutex
+	sources map[raft.DataCenterID]*inboundSource
+}
+
+// inboundSource 一个源DC的接收状态
+type inboundSource struct {
+	mu sync.Mutex
+	id raft.DataCenterID
+
+	buffered  map[raft.LogIndex]*bufferedBatch // 乱序到达的批次，按PrevLogIndex索引
+	processed map[batchKey]*raft.CompressedAppendEntriesResponse
+	order     []batchKey // 已处理批次的记录顺序，超出DedupCacheSize时淘汰最早的
+	stats     InboundStats
+}
+
+// bufferedBatch 缓存的乱序批次
+type bufferedBatch struct {
+	prevLogTerm raft.Term
+	entries     []raft.LogEntry
+}
+
+// batchKey 批次的一次投递，发送方每次重试递增SequenceNum
+type batchKey struct {
+	batchID     string
+	sequenceNum int
+}
+
+// NewReplicationReceiver 创建跨DC复制接收端，config为nil时使用默认配置
+func NewReplicationReceiver(nodeID raft.NodeID, config *ReceiverConfig, raftConfig *raft.Config, applier ReplicationApplier) *ReplicationReceiver {
+	if config == nil {
+		config = DefaultReceiverConfig()
+	}
+	return &ReplicationReceiver{
+		nodeID:  nodeID,
+		config:  config,
+		applier: applier,
+		logger:  raftConfig.ComponentLogger("replication-receiver"),
+		clock:   clock.Or(raftConfig.Clock),
+		sources: make(map[raft.DataCenterID]*inboundSource),
+	}
+}
+
+// HandleCompressedAppendEntries 处理其他DC发来的复制批次
+// 来源未授权或负载校验失败时返回错误；批次被缓存时Success为true，LastProcessedIndex停在高水位
+func (r *ReplicationReceiver) HandleCompressedAppendEntries(req *raft.CompressedAppendEntriesRequest) (*raft.CompressedAppendEntriesResponse, error) {
+	start := r.clock.Now()
+	src, err := r.authenticate(req)
+	if err != nil {
+		r.logger.Warn("拒绝复制批次", "batch_id", req.BatchID, "source_dc", req.SourceDC, "peer", req.LeaderID, logging.FieldError, err)
+		return nil, err
+	}
+
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	src.stats.Batches++
+	src.stats.LastBatchTime = start
+
+	key := batchKey{batchID: req.BatchID, sequenceNum: req.SequenceNum}
+	if cached, ok := src.processed[key]; ok {
+		src.stats.DuplicateBatches++
+		resp := *cached
+		return &resp, nil
+	}
+
+	entries, err := raft.DecompressEntries(req)
+	if err == nil {
+		err = checkContiguous(req.PrevLogIndex, entries)
+	}
+	if err != nil {
+		src.stats.Rejected++
+		r.logger.Warn("拒绝复制批次", "batch_id", req.BatchID, "source_dc", req.SourceDC, logging.FieldError, err)
+		return nil, err
+	}
+	decompressionTime := r.clock.Now().Sub(start)
+
+	resp, err := r.process(src, req, entries)
+	if err != nil {
+		return nil, err
+	}
+	resp.Term = req.Term
+	resp.BatchID = req.BatchID
+	resp.DecompressionTime = decompressionTime
+	resp.ProcessingTime = r.clock.Now().Sub(start)
+	src.stats.LastProcessedIndex = resp.LastProcessedIndex
+	src.stats.BufferedBatches = len(src.buffered)
+
+	// 只记住已经完成的批次，被缓存的批次重复投递时需要重新查看高水位
+	if resp.Success && resp.LastProcessedIndex >= req.PrevLogIndex+raft.LogIndex(len(entries)) {
+		src.remember(key, resp, r.config.DedupCacheSize)
+	}
+	return resp, nil
+}
+
+// authenticate 检查目标DC是本DC、源DC与发送节点已被允许，返回源DC的接收状态
+func (r *ReplicationReceiver) authenticate(req *raft.CompressedAppendEntriesRequest) (*inboundSource, error) {
+	if req.TargetDC != r.config.LocalDataCenter {
+		return nil, fmt.Errorf("%w: 目标DC为 %q，本DC为 %q", ErrReplicationSourceRejected, req.TargetDC, r.config.LocalDataCenter)
+	}
+	if req.SourceDC == r.config.LocalDataCenter {
+		return nil, fmt.Errorf("%w: 源DC与本DC相同", ErrReplicationSourceRejected)
+	}
+	nodes, ok := r.config.Sources[req.SourceDC]
+	if !ok {
+		return nil, fmt.Errorf("%w: 源DC %q 未被允许", ErrReplicationSourceRejected, req.SourceDC)
+	}
+	if len(nodes) > 0 && !containsNode(nodes, req.LeaderID) {
+		return nil, fmt.Errorf("%w: 节点 %q 不属于源DC %q", ErrReplicationSourceRejected, req.LeaderID, req.SourceDC)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	src, ok := r.sources[req.SourceDC]
+	if !ok {
+		src = &inboundSource{
+			id:        req.SourceDC,
+			buffered:  make(map[raft.LogIndex]*bufferedBatch),
+			processed: make(map[batchKey]*raft.CompressedAppendEntriesResponse),
+			stats:     InboundStats{SourceDC: req.SourceDC},
+		}
+		r.sources[req.SourceDC] = src
+	}
+	return src, nil
+}
+
+// process 按源DC的高水位处理已校验的批次（调用方需持有src.mu）
+func (r *ReplicationReceiver) process(src *inboundSource, req *raft.CompressedAppendEntriesRequest, entries []raft.LogEntry) (*raft.CompressedAppendEntriesResponse, error) {
+	index, term, err := r.applier.ReplicatedIndex(src.id)
+	if err != nil {
+		return nil, fmt.Errorf("读取DC %s 的复制高水位失败: %w", src.id, err)
+	}
+
+	last := req.PrevLogIndex + raft.LogIndex(len(entries))
+	switch {
+	case last <= index:
+		// 条目都已应用过：重传的批次，或者在缓存中等待时已由后续的批次带动应用
+		src.stats.DuplicateBatches++
+		return &raft.CompressedAppendEntriesResponse{Success: true, LastProcessedIndex: index}, nil
+	case req.PrevLogIndex > index:
+		// 之前的批次尚未到达，缓存到空洞填上
+		src.stats.OutOfOrderBatches++
+		src.buffer(req.PrevLogIndex, req.PrevLogTerm, entries, r.config.MaxBufferedBatches)
+		return &raft.CompressedAppendEntriesResponse{Success: true, LastProcessedIndex: index}, nil
+	case req.PrevLogIndex == index && index > 0 && req.PrevLogTerm != 0 && req.PrevLogTerm != term:
+		// 源DC在高水位处的任期与已应用的不同，两边的日志已经分叉，需要人工介入
+		src.stats.Conflicts++
+		r.logger.Error("复制批次与已应用的条目冲突", "source_dc", src.id, "index", index, "term", term, "prev_log_term", req.PrevLogTerm)
+		return &raft.CompressedAppendEntriesResponse{ConflictIndex: index, ConflictTerm: term, LastProcessedIndex: index}, nil
+	}
+
+	processed, err := r.apply(src, entries, index)
+	if err != nil {
+		return nil, err
+	}
+	index, term = last, entries[len(entries)-1].Term
+
+	// 空洞已填上，依次应用缓存中紧接的批次
+	drained, index := r.drain(src, index, term)
+	return &raft.CompressedAppendEntriesResponse{
+		Success:            true,
+		ProcessedCount:     processed + drained,
+		LastProcessedIndex: index,
+	}, nil
+}
+
+// apply 应用索引大于index的条目，返回应用的条目数（调用方需持有src.mu）
+func (r *ReplicationReceiver) apply(src *inboundSource, entries []raft.LogEntry, index raft.LogIndex) (int, error) {
+	fresh := entries[index-entries[0].Index+1:]
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.ApplyTimeout)
+	defer cancel()
+	if err := r.applier.ApplyReplicated(ctx, src.id, fresh); err != nil {
+		return 0, fmt.Errorf("应用DC %s 的条目 [%d, %d] 失败: %w", src.id, fresh[0].Index, fresh[len(fresh)-1].Index, err)
+	}
+	src.stats.AppliedEntries += uint64(len(fresh))
+	return len(fresh), nil
+}
+
+// drain 应用缓存中紧接高水位的批次，直到遇到下一个空洞，返回应用的条目数与新的高水位（调用方需持有src.mu）
+func (r *ReplicationReceiver) drain(src *inboundSource, index raft.LogIndex, term raft.Term) (int, raft.LogIndex) {
+	applied := 0
+	for {
+		var next *bufferedBatch
+		var nextPrev raft.LogIndex
+		for prev, batch := range src.buffered {
+			if prev+raft.LogIndex(len(batch.entries)) <= index {
+				delete(src.buffered, prev)
+				continue
+			}
+			if prev <= index && (next == nil || prev > nextPrev) {
+				next, nextPrev = batch, prev
+			}
+		}
+		if next == nil {
+			return applied, index
+		}
+		delete(src.buffered, nextPrev)
+
+		if nextPrev == index && next.prevLogTerm != 0 && next.prevLogTerm != term {
+			src.stats.Conflicts++
+			r.logger.Error("缓存的复制批次与已应用的条目冲突", "source_dc", src.id, "index", index, "term", term, "prev_log_term", next.prevLogTerm)
+			continue
+		}
+		n, err := r.apply(src, next.entries, index)
+		if err != nil {
+			// 批次已从缓存移除，发送方重试时重新投递
+			r.logger.Warn("应用缓存的复制批次失败", "source_dc", src.id, logging.FieldError, err)
+			return applied, index
+		}
+		applied += n
+		last := next.entries[len(next.entries)-1]
+		index, term = last.Index, last.Term
+	}
+}
+
+// buffer 缓存乱序到达的批次，缓存已满时淘汰离高水位最远的批次（调用方需持有src.mu）
+func (src *inboundSource) buffer(prev raft.LogIndex, prevTerm raft.Term, entries []raft.LogEntry, limit int) {
+	if limit <= 0 {
+		return
+	}
+	if _, exists := src.buffered[prev]; !exists && len(src.buffered) >= limit {
+		var farthest raft.LogIndex
+		for p := range src.buffered {
+			if p > farthest {
+				farthest = p
+			}
+		}
+		if farthest < prev {
+			return
+		}
+		delete(src.buffered, farthest)
+	}
+	src.buffered[prev] = &bufferedBatch{prevLogTerm: prevTerm, entries: entries}
+}
+
+// remember 记录已完成批次的响应，超出上限时淘汰最早的记录（调用方需持有src.mu）
+func (src *inboundSource) remember(key batchKey, resp *raft.CompressedAppendEntriesResponse, limit int) {
+	if limit <= 0 {
+		return
+	}
+	if len(src.order) >= limit {
+		delete(src.processed, src.order[0])
+		src.order = src.order[1:]
+	}
+	cached := *resp
+	src.processed[key] = &cached
+	src.order = append(src.order, key)
+}
+
+// Stats 各源DC的复制统计，按源DC排序
+func (r *ReplicationReceiver) Stats() []InboundStats {
+	r.mu.Lock()
+	sources := make([]*inboundSource, 0, len(r.sources))
+	for _, src := range r.sources {
+		sources = append(sources, src)
+	}
+	r.mu.Unlock()
+
+	stats := make([]InboundStats, 0, len(sources))
+	for _, src := range sources {
+		src.mu.Lock()
+		stats = append(stats, src.stats)
+		src.mu.Unlock()
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].SourceDC < stats[j].SourceDC })
+	return stats
+}
+
+// checkContiguous 检查条目非空且从prev+1开始按索引连续
+func checkContiguous(prev raft.LogIndex, entries []raft.LogEntry) error {
+	if len(entries) == 0 {
+		return fmt.Errorf("%w: 批次没有条目", ErrReplicationBatchInvalid)
+	}
+	for i := range entries {
+		if want := prev + raft.LogIndex(i) + 1; entries[i].Index != want {
+			return fmt.Errorf("%w: 第 %d 个条目的索引为 %d，期望 %d", ErrReplicationBatchInvalid, i, entries[i].Index, want)
+		}
+	}
+	return nil
+}
+
+func containsNode(nodes []raft.NodeID, id raft.NodeID) bool {
+	for _, node := range nodes {
+		if node == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,314 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-8-3 10:12:40
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-8-3 10:12:40
+* @Description: ConcordKV 跨DC复制接收端测试
+ */
+package replication_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/raft"
+	"raftserver/replication"
+	"raftserver/transport"
+)
+
+// memApplier 内存中的复制应用方，语义与状态机的REPLICATE命令相同：跳过已应用的条目，拒绝空洞
+type memApplier struct {
+	mu      sync.Mutex
+	index   raft.LogIndex
+	term    raft.Term
+	applied []raft.LogEntry
+}
+
+func (a *memApplier) ReplicatedIndex(source raft.DataCenterID) (raft.LogIndex, raft.Term, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.index, a.term, nil
+}
+
+func (a *memApplier) ApplyReplicated(ctx context.Context, source raft.DataCenterID, entries []raft.LogEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, entry := range entries {
+		if entry.Index <= a.index {
+			continue
+		}
+		if entry.Index != a.index+1 {
+			return fmt.Errorf("空洞: 已应用到 %d，收到 %d", a.index, entry.Index)
+		}
+		a.applied = append(a.applied, entry)
+		a.index, a.term = entry.Index, entry.Term
+	}
+	return nil
+}
+
+func (a *memApplier) snapshot() []raft.LogEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]raft.LogEntry(nil), a.applied...)
+}
+
+// receiverNode 把接收端挂到进程内网络上，其他RPC不会被调用
+type receiverNode struct {
+	*replication.ReplicationReceiver
+}
+
+func (receiverNode) HandleVoteRequest(req *raft.VoteRequest) *raft.VoteResponse {
+	return &raft.VoteResponse{}
+}
+
+func (receiverNode) HandleAppendEntries(req *raft.AppendEntriesRequest) *raft.AppendEntriesResponse {
+	return &raft.AppendEntriesResponse{}
+}
+
+func (receiverNode) HandleInstallSnapshot(req *raft.InstallSnapshotRequest) *raft.InstallSnapshotResponse {
+	return &raft.InstallSnapshotResponse{}
+}
+
+func (receiverNode) HandleTimeoutNow(req *raft.TimeoutNowRequest) *raft.TimeoutNowResponse {
+	return &raft.TimeoutNowResponse{}
+}
+
+// newTestReceiver 创建dc2中接受dc1的n1发来的批次的接收端
+func newTestReceiver(nodeID raft.NodeID, applier replication.ReplicationApplier) *replication.ReplicationReceiver {
+	config := replication.DefaultReceiverConfig()
+	config.LocalDataCenter = "dc2"
+	config.Sources["dc1"] = []raft.NodeID{"n1"}
+	return replication.NewReplicationReceiver(nodeID, config, &raft.Config{NodeID: nodeID, Logger: logging.Nop()}, applier)
+}
+
+// compressedRequest 构造dc1的n1发往dc2、包含[from, to]的批次
+func compressedRequest(t *testing.T, batchID string, seq int, entries []raft.LogEntry) *raft.CompressedAppendEntriesRequest {
+	t.Helper()
+
+	req := &raft.CompressedAppendEntriesRequest{
+		Term:         1,
+		LeaderID:     "n1",
+		PrevLogIndex: entries[0].Index - 1,
+		BatchID:      batchID,
+		SequenceNum:  seq,
+		SourceDC:     "dc1",
+		TargetDC:     "dc2",
+	}
+	if err := raft.CompressEntries(req, entries, raft.CompressionGzip); err != nil {
+		t.Fatalf("压缩批次失败: %v", err)
+	}
+	return req
+}
+
+// TestReplicationReceiverOrdering 乱序到达的批次被缓存，空洞填上后按顺序应用，重复投递返回缓存的响应
+func TestReplicationReceiverOrdering(t *testing.T) {
+	applier := &memApplier{}
+	receiver := newTestReceiver("n2", applier)
+
+	second := compressedRequest(t, "b2", 1, makeEntries(4, 6))
+	resp, err := receiver.HandleCompressedAppendEntries(second)
+	if err != nil || !resp.Success || resp.LastProcessedIndex != 0 || resp.ProcessedCount != 0 {
+		t.Fatalf("先到达的后一个批次应被缓存: %+v, %v", resp, err)
+	}
+
+	first := compressedRequest(t, "b1", 1, makeEntries(1, 3))
+	resp, err = receiver.HandleCompressedAppendEntries(first)
+	if err != nil || !resp.Success || resp.LastProcessedIndex != 6 || resp.ProcessedCount != 6 {
+		t.Fatalf("填上空洞后应连同缓存的批次一起应用: %+v, %v", resp, err)
+	}
+	if resp.BatchID != "b1" {
+		t.Fatalf("响应应带上批次ID: %q", resp.BatchID)
+	}
+
+	// 同一次投递重复到达时返回缓存的响应；后一个批次重发时条目都已应用过
+	if dup, err := receiver.HandleCompressedAppendEntries(first); err != nil || dup.LastProcessedIndex != 6 || dup.ProcessedCount != 6 {
+		t.Fatalf("重复投递应返回缓存的响应: %+v, %v", dup, err)
+	}
+	second.SequenceNum = 2
+	if resp, err := receiver.HandleCompressedAppendEntries(second); err != nil || !resp.Success || resp.LastProcessedIndex != 6 || resp.ProcessedCount != 0 {
+		t.Fatalf("已应用的批次重发时应直接确认: %+v, %v", resp, err)
+	}
+
+	// 与已应用的条目部分重叠的批次只应用新的条目
+	if resp, err := receiver.HandleCompressedAppendEntries(compressedRequest(t, "b3", 1, makeEntries(5, 8))); err != nil || resp.LastProcessedIndex != 8 || resp.ProcessedCount != 2 {
+		t.Fatalf("重叠的批次应只应用新条目: %+v, %v", resp, err)
+	}
+
+	applied := applier.snapshot()
+	if len(applied) != 8 {
+		t.Fatalf("应恰好应用8个条目，实际 %d", len(applied))
+	}
+	for i, entry := range applied {
+		if entry.Index != raft.LogIndex(i+1) || string(entry.Data) != fmt.Sprintf("entry-%d", i+1) {
+			t.Fatalf("第 %d 个应用的条目错误: %+v", i, entry)
+		}
+	}
+
+	stats := receiver.Stats()
+	if len(stats) != 1 || stats[0].SourceDC != "dc1" {
+		t.Fatalf("统计应只有dc1: %+v", stats)
+	}
+	if s := stats[0]; s.Batches != 5 || s.AppliedEntries != 8 || s.DuplicateBatches != 2 || s.OutOfOrderBatches != 1 || s.BufferedBatches != 0 || s.LastProcessedIndex != 8 {
+		t.Fatalf("统计错误: %+v", s)
+	}
+}
+
+// TestReplicationReceiverRejects 未授权的来源、损坏的负载与不连续的条目被拒绝，任期冲突时返回冲突位置
+// 只有已授权的源DC有统计，认证失败的批次只记录日志
+func TestReplicationReceiverRejects(t *testing.T) {
+	applier := &memApplier{}
+	receiver := newTestReceiver("n2", applier)
+
+	for name, mutate := range map[string]func(req *raft.CompressedAppendEntriesRequest){
+		"目标DC": func(req *raft.CompressedAppendEntriesRequest) { req.TargetDC = "dc3" },
+		"源DC":  func(req *raft.CompressedAppendEntriesRequest) { req.SourceDC = "dc3" },
+		"本DC":  func(req *raft.CompressedAppendEntriesRequest) { req.SourceDC = "dc2" },
+		"发送节点": func(req *raft.CompressedAppendEntriesRequest) { req.LeaderID = "n9" },
+	} {
+		req := compressedRequest(t, "b1", 1, makeEntries(1, 2))
+		mutate(req)
+		if _, err := receiver.HandleCompressedAppendEntries(req); !errors.Is(err, replication.ErrReplicationSourceRejected) {
+			t.Fatalf("%s不符时应拒绝，实际 %v", name, err)
+		}
+	}
+
+	corrupted := compressedRequest(t, "b1", 1, makeEntries(1, 2))
+	corrupted.Checksum++
+	if _, err := receiver.HandleCompressedAppendEntries(corrupted); !errors.Is(err, raft.ErrChecksumMismatch) {
+		t.Fatalf("校验和不匹配时应拒绝，实际 %v", err)
+	}
+	misplaced := compressedRequest(t, "b1", 1, makeEntries(1, 2))
+	misplaced.PrevLogIndex = 5
+	if _, err := receiver.HandleCompressedAppendEntries(misplaced); !errors.Is(err, replication.ErrReplicationBatchInvalid) {
+		t.Fatalf("条目与声明的位置不符时应拒绝，实际 %v", err)
+	}
+	if len(applier.snapshot()) != 0 {
+		t.Fatal("被拒绝的批次不应被应用")
+	}
+
+	if resp, err := receiver.HandleCompressedAppendEntries(compressedRequest(t, "b1", 1, makeEntries(1, 2))); err != nil || resp.LastProcessedIndex != 2 {
+		t.Fatalf("应用批次失败: %+v, %v", resp, err)
+	}
+	conflicting := compressedRequest(t, "b2", 1, makeEntries(3, 4))
+	conflicting.PrevLogTerm = 2
+	resp, err := receiver.HandleCompressedAppendEntries(conflicting)
+	if err != nil || resp.Success || resp.ConflictIndex != 2 || resp.ConflictTerm != 1 || resp.LastProcessedIndex != 2 {
+		t.Fatalf("前一条目任期不匹配时应返回冲突: %+v, %v", resp, err)
+	}
+	if s := receiver.Stats()[0]; s.Rejected != 2 || s.Conflicts != 1 || s.AppliedEntries != 2 {
+		t.Fatalf("统计错误: %+v", s)
+	}
+}
+
+// TestCrossDCReplicationEndToEnd 发送端经过注入延迟、重复与丢包的链路向两个接收节点复制5万个条目，
+// 每个条目恰好按顺序应用一次，发送端的复制进度推进到最后一个条目
+func TestCrossDCReplicationEndToEnd(t *testing.T) {
+	const total = 50000
+
+	network := transport.NewMemoryNetwork()
+	applier := &memApplier{}
+	var receivers []*replication.ReplicationReceiver
+	for _, id := range []raft.NodeID{"n2", "n3"} {
+		receiver := newTestReceiver(id, applier)
+		receivers = append(receivers, receiver)
+		receiverTransport := network.Transport(id)
+		receiverTransport.SetHandler(receiverNode{receiver})
+		if err := receiverTransport.Start(); err != nil {
+			t.Fatalf("启动接收节点失败: %v", err)
+		}
+	}
+
+	chaos := transport.NewChaos(1)
+	chaos.Delay(0, 2*time.Millisecond, transport.MessageCompressedAppendEntries)
+	chaos.Duplicate(20, transport.MessageCompressedAppendEntries)
+	chaos.DropPercent(1, transport.MessageCompressedAppendEntries)
+	senderTransport := chaos.Wrap("n1", network.Transport("n1"))
+	t.Cleanup(func() { senderTransport.Stop() })
+
+	raftConfig := &raft.Config{
+		NodeID: "n1",
+		Servers: []raft.Server{
+			{ID: "n1", DataCenter: "dc1"},
+			{ID: "n2", DataCenter: "dc2"},
+			{ID: "n3", DataCenter: "dc2"},
+		},
+		MultiDC: &raft.MultiDCConfig{
+			Enabled:         true,
+			LocalDataCenter: &raft.DataCenterConfig{ID: "dc1", IsPrimary: true},
+		},
+		Logger: logging.Nop(),
+	}
+	config := replication.DefaultAsyncReplicationConfig()
+	config.BatchSize = 100
+	config.BatchTimeoutMs = 5
+	config.MaxInFlightBatches = 8
+	config.RetryAttempts = 3
+	config.RetryBackoffMs = 1
+	ar := replication.NewAsyncReplicatorWithConfig("n1", config, raftConfig, senderTransport, nil)
+	if err := ar.Start(); err != nil {
+		t.Fatalf("启动异步复制管理器失败: %v", err)
+	}
+	t.Cleanup(func() { ar.Stop() })
+
+	for from := 1; from <= total; from += 1000 {
+		entries := makeEntries(from, from+999)
+		for {
+			err := ar.ReplicateAsync(entries)
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, replication.ErrReplicationBackpressure) {
+				t.Fatalf("复制失败: %v", err)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	deadline := time.Now().Add(60 * time.Second)
+	for {
+		target := ar.GetReplicationStatus()["dc2"]
+		if target.LastReplicatedIndex == total {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待复制完成超时: 已确认到 %d，接收端应用到 %d", target.LastReplicatedIndex, len(applier.snapshot()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	applied := applier.snapshot()
+	if len(applied) != total {
+		t.Fatalf("应恰好应用 %d 个条目，实际 %d", total, len(applied))
+	}
+	for i, entry := range applied {
+		if entry.Index != raft.LogIndex(i+1) || string(entry.Data) != fmt.Sprintf("entry-%d", i+1) {
+			t.Fatalf("第 %d 个应用的条目错误: index=%d data=%q", i, entry.Index, entry.Data)
+		}
+	}
+
+	injected := chaos.Stats()
+	if injected.Duplicated == 0 || injected.Delayed == 0 || injected.Dropped == 0 {
+		t.Fatalf("应注入重复、延迟与丢包: %+v", injected)
+	}
+	var duplicates, outOfOrder, appliedEntries uint64
+	for _, receiver := range receivers {
+		for _, s := range receiver.Stats() {
+			duplicates += s.DuplicateBatches
+			outOfOrder += s.OutOfOrderBatches
+			appliedEntries += s.AppliedEntries
+		}
+	}
+	if duplicates == 0 || outOfOrder == 0 {
+		t.Fatalf("接收端应遇到重复与乱序的批次: duplicates=%d outOfOrder=%d", duplicates, outOfOrder)
+	}
+	if appliedEntries != total {
+		t.Fatalf("两个接收节点合计应用的条目数应为 %d，实际 %d", total, appliedEntries)
+	}
+	t.Logf("注入统计: %+v，重复批次 %d，乱序批次 %d", injected, duplicates, outOfOrder)
+}
@@ -0,0 +1,288 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-4 19:12:37
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-4 19:12:37
+* @Description: ConcordKV 读写分离路由器 - 按key模式匹配的自定义路由规则
+ */
+package replication
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"raftserver/raft"
+)
+
+const (
+	defaultReadRouteID  = "default-read"
+	defaultWriteRouteID = "default-write"
+
+	// routePatternMeta 路由模式中的通配符
+	routePatternMeta = "*?[\\"
+)
+
+// ErrRouteNotFound 路由规则不存在
+var ErrRouteNotFound = errors.New("路由规则不存在")
+
+// matchRoutePattern 判断key是否匹配路由模式
+// "*" 匹配所有key；只以一个 "*" 结尾的模式按前缀匹配（"analytics/*" 也匹配 "analytics/a/b"）；
+// 其他含通配符的模式按path.Match规则匹配；不含通配符的模式要求完全相等
+func matchRoutePattern(pattern, key string) bool {
+	if pattern == "*" {
+		return true
+	}
+
+	meta := strings.IndexAny(pattern, routePatternMeta)
+	if meta < 0 {
+		return key == pattern
+	}
+	if meta == len(pattern)-1 && pattern[meta] == '*' {
+		return strings.HasPrefix(key, pattern[:meta])
+	}
+
+	matched, err := path.Match(pattern, key)
+	return err == nil && matched
+}
+
+// routePatternSpecificity 模式的具体程度：第一个通配符前的字面前缀长度，不含通配符的模式最具体
+func routePatternSpecificity(pattern string) int {
+	meta := strings.IndexAny(pattern, routePatternMeta)
+	if meta < 0 {
+		return len(pattern) + 1
+	}
+	return meta
+}
+
+// routeOutranks 判断路由a是否优先于b：Priority大的优先，相同时模式更具体的优先，再按ID排序保证结果稳定
+func routeOutranks(a, b *Route) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if sa, sb := routePatternSpecificity(a.Pattern), routePatternSpecificity(b.Pattern); sa != sb {
+		return sa > sb
+	}
+	return a.ID < b.ID
+}
+
+// isDefaultRouteID 默认路由由路由器维护，不能通过路由规则API修改
+func isDefaultRouteID(routeID string) bool {
+	return routeID == defaultReadRouteID || routeID == defaultWriteRouteID
+}
+
+// matchCustomRoute 在routes中选出匹配key且usable的最优自定义路由（调用方需持有routingTable.mu）
+func (rwr *ReadWriteRouter) matchCustomRoute(routes map[string]*Route, key string, usable func(route *Route) bool) *Route {
+	var best *Route
+	for routeID, route := range routes {
+		if isDefaultRouteID(routeID) || !route.IsActive || !matchRoutePattern(route.Pattern, key) {
+			continue
+		}
+		if usable != nil && !usable(route) {
+			continue
+		}
+		if best == nil || routeOutranks(route, best) {
+			best = route
+		}
+	}
+	return best
+}
+
+// hasReadableTarget 路由至少有一个目标DC当前承担读请求（调用方需持有rwr.mu）
+func (rwr *ReadWriteRouter) hasReadableTarget(route *Route) bool {
+	if route.Strategy == RoutingPrimaryDC {
+		_, exists := rwr.readReplicas[rwr.primaryDC]
+		return exists
+	}
+	for _, dcID := range route.TargetDCs {
+		if _, exists := rwr.readReplicas[dcID]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveRoute 复制路由，主DC策略的目标替换为当前主DC（调用方需持有rwr.mu和routingTable.mu）
+func (rwr *ReadWriteRouter) resolveRoute(route *Route) *Route {
+	routeCopy := *route
+	routeCopy.TargetDCs = append([]raft.DataCenterID(nil), route.TargetDCs...)
+	routeCopy.TargetNodes = append([]raft.NodeID(nil), route.TargetNodes...)
+	if route.Strategy == RoutingPrimaryDC {
+		routeCopy.TargetDCs = []raft.DataCenterID{rwr.primaryDC}
+		routeCopy.TargetNodes = append([]raft.NodeID(nil), rwr.writeTargets[rwr.primaryDC]...)
+	}
+	return &routeCopy
+}
+
+// recordRouteResult 更新路由统计，平均延迟只统计成功路由的目标DC预期延迟
+func (rwr *ReadWriteRouter) recordRouteResult(routeID string, latency time.Duration, success bool) {
+	now := time.Now()
+
+	rwr.routingTable.mu.Lock()
+	defer rwr.routingTable.mu.Unlock()
+
+	stats := rwr.routingTable.routeStats[routeID]
+	if stats == nil {
+		stats = &RouteStats{}
+		rwr.routingTable.routeStats[routeID] = stats
+	}
+	stats.RequestCount++
+	stats.LastUsed = now
+	if success {
+		successes := stats.RequestCount - stats.ErrorCount
+		stats.AverageLatency += (latency - stats.AverageLatency) / time.Duration(successes)
+	} else {
+		stats.ErrorCount++
+	}
+
+	for _, routes := range []map[string]*Route{rwr.routingTable.readRoutes, rwr.routingTable.writeRoutes} {
+		if route, exists := routes[routeID]; exists {
+			route.LastUsed = now
+			route.UseCount++
+			return
+		}
+	}
+}
+
+// routeStatsSnapshot 复制路由统计
+func (rwr *ReadWriteRouter) routeStatsSnapshot() map[string]RouteStats {
+	rwr.routingTable.mu.RLock()
+	defer rwr.routingTable.mu.RUnlock()
+
+	stats := make(map[string]RouteStats, len(rwr.routingTable.routeStats))
+	for routeID, routeStats := range rwr.routingTable.routeStats {
+		stats[routeID] = *routeStats
+	}
+	return stats
+}
+
+// AddRoute 添加自定义路由规则，请求key匹配Pattern时优先于默认路由
+// 读路由把匹配的读请求发往TargetDCs，不受PreferLocalDC限制；写请求只能发往主DC，写路由必须使用RoutingPrimaryDC策略
+func (rwr *ReadWriteRouter) AddRoute(route *Route) error {
+	if route == nil {
+		return fmt.Errorf("路由规则不能为空")
+	}
+	if route.ID == "" {
+		return fmt.Errorf("路由ID不能为空")
+	}
+	if isDefaultRouteID(route.ID) {
+		return fmt.Errorf("不能覆盖默认路由: %s", route.ID)
+	}
+	if route.Pattern == "" {
+		return fmt.Errorf("路由模式不能为空")
+	}
+	if _, err := path.Match(route.Pattern, ""); err != nil {
+		return fmt.Errorf("路由模式无效: %s", route.Pattern)
+	}
+
+	isRead := route.Type == RequestTypeRead || route.Type == RequestTypeReadWrite
+	isWrite := route.Type == RequestTypeWrite || route.Type == RequestTypeReadWrite
+	if !isRead && !isWrite {
+		return fmt.Errorf("未知的请求类型: %d", route.Type)
+	}
+	if isWrite && route.Strategy != RoutingPrimaryDC {
+		return fmt.Errorf("写路由必须使用主DC策略")
+	}
+
+	rwr.mu.RLock()
+	defer rwr.mu.RUnlock()
+
+	if route.Strategy != RoutingPrimaryDC {
+		if len(route.TargetDCs) == 0 {
+			return fmt.Errorf("路由没有目标DC")
+		}
+		for _, dcID := range route.TargetDCs {
+			if _, exists := rwr.dataCenters[dcID]; !exists {
+				return fmt.Errorf("数据中心不存在: %s", dcID)
+			}
+		}
+	}
+
+	rwr.routingTable.mu.Lock()
+	defer rwr.routingTable.mu.Unlock()
+
+	if _, exists := rwr.routingTable.readRoutes[route.ID]; exists {
+		return fmt.Errorf("路由规则已存在: %s", route.ID)
+	}
+	if _, exists := rwr.routingTable.writeRoutes[route.ID]; exists {
+		return fmt.Errorf("路由规则已存在: %s", route.ID)
+	}
+
+	routeCopy := *route
+	routeCopy.TargetDCs = append([]raft.DataCenterID(nil), route.TargetDCs...)
+	routeCopy.TargetNodes = nil
+	routeCopy.IsActive = true
+	routeCopy.CreatedAt = time.Now()
+	routeCopy.LastUsed = time.Time{}
+	routeCopy.UseCount = 0
+
+	if isRead {
+		rwr.routingTable.readRoutes[route.ID] = &routeCopy
+	}
+	if isWrite {
+		rwr.routingTable.writeRoutes[route.ID] = &routeCopy
+	}
+
+	rwr.logger.Info("添加路由规则", "route", route.ID, "pattern", route.Pattern, "priority", route.Priority, "target_dcs", route.TargetDCs)
+	return nil
+}
+
+// RemoveRoute 删除自定义路由规则及其统计
+func (rwr *ReadWriteRouter) RemoveRoute(routeID string) error {
+	if isDefaultRouteID(routeID) {
+		return fmt.Errorf("不能删除默认路由: %s", routeID)
+	}
+
+	rwr.routingTable.mu.Lock()
+	defer rwr.routingTable.mu.Unlock()
+
+	_, isRead := rwr.routingTable.readRoutes[routeID]
+	_, isWrite := rwr.routingTable.writeRoutes[routeID]
+	if !isRead && !isWrite {
+		return ErrRouteNotFound
+	}
+
+	delete(rwr.routingTable.readRoutes, routeID)
+	delete(rwr.routingTable.writeRoutes, routeID)
+	delete(rwr.routingTable.routeStats, routeID)
+
+	rwr.logger.Info("删除路由规则", "route", routeID)
+	return nil
+}
+
+// ListRoutes 列出包括默认路由在内的所有路由，按请求类型和匹配优先顺序排序
+func (rwr *ReadWriteRouter) ListRoutes() []*Route {
+	rwr.routingTable.mu.RLock()
+	defer rwr.routingTable.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	routes := make([]*Route, 0, len(rwr.routingTable.readRoutes)+len(rwr.routingTable.writeRoutes))
+	for _, table := range []map[string]*Route{rwr.routingTable.readRoutes, rwr.routingTable.writeRoutes} {
+		for routeID, route := range table {
+			if seen[routeID] {
+				continue
+			}
+			seen[routeID] = true
+
+			routeCopy := *route
+			routeCopy.TargetDCs = append([]raft.DataCenterID(nil), route.TargetDCs...)
+			routeCopy.TargetNodes = append([]raft.NodeID(nil), route.TargetNodes...)
+			routes = append(routes, &routeCopy)
+		}
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Type != routes[j].Type {
+			return routes[i].Type < routes[j].Type
+		}
+		// 默认路由排在同类型的最后
+		if di, dj := isDefaultRouteID(routes[i].ID), isDefaultRouteID(routes[j].ID); di != dj {
+			return dj
+		}
+		return routeOutranks(routes[i], routes[j])
+	})
+	return routes
+}
@@ -0,0 +1,265 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-4 19:12:37
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-4 19:12:37
+* @Description: ConcordKV 自定义路由规则测试
+ */
+package replication_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"raftserver/raft"
+	"raftserver/replication"
+)
+
+// routeRead 路由读请求并返回目标DC
+func routeRead(t *testing.T, router *replication.ReadWriteRouter, key string, consistency replication.ReadConsistencyLevel) *replication.RoutingDecision {
+	t.Helper()
+
+	decision, err := router.RouteRequest(replication.RequestTypeRead, key, consistency)
+	if err != nil {
+		t.Fatalf("路由读请求 %s 失败: %v", key, err)
+	}
+	return decision
+}
+
+// TestRouterCustomReadRoute analytics/* 的读请求发往指定DC，其他读请求仍按就近原则
+func TestRouterCustomReadRoute(t *testing.T) {
+	router := newRecoveryTestRouter()
+
+	err := router.AddRoute(&replication.Route{
+		ID:        "analytics",
+		Type:      replication.RequestTypeRead,
+		Pattern:   "analytics/*",
+		TargetDCs: []raft.DataCenterID{"dc3"},
+		Strategy:  replication.RoutingNearestDC,
+	})
+	if err != nil {
+		t.Fatalf("添加路由失败: %v", err)
+	}
+
+	if decision := routeRead(t, router, "analytics/daily/2025", replication.ReadConsistencyEventual); decision.TargetDC != "dc3" || decision.Route.ID != "analytics" {
+		t.Fatalf("analytics读请求路由到 %s (%s), 期望 dc3", decision.TargetDC, decision.Route.ID)
+	}
+	if decision := routeRead(t, router, "user:1", replication.ReadConsistencyEventual); decision.TargetDC != "dc1" || decision.Route.ID != "default-read" {
+		t.Fatalf("普通读请求路由到 %s (%s), 期望本地 dc1", decision.TargetDC, decision.Route.ID)
+	}
+
+	// 强一致性读仍然发往主DC
+	if decision := routeRead(t, router, "analytics/daily", replication.ReadConsistencyStrong); decision.TargetDC != "dc2" {
+		t.Fatalf("强一致性读路由到 %s, 期望主DC dc2", decision.TargetDC)
+	}
+	if decision := routeRead(t, router, "analytics/daily", replication.ReadConsistencyEventual); decision.TargetDC != "dc3" {
+		t.Fatalf("强一致性读修改了路由表, 之后的读请求路由到 %s", decision.TargetDC)
+	}
+
+	stats := router.GetMetrics().RouteStats["analytics"]
+	if stats.RequestCount != 3 || stats.ErrorCount != 0 || stats.AverageLatency <= 0 {
+		t.Fatalf("analytics路由统计 = %+v", stats)
+	}
+
+	if err := router.RemoveRoute("analytics"); err != nil {
+		t.Fatalf("删除路由失败: %v", err)
+	}
+	if decision := routeRead(t, router, "analytics/daily", replication.ReadConsistencyEventual); decision.TargetDC != "dc1" {
+		t.Fatalf("删除路由后读请求路由到 %s, 期望 dc1", decision.TargetDC)
+	}
+	if _, exists := router.GetMetrics().RouteStats["analytics"]; exists {
+		t.Fatalf("删除路由后仍有统计")
+	}
+	if err := router.RemoveRoute("analytics"); !errors.Is(err, replication.ErrRouteNotFound) {
+		t.Fatalf("重复删除返回 %v, 期望 ErrRouteNotFound", err)
+	}
+}
+
+// TestRouterRouteSelectionOrder 优先级高的路由优先，优先级相同时模式更具体的优先
+func TestRouterRouteSelectionOrder(t *testing.T) {
+	router := newRecoveryTestRouter()
+
+	routes := []*replication.Route{
+		{ID: "users", Pattern: "user:*", TargetDCs: []raft.DataCenterID{"dc2"}},
+		{ID: "vip-users", Pattern: "user:vip:*", TargetDCs: []raft.DataCenterID{"dc3"}},
+		{ID: "single-char", Pattern: "user:?", Priority: 10, TargetDCs: []raft.DataCenterID{"dc3"}},
+		{ID: "exact", Pattern: "user:admin", TargetDCs: []raft.DataCenterID{"dc3"}},
+	}
+	for _, route := range routes {
+		route.Type = replication.RequestTypeRead
+		route.Strategy = replication.RoutingLeastLatency
+		if err := router.AddRoute(route); err != nil {
+			t.Fatalf("添加路由 %s 失败: %v", route.ID, err)
+		}
+	}
+
+	cases := map[string]string{
+		"user:42":     "users",
+		"user:vip:7":  "vip-users",
+		"user:7":      "single-char",
+		"user:admin":  "exact",
+		"order:1":     "default-read",
+		"user:vip:7x": "vip-users",
+	}
+	for key, want := range cases {
+		if decision := routeRead(t, router, key, replication.ReadConsistencyEventual); decision.Route.ID != want {
+			t.Errorf("key %s 匹配路由 %s, 期望 %s", key, decision.Route.ID, want)
+		}
+	}
+
+	listed := router.ListRoutes()
+	ids := make([]string, 0, len(listed))
+	for _, route := range listed {
+		ids = append(ids, route.ID)
+	}
+	want := []string{"single-char", "exact", "vip-users", "users", "default-read", "default-write"}
+	if len(ids) != len(want) {
+		t.Fatalf("ListRoutes = %v, 期望 %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("ListRoutes = %v, 期望 %v", ids, want)
+		}
+	}
+}
+
+// TestRouterCustomRouteFallsBackWhenTargetExcluded 目标DC被排除时使用默认读路由
+func TestRouterCustomRouteFallsBackWhenTargetExcluded(t *testing.T) {
+	router := newRecoveryTestRouter()
+
+	if err := router.AddRoute(&replication.Route{
+		ID:        "analytics",
+		Type:      replication.RequestTypeRead,
+		Pattern:   "analytics/*",
+		TargetDCs: []raft.DataCenterID{"dc3"},
+	}); err != nil {
+		t.Fatalf("添加路由失败: %v", err)
+	}
+	if err := router.ExcludeDC("dc3"); err != nil {
+		t.Fatalf("排除dc3失败: %v", err)
+	}
+
+	if decision := routeRead(t, router, "analytics/daily", replication.ReadConsistencyEventual); decision.TargetDC != "dc1" || decision.Route.ID != "default-read" {
+		t.Fatalf("目标DC被排除后路由到 %s (%s), 期望默认路由 dc1", decision.TargetDC, decision.Route.ID)
+	}
+}
+
+// TestRouterAddRouteValidation 拒绝无效的路由规则
+func TestRouterAddRouteValidation(t *testing.T) {
+	router := newRecoveryTestRouter()
+
+	valid := replication.Route{
+		ID:        "analytics",
+		Type:      replication.RequestTypeRead,
+		Pattern:   "analytics/*",
+		TargetDCs: []raft.DataCenterID{"dc3"},
+	}
+	if err := router.AddRoute(&valid); err != nil {
+		t.Fatalf("添加路由失败: %v", err)
+	}
+
+	invalid := map[string]func(route *replication.Route){
+		"重复ID":    func(route *replication.Route) {},
+		"默认路由ID":  func(route *replication.Route) { route.ID = "default-read" },
+		"空模式":     func(route *replication.Route) { route.ID, route.Pattern = "r1", "" },
+		"无效模式":    func(route *replication.Route) { route.ID, route.Pattern = "r2", "user:[" },
+		"未知DC":    func(route *replication.Route) { route.ID, route.TargetDCs = "r3", []raft.DataCenterID{"dc9"} },
+		"没有目标DC":  func(route *replication.Route) { route.ID, route.TargetDCs = "r4", nil },
+		"写路由非主DC": func(route *replication.Route) { route.ID, route.Type = "r5", replication.RequestTypeWrite },
+	}
+	for name, mutate := range invalid {
+		route := valid
+		mutate(&route)
+		if err := router.AddRoute(&route); err == nil {
+			t.Errorf("%s: 期望添加失败", name)
+		}
+	}
+
+	// 写路由使用主DC策略，故障转移后跟随新的主DC
+	if err := router.AddRoute(&replication.Route{
+		ID:       "orders",
+		Type:     replication.RequestTypeWrite,
+		Pattern:  "order:*",
+		Strategy: replication.RoutingPrimaryDC,
+	}); err != nil {
+		t.Fatalf("添加写路由失败: %v", err)
+	}
+	if err := router.PromotePrimaryDC("dc3"); err != nil {
+		t.Fatalf("切换主DC失败: %v", err)
+	}
+	decision, err := router.RouteRequest(replication.RequestTypeWrite, "order:1", replication.ReadConsistencyStrong)
+	if err != nil {
+		t.Fatalf("路由写请求失败: %v", err)
+	}
+	if decision.TargetDC != "dc3" || decision.Route.ID != "orders" {
+		t.Fatalf("写请求路由到 %s (%s), 期望 dc3 (orders)", decision.TargetDC, decision.Route.ID)
+	}
+}
+
+// TestRouterStrongReadsDoNotNarrowEventualReads 并发的强一致性读不会修改共享的默认读路由
+func TestRouterStrongReadsDoNotNarrowEventualReads(t *testing.T) {
+	raftConfig := &raft.Config{
+		NodeID: "n1",
+		Servers: []raft.Server{
+			{ID: "n1", DataCenter: "dc1"},
+			{ID: "n2", DataCenter: "dc2"},
+			{ID: "n3", DataCenter: "dc3"},
+		},
+		MultiDC: &raft.MultiDCConfig{
+			Enabled:         true,
+			LocalDataCenter: &raft.DataCenterConfig{ID: "dc1"},
+		},
+	}
+	config := replication.DefaultReadWriteRouterConfig()
+	config.PrimaryDC = "dc2"
+	config.PreferLocalDC = false
+	router := replication.NewReadWriteRouterWithConfig("n1", config, raftConfig)
+
+	const readers, reads = 8, 200
+	errs := make(chan string, readers*reads)
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(strong bool) {
+			defer wg.Done()
+
+			consistency := replication.ReadConsistencyEventual
+			if strong {
+				consistency = replication.ReadConsistencyStrong
+			}
+			for j := 0; j < reads; j++ {
+				decision, err := router.RouteRequest(replication.RequestTypeRead, "key", consistency)
+				if err != nil {
+					errs <- err.Error()
+					continue
+				}
+				switch {
+				case strong && decision.TargetDC != "dc2":
+					errs <- "强一致性读路由到 " + string(decision.TargetDC)
+				case !strong && len(decision.Route.TargetDCs) != 3:
+					errs <- "最终一致性读的目标DC被缩小"
+				case !strong && decision.TargetDC != "dc1":
+					errs <- "最终一致性读路由到 " + string(decision.TargetDC)
+				}
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	for _, route := range router.ListRoutes() {
+		if route.ID == "default-read" {
+			if len(route.TargetDCs) != 3 {
+				t.Fatalf("默认读路由的目标DC = %v, 期望3个DC", route.TargetDCs)
+			}
+			if route.UseCount != readers*reads {
+				t.Fatalf("默认读路由使用次数 = %d, 期望 %d", route.UseCount, readers*reads)
+			}
+		}
+	}
+}
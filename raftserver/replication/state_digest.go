@@ -0,0 +1,244 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-20 15:41:09
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-20 15:41:09
+* @Description: ConcordKV replication - state_digest.go
+ */
+package replication
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// maxDigestDrillBuckets 单次请求列出键摘要的最大桶数，与服务端的限制一致
+const maxDigestDrillBuckets = 64
+
+// keyDivergence 两侧已应用状态不一致的键，Local或Remote为nil表示该侧没有这个键
+type keyDivergence struct {
+	Key    string
+	Bucket int
+	Local  *statemachine.KeyDigest
+	Remote *statemachine.KeyDigest
+}
+
+// VerifyStateConsistency 立即比较一次本地与各目标DC已应用状态的摘要
+func (cr *ConsistencyRecovery) VerifyStateConsistency() {
+	cr.verifyGlobalConsistency()
+}
+
+// verifyGlobalConsistency 比较本地与各目标DC中一个节点的状态摘要：各桶哈希不同时列出这些桶中的键，
+// 排除复制延迟造成的差异后，每个分歧的键记录一个ConflictingEntries不一致
+func (cr *ConsistencyRecovery) verifyGlobalConsistency() {
+	cr.mu.RLock()
+	local, fetcher := cr.stateSource, cr.stateFetcher
+	cr.mu.RUnlock()
+
+	if local == nil || fetcher == nil || cr.asyncReplicator == nil {
+		cr.logger.Debug("未设置状态摘要，跳过已应用状态的比较")
+		return
+	}
+
+	targets := cr.asyncReplicator.GetReplicationStatus()
+	dcs := make([]raft.DataCenterID, 0, len(targets))
+	for dcID := range targets {
+		dcs = append(dcs, dcID)
+	}
+	sort.Slice(dcs, func(i, j int) bool { return dcs[i] < dcs[j] })
+
+	for _, dcID := range dcs {
+		divergent, err := cr.compareStateDigest(local, fetcher, dcID)
+		if err != nil {
+			cr.logger.Warn("比较状态摘要失败", "target_dc", dcID, logging.FieldError, err)
+			continue
+		}
+		cr.recordStateDivergence(dcID, divergent)
+	}
+}
+
+// compareStateDigest 比较本地与目标DC的各桶摘要，对不一致的桶逐个比较其中的键
+func (cr *ConsistencyRecovery) compareStateDigest(local StateDigestSource, fetcher StateDigestFetcher, dcID raft.DataCenterID) ([]keyDivergence, error) {
+	buckets := cr.config.StateDigestBuckets
+	if buckets <= 0 || buckets > statemachine.MaxDigestBuckets {
+		buckets = statemachine.DefaultDigestBuckets
+	}
+
+	ctx, cancel := context.WithTimeout(cr.ctx, cr.config.RepairTimeout)
+	defer cancel()
+
+	remote, err := fetcher.FetchStateDigest(ctx, dcID, buckets)
+	if err != nil {
+		return nil, err
+	}
+	localDigest := local.StateDigest(buckets)
+	if len(remote.Buckets) != len(localDigest.Buckets) {
+		return nil, fmt.Errorf("DC %s 返回 %d 个桶，期望 %d 个", dcID, len(remote.Buckets), len(localDigest.Buckets))
+	}
+
+	var mismatched []int
+	for i := range localDigest.Buckets {
+		if localDigest.Buckets[i] != remote.Buckets[i] {
+			mismatched = append(mismatched, i)
+		}
+	}
+
+	var divergent []keyDivergence
+	for start := 0; start < len(mismatched); start += maxDigestDrillBuckets {
+		end := start + maxDigestDrillBuckets
+		if end > len(mismatched) {
+			end = len(mismatched)
+		}
+		selected := mismatched[start:end]
+
+		remoteKeys, err := fetcher.FetchBucketKeys(ctx, dcID, buckets, selected)
+		if err != nil {
+			return nil, err
+		}
+		divergent = append(divergent, diffStateKeys(local.BucketKeys(buckets, selected), remoteKeys)...)
+	}
+	return divergent, nil
+}
+
+// diffStateKeys 找出两侧已应用状态分歧的键
+// 一侧的修改可能尚未复制到另一侧，只有确定不是复制延迟造成的差异才算分歧：
+// 两侧都有的键，最后一次修改必须已被两侧应用；只有一侧有的键，另一侧必须已应用该键的最后一次修改，
+// 且没有应用过更多的日志（否则可能是之后删除了它）
+func diffStateKeys(local, remote *statemachine.StateDigest) []keyDivergence {
+	remoteKeys := make(map[string]*statemachine.KeyDigest, len(remote.Keys))
+	for i := range remote.Keys {
+		remoteKeys[remote.Keys[i].Key] = &remote.Keys[i]
+	}
+
+	applied := local.AppliedIndex
+	if remote.AppliedIndex < applied {
+		applied = remote.AppliedIndex
+	}
+	localApplied, remoteApplied := uint64(local.AppliedIndex), uint64(remote.AppliedIndex)
+
+	var divergent []keyDivergence
+	for i := range local.Keys {
+		l := &local.Keys[i]
+		r, exists := remoteKeys[l.Key]
+		delete(remoteKeys, l.Key)
+
+		switch {
+		case !exists:
+			if l.Version <= remoteApplied && remoteApplied <= localApplied {
+				divergent = append(divergent, keyDivergence{Key: l.Key, Bucket: l.Bucket, Local: l})
+			}
+		case l.Hash != r.Hash:
+			version := l.Version
+			if r.Version > version {
+				version = r.Version
+			}
+			if version <= uint64(applied) {
+				divergent = append(divergent, keyDivergence{Key: l.Key, Bucket: l.Bucket, Local: l, Remote: r})
+			}
+		}
+	}
+	for _, r := range remoteKeys {
+		if r.Version <= localApplied && localApplied <= remoteApplied {
+			divergent = append(divergent, keyDivergence{Key: r.Key, Bucket: r.Bucket, Remote: r})
+		}
+	}
+
+	sort.Slice(divergent, func(i, j int) bool { return divergent[i].Key < divergent[j].Key })
+	return divergent
+}
+
+// recordStateDivergence 记录目标DC中分歧的键，每个键一个不一致记录，同一个键的分歧消除之前不重复记录
+// 分歧需要人工处理（例如从其他副本恢复），重新发送日志无法修复已应用的状态，因此不加入修复队列
+func (cr *ConsistencyRecovery) recordStateDivergence(dcID raft.DataCenterID, divergent []keyDivergence) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	previous := cr.stateInconsistencies[dcID]
+	current := make(map[string]string, len(divergent))
+	recorded := 0
+	for _, d := range divergent {
+		if id, exists := previous[d.Key]; exists {
+			current[d.Key] = id
+			continue
+		}
+		if cr.config.MaxDivergentKeys > 0 && recorded >= cr.config.MaxDivergentKeys {
+			continue
+		}
+		current[d.Key] = cr.recordKeyInconsistency(dcID, d)
+		recorded++
+	}
+	cr.stateInconsistencies[dcID] = current
+
+	// DC状态中的不一致计数包含上次比较的分歧键数，替换为本次的结果；
+	// 只因状态分歧而不一致的DC在分歧消除后恢复为一致
+	previousCount := cr.divergentKeys[dcID]
+	cr.divergentKeys[dcID] = len(divergent)
+	if status, exists := cr.currentSnapshot.DCConsistencyStatus[dcID]; exists {
+		logCount := status.InconsistencyCount - previousCount
+		status.InconsistencyCount = logCount + len(divergent)
+		if len(divergent) > 0 {
+			status.IsConsistent = false
+		} else if previousCount > 0 && logCount == 0 {
+			status.IsConsistent = true
+		}
+	}
+	cr.updateGlobalConsistency()
+
+	if len(divergent) > 0 {
+		cr.logger.Warn("已应用状态与目标DC不一致", "target_dc", dcID, "keys", len(divergent), "recorded", recorded)
+	} else {
+		cr.logger.Debug("已应用状态与目标DC一致", "target_dc", dcID)
+	}
+}
+
+// recordKeyInconsistency 为分歧的键记录一个ConflictingEntries不一致，返回其ID（调用方需持有cr.mu）
+func (cr *ConsistencyRecovery) recordKeyInconsistency(dcID raft.DataCenterID, d keyDivergence) string {
+	details := map[string]interface{}{"key": d.Key, "bucket": d.Bucket}
+	var index raft.LogIndex
+	if d.Local != nil {
+		details["localVersion"], details["localHash"] = d.Local.Version, d.Local.Hash
+		index = raft.LogIndex(d.Local.Version)
+	}
+	if d.Remote != nil {
+		details["remoteVersion"], details["remoteHash"] = d.Remote.Version, d.Remote.Hash
+		if index == 0 {
+			index = raft.LogIndex(d.Remote.Version)
+		}
+	}
+
+	description := fmt.Sprintf("DC %s 中键 %q 的值与本地不一致", dcID, d.Key)
+	switch {
+	case d.Local == nil:
+		description = fmt.Sprintf("DC %s 中存在本地已没有的键 %q", dcID, d.Key)
+	case d.Remote == nil:
+		description = fmt.Sprintf("DC %s 中缺少键 %q", dcID, d.Key)
+	}
+
+	now := time.Now()
+	inconsistency := &DataInconsistency{
+		ID:              fmt.Sprintf("state-%s-%s-%d", dcID, d.Key, now.UnixNano()),
+		Type:            ConflictingEntries,
+		DetectedAt:      now,
+		SourceDC:        cr.getLocalDC(),
+		TargetDC:        dcID,
+		LogIndex:        index,
+		Keys:            []string{d.Key},
+		Severity:        cr.calculateInconsistencySeverity(ConflictingEntries),
+		ImpactLevel:     "High",
+		Description:     description,
+		ConflictDetails: details,
+		RepairStatus:    RepairSkipped,
+		RepairStrategy:  "manual",
+	}
+
+	cr.inconsistencies[inconsistency.ID] = inconsistency
+	cr.currentSnapshot.InconsistencyDetails = append(cr.currentSnapshot.InconsistencyDetails, inconsistency)
+	cr.totalInconsistenciesDetected++
+	return inconsistency.ID
+}
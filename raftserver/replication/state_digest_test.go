@@ -0,0 +1,136 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-20 15:41:09
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-20 15:41:09
+* @Description: ConcordKV replication - state_digest_test.go
+ */
+package replication_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/replication"
+	"raftserver/statemachine"
+)
+
+// stateMachineFetcher 直接从各DC的状态机计算状态摘要，记录逐键比较的桶
+type stateMachineFetcher struct {
+	machines map[raft.DataCenterID]*statemachine.KVStateMachine
+	drilled  []int
+}
+
+func (f *stateMachineFetcher) FetchStateDigest(ctx context.Context, dcID raft.DataCenterID, buckets int) (*statemachine.StateDigest, error) {
+	sm, exists := f.machines[dcID]
+	if !exists {
+		return nil, fmt.Errorf("未知DC %s", dcID)
+	}
+	return sm.StateDigest(buckets), nil
+}
+
+func (f *stateMachineFetcher) FetchBucketKeys(ctx context.Context, dcID raft.DataCenterID, buckets int, selected []int) (*statemachine.StateDigest, error) {
+	f.drilled = append(f.drilled, selected...)
+	return f.machines[dcID].BucketKeys(buckets, selected), nil
+}
+
+// applyCommand 把命令作为index处的日志条目应用到状态机
+func applyCommand(t *testing.T, sm *statemachine.KVStateMachine, index int, data []byte, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("构造命令失败: %v", err)
+	}
+	entry := &raft.LogEntry{Index: raft.LogIndex(index), Term: 1, Type: raft.EntryNormal, Data: data, Timestamp: time.Unix(1700000000, 0)}
+	if err := sm.Apply(entry); err != nil {
+		t.Fatalf("应用条目 %d 失败: %v", index, err)
+	}
+}
+
+// TestStateDigestFindsDivergentKey 远端某个键在相同日志索引处的值不同时，只有该键被记录为冲突；
+// 本地领先远端的写入和删除属于复制延迟，不记录
+func TestStateDigestFindsDivergentKey(t *testing.T) {
+	local := statemachine.NewKVStateMachine()
+	remote := statemachine.NewKVStateMachine()
+	for i := 1; i <= 300; i++ {
+		data, err := statemachine.CreateSetCommand(fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i))
+		applyCommand(t, local, i, data, err)
+		if i == 137 {
+			data, err = statemachine.CreateSetCommand("key-137", "corrupted")
+		}
+		applyCommand(t, remote, i, data, err)
+	}
+
+	// 本地多应用了两个条目，远端尚未收到
+	data, err := statemachine.CreateSetCommand("key-new", "fresh")
+	applyCommand(t, local, 301, data, err)
+	data, err = statemachine.CreateDeleteCommand("key-5")
+	applyCommand(t, local, 302, data, err)
+
+	config := replication.DefaultConsistencyRecoveryConfig()
+	config.DifferenceDetectionInterval = time.Hour
+	config.VerificationEnabled = false
+	ar := newTestReplicator(t, testConfig(100), &mockTransport{})
+	recovery := replication.NewConsistencyRecovery("n1", config, &memLogStorage{}, ar, nil, nil)
+	fetcher := &stateMachineFetcher{machines: map[raft.DataCenterID]*statemachine.KVStateMachine{"dc2": remote}}
+	recovery.SetStateDigestFetcher(local, fetcher)
+
+	recovery.VerifyStateConsistency()
+
+	inconsistencies := recovery.GetInconsistencies()
+	if len(inconsistencies) != 1 {
+		t.Fatalf("应只记录1个不一致，实际 %d 个: %+v", len(inconsistencies), inconsistencies)
+	}
+	for _, inconsistency := range inconsistencies {
+		if inconsistency.Type != replication.ConflictingEntries || inconsistency.TargetDC != "dc2" {
+			t.Errorf("不一致类型或目标DC错误: %+v", inconsistency)
+		}
+		if len(inconsistency.Keys) != 1 || inconsistency.Keys[0] != "key-137" || inconsistency.LogIndex != 137 {
+			t.Errorf("应定位到键key-137: keys=%v index=%d", inconsistency.Keys, inconsistency.LogIndex)
+		}
+		if inconsistency.RepairStatus != replication.RepairSkipped {
+			t.Errorf("状态分歧不应自动修复: %v", inconsistency.RepairStatus)
+		}
+	}
+	if recovery.IsGloballyConsistent() {
+		t.Error("存在分歧的键时不应全局一致")
+	}
+
+	// 只有三个键所在的桶需要逐键比较
+	wanted := map[int]bool{}
+	for _, key := range []string{"key-137", "key-new", "key-5"} {
+		wanted[statemachine.DigestBucket(key, config.StateDigestBuckets)] = true
+	}
+	if len(fetcher.drilled) != len(wanted) {
+		t.Errorf("应只逐键比较 %d 个桶，实际 %v", len(wanted), fetcher.drilled)
+	}
+	for _, bucket := range fetcher.drilled {
+		if !wanted[bucket] {
+			t.Errorf("桶 %d 的摘要应一致，不需要逐键比较", bucket)
+		}
+	}
+
+	// 分歧未消除时不重复记录；远端追上并修正后分歧消除
+	recovery.VerifyStateConsistency()
+	if n := len(recovery.GetInconsistencies()); n != 1 {
+		t.Fatalf("同一分歧不应重复记录，实际 %d 个", n)
+	}
+	data, err = statemachine.CreateSetCommand("key-new", "fresh")
+	applyCommand(t, remote, 301, data, err)
+	data, err = statemachine.CreateDeleteCommand("key-5")
+	applyCommand(t, remote, 302, data, err)
+	data, err = statemachine.CreateSetCommand("key-137", "value-137")
+	applyCommand(t, remote, 303, data, err)
+	applyCommand(t, local, 303, data, err)
+
+	fetcher.drilled = nil
+	recovery.VerifyStateConsistency()
+	if len(fetcher.drilled) != 0 {
+		t.Errorf("状态一致时不应逐键比较: %v", fetcher.drilled)
+	}
+	if !recovery.IsGloballyConsistent() {
+		t.Error("分歧消除后应全局一致")
+	}
+}
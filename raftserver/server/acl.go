@@ -0,0 +1,382 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - acl.go
+ */
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"raftserver/statemachine"
+)
+
+// ACLConfig 按键前缀的访问控制配置
+// 配置文件中的令牌在各节点本地加载，通过/api/acl/tokens创建的令牌经Raft复制到所有节点
+type ACLConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Tokens 静态令牌，格式：名称:令牌:规则
+	// 规则为admin，或以分号分隔的 权限=模式 列表，权限为r、w、rw，模式以*结尾时按前缀匹配
+	// 例如 "teamA:s3cret:rw=teamA/*;r=shared/*"
+	Tokens []string `yaml:"tokens"`
+}
+
+// apiTokenPrincipal 启用ACL时apiToken作为管理员令牌
+var apiTokenPrincipal = &statemachine.ACLToken{Name: "apiToken", Admin: true}
+
+// principalKey 请求上下文中保存认证主体的键
+type principalKey struct{}
+
+// parseACLToken 解析静态令牌定义
+func parseACLToken(entry string) (*statemachine.ACLToken, error) {
+	parts := strings.SplitN(entry, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("ACL令牌条目 %q 格式错误，应为 名称:令牌:规则", entry)
+	}
+
+	token := &statemachine.ACLToken{
+		Name:      parts[0],
+		TokenHash: statemachine.HashACLToken(parts[1]),
+	}
+
+	if parts[2] == "admin" {
+		token.Admin = true
+		return token, nil
+	}
+
+	for _, item := range strings.Split(parts[2], ";") {
+		access, pattern, ok := strings.Cut(item, "=")
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("令牌 %s 的规则 %q 格式错误，应为 权限=模式", token.Name, item)
+		}
+		parsed, err := statemachine.ParseACLAccess(access)
+		if err != nil {
+			return nil, fmt.Errorf("令牌 %s: %w", token.Name, err)
+		}
+		token.Rules = append(token.Rules, statemachine.ACLRule{Pattern: pattern, Access: parsed})
+	}
+
+	return token, nil
+}
+
+// loadStaticACL 加载配置文件中的令牌，按令牌摘要索引
+func loadStaticACL(config *ServerConfig) (map[string]*statemachine.ACLToken, error) {
+	if !config.ACL.Enabled {
+		return nil, nil
+	}
+
+	tokens := make(map[string]*statemachine.ACLToken, len(config.ACL.Tokens))
+	names := make(map[string]bool, len(config.ACL.Tokens))
+	hasAdmin := config.APIToken != ""
+
+	for _, entry := range config.ACL.Tokens {
+		token, err := parseACLToken(entry)
+		if err != nil {
+			return nil, err
+		}
+		if names[token.Name] {
+			return nil, fmt.Errorf("ACL令牌名称 %s 重复", token.Name)
+		}
+		if _, exists := tokens[token.TokenHash]; exists {
+			return nil, fmt.Errorf("ACL令牌 %s 与其他令牌使用了相同的密钥", token.Name)
+		}
+		names[token.Name] = true
+		tokens[token.TokenHash] = token
+		hasAdmin = hasAdmin || token.Admin
+	}
+
+	if !hasAdmin {
+		return nil, fmt.Errorf("启用ACL时必须配置apiToken或至少一个管理员令牌")
+	}
+
+	return tokens, nil
+}
+
+// bearerToken 从Authorization头中提取Bearer令牌
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return header[len(prefix):]
+}
+
+// lookupACLToken 按令牌查找主体，先查配置文件中的令牌，再查经Raft复制的令牌
+func (s *Server) lookupACLToken(token string) *statemachine.ACLToken {
+	if token == "" {
+		return nil
+	}
+
+	hash := statemachine.HashACLToken(token)
+	if principal, exists := s.staticACL[hash]; exists {
+		return principal
+	}
+	if principal, exists := s.stateMachine.LookupACLToken(hash); exists {
+		return principal
+	}
+	return nil
+}
+
+// principalFrom 获取请求的认证主体，未启用ACL时返回nil
+func principalFrom(r *http.Request) *statemachine.ACLToken {
+	principal, _ := r.Context().Value(principalKey{}).(*statemachine.ACLToken)
+	return principal
+}
+
+// withPrincipal 将认证主体保存到请求上下文
+func withPrincipal(r *http.Request, principal *statemachine.ACLToken) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+}
+
+// authorizeKey 检查请求主体对键的访问权限，拒绝时返回403并记录审计日志
+func (s *Server) authorizeKey(w http.ResponseWriter, r *http.Request, key string, need statemachine.ACLAccess) bool {
+	principal := principalFrom(r)
+	if principal == nil || principal.Allows(key, need) {
+		return true
+	}
+
+	s.denyAccess(w, r, principal, fmt.Sprintf("键 %q", key), accessName(need))
+	return false
+}
+
+// authorizePrefix 检查请求主体能否读取前缀下的某些键，前缀扫描的结果再按键过滤
+func (s *Server) authorizePrefix(w http.ResponseWriter, r *http.Request, prefix string) bool {
+	principal := principalFrom(r)
+	if principal == nil || principal.MayRead(prefix) {
+		return true
+	}
+
+	s.denyAccess(w, r, principal, fmt.Sprintf("前缀 %q", prefix), accessName(statemachine.ACLRead))
+	return false
+}
+
+// requireAdmin 检查请求主体是否为管理员
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	principal := principalFrom(r)
+	if principal == nil || principal.Admin {
+		return true
+	}
+
+	s.denyAccess(w, r, principal, r.URL.Path, "管理")
+	return false
+}
+
+// readableKeys 过滤出请求主体可读的键
+func readableKeys(r *http.Request, keys []string) []string {
+	principal := principalFrom(r)
+	if principal == nil || principal.Admin {
+		return keys
+	}
+
+	result := keys[:0:0]
+	for _, key := range keys {
+		if principal.Allows(key, statemachine.ACLRead) {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// denyAccess 返回403并记录审计日志
+func (s *Server) denyAccess(w http.ResponseWriter, r *http.Request, principal *statemachine.ACLToken, resource, action string) {
+	source := r.RemoteAddr
+	if via := r.Header.Get(forwardedHeader); via != "" {
+		source = fmt.Sprintf("%s（经 %s 转发）", source, via)
+	}
+	s.logger.Warn("审计: 拒绝请求", "token", principal.Name, "action", action, "resource", resource, "method", r.Method, "path", r.URL.Path, "source", source)
+
+	writeError(w, http.StatusForbidden, codePermissionDenied, fmt.Sprintf("令牌 %s 无权%s%s", principal.Name, action, resource))
+}
+
+// accessName 权限的中文描述
+func accessName(access statemachine.ACLAccess) string {
+	switch access {
+	case statemachine.ACLRead:
+		return "读取"
+	case statemachine.ACLWrite:
+		return "写入"
+	default:
+		return "读写"
+	}
+}
+
+// aclTokenView 令牌列表中的条目，不包含令牌摘要
+type aclTokenView struct {
+	Name   string                 `json:"name"`
+	Admin  bool                   `json:"admin"`
+	Rules  []statemachine.ACLRule `json:"rules,omitempty"`
+	Source string                 `json:"source"` // config或raft
+}
+
+// handleACLTokens 管理访问令牌：GET列出，POST创建或更新，DELETE删除
+func (s *Server) handleACLTokens(w http.ResponseWriter, r *http.Request) {
+	if !s.config.ACL.Enabled {
+		http.Error(w, "未启用ACL", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		if !s.requireAdmin(w, r) {
+			return
+		}
+		s.listACLTokens(w)
+	case "POST", "DELETE":
+		if s.redirectToLeader(w, r) || !s.requireAdmin(w, r) {
+			return
+		}
+		if r.Method == "POST" {
+			s.putACLToken(w, r)
+		} else {
+			s.deleteACLToken(w, r)
+		}
+	default:
+		http.Error(w, "只支持GET、POST、DELETE方法", http.StatusMethodNotAllowed)
+	}
+}
+
+// listACLTokens 列出所有令牌
+func (s *Server) listACLTokens(w http.ResponseWriter) {
+	tokens := make([]aclTokenView, 0, len(s.staticACL))
+	for _, token := range s.staticACL {
+		tokens = append(tokens, aclTokenView{Name: token.Name, Admin: token.Admin, Rules: token.Rules, Source: "config"})
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Name < tokens[j].Name })
+
+	for _, token := range s.stateMachine.ACLTokens() {
+		tokens = append(tokens, aclTokenView{Name: token.Name, Admin: token.Admin, Rules: token.Rules, Source: "raft"})
+	}
+
+	response := map[string]interface{}{
+		"tokens": tokens,
+		"count":  len(tokens),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// staticACLToken 按名称查找配置文件中的令牌
+func (s *Server) staticACLToken(name string) (*statemachine.ACLToken, bool) {
+	for _, token := range s.staticACL {
+		if token.Name == name {
+			return token, true
+		}
+	}
+	return nil, false
+}
+
+// putACLToken 创建或更新令牌，通过Raft复制到所有节点
+func (s *Server) putACLToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name  string                 `json:"name"`
+		Token string                 `json:"token"`
+		Admin bool                   `json:"admin"`
+		Rules []statemachine.ACLRule `json:"rules"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "解析请求失败", http.StatusBadRequest)
+		return
+	}
+
+	if req.Token == "" {
+		http.Error(w, "token不能为空", http.StatusBadRequest)
+		return
+	}
+
+	token := &statemachine.ACLToken{
+		Name:      req.Name,
+		TokenHash: statemachine.HashACLToken(req.Token),
+		Admin:     req.Admin,
+		Rules:     req.Rules,
+	}
+	if err := token.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, exists := s.staticACLToken(token.Name); exists {
+		http.Error(w, fmt.Sprintf("令牌 %s 在配置文件中定义，不能通过API修改", token.Name), http.StatusConflict)
+		return
+	}
+	if existing := s.lookupACLToken(req.Token); existing != nil && existing.Name != token.Name {
+		http.Error(w, fmt.Sprintf("该令牌已被 %s 使用", existing.Name), http.StatusConflict)
+		return
+	}
+
+	index, _, ok := s.proposeCommand(w, r, statemachine.Command{Type: "ACL_SET", ACLToken: token})
+	if !ok {
+		return
+	}
+
+	s.logger.Info("审计: 写入ACL令牌", "token", principalName(r), "target_token", token.Name, "admin", token.Admin, "rules", len(token.Rules), "index", index)
+
+	response := map[string]interface{}{
+		"success": true,
+		"name":    token.Name,
+		"index":   index,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// deleteACLToken 删除令牌，通过Raft复制到所有节点
+func (s *Server) deleteACLToken(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "缺少name参数", http.StatusBadRequest)
+		return
+	}
+
+	if _, exists := s.staticACLToken(name); exists {
+		http.Error(w, fmt.Sprintf("令牌 %s 在配置文件中定义，不能通过API删除", name), http.StatusConflict)
+		return
+	}
+
+	found := false
+	for _, token := range s.stateMachine.ACLTokens() {
+		if token.Name == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf("令牌 %s 不存在", name), http.StatusNotFound)
+		return
+	}
+
+	index, _, ok := s.proposeCommand(w, r, statemachine.Command{Type: "ACL_DELETE", Key: name})
+	if !ok {
+		return
+	}
+
+	s.logger.Info("审计: 删除ACL令牌", "token", principalName(r), "target_token", name, "index", index)
+
+	response := map[string]interface{}{
+		"success": true,
+		"name":    name,
+		"index":   index,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// principalName 请求主体名称，用于审计日志
+func principalName(r *http.Request) string {
+	if principal := principalFrom(r); principal != nil {
+		return principal.Name
+	}
+	return "-"
+}
@@ -0,0 +1,254 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - acl_test.go
+ */
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// applyCommand 以日志条目的形式把命令应用到状态机，返回命令的应用错误
+func applyCommand(t *testing.T, sm *statemachine.KVStateMachine, index raft.LogIndex, cmd statemachine.Command) error {
+	t.Helper()
+	_, err := applyCommandAt(t, sm, index, time.Now(), cmd)
+	return err
+}
+
+// applyCommandAt 以指定时间戳的日志条目应用命令，返回命令的应用结果
+func applyCommandAt(t *testing.T, sm *statemachine.KVStateMachine, index raft.LogIndex, ts time.Time, cmd statemachine.Command) (*statemachine.CommandResult, error) {
+	t.Helper()
+
+	cmd.RequestID = fmt.Sprintf("test-%d", index)
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		t.Fatalf("序列化命令失败: %v", err)
+	}
+
+	waiter := sm.RegisterWaiter(context.Background(), cmd.RequestID)
+	if err := sm.Apply(&raft.LogEntry{Index: index, Term: 1, Timestamp: ts, Type: raft.EntryNormal, Data: data}); err != nil {
+		t.Fatalf("应用日志条目失败: %v", err)
+	}
+	result := <-waiter
+	return result, result.Err
+}
+
+// TestParseACLToken 解析配置文件中的令牌定义
+func TestParseACLToken(t *testing.T) {
+	token, err := parseACLToken("teamA:s3cret:rw=teamA/*;r=shared/*;w=inbox")
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	if token.Name != "teamA" || token.TokenHash != statemachine.HashACLToken("s3cret") || len(token.Rules) != 3 {
+		t.Fatalf("解析结果不正确: %+v", token)
+	}
+
+	cases := []struct {
+		key  string
+		need statemachine.ACLAccess
+		ok   bool
+	}{
+		{"teamA/x", statemachine.ACLRead, true},
+		{"teamA/x", statemachine.ACLWrite, true},
+		{"shared/doc", statemachine.ACLRead, true},
+		{"shared/doc", statemachine.ACLWrite, false},
+		{"inbox", statemachine.ACLWrite, true},
+		{"inbox/1", statemachine.ACLWrite, false},
+		{"inbox", statemachine.ACLRead, false},
+		{"teamB/x", statemachine.ACLRead, false},
+	}
+	for _, c := range cases {
+		if got := token.Allows(c.key, c.need); got != c.ok {
+			t.Errorf("%s %s：期望 %v，实际 %v", c.need, c.key, c.ok, got)
+		}
+	}
+
+	if !token.MayRead("team") || !token.MayRead("teamA/sub/") || token.MayRead("teamB/") {
+		t.Errorf("前缀可读判断不正确")
+	}
+
+	admin, err := parseACLToken("root:pw:with:colons:admin")
+	if err == nil {
+		t.Errorf("规则格式错误时应解析失败，实际 %+v", admin)
+	}
+	for _, entry := range []string{"noRules:pw:", "a:pw:x=foo/*", "a:pw:r=", ":pw:admin"} {
+		if _, err := parseACLToken(entry); err == nil {
+			t.Errorf("%q 应解析失败", entry)
+		}
+	}
+
+	if _, err := loadStaticACL(&ServerConfig{ACL: ACLConfig{Enabled: true, Tokens: []string{"a:pw:r=x*"}}}); err == nil {
+		t.Errorf("没有管理员令牌时应拒绝启用ACL")
+	}
+	if _, err := loadStaticACL(&ServerConfig{ACL: ACLConfig{Enabled: true, Tokens: []string{"a:pw:admin", "b:pw:r=x*"}}}); err == nil {
+		t.Errorf("密钥重复时应加载失败")
+	}
+}
+
+// TestACLReplicatedTokens 通过日志写入的令牌随快照恢复，密钥冲突的命令被拒绝且不影响后续日志的应用
+func TestACLReplicatedTokens(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+
+	teamA := &statemachine.ACLToken{
+		Name:      "teamA",
+		TokenHash: statemachine.HashACLToken("a-secret"),
+		Rules:     []statemachine.ACLRule{{Pattern: "teamA/*", Access: statemachine.ACLReadWrite}},
+	}
+	if err := applyCommand(t, sm, 1, statemachine.Command{Type: "ACL_SET", ACLToken: teamA}); err != nil {
+		t.Fatalf("写入令牌失败: %v", err)
+	}
+
+	clash := &statemachine.ACLToken{Name: "teamB", TokenHash: teamA.TokenHash}
+	if err := applyCommand(t, sm, 2, statemachine.Command{Type: "ACL_SET", ACLToken: clash}); err == nil {
+		t.Fatalf("密钥冲突时应用应失败")
+	}
+	if token, _ := sm.LookupACLToken(teamA.TokenHash); token.Name != "teamA" {
+		t.Fatalf("冲突的令牌不应生效: %+v", token)
+	}
+
+	// 轮换密钥后旧密钥失效
+	rotated := *teamA
+	rotated.TokenHash = statemachine.HashACLToken("a-rotated")
+	if err := applyCommand(t, sm, 3, statemachine.Command{Type: "ACL_SET", ACLToken: &rotated}); err != nil {
+		t.Fatalf("更新令牌失败: %v", err)
+	}
+	if _, ok := sm.LookupACLToken(teamA.TokenHash); ok {
+		t.Errorf("轮换后旧密钥仍然有效")
+	}
+
+	snapshot, err := sm.CreateSnapshot()
+	if err != nil {
+		t.Fatalf("创建快照失败: %v", err)
+	}
+	restored := statemachine.NewKVStateMachine()
+	if err := restored.RestoreSnapshot(snapshot); err != nil {
+		t.Fatalf("恢复快照失败: %v", err)
+	}
+	token, ok := restored.LookupACLToken(rotated.TokenHash)
+	if !ok || !token.Allows("teamA/k", statemachine.ACLWrite) {
+		t.Fatalf("快照恢复后令牌丢失: %+v", token)
+	}
+
+	if err := applyCommand(t, restored, 4, statemachine.Command{Type: "ACL_DELETE", Key: "teamA"}); err != nil {
+		t.Fatalf("删除令牌失败: %v", err)
+	}
+	if len(restored.ACLTokens()) != 0 {
+		t.Errorf("删除后仍有令牌")
+	}
+}
+
+// TestACLHandlers 读请求按令牌规则授权，越权请求返回403并记录审计日志
+func TestACLHandlers(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	for i, key := range []string{"shared/a", "teamA/a", "teamA/b", "teamB/a"} {
+		applyCommand(t, sm, raft.LogIndex(i+1), statemachine.Command{Type: "SET", Key: key, Value: "v"})
+	}
+
+	reader := &statemachine.ACLToken{
+		Name:      "reader",
+		TokenHash: statemachine.HashACLToken("r-secret"),
+		Rules:     []statemachine.ACLRule{{Pattern: "teamA/*", Access: statemachine.ACLRead}},
+	}
+	applyCommand(t, sm, 10, statemachine.Command{Type: "ACL_SET", ACLToken: reader})
+
+	config := &ServerConfig{ACL: ACLConfig{Enabled: true, Tokens: []string{"root:root-secret:admin", "shared:s-secret:r=shared/*"}}}
+	staticACL, err := loadStaticACL(config)
+	if err != nil {
+		t.Fatalf("加载ACL失败: %v", err)
+	}
+
+	var audit bytes.Buffer
+	s := &Server{config: config, stateMachine: sm, staticACL: staticACL, logger: logging.New(logging.Options{Output: &audit})}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/get", s.handleGet)
+	mux.HandleFunc("/api/scan", s.handleScan)
+	mux.HandleFunc("/api/keys", s.handleKeys)
+	mux.HandleFunc("/api/logs", s.handleLogs)
+	mux.HandleFunc("/api/acl/tokens", s.handleACLTokens)
+	handler := s.authenticate(mux)
+
+	do := func(token, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	cases := []struct {
+		token  string
+		target string
+		code   int
+	}{
+		{"", "/api/get?key=teamA/a&stale=true", http.StatusUnauthorized},
+		{"unknown", "/api/get?key=teamA/a&stale=true", http.StatusUnauthorized},
+		{"r-secret", "/api/get?key=teamA/a&stale=true", http.StatusOK},
+		{"r-secret", "/api/get?key=teamB/a&stale=true", http.StatusForbidden},
+		{"s-secret", "/api/get?key=shared/a&stale=true", http.StatusOK},
+		{"s-secret", "/api/get?key=teamA/a&stale=true", http.StatusForbidden},
+		{"root-secret", "/api/get?key=teamB/a&stale=true", http.StatusOK},
+		{"r-secret", "/api/scan?prefix=teamB/&stale=true", http.StatusForbidden},
+		{"r-secret", "/api/logs", http.StatusForbidden},
+		{"r-secret", "/api/acl/tokens", http.StatusForbidden},
+		{"root-secret", "/api/acl/tokens", http.StatusOK},
+	}
+	for _, c := range cases {
+		if recorder := do(c.token, c.target); recorder.Code != c.code {
+			t.Errorf("令牌 %q 请求 %s：期望 %d，实际 %d %s", c.token, c.target, c.code, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	if !strings.Contains(audit.String(), `msg="审计: 拒绝请求" token=reader`) {
+		t.Errorf("越权请求未记录审计日志: %s", audit.String())
+	}
+
+	// 扫描全部键时只返回有权读取的键，分页游标不受过滤影响
+	var scan struct {
+		Keys    []string `json:"keys"`
+		HasMore bool     `json:"hasMore"`
+		Cursor  string   `json:"cursor"`
+	}
+	json.NewDecoder(do("r-secret", "/api/scan?prefix=&limit=2&stale=true").Body).Decode(&scan)
+	if len(scan.Keys) != 1 || scan.Keys[0] != "teamA/a" || !scan.HasMore {
+		t.Fatalf("第一页扫描结果不正确: %+v", scan)
+	}
+	json.NewDecoder(do("r-secret", "/api/scan?prefix=&limit=2&stale=true&cursor="+scan.Cursor).Body).Decode(&scan)
+	if len(scan.Keys) != 1 || scan.Keys[0] != "teamA/b" {
+		t.Fatalf("第二页扫描结果不正确: %+v", scan)
+	}
+
+	var keys struct {
+		Keys []string `json:"keys"`
+	}
+	json.NewDecoder(do("s-secret", "/api/keys").Body).Decode(&keys)
+	if len(keys.Keys) != 1 || keys.Keys[0] != "shared/a" {
+		t.Errorf("键列表未按权限过滤: %v", keys.Keys)
+	}
+
+	var tokens struct {
+		Tokens []aclTokenView `json:"tokens"`
+	}
+	body := do("root-secret", "/api/acl/tokens").Body.String()
+	json.Unmarshal([]byte(body), &tokens)
+	if len(tokens.Tokens) != 3 || strings.Contains(body, reader.TokenHash) {
+		t.Errorf("令牌列表不正确或泄露了摘要: %s", body)
+	}
+}
@@ -0,0 +1,158 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-19 09:20:14
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-19 09:20:14
+* @Description: ConcordKV Raft consensus server - api.go
+ */
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"raftserver/raft"
+)
+
+// 客户端API请求的默认处理超时
+const (
+	defaultReadTimeout  = 5 * time.Second
+	defaultWriteTimeout = 10 * time.Second
+)
+
+// 错误响应中机器可读的错误码
+const (
+	codeNotLeader        = "NOT_LEADER"
+	codeKeyNotFound      = "KEY_NOT_FOUND"
+	codeTimeout          = "TIMEOUT"
+	codeInvalidArgument  = "INVALID_ARGUMENT"
+	codeUnavailable      = "UNAVAILABLE"
+	codeValueTooLarge    = "VALUE_TOO_LARGE"
+	codeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	codeUnauthenticated  = "UNAUTHENTICATED"
+	codePermissionDenied = "PERMISSION_DENIED"
+	codeConflict         = "CONFLICT"
+	codeSessionExpired   = "SESSION_EXPIRED"
+	codeInternal         = "INTERNAL"
+	codeCatchingUp       = "CATCHING_UP"
+	codeQuotaExceeded    = "QUOTA_EXCEEDED"
+)
+
+// apiError 统一的错误响应体 {"error": {"code": "...", "message": "...", "raftIndex": ...}}
+// raftIndex为与错误相关的日志索引：写请求超时时为命令被分配的索引，不是领导者时为本节点的提交索引
+type apiError struct {
+	Code      string        `json:"code"`
+	Message   string        `json:"message"`
+	RaftIndex raft.LogIndex `json:"raftIndex,omitempty"`
+
+	// 不是领导者时附带已知的领导者及其API地址
+	Leader        raft.NodeID `json:"leader,omitempty"`
+	LeaderAPIAddr string      `json:"leaderApiAddr,omitempty"`
+}
+
+// writeAPIError 以统一的错误响应体返回错误
+func writeAPIError(w http.ResponseWriter, status int, apiErr apiError) {
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]apiError{"error": apiErr})
+}
+
+// writeError 返回只有错误码与描述的错误
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeAPIError(w, status, apiError{Code: code, Message: message})
+}
+
+// notLeaderError 本节点不是领导者时的错误，附带已知的领导者与本节点的提交索引
+// 调用方未给出领导者或其API地址时按本节点当前所知补全，客户端据此直接改写领导者而不必逐个节点试探
+func (s *Server) notLeaderError(leader raft.NodeID, addr string) apiError {
+	if leader == "" {
+		leader = s.currentLeader()
+	}
+	if addr == "" {
+		addr = s.leaderAPIAddr(leader)
+	}
+	return apiError{
+		Code:          codeNotLeader,
+		Message:       "不是领导者",
+		RaftIndex:     s.raftNode.GetMetrics().CommitIndex,
+		Leader:        leader,
+		LeaderAPIAddr: addr,
+	}
+}
+
+// requestKind 客户端API请求的类别，决定处理超时
+type requestKind int
+
+const (
+	readRequest  requestKind = iota // 使用ReadTimeout
+	writeRequest                    // 使用WriteTimeout
+)
+
+// api 为客户端API处理器加上方法检查、请求体长度限制、请求追踪与处理超时
+// 超时通过请求上下文传递，等待Raft提交或ReadIndex的处理器在超时后放弃等待并返回504；
+// 读取请求体同样受超时限制，慢速客户端不能无限占用处理协程
+func (s *Server) api(kind requestKind, handler http.HandlerFunc, methods ...string) http.HandlerFunc {
+	allow := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !methodAllowed(r.Method, methods) {
+			w.Header().Set("Allow", allow)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, fmt.Sprintf("只支持%s方法", strings.Join(methods, "、")))
+			return
+		}
+
+		// GET与DELETE请求不应携带请求体，写请求的请求体由处理器按键值上限限制
+		if r.Method == http.MethodGet || r.Method == http.MethodDelete {
+			r.Body = http.MaxBytesReader(w, r.Body, requestOverhead)
+		}
+
+		// 追踪请求的各阶段，请求ID同时写入请求头，转发到领导者时沿用同一个ID
+		if trace := s.traces.begin(r); trace != nil {
+			r.Header.Set(requestIDHeader, trace.id)
+			w.Header().Set(requestIDHeader, trace.id)
+			recorder := &statusRecorder{ResponseWriter: w}
+			w = recorder
+			r = r.WithContext(withTrace(r.Context(), trace))
+			defer func() { s.traces.finish(trace, recorder.status) }()
+		}
+
+		timeout := s.config.ReadTimeout
+		if kind == writeRequest {
+			timeout = s.config.WriteTimeout
+		}
+		if timeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+
+			// httptest等不支持读超时的ResponseWriter忽略错误
+			http.NewResponseController(w).SetReadDeadline(time.Now().Add(timeout))
+		}
+
+		handler(w, r)
+	}
+}
+
+// methodAllowed 请求方法是否在允许的方法中
+func methodAllowed(method string, methods []string) bool {
+	for _, m := range methods {
+		if method == m {
+			return true
+		}
+	}
+	return false
+}
+
+// requestContext 等待Raft提交或ReadIndex使用的上下文
+// 经api中间件的请求已带有处理超时，其余请求使用applyWaitTimeout
+func requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if _, ok := r.Context().Deadline(); ok {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), applyWaitTimeout)
+}
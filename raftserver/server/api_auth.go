@@ -0,0 +1,75 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - api_auth.go
+ */
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// authenticate 配置了APIToken或启用ACL时校验请求携带的Bearer令牌
+// 启用ACL时令牌对应的主体保存在请求上下文中，由各处理器按键授权；apiToken视为管理员
+// 客户端证书由TLS层按clientAuth模式校验，两者可以同时启用
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	if s.config.APIToken == "" && !s.config.ACL.Enabled {
+		return next
+	}
+
+	apiToken := []byte(s.config.APIToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+
+		var principal *statemachine.ACLToken
+		if len(apiToken) > 0 && subtle.ConstantTimeCompare([]byte(token), apiToken) == 1 {
+			principal = apiTokenPrincipal
+		} else if s.config.ACL.Enabled {
+			principal = s.lookupACLToken(token)
+		}
+
+		if principal == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="concordkv"`)
+			writeError(w, http.StatusUnauthorized, codeUnauthenticated, "未授权")
+			return
+		}
+
+		if s.config.ACL.Enabled {
+			r = withPrincipal(r, principal)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// resolvePeerAPI 根据API地址查找节点ID，转发请求时校验领导者证书身份
+func (s *Server) resolvePeerAPI(addr string) (raft.NodeID, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for id, apiAddr := range s.config.PeerAPIAddrs {
+		if apiAddr == addr {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// ReloadTLS 重新加载TLS证书，之后建立的节点间连接与API连接使用新证书
+func (s *Server) ReloadTLS() error {
+	if s.tls == nil {
+		return fmt.Errorf("未启用TLS")
+	}
+	if err := s.tls.Reload(); err != nil {
+		return err
+	}
+
+	s.logger.Info("已重新加载TLS证书")
+	return nil
+}
@@ -0,0 +1,50 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - api_auth_test.go
+ */
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestAPITokenAuthentication 配置令牌后只有携带正确Bearer令牌的请求被放行
+func TestAPITokenAuthentication(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	open := &Server{config: &ServerConfig{}}
+	recorder := httptest.NewRecorder()
+	open.authenticate(ok).ServeHTTP(recorder, httptest.NewRequest("GET", "/api/status", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("未配置令牌时应放行，实际 %d", recorder.Code)
+	}
+
+	protected := (&Server{config: &ServerConfig{APIToken: "secret"}}).authenticate(ok)
+	cases := []struct {
+		header string
+		code   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"secret", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/api/get?key=a", nil)
+		if c.header != "" {
+			req.Header.Set("Authorization", c.header)
+		}
+		recorder := httptest.NewRecorder()
+		protected.ServeHTTP(recorder, req)
+		if recorder.Code != c.code {
+			t.Errorf("Authorization=%q：期望 %d，实际 %d", c.header, c.code, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-19 09:20:14
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-19 09:20:14
+* @Description: ConcordKV Raft consensus server - api_test.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// cancelRecorder 记录被取消的等待，验证超时后放弃了对应用结果的等待
+type cancelRecorder struct {
+	*statemachine.KVStateMachine
+	cancelled atomic.Int32
+}
+
+func (c *cancelRecorder) CancelWaiter(requestID string) {
+	c.cancelled.Add(1)
+	c.KVStateMachine.CancelWaiter(requestID)
+}
+
+// decodeAPIError 解析统一的错误响应体
+func decodeAPIError(t *testing.T, recorder *httptest.ResponseRecorder) apiError {
+	t.Helper()
+	var body struct {
+		Error apiError `json:"error"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("错误响应不是统一格式: %v %s", err, recorder.Body.String())
+	}
+	return body.Error
+}
+
+// TestAPITimeoutAbortsProposal 写请求超过WriteTimeout时放弃等待Raft提交，返回504、TIMEOUT与命令被分配的日志索引
+func TestAPITimeoutAbortsProposal(t *testing.T) {
+	waiter := &cancelRecorder{KVStateMachine: statemachine.NewKVStateMachine()}
+	proposer := &fakeProposer{sm: waiter.KVStateMachine, hold: true}
+	var seq atomic.Uint64
+	nextID := func() string { return fmt.Sprintf("req-%d", seq.Add(1)) }
+	batcher := newProposalBatcher(proposer, waiter, nextID, time.Millisecond, 64, 1024, nil)
+	batcher.Start()
+	defer batcher.Stop()
+
+	s := &Server{config: &ServerConfig{WriteTimeout: 50 * time.Millisecond}, stateMachine: waiter.KVStateMachine, proposals: batcher}
+	handler := s.api(writeRequest, func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := s.proposeCommand(w, r, statemachine.Command{Type: "SET", Key: "k", Value: "v"}); ok {
+			w.WriteHeader(http.StatusOK)
+		}
+	}, http.MethodPost)
+
+	start := time.Now()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/set", nil))
+	elapsed := time.Since(start)
+
+	if recorder.Code != http.StatusGatewayTimeout {
+		t.Fatalf("期望504，实际 %d %s", recorder.Code, recorder.Body.String())
+	}
+	if elapsed >= applyWaitTimeout {
+		t.Errorf("应在WriteTimeout后返回，实际耗时 %v", elapsed)
+	}
+	apiErr := decodeAPIError(t, recorder)
+	if apiErr.Code != codeTimeout || apiErr.RaftIndex != raft.LogIndex(1) {
+		t.Errorf("期望TIMEOUT与日志索引1，实际 %+v", apiErr)
+	}
+	if waiter.cancelled.Load() != 1 {
+		t.Errorf("超时后应取消对应用结果的等待，实际取消 %d 次", waiter.cancelled.Load())
+	}
+}
+
+// TestAPIErrorEnvelope 方法不符、参数缺失与键不存在均以统一的错误响应体返回机器可读的错误码
+func TestAPIErrorEnvelope(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	applyCommand(t, sm, 1, statemachine.Command{Type: "SET", Key: "k", Value: "v"})
+	s := &Server{config: &ServerConfig{ReadTimeout: time.Second}, stateMachine: sm}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/get", s.api(readRequest, s.handleGet, http.MethodGet))
+	do := func(method, target string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+		return recorder
+	}
+
+	recorder := do(http.MethodPost, "/api/get?key=k&stale=true")
+	if recorder.Code != http.StatusMethodNotAllowed || recorder.Header().Get("Allow") != http.MethodGet {
+		t.Errorf("期望405与Allow头，实际 %d %v", recorder.Code, recorder.Header())
+	}
+	if apiErr := decodeAPIError(t, recorder); apiErr.Code != codeMethodNotAllowed {
+		t.Errorf("期望METHOD_NOT_ALLOWED，实际 %+v", apiErr)
+	}
+
+	cases := []struct {
+		target string
+		status int
+		code   string
+	}{
+		{"/api/get?stale=true", http.StatusBadRequest, codeInvalidArgument},
+		{"/api/get?key=k&consistency=eventual", http.StatusBadRequest, codeInvalidArgument},
+		{"/api/get?key=missing&stale=true", http.StatusNotFound, codeKeyNotFound},
+	}
+	for _, c := range cases {
+		recorder := do(http.MethodGet, c.target)
+		if recorder.Code != c.status {
+			t.Errorf("%s: 期望 %d，实际 %d", c.target, c.status, recorder.Code)
+			continue
+		}
+		if apiErr := decodeAPIError(t, recorder); apiErr.Code != c.code || apiErr.Message == "" {
+			t.Errorf("%s: 期望错误码 %s，实际 %+v", c.target, c.code, apiErr)
+		}
+	}
+
+	recorder = do(http.MethodGet, "/api/get?key=k&stale=true")
+	var response struct {
+		Exists bool   `json:"exists"`
+		Value  string `json:"value"`
+	}
+	if recorder.Code != http.StatusOK || json.Unmarshal(recorder.Body.Bytes(), &response) != nil || !response.Exists || response.Value != "v" {
+		t.Errorf("存在的键应正常返回: %d %s", recorder.Code, recorder.Body.String())
+	}
+}
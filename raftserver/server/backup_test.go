@@ -0,0 +1,281 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-24 11:05:32
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-24 11:05:32
+* @Description: ConcordKV Raft consensus server - backup_test.go
+ */
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"raftserver/backup"
+	"raftserver/logging"
+	"raftserver/raft"
+	"raftserver/storage"
+)
+
+// freeAddr 获取一个空闲的本地地址
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("获取空闲端口失败: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startSingleNode 以内存存储启动单节点服务器并等待其成为领导者
+func startSingleNode(t *testing.T, nodeID raft.NodeID, restoreFrom string) *Server {
+	t.Helper()
+	return startSingleNodeWith(t, nodeID, func(config *ServerConfig) { config.RestoreFrom = restoreFrom })
+}
+
+// startSingleNodeWith 同startSingleNode，configure在创建服务器前修改配置
+func startSingleNodeWith(t *testing.T, nodeID raft.NodeID, configure func(*ServerConfig)) *Server {
+	t.Helper()
+	config := &ServerConfig{
+		NodeID:              nodeID,
+		ListenAddr:          freeAddr(t),
+		APIAddr:             freeAddr(t),
+		ElectionTimeout:     150 * time.Millisecond,
+		HeartbeatInterval:   30 * time.Millisecond,
+		MaxLogEntries:       100,
+		SnapshotThreshold:   1000,
+		Peers:               map[raft.NodeID]string{},
+		Storage:             storage.BackendMemory,
+		AllowVolatile:       true,
+		ProposalBatchWindow: time.Millisecond,
+		Logger:              logging.Nop(),
+	}
+	configure(config)
+	s, err := NewServerWithConfig(config)
+	if err != nil {
+		t.Fatalf("创建服务器失败: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务器失败: %v", err)
+	}
+	t.Cleanup(func() { s.Stop() })
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.raftNode.IsLeader() {
+		if time.Now().After(deadline) {
+			t.Fatalf("节点 %s 未成为领导者", nodeID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return s
+}
+
+// apiURL 服务器API地址
+func apiURL(s *Server, path string) string {
+	return "http://" + s.config.APIAddr + path
+}
+
+// setKey 通过API写入键
+func setKey(t *testing.T, s *Server, key string, value interface{}, ttlSeconds int64) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{"key": key, "value": value, "ttlSeconds": ttlSeconds})
+	resp, err := http.Post(apiURL(s, "/api/set"), "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("写入 %s 失败: %v", key, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("写入 %s 的状态码 = %d", key, resp.StatusCode)
+	}
+}
+
+// fetchBackup 通过API获取备份
+func fetchBackup(t *testing.T, s *Server, query string) (*backup.Backup, int) {
+	t.Helper()
+	resp, err := http.Get(apiURL(s, "/api/backup"+query))
+	if err != nil {
+		t.Fatalf("请求备份失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode
+	}
+	b, err := backup.Read(resp.Body)
+	if err != nil {
+		t.Fatalf("解析备份失败: %v", err)
+	}
+	return b, resp.StatusCode
+}
+
+// TestBackupRestoreRoundTrip 完整备份加增量备份合并后恢复到新节点，键值与TTL一致，状态中报告备份的索引
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	source := startSingleNode(t, "node1", "")
+
+	setKey(t, source, "a", "1", 0)
+	setKey(t, source, "b", "2", 0)
+	setKey(t, source, "session", "token", 3600)
+
+	full, status := fetchBackup(t, source, "")
+	if full == nil {
+		t.Fatalf("完整备份的状态码 = %d", status)
+	}
+	if full.Kind != backup.KindFull || full.Index == 0 || full.NodeID != "node1" {
+		t.Fatalf("完整备份头部 = %+v", full.Header)
+	}
+
+	setKey(t, source, "c", map[string]interface{}{"n": 3.0}, 0)
+	setKey(t, source, "a", "updated", 0)
+	req, err := http.NewRequest(http.MethodDelete, apiURL(source, "/api/delete?key=b"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := http.DefaultClient.Do(req)
+	if err != nil || deleted.StatusCode != http.StatusOK {
+		t.Fatalf("删除键b失败: %v", err)
+	}
+	deleted.Body.Close()
+
+	inc, status := fetchBackup(t, source, fmt.Sprintf("?sinceIndex=%d", full.Index))
+	if inc == nil {
+		t.Fatalf("增量备份的状态码 = %d", status)
+	}
+	if inc.Kind != backup.KindIncremental || inc.SinceIndex != full.Index || len(inc.Entries) < 3 {
+		t.Fatalf("增量备份头部 = %+v，%d 个条目", inc.Header, len(inc.Entries))
+	}
+	if _, status := fetchBackup(t, source, fmt.Sprintf("?sinceIndex=%d", inc.Index+100)); status != http.StatusBadRequest {
+		t.Errorf("起始索引超过已应用索引时的状态码 = %d, 期望 400", status)
+	}
+
+	merged, err := backup.Merge(full, inc)
+	if err != nil {
+		t.Fatalf("合并备份失败: %v", err)
+	}
+	if merged.Index != inc.Index || merged.Term != inc.Term {
+		t.Fatalf("合并后的索引 = %d/%d, 期望 %d/%d", merged.Index, merged.Term, inc.Index, inc.Term)
+	}
+	path := filepath.Join(t.TempDir(), "merged.backup")
+	if err := merged.WriteFile(path); err != nil {
+		t.Fatalf("写入备份文件失败: %v", err)
+	}
+
+	restored := startSingleNode(t, "restored", path)
+
+	want := map[string]interface{}{"a": "updated", "session": "token", "c": map[string]interface{}{"n": 3.0}}
+	for key, value := range want {
+		got, ok := restored.stateMachine.Get(key)
+		if !ok {
+			t.Errorf("恢复后缺少键 %s", key)
+			continue
+		}
+		gotJSON, _ := json.Marshal(got)
+		wantJSON, _ := json.Marshal(value)
+		if !bytes.Equal(gotJSON, wantJSON) {
+			t.Errorf("键 %s = %s, 期望 %s", key, gotJSON, wantJSON)
+		}
+	}
+	if _, ok := restored.stateMachine.Get("b"); ok {
+		t.Errorf("增量备份中删除的键b在恢复后仍然存在")
+	}
+	ttl, ok := restored.stateMachine.GetTTL("session")
+	sourceTTL, _ := source.stateMachine.GetTTL("session")
+	if !ok || ttl <= 0 || ttl > sourceTTL+time.Second || ttl < sourceTTL-5*time.Second {
+		t.Errorf("恢复后键session的TTL = %v, 源节点为 %v", ttl, sourceTTL)
+	}
+
+	// 恢复后的节点继续接受写入，日志从备份索引之后继续
+	setKey(t, restored, "after", "restore", 0)
+	if got, _ := restored.stateMachine.Get("after"); got != "restore" {
+		t.Errorf("恢复后写入的键after = %v", got)
+	}
+
+	statusResp, err := http.Get(apiURL(restored, "/api/status"))
+	if err != nil {
+		t.Fatalf("请求状态失败: %v", err)
+	}
+	defer statusResp.Body.Close()
+	var nodeStatus struct {
+		LastApplied  raft.LogIndex `json:"lastApplied"`
+		RestoredFrom *struct {
+			Index  raft.LogIndex `json:"index"`
+			NodeID raft.NodeID   `json:"nodeId"`
+		} `json:"restoredFrom"`
+	}
+	if err := json.NewDecoder(statusResp.Body).Decode(&nodeStatus); err != nil {
+		t.Fatalf("解析状态失败: %v", err)
+	}
+	if nodeStatus.RestoredFrom == nil || nodeStatus.RestoredFrom.Index != merged.Index || nodeStatus.RestoredFrom.NodeID != "node1" {
+		t.Fatalf("restoredFrom = %+v, 期望索引 %d", nodeStatus.RestoredFrom, merged.Index)
+	}
+	if nodeStatus.LastApplied <= merged.Index {
+		t.Errorf("lastApplied = %d, 应在备份索引 %d 之后", nodeStatus.LastApplied, merged.Index)
+	}
+}
+
+// TestRestoreRequiresSingleNode 从备份恢复时配置了其他节点或以join启动返回错误
+func TestRestoreRequiresSingleNode(t *testing.T) {
+	config := &ServerConfig{
+		NodeID:      "node1",
+		Peers:       map[raft.NodeID]string{"node1": "127.0.0.1:1", "node2": "127.0.0.1:2"},
+		RestoreFrom: "unused.backup",
+	}
+	store := storage.NewMemoryStorage()
+	if _, err := restoreFromBackup(config, store, raft.Server{ID: "node1"}, logging.Nop()); err == nil {
+		t.Fatalf("配置了其他节点时应拒绝从备份恢复")
+	}
+
+	config.Peers = map[raft.NodeID]string{"node1": "127.0.0.1:1"}
+	config.Join = true
+	if _, err := restoreFromBackup(config, store, raft.Server{ID: "node1"}, logging.Nop()); err == nil {
+		t.Fatalf("以join启动时应拒绝从备份恢复")
+	}
+}
+
+// TestAdminSnapshot 手动创建快照压缩日志，没有新应用的日志时不重复创建
+func TestAdminSnapshot(t *testing.T) {
+	s := startSingleNode(t, "node1", "")
+	setKey(t, s, "a", "1", 0)
+	setKey(t, s, "b", "2", 0)
+
+	trigger := func() raft.SnapshotMetrics {
+		t.Helper()
+		resp, err := http.Post(apiURL(s, "/api/admin/snapshot"), "application/json", nil)
+		if err != nil {
+			t.Fatalf("请求创建快照失败: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("创建快照的状态码 = %d", resp.StatusCode)
+		}
+		var body struct {
+			Snapshot raft.SnapshotMetrics `json:"snapshot"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("解析响应失败: %v", err)
+		}
+		return body.Snapshot
+	}
+
+	first := trigger()
+	if applied := s.raftNode.GetMetrics().LastApplied; first.LastSnapshotIndex != applied || first.SnapshotCount != 1 {
+		t.Fatalf("快照指标 = %+v, 期望在已应用索引 %d 处创建1个快照", first, applied)
+	}
+	if again := trigger(); again.SnapshotCount != 1 || again.LastSnapshotIndex != first.LastSnapshotIndex {
+		t.Errorf("没有新日志时不应重复创建快照: %+v", again)
+	}
+
+	resp, err := http.Get(apiURL(s, "/api/admin/snapshot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET请求的状态码 = %d, 期望 405", resp.StatusCode)
+	}
+}
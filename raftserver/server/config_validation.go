@@ -0,0 +1,567 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-22 09:40:12
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-22 09:40:12
+* @Description: ConcordKV Raft consensus server - config_validation.go
+ */
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"raftserver/config"
+	"raftserver/keyhash"
+	"raftserver/raft"
+)
+
+// ConfigError 配置校验错误，指出出错的配置文件与字段
+type ConfigError struct {
+	File    string // 配置文件路径，由命令行参数构建的配置为空
+	Field   string // 字段路径，如 server.electionTimeout
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("配置字段 %s 无效: %s", e.Field, e.Message)
+	}
+	return fmt.Sprintf("配置文件 %s: 字段 %s 无效: %s", e.File, e.Field, e.Message)
+}
+
+// configErrorf 创建指定字段的配置错误
+func configErrorf(field, format string, args ...interface{}) *ConfigError {
+	return &ConfigError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// withConfigFile 为配置错误记录所在的配置文件
+func withConfigFile(err error, file string) error {
+	var configErr *ConfigError
+	if errors.As(err, &configErr) && configErr.File == "" {
+		configErr.File = file
+	}
+	return err
+}
+
+// configKind 配置字段的取值类型
+type configKind int
+
+const (
+	kindString configKind = iota
+	kindInt
+	kindFloat
+	kindBool
+	kindList
+	kindSection
+)
+
+// configField 配置字段的定义，kindSection的字段在fields中列出其子字段
+type configField struct {
+	kind   configKind
+	fields map[string]configField
+}
+
+// serverConfigSchema server配置段中LoadServerConfig读取的全部字段
+var serverConfigSchema = map[string]configField{
+	"nodeId":               {kind: kindString},
+	"listenAddr":           {kind: kindString},
+	"apiAddr":              {kind: kindString},
+	"electionTimeout":      {kind: kindInt},
+	"heartbeatInterval":    {kind: kindInt},
+	"maxLogEntries":        {kind: kindInt},
+	"maxInflightBatches":   {kind: kindInt},
+	"snapshotThreshold":    {kind: kindInt},
+	"snapshotChunkSize":    {kind: kindInt},
+	"transport":            {kind: kindString},
+	"apiToken":             {kind: kindString},
+	"watchBufferSize":      {kind: kindInt},
+	"maxWatchers":          {kind: kindInt},
+	"eventLogSize":         {kind: kindInt},
+	"slowRequestThreshold": {kind: kindInt},
+	"slowLogSize":          {kind: kindInt},
+	"slowLogFile":          {kind: kindString},
+	"sessionTimeout":       {kind: kindInt},
+	"loadSampleRate":       {kind: kindFloat},
+	"loadWindow":           {kind: kindInt},
+	"drainTimeout":         {kind: kindInt},
+	"catchUpSlack":         {kind: kindInt},
+	"catchUpMaxWait":       {kind: kindInt},
+	"allowDegradedReads":   {kind: kindBool},
+	"readTimeout":          {kind: kindInt},
+	"writeTimeout":         {kind: kindInt},
+	"maxValueSize":         {kind: kindInt},
+	"compressThreshold":    {kind: kindInt},
+	"maxKeyLength":         {kind: kindInt},
+	"join":                 {kind: kindBool},
+	"enableLeaseRead":      {kind: kindBool},
+	"enablePreVote":        {kind: kindBool},
+	"forwardWrites":        {kind: kindBool},
+	"logLevel":             {kind: kindString},
+	"logFormat":            {kind: kindString},
+	"proposalBatchWindow":  {kind: kindInt},
+	"proposalBatchSize":    {kind: kindInt},
+	"maxPendingProposals":  {kind: kindInt},
+	"storage":              {kind: kindString},
+	"allowVolatile":        {kind: kindBool},
+	"segmentSize":          {kind: kindInt},
+	"dataDir":              {kind: kindString},
+	"syncPolicy":           {kind: kindString},
+	"syncInterval":         {kind: kindInt},
+	"tls": {kind: kindSection, fields: map[string]configField{
+		"certFile":   {kind: kindString},
+		"keyFile":    {kind: kindString},
+		"caFile":     {kind: kindString},
+		"clientAuth": {kind: kindString},
+	}},
+	"topologyCoalesceWindow": {kind: kindInt},
+	"acl": {kind: kindSection, fields: map[string]configField{
+		"enabled": {kind: kindBool},
+		"tokens":  {kind: kindList},
+	}},
+	"dataCenter":         {kind: kindString},
+	"replicaType":        {kind: kindInt},
+	"peers":              {kind: kindList},
+	"peerApiAddrs":       {kind: kindList},
+	"peerDataCenters":    {kind: kindList},
+	"peerZones":          {kind: kindList},
+	"replicationSources": {kind: kindList},
+	"keyHash":            {kind: kindString},
+	"previousKeyHash":    {kind: kindString},
+	"catchUp": {kind: kindSection, fields: map[string]configField{
+		"bytesPerSec":            {kind: kindInt},
+		"peerBytesPerSec":        {kind: kindList},
+		"globalBytesPerSec":      {kind: kindInt},
+		"crossDCBytesPerSec":     {kind: kindInt},
+		"burstBytes":             {kind: kindInt},
+		"laggingEntries":         {kind: kindInt},
+		"commitLatencyThreshold": {kind: kindInt},
+		"adjustInterval":         {kind: kindInt},
+	}},
+	"multiDC": {kind: kindSection, fields: map[string]configField{
+		"enabled":             {kind: kindBool},
+		"commitPolicy":        {kind: kindString},
+		"crossDCMinBatchSize": {kind: kindInt},
+		"crossDCMaxBatchSize": {kind: kindInt},
+		"replicationQueue": {kind: kindSection, fields: map[string]configField{
+			"capacity":     {kind: kindInt},
+			"policy":       {kind: kindString},
+			"blockTimeout": {kind: kindInt},
+		}},
+	}},
+}
+
+// checkConfigFields 严格检查配置文件中的server配置段：未知字段与类型不符的字段均为错误，
+// 而不是静默地使用默认值。其他顶层配置段属于其他组件，不在此检查
+func checkConfigFields(cfg *config.Config) error {
+	root, _ := cfg.Section("")
+	if len(root) == 0 {
+		return nil
+	}
+	if _, ok := root["server"]; !ok {
+		return configErrorf("server", "缺少server配置段")
+	}
+	section, ok := cfg.Section("server")
+	if !ok {
+		if root["server"] == nil {
+			return nil
+		}
+		return configErrorf("server", "应为配置段")
+	}
+	return checkSection(section, "server", serverConfigSchema)
+}
+
+// checkSection 按schema检查一个配置段，字段按名称顺序检查以保证错误稳定
+func checkSection(section map[string]interface{}, prefix string, schema map[string]configField) error {
+	keys := make([]string, 0, len(section))
+	for key := range section {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		path := prefix + "." + key
+		field, ok := schema[key]
+		if !ok {
+			if suggestion := suggestField(key, schema); suggestion != "" {
+				return configErrorf(path, "未知字段，是否为 %s.%s", prefix, suggestion)
+			}
+			return configErrorf(path, "未知字段")
+		}
+
+		value := section[key]
+		if value == nil {
+			continue
+		}
+		if field.kind == kindSection {
+			sub, ok := toSection(value)
+			if !ok {
+				return configErrorf(path, "应为配置段")
+			}
+			if err := checkSection(sub, path, field.fields); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := checkKind(path, field.kind, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toSection 将YAML/JSON解析出的映射转为配置段
+func toSection(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case map[interface{}]interface{}:
+		section := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			section[fmt.Sprintf("%v", k)] = item
+		}
+		return section, true
+	}
+	return nil, false
+}
+
+// checkKind 检查字段取值的类型，与config包的读取规则一致：整数字段接受整数形式的字符串，字符串字段接受任意标量
+func checkKind(path string, kind configKind, value interface{}) error {
+	switch kind {
+	case kindString:
+		switch value.(type) {
+		case string, int, int64, float64, bool:
+			return nil
+		}
+		return configErrorf(path, "应为字符串，实际为 %v", value)
+	case kindInt:
+		switch v := value.(type) {
+		case int, int64:
+			return nil
+		case float64:
+			if v == math.Trunc(v) {
+				return nil
+			}
+		case string:
+			if _, err := strconv.Atoi(v); err == nil {
+				return nil
+			}
+		}
+		return configErrorf(path, "应为整数，实际为 %v", value)
+	case kindFloat:
+		switch v := value.(type) {
+		case int, int64, float64:
+			return nil
+		case string:
+			if _, err := strconv.ParseFloat(v, 64); err == nil {
+				return nil
+			}
+		}
+		return configErrorf(path, "应为数值，实际为 %v", value)
+	case kindBool:
+		if _, ok := value.(bool); ok {
+			return nil
+		}
+		return configErrorf(path, "应为true或false，实际为 %v", value)
+	case kindList:
+		if _, ok := value.([]interface{}); ok {
+			return nil
+		}
+		return configErrorf(path, "应为列表，实际为 %v", value)
+	}
+	return nil
+}
+
+// suggestField 查找忽略大小写、下划线与连字符后与key相同的字段，用于提示拼写错误（如node_id对应nodeId）
+func suggestField(key string, schema map[string]configField) string {
+	normalize := func(s string) string {
+		return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(s))
+	}
+	target := normalize(key)
+	for name := range schema {
+		if normalize(name) == target {
+			return name
+		}
+	}
+	return ""
+}
+
+// parseNodeList 解析 nodeID=value 形式的配置列表，field用于错误信息；checkAddr为true时value须为host:port
+func parseNodeList(items []string, field string, checkAddr bool) (map[raft.NodeID]string, error) {
+	result := make(map[raft.NodeID]string, len(items))
+	for i, item := range items {
+		path := fmt.Sprintf("%s[%d]", field, i)
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			if checkAddr {
+				return nil, configErrorf(path, "%q 格式错误，应为 nodeID=host:port", item)
+			}
+			return nil, configErrorf(path, "%q 格式错误，应为 nodeID=dc", item)
+		}
+		id := raft.NodeID(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+		if checkAddr {
+			if _, port, err := net.SplitHostPort(value); err != nil || port == "" {
+				return nil, configErrorf(path, "%q 的地址无效，应为 host:port", item)
+			}
+		}
+		if _, exists := result[id]; exists {
+			return nil, configErrorf(path, "节点ID %s 重复", id)
+		}
+		result[id] = value
+	}
+	return result, nil
+}
+
+// parseReplicationSources 解析 dc=nodeID,nodeID 形式的跨DC复制源列表
+func parseReplicationSources(items []string, field string) (map[raft.DataCenterID][]raft.NodeID, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	result := make(map[raft.DataCenterID][]raft.NodeID, len(items))
+	for i, item := range items {
+		path := fmt.Sprintf("%s[%d]", field, i)
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, configErrorf(path, "%q 格式错误，应为 dc=nodeID,nodeID", item)
+		}
+		dc := raft.DataCenterID(strings.TrimSpace(parts[0]))
+		if _, exists := result[dc]; exists {
+			return nil, configErrorf(path, "数据中心 %s 重复", dc)
+		}
+		var nodes []raft.NodeID
+		for _, node := range strings.Split(parts[1], ",") {
+			if node = strings.TrimSpace(node); node != "" {
+				nodes = append(nodes, raft.NodeID(node))
+			}
+		}
+		if len(nodes) == 0 {
+			return nil, configErrorf(path, "%q 没有列出允许发送复制批次的节点", item)
+		}
+		result[dc] = nodes
+	}
+	return result, nil
+}
+
+// Validate 检查字段之间的一致性。配置文件与命令行参数构建的配置在创建服务器前都经过该检查，
+// 错误为*ConfigError，指出出错的字段
+func (c *ServerConfig) Validate() error {
+	if c.NodeID == "" {
+		return configErrorf("server.nodeId", "不能为空")
+	}
+	if c.HeartbeatInterval <= 0 {
+		return configErrorf("server.heartbeatInterval", "必须大于0，实际为 %v", c.HeartbeatInterval)
+	}
+	if c.ElectionTimeout <= 2*c.HeartbeatInterval {
+		return configErrorf("server.electionTimeout", "选举超时 %v 必须大于心跳间隔 %v 的2倍，否则跟随者会在正常心跳间隙发起选举",
+			c.ElectionTimeout, c.HeartbeatInterval)
+	}
+
+	ids := make([]raft.NodeID, 0, len(c.Peers))
+	for id := range c.Peers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	if len(c.Peers) > 0 {
+		if _, ok := c.Peers[c.NodeID]; !ok {
+			return configErrorf("server.peers", "未包含本节点 %s，所有节点的peers应列出相同的完整成员", c.NodeID)
+		}
+		owners := make(map[string]raft.NodeID, len(ids))
+		for _, id := range ids {
+			addr := c.Peers[id]
+			if owner, exists := owners[addr]; exists {
+				return configErrorf("server.peers", "节点 %s 与 %s 的地址 %s 相同", owner, id, addr)
+			}
+			owners[addr] = id
+		}
+		if err := checkKnownNodes(c.Peers, c.PeerAPIAddrs, "server.peerApiAddrs"); err != nil {
+			return err
+		}
+		if err := checkKnownNodes(c.Peers, c.PeerDataCenters, "server.peerDataCenters"); err != nil {
+			return err
+		}
+		if err := checkKnownNodes(c.Peers, c.PeerZones, "server.peerZones"); err != nil {
+			return err
+		}
+		if c.CatchUp != nil {
+			if err := checkKnownNodes(c.Peers, c.CatchUp.PeerLimits, "server.catchUp.peerBytesPerSec"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, ok := c.ReplicationSources[c.DataCenter]; ok {
+		return configErrorf("server.replicationSources", "不能包含本节点所在的数据中心 %s", c.DataCenter)
+	}
+
+	if c.CatchUpSlack < 0 {
+		return configErrorf("server.catchUpSlack", "不能为负数，实际为 %d", c.CatchUpSlack)
+	}
+
+	if err := validateCatchUp(c.CatchUp); err != nil {
+		return err
+	}
+
+	if _, _, err := c.keyHashes(); err != nil {
+		return err
+	}
+
+	if dc, ok := c.PeerDataCenters[c.NodeID]; ok && c.DataCenter != "" && dc != c.DataCenter {
+		return configErrorf("server.peerDataCenters", "本节点 %s 属于 %s，与dataCenter %s 不一致", c.NodeID, dc, c.DataCenter)
+	}
+
+	// 成员所在的数据中心，未在peerDataCenters中列出的节点与本节点同属DataCenter
+	dcs := make(map[raft.DataCenterID]bool)
+	for _, id := range ids {
+		dc, ok := c.PeerDataCenters[id]
+		if !ok {
+			dc = c.DataCenter
+		}
+		dcs[dc] = true
+	}
+	names := make([]string, 0, len(dcs))
+	for dc := range dcs {
+		names = append(names, string(dc))
+	}
+	sort.Strings(names)
+
+	multiDC := c.MultiDCConfig
+	if multiDC == nil || !multiDC.Enabled {
+		if len(dcs) > 1 {
+			return configErrorf("server.multiDC.enabled", "peerDataCenters中的节点分布在多个数据中心(%s)，需要启用multiDC", strings.Join(names, ", "))
+		}
+		return nil
+	}
+	if multiDC.LocalDataCenter != nil && c.DataCenter != "" && multiDC.LocalDataCenter.ID != c.DataCenter {
+		return configErrorf("server.dataCenter", "%s 与multiDC的本地数据中心 %s 不一致", c.DataCenter, multiDC.LocalDataCenter.ID)
+	}
+	switch multiDC.CommitPolicy {
+	case raft.CommitPolicyMajorityPlusRemote, raft.CommitPolicyPerDCMajority:
+		if len(dcs) < 2 {
+			return configErrorf("server.multiDC.commitPolicy", "%s 需要节点分布在至少两个数据中心，peerDataCenters中只有 %s",
+				multiDC.CommitPolicy, strings.Join(names, ", "))
+		}
+	}
+	if multiDC.CrossDCMinBatchSize > 0 && multiDC.CrossDCMaxBatchSize > 0 && multiDC.CrossDCMinBatchSize > multiDC.CrossDCMaxBatchSize {
+		return configErrorf("server.multiDC.crossDCMinBatchSize", "%d 大于crossDCMaxBatchSize %d",
+			multiDC.CrossDCMinBatchSize, multiDC.CrossDCMaxBatchSize)
+	}
+	return nil
+}
+
+// keyHashes 解析分片使用的键哈希算法与迁移窗口内同时通告的之前的算法（未配置时为nil）
+func (c *ServerConfig) keyHashes() (keyhash.Algorithm, *keyhash.Algorithm, error) {
+	active := keyhash.Canonical
+	if c.KeyHash != "" {
+		algorithm, err := keyhash.Parse(c.KeyHash)
+		if err != nil {
+			return keyhash.Algorithm{}, nil, configErrorf("server.keyHash", "%v，支持的算法: %s", err, supportedKeyHashes())
+		}
+		active = algorithm
+	}
+	if c.PreviousKeyHash == "" {
+		return active, nil, nil
+	}
+
+	previous, err := keyhash.Parse(c.PreviousKeyHash)
+	if err != nil {
+		return keyhash.Algorithm{}, nil, configErrorf("server.previousKeyHash", "%v，支持的算法: %s", err, supportedKeyHashes())
+	}
+	if previous == active {
+		return keyhash.Algorithm{}, nil, configErrorf("server.previousKeyHash", "与keyHash相同(%s)", active)
+	}
+	return active, &previous, nil
+}
+
+// supportedKeyHashes 支持的键哈希算法列表，用于错误提示
+func supportedKeyHashes() string {
+	names := make([]string, 0, len(keyhash.Supported()))
+	for _, algorithm := range keyhash.Supported() {
+		names = append(names, algorithm.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// loadCatchUpConfig 读取server.catchUp配置段，时间字段的单位为毫秒
+func loadCatchUpConfig(cfg *config.Config) (*raft.CatchUpConfig, error) {
+	catchUp := &raft.CatchUpConfig{
+		PeerBytesPerSec:        int64(cfg.GetInt("server.catchUp.bytesPerSec", 0)),
+		GlobalBytesPerSec:      int64(cfg.GetInt("server.catchUp.globalBytesPerSec", 0)),
+		CrossDCBytesPerSec:     int64(cfg.GetInt("server.catchUp.crossDCBytesPerSec", 0)),
+		BurstBytes:             int64(cfg.GetInt("server.catchUp.burstBytes", 0)),
+		LaggingEntries:         cfg.GetInt("server.catchUp.laggingEntries", raft.DefaultCatchUpLaggingEntries),
+		CommitLatencyThreshold: time.Duration(cfg.GetInt("server.catchUp.commitLatencyThreshold", 0)) * time.Millisecond,
+		AdjustInterval:         time.Duration(cfg.GetInt("server.catchUp.adjustInterval", 0)) * time.Millisecond,
+	}
+
+	for i, item := range cfg.GetStringSlice("server.catchUp.peerBytesPerSec", []string{}) {
+		path := fmt.Sprintf("server.catchUp.peerBytesPerSec[%d]", i)
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, configErrorf(path, "%q 格式错误，应为 nodeID=字节/秒", item)
+		}
+		id := raft.NodeID(strings.TrimSpace(parts[0]))
+		limit, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil || limit < 0 {
+			return nil, configErrorf(path, "%q 的速率无效，应为非负整数", item)
+		}
+		if catchUp.PeerLimits == nil {
+			catchUp.PeerLimits = make(map[raft.NodeID]int64)
+		}
+		if _, exists := catchUp.PeerLimits[id]; exists {
+			return nil, configErrorf(path, "节点ID %s 重复", id)
+		}
+		catchUp.PeerLimits[id] = limit
+	}
+	return catchUp, nil
+}
+
+// validateCatchUp 检查追赶复制限速配置，速率为0表示不限制该项
+func validateCatchUp(c *raft.CatchUpConfig) error {
+	if c == nil {
+		return nil
+	}
+	rates := []struct {
+		field string
+		value int64
+	}{
+		{"server.catchUp.bytesPerSec", c.PeerBytesPerSec},
+		{"server.catchUp.globalBytesPerSec", c.GlobalBytesPerSec},
+		{"server.catchUp.crossDCBytesPerSec", c.CrossDCBytesPerSec},
+		{"server.catchUp.burstBytes", c.BurstBytes},
+	}
+	for _, rate := range rates {
+		if rate.value < 0 {
+			return configErrorf(rate.field, "不能为负数，实际为 %d", rate.value)
+		}
+	}
+	if c.LaggingEntries < 0 {
+		return configErrorf("server.catchUp.laggingEntries", "不能为负数，实际为 %d", c.LaggingEntries)
+	}
+	if c.CommitLatencyThreshold < 0 || c.AdjustInterval < 0 {
+		return configErrorf("server.catchUp.commitLatencyThreshold", "commitLatencyThreshold与adjustInterval不能为负数")
+	}
+	return nil
+}
+
+// checkKnownNodes 检查按节点配置的字段只引用peers中的节点
+func checkKnownNodes[V any](peers map[raft.NodeID]string, values map[raft.NodeID]V, field string) error {
+	ids := make([]raft.NodeID, 0, len(values))
+	for id := range values {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		if _, ok := peers[id]; !ok {
+			return configErrorf(field, "节点 %s 不在peers中", id)
+		}
+	}
+	return nil
+}
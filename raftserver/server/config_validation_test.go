@@ -0,0 +1,105 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-22 09:40:12
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-22 09:40:12
+* @Description: ConcordKV Raft consensus server - config_validation_test.go
+ */
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const validClusterPeers = `
+  peers:
+    - "node1=127.0.0.1:8000"
+    - "node2=127.0.0.1:8002"
+    - "node3=127.0.0.1:8004"`
+
+// TestLoadServerConfigRejectsInvalid 每个无效配置返回指出文件与字段的*ConfigError
+func TestLoadServerConfigRejectsInvalid(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		field   string
+		message string
+	}{
+		{"字段名大小写错误", "server:\n  nodeID: node1", "server.nodeID", "是否为 server.nodeId"},
+		{"下划线字段名", "server:\n  data_dir: data", "server.data_dir", "是否为 server.dataDir"},
+		{"嵌套配置段中的未知字段", "server:\n  tls:\n    certfile: a.pem", "server.tls.certfile", "是否为 server.tls.certFile"},
+		{"不支持的multiDC字段", "server:\n  multiDC:\n    localDataCenter: dc1", "server.multiDC.localDataCenter", "未知字段"},
+		{"整数字段带单位", "server:\n  electionTimeout: 5s", "server.electionTimeout", "应为整数"},
+		{"布尔字段类型错误", "server:\n  join: sometimes", "server.join", "应为true或false"},
+		{"配置段类型错误", "server:\n  tls: on", "server.tls", "应为配置段"},
+		{"缺少server配置段", "sever:\n  nodeId: node1", "server", "缺少server配置段"},
+		{"选举超时不足心跳的2倍", "server:\n  electionTimeout: 200\n  heartbeatInterval: 100", "server.electionTimeout", "2倍"},
+		{"心跳间隔为0", "server:\n  heartbeatInterval: 0", "server.heartbeatInterval", "必须大于0"},
+		{"peers未包含本节点", "server:\n  nodeId: node4" + validClusterPeers, "server.peers", "未包含本节点 node4"},
+		{"peers使用冒号格式", "server:\n  nodeId: node1\n  peers:\n    - \"node1=127.0.0.1:8000\"\n    - \"node2:127.0.0.1:8002\"", "server.peers[1]", "nodeID=host:port"},
+		{"peers地址缺少端口", "server:\n  nodeId: node1\n  peers:\n    - \"node1=127.0.0.1\"", "server.peers[0]", "地址无效"},
+		{"peers节点ID重复", "server:\n  nodeId: node1\n  peers:\n    - \"node1=127.0.0.1:8000\"\n    - \"node1=127.0.0.1:8002\"", "server.peers[1]", "重复"},
+		{"peers地址相同", "server:\n  nodeId: node1\n  peers:\n    - \"node1=127.0.0.1:8000\"\n    - \"node2=127.0.0.1:8000\"", "server.peers", "地址 127.0.0.1:8000 相同"},
+		{"peerApiAddrs引用未知节点", "server:\n  nodeId: node1" + validClusterPeers + "\n  peerApiAddrs:\n    - \"node9=127.0.0.1:9001\"", "server.peerApiAddrs", "node9 不在peers中"},
+		{"peerDataCenters格式错误", "server:\n  nodeId: node1" + validClusterPeers + "\n  peerDataCenters:\n    - \"node2\"", "server.peerDataCenters[0]", "nodeID=dc"},
+		{"本节点数据中心不一致", "server:\n  nodeId: node1\n  dataCenter: dc1" + validClusterPeers + "\n  peerDataCenters:\n    - \"node1=dc2\"", "server.peerDataCenters", "与dataCenter dc1 不一致"},
+		{"多数据中心未启用multiDC", "server:\n  nodeId: node1\n  dataCenter: dc1" + validClusterPeers + "\n  peerDataCenters:\n    - \"node3=dc2\"", "server.multiDC.enabled", "dc1, dc2"},
+		{"peerZones引用未知节点", "server:\n  nodeId: node1" + validClusterPeers + "\n  peerZones:\n    - \"node9=dc1-a\"", "server.peerZones", "node9 不在peers中"},
+		{"复制源缺少节点", "server:\n  replicationSources:\n    - \"dc2=\"", "server.replicationSources[0]", "没有列出允许发送复制批次的节点"},
+		{"复制源重复", "server:\n  replicationSources:\n    - \"dc2=node4\"\n    - \"dc2=node5\"", "server.replicationSources[1]", "数据中心 dc2 重复"},
+		{"复制源包含本数据中心", "server:\n  dataCenter: dc1\n  replicationSources:\n    - \"dc1=node4\"", "server.replicationSources", "不能包含本节点所在的数据中心 dc1"},
+		{"跨DC提交策略只有一个数据中心", "server:\n  nodeId: node1" + validClusterPeers + "\n  multiDC:\n    enabled: true\n    commitPolicy: majority-plus-remote", "server.multiDC.commitPolicy", "至少两个数据中心"},
+		{"未知的提交策略", "server:\n  nodeId: node1" + validClusterPeers + "\n  multiDC:\n    enabled: true\n    commitPolicy: quorum", "server.multiDC.commitPolicy", "quorum"},
+		{"追赶限速格式错误", "server:\n  nodeId: node1" + validClusterPeers + "\n  catchUp:\n    peerBytesPerSec:\n      - \"node2\"", "server.catchUp.peerBytesPerSec[0]", "nodeID=字节/秒"},
+		{"追赶限速引用未知节点", "server:\n  nodeId: node1" + validClusterPeers + "\n  catchUp:\n    peerBytesPerSec:\n      - \"node9=1048576\"", "server.catchUp.peerBytesPerSec", "node9 不在peers中"},
+		{"追赶全局限速为负数", "server:\n  nodeId: node1" + validClusterPeers + "\n  catchUp:\n    globalBytesPerSec: -1", "server.catchUp.globalBytesPerSec", "不能为负数"},
+		{"追赶门控slack为负数", "server:\n  catchUpSlack: -1", "server.catchUpSlack", "不能为负数"},
+		{"不支持的键哈希算法", "server:\n  keyHash: md5", "server.keyHash", "不支持的键哈希算法"},
+		{"之前的键哈希算法与当前相同", "server:\n  keyHash: xxhash64\n  previousKeyHash: xxhash64/v1", "server.previousKeyHash", "与keyHash相同"},
+		{"跨DC批次范围颠倒", "server:\n  nodeId: node1\n  dataCenter: dc1" + validClusterPeers + "\n  peerDataCenters:\n    - \"node3=dc2\"\n  multiDC:\n    enabled: true\n    crossDCMinBatchSize: 100\n    crossDCMaxBatchSize: 10", "server.multiDC.crossDCMinBatchSize", "大于crossDCMaxBatchSize"},
+	}
+
+	dir := t.TempDir()
+	for i, tc := range cases {
+		path := filepath.Join(dir, fmt.Sprintf("case%d.yaml", i))
+		if err := os.WriteFile(path, []byte(tc.content+"\n"), 0644); err != nil {
+			t.Fatalf("写入配置文件失败: %v", err)
+		}
+
+		_, err := LoadServerConfig(path)
+		var configErr *ConfigError
+		if !errors.As(err, &configErr) {
+			t.Errorf("%s: 期望*ConfigError，实际 %v", tc.name, err)
+			continue
+		}
+		if configErr.File != path || configErr.Field != tc.field || !strings.Contains(configErr.Message, tc.message) {
+			t.Errorf("%s: 期望 %s 的字段 %s 包含 %q，实际 %v", tc.name, path, tc.field, tc.message, err)
+		}
+		if !strings.Contains(err.Error(), path) || !strings.Contains(err.Error(), tc.field) {
+			t.Errorf("%s: 错误信息应包含文件与字段，实际 %q", tc.name, err.Error())
+		}
+	}
+
+	// 一致的多数据中心配置通过检查，其他组件的顶层配置段不受影响
+	path := filepath.Join(dir, "valid.yaml")
+	content := "server:\n  nodeId: node1\n  dataCenter: dc1" + validClusterPeers +
+		"\n  peerDataCenters:\n    - \"node3=dc2\"\n  peerZones:\n    - \"node3=dc2-a\"\n  multiDC:\n    enabled: true\n    commitPolicy: majority-plus-remote\n  replicationSources:\n    - \"dc3=node7, node8\"\nlogging:\n  level: info\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("写入配置文件失败: %v", err)
+	}
+	config, err := LoadServerConfig(path)
+	if err != nil {
+		t.Fatalf("有效配置加载失败: %v", err)
+	}
+	if len(config.Peers) != 3 || config.Peers["node2"] != "127.0.0.1:8002" || config.PeerDataCenters["node3"] != "dc2" || config.PeerZones["node3"] != "dc2-a" {
+		t.Errorf("配置解析结果不符: peers=%v dcs=%v zones=%v", config.Peers, config.PeerDataCenters, config.PeerZones)
+	}
+	if sources := config.ReplicationSources["dc3"]; len(sources) != 2 || sources[0] != "node7" || sources[1] != "node8" {
+		t.Errorf("复制源解析结果不符: %v", config.ReplicationSources)
+	}
+}
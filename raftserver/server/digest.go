@@ -0,0 +1,119 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-20 15:41:09
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-20 15:41:09
+* @Description: ConcordKV Raft consensus server - digest.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"raftserver/statemachine"
+)
+
+// 状态摘要请求的限制
+const (
+	// stateDigestCacheTTL 各桶摘要的缓存时间，期间相同桶数的请求直接返回缓存，避免频繁遍历全部键
+	stateDigestCacheTTL = time.Second
+	// maxDigestDrillBuckets 单次请求最多列出键摘要的桶数
+	maxDigestDrillBuckets = 64
+)
+
+// stateDigestCache 计算状态摘要需要遍历全部键，同一时间只计算一个，各桶摘要短时间内复用
+type stateDigestCache struct {
+	mu       sync.Mutex
+	buckets  int
+	digest   *statemachine.StateDigest
+	computed time.Time
+}
+
+// bucketDigest 获取buckets个桶的摘要，缓存未过期时直接返回
+func (c *stateDigestCache) bucketDigest(sm *statemachine.KVStateMachine, buckets int) *statemachine.StateDigest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.digest != nil && c.buckets == buckets && time.Since(c.computed) < stateDigestCacheTTL {
+		return c.digest
+	}
+	c.digest = sm.StateDigest(buckets)
+	c.buckets = buckets
+	c.computed = time.Now()
+	return c.digest
+}
+
+// bucketKeys 列出选中的桶中各键的摘要
+func (c *stateDigestCache) bucketKeys(sm *statemachine.KVStateMachine, buckets int, selected []int) *statemachine.StateDigest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return sm.BucketKeys(buckets, selected)
+}
+
+// handleStateDigest 返回状态机数据按键哈希分桶的摘要，供其他数据中心比较已应用的状态
+// buckets为桶数（默认256）；bucket为逗号分隔的桶号时改为返回这些桶中各键的版本与哈希
+func (s *Server) handleStateDigest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	buckets := statemachine.DefaultDigestBuckets
+	if v := query.Get("buckets"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > statemachine.MaxDigestBuckets {
+			http.Error(w, fmt.Sprintf("buckets参数应在1到%d之间", statemachine.MaxDigestBuckets), http.StatusBadRequest)
+			return
+		}
+		buckets = n
+	}
+
+	var digest *statemachine.StateDigest
+	if v := query.Get("bucket"); v != "" {
+		selected, err := parseDigestBuckets(v, buckets)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		digest = s.digests.bucketKeys(s.stateMachine, buckets, selected)
+	} else {
+		digest = s.digests.bucketDigest(s.stateMachine, buckets)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"buckets":      buckets,
+		"appliedIndex": digest.AppliedIndex,
+		"digest":       digest,
+	})
+}
+
+// parseDigestBuckets 解析逗号分隔的桶号
+func parseDigestBuckets(value string, buckets int) ([]int, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) > maxDigestDrillBuckets {
+		return nil, fmt.Errorf("单次最多列出 %d 个桶", maxDigestDrillBuckets)
+	}
+
+	selected := make([]int, 0, len(parts))
+	for _, part := range parts {
+		bucket, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || bucket < 0 || bucket >= buckets {
+			return nil, fmt.Errorf("bucket参数无效: %q", part)
+		}
+		selected = append(selected, bucket)
+	}
+	return selected, nil
+}
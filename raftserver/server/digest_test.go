@@ -0,0 +1,89 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-20 15:41:09
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-20 15:41:09
+* @Description: ConcordKV Raft consensus server - digest_test.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/statemachine"
+)
+
+// TestStateDigestAPI 返回各桶摘要，指定bucket时列出桶中各键的版本与哈希，参数无效时返回400
+func TestStateDigestAPI(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	now := time.Now()
+	applyCommandAt(t, sm, 1, now, statemachine.Command{Type: "SET", Key: "a", Value: "1"})
+	applyCommandAt(t, sm, 2, now, statemachine.Command{Type: "SET", Key: "b", Value: "2"})
+	applyCommandAt(t, sm, 3, now, statemachine.Command{Type: "SET", Key: "a", Value: "3"})
+
+	s := &Server{config: &ServerConfig{}, logger: logging.Nop(), stateMachine: sm}
+	ts := httptest.NewServer(http.HandlerFunc(s.handleStateDigest))
+	defer ts.Close()
+
+	get := func(query string) (int, *statemachine.StateDigest) {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/api/digest" + query)
+		if err != nil {
+			t.Fatalf("请求状态摘要失败: %v", err)
+		}
+		defer resp.Body.Close()
+		var body struct {
+			Digest *statemachine.StateDigest `json:"digest"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body.Digest
+	}
+
+	status, digest := get("?buckets=16")
+	if status != http.StatusOK || digest == nil {
+		t.Fatalf("状态码 = %d", status)
+	}
+	if digest.AppliedIndex != 3 || len(digest.Buckets) != 16 {
+		t.Fatalf("摘要应覆盖16个桶且已应用到3: %+v", digest)
+	}
+	total := 0
+	for _, bucket := range digest.Buckets {
+		total += bucket.Count
+	}
+	if total != 2 {
+		t.Errorf("各桶键数之和 = %d, 期望 2", total)
+	}
+
+	bucketA := statemachine.DigestBucket("a", 16)
+	status, digest = get(fmt.Sprintf("?buckets=16&bucket=%d", bucketA))
+	if status != http.StatusOK || digest == nil || len(digest.Keys) == 0 {
+		t.Fatalf("列出桶 %d 的键失败: %d %+v", bucketA, status, digest)
+	}
+	found := false
+	for _, key := range digest.Keys {
+		if key.Bucket != bucketA {
+			t.Errorf("键 %s 不属于桶 %d", key.Key, bucketA)
+		}
+		if key.Key == "a" {
+			found = true
+			if key.Version != 3 {
+				t.Errorf("键a的版本 = %d, 期望 3", key.Version)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("桶 %d 中应有键a: %+v", bucketA, digest.Keys)
+	}
+
+	for _, query := range []string{"?buckets=0", "?buckets=70000", "?buckets=16&bucket=16", "?bucket=x"} {
+		if status, _ := get(query); status != http.StatusBadRequest {
+			t.Errorf("%s 的状态码 = %d, 期望 400", query, status)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-17 16:40:12
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-17 16:40:12
+* @Description: ConcordKV Raft consensus server - drain.go
+ */
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/raft"
+)
+
+// 排空参数
+const (
+	defaultDrainTimeout = 30 * time.Second
+	drainPollInterval   = 10 * time.Millisecond
+	drainRetryAfter     = 5 * time.Second // 排空期间拒绝请求时建议客户端的重试间隔
+)
+
+// drainExemptPaths 排空期间仍然受理的接口：负载均衡器与客户端健康检查依赖状态与指标判断节点正在排空
+var drainExemptPaths = map[string]bool{
+	"/api/status":      true,
+	"/api/health":      true,
+	"/api/metrics":     true,
+	"/metrics":         true,
+	"/api/admin/drain": true,
+}
+
+// drainStreamPaths 长连接接口不计入进行中的请求，否则排空总要等到超时；停止时连接随API服务器关闭
+var drainStreamPaths = map[string]bool{
+	"/api/watch":           true,
+	"/api/topology/events": true,
+	"/api/events":          true,
+}
+
+// admit 排空期间以503拒绝新请求，并统计进行中的请求数供排空等待
+func (s *Server) admit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if drainExemptPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if drainStreamPaths[r.URL.Path] {
+			if s.draining.Load() {
+				rejectDraining(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// 先计数再检查，排空开始后新到的请求不会漏计
+		s.inflight.Add(1)
+		defer s.inflight.Add(-1)
+		if s.draining.Load() {
+			rejectDraining(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rejectDraining 返回503并告知客户端稍后重试其他节点
+func rejectDraining(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(drainRetryAfter/time.Second)))
+	writeError(w, http.StatusServiceUnavailable, codeUnavailable, "节点正在排空，请求其他节点")
+}
+
+// Draining 节点是否正在排空
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// Drained 节点经排空停止后关闭的通道，用于由/api/admin/drain触发停止时退出进程
+func (s *Server) Drained() <-chan struct{} {
+	return s.drained
+}
+
+// Shutdown 排空后停止服务器，整个过程不超过timeout（非正数时使用配置的DrainTimeout）
+// 排空超时不影响停止，只记录日志
+func (s *Server) Shutdown(timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = s.config.DrainTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := s.Drain(ctx); err != nil {
+		s.logger.Warn("排空未在超时前完成，直接停止", logging.FieldError, err)
+	}
+	err := s.Stop()
+	s.drainOnce.Do(func() { close(s.drained) })
+	return err
+}
+
+// Drain 排空节点：拒绝新的API请求，等待进行中的请求完成，领导者将领导权转移给其他投票成员，
+// 等待已提交的日志应用完毕。排空期间/api/status报告draining，ctx结束时返回其错误，节点保持排空状态
+func (s *Server) Drain(ctx context.Context) error {
+	s.draining.Store(true)
+	start := time.Now()
+	s.logger.Info("开始排空节点", "inflight", s.inflight.Load())
+
+	if err := s.waitDrainIdle(ctx); err != nil {
+		return err
+	}
+
+	if err := s.transferLeadershipAway(ctx); err != nil {
+		return err
+	}
+
+	if err := s.waitProposalsFlushed(ctx); err != nil {
+		return err
+	}
+
+	s.logger.Info("节点排空完成", "elapsed", time.Since(start))
+	return nil
+}
+
+// waitDrainIdle 等待进行中的请求全部完成
+func (s *Server) waitDrainIdle(ctx context.Context) error {
+	return pollUntil(ctx, func() bool { return s.inflight.Load() == 0 })
+}
+
+// transferLeadershipAway 本节点是领导者时依次尝试将领导权转移给其他投票成员，直到成功
+// 单节点集群没有可转移的目标，直接返回
+func (s *Server) transferLeadershipAway(ctx context.Context) error {
+	if !s.raftNode.IsLeader() {
+		return nil
+	}
+
+	for _, server := range s.raftNode.GetConfiguration().Servers {
+		if server.ID == s.config.NodeID {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := s.raftNode.TransferLeadership(server.ID)
+		if err == nil || errors.Is(err, raft.ErrNotLeader) {
+			s.logger.Info("排空时已转移领导权", "target", server.ID)
+			return nil
+		}
+		s.logger.Warn("排空时转移领导权失败，尝试下一个节点", "target", server.ID, logging.FieldError, err)
+	}
+	return nil
+}
+
+// waitProposalsFlushed 等待排队的提议提交，且已提交的日志在本节点应用完毕
+func (s *Server) waitProposalsFlushed(ctx context.Context) error {
+	return pollUntil(ctx, func() bool {
+		if s.proposals.pending() > 0 {
+			return false
+		}
+		metrics := s.raftNode.GetMetrics()
+		return metrics.LastApplied >= metrics.CommitIndex
+	})
+}
+
+// pollUntil 按drainPollInterval轮询直到done返回true或ctx结束
+func pollUntil(ctx context.Context, done func() bool) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for !done() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// handleDrain 开始排空并在完成后停止节点，立即返回202；进程在Drained关闭后退出
+func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	if !s.draining.CompareAndSwap(false, true) {
+		http.Error(w, "节点已在排空", http.StatusConflict)
+		return
+	}
+
+	s.logger.Info("审计: 排空节点", "token", principalName(r), "timeout", s.config.DrainTimeout)
+	go s.Shutdown(0)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"nodeId":   s.config.NodeID,
+		"draining": true,
+		"timeout":  s.config.DrainTimeout.String(),
+	})
+}
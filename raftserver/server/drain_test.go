@@ -0,0 +1,70 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-17 16:40:12
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-17 16:40:12
+* @Description: ConcordKV Raft consensus server - drain_test.go
+ */
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestDrainAdmission 排空开始后新请求返回503与Retry-After，状态接口仍可访问；
+// 排空等待进行中的请求完成，超时时返回ctx的错误
+func TestDrainAdmission(t *testing.T) {
+	s := &Server{config: &ServerConfig{NodeID: "n1"}}
+
+	release := make(chan struct{})
+	entered := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/set", func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/api/get", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {})
+	handler := s.admit(mux)
+
+	do := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		return recorder
+	}
+
+	slow := make(chan int)
+	go func() { slow <- do("/api/set").Code }()
+	<-entered
+
+	s.draining.Store(true)
+	if recorder := do("/api/get"); recorder.Code != http.StatusServiceUnavailable || recorder.Header().Get("Retry-After") == "" {
+		t.Errorf("排空期间新请求应返回503并带Retry-After: %d %v", recorder.Code, recorder.Header())
+	}
+	if recorder := do("/api/status"); recorder.Code != http.StatusOK {
+		t.Errorf("排空期间状态接口应可访问: %d", recorder.Code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if err := s.waitDrainIdle(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("进行中的请求未完成时应等到超时: %v", err)
+	}
+
+	close(release)
+	if code := <-slow; code != http.StatusOK {
+		t.Errorf("排空开始前进入的请求应正常完成: %d", code)
+	}
+	if err := s.waitDrainIdle(context.Background()); err != nil {
+		t.Fatalf("进行中的请求完成后排空等待应返回: %v", err)
+	}
+	if s.inflight.Load() != 0 {
+		t.Errorf("被拒绝的请求不应计入进行中的请求: %d", s.inflight.Load())
+	}
+}
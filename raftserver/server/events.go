@@ -0,0 +1,268 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-5 10:12:47
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-5 10:12:47
+* @Description: ConcordKV Raft consensus server - events.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"raftserver/raft"
+)
+
+// 事件日志参数
+const (
+	defaultEventLogSize   = 1000
+	eventSubscriberBuffer = 64
+)
+
+// 节点事件类型
+const (
+	eventStateChange       = "state_change"
+	eventLeaderChange      = "leader_change"
+	eventSnapshotCreated   = "snapshot_created"
+	eventSnapshotInstalled = "snapshot_installed"
+	eventConfigChange      = "config_change"
+)
+
+// nodeEvent 事件日志中的一条结构化事件，Data为对应的raft事件
+type nodeEvent struct {
+	Seq       uint64      `json:"seq"`
+	Type      string      `json:"type"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// eventLog 保存最近的节点事件的环形缓冲区，并推送给follow模式的订阅者
+// 序号从1开始单调递增，缓冲区满时覆盖最旧的事件
+type eventLog struct {
+	mu     sync.Mutex
+	events []nodeEvent
+	start  int // 最旧事件在events中的位置
+	count  int
+	seq    uint64
+
+	subscribers map[chan nodeEvent]struct{}
+}
+
+// newEventLog 创建事件日志，size<=0时使用默认大小
+func newEventLog(size int) *eventLog {
+	if size <= 0 {
+		size = defaultEventLogSize
+	}
+	return &eventLog{
+		events:      make([]nodeEvent, size),
+		subscribers: make(map[chan nodeEvent]struct{}),
+	}
+}
+
+// record 追加一条事件并推送给订阅者
+func (l *eventLog) record(eventType string, data interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	event := nodeEvent{Seq: l.seq, Type: eventType, Timestamp: time.Now(), Data: data}
+
+	if l.count < len(l.events) {
+		l.events[(l.start+l.count)%len(l.events)] = event
+		l.count++
+	} else {
+		l.events[l.start] = event
+		l.start = (l.start + 1) % len(l.events)
+	}
+
+	// 订阅者的缓冲区满时断开它，客户端重连后按序号补发
+	for ch := range l.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(l.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// since 返回序号大于since且类型匹配的事件，以及缓冲区中最旧事件的序号（为空时为下一个序号）
+func (l *eventLog) since(since uint64, types map[string]bool) ([]nodeEvent, uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sinceLocked(since, types), l.seq - uint64(l.count) + 1
+}
+
+func (l *eventLog) sinceLocked(since uint64, types map[string]bool) []nodeEvent {
+	events := make([]nodeEvent, 0)
+	for i := 0; i < l.count; i++ {
+		event := l.events[(l.start+i)%len(l.events)]
+		if event.Seq > since && matchEventType(types, event.Type) {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// subscribe 注册订阅者，返回since之后需要补发的事件
+// since早于缓冲区中最旧的事件时返回resync，被覆盖的事件无法补发；since为0时补发缓冲区中的所有事件
+func (l *eventLog) subscribe(since uint64, types map[string]bool) (chan nodeEvent, []nodeEvent, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ch := make(chan nodeEvent, eventSubscriberBuffer)
+	l.subscribers[ch] = struct{}{}
+
+	oldest := l.seq - uint64(l.count) + 1
+	return ch, l.sinceLocked(since, types), since > 0 && since+1 < oldest
+}
+
+// unsubscribe 注销订阅者
+func (l *eventLog) unsubscribe(ch chan nodeEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, exists := l.subscribers[ch]; exists {
+		delete(l.subscribers, ch)
+		close(ch)
+	}
+}
+
+// matchEventType types为空时匹配所有事件
+func matchEventType(types map[string]bool, eventType string) bool {
+	return len(types) == 0 || types[eventType]
+}
+
+// OnSnapshot 实现raft.SnapshotEventListener
+func (s *Server) OnSnapshot(event raft.SnapshotEvent) {
+	if event.Installed {
+		s.events.record(eventSnapshotInstalled, event)
+	} else {
+		s.events.record(eventSnapshotCreated, event)
+	}
+}
+
+// OnConfigChange 实现raft.ConfigChangeEventListener
+func (s *Server) OnConfigChange(event raft.ConfigChangeEvent) {
+	s.events.record(eventConfigChange, event)
+
+	// 学习者被提升后才能作为读写分离路由的写目标
+	if event.Type == raft.PromoteLearner {
+		s.mu.RLock()
+		router, ok := s.routes.(learnerRouter)
+		s.mu.RUnlock()
+		if ok {
+			router.SetLearner(event.Server.ID, false)
+		}
+	}
+}
+
+// handleEvents 返回本节点的事件日志
+// since为客户端已收到的最大序号，type为逗号分隔的事件类型过滤；follow=true时以SSE流持续推送新事件
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	var since uint64
+	if v := query.Get("since"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "since参数无效", http.StatusBadRequest)
+			return
+		}
+		since = n
+	}
+
+	var types map[string]bool
+	if v := query.Get("type"); v != "" {
+		types = make(map[string]bool)
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types[t] = true
+			}
+		}
+	}
+
+	if query.Get("follow") == "true" {
+		s.streamEvents(w, r, since, types)
+		return
+	}
+
+	events, oldest := s.events.since(since, types)
+	response := map[string]interface{}{
+		"success":   true,
+		"events":    events,
+		"oldestSeq": oldest,
+		"truncated": since > 0 && since+1 < oldest,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// streamEvents 以SSE流推送事件，先补发since之后的事件；since早于缓冲区时先发送resync事件
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, since uint64, types map[string]bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "连接不支持流式响应", http.StatusInternalServerError)
+		return
+	}
+
+	events, replay, resync := s.events.subscribe(since, types)
+	defer s.events.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if resync {
+		event := map[string]interface{}{
+			"type":   "resync",
+			"reason": resyncCompacted,
+		}
+		if err := writeSSE(w, "resync", 0, event); err != nil {
+			return
+		}
+	}
+	for i := range replay {
+		if err := writeSSE(w, replay[i].Type, raft.LogIndex(replay[i].Seq), replay[i]); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(watchHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				s.logger.Warn("事件日志订阅者消费过慢，断开连接", "remote_addr", r.RemoteAddr)
+				return
+			}
+			if !matchEventType(types, event.Type) {
+				continue
+			}
+			if err := writeSSE(w, event.Type, raft.LogIndex(event.Seq), event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := w.Write([]byte(": keepalive\n\n")); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
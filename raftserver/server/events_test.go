@@ -0,0 +1,136 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-5 10:12:47
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-5 10:12:47
+* @Description: ConcordKV Raft consensus server - events_test.go
+ */
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"raftserver/logging"
+	"raftserver/raft"
+)
+
+// TestEventLogRingBuffer 缓冲区满时覆盖最旧的事件，序号持续递增，按序号和类型过滤
+func TestEventLogRingBuffer(t *testing.T) {
+	l := newEventLog(3)
+	for term := raft.Term(1); term <= 5; term++ {
+		l.record(eventStateChange, raft.StateChangeEvent{Term: term})
+	}
+	l.record(eventLeaderChange, raft.LeaderChangeEvent{NewLeaderID: "node2"})
+
+	events, oldest := l.since(0, nil)
+	if len(events) != 3 || oldest != 4 {
+		t.Fatalf("应保留最近3个事件: %+v oldest=%d", events, oldest)
+	}
+	for i, event := range events {
+		if event.Seq != uint64(4+i) || event.Timestamp.IsZero() {
+			t.Errorf("第%d个事件序号或时间戳不正确: %+v", i, event)
+		}
+	}
+
+	if events, _ := l.since(4, nil); len(events) != 2 || events[0].Seq != 5 {
+		t.Errorf("since过滤不正确: %+v", events)
+	}
+	if events, _ := l.since(0, map[string]bool{eventLeaderChange: true}); len(events) != 1 || events[0].Seq != 6 {
+		t.Errorf("类型过滤不正确: %+v", events)
+	}
+
+	if _, _, resync := l.subscribe(1, nil); !resync {
+		t.Errorf("since早于缓冲区时应要求重新同步")
+	}
+	if _, replay, resync := l.subscribe(3, nil); resync || len(replay) != 3 {
+		t.Errorf("since紧邻缓冲区时不需要重新同步: %+v resync=%v", replay, resync)
+	}
+}
+
+// TestEventsHandler 以JSON返回事件日志，follow=true时以SSE推送匹配类型的新事件
+func TestEventsHandler(t *testing.T) {
+	s := &Server{config: &ServerConfig{}, logger: logging.Nop(), events: newEventLog(0)}
+	s.OnStateChange(raft.StateChangeEvent{NodeID: "node1", OldState: raft.Follower, NewState: raft.Candidate, Term: 2})
+	s.OnSnapshot(raft.SnapshotEvent{NodeID: "node1", LastIncludedIndex: 10, Size: 128})
+
+	ts := httptest.NewServer(http.HandlerFunc(s.handleEvents))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "?type=snapshot_created,config_change")
+	if err != nil {
+		t.Fatalf("获取事件失败: %v", err)
+	}
+	var body struct {
+		Success bool `json:"success"`
+		Events  []struct {
+			Seq  uint64                 `json:"seq"`
+			Type string                 `json:"type"`
+			Data map[string]interface{} `json:"data"`
+		} `json:"events"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+	if !body.Success || len(body.Events) != 1 || body.Events[0].Seq != 2 || body.Events[0].Type != eventSnapshotCreated ||
+		body.Events[0].Data["lastIncludedIndex"] != float64(10) {
+		t.Fatalf("事件不正确: %+v", body)
+	}
+
+	if resp, err := http.Get(ts.URL + "?since=abc"); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("since参数无效时应返回400: %v", err)
+	}
+
+	stream, err := http.Get(ts.URL + "?follow=true&since=1&type=snapshot_created,config_change")
+	if err != nil {
+		t.Fatalf("订阅事件失败: %v", err)
+	}
+	defer stream.Body.Close()
+	reader := bufio.NewReader(stream.Body)
+
+	if event := readSSE(t, reader); event.Type != eventSnapshotCreated || event.Data["seq"] != float64(2) {
+		t.Fatalf("补发事件不正确: %+v", event)
+	}
+
+	s.OnLeaderChange(raft.LeaderChangeEvent{NodeID: "node1", NewLeaderID: "node1", Term: 2})
+	s.OnConfigChange(raft.ConfigChangeEvent{NodeID: "node1", Type: raft.AddServer, Server: raft.Server{ID: "node4"}, Index: 12})
+	event := readSSE(t, reader)
+	if event.Type != eventConfigChange || event.Data["seq"] != float64(4) {
+		t.Fatalf("新事件不正确，不匹配类型的事件应被过滤: %+v", event)
+	}
+	if server := event.Data["data"].(map[string]interface{})["server"].(map[string]interface{}); server["id"] != "node4" {
+		t.Fatalf("成员变更事件内容不正确: %+v", event.Data)
+	}
+}
+
+// TestLeaderChangeEventAPIAddr 领导者变更事件附带新领导者的API地址，地址未知时省略
+func TestLeaderChangeEventAPIAddr(t *testing.T) {
+	s := &Server{
+		config: &ServerConfig{NodeID: "node1", APIAddr: "127.0.0.1:9001", PeerAPIAddrs: map[raft.NodeID]string{"node2": "127.0.0.1:9002"}},
+		logger: logging.Nop(),
+		events: newEventLog(0),
+	}
+	s.OnLeaderChange(raft.LeaderChangeEvent{NodeID: "node1", NewLeaderID: "node2", Term: 3})
+	s.OnLeaderChange(raft.LeaderChangeEvent{NodeID: "node1", NewLeaderID: "node3", Term: 4})
+
+	events, _ := s.events.since(0, map[string]bool{eventLeaderChange: true})
+	data, err := json.Marshal(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	json.Unmarshal(data, &decoded)
+	if len(decoded) != 2 || decoded[0].Data["newLeaderID"] != "node2" || decoded[0].Data["leaderApiAddr"] != "127.0.0.1:9002" || decoded[0].Data["term"] != float64(3) {
+		t.Fatalf("领导者变更事件 = %+v", decoded)
+	}
+	if _, ok := decoded[1].Data["leaderApiAddr"]; ok {
+		t.Errorf("API地址未知时应省略leaderApiAddr: %+v", decoded[1].Data)
+	}
+}
@@ -0,0 +1,282 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-4 10:05:21
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-4 10:05:21
+* @Description: ConcordKV Raft consensus server - failover.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"raftserver/queue"
+	"raftserver/raft"
+	"raftserver/replication"
+)
+
+// failoverController 故障转移协调器中人工确认相关的操作
+type failoverController interface {
+	GetPendingDecisions() []*replication.FailoverDecision
+	ApproveDecision(decisionID string) error
+	RejectDecision(decisionID, reason string) error
+}
+
+// queueStatsProvider 提供内部事件队列统计的组件
+type queueStatsProvider interface {
+	GetQueueStats() []queue.Stats
+}
+
+// failoverStatsProvider 提供故障转移统计与操作历史的协调器
+type failoverStatsProvider interface {
+	GetFailoverStats() map[string]interface{}
+	GetOperationHistory() []*replication.FailoverOperation
+}
+
+// failoverStats 故障转移统计，时长以毫秒表示
+type failoverStats struct {
+	TotalFailovers        int64                  `json:"totalFailovers"`
+	SuccessfulFailovers   int64                  `json:"successfulFailovers"`
+	FailedFailovers       int64                  `json:"failedFailovers"`
+	Rollbacks             int64                  `json:"rollbacks"`
+	FailedRollbacks       int64                  `json:"failedRollbacks"`
+	AverageFailoverTimeMs int64                  `json:"averageFailoverTimeMs"`
+	TotalDowntimeMs       int64                  `json:"totalDowntimeMs"`
+	TotalClientImpact     int64                  `json:"totalClientImpact"`
+	InCooldown            bool                   `json:"inCooldown"`
+	LastFailoverTime      *time.Time             `json:"lastFailoverTime,omitempty"`
+	LastOperation         *failoverOperationStat `json:"lastOperation,omitempty"`
+}
+
+// failoverOperationStat 最近一次故障转移操作的耗时与影响
+type failoverOperationStat struct {
+	ID                string            `json:"id"`
+	Status            string            `json:"status"`
+	FailedDC          raft.DataCenterID `json:"failedDC,omitempty"`
+	TargetDC          raft.DataCenterID `json:"targetDC"`
+	DetectedAt        time.Time         `json:"detectedAt"`
+	DurationMs        int64             `json:"durationMs"`
+	FailoverLatencyMs int64             `json:"failoverLatencyMs"`
+	ServiceDowntimeMs int64             `json:"serviceDowntimeMs"`
+	RecoveryTimeMs    int64             `json:"recoveryTimeMs"`
+	ClientImpactCount int64             `json:"clientImpactCount"`
+}
+
+// pendingFailover 待人工确认的故障转移决策
+type pendingFailover struct {
+	ID         string            `json:"id"`
+	FailedDC   raft.DataCenterID `json:"failedDC"`
+	TargetDC   raft.DataCenterID `json:"targetDC"`
+	Reason     string            `json:"reason"`
+	Confidence float64           `json:"confidence"`
+	RiskLevel  int               `json:"riskLevel"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	ExpiresAt  time.Time         `json:"expiresAt"`
+}
+
+// SetFailoverCoordinator 设置多数据中心故障转移协调器，之后才能通过API确认待执行的故障转移
+func (s *Server) SetFailoverCoordinator(coordinator *replication.FailoverCoordinator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failover = nil
+	if coordinator != nil {
+		s.failover = coordinator
+	}
+}
+
+// queueStats 节点的跨DC复制队列，以及故障转移协调器及其故障检测器、一致性恢复器的事件队列统计
+func (s *Server) queueStats(metrics *raft.Metrics) []queue.Stats {
+	stats := append([]queue.Stats{}, metrics.Queues...)
+
+	s.mu.RLock()
+	provider, ok := s.failover.(queueStatsProvider)
+	s.mu.RUnlock()
+	if ok {
+		stats = append(stats, provider.GetQueueStats()...)
+	}
+	return stats
+}
+
+// failoverCoordinator 获取故障转移协调器，未配置时返回503
+func (s *Server) failoverCoordinator(w http.ResponseWriter) failoverController {
+	s.mu.RLock()
+	coordinator := s.failover
+	s.mu.RUnlock()
+
+	if coordinator == nil {
+		http.Error(w, "未启用多数据中心故障转移", http.StatusServiceUnavailable)
+	}
+	return coordinator
+}
+
+// handleFailoverPending 列出等待人工确认的故障转移决策
+func (s *Server) handleFailoverPending(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	coordinator := s.failoverCoordinator(w)
+	if coordinator == nil {
+		return
+	}
+
+	decisions := coordinator.GetPendingDecisions()
+	pending := make([]pendingFailover, 0, len(decisions))
+	for _, decision := range decisions {
+		item := pendingFailover{
+			ID:         decision.ID,
+			TargetDC:   decision.TargetDC,
+			Confidence: decision.Confidence,
+			RiskLevel:  decision.RiskLevel,
+			CreatedAt:  decision.DecisionTime,
+			ExpiresAt:  decision.ExpiresAt,
+		}
+		if len(decision.FailureEvidence) > 0 {
+			item.FailedDC = decision.FailureEvidence[0].DataCenter
+			item.Reason = decision.FailureEvidence[0].Description
+		}
+		pending = append(pending, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"decisions": pending,
+	})
+}
+
+// handleFailoverApprove 批准待确认的故障转移决策
+func (s *Server) handleFailoverApprove(w http.ResponseWriter, r *http.Request) {
+	s.resolveFailover(w, r, func(coordinator failoverController, id, reason string) error {
+		return coordinator.ApproveDecision(id)
+	})
+}
+
+// handleFailoverReject 拒绝待确认的故障转移决策
+func (s *Server) handleFailoverReject(w http.ResponseWriter, r *http.Request) {
+	s.resolveFailover(w, r, func(coordinator failoverController, id, reason string) error {
+		return coordinator.RejectDecision(id, reason)
+	})
+}
+
+// resolveFailover 解析 {"id": ..., "reason": ...} 请求并处理待确认的决策
+func (s *Server) resolveFailover(w http.ResponseWriter, r *http.Request,
+	resolve func(coordinator failoverController, id, reason string) error) {
+	if r.Method != "POST" {
+		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		ID     string `json:"id"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "解析请求失败", http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" {
+		http.Error(w, "id不能为空", http.StatusBadRequest)
+		return
+	}
+
+	coordinator := s.failoverCoordinator(w)
+	if coordinator == nil {
+		return
+	}
+
+	if err := resolve(coordinator, req.ID, req.Reason); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, replication.ErrDecisionNotFound) {
+			status = http.StatusNotFound
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"id":      req.ID,
+	})
+}
+
+// handleFailoverStats 返回故障转移次数、平均耗时、累计停机时间和客户端影响，以及最近一次操作的指标
+func (s *Server) handleFailoverStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	s.mu.RLock()
+	provider, ok := s.failover.(failoverStatsProvider)
+	s.mu.RUnlock()
+	if !ok {
+		http.Error(w, "未启用多数据中心故障转移", http.StatusServiceUnavailable)
+		return
+	}
+
+	raw := provider.GetFailoverStats()
+	stats := failoverStats{}
+	stats.TotalFailovers, _ = raw["totalFailovers"].(int64)
+	stats.SuccessfulFailovers, _ = raw["successfulFailovers"].(int64)
+	stats.FailedFailovers, _ = raw["failedFailovers"].(int64)
+	stats.Rollbacks, _ = raw["rollbacks"].(int64)
+	stats.FailedRollbacks, _ = raw["failedRollbacks"].(int64)
+	stats.TotalClientImpact, _ = raw["totalClientImpact"].(int64)
+	stats.InCooldown, _ = raw["isInCooldown"].(bool)
+	if average, ok := raw["averageFailoverTime"].(time.Duration); ok {
+		stats.AverageFailoverTimeMs = average.Milliseconds()
+	}
+	if downtime, ok := raw["totalDowntime"].(time.Duration); ok {
+		stats.TotalDowntimeMs = downtime.Milliseconds()
+	}
+	if last, ok := raw["lastFailoverTime"].(time.Time); ok && !last.IsZero() {
+		stats.LastFailoverTime = &last
+	}
+
+	if history := provider.GetOperationHistory(); len(history) > 0 {
+		op := history[len(history)-1]
+		stats.LastOperation = &failoverOperationStat{
+			ID:                op.ID,
+			Status:            op.Status,
+			FailedDC:          op.FailedDC,
+			TargetDC:          op.TargetDC,
+			DetectedAt:        op.DetectedAt,
+			DurationMs:        op.Duration.Milliseconds(),
+			FailoverLatencyMs: op.FailoverLatency.Milliseconds(),
+			ServiceDowntimeMs: op.ServiceDowntime.Milliseconds(),
+			RecoveryTimeMs:    op.RecoveryTime.Milliseconds(),
+			ClientImpactCount: op.ClientImpactCount,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"stats":   stats,
+	})
+}
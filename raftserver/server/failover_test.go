@@ -0,0 +1,219 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-4 10:05:21
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-4 10:05:21
+* @Description: ConcordKV Raft consensus server - failover_test.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/replication"
+)
+
+// fakeFailover 记录批准与拒绝的决策
+type fakeFailover struct {
+	pending  []*replication.FailoverDecision
+	approved []string
+	rejected map[string]string
+	stats    map[string]interface{}
+	history  []*replication.FailoverOperation
+}
+
+func (f *fakeFailover) GetFailoverStats() map[string]interface{} {
+	return f.stats
+}
+
+func (f *fakeFailover) GetOperationHistory() []*replication.FailoverOperation {
+	return f.history
+}
+
+func (f *fakeFailover) GetPendingDecisions() []*replication.FailoverDecision {
+	return f.pending
+}
+
+func (f *fakeFailover) ApproveDecision(decisionID string) error {
+	if !f.take(decisionID) {
+		return replication.ErrDecisionNotFound
+	}
+	f.approved = append(f.approved, decisionID)
+	return nil
+}
+
+func (f *fakeFailover) RejectDecision(decisionID, reason string) error {
+	if !f.take(decisionID) {
+		return replication.ErrDecisionNotFound
+	}
+	f.rejected[decisionID] = reason
+	return nil
+}
+
+func (f *fakeFailover) take(decisionID string) bool {
+	for i, decision := range f.pending {
+		if decision.ID == decisionID {
+			f.pending = append(f.pending[:i], f.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// TestFailoverConfirmationAPI 列出、批准和拒绝待确认的故障转移决策
+func TestFailoverConfirmationAPI(t *testing.T) {
+	now := time.Now()
+	failover := &fakeFailover{
+		pending: []*replication.FailoverDecision{
+			{
+				ID:              "decision-1",
+				TargetDC:        "dc3",
+				Confidence:      0.95,
+				DecisionTime:    now,
+				ExpiresAt:       now.Add(30 * time.Second),
+				FailureEvidence: []*replication.DCFailureEvent{{DataCenter: "dc2", Description: "DC故障"}},
+			},
+			{ID: "decision-2", TargetDC: "dc1"},
+		},
+		rejected: make(map[string]string),
+	}
+	s := &Server{config: &ServerConfig{}, logger: logging.Nop(), failover: failover}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/failover/pending", s.handleFailoverPending)
+	mux.HandleFunc("/api/failover/approve", s.handleFailoverApprove)
+	mux.HandleFunc("/api/failover/reject", s.handleFailoverReject)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/failover/pending")
+	if err != nil {
+		t.Fatalf("查询待确认决策失败: %v", err)
+	}
+	var listed struct {
+		Decisions []pendingFailover `json:"decisions"`
+	}
+	json.NewDecoder(resp.Body).Decode(&listed)
+	resp.Body.Close()
+	if len(listed.Decisions) != 2 {
+		t.Fatalf("待确认决策数 = %d, 期望 2", len(listed.Decisions))
+	}
+	first := listed.Decisions[0]
+	if first.ID != "decision-1" || first.FailedDC != "dc2" || first.TargetDC != "dc3" || first.Reason != "DC故障" || !first.ExpiresAt.Equal(now.Add(30*time.Second)) {
+		t.Fatalf("待确认决策不正确: %+v", first)
+	}
+
+	post := func(path, body string) int {
+		t.Helper()
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("请求 %s 失败: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post("/api/failover/approve", `{"id":"decision-1"}`); status != http.StatusOK {
+		t.Fatalf("批准决策状态码 = %d", status)
+	}
+	if status := post("/api/failover/approve", `{"id":"decision-1"}`); status != http.StatusNotFound {
+		t.Fatalf("重复批准状态码 = %d, 期望 404", status)
+	}
+	if status := post("/api/failover/reject", `{"id":"decision-2","reason":"计划内维护"}`); status != http.StatusOK {
+		t.Fatalf("拒绝决策状态码 = %d", status)
+	}
+	if status := post("/api/failover/reject", `{}`); status != http.StatusBadRequest {
+		t.Fatalf("缺少id的状态码 = %d, 期望 400", status)
+	}
+
+	if len(failover.approved) != 1 || failover.approved[0] != "decision-1" {
+		t.Fatalf("批准的决策 = %v", failover.approved)
+	}
+	if failover.rejected["decision-2"] != "计划内维护" {
+		t.Fatalf("拒绝原因 = %q", failover.rejected["decision-2"])
+	}
+
+	// 未配置协调器时返回503
+	s.SetFailoverCoordinator(nil)
+	resp, err = http.Get(ts.URL + "/api/failover/pending")
+	if err != nil {
+		t.Fatalf("查询待确认决策失败: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("未配置协调器时状态码 = %d, 期望 503", resp.StatusCode)
+	}
+}
+
+// TestFailoverStatsAPI 返回以毫秒表示的平均耗时、累计停机时间和最近一次操作的影响
+func TestFailoverStatsAPI(t *testing.T) {
+	detectedAt := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	failover := &fakeFailover{
+		stats: map[string]interface{}{
+			"totalFailovers":      int64(2),
+			"successfulFailovers": int64(2),
+			"averageFailoverTime": 1500 * time.Millisecond,
+			"totalDowntime":       8 * time.Second,
+			"totalClientImpact":   int64(3),
+			"isInCooldown":        true,
+			"lastFailoverTime":    detectedAt.Add(time.Minute),
+		},
+		history: []*replication.FailoverOperation{
+			{ID: "failover-1"},
+			{
+				ID:                "failover-2",
+				Status:            "Completed",
+				FailedDC:          "dc2",
+				TargetDC:          "dc3",
+				DetectedAt:        detectedAt,
+				Duration:          time.Second,
+				FailoverLatency:   time.Second,
+				ServiceDowntime:   5 * time.Second,
+				RecoveryTime:      5 * time.Second,
+				ClientImpactCount: 3,
+			},
+		},
+	}
+	s := &Server{config: &ServerConfig{}, logger: logging.Nop(), failover: failover}
+
+	ts := httptest.NewServer(http.HandlerFunc(s.handleFailoverStats))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("查询故障转移统计失败: %v", err)
+	}
+	var body struct {
+		Stats failoverStats `json:"stats"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+
+	stats := body.Stats
+	if stats.TotalFailovers != 2 || stats.AverageFailoverTimeMs != 1500 || stats.TotalDowntimeMs != 8000 ||
+		stats.TotalClientImpact != 3 || !stats.InCooldown || stats.LastFailoverTime == nil {
+		t.Fatalf("故障转移统计不正确: %+v", stats)
+	}
+	last := stats.LastOperation
+	if last == nil || last.ID != "failover-2" || last.ServiceDowntimeMs != 5000 || last.FailoverLatencyMs != 1000 ||
+		last.ClientImpactCount != 3 || !last.DetectedAt.Equal(detectedAt) {
+		t.Fatalf("最近一次操作不正确: %+v", last)
+	}
+
+	// 未配置协调器时返回503
+	s.SetFailoverCoordinator(nil)
+	resp, err = http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("查询故障转移统计失败: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("未配置协调器时状态码 = %d, 期望 503", resp.StatusCode)
+	}
+}
@@ -0,0 +1,117 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - leader_forward.go
+ */
+package server
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"raftserver/logging"
+	"raftserver/raft"
+)
+
+// forwardedHeader 标记请求已被转发过一次，防止领导者变更期间出现转发环路
+const forwardedHeader = "X-Concord-Forwarded"
+
+// OnStateChange 实现raft.EventListener
+func (s *Server) OnStateChange(event raft.StateChangeEvent) {
+	s.events.record(eventStateChange, event)
+
+	if event.NewState != raft.Leader && event.OldState == raft.Leader {
+		s.leaderHint.Store(raft.NodeID(""))
+	}
+}
+
+// leaderChangeEvent 事件日志中的领导者变更事件，附带新领导者的API地址，订阅事件流的客户端据此更新领导者缓存
+type leaderChangeEvent struct {
+	raft.LeaderChangeEvent
+	LeaderAPIAddr string `json:"leaderApiAddr,omitempty"`
+}
+
+// OnLeaderChange 实现raft.EventListener，记录最新的领导者用于请求重定向
+func (s *Server) OnLeaderChange(event raft.LeaderChangeEvent) {
+	addr := s.leaderAPIAddr(event.NewLeaderID)
+	s.events.record(eventLeaderChange, leaderChangeEvent{LeaderChangeEvent: event, LeaderAPIAddr: addr})
+	s.leaderHint.Store(event.NewLeaderID)
+
+	if addr != "" {
+		s.logger.Info("领导者变更", "leader", event.NewLeaderID, "api_addr", addr)
+	} else {
+		s.logger.Info("领导者变更，API地址未知", "leader", event.NewLeaderID)
+	}
+}
+
+// currentLeader 获取当前已知的领导者
+func (s *Server) currentLeader() raft.NodeID {
+	if leader := s.raftNode.GetLeader(); leader != "" {
+		return leader
+	}
+	if v := s.leaderHint.Load(); v != nil {
+		return v.(raft.NodeID)
+	}
+	return ""
+}
+
+// leaderAPIAddr 查找节点的API地址
+func (s *Server) leaderAPIAddr(nodeID raft.NodeID) string {
+	if nodeID == "" {
+		return ""
+	}
+	if nodeID == s.config.NodeID {
+		return s.config.APIAddr
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.PeerAPIAddrs[nodeID]
+}
+
+// redirectToLeader 当本节点不是领导者时重定向或转发请求，返回true表示请求已被处理
+// 默认返回307重定向；请求带有forward=true时透明代理到领导者并转发响应
+func (s *Server) redirectToLeader(w http.ResponseWriter, r *http.Request) bool {
+	if s.raftNode.IsLeader() {
+		return false
+	}
+
+	leader := s.currentLeader()
+	addr := s.leaderAPIAddr(leader)
+
+	if addr == "" {
+		writeAPIError(w, http.StatusServiceUnavailable, s.notLeaderError(leader, ""))
+		return true
+	}
+
+	target := &url.URL{Scheme: "http", Host: addr}
+	if s.tls != nil {
+		target.Scheme = "https"
+	}
+
+	if r.URL.Query().Get("forward") == "true" && r.Header.Get(forwardedHeader) == "" {
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		if s.forwardTransport != nil {
+			proxy.Transport = s.forwardTransport
+		}
+		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			s.logger.Warn("转发请求到领导者失败", "leader", leader, logging.FieldError, err)
+			writeError(w, http.StatusBadGateway, codeUnavailable, "转发请求到领导者失败")
+		}
+
+		r.Header.Set(forwardedHeader, string(s.config.NodeID))
+		proxy.ServeHTTP(w, r)
+		return true
+	}
+
+	location := *r.URL
+	location.Scheme = target.Scheme
+	location.Host = target.Host
+
+	w.Header().Set("Location", location.String())
+	writeAPIError(w, http.StatusTemporaryRedirect, s.notLeaderError(leader, addr))
+	return true
+}
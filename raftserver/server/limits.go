@@ -0,0 +1,92 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-18 14:06:31
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-18 14:06:31
+* @Description: ConcordKV Raft consensus server - limits.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// 键值大小的默认上限
+const (
+	defaultMaxValueSize = 1 << 20 // 值的JSON编码长度上限
+	defaultMaxKeyLength = 1024
+
+	// defaultCompressThreshold 要求压缩的值的JSON编码超过该长度时才压缩
+	defaultCompressThreshold = 1 << 10
+
+	// requestOverhead 请求体中键值之外的字段（ttlSeconds、expectedVersion等）预留的长度
+	requestOverhead = 4 << 10
+)
+
+var (
+	// errValueTooLarge 值超过MaxValueSize，返回413
+	errValueTooLarge = errors.New("值超过大小上限")
+
+	// errKeyTooLong 键超过MaxKeyLength，返回400
+	errKeyTooLong = errors.New("键超过长度上限")
+)
+
+// limitBody 按entries个键值限制请求体的长度，避免在校验之前把超大的请求读入内存
+// 每个键值允许一个键与两个值（CAS的期望值与新值）
+func (s *Server) limitBody(w http.ResponseWriter, r *http.Request, entries int) {
+	if s.config.MaxValueSize <= 0 {
+		return
+	}
+	perEntry := 2*int64(s.config.MaxValueSize) + requestOverhead
+	if s.config.MaxKeyLength > 0 {
+		perEntry += int64(s.config.MaxKeyLength)
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, int64(entries)*perEntry)
+}
+
+// decodeLimited 解析请求体，请求体超过limitBody的限制时返回413
+func decodeLimited(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, codeValueTooLarge, fmt.Sprintf("%v: 请求体超过 %d 字节", errValueTooLarge, tooLarge.Limit))
+		return false
+	}
+	writeError(w, http.StatusBadRequest, codeInvalidArgument, "解析请求失败")
+	return false
+}
+
+// checkEntry 校验键长与值的JSON编码长度，上限为非正数时不检查
+func (s *Server) checkEntry(key string, values ...interface{}) error {
+	if max := s.config.MaxKeyLength; max > 0 && len(key) > max {
+		return fmt.Errorf("%w: 键长 %d 字节，上限 %d", errKeyTooLong, len(key), max)
+	}
+	if max := s.config.MaxValueSize; max > 0 {
+		for _, value := range values {
+			data, err := json.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("%w: 序列化值失败: %v", errInvalidCommand, err)
+			}
+			if len(data) > max {
+				return fmt.Errorf("%w: 值 %d 字节，上限 %d", errValueTooLarge, len(data), max)
+			}
+		}
+	}
+	return nil
+}
+
+// writeEntryError 按校验错误返回状态码：值过大为413，其余为400
+func writeEntryError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errValueTooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, codeValueTooLarge, err.Error())
+		return
+	}
+	writeError(w, http.StatusBadRequest, codeInvalidArgument, err.Error())
+}
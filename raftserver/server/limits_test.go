@@ -0,0 +1,90 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-18 14:06:31
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-18 14:06:31
+* @Description: ConcordKV Raft consensus server - limits_test.go
+ */
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestEntryLimits 值的JSON编码长度恰好等于上限时接受，超过一个字节时拒绝；键长同理
+func TestEntryLimits(t *testing.T) {
+	s := &Server{config: &ServerConfig{MaxValueSize: 64, MaxKeyLength: 8}}
+
+	atLimit := strings.Repeat("v", 62) // 加上引号恰好64字节
+	if err := s.checkEntry("k", atLimit); err != nil {
+		t.Errorf("恰好达到上限的值应被接受: %v", err)
+	}
+	if err := s.checkEntry("k", atLimit+"v"); !errors.Is(err, errValueTooLarge) {
+		t.Errorf("超过上限的值应被拒绝: %v", err)
+	}
+	if err := s.checkEntry("k", strings.Repeat("<", 11)); !errors.Is(err, errValueTooLarge) {
+		t.Errorf("按转义后的编码长度计算: %v", err)
+	}
+	if err := s.checkEntry("k", nil, atLimit); err != nil {
+		t.Errorf("nil值应被接受: %v", err)
+	}
+
+	if err := s.checkEntry(strings.Repeat("k", 8), "v"); err != nil {
+		t.Errorf("恰好达到上限的键应被接受: %v", err)
+	}
+	if err := s.checkEntry(strings.Repeat("k", 9), "v"); !errors.Is(err, errKeyTooLong) {
+		t.Errorf("超过上限的键应被拒绝: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	writeEntryError(recorder, s.checkEntry("k", atLimit+"v"))
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("值过大应返回413，实际 %d", recorder.Code)
+	}
+
+	unlimited := &Server{config: &ServerConfig{MaxValueSize: -1, MaxKeyLength: -1}}
+	if err := unlimited.checkEntry(strings.Repeat("k", 4096), strings.Repeat("v", 1<<20)); err != nil {
+		t.Errorf("上限为负数时不应检查: %v", err)
+	}
+}
+
+// TestRequestBodyLimit 请求体超过按键值上限计算的长度时，在读完之前以413拒绝
+func TestRequestBodyLimit(t *testing.T) {
+	s := &Server{config: &ServerConfig{MaxValueSize: 64, MaxKeyLength: 8}}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Key   string      `json:"key"`
+			Value interface{} `json:"value"`
+		}
+		s.limitBody(w, r, 1)
+		if !decodeLimited(w, r, &req) {
+			return
+		}
+		if err := s.checkEntry(req.Key, req.Value); err != nil {
+			writeEntryError(w, err)
+		}
+	})
+
+	do := func(body string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/set", strings.NewReader(body)))
+		return recorder.Code
+	}
+
+	if code := do(`{"key":"k","value":"` + strings.Repeat("v", 62) + `"}`); code != http.StatusOK {
+		t.Errorf("上限内的请求应被接受: %d", code)
+	}
+	if code := do(`{"key":"k","value":"` + strings.Repeat("v", 63) + `"}`); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("值超过上限应返回413: %d", code)
+	}
+	if code := do(`{"key":"k","value":"` + strings.Repeat("v", 1<<20) + `"}`); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("超大的请求体应返回413: %d", code)
+	}
+	if code := do(`{"key":`); code != http.StatusBadRequest {
+		t.Errorf("格式错误的请求应返回400: %d", code)
+	}
+}
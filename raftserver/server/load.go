@@ -0,0 +1,361 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-17 14:21:06
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-17 14:21:06
+* @Description: ConcordKV Raft consensus server - load.go
+ */
+package server
+
+import (
+	"container/heap"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 负载采样参数
+const (
+	defaultLoadSampleRate = 0.01
+	defaultLoadWindow     = time.Minute
+
+	loadWindowBuckets = 6    // 滑动窗口划分的时间桶数
+	loadSketchDepth   = 4    // count-min草图的行数，估计失败的概率约为e^-4
+	loadSketchWidth   = 2048 // 每行的计数器数，高估不超过窗口内采样数的e/2048
+	loadCandidates    = 64   // 每个时间桶保留的候选热点键数
+
+	defaultLoadTopKeys = 20
+	maxLoadTopKeys     = loadCandidates
+)
+
+// countMinSketch 估计键出现次数的count-min草图，估计值不小于真实值
+type countMinSketch struct {
+	counts [loadSketchDepth][loadSketchWidth]uint32
+}
+
+// sketchHash 键的64位FNV-1a哈希，拆成两个32位哈希用于生成各行的位置
+func sketchHash(key string) (uint32, uint32) {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	hash := uint64(offset64)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= prime64
+	}
+	return uint32(hash), uint32(hash>>32) | 1
+}
+
+// add 计数加一，返回加一后的估计值
+func (s *countMinSketch) add(key string) uint32 {
+	h1, h2 := sketchHash(key)
+	estimate := ^uint32(0)
+	for row := 0; row < loadSketchDepth; row++ {
+		col := (h1 + uint32(row)*h2) % loadSketchWidth
+		s.counts[row][col]++
+		if s.counts[row][col] < estimate {
+			estimate = s.counts[row][col]
+		}
+	}
+	return estimate
+}
+
+// estimateAcross 估计键在所有草图中出现的总次数：各行分别求和后取最小值
+func estimateAcross(sketches []*countMinSketch, key string) uint64 {
+	h1, h2 := sketchHash(key)
+	estimate := ^uint64(0)
+	for row := 0; row < loadSketchDepth; row++ {
+		col := (h1 + uint32(row)*h2) % loadSketchWidth
+		var sum uint64
+		for _, s := range sketches {
+			sum += uint64(s.counts[row][col])
+		}
+		if sum < estimate {
+			estimate = sum
+		}
+	}
+	return estimate
+}
+
+// hotKey 候选热点键及其在时间桶内的估计次数
+type hotKey struct {
+	key   string
+	count uint32
+	index int
+}
+
+// topKeys 按估计次数维护的最小堆，堆顶是候选中最冷的键
+type topKeys struct {
+	items []*hotKey
+	byKey map[string]*hotKey
+}
+
+func (t *topKeys) Len() int           { return len(t.items) }
+func (t *topKeys) Less(i, j int) bool { return t.items[i].count < t.items[j].count }
+func (t *topKeys) Swap(i, j int) {
+	t.items[i], t.items[j] = t.items[j], t.items[i]
+	t.items[i].index = i
+	t.items[j].index = j
+}
+func (t *topKeys) Push(x interface{}) {
+	item := x.(*hotKey)
+	item.index = len(t.items)
+	t.items = append(t.items, item)
+}
+func (t *topKeys) Pop() interface{} {
+	item := t.items[len(t.items)-1]
+	t.items = t.items[:len(t.items)-1]
+	return item
+}
+
+// offer 以新的估计值更新候选：已在候选中则调整位置，候选已满时替换比它冷的堆顶
+func (t *topKeys) offer(key string, count uint32) {
+	if item, exists := t.byKey[key]; exists {
+		item.count = count
+		heap.Fix(t, item.index)
+		return
+	}
+	if len(t.items) < loadCandidates {
+		item := &hotKey{key: key, count: count}
+		t.byKey[key] = item
+		heap.Push(t, item)
+		return
+	}
+	if coldest := t.items[0]; coldest.count < count {
+		delete(t.byKey, coldest.key)
+		coldest.key, coldest.count = key, count
+		t.byKey[key] = coldest
+		heap.Fix(t, 0)
+	}
+}
+
+// shardLoad 分片在时间桶内的采样请求数
+type shardLoad struct {
+	reads  uint64
+	writes uint64
+}
+
+// loadBucket 滑动窗口中的一个时间桶
+type loadBucket struct {
+	epoch  int64 // 时间桶序号，即起始时间除以桶跨度
+	shards map[string]*shardLoad
+	sketch *countMinSketch
+	top    *topKeys
+}
+
+// reset 清空时间桶并开始新的序号
+func (b *loadBucket) reset(epoch int64) {
+	b.epoch = epoch
+	b.shards = make(map[string]*shardLoad)
+	b.sketch = &countMinSketch{}
+	b.top = &topKeys{byKey: make(map[string]*hotKey)}
+}
+
+// loadTracker 按采样率记录请求，统计滑动窗口内各分片的请求速率与热点键
+// 未被采样的请求只付出一次随机数的开销；统计值按采样率放大为估计值
+type loadTracker struct {
+	mu         sync.Mutex
+	sampleRate float64
+	window     time.Duration
+	span       time.Duration
+	buckets    [loadWindowBuckets]loadBucket
+	started    time.Time
+
+	shardOf func(key string) string
+	now     func() time.Time
+	sample  func() float64
+}
+
+// newLoadTracker 创建负载统计，shardOf返回键所在的分片
+func newLoadTracker(sampleRate float64, window time.Duration, shardOf func(key string) string) *loadTracker {
+	if window <= 0 {
+		window = defaultLoadWindow
+	}
+	if sampleRate > 1 {
+		sampleRate = 1
+	}
+	t := &loadTracker{
+		sampleRate: sampleRate,
+		window:     window,
+		span:       window / loadWindowBuckets,
+		shardOf:    shardOf,
+		now:        time.Now,
+		sample:     rand.Float64,
+	}
+	t.started = t.now()
+	for i := range t.buckets {
+		t.buckets[i].reset(-1)
+	}
+	return t
+}
+
+// record 按采样率记录一次对键的读或写
+func (t *loadTracker) record(key string, write bool) {
+	if t == nil || t.sampleRate <= 0 || t.sample() >= t.sampleRate {
+		return
+	}
+	shardID := t.shardOf(key)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	epoch := t.now().UnixNano() / int64(t.span)
+	bucket := &t.buckets[epoch%loadWindowBuckets]
+	if bucket.epoch != epoch {
+		bucket.reset(epoch)
+	}
+
+	load, exists := bucket.shards[shardID]
+	if !exists {
+		load = &shardLoad{}
+		bucket.shards[shardID] = load
+	}
+	if write {
+		load.writes++
+	} else {
+		load.reads++
+	}
+	bucket.top.offer(key, bucket.sketch.add(key))
+}
+
+// shardLoadReport 分片在窗口内的请求速率
+type shardLoadReport struct {
+	ShardID    string  `json:"shardId"`
+	QPS        float64 `json:"qps"`
+	ReadQPS    float64 `json:"readQps"`
+	WriteQPS   float64 `json:"writeQps"`
+	WriteRatio float64 `json:"writeRatio"`
+}
+
+// hotKeyReport 热点键在窗口内的估计请求数
+type hotKeyReport struct {
+	Key     string  `json:"key"`
+	ShardID string  `json:"shardId"`
+	Count   uint64  `json:"count"`
+	QPS     float64 `json:"qps"`
+}
+
+// loadReport 负载统计结果
+type loadReport struct {
+	WindowSeconds float64           `json:"windowSeconds"` // 实际统计的时长，启动后不足一个窗口时较短
+	SampleRate    float64           `json:"sampleRate"`
+	Shards        []shardLoadReport `json:"shards"`
+	HotKeys       []hotKeyReport    `json:"hotKeys"`
+}
+
+// report 汇总窗口内的时间桶，返回各分片的请求速率与估计次数最高的topN个键
+func (t *loadTracker) report(topN int) loadReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	current := now.UnixNano() / int64(t.span)
+	covered := t.window
+	if elapsed := now.Sub(t.started); elapsed < covered {
+		covered = elapsed
+	}
+	if covered < t.span {
+		covered = t.span
+	}
+	seconds := covered.Seconds()
+
+	result := loadReport{WindowSeconds: seconds, SampleRate: t.sampleRate, Shards: []shardLoadReport{}, HotKeys: []hotKeyReport{}}
+	if t.sampleRate <= 0 {
+		return result
+	}
+	scale := 1 / t.sampleRate
+
+	loads := make(map[string]*shardLoad)
+	var sketches []*countMinSketch
+	candidates := make(map[string]struct{})
+	for i := range t.buckets {
+		bucket := &t.buckets[i]
+		if bucket.epoch <= current-loadWindowBuckets || bucket.epoch > current {
+			continue
+		}
+		for shardID, load := range bucket.shards {
+			total, exists := loads[shardID]
+			if !exists {
+				total = &shardLoad{}
+				loads[shardID] = total
+			}
+			total.reads += load.reads
+			total.writes += load.writes
+		}
+		sketches = append(sketches, bucket.sketch)
+		for key := range bucket.top.byKey {
+			candidates[key] = struct{}{}
+		}
+	}
+
+	for shardID, load := range loads {
+		shard := shardLoadReport{
+			ShardID:  shardID,
+			ReadQPS:  float64(load.reads) * scale / seconds,
+			WriteQPS: float64(load.writes) * scale / seconds,
+		}
+		shard.QPS = shard.ReadQPS + shard.WriteQPS
+		if total := load.reads + load.writes; total > 0 {
+			shard.WriteRatio = float64(load.writes) / float64(total)
+		}
+		result.Shards = append(result.Shards, shard)
+	}
+	sort.Slice(result.Shards, func(i, j int) bool { return result.Shards[i].ShardID < result.Shards[j].ShardID })
+
+	for key := range candidates {
+		count := uint64(float64(estimateAcross(sketches, key)) * scale)
+		result.HotKeys = append(result.HotKeys, hotKeyReport{Key: key, Count: count, QPS: float64(count) / seconds})
+	}
+	sort.Slice(result.HotKeys, func(i, j int) bool {
+		a, b := result.HotKeys[i], result.HotKeys[j]
+		return a.Count > b.Count || (a.Count == b.Count && a.Key < b.Key)
+	})
+	if len(result.HotKeys) > topN {
+		result.HotKeys = result.HotKeys[:topN]
+	}
+	for i := range result.HotKeys {
+		result.HotKeys[i].ShardID = t.shardOf(result.HotKeys[i].Key)
+	}
+	return result
+}
+
+// handleShardLoad 返回本节点处理的请求在滑动窗口内按分片的速率、写比例与热点键
+// top参数指定返回的热点键数（默认20）；热点键暴露键名，只返回给管理员
+func (s *Server) handleShardLoad(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	topN := defaultLoadTopKeys
+	if v := r.URL.Query().Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxLoadTopKeys {
+			http.Error(w, "top参数无效", http.StatusBadRequest)
+			return
+		}
+		topN = n
+	}
+	if principal := principalFrom(r); principal != nil && !principal.Admin {
+		topN = 0
+	}
+
+	report := s.load.report(topN)
+
+	response := map[string]interface{}{
+		"success":       true,
+		"nodeId":        s.config.NodeID,
+		"windowSeconds": report.WindowSeconds,
+		"sampleRate":    report.SampleRate,
+		"shards":        report.Shards,
+		"hotKeys":       report.HotKeys,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,143 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-17 14:21:06
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-17 14:21:06
+* @Description: ConcordKV Raft consensus server - load_test.go
+ */
+package server
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestLoadTracker 使用可控时钟与固定随机源的负载统计，键按前缀分到shard-a与shard-b
+func newTestLoadTracker(sampleRate float64, now *time.Time) *loadTracker {
+	t := newLoadTracker(sampleRate, time.Minute, func(key string) string {
+		if strings.HasPrefix(key, "a") {
+			return "shard-a"
+		}
+		return "shard-b"
+	})
+	t.now = func() time.Time { return *now }
+	t.sample = rand.New(rand.NewSource(1)).Float64
+	t.started = *now
+	return t
+}
+
+// TestLoadTrackerFindsHotKey 均匀分布的背景流量中埋入热点键，top-K按count-min的误差上界找到它们
+func TestLoadTrackerFindsHotKey(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tracker := newTestLoadTracker(1, &now)
+
+	const (
+		background = 100000
+		keyspace   = 10000
+		hot        = 2000
+		warm       = 800
+	)
+	rng := rand.New(rand.NewSource(2))
+	for i := 0; i < background+hot+warm; i++ {
+		switch {
+		case i%50 == 0 && i/50 < hot:
+			tracker.record("b-hot", false)
+		case i%50 == 1 && i/50 < warm:
+			tracker.record("a-warm", true)
+		default:
+			tracker.record(fmt.Sprintf("b-%d", rng.Intn(keyspace)), false)
+		}
+		if i%10000 == 0 {
+			now = now.Add(time.Second)
+		}
+	}
+
+	report := tracker.report(defaultLoadTopKeys)
+	if len(report.HotKeys) != defaultLoadTopKeys {
+		t.Fatalf("应返回 %d 个热点键，实际 %d", defaultLoadTopKeys, len(report.HotKeys))
+	}
+
+	// count-min的估计不低于真实值，高估不超过 e/width * 总数
+	bound := uint64(math.Ceil(math.E / loadSketchWidth * float64(background+hot+warm)))
+	first, second := report.HotKeys[0], report.HotKeys[1]
+	if first.Key != "b-hot" || first.Count < hot || first.Count > hot+bound || first.ShardID != "shard-b" {
+		t.Errorf("最热的键应为b-hot，估计次数在 [%d, %d] 内: %+v", hot, hot+bound, first)
+	}
+	if second.Key != "a-warm" || second.Count < warm || second.Count > warm+bound || second.ShardID != "shard-a" {
+		t.Errorf("第二热的键应为a-warm: %+v", second)
+	}
+	for _, key := range report.HotKeys[2:] {
+		if key.Count > 10+bound {
+			t.Errorf("背景键的估计次数过高: %+v", key)
+		}
+	}
+
+	if len(report.Shards) != 2 {
+		t.Fatalf("应有两个分片的负载: %+v", report.Shards)
+	}
+	a, b := report.Shards[0], report.Shards[1]
+	if a.ShardID != "shard-a" || a.WriteRatio != 1 || b.WriteRatio != 0 {
+		t.Errorf("写比例不正确: %+v %+v", a, b)
+	}
+	if total := (a.QPS + b.QPS) * report.WindowSeconds; math.Abs(total-float64(background+hot+warm)) > 1 {
+		t.Errorf("窗口内的请求总数应为 %d，实际 %.0f", background+hot+warm, total)
+	}
+}
+
+// TestLoadTrackerSampling 按采样率放大的估计值接近真实值，超出窗口的时间桶不再计入
+func TestLoadTrackerSampling(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tracker := newTestLoadTracker(0.1, &now)
+
+	for i := 0; i < 50000; i++ {
+		if i%5 == 0 {
+			tracker.record("a-hot", true)
+		} else {
+			tracker.record(fmt.Sprintf("b-%d", i), false)
+		}
+	}
+	now = now.Add(30 * time.Second)
+
+	report := tracker.report(1)
+	if len(report.HotKeys) != 1 || report.HotKeys[0].Key != "a-hot" {
+		t.Fatalf("采样后仍应找到热点键: %+v", report.HotKeys)
+	}
+	if count := float64(report.HotKeys[0].Count); math.Abs(count-10000)/10000 > 0.1 {
+		t.Errorf("热点键的估计次数应接近10000，实际 %.0f", count)
+	}
+	if report.WindowSeconds != 30 {
+		t.Errorf("启动不足一个窗口时按实际时长计算速率: %v", report.WindowSeconds)
+	}
+	if qps := report.Shards[0].QPS; math.Abs(qps-10000.0/30)/(10000.0/30) > 0.1 {
+		t.Errorf("shard-a的速率应接近 %.1f，实际 %.1f", 10000.0/30, qps)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if report := tracker.report(20); len(report.Shards) != 0 || len(report.HotKeys) != 0 {
+		t.Errorf("窗口之外的请求不应计入: %+v", report)
+	}
+
+	disabled := newTestLoadTracker(-1, &now)
+	disabled.record("a", true)
+	if report := disabled.report(20); len(report.Shards) != 0 {
+		t.Errorf("关闭采样时不应记录请求: %+v", report)
+	}
+}
+
+// BenchmarkLoadTrackerRecord 默认采样率下每个请求的记录开销
+func BenchmarkLoadTrackerRecord(b *testing.B) {
+	tracker := newLoadTracker(defaultLoadSampleRate, time.Minute, func(string) string { return "shard-0" })
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tracker.record(keys[i%len(keys)], i%4 == 0)
+	}
+}
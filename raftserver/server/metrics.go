@@ -0,0 +1,96 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-11 10:32:17
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-11 10:32:17
+* @Description: ConcordKV Raft consensus server - metrics.go
+ */
+package server
+
+import (
+	"raftserver/metrics"
+	"raftserver/storage"
+)
+
+// newMetricsRegistry 创建节点的指标注册表，注册Raft、存储、内部队列，
+// 以及运行时才设置的读写分离路由器和故障转移协调器的收集器
+func (s *Server) newMetricsRegistry() *metrics.Registry {
+	registry := metrics.NewRegistry(metrics.LabelNodeID, string(s.config.NodeID))
+	registry.Register("raft", s.raftNode)
+	registry.Register("storage", metrics.CollectorFunc(s.collectStorageMetrics))
+	registry.Register("queues", metrics.CollectorFunc(s.collectQueueMetrics))
+	registry.Register("forwarding", metrics.CollectorFunc(s.collectWriteForwardMetrics))
+	registry.Register("waiters", metrics.CollectorFunc(s.collectWaiterMetrics))
+	registry.Register("router", metrics.CollectorFunc(func() []*metrics.Family {
+		s.mu.RLock()
+		collector, ok := s.routes.(metrics.Collector)
+		s.mu.RUnlock()
+		if !ok {
+			return nil
+		}
+		return collector.Collect()
+	}))
+	registry.Register("failover", metrics.CollectorFunc(func() []*metrics.Family {
+		s.mu.RLock()
+		collector, ok := s.failover.(metrics.Collector)
+		s.mu.RUnlock()
+		if !ok {
+			return nil
+		}
+		return collector.Collect()
+	}))
+	return registry
+}
+
+// Metrics 获取节点的指标注册表，异步复制器等在服务器之外创建的组件可在此注册收集器
+func (s *Server) Metrics() *metrics.Registry {
+	return s.metrics
+}
+
+// collectStorageMetrics 输出WAL的刷盘统计，使用内存存储时不输出
+func (s *Server) collectStorageMetrics() []*metrics.Family {
+	wal, ok := s.storage.(*storage.WALStorage)
+	if !ok {
+		return nil
+	}
+
+	stats := wal.SyncStats()
+	policy := string(stats.Policy)
+	return []*metrics.Family{
+		metrics.NewCounter("storage_wal_syncs_total", "Number of fsyncs performed on the WAL.").
+			With(float64(stats.Syncs), "policy", policy),
+		metrics.NewCounter("storage_wal_synced_appends_total", "Number of WAL appends made durable by fsync.").
+			With(float64(stats.SyncedAppends), "policy", policy),
+	}
+}
+
+// collectWaiterMetrics 输出等待命令应用结果的请求数，以及请求超时或被取消后放弃的等待数
+func (s *Server) collectWaiterMetrics() []*metrics.Family {
+	stats := s.stateMachine.WaiterStats()
+	return []*metrics.Family{
+		metrics.NewGauge("proposal_waiters", "Requests currently waiting for their command to be applied.").
+			With(float64(stats.Registered)),
+		metrics.NewCounter("proposal_waiters_abandoned_total", "Waits dropped because the request timed out or was cancelled before its command was applied.").
+			With(float64(stats.Abandoned)),
+	}
+}
+
+// collectQueueMetrics 输出内部队列的深度与入队、丢弃、合并计数
+func (s *Server) collectQueueMetrics() []*metrics.Family {
+	stats := s.queueStats(s.raftNode.GetMetrics())
+	if len(stats) == 0 {
+		return nil
+	}
+
+	depth := metrics.NewGauge("queue_depth", "Number of items waiting in the queue.")
+	enqueued := metrics.NewCounter("queue_enqueued_total", "Items added to the queue as new entries.")
+	dropped := metrics.NewCounter("queue_dropped_total", "Items dropped because the queue was full.")
+	coalesced := metrics.NewCounter("queue_coalesced_total", "Items merged into an entry already waiting in the queue.")
+	for _, q := range stats {
+		depth.With(float64(q.Depth), "queue", q.Name)
+		enqueued.With(float64(q.Enqueued), "queue", q.Name)
+		dropped.With(float64(q.Dropped), "queue", q.Name)
+		coalesced.With(float64(q.Coalesced), "queue", q.Name)
+	}
+	return []*metrics.Family{depth, enqueued, dropped, coalesced}
+}
@@ -0,0 +1,71 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-11 10:32:17
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-11 10:32:17
+* @Description: ConcordKV Raft consensus server - metrics_test.go
+ */
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"raftserver/replication"
+	"raftserver/statemachine"
+)
+
+// TestMetricsEndpointRouter /metrics输出运行时设置的读写分离路由器指标，并带上节点ID
+func TestMetricsEndpointRouter(t *testing.T) {
+	s := &Server{config: &ServerConfig{NodeID: "n1"}, stateMachine: statemachine.NewKVStateMachine()}
+	s.metrics = s.newMetricsRegistry()
+	// 测试不启动Raft节点
+	s.metrics.Unregister("raft")
+	s.metrics.Unregister("queues")
+
+	ts := httptest.NewServer(s.metrics)
+	defer ts.Close()
+
+	scrape := func() string {
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("抓取失败: %v", err)
+		}
+		defer resp.Body.Close()
+		var buf bytes.Buffer
+		buf.ReadFrom(resp.Body)
+		return buf.String()
+	}
+
+	if out := scrape(); strings.Contains(out, "rw_router_requests_total") {
+		t.Fatalf("未设置路由器时不应输出路由指标:\n%s", out)
+	}
+
+	router := newTestRouter()
+	s.SetReadWriteRouter(router)
+	for i := 0; i < 3; i++ {
+		if _, err := router.RouteRequest(replication.RequestTypeRead, "key", replication.ReadConsistencyEventual); err != nil {
+			t.Fatalf("路由读请求失败: %v", err)
+		}
+	}
+
+	out := scrape()
+	for _, want := range []string{
+		"# TYPE rw_router_requests_total counter\n",
+		`rw_router_requests_total{node_id="n1",type="read"} 3` + "\n",
+		`rw_router_requests_total{node_id="n1",type="write"} 0` + "\n",
+		`proposal_waiters{node_id="n1"} 0` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("输出缺少 %q:\n%s", want, out)
+		}
+	}
+
+	s.SetReadWriteRouter(nil)
+	if out := scrape(); strings.Contains(out, "rw_router_requests_total") {
+		t.Fatalf("移除路由器后不应输出路由指标:\n%s", out)
+	}
+}
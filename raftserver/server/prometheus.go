@@ -0,0 +1,224 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - prometheus.go
+ */
+package server
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"raftserver/queue"
+	"raftserver/raft"
+	"raftserver/replication"
+	"raftserver/storage"
+)
+
+// prometheusContentType Prometheus文本暴露格式的内容类型
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// writePrometheusMetrics 以Prometheus文本暴露格式输出节点指标、WAL刷盘统计与各跟随者的复制进度
+// 使用内存存储时syncStats为nil，不输出刷盘统计
+func writePrometheusMetrics(w io.Writer, nodeID raft.NodeID, metrics *raft.Metrics, syncStats *storage.SyncStats) {
+	node := fmt.Sprintf("node=%q", string(nodeID))
+
+	gauge := func(name, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+
+	isLeader := 0
+	if metrics.State == raft.Leader {
+		isLeader = 1
+	}
+
+	gauge("concordkv_raft_term", "Current Raft term.")
+	fmt.Fprintf(w, "concordkv_raft_term{%s} %d\n", node, metrics.CurrentTerm)
+	gauge("concordkv_raft_commit_index", "Highest log index known to be committed.")
+	fmt.Fprintf(w, "concordkv_raft_commit_index{%s} %d\n", node, metrics.CommitIndex)
+	gauge("concordkv_raft_last_applied", "Highest log index applied to the state machine.")
+	fmt.Fprintf(w, "concordkv_raft_last_applied{%s} %d\n", node, metrics.LastApplied)
+	gauge("concordkv_raft_is_leader", "Whether this node is the leader (1) or not (0).")
+	fmt.Fprintf(w, "concordkv_raft_is_leader{%s} %d\n", node, isLeader)
+	gauge("concordkv_raft_commit_policy_info", "Commit quorum policy in effect on this node.")
+	fmt.Fprintf(w, "concordkv_raft_commit_policy_info{%s,policy=%q} 1\n", node, string(metrics.Commit.Policy))
+	fmt.Fprintf(w, "# HELP concordkv_raft_commit_delayed_entries_total Entries that reached a majority but waited for cross-DC acknowledgment.\n# TYPE concordkv_raft_commit_delayed_entries_total counter\n")
+	fmt.Fprintf(w, "concordkv_raft_commit_delayed_entries_total{%s} %d\n", node, metrics.Commit.DelayedCommits)
+	fmt.Fprintf(w, "# HELP concordkv_raft_commit_delayed_wait_seconds_total Time spent waiting for cross-DC acknowledgment before commit.\n# TYPE concordkv_raft_commit_delayed_wait_seconds_total counter\n")
+	fmt.Fprintf(w, "concordkv_raft_commit_delayed_wait_seconds_total{%s} %s\n", node, strconv.FormatFloat(metrics.Commit.DelayedWait/1000, 'f', -1, 64))
+
+	if syncStats != nil {
+		fmt.Fprintf(w, "# HELP concordkv_storage_wal_syncs_total Number of fsyncs performed on the WAL.\n# TYPE concordkv_storage_wal_syncs_total counter\n")
+		fmt.Fprintf(w, "concordkv_storage_wal_syncs_total{%s,policy=%q} %d\n", node, string(syncStats.Policy), syncStats.Syncs)
+		fmt.Fprintf(w, "# HELP concordkv_storage_wal_synced_appends_total Number of WAL appends made durable by fsync.\n# TYPE concordkv_storage_wal_synced_appends_total counter\n")
+		fmt.Fprintf(w, "concordkv_storage_wal_synced_appends_total{%s,policy=%q} %d\n", node, string(syncStats.Policy), syncStats.SyncedAppends)
+		gauge("concordkv_storage_wal_sync_batch_size_avg", "Average number of WAL appends covered by one fsync.")
+		fmt.Fprintf(w, "concordkv_storage_wal_sync_batch_size_avg{%s,policy=%q} %s\n", node, string(syncStats.Policy),
+			strconv.FormatFloat(syncStats.AvgBatchSize, 'f', -1, 64))
+	}
+
+	if len(metrics.Replication) == 0 {
+		return
+	}
+
+	peers := make([]raft.NodeID, 0, len(metrics.Replication))
+	for id := range metrics.Replication {
+		peers = append(peers, id)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+
+	series := []struct {
+		name  string
+		help  string
+		value func(p raft.ReplicationProgress) float64
+	}{
+		{"concordkv_raft_replication_match_index", "Highest log index known to be replicated on the peer.",
+			func(p raft.ReplicationProgress) float64 { return float64(p.MatchIndex) }},
+		{"concordkv_raft_replication_next_index", "Next log index the leader will send to the peer.",
+			func(p raft.ReplicationProgress) float64 { return float64(p.NextIndex) }},
+		{"concordkv_raft_replication_last_append_timestamp_seconds", "Unix time of the last successful AppendEntries to the peer.",
+			func(p raft.ReplicationProgress) float64 {
+				if p.LastAppendTime.IsZero() {
+					return 0
+				}
+				return float64(p.LastAppendTime.UnixNano()) / 1e9
+			}},
+		{"concordkv_raft_replication_inflight_entries", "Entries sent to the peer and not yet acknowledged.",
+			func(p raft.ReplicationProgress) float64 { return float64(p.InflightEntries) }},
+		{"concordkv_raft_replication_lag_entries", "Number of log entries the peer is behind the leader.",
+			func(p raft.ReplicationProgress) float64 { return float64(p.LagEntries) }},
+		{"concordkv_raft_replication_lag_milliseconds", "Estimated replication lag of the peer in milliseconds.",
+			func(p raft.ReplicationProgress) float64 { return float64(p.LagMillis) }},
+		{"concordkv_raft_replication_catchup_rate_bytes", "Recent catch-up replication rate to the peer in bytes per second.",
+			func(p raft.ReplicationProgress) float64 { return p.CatchUpRate }},
+		{"concordkv_raft_replication_throttled_milliseconds", "Time catch-up replication to the peer spent waiting for the rate limiter.",
+			func(p raft.ReplicationProgress) float64 { return float64(p.ThrottledMillis) }},
+	}
+
+	for _, s := range series {
+		gauge(s.name, s.help)
+		for _, id := range peers {
+			progress := metrics.Replication[id]
+			fmt.Fprintf(w, "%s{%s,peer=%q,learner=\"%t\"} %s\n", s.name, node, string(id), progress.Learner,
+				strconv.FormatFloat(s.value(progress), 'f', -1, 64))
+		}
+	}
+
+	writeSnapshotTransferMetrics(w, node, metrics.SnapshotTransfers, gauge)
+}
+
+// writeSnapshotTransferMetrics 输出向各跟随者发送快照的进度
+func writeSnapshotTransferMetrics(w io.Writer, node string, transfers map[raft.NodeID]raft.SnapshotTransferProgress, gauge func(name, help string)) {
+	if len(transfers) == 0 {
+		return
+	}
+
+	peers := make([]raft.NodeID, 0, len(transfers))
+	for id := range transfers {
+		peers = append(peers, id)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+
+	gauge("concordkv_raft_snapshot_transfer_bytes_sent", "Snapshot bytes acknowledged by the peer in the current transfer.")
+	for _, id := range peers {
+		fmt.Fprintf(w, "concordkv_raft_snapshot_transfer_bytes_sent{%s,peer=%q} %d\n", node, string(id), transfers[id].BytesSent)
+	}
+	gauge("concordkv_raft_snapshot_transfer_total_bytes", "Total size of the snapshot being sent to the peer.")
+	for _, id := range peers {
+		fmt.Fprintf(w, "concordkv_raft_snapshot_transfer_total_bytes{%s,peer=%q} %d\n", node, string(id), transfers[id].TotalBytes)
+	}
+	gauge("concordkv_raft_snapshot_transfer_active", "Whether chunks are currently being sent to the peer (1) or the transfer is waiting to resume (0).")
+	for _, id := range peers {
+		active := 0
+		if transfers[id].Active {
+			active = 1
+		}
+		fmt.Fprintf(w, "concordkv_raft_snapshot_transfer_active{%s,peer=%q} %d\n", node, string(id), active)
+	}
+}
+
+// writeQueueMetrics 输出内部队列的深度与入队、丢弃、合并计数
+func writeQueueMetrics(w io.Writer, nodeID raft.NodeID, stats []queue.Stats) {
+	if len(stats) == 0 {
+		return
+	}
+
+	node := fmt.Sprintf("node=%q", string(nodeID))
+	fmt.Fprintf(w, "# HELP concordkv_queue_depth Number of items waiting in the queue.\n# TYPE concordkv_queue_depth gauge\n")
+	for _, q := range stats {
+		fmt.Fprintf(w, "concordkv_queue_depth{%s,queue=%q,policy=%q} %d\n", node, q.Name, string(q.Policy), q.Depth)
+	}
+	fmt.Fprintf(w, "# HELP concordkv_queue_capacity Maximum number of items the queue holds.\n# TYPE concordkv_queue_capacity gauge\n")
+	for _, q := range stats {
+		fmt.Fprintf(w, "concordkv_queue_capacity{%s,queue=%q} %d\n", node, q.Name, q.Capacity)
+	}
+
+	counters := []struct {
+		name  string
+		help  string
+		value func(q queue.Stats) int64
+	}{
+		{"concordkv_queue_enqueued_total", "Items added to the queue as new entries.",
+			func(q queue.Stats) int64 { return q.Enqueued }},
+		{"concordkv_queue_dropped_total", "Items dropped because the queue was full.",
+			func(q queue.Stats) int64 { return q.Dropped }},
+		{"concordkv_queue_coalesced_total", "Items merged into an entry already waiting in the queue.",
+			func(q queue.Stats) int64 { return q.Coalesced }},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for _, q := range stats {
+			fmt.Fprintf(w, "%s{%s,queue=%q} %d\n", c.name, node, q.Name, c.value(q))
+		}
+	}
+}
+
+// writeRouterLatencyMetrics 以Prometheus直方图输出读写分离路由器滑动窗口内的路由延迟，按请求类型和目标DC分别统计
+func writeRouterLatencyMetrics(w io.Writer, nodeID raft.NodeID, histograms *replication.RouterLatencyHistograms) {
+	node := fmt.Sprintf("node=%q", string(nodeID))
+
+	types := make([]replication.RequestType, 0, len(histograms.ByRequestType))
+	for requestType := range histograms.ByRequestType {
+		types = append(types, requestType)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	name := "concordkv_router_routing_latency_seconds"
+	fmt.Fprintf(w, "# HELP %s Request routing latency by request type over the last %s.\n# TYPE %s histogram\n",
+		name, histograms.Window, name)
+	for _, requestType := range types {
+		labels := fmt.Sprintf("%s,type=%q", node, routeTypeNames[requestType])
+		writePrometheusHistogram(w, name, labels, histograms.ByRequestType[requestType])
+	}
+
+	dcs := make([]raft.DataCenterID, 0, len(histograms.ByDC))
+	for dcID := range histograms.ByDC {
+		dcs = append(dcs, dcID)
+	}
+	sort.Slice(dcs, func(i, j int) bool { return dcs[i] < dcs[j] })
+
+	name = "concordkv_router_dc_routing_latency_seconds"
+	fmt.Fprintf(w, "# HELP %s Request routing latency by target data center over the last %s.\n# TYPE %s histogram\n",
+		name, histograms.Window, name)
+	for _, dcID := range dcs {
+		labels := fmt.Sprintf("%s,dc=%q", node, string(dcID))
+		writePrometheusHistogram(w, name, labels, histograms.ByDC[dcID])
+	}
+}
+
+// writePrometheusHistogram 输出一个直方图序列的累计桶、样本总和与样本数
+func writePrometheusHistogram(w io.Writer, name, labels string, histogram *replication.LatencyHistogramSnapshot) {
+	var cumulative uint64
+	for i, bound := range histogram.Bounds {
+		cumulative += histogram.Counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", name, labels,
+			strconv.FormatFloat(bound.Seconds(), 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, histogram.Count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(histogram.Sum.Seconds(), 'f', -1, 64))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, histogram.Count)
+}
@@ -0,0 +1,123 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-4 21:48:12
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-4 21:48:12
+* @Description: ConcordKV Raft consensus server - prometheus_test.go
+ */
+package server
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+
+	"raftserver/queue"
+	"raftserver/raft"
+	"raftserver/replication"
+)
+
+// TestWriteRouterLatencyMetrics 路由延迟按请求类型和DC输出为Prometheus直方图
+func TestWriteRouterLatencyMetrics(t *testing.T) {
+	router := newTestRouter()
+	for i := 0; i < 3; i++ {
+		if _, err := router.RouteRequest(replication.RequestTypeRead, "key", replication.ReadConsistencyEventual); err != nil {
+			t.Fatalf("路由读请求失败: %v", err)
+		}
+	}
+	if _, err := router.RouteRequest(replication.RequestTypeWrite, "key", replication.ReadConsistencyStrong); err != nil {
+		t.Fatalf("路由写请求失败: %v", err)
+	}
+
+	var buf bytes.Buffer
+	writeRouterLatencyMetrics(&buf, "n1", router.GetLatencyHistograms())
+	out := buf.String()
+
+	for _, want := range []string{
+		"# TYPE concordkv_router_routing_latency_seconds histogram\n",
+		`concordkv_router_routing_latency_seconds_bucket{node="n1",type="read",le="+Inf"} 3` + "\n",
+		`concordkv_router_routing_latency_seconds_count{node="n1",type="write"} 1` + "\n",
+		`concordkv_router_routing_latency_seconds_bucket{node="n1",type="read",le="1e-06"} `,
+		"# TYPE concordkv_router_dc_routing_latency_seconds histogram\n",
+		`concordkv_router_dc_routing_latency_seconds_count{node="n1",dc="dc1"} 3` + "\n",
+		`concordkv_router_dc_routing_latency_seconds_count{node="n1",dc="dc2"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("输出缺少 %q", want)
+		}
+	}
+
+	// 桶计数是累计的，不会减少
+	var last int
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.HasPrefix(line, `concordkv_router_routing_latency_seconds_bucket{node="n1",type="read"`) {
+			continue
+		}
+		fields := strings.Fields(line)
+		count, err := strconv.Atoi(fields[len(fields)-1])
+		if err != nil {
+			t.Fatalf("无法解析桶计数: %s", line)
+		}
+		if count < last {
+			t.Fatalf("桶计数减少: %s", line)
+		}
+		last = count
+	}
+	if last != 3 {
+		t.Fatalf("最后一个桶计数 = %d, 期望 3", last)
+	}
+}
+
+// TestWritePrometheusCommitPolicy 输出生效的提交策略与等待跨DC确认的统计
+func TestWritePrometheusCommitPolicy(t *testing.T) {
+	metrics := &raft.Metrics{
+		Commit: raft.CommitPolicyMetrics{
+			Policy:         raft.CommitPolicyMajorityPlusRemote,
+			DelayedCommits: 7,
+			DelayedWait:    1500,
+		},
+	}
+
+	var buf bytes.Buffer
+	writePrometheusMetrics(&buf, "n1", metrics, nil)
+	out := buf.String()
+
+	for _, want := range []string{
+		`concordkv_raft_commit_policy_info{node="n1",policy="majority-plus-remote"} 1` + "\n",
+		"# TYPE concordkv_raft_commit_delayed_entries_total counter\n",
+		`concordkv_raft_commit_delayed_entries_total{node="n1"} 7` + "\n",
+		`concordkv_raft_commit_delayed_wait_seconds_total{node="n1"} 1.5` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("输出缺少 %q", want)
+		}
+	}
+}
+
+// TestWriteQueueMetrics 输出节点与故障转移协调器各队列的深度和计数
+func TestWriteQueueMetrics(t *testing.T) {
+	s := &Server{config: &ServerConfig{NodeID: "n1"}}
+	s.SetFailoverCoordinator(replication.NewFailoverCoordinator("n1", nil, nil, nil, nil, nil))
+	metrics := &raft.Metrics{Queues: []queue.Stats{
+		{Name: "cross-dc-replication", Policy: queue.BlockWithTimeout, Capacity: 1000, Depth: 3, Enqueued: 10, Dropped: 2},
+	}}
+
+	var buf bytes.Buffer
+	writeQueueMetrics(&buf, "n1", s.queueStats(metrics))
+	out := buf.String()
+
+	for _, want := range []string{
+		`concordkv_queue_depth{node="n1",queue="cross-dc-replication",policy="block-with-timeout"} 3` + "\n",
+		`concordkv_queue_capacity{node="n1",queue="cross-dc-replication"} 1000` + "\n",
+		"# TYPE concordkv_queue_dropped_total counter\n",
+		`concordkv_queue_enqueued_total{node="n1",queue="cross-dc-replication"} 10` + "\n",
+		`concordkv_queue_dropped_total{node="n1",queue="cross-dc-replication"} 2` + "\n",
+		`concordkv_queue_depth{node="n1",queue="failover-failure-events",policy="coalesce"} 0` + "\n",
+		`concordkv_queue_coalesced_total{node="n1",queue="failover-failure-events"} 0` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("输出缺少 %q", want)
+		}
+	}
+}
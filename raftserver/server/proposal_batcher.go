@@ -0,0 +1,350 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - proposal_batcher.go
+ */
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// 提议批处理默认参数
+const (
+	defaultProposalBatchWindow = 5 * time.Millisecond
+	defaultProposalBatchSize   = 64
+	defaultMaxPendingProposals = 1024
+)
+
+var (
+	// ErrProposalQueueFull 提议队列已满，调用方应稍后重试
+	ErrProposalQueueFull = errors.New("提议队列已满")
+
+	// ErrBatcherStopped 批处理器已停止
+	ErrBatcherStopped = errors.New("提议批处理器已停止")
+
+	// errInvalidCommand 命令未通过校验
+	errInvalidCommand = errors.New("无效的命令")
+)
+
+// batchProposer 支持一次追加多个日志条目的提议者，由raft.Node实现
+type batchProposer interface {
+	ProposeBatch(data [][]byte) ([]raft.LogIndex, error)
+}
+
+// resultWaiter 等待命令应用结果，由statemachine.KVStateMachine实现
+type resultWaiter interface {
+	RegisterWaiter(ctx context.Context, requestID string) <-chan *statemachine.CommandResult
+	CancelWaiter(requestID string)
+}
+
+// proposal 等待合并提交的单个提议
+type proposal struct {
+	ctx   context.Context // 请求的上下文，结束后不再等待应用结果
+	cmd   statemachine.Command
+	done  chan proposalAck // 追加到日志或失败后收到一次通知
+	trace *requestTrace    // 请求的阶段追踪，可能为nil
+}
+
+// proposalAck 提议被追加到日志后的确认
+type proposalAck struct {
+	index  raft.LogIndex
+	result <-chan *statemachine.CommandResult
+	err    error
+}
+
+// proposalBatcher 将短时间内到达的提议合并为一次日志追加
+// 达到批大小或批处理窗口到期（以先到者为准）时提交，每个调用方仍各自等待自己命令的应用结果
+type proposalBatcher struct {
+	proposer  batchProposer
+	waiter    resultWaiter
+	nextID    func() string
+	window    time.Duration
+	batchSize int
+	logger    logging.Logger
+
+	queue  chan *proposal
+	queued atomic.Int64 // 已入队但尚未追加到日志或返回错误的提议数
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// newProposalBatcher 创建提议批处理器，非正数参数使用默认值
+func newProposalBatcher(proposer batchProposer, waiter resultWaiter, nextID func() string,
+	window time.Duration, batchSize, maxPending int, logger logging.Logger) *proposalBatcher {
+	if window <= 0 {
+		window = defaultProposalBatchWindow
+	}
+	if batchSize <= 0 {
+		batchSize = defaultProposalBatchSize
+	}
+	if maxPending <= 0 {
+		maxPending = defaultMaxPendingProposals
+	}
+
+	return &proposalBatcher{
+		proposer:  proposer,
+		waiter:    waiter,
+		nextID:    nextID,
+		window:    window,
+		batchSize: batchSize,
+		logger:    logger,
+		queue:     make(chan *proposal, maxPending),
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// Start 启动批处理协程
+func (b *proposalBatcher) Start() {
+	b.wg.Add(1)
+	go b.run()
+}
+
+// Stop 停止批处理协程，队列中尚未提交的提议返回ErrBatcherStopped
+func (b *proposalBatcher) Stop() {
+	close(b.stopCh)
+	b.wg.Wait()
+
+	for {
+		select {
+		case p := <-b.queue:
+			p.done <- proposalAck{err: ErrBatcherStopped}
+			b.queued.Add(-1)
+		default:
+			return
+		}
+	}
+}
+
+// Submit 提交命令并等待其被应用，返回命令的日志索引与应用结果
+// 队列已满时立即返回ErrProposalQueueFull
+func (b *proposalBatcher) Submit(ctx context.Context, cmd statemachine.Command) (raft.LogIndex, *statemachine.CommandResult, error) {
+	p := &proposal{
+		ctx:   ctx,
+		cmd:   cmd,
+		done:  make(chan proposalAck, 1),
+		trace: traceFrom(ctx),
+	}
+
+	select {
+	case <-b.stopCh:
+		return 0, nil, ErrBatcherStopped
+	default:
+	}
+
+	b.queued.Add(1)
+	select {
+	case b.queue <- p:
+	default:
+		b.queued.Add(-1)
+		return 0, nil, ErrProposalQueueFull
+	}
+
+	var ack proposalAck
+	select {
+	case ack = <-p.done:
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	}
+
+	if ack.err != nil {
+		return 0, nil, ack.err
+	}
+
+	select {
+	case result := <-ack.result:
+		p.trace.mark(stageApplied)
+		if result.Err != nil {
+			return ack.index, result, result.Err
+		}
+		return ack.index, result, nil
+	case <-ctx.Done():
+		b.waiter.CancelWaiter(p.cmd.RequestID)
+		return ack.index, nil, ctx.Err()
+	}
+}
+
+// run 批处理主循环
+func (b *proposalBatcher) run() {
+	defer b.wg.Done()
+
+	for {
+		var first *proposal
+		select {
+		case <-b.stopCh:
+			return
+		case first = <-b.queue:
+		}
+
+		batch := []*proposal{first}
+		timer := time.NewTimer(b.window)
+
+	collect:
+		for len(batch) < b.batchSize {
+			select {
+			case p := <-b.queue:
+				batch = append(batch, p)
+			case <-timer.C:
+				break collect
+			case <-b.stopCh:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		b.flush(batch)
+		b.queued.Add(-int64(len(batch)))
+	}
+}
+
+// pending 已入队但尚未追加到日志的提议数
+func (b *proposalBatcher) pending() int64 {
+	return b.queued.Load()
+}
+
+// flush 校验并一次性追加一批提议，未通过校验的提议单独返回错误，不影响同批其他提议
+func (b *proposalBatcher) flush(batch []*proposal) {
+	accepted := make([]*proposal, 0, len(batch))
+	results := make([]<-chan *statemachine.CommandResult, 0, len(batch))
+	data := make([][]byte, 0, len(batch))
+
+	for _, p := range batch {
+		if err := validateCommand(&p.cmd); err != nil {
+			p.done <- proposalAck{err: err}
+			continue
+		}
+
+		p.cmd.RequestID = b.nextID()
+		cmdData, err := json.Marshal(p.cmd)
+		if err != nil {
+			p.done <- proposalAck{err: fmt.Errorf("%w: 序列化命令失败: %v", errInvalidCommand, err)}
+			continue
+		}
+
+		// 在提议之前注册等待，避免错过应用结果；调用方已超时的提议仍会提交，只是等待随即被移除
+		results = append(results, b.waiter.RegisterWaiter(p.ctx, p.cmd.RequestID))
+		accepted = append(accepted, p)
+		data = append(data, cmdData)
+	}
+
+	if len(accepted) == 0 {
+		return
+	}
+
+	for _, p := range accepted {
+		p.trace.mark(stageProposed)
+	}
+
+	indexes, err := b.proposer.ProposeBatch(data)
+	if err != nil {
+		for _, p := range accepted {
+			b.waiter.CancelWaiter(p.cmd.RequestID)
+			p.done <- proposalAck{err: err}
+		}
+		return
+	}
+
+	if len(accepted) > 1 && b.logger != nil {
+		b.logger.Debug("合并提议为一次日志追加", "proposals", len(accepted), "first_index", indexes[0], "last_index", indexes[len(indexes)-1])
+	}
+
+	for i, p := range accepted {
+		p.trace.appended(indexes[i])
+		p.done <- proposalAck{index: indexes[i], result: results[i]}
+	}
+}
+
+// validateCommand 在追加到日志之前校验命令
+func validateCommand(cmd *statemachine.Command) error {
+	switch cmd.Type {
+	case "SET", "DELETE", "CAS":
+		if cmd.Key == "" {
+			return fmt.Errorf("%w: key不能为空", errInvalidCommand)
+		}
+		if cmd.TTLSeconds < 0 {
+			return fmt.Errorf("%w: ttlSeconds不能为负数", errInvalidCommand)
+		}
+		if cmd.Meta != nil {
+			if err := cmd.Meta.Validate(); err != nil {
+				return fmt.Errorf("%w: %v", errInvalidCommand, err)
+			}
+		}
+	case "BATCH":
+		if len(cmd.Ops) == 0 {
+			return fmt.Errorf("%w: 批量操作不能为空", errInvalidCommand)
+		}
+	case "DELETE_RANGE":
+		if cmd.Range == nil {
+			return fmt.Errorf("%w: 缺少键范围", errInvalidCommand)
+		}
+		if err := cmd.Range.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", errInvalidCommand, err)
+		}
+		if cmd.Limit < 0 || cmd.Limit > statemachine.MaxDeleteRangeKeys {
+			return fmt.Errorf("%w: limit必须在0到%d之间", errInvalidCommand, statemachine.MaxDeleteRangeKeys)
+		}
+	case "TXN":
+		if err := statemachine.ValidateTxn(cmd); err != nil {
+			return fmt.Errorf("%w: %v", errInvalidCommand, err)
+		}
+	case "ACL_SET":
+		if cmd.ACLToken == nil {
+			return fmt.Errorf("%w: 缺少令牌", errInvalidCommand)
+		}
+		if err := cmd.ACLToken.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", errInvalidCommand, err)
+		}
+	case "ACL_DELETE":
+		if cmd.Key == "" {
+			return fmt.Errorf("%w: 令牌名称不能为空", errInvalidCommand)
+		}
+	case "QUOTA_SET":
+		if cmd.Quota == nil {
+			return fmt.Errorf("%w: 缺少配额", errInvalidCommand)
+		}
+		if err := cmd.Quota.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", errInvalidCommand, err)
+		}
+	case "QUOTA_DELETE":
+		if cmd.Key == "" {
+			return fmt.Errorf("%w: 配额的前缀不能为空", errInvalidCommand)
+		}
+	case "REPLICATE":
+		if cmd.Replicated == nil || cmd.Replicated.SourceDC == "" || len(cmd.Replicated.Entries) == 0 {
+			return fmt.Errorf("%w: 缺少源DC或复制的条目", errInvalidCommand)
+		}
+	case "SHARD_SPLIT", "SHARD_MERGE", "SHARD_ACTIVATE":
+		if cmd.ShardOp == nil {
+			return fmt.Errorf("%w: 缺少分片参数", errInvalidCommand)
+		}
+	case "SESSION_REGISTER", "SESSION_KEEPALIVE", "SESSION_CLOSE":
+		if cmd.SessionID == "" {
+			return fmt.Errorf("%w: 缺少会话ID", errInvalidCommand)
+		}
+	default:
+		return fmt.Errorf("%w: 未知命令类型 %s", errInvalidCommand, cmd.Type)
+	}
+
+	// 只有修改键值的命令参与会话去重
+	if cmd.SeqNum != 0 {
+		switch {
+		case cmd.SessionID == "":
+			return fmt.Errorf("%w: 带序号的命令缺少会话ID", errInvalidCommand)
+		case cmd.Type != "SET" && cmd.Type != "DELETE" && cmd.Type != "CAS" && cmd.Type != "BATCH" && cmd.Type != "TXN" && cmd.Type != "DELETE_RANGE":
+			return fmt.Errorf("%w: %s命令不支持会话序号", errInvalidCommand, cmd.Type)
+		}
+	}
+	return nil
+}
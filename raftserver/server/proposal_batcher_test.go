@@ -0,0 +1,303 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - proposal_batcher_test.go
+ */
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// fakeProposer 记录每次ProposeBatch调用，并异步把条目应用到状态机
+type fakeProposer struct {
+	mu      sync.Mutex
+	sm      *statemachine.KVStateMachine
+	last    raft.LogIndex
+	batches [][][]byte
+	err     error
+	block   chan struct{} // 非nil时ProposeBatch阻塞到关闭
+	hold    bool          // 只分配索引不应用，模拟迟迟未能提交的条目
+}
+
+func (p *fakeProposer) ProposeBatch(data [][]byte) ([]raft.LogIndex, error) {
+	if p.block != nil {
+		<-p.block
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.batches = append(p.batches, data)
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	entries := make([]*raft.LogEntry, len(data))
+	indexes := make([]raft.LogIndex, len(data))
+	for i, d := range data {
+		p.last++
+		indexes[i] = p.last
+		entries[i] = &raft.LogEntry{Index: p.last, Term: 1, Timestamp: time.Now(), Type: raft.EntryNormal, Data: d}
+	}
+
+	if p.hold {
+		return indexes, nil
+	}
+
+	go func() {
+		for _, entry := range entries {
+			p.sm.Apply(entry)
+		}
+	}()
+
+	return indexes, nil
+}
+
+func (p *fakeProposer) batchCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.batches)
+}
+
+// newTestBatcher 创建使用fakeProposer的批处理器
+func newTestBatcher(proposer *fakeProposer, window time.Duration, batchSize, maxPending int) *proposalBatcher {
+	var seq uint64
+	nextID := func() string { return fmt.Sprintf("req-%d", atomic.AddUint64(&seq, 1)) }
+	return newProposalBatcher(proposer, proposer.sm, nextID, window, batchSize, maxPending, nil)
+}
+
+// TestProposalBatcherCoalesces 并发提议被合并为少量日志追加，且每个调用方拿到自己命令的索引与结果
+func TestProposalBatcherCoalesces(t *testing.T) {
+	proposer := &fakeProposer{sm: statemachine.NewKVStateMachine()}
+	batcher := newTestBatcher(proposer, 20*time.Millisecond, 64, 1024)
+	batcher.Start()
+	defer batcher.Stop()
+
+	const clients = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// 期望键不存在的CAS成功后版本等于其日志索引
+			cmd := statemachine.Command{Type: "CAS", Key: fmt.Sprintf("key-%d", i), Value: i}
+			index, result, err := batcher.Submit(context.Background(), cmd)
+			if err != nil {
+				errs <- fmt.Errorf("提议 %d 失败: %v", i, err)
+				return
+			}
+			if !result.Swapped || result.Version != uint64(index) {
+				errs <- fmt.Errorf("提议 %d 结果不匹配: 索引 %d，结果 %+v", i, index, result)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if count := proposer.batchCount(); count >= clients {
+		t.Fatalf("提议未被合并: %d 个提议产生了 %d 次追加", clients, count)
+	}
+}
+
+// TestProposalBatcherRejectsInvalid 同批中的无效命令单独失败，不影响其他命令
+func TestProposalBatcherRejectsInvalid(t *testing.T) {
+	proposer := &fakeProposer{sm: statemachine.NewKVStateMachine()}
+	batcher := newTestBatcher(proposer, 20*time.Millisecond, 64, 1024)
+	batcher.Start()
+	defer batcher.Stop()
+
+	cmds := []statemachine.Command{
+		{Type: "SET", Key: "a", Value: "1"},
+		{Type: "SET", Key: "", Value: "2"},
+		{Type: "UNKNOWN", Key: "b"},
+		{Type: "BATCH"},
+		{Type: "DELETE", Key: "c"},
+	}
+	valid := []bool{true, false, false, false, true}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(cmds))
+	for i, cmd := range cmds {
+		wg.Add(1)
+		go func(i int, cmd statemachine.Command) {
+			defer wg.Done()
+			_, _, errs[i] = batcher.Submit(context.Background(), cmd)
+		}(i, cmd)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if valid[i] && err != nil {
+			t.Errorf("命令 %d 应当成功: %v", i, err)
+		}
+		if !valid[i] && !errors.Is(err, errInvalidCommand) {
+			t.Errorf("命令 %d 应当因校验失败被拒绝，实际: %v", i, err)
+		}
+	}
+
+	proposed := 0
+	for _, batch := range proposer.batches {
+		proposed += len(batch)
+	}
+	if proposed != 2 {
+		t.Fatalf("只有有效命令应被追加，实际追加 %d 条", proposed)
+	}
+}
+
+// TestProposalBatcherQueueFull 待处理提议超过上限时立即返回ErrProposalQueueFull
+func TestProposalBatcherQueueFull(t *testing.T) {
+	proposer := &fakeProposer{sm: statemachine.NewKVStateMachine(), block: make(chan struct{})}
+	batcher := newTestBatcher(proposer, time.Millisecond, 1, 2)
+	batcher.Start()
+	defer batcher.Stop()
+	defer close(proposer.block)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 第一个提议占住批处理协程，随后两个填满队列
+	for i := 0; i < 3; i++ {
+		go batcher.Submit(ctx, statemachine.Command{Type: "SET", Key: fmt.Sprintf("k%d", i)})
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, _, err := batcher.Submit(ctx, statemachine.Command{Type: "SET", Key: "overflow"}); !errors.Is(err, ErrProposalQueueFull) {
+		t.Fatalf("期望ErrProposalQueueFull，实际: %v", err)
+	}
+}
+
+// TestProposalBatcherPropagatesProposeError 追加失败时同批所有调用方都收到该错误
+func TestProposalBatcherPropagatesProposeError(t *testing.T) {
+	proposer := &fakeProposer{sm: statemachine.NewKVStateMachine(), err: raft.ErrNotLeader}
+	batcher := newTestBatcher(proposer, 20*time.Millisecond, 64, 1024)
+	batcher.Start()
+	defer batcher.Stop()
+
+	const clients = 10
+	var wg sync.WaitGroup
+	errs := make([]error, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, errs[i] = batcher.Submit(context.Background(), statemachine.Command{Type: "SET", Key: fmt.Sprintf("k%d", i)})
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if !errors.Is(err, raft.ErrNotLeader) {
+			t.Errorf("提议 %d 期望ErrNotLeader，实际: %v", i, err)
+		}
+	}
+}
+
+// TestProposalBatcherAbandonsExpiredWaiters 大量1ms截止时间的写请求超时后立即移除各自的等待，注册的等待数不随请求累积；
+// 命令仍被提交，之后应用时没有等待方也不受影响
+func TestProposalBatcherAbandonsExpiredWaiters(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	proposer := &fakeProposer{sm: sm, hold: true}
+	batcher := newTestBatcher(proposer, time.Millisecond, 64, 8192)
+	batcher.Start()
+	defer batcher.Stop()
+
+	const (
+		workers   = 50
+		perWorker = 100
+		total     = workers * perWorker
+	)
+
+	stopSampling := make(chan struct{})
+	var peak atomic.Int64
+	var sampling sync.WaitGroup
+	sampling.Add(1)
+	go func() {
+		defer sampling.Done()
+		for {
+			if n := int64(sm.WaiterStats().Registered); n > peak.Load() {
+				peak.Store(n)
+			}
+			select {
+			case <-stopSampling:
+				return
+			case <-time.After(100 * time.Microsecond):
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var timeouts atomic.Int64
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+				_, _, err := batcher.Submit(ctx, statemachine.Command{Type: "SET", Key: fmt.Sprintf("k-%d-%d", w, i), Value: i})
+				cancel()
+				if errors.Is(err, context.DeadlineExceeded) {
+					timeouts.Add(1)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for batcher.pending() > 0 || sm.WaiterStats().Registered > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待未被移除: 队列中 %d 个提议，%+v", batcher.pending(), sm.WaiterStats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(stopSampling)
+	sampling.Wait()
+
+	if timeouts.Load() != total {
+		t.Fatalf("期望 %d 个请求超时，实际 %d", total, timeouts.Load())
+	}
+	if p := peak.Load(); p > total/4 {
+		t.Fatalf("注册的等待数随请求累积，峰值 %d", p)
+	}
+	if stats := sm.WaiterStats(); stats.Abandoned != total {
+		t.Fatalf("期望放弃 %d 个等待，实际 %+v", total, stats)
+	}
+
+	// 超时只放弃了响应，已追加的命令在提交后照常应用
+	index := raft.LogIndex(0)
+	proposer.mu.Lock()
+	batches := proposer.batches
+	proposer.mu.Unlock()
+	for _, batch := range batches {
+		for _, data := range batch {
+			index++
+			if err := sm.Apply(&raft.LogEntry{Index: index, Term: 1, Timestamp: time.Now(), Type: raft.EntryNormal, Data: data}); err != nil {
+				t.Fatalf("应用日志条目失败: %v", err)
+			}
+		}
+	}
+	if index != total || sm.Size() != total {
+		t.Fatalf("期望应用 %d 个写入，实际提交 %d 个、状态机中 %d 个键", total, index, sm.Size())
+	}
+	if stats := sm.WaiterStats(); stats.Registered != 0 || stats.Abandoned != total {
+		t.Fatalf("应用没有等待方的命令后 %+v", stats)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-8-2 10:06:52
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-8-2 10:06:52
+* @Description: ConcordKV Raft consensus server - quota.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"raftserver/statemachine"
+)
+
+// handleQuota 管理命名空间配额：POST设置或更新，DELETE按prefix参数删除，均通过Raft复制到所有节点
+// 超出配额的写入在应用时被拒绝，返回507与QUOTA_EXCEEDED
+func (s *Server) handleQuota(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" && r.Method != "DELETE" {
+		http.Error(w, "只支持POST、DELETE方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.redirectToLeader(w, r) || !s.requireAdmin(w, r) {
+		return
+	}
+
+	var quota statemachine.Quota
+	cmd := statemachine.Command{Type: "QUOTA_DELETE"}
+	if r.Method == "POST" {
+		if err := json.NewDecoder(r.Body).Decode(&quota); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, "解析请求失败")
+			return
+		}
+		if err := quota.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, err.Error())
+			return
+		}
+		cmd = statemachine.Command{Type: "QUOTA_SET", Quota: &quota}
+	} else {
+		quota.Prefix = r.URL.Query().Get("prefix")
+		if quota.Prefix == "" {
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, "缺少prefix参数")
+			return
+		}
+		if !s.hasQuota(quota.Prefix) {
+			writeError(w, http.StatusNotFound, codeKeyNotFound, fmt.Sprintf("前缀 %q 没有配额", quota.Prefix))
+			return
+		}
+		cmd.Key = quota.Prefix
+	}
+
+	index, _, ok := s.proposeCommand(w, r, cmd)
+	if !ok {
+		return
+	}
+
+	s.logger.Info("审计: 修改命名空间配额", "token", principalName(r), "type", cmd.Type, "prefix", quota.Prefix,
+		"max_keys", quota.MaxKeys, "max_bytes", quota.MaxBytes, "index", index)
+
+	response := map[string]interface{}{
+		"success": true,
+		"prefix":  quota.Prefix,
+		"index":   index,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// hasQuota 前缀是否设置了配额
+func (s *Server) hasQuota(prefix string) bool {
+	for _, usage := range s.stateMachine.QuotaUsage() {
+		if usage.Prefix == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// handleUsage 返回各命名空间的配额与本节点状态机中的当前用量
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	usage := s.stateMachine.QuotaUsage()
+	response := map[string]interface{}{
+		"nodeId":       s.config.NodeID,
+		"appliedIndex": s.stateMachine.AppliedIndex(),
+		"usage":        usage,
+		"count":        len(usage),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
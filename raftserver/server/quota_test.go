@@ -0,0 +1,324 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-8-2 10:48:19
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-8-2 10:48:19
+* @Description: ConcordKV Raft consensus server - quota_test.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/statemachine"
+	"raftserver/storage"
+)
+
+// quotaUsageOf 前缀的用量，前缀没有配额时返回零值
+func quotaUsageOf(sm *statemachine.KVStateMachine, prefix string) statemachine.QuotaUsage {
+	for _, usage := range sm.QuotaUsage() {
+		if usage.Prefix == prefix {
+			return usage
+		}
+	}
+	return statemachine.QuotaUsage{}
+}
+
+// TestQuotaMaxKeys 命名空间写满后新键被拒绝，覆盖已有键与其他命名空间不受影响；删除与TTL过期清理后写入恢复
+func TestQuotaMaxKeys(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	now := time.Now()
+	index := raft.LogIndex(1)
+	apply := func(cmd statemachine.Command) error {
+		t.Helper()
+		_, err := applyCommandAt(t, sm, index, now, cmd)
+		index++
+		return err
+	}
+
+	// 设置配额时按现有数据统计用量
+	if err := apply(statemachine.Command{Type: "SET", Key: "ns/a", Value: "v"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := apply(statemachine.Command{Type: "QUOTA_SET", Quota: &statemachine.Quota{Prefix: "ns/", MaxKeys: 3}}); err != nil {
+		t.Fatalf("设置配额失败: %v", err)
+	}
+	if usage := quotaUsageOf(sm, "ns/"); usage.Keys != 1 || usage.Bytes != int64(len("ns/a")+len(`"v"`)) {
+		t.Fatalf("设置配额后的用量 = %+v", usage)
+	}
+
+	for _, key := range []string{"ns/b", "ns/c"} {
+		if err := apply(statemachine.Command{Type: "SET", Key: key, Value: "v"}); err != nil {
+			t.Fatalf("写入 %s 失败: %v", key, err)
+		}
+	}
+	if err := apply(statemachine.Command{Type: "SET", Key: "ns/d", Value: "v"}); !errors.Is(err, statemachine.ErrQuotaExceeded) {
+		t.Fatalf("超出键数配额的写入应被拒绝: %v", err)
+	}
+	if _, exists := sm.Get("ns/d"); exists {
+		t.Fatal("被拒绝的写入不应生效")
+	}
+	if err := apply(statemachine.Command{Type: "SET", Key: "ns/a", Value: "v2"}); err != nil {
+		t.Errorf("覆盖已有键不增加键数，应允许: %v", err)
+	}
+	if err := apply(statemachine.Command{Type: "SET", Key: "other", Value: "v"}); err != nil {
+		t.Errorf("配额之外的键不受限制: %v", err)
+	}
+
+	// 删除后写入恢复
+	if err := apply(statemachine.Command{Type: "DELETE", Key: "ns/a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := apply(statemachine.Command{Type: "SET", Key: "ns/d", Value: "v", TTLSeconds: 1}); err != nil {
+		t.Fatalf("删除后写入应恢复: %v", err)
+	}
+	if err := apply(statemachine.Command{Type: "SET", Key: "ns/e", Value: "v"}); !errors.Is(err, statemachine.ErrQuotaExceeded) {
+		t.Fatalf("再次写满后应拒绝: %v", err)
+	}
+
+	// 过期清理同样释放配额
+	if _, err := applyCommandAt(t, sm, index, now.Add(2*time.Second), statemachine.Command{Type: "EXPIRE", Keys: []string{"ns/d"}}); err != nil {
+		t.Fatal(err)
+	}
+	index++
+	if usage := quotaUsageOf(sm, "ns/"); usage.Keys != 2 {
+		t.Fatalf("过期清理后的键数 = %d, 期望 2", usage.Keys)
+	}
+	if err := apply(statemachine.Command{Type: "SET", Key: "ns/e", Value: "v"}); err != nil {
+		t.Fatalf("过期清理后写入应恢复: %v", err)
+	}
+
+	// 删除配额后不再限制
+	if err := apply(statemachine.Command{Type: "QUOTA_DELETE", Key: "ns/"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := apply(statemachine.Command{Type: "SET", Key: "ns/f", Value: "v"}); err != nil {
+		t.Errorf("删除配额后写入应允许: %v", err)
+	}
+}
+
+// TestQuotaMaxBytes 字节配额按键长加值的JSON编码长度计算；批量命令与事务按整体的用量变化检查，被拒绝时不执行任何操作
+func TestQuotaMaxBytes(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	now := time.Now()
+	index := raft.LogIndex(1)
+	apply := func(cmd statemachine.Command) (*statemachine.CommandResult, error) {
+		t.Helper()
+		result, err := applyCommandAt(t, sm, index, now, cmd)
+		index++
+		return result, err
+	}
+
+	if _, err := apply(statemachine.Command{Type: "QUOTA_SET", Quota: &statemachine.Quota{Prefix: "big/", MaxBytes: 30}}); err != nil {
+		t.Fatal(err)
+	}
+	// "big/k" + "\"0123456789\"" = 5 + 12 字节
+	if _, err := apply(statemachine.Command{Type: "SET", Key: "big/k", Value: "0123456789"}); err != nil {
+		t.Fatal(err)
+	}
+	if usage := quotaUsageOf(sm, "big/"); usage.Bytes != 17 {
+		t.Fatalf("用量 = %d 字节, 期望 17", usage.Bytes)
+	}
+	if _, err := apply(statemachine.Command{Type: "SET", Key: "big/k2", Value: "0123456789"}); !errors.Is(err, statemachine.ErrQuotaExceeded) {
+		t.Fatalf("超出字节配额的写入应被拒绝: %v", err)
+	}
+
+	// 整批的用量变化不超出配额时允许，其中某一步单独看会超出也无妨
+	batch := []statemachine.Command{
+		{Type: "SET", Key: "big/k2", Value: "0123456789"},
+		{Type: "DELETE", Key: "big/k"},
+	}
+	if _, err := apply(statemachine.Command{Type: "BATCH", Ops: batch}); err != nil {
+		t.Fatalf("整体不超出配额的批量命令应允许: %v", err)
+	}
+	if usage := quotaUsageOf(sm, "big/"); usage.Keys != 1 || usage.Bytes != 18 {
+		t.Fatalf("批量命令后的用量 = %+v", usage)
+	}
+
+	// 超出配额的批量命令整批拒绝
+	rejected := []statemachine.Command{
+		{Type: "SET", Key: "free", Value: "v"},
+		{Type: "SET", Key: "big/k3", Value: "0123456789"},
+	}
+	if _, err := apply(statemachine.Command{Type: "BATCH", Ops: rejected}); !errors.Is(err, statemachine.ErrQuotaExceeded) {
+		t.Fatalf("超出配额的批量命令应被拒绝: %v", err)
+	}
+	if _, exists := sm.Get("free"); exists {
+		t.Fatal("被拒绝的批量命令不应执行任何操作")
+	}
+
+	// 事务与CAS同样在执行前检查
+	txn := statemachine.Command{
+		Type:     "TXN",
+		Compares: []statemachine.TxnCompare{{Key: "big/k2", Target: statemachine.TxnTargetVersion, Op: ">", Version: 0}},
+		Ops:      []statemachine.Command{{Type: "SET", Key: "big/k2", Value: "012345678901234567890123456789"}},
+	}
+	if _, err := apply(txn); !errors.Is(err, statemachine.ErrQuotaExceeded) {
+		t.Fatalf("超出配额的事务应被拒绝: %v", err)
+	}
+	if _, err := apply(statemachine.Command{Type: "CAS", Key: "big/k4", Value: "0123456789"}); !errors.Is(err, statemachine.ErrQuotaExceeded) {
+		t.Fatalf("超出配额的CAS应被拒绝: %v", err)
+	}
+
+	// 配额调低到当前用量以下后，缩小值的写入仍然允许
+	if _, err := apply(statemachine.Command{Type: "QUOTA_SET", Quota: &statemachine.Quota{Prefix: "big/", MaxBytes: 10}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := apply(statemachine.Command{Type: "SET", Key: "big/k2", Value: "0"}); err != nil {
+		t.Errorf("缩小值的写入应允许: %v", err)
+	}
+	if usage := quotaUsageOf(sm, "big/"); usage.Bytes != 9 {
+		t.Errorf("缩小后的用量 = %d 字节, 期望 9", usage.Bytes)
+	}
+
+	for _, bad := range []*statemachine.Quota{nil, {}, {Prefix: "x/", MaxKeys: -1}} {
+		if _, err := apply(statemachine.Command{Type: "QUOTA_SET", Quota: bad}); err == nil {
+			t.Errorf("配额 %+v 应被拒绝", bad)
+		}
+	}
+}
+
+// TestQuotaSnapshotRestore 快照只保存配额定义，恢复后按数据重新统计的用量与增量维护的一致，嵌套前缀分别计数
+func TestQuotaSnapshotRestore(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	now := time.Now()
+	index := raft.LogIndex(1)
+	for _, cmd := range []statemachine.Command{
+		{Type: "QUOTA_SET", Quota: &statemachine.Quota{Prefix: "t/", MaxKeys: 10}},
+		{Type: "QUOTA_SET", Quota: &statemachine.Quota{Prefix: "t/logs/", MaxKeys: 2, MaxBytes: 1000}},
+		{Type: "SET", Key: "t/a", Value: map[string]interface{}{"n": 1.0}},
+		{Type: "SET", Key: "t/logs/1", Value: "line"},
+		{Type: "SET", Key: "t/logs/2", Value: "line", TTLSeconds: 60},
+		{Type: "DELETE", Key: "t/a"},
+		{Type: "SET", Key: "t/b", Value: []interface{}{"x", 2.0}},
+	} {
+		if _, err := applyCommandAt(t, sm, index, now, cmd); err != nil {
+			t.Fatalf("应用 %s 失败: %v", cmd.Type, err)
+		}
+		index++
+	}
+
+	before := sm.QuotaUsage()
+	if fmt.Sprint(before[0].Keys, before[1].Keys) != "3 2" {
+		t.Fatalf("嵌套前缀的键数 = %+v", before)
+	}
+
+	data, err := sm.CreateSnapshot()
+	if err != nil {
+		t.Fatalf("创建快照失败: %v", err)
+	}
+	restored := statemachine.NewKVStateMachine()
+	if err := restored.RestoreSnapshot(data); err != nil {
+		t.Fatalf("恢复快照失败: %v", err)
+	}
+	if after := restored.QuotaUsage(); fmt.Sprint(after) != fmt.Sprint(before) {
+		t.Fatalf("恢复后的用量 = %+v, 期望 %+v", after, before)
+	}
+
+	if _, err := applyCommandAt(t, restored, index, now, statemachine.Command{Type: "SET", Key: "t/logs/3", Value: "line"}); !errors.Is(err, statemachine.ErrQuotaExceeded) {
+		t.Fatalf("恢复后配额应继续生效: %v", err)
+	}
+}
+
+// adminRequest 发送管理请求并解析响应体
+func adminRequest(t *testing.T, s *Server, method, path string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	var resp *http.Response
+	if method == http.MethodPost {
+		resp = postJSON(t, s, path, body, nil)
+	} else {
+		req, err := http.NewRequest(method, apiURL(s, path), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp, err = http.DefaultClient.Do(req); err != nil {
+			t.Fatalf("请求 %s 失败: %v", path, err)
+		}
+	}
+	defer resp.Body.Close()
+	var out map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out
+}
+
+// fetchUsage 通过API查询前缀的用量
+func fetchUsage(t *testing.T, s *Server, prefix string) statemachine.QuotaUsage {
+	t.Helper()
+	resp, err := http.Get(apiURL(s, "/api/admin/usage"))
+	if err != nil {
+		t.Fatalf("请求用量失败: %v", err)
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Usage []statemachine.QuotaUsage `json:"usage"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&out) != nil {
+		t.Fatalf("用量请求的状态码 = %d", resp.StatusCode)
+	}
+	for _, usage := range out.Usage {
+		if usage.Prefix == prefix {
+			return usage
+		}
+	}
+	t.Fatalf("用量中没有前缀 %q: %+v", prefix, out.Usage)
+	return statemachine.QuotaUsage{}
+}
+
+// TestQuotaAPI 通过管理API设置配额，超出配额的写入返回507与QUOTA_EXCEEDED，删除后写入恢复；快照后重启节点用量不变
+func TestQuotaAPI(t *testing.T) {
+	dir := t.TempDir()
+	configure := func(config *ServerConfig) {
+		config.Storage = storage.BackendWAL
+		config.DataDir = dir
+	}
+	s := startSingleNodeWith(t, "node1", configure)
+
+	if status, out := adminRequest(t, s, http.MethodPost, "/api/admin/quota", map[string]interface{}{"prefix": "tenant/", "maxKeys": 2}); status != http.StatusOK {
+		t.Fatalf("设置配额的状态码 = %d: %v", status, out)
+	}
+	setKey(t, s, "tenant/1", "v", 0)
+	setKey(t, s, "tenant/2", "v", 0)
+
+	status, out := adminRequest(t, s, http.MethodPost, "/api/set", map[string]interface{}{"key": "tenant/3", "value": "v"})
+	if apiErr, _ := out["error"].(map[string]interface{}); status != http.StatusInsufficientStorage || apiErr["code"] != codeQuotaExceeded {
+		t.Fatalf("超出配额的写入: 状态码 = %d, 响应 = %v", status, out)
+	}
+
+	if status, _ := adminRequest(t, s, http.MethodDelete, "/api/delete?key=tenant/1", nil); status != http.StatusOK {
+		t.Fatalf("删除的状态码 = %d", status)
+	}
+	setKey(t, s, "tenant/3", "v", 0)
+	usage := fetchUsage(t, s, "tenant/")
+	if usage.Keys != 2 || usage.MaxKeys != 2 {
+		t.Fatalf("用量 = %+v", usage)
+	}
+
+	for _, bad := range []map[string]interface{}{{}, {"prefix": "x/", "maxBytes": -1}} {
+		if status, _ := adminRequest(t, s, http.MethodPost, "/api/admin/quota", bad); status != http.StatusBadRequest {
+			t.Errorf("配额 %v 的状态码 = %d, 期望 400", bad, status)
+		}
+	}
+	if status, _ := adminRequest(t, s, http.MethodDelete, "/api/admin/quota?prefix=none/", nil); status != http.StatusNotFound {
+		t.Errorf("删除不存在的配额的状态码 = %d, 期望 404", status)
+	}
+
+	// 快照后重启，用量由快照中的数据重新统计
+	if status, out := adminRequest(t, s, http.MethodPost, "/api/admin/snapshot", nil); status != http.StatusOK {
+		t.Fatalf("创建快照的状态码 = %d: %v", status, out)
+	}
+	s.Stop()
+
+	restarted := startSingleNodeWith(t, "node1", configure)
+	if got := fetchUsage(t, restarted, "tenant/"); got != usage {
+		t.Fatalf("重启后的用量 = %+v, 期望 %+v", got, usage)
+	}
+	if status, _ := adminRequest(t, restarted, http.MethodPost, "/api/set", map[string]interface{}{"key": "tenant/4", "value": "v"}); status != http.StatusInsufficientStorage {
+		t.Errorf("重启后超出配额的写入的状态码 = %d, 期望 507", status)
+	}
+}
@@ -0,0 +1,162 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-30 16:02:18
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-30 16:02:18
+* @Description: ConcordKV Raft consensus server - range.go
+ */
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"raftserver/statemachine"
+)
+
+// defaultDeleteRangeLimit 范围删除未指定limit时每个日志条目删除的键数
+const defaultDeleteRangeLimit = 1000
+
+// handleDeleteRange 处理范围删除请求，按前缀或[start, end)删除键
+// 每个请求作为一个日志条目删除最多limit个键，范围内还有剩余的键时返回游标，调用方携带游标继续删除；
+// 各批次之间写入的键：位于游标之后的会被后续批次删除，位于游标之前的保留
+func (s *Server) handleDeleteRange(w http.ResponseWriter, r *http.Request) {
+	if s.redirectWrite(w, r) {
+		return
+	}
+
+	var req struct {
+		Prefix string `json:"prefix"`
+		Start  string `json:"start"`
+		End    string `json:"end"`
+		Cursor string `json:"cursor"`
+		Limit  int    `json:"limit"`
+	}
+
+	s.limitBody(w, r, 1)
+	if !decodeLimited(w, r, &req) {
+		return
+	}
+
+	if req.Prefix != "" && (req.Start != "" || req.End != "") {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "prefix与start/end不能同时指定")
+		return
+	}
+
+	limit := req.Limit
+	switch {
+	case limit < 0:
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "limit参数无效")
+		return
+	case limit == 0:
+		limit = defaultDeleteRangeLimit
+	case limit > statemachine.MaxDeleteRangeKeys:
+		limit = statemachine.MaxDeleteRangeKeys
+	}
+
+	keyRange := statemachine.KeyRange{Prefix: req.Prefix, Start: req.Start, End: req.End}
+	if err := keyRange.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, err.Error())
+		return
+	}
+
+	// 游标是上一批次剩余的第一个键，从它开始继续删除
+	if req.Cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(req.Cursor)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, "cursor参数无效")
+			return
+		}
+		if next := string(decoded); next > keyRange.Start {
+			keyRange.Start = next
+		}
+	}
+
+	if !s.authorizeRange(w, r, keyRange, statemachine.ACLWrite) {
+		return
+	}
+
+	cmd := statemachine.Command{Type: "DELETE_RANGE", Range: &keyRange, Limit: limit}
+	if err := attachSession(r, &cmd); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, err.Error())
+		return
+	}
+	index, result, ok := s.proposeCommand(w, r, cmd)
+	if !ok {
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"deleted": result.Deleted,
+		"index":   index,
+		"hasMore": result.NextKey != "",
+	}
+	if result.NextKey != "" {
+		response["cursor"] = base64.RawURLEncoding.EncodeToString([]byte(result.NextKey))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleCount 处理前缀计数请求，只返回键数，不传输键或值
+func (s *Server) handleCount(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+
+	// 与扫描相同，非stale计数必须由领导者处理
+	stale := query.Get("stale") == "true"
+	if !stale && s.redirectToLeader(w, r) {
+		return
+	}
+	if stale && !s.checkCatchUp(w) {
+		return
+	}
+
+	if !s.authorizeRange(w, r, statemachine.KeyRange{Prefix: prefix}, statemachine.ACLRead) {
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"prefix":  prefix,
+		"count":   s.stateMachine.Count(prefix),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// authorizeRange 检查请求主体对范围内所有键的访问权限
+// [start, end)内的键都以start与end的公共前缀开头，按该前缀检查
+func (s *Server) authorizeRange(w http.ResponseWriter, r *http.Request, keyRange statemachine.KeyRange, need statemachine.ACLAccess) bool {
+	principal := principalFrom(r)
+	if principal == nil {
+		return true
+	}
+
+	prefix := keyRange.Prefix
+	if keyRange.End != "" {
+		if common := commonPrefix(keyRange.Start, keyRange.End); len(common) > len(prefix) {
+			prefix = common
+		}
+	}
+	if principal.Covers(prefix, need) {
+		return true
+	}
+
+	s.denyAccess(w, r, principal, fmt.Sprintf("前缀 %q 下的所有键", prefix), accessName(need))
+	return false
+}
+
+// commonPrefix 两个字符串的最长公共前缀
+func commonPrefix(a, b string) string {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return a[:n]
+}
@@ -0,0 +1,376 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-30 16:40:51
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-30 16:40:51
+* @Description: ConcordKV Raft consensus server - range_test.go
+ */
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// deleteRangeAt 应用一个DELETE_RANGE条目
+func deleteRangeAt(t *testing.T, sm *statemachine.KVStateMachine, index raft.LogIndex, keyRange statemachine.KeyRange, limit int) *statemachine.CommandResult {
+	t.Helper()
+	result, err := applyCommandAt(t, sm, index, time.Now(), statemachine.Command{Type: "DELETE_RANGE", Range: &keyRange, Limit: limit})
+	if err != nil {
+		t.Fatalf("应用范围删除失败: %v", err)
+	}
+	return result
+}
+
+// scanKeys 按前缀扫描出所有键
+func scanKeys(sm *statemachine.KVStateMachine, prefix string) []string {
+	entries, _ := sm.Scan(prefix, "", 1000)
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.Key
+	}
+	return keys
+}
+
+// TestDeleteRangeChunked 每个条目最多删除limit个键，凭NextKey分批删除完整个前缀，范围外的键不受影响
+func TestDeleteRangeChunked(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	now := time.Now()
+	index := raft.LogIndex(1)
+	for i := 0; i < 25; i++ {
+		applyCommandAt(t, sm, index, now, statemachine.Command{Type: "SET", Key: fmt.Sprintf("ns/%02d", i), Value: "v"})
+		index++
+	}
+	for _, key := range []string{"nr", "ns", "nt/0"} {
+		applyCommandAt(t, sm, index, now, statemachine.Command{Type: "SET", Key: key, Value: "v"})
+		index++
+	}
+	if count := sm.Count("ns/"); count != 25 {
+		t.Fatalf("Count(ns/) = %d, 期望 25", count)
+	}
+
+	keyRange := statemachine.KeyRange{Prefix: "ns/"}
+	var batches []int
+	for {
+		result := deleteRangeAt(t, sm, index, keyRange, 10)
+		index++
+		batches = append(batches, result.Deleted)
+		if result.NextKey == "" {
+			break
+		}
+		keyRange.Start = result.NextKey
+	}
+	if fmt.Sprint(batches) != "[10 10 5]" {
+		t.Fatalf("各批次删除的键数 = %v, 期望 [10 10 5]", batches)
+	}
+	if count := sm.Count("ns/"); count != 0 {
+		t.Errorf("删除后Count(ns/) = %d", count)
+	}
+	if keys := scanKeys(sm, "n"); fmt.Sprint(keys) != "[nr ns nt/0]" {
+		t.Errorf("范围外的键应保留，实际 %v", keys)
+	}
+
+	// [start, end)范围不包含end，limit为0时使用上限
+	result := deleteRangeAt(t, sm, index, statemachine.KeyRange{Start: "nr", End: "nt"}, 0)
+	if result.Deleted != 2 || result.NextKey != "" {
+		t.Errorf("范围删除结果不正确: %+v", result)
+	}
+	if keys := scanKeys(sm, "n"); fmt.Sprint(keys) != "[nt/0]" {
+		t.Errorf("范围删除后剩余的键 = %v", keys)
+	}
+
+	// 没有前缀与上界的范围被拒绝，不会清空整个键空间
+	if _, err := applyCommandAt(t, sm, index+1, now, statemachine.Command{Type: "DELETE_RANGE", Range: &statemachine.KeyRange{}}); err == nil {
+		t.Errorf("空范围应被拒绝")
+	}
+	if err := validateCommand(&statemachine.Command{Type: "DELETE_RANGE", Range: &statemachine.KeyRange{Start: "b", End: "a"}}); err == nil {
+		t.Errorf("start不小于end的范围应被拒绝")
+	}
+}
+
+// TestDeleteRangeInterleavedWrites 批次之间写入的键：位于游标之后的被后续批次删除，之前的保留，有序索引保持一致
+func TestDeleteRangeInterleavedWrites(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	now := time.Now()
+	index := raft.LogIndex(1)
+	set := func(key string) {
+		applyCommandAt(t, sm, index, now, statemachine.Command{Type: "SET", Key: key, Value: "v"})
+		index++
+	}
+	for i := 0; i < 20; i++ {
+		set(fmt.Sprintf("ns/%02d", i))
+	}
+	// 已过期但尚未清理的键被删除，但不计入删除数
+	applyCommandAt(t, sm, index, now.Add(-time.Hour), statemachine.Command{Type: "SET", Key: "ns/expired", Value: "v", TTLSeconds: 1})
+	index++
+
+	keyRange := statemachine.KeyRange{Prefix: "ns/"}
+	first := deleteRangeAt(t, sm, index, keyRange, 8)
+	index++
+	if first.Deleted != 8 || first.NextKey != "ns/08" {
+		t.Fatalf("第一批结果不正确: %+v", first)
+	}
+
+	// 游标之前重新写入的键保留，游标之后新增或更新的键随后续批次删除
+	set("ns/03")
+	set("ns/10")
+	set("ns/50")
+	if keys := scanKeys(sm, "ns/"); len(keys) != 14 || keys[0] != "ns/03" {
+		t.Fatalf("批次之间扫描到的键不正确: %v", keys)
+	}
+
+	deleted := first.Deleted
+	for keyRange.Start = first.NextKey; keyRange.Start != ""; index++ {
+		result := deleteRangeAt(t, sm, index, keyRange, 8)
+		deleted += result.Deleted
+		keyRange.Start = result.NextKey
+	}
+	if deleted != 21 {
+		t.Errorf("共删除 %d 个键，期望 21（过期键不计入）", deleted)
+	}
+	if keys := scanKeys(sm, "ns/"); fmt.Sprint(keys) != "[ns/03]" {
+		t.Errorf("删除完成后剩余的键 = %v, 期望 [ns/03]", keys)
+	}
+	if count := sm.Count("ns/"); count != 1 {
+		t.Errorf("Count(ns/) = %d, 期望 1", count)
+	}
+}
+
+// TestScanOrderedIndexIncremental 大量键的随机写入与删除跨越索引块的拆分与清空，分页扫描、计数与范围删除
+// 始终与期望的有序键集合一致，快照恢复后重建的索引相同
+func TestScanOrderedIndexIncremental(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	now := time.Now()
+	index := raft.LogIndex(1)
+	rng := rand.New(rand.NewSource(1))
+	expected := make(map[string]bool)
+
+	// pagedKeys 每页limit个键分页扫描前缀
+	pagedKeys := func(sm *statemachine.KVStateMachine, prefix string, limit int) []string {
+		var keys []string
+		after := ""
+		for {
+			entries, more := sm.Scan(prefix, after, limit)
+			for _, entry := range entries {
+				keys = append(keys, entry.Key)
+			}
+			if !more {
+				return keys
+			}
+			after = entries[len(entries)-1].Key
+		}
+	}
+	check := func(sm *statemachine.KVStateMachine) {
+		t.Helper()
+		want := make([]string, 0, len(expected))
+		for key := range expected {
+			want = append(want, key)
+		}
+		sort.Strings(want)
+		if got := pagedKeys(sm, "k/", 97); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("分页扫描得到 %d 个键，期望 %d 个，顺序或内容不一致", len(got), len(want))
+		}
+		if count := sm.Count("k/"); count != len(want) {
+			t.Fatalf("Count(k/) = %d, 期望 %d", count, len(want))
+		}
+	}
+
+	for round := 0; round < 6; round++ {
+		for i := 0; i < 800; i++ {
+			key := fmt.Sprintf("k/%05d", rng.Intn(4000))
+			if rng.Intn(3) == 0 {
+				applyCommandAt(t, sm, index, now, statemachine.Command{Type: "DELETE", Key: key})
+				delete(expected, key)
+			} else {
+				applyCommandAt(t, sm, index, now, statemachine.Command{Type: "SET", Key: key, Value: "v"})
+				expected[key] = true
+			}
+			index++
+		}
+		check(sm)
+	}
+
+	// 范围删除中间的一段键
+	keyRange := statemachine.KeyRange{Prefix: "k/", Start: "k/01000", End: "k/03000"}
+	for {
+		result := deleteRangeAt(t, sm, index, keyRange, 300)
+		index++
+		if result.NextKey == "" {
+			break
+		}
+		keyRange.Start = result.NextKey
+	}
+	for key := range expected {
+		if key >= "k/01000" && key < "k/03000" {
+			delete(expected, key)
+		}
+	}
+	check(sm)
+
+	data, err := sm.CreateSnapshot()
+	if err != nil {
+		t.Fatalf("创建快照失败: %v", err)
+	}
+	restored := statemachine.NewKVStateMachine()
+	if err := restored.RestoreSnapshot(data); err != nil {
+		t.Fatalf("恢复快照失败: %v", err)
+	}
+	check(restored)
+}
+
+// postDeleteRange 通过API删除一批键
+func postDeleteRange(t *testing.T, s *Server, body map[string]interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	resp, err := http.Post(apiURL(s, "/api/delete-range"), "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("请求范围删除失败: %v", err)
+	}
+	defer resp.Body.Close()
+	var out map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out
+}
+
+// fetchCount 通过API统计前缀下的键数
+func fetchCount(t *testing.T, s *Server, prefix string) int {
+	t.Helper()
+	resp, err := http.Get(apiURL(s, "/api/count?prefix="+url.QueryEscape(prefix)))
+	if err != nil {
+		t.Fatalf("请求计数失败: %v", err)
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Count int `json:"count"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&out) != nil {
+		t.Fatalf("计数请求的状态码 = %d", resp.StatusCode)
+	}
+	return out.Count
+}
+
+// TestDeleteRangeAPI 凭游标分批删除前缀，期间其他前缀的并发写入不受影响
+func TestDeleteRangeAPI(t *testing.T) {
+	s := startSingleNode(t, "node1", "")
+	for i := 0; i < 30; i++ {
+		setKey(t, s, fmt.Sprintf("tmp/%02d", i), "v", 0)
+	}
+	setKey(t, s, "tmq", "v", 0)
+	if count := fetchCount(t, s, "tmp/"); count != 30 {
+		t.Fatalf("删除前计数 = %d, 期望 30", count)
+	}
+
+	const writers, writes = 4, 10
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				// 非测试协程中不能调用t.Fatalf，失败时只记录错误
+				body, _ := json.Marshal(map[string]interface{}{"key": fmt.Sprintf("keep/%d/%d", w, i), "value": "v"})
+				resp, err := http.Post(apiURL(s, "/api/set"), "application/json", bytes.NewReader(body))
+				if err != nil {
+					t.Errorf("并发写入失败: %v", err)
+					return
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Errorf("并发写入的状态码 = %d", resp.StatusCode)
+				}
+			}
+		}(w)
+	}
+
+	deleted, batches := 0, 0
+	body := map[string]interface{}{"prefix": "tmp/", "limit": 7}
+	for {
+		status, out := postDeleteRange(t, s, body)
+		if status != http.StatusOK {
+			t.Fatalf("范围删除的状态码 = %d: %v", status, out)
+		}
+		deleted += int(out["deleted"].(float64))
+		batches++
+		if out["hasMore"] != true {
+			break
+		}
+		body["cursor"] = out["cursor"]
+	}
+	wg.Wait()
+
+	if deleted != 30 || batches != 5 {
+		t.Errorf("分 %d 批删除 %d 个键，期望5批30个", batches, deleted)
+	}
+	if count := fetchCount(t, s, "tmp/"); count != 0 {
+		t.Errorf("删除后计数 = %d", count)
+	}
+	if count := fetchCount(t, s, "tm"); count != 1 {
+		t.Errorf("前缀外的键应保留，计数 = %d", count)
+	}
+	if count := fetchCount(t, s, "keep/"); count != writers*writes {
+		t.Errorf("并发写入的键计数 = %d, 期望 %d", count, writers*writes)
+	}
+
+	for _, bad := range []map[string]interface{}{
+		{},
+		{"prefix": "tmp/", "end": "z"},
+		{"start": "b", "end": "a"},
+		{"prefix": "tmp/", "limit": -1},
+		{"prefix": "tmp/", "cursor": "!"},
+	} {
+		if status, _ := postDeleteRange(t, s, bad); status != http.StatusBadRequest {
+			t.Errorf("请求 %v 的状态码 = %d, 期望 400", bad, status)
+		}
+	}
+}
+
+// TestCountACL 计数与范围删除要求对整个前缀具有权限，只覆盖其中部分键的规则不够
+func TestCountACL(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	config := &ServerConfig{ACL: ACLConfig{Enabled: true, Tokens: []string{"root:root-secret:admin", "team:t-secret:r=teamA/*"}}}
+	staticACL, err := loadStaticACL(config)
+	if err != nil {
+		t.Fatalf("加载ACL失败: %v", err)
+	}
+	s := &Server{config: config, stateMachine: sm, staticACL: staticACL, logger: logging.Nop()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/count", s.handleCount)
+	handler := s.authenticate(mux)
+
+	for target, code := range map[string]int{
+		"/api/count?prefix=teamA/&stale=true":  http.StatusOK,
+		"/api/count?prefix=teamA/x&stale=true": http.StatusOK,
+		"/api/count?prefix=team&stale=true":    http.StatusForbidden,
+		"/api/count?prefix=&stale=true":        http.StatusForbidden,
+	} {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Authorization", "Bearer t-secret")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != code {
+			t.Errorf("请求 %s 的状态码 = %d, 期望 %d", target, recorder.Code, code)
+		}
+	}
+
+	// [start, end)按公共前缀检查
+	token := &statemachine.ACLToken{Rules: []statemachine.ACLRule{{Pattern: "teamA/*", Access: statemachine.ACLWrite}}}
+	if !token.Covers(commonPrefix("teamA/a", "teamA/b"), statemachine.ACLWrite) {
+		t.Errorf("teamA/*规则应覆盖[teamA/a, teamA/b)")
+	}
+	if token.Covers(commonPrefix("teamA/a", "teamB"), statemachine.ACLWrite) {
+		t.Errorf("teamA/*规则不应覆盖[teamA/a, teamB)")
+	}
+}
@@ -0,0 +1,122 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-31 09:42:26
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-31 09:42:26
+* @Description: ConcordKV Raft consensus server - readiness.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"raftserver/raft"
+)
+
+// defaultCatchUpMaxWait 启动追赶门控允许降级读之前的默认等待时间
+const defaultCatchUpMaxWait = time.Minute
+
+// eventCaughtUp 节点追上启动时的集群提交索引
+const eventCaughtUp = "caught_up"
+
+// readinessGate 启动追赶门控：节点重启后本地状态可能远远落后于集群，
+// 追上启动时得知的提交索引之前拒绝stale读，避免客户端读到很旧的数据而不自知
+// 就绪后不再回到未就绪，正常运行中的复制延迟由lastApplied与leaderCommit反映
+type readinessGate struct {
+	startedAt time.Time
+	ready     atomic.Bool
+	degraded  atomic.Bool
+}
+
+// catchUpStatus 启动追赶的进度
+type catchUpStatus struct {
+	Ready     bool          // 可以提供stale读：已追上，或已超过最长等待且允许降级读
+	Degraded  bool          // 未追上但因允许降级读而提供stale读
+	Known     bool          // 是否已得知启动时的集群提交索引
+	Target    raft.LogIndex // 启动时的集群提交索引
+	Remaining raft.LogIndex // 距追上还需应用的条目数，未得知目标时为0
+}
+
+// catchUpStatus 返回启动追赶的进度，首次追上时记录日志与事件
+func (s *Server) catchUpStatus() catchUpStatus {
+	if s.raftNode == nil || s.readiness.ready.Load() {
+		return catchUpStatus{Ready: true, Known: true}
+	}
+
+	target, known := s.raftNode.BootCommitIndex()
+	status := catchUpStatus{Known: known, Target: target}
+	if known {
+		if applied := s.raftNode.LastApplied(); applied < target {
+			status.Remaining = target - applied
+		}
+		if status.Remaining <= raft.LogIndex(s.config.CatchUpSlack) {
+			if s.readiness.ready.CompareAndSwap(false, true) {
+				waited := time.Since(s.readiness.startedAt)
+				s.logger.Info("已追上启动时的提交索引，开始提供stale读", "target", target, "waited", waited)
+				s.events.record(eventCaughtUp, map[string]interface{}{"target": target, "waitedMs": waited.Milliseconds()})
+			}
+			return catchUpStatus{Ready: true, Known: true, Target: target}
+		}
+	}
+
+	if s.config.AllowDegradedReads && time.Since(s.readiness.startedAt) >= s.config.CatchUpMaxWait {
+		status.Ready, status.Degraded = true, true
+		if s.readiness.degraded.CompareAndSwap(false, true) {
+			s.logger.Warn("等待追赶超时，以降级状态提供stale读", "target", target, "remaining", status.Remaining, "known", known)
+		}
+	}
+	return status
+}
+
+// checkCatchUp 节点尚未追上启动时的提交索引时以503拒绝本地读取，返回是否可以继续处理
+func (s *Server) checkCatchUp(w http.ResponseWriter) bool {
+	status := s.catchUpStatus()
+	if status.Ready {
+		return true
+	}
+
+	message := "节点正在获取集群的提交索引，暂不提供stale读"
+	if status.Known {
+		message = fmt.Sprintf("节点正在追赶启动时的提交索引 %d，还需应用 %d 条，暂不提供stale读", status.Target, status.Remaining)
+	}
+	w.Header().Set("Retry-After", "1")
+	writeAPIError(w, http.StatusServiceUnavailable, apiError{Code: codeCatchingUp, Message: message, RaftIndex: s.stateMachine.AppliedIndex()})
+	return false
+}
+
+// catchUpFields /api/status与/api/health中的追赶进度，未得知目标时省略catchUpRemaining
+func (s *Server) catchUpFields(response map[string]interface{}) catchUpStatus {
+	status := s.catchUpStatus()
+	response["ready"] = status.Ready
+	if status.Known {
+		response["catchUpRemaining"] = status.Remaining
+	}
+	if status.Degraded {
+		response["degraded"] = true
+	}
+	return status
+}
+
+// handleHealth 处理健康检查请求：能提供stale读且未排空时返回200，否则返回503，供编排系统决定是否导入流量
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	response := map[string]interface{}{"nodeId": s.config.NodeID}
+	status := s.catchUpFields(response)
+
+	draining := s.draining.Load()
+	if draining {
+		response["ready"] = false
+		response["draining"] = true
+	}
+	code := http.StatusOK
+	if !status.Ready || draining {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
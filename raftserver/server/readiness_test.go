@@ -0,0 +1,208 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-31 10:55:03
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-31 10:55:03
+* @Description: ConcordKV Raft consensus server - readiness_test.go
+ */
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"testing"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/raft"
+	"raftserver/statemachine"
+	"raftserver/storage"
+)
+
+// startIdleFollower 启动两节点集群中的跟随者，另一个节点不存在，由测试直接调用RPC处理器扮演领导者
+func startIdleFollower(t *testing.T, configure func(*ServerConfig)) *Server {
+	t.Helper()
+	addr := freeAddr(t)
+	config := &ServerConfig{
+		NodeID:            "node2",
+		ListenAddr:        addr,
+		APIAddr:           freeAddr(t),
+		ElectionTimeout:   time.Minute,
+		HeartbeatInterval: time.Second,
+		MaxLogEntries:     100,
+		SnapshotThreshold: 1000,
+		Peers:             map[raft.NodeID]string{"node1": freeAddr(t), "node2": addr},
+		Storage:           storage.BackendMemory,
+		AllowVolatile:     true,
+		Logger:            logging.Nop(),
+	}
+	configure(config)
+	s, err := NewServerWithConfig(config)
+	if err != nil {
+		t.Fatalf("创建服务器失败: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务器失败: %v", err)
+	}
+	t.Cleanup(func() { s.Stop() })
+	return s
+}
+
+// fetchHealth 请求/api/health，返回状态码与响应体
+func fetchHealth(t *testing.T, s *Server) (int, map[string]interface{}) {
+	t.Helper()
+	resp, err := http.Get(apiURL(s, "/api/health"))
+	if err != nil {
+		t.Fatalf("请求健康检查失败: %v", err)
+	}
+	defer resp.Body.Close()
+	var out map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out
+}
+
+// staleGet 以stale一致性读取键，返回状态码与错误码
+func staleGet(t *testing.T, s *Server, key string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(apiURL(s, "/api/get?consistency=stale&key="+key))
+	if err != nil {
+		t.Fatalf("读取 %s 失败: %v", key, err)
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out.Error.Code
+}
+
+// setEntries 生成写入k<index>的日志条目
+func setEntries(t *testing.T, from, to raft.LogIndex) []raft.LogEntry {
+	t.Helper()
+	var entries []raft.LogEntry
+	for i := from; i <= to; i++ {
+		data, err := statemachine.CreateSetCommand(fmt.Sprintf("k%02d", i), "v")
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, raft.LogEntry{Index: i, Term: 1, Timestamp: time.Now(), Type: raft.EntryNormal, Data: data})
+	}
+	return entries
+}
+
+// TestCatchUpGate 带着旧快照加入的节点在已应用索引距启动时的提交索引不超过slack之前不就绪，追上的那一刻转为就绪
+func TestCatchUpGate(t *testing.T) {
+	s := startIdleFollower(t, func(config *ServerConfig) { config.CatchUpSlack = 5 })
+
+	// 尚未收到领导者的消息，不知道集群进度
+	if code, body := fetchHealth(t, s); code != http.StatusServiceUnavailable || body["ready"] != false || body["catchUpRemaining"] != nil {
+		t.Fatalf("启动后的健康检查 = %d %v", code, body)
+	}
+	if code, errCode := staleGet(t, s, "k01"); code != http.StatusServiceUnavailable || errCode != codeCatchingUp {
+		t.Fatalf("未就绪时stale读 = %d %s", code, errCode)
+	}
+
+	// 领导者发来索引10处的旧快照
+	old := statemachine.NewKVStateMachine()
+	for i, entry := range setEntries(t, 1, 10) {
+		entry := entry
+		if err := old.Apply(&entry); err != nil {
+			t.Fatalf("应用第 %d 条失败: %v", i, err)
+		}
+	}
+	data, err := old.CreateSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	configuration := raft.Configuration{Servers: []raft.Server{{ID: "node1", Address: s.config.Peers["node1"]}, {ID: "node2", Address: s.config.Peers["node2"]}}}
+	resp := s.HandleInstallSnapshot(&raft.InstallSnapshotRequest{
+		Term: 1, LeaderID: "node1", LastIncludedIndex: 10, LastIncludedTerm: 1, Configuration: configuration,
+		Data: data, Done: true, TotalSize: int64(len(data)),
+		ChunkChecksum: crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)), SnapshotHash: hex.EncodeToString(sum[:]),
+	})
+	if !resp.Installed {
+		t.Fatalf("安装快照失败: %+v", resp)
+	}
+
+	// 第一个追加请求带来领导者的提交索引30，之后的请求不再改变追赶目标
+	appendEntries := func(prev raft.LogIndex, entries []raft.LogEntry, leaderCommit raft.LogIndex) {
+		t.Helper()
+		resp := s.HandleAppendEntries(&raft.AppendEntriesRequest{
+			Term: 1, LeaderID: "node1", PrevLogIndex: prev, PrevLogTerm: 1, Entries: entries, LeaderCommit: leaderCommit,
+		})
+		if !resp.Success {
+			t.Fatalf("追加日志失败: %+v", resp)
+		}
+		last := prev + raft.LogIndex(len(entries))
+		deadline := time.Now().Add(5 * time.Second)
+		for s.raftNode.LastApplied() < last {
+			if time.Now().After(deadline) {
+				t.Fatalf("未应用到索引 %d", last)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	steps := []struct {
+		prev, last   raft.LogIndex
+		leaderCommit raft.LogIndex
+		remaining    float64
+		ready        bool
+	}{
+		{10, 20, 30, 10, false},
+		{20, 24, 31, 6, false},
+		{24, 25, 40, 0, true}, // 剩余条数等于slack时就绪，之后报告0
+		{25, 26, 100, 0, true},
+	}
+	for _, step := range steps {
+		appendEntries(step.prev, setEntries(t, step.prev+1, step.last), step.leaderCommit)
+
+		code, body := fetchHealth(t, s)
+		if body["ready"] != step.ready || body["catchUpRemaining"] != step.remaining {
+			t.Fatalf("应用到 %d 后的健康检查 = %d %v", step.last, code, body)
+		}
+		getCode, _ := staleGet(t, s, "k01")
+		if step.ready && (code != http.StatusOK || getCode != http.StatusOK) {
+			t.Fatalf("应用到 %d 后应就绪: health=%d get=%d", step.last, code, getCode)
+		}
+		if !step.ready && (code != http.StatusServiceUnavailable || getCode != http.StatusServiceUnavailable) {
+			t.Fatalf("应用到 %d 后不应就绪: health=%d get=%d", step.last, code, getCode)
+		}
+	}
+
+	events, _ := s.events.since(0, map[string]bool{eventCaughtUp: true})
+	if len(events) != 1 {
+		t.Errorf("caught_up事件数 = %d, 期望 1", len(events))
+	}
+}
+
+// TestCatchUpDegradedReads 超过最长等待后，只有允许降级读时才恢复stale读
+func TestCatchUpDegradedReads(t *testing.T) {
+	strict := startIdleFollower(t, func(config *ServerConfig) { config.CatchUpMaxWait = 300 * time.Millisecond })
+	degraded := startIdleFollower(t, func(config *ServerConfig) {
+		config.CatchUpMaxWait = 300 * time.Millisecond
+		config.AllowDegradedReads = true
+	})
+
+	if code, _ := staleGet(t, degraded, "k01"); code != http.StatusServiceUnavailable {
+		t.Fatalf("最长等待之前stale读的状态码 = %d", code)
+	}
+	time.Sleep(400 * time.Millisecond)
+
+	if code, errCode := staleGet(t, strict, "k01"); code != http.StatusServiceUnavailable || errCode != codeCatchingUp {
+		t.Errorf("未允许降级读时stale读 = %d %s", code, errCode)
+	}
+	if code, _ := staleGet(t, degraded, "k01"); code != http.StatusNotFound {
+		t.Errorf("允许降级读后stale读的状态码 = %d, 期望 404", code)
+	}
+	if code, body := fetchHealth(t, degraded); code != http.StatusOK || body["degraded"] != true {
+		t.Errorf("降级状态的健康检查 = %d %v", code, body)
+	}
+}
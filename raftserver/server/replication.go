@@ -0,0 +1,197 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-20 10:24:36
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-20 10:24:36
+* @Description: ConcordKV Raft consensus server - replication.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/replication"
+)
+
+// eventReplicationLag 跨DC异步复制延迟告警级别变化的事件类型
+const eventReplicationLag = "replication_lag"
+
+// replicationController 跨DC异步复制管理器中人工暂停、恢复复制和查询状态的操作
+type replicationController interface {
+	PauseReplication(dcID raft.DataCenterID) error
+	ResumeReplication(dcID raft.DataCenterID) error
+	GetReplicationStatus() map[raft.DataCenterID]*replication.AsyncReplicationTarget
+}
+
+// connectionStateNames 复制目标连接状态在API中的名称
+var connectionStateNames = map[replication.ConnectionState]string{
+	replication.ConnectionHealthy:    "healthy",
+	replication.ConnectionDegraded:   "degraded",
+	replication.ConnectionFailed:     "failed",
+	replication.ConnectionRecovering: "recovering",
+}
+
+// replicationTarget 复制目标DC状态的API表示
+type replicationTarget struct {
+	DataCenter          raft.DataCenterID    `json:"dataCenter"`
+	IsPrimary           bool                 `json:"isPrimary"`
+	Paused              bool                 `json:"paused"`
+	PausedAt            *time.Time           `json:"pausedAt,omitempty"`
+	Healthy             bool                 `json:"healthy"`
+	ConnectionState     string               `json:"connectionState"`
+	LastReplicatedIndex raft.LogIndex        `json:"lastReplicatedIndex"`
+	PendingEntries      int                  `json:"pendingEntries"`
+	PendingBatches      int                  `json:"pendingBatches"`
+	LagEntries          int64                `json:"lagEntries"`
+	PendingLagMs        int64                `json:"pendingLagMs"`
+	ReplicationLagMs    int64                `json:"replicationLagMs"`
+	LagLevel            replication.LagLevel `json:"lagLevel"`
+}
+
+// SetAsyncReplicator 设置跨DC异步复制管理器，之后才能通过API暂停、恢复向各DC的复制；
+// 复制延迟告警级别的变化记录到事件日志
+func (s *Server) SetAsyncReplicator(replicator *replication.AsyncReplicator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replication = nil
+	if replicator != nil {
+		replicator.SetLagAlertHandler(func(alert replication.LagAlert) {
+			s.events.record(eventReplicationLag, alert)
+		})
+		s.replication = replicator
+	}
+}
+
+// asyncReplicator 获取跨DC异步复制管理器，未配置时返回503
+func (s *Server) asyncReplicator(w http.ResponseWriter) replicationController {
+	s.mu.RLock()
+	replicator := s.replication
+	s.mu.RUnlock()
+
+	if replicator == nil {
+		http.Error(w, "未启用跨DC异步复制", http.StatusServiceUnavailable)
+	}
+	return replicator
+}
+
+// replicationTargets 按DC排序的复制目标状态
+func replicationTargets(replicator replicationController) []replicationTarget {
+	status := replicator.GetReplicationStatus()
+	targets := make([]replicationTarget, 0, len(status))
+	for _, target := range status {
+		item := replicationTarget{
+			DataCenter:          target.DataCenter,
+			IsPrimary:           target.IsPrimary,
+			Paused:              target.Paused,
+			Healthy:             target.IsHealthy,
+			ConnectionState:     connectionStateNames[target.ConnectionState],
+			LastReplicatedIndex: target.LastReplicatedIndex,
+			PendingEntries:      len(target.PendingEntries),
+			PendingBatches:      target.PendingBatches,
+			LagEntries:          target.LagEntries,
+			PendingLagMs:        target.PendingLag.Milliseconds(),
+			ReplicationLagMs:    target.ReplicationLag.Milliseconds(),
+			LagLevel:            target.LagLevel,
+		}
+		if target.Paused {
+			pausedAt := target.PausedAt
+			item.PausedAt = &pausedAt
+		}
+		targets = append(targets, item)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].DataCenter < targets[j].DataCenter })
+	return targets
+}
+
+// handleReplicationStatus 列出各复制目标DC的进度、延迟告警级别与暂停状态
+func (s *Server) handleReplicationStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	replicator := s.asyncReplicator(w)
+	if replicator == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"targets": replicationTargets(replicator),
+	})
+}
+
+// handleReplicationPause 暂停向DC发送复制批次，条目继续缓冲
+func (s *Server) handleReplicationPause(w http.ResponseWriter, r *http.Request) {
+	s.controlReplication(w, r, replicationController.PauseReplication)
+}
+
+// handleReplicationResume 恢复向DC发送复制批次，暂停期间缓冲的条目按顺序发出
+func (s *Server) handleReplicationResume(w http.ResponseWriter, r *http.Request) {
+	s.controlReplication(w, r, replicationController.ResumeReplication)
+}
+
+// controlReplication 解析 {"dc": ...} 请求并暂停或恢复向该DC的复制，返回该DC的复制状态
+func (s *Server) controlReplication(w http.ResponseWriter, r *http.Request,
+	control func(replicator replicationController, dcID raft.DataCenterID) error) {
+	if r.Method != "POST" {
+		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		DC raft.DataCenterID `json:"dc"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "解析请求失败", http.StatusBadRequest)
+		return
+	}
+	if req.DC == "" {
+		http.Error(w, "dc不能为空", http.StatusBadRequest)
+		return
+	}
+
+	replicator := s.asyncReplicator(w)
+	if replicator == nil {
+		return
+	}
+
+	if err := control(replicator, req.DC); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, replication.ErrReplicationTargetNotFound) {
+			status = http.StatusNotFound
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	response := map[string]interface{}{"success": true, "dc": req.DC}
+	for _, target := range replicationTargets(replicator) {
+		if target.DataCenter == req.DC {
+			response["target"] = target
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,85 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-8-3 14:20:51
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-8-3 14:20:51
+* @Description: ConcordKV Raft consensus server - replication_receiver.go
+ */
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"raftserver/raft"
+	"raftserver/replication"
+	"raftserver/statemachine"
+)
+
+// errReceiverDisabled 未配置server.replicationSources时拒绝其他DC发来的复制批次
+var errReceiverDisabled = errors.New("未启用跨DC复制接收")
+
+// replicatedApplier 通过Raft应用其他DC的条目，高水位保存在状态机中，随快照持久化
+type replicatedApplier struct {
+	s *Server
+}
+
+// ReplicatedIndex 本节点状态机中源DC的高水位，落后于领导者时REPLICATE命令会跳过已应用的条目
+func (a replicatedApplier) ReplicatedIndex(source raft.DataCenterID) (raft.LogIndex, raft.Term, error) {
+	mark := a.s.stateMachine.ReplicationMark(source)
+	return mark.Index, mark.Term, nil
+}
+
+// ApplyReplicated 把条目作为一条REPLICATE命令提交，跟随者在启用写转发时转发给领导者
+func (a replicatedApplier) ApplyReplicated(ctx context.Context, source raft.DataCenterID, entries []raft.LogEntry) error {
+	_, _, err := a.s.submitCommand(ctx, statemachine.Command{
+		Type:       "REPLICATE",
+		Replicated: &statemachine.ReplicatedBatch{SourceDC: source, Entries: entries},
+	})
+	return err
+}
+
+// newReplicationReceiver 按配置创建跨DC复制接收端，未配置源DC时返回nil
+func newReplicationReceiver(server *Server, config *ServerConfig, raftConfig *raft.Config) *replication.ReplicationReceiver {
+	if len(config.ReplicationSources) == 0 {
+		return nil
+	}
+	receiverConfig := replication.DefaultReceiverConfig()
+	receiverConfig.LocalDataCenter = config.DataCenter
+	receiverConfig.Sources = config.ReplicationSources
+	return replication.NewReplicationReceiver(config.NodeID, receiverConfig, raftConfig, replicatedApplier{s: server})
+}
+
+// HandleCompressedAppendEntries 实现transport.CompressedAppendEntriesHandler，接收其他DC发来的复制批次
+func (s *Server) HandleCompressedAppendEntries(req *raft.CompressedAppendEntriesRequest) (*raft.CompressedAppendEntriesResponse, error) {
+	if s.receiver == nil {
+		return nil, errReceiverDisabled
+	}
+	return s.receiver.HandleCompressedAppendEntries(req)
+}
+
+// handleReplicationInbound 列出各源DC的复制高水位与本节点接收端的统计
+func (s *Server) handleReplicationInbound(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"enabled": s.receiver != nil,
+		"marks":   s.stateMachine.ReplicationMarks(),
+	}
+	if s.receiver != nil {
+		response["sources"] = s.receiver.Stats()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
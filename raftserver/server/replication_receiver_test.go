@@ -0,0 +1,169 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-8-3 14:20:51
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-8-3 14:20:51
+* @Description: ConcordKV Raft consensus server - replication_receiver_test.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// sourceEntry 源DC中携带命令的日志条目
+func sourceEntry(t *testing.T, index raft.LogIndex, ts time.Time, cmd statemachine.Command) raft.LogEntry {
+	t.Helper()
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		t.Fatalf("序列化命令失败: %v", err)
+	}
+	return raft.LogEntry{Index: index, Term: 3, Timestamp: ts, Type: raft.EntryNormal, Data: data}
+}
+
+// TestReplicateCommand REPLICATE按源DC的高水位跳过已应用的条目、拒绝空洞；只应用修改键值的命令，
+// 过期时间按源条目的时间戳计算，高水位随快照恢复
+func TestReplicateCommand(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	sourceTime := time.Now().Add(-5 * time.Second)
+	expected := "1"
+
+	entries := []raft.LogEntry{
+		sourceEntry(t, 1, sourceTime, statemachine.Command{Type: "SET", Key: "a", Value: "1", TTLSeconds: 60, RequestID: "req-1"}),
+		sourceEntry(t, 2, sourceTime, statemachine.Command{Type: "SESSION_REGISTER", SessionID: "s1", SessionTimeoutMs: 1000}),
+		sourceEntry(t, 3, sourceTime, statemachine.Command{Type: "CAS", Key: "a", Expected: &expected, Value: "2", SessionID: "s1", SeqNum: 1}),
+		{Index: 4, Term: 3, Type: raft.EntryNoop},
+		{Index: 5, Term: 3, Type: raft.EntryNormal, Data: []byte("{")},
+		sourceEntry(t, 6, sourceTime, statemachine.Command{Type: "SET", Key: "b", Value: "x"}),
+	}
+	replicate := func(index raft.LogIndex, batch []raft.LogEntry) error {
+		t.Helper()
+		return applyCommand(t, sm, index, statemachine.Command{
+			Type:       "REPLICATE",
+			Replicated: &statemachine.ReplicatedBatch{SourceDC: "dc1", Entries: batch},
+		})
+	}
+
+	if err := replicate(1, entries[:1]); err != nil {
+		t.Fatalf("应用复制的条目失败: %v", err)
+	}
+	if ttl, ok := sm.GetTTL("a"); !ok || ttl > 56*time.Second {
+		t.Fatalf("过期时间应按源条目的时间戳计算，剩余 %v", ttl)
+	}
+
+	// 与已应用的条目重叠的批次只应用新条目，空洞被拒绝
+	if err := replicate(2, entries[:3]); err != nil {
+		t.Fatalf("应用重叠的批次失败: %v", err)
+	}
+	if value, _ := sm.Get("a"); value != "2" {
+		t.Fatalf("CAS应去掉源集群的会话后应用，a = %v", value)
+	}
+	if err := replicate(3, entries[2:]); err != nil {
+		t.Fatalf("应用重叠的批次失败: %v", err)
+	}
+	if err := replicate(4, []raft.LogEntry{sourceEntry(t, 9, sourceTime, statemachine.Command{Type: "SET", Key: "c", Value: "y"})}); !errors.Is(err, statemachine.ErrReplicationGap) {
+		t.Fatalf("有空洞的批次应被拒绝，实际 %v", err)
+	}
+	if _, exists := sm.Get("c"); exists {
+		t.Fatal("被拒绝的批次不应生效")
+	}
+
+	mark := sm.ReplicationMark("dc1")
+	if mark.Index != 6 || mark.Term != 3 || mark.Applied != 6 || mark.Rejected != 1 {
+		t.Fatalf("高水位 = %+v", mark)
+	}
+	if value, _ := sm.Get("b"); value != "x" || sm.GetVersion("b") != 3 {
+		t.Fatalf("b = %v，版本 %d，版本应取本集群的日志索引", value, sm.GetVersion("b"))
+	}
+
+	data, err := sm.CreateSnapshot()
+	if err != nil {
+		t.Fatalf("创建快照失败: %v", err)
+	}
+	restored := statemachine.NewKVStateMachine()
+	if err := restored.RestoreSnapshot(data); err != nil {
+		t.Fatalf("恢复快照失败: %v", err)
+	}
+	if got := restored.ReplicationMark("dc1"); got != mark {
+		t.Fatalf("恢复后的高水位 = %+v，期望 %+v", got, mark)
+	}
+	if marks := restored.ReplicationMarks(); len(marks) != 1 || marks[0].SourceDC != "dc1" {
+		t.Fatalf("恢复后的高水位列表 = %+v", marks)
+	}
+}
+
+// replicationRequest 构造dc1的n1发往dc2的压缩批次
+func replicationRequest(t *testing.T, batchID string, entries []raft.LogEntry) *raft.CompressedAppendEntriesRequest {
+	t.Helper()
+	req := &raft.CompressedAppendEntriesRequest{
+		Term:         3,
+		LeaderID:     "n1",
+		PrevLogIndex: entries[0].Index - 1,
+		BatchID:      batchID,
+		SequenceNum:  1,
+		SourceDC:     "dc1",
+		TargetDC:     "dc2",
+	}
+	if err := raft.CompressEntries(req, entries, raft.CompressionGzip); err != nil {
+		t.Fatalf("压缩批次失败: %v", err)
+	}
+	return req
+}
+
+// TestReplicationReceiverServer 服务器经Raft应用其他DC的批次，乱序的批次等空洞填上后应用；
+// 未配置复制源的服务器拒绝批次
+func TestReplicationReceiverServer(t *testing.T) {
+	s := startSingleNodeWith(t, "node1", func(config *ServerConfig) {
+		config.DataCenter = "dc2"
+		config.ReplicationSources = map[raft.DataCenterID][]raft.NodeID{"dc1": {"n1"}}
+	})
+
+	now := time.Now()
+	var entries []raft.LogEntry
+	for i, key := range []string{"k1", "k2", "k3", "k4"} {
+		entries = append(entries, sourceEntry(t, raft.LogIndex(i+1), now, statemachine.Command{Type: "SET", Key: key, Value: key}))
+	}
+
+	resp, err := s.HandleCompressedAppendEntries(replicationRequest(t, "b2", entries[2:]))
+	if err != nil || !resp.Success || resp.LastProcessedIndex != 0 {
+		t.Fatalf("先到达的后一个批次应被缓存: %+v, %v", resp, err)
+	}
+	if _, exists := s.stateMachine.Get("k3"); exists {
+		t.Fatal("空洞填上之前不应应用后面的批次")
+	}
+
+	resp, err = s.HandleCompressedAppendEntries(replicationRequest(t, "b1", entries[:2]))
+	if err != nil || !resp.Success || resp.LastProcessedIndex != 4 || resp.ProcessedCount != 4 {
+		t.Fatalf("填上空洞后应应用全部条目: %+v, %v", resp, err)
+	}
+	for _, key := range []string{"k1", "k2", "k3", "k4"} {
+		if value, _ := s.stateMachine.Get(key); value != key {
+			t.Fatalf("%s = %v", key, value)
+		}
+	}
+
+	status, body := adminRequest(t, s, http.MethodGet, "/api/replication/inbound", nil)
+	if status != http.StatusOK || body["enabled"] != true {
+		t.Fatalf("查询复制接收状态: %d %v", status, body)
+	}
+	marks, _ := body["marks"].([]interface{})
+	if len(marks) != 1 || marks[0].(map[string]interface{})["index"] != float64(4) {
+		t.Fatalf("高水位 = %v", body["marks"])
+	}
+	sources, _ := body["sources"].([]interface{})
+	if len(sources) != 1 || sources[0].(map[string]interface{})["outOfOrderBatches"] != float64(1) {
+		t.Fatalf("接收统计 = %v", body["sources"])
+	}
+
+	disabled := &Server{}
+	if _, err := disabled.HandleCompressedAppendEntries(replicationRequest(t, "b1", entries[:2])); !errors.Is(err, errReceiverDisabled) {
+		t.Fatalf("未配置复制源时应拒绝批次，实际 %v", err)
+	}
+}
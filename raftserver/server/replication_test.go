@@ -0,0 +1,131 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-20 10:24:36
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-20 10:24:36
+* @Description: ConcordKV Raft consensus server - replication_test.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/raft"
+	"raftserver/replication"
+)
+
+// fakeReplication 记录各DC的暂停状态
+type fakeReplication struct {
+	targets map[raft.DataCenterID]*replication.AsyncReplicationTarget
+}
+
+func (f *fakeReplication) PauseReplication(dcID raft.DataCenterID) error {
+	return f.set(dcID, true)
+}
+
+func (f *fakeReplication) ResumeReplication(dcID raft.DataCenterID) error {
+	return f.set(dcID, false)
+}
+
+func (f *fakeReplication) set(dcID raft.DataCenterID, paused bool) error {
+	target, ok := f.targets[dcID]
+	if !ok {
+		return fmt.Errorf("%w: %s", replication.ErrReplicationTargetNotFound, dcID)
+	}
+	target.Paused = paused
+	target.PausedAt = time.Time{}
+	if paused {
+		target.PausedAt = time.Now()
+	}
+	return nil
+}
+
+func (f *fakeReplication) GetReplicationStatus() map[raft.DataCenterID]*replication.AsyncReplicationTarget {
+	return f.targets
+}
+
+// TestReplicationControlAPI 暂停、恢复向DC的复制，状态中反映暂停与延迟告警级别
+func TestReplicationControlAPI(t *testing.T) {
+	controller := &fakeReplication{targets: map[raft.DataCenterID]*replication.AsyncReplicationTarget{
+		"dc3": {DataCenter: "dc3", IsHealthy: true},
+		"dc2": {
+			DataCenter:      "dc2",
+			ConnectionState: replication.ConnectionDegraded,
+			PendingEntries:  make([]raft.LogEntry, 4),
+			LagEntries:      6,
+			PendingLag:      1500 * time.Millisecond,
+			LagLevel:        replication.LagWarning,
+		},
+	}}
+	s := &Server{config: &ServerConfig{}, logger: logging.Nop(), replication: controller}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/replication/status", s.handleReplicationStatus)
+	mux.HandleFunc("/api/replication/pause", s.handleReplicationPause)
+	mux.HandleFunc("/api/replication/resume", s.handleReplicationResume)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	post := func(path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("请求 %s 失败: %v", path, err)
+		}
+		defer resp.Body.Close()
+		var decoded map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&decoded)
+		return resp.StatusCode, decoded
+	}
+
+	status, body := post("/api/replication/pause", `{"dc":"dc2"}`)
+	if status != http.StatusOK {
+		t.Fatalf("暂停复制状态码 = %d", status)
+	}
+	target, _ := body["target"].(map[string]interface{})
+	if target["paused"] != true || target["pausedAt"] == nil || target["lagLevel"] != "warning" || target["connectionState"] != "degraded" {
+		t.Fatalf("暂停后的DC状态不正确: %v", body)
+	}
+
+	resp, err := http.Get(ts.URL + "/api/replication/status")
+	if err != nil {
+		t.Fatalf("查询复制状态失败: %v", err)
+	}
+	var listed struct {
+		Targets []replicationTarget `json:"targets"`
+	}
+	json.NewDecoder(resp.Body).Decode(&listed)
+	resp.Body.Close()
+	if len(listed.Targets) != 2 || listed.Targets[0].DataCenter != "dc2" || listed.Targets[1].DataCenter != "dc3" {
+		t.Fatalf("复制目标应按DC排序: %+v", listed.Targets)
+	}
+	if dc2 := listed.Targets[0]; !dc2.Paused || dc2.PendingEntries != 4 || dc2.LagEntries != 6 || dc2.PendingLagMs != 1500 {
+		t.Fatalf("dc2的状态不正确: %+v", dc2)
+	}
+	if listed.Targets[1].Paused || listed.Targets[1].PausedAt != nil {
+		t.Fatalf("dc3不应被暂停: %+v", listed.Targets[1])
+	}
+
+	if status, body := post("/api/replication/resume", `{"dc":"dc2"}`); status != http.StatusOK || body["target"].(map[string]interface{})["paused"] != false {
+		t.Fatalf("恢复复制失败: %d %v", status, body)
+	}
+	if status, _ := post("/api/replication/pause", `{"dc":"dc9"}`); status != http.StatusNotFound {
+		t.Fatalf("暂停不存在的DC状态码 = %d, 期望 404", status)
+	}
+	if status, _ := post("/api/replication/pause", `{}`); status != http.StatusBadRequest {
+		t.Fatalf("缺少dc的状态码 = %d, 期望 400", status)
+	}
+
+	// 未配置异步复制时返回503
+	s.SetAsyncReplicator(nil)
+	if status, _ := post("/api/replication/resume", `{"dc":"dc2"}`); status != http.StatusServiceUnavailable {
+		t.Fatalf("未配置异步复制时状态码 = %d, 期望 503", status)
+	}
+}
@@ -0,0 +1,249 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-4 19:40:18
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-4 19:40:18
+* @Description: ConcordKV Raft consensus server - routes.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/replication"
+)
+
+// routeManager 读写分离路由器中管理自定义路由规则和导出路由指标的操作
+type routeManager interface {
+	AddRoute(route *replication.Route) error
+	RemoveRoute(routeID string) error
+	ListRoutes() []*replication.Route
+	GetMetrics() *replication.RouterMetrics
+	GetLatencyHistograms() *replication.RouterLatencyHistograms
+}
+
+// learnerRouter 读写分离路由器中跟踪学习者角色的操作
+type learnerRouter interface {
+	SetLearner(nodeID raft.NodeID, learner bool) error
+}
+
+// 请求类型与路由策略在API中的名称
+var (
+	routeTypeNames = map[replication.RequestType]string{
+		replication.RequestTypeRead:      "read",
+		replication.RequestTypeWrite:     "write",
+		replication.RequestTypeReadWrite: "readwrite",
+	}
+	routeStrategyNames = map[replication.RoutingStrategy]string{
+		replication.RoutingNearestDC:          "nearest",
+		replication.RoutingRoundRobin:         "round-robin",
+		replication.RoutingWeightedRoundRobin: "weighted-round-robin",
+		replication.RoutingLeastLatency:       "least-latency",
+		replication.RoutingPrimaryDC:          "primary",
+		replication.RoutingLocalFirst:         "local-first",
+	}
+)
+
+// routeRule 路由规则的API表示
+type routeRule struct {
+	ID        string              `json:"id"`
+	Type      string              `json:"type"`
+	Pattern   string              `json:"pattern"`
+	Priority  int                 `json:"priority"`
+	TargetDCs []raft.DataCenterID `json:"targetDCs,omitempty"`
+	Strategy  string              `json:"strategy"`
+	Active    bool                `json:"active"`
+	CreatedAt time.Time           `json:"createdAt"`
+	Stats     *routeRuleStats     `json:"stats,omitempty"`
+}
+
+// routeRuleStats 路由规则的请求统计
+type routeRuleStats struct {
+	RequestCount     int64     `json:"requestCount"`
+	ErrorCount       int64     `json:"errorCount"`
+	AverageLatencyMs float64   `json:"averageLatencyMs"`
+	LastUsed         time.Time `json:"lastUsed"`
+}
+
+// SetReadWriteRouter 设置读写分离路由器，之后才能通过API管理路由规则
+// Raft传输层支持探测时路由器的健康检查经由它探测各节点，需要其他探测方式时在之后调用router.SetHealthProbe
+func (s *Server) SetReadWriteRouter(router *replication.ReadWriteRouter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.routes = nil
+	if router != nil {
+		if pinger, ok := s.transport.(raft.Pinger); ok {
+			router.SetHealthProbe(pinger)
+		}
+		s.routes = router
+	}
+}
+
+// readWriteRouter 获取读写分离路由器，未配置时返回503
+func (s *Server) readWriteRouter(w http.ResponseWriter) routeManager {
+	s.mu.RLock()
+	router := s.routes
+	s.mu.RUnlock()
+
+	if router == nil {
+		http.Error(w, "未启用读写分离路由", http.StatusServiceUnavailable)
+	}
+	return router
+}
+
+// handleRoutes 列出(GET)、添加(POST)、删除(DELETE ?id=)路由规则
+func (s *Server) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" && r.Method != "DELETE" {
+		http.Error(w, "只支持GET、POST、DELETE方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	router := s.readWriteRouter(w)
+	if router == nil {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		s.listRoutes(w, router)
+	case "POST":
+		s.addRoute(w, r, router)
+	default:
+		s.removeRoute(w, r, router)
+	}
+}
+
+// listRoutes 列出路由规则及其统计
+func (s *Server) listRoutes(w http.ResponseWriter, router routeManager) {
+	stats := router.GetMetrics().RouteStats
+
+	routes := router.ListRoutes()
+	rules := make([]routeRule, 0, len(routes))
+	for _, route := range routes {
+		rule := routeRule{
+			ID:        route.ID,
+			Type:      routeTypeNames[route.Type],
+			Pattern:   route.Pattern,
+			Priority:  route.Priority,
+			TargetDCs: route.TargetDCs,
+			Strategy:  routeStrategyNames[route.Strategy],
+			Active:    route.IsActive,
+			CreatedAt: route.CreatedAt,
+		}
+		if routeStats, exists := stats[route.ID]; exists {
+			rule.Stats = &routeRuleStats{
+				RequestCount:     routeStats.RequestCount,
+				ErrorCount:       routeStats.ErrorCount,
+				AverageLatencyMs: float64(routeStats.AverageLatency) / float64(time.Millisecond),
+				LastUsed:         routeStats.LastUsed,
+			}
+		}
+		rules = append(rules, rule)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"routes":  rules,
+	})
+}
+
+// addRoute 添加路由规则，type缺省为read，strategy缺省为nearest（写路由缺省为primary）
+func (s *Server) addRoute(w http.ResponseWriter, r *http.Request, router routeManager) {
+	var rule routeRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "解析请求失败", http.StatusBadRequest)
+		return
+	}
+
+	route, err := routeFromRule(rule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := router.AddRoute(route); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"id":      route.ID,
+	})
+}
+
+// routeFromRule 把API表示转换为路由规则
+func routeFromRule(rule routeRule) (*replication.Route, error) {
+	route := &replication.Route{
+		ID:        rule.ID,
+		Pattern:   rule.Pattern,
+		Priority:  rule.Priority,
+		TargetDCs: rule.TargetDCs,
+		Type:      replication.RequestTypeRead,
+		Strategy:  replication.RoutingNearestDC,
+	}
+
+	if rule.Type != "" {
+		found := false
+		for requestType, name := range routeTypeNames {
+			if name == rule.Type {
+				route.Type, found = requestType, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("未知的路由类型: %s", rule.Type)
+		}
+	}
+	if route.Type != replication.RequestTypeRead {
+		route.Strategy = replication.RoutingPrimaryDC
+	}
+
+	if rule.Strategy != "" {
+		found := false
+		for strategy, name := range routeStrategyNames {
+			if name == rule.Strategy {
+				route.Strategy, found = strategy, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("未知的路由策略: %s", rule.Strategy)
+		}
+	}
+
+	return route, nil
+}
+
+// removeRoute 删除路由规则
+func (s *Server) removeRoute(w http.ResponseWriter, r *http.Request, router routeManager) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "缺少id参数", http.StatusBadRequest)
+		return
+	}
+
+	if err := router.RemoveRoute(id); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, replication.ErrRouteNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"id":      id,
+	})
+}
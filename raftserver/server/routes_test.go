@@ -0,0 +1,119 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-4 19:40:18
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-4 19:40:18
+* @Description: ConcordKV Raft consensus server - routes_test.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"raftserver/logging"
+	"raftserver/raft"
+	"raftserver/replication"
+)
+
+// newTestRouter 创建本地DC为dc1、主DC为dc2、另有dc3的路由器
+func newTestRouter() *replication.ReadWriteRouter {
+	raftConfig := &raft.Config{
+		NodeID: "n1",
+		Servers: []raft.Server{
+			{ID: "n1", DataCenter: "dc1"},
+			{ID: "n2", DataCenter: "dc2"},
+			{ID: "n3", DataCenter: "dc3"},
+		},
+		MultiDC: &raft.MultiDCConfig{
+			Enabled:         true,
+			LocalDataCenter: &raft.DataCenterConfig{ID: "dc1"},
+		},
+	}
+
+	config := replication.DefaultReadWriteRouterConfig()
+	config.PrimaryDC = "dc2"
+	return replication.NewReadWriteRouterWithConfig("n1", config, raftConfig)
+}
+
+// TestRoutesAPI 通过API添加、列出和删除路由规则
+func TestRoutesAPI(t *testing.T) {
+	router := newTestRouter()
+	s := &Server{config: &ServerConfig{}, logger: logging.Nop()}
+	s.SetReadWriteRouter(router)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/routes", s.handleRoutes)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	do := func(method, path, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("请求 %s %s 失败: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := do("POST", "/api/routes", `{"id":"analytics","pattern":"analytics/*","targetDCs":["dc3"]}`); status != http.StatusOK {
+		t.Fatalf("添加路由状态码 = %d", status)
+	}
+	if status := do("POST", "/api/routes", `{"id":"bad","pattern":"x/*","targetDCs":["dc3"],"strategy":"fastest"}`); status != http.StatusBadRequest {
+		t.Fatalf("未知策略的状态码 = %d, 期望 400", status)
+	}
+	if status := do("POST", "/api/routes", `{"id":"orders","type":"write","pattern":"order:*"}`); status != http.StatusOK {
+		t.Fatalf("添加写路由状态码 = %d", status)
+	}
+
+	decision, err := router.RouteRequest(replication.RequestTypeRead, "analytics/daily", replication.ReadConsistencyEventual)
+	if err != nil || decision.TargetDC != "dc3" {
+		t.Fatalf("analytics读请求路由到 %+v, err=%v, 期望 dc3", decision, err)
+	}
+
+	resp, err := http.Get(ts.URL + "/api/routes")
+	if err != nil {
+		t.Fatalf("列出路由失败: %v", err)
+	}
+	var listed struct {
+		Routes []routeRule `json:"routes"`
+	}
+	json.NewDecoder(resp.Body).Decode(&listed)
+	resp.Body.Close()
+
+	rules := make(map[string]routeRule)
+	for _, rule := range listed.Routes {
+		rules[rule.ID] = rule
+	}
+	if len(rules) != 4 {
+		t.Fatalf("路由数 = %d, 期望 4: %+v", len(rules), listed.Routes)
+	}
+	analytics := rules["analytics"]
+	if analytics.Type != "read" || analytics.Strategy != "nearest" || analytics.Stats == nil || analytics.Stats.RequestCount != 1 {
+		t.Fatalf("analytics路由 = %+v", analytics)
+	}
+	if orders := rules["orders"]; orders.Type != "write" || orders.Strategy != "primary" {
+		t.Fatalf("orders路由 = %+v", orders)
+	}
+
+	if status := do("DELETE", "/api/routes?id=analytics", ""); status != http.StatusOK {
+		t.Fatalf("删除路由状态码 = %d", status)
+	}
+	if status := do("DELETE", "/api/routes?id=analytics", ""); status != http.StatusNotFound {
+		t.Fatalf("重复删除状态码 = %d, 期望 404", status)
+	}
+	if status := do("DELETE", "/api/routes?id=default-read", ""); status != http.StatusBadRequest {
+		t.Fatalf("删除默认路由状态码 = %d, 期望 400", status)
+	}
+
+	// 未配置路由器时返回503
+	s.SetReadWriteRouter(nil)
+	if status := do("GET", "/api/routes", ""); status != http.StatusServiceUnavailable {
+		t.Fatalf("未配置路由器时状态码 = %d, 期望 503", status)
+	}
+}
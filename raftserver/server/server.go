@@ -8,15 +8,26 @@
 package server
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"raftserver/backup"
 	"raftserver/config"
+	"raftserver/keyhash"
+	"raftserver/kverrors"
+	"raftserver/logging"
+	"raftserver/metrics"
+	"raftserver/queue"
 	"raftserver/raft"
+	"raftserver/replication"
 	"raftserver/statemachine"
 	"raftserver/storage"
 	"raftserver/transport"
@@ -27,12 +38,93 @@ type Server struct {
 	mu           sync.RWMutex
 	config       *ServerConfig
 	raftNode     *raft.Node
-	transport    *transport.HTTPTransport
-	storage      *storage.MemoryStorage
+	transport    raftTransport
+	storage      storage.LogStorage
 	stateMachine *statemachine.KVStateMachine
 	apiServer    *http.Server
-	logger       *log.Logger
+	logger       logging.Logger
 	running      bool
+
+	// 后台任务控制
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+
+	// 请求序号，用于生成等待应用结果的请求ID
+	requestSeq atomic.Uint64
+
+	// 最近一次领导者变更事件中的领导者
+	leaderHint atomic.Value // raft.NodeID
+
+	// 客户端写请求的提议批处理器
+	proposals *proposalBatcher
+
+	// TLS证书，未启用TLS时为nil
+	tls *transport.TLSCredentials
+
+	// 启用TLS时转发请求到领导者使用的连接池，校验领导者证书身份
+	forwardTransport http.RoundTripper
+
+	// 配置文件中定义的ACL令牌，按令牌摘要索引，未启用ACL时为nil
+	staticACL map[string]*statemachine.ACLToken
+
+	// 键变更事件的监听者
+	watches *watchHub
+
+	// 分片拓扑变更事件的订阅者
+	topology *topologyHub
+
+	// 更换键哈希算法的迁移窗口内，随/api/topology同时通告的之前的算法
+	previousKeyHash *keyhash.Algorithm
+
+	// 按采样记录的各分片请求速率与热点键
+	load *loadTracker
+
+	// 排空状态：排空期间拒绝新的API请求，等待进行中的请求完成后停止
+	draining  atomic.Bool
+	inflight  atomic.Int64
+	drained   chan struct{}
+	drainOnce sync.Once
+
+	// 本次启动恢复的备份，未从备份恢复时为nil
+	restored *backup.Header
+
+	// 启动追赶门控：追上启动时的集群提交索引之前拒绝stale读
+	readiness readinessGate
+
+	// 节点状态、领导者、快照与成员变更的结构化事件日志
+	events *eventLog
+
+	// 跨数据中心比较已应用状态的摘要
+	digests stateDigestCache
+
+	// 多数据中心故障转移协调器，未启用时为nil
+	failover failoverController
+
+	// 读写分离路由器，未启用时为nil
+	routes routeManager
+
+	// 跨DC异步复制管理器，未启用时为nil
+	replication replicationController
+
+	// 跨DC复制接收端，未配置源DC时为nil
+	receiver *replication.ReplicationReceiver
+
+	// 各组件的指标收集器，通过/metrics以Prometheus文本格式暴露
+	metrics *metrics.Registry
+
+	// 客户端API请求的阶段追踪与慢请求日志
+	traces *requestTracer
+
+	// 写转发：作为跟随者转发写请求的统计，作为领导者按幂等令牌记录的转发结果
+	forwardStats    writeForwardStats
+	forwardedWrites *forwardedWrites
+}
+
+// raftTransport 服务器使用的Raft传输层，HTTP与gRPC传输层均实现该接口
+type raftTransport interface {
+	raft.Transport
+	SetHandler(handler transport.TransportHandler)
+	SetTLS(creds *transport.TLSCredentials)
 }
 
 // ServerConfig 服务器配置
@@ -44,84 +136,455 @@ type ServerConfig struct {
 	HeartbeatInterval time.Duration          `yaml:"heartbeatInterval"`
 	MaxLogEntries     int                    `yaml:"maxLogEntries"`
 	SnapshotThreshold int                    `yaml:"snapshotThreshold"`
+	SnapshotChunkSize int                    `yaml:"snapshotChunkSize"`
 	Peers             map[raft.NodeID]string `yaml:"peers"`
+	PeerAPIAddrs      map[raft.NodeID]string `yaml:"peerApiAddrs"`
+
+	// Transport 节点间传输层：http或grpc，集群内所有节点必须一致
+	Transport transport.Kind `yaml:"transport"`
+
+	// TLS 配置证书后节点间通信与API服务器均启用TLS，节点间双向校验证书
+	TLS transport.TLSConfig `yaml:"tls"`
+
+	// APIToken 非空时API请求必须携带 Authorization: Bearer <token>
+	APIToken string `yaml:"apiToken"`
+
+	// ACL 按键前缀的访问控制，启用后每个请求按其令牌的规则授权
+	ACL ACLConfig `yaml:"acl"`
+
+	// 变更监听：每个监听者的事件缓冲区大小（满时丢弃事件并通知重新同步）与监听者数量上限
+	WatchBufferSize int `yaml:"watchBufferSize"`
+	MaxWatchers     int `yaml:"maxWatchers"`
+
+	// EventLogSize /api/events保留的最近节点事件数
+	EventLogSize int `yaml:"eventLogSize"`
+
+	// 慢请求日志：耗时达到SlowRequestThreshold的API请求连同各阶段耗时保留最近SlowLogSize条，
+	// 配置SlowLogFile时同时以JSON行追加写入；阈值为0时使用默认值（500毫秒），小于0时关闭
+	SlowRequestThreshold time.Duration `yaml:"slowRequestThreshold"`
+	SlowLogSize          int           `yaml:"slowLogSize"`
+	SlowLogFile          string        `yaml:"slowLogFile"`
+
+	// SessionTimeout 客户端会话的空闲超时，超时的会话通过日志条目在所有副本上清理
+	SessionTimeout time.Duration `yaml:"sessionTimeout"`
+
+	// 负载统计：按LoadSampleRate采样请求（为0时使用1%，小于0时关闭），统计最近LoadWindow内的分片速率与热点键
+	LoadSampleRate float64       `yaml:"loadSampleRate"`
+	LoadWindow     time.Duration `yaml:"loadWindow"`
+
+	// TopologyCoalesceWindow 拓扑事件的合并窗口：窗口内同一分片的事件合并为最新状态，多个分片的事件作为一帧推送；
+	// 为0时使用默认值（100毫秒），小于0时每次观察到的变更立即推送
+	TopologyCoalesceWindow time.Duration `yaml:"topologyCoalesceWindow"`
+
+	// DrainTimeout 排空（/api/admin/drain或SIGTERM）到停止的最长时间
+	DrainTimeout time.Duration `yaml:"drainTimeout"`
+
+	// 启动追赶门控：已应用索引距启动时得知的集群提交索引超过CatchUpSlack条时拒绝stale读（503，CATCHING_UP），/api/health报告未就绪；
+	// 启动超过CatchUpMaxWait（为0时使用默认值1分钟）仍未追上时，只有AllowDegradedReads为true才以降级状态恢复stale读
+	CatchUpSlack       int           `yaml:"catchUpSlack"`
+	CatchUpMaxWait     time.Duration `yaml:"catchUpMaxWait"`
+	AllowDegradedReads bool          `yaml:"allowDegradedReads"`
+
+	// 客户端API读、写请求的处理超时，包括读取请求体与等待Raft提交；为0时使用默认值（5秒与10秒），小于0时不限制
+	ReadTimeout  time.Duration `yaml:"readTimeout"`
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+
+	// 键值大小上限：值按JSON编码长度计算，超过时返回413；为0时使用默认值（1MB与1024字节），小于0时不限制
+	MaxValueSize int `yaml:"maxValueSize"`
+	MaxKeyLength int `yaml:"maxKeyLength"`
+
+	// CompressThreshold 写请求要求压缩时，值的JSON编码超过该长度（字节）才以gzip压缩存储；为0时使用默认值1024，小于0时不论长度都压缩
+	CompressThreshold int `yaml:"compressThreshold"`
+
+	// Join 以非投票成员身份启动，等待领导者通过成员变更将本节点加入集群
+	Join bool `yaml:"join"`
+
+	// RestoreFrom 备份文件路径，非空时首次启动用备份初始化空存储，以单节点集群启动
+	RestoreFrom string `yaml:"-"`
+
+	// EnableLeaseRead 启用基于租约的线性一致读
+	EnableLeaseRead bool `yaml:"enableLeaseRead"`
+
+	// EnablePreVote 启用预投票，默认开启
+	EnablePreVote bool `yaml:"enablePreVote"`
+
+	// ForwardWrites 启用写转发：跟随者接受客户端写请求，经Raft传输层转发给领导者并等待提交后响应，
+	// 而不是把客户端重定向到领导者；适用于次级数据中心的客户端只访问本地节点的部署
+	ForwardWrites bool `yaml:"forwardWrites"`
+
+	// MaxInflightBatches 每个跟随者允许同时在途的追加日志批次数，为1时关闭流水线复制
+	MaxInflightBatches int `yaml:"maxInflightBatches"`
+
+	// 提议批处理：窗口期内到达的写请求合并为一次日志追加
+	ProposalBatchWindow time.Duration `yaml:"proposalBatchWindow"` // 批处理窗口
+	ProposalBatchSize   int           `yaml:"proposalBatchSize"`   // 单批最多合并的提议数
+	MaxPendingProposals int           `yaml:"maxPendingProposals"` // 排队提议上限，超过时返回503
+
+	// 持久化：存储后端为memory、wal或file，未指定时配置了数据目录则使用wal，否则使用memory
+	Storage      storage.Backend    `yaml:"storage"`      // 存储后端
+	DataDir      string             `yaml:"dataDir"`      // 数据目录
+	SyncPolicy   storage.SyncPolicy `yaml:"syncPolicy"`   // WAL刷盘策略：always、interval、group
+	SyncInterval time.Duration      `yaml:"syncInterval"` // interval策略的刷盘间隔
+	SegmentSize  int64              `yaml:"segmentSize"`  // file存储的日志段大小上限（字节）
+
+	// AllowVolatile 允许使用内存存储；重启后任期与投票丢失可能导致同一任期投出两票，仅用于测试
+	AllowVolatile bool `yaml:"allowVolatile"`
 
 	// 数据中心配置
 	DataCenter    raft.DataCenterID   `yaml:"dataCenter"`
 	ReplicaType   raft.ReplicaType    `yaml:"replicaType"`
 	MultiDCConfig *raft.MultiDCConfig `yaml:"multiDC,omitempty"`
+
+	// PeerDataCenters 各节点所在的数据中心，未列出的节点与本节点同属DataCenter
+	PeerDataCenters map[raft.NodeID]raft.DataCenterID `yaml:"peerDataCenters"`
+
+	// PeerZones 各节点所在的可用区，随/api/topology返回给客户端用于就近读取；未列出的节点没有可用区标签
+	PeerZones map[raft.NodeID]string `yaml:"peerZones"`
+
+	// ReplicationSources 接收跨DC复制批次的源DC及其允许发送批次的节点，为空时拒绝其他DC发来的批次
+	ReplicationSources map[raft.DataCenterID][]raft.NodeID `yaml:"replicationSources"`
+
+	// 键哈希算法：KeyHash为分片使用的算法（为空时使用keyhash.Canonical），随/api/topology通告给客户端；
+	// 更换算法的迁移窗口内以PreviousKeyHash同时通告之前的算法，尚不支持新算法的客户端可以继续按旧算法路由
+	KeyHash         string `yaml:"keyHash"`
+	PreviousKeyHash string `yaml:"previousKeyHash"`
+
+	// CatchUp 追赶复制限速，为nil时领导者以最快速度向落后的跟随者发送日志与快照
+	CatchUp *raft.CatchUpConfig `yaml:"catchUp,omitempty"`
+
+	// 日志：级别为debug、info、warn或error，格式为text或json
+	LogLevel  string `yaml:"logLevel"`
+	LogFormat string `yaml:"logFormat"`
+
+	// Logger 非nil时替代按LogLevel和LogFormat创建的日志，服务器与Raft各组件均以其为基础
+	Logger logging.Logger `yaml:"-"`
 }
 
 // NewServer 创建新的服务器
 func NewServer(configPath string) (*Server, error) {
+	serverConfig, err := LoadServerConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewServerWithConfig(serverConfig)
+}
+
+// LoadServerConfig 从配置文件加载服务器配置，server配置段中的未知字段、类型不符的字段与字段间的不一致均返回*ConfigError
+func LoadServerConfig(configPath string) (*ServerConfig, error) {
 	// 加载配置
 	cfg, err := config.Load(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("加载配置失败: %w", err)
 	}
+	if err := checkConfigFields(cfg); err != nil {
+		return nil, withConfigFile(err, configPath)
+	}
 
 	serverConfig := &ServerConfig{
-		NodeID:            raft.NodeID(cfg.GetString("server.nodeId", "node1")),
-		ListenAddr:        cfg.GetString("server.listenAddr", ":8080"),
-		APIAddr:           cfg.GetString("server.apiAddr", ":8081"),
-		ElectionTimeout:   time.Duration(cfg.GetInt("server.electionTimeout", 5000)) * time.Millisecond,
-		HeartbeatInterval: time.Duration(cfg.GetInt("server.heartbeatInterval", 1000)) * time.Millisecond,
-		MaxLogEntries:     cfg.GetInt("server.maxLogEntries", 100),
-		SnapshotThreshold: cfg.GetInt("server.snapshotThreshold", 1000),
-		Peers:             make(map[raft.NodeID]string),
+		NodeID:               raft.NodeID(cfg.GetString("server.nodeId", "node1")),
+		ListenAddr:           cfg.GetString("server.listenAddr", ":8080"),
+		APIAddr:              cfg.GetString("server.apiAddr", ":8081"),
+		ElectionTimeout:      time.Duration(cfg.GetInt("server.electionTimeout", 5000)) * time.Millisecond,
+		HeartbeatInterval:    time.Duration(cfg.GetInt("server.heartbeatInterval", 1000)) * time.Millisecond,
+		MaxLogEntries:        cfg.GetInt("server.maxLogEntries", 100),
+		MaxInflightBatches:   cfg.GetInt("server.maxInflightBatches", raft.DefaultMaxInflightBatches),
+		SnapshotThreshold:    cfg.GetInt("server.snapshotThreshold", 1000),
+		SnapshotChunkSize:    cfg.GetInt("server.snapshotChunkSize", raft.DefaultSnapshotChunkSize),
+		Peers:                make(map[raft.NodeID]string),
+		PeerAPIAddrs:         make(map[raft.NodeID]string),
+		Transport:            transport.Kind(cfg.GetString("server.transport", string(transport.KindHTTP))),
+		APIToken:             cfg.GetString("server.apiToken", ""),
+		WatchBufferSize:      cfg.GetInt("server.watchBufferSize", defaultWatchBufferSize),
+		MaxWatchers:          cfg.GetInt("server.maxWatchers", defaultMaxWatchers),
+		EventLogSize:         cfg.GetInt("server.eventLogSize", defaultEventLogSize),
+		SlowRequestThreshold: time.Duration(cfg.GetInt("server.slowRequestThreshold", int(defaultSlowRequestThreshold/time.Millisecond))) * time.Millisecond,
+		SlowLogSize:          cfg.GetInt("server.slowLogSize", defaultSlowLogSize),
+		SlowLogFile:          cfg.GetString("server.slowLogFile", ""),
+		SessionTimeout:       time.Duration(cfg.GetInt("server.sessionTimeout", int(defaultSessionTimeout/time.Millisecond))) * time.Millisecond,
+		LoadSampleRate:       cfg.GetFloat("server.loadSampleRate", defaultLoadSampleRate),
+		LoadWindow:           time.Duration(cfg.GetInt("server.loadWindow", int(defaultLoadWindow/time.Millisecond))) * time.Millisecond,
+		DrainTimeout:         time.Duration(cfg.GetInt("server.drainTimeout", int(defaultDrainTimeout/time.Millisecond))) * time.Millisecond,
+		CatchUpSlack:         cfg.GetInt("server.catchUpSlack", 0),
+		CatchUpMaxWait:       time.Duration(cfg.GetInt("server.catchUpMaxWait", int(defaultCatchUpMaxWait/time.Millisecond))) * time.Millisecond,
+		AllowDegradedReads:   cfg.GetBool("server.allowDegradedReads", false),
+		ReadTimeout:          time.Duration(cfg.GetInt("server.readTimeout", int(defaultReadTimeout/time.Millisecond))) * time.Millisecond,
+		WriteTimeout:         time.Duration(cfg.GetInt("server.writeTimeout", int(defaultWriteTimeout/time.Millisecond))) * time.Millisecond,
+		MaxValueSize:         cfg.GetInt("server.maxValueSize", defaultMaxValueSize),
+		MaxKeyLength:         cfg.GetInt("server.maxKeyLength", defaultMaxKeyLength),
+		CompressThreshold:    cfg.GetInt("server.compressThreshold", defaultCompressThreshold),
+		Join:                 cfg.GetBool("server.join", false),
+		EnableLeaseRead:      cfg.GetBool("server.enableLeaseRead", false),
+		EnablePreVote:        cfg.GetBool("server.enablePreVote", true),
+		ForwardWrites:        cfg.GetBool("server.forwardWrites", false),
+		LogLevel:             cfg.GetString("server.logLevel", "info"),
+		LogFormat:            cfg.GetString("server.logFormat", string(logging.FormatText)),
+		KeyHash:              cfg.GetString("server.keyHash", ""),
+		PreviousKeyHash:      cfg.GetString("server.previousKeyHash", ""),
+
+		// 拓扑事件配置
+		TopologyCoalesceWindow: time.Duration(cfg.GetInt("server.topologyCoalesceWindow", int(defaultTopologyCoalesceWindow/time.Millisecond))) * time.Millisecond,
+
+		// 提议批处理配置
+		ProposalBatchWindow: time.Duration(cfg.GetInt("server.proposalBatchWindow", 5)) * time.Millisecond,
+		ProposalBatchSize:   cfg.GetInt("server.proposalBatchSize", defaultProposalBatchSize),
+		MaxPendingProposals: cfg.GetInt("server.maxPendingProposals", defaultMaxPendingProposals),
+
+		// 持久化配置
+		Storage:       storage.Backend(cfg.GetString("server.storage", "")),
+		AllowVolatile: cfg.GetBool("server.allowVolatile", false),
+		SegmentSize:   int64(cfg.GetInt("server.segmentSize", 0)) << 20,
+		DataDir:       cfg.GetString("server.dataDir", ""),
+		SyncPolicy:    storage.SyncPolicy(cfg.GetString("server.syncPolicy", string(storage.SyncAlways))),
+		SyncInterval:  time.Duration(cfg.GetInt("server.syncInterval", 10)) * time.Millisecond,
+
+		// TLS配置
+		TLS: transport.TLSConfig{
+			CertFile:   cfg.GetString("server.tls.certFile", ""),
+			KeyFile:    cfg.GetString("server.tls.keyFile", ""),
+			CAFile:     cfg.GetString("server.tls.caFile", ""),
+			ClientAuth: transport.ClientAuthMode(cfg.GetString("server.tls.clientAuth", string(transport.ClientAuthNone))),
+		},
+
+		// 访问控制配置
+		ACL: ACLConfig{
+			Enabled: cfg.GetBool("server.acl.enabled", false),
+			Tokens:  cfg.GetStringSlice("server.acl.tokens", []string{}),
+		},
 
 		// 数据中心配置
 		DataCenter:  raft.DataCenterID(cfg.GetString("server.dataCenter", "dc1")),
 		ReplicaType: raft.ReplicaType(cfg.GetInt("server.replicaType", int(raft.PrimaryReplica))),
 	}
 
-	// 加载节点列表
-	peersList := cfg.GetStringSlice("server.peers", []string{})
-	for _, peer := range peersList {
-		// 解析节点信息，格式：nodeId:address
-		// 这里简化处理，实际应该更严格
-		if len(peer) > 0 {
-			serverConfig.Peers[raft.NodeID(peer)] = peer
+	// 加载节点列表、节点API地址与节点所在的数据中心，格式：nodeId=host:port、nodeId=dc
+	peers, err := parseNodeList(cfg.GetStringSlice("server.peers", []string{}), "server.peers", true)
+	if err != nil {
+		return nil, withConfigFile(err, configPath)
+	}
+	serverConfig.Peers = peers
+
+	peerAPIAddrs, err := parseNodeList(cfg.GetStringSlice("server.peerApiAddrs", []string{}), "server.peerApiAddrs", true)
+	if err != nil {
+		return nil, withConfigFile(err, configPath)
+	}
+	serverConfig.PeerAPIAddrs = peerAPIAddrs
+
+	peerDCs, err := parseNodeList(cfg.GetStringSlice("server.peerDataCenters", []string{}), "server.peerDataCenters", false)
+	if err != nil {
+		return nil, withConfigFile(err, configPath)
+	}
+	for id, dc := range peerDCs {
+		if serverConfig.PeerDataCenters == nil {
+			serverConfig.PeerDataCenters = make(map[raft.NodeID]raft.DataCenterID)
 		}
+		serverConfig.PeerDataCenters[id] = raft.DataCenterID(dc)
 	}
 
-	return NewServerWithConfig(serverConfig)
+	peerZones, err := parseNodeList(cfg.GetStringSlice("server.peerZones", []string{}), "server.peerZones", false)
+	if err != nil {
+		return nil, withConfigFile(err, configPath)
+	}
+	serverConfig.ReplicationSources, err = parseReplicationSources(cfg.GetStringSlice("server.replicationSources", []string{}), "server.replicationSources")
+	if err != nil {
+		return nil, withConfigFile(err, configPath)
+	}
+	if len(peerZones) > 0 {
+		serverConfig.PeerZones = peerZones
+	}
+
+	// 追赶复制限速，peerBytesPerSec格式：nodeId=字节/秒
+	if cfg.Exists("server.catchUp") {
+		catchUp, err := loadCatchUpConfig(cfg)
+		if err != nil {
+			return nil, withConfigFile(err, configPath)
+		}
+		serverConfig.CatchUp = catchUp
+	}
+
+	// 多数据中心模式与提交策略
+	if cfg.GetBool("server.multiDC.enabled", false) {
+		policy, err := raft.ParseCommitPolicy(cfg.GetString("server.multiDC.commitPolicy", ""))
+		if err != nil {
+			return nil, withConfigFile(configErrorf("server.multiDC.commitPolicy", "%v", err), configPath)
+		}
+		queuePolicy, err := queue.ParsePolicy(cfg.GetString("server.multiDC.replicationQueue.policy", ""))
+		if err != nil {
+			return nil, withConfigFile(configErrorf("server.multiDC.replicationQueue.policy", "%v", err), configPath)
+		}
+		serverConfig.MultiDCConfig = &raft.MultiDCConfig{
+			Enabled:             true,
+			LocalDataCenter:     &raft.DataCenterConfig{ID: serverConfig.DataCenter},
+			CommitPolicy:        policy,
+			CrossDCMinBatchSize: cfg.GetInt("server.multiDC.crossDCMinBatchSize", 0),
+			CrossDCMaxBatchSize: cfg.GetInt("server.multiDC.crossDCMaxBatchSize", 0),
+			ReplicationQueue: queue.Config{
+				Capacity:     cfg.GetInt("server.multiDC.replicationQueue.capacity", 0),
+				Policy:       queuePolicy,
+				BlockTimeout: time.Duration(cfg.GetInt("server.multiDC.replicationQueue.blockTimeout", 0)) * time.Millisecond,
+			},
+		}
+	}
+
+	if err := serverConfig.Validate(); err != nil {
+		return nil, withConfigFile(err, configPath)
+	}
+	return serverConfig, nil
+}
+
+// openStorage 按配置打开日志存储，未显式允许时拒绝使用内存存储
+func openStorage(config *ServerConfig, logger logging.Logger) (storage.LogStorage, error) {
+	if config.Storage == "" {
+		config.Storage = storage.BackendMemory
+		if config.DataDir != "" {
+			config.Storage = storage.BackendWAL
+		}
+	}
+
+	backend, err := storage.ParseBackend(string(config.Storage))
+	if err != nil {
+		return nil, err
+	}
+	if !backend.Persistent() && !config.AllowVolatile {
+		return nil, fmt.Errorf("内存存储在重启后丢失任期、投票与日志，可能破坏Raft的安全性；请配置dataDir与storage（wal或file），或使用 --allow-volatile 显式允许")
+	}
+
+	store, err := storage.Open(storage.Options{
+		Backend:      backend,
+		Dir:          config.DataDir,
+		SyncPolicy:   config.SyncPolicy,
+		SyncInterval: config.SyncInterval,
+		SegmentSize:  config.SegmentSize,
+		Logger:       logger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("打开%s存储失败: %w", backend, err)
+	}
+	return store, nil
 }
 
 // NewServerWithConfig 使用配置创建服务器
 func NewServerWithConfig(config *ServerConfig) (*Server, error) {
-	logger := log.New(log.Writer(), fmt.Sprintf("[server-%s] ", config.NodeID), log.LstdFlags)
+	baseLogger, err := newBaseLogger(config)
+	if err != nil {
+		return nil, err
+	}
+	logger := logging.Component(baseLogger, "server", logging.FieldNodeID, string(config.NodeID), logging.FieldDC, string(config.DataCenter))
+
+	if config.SessionTimeout <= 0 {
+		config.SessionTimeout = defaultSessionTimeout
+	}
+	if config.DrainTimeout <= 0 {
+		config.DrainTimeout = defaultDrainTimeout
+	}
+	if config.CatchUpMaxWait <= 0 {
+		config.CatchUpMaxWait = defaultCatchUpMaxWait
+	}
+	if config.TopologyCoalesceWindow == 0 {
+		config.TopologyCoalesceWindow = defaultTopologyCoalesceWindow
+	}
+	if config.ReadTimeout == 0 {
+		config.ReadTimeout = defaultReadTimeout
+	}
+	if config.WriteTimeout == 0 {
+		config.WriteTimeout = defaultWriteTimeout
+	}
+	if config.MaxValueSize == 0 {
+		config.MaxValueSize = defaultMaxValueSize
+	}
+	if config.MaxKeyLength == 0 {
+		config.MaxKeyLength = defaultMaxKeyLength
+	}
+	if config.CompressThreshold == 0 {
+		config.CompressThreshold = defaultCompressThreshold
+	}
+
+	staticACL, err := loadStaticACL(config)
+	if err != nil {
+		return nil, fmt.Errorf("加载ACL配置失败: %w", err)
+	}
+
+	keyHash, previousKeyHash, err := config.keyHashes()
+	if err != nil {
+		return nil, err
+	}
 
 	// 创建存储
-	storage := storage.NewMemoryStorage()
+	store, err := openStorage(config, baseLogger.With(logging.FieldNodeID, string(config.NodeID)))
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("使用存储后端", "backend", config.Storage, "data_dir", config.DataDir)
 
-	// 创建状态机
+	// 创建状态机，分片按配置的键哈希算法划分
 	stateMachine := statemachine.NewKVStateMachine()
+	stateMachine.SetKeyHash(keyHash)
 
 	// 创建传输层
-	transport := transport.NewHTTPTransport(config.ListenAddr, config.Peers)
+	kind, err := transport.ParseKind(string(config.Transport))
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	var peerTransport raftTransport
+	switch kind {
+	case transport.KindGRPC:
+		peerTransport = transport.NewGRPCTransport(config.ListenAddr, config.Peers, config.ElectionTimeout)
+	default:
+		peerTransport = transport.NewHTTPTransport(config.ListenAddr, config.Peers)
+	}
+	logger.Info("使用传输层", "transport", kind)
+
+	var tlsCreds *transport.TLSCredentials
+	if config.TLS.Enabled() {
+		tlsCreds, err = transport.NewTLSCredentials(config.TLS)
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("加载TLS配置失败: %w", err)
+		}
+		peerTransport.SetTLS(tlsCreds)
+		logger.Info("已启用TLS", "client_auth", tlsCreds.ClientAuth())
+	}
 
 	// 创建Raft配置
 	raftConfig := &raft.Config{
-		NodeID:            config.NodeID,
-		ElectionTimeout:   config.ElectionTimeout,
-		HeartbeatInterval: config.HeartbeatInterval,
-		MaxLogEntries:     config.MaxLogEntries,
-		SnapshotThreshold: config.SnapshotThreshold,
-		Servers:           make([]raft.Server, 0),
-		MultiDC:           config.MultiDCConfig,
+		NodeID:             config.NodeID,
+		ElectionTimeout:    config.ElectionTimeout,
+		HeartbeatInterval:  config.HeartbeatInterval,
+		MaxLogEntries:      config.MaxLogEntries,
+		MaxInflightBatches: config.MaxInflightBatches,
+		SnapshotThreshold:  config.SnapshotThreshold,
+		SnapshotChunkSize:  config.SnapshotChunkSize,
+		SnapshotDir:        config.DataDir,
+		EnableLeaseRead:    config.EnableLeaseRead,
+		EnablePreVote:      config.EnablePreVote,
+		Servers:            make([]raft.Server, 0),
+		MultiDC:            config.MultiDCConfig,
+		CatchUp:            config.CatchUp,
+		Logger:             baseLogger,
 	}
 
-	// 添加服务器列表
+	// 添加服务器列表，加入已有集群时本节点不在初始配置中
 	for nodeID, addr := range config.Peers {
+		if config.Join && nodeID == config.NodeID {
+			continue
+		}
+		dataCenter := config.DataCenter
+		if dc, ok := config.PeerDataCenters[nodeID]; ok {
+			dataCenter = dc
+		}
 		raftConfig.Servers = append(raftConfig.Servers, raft.Server{
 			ID:          nodeID,
 			Address:     addr,
-			DataCenter:  config.DataCenter,
+			DataCenter:  dataCenter,
 			ReplicaType: config.ReplicaType,
 		})
 	}
 
+	if config.Join && len(raftConfig.Servers) == 0 {
+		return nil, fmt.Errorf("加入已有集群时必须指定集群中的其他节点")
+	}
+
 	// 如果没有配置其他节点，添加自己作为单节点集群
 	if len(raftConfig.Servers) == 0 {
 		raftConfig.Servers = append(raftConfig.Servers, raft.Server{
@@ -132,27 +595,106 @@ func NewServerWithConfig(config *ServerConfig) (*Server, error) {
 		})
 	}
 
+	// 从备份恢复时以备份的快照初始化存储，成员只有本节点
+	var restored *backup.Header
+	if config.RestoreFrom != "" {
+		restored, err = restoreFromBackup(config, store, raftConfig.Servers[0], logger)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+
 	// 创建Raft节点
-	raftNode, err := raft.NewNode(raftConfig, transport, storage, stateMachine)
+	raftNode, err := raft.NewNode(raftConfig, peerTransport, store, stateMachine)
 	if err != nil {
+		store.Close()
 		return nil, fmt.Errorf("创建Raft节点失败: %w", err)
 	}
 
 	server := &Server{
 		config:       config,
 		raftNode:     raftNode,
-		transport:    transport,
-		storage:      storage,
+		transport:    peerTransport,
+		storage:      store,
 		stateMachine: stateMachine,
 		logger:       logger,
+		tls:          tlsCreds,
+		staticACL:    staticACL,
+		drained:      make(chan struct{}),
+		restored:     restored,
+	}
+
+	if tlsCreds != nil {
+		server.forwardTransport = &http.Transport{
+			DialTLSContext: tlsCreds.DialTLSContext(server.resolvePeerAPI),
+		}
+	}
+
+	// 跟随者转发的写请求按幂等令牌去重，任何节点成为领导者后都可能收到转发的写请求
+	server.forwardedWrites = newForwardedWrites()
+
+	// 应用日志产生的键变更分发给监听者
+	server.watches = newWatchHub(config.WatchBufferSize, config.MaxWatchers, raftNode.LastSnapshotIndex)
+	stateMachine.SetChangeListener(server.watches)
+
+	// 领导者或成员变更产生的拓扑事件
+	server.topology = newTopologyHub(string(config.NodeID), config.PeerZones)
+	server.previousKeyHash = previousKeyHash
+	if config.TopologyCoalesceWindow > 0 {
+		server.topology.window = config.TopologyCoalesceWindow
+	}
+
+	// 请求路径上的负载采样
+	if config.LoadSampleRate == 0 {
+		config.LoadSampleRate = defaultLoadSampleRate
+	}
+	server.load = newLoadTracker(config.LoadSampleRate, config.LoadWindow, stateMachine.ShardForKey)
+
+	// 节点事件日志，由事件监听回调填充
+	server.events = newEventLog(config.EventLogSize)
+
+	// 请求追踪与慢请求日志，提交事件由事件监听回调送达
+	server.traces, err = newRequestTracer(config.SlowRequestThreshold, config.SlowLogSize, config.SlowLogFile, server.nextRequestID, logger)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("打开慢请求日志文件失败: %w", err)
 	}
 
+	server.proposals = newProposalBatcher(raftNode, stateMachine, server.nextRequestID,
+		config.ProposalBatchWindow, config.ProposalBatchSize, config.MaxPendingProposals, logger)
+
+	// 各组件的指标，每个样本带上节点ID
+	server.metrics = server.newMetricsRegistry()
+
+	// 接收其他DC的复制批次，经Raft传输层送达
+	server.receiver = newReplicationReceiver(server, config, raftConfig)
+
 	// 设置传输处理器
-	transport.SetHandler(server)
+	peerTransport.SetHandler(server)
+
+	// 监听领导者变更，用于重定向非领导者收到的请求，同时记录到事件日志
+	raftNode.AddEventListener(server)
 
 	return server, nil
 }
 
+// newBaseLogger 按配置创建服务器与各组件共用的日志，配置中注入了Logger时直接使用
+func newBaseLogger(config *ServerConfig) (logging.Logger, error) {
+	if config.Logger != nil {
+		return config.Logger, nil
+	}
+	level, err := logging.ParseLevel(config.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	format, err := logging.ParseFormat(config.LogFormat)
+	if err != nil {
+		return nil, err
+	}
+	return logging.New(logging.Options{Level: level, Format: format}), nil
+}
+
 // Start 启动服务器
 func (s *Server) Start() error {
 	s.mu.Lock()
@@ -162,21 +704,35 @@ func (s *Server) Start() error {
 		return fmt.Errorf("服务器已经启动")
 	}
 
-	s.logger.Printf("启动ConcordKV Raft服务器，节点ID: %s", s.config.NodeID)
+	s.logger.Info("启动ConcordKV Raft服务器")
+	s.readiness.startedAt = time.Now()
 
 	// 启动Raft节点
 	if err := s.raftNode.Start(); err != nil {
 		return fmt.Errorf("启动Raft节点失败: %w", err)
 	}
 
+	// 启动提议批处理器
+	s.proposals.Start()
+
 	// 启动API服务器
 	if err := s.startAPIServer(); err != nil {
+		s.proposals.Stop()
+		if err := s.traces.slow.close(); err != nil {
+			s.logger.Warn("关闭慢请求日志文件失败", logging.FieldError, err)
+		}
 		s.raftNode.Stop()
 		return fmt.Errorf("启动API服务器失败: %w", err)
 	}
 
+	// 启动过期键清理
+	s.stopCh = make(chan struct{})
+	s.wg.Add(2)
+	go s.expirationSweepLoop()
+	go s.topologyWatchLoop()
+
 	s.running = true
-	s.logger.Printf("服务器启动成功")
+	s.logger.Info("服务器启动成功")
 
 	return nil
 }
@@ -190,20 +746,25 @@ func (s *Server) Stop() error {
 		return nil
 	}
 
-	s.logger.Printf("停止ConcordKV Raft服务器")
+	s.logger.Info("停止ConcordKV Raft服务器")
 
 	// 停止API服务器
 	if s.apiServer != nil {
 		s.apiServer.Close()
 	}
 
+	// 停止后台任务
+	close(s.stopCh)
+	s.wg.Wait()
+	s.proposals.Stop()
+
 	// 停止Raft节点
 	if err := s.raftNode.Stop(); err != nil {
-		s.logger.Printf("停止Raft节点失败: %v", err)
+		s.logger.Error("停止Raft节点失败", logging.FieldError, err)
 	}
 
 	s.running = false
-	s.logger.Printf("服务器已停止")
+	s.logger.Info("服务器已停止")
 
 	return nil
 }
@@ -213,34 +774,95 @@ func (s *Server) startAPIServer() error {
 	mux := http.NewServeMux()
 
 	// 客户端API
-	mux.HandleFunc("/api/get", s.handleGet)
-	mux.HandleFunc("/api/set", s.handleSet)
-	mux.HandleFunc("/api/delete", s.handleDelete)
-	mux.HandleFunc("/api/keys", s.handleKeys)
+	mux.HandleFunc("/api/get", s.api(readRequest, s.handleGet, http.MethodGet))
+	mux.HandleFunc("/api/set", s.api(writeRequest, s.handleSet, http.MethodPost))
+	mux.HandleFunc("/api/delete", s.api(writeRequest, s.handleDelete, http.MethodDelete))
+	mux.HandleFunc("/api/delete-range", s.api(writeRequest, s.handleDeleteRange, http.MethodPost))
+	mux.HandleFunc("/api/keys", s.api(readRequest, s.handleKeys, http.MethodGet))
+	mux.HandleFunc("/api/batch", s.api(writeRequest, s.handleBatch, http.MethodPost))
+	mux.HandleFunc("/api/scan", s.api(readRequest, s.handleScan, http.MethodGet))
+	mux.HandleFunc("/api/count", s.api(readRequest, s.handleCount, http.MethodGet))
+	mux.HandleFunc("/api/cas", s.api(writeRequest, s.handleCAS, http.MethodPost))
+	mux.HandleFunc("/api/txn", s.api(writeRequest, s.handleTxn, http.MethodPost))
 
 	// 管理API
-	mux.HandleFunc("/api/status", s.handleStatus)
-	mux.HandleFunc("/api/metrics", s.handleMetrics)
+	mux.HandleFunc("/api/status", s.api(readRequest, s.handleStatus, http.MethodGet))
+	mux.HandleFunc("/api/health", s.api(readRequest, s.handleHealth, http.MethodGet))
+	mux.HandleFunc("/api/metrics", s.api(readRequest, s.handleMetrics, http.MethodGet))
+	mux.Handle("/metrics", s.metrics)
 	mux.HandleFunc("/api/logs", s.handleLogs)
+	mux.HandleFunc("/api/events", s.handleEvents)
+	mux.HandleFunc("/api/log/digest", s.handleLogDigest)
+	mux.HandleFunc("/api/digest", s.handleStateDigest)
+	mux.HandleFunc("/api/slowlog", s.handleSlowLog)
+	mux.HandleFunc("/api/backup", s.handleBackup)
 
 	// 集群管理API
 	mux.HandleFunc("/api/cluster/add", s.handleAddServer)
 	mux.HandleFunc("/api/cluster/remove", s.handleRemoveServer)
+	mux.HandleFunc("/api/cluster/promote", s.handlePromoteLearner)
 	mux.HandleFunc("/api/cluster/config", s.handleGetConfiguration)
+	mux.HandleFunc("/api/topology", s.handleTopology)
+	mux.HandleFunc("/api/topology/events", s.handleTopologyEvents)
+	mux.HandleFunc("/api/shards/split", s.handleShardSplit)
+	mux.HandleFunc("/api/shards/merge", s.handleShardMerge)
+	mux.HandleFunc("/api/shards/load", s.handleShardLoad)
+	mux.HandleFunc("/api/transfer-leader", s.handleTransferLeader)
+	mux.HandleFunc("/api/admin/drain", s.handleDrain)
+	mux.HandleFunc("/api/admin/snapshot", s.handleSnapshot)
+	mux.HandleFunc("/api/admin/quota", s.handleQuota)
+	mux.HandleFunc("/api/admin/usage", s.handleUsage)
+
+	// 故障转移人工确认与统计
+	mux.HandleFunc("/api/failover/pending", s.handleFailoverPending)
+	mux.HandleFunc("/api/failover/approve", s.handleFailoverApprove)
+	mux.HandleFunc("/api/failover/reject", s.handleFailoverReject)
+	mux.HandleFunc("/api/failover/stats", s.handleFailoverStats)
+
+	// 读写分离路由规则
+	mux.HandleFunc("/api/routes", s.handleRoutes)
+
+	// 跨DC异步复制的暂停与恢复
+	mux.HandleFunc("/api/replication/status", s.handleReplicationStatus)
+	mux.HandleFunc("/api/replication/pause", s.handleReplicationPause)
+	mux.HandleFunc("/api/replication/resume", s.handleReplicationResume)
+	mux.HandleFunc("/api/replication/inbound", s.handleReplicationInbound)
+
+	// 访问控制
+	mux.HandleFunc("/api/acl/tokens", s.handleACLTokens)
+
+	// 变更监听
+	mux.HandleFunc("/api/watch", s.handleWatch)
+
+	// 客户端会话
+	mux.HandleFunc("/api/session", s.handleSession)
+	mux.HandleFunc("/api/session/keepalive", s.handleSessionKeepAlive)
 
 	s.apiServer = &http.Server{
 		Addr:    s.config.APIAddr,
-		Handler: mux,
+		Handler: s.admit(s.authenticate(mux)),
+		// 请求头必须在读超时内到达，请求体与处理由api中间件按请求类别限时
+		ReadHeaderTimeout: s.config.ReadTimeout,
+	}
+	if s.tls != nil {
+		s.apiServer.TLSConfig = s.tls.APIServerConfig()
 	}
 
 	go func() {
-		s.logger.Printf("API服务器开始监听 %s", s.config.APIAddr)
-		if err := s.apiServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			s.logger.Printf("API服务器错误: %v", err)
+		s.logger.Info("API服务器开始监听", "api_addr", s.config.APIAddr)
+		var err error
+		if s.tls != nil {
+			// 证书由TLSConfig.GetCertificate提供，支持重新加载
+			err = s.apiServer.ListenAndServeTLS("", "")
+		} else {
+			err = s.apiServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			s.logger.Error("API服务器错误", logging.FieldError, err)
 		}
 	}()
 
-	s.logger.Printf("API服务器启动在 %s", s.config.APIAddr)
+	s.logger.Info("API服务器已启动", "api_addr", s.config.APIAddr)
 
 	// 等待一小段时间确保服务器启动
 	time.Sleep(time.Millisecond * 100)
@@ -265,31 +887,116 @@ func (s *Server) HandleInstallSnapshot(req *raft.InstallSnapshotRequest) *raft.I
 	return s.raftNode.HandleInstallSnapshot(req)
 }
 
+// HandleTimeoutNow 处理TimeoutNow请求
+func (s *Server) HandleTimeoutNow(req *raft.TimeoutNowRequest) *raft.TimeoutNowResponse {
+	return s.raftNode.HandleTimeoutNow(req)
+}
+
 // API处理器
 
 // handleGet 处理GET请求
 func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+	// consistency=stale（或stale=true）读取本地数据；其余情况由领导者处理
+	// consistency=linearizable时领导者通过ReadIndex确认后再读取
+	// minIndex=N时等待本地状态机应用到N后再读取（受读超时限制），传入自己写入返回的index即可读己之写
+	consistency := r.URL.Query().Get("consistency")
+	if r.URL.Query().Get("stale") == "true" && consistency == "" {
+		consistency = consistencyStale
+	}
+
+	switch consistency {
+	case "", consistencyStale, consistencyLinearizable:
+	default:
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, fmt.Sprintf("不支持的一致性级别: %s", consistency))
+		return
+	}
+
+	var minIndex raft.LogIndex
+	if v := r.URL.Query().Get("minIndex"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, "minIndex参数无效")
+			return
+		}
+		minIndex = raft.LogIndex(n)
+	}
+
+	if consistency != consistencyStale && s.redirectToLeader(w, r) {
+		return
+	}
+	// 带minIndex的读取已限定了陈旧程度，不受启动追赶门控限制
+	if consistency == consistencyStale && minIndex == 0 && !s.checkCatchUp(w) {
 		return
 	}
 
 	key := r.URL.Query().Get("key")
 	if key == "" {
-		http.Error(w, "缺少key参数", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "缺少key参数")
 		return
 	}
 
-	value, exists := s.stateMachine.Get(key)
+	if !s.authorizeKey(w, r, key, statemachine.ACLRead) {
+		return
+	}
+	s.load.record(key, false)
 
-	response := map[string]interface{}{
-		"key":    key,
-		"exists": exists,
+	if consistency == consistencyLinearizable {
+		ctx, cancel := requestContext(r)
+		defer cancel()
+
+		if _, err := s.raftNode.ReadIndex(ctx); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				writeError(w, http.StatusGatewayTimeout, codeTimeout, fmt.Sprintf("线性一致读超时: %v", err))
+				return
+			}
+			writeError(w, http.StatusServiceUnavailable, codeUnavailable, fmt.Sprintf("线性一致读失败: %v", err))
+			return
+		}
+	}
+
+	if minIndex > 0 {
+		ctx, cancel := requestContext(r)
+		defer cancel()
+
+		if applied, err := s.raftNode.WaitApplied(ctx, minIndex); err != nil {
+			writeAPIError(w, http.StatusGatewayTimeout, apiError{
+				Code:      codeTimeout,
+				Message:   fmt.Sprintf("等待应用到索引 %d 超时", minIndex),
+				RaftIndex: applied,
+			})
+			return
+		}
+	}
+
+	// 先取lastApplied再读取，读到的数据至少新于报告的索引
+	lastApplied := s.stateMachine.AppliedIndex()
+	value, meta, exists, err := s.stateMachine.GetWithMeta(key)
+	if !exists {
+		writeAPIError(w, http.StatusNotFound, apiError{Code: codeKeyNotFound, Message: fmt.Sprintf("键 %q 不存在", key), RaftIndex: lastApplied})
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, apiError{Code: codeInternal, Message: fmt.Sprintf("读取值失败: %v", err), RaftIndex: lastApplied})
+		return
 	}
 
-	if exists {
-		response["value"] = value
+	// lastApplied与leaderCommit供调用方判断本地数据的陈旧程度，leaderCommit未知时省略
+	response := map[string]interface{}{
+		"key":         key,
+		"exists":      true,
+		"value":       value,
+		"version":     s.stateMachine.GetVersion(key),
+		"lastApplied": lastApplied,
+	}
+	if s.raftNode != nil {
+		if commit := s.raftNode.LeaderCommitIndex(); commit > 0 {
+			response["leaderCommit"] = commit
+		}
+	}
+	if ttl, ok := s.stateMachine.GetTTL(key); ok {
+		response["ttlSeconds"] = int64(ttl.Seconds())
 	}
+	metaFields(response, meta)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -297,56 +1004,73 @@ func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
 
 // handleSet 处理SET请求
 func (s *Server) handleSet(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
+	if s.redirectWrite(w, r) {
 		return
 	}
 
 	var req struct {
-		Key   string      `json:"key"`
-		Value interface{} `json:"value"`
+		Key         string      `json:"key"`
+		Value       interface{} `json:"value"`
+		TTLSeconds  int64       `json:"ttlSeconds"`
+		ContentType string      `json:"contentType"`
+		Compress    bool        `json:"compress"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "解析请求失败", http.StatusBadRequest)
+	s.limitBody(w, r, 1)
+	if !decodeLimited(w, r, &req) {
 		return
 	}
 
 	if req.Key == "" {
-		http.Error(w, "key不能为空", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "key不能为空")
 		return
 	}
 
-	// 创建命令
-	cmdData, err := statemachine.CreateSetCommand(req.Key, req.Value)
-	if err != nil {
-		http.Error(w, "创建命令失败", http.StatusInternalServerError)
+	if req.TTLSeconds < 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "ttlSeconds不能为负数")
 		return
 	}
 
-	// 提议到Raft
-	if err := s.raftNode.Propose(cmdData); err != nil {
-		if err == raft.ErrNotLeader {
-			leader := s.raftNode.GetLeader()
-			response := map[string]interface{}{
-				"success": false,
-				"error":   "不是领导者",
-				"leader":  leader,
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
-			return
-		}
+	if err := s.checkEntry(req.Key, req.Value); err != nil {
+		writeEntryError(w, err)
+		return
+	}
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !s.authorizeKey(w, r, req.Key, statemachine.ACLWrite) {
+		return
+	}
+	s.load.record(req.Key, true)
+
+	// 压缩在提议之前完成，日志条目与各副本的状态机中都是压缩后的值
+	stored, meta, err := s.encodeValue(req.Value, req.ContentType, req.Compress)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, err.Error())
+		return
+	}
+
+	// 提议到Raft，与同一窗口内的其他写请求合并提交
+	cmd := statemachine.Command{Type: "SET", Key: req.Key, Value: stored, Meta: meta, TTLSeconds: req.TTLSeconds}
+	if err := attachSession(r, &cmd); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, err.Error())
+		return
+	}
+	index, _, ok := s.proposeCommand(w, r, cmd)
+	if !ok {
 		return
 	}
 
+	// index为命令的日志索引，返回时已提交并应用，客户端可将其作为minIndex从跟随者读取自己的写入
 	response := map[string]interface{}{
 		"success": true,
 		"key":     req.Key,
 		"value":   req.Value,
+		"index":   index,
+	}
+
+	if req.TTLSeconds > 0 {
+		response["ttlSeconds"] = req.TTLSeconds
 	}
+	metaFields(response, meta)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -354,123 +1078,478 @@ func (s *Server) handleSet(w http.ResponseWriter, r *http.Request) {
 
 // handleDelete 处理DELETE请求
 func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "DELETE" {
-		http.Error(w, "只支持DELETE方法", http.StatusMethodNotAllowed)
+	if s.redirectWrite(w, r) {
 		return
 	}
 
 	key := r.URL.Query().Get("key")
 	if key == "" {
-		http.Error(w, "缺少key参数", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "缺少key参数")
 		return
 	}
 
-	// 创建命令
-	cmdData, err := statemachine.CreateDeleteCommand(key)
-	if err != nil {
-		http.Error(w, "创建命令失败", http.StatusInternalServerError)
+	if !s.authorizeKey(w, r, key, statemachine.ACLWrite) {
 		return
 	}
+	s.load.record(key, true)
 
-	// 提议到Raft
-	if err := s.raftNode.Propose(cmdData); err != nil {
-		if err == raft.ErrNotLeader {
-			leader := s.raftNode.GetLeader()
-			response := map[string]interface{}{
-				"success": false,
-				"error":   "不是领导者",
-				"leader":  leader,
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
-			return
-		}
-
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// 提议到Raft，与同一窗口内的其他写请求合并提交
+	cmd := statemachine.Command{Type: "DELETE", Key: key}
+	if err := attachSession(r, &cmd); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, err.Error())
+		return
+	}
+	index, _, ok := s.proposeCommand(w, r, cmd)
+	if !ok {
 		return
 	}
 
 	response := map[string]interface{}{
 		"success": true,
 		"key":     key,
+		"index":   index,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// handleKeys 处理获取所有键的请求
-func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+// BatchOperation 批量请求中的单个操作
+type BatchOperation struct {
+	Op         string      `json:"op"`         // 操作类型: set, delete
+	Key        string      `json:"key"`        // 键
+	Value      interface{} `json:"value"`      // 值（仅set使用）
+	TTLSeconds int64       `json:"ttlSeconds"` // 过期时间（仅set使用）
+}
+
+// BatchOperationResult 批量请求中单个操作的结果
+type BatchOperationResult struct {
+	Op      string `json:"op"`
+	Key     string `json:"key"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// handleBatch 处理批量写请求，所有合法操作作为一个Raft日志条目提交
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if s.redirectWrite(w, r) {
 		return
 	}
 
-	keys := s.stateMachine.Keys()
-
-	response := map[string]interface{}{
-		"keys":  keys,
-		"count": len(keys),
+	var ops []BatchOperation
+	if s.config.MaxLogEntries > 0 {
+		s.limitBody(w, r, s.config.MaxLogEntries)
+	}
+	if !decodeLimited(w, r, &ops) {
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
+	if len(ops) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "批量操作不能为空")
+		return
+	}
 
-// handleStatus 处理状态查询请求
-func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+	if s.config.MaxLogEntries > 0 && len(ops) > s.config.MaxLogEntries {
+		writeError(w, http.StatusRequestEntityTooLarge, codeValueTooLarge,
+			fmt.Sprintf("批量操作数 %d 超过上限 %d，请拆分后重试", len(ops), s.config.MaxLogEntries))
 		return
 	}
 
-	s.logger.Printf("处理状态查询请求")
+	// 任一操作越权时拒绝整个批次，避免部分写入
+	for _, op := range ops {
+		if op.Key != "" && !s.authorizeKey(w, r, op.Key, statemachine.ACLWrite) {
+			return
+		}
+	}
 
-	s.logger.Printf("获取Raft指标...")
-	metrics := s.raftNode.GetMetrics()
-	s.logger.Printf("获取Raft指标完成")
+	// 校验每个操作，只有合法的操作会被提交
+	results := make([]BatchOperationResult, len(ops))
+	commands := make([]statemachine.Command, 0, len(ops))
+	accepted := make([]int, 0, len(ops))
 
-	s.logger.Printf("检查是否为领导者...")
-	isLeader := s.raftNode.IsLeader()
-	s.logger.Printf("检查是否为领导者完成: %v", isLeader)
+	for i, op := range ops {
+		results[i] = BatchOperationResult{Op: op.Op, Key: op.Key}
 
-	s.logger.Printf("获取存储大小...")
+		if op.Key == "" {
+			results[i].Error = "key不能为空"
+			continue
+		}
+
+		switch op.Op {
+		case "set":
+			if op.TTLSeconds < 0 {
+				results[i].Error = "ttlSeconds不能为负数"
+				continue
+			}
+			if err := s.checkEntry(op.Key, op.Value); err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			commands = append(commands, statemachine.Command{Type: "SET", Key: op.Key, Value: op.Value, TTLSeconds: op.TTLSeconds})
+		case "delete":
+			commands = append(commands, statemachine.Command{Type: "DELETE", Key: op.Key})
+		default:
+			results[i].Error = fmt.Sprintf("未知操作类型: %s", op.Op)
+			continue
+		}
+		accepted = append(accepted, i)
+		s.load.record(op.Key, true)
+	}
+
+	response := map[string]interface{}{
+		"success": len(accepted) == len(ops),
+		"results": results,
+	}
+
+	if len(commands) > 0 {
+		// 提议到Raft，所有合法操作作为一个日志条目应用
+		cmd := statemachine.Command{Type: "BATCH", Ops: commands}
+		if err := attachSession(r, &cmd); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, err.Error())
+			return
+		}
+		index, _, ok := s.proposeCommand(w, r, cmd)
+		if !ok {
+			return
+		}
+
+		for _, i := range accepted {
+			results[i].Success = true
+		}
+		response["index"] = index
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 扫描分页参数
+const (
+	defaultScanLimit = 100
+	maxScanLimit     = 1000
+)
+
+// handleScan 处理前缀扫描请求，通过不透明游标分页
+func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	withValues := query.Get("values") == "true"
+	stale := query.Get("stale") == "true"
+
+	limit := defaultScanLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, "limit参数无效")
+			return
+		}
+		if n > maxScanLimit {
+			n = maxScanLimit
+		}
+		limit = n
+	}
+
+	var after string
+	if c := query.Get("cursor"); c != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(c)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, "cursor参数无效")
+			return
+		}
+		after = string(decoded)
+	}
+
+	// 非stale扫描必须由领导者处理
+	if !stale && s.redirectToLeader(w, r) {
+		return
+	}
+	if stale && !s.checkCatchUp(w) {
+		return
+	}
+
+	if !s.authorizePrefix(w, r, prefix) {
+		return
+	}
+
+	entries, more := s.stateMachine.Scan(prefix, after, limit)
+
+	// 游标指向扫描到的最后一个键，过滤掉的无权读取的键不会被重复扫描
+	var last string
+	if len(entries) > 0 {
+		last = entries[len(entries)-1].Key
+	}
+
+	if principal := principalFrom(r); principal != nil && !principal.Admin {
+		visible := entries[:0]
+		for _, entry := range entries {
+			if principal.Allows(entry.Key, statemachine.ACLRead) {
+				visible = append(visible, entry)
+			}
+		}
+		entries = visible
+	}
+
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.Key
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"keys":    keys,
+		"count":   len(keys),
+		"hasMore": more,
+	}
+
+	if withValues {
+		items, err := scanItems(entries)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("读取值失败: %v", err))
+			return
+		}
+		response["items"] = items
+	}
+
+	if more && last != "" {
+		response["cursor"] = base64.RawURLEncoding.EncodeToString([]byte(last))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 读一致性级别
+const (
+	consistencyStale        = "stale"
+	consistencyLinearizable = "linearizable"
+)
+
+// applyWaitTimeout 等待命令被应用的超时时间
+const applyWaitTimeout = 5 * time.Second
+
+// handleCAS 处理比较并交换请求，条件在状态机应用日志时检查
+func (s *Server) handleCAS(w http.ResponseWriter, r *http.Request) {
+	if s.redirectWrite(w, r) {
+		return
+	}
+
+	var req struct {
+		Key             string      `json:"key"`
+		ExpectedValue   interface{} `json:"expectedValue"`
+		ExpectedVersion *uint64     `json:"expectedVersion"`
+		NewValue        interface{} `json:"newValue"`
+		TTLSeconds      int64       `json:"ttlSeconds"`
+	}
+
+	s.limitBody(w, r, 1)
+	if !decodeLimited(w, r, &req) {
+		return
+	}
+
+	if req.Key == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "key不能为空")
+		return
+	}
+
+	if req.TTLSeconds < 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "ttlSeconds不能为负数")
+		return
+	}
+
+	if err := s.checkEntry(req.Key, req.NewValue); err != nil {
+		writeEntryError(w, err)
+		return
+	}
+
+	// CAS的响应包含当前值，需要同时拥有读写权限
+	if !s.authorizeKey(w, r, req.Key, statemachine.ACLWrite) || !s.authorizeKey(w, r, req.Key, statemachine.ACLRead) {
+		return
+	}
+	s.load.record(req.Key, true)
+
+	// 提议到Raft，与同一窗口内的其他写请求合并提交
+	cmd := statemachine.Command{Type: "CAS", Key: req.Key, Value: req.NewValue, TTLSeconds: req.TTLSeconds}
+	if req.ExpectedVersion != nil {
+		cmd.ExpectedVersion = req.ExpectedVersion
+	} else {
+		cmd.Expected = req.ExpectedValue
+	}
+	if err := attachSession(r, &cmd); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, err.Error())
+		return
+	}
+
+	index, result, ok := s.proposeCommand(w, r, cmd)
+	if !ok {
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"key":     req.Key,
+		"index":   index,
+		"swapped": result.Swapped,
+		"exists":  result.Exists,
+		"version": result.Version,
+	}
+	if result.Exists {
+		response["value"] = result.Value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// proposeCommand 通过提议批处理器提交命令并等待其被应用
+// 失败时写入相应的错误响应并返回false
+func (s *Server) proposeCommand(w http.ResponseWriter, r *http.Request, cmd statemachine.Command) (raft.LogIndex, *statemachine.CommandResult, bool) {
+	ctx, cancel := requestContext(r)
+	defer cancel()
+
+	traceFrom(r.Context()).setCommand(cmd.Type, cmd.Key)
+	index, result, err := s.submitCommand(ctx, cmd)
+	if err == nil {
+		if result.Duplicate {
+			w.Header().Set(duplicateHeader, "true")
+		}
+		return index, result, true
+	}
+
+	var notLeader *kverrors.NotLeaderError
+	switch {
+	case errors.As(err, &notLeader):
+		writeAPIError(w, http.StatusServiceUnavailable, s.notLeaderError(raft.NodeID(notLeader.LeaderID), notLeader.LeaderAddr))
+	case errors.Is(err, ErrProposalQueueFull):
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "写请求过多，请稍后重试")
+	case errors.Is(err, errInvalidCommand), errors.Is(err, statemachine.ErrInvalidRange):
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, err.Error())
+	case errors.Is(err, statemachine.ErrSessionExpired):
+		writeError(w, http.StatusGone, codeSessionExpired, err.Error())
+	case errors.Is(err, statemachine.ErrStaleSequence), errors.Is(err, kverrors.ErrConflict):
+		writeError(w, http.StatusConflict, codeConflict, err.Error())
+	case errors.Is(err, statemachine.ErrTooManyPendingResponses):
+		writeError(w, http.StatusTooManyRequests, codeUnavailable, err.Error())
+	case errors.Is(err, statemachine.ErrQuotaExceeded):
+		writeError(w, http.StatusInsufficientStorage, codeQuotaExceeded, err.Error())
+	case errors.Is(err, statemachine.ErrShardNotFound), errors.Is(err, statemachine.ErrShardMigrating), errors.Is(err, statemachine.ErrInvalidShardOp):
+		writeShardError(w, err)
+	case errors.Is(err, kverrors.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		// 命令可能已追加到日志，客户端可凭raftIndex确认其最终是否被应用
+		writeAPIError(w, http.StatusGatewayTimeout, apiError{Code: codeTimeout, Message: "等待命令提交超时", RaftIndex: index})
+	case errors.Is(err, ErrBatcherStopped):
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, err.Error())
+	case errors.Is(err, errForwardFailed):
+		writeError(w, http.StatusBadGateway, codeUnavailable, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+	}
+
+	return 0, nil, false
+}
+
+// nextRequestID 生成节点内唯一的请求ID
+func (s *Server) nextRequestID() string {
+	return fmt.Sprintf("%s-%d-%d", s.config.NodeID, time.Now().UnixNano(), s.requestSeq.Add(1))
+}
+
+// handleKeys 处理获取所有键的请求
+func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	// 键列表总是读取本地状态，与stale读一样受启动追赶门控限制
+	if !s.checkCatchUp(w) {
+		return
+	}
+	if !s.authorizePrefix(w, r, "") {
+		return
+	}
+
+	keys := readableKeys(r, s.stateMachine.Keys())
+
+	response := map[string]interface{}{
+		"keys":  keys,
+		"count": len(keys),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleStatus 处理状态查询请求
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.logger.Debug("处理状态查询请求")
+
+	metrics := s.raftNode.GetMetrics()
+	isLeader := s.raftNode.IsLeader()
 	storageSize := s.stateMachine.Size()
-	s.logger.Printf("获取存储大小完成: %d", storageSize)
 
 	response := map[string]interface{}{
-		"nodeId":       s.config.NodeID,
-		"state":        metrics.State.String(),
-		"term":         metrics.CurrentTerm,
-		"leader":       metrics.Leader,
-		"lastLogIndex": metrics.LastLogIndex,
-		"commitIndex":  metrics.CommitIndex,
-		"lastApplied":  metrics.LastApplied,
-		"isLeader":     isLeader,
-		"storageSize":  storageSize,
-	}
-
-	s.logger.Printf("发送响应...")
+		"nodeId":        s.config.NodeID,
+		"state":         metrics.State.String(),
+		"role":          s.raftNode.GetRole(),
+		"term":          metrics.CurrentTerm,
+		"leader":        metrics.LeaderID,
+		"lastLogIndex":  s.storage.GetLastLogIndex(),
+		"commitIndex":   metrics.CommitIndex,
+		"lastApplied":   metrics.LastApplied,
+		"isLeader":      isLeader,
+		"storageSize":   storageSize,
+		"sessions":      s.stateMachine.SessionCount(),
+		"watchers":      s.watches.count(),
+		"configuration": s.raftNode.GetConfiguration().Servers,
+		"learners":      s.raftNode.GetLearners(),
+		"draining":      s.draining.Load(),
+	}
+	s.catchUpFields(response)
+	if s.restored != nil {
+		response["restoredFrom"] = map[string]interface{}{
+			"index":     s.restored.Index,
+			"term":      s.restored.Term,
+			"nodeId":    s.restored.NodeID,
+			"createdAt": s.restored.CreatedAt,
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
-	s.logger.Printf("状态查询请求处理完成")
 }
 
 // handleMetrics 处理指标查询请求
 func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+	metrics := s.raftNode.GetMetrics()
+
+	if r.URL.Query().Get("format") == "prometheus" {
+		w.Header().Set("Content-Type", prometheusContentType)
+		var syncStats *storage.SyncStats
+		if wal, ok := s.storage.(*storage.WALStorage); ok {
+			stats := wal.SyncStats()
+			syncStats = &stats
+		}
+		writePrometheusMetrics(w, s.config.NodeID, metrics, syncStats)
+		writeQueueMetrics(w, s.config.NodeID, s.queueStats(metrics))
+
+		s.mu.RLock()
+		router := s.routes
+		s.mu.RUnlock()
+		if router != nil {
+			writeRouterLatencyMetrics(w, s.config.NodeID, router.GetLatencyHistograms())
+		}
 		return
 	}
 
-	metrics := s.raftNode.GetMetrics()
 	storageStats := s.storage.GetLogStats()
 
 	response := map[string]interface{}{
 		"raft":    metrics,
 		"storage": storageStats,
+		"queues":  s.queueStats(metrics),
+		"waiters": s.stateMachine.WaiterStats(),
 		"data":    s.stateMachine.GetAll(),
 	}
+	if s.config.ForwardWrites || s.forwardStats.received.Load() > 0 {
+		forwarding := s.forwardStats.snapshot()
+		forwarding.PendingTokens = s.forwardedWrites.size()
+		response["forwarding"] = forwarding
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -483,12 +1562,61 @@ func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
 	logs := s.storage.DebugLogs()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(logs))
 }
 
+// maxLogDigestRange 单次摘要请求最多覆盖的日志条目数
+const maxLogDigestRange = 10000
+
+// handleLogDigest 返回[start, end]范围内日志条目的(索引, 任期, 校验和)，供其他数据中心比较日志
+func (s *Server) handleLogDigest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	start, err := strconv.ParseUint(r.URL.Query().Get("start"), 10, 64)
+	if err != nil {
+		http.Error(w, "start参数无效", http.StatusBadRequest)
+		return
+	}
+	end, err := strconv.ParseUint(r.URL.Query().Get("end"), 10, 64)
+	if err != nil || end < start {
+		http.Error(w, "end参数无效", http.StatusBadRequest)
+		return
+	}
+	if end-start >= maxLogDigestRange {
+		http.Error(w, fmt.Sprintf("单次最多请求 %d 个条目", maxLogDigestRange), http.StatusBadRequest)
+		return
+	}
+
+	digests, err := raft.ComputeLogDigests(s.storage, raft.LogIndex(start), raft.LogIndex(end))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":      true,
+		"lastLogIndex": s.storage.GetLastLogIndex(),
+		"digests":      digests,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
 // handleAddServer 处理添加服务器请求
 func (s *Server) handleAddServer(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
@@ -496,9 +1624,15 @@ func (s *Server) handleAddServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
 	var req struct {
-		ID      string `json:"id"`
-		Address string `json:"address"`
+		ID         string `json:"id"`
+		Address    string `json:"address"`
+		DataCenter string `json:"dataCenter"`
+		Learner    bool   `json:"learner"` // 以学习者身份加入，不改变提交仲裁
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -511,31 +1645,30 @@ func (s *Server) handleAddServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.redirectToLeader(w, r) {
+		return
+	}
+
 	server := raft.Server{
-		ID:      raft.NodeID(req.ID),
-		Address: req.Address,
+		ID:          raft.NodeID(req.ID),
+		Address:     req.Address,
+		DataCenter:  raft.DataCenterID(req.DataCenter),
+		ReplicaType: raft.PrimaryReplica,
+		IsLearner:   req.Learner,
+	}
+	if server.DataCenter == "" {
+		server.DataCenter = s.config.DataCenter
 	}
 
 	if err := s.raftNode.AddServer(server); err != nil {
-		if err == raft.ErrNotLeader {
-			leader := s.raftNode.GetLeader()
-			response := map[string]interface{}{
-				"success": false,
-				"error":   "不是领导者",
-				"leader":  leader,
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
-			return
-		}
-
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.writeMembershipError(w, err)
 		return
 	}
 
 	response := map[string]interface{}{
-		"success": true,
-		"message": fmt.Sprintf("服务器 %s 添加成功", req.ID),
+		"success":       true,
+		"message":       fmt.Sprintf("服务器 %s 添加成功", req.ID),
+		"configuration": s.raftNode.GetConfiguration().Servers,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -549,6 +1682,10 @@ func (s *Server) handleRemoveServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
 	var req struct {
 		ID string `json:"id"`
 	}
@@ -563,32 +1700,96 @@ func (s *Server) handleRemoveServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.redirectToLeader(w, r) {
+		return
+	}
+
 	if err := s.raftNode.RemoveServer(raft.NodeID(req.ID)); err != nil {
-		if err == raft.ErrNotLeader {
-			leader := s.raftNode.GetLeader()
-			response := map[string]interface{}{
-				"success": false,
-				"error":   "不是领导者",
-				"leader":  leader,
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
-			return
-		}
+		s.writeMembershipError(w, err)
+		return
+	}
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	response := map[string]interface{}{
+		"success":       true,
+		"message":       fmt.Sprintf("服务器 %s 移除成功", req.ID),
+		"configuration": s.raftNode.GetConfiguration().Servers,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handlePromoteLearner 处理学习者提升请求，学习者追上日志后成为投票成员
+func (s *Server) handlePromoteLearner(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "解析请求失败", http.StatusBadRequest)
+		return
+	}
+
+	if req.ID == "" {
+		http.Error(w, "id不能为空", http.StatusBadRequest)
+		return
+	}
+
+	if s.redirectToLeader(w, r) {
+		return
+	}
+
+	if err := s.raftNode.PromoteLearner(raft.NodeID(req.ID)); err != nil {
+		s.writeMembershipError(w, err)
 		return
 	}
 
 	response := map[string]interface{}{
-		"success": true,
-		"message": fmt.Sprintf("服务器 %s 移除成功", req.ID),
+		"success":       true,
+		"message":       fmt.Sprintf("学习者 %s 已提升为投票成员", req.ID),
+		"configuration": s.raftNode.GetConfiguration().Servers,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// writeMembershipError 将成员变更错误转换为HTTP响应
+func (s *Server) writeMembershipError(w http.ResponseWriter, err error) {
+	if err == raft.ErrNotLeader {
+		leader := s.raftNode.GetLeader()
+		response := map[string]interface{}{
+			"success": false,
+			"error":   "不是领导者",
+			"leader":  leader,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, raft.ErrConfigChangeInProgress):
+		status = http.StatusConflict
+	case errors.Is(err, raft.ErrLearnerCatchUpTimeout):
+		status = http.StatusGatewayTimeout
+	case errors.Is(err, raft.ErrNotLearner):
+		status = http.StatusBadRequest
+	}
+
+	http.Error(w, err.Error(), status)
+}
+
 // handleGetConfiguration 处理获取集群配置请求
 func (s *Server) handleGetConfiguration(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
@@ -607,6 +1808,134 @@ func (s *Server) handleGetConfiguration(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(response)
 }
 
+// handleTransferLeader 处理领导权转移请求
+func (s *Server) handleTransferLeader(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "target参数不能为空", http.StatusBadRequest)
+		return
+	}
+
+	if s.redirectToLeader(w, r) {
+		return
+	}
+
+	previous := s.raftNode.GetLeader()
+	if err := s.raftNode.TransferLeadership(raft.NodeID(target)); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, raft.ErrUnknownTransferee):
+			status = http.StatusBadRequest
+		case errors.Is(err, raft.ErrTransferInProgress):
+			status = http.StatusConflict
+		case errors.Is(err, raft.ErrTransferTimeout):
+			status = http.StatusGatewayTimeout
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"leader":  s.raftNode.GetLeader(),
+		})
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":        true,
+		"previousLeader": previous,
+		"leader":         target,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleSnapshot 立即在本节点创建快照并压缩日志，返回之后的快照指标
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	snapshot, err := s.raftNode.TakeSnapshot()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("创建快照失败: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Info("手动创建快照", "last_included_index", snapshot.LastSnapshotIndex)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"nodeId":   s.config.NodeID,
+		"snapshot": snapshot,
+	})
+}
+
+// 过期键清理参数
+const (
+	expirationSweepInterval = time.Second
+	expirationSweepBatch    = 100
+)
+
+// expirationSweepLoop 定期清理已过期的键与空闲超时的客户端会话，并重新激活迁移中断的分片
+// 仅领导者提议清理命令，实际删除通过Raft日志在所有副本上确定性地执行
+func (s *Server) expirationSweepLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(expirationSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			if !s.raftNode.IsLeader() {
+				continue
+			}
+
+			s.sweepExpiredKeys()
+			s.sweepIdleSessions()
+			s.resumeShardActivation()
+		}
+	}
+}
+
+// sweepExpiredKeys 提议删除已过期的键
+func (s *Server) sweepExpiredKeys() {
+	keys := s.stateMachine.ExpiredKeys(time.Now(), expirationSweepBatch)
+	if len(keys) == 0 {
+		return
+	}
+
+	cmdData, err := statemachine.CreateExpireCommand(keys)
+	if err != nil {
+		s.logger.Error("创建过期清理命令失败", logging.FieldError, err)
+		return
+	}
+
+	if _, err := s.raftNode.ProposeWithIndex(cmdData); err != nil && err != raft.ErrNotLeader && err != raft.ErrTransferInProgress {
+		s.logger.Warn("提议过期清理命令失败", logging.FieldError, err)
+	}
+}
+
 // GetRaftNode 获取Raft节点（用于测试）
 func (s *Server) GetRaftNode() *raft.Node {
 	return s.raftNode
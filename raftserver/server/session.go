@@ -0,0 +1,166 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - session.go
+ */
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// 客户端会话请求头：写请求携带会话ID与序号后，超时重试不会被重复执行
+const (
+	sessionIDHeader  = "X-Concord-Session"
+	sessionSeqHeader = "X-Concord-Seq"
+	sessionAckHeader = "X-Concord-Ack" // 客户端已收到响应的序号，之前的缓存响应可以丢弃
+
+	// duplicateHeader 响应是会话中已应用过的请求的缓存结果
+	duplicateHeader = "X-Concord-Duplicate"
+)
+
+// 会话参数
+const (
+	defaultSessionTimeout = time.Minute
+	sessionSweepBatch     = 100
+)
+
+// attachSession 把请求头中的会话信息写入命令，未携带会话时不做修改
+func attachSession(r *http.Request, cmd *statemachine.Command) error {
+	id := r.Header.Get(sessionIDHeader)
+	if id == "" {
+		return nil
+	}
+
+	seq, err := strconv.ParseUint(r.Header.Get(sessionSeqHeader), 10, 64)
+	if err != nil || seq == 0 {
+		return fmt.Errorf("%s请求头无效，应为正整数", sessionSeqHeader)
+	}
+
+	var ack uint64
+	if v := r.Header.Get(sessionAckHeader); v != "" {
+		if ack, err = strconv.ParseUint(v, 10, 64); err != nil || ack >= seq {
+			return fmt.Errorf("%s请求头无效，应小于请求序号", sessionAckHeader)
+		}
+	}
+
+	cmd.SessionID = id
+	cmd.SeqNum = seq
+	cmd.AckSeq = ack
+	return nil
+}
+
+// newSessionID 生成随机会话ID
+func newSessionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成会话ID失败: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// handleSession 管理客户端会话：POST注册新会话，DELETE关闭会话
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" && r.Method != "DELETE" {
+		http.Error(w, "只支持POST、DELETE方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.redirectWrite(w, r) {
+		return
+	}
+
+	cmd := statemachine.Command{Type: "SESSION_REGISTER", SessionTimeoutMs: s.config.SessionTimeout.Milliseconds()}
+	if r.Method == "DELETE" {
+		cmd = statemachine.Command{Type: "SESSION_CLOSE", SessionID: r.Header.Get(sessionIDHeader)}
+	} else {
+		id, err := newSessionID()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		cmd.SessionID = id
+	}
+
+	index, _, ok := s.proposeCommand(w, r, cmd)
+	if !ok {
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":   true,
+		"sessionId": cmd.SessionID,
+		"index":     index,
+	}
+	if cmd.Type == "SESSION_REGISTER" {
+		response["timeoutMs"] = cmd.SessionTimeoutMs
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleSessionKeepAlive 刷新会话的活动时间，空闲的客户端需定期调用以免会话过期
+func (s *Server) handleSessionKeepAlive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.redirectWrite(w, r) {
+		return
+	}
+
+	cmd := statemachine.Command{Type: "SESSION_KEEPALIVE", SessionID: r.Header.Get(sessionIDHeader)}
+	if v := r.Header.Get(sessionAckHeader); v != "" {
+		ack, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s请求头无效", sessionAckHeader), http.StatusBadRequest)
+			return
+		}
+		cmd.AckSeq = ack
+	}
+
+	if _, _, ok := s.proposeCommand(w, r, cmd); !ok {
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":   true,
+		"sessionId": cmd.SessionID,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// sweepIdleSessions 清理空闲超时的会话
+// 仅领导者提议SESSION_EXPIRE命令，是否过期在应用时按日志时间戳判断，所有副本结果一致
+func (s *Server) sweepIdleSessions() {
+	ids := s.stateMachine.IdleSessions(time.Now(), sessionSweepBatch)
+	if len(ids) == 0 {
+		return
+	}
+
+	cmdData, err := statemachine.CreateSessionExpireCommand(ids)
+	if err != nil {
+		s.logger.Error("创建会话清理命令失败", logging.FieldError, err)
+		return
+	}
+
+	if _, err := s.raftNode.ProposeWithIndex(cmdData); err != nil && err != raft.ErrNotLeader && err != raft.ErrTransferInProgress {
+		s.logger.Warn("提议会话清理命令失败", logging.FieldError, err)
+	}
+}
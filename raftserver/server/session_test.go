@@ -0,0 +1,142 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - session_test.go
+ */
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// TestSessionDeduplication 会话中重复的命令返回首次应用的结果而不再执行，会话状态随快照恢复
+func TestSessionDeduplication(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	start := time.Now()
+
+	register := statemachine.Command{Type: "SESSION_REGISTER", SessionID: "s1", SessionTimeoutMs: time.Minute.Milliseconds()}
+	if _, err := applyCommandAt(t, sm, 1, start, register); err != nil {
+		t.Fatalf("注册会话失败: %v", err)
+	}
+
+	// 仅在键不存在时创建：首次执行成功，重试若被再次执行将返回失败
+	create := statemachine.Command{Type: "CAS", Key: "counter", Value: "1", SessionID: "s1", SeqNum: 1}
+	first, err := applyCommandAt(t, sm, 2, start, create)
+	if err != nil || !first.Swapped {
+		t.Fatalf("首次CAS应成功: %+v %v", first, err)
+	}
+
+	retry, err := applyCommandAt(t, sm, 3, start, create)
+	if err != nil || !retry.Swapped || !retry.Duplicate || retry.Version != first.Version {
+		t.Fatalf("重试应返回缓存的结果: %+v %v", retry, err)
+	}
+	if version := sm.GetVersion("counter"); version != 2 {
+		t.Errorf("重复的命令不应再次执行，版本 %d", version)
+	}
+
+	// 乱序到达的序号各自执行
+	if _, err := applyCommandAt(t, sm, 4, start, statemachine.Command{Type: "SET", Key: "b", Value: "x", SessionID: "s1", SeqNum: 3}); err != nil {
+		t.Fatalf("SET失败: %v", err)
+	}
+	if _, err := applyCommandAt(t, sm, 5, start, statemachine.Command{Type: "SET", Key: "a", Value: "x", SessionID: "s1", SeqNum: 2, AckSeq: 1}); err != nil {
+		t.Fatalf("SET失败: %v", err)
+	}
+
+	// 已确认的序号无法再判断是否重复
+	if _, err := applyCommandAt(t, sm, 6, start, create); !errors.Is(err, statemachine.ErrStaleSequence) {
+		t.Errorf("已确认的序号应被拒绝，实际 %v", err)
+	}
+
+	snapshot, err := sm.CreateSnapshot()
+	if err != nil {
+		t.Fatalf("创建快照失败: %v", err)
+	}
+	restored := statemachine.NewKVStateMachine()
+	if err := restored.RestoreSnapshot(snapshot); err != nil {
+		t.Fatalf("恢复快照失败: %v", err)
+	}
+	result, err := applyCommandAt(t, restored, 7, start, statemachine.Command{Type: "SET", Key: "b", Value: "y", SessionID: "s1", SeqNum: 3})
+	if err != nil || !result.Duplicate {
+		t.Fatalf("快照恢复后重试应返回缓存的结果: %+v %v", result, err)
+	}
+	if value, _ := restored.Get("b"); value != "x" {
+		t.Errorf("重复的SET不应覆盖值，实际 %v", value)
+	}
+}
+
+// TestSessionExpiry 会话按日志时间戳判断空闲超时，期间有活动的会话不会被清理
+func TestSessionExpiry(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	start := time.Now()
+
+	for i, id := range []string{"idle", "active"} {
+		cmd := statemachine.Command{Type: "SESSION_REGISTER", SessionID: id, SessionTimeoutMs: 1000}
+		if _, err := applyCommandAt(t, sm, raft.LogIndex(i+1), start, cmd); err != nil {
+			t.Fatalf("注册会话失败: %v", err)
+		}
+	}
+
+	keepAlive := statemachine.Command{Type: "SESSION_KEEPALIVE", SessionID: "active"}
+	if _, err := applyCommandAt(t, sm, 3, start.Add(900*time.Millisecond), keepAlive); err != nil {
+		t.Fatalf("刷新会话失败: %v", err)
+	}
+
+	// 领导者依据本地时钟挑选候选会话，应用时再按日志时间戳确认
+	ids := sm.IdleSessions(start.Add(1500*time.Millisecond), 10)
+	if len(ids) != 1 || ids[0] != "idle" {
+		t.Fatalf("空闲会话不正确: %v", ids)
+	}
+	expire := statemachine.Command{Type: "SESSION_EXPIRE", Sessions: []string{"idle", "active"}}
+	if _, err := applyCommandAt(t, sm, 4, start.Add(1500*time.Millisecond), expire); err != nil {
+		t.Fatalf("清理会话失败: %v", err)
+	}
+	if sm.SessionCount() != 1 {
+		t.Fatalf("应只清理空闲的会话，剩余 %d", sm.SessionCount())
+	}
+
+	write := statemachine.Command{Type: "SET", Key: "k", Value: "v", SessionID: "idle", SeqNum: 1}
+	if _, err := applyCommandAt(t, sm, 5, start.Add(1600*time.Millisecond), write); !errors.Is(err, statemachine.ErrSessionExpired) {
+		t.Errorf("过期会话的写入应被拒绝，实际 %v", err)
+	}
+	if _, exists := sm.Get("k"); exists {
+		t.Errorf("过期会话的写入不应生效")
+	}
+}
+
+// TestAttachSession 解析写请求携带的会话请求头
+func TestAttachSession(t *testing.T) {
+	cases := []struct {
+		id, seq, ack string
+		ok           bool
+	}{
+		{"", "", "", true},
+		{"s1", "1", "", true},
+		{"s1", "5", "4", true},
+		{"s1", "", "", false},
+		{"s1", "0", "", false},
+		{"s1", "x", "", false},
+		{"s1", "5", "5", false},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/api/set", nil)
+		for header, value := range map[string]string{sessionIDHeader: c.id, sessionSeqHeader: c.seq, sessionAckHeader: c.ack} {
+			if value != "" {
+				req.Header.Set(header, value)
+			}
+		}
+
+		var cmd statemachine.Command
+		if err := attachSession(req, &cmd); (err == nil) != c.ok {
+			t.Errorf("会话=%q 序号=%q 确认=%q：期望成功=%v，实际 %v", c.id, c.seq, c.ack, c.ok, err)
+		}
+	}
+}
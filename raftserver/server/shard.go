@@ -0,0 +1,212 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-16 10:05:27
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-16 10:05:27
+* @Description: ConcordKV Raft consensus server - shard.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"raftserver/logging"
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// handleShardSplit 在splitHash处拆分分片，拆分点成为右分片的起点
+// 请求体为{"shardId", "splitHash"或"splitKey", "dryRun"}；splitKey按分片使用的键哈希算法换算为拆分点
+// dryRun为true时只校验并返回拆分后的分片，不修改分片表
+func (s *Server) handleShardSplit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.redirectToLeader(w, r) || !s.requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		ShardID   string  `json:"shardId"`
+		SplitHash *uint64 `json:"splitHash"`
+		SplitKey  string  `json:"splitKey"`
+		DryRun    bool    `json:"dryRun"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "解析请求失败", http.StatusBadRequest)
+		return
+	}
+
+	if req.ShardID == "" {
+		http.Error(w, "shardId不能为空", http.StatusBadRequest)
+		return
+	}
+	if (req.SplitHash == nil) == (req.SplitKey == "") {
+		http.Error(w, "需要指定splitHash或splitKey之一", http.StatusBadRequest)
+		return
+	}
+
+	splitHash := s.stateMachine.HashKey(req.SplitKey)
+	if req.SplitHash != nil {
+		splitHash = *req.SplitHash
+	}
+
+	if req.DryRun {
+		planned, err := s.stateMachine.PlanShardSplit(req.ShardID, splitHash)
+		if err != nil {
+			writeShardError(w, err)
+			return
+		}
+		writeShardPlan(w, planned)
+		return
+	}
+
+	cmd := statemachine.Command{
+		Type:    "SHARD_SPLIT",
+		ShardOp: &statemachine.ShardOp{ShardID: req.ShardID, SplitHash: splitHash},
+	}
+	s.changeShards(w, r, cmd, "拆分")
+}
+
+// handleShardMerge 将右分片并入左分片，两者的哈希范围必须首尾相接
+// 请求体为{"leftId", "rightId", "dryRun"}，dryRun语义同handleShardSplit
+func (s *Server) handleShardMerge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.redirectToLeader(w, r) || !s.requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		LeftID  string `json:"leftId"`
+		RightID string `json:"rightId"`
+		DryRun  bool   `json:"dryRun"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "解析请求失败", http.StatusBadRequest)
+		return
+	}
+
+	if req.LeftID == "" || req.RightID == "" {
+		http.Error(w, "leftId和rightId不能为空", http.StatusBadRequest)
+		return
+	}
+
+	if req.DryRun {
+		merged, err := s.stateMachine.PlanShardMerge(req.LeftID, req.RightID)
+		if err != nil {
+			writeShardError(w, err)
+			return
+		}
+		writeShardPlan(w, []statemachine.ShardRecord{merged})
+		return
+	}
+
+	cmd := statemachine.Command{
+		Type:    "SHARD_MERGE",
+		ShardOp: &statemachine.ShardOp{ShardID: req.LeftID, RightID: req.RightID},
+	}
+	s.changeShards(w, r, cmd, "合并")
+}
+
+// changeShards 提交拆分或合并命令，产生的分片处于迁移状态；
+// 所有分片由同一个Raft组服务，数据无需搬迁，随后立即提交激活命令
+// 激活失败时分片保持迁移状态，由领导者的后台清理循环重新激活
+func (s *Server) changeShards(w http.ResponseWriter, r *http.Request, cmd statemachine.Command, action string) {
+	index, result, ok := s.proposeCommand(w, r, cmd)
+	if !ok {
+		return
+	}
+
+	changed, _ := result.Value.([]statemachine.ShardRecord)
+	ids := make([]string, 0, len(changed))
+	for _, shard := range changed {
+		ids = append(ids, shard.ID)
+	}
+
+	s.logger.Info("审计: "+action+"分片", "token", principalName(r), "shards", ids, "index", index)
+
+	activate := statemachine.Command{Type: "SHARD_ACTIVATE", ShardOp: &statemachine.ShardOp{ShardIDs: ids}}
+	activateIndex, _, ok := s.proposeCommand(w, r, activate)
+	if !ok {
+		s.logger.Warn("激活分片失败，等待后台重新激活", "shards", ids)
+		return
+	}
+
+	// 返回激活后的分片，拓扑版本与/api/topology一致
+	version, shards := s.currentTopology()
+	current := make([]shardInfo, 0, len(ids))
+	for _, shard := range shards {
+		for _, id := range ids {
+			if shard.ID == id {
+				current = append(current, shard)
+			}
+		}
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"index":   activateIndex,
+		"version": version,
+		"shards":  current,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// resumeShardActivation 重新激活仍处于迁移状态的分片，用于拆分或合并后激活命令未能提交的情况
+func (s *Server) resumeShardActivation() {
+	var ids []string
+	for _, shard := range s.stateMachine.ShardTable().Shards {
+		if shard.State == statemachine.ShardMigrating {
+			ids = append(ids, shard.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	cmdData, err := statemachine.CreateShardActivateCommand(ids)
+	if err != nil {
+		s.logger.Error("创建分片激活命令失败", logging.FieldError, err)
+		return
+	}
+
+	if _, err := s.raftNode.ProposeWithIndex(cmdData); err != nil && err != raft.ErrNotLeader && err != raft.ErrTransferInProgress {
+		s.logger.Warn("提议分片激活命令失败", logging.FieldError, err)
+	}
+}
+
+// writeShardPlan 返回试运行的结果
+func writeShardPlan(w http.ResponseWriter, planned []statemachine.ShardRecord) {
+	response := map[string]interface{}{
+		"success": true,
+		"dryRun":  true,
+		"shards":  planned,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeShardError 按分片操作的错误类型返回状态码
+func writeShardError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, statemachine.ErrShardNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, statemachine.ErrShardMigrating):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
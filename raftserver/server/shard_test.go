@@ -0,0 +1,173 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-16 10:05:27
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-16 10:05:27
+* @Description: ConcordKV Raft consensus server - shard_test.go
+ */
+package server
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// shardOf 按拓扑中的哈希范围查找键所在的分片
+func shardOf(t *testing.T, shards []shardInfo, key string) shardInfo {
+	t.Helper()
+	hash := statemachine.ShardKeyHash(key)
+	for _, shard := range shards {
+		if hash >= shard.Range.StartHash && hash < shard.Range.EndHash {
+			return shard
+		}
+	}
+	t.Fatalf("键 %s 没有对应的分片", key)
+	return shardInfo{}
+}
+
+// keysAround 找出哈希值分别位于拆分点两侧的键
+func keysAround(t *testing.T, splitHash uint64) (string, string) {
+	t.Helper()
+	var below, above string
+	for i := 0; below == "" || above == ""; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if statemachine.ShardKeyHash(key) < splitHash {
+			below = key
+		} else {
+			above = key
+		}
+	}
+	return below, above
+}
+
+// TestShardSplitMerge 拆分与合并经过迁移状态后激活，拆分点两侧的键路由到各自的分片；
+// 非法的拆分点、不相邻或迁移中的分片被拒绝，分片表随快照恢复
+func TestShardSplitMerge(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	view := raft.ClusterView{Term: 2, Leader: "node1", Servers: []raft.Server{{ID: "node1"}, {ID: "node2"}}, ConfigIndex: 3}
+	baseVersion, _ := topologyFromView(view, sm.ShardTable(), nil)
+
+	const splitHash = uint64(1) << 63
+	planned, err := sm.PlanShardSplit(statemachine.DefaultShardID, splitHash)
+	if err != nil || len(planned) != 2 || planned[0].EndHash != splitHash || planned[1].StartHash != splitHash {
+		t.Fatalf("试运行结果不正确: %+v, %v", planned, err)
+	}
+	if table := sm.ShardTable(); len(table.Shards) != 1 || table.Index != 0 {
+		t.Fatalf("试运行不应修改分片表: %+v", table)
+	}
+
+	for _, hash := range []uint64{0, ^uint64(0)} {
+		err := applyCommand(t, sm, 10, statemachine.Command{Type: "SHARD_SPLIT", ShardOp: &statemachine.ShardOp{ShardID: statemachine.DefaultShardID, SplitHash: hash}})
+		if !errors.Is(err, statemachine.ErrInvalidShardOp) {
+			t.Errorf("拆分点 %d 不在分片内部时应被拒绝: %v", hash, err)
+		}
+	}
+
+	result, err := applyCommandAt(t, sm, 11, time.Now(), statemachine.Command{Type: "SHARD_SPLIT", ShardOp: &statemachine.ShardOp{ShardID: statemachine.DefaultShardID, SplitHash: splitHash}})
+	if err != nil {
+		t.Fatalf("拆分失败: %v", err)
+	}
+	created := result.Value.([]statemachine.ShardRecord)
+	if len(created) != 2 || created[0].State != statemachine.ShardMigrating || created[1].ID == created[0].ID {
+		t.Fatalf("拆分后的分片应处于迁移状态: %+v", created)
+	}
+	err = applyCommand(t, sm, 12, statemachine.Command{Type: "SHARD_MERGE", ShardOp: &statemachine.ShardOp{ShardID: created[0].ID, RightID: created[1].ID}})
+	if !errors.Is(err, statemachine.ErrShardMigrating) {
+		t.Errorf("迁移中的分片不能合并: %v", err)
+	}
+
+	ids := []string{created[0].ID, created[1].ID}
+	if err := applyCommand(t, sm, 13, statemachine.Command{Type: "SHARD_ACTIVATE", ShardOp: &statemachine.ShardOp{ShardIDs: ids}}); err != nil {
+		t.Fatalf("激活分片失败: %v", err)
+	}
+
+	version, shards := topologyFromView(view, sm.ShardTable(), nil)
+	if version <= baseVersion || len(shards) != 2 {
+		t.Fatalf("拆分后版本号应增大且有两个分片: %d <= %d, %+v", version, baseVersion, shards)
+	}
+	below, above := keysAround(t, splitHash)
+	if shard := shardOf(t, shards, below); shard.ID != created[0].ID || shard.State != int(statemachine.ShardActive) {
+		t.Errorf("拆分点之前的键应路由到左分片: %+v", shard)
+	}
+	if shard := shardOf(t, shards, above); shard.ID != created[1].ID || shard.State != int(statemachine.ShardActive) {
+		t.Errorf("拆分点之后的键应路由到右分片: %+v", shard)
+	}
+
+	// 快照恢复后分片表与新分片ID的分配保持一致
+	data, err := sm.CreateSnapshot()
+	if err != nil {
+		t.Fatalf("创建快照失败: %v", err)
+	}
+	restored := statemachine.NewKVStateMachine()
+	if err := restored.RestoreSnapshot(data); err != nil {
+		t.Fatalf("恢复快照失败: %v", err)
+	}
+	if table := restored.ShardTable(); len(table.Shards) != 2 || table.Index != 13 {
+		t.Fatalf("恢复后的分片表不正确: %+v", table)
+	}
+	if next, _ := restored.PlanShardSplit(created[1].ID, splitHash+1); next[1].ID == created[1].ID {
+		t.Errorf("恢复后不应重复分配分片ID: %+v", next)
+	}
+
+	if _, err := sm.PlanShardMerge(created[1].ID, created[0].ID); !errors.Is(err, statemachine.ErrInvalidShardOp) {
+		t.Errorf("不相邻的分片不能合并: %v", err)
+	}
+	if _, err := sm.PlanShardMerge(created[0].ID, "shard-9"); !errors.Is(err, statemachine.ErrShardNotFound) {
+		t.Errorf("不存在的分片不能合并: %v", err)
+	}
+	if err := applyCommand(t, sm, 14, statemachine.Command{Type: "SHARD_MERGE", ShardOp: &statemachine.ShardOp{ShardID: created[0].ID, RightID: created[1].ID}}); err != nil {
+		t.Fatalf("合并失败: %v", err)
+	}
+	applyCommand(t, sm, 15, statemachine.Command{Type: "SHARD_ACTIVATE", ShardOp: &statemachine.ShardOp{ShardIDs: []string{created[0].ID}}})
+
+	merged, shards := topologyFromView(view, sm.ShardTable(), nil)
+	if merged <= version || len(shards) != 1 || shards[0].Range.StartHash != 0 || shards[0].Range.EndHash != ^uint64(0) {
+		t.Fatalf("合并后应只剩覆盖整个哈希环的分片: %+v", shards)
+	}
+	if shardOf(t, shards, below).ID != shardOf(t, shards, above).ID {
+		t.Errorf("合并后拆分点两侧的键应路由到同一分片")
+	}
+}
+
+// TestTopologyHubShardEvents 拆分产生新增与更新事件，合并产生删除事件
+func TestTopologyHubShardEvents(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	view := raft.ClusterView{Term: 1, Leader: "node1", Servers: []raft.Server{{ID: "node1"}}}
+	hub := newTopologyHub("node1", nil)
+	hub.observe(view, sm.ShardTable())
+
+	split := statemachine.Command{Type: "SHARD_SPLIT", ShardOp: &statemachine.ShardOp{ShardID: statemachine.DefaultShardID, SplitHash: 1 << 40}}
+	if err := applyCommand(t, sm, 5, split); err != nil {
+		t.Fatalf("拆分失败: %v", err)
+	}
+	hub.observe(view, sm.ShardTable())
+
+	types := make(map[string]int)
+	for _, event := range hub.history {
+		types[event.ShardID] = event.Type
+	}
+	if len(types) != 2 || types[statemachine.DefaultShardID] != topologyShardUpdated || types["shard-1"] != topologyShardAdded {
+		t.Fatalf("拆分事件不正确: %+v", hub.history)
+	}
+
+	applyCommand(t, sm, 6, statemachine.Command{Type: "SHARD_ACTIVATE", ShardOp: &statemachine.ShardOp{ShardIDs: []string{statemachine.DefaultShardID, "shard-1"}}})
+	merge := statemachine.Command{Type: "SHARD_MERGE", ShardOp: &statemachine.ShardOp{ShardID: statemachine.DefaultShardID, RightID: "shard-1"}}
+	if err := applyCommand(t, sm, 7, merge); err != nil {
+		t.Fatalf("合并失败: %v", err)
+	}
+	hub.observe(view, sm.ShardTable())
+
+	last := hub.history[len(hub.history)-2:]
+	removed := false
+	for _, event := range last {
+		removed = removed || (event.Type == topologyShardRemoved && event.ShardID == "shard-1")
+	}
+	if !removed {
+		t.Errorf("合并后应产生右分片的删除事件: %+v", last)
+	}
+}
@@ -0,0 +1,494 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - topology.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// 拓扑事件参数
+const (
+	topologyPollInterval     = 200 * time.Millisecond
+	topologyHistorySize      = 1000
+	topologySubscriberBuffer = 64
+
+	// defaultTopologyCoalesceWindow 合并拓扑事件的默认窗口
+	defaultTopologyCoalesceWindow = 100 * time.Millisecond
+)
+
+// 拓扑事件类型，与客户端TopologyEventType一致
+const (
+	topologyShardAdded   = 0
+	topologyShardRemoved = 1
+	topologyShardUpdated = 2
+)
+
+// shardRange 分片的哈希范围，结束值不包含
+type shardRange struct {
+	StartHash uint64 `json:"startHash"`
+	EndHash   uint64 `json:"endHash"`
+}
+
+// replicaLocation 副本所在的数据中心与可用区，与客户端的ReplicaLocation一致
+type replicaLocation struct {
+	DataCenter raft.DataCenterID `json:"dataCenter,omitempty"`
+	Zone       string            `json:"zone,omitempty"`
+}
+
+// shardInfo 分片信息，字段与客户端的ShardInfo一致
+type shardInfo struct {
+	ID        string                          `json:"id"`
+	Range     shardRange                      `json:"range"`
+	Primary   raft.NodeID                     `json:"primary"`
+	Replicas  []raft.NodeID                   `json:"replicas"`
+	State     int                             `json:"state"`
+	Version   int64                           `json:"version"`
+	Metadata  map[string]string               `json:"metadata,omitempty"`
+	Locations map[raft.NodeID]replicaLocation `json:"locations,omitempty"`
+}
+
+// topologyVersion 由任期、领导者是否已知和日志索引组成的版本号：
+// 任期占高32位，其后1位表示本任期的领导者是否已知，配置索引与分片表索引中较大者占低31位。
+// 每个任期最多只有一个领导者，领导者变更必然伴随任期增长，成员变更与分片拆分合并使对应的日志索引增长，
+// 因此拓扑的任何变化都会使版本号增大，且各节点对相同的拓扑给出相同的版本号；落后的节点只会给出较小的版本号
+func topologyVersion(view raft.ClusterView, table statemachine.ShardTable) int64 {
+	version := int64(view.Term) << 32
+	if view.Leader != "" {
+		version |= 1 << 31
+	}
+	index := uint64(view.ConfigIndex)
+	if table.Index > index {
+		index = table.Index
+	}
+	return version | int64(index&(1<<31-1))
+}
+
+// topologyFromView 根据集群视图与分片表构造分片信息，zones为各节点所在的可用区
+// 所有分片由本Raft组服务，主节点与副本相同；各分片都使用全局版本号，任何变化都会使所有分片的版本号增大
+// 副本位置取自成员配置中的数据中心，可用区是静态配置，不影响版本号
+func topologyFromView(view raft.ClusterView, table statemachine.ShardTable, zones map[raft.NodeID]string) (int64, []shardInfo) {
+	version := topologyVersion(view, table)
+
+	replicas := make([]raft.NodeID, 0, len(view.Servers))
+	locations := make(map[raft.NodeID]replicaLocation, len(view.Servers))
+	for _, server := range view.Servers {
+		if server.ID != view.Leader {
+			replicas = append(replicas, server.ID)
+		}
+		if server.DataCenter != "" || zones[server.ID] != "" {
+			locations[server.ID] = replicaLocation{DataCenter: server.DataCenter, Zone: zones[server.ID]}
+		}
+	}
+	if len(locations) == 0 {
+		locations = nil
+	}
+
+	shards := make([]shardInfo, 0, len(table.Shards))
+	for _, record := range table.Shards {
+		shards = append(shards, shardInfo{
+			ID:       record.ID,
+			Range:    shardRange{StartHash: record.StartHash, EndHash: record.EndHash},
+			Primary:  view.Leader,
+			Replicas: replicas,
+			State:    int(record.State),
+			Version:  version,
+			Metadata: map[string]string{
+				"term":        strconv.FormatUint(uint64(view.Term), 10),
+				"configIndex": strconv.FormatUint(uint64(view.ConfigIndex), 10),
+				"shardIndex":  strconv.FormatUint(record.Index, 10),
+			},
+			Locations: locations,
+		})
+	}
+	return version, shards
+}
+
+// currentTopology 根据本节点的集群视图与分片表构造分片信息
+func (s *Server) currentTopology() (int64, []shardInfo) {
+	return topologyFromView(s.raftNode.GetClusterView(), s.stateMachine.ShardTable(), s.config.PeerZones)
+}
+
+// handleTopology 返回所有分片信息及全局版本号
+// sinceVersion参数用于增量获取，只返回版本号比它新的分片；complete表示返回了全部分片，客户端应驱逐不在其中的分片。
+// keyHash为分片范围所在哈希空间的算法，迁移窗口内previousKeyHash为之前的算法
+func (s *Server) handleTopology(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var sinceVersion int64
+	if v := r.URL.Query().Get("sinceVersion"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "sinceVersion参数无效", http.StatusBadRequest)
+			return
+		}
+		sinceVersion = n
+	}
+
+	version, shards := s.currentTopology()
+	changed := make([]shardInfo, 0, len(shards))
+	for _, shard := range shards {
+		if shard.Version > sinceVersion {
+			changed = append(changed, shard)
+		}
+	}
+
+	response := map[string]interface{}{
+		"success":  true,
+		"version":  version,
+		"shards":   changed,
+		"complete": len(changed) == len(shards),
+		"keyHash":  s.stateMachine.KeyHash(),
+	}
+	if s.previousKeyHash != nil {
+		response["previousKeyHash"] = s.previousKeyHash
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// topologyEvent 分片变更事件，字段与客户端的TopologyEvent一致
+type topologyEvent struct {
+	Type      int        `json:"type"`
+	ShardID   string     `json:"shardId"`
+	ShardInfo *shardInfo `json:"shardInfo,omitempty"`
+	Version   int64      `json:"version"`
+	Timestamp time.Time  `json:"timestamp"`
+	Source    string     `json:"source"`
+}
+
+// topologyBatch 一次推送的多个分片事件，事件的版本号均为批次的全局版本号
+type topologyBatch struct {
+	Version   int64           `json:"version"`
+	Events    []topologyEvent `json:"events"`
+	Timestamp time.Time       `json:"timestamp"`
+	Source    string          `json:"source"`
+}
+
+// topologyHub 比较本节点先后观察到的拓扑，生成分片变更事件并推送给订阅者
+// 窗口期内同一分片的事件合并为最新状态，窗口结束时作为一帧推送；
+// 保留最近的事件用于断线重连后的补发，更早的版本需要客户端重新获取完整拓扑
+type topologyHub struct {
+	mu      sync.Mutex
+	source  string
+	zones   map[raft.NodeID]string // 各节点所在的可用区
+	version int64
+	shards  map[string]shardInfo
+
+	// 合并窗口，为0时每次观察到的变更立即推送
+	window  time.Duration
+	pending []topologyEvent
+	flushAt *time.Timer
+
+	history     []topologyEvent
+	baseVersion int64 // history包含该版本之后的所有已推送事件
+	historySize int
+
+	subscribers map[chan []topologyEvent]struct{}
+}
+
+// newTopologyHub 创建拓扑事件分发器，source为事件中的节点标识，zones为各节点所在的可用区
+func newTopologyHub(source string, zones map[raft.NodeID]string) *topologyHub {
+	return &topologyHub{
+		source:      source,
+		zones:       zones,
+		shards:      make(map[string]shardInfo),
+		historySize: topologyHistorySize,
+		subscribers: make(map[chan []topologyEvent]struct{}),
+	}
+}
+
+// observe 记录集群视图与分片表，拓扑版本增大时生成变更事件
+func (h *topologyHub) observe(view raft.ClusterView, table statemachine.ShardTable) {
+	version, shards := topologyFromView(view, table, h.zones)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if version <= h.version {
+		return
+	}
+
+	current := make(map[string]shardInfo, len(shards))
+	for _, shard := range shards {
+		current[shard.ID] = shard
+	}
+
+	// 首次观察到的拓扑是事件历史的起点
+	if h.version == 0 {
+		h.version, h.baseVersion, h.shards = version, version, current
+		return
+	}
+
+	now := time.Now()
+	events := make([]topologyEvent, 0, len(current))
+	for id, shard := range current {
+		shard := shard
+		old, exists := h.shards[id]
+		switch {
+		case !exists:
+			events = append(events, topologyEvent{Type: topologyShardAdded, ShardID: id, ShardInfo: &shard})
+		case old.Version != shard.Version:
+			events = append(events, topologyEvent{Type: topologyShardUpdated, ShardID: id, ShardInfo: &shard})
+		}
+	}
+	for id := range h.shards {
+		if _, exists := current[id]; !exists {
+			events = append(events, topologyEvent{Type: topologyShardRemoved, ShardID: id})
+		}
+	}
+	for i := range events {
+		events[i].Timestamp = now
+		events[i].Source = h.source
+	}
+
+	h.version, h.shards = version, current
+	for _, event := range events {
+		h.coalesce(event)
+	}
+
+	switch {
+	case len(h.pending) == 0:
+	case h.window <= 0:
+		h.flushLocked()
+	case h.flushAt == nil:
+		h.flushAt = time.AfterFunc(h.window, h.flush)
+	}
+}
+
+// coalesce 把事件合并到待推送的事件中：同一分片只保留最新状态，
+// 窗口内先添加后更新的分片仍为添加事件（调用方需持有锁）
+func (h *topologyHub) coalesce(event topologyEvent) {
+	for i := range h.pending {
+		if h.pending[i].ShardID != event.ShardID {
+			continue
+		}
+		if h.pending[i].Type == topologyShardAdded && event.Type == topologyShardUpdated {
+			event.Type = topologyShardAdded
+		}
+		h.pending[i] = event
+		return
+	}
+	h.pending = append(h.pending, event)
+}
+
+// flush 合并窗口结束，推送待推送的事件
+func (h *topologyHub) flush() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.flushLocked()
+}
+
+// flushLocked 以当前版本号推送待推送的事件并记入历史（调用方需持有锁）
+func (h *topologyHub) flushLocked() {
+	if h.flushAt != nil {
+		h.flushAt.Stop()
+		h.flushAt = nil
+	}
+	if len(h.pending) == 0 {
+		return
+	}
+
+	events := h.pending
+	h.pending = nil
+	for i := range events {
+		events[i].Version = h.version
+	}
+
+	h.history = append(h.history, events...)
+	if len(h.history) > h.historySize {
+		cut := len(h.history) / 2
+		h.baseVersion = h.history[cut-1].Version
+		h.history = append([]topologyEvent(nil), h.history[cut:]...)
+	}
+
+	// 订阅者的缓冲区满时断开它，客户端重连后按版本号补发
+	for ch := range h.subscribers {
+		select {
+		case ch <- events:
+		default:
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe 注册订阅者，返回sinceVersion之后需要补发的事件
+// sinceVersion早于保留的事件历史时返回resync，客户端需要重新获取完整拓扑
+func (h *topologyHub) subscribe(sinceVersion int64) (chan []topologyEvent, []topologyEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan []topologyEvent, topologySubscriberBuffer)
+	h.subscribers[ch] = struct{}{}
+
+	if sinceVersion == 0 {
+		return ch, nil, false
+	}
+	if sinceVersion < h.baseVersion {
+		return ch, nil, true
+	}
+
+	var replay []topologyEvent
+	for _, event := range h.history {
+		if event.Version > sinceVersion {
+			replay = append(replay, event)
+		}
+	}
+	return ch, replay, false
+}
+
+// unsubscribe 注销订阅者
+func (h *topologyHub) unsubscribe(ch chan []topologyEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, exists := h.subscribers[ch]; exists {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// stop 停止合并窗口的计时，服务器停止后不再推送
+func (h *topologyHub) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.flushAt != nil {
+		h.flushAt.Stop()
+		h.flushAt = nil
+	}
+}
+
+// currentVersion 最近观察到的拓扑版本号
+func (h *topologyHub) currentVersion() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.version
+}
+
+// topologyWatchLoop 定期观察集群视图与分片表，领导者、成员或分片变更后生成拓扑事件
+func (s *Server) topologyWatchLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(topologyPollInterval)
+	defer ticker.Stop()
+	defer s.topology.stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.topology.observe(s.raftNode.GetClusterView(), s.stateMachine.ShardTable())
+		}
+	}
+}
+
+// handleTopologyEvents 以SSE流推送分片变更事件
+// sinceVersion为客户端已知的拓扑版本，之后的事件先补发；版本过旧时发送resync事件，客户端需重新获取完整拓扑
+func (s *Server) handleTopologyEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "连接不支持流式响应", http.StatusInternalServerError)
+		return
+	}
+
+	var sinceVersion int64
+	if v := r.URL.Query().Get("sinceVersion"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "sinceVersion参数无效", http.StatusBadRequest)
+			return
+		}
+		sinceVersion = n
+	}
+
+	events, replay, resync := s.topology.subscribe(sinceVersion)
+	defer s.topology.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if resync {
+		event := map[string]interface{}{
+			"type":    "resync",
+			"reason":  resyncCompacted,
+			"version": s.topology.currentVersion(),
+		}
+		if err := writeSSE(w, "resync", 0, event); err != nil {
+			return
+		}
+	}
+	// 补发的事件按版本号分帧，与实时推送时一致
+	for start := 0; start < len(replay); {
+		end := start + 1
+		for end < len(replay) && replay[end].Version == replay[start].Version {
+			end++
+		}
+		if err := writeTopologyFrame(w, replay[start:end]); err != nil {
+			return
+		}
+		start = end
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(watchHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case frame, ok := <-events:
+			if !ok {
+				s.logger.Warn("拓扑事件订阅者消费过慢，断开连接", "remote_addr", r.RemoteAddr)
+				return
+			}
+			if err := writeTopologyFrame(w, frame); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := w.Write([]byte(": keepalive\n\n")); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeTopologyFrame 写出一帧拓扑事件：单个事件为topology事件，多个事件合并为一个topology-batch事件
+func writeTopologyFrame(w http.ResponseWriter, events []topologyEvent) error {
+	if len(events) == 1 {
+		return writeSSE(w, "topology", 0, events[0])
+	}
+	last := events[len(events)-1]
+	return writeSSE(w, "topology-batch", 0, topologyBatch{
+		Version:   last.Version,
+		Events:    events,
+		Timestamp: last.Timestamp,
+		Source:    last.Source,
+	})
+}
@@ -0,0 +1,328 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - topology_test.go
+ */
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"raftserver/keyhash"
+	"raftserver/logging"
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// initialShards 从未拆分过的分片表，只有覆盖整个哈希环的初始分片
+var initialShards = statemachine.NewKVStateMachine().ShardTable()
+
+// TestTopologyFromView 领导者为主节点，其余成员为副本；领导者或成员变更后版本号增大
+func TestTopologyFromView(t *testing.T) {
+	servers := []raft.Server{{ID: "node1"}, {ID: "node2"}, {ID: "node3"}}
+	view := raft.ClusterView{Term: 3, Leader: "node2", Servers: servers, ConfigIndex: 40}
+
+	version, shards := topologyFromView(view, initialShards, nil)
+	if len(shards) != 1 || shards[0].Version != version {
+		t.Fatalf("分片信息不正确: %+v", shards)
+	}
+	shard := shards[0]
+	if shard.Primary != "node2" || len(shard.Replicas) != 2 || shard.Replicas[0] != "node1" || shard.Replicas[1] != "node3" {
+		t.Errorf("主节点或副本不正确: %+v", shard)
+	}
+	if shard.Range.StartHash != 0 || shard.Range.EndHash != ^uint64(0) {
+		t.Errorf("分片应覆盖整个哈希环: %+v", shard.Range)
+	}
+
+	// 同一任期内的成员变更
+	grown := view
+	grown.Servers = append(servers, raft.Server{ID: "node4"})
+	grown.ConfigIndex = 41
+	if v, _ := topologyFromView(grown, initialShards, nil); v <= version {
+		t.Errorf("成员变更后版本号应增大: %d <= %d", v, version)
+	}
+
+	// 新任期的领导者，即使配置索引来自更早的快照也不会回退
+	elected := raft.ClusterView{Term: 4, Leader: "node1", Servers: servers, ConfigIndex: 2}
+	if v, _ := topologyFromView(elected, initialShards, nil); v <= version {
+		t.Errorf("领导者变更后版本号应增大: %d <= %d", v, version)
+	}
+
+	// 选举期间没有主节点，所有成员都是副本；同一任期内得知领导者后版本号增大
+	electing := raft.ClusterView{Term: 5, Servers: servers, ConfigIndex: 41}
+	v, shards := topologyFromView(electing, initialShards, nil)
+	if shards[0].Primary != "" || len(shards[0].Replicas) != 3 {
+		t.Errorf("选举期间的分片信息不正确: %+v", shards[0])
+	}
+	electing.Leader = "node3"
+	if known, _ := topologyFromView(electing, initialShards, nil); known <= v {
+		t.Errorf("得知领导者后版本号应增大: %d <= %d", known, v)
+	}
+}
+
+// TestTopologyLocations 分片信息带有各成员所在的数据中心与配置的可用区，客户端据此就近读取
+func TestTopologyLocations(t *testing.T) {
+	servers := []raft.Server{{ID: "node1", DataCenter: "dc1"}, {ID: "node2", DataCenter: "dc2"}, {ID: "node3", DataCenter: "dc3"}}
+	view := raft.ClusterView{Term: 3, Leader: "node1", Servers: servers, ConfigIndex: 40}
+
+	_, shards := topologyFromView(view, initialShards, map[raft.NodeID]string{"node2": "dc2-b"})
+	locations := shards[0].Locations
+	if len(locations) != 3 || locations["node1"].DataCenter != "dc1" || locations["node3"].Zone != "" {
+		t.Errorf("副本位置不正确: %+v", locations)
+	}
+	if node2 := locations["node2"]; node2.DataCenter != "dc2" || node2.Zone != "dc2-b" {
+		t.Errorf("node2应位于dc2的dc2-b: %+v", node2)
+	}
+
+	// 没有位置信息时不返回locations，与旧版本的载荷相同
+	_, shards = topologyFromView(raft.ClusterView{Term: 3, Leader: "node1", Servers: []raft.Server{{ID: "node1"}}}, initialShards, nil)
+	if shards[0].Locations != nil {
+		t.Errorf("没有位置信息时locations应为空: %+v", shards[0].Locations)
+	}
+}
+
+// TestTopologyHub 版本增大时生成分片事件，按版本补发，过旧的版本要求重新同步，慢订阅者被断开
+func TestTopologyHub(t *testing.T) {
+	servers := []raft.Server{{ID: "node1"}, {ID: "node2"}, {ID: "node3"}}
+	hub := newTopologyHub("node1", nil)
+	hub.historySize = 4
+
+	view := raft.ClusterView{Term: 1, Leader: "node1", Servers: servers}
+	hub.observe(view, initialShards)
+	base := hub.currentVersion()
+	if len(hub.history) != 0 {
+		t.Fatalf("首次观察不应产生事件: %+v", hub.history)
+	}
+
+	// 版本未变化时不产生事件
+	hub.observe(view, initialShards)
+	view.Term, view.Leader = 2, "node2"
+	hub.observe(view, initialShards)
+	if len(hub.history) != 1 || hub.history[0].Type != topologyShardUpdated || hub.history[0].ShardInfo.Primary != "node2" {
+		t.Fatalf("领导者变更事件不正确: %+v", hub.history)
+	}
+
+	_, replay, resync := hub.subscribe(base)
+	if resync || len(replay) != 1 || replay[0].Version != hub.currentVersion() {
+		t.Fatalf("补发事件不正确: %+v resync=%v", replay, resync)
+	}
+	if _, replay, _ := hub.subscribe(hub.currentVersion()); len(replay) != 0 {
+		t.Errorf("已是最新版本时不应补发: %+v", replay)
+	}
+
+	// 历史超过上限后截断，早于保留历史的版本需要重新同步
+	slow, _, _ := hub.subscribe(0)
+	for term := raft.Term(3); term <= 6; term++ {
+		view.Term = term
+		hub.observe(view, initialShards)
+	}
+	if _, _, resync := hub.subscribe(base); !resync {
+		t.Errorf("版本早于保留的历史时应要求重新同步")
+	}
+	if len(slow) != 4 {
+		t.Fatalf("订阅者应收到4个事件，实际 %d", len(slow))
+	}
+
+	for i := 0; i < topologySubscriberBuffer; i++ {
+		view.Term++
+		hub.observe(view, initialShards)
+	}
+	for range slow {
+	}
+	if _, exists := hub.subscribers[slow]; exists {
+		t.Errorf("缓冲区满的订阅者应被断开")
+	}
+}
+
+// TestTopologyEventStream 以SSE推送分片事件，重连时按版本补发
+func TestTopologyEventStream(t *testing.T) {
+	servers := []raft.Server{{ID: "node1"}, {ID: "node2"}}
+	s := &Server{config: &ServerConfig{}, logger: logging.Nop(), topology: newTopologyHub("node1", nil)}
+
+	view := raft.ClusterView{Term: 1, Leader: "node1", Servers: servers}
+	s.topology.observe(view, initialShards)
+	base := s.topology.currentVersion()
+	view.Term, view.Leader = 2, "node2"
+	s.topology.observe(view, initialShards)
+
+	ts := httptest.NewServer(http.HandlerFunc(s.handleTopologyEvents))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "?sinceVersion=" + strconv.FormatInt(base, 10))
+	if err != nil {
+		t.Fatalf("订阅拓扑事件失败: %v", err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+
+	event := readSSE(t, reader)
+	if event.Type != "topology" || event.Data["type"] != float64(topologyShardUpdated) || event.Data["shardInfo"].(map[string]interface{})["primary"] != "node2" {
+		t.Fatalf("补发事件不正确: %+v", event)
+	}
+
+	view.Servers = append(servers, raft.Server{ID: "node3"})
+	view.ConfigIndex = 9
+	s.topology.observe(view, initialShards)
+	event = readSSE(t, reader)
+	if event.Data["version"] != float64(s.topology.currentVersion()) || len(event.Data["shardInfo"].(map[string]interface{})["replicas"].([]interface{})) != 2 {
+		t.Fatalf("成员变更事件不正确: %+v", event)
+	}
+
+	stale, err := http.Get(ts.URL + "?sinceVersion=1")
+	if err != nil {
+		t.Fatalf("订阅拓扑事件失败: %v", err)
+	}
+	defer stale.Body.Close()
+	if event := readSSE(t, bufio.NewReader(stale.Body)); event.Type != "resync" {
+		t.Fatalf("过旧的版本应收到resync事件，实际 %+v", event)
+	}
+}
+
+// TestTopologyHubCoalesce 窗口内同一分片的多次变更合并为最新状态，多个分片的事件作为一帧以最新的全局版本号推送
+func TestTopologyHubCoalesce(t *testing.T) {
+	servers := []raft.Server{{ID: "node1"}, {ID: "node2"}}
+	s := &Server{config: &ServerConfig{}, logger: logging.Nop(), topology: newTopologyHub("node1", nil)}
+	hub := s.topology
+	hub.window = time.Hour
+
+	table := func(index uint64, shards ...statemachine.ShardRecord) statemachine.ShardTable {
+		return statemachine.ShardTable{Index: index, Shards: shards}
+	}
+	left := statemachine.ShardRecord{ID: "shard-0", StartHash: 0, EndHash: 1 << 63, Index: 5}
+	right := statemachine.ShardRecord{ID: "shard-1", StartHash: 1 << 63, EndHash: ^uint64(0), Index: 5}
+	extra := statemachine.ShardRecord{ID: "shard-2", StartHash: 1 << 62, EndHash: 1 << 63, Index: 7}
+
+	view := raft.ClusterView{Term: 1, Leader: "node1", Servers: servers}
+	hub.observe(view, initialShards)
+	base := hub.currentVersion()
+
+	ts := httptest.NewServer(http.HandlerFunc(s.handleTopologyEvents))
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "?sinceVersion=" + strconv.FormatInt(base, 10))
+	if err != nil {
+		t.Fatalf("订阅拓扑事件失败: %v", err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+
+	// 同一窗口内：拆分出shard-1，领导者变更，新增shard-2后又被移除
+	hub.observe(view, table(5, left, right))
+	view.Term, view.Leader = 2, "node2"
+	hub.observe(view, table(5, left, right))
+	hub.observe(view, table(7, left, extra, right))
+	hub.observe(view, table(8, left, right))
+	if len(hub.history) != 0 {
+		t.Fatalf("窗口结束前不应推送事件: %+v", hub.history)
+	}
+
+	hub.flush()
+	latest := hub.currentVersion()
+	event := readSSE(t, reader)
+	if event.Type != "topology-batch" || event.Data["version"] != float64(latest) {
+		t.Fatalf("应收到版本号为 %d 的批量事件，实际 %+v", latest, event)
+	}
+
+	got := make(map[string]map[string]interface{})
+	for _, raw := range event.Data["events"].([]interface{}) {
+		e := raw.(map[string]interface{})
+		if e["version"] != float64(latest) {
+			t.Errorf("批量事件中的事件版本号 = %v, 期望 %d", e["version"], latest)
+		}
+		id := e["shardId"].(string)
+		if _, dup := got[id]; dup {
+			t.Errorf("分片 %s 在一帧中出现多次", id)
+		}
+		got[id] = e
+	}
+	if len(got) != 3 {
+		t.Fatalf("批量事件应包含3个分片，实际 %+v", got)
+	}
+	if e := got["shard-0"]; e["type"] != float64(topologyShardUpdated) || e["shardInfo"].(map[string]interface{})["primary"] != "node2" {
+		t.Errorf("shard-0应为最新状态的更新事件: %+v", e)
+	}
+	if e := got["shard-1"]; e["type"] != float64(topologyShardAdded) || e["shardInfo"].(map[string]interface{})["version"] != float64(latest) {
+		t.Errorf("窗口内先添加后更新的shard-1应为最新状态的添加事件: %+v", e)
+	}
+	if e := got["shard-2"]; e["type"] != float64(topologyShardRemoved) {
+		t.Errorf("窗口内添加后移除的shard-2应为移除事件: %+v", e)
+	}
+
+	// 重连补发时同一批次仍为一帧
+	replayed, err := http.Get(ts.URL + "?sinceVersion=" + strconv.FormatInt(base, 10))
+	if err != nil {
+		t.Fatalf("订阅拓扑事件失败: %v", err)
+	}
+	defer replayed.Body.Close()
+	if event := readSSE(t, bufio.NewReader(replayed.Body)); event.Type != "topology-batch" || len(event.Data["events"].([]interface{})) != 3 {
+		t.Fatalf("补发的批量事件不正确: %+v", event)
+	}
+
+	// 窗口结束时自动推送
+	hub.window = 10 * time.Millisecond
+	view.Term = 3
+	hub.observe(view, table(8, left, right))
+	event = readSSE(t, reader)
+	if event.Type != "topology-batch" || event.Data["version"] != float64(hub.currentVersion()) {
+		t.Fatalf("窗口结束后应推送批量事件，实际 %+v", event)
+	}
+}
+
+// fetchKeyHashes 请求/api/topology，返回通告的键哈希算法
+func fetchKeyHashes(t *testing.T, s *Server) (keyHash, previous *keyhash.Algorithm) {
+	t.Helper()
+	resp, err := http.Get(apiURL(s, "/api/topology"))
+	if err != nil {
+		t.Fatalf("请求拓扑失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		KeyHash         *keyhash.Algorithm `json:"keyHash"`
+		PreviousKeyHash *keyhash.Algorithm `json:"previousKeyHash"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("解析拓扑失败: %v", err)
+	}
+	return body.KeyHash, body.PreviousKeyHash
+}
+
+// TestTopologyKeyHash 拓扑接口通告分片使用的键哈希算法，迁移窗口内同时通告之前的算法；
+// 分片归属与按键拆分都使用配置的算法
+func TestTopologyKeyHash(t *testing.T) {
+	s := startSingleNode(t, "node1", "")
+	if keyHash, previous := fetchKeyHashes(t, s); keyHash == nil || *keyHash != keyhash.Canonical || previous != nil {
+		t.Fatalf("默认应只通告 %s，实际 %v %v", keyhash.Canonical, keyHash, previous)
+	}
+
+	migrating := startSingleNodeWith(t, "node1", func(config *ServerConfig) {
+		config.KeyHash = "sha256-prefix"
+		config.PreviousKeyHash = "xxhash64/v1"
+	})
+	keyHash, previous := fetchKeyHashes(t, migrating)
+	if keyHash == nil || *keyHash != keyhash.SHA256Prefix || previous == nil || *previous != keyhash.XXHash64 {
+		t.Fatalf("迁移窗口内应通告 %s 与之前的 %s，实际 %v %v", keyhash.SHA256Prefix, keyhash.XXHash64, keyHash, previous)
+	}
+	for _, key := range []string{"a", "user:1", "键"} {
+		if got := migrating.stateMachine.HashKey(key); got != keyhash.SHA256Prefix.Sum64(key) {
+			t.Fatalf("分片应按 %s 计算键 %q 的哈希，实际 %016x", keyhash.SHA256Prefix, key, got)
+		}
+	}
+
+	for _, config := range []*ServerConfig{
+		{KeyHash: "md5"},
+		{PreviousKeyHash: "xxhash64/v9"},
+		{KeyHash: "xxhash64", PreviousKeyHash: "xxhash64/v1"},
+	} {
+		if _, _, err := config.keyHashes(); err == nil {
+			t.Errorf("配置 keyHash=%q previousKeyHash=%q 应被拒绝", config.KeyHash, config.PreviousKeyHash)
+		}
+	}
+}
@@ -0,0 +1,463 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-21 10:05:37
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-21 10:05:37
+* @Description: ConcordKV Raft consensus server - trace.go
+ */
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/raft"
+)
+
+// 慢请求日志参数
+const (
+	defaultSlowRequestThreshold = 500 * time.Millisecond
+	defaultSlowLogSize          = 128
+
+	// requestIDHeader 请求ID的请求头与响应头，客户端提供的ID原样沿用
+	requestIDHeader = "X-Request-ID"
+	// maxRequestIDLength 沿用客户端请求ID的最大长度，超过或含不可见字符时重新生成
+	maxRequestIDLength = 128
+)
+
+// 请求经过的阶段，写请求依次经过全部阶段，读请求只有received与responded
+const (
+	stageReceived  = "received"  // 收到请求
+	stageForwarded = "forwarded" // 跟随者开始把写请求转发给领导者（启用写转发时）
+	stageProposed  = "proposed"  // 提议批次开始追加到日志
+	stageAppended  = "appended"  // 已追加到领导者的日志
+	stageCommitted = "committed" // 多数派确认，提交索引越过命令的日志索引
+	stageApplied   = "applied"   // 状态机已应用命令并返回结果
+	stageResponded = "responded" // 处理器已写完响应
+)
+
+// TraceExporter 追踪导出器，例如将每个阶段转换为OpenTelemetry子span
+// ExportTrace在请求结束后于独立协程中调用
+type TraceExporter interface {
+	ExportTrace(record *TraceRecord)
+}
+
+// TraceRecord 一个已结束请求的追踪记录
+type TraceRecord struct {
+	RequestID  string        `json:"requestId"`
+	Method     string        `json:"method"`
+	Path       string        `json:"path"`
+	Command    string        `json:"command,omitempty"`
+	Key        string        `json:"key,omitempty"`
+	Status     int           `json:"status"`
+	RaftIndex  raft.LogIndex `json:"raftIndex,omitempty"`
+	Start      time.Time     `json:"start"`
+	DurationMs float64       `json:"durationMs"`
+	Stages     []TraceStage  `json:"stages"`
+}
+
+// TraceStage 请求到达某个阶段之前经过的时间段，Start为上一个阶段的时间
+type TraceStage struct {
+	Name       string    `json:"name"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	DurationMs float64   `json:"durationMs"`
+}
+
+// traceMark 请求到达某个阶段的时间
+type traceMark struct {
+	name string
+	at   time.Time
+}
+
+// requestTrace 单个API请求的追踪，nil表示不追踪，所有方法都可以在nil上调用
+type requestTrace struct {
+	tracer *requestTracer
+	id     string
+	method string
+	path   string
+	start  time.Time
+
+	mu      sync.Mutex
+	command string
+	key     string
+	index   raft.LogIndex
+	marks   []traceMark
+}
+
+type traceContextKey struct{}
+
+// withTrace 将追踪放入请求上下文
+func withTrace(ctx context.Context, trace *requestTrace) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, trace)
+}
+
+// traceFrom 请求上下文中的追踪，没有时返回nil
+func traceFrom(ctx context.Context) *requestTrace {
+	trace, _ := ctx.Value(traceContextKey{}).(*requestTrace)
+	return trace
+}
+
+// mark 记录到达阶段的时间
+func (t *requestTrace) mark(stage string) {
+	t.markAt(stage, time.Now())
+}
+
+func (t *requestTrace) markAt(stage string, at time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.marks = append(t.marks, traceMark{name: stage, at: at})
+}
+
+// setCommand 记录写请求提议的命令
+func (t *requestTrace) setCommand(command, key string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.command = command
+	if key != "" {
+		t.key = key
+	}
+}
+
+// appended 命令已追加到日志，之后在提交索引越过index时记录committed阶段
+func (t *requestTrace) appended(index raft.LogIndex) {
+	if t == nil {
+		return
+	}
+	t.mark(stageAppended)
+	t.mu.Lock()
+	t.index = index
+	t.mu.Unlock()
+	t.tracer.commits.await(index, t)
+}
+
+// record 生成追踪记录，各阶段按时间排序
+func (t *requestTrace) record(status int, end time.Time) *TraceRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	marks := append([]traceMark(nil), t.marks...)
+	sort.SliceStable(marks, func(i, j int) bool { return marks[i].at.Before(marks[j].at) })
+
+	record := &TraceRecord{
+		RequestID:  t.id,
+		Method:     t.method,
+		Path:       t.path,
+		Command:    t.command,
+		Key:        t.key,
+		Status:     status,
+		RaftIndex:  t.index,
+		Start:      t.start,
+		DurationMs: durationMs(end.Sub(t.start)),
+		Stages:     make([]TraceStage, 0, len(marks)),
+	}
+	previous := t.start
+	for _, m := range marks {
+		record.Stages = append(record.Stages, TraceStage{
+			Name:       m.name,
+			Start:      previous,
+			End:        m.at,
+			DurationMs: durationMs(m.at.Sub(previous)),
+		})
+		previous = m.at
+	}
+	return record
+}
+
+// durationMs 以毫秒表示的时长，保留微秒精度
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// commitTracker 记录已追加到日志、等待提交的请求，领导者推进提交索引时标记它们的committed阶段
+type commitTracker struct {
+	mu        sync.Mutex
+	pending   map[raft.LogIndex]*requestTrace
+	lastIndex raft.LogIndex
+	lastTime  time.Time
+}
+
+// await 等待index被提交；单节点集群在追加时已提交，直接使用最近一次提交的时间
+func (c *commitTracker) await(index raft.LogIndex, trace *requestTrace) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if index <= c.lastIndex {
+		trace.markAt(stageCommitted, c.lastTime)
+		return
+	}
+	c.pending[index] = trace
+}
+
+// committed 提交索引推进到index
+func (c *commitTracker) committed(index raft.LogIndex, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if index > c.lastIndex {
+		c.lastIndex, c.lastTime = index, at
+	}
+	for i, trace := range c.pending {
+		if i <= index {
+			trace.markAt(stageCommitted, at)
+			delete(c.pending, i)
+		}
+	}
+}
+
+// forget 请求已结束，不再等待其提交（例如等待超时或失去领导权）
+func (c *commitTracker) forget(index raft.LogIndex) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, index)
+}
+
+// slowLog 保存最近的慢请求的环形缓冲区，配置了文件时同时以JSON行追加写入
+type slowLog struct {
+	mu      sync.Mutex
+	entries []*TraceRecord
+	start   int
+	count   int
+	file    *os.File
+}
+
+// add 追加一条慢请求，缓冲区满时覆盖最旧的一条
+func (l *slowLog) add(record *TraceRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.count < len(l.entries) {
+		l.entries[(l.start+l.count)%len(l.entries)] = record
+		l.count++
+	} else {
+		l.entries[l.start] = record
+		l.start = (l.start + 1) % len(l.entries)
+	}
+
+	if l.file == nil {
+		return nil
+	}
+	return json.NewEncoder(l.file).Encode(record)
+}
+
+// recent 最近的至多limit条慢请求，最新的在前；limit<=0时返回全部
+func (l *slowLog) recent(limit int) []*TraceRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if limit <= 0 || limit > l.count {
+		limit = l.count
+	}
+	records := make([]*TraceRecord, 0, limit)
+	for i := l.count - 1; i >= l.count-limit; i-- {
+		records = append(records, l.entries[(l.start+i)%len(l.entries)])
+	}
+	return records
+}
+
+// close 关闭慢请求日志文件
+func (l *slowLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// requestTracer 为API请求记录各阶段的时间，耗时达到阈值的请求写入慢请求日志
+type requestTracer struct {
+	threshold time.Duration // 小于0时不记录慢请求
+	nextID    func() string
+	logger    logging.Logger
+	commits   commitTracker
+	slow      slowLog
+
+	mu       sync.RWMutex
+	exporter TraceExporter
+}
+
+// newRequestTracer 创建请求追踪器，threshold为0时使用默认阈值，size<=0时使用默认大小；
+// file非空时慢请求同时追加写入该文件
+func newRequestTracer(threshold time.Duration, size int, file string, nextID func() string, logger logging.Logger) (*requestTracer, error) {
+	if threshold == 0 {
+		threshold = defaultSlowRequestThreshold
+	}
+	if size <= 0 {
+		size = defaultSlowLogSize
+	}
+
+	t := &requestTracer{
+		threshold: threshold,
+		nextID:    nextID,
+		logger:    logger,
+		commits:   commitTracker{pending: make(map[raft.LogIndex]*requestTrace)},
+		slow:      slowLog{entries: make([]*TraceRecord, size)},
+	}
+	if file != "" && threshold > 0 {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		t.slow.file = f
+	}
+	return t, nil
+}
+
+// begin 开始追踪请求，沿用请求头中有效的请求ID，否则生成新的ID
+func (t *requestTracer) begin(r *http.Request) *requestTrace {
+	if t == nil {
+		return nil
+	}
+
+	id := r.Header.Get(requestIDHeader)
+	if !validRequestID(id) {
+		id = t.nextID()
+	}
+	trace := &requestTrace{
+		tracer: t,
+		id:     id,
+		method: r.Method,
+		path:   r.URL.Path,
+		start:  time.Now(),
+		key:    r.URL.Query().Get("key"),
+	}
+	trace.markAt(stageReceived, trace.start)
+	return trace
+}
+
+// finish 请求处理完毕，记录responded阶段，慢请求写入慢请求日志并导出追踪
+func (t *requestTracer) finish(trace *requestTrace, status int) {
+	if trace == nil {
+		return
+	}
+
+	end := time.Now()
+	trace.markAt(stageResponded, end)
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	trace.mu.Lock()
+	index := trace.index
+	trace.mu.Unlock()
+	if index > 0 {
+		t.commits.forget(index)
+	}
+
+	t.mu.RLock()
+	exporter := t.exporter
+	t.mu.RUnlock()
+
+	slow := t.threshold > 0 && end.Sub(trace.start) >= t.threshold
+	if !slow && exporter == nil {
+		return
+	}
+
+	record := trace.record(status, end)
+	if slow {
+		t.logger.Warn("慢请求", "request_id", record.RequestID, "method", record.Method, "path", record.Path,
+			"status", record.Status, "duration_ms", record.DurationMs)
+		if err := t.slow.add(record); err != nil {
+			t.logger.Warn("写入慢请求日志文件失败", logging.FieldError, err)
+		}
+	}
+	if exporter != nil {
+		go exporter.ExportTrace(record)
+	}
+}
+
+// validRequestID 客户端提供的请求ID是否可以沿用：非空、不超过最大长度且只含可见ASCII字符
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// statusRecorder 记录处理器写出的状态码
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap 供http.ResponseController访问底层连接
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// SetTraceExporter 设置追踪导出器，所有经过客户端API的请求在结束后导出
+func (s *Server) SetTraceExporter(exporter TraceExporter) {
+	s.traces.mu.Lock()
+	defer s.traces.mu.Unlock()
+	s.traces.exporter = exporter
+}
+
+// OnCommit 实现raft.CommitEventListener，标记等待提交的请求
+func (s *Server) OnCommit(event raft.CommitEvent) {
+	s.traces.commits.committed(event.CommitIndex, event.Time)
+}
+
+// handleSlowLog 返回最近的慢请求及其各阶段耗时，最新的在前；limit限制返回的条数
+func (s *Server) handleSlowLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "limit参数无效", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"thresholdMs": durationMs(s.traces.threshold),
+		"entries":     s.traces.slow.recent(limit),
+	})
+}
@@ -0,0 +1,121 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-21 10:05:37
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-21 10:05:37
+* @Description: ConcordKV Raft consensus server - trace_test.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// TestSlowLogStageBreakdown 等待多数派确认的慢写请求进入慢请求日志，各阶段耗时显示时间花在提交上；
+// 沿用客户端的请求ID，快速的读请求不记录
+func TestSlowLogStageBreakdown(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	proposer := &fakeProposer{sm: sm, hold: true}
+	var seq atomic.Uint64
+	nextID := func() string { return fmt.Sprintf("req-%d", seq.Add(1)) }
+	batcher := newProposalBatcher(proposer, sm, nextID, time.Millisecond, 64, 1024, nil)
+	batcher.Start()
+	defer batcher.Stop()
+
+	tracer, err := newRequestTracer(50*time.Millisecond, 8, "", nextID, logging.Nop())
+	if err != nil {
+		t.Fatalf("创建请求追踪器失败: %v", err)
+	}
+	s := &Server{config: &ServerConfig{}, logger: logging.Nop(), stateMachine: sm, proposals: batcher, traces: tracer}
+
+	write := s.api(writeRequest, func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := s.proposeCommand(w, r, statemachine.Command{Type: "SET", Key: "slow", Value: "v"}); ok {
+			w.WriteHeader(http.StatusOK)
+		}
+	}, http.MethodPost)
+	read := s.api(readRequest, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, http.MethodGet)
+
+	recorder := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest(http.MethodPost, "/api/set", nil)
+		req.Header.Set(requestIDHeader, "client-trace-1")
+		write.ServeHTTP(recorder, req)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for proposer.batchCount() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("命令未被提议")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 模拟等待跟随者确认80毫秒，提交后立即应用
+	time.Sleep(80 * time.Millisecond)
+	s.OnCommit(raft.CommitEvent{CommitIndex: 1, Time: time.Now()})
+	proposer.mu.Lock()
+	data := proposer.batches[0][0]
+	proposer.mu.Unlock()
+	sm.Apply(&raft.LogEntry{Index: 1, Term: 1, Timestamp: time.Now(), Type: raft.EntryNormal, Data: data})
+	<-done
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("写请求失败: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if id := recorder.Header().Get(requestIDHeader); id != "client-trace-1" {
+		t.Errorf("应沿用客户端的请求ID，实际 %q", id)
+	}
+
+	fast := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/get?key=slow", nil)
+	req.Header.Set(requestIDHeader, "bad id")
+	read.ServeHTTP(fast, req)
+	if id := fast.Header().Get(requestIDHeader); id == "" || id == "bad id" {
+		t.Errorf("无效的请求ID应重新生成，实际 %q", id)
+	}
+
+	slowlog := httptest.NewRecorder()
+	s.handleSlowLog(slowlog, httptest.NewRequest(http.MethodGet, "/api/slowlog", nil))
+	var body struct {
+		Entries []TraceRecord `json:"entries"`
+	}
+	if err := json.Unmarshal(slowlog.Body.Bytes(), &body); err != nil {
+		t.Fatalf("解析慢请求日志失败: %v %s", err, slowlog.Body.String())
+	}
+	if len(body.Entries) != 1 {
+		t.Fatalf("期望1条慢请求，实际 %+v", body.Entries)
+	}
+
+	entry := body.Entries[0]
+	if entry.RequestID != "client-trace-1" || entry.Command != "SET" || entry.Key != "slow" || entry.RaftIndex != 1 || entry.Status != http.StatusOK {
+		t.Errorf("慢请求记录不符: %+v", entry)
+	}
+	expected := []string{stageReceived, stageProposed, stageAppended, stageCommitted, stageApplied, stageResponded}
+	if len(entry.Stages) != len(expected) {
+		t.Fatalf("期望阶段 %v，实际 %+v", expected, entry.Stages)
+	}
+	stages := make(map[string]float64)
+	for i, stage := range entry.Stages {
+		if stage.Name != expected[i] {
+			t.Errorf("第%d个阶段 = %s, 期望 %s", i, stage.Name, expected[i])
+		}
+		stages[stage.Name] = stage.DurationMs
+	}
+	if stages[stageCommitted] < 80 || stages[stageApplied] >= stages[stageCommitted] {
+		t.Errorf("时间应花在等待提交上: %+v", entry.Stages)
+	}
+}
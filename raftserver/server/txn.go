@@ -0,0 +1,147 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-19 15:32:08
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-19 15:32:08
+* @Description: ConcordKV Raft consensus server - txn.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"raftserver/statemachine"
+)
+
+// TxnRequest 多键事务请求：compare中的条件全部成立时执行success中的操作，否则执行failure中的操作
+// 整个事务作为一个日志条目提议，条件在状态机应用时求值
+type TxnRequest struct {
+	Compare []statemachine.TxnCompare `json:"compare"`
+	Success []BatchOperation          `json:"success"` // 操作类型: get, set, delete
+	Failure []BatchOperation          `json:"failure"`
+}
+
+// txnCommand 校验事务请求并转换为TXN命令
+// 条件与操作的总数受MaxLogEntries限制，超过时返回errValueTooLarge
+func (s *Server) txnCommand(req *TxnRequest) (statemachine.Command, error) {
+	cmd := statemachine.Command{Type: "TXN", Compares: req.Compare}
+
+	size := len(req.Compare) + len(req.Success) + len(req.Failure)
+	if size == 0 {
+		return cmd, fmt.Errorf("%w: 事务不能为空", errInvalidCommand)
+	}
+	if max := s.config.MaxLogEntries; max > 0 && size > max {
+		return cmd, fmt.Errorf("%w: 事务包含 %d 个条件与操作，上限 %d", errValueTooLarge, size, max)
+	}
+
+	for i := range cmd.Compares {
+		cmd.Compares[i].Target = strings.ToLower(cmd.Compares[i].Target)
+		if err := s.checkEntry(cmd.Compares[i].Key, cmd.Compares[i].Value); err != nil {
+			return cmd, err
+		}
+	}
+
+	var err error
+	if cmd.Ops, err = s.txnOps(req.Success); err != nil {
+		return cmd, err
+	}
+	if cmd.Else, err = s.txnOps(req.Failure); err != nil {
+		return cmd, err
+	}
+
+	if err := statemachine.ValidateTxn(&cmd); err != nil {
+		return cmd, fmt.Errorf("%w: %v", errInvalidCommand, err)
+	}
+	return cmd, nil
+}
+
+// txnOps 把事务分支中的操作转换为命令
+func (s *Server) txnOps(ops []BatchOperation) ([]statemachine.Command, error) {
+	commands := make([]statemachine.Command, 0, len(ops))
+	for _, op := range ops {
+		switch op.Op {
+		case "get":
+			commands = append(commands, statemachine.Command{Type: "GET", Key: op.Key})
+		case "set":
+			if err := s.checkEntry(op.Key, op.Value); err != nil {
+				return nil, err
+			}
+			commands = append(commands, statemachine.Command{Type: "SET", Key: op.Key, Value: op.Value, TTLSeconds: op.TTLSeconds})
+		case "delete":
+			commands = append(commands, statemachine.Command{Type: "DELETE", Key: op.Key})
+		case "txn":
+			return nil, fmt.Errorf("%w: 不支持嵌套事务", errInvalidCommand)
+		default:
+			return nil, fmt.Errorf("%w: 未知操作类型: %s", errInvalidCommand, op.Op)
+		}
+	}
+	return commands, nil
+}
+
+// handleTxn 处理多键事务请求，响应中返回执行的分支及其中各操作的结果
+func (s *Server) handleTxn(w http.ResponseWriter, r *http.Request) {
+	if s.redirectWrite(w, r) {
+		return
+	}
+
+	var req TxnRequest
+	if s.config.MaxLogEntries > 0 {
+		s.limitBody(w, r, s.config.MaxLogEntries)
+	}
+	if !decodeLimited(w, r, &req) {
+		return
+	}
+
+	cmd, err := s.txnCommand(&req)
+	if err != nil {
+		writeEntryError(w, err)
+		return
+	}
+
+	// 任一键越权时拒绝整个事务：比较条件与get需要读权限，set与delete需要写权限
+	for _, cmp := range cmd.Compares {
+		if !s.authorizeKey(w, r, cmp.Key, statemachine.ACLRead) {
+			return
+		}
+	}
+	for _, op := range append(append([]statemachine.Command(nil), cmd.Ops...), cmd.Else...) {
+		permission := statemachine.ACLWrite
+		if op.Type == "GET" {
+			permission = statemachine.ACLRead
+		}
+		if !s.authorizeKey(w, r, op.Key, permission) {
+			return
+		}
+		s.load.record(op.Key, op.Type != "GET")
+	}
+
+	if err := attachSession(r, &cmd); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, err.Error())
+		return
+	}
+	index, result, ok := s.proposeCommand(w, r, cmd)
+	if !ok {
+		return
+	}
+
+	branch := "success"
+	if !result.Succeeded {
+		branch = "failure"
+	}
+	responses := result.Responses
+	if responses == nil {
+		responses = []statemachine.TxnOpResult{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"succeeded": result.Succeeded,
+		"branch":    branch,
+		"responses": responses,
+		"index":     index,
+	})
+}
@@ -0,0 +1,149 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-19 15:32:08
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-19 15:32:08
+* @Description: ConcordKV Raft consensus server - txn_test.go
+ */
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// TestTxnBranches 条件全部成立时执行成功分支，否则执行失败分支；不存在的键版本为0，按值比较不成立
+func TestTxnBranches(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	now := time.Now()
+	applyCommandAt(t, sm, 1, now, statemachine.Command{Type: "SET", Key: "a", Value: "1"})
+	applyCommandAt(t, sm, 2, now, statemachine.Command{Type: "SET", Key: "n", Value: float64(5)})
+
+	// 不存在的键按版本0比较，成功分支写入两个键并读取一个键
+	result, err := applyCommandAt(t, sm, 3, now, statemachine.Command{
+		Type: "TXN",
+		Compares: []statemachine.TxnCompare{
+			{Key: "lock", Target: statemachine.TxnTargetVersion, Op: "=", Version: 0},
+			{Key: "a", Target: statemachine.TxnTargetValue, Op: "=", Value: "1"},
+			{Key: "n", Target: statemachine.TxnTargetValue, Op: ">", Value: float64(3)},
+		},
+		Ops: []statemachine.Command{
+			{Type: "SET", Key: "lock", Value: "owner"},
+			{Type: "DELETE", Key: "a"},
+			{Type: "GET", Key: "n"},
+		},
+		Else: []statemachine.Command{{Type: "SET", Key: "lost", Value: "x"}},
+	})
+	if err != nil {
+		t.Fatalf("应用事务失败: %v", err)
+	}
+	if !result.Succeeded || len(result.Responses) != 3 {
+		t.Fatalf("应执行成功分支: %+v", result)
+	}
+	if r := result.Responses[0]; r.Type != "SET" || r.Version != 3 {
+		t.Errorf("SET的结果应带有新版本3: %+v", r)
+	}
+	if r := result.Responses[1]; !r.Exists {
+		t.Errorf("DELETE的结果应表明删除前键存在: %+v", r)
+	}
+	if r := result.Responses[2]; !r.Exists || r.Value != float64(5) || r.Version != 2 {
+		t.Errorf("GET的结果不正确: %+v", r)
+	}
+	if _, exists := sm.Get("lost"); exists {
+		t.Errorf("失败分支不应执行")
+	}
+	if _, exists := sm.Get("a"); exists {
+		t.Errorf("成功分支中的DELETE应生效")
+	}
+
+	// 任一条件不成立时执行失败分支，按值比较不存在的键不成立
+	for i, cmp := range []statemachine.TxnCompare{
+		{Key: "lock", Target: statemachine.TxnTargetVersion, Op: "=", Version: 0},
+		{Key: "a", Target: statemachine.TxnTargetValue, Op: "!=", Value: "1"},
+		{Key: "n", Target: statemachine.TxnTargetValue, Op: "<", Value: "9"},
+	} {
+		result, err := applyCommandAt(t, sm, raft.LogIndex(4+i), now, statemachine.Command{
+			Type:     "TXN",
+			Compares: []statemachine.TxnCompare{cmp},
+			Ops:      []statemachine.Command{{Type: "SET", Key: "won", Value: "x"}},
+			Else:     []statemachine.Command{{Type: "GET", Key: "lock"}, {Type: "GET", Key: "a"}},
+		})
+		if err != nil {
+			t.Fatalf("条件 %d: 应用事务失败: %v", i, err)
+		}
+		if result.Succeeded || len(result.Responses) != 2 {
+			t.Fatalf("条件 %d: 应执行失败分支: %+v", i, result)
+		}
+		if r := result.Responses[0]; !r.Exists || r.Value != "owner" {
+			t.Errorf("条件 %d: 失败分支应读到当前值: %+v", i, r)
+		}
+		if r := result.Responses[1]; r.Exists {
+			t.Errorf("条件 %d: 不存在的键应返回exists=false: %+v", i, r)
+		}
+	}
+	if _, exists := sm.Get("won"); exists {
+		t.Errorf("条件不成立时成功分支不应执行")
+	}
+}
+
+// TestTxnExpiredKeys 过期键在比较时视为不存在
+func TestTxnExpiredKeys(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	start := time.Now()
+	applyCommandAt(t, sm, 1, start, statemachine.Command{Type: "SET", Key: "lease", Value: "v", TTLSeconds: 1})
+
+	result, err := applyCommandAt(t, sm, 2, start.Add(2*time.Second), statemachine.Command{
+		Type:     "TXN",
+		Compares: []statemachine.TxnCompare{{Key: "lease", Target: statemachine.TxnTargetVersion, Op: "=", Version: 0}},
+		Ops:      []statemachine.Command{{Type: "SET", Key: "lease", Value: "mine"}},
+	})
+	if err != nil || !result.Succeeded {
+		t.Fatalf("过期键应按不存在比较: %+v %v", result, err)
+	}
+	if value, _ := sm.Get("lease"); value != "mine" {
+		t.Errorf("期望mine，实际 %v", value)
+	}
+}
+
+// TestTxnCommandValidation 空事务、嵌套事务与未知操作返回400，超过MaxLogEntries返回413
+func TestTxnCommandValidation(t *testing.T) {
+	s := &Server{config: &ServerConfig{MaxLogEntries: 3}}
+
+	set := BatchOperation{Op: "set", Key: "k", Value: "v"}
+	cases := []struct {
+		name string
+		req  TxnRequest
+		want error
+	}{
+		{"空事务", TxnRequest{}, errInvalidCommand},
+		{"嵌套事务", TxnRequest{Success: []BatchOperation{{Op: "txn", Key: "k"}}}, errInvalidCommand},
+		{"未知操作", TxnRequest{Failure: []BatchOperation{{Op: "incr", Key: "k"}}}, errInvalidCommand},
+		{"空键", TxnRequest{Success: []BatchOperation{{Op: "get"}}}, errInvalidCommand},
+		{"未知运算符", TxnRequest{Compare: []statemachine.TxnCompare{{Key: "k", Target: "version", Op: ">="}}}, errInvalidCommand},
+		{"超过上限", TxnRequest{Success: []BatchOperation{set, set}, Failure: []BatchOperation{set, set}}, errValueTooLarge},
+	}
+	for _, c := range cases {
+		if _, err := s.txnCommand(&c.req); !errors.Is(err, c.want) {
+			t.Errorf("%s: 期望 %v，实际 %v", c.name, c.want, err)
+		}
+	}
+
+	cmd, err := s.txnCommand(&TxnRequest{
+		Compare: []statemachine.TxnCompare{{Key: "k", Target: "VERSION", Op: ">", Version: 1}},
+		Success: []BatchOperation{set},
+		Failure: []BatchOperation{{Op: "get", Key: "k"}},
+	})
+	if err != nil {
+		t.Fatalf("合法的事务被拒绝: %v", err)
+	}
+	if cmd.Type != "TXN" || len(cmd.Ops) != 1 || cmd.Ops[0].Type != "SET" || len(cmd.Else) != 1 || cmd.Else[0].Type != "GET" {
+		t.Errorf("转换结果不正确: %+v", cmd)
+	}
+	if err := validateCommand(&cmd); err != nil {
+		t.Errorf("转换后的命令应通过提议校验: %v", err)
+	}
+}
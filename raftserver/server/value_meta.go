@@ -0,0 +1,85 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-31 15:20:44
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-31 15:20:44
+* @Description: ConcordKV Raft consensus server - value_meta.go
+ */
+package server
+
+import (
+	"fmt"
+
+	"raftserver/statemachine"
+)
+
+// scanItem 扫描结果中的键值对，压缩存储的值解压后返回
+type scanItem struct {
+	Key         string      `json:"key"`
+	Value       interface{} `json:"value,omitempty"`
+	ContentType string      `json:"contentType,omitempty"`
+	Compressed  bool        `json:"compressed,omitempty"`
+}
+
+// encodeValue 按写请求的元数据生成提议的值：要求压缩且JSON编码超过CompressThreshold时以gzip压缩，
+// 压缩后不更短时存储原值；未指定内容类型且未压缩时元数据为nil
+func (s *Server) encodeValue(value interface{}, contentType string, compress bool) (interface{}, *statemachine.ValueMeta, error) {
+	meta := &statemachine.ValueMeta{ContentType: contentType}
+	if err := meta.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	stored := value
+	if compress {
+		compressed, size, ok, err := statemachine.CompressValue(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok && size > s.config.CompressThreshold {
+			stored, meta.Encoding = compressed, statemachine.EncodingGzip
+		}
+	}
+
+	if *meta == (statemachine.ValueMeta{}) {
+		return stored, nil, nil
+	}
+	return stored, meta, nil
+}
+
+// metaFields 在读写响应中加入值的内容类型与是否压缩存储
+func metaFields(response map[string]interface{}, meta *statemachine.ValueMeta) {
+	if meta == nil {
+		return
+	}
+	if meta.ContentType != "" {
+		response["contentType"] = meta.ContentType
+	}
+	if meta.Compressed() {
+		response["compressed"] = true
+	}
+}
+
+// scanItems 把扫描结果转换为响应中的键值对，压缩存储的值逐个解压
+func scanItems(entries []statemachine.ScanEntry) ([]scanItem, error) {
+	items := make([]scanItem, len(entries))
+	for i, entry := range entries {
+		value, err := statemachine.DecodeValue(entry.Value, entry.Meta)
+		if err != nil {
+			return nil, fmt.Errorf("键 %q: %w", entry.Key, err)
+		}
+		items[i] = scanItem{Key: entry.Key, Value: value, ContentType: entry.Meta.ContentTypeOf(), Compressed: entry.Meta.Compressed()}
+	}
+	return items, nil
+}
+
+// decodeEvent 解压监听事件中压缩存储的值后推送，解压在监听者的协程中进行，不占用日志应用路径
+// 无法解压时推送存储的值并保留encoding字段，由客户端自行处理
+func decodeEvent(event statemachine.ChangeEvent) statemachine.ChangeEvent {
+	if event.Encoding == "" {
+		return event
+	}
+	if value, err := statemachine.DecodeValue(event.Value, &statemachine.ValueMeta{Encoding: event.Encoding}); err == nil {
+		event.Value, event.Encoding = value, ""
+	}
+	return event
+}
@@ -0,0 +1,151 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-31 16:02:19
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-31 16:02:19
+* @Description: ConcordKV Raft consensus server - value_meta_test.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// largeDocument 重复度高、压缩效果好的值
+func largeDocument() map[string]interface{} {
+	return map[string]interface{}{"title": "report", "body": strings.Repeat("concord ", 400), "pages": float64(12)}
+}
+
+// TestCompressedValueSnapshotRestore 压缩存储的值与元数据经快照恢复后保持不变，读取时透明解压
+func TestCompressedValueSnapshotRestore(t *testing.T) {
+	doc := largeDocument()
+	compressed, size, ok, err := statemachine.CompressValue(doc)
+	if err != nil || !ok || len(compressed) >= size {
+		t.Fatalf("压缩结果不正确: ok=%v err=%v 压缩后 %d 字节，原 %d 字节", ok, err, len(compressed), size)
+	}
+
+	sm := statemachine.NewKVStateMachine()
+	now := time.Now()
+	gzipMeta := &statemachine.ValueMeta{ContentType: "application/json", Encoding: statemachine.EncodingGzip}
+	for i, cmd := range []statemachine.Command{
+		{Type: "SET", Key: "doc", Value: compressed, Meta: gzipMeta},
+		{Type: "SET", Key: "note", Value: "hello", Meta: &statemachine.ValueMeta{ContentType: "text/plain"}},
+		{Type: "SET", Key: "plain", Value: "v"},
+	} {
+		if _, err := applyCommandAt(t, sm, raft.LogIndex(i+1), now, cmd); err != nil {
+			t.Fatalf("应用 %s 失败: %v", cmd.Key, err)
+		}
+	}
+	if _, err := applyCommandAt(t, sm, 4, now, statemachine.Command{Type: "SET", Key: "bad", Value: "v", Meta: &statemachine.ValueMeta{Encoding: "zstd"}}); err == nil {
+		t.Errorf("不支持的编码应被拒绝")
+	}
+
+	data, err := sm.CreateSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := statemachine.NewKVStateMachine()
+	if err := restored.RestoreSnapshot(data); err != nil {
+		t.Fatalf("恢复快照失败: %v", err)
+	}
+
+	value, meta, exists, err := restored.GetWithMeta("doc")
+	if !exists || err != nil || !reflect.DeepEqual(value, doc) {
+		t.Fatalf("恢复后读取doc = %v %v %v", exists, err, value)
+	}
+	if !reflect.DeepEqual(meta, gzipMeta) {
+		t.Errorf("恢复后doc的元数据 = %+v", meta)
+	}
+	if value, ok := restored.Get("doc"); !ok || !reflect.DeepEqual(value, doc) {
+		t.Errorf("Get应返回解压后的值")
+	}
+	if _, meta, _, _ := restored.GetWithMeta("note"); meta == nil || meta.ContentType != "text/plain" || meta.Compressed() {
+		t.Errorf("恢复后note的元数据 = %+v", meta)
+	}
+	if _, meta, _, _ := restored.GetWithMeta("plain"); meta != nil {
+		t.Errorf("未指定元数据的键不应有元数据: %+v", meta)
+	}
+	if !reflect.DeepEqual(sm.StateDigest(8), restored.StateDigest(8)) {
+		t.Errorf("恢复前后的摘要不一致")
+	}
+
+	// 覆盖写入不带元数据时清除之前的元数据
+	applyCommandAt(t, restored, 5, now, statemachine.Command{Type: "SET", Key: "doc", Value: "small"})
+	if value, meta, _, _ := restored.GetWithMeta("doc"); value != "small" || meta != nil {
+		t.Errorf("覆盖写入后doc = %v %+v", value, meta)
+	}
+}
+
+// TestCompressedScan 压缩与未压缩的值混合扫描，均返回原值与各自的元数据
+func TestCompressedScan(t *testing.T) {
+	s := startSingleNode(t, "node1", "")
+	doc := largeDocument()
+
+	for _, body := range []map[string]interface{}{
+		{"key": "m/doc", "value": doc, "contentType": "application/json", "compress": true},
+		{"key": "m/small", "value": "tiny", "compress": true}, // 未超过阈值，存储原值
+		{"key": "m/plain", "value": "v"},
+	} {
+		resp := postJSON(t, s, "/api/set", body, nil)
+		var out map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("写入 %s 的状态码 = %d: %v", body["key"], resp.StatusCode, out)
+		}
+		if compressed := out["compressed"] == true; compressed != (body["key"] == "m/doc") {
+			t.Errorf("写入 %s 的响应 compressed = %v", body["key"], out["compressed"])
+		}
+	}
+
+	resp, err := http.Get(apiURL(s, "/api/get?key=m/doc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if !reflect.DeepEqual(got["value"], doc) || got["contentType"] != "application/json" || got["compressed"] != true {
+		t.Errorf("读取m/doc = %v", got)
+	}
+
+	resp, err = http.Get(apiURL(s, "/api/scan?prefix=m/&values=true"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scanned struct {
+		Items []scanItem `json:"items"`
+	}
+	json.NewDecoder(resp.Body).Decode(&scanned)
+	resp.Body.Close()
+	want := []scanItem{
+		{Key: "m/doc", Value: doc, ContentType: "application/json", Compressed: true},
+		{Key: "m/plain", Value: "v"},
+		{Key: "m/small", Value: "tiny"},
+	}
+	if !reflect.DeepEqual(scanned.Items, want) {
+		t.Errorf("扫描结果 = %+v", scanned.Items)
+	}
+
+	// 压缩存储的值按原值参与CAS比较
+	resp = postJSON(t, s, "/api/cas", map[string]interface{}{"key": "m/doc", "expectedValue": doc, "newValue": "replaced"}, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("以原值CAS的状态码 = %d", resp.StatusCode)
+	}
+	if value, ok := s.stateMachine.Get("m/doc"); !ok || value != "replaced" {
+		t.Errorf("CAS后m/doc = %v", value)
+	}
+
+	if resp := postJSON(t, s, "/api/set", map[string]interface{}{"key": "m/bad", "value": "v", "contentType": strings.Repeat("x", 300)}, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("过长的contentType应返回400，实际 %d", resp.StatusCode)
+	}
+}
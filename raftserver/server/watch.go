@@ -0,0 +1,343 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - watch.go
+ */
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// 监听参数
+const (
+	defaultWatchBufferSize  = 256
+	defaultMaxWatchers      = 10000
+	defaultWatchHistorySize = 10000
+	watchHeartbeatInterval  = 15 * time.Second
+)
+
+// 需要重新同步的原因
+const (
+	resyncCompacted = "compacted" // 起始索引之前的事件已被快照或历史截断压缩
+	resyncOverflow  = "overflow"  // 消费过慢，缓冲区溢出时丢弃了事件
+	resyncSnapshot  = "snapshot"  // 本节点从快照恢复，状态发生了跳变
+)
+
+// watcher 单个监听者，事件经有界缓冲区投递，缓冲区满时丢弃事件并标记需要重新同步
+type watcher struct {
+	prefix    string
+	principal *statemachine.ACLToken
+
+	events chan statemachine.ChangeEvent
+	lagged chan struct{} // 容量为1，有事件被丢弃时发出信号
+
+	mu          sync.Mutex
+	resyncFrom  raft.LogIndex // 第一个丢失事件的索引
+	resyncWhy   string
+	droppedSize int
+}
+
+// matches 判断事件是否属于该监听者
+func (w *watcher) matches(event *statemachine.ChangeEvent) bool {
+	if !strings.HasPrefix(event.Key, w.prefix) {
+		return false
+	}
+	return w.principal == nil || w.principal.Allows(event.Key, statemachine.ACLRead)
+}
+
+// offer 非阻塞地投递事件，缓冲区满时丢弃并标记
+func (w *watcher) offer(event statemachine.ChangeEvent) {
+	select {
+	case w.events <- event:
+	default:
+		w.flag(event.Index, resyncOverflow, 1)
+	}
+}
+
+// flag 标记需要重新同步，保留第一个丢失事件的索引
+func (w *watcher) flag(index raft.LogIndex, reason string, dropped int) {
+	w.mu.Lock()
+	if w.resyncWhy == "" {
+		w.resyncFrom = index
+		w.resyncWhy = reason
+	}
+	w.droppedSize += dropped
+	w.mu.Unlock()
+
+	select {
+	case w.lagged <- struct{}{}:
+	default:
+	}
+}
+
+// takeResync 取出并清除重新同步标记
+func (w *watcher) takeResync() (raft.LogIndex, string, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	index, reason, dropped := w.resyncFrom, w.resyncWhy, w.droppedSize
+	w.resyncFrom, w.resyncWhy, w.droppedSize = 0, "", 0
+	return index, reason, dropped
+}
+
+// watchHub 将状态机的变更事件分发给所有监听者
+// 在应用日志的协程中被调用，只做非阻塞投递，慢消费者不会阻塞日志应用
+type watchHub struct {
+	mu       sync.Mutex
+	watchers map[*watcher]struct{}
+
+	// 最近的事件，用于fromIndex之后的补发；超过上限时截断较早的一半
+	history     []statemachine.ChangeEvent
+	historySize int
+	compacted   raft.LogIndex // 该索引及之前的事件已不再保留
+
+	bufferSize  int
+	maxWatchers int
+
+	// snapshotIndex 最近一次快照包含的索引，之前的事件无法补发
+	snapshotIndex func() raft.LogIndex
+}
+
+// newWatchHub 创建监听分发器，非正数参数使用默认值
+func newWatchHub(bufferSize, maxWatchers int, snapshotIndex func() raft.LogIndex) *watchHub {
+	if bufferSize <= 0 {
+		bufferSize = defaultWatchBufferSize
+	}
+	if maxWatchers <= 0 {
+		maxWatchers = defaultMaxWatchers
+	}
+
+	return &watchHub{
+		watchers:      make(map[*watcher]struct{}),
+		historySize:   defaultWatchHistorySize,
+		bufferSize:    bufferSize,
+		maxWatchers:   maxWatchers,
+		snapshotIndex: snapshotIndex,
+	}
+}
+
+// OnChanges 实现statemachine.ChangeListener
+func (h *watchHub) OnChanges(events []statemachine.ChangeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.history = append(h.history, events...)
+	if len(h.history) > h.historySize {
+		cut := len(h.history) / 2
+		h.compacted = h.history[cut-1].Index
+		h.history = append([]statemachine.ChangeEvent(nil), h.history[cut:]...)
+	}
+
+	for w := range h.watchers {
+		for i := range events {
+			if w.matches(&events[i]) {
+				w.offer(events[i])
+			}
+		}
+	}
+}
+
+// OnRestore 实现statemachine.ChangeListener，快照恢复后通知所有监听者重新同步
+func (h *watchHub) OnRestore() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.history = nil
+	for w := range h.watchers {
+		w.flag(0, resyncSnapshot, 0)
+	}
+}
+
+// subscribe 注册监听者，返回fromIndex之后需要补发的历史事件
+// fromIndex不晚于最近的快照或已截断的历史时返回compacted，调用方需通知客户端重新同步
+func (h *watchHub) subscribe(prefix string, fromIndex raft.LogIndex, principal *statemachine.ACLToken) (*watcher, []statemachine.ChangeEvent, raft.LogIndex, error) {
+	var snapshotIndex raft.LogIndex
+	if h.snapshotIndex != nil && fromIndex > 0 {
+		snapshotIndex = h.snapshotIndex()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.watchers) >= h.maxWatchers {
+		return nil, nil, 0, fmt.Errorf("监听者数量已达上限 %d", h.maxWatchers)
+	}
+
+	w := &watcher{
+		prefix:    prefix,
+		principal: principal,
+		events:    make(chan statemachine.ChangeEvent, h.bufferSize),
+		lagged:    make(chan struct{}, 1),
+	}
+	h.watchers[w] = struct{}{}
+
+	if fromIndex == 0 {
+		return w, nil, 0, nil
+	}
+
+	compacted := h.compacted
+	if snapshotIndex > compacted {
+		compacted = snapshotIndex
+	}
+	if fromIndex <= compacted {
+		return w, nil, compacted, nil
+	}
+
+	var replay []statemachine.ChangeEvent
+	for i := range h.history {
+		if h.history[i].Index >= fromIndex && w.matches(&h.history[i]) {
+			replay = append(replay, h.history[i])
+		}
+	}
+	return w, replay, 0, nil
+}
+
+// unsubscribe 注销监听者
+func (h *watchHub) unsubscribe(w *watcher) {
+	h.mu.Lock()
+	delete(h.watchers, w)
+	h.mu.Unlock()
+}
+
+// count 当前监听者数量
+func (h *watchHub) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.watchers)
+}
+
+// writeSSE 以Server-Sent Events格式写出一个事件
+func writeSSE(w http.ResponseWriter, eventType string, id raft.LogIndex, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, payload)
+	return err
+}
+
+// handleWatch 以SSE流推送键的变更事件
+// 事件来自本节点应用的日志，任意节点都可以提供监听；fromIndex指定从哪个日志索引开始补发
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "连接不支持流式响应", http.StatusInternalServerError)
+		return
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	var fromIndex raft.LogIndex
+	if v := r.URL.Query().Get("fromIndex"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "fromIndex参数无效", http.StatusBadRequest)
+			return
+		}
+		fromIndex = raft.LogIndex(n)
+	}
+
+	if !s.authorizePrefix(w, r, prefix) {
+		return
+	}
+
+	watcher, replay, compacted, err := s.watches.subscribe(prefix, fromIndex, principalFrom(r))
+	if err != nil {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer s.watches.unsubscribe(watcher)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if compacted > 0 {
+		if err := writeResync(w, compacted, resyncCompacted, 0); err != nil {
+			return
+		}
+	}
+	for i := range replay {
+		if err := writeSSE(w, replay[i].Type, replay[i].Index, replay[i]); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(watchHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-watcher.events:
+			if err := writeSSE(w, event.Type, event.Index, decodeEvent(event)); err != nil {
+				return
+			}
+		case <-watcher.lagged:
+			// 先写出丢弃发生前已缓冲的事件，再通知客户端重新同步
+			for drained := false; !drained; {
+				select {
+				case event := <-watcher.events:
+					if err := writeSSE(w, event.Type, event.Index, decodeEvent(event)); err != nil {
+						return
+					}
+				default:
+					drained = true
+				}
+			}
+			index, reason, dropped := watcher.takeResync()
+			if reason == "" {
+				continue
+			}
+			if err := writeResync(w, index, reason, dropped); err != nil {
+				return
+			}
+			if reason == resyncOverflow {
+				s.logger.Warn("监听者消费过慢，丢弃事件", "remote_addr", r.RemoteAddr, "dropped", dropped)
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeResync 通知客户端事件流不再连续，需要重新读取数据后继续监听
+func writeResync(w http.ResponseWriter, index raft.LogIndex, reason string, dropped int) error {
+	event := map[string]interface{}{
+		"type":      "resync",
+		"reason":    reason,
+		"raftIndex": index,
+	}
+	if dropped > 0 {
+		event["dropped"] = dropped
+	}
+	return writeSSE(w, "resync", 0, event)
+}
@@ -0,0 +1,163 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - watch_test.go
+ */
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+// TestWatchHub 补发起始索引之后的历史事件，压缩的起始索引与溢出的缓冲区都要求重新同步
+func TestWatchHub(t *testing.T) {
+	var snapshotIndex raft.LogIndex
+	hub := newWatchHub(2, 10, func() raft.LogIndex { return snapshotIndex })
+
+	for i := 1; i <= 5; i++ {
+		hub.OnChanges([]statemachine.ChangeEvent{{Type: "put", Key: "a/" + strconv.Itoa(i), Index: raft.LogIndex(i)}})
+	}
+	hub.OnChanges([]statemachine.ChangeEvent{{Type: "put", Key: "b/x", Index: 6}})
+
+	_, replay, compacted, err := hub.subscribe("a/", 4, nil)
+	if err != nil || compacted != 0 || len(replay) != 2 || replay[0].Index != 4 {
+		t.Fatalf("补发事件不正确: %+v compacted=%d err=%v", replay, compacted, err)
+	}
+
+	snapshotIndex = 3
+	if _, replay, compacted, _ := hub.subscribe("a/", 3, nil); compacted != 3 || replay != nil {
+		t.Fatalf("起始索引不晚于快照时应要求重新同步: compacted=%d replay=%v", compacted, replay)
+	}
+
+	// 缓冲区容量为2，第三个事件被丢弃并标记
+	slow, _, _, _ := hub.subscribe("c/", 0, nil)
+	for i := 7; i <= 9; i++ {
+		hub.OnChanges([]statemachine.ChangeEvent{{Type: "delete", Key: "c/k", Index: raft.LogIndex(i)}})
+	}
+	if len(slow.events) != 2 {
+		t.Fatalf("缓冲事件数应为2，实际 %d", len(slow.events))
+	}
+	select {
+	case <-slow.lagged:
+	default:
+		t.Fatalf("丢弃事件后应发出信号")
+	}
+	if index, reason, dropped := slow.takeResync(); index != 9 || reason != resyncOverflow || dropped != 1 {
+		t.Errorf("重新同步标记不正确: %d %s %d", index, reason, dropped)
+	}
+
+	hub.OnRestore()
+	if _, reason, _ := slow.takeResync(); reason != resyncSnapshot {
+		t.Errorf("快照恢复后应要求重新同步，实际 %q", reason)
+	}
+
+	for i := 0; i < 7; i++ {
+		hub.subscribe("", 0, nil)
+	}
+	if _, _, _, err := hub.subscribe("", 0, nil); err == nil {
+		t.Errorf("超过监听者上限时应拒绝")
+	}
+}
+
+// sseEvent 解析出的SSE事件
+type sseEvent struct {
+	Type string
+	Data map[string]interface{}
+}
+
+// readSSE 从流中读取下一个事件，跳过心跳注释
+func readSSE(t *testing.T, reader *bufio.Reader) sseEvent {
+	t.Helper()
+
+	var event sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("读取事件流失败: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && event.Type != "":
+			return event
+		case strings.HasPrefix(line, "event: "):
+			event.Type = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.Data)
+		}
+	}
+}
+
+// TestWatchStream 应用日志产生的变更以SSE事件推送给匹配前缀的监听者
+func TestWatchStream(t *testing.T) {
+	sm := statemachine.NewKVStateMachine()
+	s := &Server{config: &ServerConfig{}, stateMachine: sm, logger: logging.Nop()}
+	s.watches = newWatchHub(0, 0, func() raft.LogIndex { return 0 })
+	sm.SetChangeListener(s.watches)
+
+	applyCommand(t, sm, 1, statemachine.Command{Type: "SET", Key: "cfg/a", Value: "1"})
+
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWatch))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "?prefix=cfg/&fromIndex=1")
+	if err != nil {
+		t.Fatalf("建立监听失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type不正确: %s", ct)
+	}
+	reader := bufio.NewReader(resp.Body)
+
+	if event := readSSE(t, reader); event.Type != "put" || event.Data["key"] != "cfg/a" || event.Data["raftIndex"] != float64(1) {
+		t.Fatalf("补发事件不正确: %+v", event)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for s.watches.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	applyCommand(t, sm, 2, statemachine.Command{Type: "SET", Key: "other", Value: "x"})
+	applyCommand(t, sm, 3, statemachine.Command{Type: "BATCH", Ops: []statemachine.Command{
+		{Type: "SET", Key: "cfg/b", Value: "2"},
+		{Type: "DELETE", Key: "cfg/a"},
+	}})
+	// 比较失败的CAS不产生事件
+	applyCommand(t, sm, 4, statemachine.Command{Type: "CAS", Key: "cfg/b", Expected: "nope", Value: "3"})
+	applyCommand(t, sm, 5, statemachine.Command{Type: "DELETE", Key: "cfg/b"})
+
+	want := []struct {
+		typ   string
+		key   string
+		index float64
+	}{
+		{"put", "cfg/b", 3},
+		{"delete", "cfg/a", 3},
+		{"delete", "cfg/b", 5},
+	}
+	for _, w := range want {
+		event := readSSE(t, reader)
+		if event.Type != w.typ || event.Data["key"] != w.key || event.Data["raftIndex"] != w.index {
+			t.Fatalf("期望 %s %s@%v，实际 %+v", w.typ, w.key, w.index, event)
+		}
+	}
+
+	s.watches.OnRestore()
+	if event := readSSE(t, reader); event.Type != "resync" || event.Data["reason"] != resyncSnapshot {
+		t.Fatalf("期望重新同步事件，实际 %+v", event)
+	}
+}
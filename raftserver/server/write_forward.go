@@ -0,0 +1,420 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-25 09:42:16
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-25 09:42:16
+* @Description: ConcordKV Raft consensus server - write_forward.go
+ */
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"raftserver/kverrors"
+	"raftserver/logging"
+	"raftserver/metrics"
+	"raftserver/raft"
+	"raftserver/statemachine"
+)
+
+const (
+	// forwardedWriteTTL 领导者保留转发写请求结果的时间，转发方或客户端在此期间以同一令牌重试时返回该结果
+	forwardedWriteTTL = time.Minute
+
+	// maxForwardedWrites 保留的转发结果超过该数量时清理已过期的结果
+	maxForwardedWrites = 4096
+)
+
+// errForwardFailed 未能把写请求转发给领导者
+var errForwardFailed = errors.New("转发写请求到领导者失败")
+
+// forwardableCommands 允许跟随者转发的命令类型，只包括客户端写请求；ACL与分片等管理命令仍需发往领导者
+var forwardableCommands = map[string]bool{
+	"SET": true, "DELETE": true, "BATCH": true, "CAS": true, "TXN": true,
+	"SESSION_REGISTER": true, "SESSION_KEEPALIVE": true, "SESSION_CLOSE": true,
+}
+
+// forwardErrorCodes 领导者通过错误码把提议失败的原因传回转发方，转发方还原为同一错误后按本地提议的方式响应客户端
+var forwardErrorCodes = []struct {
+	code string
+	err  error
+}{
+	{"not_leader", raft.ErrNotLeader},
+	{"queue_full", ErrProposalQueueFull},
+	{"invalid_command", errInvalidCommand},
+	{"session_expired", statemachine.ErrSessionExpired},
+	{"stale_sequence", statemachine.ErrStaleSequence},
+	{"too_many_pending", statemachine.ErrTooManyPendingResponses},
+	{"quota_exceeded", statemachine.ErrQuotaExceeded},
+	{"replication_gap", statemachine.ErrReplicationGap},
+	{"shard_not_found", statemachine.ErrShardNotFound},
+	{"shard_migrating", statemachine.ErrShardMigrating},
+	{"invalid_shard_op", statemachine.ErrInvalidShardOp},
+	{"timeout", context.DeadlineExceeded},
+	{"stopped", ErrBatcherStopped},
+}
+
+// forwardedError 转发方还原的领导者错误，保留领导者的错误信息
+type forwardedError struct {
+	err error
+	msg string
+}
+
+func (e *forwardedError) Error() string { return e.msg }
+func (e *forwardedError) Unwrap() error { return e.err }
+
+// forwardErrorCode 错误对应的错误码，命令未被提议的错误之外的未知错误返回internal
+func forwardErrorCode(err error) string {
+	if errors.Is(err, context.Canceled) {
+		return "timeout"
+	}
+	for _, c := range forwardErrorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return "internal"
+}
+
+// forwardCodeError 还原错误码对应的错误
+func forwardCodeError(code, msg string) error {
+	for _, c := range forwardErrorCodes {
+		if c.code != code {
+			continue
+		}
+		if msg == "" || msg == c.err.Error() {
+			return c.err
+		}
+		return &forwardedError{err: c.err, msg: msg}
+	}
+	return errors.New(msg)
+}
+
+// notProposed 错误表明命令一定没有追加到日志，以同一令牌重试时应重新提议
+func notProposed(err error) bool {
+	return errors.Is(err, raft.ErrNotLeader) || errors.Is(err, ErrProposalQueueFull) ||
+		errors.Is(err, errInvalidCommand) || errors.Is(err, ErrBatcherStopped)
+}
+
+// WriteForwardStats 写请求转发的统计
+type WriteForwardStats struct {
+	Forwarded     uint64  `json:"forwarded"`     // 作为跟随者转发给领导者的写请求数
+	Failed        uint64  `json:"failed"`        // 未能送达领导者的转发请求数
+	AvgLatencyMs  float64 `json:"avgLatencyMs"`  // 转发请求的平均耗时（毫秒），包括节点间往返与等待领导者提交
+	MaxLatencyMs  float64 `json:"maxLatencyMs"`  // 转发请求的最大耗时（毫秒）
+	Received      uint64  `json:"received"`      // 作为领导者收到的转发写请求数
+	Deduplicated  uint64  `json:"deduplicated"`  // 按幂等令牌返回已有结果而未再次提议的转发请求数
+	PendingTokens int     `json:"pendingTokens"` // 领导者保留的转发结果数
+}
+
+// writeForwardStats 写请求转发的计数器
+type writeForwardStats struct {
+	forwarded    atomic.Uint64
+	failed       atomic.Uint64
+	latencyNanos atomic.Int64
+	maxNanos     atomic.Int64
+	received     atomic.Uint64
+	deduplicated atomic.Uint64
+}
+
+// observe 记录一次转发的耗时与结果
+func (st *writeForwardStats) observe(latency time.Duration, err error) {
+	st.forwarded.Add(1)
+	if err != nil {
+		st.failed.Add(1)
+	}
+	st.latencyNanos.Add(int64(latency))
+	for {
+		max := st.maxNanos.Load()
+		if int64(latency) <= max || st.maxNanos.CompareAndSwap(max, int64(latency)) {
+			return
+		}
+	}
+}
+
+// snapshot 统计快照
+func (st *writeForwardStats) snapshot() WriteForwardStats {
+	stats := WriteForwardStats{
+		Forwarded:    st.forwarded.Load(),
+		Failed:       st.failed.Load(),
+		MaxLatencyMs: float64(st.maxNanos.Load()) / float64(time.Millisecond),
+		Received:     st.received.Load(),
+		Deduplicated: st.deduplicated.Load(),
+	}
+	if stats.Forwarded > 0 {
+		stats.AvgLatencyMs = float64(st.latencyNanos.Load()) / float64(stats.Forwarded) / float64(time.Millisecond)
+	}
+	return stats
+}
+
+// forwardedWrite 一个幂等令牌对应的转发写请求，done关闭后resp为其结果
+type forwardedWrite struct {
+	done    chan struct{}
+	resp    *raft.ForwardProposeResponse
+	expires time.Time
+}
+
+// forwardedWrites 领导者按幂等令牌记录转发写请求的结果
+// 同一令牌的请求只提议一次：在途时后来者等待第一次的结果，完成后在forwardedWriteTTL内直接返回该结果
+type forwardedWrites struct {
+	mu      sync.Mutex
+	entries map[string]*forwardedWrite
+}
+
+// newForwardedWrites 创建转发结果表
+func newForwardedWrites() *forwardedWrites {
+	return &forwardedWrites{entries: make(map[string]*forwardedWrite)}
+}
+
+// begin 登记令牌，返回的owner为true时调用方负责提议并调用finish，否则等待已有请求的结果
+func (f *forwardedWrites) begin(token string) (*forwardedWrite, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	if entry, ok := f.entries[token]; ok && (entry.expires.IsZero() || now.Before(entry.expires)) {
+		return entry, false
+	}
+
+	if len(f.entries) >= maxForwardedWrites {
+		for t, entry := range f.entries {
+			if !entry.expires.IsZero() && !now.Before(entry.expires) {
+				delete(f.entries, t)
+			}
+		}
+	}
+
+	entry := &forwardedWrite{done: make(chan struct{})}
+	f.entries[token] = entry
+	return entry, true
+}
+
+// finish 记录结果并唤醒等待者；命令未被提议时移除令牌，之后的重试重新提议
+func (f *forwardedWrites) finish(token string, entry *forwardedWrite, resp *raft.ForwardProposeResponse, keep bool) {
+	f.mu.Lock()
+	entry.resp = resp
+	entry.expires = time.Now().Add(forwardedWriteTTL)
+	if !keep && f.entries[token] == entry {
+		delete(f.entries, token)
+	}
+	f.mu.Unlock()
+
+	close(entry.done)
+}
+
+// size 保留的转发结果数
+func (f *forwardedWrites) size() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.entries)
+}
+
+// forwardToken 转发写请求的幂等令牌
+// 带会话序号的命令使用(会话, 序号)，客户端在转发方故障后经其他节点重试时令牌不变；
+// 其余命令使用本节点的请求ID，只保护转发方自身的重试，跨节点的重试需要客户端使用会话
+func forwardToken(cmd statemachine.Command, nextID func() string) string {
+	if cmd.SessionID != "" && cmd.SeqNum != 0 {
+		return fmt.Sprintf("session/%s/%d", cmd.SessionID, cmd.SeqNum)
+	}
+	return "request/" + nextID()
+}
+
+// redirectWrite 写请求的重定向：启用写转发时由proposeCommand把命令转发给领导者，不重定向客户端
+// 已被其他节点代理过的请求不再转发，转发深度不超过1
+func (s *Server) redirectWrite(w http.ResponseWriter, r *http.Request) bool {
+	if s.config.ForwardWrites && r.Header.Get(forwardedHeader) == "" {
+		return false
+	}
+	return s.redirectToLeader(w, r)
+}
+
+// submitCommand 本节点是领导者或未启用写转发时通过提议批处理器提交，否则转发给领导者
+// 等待超时的错误包装为kverrors.ErrTimeout，非领导者的错误附带当前已知的领导者
+func (s *Server) submitCommand(ctx context.Context, cmd statemachine.Command) (raft.LogIndex, *statemachine.CommandResult, error) {
+	var (
+		index  raft.LogIndex
+		result *statemachine.CommandResult
+		err    error
+	)
+	if s.config.ForwardWrites && !s.raftNode.IsLeader() {
+		index, result, err = s.forwardCommand(ctx, cmd)
+	} else {
+		index, result, err = s.proposals.Submit(ctx, cmd)
+	}
+
+	var notLeader *kverrors.NotLeaderError
+	switch {
+	case err == nil:
+	case errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, kverrors.ErrTimeout):
+		err = fmt.Errorf("%w: %w", kverrors.ErrTimeout, err)
+	case errors.Is(err, kverrors.ErrNotLeader) && !errors.As(err, &notLeader):
+		leader := s.currentLeader()
+		err = &kverrors.NotLeaderError{LeaderID: string(leader), LeaderAddr: s.leaderAPIAddr(leader)}
+	}
+	return index, result, err
+}
+
+// forwardCommand 经Raft传输层把命令转发给当前领导者，等待其被应用后返回领导者的结果
+// 总是直接发往领导者；传输失败时领导者可能已提议该命令，以同一令牌重试一次，由领导者返回第一次的结果
+func (s *Server) forwardCommand(ctx context.Context, cmd statemachine.Command) (raft.LogIndex, *statemachine.CommandResult, error) {
+	leader := s.currentLeader()
+	forwarder, ok := s.transport.(raft.WriteForwarder)
+	if leader == "" || leader == s.config.NodeID || !ok {
+		return 0, nil, raft.ErrNotLeader
+	}
+
+	// 在本地校验，无效的命令不占用一次节点间往返
+	if err := validateCommand(&cmd); err != nil {
+		return 0, nil, err
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: 序列化命令失败: %v", errInvalidCommand, err)
+	}
+
+	req := &raft.ForwardProposeRequest{
+		From:    s.config.NodeID,
+		Token:   forwardToken(cmd, s.nextRequestID),
+		Command: data,
+	}
+
+	traceFrom(ctx).mark(stageForwarded)
+	start := time.Now()
+	resp, err := forwarder.ForwardPropose(ctx, leader, req)
+	if err != nil && ctx.Err() == nil {
+		s.logger.Warn("转发写请求失败，以同一令牌重试", "leader", leader, "token", req.Token, logging.FieldError, err)
+		resp, err = forwarder.ForwardPropose(ctx, leader, req)
+	}
+	s.forwardStats.observe(time.Since(start), err)
+
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return 0, nil, ctxErr
+		}
+		return 0, nil, fmt.Errorf("%w: %v", errForwardFailed, err)
+	}
+
+	index, result, err := decodeForwardResponse(resp)
+	if err == nil {
+		traceFrom(ctx).mark(stageApplied)
+	}
+	return index, result, err
+}
+
+// decodeForwardResponse 还原领导者返回的日志索引、应用结果与错误
+func decodeForwardResponse(resp *raft.ForwardProposeResponse) (raft.LogIndex, *statemachine.CommandResult, error) {
+	var result *statemachine.CommandResult
+	if len(resp.Result) > 0 {
+		result = &statemachine.CommandResult{}
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return resp.Index, nil, fmt.Errorf("解析领导者返回的结果失败: %w", err)
+		}
+	}
+
+	if resp.ErrorCode != "" {
+		return resp.Index, result, forwardCodeError(resp.ErrorCode, resp.Error)
+	}
+	if result == nil {
+		result = &statemachine.CommandResult{}
+	}
+	return resp.Index, result, nil
+}
+
+// HandleForwardPropose 实现transport.ForwardProposeHandler，提议跟随者转发的写请求并等待其被应用
+// 本节点不是领导者时拒绝并返回已知的领导者，不再继续转发；同一幂等令牌只提议一次
+func (s *Server) HandleForwardPropose(ctx context.Context, req *raft.ForwardProposeRequest) *raft.ForwardProposeResponse {
+	if !s.raftNode.IsLeader() {
+		return &raft.ForwardProposeResponse{
+			ErrorCode: forwardErrorCode(raft.ErrNotLeader),
+			Error:     raft.ErrNotLeader.Error(),
+			LeaderID:  s.raftNode.GetLeader(),
+		}
+	}
+
+	var cmd statemachine.Command
+	if err := json.Unmarshal(req.Command, &cmd); err != nil {
+		return forwardResponse(0, nil, fmt.Errorf("%w: 解析转发的命令失败: %v", errInvalidCommand, err))
+	}
+	if !forwardableCommands[cmd.Type] {
+		return forwardResponse(0, nil, fmt.Errorf("%w: 不能转发%s命令", errInvalidCommand, cmd.Type))
+	}
+
+	s.forwardStats.received.Add(1)
+
+	entry, owner := s.forwardedWrites.begin(req.Token)
+	if !owner {
+		s.forwardStats.deduplicated.Add(1)
+		select {
+		case <-entry.done:
+			return duplicateForwardResponse(entry.resp)
+		case <-ctx.Done():
+			return forwardResponse(0, nil, ctx.Err())
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, applyWaitTimeout)
+	defer cancel()
+
+	index, result, err := s.proposals.Submit(ctx, cmd)
+	resp := forwardResponse(index, result, err)
+	s.forwardedWrites.finish(req.Token, entry, resp, err == nil || !notProposed(err))
+
+	if err != nil {
+		s.logger.Debug("转发的写请求失败", "from", req.From, "type", cmd.Type, logging.FieldError, err)
+	}
+	return resp
+}
+
+// forwardResponse 构造转发写请求的响应
+func forwardResponse(index raft.LogIndex, result *statemachine.CommandResult, err error) *raft.ForwardProposeResponse {
+	resp := &raft.ForwardProposeResponse{Index: index}
+	if result != nil {
+		resp.Result, _ = json.Marshal(result)
+	}
+	if err != nil {
+		resp.ErrorCode = forwardErrorCode(err)
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// duplicateForwardResponse 重试的请求返回第一次的结果，并标记为重复请求
+func duplicateForwardResponse(resp *raft.ForwardProposeResponse) *raft.ForwardProposeResponse {
+	if len(resp.Result) == 0 {
+		return resp
+	}
+
+	var result statemachine.CommandResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return resp
+	}
+	result.Duplicate = true
+
+	dup := *resp
+	dup.Result, _ = json.Marshal(&result)
+	return &dup
+}
+
+// collectWriteForwardMetrics 输出写请求转发的计数与耗时
+func (s *Server) collectWriteForwardMetrics() []*metrics.Family {
+	stats := s.forwardStats.snapshot()
+	return []*metrics.Family{
+		metrics.NewCounter("write_forwarded_total", "Client writes forwarded to the leader by this node.").
+			With(float64(stats.Forwarded)),
+		metrics.NewCounter("write_forward_failures_total", "Forwarded writes that could not be delivered to the leader.").
+			With(float64(stats.Failed)),
+		metrics.NewCounter("write_forward_latency_seconds_total", "Total time spent forwarding writes to the leader, including waiting for the commit.").
+			With(float64(s.forwardStats.latencyNanos.Load()) / float64(time.Second)),
+		metrics.NewCounter("write_forward_received_total", "Forwarded writes received by this node as leader.").
+			With(float64(stats.Received)),
+		metrics.NewCounter("write_forward_deduplicated_total", "Forwarded writes answered from an earlier attempt with the same idempotency token.").
+			With(float64(stats.Deduplicated)),
+	}
+}
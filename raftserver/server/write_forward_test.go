@@ -0,0 +1,231 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-25 09:42:16
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-25 09:42:16
+* @Description: ConcordKV Raft consensus server - write_forward_test.go
+ */
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"raftserver/kverrors"
+	"raftserver/logging"
+	"raftserver/raft"
+	"raftserver/statemachine"
+	"raftserver/storage"
+	"raftserver/transport"
+)
+
+// startForwardingCluster 启动启用写转发的三节点集群，返回领导者与两个跟随者
+func startForwardingCluster(t *testing.T, kind transport.Kind) (*Server, []*Server) {
+	t.Helper()
+	ids := []raft.NodeID{"node1", "node2", "node3"}
+	peers := make(map[raft.NodeID]string)
+	apis := make(map[raft.NodeID]string)
+	used := make(map[string]bool)
+	uniqueAddr := func() string {
+		for {
+			if addr := freeAddr(t); !used[addr] {
+				used[addr] = true
+				return addr
+			}
+		}
+	}
+	for _, id := range ids {
+		peers[id] = uniqueAddr()
+		apis[id] = uniqueAddr()
+	}
+
+	servers := make([]*Server, 0, len(ids))
+	for _, id := range ids {
+		peerCopy := make(map[raft.NodeID]string)
+		apiCopy := make(map[raft.NodeID]string)
+		for peer, addr := range peers {
+			peerCopy[peer] = addr
+			apiCopy[peer] = apis[peer]
+		}
+		s, err := NewServerWithConfig(&ServerConfig{
+			NodeID:              id,
+			ListenAddr:          peers[id],
+			APIAddr:             apis[id],
+			ElectionTimeout:     150 * time.Millisecond,
+			HeartbeatInterval:   30 * time.Millisecond,
+			MaxLogEntries:       100,
+			SnapshotThreshold:   1000,
+			Peers:               peerCopy,
+			PeerAPIAddrs:        apiCopy,
+			Transport:           kind,
+			Storage:             storage.BackendMemory,
+			AllowVolatile:       true,
+			ProposalBatchWindow: time.Millisecond,
+			ForwardWrites:       true,
+			Logger:              logging.Nop(),
+		})
+		if err != nil {
+			t.Fatalf("创建服务器 %s 失败: %v", id, err)
+		}
+		if err := s.Start(); err != nil {
+			t.Fatalf("启动服务器 %s 失败: %v", id, err)
+		}
+		t.Cleanup(func() { s.Stop() })
+		servers = append(servers, s)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var leader *Server
+		var followers []*Server
+		for _, s := range servers {
+			if s.raftNode.IsLeader() {
+				leader = s
+			} else if s.currentLeader() != "" {
+				followers = append(followers, s)
+			}
+		}
+		if leader != nil && len(followers) == len(servers)-1 {
+			return leader, followers
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("集群未选出领导者")
+	return nil, nil
+}
+
+// postJSON 向节点发送写请求，不跟随重定向
+func postJSON(t *testing.T, s *Server, path string, body interface{}, header http.Header) *http.Response {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequest(http.MethodPost, apiURL(s, path), bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("请求 %s 失败: %v", path, err)
+	}
+	return resp
+}
+
+// TestWriteForwarding 跟随者把写请求转发给领导者并在提交后响应；会话请求经另一个跟随者重试时不会重复执行
+func TestWriteForwarding(t *testing.T) {
+	for _, kind := range []transport.Kind{transport.KindHTTP, transport.KindGRPC} {
+		t.Run(string(kind), func(t *testing.T) {
+			leader, followers := startForwardingCluster(t, kind)
+
+			resp := postJSON(t, followers[0], "/api/set", map[string]interface{}{"key": "k", "value": "v"}, nil)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("跟随者处理写请求的状态码 = %d, 期望 200", resp.StatusCode)
+			}
+			if got, _ := leader.stateMachine.Get("k"); got != "v" {
+				t.Fatalf("领导者上的键k = %v, 期望 v", got)
+			}
+			if stats := followers[0].forwardStats.snapshot(); stats.Forwarded != 1 || stats.Failed != 0 {
+				t.Errorf("跟随者的转发统计 = %+v", stats)
+			}
+			if received := leader.forwardStats.received.Load(); received != 1 {
+				t.Errorf("领导者收到 %d 个转发的写请求, 期望 1", received)
+			}
+
+			// 会话经跟随者注册，同一序号的请求在转发方故障后经另一个跟随者重试
+			resp = postJSON(t, followers[0], "/api/session", nil, nil)
+			var session struct {
+				SessionID string `json:"sessionId"`
+			}
+			json.NewDecoder(resp.Body).Decode(&session)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || session.SessionID == "" {
+				t.Fatalf("经跟随者注册会话的状态码 = %d", resp.StatusCode)
+			}
+
+			header := http.Header{sessionIDHeader: {session.SessionID}, sessionSeqHeader: {"1"}}
+			body := map[string]interface{}{"key": "counter", "value": 1}
+			first := postJSON(t, followers[0], "/api/set", body, header)
+			first.Body.Close()
+			retry := postJSON(t, followers[1], "/api/set", body, header)
+			retry.Body.Close()
+			if first.StatusCode != http.StatusOK || retry.StatusCode != http.StatusOK {
+				t.Fatalf("会话写请求的状态码 = %d/%d", first.StatusCode, retry.StatusCode)
+			}
+			if first.Header.Get(duplicateHeader) != "" || retry.Header.Get(duplicateHeader) != "true" {
+				t.Errorf("重试的请求应标记为重复: 第一次 %q, 重试 %q", first.Header.Get(duplicateHeader), retry.Header.Get(duplicateHeader))
+			}
+			if deduplicated := leader.forwardStats.deduplicated.Load(); deduplicated != 1 {
+				t.Errorf("领导者按令牌去重 %d 次, 期望 1", deduplicated)
+			}
+
+			// 转发深度为1：跟随者收到转发的写请求时拒绝并返回领导者，不再继续转发
+			data, _ := json.Marshal(statemachine.Command{Type: "SET", Key: "k", Value: "again"})
+			rejected := followers[1].HandleForwardPropose(context.Background(), &raft.ForwardProposeRequest{From: followers[0].config.NodeID, Token: "t", Command: data})
+			if rejected.ErrorCode != "not_leader" || rejected.LeaderID != leader.config.NodeID {
+				t.Errorf("跟随者处理转发请求的响应 = %+v, 期望not_leader并指向 %s", rejected, leader.config.NodeID)
+			}
+		})
+	}
+}
+
+// TestForwardProposeToken 领导者对同一幂等令牌只提议一次，重试返回第一次的结果；管理命令不能转发
+func TestForwardProposeToken(t *testing.T) {
+	s := startSingleNode(t, "node1", "")
+	ctx := context.Background()
+
+	data, _ := json.Marshal(statemachine.Command{Type: "SET", Key: "k", Value: "v"})
+	first := s.HandleForwardPropose(ctx, &raft.ForwardProposeRequest{From: "node2", Token: "request/a", Command: data})
+	retry := s.HandleForwardPropose(ctx, &raft.ForwardProposeRequest{From: "node2", Token: "request/a", Command: data})
+	other := s.HandleForwardPropose(ctx, &raft.ForwardProposeRequest{From: "node2", Token: "request/b", Command: data})
+	if first.ErrorCode != "" || retry.ErrorCode != "" || other.ErrorCode != "" {
+		t.Fatalf("转发的写请求失败: %+v / %+v / %+v", first, retry, other)
+	}
+	if retry.Index != first.Index || other.Index <= first.Index {
+		t.Fatalf("日志索引 = %d/%d/%d, 重试应返回第一次的索引", first.Index, retry.Index, other.Index)
+	}
+
+	_, result, err := decodeForwardResponse(retry)
+	if err != nil || !result.Duplicate {
+		t.Errorf("重试的结果应标记为重复: %+v, %v", result, err)
+	}
+
+	acl, _ := json.Marshal(statemachine.Command{Type: "ACL_DELETE", Key: "token"})
+	resp := s.HandleForwardPropose(ctx, &raft.ForwardProposeRequest{From: "node2", Token: "request/c", Command: acl})
+	if _, _, err := decodeForwardResponse(resp); !errors.Is(err, errInvalidCommand) {
+		t.Errorf("转发管理命令应返回errInvalidCommand，实际: %v", err)
+	}
+}
+
+// TestSubmitCommandTypedErrors 提交超时可用errors.Is判断为kverrors.ErrTimeout，非领导者的错误附带领导者
+func TestSubmitCommandTypedErrors(t *testing.T) {
+	leader, followers := startForwardingCluster(t, transport.KindHTTP)
+	cmd := statemachine.Command{Type: "SET", Key: "k", Value: "v"}
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if _, _, err := leader.submitCommand(ctx, cmd); !errors.Is(err, kverrors.ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("超时的提交应同时匹配ErrTimeout与DeadlineExceeded，实际: %v", err)
+	}
+
+	// 未启用写转发的跟随者直接拒绝，错误中带有领导者的ID与API地址
+	follower := followers[0]
+	follower.config.ForwardWrites = false
+	_, _, err := follower.submitCommand(context.Background(), cmd)
+	var notLeader *kverrors.NotLeaderError
+	if !errors.As(err, &notLeader) || !errors.Is(err, raft.ErrNotLeader) {
+		t.Fatalf("跟随者提交应返回NotLeaderError，实际: %v", err)
+	}
+	if notLeader.LeaderID != string(leader.config.NodeID) || notLeader.LeaderAddr != leader.config.APIAddr {
+		t.Errorf("NotLeaderError = %+v, 期望领导者 %s (%s)", notLeader, leader.config.NodeID, leader.config.APIAddr)
+	}
+}
@@ -9,12 +9,11 @@
 package sharding
 
 import (
-	"crypto/sha256"
-	"encoding/binary"
 	"fmt"
 	"sort"
 	"sync"
 
+	"raftserver/keyhash"
 	"raftserver/raft"
 )
 
@@ -31,7 +30,7 @@ type VirtualNode struct {
 type HashRingConfig struct {
 	VirtualNodesPerNode  int     // 每个物理节点的虚拟节点数量，默认200
 	LoadBalanceThreshold float64 // 负载平衡阈值，默认0.2 (±20%)
-	HashFunction         string  // 哈希函数类型，默认"sha256"
+	HashFunction         string  // 键哈希算法（见keyhash.Parse），默认keyhash.Canonical；"sha256"即keyhash.SHA256Prefix
 }
 
 // DefaultHashRingConfig 默认哈希环配置
@@ -39,7 +38,7 @@ func DefaultHashRingConfig() *HashRingConfig {
 	return &HashRingConfig{
 		VirtualNodesPerNode:  200,
 		LoadBalanceThreshold: 0.2,
-		HashFunction:         "sha256",
+		HashFunction:         keyhash.Canonical.Name,
 	}
 }
 
@@ -51,6 +50,7 @@ type ConsistentHashRing struct {
 	physicalNodes map[raft.NodeID]*PhysicalNode // 物理节点映射
 	totalWeight   float64                       // 总权重
 	stats         *HashRingStats                // 统计信息
+	keyHash       keyhash.Algorithm             // 由HashFunction解析的键哈希算法
 }
 
 // PhysicalNode 物理节点信息
@@ -118,9 +118,22 @@ func NewConsistentHashRing(config *HashRingConfig) *ConsistentHashRing {
 		virtualNodes:  make([]VirtualNode, 0),
 		physicalNodes: make(map[raft.NodeID]*PhysicalNode),
 		stats:         &HashRingStats{},
+		keyHash:       parseHashFunction(config.HashFunction),
 	}
 }
 
+// parseHashFunction 解析哈希函数配置，无法识别时使用keyhash.Canonical
+func parseHashFunction(name string) keyhash.Algorithm {
+	if name == "sha256" {
+		return keyhash.SHA256Prefix
+	}
+	algorithm, err := keyhash.Parse(name)
+	if err != nil {
+		return keyhash.Canonical
+	}
+	return algorithm
+}
+
 // AddNode 添加物理节点到哈希环
 func (h *ConsistentHashRing) AddNode(nodeID raft.NodeID, address string, weight float64) error {
 	h.mu.Lock()
@@ -405,22 +418,9 @@ func (h *ConsistentHashRing) sortVirtualNodes() {
 	})
 }
 
-// hash 计算字符串的哈希值
+// hash 计算字符串的哈希值，与服务端分片和客户端路由使用同一套键哈希算法
 func (h *ConsistentHashRing) hash(key string) uint64 {
-	switch h.config.HashFunction {
-	case "sha256":
-		return h.sha256Hash(key)
-	default:
-		return h.sha256Hash(key)
-	}
-}
-
-// sha256Hash 使用SHA256计算哈希值
-func (h *ConsistentHashRing) sha256Hash(key string) uint64 {
-	hasher := sha256.New()
-	hasher.Write([]byte(key))
-	hash := hasher.Sum(nil)
-	return binary.BigEndian.Uint64(hash[:8])
+	return h.keyHash.Sum64(key)
 }
 
 // updateStats 更新统计信息
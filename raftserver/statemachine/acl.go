@@ -0,0 +1,224 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - acl.go
+ */
+package statemachine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ACLAccess 键前缀上的访问权限
+type ACLAccess string
+
+const (
+	// ACLRead 只读
+	ACLRead ACLAccess = "r"
+	// ACLWrite 只写
+	ACLWrite ACLAccess = "w"
+	// ACLReadWrite 读写
+	ACLReadWrite ACLAccess = "rw"
+)
+
+// ParseACLAccess 解析访问权限
+func ParseACLAccess(s string) (ACLAccess, error) {
+	switch ACLAccess(s) {
+	case ACLRead, ACLWrite, ACLReadWrite:
+		return ACLAccess(s), nil
+	case "wr":
+		return ACLReadWrite, nil
+	default:
+		return "", fmt.Errorf("不支持的访问权限: %s（可选 r、w、rw）", s)
+	}
+}
+
+// allows 判断权限是否包含所需的访问类型
+func (a ACLAccess) allows(need ACLAccess) bool {
+	return a == ACLReadWrite || a == need
+}
+
+// ACLRule 访问规则：以"*"结尾的模式按前缀匹配，否则精确匹配键
+type ACLRule struct {
+	Pattern string    `json:"pattern"`
+	Access  ACLAccess `json:"access"`
+}
+
+// matches 判断规则是否覆盖键
+func (r ACLRule) matches(key string) bool {
+	if strings.HasSuffix(r.Pattern, "*") {
+		return strings.HasPrefix(key, strings.TrimSuffix(r.Pattern, "*"))
+	}
+	return key == r.Pattern
+}
+
+// overlaps 判断规则与以prefix开头的键集合是否有交集
+func (r ACLRule) overlaps(prefix string) bool {
+	if strings.HasSuffix(r.Pattern, "*") {
+		rulePrefix := strings.TrimSuffix(r.Pattern, "*")
+		return strings.HasPrefix(prefix, rulePrefix) || strings.HasPrefix(rulePrefix, prefix)
+	}
+	return strings.HasPrefix(r.Pattern, prefix)
+}
+
+// ACLToken 访问令牌，只保存令牌的SHA-256摘要
+type ACLToken struct {
+	Name      string    `json:"name"`
+	TokenHash string    `json:"tokenHash"`
+	Admin     bool      `json:"admin,omitempty"` // 管理员可访问所有键并管理令牌
+	Rules     []ACLRule `json:"rules,omitempty"`
+}
+
+// HashACLToken 计算令牌摘要
+func HashACLToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Allows 判断令牌是否拥有对键的指定访问权限
+func (t *ACLToken) Allows(key string, need ACLAccess) bool {
+	if t.Admin {
+		return true
+	}
+	for _, rule := range t.Rules {
+		if rule.Access.allows(need) && rule.matches(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// MayRead 判断令牌是否可能读取以prefix开头的某些键，用于决定前缀扫描是被拒绝还是过滤结果
+func (t *ACLToken) MayRead(prefix string) bool {
+	if t.Admin {
+		return true
+	}
+	for _, rule := range t.Rules {
+		if rule.Access.allows(ACLRead) && rule.overlaps(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers 判断令牌是否对以prefix开头的所有键都拥有指定访问权限，用于范围删除与计数这类不逐键过滤的操作
+func (t *ACLToken) Covers(prefix string, need ACLAccess) bool {
+	if t.Admin {
+		return true
+	}
+	for _, rule := range t.Rules {
+		if rule.Access.allows(need) && strings.HasSuffix(rule.Pattern, "*") &&
+			strings.HasPrefix(prefix, strings.TrimSuffix(rule.Pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate 校验令牌定义
+func (t *ACLToken) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("令牌名称不能为空")
+	}
+	if len(t.TokenHash) != sha256.Size*2 {
+		return fmt.Errorf("令牌 %s 的摘要无效", t.Name)
+	}
+	for _, rule := range t.Rules {
+		if rule.Pattern == "" {
+			return fmt.Errorf("令牌 %s 的规则模式不能为空", t.Name)
+		}
+		if _, err := ParseACLAccess(string(rule.Access)); err != nil {
+			return fmt.Errorf("令牌 %s: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// clone 深拷贝令牌
+func (t *ACLToken) clone() *ACLToken {
+	c := *t
+	c.Rules = append([]ACLRule(nil), t.Rules...)
+	return &c
+}
+
+// applyACLSet 创建或更新令牌（调用方需持有写锁）
+func (sm *KVStateMachine) applyACLSet(token *ACLToken) error {
+	if token == nil {
+		return fmt.Errorf("ACL_SET命令缺少令牌")
+	}
+	if err := token.Validate(); err != nil {
+		return err
+	}
+
+	if other, exists := sm.aclByHash[token.TokenHash]; exists && other.Name != token.Name {
+		return fmt.Errorf("令牌 %s 与 %s 使用了相同的密钥", token.Name, other.Name)
+	}
+	if old, exists := sm.acl[token.Name]; exists {
+		delete(sm.aclByHash, old.TokenHash)
+	}
+
+	stored := token.clone()
+	sm.acl[stored.Name] = stored
+	sm.aclByHash[stored.TokenHash] = stored
+	return nil
+}
+
+// applyACLDelete 删除令牌（调用方需持有写锁）
+func (sm *KVStateMachine) applyACLDelete(name string) {
+	if old, exists := sm.acl[name]; exists {
+		delete(sm.aclByHash, old.TokenHash)
+		delete(sm.acl, name)
+	}
+}
+
+// LookupACLToken 按令牌摘要查找通过Raft写入的令牌
+func (sm *KVStateMachine) LookupACLToken(tokenHash string) (*ACLToken, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	token, exists := sm.aclByHash[tokenHash]
+	if !exists {
+		return nil, false
+	}
+	return token.clone(), true
+}
+
+// ACLTokens 获取所有通过Raft写入的令牌，按名称排序
+func (sm *KVStateMachine) ACLTokens() []*ACLToken {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	tokens := make([]*ACLToken, 0, len(sm.acl))
+	for _, token := range sm.acl {
+		tokens = append(tokens, token.clone())
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Name < tokens[j].Name })
+	return tokens
+}
+
+// CreateACLSetCommand 创建写入令牌的命令
+func CreateACLSetCommand(token *ACLToken) ([]byte, error) {
+	cmd := Command{
+		Type:     "ACL_SET",
+		ACLToken: token,
+	}
+
+	return json.Marshal(cmd)
+}
+
+// CreateACLDeleteCommand 创建删除令牌的命令
+func CreateACLDeleteCommand(name string) ([]byte, error) {
+	cmd := Command{
+		Type: "ACL_DELETE",
+		Key:  name,
+	}
+
+	return json.Marshal(cmd)
+}
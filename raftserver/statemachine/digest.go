@@ -0,0 +1,128 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-20 15:41:09
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-20 15:41:09
+* @Description: ConcordKV Raft consensus server - digest.go
+ */
+package statemachine
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+
+	"raftserver/raft"
+)
+
+// 状态摘要的桶数
+const (
+	DefaultDigestBuckets = 256
+	MaxDigestBuckets     = 65536
+)
+
+// StateDigest 状态机数据的分层摘要，用于跨数据中心比较已应用的状态
+// 键按哈希分到固定数量的桶中：先比较各桶的哈希与键数，只对不一致的桶列出其中各键的版本与哈希
+type StateDigest struct {
+	AppliedIndex raft.LogIndex  `json:"appliedIndex"` // 计算摘要时已应用的最后一个日志索引
+	Buckets      []BucketDigest `json:"buckets,omitempty"`
+	Keys         []KeyDigest    `json:"keys,omitempty"`
+}
+
+// BucketDigest 一个桶的摘要，Hash为桶中各键哈希之和，与键的遍历顺序无关
+type BucketDigest struct {
+	Hash  uint64 `json:"hash"`
+	Count int    `json:"count"`
+}
+
+// KeyDigest 单个键的摘要，Hash覆盖键、值与过期时间，不包括版本
+type KeyDigest struct {
+	Key     string `json:"key"`
+	Bucket  int    `json:"bucket"`
+	Version uint64 `json:"version"` // 最后一次修改该键的日志索引
+	Hash    uint64 `json:"hash"`
+}
+
+// DigestBucket 键在buckets个桶中所属的桶
+func DigestBucket(key string, buckets int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(buckets))
+}
+
+// normalizeBuckets 桶数不在[1, MaxDigestBuckets]范围内时使用默认值
+func normalizeBuckets(buckets int) int {
+	if buckets <= 0 || buckets > MaxDigestBuckets {
+		return DefaultDigestBuckets
+	}
+	return buckets
+}
+
+// StateDigest 计算各桶的摘要，遍历全部键，调用方应限制调用频率
+func (sm *KVStateMachine) StateDigest(buckets int) *StateDigest {
+	buckets = normalizeBuckets(buckets)
+
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	digest := &StateDigest{
+		AppliedIndex: sm.appliedIndex,
+		Buckets:      make([]BucketDigest, buckets),
+	}
+	for key, value := range sm.data {
+		bucket := &digest.Buckets[DigestBucket(key, buckets)]
+		bucket.Hash += sm.keyHash(key, value)
+		bucket.Count++
+	}
+	return digest
+}
+
+// BucketKeys 列出选中的桶中各键的摘要，按键排序；超出范围的桶被忽略
+func (sm *KVStateMachine) BucketKeys(buckets int, selected []int) *StateDigest {
+	buckets = normalizeBuckets(buckets)
+	wanted := make(map[int]bool, len(selected))
+	for _, bucket := range selected {
+		wanted[bucket] = true
+	}
+
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	digest := &StateDigest{AppliedIndex: sm.appliedIndex, Keys: make([]KeyDigest, 0)}
+	for key, value := range sm.data {
+		bucket := DigestBucket(key, buckets)
+		if !wanted[bucket] {
+			continue
+		}
+		digest.Keys = append(digest.Keys, KeyDigest{
+			Key:     key,
+			Bucket:  bucket,
+			Version: sm.versions[key],
+			Hash:    sm.keyHash(key, value),
+		})
+	}
+	sort.Slice(digest.Keys, func(i, j int) bool { return digest.Keys[i].Key < digest.Keys[j].Key })
+	return digest
+}
+
+// keyHash 键、值的JSON编码与过期时间的FNV-1a哈希（调用方需持有读锁）
+func (sm *KVStateMachine) keyHash(key string, value interface{}) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	// 值来自JSON解码，重新编码的结果是确定的（映射按键排序）
+	encoded, _ := json.Marshal(value)
+	h.Write(encoded)
+	// 只有写入时指定了元数据的键才计入元数据，未使用该特性的副本摘要不变
+	if meta := sm.meta[key]; meta != nil {
+		h.Write([]byte{0})
+		h.Write([]byte(meta.ContentType))
+		h.Write([]byte{0})
+		h.Write([]byte(meta.Encoding))
+	}
+	var expires [8]byte
+	binary.BigEndian.PutUint64(expires[:], uint64(sm.expires[key]))
+	h.Write(expires[:])
+	return h.Sum64()
+}
@@ -0,0 +1,141 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-31 14:08:37
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-31 14:08:37
+* @Description: ConcordKV Raft consensus server - encoding.go
+ */
+package statemachine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// EncodingGzip 值的JSON编码经gzip压缩后以base64字符串存储
+const EncodingGzip = "gzip"
+
+// MaxContentTypeLength 内容类型的最大长度
+const MaxContentTypeLength = 255
+
+// ErrInvalidValueMeta 值的元数据无效
+var ErrInvalidValueMeta = errors.New("无效的值元数据")
+
+// ValueMeta 值的元数据，随SET命令写入日志，与值一起保存在状态机与快照中
+// 压缩由收到写请求的节点在提议之前完成，日志条目、快照与备份中都是压缩后的值
+type ValueMeta struct {
+	ContentType string `json:"contentType,omitempty"` // 写入方声明的内容类型，原样保存并在读取时返回
+	Encoding    string `json:"encoding,omitempty"`    // 存储编码，为空表示原值
+}
+
+// Validate 校验元数据
+func (m *ValueMeta) Validate() error {
+	if len(m.ContentType) > MaxContentTypeLength {
+		return fmt.Errorf("%w: contentType超过%d字节", ErrInvalidValueMeta, MaxContentTypeLength)
+	}
+	if m.Encoding != "" && m.Encoding != EncodingGzip {
+		return fmt.Errorf("%w: 不支持的编码 %q", ErrInvalidValueMeta, m.Encoding)
+	}
+	return nil
+}
+
+// Compressed 值是否压缩存储
+func (m *ValueMeta) Compressed() bool {
+	return m != nil && m.Encoding == EncodingGzip
+}
+
+// ContentTypeOf 元数据中的内容类型，m为nil时为空
+func (m *ValueMeta) ContentTypeOf() string {
+	if m == nil {
+		return ""
+	}
+	return m.ContentType
+}
+
+// CompressValue 把值的JSON编码以gzip压缩为base64字符串，同时返回JSON编码的长度
+// 压缩后不比JSON编码短时ok为false，调用方应存储原值
+func CompressValue(value interface{}) (compressed string, size int, ok bool, err error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("编码值失败: %w", err)
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(encoded); err != nil {
+		return "", 0, false, fmt.Errorf("压缩值失败: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return "", 0, false, fmt.Errorf("压缩值失败: %w", err)
+	}
+
+	compressed = base64.StdEncoding.EncodeToString(buf.Bytes())
+	return compressed, len(encoded), len(compressed) < len(encoded), nil
+}
+
+// DecodeValue 按元数据还原存储的值，未压缩的值原样返回
+func DecodeValue(stored interface{}, meta *ValueMeta) (interface{}, error) {
+	if !meta.Compressed() {
+		return stored, nil
+	}
+
+	text, ok := stored.(string)
+	if !ok {
+		return nil, fmt.Errorf("压缩的值应为字符串，实际为 %T", stored)
+	}
+	raw, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("解码压缩的值失败: %w", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("解压值失败: %w", err)
+	}
+	defer zr.Close()
+	encoded, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("解压值失败: %w", err)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(encoded, &value); err != nil {
+		return nil, fmt.Errorf("解析解压后的值失败: %w", err)
+	}
+	return value, nil
+}
+
+// valueLocked 键的值（压缩存储的值解压后返回）与元数据（调用方需持有锁）
+func (sm *KVStateMachine) valueLocked(key string) (interface{}, *ValueMeta, bool, error) {
+	stored, exists := sm.data[key]
+	if !exists {
+		return nil, nil, false, nil
+	}
+	meta := sm.meta[key]
+	value, err := DecodeValue(stored, meta)
+	if err != nil {
+		return nil, meta, true, fmt.Errorf("键 %q: %w", key, err)
+	}
+	return value, meta, true, nil
+}
+
+// GetWithMeta 获取键值与元数据，压缩存储的值解压后返回；元数据为nil表示写入时未指定
+func (sm *KVStateMachine) GetWithMeta(key string) (interface{}, *ValueMeta, bool, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	if sm.isExpired(key, time.Now().UnixMilli()) {
+		return nil, nil, false, nil
+	}
+	value, meta, exists, err := sm.valueLocked(key)
+	if meta != nil {
+		copied := *meta
+		meta = &copied
+	}
+	return value, meta, exists, err
+}
@@ -0,0 +1,121 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-8-4 10:12:40
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-8-4 10:12:40
+* @Description: ConcordKV Raft consensus server - key_index.go
+ */
+package statemachine
+
+import "sort"
+
+// keyIndexBlockSize 有序键索引中每个块的最大键数，超过时对半拆分
+const keyIndexBlockSize = 512
+
+// keyIndex 按字典序排列的键集合，由若干有序块组成，块之间首尾有序
+// 插入与删除只移动一个块内的元素（O(log n + 块大小)），应用日志时增量维护，扫描与分页不需要重建或排序
+type keyIndex struct {
+	blocks [][]string
+	size   int
+}
+
+// newKeyIndex 由无序的键集合创建索引
+func newKeyIndex(keys []string) *keyIndex {
+	sort.Strings(keys)
+	x := &keyIndex{size: len(keys)}
+	for len(keys) > 0 {
+		n := keyIndexBlockSize / 2
+		if n > len(keys) {
+			n = len(keys)
+		}
+		x.blocks = append(x.blocks, append(make([]string, 0, keyIndexBlockSize), keys[:n]...))
+		keys = keys[n:]
+	}
+	return x
+}
+
+// Len 索引中的键数
+func (x *keyIndex) Len() int {
+	return x.size
+}
+
+// seek 第一个不小于key的键所在的位置；所有键都小于key时返回(len(blocks), 0)
+func (x *keyIndex) seek(key string) (int, int) {
+	b := sort.Search(len(x.blocks), func(i int) bool {
+		block := x.blocks[i]
+		return block[len(block)-1] >= key
+	})
+	if b == len(x.blocks) {
+		return b, 0
+	}
+	return b, sort.SearchStrings(x.blocks[b], key)
+}
+
+// insert 加入键，已存在时返回false
+func (x *keyIndex) insert(key string) bool {
+	if len(x.blocks) == 0 {
+		x.blocks = append(x.blocks, append(make([]string, 0, keyIndexBlockSize), key))
+		x.size++
+		return true
+	}
+
+	b, i := x.seek(key)
+	if b == len(x.blocks) {
+		// 大于所有键，追加到最后一个块
+		b = len(x.blocks) - 1
+		i = len(x.blocks[b])
+	} else if x.blocks[b][i] == key {
+		return false
+	}
+
+	block := append(x.blocks[b], "")
+	copy(block[i+1:], block[i:])
+	block[i] = key
+	x.blocks[b] = block
+	x.size++
+
+	if len(block) > keyIndexBlockSize {
+		half := len(block) / 2
+		right := append(make([]string, 0, keyIndexBlockSize), block[half:]...)
+		clear(block[half:])
+		x.blocks[b] = block[:half]
+		x.blocks = append(x.blocks, nil)
+		copy(x.blocks[b+2:], x.blocks[b+1:])
+		x.blocks[b+1] = right
+	}
+	return true
+}
+
+// remove 移除键，不存在时返回false
+func (x *keyIndex) remove(key string) bool {
+	b, i := x.seek(key)
+	if b == len(x.blocks) || x.blocks[b][i] != key {
+		return false
+	}
+
+	block := x.blocks[b]
+	copy(block[i:], block[i+1:])
+	block[len(block)-1] = ""
+	block = block[:len(block)-1]
+	x.size--
+
+	if len(block) == 0 {
+		x.blocks = append(x.blocks[:b], x.blocks[b+1:]...)
+	} else {
+		x.blocks[b] = block
+	}
+	return true
+}
+
+// ascend 从第一个不小于from的键开始按字典序遍历，fn返回false时停止
+// 遍历期间不能修改索引
+func (x *keyIndex) ascend(from string, fn func(key string) bool) {
+	b, i := x.seek(from)
+	for ; b < len(x.blocks); b, i = b+1, 0 {
+		for _, key := range x.blocks[b][i:] {
+			if !fn(key) {
+				return
+			}
+		}
+	}
+}
@@ -8,37 +8,212 @@
 package statemachine
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"strings"
 	"sync"
+	"time"
 
+	"raftserver/keyhash"
 	"raftserver/raft"
 )
 
 // Command 命令类型
 type Command struct {
-	Type  string      `json:"type"`  // 命令类型: SET, GET, DELETE
-	Key   string      `json:"key"`   // 键
-	Value interface{} `json:"value"` // 值
+	Type       string      `json:"type"`                 // 命令类型: SET, GET, DELETE, DELETE_RANGE, BATCH, EXPIRE, CAS, TXN, ACL_SET, ACL_DELETE, QUOTA_SET, QUOTA_DELETE, REPLICATE, SESSION_*, SHARD_*
+	Key        string      `json:"key"`                  // 键
+	Value      interface{} `json:"value"`                // 值
+	Meta       *ValueMeta  `json:"meta,omitempty"`       // 值的元数据（SET与TXN中的SET操作），压缩时Value为压缩后的字符串
+	TTLSeconds int64       `json:"ttlSeconds,omitempty"` // 过期时间（秒），0表示永不过期
+	Ops        []Command   `json:"ops,omitempty"`        // 批量操作（BATCH命令）或条件成立时执行的操作（TXN命令）
+	Keys       []string    `json:"keys,omitempty"`       // 待清理的过期键（仅EXPIRE命令使用）
+	RequestID  string      `json:"requestId,omitempty"`  // 请求ID，用于向等待方回传应用结果
+
+	// CAS条件：指定ExpectedVersion时按版本比较，否则按值比较（nil表示期望键不存在）
+	Expected        interface{} `json:"expected,omitempty"`
+	ExpectedVersion *uint64     `json:"expectedVersion,omitempty"`
+
+	// TXN的比较条件与条件不成立时执行的操作，条件成立时执行Ops
+	Compares []TxnCompare `json:"compares,omitempty"`
+	Else     []Command    `json:"else,omitempty"`
+
+	// DELETE_RANGE删除的键范围与本条目最多删除的键数（不超过MaxDeleteRangeKeys）
+	Range *KeyRange `json:"range,omitempty"`
+	Limit int       `json:"limit,omitempty"`
+
+	// ACL_SET写入的令牌，ACL_DELETE使用Key作为令牌名称
+	ACLToken *ACLToken `json:"aclToken,omitempty"`
+
+	// QUOTA_SET写入的配额，QUOTA_DELETE使用Key作为前缀
+	Quota *Quota `json:"quota,omitempty"`
+
+	// REPLICATE应用的其他DC的日志条目
+	Replicated *ReplicatedBatch `json:"replicated,omitempty"`
+
+	// 分片表的拆分、合并与激活参数（仅SHARD_*命令使用）
+	ShardOp *ShardOp `json:"shardOp,omitempty"`
+
+	// 客户端会话：SeqNum非零的命令按(SessionID, SeqNum)去重，重复的命令返回缓存的结果
+	SessionID        string   `json:"sessionId,omitempty"`
+	SeqNum           uint64   `json:"seqNum,omitempty"`
+	AckSeq           uint64   `json:"ackSeq,omitempty"`           // 客户端已收到响应的序号，不大于它的缓存响应可以丢弃
+	SessionTimeoutMs int64    `json:"sessionTimeoutMs,omitempty"` // 会话空闲超时（仅SESSION_REGISTER命令使用）
+	Sessions         []string `json:"sessions,omitempty"`         // 待清理的空闲会话（仅SESSION_EXPIRE命令使用）
+}
+
+// CommandResult 命令在状态机中的应用结果
+type CommandResult struct {
+	Swapped bool        `json:"swapped"` // CAS是否成功
+	Exists  bool        `json:"exists"`  // 应用后键是否存在
+	Value   interface{} `json:"value"`   // 应用后的当前值
+	Version uint64      `json:"version"` // 应用后的当前版本
+	Err     error       `json:"-"`       // 应用错误
+
+	// Duplicate 命令是会话中已应用过的重复请求，结果取自缓存
+	Duplicate bool `json:"duplicate,omitempty"`
+
+	// TXN的比较条件是否全部成立及所执行分支中各操作的结果
+	Succeeded bool          `json:"succeeded,omitempty"`
+	Responses []TxnOpResult `json:"responses,omitempty"`
+
+	// DELETE_RANGE删除的键数与范围内剩余的第一个键，NextKey为空表示范围内的键已全部删除
+	Deleted int    `json:"deleted,omitempty"`
+	NextKey string `json:"nextKey,omitempty"`
 }
 
 // KVStateMachine 键值存储状态机
 type KVStateMachine struct {
 	mu   sync.RWMutex
 	data map[string]interface{}
+
+	// 键的过期时间（Unix毫秒），由领导者分配的日志时间戳计算，保证各副本一致
+	expires map[string]int64
+
+	// 键的版本，取最后一次修改该键的日志索引
+	versions map[string]uint64
+
+	// 写入时指定了内容类型或压缩存储的键的元数据
+	meta map[string]*ValueMeta
+
+	// 已应用的最后一个日志索引，随状态摘要返回，用于区分复制延迟与状态分歧
+	appliedIndex raft.LogIndex
+
+	// 等待命令应用结果的请求；abandoned统计请求的上下文结束时仍未收到结果而被移除的等待
+	waitMu    sync.Mutex
+	waiters   map[string]*commandWaiter
+	abandoned uint64
+
+	// 有序键索引，用于前缀扫描与范围删除；写入新键与删除键时增量维护
+	keys *keyIndex
+
+	// 通过Raft写入的访问令牌，按名称与令牌摘要索引
+	acl       map[string]*ACLToken
+	aclByHash map[string]*ACLToken
+
+	// 按键前缀的配额及其用量，按前缀索引
+	quotas map[string]*quotaState
+
+	// 从其他DC复制过来的条目的高水位，按源DC索引
+	replication map[raft.DataCenterID]*ReplicationMark
+
+	// 客户端会话，按会话ID索引
+	sessions map[string]*clientSession
+
+	// 分片表：为空时只有覆盖整个哈希环的初始分片；shardSeq用于分配新分片的ID，shardIndex为最后一次修改的日志索引
+	shards     map[string]*ShardRecord
+	shardSeq   uint64
+	shardIndex uint64
+
+	// 键哈希算法，分片范围定义在它的哈希空间上；由配置决定，不随日志或快照复制
+	shardHash keyhash.Algorithm
+
+	// 变更事件监听器，应用日志时收集本条目产生的事件，释放锁后一次性通知
+	listener ChangeListener
+	changes  []ChangeEvent
+}
+
+// ChangeEvent 键的变更事件
+// Value为存储的值，Encoding为gzip时是压缩后的字符串，可用DecodeValue还原
+type ChangeEvent struct {
+	Type        string        `json:"type"` // put或delete
+	Key         string        `json:"key"`
+	Value       interface{}   `json:"value,omitempty"`
+	ContentType string        `json:"contentType,omitempty"`
+	Encoding    string        `json:"encoding,omitempty"`
+	Index       raft.LogIndex `json:"raftIndex"`
+}
+
+// ChangeListener 接收状态机的变更，在应用日志的协程中同步调用，实现不能阻塞
+type ChangeListener interface {
+	// OnChanges 一个日志条目应用完成后产生的变更事件
+	OnChanges(events []ChangeEvent)
+	// OnRestore 状态机从快照恢复，之前的事件序列不再连续
+	OnRestore()
+}
+
+// ScanEntry 扫描结果中的键值对，Value为存储的值，压缩存储时由DecodeValue按Meta还原
+type ScanEntry struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value,omitempty"`
+	Meta  *ValueMeta  `json:"-"`
 }
 
 // NewKVStateMachine 创建新的键值存储状态机
 func NewKVStateMachine() *KVStateMachine {
 	return &KVStateMachine{
-		data: make(map[string]interface{}),
+		data:        make(map[string]interface{}),
+		expires:     make(map[string]int64),
+		versions:    make(map[string]uint64),
+		meta:        make(map[string]*ValueMeta),
+		keys:        newKeyIndex(nil),
+		waiters:     make(map[string]*commandWaiter),
+		acl:         make(map[string]*ACLToken),
+		aclByHash:   make(map[string]*ACLToken),
+		quotas:      make(map[string]*quotaState),
+		replication: make(map[raft.DataCenterID]*ReplicationMark),
+		sessions:    make(map[string]*clientSession),
+		shards:      make(map[string]*ShardRecord),
+		shardHash:   keyhash.Canonical,
 	}
 }
 
+// SetChangeListener 设置变更事件监听器，需在开始应用日志之前调用
+func (sm *KVStateMachine) SetChangeListener(listener ChangeListener) {
+	sm.listener = listener
+}
+
+// kvSnapshot 快照格式，同时保存数据与剩余的过期时间
+type kvSnapshot struct {
+	Version  int                    `json:"version"`
+	Data     map[string]interface{} `json:"data"`
+	Expires  map[string]int64       `json:"expires,omitempty"`
+	Versions map[string]uint64      `json:"versions,omitempty"`
+	Meta     map[string]*ValueMeta  `json:"meta,omitempty"`
+	ACL      map[string]*ACLToken   `json:"acl,omitempty"`
+	Quotas   []Quota                `json:"quotas,omitempty"`
+	Sessions []*clientSession       `json:"sessions,omitempty"`
+
+	Replication []ReplicationMark `json:"replication,omitempty"`
+
+	Shards     []ShardRecord `json:"shards,omitempty"`
+	ShardSeq   uint64        `json:"shardSeq,omitempty"`
+	ShardIndex uint64        `json:"shardIndex,omitempty"`
+
+	AppliedIndex raft.LogIndex `json:"appliedIndex,omitempty"`
+}
+
+// kvSnapshotVersion 当前快照格式版本
+const kvSnapshotVersion = 1
+
 // Apply 应用日志条目到状态机
 func (sm *KVStateMachine) Apply(entry *raft.LogEntry) error {
 	if entry.Type != raft.EntryNormal {
 		// 跳过非普通条目
+		sm.mu.Lock()
+		sm.appliedIndex = entry.Index
+		sm.mu.Unlock()
 		return nil
 	}
 
@@ -48,13 +223,123 @@ func (sm *KVStateMachine) Apply(entry *raft.LogEntry) error {
 	}
 
 	sm.mu.Lock()
-	defer sm.mu.Unlock()
+	sm.appliedIndex = entry.Index
+	var result *CommandResult
+
+	// 会话中重复的命令直接返回首次应用时的结果
+	cached, session, err := sm.beginSessionCommand(&cmd, entry)
+	switch {
+	case err != nil:
+	case cached != nil:
+		result, err = cached.restore()
+	default:
+		result, err = sm.applyTopLevel(&cmd, entry)
+		if session != nil {
+			session.record(cmd.SeqNum, result, err)
+		}
+	}
+	changes := sm.changes
+	sm.changes = nil
+	sm.mu.Unlock()
+
+	if len(changes) > 0 {
+		for i := range changes {
+			changes[i].Index = entry.Index
+		}
+		sm.listener.OnChanges(changes)
+	}
+
+	if cmd.RequestID != "" {
+		sm.notifyResult(cmd.RequestID, result, err)
+	}
+
+	// 命令被拒绝是确定性的，所有副本得到相同的结果：错误只回传给等待方，不能阻塞后续日志的应用
+	return nil
+}
+
+// applyTopLevel 应用日志条目中的顶层命令（调用方需持有写锁）
+func (sm *KVStateMachine) applyTopLevel(cmd *Command, entry *raft.LogEntry) (*CommandResult, error) {
+	switch cmd.Type {
+	case "BATCH":
+		// 批量命令在同一把锁内按顺序应用，保证原子可见；超出配额时整批拒绝
+		if err := sm.checkQuota(cmd.Ops); err != nil {
+			return nil, err
+		}
+		for i := range cmd.Ops {
+			if err := sm.applyCommand(&cmd.Ops[i], entry); err != nil {
+				return nil, fmt.Errorf("应用批量操作 %d 失败: %w", i, err)
+			}
+		}
+		return nil, nil
+	case "SET":
+		// 批量命令中的SET随整批检查配额，这里只检查单独的SET
+		if err := sm.checkQuota([]Command{*cmd}); err != nil {
+			return nil, err
+		}
+		return nil, sm.applyCommand(cmd, entry)
+	case "CAS":
+		return sm.applyCAS(cmd, entry)
+	case "TXN":
+		// 比较条件与所选分支在同一把锁内求值并应用
+		return sm.applyTxn(cmd, entry)
+	case "DELETE_RANGE":
+		return sm.applyDeleteRange(cmd, entry)
+	case "REPLICATE":
+		// 其他DC的条目逐个经过本函数应用，不计入本集群的会话
+		return sm.applyReplicate(cmd, entry)
+	case "SHARD_SPLIT", "SHARD_MERGE", "SHARD_ACTIVATE":
+		// 结果中返回被创建或修改的分片
+		shards, err := sm.applyShardOp(cmd, entry)
+		if err != nil {
+			return nil, err
+		}
+		return &CommandResult{Value: shards}, nil
+	default:
+		return nil, sm.applyCommand(cmd, entry)
+	}
+}
+
+// applyCommand 应用单条命令（调用方需持有写锁）
+// 所有与时间相关的决策都基于领导者写入日志条目时分配的时间戳
+func (sm *KVStateMachine) applyCommand(cmd *Command, entry *raft.LogEntry) error {
+	ts := entry.Timestamp
 
 	switch cmd.Type {
 	case "SET":
-		sm.data[cmd.Key] = cmd.Value
+		if cmd.Meta != nil {
+			if err := cmd.Meta.Validate(); err != nil {
+				return err
+			}
+		}
+		sm.setKey(cmd.Key, cmd.Value, cmd.Meta, cmd.TTLSeconds, entry)
 	case "DELETE":
-		delete(sm.data, cmd.Key)
+		sm.deleteKey(cmd.Key)
+	case "EXPIRE":
+		// 仅删除在该日志时间戳时确实已过期的键，避免误删期间被重新设置的键
+		for _, key := range cmd.Keys {
+			if expireAt, ok := sm.expires[key]; ok && expireAt <= ts.UnixMilli() {
+				sm.deleteKey(key)
+			}
+		}
+	case "ACL_SET":
+		return sm.applyACLSet(cmd.ACLToken)
+	case "ACL_DELETE":
+		sm.applyACLDelete(cmd.Key)
+	case "QUOTA_SET":
+		return sm.applyQuotaSet(cmd.Quota)
+	case "QUOTA_DELETE":
+		delete(sm.quotas, cmd.Key)
+	case "SESSION_REGISTER":
+		return sm.applySessionRegister(cmd, entry)
+	case "SESSION_KEEPALIVE":
+		return sm.applySessionKeepAlive(cmd, entry)
+	case "SESSION_CLOSE":
+		delete(sm.sessions, cmd.SessionID)
+	case "SESSION_EXPIRE":
+		sm.applySessionExpire(cmd, entry)
+	case "SHARD_SPLIT", "SHARD_MERGE", "SHARD_ACTIVATE":
+		_, err := sm.applyShardOp(cmd, entry)
+		return err
 	case "GET":
 		// GET命令不修改状态，通常用于只读操作
 		// 在实际实现中，可以考虑不将GET命令加入日志
@@ -65,15 +350,208 @@ func (sm *KVStateMachine) Apply(entry *raft.LogEntry) error {
 	return nil
 }
 
+// applyCAS 应用CAS命令，条件满足时写入新值，写入会超出配额时拒绝命令（调用方需持有写锁）
+func (sm *KVStateMachine) applyCAS(cmd *Command, entry *raft.LogEntry) (*CommandResult, error) {
+	// 按日志时间戳判断过期，过期键视为不存在
+	if sm.isExpired(cmd.Key, entry.Timestamp.UnixMilli()) {
+		sm.deleteKey(cmd.Key)
+	}
+
+	// 压缩存储的值解压后比较，无法解压的值视为不相等
+	current, _, exists, decodeErr := sm.valueLocked(cmd.Key)
+	version := sm.versions[cmd.Key]
+
+	var match bool
+	if cmd.ExpectedVersion != nil {
+		match = *cmd.ExpectedVersion == version
+	} else if cmd.Expected == nil {
+		match = !exists
+	} else {
+		match = exists && decodeErr == nil && reflect.DeepEqual(current, cmd.Expected)
+	}
+
+	if match {
+		if err := sm.checkQuota([]Command{{Type: "SET", Key: cmd.Key, Value: cmd.Value}}); err != nil {
+			return nil, err
+		}
+		sm.setKey(cmd.Key, cmd.Value, nil, cmd.TTLSeconds, entry)
+		current, exists, version = cmd.Value, true, sm.versions[cmd.Key]
+	}
+
+	return &CommandResult{
+		Swapped: match,
+		Exists:  exists,
+		Value:   current,
+		Version: version,
+	}, nil
+}
+
+// setKey 写入键值并更新过期时间、版本与元数据，meta为nil时清除之前的元数据（调用方需持有写锁）
+func (sm *KVStateMachine) setKey(key string, value interface{}, meta *ValueMeta, ttlSeconds int64, entry *raft.LogEntry) {
+	sm.trackWrite(key, value)
+	if _, exists := sm.data[key]; !exists {
+		sm.keys.insert(key)
+	}
+	sm.data[key] = value
+	sm.versions[key] = uint64(entry.Index)
+	if meta != nil && *meta != (ValueMeta{}) {
+		copied := *meta
+		sm.meta[key] = &copied
+	} else {
+		delete(sm.meta, key)
+	}
+	if sm.listener != nil {
+		event := ChangeEvent{Type: "put", Key: key, Value: value}
+		if meta != nil {
+			event.ContentType, event.Encoding = meta.ContentType, meta.Encoding
+		}
+		sm.changes = append(sm.changes, event)
+	}
+	if ttlSeconds > 0 {
+		sm.expires[key] = entry.Timestamp.Add(time.Duration(ttlSeconds) * time.Second).UnixMilli()
+	} else {
+		delete(sm.expires, key)
+	}
+}
+
+// deleteKey 删除键及其过期时间（调用方需持有写锁）
+func (sm *KVStateMachine) deleteKey(key string) {
+	sm.trackDelete(key)
+	if _, exists := sm.data[key]; exists {
+		sm.keys.remove(key)
+		if sm.listener != nil {
+			sm.changes = append(sm.changes, ChangeEvent{Type: "delete", Key: key})
+		}
+	}
+	delete(sm.data, key)
+	delete(sm.expires, key)
+	delete(sm.versions, key)
+	delete(sm.meta, key)
+}
+
+// commandWaiter 一个请求对应用结果的等待
+type commandWaiter struct {
+	ctx  context.Context
+	ch   chan *CommandResult
+	stop func() bool // 解除与ctx的关联
+}
+
+// WaiterStats 应用结果等待的统计
+type WaiterStats struct {
+	Registered int    `json:"registered"` // 当前注册的等待数
+	Abandoned  uint64 `json:"abandoned"`  // 上下文结束时仍未收到结果而被移除的等待数
+}
+
+// RegisterWaiter 注册等待指定请求应用结果的通道，需在提议命令之前调用
+// ctx结束（请求超时或被取消）时等待立即被移除，之后应用该命令不再投递结果；命令本身仍会被应用
+func (sm *KVStateMachine) RegisterWaiter(ctx context.Context, requestID string) <-chan *CommandResult {
+	w := &commandWaiter{ctx: ctx, ch: make(chan *CommandResult, 1)}
+
+	// 持有锁时关联ctx：已结束的ctx立即触发的回调要等注册完成后才能移除等待
+	sm.waitMu.Lock()
+	sm.waiters[requestID] = w
+	w.stop = context.AfterFunc(ctx, func() { sm.abandonWaiter(requestID, w) })
+	sm.waitMu.Unlock()
+
+	return w.ch
+}
+
+// CancelWaiter 取消等待（例如提议失败或等待超时）
+func (sm *KVStateMachine) CancelWaiter(requestID string) {
+	sm.waitMu.Lock()
+	w, ok := sm.waiters[requestID]
+	if ok {
+		delete(sm.waiters, requestID)
+		if w.ctx.Err() != nil {
+			sm.abandoned++
+		}
+	}
+	sm.waitMu.Unlock()
+
+	if ok {
+		w.stop()
+	}
+}
+
+// abandonWaiter 请求的上下文结束后移除仍在等待的w
+func (sm *KVStateMachine) abandonWaiter(requestID string, w *commandWaiter) {
+	sm.waitMu.Lock()
+	defer sm.waitMu.Unlock()
+
+	if sm.waiters[requestID] == w {
+		delete(sm.waiters, requestID)
+		sm.abandoned++
+	}
+}
+
+// WaiterStats 当前注册的等待数与累计被放弃的等待数
+func (sm *KVStateMachine) WaiterStats() WaiterStats {
+	sm.waitMu.Lock()
+	defer sm.waitMu.Unlock()
+
+	return WaiterStats{Registered: len(sm.waiters), Abandoned: sm.abandoned}
+}
+
+// notifyResult 将应用结果发送给等待方（如果存在），没有等待方时只是一次查找
+func (sm *KVStateMachine) notifyResult(requestID string, result *CommandResult, err error) {
+	sm.waitMu.Lock()
+	w, ok := sm.waiters[requestID]
+	delete(sm.waiters, requestID)
+	sm.waitMu.Unlock()
+
+	if !ok {
+		return
+	}
+	w.stop()
+	if result == nil {
+		result = &CommandResult{}
+	}
+	result.Err = err
+	w.ch <- result
+}
+
+// isExpired 判断键在now时刻是否已过期（调用方需持有读锁）
+func (sm *KVStateMachine) isExpired(key string, now int64) bool {
+	expireAt, ok := sm.expires[key]
+	return ok && expireAt <= now
+}
+
 // CreateSnapshot 创建状态机快照
 func (sm *KVStateMachine) CreateSnapshot() ([]byte, error) {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
-	snapshot := make(map[string]interface{})
+	snapshot := kvSnapshot{
+		Version:  kvSnapshotVersion,
+		Data:     make(map[string]interface{}, len(sm.data)),
+		Expires:  make(map[string]int64, len(sm.expires)),
+		Versions: make(map[string]uint64, len(sm.versions)),
+		Meta:     make(map[string]*ValueMeta, len(sm.meta)),
+		ACL:      make(map[string]*ACLToken, len(sm.acl)),
+	}
 	for k, v := range sm.data {
-		snapshot[k] = v
+		snapshot.Data[k] = v
 	}
+	for k, v := range sm.expires {
+		snapshot.Expires[k] = v
+	}
+	for k, v := range sm.versions {
+		snapshot.Versions[k] = v
+	}
+	for k, v := range sm.meta {
+		copied := *v
+		snapshot.Meta[k] = &copied
+	}
+	for k, v := range sm.acl {
+		snapshot.ACL[k] = v.clone()
+	}
+	snapshot.Quotas = sm.snapshotQuotas()
+	snapshot.Replication = sm.snapshotReplication()
+	snapshot.Sessions = sm.snapshotSessions()
+	snapshot.Shards = sm.snapshotShards()
+	snapshot.ShardSeq = sm.shardSeq
+	snapshot.ShardIndex = sm.shardIndex
+	snapshot.AppliedIndex = sm.appliedIndex
 
 	data, err := json.Marshal(snapshot)
 	if err != nil {
@@ -85,35 +563,173 @@ func (sm *KVStateMachine) CreateSnapshot() ([]byte, error) {
 
 // RestoreSnapshot 从快照恢复状态机
 func (sm *KVStateMachine) RestoreSnapshot(data []byte) error {
-	var snapshot map[string]interface{}
-	if err := json.Unmarshal(data, &snapshot); err != nil {
-		return fmt.Errorf("反序列化快照失败: %w", err)
+	var snapshot kvSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil || snapshot.Version == 0 {
+		// 兼容旧格式：快照直接是键值映射
+		var legacy map[string]interface{}
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return fmt.Errorf("反序列化快照失败: %w", err)
+		}
+		snapshot = kvSnapshot{Data: legacy}
+	}
+
+	if snapshot.Data == nil {
+		snapshot.Data = make(map[string]interface{})
+	}
+	if snapshot.Expires == nil {
+		snapshot.Expires = make(map[string]int64)
+	}
+	if snapshot.Versions == nil {
+		snapshot.Versions = make(map[string]uint64)
+	}
+	if snapshot.Meta == nil {
+		snapshot.Meta = make(map[string]*ValueMeta)
 	}
 
 	sm.mu.Lock()
-	defer sm.mu.Unlock()
 
-	sm.data = snapshot
+	sm.data = snapshot.Data
+	sm.expires = snapshot.Expires
+	sm.versions = snapshot.Versions
+	sm.meta = snapshot.Meta
+	keys := make([]string, 0, len(sm.data))
+	for key := range sm.data {
+		keys = append(keys, key)
+	}
+	sm.keys = newKeyIndex(keys)
+
+	sm.acl = make(map[string]*ACLToken, len(snapshot.ACL))
+	sm.aclByHash = make(map[string]*ACLToken, len(snapshot.ACL))
+	for name, token := range snapshot.ACL {
+		sm.acl[name] = token
+		sm.aclByHash[token.TokenHash] = token
+	}
+
+	// 配额的用量不写入快照，按恢复的数据重新统计
+	sm.quotas = make(map[string]*quotaState, len(snapshot.Quotas))
+	for _, quota := range snapshot.Quotas {
+		sm.quotas[quota.Prefix] = &quotaState{Quota: quota}
+	}
+	sm.rebuildQuotaUsageLocked()
+
+	sm.replication = make(map[raft.DataCenterID]*ReplicationMark, len(snapshot.Replication))
+	for i := range snapshot.Replication {
+		mark := snapshot.Replication[i]
+		sm.replication[mark.SourceDC] = &mark
+	}
+
+	sm.sessions = make(map[string]*clientSession, len(snapshot.Sessions))
+	for _, session := range snapshot.Sessions {
+		if session.Responses == nil {
+			session.Responses = make(map[uint64]*sessionResponse)
+		}
+		sm.sessions[session.ID] = session
+	}
+
+	sm.shards = make(map[string]*ShardRecord, len(snapshot.Shards))
+	for i := range snapshot.Shards {
+		shard := snapshot.Shards[i]
+		sm.shards[shard.ID] = &shard
+	}
+	sm.shardSeq = snapshot.ShardSeq
+	sm.shardIndex = snapshot.ShardIndex
+	sm.appliedIndex = snapshot.AppliedIndex
+	sm.mu.Unlock()
+
+	if sm.listener != nil {
+		sm.listener.OnRestore()
+	}
 
 	return nil
 }
 
+// AppliedIndex 状态机已应用的最后一个日志索引
+func (sm *KVStateMachine) AppliedIndex() raft.LogIndex {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.appliedIndex
+}
+
 // Get 获取键值
 func (sm *KVStateMachine) Get(key string) (interface{}, bool) {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
-	value, exists := sm.data[key]
+	if sm.isExpired(key, time.Now().UnixMilli()) {
+		return nil, false
+	}
+
+	// 压缩存储的值解压后返回，无法解压时返回存储的值
+	value, _, exists, err := sm.valueLocked(key)
+	if err != nil {
+		return sm.data[key], true
+	}
 	return value, exists
 }
 
+// GetVersion 获取键的当前版本，键不存在时返回0
+func (sm *KVStateMachine) GetVersion(key string) uint64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	if sm.isExpired(key, time.Now().UnixMilli()) {
+		return 0
+	}
+
+	return sm.versions[key]
+}
+
+// GetTTL 获取键的剩余存活时间，ok为false表示键不存在或没有设置过期时间
+func (sm *KVStateMachine) GetTTL(key string) (time.Duration, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	expireAt, ok := sm.expires[key]
+	if !ok {
+		return 0, false
+	}
+
+	remaining := time.Until(time.UnixMilli(expireAt))
+	if remaining <= 0 {
+		return 0, false
+	}
+
+	return remaining, true
+}
+
+// ExpiredKeys 返回在now时刻已过期但尚未被物理删除的键，最多limit个
+func (sm *KVStateMachine) ExpiredKeys(now time.Time, limit int) []string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	nowMs := now.UnixMilli()
+	keys := make([]string, 0)
+	for key, expireAt := range sm.expires {
+		if expireAt <= nowMs {
+			keys = append(keys, key)
+			if len(keys) >= limit {
+				break
+			}
+		}
+	}
+
+	return keys
+}
+
 // GetAll 获取所有键值对
 func (sm *KVStateMachine) GetAll() map[string]interface{} {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
+	now := time.Now().UnixMilli()
 	result := make(map[string]interface{})
 	for k, v := range sm.data {
+		if sm.isExpired(k, now) {
+			continue
+		}
+		if decoded, err := DecodeValue(v, sm.meta[k]); err == nil {
+			v = decoded
+		}
 		result[k] = v
 	}
 
@@ -133,14 +749,58 @@ func (sm *KVStateMachine) Keys() []string {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
+	now := time.Now().UnixMilli()
 	keys := make([]string, 0, len(sm.data))
 	for k := range sm.data {
+		if sm.isExpired(k, now) {
+			continue
+		}
 		keys = append(keys, k)
 	}
 
 	return keys
 }
 
+// Scan 按字典序返回前缀匹配且大于after的最多limit个键值对，more表示是否还有后续结果
+func (sm *KVStateMachine) Scan(prefix, after string, limit int) ([]ScanEntry, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	// 起始位置：前缀的第一个键，或游标之后的第一个键
+	from := prefix
+	if after != "" && after >= prefix {
+		from = after
+	}
+
+	now := time.Now().UnixMilli()
+	entries := make([]ScanEntry, 0, limit)
+	more := false
+	sm.keys.ascend(from, func(key string) bool {
+		if key == after {
+			return true
+		}
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		if sm.isExpired(key, now) {
+			return true
+		}
+		if len(entries) == limit {
+			more = true
+			return false
+		}
+		entry := ScanEntry{Key: key, Value: sm.data[key]}
+		if meta := sm.meta[key]; meta != nil {
+			copied := *meta
+			entry.Meta = &copied
+		}
+		entries = append(entries, entry)
+		return true
+	})
+
+	return entries, more
+}
+
 // CreateSetCommand 创建SET命令
 func CreateSetCommand(key string, value interface{}) ([]byte, error) {
 	cmd := Command{
@@ -152,6 +812,56 @@ func CreateSetCommand(key string, value interface{}) ([]byte, error) {
 	return json.Marshal(cmd)
 }
 
+// CreateSetWithTTLCommand 创建带过期时间的SET命令，过期时刻由日志条目时间戳加ttl决定
+func CreateSetWithTTLCommand(key string, value interface{}, ttl time.Duration) ([]byte, error) {
+	cmd := Command{
+		Type:       "SET",
+		Key:        key,
+		Value:      value,
+		TTLSeconds: int64(ttl / time.Second),
+	}
+
+	return json.Marshal(cmd)
+}
+
+// CreateCASCommand 创建按值比较的CAS命令，expected为nil表示期望键不存在
+func CreateCASCommand(requestID, key string, expected, value interface{}, ttl time.Duration) ([]byte, error) {
+	cmd := Command{
+		Type:       "CAS",
+		Key:        key,
+		Value:      value,
+		TTLSeconds: int64(ttl / time.Second),
+		RequestID:  requestID,
+		Expected:   expected,
+	}
+
+	return json.Marshal(cmd)
+}
+
+// CreateCASVersionCommand 创建按版本比较的CAS命令，expectedVersion为0表示期望键不存在
+func CreateCASVersionCommand(requestID, key string, expectedVersion uint64, value interface{}, ttl time.Duration) ([]byte, error) {
+	cmd := Command{
+		Type:            "CAS",
+		Key:             key,
+		Value:           value,
+		TTLSeconds:      int64(ttl / time.Second),
+		RequestID:       requestID,
+		ExpectedVersion: &expectedVersion,
+	}
+
+	return json.Marshal(cmd)
+}
+
+// CreateExpireCommand 创建EXPIRE命令，用于清理已过期的键
+func CreateExpireCommand(keys []string) ([]byte, error) {
+	cmd := Command{
+		Type: "EXPIRE",
+		Keys: keys,
+	}
+
+	return json.Marshal(cmd)
+}
+
 // CreateDeleteCommand 创建DELETE命令
 func CreateDeleteCommand(key string) ([]byte, error) {
 	cmd := Command{
@@ -171,3 +881,13 @@ func CreateGetCommand(key string) ([]byte, error) {
 
 	return json.Marshal(cmd)
 }
+
+// CreateBatchCommand 创建BATCH命令，ops中的命令将在同一日志条目中按顺序应用
+func CreateBatchCommand(ops []Command) ([]byte, error) {
+	cmd := Command{
+		Type: "BATCH",
+		Ops:  ops,
+	}
+
+	return json.Marshal(cmd)
+}
@@ -0,0 +1,271 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-8-2 09:14:27
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-8-2 09:14:27
+* @Description: ConcordKV Raft consensus server - quota.go
+ */
+package statemachine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrQuotaExceeded 写入会使命名空间超出配额，命令未被应用
+var ErrQuotaExceeded = errors.New("超出命名空间配额")
+
+// Quota 键前缀（命名空间）的配额，MaxKeys与MaxBytes为0表示该项不限制
+// 键的字节数为键长加上值的JSON编码长度，压缩存储的值按压缩后的长度计算
+type Quota struct {
+	Prefix   string `json:"prefix"`
+	MaxKeys  int64  `json:"maxKeys,omitempty"`
+	MaxBytes int64  `json:"maxBytes,omitempty"`
+}
+
+// QuotaUsage 命名空间的配额与当前用量，已过期但尚未清理的键计入用量
+type QuotaUsage struct {
+	Quota
+	Keys  int64 `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Validate 检查配额是否有效
+func (q *Quota) Validate() error {
+	if q.Prefix == "" {
+		return fmt.Errorf("配额的前缀不能为空")
+	}
+	if q.MaxKeys < 0 || q.MaxBytes < 0 {
+		return fmt.Errorf("前缀 %q 的配额不能为负数", q.Prefix)
+	}
+	return nil
+}
+
+// quotaState 配额及其用量计数，用量在setKey与deleteKey中增量维护，从快照恢复时按数据重新统计
+type quotaState struct {
+	Quota
+	keys  int64
+	bytes int64
+}
+
+// entrySize 键值占用的字节数
+func entrySize(key string, value interface{}) int64 {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return int64(len(key))
+	}
+	return int64(len(key) + len(data))
+}
+
+// quotasFor 键所属的所有命名空间，前缀嵌套时键同时计入外层与内层（调用方需持有锁）
+func (sm *KVStateMachine) quotasFor(key string) []*quotaState {
+	var matched []*quotaState
+	for prefix, q := range sm.quotas {
+		if strings.HasPrefix(key, prefix) {
+			matched = append(matched, q)
+		}
+	}
+	return matched
+}
+
+// trackWrite 写入键值前更新所属命名空间的用量（调用方需持有写锁）
+func (sm *KVStateMachine) trackWrite(key string, value interface{}) {
+	matched := sm.quotasFor(key)
+	if len(matched) == 0 {
+		return
+	}
+
+	var keys, bytes int64 = 1, entrySize(key, value)
+	if old, exists := sm.data[key]; exists {
+		keys, bytes = 0, bytes-entrySize(key, old)
+	}
+	for _, q := range matched {
+		q.keys += keys
+		q.bytes += bytes
+	}
+}
+
+// trackDelete 删除键前更新所属命名空间的用量（调用方需持有写锁）
+func (sm *KVStateMachine) trackDelete(key string) {
+	old, exists := sm.data[key]
+	if !exists {
+		return
+	}
+	matched := sm.quotasFor(key)
+	if len(matched) == 0 {
+		return
+	}
+
+	size := entrySize(key, old)
+	for _, q := range matched {
+		q.keys--
+		q.bytes -= size
+	}
+}
+
+// checkQuota 检查按顺序执行ops中的SET与DELETE后各命名空间是否超出配额（调用方需持有锁）
+// 只拒绝增加某项用量并使其超出上限的写入，配额调低到当前用量以下后删除与缩小值的写入仍然允许
+func (sm *KVStateMachine) checkQuota(ops []Command) error {
+	if len(sm.quotas) == 0 {
+		return nil
+	}
+
+	type usageDelta struct{ keys, bytes int64 }
+	deltas := make(map[*quotaState]*usageDelta)
+	sizes := make(map[string]int64) // 前面的操作执行后键的字节数，-1表示键不存在
+
+	for i := range ops {
+		op := &ops[i]
+		if op.Type != "SET" && op.Type != "DELETE" {
+			continue
+		}
+		matched := sm.quotasFor(op.Key)
+		if len(matched) == 0 {
+			continue
+		}
+
+		oldSize, seen := sizes[op.Key]
+		if !seen {
+			oldSize = -1
+			if old, exists := sm.data[op.Key]; exists {
+				oldSize = entrySize(op.Key, old)
+			}
+		}
+		newSize := int64(-1)
+		if op.Type == "SET" {
+			newSize = entrySize(op.Key, op.Value)
+		}
+		sizes[op.Key] = newSize
+
+		var keys, bytes int64
+		if oldSize >= 0 {
+			keys, bytes = keys-1, bytes-oldSize
+		}
+		if newSize >= 0 {
+			keys, bytes = keys+1, bytes+newSize
+		}
+		for _, q := range matched {
+			d := deltas[q]
+			if d == nil {
+				d = &usageDelta{}
+				deltas[q] = d
+			}
+			d.keys += keys
+			d.bytes += bytes
+		}
+	}
+
+	// 按前缀顺序检查，各副本返回相同的错误
+	changed := make([]*quotaState, 0, len(deltas))
+	for q := range deltas {
+		changed = append(changed, q)
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].Prefix < changed[j].Prefix })
+
+	for _, q := range changed {
+		d := deltas[q]
+		if q.MaxKeys > 0 && d.keys > 0 && q.keys+d.keys > q.MaxKeys {
+			return fmt.Errorf("%w: 前缀 %q 最多 %d 个键，当前 %d 个", ErrQuotaExceeded, q.Prefix, q.MaxKeys, q.keys)
+		}
+		if q.MaxBytes > 0 && d.bytes > 0 && q.bytes+d.bytes > q.MaxBytes {
+			return fmt.Errorf("%w: 前缀 %q 最多 %d 字节，当前 %d 字节，写入需要增加 %d 字节", ErrQuotaExceeded, q.Prefix, q.MaxBytes, q.bytes, d.bytes)
+		}
+	}
+	return nil
+}
+
+// applyQuotaSet 设置命名空间的配额（调用方需持有写锁）
+// 新的命名空间按现有数据统计用量，已有的命名空间只更新上限
+func (sm *KVStateMachine) applyQuotaSet(quota *Quota) error {
+	if quota == nil {
+		return fmt.Errorf("QUOTA_SET命令缺少配额")
+	}
+	if err := quota.Validate(); err != nil {
+		return err
+	}
+
+	if q, exists := sm.quotas[quota.Prefix]; exists {
+		q.Quota = *quota
+		return nil
+	}
+
+	q := &quotaState{Quota: *quota}
+	for key, value := range sm.data {
+		if strings.HasPrefix(key, q.Prefix) {
+			q.keys++
+			q.bytes += entrySize(key, value)
+		}
+	}
+	sm.quotas[q.Prefix] = q
+	return nil
+}
+
+// rebuildQuotaUsageLocked 按数据重新统计所有命名空间的用量（调用方需持有写锁）
+func (sm *KVStateMachine) rebuildQuotaUsageLocked() {
+	if len(sm.quotas) == 0 {
+		return
+	}
+	for _, q := range sm.quotas {
+		q.keys, q.bytes = 0, 0
+	}
+	for key, value := range sm.data {
+		matched := sm.quotasFor(key)
+		if len(matched) == 0 {
+			continue
+		}
+		size := entrySize(key, value)
+		for _, q := range matched {
+			q.keys++
+			q.bytes += size
+		}
+	}
+}
+
+// snapshotQuotas 按前缀排序的配额定义，用量不写入快照（调用方需持有锁）
+func (sm *KVStateMachine) snapshotQuotas() []Quota {
+	if len(sm.quotas) == 0 {
+		return nil
+	}
+	quotas := make([]Quota, 0, len(sm.quotas))
+	for _, q := range sm.quotas {
+		quotas = append(quotas, q.Quota)
+	}
+	sort.Slice(quotas, func(i, j int) bool { return quotas[i].Prefix < quotas[j].Prefix })
+	return quotas
+}
+
+// QuotaUsage 所有命名空间的配额与当前用量，按前缀排序
+func (sm *KVStateMachine) QuotaUsage() []QuotaUsage {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	usage := make([]QuotaUsage, 0, len(sm.quotas))
+	for _, q := range sm.quotas {
+		usage = append(usage, QuotaUsage{Quota: q.Quota, Keys: q.keys, Bytes: q.bytes})
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Prefix < usage[j].Prefix })
+	return usage
+}
+
+// CreateQuotaSetCommand 创建设置命名空间配额的命令
+func CreateQuotaSetCommand(quota *Quota) ([]byte, error) {
+	cmd := Command{
+		Type:  "QUOTA_SET",
+		Quota: quota,
+	}
+
+	return json.Marshal(cmd)
+}
+
+// CreateQuotaDeleteCommand 创建删除命名空间配额的命令
+func CreateQuotaDeleteCommand(prefix string) ([]byte, error) {
+	cmd := Command{
+		Type: "QUOTA_DELETE",
+		Key:  prefix,
+	}
+
+	return json.Marshal(cmd)
+}
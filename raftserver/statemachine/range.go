@@ -0,0 +1,126 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-30 15:20:44
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-30 15:20:44
+* @Description: ConcordKV Raft consensus server - range.go
+ */
+package statemachine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"raftserver/raft"
+)
+
+// MaxDeleteRangeKeys 一个DELETE_RANGE日志条目最多删除的键数，范围内更多的键由调用方凭NextKey分批删除，
+// 避免单次应用长时间持有状态机的写锁
+const MaxDeleteRangeKeys = 10000
+
+// ErrInvalidRange 键范围无效
+var ErrInvalidRange = errors.New("无效的键范围")
+
+// KeyRange 键范围：以Prefix开头、不小于Start且小于End的键，End为空时不设上界
+type KeyRange struct {
+	Prefix string `json:"prefix,omitempty"`
+	Start  string `json:"start,omitempty"`
+	End    string `json:"end,omitempty"`
+}
+
+// Validate 校验范围，Prefix与End不能同时为空，避免一个命令清空整个键空间
+func (r *KeyRange) Validate() error {
+	if r.Prefix == "" && r.End == "" {
+		return errors.New("键范围需要指定prefix或end")
+	}
+	if r.End != "" && r.Start >= r.End {
+		return errors.New("键范围的start必须小于end")
+	}
+	return nil
+}
+
+// first 范围内可能的最小键
+func (r *KeyRange) first() string {
+	if r.Start > r.Prefix {
+		return r.Start
+	}
+	return r.Prefix
+}
+
+// past 按字典序遍历到key时是否已越过范围的上界，key不小于first()
+func (r *KeyRange) past(key string) bool {
+	return !strings.HasPrefix(key, r.Prefix) || (r.End != "" && key >= r.End)
+}
+
+// applyDeleteRange 按字典序删除范围内最多cmd.Limit个键（调用方需持有写锁）
+// 结果中Deleted为删除时未过期的键数，NextKey为范围内剩余的第一个键，为空表示范围内的键已全部删除
+func (sm *KVStateMachine) applyDeleteRange(cmd *Command, entry *raft.LogEntry) (*CommandResult, error) {
+	if cmd.Range == nil {
+		return nil, ErrInvalidRange
+	}
+	if err := cmd.Range.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRange, err)
+	}
+	limit := cmd.Limit
+	if limit <= 0 || limit > MaxDeleteRangeKeys {
+		limit = MaxDeleteRangeKeys
+	}
+
+	now := entry.Timestamp.UnixMilli()
+	result := &CommandResult{}
+
+	// 遍历有序索引时不能修改它，先收集要删除的键
+	var keys []string
+	sm.keys.ascend(cmd.Range.first(), func(key string) bool {
+		if cmd.Range.past(key) {
+			return false
+		}
+		if len(keys) == limit {
+			result.NextKey = key
+			return false
+		}
+		keys = append(keys, key)
+		return true
+	})
+
+	for _, key := range keys {
+		if !sm.isExpired(key, now) {
+			result.Deleted++
+		}
+		sm.deleteKey(key)
+	}
+	return result, nil
+}
+
+// Count 返回以prefix开头且未过期的键数，不复制键或值
+func (sm *KVStateMachine) Count(prefix string) int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	now := time.Now().UnixMilli()
+	count := 0
+	sm.keys.ascend(prefix, func(key string) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		if !sm.isExpired(key, now) {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// CreateDeleteRangeCommand 创建DELETE_RANGE命令，limit为本条目最多删除的键数
+func CreateDeleteRangeCommand(r KeyRange, limit int) ([]byte, error) {
+	cmd := Command{
+		Type:  "DELETE_RANGE",
+		Range: &r,
+		Limit: limit,
+	}
+
+	return json.Marshal(cmd)
+}
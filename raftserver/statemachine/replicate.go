@@ -0,0 +1,151 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-8-3 09:36:18
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-8-3 09:36:18
+* @Description: ConcordKV Raft consensus server - replicate.go
+ */
+package statemachine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"raftserver/raft"
+)
+
+// ErrReplicationGap 复制批次与源DC已应用的位置之间有空洞，命令未被应用
+var ErrReplicationGap = errors.New("跨DC复制批次不连续")
+
+// ReplicatedBatch REPLICATE命令携带的其他DC的日志条目，条目按索引连续
+type ReplicatedBatch struct {
+	SourceDC raft.DataCenterID `json:"sourceDC"`
+	Entries  []raft.LogEntry   `json:"entries"`
+}
+
+// ReplicationMark 源DC的复制高水位：不大于Index的条目都已应用，随快照持久化
+// Rejected统计在本集群被拒绝（例如超出配额）的条目，它们同样推进高水位，不会被重新应用
+type ReplicationMark struct {
+	SourceDC raft.DataCenterID `json:"sourceDC"`
+	Index    raft.LogIndex     `json:"index"`
+	Term     raft.Term         `json:"term"`
+	Applied  uint64            `json:"applied"`
+	Rejected uint64            `json:"rejected,omitempty"`
+}
+
+// replicatedCommandTypes 从其他DC复制过来时应用的命令类型，会话、ACL、配额与分片等集群本地的命令被跳过
+var replicatedCommandTypes = map[string]bool{
+	"SET":          true,
+	"DELETE":       true,
+	"DELETE_RANGE": true,
+	"BATCH":        true,
+	"EXPIRE":       true,
+	"CAS":          true,
+	"TXN":          true,
+}
+
+// applyReplicate 应用其他DC的日志条目（调用方需持有写锁）
+// 不大于高水位的条目已应用过，直接跳过；其余条目必须紧接高水位，否则整条命令被拒绝。
+// 各条目以源DC日志的时间戳应用，被拒绝的条目计入Rejected后继续
+func (sm *KVStateMachine) applyReplicate(cmd *Command, entry *raft.LogEntry) (*CommandResult, error) {
+	batch := cmd.Replicated
+	if batch == nil || batch.SourceDC == "" {
+		return nil, fmt.Errorf("REPLICATE命令缺少源DC")
+	}
+
+	mark := sm.replication[batch.SourceDC]
+	if mark == nil {
+		mark = &ReplicationMark{SourceDC: batch.SourceDC}
+	}
+
+	first := 0
+	for first < len(batch.Entries) && batch.Entries[first].Index <= mark.Index {
+		first++
+	}
+	fresh := batch.Entries[first:]
+	for i := range fresh {
+		if want := mark.Index + raft.LogIndex(i) + 1; fresh[i].Index != want {
+			return nil, fmt.Errorf("%w: DC %s 已应用到 %d，期望索引 %d，实际 %d", ErrReplicationGap, batch.SourceDC, mark.Index, want, fresh[i].Index)
+		}
+	}
+	for i := range fresh {
+		source := &fresh[i]
+		if source.Type == raft.EntryNormal && !sm.applyReplicatedEntry(source, entry) {
+			mark.Rejected++
+		}
+		mark.Index, mark.Term = source.Index, source.Term
+		mark.Applied++
+	}
+	sm.replication[batch.SourceDC] = mark
+	return nil, nil
+}
+
+// applyReplicatedEntry 应用源DC的一个普通条目，返回条目是否被接受（调用方需持有写锁）
+// 版本号取本集群的日志索引，过期时间按源条目的时间戳计算；会话与请求ID只在源集群有意义，应用前去掉
+func (sm *KVStateMachine) applyReplicatedEntry(source *raft.LogEntry, entry *raft.LogEntry) bool {
+	var cmd Command
+	if err := json.Unmarshal(source.Data, &cmd); err != nil {
+		return false
+	}
+	if !replicatedCommandTypes[cmd.Type] {
+		return true
+	}
+	cmd.RequestID, cmd.SessionID, cmd.SeqNum, cmd.AckSeq = "", "", 0, 0
+
+	local := &raft.LogEntry{
+		Index:     entry.Index,
+		Term:      entry.Term,
+		Timestamp: source.Timestamp,
+		Type:      raft.EntryNormal,
+	}
+	_, err := sm.applyTopLevel(&cmd, local)
+	return err == nil
+}
+
+// snapshotReplication 按源DC排序的复制高水位（调用方需持有锁）
+func (sm *KVStateMachine) snapshotReplication() []ReplicationMark {
+	if len(sm.replication) == 0 {
+		return nil
+	}
+	marks := make([]ReplicationMark, 0, len(sm.replication))
+	for _, mark := range sm.replication {
+		marks = append(marks, *mark)
+	}
+	sort.Slice(marks, func(i, j int) bool { return marks[i].SourceDC < marks[j].SourceDC })
+	return marks
+}
+
+// ReplicationMark 源DC的复制高水位，尚未收到过该DC的条目时Index为0
+func (sm *KVStateMachine) ReplicationMark(source raft.DataCenterID) ReplicationMark {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	if mark, ok := sm.replication[source]; ok {
+		return *mark
+	}
+	return ReplicationMark{SourceDC: source}
+}
+
+// ReplicationMarks 所有源DC的复制高水位，按源DC排序
+func (sm *KVStateMachine) ReplicationMarks() []ReplicationMark {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	marks := sm.snapshotReplication()
+	if marks == nil {
+		marks = []ReplicationMark{}
+	}
+	return marks
+}
+
+// CreateReplicateCommand 创建应用其他DC日志条目的命令
+func CreateReplicateCommand(source raft.DataCenterID, entries []raft.LogEntry) ([]byte, error) {
+	cmd := Command{
+		Type:       "REPLICATE",
+		Replicated: &ReplicatedBatch{SourceDC: source, Entries: entries},
+	}
+
+	return json.Marshal(cmd)
+}
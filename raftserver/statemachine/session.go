@@ -0,0 +1,235 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - session.go
+ */
+package statemachine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"raftserver/raft"
+)
+
+var (
+	// ErrSessionExpired 会话不存在或已过期，命令未被应用
+	ErrSessionExpired = errors.New("会话不存在或已过期")
+	// ErrStaleSequence 序号的响应已被客户端确认并丢弃，无法判断重复
+	ErrStaleSequence = errors.New("请求序号已被确认")
+	// ErrTooManyPendingResponses 会话中未确认的响应过多
+	ErrTooManyPendingResponses = errors.New("会话中未确认的响应过多")
+)
+
+// MaxSessionResponses 每个会话最多缓存的未确认响应数
+const MaxSessionResponses = 1024
+
+// sessionResponse 缓存的命令应用结果，错误以文本保存以便写入快照
+type sessionResponse struct {
+	Result *CommandResult `json:"result,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// restore 还原缓存的结果，标记为重复请求
+func (r *sessionResponse) restore() (*CommandResult, error) {
+	result := &CommandResult{Duplicate: true}
+	if r.Result != nil {
+		*result = *r.Result
+		result.Duplicate = true
+	}
+	if r.Error != "" {
+		// 超出配额的拒绝保留错误类型，重复请求与首次请求得到相同的响应
+		if strings.HasPrefix(r.Error, ErrQuotaExceeded.Error()) {
+			return result, &restoredError{err: ErrQuotaExceeded, msg: r.Error}
+		}
+		return result, errors.New(r.Error)
+	}
+	return result, nil
+}
+
+// restoredError 从缓存文本还原的错误，保留原来的错误信息
+type restoredError struct {
+	err error
+	msg string
+}
+
+func (e *restoredError) Error() string { return e.msg }
+func (e *restoredError) Unwrap() error { return e.err }
+
+// clientSession 客户端会话，缓存每个未确认序号的响应
+// 所有时间都取自日志条目的时间戳，保证各副本对会话的判断一致
+type clientSession struct {
+	ID         string                      `json:"id"`
+	TimeoutMs  int64                       `json:"timeoutMs"`  // 空闲超时
+	LastActive int64                       `json:"lastActive"` // 最近一次活动的日志时间戳（Unix毫秒）
+	AckSeq     uint64                      `json:"ackSeq"`     // 客户端已确认的序号，不大于它的响应已丢弃
+	Responses  map[uint64]*sessionResponse `json:"responses,omitempty"`
+}
+
+// idle 判断会话在now时刻是否已空闲超时
+func (s *clientSession) idle(now int64) bool {
+	return now-s.LastActive >= s.TimeoutMs
+}
+
+// ack 丢弃客户端已确认的响应
+func (s *clientSession) ack(seq uint64) {
+	if seq <= s.AckSeq {
+		return
+	}
+	for n := range s.Responses {
+		if n <= seq {
+			delete(s.Responses, n)
+		}
+	}
+	s.AckSeq = seq
+}
+
+// record 缓存命令的应用结果
+func (s *clientSession) record(seq uint64, result *CommandResult, err error) {
+	response := &sessionResponse{}
+	if result != nil {
+		c := *result
+		c.Err = nil
+		response.Result = &c
+	}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	s.Responses[seq] = response
+}
+
+// beginSessionCommand 检查带会话的命令（调用方需持有写锁）
+// 返回缓存的响应表示命令是重复请求，不应再次执行；返回会话表示命令需要执行并记录结果
+func (sm *KVStateMachine) beginSessionCommand(cmd *Command, entry *raft.LogEntry) (*sessionResponse, *clientSession, error) {
+	if cmd.SessionID == "" || cmd.SeqNum == 0 {
+		return nil, nil, nil
+	}
+
+	session, exists := sm.sessions[cmd.SessionID]
+	if !exists {
+		return nil, nil, ErrSessionExpired
+	}
+
+	session.LastActive = entry.Timestamp.UnixMilli()
+	session.ack(cmd.AckSeq)
+
+	if response, exists := session.Responses[cmd.SeqNum]; exists {
+		return response, nil, nil
+	}
+	if cmd.SeqNum <= session.AckSeq {
+		return nil, nil, fmt.Errorf("%w: 序号 %d 不大于已确认的 %d", ErrStaleSequence, cmd.SeqNum, session.AckSeq)
+	}
+	if len(session.Responses) >= MaxSessionResponses {
+		return nil, nil, fmt.Errorf("%w: 上限 %d", ErrTooManyPendingResponses, MaxSessionResponses)
+	}
+
+	return nil, session, nil
+}
+
+// applySessionRegister 注册会话（调用方需持有写锁）
+func (sm *KVStateMachine) applySessionRegister(cmd *Command, entry *raft.LogEntry) error {
+	if cmd.SessionID == "" || cmd.SessionTimeoutMs <= 0 {
+		return fmt.Errorf("SESSION_REGISTER命令缺少会话ID或超时时间")
+	}
+	if _, exists := sm.sessions[cmd.SessionID]; exists {
+		return fmt.Errorf("会话 %s 已存在", cmd.SessionID)
+	}
+
+	sm.sessions[cmd.SessionID] = &clientSession{
+		ID:         cmd.SessionID,
+		TimeoutMs:  cmd.SessionTimeoutMs,
+		LastActive: entry.Timestamp.UnixMilli(),
+		Responses:  make(map[uint64]*sessionResponse),
+	}
+	return nil
+}
+
+// applySessionKeepAlive 刷新会话的活动时间（调用方需持有写锁）
+func (sm *KVStateMachine) applySessionKeepAlive(cmd *Command, entry *raft.LogEntry) error {
+	session, exists := sm.sessions[cmd.SessionID]
+	if !exists {
+		return ErrSessionExpired
+	}
+
+	session.LastActive = entry.Timestamp.UnixMilli()
+	session.ack(cmd.AckSeq)
+	return nil
+}
+
+// applySessionExpire 删除在该日志时间戳时确实已空闲超时的会话，期间有活动的会话保留（调用方需持有写锁）
+func (sm *KVStateMachine) applySessionExpire(cmd *Command, entry *raft.LogEntry) {
+	now := entry.Timestamp.UnixMilli()
+	for _, id := range cmd.Sessions {
+		if session, exists := sm.sessions[id]; exists && session.idle(now) {
+			delete(sm.sessions, id)
+		}
+	}
+}
+
+// IdleSessions 返回在now时刻已空闲超时的会话，最多limit个
+func (sm *KVStateMachine) IdleSessions(now time.Time, limit int) []string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	nowMs := now.UnixMilli()
+	ids := make([]string, 0)
+	for id, session := range sm.sessions {
+		if session.idle(nowMs) {
+			ids = append(ids, id)
+			if len(ids) >= limit {
+				break
+			}
+		}
+	}
+
+	return ids
+}
+
+// SessionCount 获取活跃会话数
+func (sm *KVStateMachine) SessionCount() int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return len(sm.sessions)
+}
+
+// snapshotSessions 深拷贝会话用于快照（调用方需持有读锁）
+func (sm *KVStateMachine) snapshotSessions() []*clientSession {
+	sessions := make([]*clientSession, 0, len(sm.sessions))
+	for _, session := range sm.sessions {
+		c := *session
+		c.Responses = make(map[uint64]*sessionResponse, len(session.Responses))
+		for seq, response := range session.Responses {
+			c.Responses[seq] = response
+		}
+		sessions = append(sessions, &c)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+	return sessions
+}
+
+// CreateSessionRegisterCommand 创建注册会话的命令
+func CreateSessionRegisterCommand(sessionID string, timeout time.Duration) ([]byte, error) {
+	cmd := Command{
+		Type:             "SESSION_REGISTER",
+		SessionID:        sessionID,
+		SessionTimeoutMs: timeout.Milliseconds(),
+	}
+
+	return json.Marshal(cmd)
+}
+
+// CreateSessionExpireCommand 创建清理空闲会话的命令
+func CreateSessionExpireCommand(sessionIDs []string) ([]byte, error) {
+	cmd := Command{
+		Type:     "SESSION_EXPIRE",
+		Sessions: sessionIDs,
+	}
+
+	return json.Marshal(cmd)
+}
@@ -0,0 +1,289 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-16 10:05:27
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-16 10:05:27
+* @Description: ConcordKV Raft consensus server - shard.go
+ */
+package statemachine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"raftserver/keyhash"
+	"raftserver/kverrors"
+	"raftserver/raft"
+)
+
+var (
+	// ErrShardNotFound 分片不存在，与kverrors.ErrShardNotFound是同一个值
+	ErrShardNotFound = kverrors.ErrShardNotFound
+	// ErrShardMigrating 分片正在迁移，迁移完成之前不能再次拆分或合并
+	ErrShardMigrating = errors.New("分片正在迁移")
+	// ErrInvalidShardOp 拆分点不在分片内部或合并的分片不相邻
+	ErrInvalidShardOp = errors.New("无效的分片操作")
+)
+
+// DefaultShardID 分片表为空时覆盖整个哈希环的初始分片
+const DefaultShardID = "shard-0"
+
+// ShardState 分片状态，取值与客户端ShardState一致
+type ShardState int
+
+const (
+	// ShardActive 正常服务
+	ShardActive ShardState = 0
+	// ShardMigrating 拆分或合并后尚未激活，客户端需刷新拓扑后重试
+	ShardMigrating ShardState = 1
+)
+
+// ShardRecord 分片表中的分片，哈希范围为[StartHash, EndHash)
+type ShardRecord struct {
+	ID        string     `json:"id"`
+	StartHash uint64     `json:"startHash"`
+	EndHash   uint64     `json:"endHash"`
+	State     ShardState `json:"state"`
+	Index     uint64     `json:"index"` // 最后一次修改该分片的日志索引
+}
+
+// ShardTable 分片表的一致视图
+type ShardTable struct {
+	Index  uint64        // 最后一次修改分片表的日志索引，从未修改时为0
+	Shards []ShardRecord // 按StartHash排序
+}
+
+// ShardOp 分片命令的参数
+type ShardOp struct {
+	ShardID   string   `json:"shardId,omitempty"`   // SHARD_SPLIT的源分片，SHARD_MERGE的左分片
+	SplitHash uint64   `json:"splitHash,omitempty"` // SHARD_SPLIT的拆分点，成为右分片的起点
+	RightID   string   `json:"rightId,omitempty"`   // SHARD_MERGE的右分片
+	ShardIDs  []string `json:"shardIds,omitempty"`  // SHARD_ACTIVATE激活的分片
+}
+
+// ShardKeyHash 按规范算法（keyhash.Canonical）计算键在哈希环上的位置
+func ShardKeyHash(key string) uint64 {
+	return keyhash.Canonical.Sum64(key)
+}
+
+// SetKeyHash 设置分片使用的键哈希算法，需在开始应用日志之前调用；集群的所有节点应配置相同的算法
+func (sm *KVStateMachine) SetKeyHash(algorithm keyhash.Algorithm) {
+	sm.shardHash = algorithm
+}
+
+// KeyHash 分片使用的键哈希算法
+func (sm *KVStateMachine) KeyHash() keyhash.Algorithm {
+	return sm.shardHash
+}
+
+// HashKey 按分片使用的算法计算键在哈希环上的位置
+func (sm *KVStateMachine) HashKey(key string) uint64 {
+	return sm.shardHash.Sum64(key)
+}
+
+// defaultShard 覆盖整个哈希环的初始分片
+func defaultShard() *ShardRecord {
+	return &ShardRecord{ID: DefaultShardID, StartHash: 0, EndHash: math.MaxUint64, State: ShardActive}
+}
+
+// shardLocked 查找分片，分片表为空时只有初始分片（调用方需持有锁）
+func (sm *KVStateMachine) shardLocked(id string) (*ShardRecord, bool) {
+	if len(sm.shards) == 0 {
+		if id == DefaultShardID {
+			return defaultShard(), true
+		}
+		return nil, false
+	}
+	shard, exists := sm.shards[id]
+	return shard, exists
+}
+
+// planSplitLocked 计算拆分后的左右分片：左分片保留原ID，右分片使用新分配的ID（调用方需持有锁）
+func (sm *KVStateMachine) planSplitLocked(shardID string, splitHash uint64) (ShardRecord, ShardRecord, error) {
+	source, exists := sm.shardLocked(shardID)
+	if !exists {
+		return ShardRecord{}, ShardRecord{}, fmt.Errorf("%w: %s", ErrShardNotFound, shardID)
+	}
+	if source.State == ShardMigrating {
+		return ShardRecord{}, ShardRecord{}, fmt.Errorf("%w: %s", ErrShardMigrating, shardID)
+	}
+	if splitHash <= source.StartHash || splitHash >= source.EndHash {
+		return ShardRecord{}, ShardRecord{}, fmt.Errorf("%w: 拆分点 %d 不在分片 %s 的范围 [%d, %d) 内部",
+			ErrInvalidShardOp, splitHash, shardID, source.StartHash, source.EndHash)
+	}
+
+	left := ShardRecord{ID: source.ID, StartHash: source.StartHash, EndHash: splitHash, State: ShardMigrating}
+	right := ShardRecord{ID: fmt.Sprintf("shard-%d", sm.shardSeq+1), StartHash: splitHash, EndHash: source.EndHash, State: ShardMigrating}
+	return left, right, nil
+}
+
+// planMergeLocked 计算合并后的分片：右分片并入左分片，两者的范围必须首尾相接（调用方需持有锁）
+func (sm *KVStateMachine) planMergeLocked(leftID, rightID string) (ShardRecord, error) {
+	if leftID == rightID {
+		return ShardRecord{}, fmt.Errorf("%w: 不能与自身合并", ErrInvalidShardOp)
+	}
+	left, exists := sm.shardLocked(leftID)
+	if !exists {
+		return ShardRecord{}, fmt.Errorf("%w: %s", ErrShardNotFound, leftID)
+	}
+	right, exists := sm.shardLocked(rightID)
+	if !exists {
+		return ShardRecord{}, fmt.Errorf("%w: %s", ErrShardNotFound, rightID)
+	}
+	for _, shard := range []*ShardRecord{left, right} {
+		if shard.State == ShardMigrating {
+			return ShardRecord{}, fmt.Errorf("%w: %s", ErrShardMigrating, shard.ID)
+		}
+	}
+	if left.EndHash != right.StartHash {
+		return ShardRecord{}, fmt.Errorf("%w: 分片 %s [%d, %d) 与 %s [%d, %d) 不相邻",
+			ErrInvalidShardOp, left.ID, left.StartHash, left.EndHash, right.ID, right.StartHash, right.EndHash)
+	}
+
+	return ShardRecord{ID: left.ID, StartHash: left.StartHash, EndHash: right.EndHash, State: ShardMigrating}, nil
+}
+
+// PlanShardSplit 校验拆分并返回拆分后的左右分片，不修改分片表（用于试运行）
+func (sm *KVStateMachine) PlanShardSplit(shardID string, splitHash uint64) ([]ShardRecord, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	left, right, err := sm.planSplitLocked(shardID, splitHash)
+	if err != nil {
+		return nil, err
+	}
+	return []ShardRecord{left, right}, nil
+}
+
+// PlanShardMerge 校验合并并返回合并后的分片，不修改分片表（用于试运行）
+func (sm *KVStateMachine) PlanShardMerge(leftID, rightID string) (ShardRecord, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	return sm.planMergeLocked(leftID, rightID)
+}
+
+// applyShardOp 应用分片命令，返回被创建或修改的分片（调用方需持有写锁）
+// 拆分与合并产生的分片处于迁移状态，由之后的SHARD_ACTIVATE命令激活
+func (sm *KVStateMachine) applyShardOp(cmd *Command, entry *raft.LogEntry) ([]ShardRecord, error) {
+	op := cmd.ShardOp
+	if op == nil {
+		return nil, fmt.Errorf("%s命令缺少分片参数", cmd.Type)
+	}
+
+	var changed []ShardRecord
+	switch cmd.Type {
+	case "SHARD_SPLIT":
+		left, right, err := sm.planSplitLocked(op.ShardID, op.SplitHash)
+		if err != nil {
+			return nil, err
+		}
+		sm.materializeShardsLocked()
+		sm.shardSeq++
+		changed = []ShardRecord{left, right}
+	case "SHARD_MERGE":
+		merged, err := sm.planMergeLocked(op.ShardID, op.RightID)
+		if err != nil {
+			return nil, err
+		}
+		sm.materializeShardsLocked()
+		delete(sm.shards, op.RightID)
+		changed = []ShardRecord{merged}
+	case "SHARD_ACTIVATE":
+		// 激活之前分片可能已被其他操作修改，只激活仍处于迁移状态的分片
+		for _, id := range op.ShardIDs {
+			if shard, exists := sm.shards[id]; exists && shard.State == ShardMigrating {
+				activated := *shard
+				activated.State = ShardActive
+				changed = append(changed, activated)
+			}
+		}
+	}
+
+	for i := range changed {
+		changed[i].Index = uint64(entry.Index)
+		record := changed[i]
+		sm.shards[record.ID] = &record
+	}
+	if len(changed) > 0 {
+		sm.shardIndex = uint64(entry.Index)
+	}
+	return changed, nil
+}
+
+// materializeShardsLocked 首次修改分片表时写入初始分片（调用方需持有写锁）
+func (sm *KVStateMachine) materializeShardsLocked() {
+	if len(sm.shards) == 0 {
+		sm.shards[DefaultShardID] = defaultShard()
+	}
+}
+
+// ShardTable 获取分片表，按哈希范围排序；从未拆分过时只有覆盖整个哈希环的初始分片
+func (sm *KVStateMachine) ShardTable() ShardTable {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	table := ShardTable{Index: sm.shardIndex, Shards: sm.snapshotShards()}
+	if len(table.Shards) == 0 {
+		table.Shards = []ShardRecord{*defaultShard()}
+	}
+	return table
+}
+
+// snapshotShards 复制分片表，按StartHash排序（调用方需持有锁）
+func (sm *KVStateMachine) snapshotShards() []ShardRecord {
+	shards := make([]ShardRecord, 0, len(sm.shards))
+	for _, shard := range sm.shards {
+		shards = append(shards, *shard)
+	}
+	sort.Slice(shards, func(i, j int) bool { return shards[i].StartHash < shards[j].StartHash })
+	return shards
+}
+
+// CreateShardSplitCommand 创建拆分分片的命令
+func CreateShardSplitCommand(shardID string, splitHash uint64) ([]byte, error) {
+	cmd := Command{
+		Type:    "SHARD_SPLIT",
+		ShardOp: &ShardOp{ShardID: shardID, SplitHash: splitHash},
+	}
+
+	return json.Marshal(cmd)
+}
+
+// CreateShardMergeCommand 创建合并分片的命令
+func CreateShardMergeCommand(leftID, rightID string) ([]byte, error) {
+	cmd := Command{
+		Type:    "SHARD_MERGE",
+		ShardOp: &ShardOp{ShardID: leftID, RightID: rightID},
+	}
+
+	return json.Marshal(cmd)
+}
+
+// CreateShardActivateCommand 创建激活分片的命令
+func CreateShardActivateCommand(shardIDs []string) ([]byte, error) {
+	cmd := Command{
+		Type:    "SHARD_ACTIVATE",
+		ShardOp: &ShardOp{ShardIDs: shardIDs},
+	}
+
+	return json.Marshal(cmd)
+}
+
+// ShardForKey 返回键所在的分片ID
+func (sm *KVStateMachine) ShardForKey(key string) string {
+	hash := sm.HashKey(key)
+
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	for id, shard := range sm.shards {
+		if hash >= shard.StartHash && hash < shard.EndHash {
+			return id
+		}
+	}
+	return DefaultShardID
+}
@@ -0,0 +1,201 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-19 15:32:08
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-19 15:32:08
+* @Description: ConcordKV Raft consensus server - txn.go
+ */
+package statemachine
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"raftserver/raft"
+)
+
+// ErrInvalidTxn 事务的比较条件或操作不合法
+var ErrInvalidTxn = errors.New("无效的事务")
+
+// 比较条件的目标
+const (
+	TxnTargetVersion = "version" // 键的版本，不存在的键版本为0
+	TxnTargetValue   = "value"   // 键的值，键不存在时比较不成立
+)
+
+// TxnCompare 事务的比较条件，所有条件成立时执行成功分支，否则执行失败分支
+type TxnCompare struct {
+	Key     string      `json:"key"`
+	Target  string      `json:"target"`            // version或value
+	Op      string      `json:"op"`                // =、!=、<、>
+	Version uint64      `json:"version,omitempty"` // 目标为version时比较的版本
+	Value   interface{} `json:"value,omitempty"`   // 目标为value时比较的值，<与>只支持数值或字符串
+}
+
+// TxnOpResult 事务中单个操作的结果
+type TxnOpResult struct {
+	Type    string      `json:"type"`
+	Key     string      `json:"key"`
+	Exists  bool        `json:"exists"`            // GET为读取时键是否存在，DELETE为删除前键是否存在，SET恒为true
+	Value   interface{} `json:"value,omitempty"`   // GET读取到的值
+	Version uint64      `json:"version,omitempty"` // 操作后键的版本
+}
+
+// ValidateTxn 校验TXN命令的结构，分支中只允许SET、DELETE与GET，不允许嵌套事务
+func ValidateTxn(cmd *Command) error {
+	if len(cmd.Compares) == 0 && len(cmd.Ops) == 0 && len(cmd.Else) == 0 {
+		return fmt.Errorf("%w: 事务不能为空", ErrInvalidTxn)
+	}
+
+	for i, cmp := range cmd.Compares {
+		if cmp.Key == "" {
+			return fmt.Errorf("%w: 比较条件 %d 的key不能为空", ErrInvalidTxn, i)
+		}
+		switch cmp.Op {
+		case "=", "!=", "<", ">":
+		default:
+			return fmt.Errorf("%w: 比较条件 %d 的运算符 %q 不支持", ErrInvalidTxn, i, cmp.Op)
+		}
+		if cmp.Target != TxnTargetVersion && cmp.Target != TxnTargetValue {
+			return fmt.Errorf("%w: 比较条件 %d 的目标 %q 不支持", ErrInvalidTxn, i, cmp.Target)
+		}
+	}
+
+	for _, branch := range []struct {
+		name string
+		ops  []Command
+	}{{"成功", cmd.Ops}, {"失败", cmd.Else}} {
+		for i, op := range branch.ops {
+			switch op.Type {
+			case "SET", "DELETE", "GET":
+			case "TXN":
+				return fmt.Errorf("%w: %s分支的操作 %d 不支持嵌套事务", ErrInvalidTxn, branch.name, i)
+			default:
+				return fmt.Errorf("%w: %s分支的操作 %d 的类型 %q 不支持", ErrInvalidTxn, branch.name, i, op.Type)
+			}
+			if op.Key == "" {
+				return fmt.Errorf("%w: %s分支的操作 %d 的key不能为空", ErrInvalidTxn, branch.name, i)
+			}
+			if op.TTLSeconds < 0 {
+				return fmt.Errorf("%w: %s分支的操作 %d 的ttlSeconds不能为负数", ErrInvalidTxn, branch.name, i)
+			}
+			if op.Meta != nil {
+				if err := op.Meta.Validate(); err != nil {
+					return fmt.Errorf("%w: %s分支的操作 %d: %v", ErrInvalidTxn, branch.name, i, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// applyTxn 在同一把锁内求值比较条件并执行其中一个分支（调用方需持有写锁）
+// 过期键按日志时间戳视为不存在，所有副本得到相同的结果
+func (sm *KVStateMachine) applyTxn(cmd *Command, entry *raft.LogEntry) (*CommandResult, error) {
+	if err := ValidateTxn(cmd); err != nil {
+		return nil, err
+	}
+
+	now := entry.Timestamp.UnixMilli()
+	expire := func(key string) {
+		if sm.isExpired(key, now) {
+			sm.deleteKey(key)
+		}
+	}
+
+	succeeded := true
+	for i := range cmd.Compares {
+		expire(cmd.Compares[i].Key)
+		if !sm.evalCompare(&cmd.Compares[i]) {
+			succeeded = false
+			break
+		}
+	}
+
+	ops := cmd.Ops
+	if !succeeded {
+		ops = cmd.Else
+	}
+
+	// 所选分支会超出配额时整个事务被拒绝，不执行任何操作
+	if err := sm.checkQuota(ops); err != nil {
+		return nil, err
+	}
+
+	responses := make([]TxnOpResult, len(ops))
+	for i := range ops {
+		op := &ops[i]
+		expire(op.Key)
+
+		responses[i] = TxnOpResult{Type: op.Type, Key: op.Key}
+		switch op.Type {
+		case "SET":
+			sm.setKey(op.Key, op.Value, op.Meta, op.TTLSeconds, entry)
+			responses[i].Exists = true
+			responses[i].Version = sm.versions[op.Key]
+		case "DELETE":
+			_, responses[i].Exists = sm.data[op.Key]
+			sm.deleteKey(op.Key)
+		case "GET":
+			// 压缩存储的值解压后返回，无法解压时返回存储的值
+			value, _, exists, err := sm.valueLocked(op.Key)
+			if err != nil {
+				value = sm.data[op.Key]
+			}
+			responses[i].Value, responses[i].Exists = value, exists
+			responses[i].Version = sm.versions[op.Key]
+		}
+	}
+
+	return &CommandResult{Succeeded: succeeded, Responses: responses}, nil
+}
+
+// evalCompare 求值单个比较条件（调用方需持有锁）
+func (sm *KVStateMachine) evalCompare(cmp *TxnCompare) bool {
+	if cmp.Target == TxnTargetVersion {
+		return compareOrdered(sm.versions[cmp.Key], cmp.Version, cmp.Op)
+	}
+
+	// 与解压后的值比较，无法解压的值比较不成立
+	current, _, exists, err := sm.valueLocked(cmp.Key)
+	if !exists || err != nil {
+		return false
+	}
+	switch cmp.Op {
+	case "=":
+		return reflect.DeepEqual(current, cmp.Value)
+	case "!=":
+		return !reflect.DeepEqual(current, cmp.Value)
+	}
+
+	// <与>只比较同为数值或同为字符串的值，其余类型比较不成立
+	switch a := current.(type) {
+	case float64:
+		if b, ok := cmp.Value.(float64); ok {
+			return compareOrdered(a, b, cmp.Op)
+		}
+	case string:
+		if b, ok := cmp.Value.(string); ok {
+			return compareOrdered(strings.Compare(a, b), 0, cmp.Op)
+		}
+	}
+	return false
+}
+
+// compareOrdered 按运算符比较两个有序值
+func compareOrdered[T uint64 | float64 | int](a, b T, op string) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case ">":
+		return a > b
+	default:
+		return false
+	}
+}
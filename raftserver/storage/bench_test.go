@@ -0,0 +1,144 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-14 09:32:18
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-14 09:32:18
+* @Description: ConcordKV Raft consensus server - bench_test.go
+ */
+package storage
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/raft"
+)
+
+// benchBackends 参与基准测试的存储后端
+var benchBackends = []Backend{BackendMemory, BackendWAL, BackendFile}
+
+// openBench 在临时目录中打开指定后端，wal后端使用always策略以便与file后端比较落盘开销
+func openBench(b *testing.B, backend Backend, dir string) LogStorage {
+	b.Helper()
+	store, err := Open(Options{Backend: backend, Dir: dir, SyncPolicy: SyncAlways, Logger: logging.Nop()})
+	if err != nil {
+		b.Fatalf("打开%s存储失败: %v", backend, err)
+	}
+	return store
+}
+
+// benchEntry 构造数据长度为size的日志条目
+func benchEntry(index raft.LogIndex, size int) raft.LogEntry {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = 'a' + byte(i%26)
+	}
+	return raft.LogEntry{Index: index, Term: 1, Timestamp: time.Now(), Type: raft.EntryNormal, Data: data}
+}
+
+// BenchmarkAppendEntries 不同条目大小下逐条追加的吞吐量，每次追加返回前已按后端语义落盘
+func BenchmarkAppendEntries(b *testing.B) {
+	for _, backend := range benchBackends {
+		for _, size := range []int{64, 1024, 16 << 10} {
+			b.Run(fmt.Sprintf("%s/%dB", backend, size), func(b *testing.B) {
+				store := openBench(b, backend, b.TempDir())
+				defer store.Close()
+
+				entry := benchEntry(0, size)
+				b.SetBytes(int64(size))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					entry.Index = raft.LogIndex(i + 1)
+					if err := store.SaveLogEntries([]raft.LogEntry{entry}); err != nil {
+						b.Fatalf("追加日志失败: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkGetLogEntry 在一万个条目中随机读取单个条目的延迟
+func BenchmarkGetLogEntry(b *testing.B) {
+	const entries = 10000
+
+	for _, backend := range benchBackends {
+		b.Run(string(backend), func(b *testing.B) {
+			store := openBench(b, backend, b.TempDir())
+			defer store.Close()
+
+			batch := make([]raft.LogEntry, 0, 100)
+			for i := 1; i <= entries; i++ {
+				batch = append(batch, benchEntry(raft.LogIndex(i), 256))
+				if len(batch) == cap(batch) {
+					if err := store.SaveLogEntries(batch); err != nil {
+						b.Fatalf("追加日志失败: %v", err)
+					}
+					batch = batch[:0]
+				}
+			}
+
+			rng := rand.New(rand.NewSource(1))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				index := raft.LogIndex(rng.Intn(entries) + 1)
+				if _, err := store.GetLogEntry(index); err != nil {
+					b.Fatalf("读取日志 %d 失败: %v", index, err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSnapshot 保存1MB快照的耗时，以及持久化后端重启时加载快照与剩余日志的耗时
+func BenchmarkSnapshot(b *testing.B) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	for _, backend := range benchBackends {
+		b.Run(fmt.Sprintf("save/%s", backend), func(b *testing.B) {
+			store := openBench(b, backend, b.TempDir())
+			defer store.Close()
+
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				index := raft.LogIndex(i + 1)
+				if err := store.SaveLogEntries([]raft.LogEntry{benchEntry(index, 64)}); err != nil {
+					b.Fatalf("追加日志失败: %v", err)
+				}
+				if err := store.SaveSnapshot(&raft.Snapshot{LastIncludedIndex: index, LastIncludedTerm: 1, Data: data}); err != nil {
+					b.Fatalf("保存快照失败: %v", err)
+				}
+			}
+		})
+
+		if !backend.Persistent() {
+			continue
+		}
+		b.Run(fmt.Sprintf("load/%s", backend), func(b *testing.B) {
+			dir := b.TempDir()
+			store := openBench(b, backend, dir)
+			for i := 1; i <= 1000; i++ {
+				store.SaveLogEntries([]raft.LogEntry{benchEntry(raft.LogIndex(i), 256)})
+			}
+			if err := store.SaveSnapshot(&raft.Snapshot{LastIncludedIndex: 500, LastIncludedTerm: 1, Data: data}); err != nil {
+				b.Fatalf("保存快照失败: %v", err)
+			}
+			store.Close()
+
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				store := openBench(b, backend, dir)
+				if snapshot, err := store.GetSnapshot(); err != nil || len(snapshot.Data) != len(data) {
+					b.Fatalf("加载快照失败: %v", err)
+				}
+				store.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-14 09:32:18
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-14 09:32:18
+* @Description: ConcordKV Raft consensus server - factory.go
+ */
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/raft"
+)
+
+// Backend 日志存储后端
+type Backend string
+
+const (
+	// BackendMemory 内存存储，重启后任期、投票与日志全部丢失，仅用于测试
+	BackendMemory Backend = "memory"
+
+	// BackendWAL 单文件预写日志，日志全部保存在内存中，保存快照时重写WAL
+	BackendWAL Backend = "wal"
+
+	// BackendFile 分段日志文件，内存只保留条目的偏移与任期
+	BackendFile Backend = "file"
+)
+
+// LogStorage 服务器使用的日志存储，在raft.Storage之外提供调试信息
+type LogStorage interface {
+	raft.Storage
+
+	// GetLogStats 获取日志统计信息
+	GetLogStats() map[string]interface{}
+
+	// DebugLogs 获取日志内容（用于调试）
+	DebugLogs() string
+}
+
+// Options 打开存储的选项
+type Options struct {
+	Backend      Backend        // 存储后端
+	Dir          string         // 数据目录，持久化后端必须指定
+	SyncPolicy   SyncPolicy     // wal后端的刷盘策略
+	SyncInterval time.Duration  // wal后端interval策略的刷盘间隔
+	SegmentSize  int64          // file后端的日志段大小上限
+	Logger       logging.Logger // 日志
+}
+
+// ParseBackend 解析存储后端名称
+func ParseBackend(s string) (Backend, error) {
+	switch Backend(s) {
+	case BackendMemory, BackendWAL, BackendFile:
+		return Backend(s), nil
+	default:
+		return "", fmt.Errorf("不支持的存储后端: %q（可选 memory、wal、file）", s)
+	}
+}
+
+// Persistent 后端是否在重启后保留任期、投票与日志
+func (b Backend) Persistent() bool {
+	return b != BackendMemory
+}
+
+// Open 按选项打开存储
+// wal与file的数据格式不同：file后端会导入目录中已有的WAL数据，wal后端拒绝打开file后端的目录
+func Open(opts Options) (LogStorage, error) {
+	backend, err := ParseBackend(string(opts.Backend))
+	if err != nil {
+		return nil, err
+	}
+	if backend.Persistent() && opts.Dir == "" {
+		return nil, fmt.Errorf("%s存储需要指定数据目录", backend)
+	}
+
+	switch backend {
+	case BackendWAL:
+		if isFileStorageDir(opts.Dir) {
+			return nil, fmt.Errorf("数据目录 %s 由file存储创建，不能以wal存储打开；请使用 storage: file", opts.Dir)
+		}
+		return NewWALStorage(opts.Dir, WALOptions{
+			SyncPolicy:   opts.SyncPolicy,
+			SyncInterval: opts.SyncInterval,
+			Logger:       opts.Logger,
+		})
+	case BackendFile:
+		return NewFileStorage(opts.Dir, FileOptions{
+			SegmentSize: opts.SegmentSize,
+			Logger:      opts.Logger,
+		})
+	default:
+		return NewMemoryStorage(), nil
+	}
+}
+
+// isFileStorageDir 目录中是否存在file存储的任期文件或日志段
+func isFileStorageDir(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, fileStateName)); err == nil {
+		return true
+	}
+	firsts, err := listSegments(filepath.Join(dir, segmentDirName))
+	return err != nil || len(firsts) > 0
+}
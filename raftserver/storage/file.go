@@ -0,0 +1,993 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-14 09:32:18
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-14 09:32:18
+* @Description: ConcordKV Raft consensus server - file.go
+ */
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"raftserver/logging"
+	"raftserver/raft"
+)
+
+// DefaultSegmentSize 日志段的默认大小上限，超过后滚动到新的段
+const DefaultSegmentSize = 64 << 20
+
+const (
+	fileStateName    = "state.json"
+	segmentDirName   = "segments"
+	segmentExt       = ".seg"
+	segmentIndexExt  = ".idx"
+	migratedWALName  = walFileName + ".migrated"
+	debugLogsLimit   = 100
+	segmentIndexSize = 16 // 每个条目在索引文件中占用的字节：偏移(8字节) + 任期(8字节)
+
+	// 段内记录类型，记录格式与WAL相同
+	segmentRecordEntry byte = 1
+)
+
+// ErrStorageClosed 存储已关闭
+var ErrStorageClosed = errors.New("存储已关闭")
+
+// FileOptions 文件存储选项
+type FileOptions struct {
+	SegmentSize int64          // 日志段大小上限，默认DefaultSegmentSize
+	Logger      logging.Logger // 日志，为nil时使用logging.Default()
+}
+
+// fileState 持久化的任期与投票
+type fileState struct {
+	CurrentTerm raft.Term   `json:"currentTerm"`
+	VotedFor    raft.NodeID `json:"votedFor"`
+}
+
+// segment 一个日志段文件，保存从first开始的连续条目
+type segment struct {
+	first   raft.LogIndex
+	file    *os.File
+	offsets []int64     // 各条目记录在文件中的偏移
+	terms   []raft.Term // 各条目的任期
+	size    int64       // 文件中有效数据的长度
+}
+
+// last 段内最后一个条目的索引
+func (s *segment) last() raft.LogIndex {
+	return s.first + raft.LogIndex(len(s.offsets)) - 1
+}
+
+// recordEnd 段内第i个条目记录的结束偏移
+func (s *segment) recordEnd(i int) int64 {
+	if i+1 < len(s.offsets) {
+		return s.offsets[i+1]
+	}
+	return s.size
+}
+
+// FileStorage 基于分段日志文件的持久化存储
+// 日志条目按索引顺序追加到固定大小上限的段文件中，内存只保留各条目的偏移与任期，读取时按偏移从文件读取；
+// 已写满的段附带索引文件以加快启动，最后一个段在启动时完整扫描并截断崩溃时未写完的记录；
+// 任期与投票、快照分别原子写入独立文件，每次修改在返回前落盘
+type FileStorage struct {
+	mu          sync.RWMutex
+	dir         string
+	segmentDir  string
+	segmentSize int64
+	logger      logging.Logger
+
+	state      fileState
+	snapshot   *raft.Snapshot
+	firstIndex raft.LogIndex // 快照之后第一个日志条目的索引
+	segments   []*segment
+	closed     bool
+	err        error // 写入或落盘失败后文件状态不可信，之后的修改全部失败
+}
+
+// NewFileStorage 打开或创建dir下的文件存储
+// 目录中存在WAL存储的数据时，先将其导入分段日志，完成后把WAL文件重命名为wal.log.migrated
+func NewFileStorage(dir string, opts FileOptions) (*FileStorage, error) {
+	segmentSize := opts.SegmentSize
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+
+	f := &FileStorage{
+		dir:         dir,
+		segmentDir:  filepath.Join(dir, segmentDirName),
+		segmentSize: segmentSize,
+		logger:      logging.Component(opts.Logger, "file_storage"),
+		firstIndex:  1,
+	}
+
+	if err := os.MkdirAll(f.segmentDir, 0755); err != nil {
+		return nil, fmt.Errorf("创建数据目录失败: %w", err)
+	}
+
+	_, err := os.Stat(filepath.Join(dir, walFileName))
+	migrate := err == nil
+	if migrate {
+		// 上次迁移未完成时丢弃已导入的部分，从WAL重新导入
+		if err := f.resetForMigration(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := f.load(); err != nil {
+		f.closeSegments()
+		return nil, err
+	}
+
+	if migrate {
+		if err := f.migrateWAL(opts.Logger); err != nil {
+			f.closeSegments()
+			return nil, fmt.Errorf("从WAL迁移失败: %w", err)
+		}
+	}
+
+	return f, nil
+}
+
+// SaveCurrentTerm 保存当前任期号
+func (f *FileStorage) SaveCurrentTerm(term raft.Term) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	state := f.state
+	state.CurrentTerm = term
+	return f.saveStateLocked(state)
+}
+
+// GetCurrentTerm 获取当前任期号
+func (f *FileStorage) GetCurrentTerm() (raft.Term, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.state.CurrentTerm, nil
+}
+
+// SaveVotedFor 保存投票给的候选人
+func (f *FileStorage) SaveVotedFor(candidateID raft.NodeID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	state := f.state
+	state.VotedFor = candidateID
+	return f.saveStateLocked(state)
+}
+
+// GetVotedFor 获取投票给的候选人
+func (f *FileStorage) GetVotedFor() (raft.NodeID, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.state.VotedFor, nil
+}
+
+// SaveLogEntries 保存日志条目
+// 已被快照覆盖或已存在且任期相同的条目被跳过，任期冲突时先截断冲突条目及其后的日志；
+// 条目必须紧接在最后一个日志条目之后，不允许留下空洞
+func (f *FileStorage) SaveLogEntries(entries []raft.LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.saveEntriesLocked(entries)
+}
+
+// saveEntriesLocked 保存日志条目（调用方需持有写锁）
+func (f *FileStorage) saveEntriesLocked(entries []raft.LogEntry) error {
+	if err := f.writableLocked(); err != nil {
+		return err
+	}
+
+	pending := make([]raft.LogEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Index < f.firstIndex {
+			continue
+		}
+
+		next := f.lastIndexLocked() + 1
+		if len(pending) > 0 {
+			next = pending[len(pending)-1].Index + 1
+		}
+
+		if entry.Index < next {
+			if len(pending) > 0 {
+				return fmt.Errorf("日志条目 %d 的索引不是递增的", entry.Index)
+			}
+			if term, ok := f.termAtLocked(entry.Index); ok && term == entry.Term {
+				continue
+			}
+			if err := f.truncateLocked(entry.Index - 1); err != nil {
+				return err
+			}
+			next = entry.Index
+		}
+
+		if entry.Index != next {
+			return fmt.Errorf("日志条目 %d 不连续，期望索引 %d", entry.Index, next)
+		}
+		pending = append(pending, entry)
+	}
+
+	return f.appendLocked(pending)
+}
+
+// GetLogEntry 获取指定索引的日志条目
+func (f *FileStorage) GetLogEntry(index raft.LogIndex) (*raft.LogEntry, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.closed {
+		return nil, ErrStorageClosed
+	}
+	if f.snapshot != nil && index <= f.snapshot.LastIncludedIndex {
+		return nil, fmt.Errorf("日志条目 %d 已被快照包含", index)
+	}
+	if index < f.firstIndex {
+		return nil, fmt.Errorf("日志索引 %d 小于第一个日志索引 %d", index, f.firstIndex)
+	}
+
+	seg, i := f.locateLocked(index)
+	if seg == nil {
+		return nil, fmt.Errorf("日志索引 %d 超出范围", index)
+	}
+
+	entries, err := seg.read(i, i+1)
+	if err != nil {
+		return nil, err
+	}
+	return &entries[0], nil
+}
+
+// GetLogEntries 获取指定范围的日志条目，范围内不存在的条目被跳过
+func (f *FileStorage) GetLogEntries(start, end raft.LogIndex) ([]raft.LogEntry, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if start > end {
+		return nil, fmt.Errorf("起始索引 %d 大于结束索引 %d", start, end)
+	}
+	if f.closed {
+		return nil, ErrStorageClosed
+	}
+
+	if start < f.firstIndex {
+		start = f.firstIndex
+	}
+	if last := f.lastIndexLocked(); end > last {
+		end = last
+	}
+
+	var entries []raft.LogEntry
+	for _, seg := range f.segments {
+		if seg.last() < start || seg.first > end {
+			continue
+		}
+		from, to := start, end
+		if from < seg.first {
+			from = seg.first
+		}
+		if to > seg.last() {
+			to = seg.last()
+		}
+
+		batch, err := seg.read(int(from-seg.first), int(to-seg.first)+1)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, batch...)
+	}
+
+	return entries, nil
+}
+
+// GetLastLogIndex 获取最后一个日志索引
+func (f *FileStorage) GetLastLogIndex() raft.LogIndex {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.lastIndexLocked()
+}
+
+// GetLastLogTerm 获取最后一个日志的任期号
+func (f *FileStorage) GetLastLogTerm() raft.Term {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.lastTermLocked()
+}
+
+// TruncateLog 截断日志（删除指定索引之后的所有条目）
+func (f *FileStorage) TruncateLog(index raft.LogIndex) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.writableLocked(); err != nil {
+		return err
+	}
+	return f.truncateLocked(index)
+}
+
+// SaveSnapshot 保存快照，并删除完全被快照覆盖的日志段
+// 快照文件先于日志段的删除落盘，崩溃后重新打开时会补做删除
+func (f *FileStorage) SaveSnapshot(snapshot *raft.Snapshot) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.writableLocked(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("序列化快照失败: %w", err)
+	}
+	if err := writeFileAtomic(f.dir, snapshotFileName, data); err != nil {
+		return f.failLocked(fmt.Errorf("保存快照文件失败: %w", err))
+	}
+
+	f.snapshot = snapshot
+	f.firstIndex = snapshot.LastIncludedIndex + 1
+	return f.compactLocked()
+}
+
+// GetSnapshot 获取快照
+func (f *FileStorage) GetSnapshot() (*raft.Snapshot, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.snapshot == nil {
+		return nil, fmt.Errorf("快照不存在")
+	}
+	return f.snapshot, nil
+}
+
+// Close 关闭所有日志段文件
+func (f *FileStorage) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	return f.closeSegments()
+}
+
+// GetLogStats 获取日志统计信息（用于调试）
+func (f *FileStorage) GetLogStats() map[string]interface{} {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.statsLocked()
+}
+
+// DebugLogs 获取统计信息与最近的日志条目（用于调试）
+func (f *FileStorage) DebugLogs() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var logs []raft.LogEntry
+	if last := f.lastIndexLocked(); last >= f.firstIndex {
+		start := f.firstIndex
+		if last-start >= debugLogsLimit {
+			start = last - debugLogsLimit + 1
+		}
+		for _, seg := range f.segments {
+			if seg.last() < start {
+				continue
+			}
+			from := start
+			if from < seg.first {
+				from = seg.first
+			}
+			batch, err := seg.read(int(from-seg.first), len(seg.offsets))
+			if err != nil {
+				break
+			}
+			logs = append(logs, batch...)
+		}
+	}
+
+	data, _ := json.MarshalIndent(map[string]interface{}{
+		"stats": f.statsLocked(),
+		"logs":  logs,
+	}, "", "  ")
+
+	return string(data)
+}
+
+// statsLocked 汇总日志统计信息（调用方需持有读锁）
+func (f *FileStorage) statsLocked() map[string]interface{} {
+	var count int
+	var size int64
+	for _, seg := range f.segments {
+		count += len(seg.offsets)
+		size += seg.size
+	}
+
+	stats := map[string]interface{}{
+		"backend":       string(BackendFile),
+		"currentTerm":   f.state.CurrentTerm,
+		"votedFor":      f.state.VotedFor,
+		"firstLogIndex": f.firstIndex,
+		"logCount":      count,
+		"lastLogIndex":  f.lastIndexLocked(),
+		"lastLogTerm":   f.lastTermLocked(),
+		"hasSnapshot":   f.snapshot != nil,
+		"segments":      len(f.segments),
+		"segmentBytes":  size,
+	}
+
+	if f.snapshot != nil {
+		stats["snapshotLastIndex"] = f.snapshot.LastIncludedIndex
+		stats["snapshotLastTerm"] = f.snapshot.LastIncludedTerm
+	}
+
+	return stats
+}
+
+// lastIndexLocked 最后一个日志条目的索引，没有日志时为快照的最后索引
+func (f *FileStorage) lastIndexLocked() raft.LogIndex {
+	if n := len(f.segments); n > 0 {
+		return f.segments[n-1].last()
+	}
+	return f.firstIndex - 1
+}
+
+// lastTermLocked 最后一个日志条目的任期，没有日志时为快照的最后任期
+func (f *FileStorage) lastTermLocked() raft.Term {
+	if n := len(f.segments); n > 0 {
+		seg := f.segments[n-1]
+		return seg.terms[len(seg.terms)-1]
+	}
+	if f.snapshot != nil {
+		return f.snapshot.LastIncludedTerm
+	}
+	return 0
+}
+
+// termAtLocked 获取日志中指定索引的任期
+func (f *FileStorage) termAtLocked(index raft.LogIndex) (raft.Term, bool) {
+	seg, i := f.locateLocked(index)
+	if seg == nil {
+		return 0, false
+	}
+	return seg.terms[i], true
+}
+
+// locateLocked 查找包含index的日志段及条目在段内的位置
+func (f *FileStorage) locateLocked(index raft.LogIndex) (*segment, int) {
+	n := sort.Search(len(f.segments), func(i int) bool {
+		return f.segments[i].last() >= index
+	})
+	if n == len(f.segments) || f.segments[n].first > index {
+		return nil, 0
+	}
+	seg := f.segments[n]
+	return seg, int(index - seg.first)
+}
+
+// writableLocked 检查存储是否可写
+func (f *FileStorage) writableLocked() error {
+	if f.closed {
+		return ErrStorageClosed
+	}
+	return f.err
+}
+
+// failLocked 记录不可恢复的错误
+func (f *FileStorage) failLocked(err error) error {
+	if f.err == nil {
+		f.err = err
+		f.logger.Error("文件存储写入失败，拒绝后续修改", logging.FieldError, err)
+	}
+	return f.err
+}
+
+// saveStateLocked 原子写入任期与投票
+func (f *FileStorage) saveStateLocked(state fileState) error {
+	if err := f.writableLocked(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("序列化任期与投票失败: %w", err)
+	}
+	if err := writeFileAtomic(f.dir, fileStateName, data); err != nil {
+		return f.failLocked(fmt.Errorf("保存任期与投票失败: %w", err))
+	}
+	f.state = state
+	return nil
+}
+
+// appendLocked 将连续的条目追加到最后一个日志段，段写满后滚动，返回前对写入的段执行fsync
+func (f *FileStorage) appendLocked(entries []raft.LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	var active *segment
+	if n := len(f.segments); n > 0 {
+		active = f.segments[n-1]
+	}
+
+	var buf []byte
+	var offsets []int64
+	var terms []raft.Term
+	for _, entry := range entries {
+		payload, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("序列化日志条目 %d 失败: %w", entry.Index, err)
+		}
+
+		if active == nil || active.size+int64(len(buf)) >= f.segmentSize {
+			if err := f.flushLocked(active, buf, offsets, terms); err != nil {
+				return err
+			}
+			if active != nil {
+				if err := f.sealLocked(active); err != nil {
+					return err
+				}
+			}
+			if active, err = f.createSegmentLocked(entry.Index); err != nil {
+				return err
+			}
+			buf, offsets, terms = buf[:0], nil, nil
+		}
+
+		offsets = append(offsets, active.size+int64(len(buf)))
+		terms = append(terms, entry.Term)
+		buf = append(buf, encodeWALRecord(segmentRecordEntry, payload)...)
+	}
+
+	return f.flushLocked(active, buf, offsets, terms)
+}
+
+// flushLocked 把缓冲的记录写入段并落盘，成功后才更新段的内存索引
+func (f *FileStorage) flushLocked(seg *segment, buf []byte, offsets []int64, terms []raft.Term) error {
+	if seg == nil || len(buf) == 0 {
+		return nil
+	}
+
+	if _, err := seg.file.WriteAt(buf, seg.size); err != nil {
+		return f.failLocked(fmt.Errorf("写入日志段失败: %w", err))
+	}
+	if err := seg.file.Sync(); err != nil {
+		return f.failLocked(fmt.Errorf("日志段落盘失败: %w", err))
+	}
+
+	seg.offsets = append(seg.offsets, offsets...)
+	seg.terms = append(seg.terms, terms...)
+	seg.size += int64(len(buf))
+	return nil
+}
+
+// createSegmentLocked 创建从first开始的新日志段
+func (f *FileStorage) createSegmentLocked(first raft.LogIndex) (*segment, error) {
+	file, err := os.OpenFile(f.segmentPath(first, segmentExt), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, f.failLocked(fmt.Errorf("创建日志段失败: %w", err))
+	}
+	if err := syncDir(f.segmentDir); err != nil {
+		file.Close()
+		return nil, f.failLocked(fmt.Errorf("创建日志段失败: %w", err))
+	}
+
+	seg := &segment{first: first, file: file}
+	f.segments = append(f.segments, seg)
+	return seg, nil
+}
+
+// sealLocked 为写满的日志段写入索引文件
+func (f *FileStorage) sealLocked(seg *segment) error {
+	if err := writeFileAtomic(f.segmentDir, filepath.Base(f.segmentPath(seg.first, segmentIndexExt)), encodeSegmentIndex(seg)); err != nil {
+		return f.failLocked(fmt.Errorf("写入日志段索引失败: %w", err))
+	}
+	return nil
+}
+
+// truncateLocked 删除index之后的所有条目
+// 先从后往前删除整个段，再截断包含index的段，任一步骤崩溃后日志都是截断前日志的前缀
+func (f *FileStorage) truncateLocked(index raft.LogIndex) error {
+	if index >= f.lastIndexLocked() {
+		return nil
+	}
+
+	for len(f.segments) > 0 {
+		seg := f.segments[len(f.segments)-1]
+		if seg.first <= index {
+			break
+		}
+		if err := f.removeSegmentLocked(seg); err != nil {
+			return err
+		}
+		f.segments = f.segments[:len(f.segments)-1]
+	}
+
+	if n := len(f.segments); n > 0 && f.segments[n-1].last() > index {
+		seg := f.segments[n-1]
+		keep := int(index-seg.first) + 1
+		size := seg.offsets[keep]
+
+		// 截断后该段成为最后一个段，启动时会完整扫描，不再需要索引文件
+		if err := os.Remove(f.segmentPath(seg.first, segmentIndexExt)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return f.failLocked(fmt.Errorf("删除日志段索引失败: %w", err))
+		}
+		if err := seg.file.Truncate(size); err != nil {
+			return f.failLocked(fmt.Errorf("截断日志段失败: %w", err))
+		}
+		if err := seg.file.Sync(); err != nil {
+			return f.failLocked(fmt.Errorf("日志段落盘失败: %w", err))
+		}
+		seg.offsets = seg.offsets[:keep]
+		seg.terms = seg.terms[:keep]
+		seg.size = size
+	}
+
+	if err := syncDir(f.segmentDir); err != nil {
+		return f.failLocked(fmt.Errorf("同步日志段目录失败: %w", err))
+	}
+
+	// 截断到快照之前时，剩余的段已完全被快照覆盖
+	return f.compactLocked()
+}
+
+// compactLocked 从前往后删除完全被快照覆盖的日志段
+func (f *FileStorage) compactLocked() error {
+	removed := 0
+	for _, seg := range f.segments {
+		if seg.last() >= f.firstIndex {
+			break
+		}
+		if err := f.removeSegmentLocked(seg); err != nil {
+			f.segments = f.segments[removed:]
+			return err
+		}
+		removed++
+	}
+	if removed == 0 {
+		return nil
+	}
+	f.segments = f.segments[removed:]
+
+	if err := syncDir(f.segmentDir); err != nil {
+		return f.failLocked(fmt.Errorf("同步日志段目录失败: %w", err))
+	}
+	return nil
+}
+
+// removeSegmentLocked 关闭并删除日志段及其索引文件
+func (f *FileStorage) removeSegmentLocked(seg *segment) error {
+	seg.file.Close()
+	for _, ext := range []string{segmentIndexExt, segmentExt} {
+		if err := os.Remove(f.segmentPath(seg.first, ext)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return f.failLocked(fmt.Errorf("删除日志段失败: %w", err))
+		}
+	}
+	return nil
+}
+
+// closeSegments 关闭所有日志段文件
+func (f *FileStorage) closeSegments() error {
+	var firstErr error
+	for _, seg := range f.segments {
+		if err := seg.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// segmentPath 段文件或其索引文件的路径
+func (f *FileStorage) segmentPath(first raft.LogIndex, ext string) string {
+	return segmentFilePath(f.segmentDir, first, ext)
+}
+
+// segmentFilePath 以段内第一个条目的索引命名段文件，文件名按字典序即按索引排序
+func segmentFilePath(segmentDir string, first raft.LogIndex, ext string) string {
+	return filepath.Join(segmentDir, fmt.Sprintf("%020d%s", first, ext))
+}
+
+// load 恢复任期、投票、快照与日志段
+func (f *FileStorage) load() error {
+	if data, err := os.ReadFile(filepath.Join(f.dir, fileStateName)); err == nil {
+		if err := json.Unmarshal(data, &f.state); err != nil {
+			return fmt.Errorf("解析任期与投票失败: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("读取任期与投票失败: %w", err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(f.dir, snapshotFileName)); err == nil {
+		var snapshot raft.Snapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return fmt.Errorf("解析快照文件失败: %w", err)
+		}
+		f.snapshot = &snapshot
+		f.firstIndex = snapshot.LastIncludedIndex + 1
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("读取快照文件失败: %w", err)
+	}
+
+	firsts, err := listSegments(f.segmentDir)
+	if err != nil {
+		return err
+	}
+
+	for i, first := range firsts {
+		sealed := i < len(firsts)-1
+		seg, err := f.openSegment(first, sealed)
+		if err != nil {
+			return err
+		}
+		if len(seg.offsets) == 0 {
+			// 创建后尚未写入任何条目的段
+			if sealed {
+				seg.file.Close()
+				return fmt.Errorf("日志段 %d 为空", first)
+			}
+			if err := f.removeSegmentLocked(seg); err != nil {
+				return err
+			}
+			continue
+		}
+		if n := len(f.segments); n > 0 && f.segments[n-1].last()+1 != seg.first {
+			seg.file.Close()
+			return fmt.Errorf("日志段不连续: 段 %d 之后是段 %d", f.segments[n-1].first, seg.first)
+		}
+		f.segments = append(f.segments, seg)
+	}
+
+	// 保存快照后崩溃时被覆盖的段可能尚未删除
+	if err := f.compactLocked(); err != nil {
+		return err
+	}
+	if len(f.segments) > 0 && f.segments[0].first > f.firstIndex {
+		return fmt.Errorf("日志缺少条目 %d 到 %d", f.firstIndex, f.segments[0].first-1)
+	}
+
+	if len(f.segments) > 0 {
+		f.logger.Info("从日志段恢复", "segments", len(f.segments), "first_index", f.firstIndex, "last_index", f.lastIndexLocked())
+	}
+	return nil
+}
+
+// openSegment 打开日志段并重建内存索引
+// 已写满的段优先使用索引文件，索引缺失或与段不符时扫描段文件，段内的任何损坏都拒绝打开；
+// 最后一个段总是扫描，尾部不完整或校验失败的记录视为崩溃时未写完的追加，截断后继续使用，
+// 损坏之后仍有完整的记录时说明已确认的条目被破坏，返回ErrLogCorrupted
+func (f *FileStorage) openSegment(first raft.LogIndex, sealed bool) (*segment, error) {
+	path := f.segmentPath(first, segmentExt)
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("打开日志段失败: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("读取日志段信息失败: %w", err)
+	}
+
+	seg := &segment{first: first, file: file}
+	if sealed && loadSegmentIndex(f.segmentPath(first, segmentIndexExt), seg, info.Size()) {
+		return seg, nil
+	}
+
+	scan, err := scanRecords(file, info.Size(), isSegmentRecord, func(offset int64, _ byte, payload []byte) error {
+		term, err := decodeSegmentEntry(payload, seg.first+raft.LogIndex(len(seg.offsets)))
+		if err != nil {
+			return err
+		}
+		seg.offsets = append(seg.offsets, offset)
+		seg.terms = append(seg.terms, term)
+		return nil
+	})
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("读取日志段 %d 失败: %w", first, err)
+	}
+	seg.size = scan.valid
+
+	if scan.err != nil {
+		switch {
+		case sealed:
+			file.Close()
+			return nil, corruptionError(path, &scan, "该段之后还有日志段")
+		case !scan.tail:
+			file.Close()
+			return nil, corruptionError(path, &scan, scan.followedDetail())
+		}
+
+		f.logger.Warn("日志段尾部存在不完整的记录，截断尾部",
+			"segment", first, "offset", scan.valid, "dropped_bytes", scan.dropped(info.Size()),
+			"first_dropped_index", seg.first+raft.LogIndex(len(seg.offsets)), logging.FieldError, scan.err)
+		if err := truncateTail(file, scan.valid); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("日志段 %d %w", first, err)
+		}
+	}
+
+	if sealed {
+		if err := writeFileAtomic(f.segmentDir, filepath.Base(f.segmentPath(first, segmentIndexExt)), encodeSegmentIndex(seg)); err != nil {
+			f.logger.Warn("重建日志段索引失败", "segment", first, logging.FieldError, err)
+		}
+	}
+	return seg, nil
+}
+
+// isSegmentRecord 段内唯一的记录类型是日志条目
+func isSegmentRecord(recordType byte) bool {
+	return recordType == segmentRecordEntry
+}
+
+// decodeSegmentEntry 解析段内的日志条目记录并检查其索引与位置一致，返回条目的任期
+func decodeSegmentEntry(payload []byte, want raft.LogIndex) (raft.Term, error) {
+	var entry raft.LogEntry
+	if err := json.Unmarshal(payload, &entry); err != nil {
+		return 0, fmt.Errorf("%w: 解析日志条目失败: %v", errWALCorrupt, err)
+	}
+	if entry.Index != want {
+		return 0, fmt.Errorf("%w: 条目索引 %d 与位置不符，应为 %d", errWALCorrupt, entry.Index, want)
+	}
+	return entry.Term, nil
+}
+
+// read 读取段内第from到第to-1个条目
+func (s *segment) read(from, to int) ([]raft.LogEntry, error) {
+	start, end := s.offsets[from], s.recordEnd(to-1)
+	buf := make([]byte, end-start)
+	if _, err := s.file.ReadAt(buf, start); err != nil {
+		return nil, fmt.Errorf("读取日志段 %d 失败: %w", s.first, err)
+	}
+
+	reader := bytes.NewReader(buf)
+	entries := make([]raft.LogEntry, 0, to-from)
+	for i := from; i < to; i++ {
+		_, payload, err := readWALRecord(reader)
+		if err != nil {
+			return nil, fmt.Errorf("读取日志条目 %d 失败: %w", s.first+raft.LogIndex(i), err)
+		}
+		var entry raft.LogEntry
+		if err := json.Unmarshal(payload, &entry); err != nil {
+			return nil, fmt.Errorf("解析日志条目 %d 失败: %w", s.first+raft.LogIndex(i), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// listSegments 列出目录中的日志段，按第一个条目的索引升序返回
+func listSegments(dir string) ([]raft.LogIndex, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+
+	firsts := make([]raft.LogIndex, 0, len(names))
+	for _, name := range names {
+		base := strings.TrimSuffix(filepath.Base(name), segmentExt)
+		first, err := strconv.ParseUint(base, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("无法识别的日志段文件: %s", name)
+		}
+		firsts = append(firsts, raft.LogIndex(first))
+	}
+	sort.Slice(firsts, func(i, j int) bool { return firsts[i] < firsts[j] })
+	return firsts, nil
+}
+
+// encodeSegmentIndex 编码段索引：段长度(8字节) + 各条目的偏移与任期 + CRC32(4字节)
+func encodeSegmentIndex(seg *segment) []byte {
+	buf := make([]byte, 8+len(seg.offsets)*segmentIndexSize+4)
+	binary.LittleEndian.PutUint64(buf[0:8], uint64(seg.size))
+	for i, offset := range seg.offsets {
+		pos := 8 + i*segmentIndexSize
+		binary.LittleEndian.PutUint64(buf[pos:pos+8], uint64(offset))
+		binary.LittleEndian.PutUint64(buf[pos+8:pos+16], uint64(seg.terms[i]))
+	}
+	body := buf[:len(buf)-4]
+	binary.LittleEndian.PutUint32(buf[len(body):], crc32.Checksum(body, walCRCTable))
+	return buf
+}
+
+// loadSegmentIndex 从索引文件恢复段的内存索引，索引缺失、损坏或与段文件长度不符时返回false
+func loadSegmentIndex(path string, seg *segment, size int64) bool {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) < 12 || (len(data)-12)%segmentIndexSize != 0 {
+		return false
+	}
+	body := data[:len(data)-4]
+	if crc32.Checksum(body, walCRCTable) != binary.LittleEndian.Uint32(data[len(body):]) {
+		return false
+	}
+	if int64(binary.LittleEndian.Uint64(body[0:8])) != size {
+		return false
+	}
+
+	count := (len(body) - 8) / segmentIndexSize
+	seg.offsets = make([]int64, count)
+	seg.terms = make([]raft.Term, count)
+	for i := 0; i < count; i++ {
+		pos := 8 + i*segmentIndexSize
+		seg.offsets[i] = int64(binary.LittleEndian.Uint64(body[pos : pos+8]))
+		seg.terms[i] = raft.Term(binary.LittleEndian.Uint64(body[pos+8 : pos+16]))
+	}
+	seg.size = size
+	return true
+}
+
+// resetForMigration 删除上次未完成的迁移留下的任期文件与日志段
+func (f *FileStorage) resetForMigration() error {
+	firsts, err := listSegments(f.segmentDir)
+	if err != nil {
+		return err
+	}
+	if len(firsts) > 0 {
+		f.logger.Warn("检测到未完成的WAL迁移，重新导入", "segments", len(firsts))
+	}
+	for _, first := range firsts {
+		for _, ext := range []string{segmentIndexExt, segmentExt} {
+			if err := os.Remove(f.segmentPath(first, ext)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("清理日志段失败: %w", err)
+			}
+		}
+	}
+	if err := os.Remove(filepath.Join(f.dir, fileStateName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("清理任期文件失败: %w", err)
+	}
+	return syncDir(f.segmentDir)
+}
+
+// migrateWAL 导入WAL中的任期、投票与日志，全部落盘后重命名WAL文件
+// 快照文件格式与WAL存储相同，无需转换；重命名前崩溃时下次打开会重新导入
+func (f *FileStorage) migrateWAL(logger logging.Logger) error {
+	wal, err := NewWALStorage(f.dir, WALOptions{SyncPolicy: SyncAlways, Logger: logger})
+	if err != nil {
+		return err
+	}
+	defer wal.Close()
+
+	term, _ := wal.GetCurrentTerm()
+	votedFor, _ := wal.GetVotedFor()
+
+	var entries []raft.LogEntry
+	if last := wal.GetLastLogIndex(); last >= wal.firstIndex() {
+		if entries, err = wal.GetLogEntries(wal.firstIndex(), last); err != nil {
+			return err
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.saveStateLocked(fileState{CurrentTerm: term, VotedFor: votedFor}); err != nil {
+		return err
+	}
+	if err := f.saveEntriesLocked(entries); err != nil {
+		return err
+	}
+
+	if err := os.Rename(filepath.Join(f.dir, walFileName), filepath.Join(f.dir, migratedWALName)); err != nil {
+		return err
+	}
+	if err := syncDir(f.dir); err != nil {
+		return err
+	}
+
+	f.logger.Info("已从WAL迁移到分段日志", "entries", len(entries), "last_index", f.lastIndexLocked(), "backup", migratedWALName)
+	return nil
+}
@@ -0,0 +1,285 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-14 09:32:18
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-14 09:32:18
+* @Description: ConcordKV Raft consensus server - file_test.go
+ */
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"raftserver/raft"
+)
+
+// openFileStorage 以较小的段大小打开文件存储，使少量条目即可跨越多个段
+func openFileStorage(t *testing.T, dir string) *FileStorage {
+	t.Helper()
+	f, err := NewFileStorage(dir, FileOptions{SegmentSize: 1024})
+	if err != nil {
+		t.Fatalf("打开文件存储失败: %v", err)
+	}
+	return f
+}
+
+// appendRange 逐条追加[from, to]范围内的条目
+func appendRange(t *testing.T, s raft.Storage, from, to raft.LogIndex, term raft.Term) {
+	t.Helper()
+	for i := from; i <= to; i++ {
+		if err := s.SaveLogEntries([]raft.LogEntry{testEntry(i, term)}); err != nil {
+			t.Fatalf("追加日志 %d 失败: %v", i, err)
+		}
+	}
+}
+
+// TestFileStorageReopen 重新打开后恢复任期、投票与跨多个段的日志，范围读取跨越段边界
+func TestFileStorageReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	f := openFileStorage(t, dir)
+	f.SaveCurrentTerm(3)
+	f.SaveVotedFor("node2")
+	appendRange(t, f, 1, 50, 3)
+	segments := len(f.segments)
+	if segments < 3 {
+		t.Fatalf("50个条目应滚动出多个段，实际 %d", segments)
+	}
+	f.Close()
+
+	f = openFileStorage(t, dir)
+	defer f.Close()
+
+	if term, _ := f.GetCurrentTerm(); term != 3 {
+		t.Errorf("任期应为3，实际 %d", term)
+	}
+	if votedFor, _ := f.GetVotedFor(); votedFor != "node2" {
+		t.Errorf("投票对象应为node2，实际 %s", votedFor)
+	}
+	if len(f.segments) != segments {
+		t.Errorf("段数应为 %d，实际 %d", segments, len(f.segments))
+	}
+	for _, seg := range f.segments[:segments-1] {
+		if _, err := os.Stat(f.segmentPath(seg.first, segmentIndexExt)); err != nil {
+			t.Errorf("写满的段 %d 缺少索引文件: %v", seg.first, err)
+		}
+	}
+
+	entries, err := f.GetLogEntries(5, 45)
+	if err != nil || len(entries) != 41 {
+		t.Fatalf("范围读取应返回41个条目，实际 %d, %v", len(entries), err)
+	}
+	for i, entry := range entries {
+		if entry.Index != raft.LogIndex(i+5) || string(entry.Data) != string(testEntry(entry.Index, 3).Data) {
+			t.Fatalf("第 %d 个条目不正确: %+v", i, entry)
+		}
+	}
+	if f.GetLastLogIndex() != 50 || f.GetLastLogTerm() != 3 {
+		t.Errorf("最后日志应为50@3，实际 %d@%d", f.GetLastLogIndex(), f.GetLastLogTerm())
+	}
+}
+
+// TestFileStorageConflict 相同任期的已有条目被跳过，任期冲突时截断跨段的后续日志，不允许留下空洞
+func TestFileStorageConflict(t *testing.T) {
+	dir := t.TempDir()
+	f := openFileStorage(t, dir)
+	appendRange(t, f, 1, 40, 1)
+
+	// 重复追加相同任期的条目不影响之后的日志
+	if err := f.SaveLogEntries([]raft.LogEntry{testEntry(10, 1), testEntry(11, 1)}); err != nil {
+		t.Fatalf("重复追加失败: %v", err)
+	}
+	if last := f.GetLastLogIndex(); last != 40 {
+		t.Fatalf("重复追加后最后日志索引应为40，实际 %d", last)
+	}
+
+	if err := f.SaveLogEntries([]raft.LogEntry{testEntry(9, 1), testEntry(10, 2), testEntry(11, 2)}); err != nil {
+		t.Fatalf("覆盖冲突条目失败: %v", err)
+	}
+	if last, term := f.GetLastLogIndex(), f.GetLastLogTerm(); last != 11 || term != 2 {
+		t.Fatalf("截断后最后日志应为11@2，实际 %d@%d", last, term)
+	}
+
+	if err := f.SaveLogEntries([]raft.LogEntry{testEntry(13, 2)}); err == nil {
+		t.Errorf("留下空洞的追加应失败")
+	}
+	f.Close()
+
+	f = openFileStorage(t, dir)
+	defer f.Close()
+	if last, term := f.GetLastLogIndex(), f.GetLastLogTerm(); last != 11 || term != 2 {
+		t.Fatalf("重新打开后最后日志应为11@2，实际 %d@%d", last, term)
+	}
+	if entry, err := f.GetLogEntry(9); err != nil || entry.Term != 1 {
+		t.Errorf("冲突之前的条目应保留: %+v, %v", entry, err)
+	}
+	appendRange(t, f, 12, 15, 2)
+}
+
+// TestFileStorageTornTail 最后一个段尾部写了一半的记录在恢复时被截断，之前的条目完好
+func TestFileStorageTornTail(t *testing.T) {
+	dir := t.TempDir()
+	f := openFileStorage(t, dir)
+	appendRange(t, f, 1, 30, 1)
+	last := f.segments[len(f.segments)-1]
+	path := f.segmentPath(last.first, segmentExt)
+	f.Close()
+
+	record := encodeWALRecord(segmentRecordEntry, []byte(`{"index":31,"term":1}`))
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.Write(record[:len(record)/2])
+	file.Close()
+	sizeTorn := fileSize(t, path)
+
+	f = openFileStorage(t, dir)
+	if last := f.GetLastLogIndex(); last != 30 {
+		t.Fatalf("最后日志索引应为30，实际 %d", last)
+	}
+	if size := fileSize(t, path); size >= sizeTorn {
+		t.Errorf("不完整的尾部记录未被截断")
+	}
+	appendRange(t, f, 31, 32, 1)
+	f.Close()
+
+	f = openFileStorage(t, dir)
+	defer f.Close()
+	if last := f.GetLastLogIndex(); last != 32 {
+		t.Fatalf("最后日志索引应为32，实际 %d", last)
+	}
+}
+
+// TestFileStorageSealedCorruption 写满的段损坏时拒绝打开，索引文件损坏时从段文件重建
+func TestFileStorageSealedCorruption(t *testing.T) {
+	dir := t.TempDir()
+	f := openFileStorage(t, dir)
+	appendRange(t, f, 1, 30, 1)
+	first := f.segments[0].first
+	f.Close()
+
+	indexPath := f.segmentPath(first, segmentIndexExt)
+	os.WriteFile(indexPath, []byte("garbage"), 0644)
+	f = openFileStorage(t, dir)
+	if last := f.GetLastLogIndex(); last != 30 {
+		t.Fatalf("索引重建后最后日志索引应为30，实际 %d", last)
+	}
+	f.Close()
+
+	segmentPath := f.segmentPath(first, segmentExt)
+	data, _ := os.ReadFile(segmentPath)
+	data[walHeaderSize+2] ^= 0xff
+	os.WriteFile(segmentPath, data, 0644)
+	os.Remove(indexPath)
+
+	if _, err := NewFileStorage(dir, FileOptions{SegmentSize: 1024}); err == nil {
+		t.Fatalf("写满的段损坏时应拒绝打开")
+	}
+}
+
+// TestFileStorageSnapshot 保存快照后删除被覆盖的段，重启后快照与剩余日志一并恢复；
+// 截断到快照之前时清空全部日志
+func TestFileStorageSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	f := openFileStorage(t, dir)
+	appendRange(t, f, 1, 60, 2)
+	before := len(f.segments)
+
+	if err := f.SaveSnapshot(&raft.Snapshot{LastIncludedIndex: 45, LastIncludedTerm: 2, Data: []byte("state")}); err != nil {
+		t.Fatalf("保存快照失败: %v", err)
+	}
+	if len(f.segments) >= before || f.segments[0].last() < 46 {
+		t.Errorf("快照未删除被覆盖的段: %d -> %d", before, len(f.segments))
+	}
+	if _, err := f.GetLogEntry(45); err == nil {
+		t.Errorf("快照覆盖的日志不应再可读")
+	}
+	if entries, _ := f.GetLogEntries(1, 60); len(entries) != 15 || entries[0].Index != 46 {
+		t.Errorf("范围读取应从快照之后开始: %d", len(entries))
+	}
+	f.Close()
+
+	f = openFileStorage(t, dir)
+	snapshot, err := f.GetSnapshot()
+	if err != nil || snapshot.LastIncludedIndex != 45 || string(snapshot.Data) != "state" {
+		t.Fatalf("快照恢复异常: %+v, %v", snapshot, err)
+	}
+	if last := f.GetLastLogIndex(); last != 60 {
+		t.Fatalf("最后日志索引应为60，实际 %d", last)
+	}
+
+	// 安装快照前清空日志
+	if err := f.TruncateLog(0); err != nil {
+		t.Fatalf("清空日志失败: %v", err)
+	}
+	if last, term := f.GetLastLogIndex(), f.GetLastLogTerm(); last != 45 || term != 2 {
+		t.Fatalf("清空后最后日志应为快照的45@2，实际 %d@%d", last, term)
+	}
+	if err := f.SaveSnapshot(&raft.Snapshot{LastIncludedIndex: 100, LastIncludedTerm: 3}); err != nil {
+		t.Fatalf("保存快照失败: %v", err)
+	}
+	appendRange(t, f, 101, 102, 3)
+	f.Close()
+
+	f = openFileStorage(t, dir)
+	defer f.Close()
+	if entries, _ := f.GetLogEntries(101, 102); len(entries) != 2 {
+		t.Errorf("安装快照后追加的日志丢失: %d", len(entries))
+	}
+}
+
+// TestFileStorageMigrateWAL file后端导入WAL数据并保留备份，wal后端拒绝打开file后端的目录
+func TestFileStorageMigrateWAL(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := NewWALStorage(dir, WALOptions{SyncPolicy: SyncAlways})
+	if err != nil {
+		t.Fatalf("打开WAL失败: %v", err)
+	}
+	w.SaveCurrentTerm(5)
+	w.SaveVotedFor("node3")
+	appendRange(t, w, 1, 30, 4)
+	w.SaveSnapshot(&raft.Snapshot{LastIncludedIndex: 10, LastIncludedTerm: 4, Data: []byte("state")})
+	w.Close()
+
+	// 模拟上次迁移中途崩溃留下的段
+	os.MkdirAll(filepath.Join(dir, segmentDirName), 0755)
+	os.WriteFile(filepath.Join(dir, segmentDirName, "00000000000000000011.seg"), []byte("partial"), 0644)
+
+	store, err := Open(Options{Backend: BackendFile, Dir: dir})
+	if err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	if term, _ := store.GetCurrentTerm(); term != 5 {
+		t.Errorf("任期应为5，实际 %d", term)
+	}
+	if votedFor, _ := store.GetVotedFor(); votedFor != "node3" {
+		t.Errorf("投票对象应为node3，实际 %s", votedFor)
+	}
+	if entries, _ := store.GetLogEntries(11, 30); len(entries) != 20 {
+		t.Errorf("迁移后应有20个条目，实际 %d", len(entries))
+	}
+	if snapshot, err := store.GetSnapshot(); err != nil || snapshot.LastIncludedIndex != 10 {
+		t.Errorf("迁移后快照异常: %+v, %v", snapshot, err)
+	}
+	store.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, walFileName)); !os.IsNotExist(err) {
+		t.Errorf("迁移完成后WAL文件应被重命名: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, migratedWALName)); err != nil {
+		t.Errorf("迁移后应保留WAL备份: %v", err)
+	}
+
+	_, err = Open(Options{Backend: BackendWAL, Dir: dir})
+	if err == nil || !strings.Contains(err.Error(), "storage: file") {
+		t.Fatalf("wal后端应拒绝打开file后端的目录: %v", err)
+	}
+	if _, err := Open(Options{Backend: "pebble", Dir: dir}); err == nil {
+		t.Errorf("未知的后端应返回错误")
+	}
+	if _, err := Open(Options{Backend: BackendFile}); err == nil {
+		t.Errorf("持久化后端缺少数据目录时应返回错误")
+	}
+}
@@ -71,15 +71,17 @@ func (s *MemoryStorage) SaveLogEntries(entries []raft.LogEntry) error {
 	defer s.mu.Unlock()
 
 	for _, entry := range entries {
+		// 已被快照覆盖的条目无需保存
+		if entry.Index < s.firstLogIndex {
+			continue
+		}
+
 		// 计算在数组中的位置
 		arrayIndex := entry.Index - s.firstLogIndex
 
-		// 如果需要扩展数组
+		// 如果需要扩展数组，使用append按倍数扩容，避免逐条追加时每次复制整个日志
 		if arrayIndex >= raft.LogIndex(len(s.logs)) {
-			// 扩展数组到需要的大小
-			newLogs := make([]raft.LogEntry, arrayIndex+1)
-			copy(newLogs, s.logs)
-			s.logs = newLogs
+			s.logs = append(s.logs, make([]raft.LogEntry, int(arrayIndex)+1-len(s.logs))...)
 		}
 
 		s.logs[arrayIndex] = entry
@@ -167,6 +169,13 @@ func (s *MemoryStorage) GetLastLogIndex() raft.LogIndex {
 	return 0
 }
 
+// firstIndex 获取内存中第一个日志条目的索引
+func (s *MemoryStorage) firstIndex() raft.LogIndex {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.firstLogIndex
+}
+
 // GetLastLogTerm 获取最后一个日志的任期号
 func (s *MemoryStorage) GetLastLogTerm() raft.Term {
 	s.mu.RLock()
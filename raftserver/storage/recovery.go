@@ -0,0 +1,120 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-19 19:06:40
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-19 19:06:40
+* @Description: ConcordKV Raft consensus server - recovery.go
+ */
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// ErrLogCorrupted 日志在尾部之外的位置损坏
+// 崩溃时未写完的追加只会出现在文件尾部，损坏之后仍有完整的记录说明已确认的数据被破坏，
+// 截断会丢弃这些记录，因此拒绝启动
+var ErrLogCorrupted = errors.New("日志在尾部之外的位置损坏")
+
+// restoreHint 检测到无法自动恢复的损坏时给出的处理建议
+const restoreHint = "请用 -verify-storage 检查数据目录，并从快照或其他副本恢复"
+
+// recordScan 顺序扫描日志文件的结果
+type recordScan struct {
+	records int   // 校验通过的完整记录数
+	valid   int64 // 最后一条完整记录的结束偏移，也是损坏记录的起始偏移
+	err     error // 第一条损坏记录的原因，为nil时文件完整
+	next    int64 // 损坏之后第一条完整记录的偏移，tail为false时有效
+	tail    bool  // 损坏之后没有完整的记录，视为崩溃时未写完的追加
+}
+
+// dropped 截断到最后一条完整记录时丢弃的字节数
+func (s *recordScan) dropped(size int64) int64 {
+	return size - s.valid
+}
+
+// scanRecords 从头扫描文件中的记录，每条校验通过的记录交给visit
+// 记录不完整、校验失败或visit返回包装errWALCorrupt的错误时停止扫描，并判断损坏是否位于尾部；
+// visit返回其他错误或读取文件失败时返回该错误
+func scanRecords(file io.ReaderAt, size int64, validType func(byte) bool, visit func(offset int64, recordType byte, payload []byte) error) (recordScan, error) {
+	reader := bufio.NewReader(io.NewSectionReader(file, 0, size))
+	var scan recordScan
+
+	for {
+		recordType, payload, err := readWALRecord(reader)
+		if err == io.EOF {
+			return scan, nil
+		}
+		if err == nil && !validType(recordType) {
+			err = fmt.Errorf("%w: 未知的记录类型 %d", errWALCorrupt, recordType)
+		}
+		if err == nil {
+			if err = visit(scan.valid, recordType, payload); err != nil && !errors.Is(err, errWALCorrupt) {
+				return scan, err
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, errWALCorrupt) {
+				return scan, err
+			}
+			next, found := findRecordAfter(file, scan.valid, size, validType)
+			scan.err, scan.next, scan.tail = err, next, !found
+			return scan, nil
+		}
+
+		scan.records++
+		scan.valid += int64(walHeaderSize + len(payload))
+	}
+}
+
+// findRecordAfter 在from之后逐字节查找校验通过的完整记录，返回其偏移
+// 损坏记录的长度字段本身可能已被破坏，因此不能按长度跳到下一条记录
+func findRecordAfter(file io.ReaderAt, from, size int64, validType func(byte) bool) (int64, bool) {
+	start := from + 1
+	if size-start < walHeaderSize {
+		return 0, false
+	}
+	buf := make([]byte, size-start)
+	if _, err := file.ReadAt(buf, start); err != nil && err != io.EOF {
+		return 0, false
+	}
+
+	for i := 0; i+walHeaderSize <= len(buf); i++ {
+		length := int(binary.LittleEndian.Uint32(buf[i : i+4]))
+		if length > walMaxRecordSize || i+walHeaderSize+length > len(buf) || !validType(buf[i+8]) {
+			continue
+		}
+		checksum := crc32.Checksum(buf[i+8:i+walHeaderSize+length], walCRCTable)
+		if checksum == binary.LittleEndian.Uint32(buf[i+4:i+8]) {
+			return start + int64(i), true
+		}
+	}
+	return 0, false
+}
+
+// truncateTail 截断文件尾部不完整的记录并落盘
+func truncateTail(file *os.File, size int64) error {
+	if err := file.Truncate(size); err != nil {
+		return fmt.Errorf("截断尾部失败: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("落盘失败: %w", err)
+	}
+	return nil
+}
+
+// corruptionError 损坏不在尾部时拒绝启动的错误，detail说明判定依据
+func corruptionError(path string, scan *recordScan, detail string) error {
+	return fmt.Errorf("%w: %s 偏移 %d 处的记录损坏(%v)，%s；%s", ErrLogCorrupted, path, scan.valid, scan.err, detail, restoreHint)
+}
+
+// followedDetail 损坏之后仍有完整记录时的判定依据
+func (s *recordScan) followedDetail() string {
+	return fmt.Sprintf("其后偏移 %d 处仍有完整的记录", s.next)
+}
@@ -0,0 +1,245 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-19 19:06:40
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-19 19:06:40
+* @Description: ConcordKV Raft consensus server - recovery_test.go
+ */
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"raftserver/raft"
+)
+
+// recordOffsets 文件中各记录的起始偏移，最后附加文件长度
+func recordOffsets(t *testing.T, data []byte) []int64 {
+	t.Helper()
+	reader := bytes.NewReader(data)
+	offsets := []int64{0}
+	for reader.Len() > 0 {
+		if _, _, err := readWALRecord(reader); err != nil {
+			t.Fatalf("读取原始记录失败: %v", err)
+		}
+		offsets = append(offsets, int64(len(data)-reader.Len()))
+	}
+	return offsets
+}
+
+// completeRecords 长度为size的前缀中完整记录的数量
+func completeRecords(offsets []int64, size int64) int {
+	return sort.Search(len(offsets), func(i int) bool { return offsets[i] > size }) - 1
+}
+
+// segmentFixture 单个日志段中写入10个条目，返回段文件的内容与其中各记录的偏移
+func segmentFixture(t *testing.T) ([]byte, []int64) {
+	t.Helper()
+	dir := t.TempDir()
+	f, err := NewFileStorage(dir, FileOptions{SegmentSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("打开文件存储失败: %v", err)
+	}
+	appendRange(t, f, 1, 10, 1)
+	path := f.segmentPath(1, segmentExt)
+	f.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("读取日志段失败: %v", err)
+	}
+	return data, recordOffsets(t, data)
+}
+
+// writeSegmentDir 在新目录中写入只有一个日志段的file存储
+func writeSegmentDir(t *testing.T, data []byte) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := segmentFilePath(filepath.Join(dir, segmentDirName), 1, segmentExt)
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("写入日志段失败: %v", err)
+	}
+	return dir, path
+}
+
+// TestFileStorageTruncatedAtAnyOffset 段文件在任意偏移处截断（崩溃时写了一部分），
+// 打开时保留此前完整的条目并截掉不完整的尾部，检查模式报告可以启动且不修改文件
+func TestFileStorageTruncatedAtAnyOffset(t *testing.T) {
+	data, offsets := segmentFixture(t)
+
+	for size := int64(0); size < int64(len(data)); size++ {
+		dir, path := writeSegmentDir(t, data[:size])
+		want := raft.LogIndex(completeRecords(offsets, size))
+
+		report, err := Verify(dir)
+		if err != nil {
+			t.Fatalf("截断到 %d: 检查失败: %v", size, err)
+		}
+		if !report.Startable() || report.Clean() != (size == offsets[want]) {
+			t.Fatalf("截断到 %d: 检查结果不正确\n%s", size, report)
+		}
+		if fileSize(t, path) != size {
+			t.Fatalf("截断到 %d: 检查模式不应修改文件", size)
+		}
+
+		f, err := NewFileStorage(dir, FileOptions{SegmentSize: 1 << 20})
+		if err != nil {
+			t.Fatalf("截断到 %d: 打开失败: %v", size, err)
+		}
+		if last := f.GetLastLogIndex(); last != want {
+			t.Fatalf("截断到 %d: 最后日志索引应为 %d，实际 %d", size, want, last)
+		}
+		f.Close()
+		if want > 0 && fileSize(t, path) != offsets[want] {
+			t.Fatalf("截断到 %d: 不完整的尾部应被截掉", size)
+		}
+	}
+}
+
+// TestFileStorageBitFlip 最后一条记录中的位翻转视为未写完的尾部被截掉；
+// 之前的记录中任意位置（长度、校验和、类型、负载）的位翻转都拒绝启动
+func TestFileStorageBitFlip(t *testing.T) {
+	data, offsets := segmentFixture(t)
+	records := len(offsets) - 1
+
+	for i := 0; i < records; i++ {
+		start := offsets[i]
+		for _, pos := range []int64{start, start + 5, start + 8, start + walHeaderSize + 3, offsets[i+1] - 1} {
+			corrupted := append([]byte(nil), data...)
+			corrupted[pos] ^= 0x10
+			dir, _ := writeSegmentDir(t, corrupted)
+
+			report, err := Verify(dir)
+			if err != nil {
+				t.Fatalf("记录 %d 偏移 %d: 检查失败: %v", i, pos, err)
+			}
+			f, err := NewFileStorage(dir, FileOptions{SegmentSize: 1 << 20})
+
+			if i == records-1 {
+				if err != nil {
+					t.Fatalf("记录 %d 偏移 %d: 最后一条记录损坏时应截断后启动: %v", i, pos, err)
+				}
+				if last := f.GetLastLogIndex(); last != raft.LogIndex(i) {
+					t.Errorf("记录 %d 偏移 %d: 最后日志索引应为 %d，实际 %d", i, pos, i, last)
+				}
+				f.Close()
+				if !report.Startable() || report.Clean() {
+					t.Errorf("记录 %d 偏移 %d: 应报告可恢复的尾部损坏\n%s", i, pos, report)
+				}
+				continue
+			}
+
+			if !errors.Is(err, ErrLogCorrupted) {
+				t.Fatalf("记录 %d 偏移 %d: 期望ErrLogCorrupted，实际 %v", i, pos, err)
+			}
+			if report.Startable() {
+				t.Errorf("记录 %d 偏移 %d: 检查应报告无法启动\n%s", i, pos, report)
+			}
+		}
+	}
+}
+
+// TestWALTruncateAndBitFlip WAL在任意偏移处截断时截掉不完整的尾部；
+// 中间记录的位翻转拒绝启动，且不修改WAL文件
+func TestWALTruncateAndBitFlip(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWALStorage(dir, WALOptions{SyncPolicy: SyncAlways})
+	if err != nil {
+		t.Fatalf("打开WAL失败: %v", err)
+	}
+	w.SaveCurrentTerm(1)
+	appendRange(t, w, 1, 6, 1)
+	w.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, walFileName))
+	if err != nil {
+		t.Fatalf("读取WAL失败: %v", err)
+	}
+	offsets := recordOffsets(t, data)
+
+	open := func(contents []byte) (string, *WALStorage, error) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, walFileName)
+		os.WriteFile(path, contents, 0644)
+		w, err := NewWALStorage(dir, WALOptions{SyncPolicy: SyncAlways})
+		return path, w, err
+	}
+
+	for size := int64(0); size < int64(len(data)); size++ {
+		_, w, err := open(data[:size])
+		if err != nil {
+			t.Fatalf("截断到 %d: 打开失败: %v", size, err)
+		}
+		// 第一条记录是任期，之后每条记录一个条目
+		want := raft.LogIndex(0)
+		if n := completeRecords(offsets, size); n > 1 {
+			want = raft.LogIndex(n - 1)
+		}
+		if last := w.GetLastLogIndex(); last != want {
+			t.Fatalf("截断到 %d: 最后日志索引应为 %d，实际 %d", size, want, last)
+		}
+		w.Close()
+	}
+
+	for i := 0; i < len(offsets)-2; i++ {
+		corrupted := append([]byte(nil), data...)
+		corrupted[offsets[i]+walHeaderSize] ^= 0x01
+		path, _, err := open(corrupted)
+		if !errors.Is(err, ErrLogCorrupted) {
+			t.Fatalf("记录 %d: 期望ErrLogCorrupted，实际 %v", i, err)
+		}
+		if !bytes.Equal(mustReadFile(t, path), corrupted) {
+			t.Errorf("记录 %d: 拒绝启动时不应修改WAL", i)
+		}
+
+		report, err := Verify(filepath.Dir(path))
+		if err != nil || report.Startable() || report.Backend != BackendWAL {
+			t.Errorf("记录 %d: 检查应报告无法启动: %v\n%s", i, err, report)
+		}
+	}
+}
+
+// TestVerifyCleanStorage 完整的数据目录检查通过，报告中包含日志范围
+func TestVerifyCleanStorage(t *testing.T) {
+	dir := t.TempDir()
+	f := openFileStorage(t, dir)
+	appendRange(t, f, 1, 30, 1)
+	f.Close()
+
+	report, err := Verify(dir)
+	if err != nil {
+		t.Fatalf("检查失败: %v", err)
+	}
+	if !report.Clean() || report.Backend != BackendFile || len(report.Files) < 2 {
+		t.Fatalf("检查结果不正确\n%s", report)
+	}
+	if first, last := report.Files[0].FirstIndex, report.Files[len(report.Files)-1].LastIndex; first != 1 || last != 30 {
+		t.Errorf("日志范围应为1-30，实际 %d-%d", first, last)
+	}
+
+	// 中间的段缺失时日志不连续
+	os.Remove(report.Files[1].Path)
+	if report, err := Verify(dir); err != nil || report.Startable() {
+		t.Errorf("段缺失时应报告无法启动: %v\n%s", err, report)
+	}
+
+	if _, err := Verify(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("数据目录不存在时应返回错误")
+	}
+}
+
+// mustReadFile 读取文件内容
+func mustReadFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("读取 %s 失败: %v", path, err)
+	}
+	return data
+}
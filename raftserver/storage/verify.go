@@ -0,0 +1,289 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-19 19:06:40
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-19 19:06:40
+* @Description: ConcordKV Raft consensus server - verify.go
+ */
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"raftserver/raft"
+)
+
+// FileReport 单个日志文件的检查结果
+type FileReport struct {
+	Path       string        `json:"path"`
+	Size       int64         `json:"size"`
+	Records    int           `json:"records"`    // 校验通过的完整记录数
+	FirstIndex raft.LogIndex `json:"firstIndex"` // 文件中第一个日志条目的索引，没有条目时为0
+	LastIndex  raft.LogIndex `json:"lastIndex"`  // 文件中最后一个日志条目的索引
+	ValidBytes int64         `json:"validBytes"` // 最后一条完整记录的结束偏移
+
+	// 损坏的记录：Corruption为空时文件完整；Recoverable表示损坏位于日志尾部，启动时会截断
+	Corruption  string `json:"corruption,omitempty"`
+	Offset      int64  `json:"offset,omitempty"`
+	Recoverable bool   `json:"recoverable,omitempty"`
+}
+
+// VerifyReport 数据目录的完整性检查结果
+type VerifyReport struct {
+	Dir      string        `json:"dir"`
+	Backend  Backend       `json:"backend,omitempty"`  // 按目录内容识别的存储后端，目录中没有日志时为空
+	Snapshot raft.LogIndex `json:"snapshot,omitempty"` // 快照包含的最后一个日志索引
+	Files    []FileReport  `json:"files"`
+	Problems []string      `json:"problems,omitempty"` // 无法启动的问题：不可恢复的损坏、日志段不连续、元数据无法解析等
+}
+
+// Clean 没有任何损坏
+func (r *VerifyReport) Clean() bool {
+	if len(r.Problems) > 0 {
+		return false
+	}
+	for _, file := range r.Files {
+		if file.Corruption != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Startable 节点可以启动：没有损坏，或只有启动时会被截断的尾部
+func (r *VerifyReport) Startable() bool {
+	return len(r.Problems) == 0
+}
+
+// String 可读的检查报告
+func (r *VerifyReport) String() string {
+	var b strings.Builder
+	backend := string(r.Backend)
+	if backend == "" {
+		backend = "无日志数据"
+	}
+	fmt.Fprintf(&b, "数据目录: %s\n存储后端: %s\n", r.Dir, backend)
+	if r.Snapshot > 0 {
+		fmt.Fprintf(&b, "快照: 包含到日志索引 %d\n", r.Snapshot)
+	}
+
+	for _, file := range r.Files {
+		fmt.Fprintf(&b, "%s: %d 字节，%d 条记录", file.Path, file.Size, file.Records)
+		if file.LastIndex > 0 {
+			fmt.Fprintf(&b, "，日志索引 %d-%d", file.FirstIndex, file.LastIndex)
+		}
+		switch {
+		case file.Corruption == "":
+			b.WriteString("，完整\n")
+		case file.Recoverable:
+			fmt.Fprintf(&b, "\n  尾部偏移 %d 处的记录不完整(%s)，启动时将截断 %d 字节\n", file.Offset, file.Corruption, file.Size-file.ValidBytes)
+		default:
+			fmt.Fprintf(&b, "\n  偏移 %d 处的记录损坏(%s)\n", file.Offset, file.Corruption)
+		}
+	}
+
+	for _, problem := range r.Problems {
+		fmt.Fprintf(&b, "错误: %s\n", problem)
+	}
+	switch {
+	case r.Clean():
+		b.WriteString("结果: 完整\n")
+	case r.Startable():
+		b.WriteString("结果: 只有尾部存在崩溃时未写完的记录，可以正常启动\n")
+	default:
+		b.WriteString("结果: 存在无法自动恢复的损坏，节点将拒绝启动，请从快照或其他副本恢复数据目录\n")
+	}
+	return b.String()
+}
+
+// Verify 只读地检查数据目录中任期、快照与日志的完整性，不修改任何文件
+func Verify(dir string) (*VerifyReport, error) {
+	if info, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("读取数据目录失败: %w", err)
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s 不是目录", dir)
+	}
+
+	report := &VerifyReport{Dir: dir}
+
+	if data, err := os.ReadFile(filepath.Join(dir, snapshotFileName)); err == nil {
+		var snapshot raft.Snapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("解析快照文件失败: %v", err))
+		} else {
+			report.Snapshot = snapshot.LastIncludedIndex
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("读取快照文件失败: %w", err)
+	}
+
+	firsts, err := listSegments(filepath.Join(dir, segmentDirName))
+	if err != nil {
+		return nil, err
+	}
+	_, walErr := os.Stat(filepath.Join(dir, walFileName))
+
+	switch {
+	case walErr == nil:
+		// 迁移未完成时file存储会丢弃已导入的日志段，从WAL重新导入，因此只需检查WAL
+		report.Backend = BackendWAL
+		if isFileStorageDir(dir) {
+			report.Backend = BackendFile
+		}
+		err = verifyWAL(report, filepath.Join(dir, walFileName))
+	case isFileStorageDir(dir):
+		report.Backend = BackendFile
+		err = verifySegments(report, dir, firsts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// verifySegments 检查file存储的任期文件与各日志段
+func verifySegments(report *VerifyReport, dir string, firsts []raft.LogIndex) error {
+	if data, err := os.ReadFile(filepath.Join(dir, fileStateName)); err == nil {
+		var state fileState
+		if err := json.Unmarshal(data, &state); err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("解析任期与投票失败: %v", err))
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("读取任期与投票失败: %w", err)
+	}
+
+	var last raft.LogIndex
+	for i, first := range firsts {
+		path := segmentFilePath(filepath.Join(dir, segmentDirName), first, segmentExt)
+		next := first
+		file, scan, err := verifyFile(path, isSegmentRecord, func(_ int64, _ byte, payload []byte) error {
+			if _, err := decodeSegmentEntry(payload, next); err != nil {
+				return err
+			}
+			next++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if scan.records > 0 {
+			file.FirstIndex, file.LastIndex = first, next-1
+		}
+
+		sealed := i < len(firsts)-1
+		if scan.err != nil {
+			switch {
+			case sealed:
+				report.Problems = append(report.Problems, fmt.Sprintf("%s: 写满的日志段损坏，该段之后还有日志段", path))
+			case !scan.tail:
+				report.Problems = append(report.Problems, fmt.Sprintf("%s: 记录损坏，%s", path, scan.followedDetail()))
+			default:
+				file.Recoverable = true
+			}
+		}
+		report.Files = append(report.Files, file)
+
+		if scan.records == 0 {
+			if sealed {
+				report.Problems = append(report.Problems, fmt.Sprintf("%s: 日志段 %d 为空", path, first))
+			}
+			continue
+		}
+		if last > 0 && last+1 != first {
+			report.Problems = append(report.Problems, fmt.Sprintf("日志段不连续: 索引 %d 之后是段 %d", last, first))
+		}
+		last = next - 1
+	}
+
+	// 完全被快照覆盖的段在启动时删除，之后的第一个段必须紧接快照
+	for _, file := range report.Files {
+		if file.LastIndex <= report.Snapshot {
+			continue
+		}
+		if file.FirstIndex > report.Snapshot+1 {
+			report.Problems = append(report.Problems, fmt.Sprintf("日志缺少条目 %d 到 %d", report.Snapshot+1, file.FirstIndex-1))
+		}
+		break
+	}
+	return nil
+}
+
+// verifyWAL 检查wal存储的WAL文件，日志范围按重放条目与截断记录后的结果计算
+func verifyWAL(report *VerifyReport, path string) error {
+	var first, last raft.LogIndex
+	file, scan, err := verifyFile(path, isWALRecord, func(_ int64, recordType byte, payload []byte) error {
+		var err error
+		switch recordType {
+		case walRecordTerm:
+			err = json.Unmarshal(payload, new(raft.Term))
+		case walRecordVote:
+			err = json.Unmarshal(payload, new(raft.NodeID))
+		case walRecordEntries:
+			var entries []raft.LogEntry
+			if err = json.Unmarshal(payload, &entries); err == nil && len(entries) > 0 {
+				if first == 0 || entries[0].Index < first {
+					first = entries[0].Index
+				}
+				last = entries[len(entries)-1].Index
+			}
+		case walRecordTruncate:
+			var index raft.LogIndex
+			if err = json.Unmarshal(payload, &index); err == nil && index < last {
+				last = index
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%w: 解析记录失败: %v", errWALCorrupt, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if last >= first && last > 0 {
+		file.FirstIndex, file.LastIndex = first, last
+	}
+
+	if scan.err != nil {
+		if scan.tail {
+			file.Recoverable = true
+		} else {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: 记录损坏，%s", path, scan.followedDetail()))
+		}
+	}
+	report.Files = append(report.Files, file)
+	return nil
+}
+
+// verifyFile 以只读方式扫描一个日志文件
+func verifyFile(path string, validType func(byte) bool, visit func(offset int64, recordType byte, payload []byte) error) (FileReport, recordScan, error) {
+	report := FileReport{Path: path}
+	file, err := os.Open(path)
+	if err != nil {
+		return report, recordScan{}, fmt.Errorf("打开 %s 失败: %w", path, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return report, recordScan{}, fmt.Errorf("读取 %s 信息失败: %w", path, err)
+	}
+	report.Size = info.Size()
+
+	scan, err := scanRecords(file, info.Size(), validType, visit)
+	if err != nil {
+		return report, scan, fmt.Errorf("读取 %s 失败: %w", path, err)
+	}
+	report.Records = scan.records
+	report.ValidBytes = scan.valid
+	if scan.err != nil {
+		report.Corruption = scan.err.Error()
+		report.Offset = scan.valid
+	}
+	return report, scan, nil
+}
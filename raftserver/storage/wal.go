@@ -0,0 +1,652 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - wal.go
+ */
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"raftserver/logging"
+	"raftserver/raft"
+)
+
+// SyncPolicy WAL刷盘策略
+type SyncPolicy string
+
+const (
+	// SyncAlways 每次追加后立即fsync，返回即表示已落盘
+	SyncAlways SyncPolicy = "always"
+
+	// SyncInterval 追加只写入页缓存，由后台协程每隔固定时间fsync一次
+	// 机器掉电时可能丢失最近一个间隔内已确认的写入
+	SyncInterval SyncPolicy = "interval"
+
+	// SyncGroup 合并并发写入者的追加，一次fsync后唤醒同组所有等待者，返回即表示已落盘
+	SyncGroup SyncPolicy = "group"
+)
+
+// DefaultSyncInterval interval策略的默认刷盘间隔
+const DefaultSyncInterval = 10 * time.Millisecond
+
+const (
+	walFileName      = "wal.log"
+	snapshotFileName = "snapshot.json"
+
+	// 记录头：负载长度(4字节) + CRC32(4字节) + 记录类型(1字节)
+	walHeaderSize    = 9
+	walMaxRecordSize = 64 << 20
+)
+
+// WAL记录类型
+const (
+	walRecordTerm     byte = 1 // 当前任期
+	walRecordVote     byte = 2 // 投票对象
+	walRecordEntries  byte = 3 // 日志条目
+	walRecordTruncate byte = 4 // 截断日志
+)
+
+var (
+	// ErrWALClosed WAL已关闭
+	ErrWALClosed = errors.New("WAL已关闭")
+
+	// errWALCorrupt 记录校验失败
+	errWALCorrupt = errors.New("WAL记录损坏")
+
+	walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// ParseSyncPolicy 解析刷盘策略，空字符串视为always
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch SyncPolicy(s) {
+	case "", SyncAlways:
+		return SyncAlways, nil
+	case SyncInterval, SyncGroup:
+		return SyncPolicy(s), nil
+	default:
+		return "", fmt.Errorf("不支持的刷盘策略: %s（可选 always、interval、group）", s)
+	}
+}
+
+// WALOptions WAL存储选项
+type WALOptions struct {
+	SyncPolicy   SyncPolicy     // 刷盘策略，默认always
+	SyncInterval time.Duration  // interval策略的刷盘间隔，默认DefaultSyncInterval
+	Logger       logging.Logger // 日志，为nil时使用logging.Default()
+}
+
+// SyncStats WAL刷盘统计
+type SyncStats struct {
+	Policy        SyncPolicy `json:"policy"`        // 刷盘策略
+	Syncs         uint64     `json:"syncs"`         // 已执行的fsync次数
+	SyncedAppends uint64     `json:"syncedAppends"` // 已落盘的追加次数
+	AvgBatchSize  float64    `json:"avgBatchSize"`  // 平均每次fsync覆盖的追加次数
+}
+
+// WALStorage 基于预写日志的持久化存储
+// 读取走内存索引，任期、投票与日志的修改先追加到WAL文件再更新内存；
+// 快照单独保存，保存快照后用剩余日志重写WAL以回收空间
+type WALStorage struct {
+	*MemoryStorage
+
+	dir      string
+	policy   SyncPolicy
+	interval time.Duration
+	logger   logging.Logger
+
+	writeMu sync.Mutex   // 串行化记录写入，保证文件中的记录顺序与内存状态一致
+	fileMu  sync.RWMutex // 保护file的替换，fsync期间持读锁
+	file    *os.File
+	written atomic.Uint64 // 已写入文件的记录数
+	closed  bool
+
+	syncMu   sync.Mutex
+	syncCond *sync.Cond
+	syncing  bool                 // group策略下是否已有写入者在执行fsync
+	synced   uint64               // 已落盘的记录数
+	syncs    uint64               // 已执行的fsync次数
+	syncErr  error                // fsync失败后页缓存状态不可信，之后的写入全部失败
+	fsync    func(*os.File) error // 执行fsync，由fileMu保护
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewWALStorage 打开或创建dir下的WAL存储，并从快照与WAL恢复状态
+func NewWALStorage(dir string, opts WALOptions) (*WALStorage, error) {
+	policy, err := ParseSyncPolicy(string(opts.SyncPolicy))
+	if err != nil {
+		return nil, err
+	}
+
+	interval := opts.SyncInterval
+	if interval <= 0 {
+		interval = DefaultSyncInterval
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建数据目录失败: %w", err)
+	}
+
+	w := &WALStorage{
+		MemoryStorage: NewMemoryStorage(),
+		dir:           dir,
+		policy:        policy,
+		interval:      interval,
+		logger:        logging.Component(opts.Logger, "wal"),
+		stopCh:        make(chan struct{}),
+		fsync:         (*os.File).Sync,
+	}
+	w.syncCond = sync.NewCond(&w.syncMu)
+
+	if err := w.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := w.replay(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(w.walPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("打开WAL文件失败: %w", err)
+	}
+	w.file = file
+
+	if policy == SyncInterval {
+		w.wg.Add(1)
+		go w.flushLoop()
+	}
+
+	return w, nil
+}
+
+// SaveCurrentTerm 保存当前任期号
+func (w *WALStorage) SaveCurrentTerm(term raft.Term) error {
+	return w.append(walRecordTerm, term, func() error {
+		return w.MemoryStorage.SaveCurrentTerm(term)
+	})
+}
+
+// SaveVotedFor 保存投票给的候选人
+func (w *WALStorage) SaveVotedFor(candidateID raft.NodeID) error {
+	return w.append(walRecordVote, candidateID, func() error {
+		return w.MemoryStorage.SaveVotedFor(candidateID)
+	})
+}
+
+// SaveLogEntries 保存日志条目
+func (w *WALStorage) SaveLogEntries(entries []raft.LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return w.append(walRecordEntries, entries, func() error {
+		return w.MemoryStorage.SaveLogEntries(entries)
+	})
+}
+
+// TruncateLog 截断日志（删除指定索引之后的所有条目）
+func (w *WALStorage) TruncateLog(index raft.LogIndex) error {
+	return w.append(walRecordTruncate, index, func() error {
+		return w.MemoryStorage.TruncateLog(index)
+	})
+}
+
+// SaveSnapshot 保存快照，并用快照之后的日志重写WAL
+func (w *WALStorage) SaveSnapshot(snapshot *raft.Snapshot) error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	if w.closed {
+		return ErrWALClosed
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("序列化快照失败: %w", err)
+	}
+	if err := writeFileAtomic(w.dir, snapshotFileName, data); err != nil {
+		return fmt.Errorf("保存快照文件失败: %w", err)
+	}
+
+	if err := w.MemoryStorage.SaveSnapshot(snapshot); err != nil {
+		return err
+	}
+
+	return w.rewriteLocked()
+}
+
+// Close 刷盘并关闭WAL
+func (w *WALStorage) Close() error {
+	w.writeMu.Lock()
+	if w.closed {
+		w.writeMu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.writeMu.Unlock()
+
+	close(w.stopCh)
+	w.wg.Wait()
+
+	err := w.syncFile()
+
+	w.fileMu.Lock()
+	defer w.fileMu.Unlock()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// SyncStats 获取刷盘统计
+func (w *WALStorage) SyncStats() SyncStats {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
+	stats := SyncStats{
+		Policy:        w.policy,
+		Syncs:         w.syncs,
+		SyncedAppends: w.synced,
+	}
+	if w.syncs > 0 {
+		stats.AvgBatchSize = float64(w.synced) / float64(w.syncs)
+	}
+	return stats
+}
+
+// GetLogStats 获取日志统计信息，附带WAL刷盘统计
+func (w *WALStorage) GetLogStats() map[string]interface{} {
+	stats := w.MemoryStorage.GetLogStats()
+	stats["wal"] = w.SyncStats()
+	return stats
+}
+
+// append 写入一条记录并更新内存状态，随后按刷盘策略决定何时返回
+func (w *WALStorage) append(recordType byte, v interface{}, apply func() error) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("序列化WAL记录失败: %w", err)
+	}
+	record := encodeWALRecord(recordType, payload)
+
+	w.writeMu.Lock()
+	if w.closed {
+		w.writeMu.Unlock()
+		return ErrWALClosed
+	}
+	if err := w.stickyErr(); err != nil {
+		w.writeMu.Unlock()
+		return err
+	}
+
+	if _, err := w.file.Write(record); err != nil {
+		// 文件尾部可能残留半条记录，继续追加会让后续记录在恢复时被丢弃
+		w.writeMu.Unlock()
+		return w.fail(fmt.Errorf("写入WAL失败: %w", err))
+	}
+	seq := w.written.Add(1)
+
+	if err := apply(); err != nil {
+		w.writeMu.Unlock()
+		return err
+	}
+
+	if w.policy == SyncAlways {
+		err = w.syncFile()
+	}
+	w.writeMu.Unlock()
+
+	if w.policy == SyncGroup {
+		return w.waitSynced(seq)
+	}
+	return err
+}
+
+// waitSynced 等待序号为seq的记录落盘（group策略）
+// 没有写入者在fsync时由当前等待者发起，一次fsync覆盖此前所有已写入的记录；
+// fsync期间到达的写入者排队等待，由下一个醒来的等待者为它们统一刷盘
+func (w *WALStorage) waitSynced(seq uint64) error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
+	for w.synced < seq {
+		if w.syncErr != nil {
+			return w.syncErr
+		}
+		if w.syncing {
+			w.syncCond.Wait()
+			continue
+		}
+
+		w.syncing = true
+		w.syncMu.Unlock()
+		w.syncFile()
+		w.syncMu.Lock()
+		w.syncing = false
+		w.syncCond.Broadcast()
+	}
+
+	return nil
+}
+
+// flushLoop 按固定间隔刷盘（interval策略）
+func (w *WALStorage) flushLoop() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case <-ticker.C:
+			w.syncMu.Lock()
+			dirty := w.written.Load() > w.synced
+			w.syncMu.Unlock()
+
+			if dirty {
+				if err := w.syncFile(); err != nil {
+					w.logger.Error("后台刷盘失败", logging.FieldError, err)
+				}
+			}
+		}
+	}
+}
+
+// syncFile 对当前WAL文件执行一次fsync，并记录此次覆盖的记录数
+func (w *WALStorage) syncFile() error {
+	w.fileMu.RLock()
+	target := w.written.Load()
+	err := w.fsync(w.file)
+	w.fileMu.RUnlock()
+
+	if err != nil {
+		return w.fail(fmt.Errorf("WAL刷盘失败: %w", err))
+	}
+
+	w.syncMu.Lock()
+	w.recordSyncLocked(target)
+	w.syncMu.Unlock()
+	return nil
+}
+
+// recordSyncLocked 记录一次覆盖到target的成功刷盘（调用方需持有syncMu）
+func (w *WALStorage) recordSyncLocked(target uint64) {
+	if target > w.synced {
+		w.syncs++
+		w.synced = target
+	}
+	w.syncCond.Broadcast()
+}
+
+// fail 记录不可恢复的错误并唤醒所有等待者
+func (w *WALStorage) fail(err error) error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
+	if w.syncErr == nil {
+		w.syncErr = err
+	}
+	w.syncCond.Broadcast()
+	return w.syncErr
+}
+
+// stickyErr 获取此前发生的不可恢复错误
+func (w *WALStorage) stickyErr() error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	return w.syncErr
+}
+
+// rewriteLocked 用当前任期、投票与剩余日志重写WAL（调用方需持有writeMu）
+// 新文件落盘后原子替换旧文件，此前写入的记录随之全部落盘
+func (w *WALStorage) rewriteLocked() error {
+	term, _ := w.MemoryStorage.GetCurrentTerm()
+	votedFor, _ := w.MemoryStorage.GetVotedFor()
+
+	var buf []byte
+	appendRecord := func(recordType byte, v interface{}) error {
+		payload, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("序列化WAL记录失败: %w", err)
+		}
+		buf = append(buf, encodeWALRecord(recordType, payload)...)
+		return nil
+	}
+
+	if err := appendRecord(walRecordTerm, term); err != nil {
+		return err
+	}
+	if err := appendRecord(walRecordVote, votedFor); err != nil {
+		return err
+	}
+
+	first := w.MemoryStorage.firstIndex()
+	last := w.MemoryStorage.GetLastLogIndex()
+	if last >= first {
+		entries, err := w.MemoryStorage.GetLogEntries(first, last)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			if err := appendRecord(walRecordEntries, entries); err != nil {
+				return err
+			}
+		}
+	}
+
+	w.fileMu.Lock()
+	defer w.fileMu.Unlock()
+
+	if err := writeFileAtomic(w.dir, walFileName, buf); err != nil {
+		return w.fail(fmt.Errorf("重写WAL失败: %w", err))
+	}
+
+	file, err := os.OpenFile(w.walPath(), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return w.fail(fmt.Errorf("打开WAL文件失败: %w", err))
+	}
+	w.file.Close()
+	w.file = file
+
+	w.syncMu.Lock()
+	w.recordSyncLocked(w.written.Load())
+	w.syncMu.Unlock()
+
+	return nil
+}
+
+// loadSnapshot 从快照文件恢复快照
+func (w *WALStorage) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(w.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取快照文件失败: %w", err)
+	}
+
+	var snapshot raft.Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("解析快照文件失败: %w", err)
+	}
+	return w.MemoryStorage.SaveSnapshot(&snapshot)
+}
+
+// replay 按顺序重放WAL记录
+// 尾部不完整或校验失败的记录视为崩溃时未写完的追加，截断后继续使用；
+// 损坏之后仍有完整的记录时说明已确认的记录被破坏，返回ErrLogCorrupted
+func (w *WALStorage) replay() error {
+	file, err := os.OpenFile(w.walPath(), os.O_RDWR, 0644)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("打开WAL文件失败: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("读取WAL文件信息失败: %w", err)
+	}
+
+	scan, err := scanRecords(file, info.Size(), isWALRecord, func(offset int64, recordType byte, payload []byte) error {
+		if err := w.applyRecord(recordType, payload); err != nil {
+			return fmt.Errorf("重放WAL记录(偏移 %d)失败: %w", offset, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if scan.err != nil {
+		if !scan.tail {
+			return corruptionError(w.walPath(), &scan, scan.followedDetail())
+		}
+		w.logger.Warn("WAL尾部存在不完整的记录，截断尾部",
+			"offset", scan.valid, "dropped_bytes", scan.dropped(info.Size()),
+			"last_index", w.MemoryStorage.GetLastLogIndex(), logging.FieldError, scan.err)
+		if err := truncateTail(file, scan.valid); err != nil {
+			return fmt.Errorf("WAL%w", err)
+		}
+	}
+
+	w.written.Store(uint64(scan.records))
+	w.synced = uint64(scan.records)
+	if scan.records > 0 {
+		w.logger.Info("从WAL恢复记录", "records", scan.records, "last_index", w.MemoryStorage.GetLastLogIndex())
+	}
+	return nil
+}
+
+// isWALRecord 记录类型是否为WAL中的已知类型
+func isWALRecord(recordType byte) bool {
+	return recordType >= walRecordTerm && recordType <= walRecordTruncate
+}
+
+// applyRecord 将一条WAL记录应用到内存状态
+func (w *WALStorage) applyRecord(recordType byte, payload []byte) error {
+	switch recordType {
+	case walRecordTerm:
+		var term raft.Term
+		if err := json.Unmarshal(payload, &term); err != nil {
+			return err
+		}
+		return w.MemoryStorage.SaveCurrentTerm(term)
+	case walRecordVote:
+		var votedFor raft.NodeID
+		if err := json.Unmarshal(payload, &votedFor); err != nil {
+			return err
+		}
+		return w.MemoryStorage.SaveVotedFor(votedFor)
+	case walRecordEntries:
+		var entries []raft.LogEntry
+		if err := json.Unmarshal(payload, &entries); err != nil {
+			return err
+		}
+		return w.MemoryStorage.SaveLogEntries(entries)
+	case walRecordTruncate:
+		var index raft.LogIndex
+		if err := json.Unmarshal(payload, &index); err != nil {
+			return err
+		}
+		return w.MemoryStorage.TruncateLog(index)
+	default:
+		return fmt.Errorf("未知的WAL记录类型: %d", recordType)
+	}
+}
+
+// walPath WAL文件路径
+func (w *WALStorage) walPath() string {
+	return filepath.Join(w.dir, walFileName)
+}
+
+// encodeWALRecord 编码一条WAL记录
+func encodeWALRecord(recordType byte, payload []byte) []byte {
+	record := make([]byte, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	record[8] = recordType
+	copy(record[walHeaderSize:], payload)
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(record[8:], walCRCTable))
+	return record
+}
+
+// readWALRecord 读取并校验一条WAL记录
+func readWALRecord(r io.Reader) (byte, []byte, error) {
+	var header [walHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length > walMaxRecordSize {
+		return 0, nil, fmt.Errorf("%w: 记录长度 %d 超出上限", errWALCorrupt, length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+
+	checksum := crc32.Update(crc32.Checksum(header[8:9], walCRCTable), walCRCTable, payload)
+	if checksum != binary.LittleEndian.Uint32(header[4:8]) {
+		return 0, nil, fmt.Errorf("%w: 校验和不匹配", errWALCorrupt)
+	}
+
+	return header[8], payload, nil
+}
+
+// writeFileAtomic 先写临时文件并落盘，再原子替换目标文件
+func writeFileAtomic(dir, name string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, name+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir 刷新目录项，保证重命名在崩溃后可见
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,319 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - wal_test.go
+ */
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+)
+
+// testEntry 构造测试用日志条目
+func testEntry(index raft.LogIndex, term raft.Term) raft.LogEntry {
+	return raft.LogEntry{
+		Index:     index,
+		Term:      term,
+		Timestamp: time.Now(),
+		Type:      raft.EntryNormal,
+		Data:      []byte(fmt.Sprintf(`{"type":"SET","key":"k%d","value":"v"}`, index)),
+	}
+}
+
+// TestWALReplay 重新打开后恢复任期、投票、日志与截断
+func TestWALReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := NewWALStorage(dir, WALOptions{SyncPolicy: SyncAlways})
+	if err != nil {
+		t.Fatalf("打开WAL失败: %v", err)
+	}
+	w.SaveCurrentTerm(3)
+	w.SaveVotedFor("node2")
+	for i := raft.LogIndex(1); i <= 10; i++ {
+		if err := w.SaveLogEntries([]raft.LogEntry{testEntry(i, 3)}); err != nil {
+			t.Fatalf("追加日志失败: %v", err)
+		}
+	}
+	w.TruncateLog(7)
+	w.SaveLogEntries([]raft.LogEntry{testEntry(8, 4)})
+	w.Close()
+
+	w, err = NewWALStorage(dir, WALOptions{SyncPolicy: SyncAlways})
+	if err != nil {
+		t.Fatalf("重新打开WAL失败: %v", err)
+	}
+	defer w.Close()
+
+	if term, _ := w.GetCurrentTerm(); term != 3 {
+		t.Errorf("任期应为3，实际 %d", term)
+	}
+	if votedFor, _ := w.GetVotedFor(); votedFor != "node2" {
+		t.Errorf("投票对象应为node2，实际 %s", votedFor)
+	}
+	if last := w.GetLastLogIndex(); last != 8 {
+		t.Fatalf("最后日志索引应为8，实际 %d", last)
+	}
+	if term := w.GetLastLogTerm(); term != 4 {
+		t.Errorf("最后日志任期应为4，实际 %d", term)
+	}
+}
+
+// TestWALSnapshotCompaction 保存快照后WAL只保留快照之后的日志，重启后快照与日志一并恢复
+func TestWALSnapshotCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := NewWALStorage(dir, WALOptions{SyncPolicy: SyncGroup})
+	if err != nil {
+		t.Fatalf("打开WAL失败: %v", err)
+	}
+	w.SaveCurrentTerm(2)
+	for i := raft.LogIndex(1); i <= 20; i++ {
+		w.SaveLogEntries([]raft.LogEntry{testEntry(i, 2)})
+	}
+
+	sizeBefore := fileSize(t, filepath.Join(dir, walFileName))
+	if err := w.SaveSnapshot(&raft.Snapshot{LastIncludedIndex: 15, LastIncludedTerm: 2, Data: []byte("state")}); err != nil {
+		t.Fatalf("保存快照失败: %v", err)
+	}
+	if sizeAfter := fileSize(t, filepath.Join(dir, walFileName)); sizeAfter >= sizeBefore {
+		t.Errorf("快照后WAL未被压缩: %d -> %d 字节", sizeBefore, sizeAfter)
+	}
+
+	w.SaveLogEntries([]raft.LogEntry{testEntry(21, 2)})
+	w.Close()
+
+	w, err = NewWALStorage(dir, WALOptions{SyncPolicy: SyncGroup})
+	if err != nil {
+		t.Fatalf("重新打开WAL失败: %v", err)
+	}
+	defer w.Close()
+
+	snapshot, err := w.GetSnapshot()
+	if err != nil || snapshot.LastIncludedIndex != 15 || string(snapshot.Data) != "state" {
+		t.Fatalf("快照恢复异常: %+v, %v", snapshot, err)
+	}
+	if _, err := w.GetLogEntry(15); err == nil {
+		t.Errorf("快照覆盖的日志不应再可读")
+	}
+	for i := raft.LogIndex(16); i <= 21; i++ {
+		if _, err := w.GetLogEntry(i); err != nil {
+			t.Errorf("日志 %d 丢失: %v", i, err)
+		}
+	}
+}
+
+// TestWALTornTail 尾部写了一半的记录在恢复时被截断，之前的记录完好
+func TestWALTornTail(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := NewWALStorage(dir, WALOptions{SyncPolicy: SyncAlways})
+	if err != nil {
+		t.Fatalf("打开WAL失败: %v", err)
+	}
+	for i := raft.LogIndex(1); i <= 5; i++ {
+		w.SaveLogEntries([]raft.LogEntry{testEntry(i, 1)})
+	}
+	w.Close()
+
+	// 模拟崩溃时只写入了部分的第6条记录
+	path := filepath.Join(dir, walFileName)
+	record := encodeWALRecord(walRecordEntries, []byte(`[{"index":6,"term":1}]`))
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write(record[:len(record)/2])
+	f.Close()
+	sizeTorn := fileSize(t, path)
+
+	w, err = NewWALStorage(dir, WALOptions{SyncPolicy: SyncAlways})
+	if err != nil {
+		t.Fatalf("重新打开WAL失败: %v", err)
+	}
+	if last := w.GetLastLogIndex(); last != 5 {
+		t.Fatalf("最后日志索引应为5，实际 %d", last)
+	}
+	if size := fileSize(t, path); size >= sizeTorn {
+		t.Errorf("不完整的尾部记录未被截断")
+	}
+
+	// 截断后继续追加，再次恢复时新记录可见
+	w.SaveLogEntries([]raft.LogEntry{testEntry(6, 1)})
+	w.Close()
+
+	w, err = NewWALStorage(dir, WALOptions{SyncPolicy: SyncAlways})
+	if err != nil {
+		t.Fatalf("重新打开WAL失败: %v", err)
+	}
+	defer w.Close()
+	if last := w.GetLastLogIndex(); last != 6 {
+		t.Fatalf("最后日志索引应为6，实际 %d", last)
+	}
+}
+
+// TestWALSyncPolicies 各策略的刷盘次数：always每次追加一次，group合并并发追加，interval由后台刷盘
+func TestWALSyncPolicies(t *testing.T) {
+	const writers, perWriter = 16, 25
+
+	for _, policy := range []SyncPolicy{SyncAlways, SyncGroup, SyncInterval} {
+		t.Run(string(policy), func(t *testing.T) {
+			w, err := NewWALStorage(t.TempDir(), WALOptions{SyncPolicy: policy, SyncInterval: 5 * time.Millisecond})
+			if err != nil {
+				t.Fatalf("打开WAL失败: %v", err)
+			}
+			defer w.Close()
+
+			// 模拟较慢的磁盘，使并发写入者有机会在一次fsync期间排队
+			w.fileMu.Lock()
+			w.fsync = func(f *os.File) error {
+				time.Sleep(200 * time.Microsecond)
+				return f.Sync()
+			}
+			w.fileMu.Unlock()
+
+			concurrentAppend(t, w, writers, perWriter, nil)
+
+			if policy == SyncInterval {
+				time.Sleep(50 * time.Millisecond)
+			}
+
+			stats := w.SyncStats()
+			total := uint64(writers * perWriter)
+			if stats.SyncedAppends != total {
+				t.Fatalf("已落盘追加数应为 %d，实际 %+v", total, stats)
+			}
+
+			switch policy {
+			case SyncAlways:
+				if stats.Syncs != total {
+					t.Errorf("always策略应每次追加fsync一次: %+v", stats)
+				}
+			default:
+				if stats.Syncs >= total || stats.AvgBatchSize <= 1 {
+					t.Errorf("%s策略未合并刷盘: %+v", policy, stats)
+				}
+			}
+		})
+	}
+}
+
+// concurrentAppend 多个写入者并发追加交错且互不重叠的日志索引，每次追加返回后调用ack
+func concurrentAppend(t testing.TB, w *WALStorage, writers, perWriter int, ack func(raft.LogIndex)) {
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(writer int) {
+			defer wg.Done()
+			for j := 0; j < perWriter; j++ {
+				index := raft.LogIndex(j*writers + writer + 1)
+				if err := w.SaveLogEntries([]raft.LogEntry{testEntry(index, 1)}); err != nil {
+					t.Errorf("追加日志 %d 失败: %v", index, err)
+					return
+				}
+				if ack != nil {
+					ack(index)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TestWALCrashSafety 子进程并发追加并报告已确认的索引，被kill -9后重新打开，已确认的条目一条不丢
+// kill -9不会丢弃页缓存，该测试主要验证确认发生在写入之后以及崩溃后的恢复；掉电场景依赖fsync语义
+func TestWALCrashSafety(t *testing.T) {
+	if testing.Short() {
+		t.Skip("短测试模式跳过崩溃测试")
+	}
+
+	for _, policy := range []SyncPolicy{SyncAlways, SyncGroup} {
+		t.Run(string(policy), func(t *testing.T) {
+			dir := t.TempDir()
+
+			cmd := exec.Command(os.Args[0], "-test.run=^TestWALCrashHelper$")
+			cmd.Env = append(os.Environ(), "WAL_CRASH_DIR="+dir, "WAL_CRASH_POLICY="+string(policy))
+			stdout, err := cmd.StdoutPipe()
+			if err != nil {
+				t.Fatalf("创建管道失败: %v", err)
+			}
+			if err := cmd.Start(); err != nil {
+				t.Fatalf("启动子进程失败: %v", err)
+			}
+
+			acked := make(map[raft.LogIndex]bool)
+			scanner := bufio.NewScanner(stdout)
+			for scanner.Scan() && len(acked) < 2000 {
+				line := scanner.Text()
+				if !strings.HasPrefix(line, "ack ") {
+					continue
+				}
+				index, err := strconv.ParseUint(strings.TrimPrefix(line, "ack "), 10, 64)
+				if err != nil {
+					t.Fatalf("解析确认失败: %q", line)
+				}
+				acked[raft.LogIndex(index)] = true
+			}
+
+			cmd.Process.Kill()
+			cmd.Wait()
+
+			if len(acked) == 0 {
+				t.Fatalf("子进程未确认任何写入")
+			}
+
+			w, err := NewWALStorage(dir, WALOptions{SyncPolicy: policy})
+			if err != nil {
+				t.Fatalf("崩溃后打开WAL失败: %v", err)
+			}
+			defer w.Close()
+
+			for index := range acked {
+				if _, err := w.GetLogEntry(index); err != nil {
+					t.Errorf("已确认的日志 %d 在崩溃后丢失: %v", index, err)
+				}
+			}
+		})
+	}
+}
+
+// TestWALCrashHelper 由TestWALCrashSafety以子进程方式运行，持续写入直到被杀死
+func TestWALCrashHelper(t *testing.T) {
+	dir := os.Getenv("WAL_CRASH_DIR")
+	if dir == "" {
+		t.Skip("仅作为崩溃测试的子进程运行")
+	}
+
+	w, err := NewWALStorage(dir, WALOptions{SyncPolicy: SyncPolicy(os.Getenv("WAL_CRASH_POLICY"))})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "打开WAL失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	var mu sync.Mutex
+	concurrentAppend(t, w, 8, 1<<20, func(index raft.LogIndex) {
+		mu.Lock()
+		fmt.Printf("ack %d\n", index)
+		mu.Unlock()
+	})
+}
+
+// fileSize 获取文件大小
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("获取文件信息失败: %v", err)
+	}
+	return info.Size()
+}
@@ -0,0 +1,224 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-8-1 10:31:08
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-8-1 10:31:08
+* @Description: ConcordKV Raft consensus server - fake_clock.go
+ */
+
+// Package testutil 测试辅助工具。
+// FakeClock 实现clock.Clock，时间只在调用Advance时前进，定时器按到期时间确定地触发，
+// 依赖时间的测试不需要sleep等待真实时间。客户端（client/go/testutil）保留一份相同的实现
+package testutil
+
+import (
+	"sync"
+	"time"
+
+	"raftserver/clock"
+)
+
+// FakeClock 可控的时钟
+//
+// 一次性定时器（NewTimer、After、Sleep）的通道容量为1，到期时不阻塞地放入时间，与time.Timer相同；
+// 周期定时器（NewTicker）的通道无缓冲，Advance等到接收方取走这一次触发（或定时器被停止）后才继续，
+// 因此Advance返回时各周期循环已经开始处理最后一次触发，再次Advance时上一次的处理已经结束
+type FakeClock struct {
+	mu      sync.Mutex
+	changed *sync.Cond
+	now     time.Time
+	waiters []*waiter // 未到期的定时器，按创建顺序排列，到期时间相同时先创建的先触发
+}
+
+// NewFakeClock 创建当前时间为start的时钟
+func NewFakeClock(start time.Time) *FakeClock {
+	f := &FakeClock{now: start}
+	f.changed = sync.NewCond(&f.mu)
+	return f
+}
+
+// Now 当前时间
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// NewTimer 创建d之后触发一次的定时器，d不大于0时立即触发
+func (f *FakeClock) NewTimer(d time.Duration) clock.Timer {
+	t := fakeTimer{&waiter{clock: f, c: make(chan time.Time, 1)}}
+	t.schedule(d)
+	return t
+}
+
+// NewTicker 创建每隔d触发一次的周期定时器
+func (f *FakeClock) NewTicker(d time.Duration) clock.Ticker {
+	if d <= 0 {
+		panic("testutil: NewTicker的间隔必须大于0")
+	}
+	t := fakeTicker{&waiter{clock: f, c: make(chan time.Time), period: d}}
+	t.schedule(d)
+	return t
+}
+
+// Sleep 阻塞到时钟前进d
+func (f *FakeClock) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+// After 时钟前进d后收到当时时间的通道
+func (f *FakeClock) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+// Advance 时钟前进d，其间到期的定时器按到期时间依次触发，触发时Now返回该定时器的到期时间
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	target := f.now.Add(d)
+	f.mu.Unlock()
+
+	for {
+		f.mu.Lock()
+		next := f.nextDueLocked(target)
+		if next == nil {
+			if target.After(f.now) {
+				f.now = target
+			}
+			f.mu.Unlock()
+			return
+		}
+
+		if next.deadline.After(f.now) {
+			f.now = next.deadline
+		}
+		tick := f.now
+		periodic, stopped := next.period > 0, next.stopped
+		if periodic {
+			next.deadline = next.deadline.Add(next.period)
+		} else {
+			f.removeLocked(next)
+		}
+		f.mu.Unlock()
+
+		if periodic {
+			select {
+			case next.c <- tick:
+			case <-stopped:
+			}
+		} else {
+			select {
+			case next.c <- tick:
+			default:
+			}
+		}
+	}
+}
+
+// BlockUntil 阻塞到至少有n个未停止的定时器，用于等待后台循环创建好定时器后再Advance
+func (f *FakeClock) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.changed.Wait()
+	}
+}
+
+// nextDueLocked 到期时间不晚于target的定时器中最早到期的一个（调用方需持有mu）
+func (f *FakeClock) nextDueLocked(target time.Time) *waiter {
+	var next *waiter
+	for _, t := range f.waiters {
+		if t.deadline.After(target) {
+			continue
+		}
+		if next == nil || t.deadline.Before(next.deadline) {
+			next = t
+		}
+	}
+	return next
+}
+
+// removeLocked 移除定时器，返回定时器是否未停止（调用方需持有mu）
+func (f *FakeClock) removeLocked(w *waiter) bool {
+	for i, other := range f.waiters {
+		if other == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			f.changed.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+// waiter FakeClock的定时器，period大于0时为周期定时器
+type waiter struct {
+	clock    *FakeClock
+	c        chan time.Time
+	deadline time.Time
+	period   time.Duration
+	stopped  chan struct{} // 周期定时器停止时关闭，结束正在等待接收方的触发
+}
+
+func (w *waiter) C() <-chan time.Time {
+	return w.c
+}
+
+// Stop 停止定时器，返回定时器停止前是否还未触发
+func (w *waiter) Stop() bool {
+	f := w.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	active := f.removeLocked(w)
+	if active && w.stopped != nil {
+		close(w.stopped)
+		w.stopped = nil
+	}
+	return active
+}
+
+// schedule 从当前时间起d后到期，返回重新调度前定时器是否还未触发
+func (w *waiter) schedule(d time.Duration) bool {
+	f := w.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	active := f.removeLocked(w)
+	if w.period == 0 && d <= 0 {
+		select {
+		case w.c <- f.now:
+		default:
+		}
+		return active
+	}
+	if w.period > 0 {
+		w.period = d
+		if w.stopped == nil {
+			w.stopped = make(chan struct{})
+		}
+	}
+	w.deadline = f.now.Add(d)
+	f.waiters = append(f.waiters, w)
+	f.changed.Broadcast()
+	return active
+}
+
+// fakeTimer 一次性定时器
+type fakeTimer struct{ *waiter }
+
+func (t fakeTimer) Reset(d time.Duration) bool {
+	return t.schedule(d)
+}
+
+// fakeTicker 周期定时器
+type fakeTicker struct{ *waiter }
+
+func (t fakeTicker) Stop() {
+	t.waiter.Stop()
+}
+
+func (t fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("testutil: Ticker.Reset的间隔必须大于0")
+	}
+	t.schedule(d)
+}
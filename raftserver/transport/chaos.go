@@ -0,0 +1,402 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-12 10:15:42
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-12 10:15:42
+* @Description: ConcordKV Raft consensus server - chaos.go
+ */
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"raftserver/raft"
+)
+
+// ErrMessageDropped 消息被故障规则丢弃
+var ErrMessageDropped = errors.New("消息被故障注入丢弃")
+
+// duplicateTimeout 重复投递的消息在原请求返回后独立发送，使用的超时时间
+const duplicateTimeout = time.Second
+
+// MessageType 故障规则作用的消息类型，可按位组合
+type MessageType uint8
+
+const (
+	MessageVote MessageType = 1 << iota
+	MessagePreVote
+	MessageAppendEntries
+	MessageInstallSnapshot
+	MessageTimeoutNow
+	MessageCompressedAppendEntries
+	MessagePing
+	MessageForwardPropose
+
+	// AllMessages 所有消息类型，规则未指定消息类型时使用
+	AllMessages = MessageVote | MessagePreVote | MessageAppendEntries | MessageInstallSnapshot |
+		MessageTimeoutNow | MessageCompressedAppendEntries | MessagePing | MessageForwardPropose
+)
+
+var messageTypeNames = []string{"vote", "pre-vote", "append-entries", "install-snapshot", "timeout-now", "compressed-append-entries", "ping", "forward-propose"}
+
+func (m MessageType) String() string {
+	var names []string
+	for i, name := range messageTypeNames {
+		if m&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// RuleID 故障规则ID，用于单独撤销规则
+type RuleID uint64
+
+// chaosRule 故障规则：作用于匹配的链路上指定类型的消息
+type chaosRule struct {
+	types     MessageType
+	match     func(from, to raft.NodeID) bool
+	drop      float64 // 丢弃概率
+	minDelay  time.Duration
+	maxDelay  time.Duration
+	duplicate float64 // 重复投递概率
+}
+
+// ChaosStats 故障注入统计
+type ChaosStats struct {
+	Delivered  uint64 `json:"delivered"`  // 投递给下层传输层的消息数（不含重复投递）
+	Dropped    uint64 `json:"dropped"`    // 被丢弃的消息数
+	Delayed    uint64 `json:"delayed"`    // 被延迟的消息数
+	Duplicated uint64 `json:"duplicated"` // 被重复投递的消息数
+}
+
+// Chaos 故障注入控制器，由同一集群所有节点的ChaosTransport共享
+// 规则可在运行时随时添加和撤销，对之后发送的消息生效
+type Chaos struct {
+	mu     sync.Mutex
+	rules  map[RuleID]*chaosRule
+	nextID RuleID
+	rand   *rand.Rand
+	stats  ChaosStats
+}
+
+// NewChaos 创建故障注入控制器，相同的seed产生相同的随机故障序列
+func NewChaos(seed int64) *Chaos {
+	return &Chaos{
+		rules: make(map[RuleID]*chaosRule),
+		rand:  rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Wrap 用故障注入包装节点的传输层
+func (c *Chaos) Wrap(id raft.NodeID, inner raft.Transport) *ChaosTransport {
+	return &ChaosTransport{id: id, inner: inner, chaos: c}
+}
+
+// Partition 把节点分为两组，组间指定类型的消息全部丢弃，组内不受影响
+func (c *Chaos) Partition(groupA, groupB []raft.NodeID, types ...MessageType) RuleID {
+	a, b := nodeSet(groupA), nodeSet(groupB)
+	return c.addRule(&chaosRule{
+		types: messageTypes(types),
+		match: func(from, to raft.NodeID) bool {
+			return (a[from] && b[to]) || (b[from] && a[to])
+		},
+		drop: 1,
+	})
+}
+
+// Isolate 丢弃节点发出和收到的指定类型的消息
+func (c *Chaos) Isolate(node raft.NodeID, types ...MessageType) RuleID {
+	return c.addRule(&chaosRule{
+		types: messageTypes(types),
+		match: func(from, to raft.NodeID) bool { return from == node || to == node },
+		drop:  1,
+	})
+}
+
+// DropPercent 按百分比（0-100）随机丢弃指定类型的消息
+func (c *Chaos) DropPercent(percent float64, types ...MessageType) RuleID {
+	return c.addRule(&chaosRule{types: messageTypes(types), drop: percent / 100})
+}
+
+// Delay 指定类型的消息在[min, max]内随机延迟后投递
+func (c *Chaos) Delay(min, max time.Duration, types ...MessageType) RuleID {
+	if max < min {
+		max = min
+	}
+	return c.addRule(&chaosRule{types: messageTypes(types), minDelay: min, maxDelay: max})
+}
+
+// Duplicate 按百分比（0-100）把指定类型的消息重复投递一次，重复的消息在原消息返回后发送
+func (c *Chaos) Duplicate(percent float64, types ...MessageType) RuleID {
+	return c.addRule(&chaosRule{types: messageTypes(types), duplicate: percent / 100})
+}
+
+// Heal 撤销故障规则，规则不存在时返回false
+func (c *Chaos) Heal(id RuleID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.rules[id]
+	delete(c.rules, id)
+	return ok
+}
+
+// HealAll 撤销所有故障规则
+func (c *Chaos) HealAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules = make(map[RuleID]*chaosRule)
+}
+
+// Stats 获取故障注入统计
+func (c *Chaos) Stats() ChaosStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func (c *Chaos) addRule(rule *chaosRule) RuleID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextID++
+	c.rules[c.nextID] = rule
+	return c.nextID
+}
+
+// chaosVerdict 对一条消息应用规则的结果
+type chaosVerdict struct {
+	drop      bool
+	delay     time.Duration
+	duplicate bool
+}
+
+// judge 对从from发往to的消息应用所有匹配的规则：任一规则丢弃即丢弃，延迟取各规则的最大值
+func (c *Chaos) judge(from, to raft.NodeID, msgType MessageType) chaosVerdict {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var verdict chaosVerdict
+	for _, rule := range c.rules {
+		if rule.types&msgType == 0 || (rule.match != nil && !rule.match(from, to)) {
+			continue
+		}
+		if rule.drop > 0 && c.rand.Float64() < rule.drop {
+			verdict.drop = true
+		}
+		if rule.maxDelay > 0 {
+			delay := rule.minDelay
+			if span := rule.maxDelay - rule.minDelay; span > 0 {
+				delay += time.Duration(c.rand.Int63n(int64(span) + 1))
+			}
+			if delay > verdict.delay {
+				verdict.delay = delay
+			}
+		}
+		if rule.duplicate > 0 && c.rand.Float64() < rule.duplicate {
+			verdict.duplicate = true
+		}
+	}
+
+	switch {
+	case verdict.drop:
+		c.stats.Dropped++
+	default:
+		c.stats.Delivered++
+		if verdict.delay > 0 {
+			c.stats.Delayed++
+		}
+		if verdict.duplicate {
+			c.stats.Duplicated++
+		}
+	}
+	return verdict
+}
+
+func nodeSet(nodes []raft.NodeID) map[raft.NodeID]bool {
+	set := make(map[raft.NodeID]bool, len(nodes))
+	for _, node := range nodes {
+		set[node] = true
+	}
+	return set
+}
+
+func messageTypes(types []MessageType) MessageType {
+	var mask MessageType
+	for _, t := range types {
+		mask |= t
+	}
+	if mask == 0 {
+		return AllMessages
+	}
+	return mask
+}
+
+// ChaosTransport 按Chaos的规则对发出的消息注入故障，再交给下层传输层发送
+// 下层传输层的可选接口（Pinger、PeerManager、CompressedAppendEntriesSender）在未实现时返回错误或忽略
+type ChaosTransport struct {
+	id    raft.NodeID
+	inner raft.Transport
+	chaos *Chaos
+
+	// 在途的重复投递，Stop时等待完成
+	duplicates sync.WaitGroup
+}
+
+// deliver 按规则丢弃、延迟或重复投递消息，send在下层传输层上发送一次消息
+func (t *ChaosTransport) deliver(ctx context.Context, target raft.NodeID, msgType MessageType, send func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	verdict := t.chaos.judge(t.id, target, msgType)
+	if verdict.delay > 0 {
+		timer := time.NewTimer(verdict.delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+	if verdict.drop {
+		return nil, fmt.Errorf("%w: %s %s -> %s", ErrMessageDropped, msgType, t.id, target)
+	}
+
+	resp, err := send(ctx)
+	if verdict.duplicate {
+		t.duplicates.Add(1)
+		go func() {
+			defer t.duplicates.Done()
+			dupCtx, cancel := context.WithTimeout(context.Background(), duplicateTimeout)
+			defer cancel()
+			send(dupCtx)
+		}()
+	}
+	return resp, err
+}
+
+// SendVoteRequest 发送投票或预投票请求
+func (t *ChaosTransport) SendVoteRequest(ctx context.Context, target raft.NodeID, req *raft.VoteRequest) (*raft.VoteResponse, error) {
+	msgType := MessageVote
+	if req.PreVote {
+		msgType = MessagePreVote
+	}
+	resp, err := t.deliver(ctx, target, msgType, func(ctx context.Context) (interface{}, error) {
+		return t.inner.SendVoteRequest(ctx, target, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*raft.VoteResponse), nil
+}
+
+// SendAppendEntries 发送追加日志请求
+func (t *ChaosTransport) SendAppendEntries(ctx context.Context, target raft.NodeID, req *raft.AppendEntriesRequest) (*raft.AppendEntriesResponse, error) {
+	resp, err := t.deliver(ctx, target, MessageAppendEntries, func(ctx context.Context) (interface{}, error) {
+		return t.inner.SendAppendEntries(ctx, target, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*raft.AppendEntriesResponse), nil
+}
+
+// SendInstallSnapshot 发送安装快照请求
+func (t *ChaosTransport) SendInstallSnapshot(ctx context.Context, target raft.NodeID, req *raft.InstallSnapshotRequest) (*raft.InstallSnapshotResponse, error) {
+	resp, err := t.deliver(ctx, target, MessageInstallSnapshot, func(ctx context.Context) (interface{}, error) {
+		return t.inner.SendInstallSnapshot(ctx, target, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*raft.InstallSnapshotResponse), nil
+}
+
+// SendTimeoutNow 发送TimeoutNow请求
+func (t *ChaosTransport) SendTimeoutNow(ctx context.Context, target raft.NodeID, req *raft.TimeoutNowRequest) (*raft.TimeoutNowResponse, error) {
+	resp, err := t.deliver(ctx, target, MessageTimeoutNow, func(ctx context.Context) (interface{}, error) {
+		return t.inner.SendTimeoutNow(ctx, target, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*raft.TimeoutNowResponse), nil
+}
+
+// SendCompressedAppendEntries 发送压缩的追加请求，下层传输层不支持时返回错误
+func (t *ChaosTransport) SendCompressedAppendEntries(ctx context.Context, target raft.NodeID, req *raft.CompressedAppendEntriesRequest) (*raft.CompressedAppendEntriesResponse, error) {
+	sender, ok := t.inner.(raft.CompressedAppendEntriesSender)
+	if !ok {
+		return nil, fmt.Errorf("下层传输层不支持压缩的追加请求")
+	}
+	resp, err := t.deliver(ctx, target, MessageCompressedAppendEntries, func(ctx context.Context) (interface{}, error) {
+		return sender.SendCompressedAppendEntries(ctx, target, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*raft.CompressedAppendEntriesResponse), nil
+}
+
+// ForwardPropose 转发客户端写请求，下层传输层不支持时返回错误
+func (t *ChaosTransport) ForwardPropose(ctx context.Context, target raft.NodeID, req *raft.ForwardProposeRequest) (*raft.ForwardProposeResponse, error) {
+	forwarder, ok := t.inner.(raft.WriteForwarder)
+	if !ok {
+		return nil, fmt.Errorf("下层传输层不支持转发写请求")
+	}
+	resp, err := t.deliver(ctx, target, MessageForwardPropose, func(ctx context.Context) (interface{}, error) {
+		return forwarder.ForwardPropose(ctx, target, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*raft.ForwardProposeResponse), nil
+}
+
+// Ping 探测目标节点，下层传输层不支持时只按故障规则判断是否可达
+func (t *ChaosTransport) Ping(ctx context.Context, target raft.NodeID) error {
+	_, err := t.deliver(ctx, target, MessagePing, func(ctx context.Context) (interface{}, error) {
+		if pinger, ok := t.inner.(raft.Pinger); ok {
+			return nil, pinger.Ping(ctx, target)
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// AddPeer 转发给下层传输层
+func (t *ChaosTransport) AddPeer(id raft.NodeID, addr string) {
+	if pm, ok := t.inner.(raft.PeerManager); ok {
+		pm.AddPeer(id, addr)
+	}
+}
+
+// RemovePeer 转发给下层传输层
+func (t *ChaosTransport) RemovePeer(id raft.NodeID) {
+	if pm, ok := t.inner.(raft.PeerManager); ok {
+		pm.RemovePeer(id)
+	}
+}
+
+// Start 启动下层传输层
+func (t *ChaosTransport) Start() error {
+	return t.inner.Start()
+}
+
+// Stop 等待在途的重复投递完成后停止下层传输层
+func (t *ChaosTransport) Stop() error {
+	t.duplicates.Wait()
+	return t.inner.Stop()
+}
+
+// LocalAddr 下层传输层的本地地址
+func (t *ChaosTransport) LocalAddr() string {
+	return t.inner.LocalAddr()
+}
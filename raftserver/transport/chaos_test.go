@@ -0,0 +1,132 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-12 10:15:42
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-12 10:15:42
+* @Description: ConcordKV Raft consensus server - chaos_test.go
+ */
+package transport
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+)
+
+// newChaosNetwork 在进程内网络上创建n1、n2、n3三个节点，返回n1的ChaosTransport和各节点的处理器
+func newChaosNetwork(t *testing.T, chaos *Chaos) (*ChaosTransport, map[raft.NodeID]*recordingHandler) {
+	t.Helper()
+
+	network := NewMemoryNetwork()
+	handlers := make(map[raft.NodeID]*recordingHandler)
+	var local *ChaosTransport
+	for _, id := range []raft.NodeID{"n1", "n2", "n3"} {
+		memory := network.Transport(id)
+		handlers[id] = &recordingHandler{}
+		memory.SetHandler(handlers[id])
+		wrapped := chaos.Wrap(id, memory)
+		if err := wrapped.Start(); err != nil {
+			t.Fatalf("启动传输层失败: %v", err)
+		}
+		if id == "n1" {
+			local = wrapped
+		}
+	}
+	return local, handlers
+}
+
+func (h *recordingHandler) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.requests)
+}
+
+// TestChaosRules 分区和隔离按消息类型生效，撤销后恢复投递
+func TestChaosRules(t *testing.T) {
+	chaos := NewChaos(1)
+	local, handlers := newChaosNetwork(t, chaos)
+	ctx := context.Background()
+	vote := &raft.VoteRequest{Term: 2, CandidateID: "n1"}
+	preVote := &raft.VoteRequest{Term: 2, CandidateID: "n1", PreVote: true}
+	appendReq := &raft.AppendEntriesRequest{Term: 2, LeaderID: "n1"}
+
+	partition := chaos.Partition([]raft.NodeID{"n1"}, []raft.NodeID{"n2", "n3"}, MessageAppendEntries)
+	if _, err := local.SendAppendEntries(ctx, "n2", appendReq); !errors.Is(err, ErrMessageDropped) {
+		t.Fatalf("分区两侧的追加请求应被丢弃: %v", err)
+	}
+	if resp, err := local.SendVoteRequest(ctx, "n2", vote); err != nil || !resp.VoteGranted {
+		t.Fatalf("分区只作用于追加请求，投票应正常投递: %v, %v", resp, err)
+	}
+	if !chaos.Heal(partition) || chaos.Heal(partition) {
+		t.Fatalf("规则只能撤销一次")
+	}
+	if _, err := local.SendAppendEntries(ctx, "n2", appendReq); err != nil {
+		t.Fatalf("撤销分区后应恢复投递: %v", err)
+	}
+
+	chaos.Isolate("n3", MessagePreVote)
+	if _, err := local.SendVoteRequest(ctx, "n3", preVote); !errors.Is(err, ErrMessageDropped) {
+		t.Fatalf("隔离节点的预投票应被丢弃: %v", err)
+	}
+	if _, err := local.SendVoteRequest(ctx, "n3", vote); err != nil {
+		t.Fatalf("隔离只作用于预投票: %v", err)
+	}
+	if _, err := local.SendVoteRequest(ctx, "n2", preVote); err != nil {
+		t.Fatalf("未被隔离的节点应收到预投票: %v", err)
+	}
+
+	chaos.HealAll()
+	chaos.DropPercent(100, MessageTimeoutNow)
+	if _, err := local.SendTimeoutNow(ctx, "n2", &raft.TimeoutNowRequest{Term: 2}); !errors.Is(err, ErrMessageDropped) {
+		t.Fatalf("丢弃率100%%时消息应全部丢弃: %v", err)
+	}
+	if err := local.Ping(ctx, "n2"); err != nil {
+		t.Fatalf("丢弃规则只作用于TimeoutNow: %v", err)
+	}
+
+	if stats := chaos.Stats(); stats.Dropped != 3 || stats.Delivered != 5 {
+		t.Errorf("统计不正确: %+v", stats)
+	}
+	if handlers["n2"].count() != 3 || handlers["n3"].count() != 1 {
+		t.Errorf("处理器收到的请求数不正确: n2=%d n3=%d", handlers["n2"].count(), handlers["n3"].count())
+	}
+}
+
+// TestChaosDelayAndDuplicate 延迟在投递前生效并可被ctx取消，重复投递使处理器收到两次请求
+func TestChaosDelayAndDuplicate(t *testing.T) {
+	chaos := NewChaos(1)
+	local, handlers := newChaosNetwork(t, chaos)
+
+	chaos.Delay(50*time.Millisecond, 60*time.Millisecond, MessageAppendEntries)
+	start := time.Now()
+	if _, err := local.SendAppendEntries(context.Background(), "n2", &raft.AppendEntriesRequest{Term: 1}); err != nil {
+		t.Fatalf("延迟的请求应成功: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("请求应至少延迟50ms，实际 %v", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := local.SendAppendEntries(ctx, "n2", &raft.AppendEntriesRequest{Term: 1}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("延迟期间ctx超时应返回超时错误: %v", err)
+	}
+
+	chaos.HealAll()
+	chaos.Duplicate(100, MessageVote)
+	if _, err := local.SendVoteRequest(context.Background(), "n3", &raft.VoteRequest{Term: 3}); err != nil {
+		t.Fatalf("重复投递的请求应成功: %v", err)
+	}
+	if err := local.Stop(); err != nil {
+		t.Fatalf("停止传输层失败: %v", err)
+	}
+	if got := handlers["n3"].count(); got != 2 {
+		t.Fatalf("重复投递后处理器应收到2次请求，实际 %d", got)
+	}
+	if stats := chaos.Stats(); stats.Duplicated != 1 || stats.Delayed != 2 {
+		t.Errorf("统计不正确: %+v", stats)
+	}
+}
@@ -0,0 +1,488 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - grpc.go
+ */
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"raftserver/raft"
+	"raftserver/transport/raftpb"
+)
+
+const (
+	// grpcMaxMessageSize 单条消息上限，需容纳批量追加的日志与快照数据块
+	grpcMaxMessageSize = 64 << 20
+
+	// grpcStopTimeout 优雅关闭等待在途RPC完成的最长时间，超时后强制关闭
+	grpcStopTimeout = 5 * time.Second
+
+	// defaultGRPCElectionTimeout 未指定选举超时时使用的默认值
+	defaultGRPCElectionTimeout = 5 * time.Second
+)
+
+// CompressedAppendEntriesHandler 可选的处理器接口，处理跨数据中心复制的压缩追加请求
+// 负载校验失败时返回raft.ErrChecksumMismatch，传输层以DataLoss状态回应，发送方得到同一错误后重发
+type CompressedAppendEntriesHandler interface {
+	HandleCompressedAppendEntries(req *raft.CompressedAppendEntriesRequest) (*raft.CompressedAppendEntriesResponse, error)
+}
+
+// GRPCTransport gRPC传输层实现
+// 每个对端复用一个长连接（HTTP/2多路复用），RPC截止时间由选举超时推导：
+// 超过选举超时才返回的投票或追加响应已无意义，继续等待只会占用复制窗口
+type GRPCTransport struct {
+	mu              sync.RWMutex
+	addr            string
+	electionTimeout time.Duration
+	server          *grpc.Server
+	listener        net.Listener
+	peers           map[raft.NodeID]string
+	conns           map[raft.NodeID]*grpc.ClientConn
+	handler         TransportHandler
+	running         bool
+	tls             *TLSCredentials
+}
+
+// NewGRPCTransport 创建新的gRPC传输层
+func NewGRPCTransport(addr string, peers map[raft.NodeID]string, electionTimeout time.Duration) *GRPCTransport {
+	// 复制一份地址表，成员变更时会动态修改
+	peerAddrs := make(map[raft.NodeID]string, len(peers))
+	for id, peerAddr := range peers {
+		peerAddrs[id] = peerAddr
+	}
+
+	if electionTimeout <= 0 {
+		electionTimeout = defaultGRPCElectionTimeout
+	}
+
+	return &GRPCTransport{
+		addr:            addr,
+		electionTimeout: electionTimeout,
+		peers:           peerAddrs,
+		conns:           make(map[raft.NodeID]*grpc.ClientConn),
+	}
+}
+
+// SetHandler 设置传输处理器
+func (t *GRPCTransport) SetHandler(handler TransportHandler) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handler = handler
+}
+
+// SetTLS 启用TLS，节点间双向校验证书，需在Start之前调用
+func (t *GRPCTransport) SetTLS(creds *TLSCredentials) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tls = creds
+}
+
+// Start 启动传输层
+func (t *GRPCTransport) Start() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.running {
+		return fmt.Errorf("传输层已经启动")
+	}
+
+	listener, err := net.Listen("tcp", t.addr)
+	if err != nil {
+		return fmt.Errorf("监听地址失败: %w", err)
+	}
+
+	options := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(grpcMaxMessageSize),
+		grpc.MaxSendMsgSize(grpcMaxMessageSize),
+	}
+	if t.tls != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(t.tls.PeerServerConfig(t.isPeer))))
+	}
+
+	t.server = grpc.NewServer(options...)
+	raftpb.RegisterRaftServer(t.server, &grpcService{transport: t})
+	healthpb.RegisterHealthServer(t.server, health.NewServer())
+	t.listener = listener
+
+	go func(server *grpc.Server) {
+		if err := server.Serve(listener); err != nil && err != grpc.ErrServerStopped {
+			fmt.Printf("gRPC服务器错误: %v\n", err)
+		}
+	}(t.server)
+
+	t.running = true
+	fmt.Printf("gRPC传输层启动在 %s\n", listener.Addr())
+
+	return nil
+}
+
+// Stop 停止传输层，等待在途RPC完成后关闭，并断开所有对端连接
+func (t *GRPCTransport) Stop() error {
+	t.mu.Lock()
+	server := t.server
+	running := t.running
+	conns := t.conns
+	t.conns = make(map[raft.NodeID]*grpc.ClientConn)
+	t.running = false
+	t.mu.Unlock()
+
+	// 仅作为客户端使用时也需要关闭到对端的连接
+	for _, conn := range conns {
+		conn.Close()
+	}
+
+	if !running {
+		return nil
+	}
+
+	// 在锁外等待在途RPC，处理器执行期间可能需要读取传输层状态
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(grpcStopTimeout):
+		server.Stop()
+		<-stopped
+	}
+
+	fmt.Printf("gRPC传输层已停止\n")
+
+	return nil
+}
+
+// LocalAddr 获取本地地址
+func (t *GRPCTransport) LocalAddr() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	// 监听":0"等地址时返回实际绑定的地址
+	if t.listener != nil {
+		return t.listener.Addr().String()
+	}
+	return t.addr
+}
+
+// AddPeer 添加或更新对端地址，地址变化时关闭旧连接
+func (t *GRPCTransport) AddPeer(id raft.NodeID, addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if old, exists := t.peers[id]; exists && old != addr {
+		t.closeConnLocked(id)
+	}
+	t.peers[id] = addr
+}
+
+// RemovePeer 移除对端地址并关闭连接
+func (t *GRPCTransport) RemovePeer(id raft.NodeID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closeConnLocked(id)
+	delete(t.peers, id)
+}
+
+// isPeer 判断节点是否为已知的集群节点
+func (t *GRPCTransport) isPeer(id raft.NodeID) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	_, exists := t.peers[id]
+	return exists
+}
+
+// closeConnLocked 关闭到对端的连接，调用者需持有写锁
+func (t *GRPCTransport) closeConnLocked(id raft.NodeID) {
+	if conn, exists := t.conns[id]; exists {
+		conn.Close()
+		delete(t.conns, id)
+	}
+}
+
+// client 获取到目标节点的客户端，连接建立后被后续RPC复用
+func (t *GRPCTransport) client(target raft.NodeID) (raftpb.RaftClient, error) {
+	conn, err := t.conn(target)
+	if err != nil {
+		return nil, err
+	}
+	return raftpb.NewRaftClient(conn), nil
+}
+
+// conn 获取到目标节点的连接，不存在时惰性创建
+func (t *GRPCTransport) conn(target raft.NodeID) (*grpc.ClientConn, error) {
+	t.mu.RLock()
+	conn, exists := t.conns[target]
+	t.mu.RUnlock()
+	if exists {
+		return conn, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if conn, exists := t.conns[target]; exists {
+		return conn, nil
+	}
+
+	addr, exists := t.peers[target]
+	if !exists {
+		return nil, fmt.Errorf("未找到节点 %s 的地址", target)
+	}
+
+	transportCredentials := insecure.NewCredentials()
+	if t.tls != nil {
+		transportCredentials = credentials.NewTLS(t.tls.PeerClientConfig(target))
+	}
+
+	// 连接惰性建立，对端暂时不可达时由gRPC在后台重连
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(transportCredentials),
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(grpcMaxMessageSize),
+			grpc.MaxCallSendMsgSize(grpcMaxMessageSize),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("创建到节点 %s 的连接失败: %w", target, err)
+	}
+	t.conns[target] = conn
+
+	return conn, nil
+}
+
+// withDeadline 为RPC设置由选举超时推导的截止时间，调用方已设置更早的截止时间时保持不变
+func (t *GRPCTransport) withDeadline(ctx context.Context, multiple int) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, t.electionTimeout*time.Duration(multiple))
+}
+
+// SendVoteRequest 发送投票请求
+func (t *GRPCTransport) SendVoteRequest(ctx context.Context, target raft.NodeID, req *raft.VoteRequest) (*raft.VoteResponse, error) {
+	client, err := t.client(target)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := t.withDeadline(ctx, 1)
+	defer cancel()
+
+	resp, err := client.RequestVote(ctx, toPBVoteRequest(req))
+	if err != nil {
+		return nil, fmt.Errorf("发送gRPC请求失败: %w", err)
+	}
+	return fromPBVoteResponse(resp), nil
+}
+
+// SendAppendEntries 发送追加日志请求
+func (t *GRPCTransport) SendAppendEntries(ctx context.Context, target raft.NodeID, req *raft.AppendEntriesRequest) (*raft.AppendEntriesResponse, error) {
+	client, err := t.client(target)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := t.withDeadline(ctx, 1)
+	defer cancel()
+
+	resp, err := client.AppendEntries(ctx, toPBAppendEntriesRequest(req))
+	if err != nil {
+		return nil, fmt.Errorf("发送gRPC请求失败: %w", err)
+	}
+	return fromPBAppendEntriesResponse(resp), nil
+}
+
+// SendInstallSnapshot 发送安装快照请求，数据块较大，截止时间放宽为两倍选举超时
+func (t *GRPCTransport) SendInstallSnapshot(ctx context.Context, target raft.NodeID, req *raft.InstallSnapshotRequest) (*raft.InstallSnapshotResponse, error) {
+	client, err := t.client(target)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := t.withDeadline(ctx, 2)
+	defer cancel()
+
+	resp, err := client.InstallSnapshot(ctx, toPBInstallSnapshotRequest(req))
+	if err != nil {
+		return nil, fmt.Errorf("发送gRPC请求失败: %w", err)
+	}
+	return fromPBInstallSnapshotResponse(resp), nil
+}
+
+// SendTimeoutNow 发送TimeoutNow请求
+func (t *GRPCTransport) SendTimeoutNow(ctx context.Context, target raft.NodeID, req *raft.TimeoutNowRequest) (*raft.TimeoutNowResponse, error) {
+	client, err := t.client(target)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := t.withDeadline(ctx, 1)
+	defer cancel()
+
+	resp, err := client.TimeoutNow(ctx, toPBTimeoutNowRequest(req))
+	if err != nil {
+		return nil, fmt.Errorf("发送gRPC请求失败: %w", err)
+	}
+	return fromPBTimeoutNowResponse(resp), nil
+}
+
+// Ping 调用对端的gRPC健康检查服务，DC健康检查用它探测节点并测量往返延迟
+func (t *GRPCTransport) Ping(ctx context.Context, target raft.NodeID) error {
+	conn, err := t.conn(target)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := t.withDeadline(ctx, 1)
+	defer cancel()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return fmt.Errorf("发送gRPC请求失败: %w", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("节点 %s 健康状态为 %s", target, resp.Status)
+	}
+	return nil
+}
+
+// SendCompressedAppendEntries 发送跨数据中心复制的压缩追加请求
+func (t *GRPCTransport) SendCompressedAppendEntries(ctx context.Context, target raft.NodeID, req *raft.CompressedAppendEntriesRequest) (*raft.CompressedAppendEntriesResponse, error) {
+	client, err := t.client(target)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := t.withDeadline(ctx, 2)
+	defer cancel()
+
+	resp, err := client.CompressedAppendEntries(ctx, toPBCompressedAppendEntriesRequest(req))
+	if status.Code(err) == codes.DataLoss {
+		return nil, fmt.Errorf("节点 %s 拒绝批次 %s: %w", target, req.BatchID, raft.ErrChecksumMismatch)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("发送gRPC请求失败: %w", err)
+	}
+	return fromPBCompressedAppendEntriesResponse(resp), nil
+}
+
+// ForwardPropose 把客户端写请求转发给领导者，等待命令被应用后返回
+// 等待时间由调用方的上下文决定，不套用基于选举超时的截止时间
+func (t *GRPCTransport) ForwardPropose(ctx context.Context, target raft.NodeID, req *raft.ForwardProposeRequest) (*raft.ForwardProposeResponse, error) {
+	client, err := t.client(target)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.ForwardPropose(ctx, toPBForwardProposeRequest(req))
+	if err != nil {
+		return nil, fmt.Errorf("发送gRPC请求失败: %w", err)
+	}
+	return fromPBForwardProposeResponse(resp), nil
+}
+
+// getHandler 获取传输处理器
+func (t *GRPCTransport) getHandler() (TransportHandler, error) {
+	t.mu.RLock()
+	handler := t.handler
+	t.mu.RUnlock()
+
+	if handler == nil {
+		return nil, status.Error(codes.Unavailable, "处理器未设置")
+	}
+	return handler, nil
+}
+
+// grpcService 将gRPC调用转发给传输处理器
+type grpcService struct {
+	raftpb.UnimplementedRaftServer
+	transport *GRPCTransport
+}
+
+// RequestVote 处理投票请求
+func (s *grpcService) RequestVote(ctx context.Context, req *raftpb.VoteRequest) (*raftpb.VoteResponse, error) {
+	handler, err := s.transport.getHandler()
+	if err != nil {
+		return nil, err
+	}
+	return toPBVoteResponse(handler.HandleVoteRequest(fromPBVoteRequest(req))), nil
+}
+
+// AppendEntries 处理追加日志请求
+func (s *grpcService) AppendEntries(ctx context.Context, req *raftpb.AppendEntriesRequest) (*raftpb.AppendEntriesResponse, error) {
+	handler, err := s.transport.getHandler()
+	if err != nil {
+		return nil, err
+	}
+	return toPBAppendEntriesResponse(handler.HandleAppendEntries(fromPBAppendEntriesRequest(req))), nil
+}
+
+// InstallSnapshot 处理安装快照请求
+func (s *grpcService) InstallSnapshot(ctx context.Context, req *raftpb.InstallSnapshotRequest) (*raftpb.InstallSnapshotResponse, error) {
+	handler, err := s.transport.getHandler()
+	if err != nil {
+		return nil, err
+	}
+	return toPBInstallSnapshotResponse(handler.HandleInstallSnapshot(fromPBInstallSnapshotRequest(req))), nil
+}
+
+// TimeoutNow 处理TimeoutNow请求
+func (s *grpcService) TimeoutNow(ctx context.Context, req *raftpb.TimeoutNowRequest) (*raftpb.TimeoutNowResponse, error) {
+	handler, err := s.transport.getHandler()
+	if err != nil {
+		return nil, err
+	}
+	return toPBTimeoutNowResponse(handler.HandleTimeoutNow(fromPBTimeoutNowRequest(req))), nil
+}
+
+// CompressedAppendEntries 处理压缩的追加请求，处理器未实现时返回Unimplemented
+func (s *grpcService) CompressedAppendEntries(ctx context.Context, req *raftpb.CompressedAppendEntriesRequest) (*raftpb.CompressedAppendEntriesResponse, error) {
+	handler, err := s.transport.getHandler()
+	if err != nil {
+		return nil, err
+	}
+
+	compressedHandler, ok := handler.(CompressedAppendEntriesHandler)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "处理器不支持压缩的追加请求")
+	}
+	resp, err := compressedHandler.HandleCompressedAppendEntries(fromPBCompressedAppendEntriesRequest(req))
+	if errors.Is(err, raft.ErrChecksumMismatch) {
+		return nil, status.Error(codes.DataLoss, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return toPBCompressedAppendEntriesResponse(resp), nil
+}
+
+// ForwardPropose 处理跟随者转发的写请求，处理器未实现时返回Unimplemented
+func (s *grpcService) ForwardPropose(ctx context.Context, req *raftpb.ForwardProposeRequest) (*raftpb.ForwardProposeResponse, error) {
+	handler, err := s.transport.getHandler()
+	if err != nil {
+		return nil, err
+	}
+
+	forwardHandler, ok := handler.(ForwardProposeHandler)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "处理器不支持转发写请求")
+	}
+	return toPBForwardProposeResponse(forwardHandler.HandleForwardPropose(ctx, fromPBForwardProposeRequest(req))), nil
+}
@@ -0,0 +1,375 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - grpc_convert.go
+ */
+package transport
+
+import (
+	"time"
+
+	"raftserver/raft"
+	"raftserver/transport/raftpb"
+)
+
+// toPBEntries 转换日志条目
+func toPBEntries(entries []raft.LogEntry) []*raftpb.LogEntry {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	result := make([]*raftpb.LogEntry, len(entries))
+	for i, entry := range entries {
+		var timestamp int64
+		if !entry.Timestamp.IsZero() {
+			timestamp = entry.Timestamp.UnixNano()
+		}
+		result[i] = &raftpb.LogEntry{
+			Index:             uint64(entry.Index),
+			Term:              uint64(entry.Term),
+			TimestampUnixNano: timestamp,
+			Type:              int32(entry.Type),
+			Data:              entry.Data,
+		}
+	}
+	return result
+}
+
+// fromPBEntries 还原日志条目
+func fromPBEntries(entries []*raftpb.LogEntry) []raft.LogEntry {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	result := make([]raft.LogEntry, len(entries))
+	for i, entry := range entries {
+		var timestamp time.Time
+		if entry.GetTimestampUnixNano() != 0 {
+			timestamp = time.Unix(0, entry.GetTimestampUnixNano())
+		}
+		result[i] = raft.LogEntry{
+			Index:     raft.LogIndex(entry.GetIndex()),
+			Term:      raft.Term(entry.GetTerm()),
+			Timestamp: timestamp,
+			Type:      raft.EntryType(entry.GetType()),
+			Data:      entry.GetData(),
+		}
+	}
+	return result
+}
+
+// toPBConfiguration 转换集群配置
+func toPBConfiguration(config raft.Configuration) *raftpb.Configuration {
+	servers := make([]*raftpb.Server, len(config.Servers))
+	for i, server := range config.Servers {
+		servers[i] = &raftpb.Server{
+			Id:          string(server.ID),
+			Address:     server.Address,
+			DataCenter:  string(server.DataCenter),
+			ReplicaType: int32(server.ReplicaType),
+			IsLearner:   server.IsLearner,
+		}
+	}
+	return &raftpb.Configuration{Servers: servers}
+}
+
+// fromPBConfiguration 还原集群配置
+func fromPBConfiguration(config *raftpb.Configuration) raft.Configuration {
+	var result raft.Configuration
+	for _, server := range config.GetServers() {
+		result.Servers = append(result.Servers, raft.Server{
+			ID:          raft.NodeID(server.GetId()),
+			Address:     server.GetAddress(),
+			DataCenter:  raft.DataCenterID(server.GetDataCenter()),
+			ReplicaType: raft.ReplicaType(server.GetReplicaType()),
+			IsLearner:   server.GetIsLearner(),
+		})
+	}
+	return result
+}
+
+// toPBVoteRequest 转换投票请求
+func toPBVoteRequest(req *raft.VoteRequest) *raftpb.VoteRequest {
+	return &raftpb.VoteRequest{
+		Term:               uint64(req.Term),
+		CandidateId:        string(req.CandidateID),
+		LastLogIndex:       uint64(req.LastLogIndex),
+		LastLogTerm:        uint64(req.LastLogTerm),
+		LeadershipTransfer: req.LeadershipTransfer,
+		PreVote:            req.PreVote,
+	}
+}
+
+// fromPBVoteRequest 还原投票请求
+func fromPBVoteRequest(req *raftpb.VoteRequest) *raft.VoteRequest {
+	return &raft.VoteRequest{
+		Term:               raft.Term(req.GetTerm()),
+		CandidateID:        raft.NodeID(req.GetCandidateId()),
+		LastLogIndex:       raft.LogIndex(req.GetLastLogIndex()),
+		LastLogTerm:        raft.Term(req.GetLastLogTerm()),
+		LeadershipTransfer: req.GetLeadershipTransfer(),
+		PreVote:            req.GetPreVote(),
+	}
+}
+
+// toPBVoteResponse 转换投票响应
+func toPBVoteResponse(resp *raft.VoteResponse) *raftpb.VoteResponse {
+	return &raftpb.VoteResponse{
+		Term:        uint64(resp.Term),
+		VoteGranted: resp.VoteGranted,
+	}
+}
+
+// fromPBVoteResponse 还原投票响应
+func fromPBVoteResponse(resp *raftpb.VoteResponse) *raft.VoteResponse {
+	return &raft.VoteResponse{
+		Term:        raft.Term(resp.GetTerm()),
+		VoteGranted: resp.GetVoteGranted(),
+	}
+}
+
+// toPBAppendEntriesRequest 转换追加日志请求
+func toPBAppendEntriesRequest(req *raft.AppendEntriesRequest) *raftpb.AppendEntriesRequest {
+	return &raftpb.AppendEntriesRequest{
+		Term:         uint64(req.Term),
+		LeaderId:     string(req.LeaderID),
+		PrevLogIndex: uint64(req.PrevLogIndex),
+		PrevLogTerm:  uint64(req.PrevLogTerm),
+		Entries:      toPBEntries(req.Entries),
+		LeaderCommit: uint64(req.LeaderCommit),
+	}
+}
+
+// fromPBAppendEntriesRequest 还原追加日志请求
+func fromPBAppendEntriesRequest(req *raftpb.AppendEntriesRequest) *raft.AppendEntriesRequest {
+	return &raft.AppendEntriesRequest{
+		Term:         raft.Term(req.GetTerm()),
+		LeaderID:     raft.NodeID(req.GetLeaderId()),
+		PrevLogIndex: raft.LogIndex(req.GetPrevLogIndex()),
+		PrevLogTerm:  raft.Term(req.GetPrevLogTerm()),
+		Entries:      fromPBEntries(req.GetEntries()),
+		LeaderCommit: raft.LogIndex(req.GetLeaderCommit()),
+	}
+}
+
+// toPBAppendEntriesResponse 转换追加日志响应
+func toPBAppendEntriesResponse(resp *raft.AppendEntriesResponse) *raftpb.AppendEntriesResponse {
+	return &raftpb.AppendEntriesResponse{
+		Term:          uint64(resp.Term),
+		Success:       resp.Success,
+		ConflictIndex: uint64(resp.ConflictIndex),
+		ConflictTerm:  uint64(resp.ConflictTerm),
+	}
+}
+
+// fromPBAppendEntriesResponse 还原追加日志响应
+func fromPBAppendEntriesResponse(resp *raftpb.AppendEntriesResponse) *raft.AppendEntriesResponse {
+	return &raft.AppendEntriesResponse{
+		Term:          raft.Term(resp.GetTerm()),
+		Success:       resp.GetSuccess(),
+		ConflictIndex: raft.LogIndex(resp.GetConflictIndex()),
+		ConflictTerm:  raft.Term(resp.GetConflictTerm()),
+	}
+}
+
+// toPBInstallSnapshotRequest 转换安装快照请求
+func toPBInstallSnapshotRequest(req *raft.InstallSnapshotRequest) *raftpb.InstallSnapshotRequest {
+	return &raftpb.InstallSnapshotRequest{
+		Term:              uint64(req.Term),
+		LeaderId:          string(req.LeaderID),
+		LastIncludedIndex: uint64(req.LastIncludedIndex),
+		LastIncludedTerm:  uint64(req.LastIncludedTerm),
+		Configuration:     toPBConfiguration(req.Configuration),
+		Offset:            req.Offset,
+		Data:              req.Data,
+		Done:              req.Done,
+		TotalSize:         req.TotalSize,
+		ChunkChecksum:     req.ChunkChecksum,
+		SnapshotHash:      req.SnapshotHash,
+	}
+}
+
+// fromPBInstallSnapshotRequest 还原安装快照请求
+func fromPBInstallSnapshotRequest(req *raftpb.InstallSnapshotRequest) *raft.InstallSnapshotRequest {
+	return &raft.InstallSnapshotRequest{
+		Term:              raft.Term(req.GetTerm()),
+		LeaderID:          raft.NodeID(req.GetLeaderId()),
+		LastIncludedIndex: raft.LogIndex(req.GetLastIncludedIndex()),
+		LastIncludedTerm:  raft.Term(req.GetLastIncludedTerm()),
+		Configuration:     fromPBConfiguration(req.GetConfiguration()),
+		Offset:            req.GetOffset(),
+		Data:              req.GetData(),
+		Done:              req.GetDone(),
+		TotalSize:         req.GetTotalSize(),
+		ChunkChecksum:     req.GetChunkChecksum(),
+		SnapshotHash:      req.GetSnapshotHash(),
+	}
+}
+
+// toPBInstallSnapshotResponse 转换安装快照响应
+func toPBInstallSnapshotResponse(resp *raft.InstallSnapshotResponse) *raftpb.InstallSnapshotResponse {
+	return &raftpb.InstallSnapshotResponse{
+		Term:      uint64(resp.Term),
+		Offset:    resp.Offset,
+		Installed: resp.Installed,
+	}
+}
+
+// fromPBInstallSnapshotResponse 还原安装快照响应
+func fromPBInstallSnapshotResponse(resp *raftpb.InstallSnapshotResponse) *raft.InstallSnapshotResponse {
+	return &raft.InstallSnapshotResponse{
+		Term:      raft.Term(resp.GetTerm()),
+		Offset:    resp.GetOffset(),
+		Installed: resp.GetInstalled(),
+	}
+}
+
+// toPBTimeoutNowRequest 转换TimeoutNow请求
+func toPBTimeoutNowRequest(req *raft.TimeoutNowRequest) *raftpb.TimeoutNowRequest {
+	return &raftpb.TimeoutNowRequest{
+		Term:     uint64(req.Term),
+		LeaderId: string(req.LeaderID),
+	}
+}
+
+// fromPBTimeoutNowRequest 还原TimeoutNow请求
+func fromPBTimeoutNowRequest(req *raftpb.TimeoutNowRequest) *raft.TimeoutNowRequest {
+	return &raft.TimeoutNowRequest{
+		Term:     raft.Term(req.GetTerm()),
+		LeaderID: raft.NodeID(req.GetLeaderId()),
+	}
+}
+
+// toPBTimeoutNowResponse 转换TimeoutNow响应
+func toPBTimeoutNowResponse(resp *raft.TimeoutNowResponse) *raftpb.TimeoutNowResponse {
+	return &raftpb.TimeoutNowResponse{
+		Term:    uint64(resp.Term),
+		Success: resp.Success,
+	}
+}
+
+// fromPBTimeoutNowResponse 还原TimeoutNow响应
+func fromPBTimeoutNowResponse(resp *raftpb.TimeoutNowResponse) *raft.TimeoutNowResponse {
+	return &raft.TimeoutNowResponse{
+		Term:    raft.Term(resp.GetTerm()),
+		Success: resp.GetSuccess(),
+	}
+}
+
+// toPBCompressedAppendEntriesRequest 转换压缩的追加日志请求
+func toPBCompressedAppendEntriesRequest(req *raft.CompressedAppendEntriesRequest) *raftpb.CompressedAppendEntriesRequest {
+	return &raftpb.CompressedAppendEntriesRequest{
+		Term:            uint64(req.Term),
+		LeaderId:        string(req.LeaderID),
+		PrevLogIndex:    uint64(req.PrevLogIndex),
+		PrevLogTerm:     uint64(req.PrevLogTerm),
+		LeaderCommit:    uint64(req.LeaderCommit),
+		IsCompressed:    req.IsCompressed,
+		CompressedData:  req.CompressedData,
+		OriginalSize:    int64(req.OriginalSize),
+		CompressionType: req.CompressionType,
+		Checksum:        req.Checksum,
+		BatchId:         req.BatchID,
+		BatchSize:       int64(req.BatchSize),
+		SequenceNum:     int64(req.SequenceNum),
+		SourceDc:        string(req.SourceDC),
+		TargetDc:        string(req.TargetDC),
+		Priority:        int32(req.Priority),
+	}
+}
+
+// fromPBCompressedAppendEntriesRequest 还原压缩的追加日志请求
+func fromPBCompressedAppendEntriesRequest(req *raftpb.CompressedAppendEntriesRequest) *raft.CompressedAppendEntriesRequest {
+	return &raft.CompressedAppendEntriesRequest{
+		Term:            raft.Term(req.GetTerm()),
+		LeaderID:        raft.NodeID(req.GetLeaderId()),
+		PrevLogIndex:    raft.LogIndex(req.GetPrevLogIndex()),
+		PrevLogTerm:     raft.Term(req.GetPrevLogTerm()),
+		LeaderCommit:    raft.LogIndex(req.GetLeaderCommit()),
+		IsCompressed:    req.GetIsCompressed(),
+		CompressedData:  req.GetCompressedData(),
+		OriginalSize:    int(req.GetOriginalSize()),
+		CompressionType: req.GetCompressionType(),
+		Checksum:        req.GetChecksum(),
+		BatchID:         req.GetBatchId(),
+		BatchSize:       int(req.GetBatchSize()),
+		SequenceNum:     int(req.GetSequenceNum()),
+		SourceDC:        raft.DataCenterID(req.GetSourceDc()),
+		TargetDC:        raft.DataCenterID(req.GetTargetDc()),
+		Priority:        int(req.GetPriority()),
+	}
+}
+
+// toPBCompressedAppendEntriesResponse 转换压缩的追加日志响应
+func toPBCompressedAppendEntriesResponse(resp *raft.CompressedAppendEntriesResponse) *raftpb.CompressedAppendEntriesResponse {
+	return &raftpb.CompressedAppendEntriesResponse{
+		Term:                   uint64(resp.Term),
+		Success:                resp.Success,
+		ConflictIndex:          uint64(resp.ConflictIndex),
+		ConflictTerm:           uint64(resp.ConflictTerm),
+		ProcessingTimeNanos:    int64(resp.ProcessingTime),
+		DecompressionTimeNanos: int64(resp.DecompressionTime),
+		NetworkLatencyNanos:    int64(resp.NetworkLatency),
+		BatchId:                resp.BatchID,
+		ProcessedCount:         int64(resp.ProcessedCount),
+		LastProcessedIndex:     uint64(resp.LastProcessedIndex),
+	}
+}
+
+// fromPBCompressedAppendEntriesResponse 还原压缩的追加日志响应
+func fromPBCompressedAppendEntriesResponse(resp *raftpb.CompressedAppendEntriesResponse) *raft.CompressedAppendEntriesResponse {
+	return &raft.CompressedAppendEntriesResponse{
+		Term:               raft.Term(resp.GetTerm()),
+		Success:            resp.GetSuccess(),
+		ConflictIndex:      raft.LogIndex(resp.GetConflictIndex()),
+		ConflictTerm:       raft.Term(resp.GetConflictTerm()),
+		ProcessingTime:     time.Duration(resp.GetProcessingTimeNanos()),
+		DecompressionTime:  time.Duration(resp.GetDecompressionTimeNanos()),
+		NetworkLatency:     time.Duration(resp.GetNetworkLatencyNanos()),
+		BatchID:            resp.GetBatchId(),
+		ProcessedCount:     int(resp.GetProcessedCount()),
+		LastProcessedIndex: raft.LogIndex(resp.GetLastProcessedIndex()),
+	}
+}
+
+// toPBForwardProposeRequest 转换转发的写请求
+func toPBForwardProposeRequest(req *raft.ForwardProposeRequest) *raftpb.ForwardProposeRequest {
+	return &raftpb.ForwardProposeRequest{
+		From:    string(req.From),
+		Token:   req.Token,
+		Command: req.Command,
+	}
+}
+
+// fromPBForwardProposeRequest 还原转发的写请求
+func fromPBForwardProposeRequest(req *raftpb.ForwardProposeRequest) *raft.ForwardProposeRequest {
+	return &raft.ForwardProposeRequest{
+		From:    raft.NodeID(req.GetFrom()),
+		Token:   req.GetToken(),
+		Command: req.GetCommand(),
+	}
+}
+
+// toPBForwardProposeResponse 转换转发写请求的响应
+func toPBForwardProposeResponse(resp *raft.ForwardProposeResponse) *raftpb.ForwardProposeResponse {
+	return &raftpb.ForwardProposeResponse{
+		Index:     uint64(resp.Index),
+		Result:    resp.Result,
+		ErrorCode: resp.ErrorCode,
+		Error:     resp.Error,
+		LeaderId:  string(resp.LeaderID),
+	}
+}
+
+// fromPBForwardProposeResponse 还原转发写请求的响应
+func fromPBForwardProposeResponse(resp *raftpb.ForwardProposeResponse) *raft.ForwardProposeResponse {
+	return &raft.ForwardProposeResponse{
+		Index:     raft.LogIndex(resp.GetIndex()),
+		Result:    resp.GetResult(),
+		ErrorCode: resp.GetErrorCode(),
+		Error:     resp.GetError(),
+		LeaderID:  raft.NodeID(resp.GetLeaderId()),
+	}
+}
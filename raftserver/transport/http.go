@@ -10,6 +10,7 @@ package transport
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -30,6 +31,7 @@ type HTTPTransport struct {
 	peers   map[raft.NodeID]string
 	handler TransportHandler
 	running bool
+	tls     *TLSCredentials
 }
 
 // TransportHandler 传输处理器接口
@@ -37,13 +39,26 @@ type TransportHandler interface {
 	HandleVoteRequest(req *raft.VoteRequest) *raft.VoteResponse
 	HandleAppendEntries(req *raft.AppendEntriesRequest) *raft.AppendEntriesResponse
 	HandleInstallSnapshot(req *raft.InstallSnapshotRequest) *raft.InstallSnapshotResponse
+	HandleTimeoutNow(req *raft.TimeoutNowRequest) *raft.TimeoutNowResponse
+}
+
+// ForwardProposeHandler 可选的处理器接口，处理跟随者转发的客户端写请求
+// 处理器在命令被应用或失败后返回，失败原因通过响应的错误码传回转发方
+type ForwardProposeHandler interface {
+	HandleForwardPropose(ctx context.Context, req *raft.ForwardProposeRequest) *raft.ForwardProposeResponse
 }
 
 // NewHTTPTransport 创建新的HTTP传输层
 func NewHTTPTransport(addr string, peers map[raft.NodeID]string) *HTTPTransport {
+	// 复制一份地址表，成员变更时会动态修改
+	peerAddrs := make(map[raft.NodeID]string, len(peers))
+	for id, peerAddr := range peers {
+		peerAddrs[id] = peerAddr
+	}
+
 	return &HTTPTransport{
 		addr:  addr,
-		peers: peers,
+		peers: peerAddrs,
 		client: &http.Client{
 			Timeout: time.Second * 5,
 		},
@@ -57,6 +72,21 @@ func (t *HTTPTransport) SetHandler(handler TransportHandler) {
 	t.handler = handler
 }
 
+// SetTLS 启用TLS，节点间双向校验证书，需在Start之前调用
+func (t *HTTPTransport) SetTLS(creds *TLSCredentials) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.tls = creds
+	t.client = &http.Client{
+		Timeout: time.Second * 5,
+		Transport: &http.Transport{
+			DialTLSContext:      creds.DialTLSContext(t.resolvePeer),
+			MaxIdleConnsPerHost: 4,
+		},
+	}
+}
+
 // Start 启动传输层
 func (t *HTTPTransport) Start() error {
 	t.mu.Lock()
@@ -70,6 +100,8 @@ func (t *HTTPTransport) Start() error {
 	mux.HandleFunc("/vote", t.handleVoteRequest)
 	mux.HandleFunc("/append", t.handleAppendEntries)
 	mux.HandleFunc("/snapshot", t.handleInstallSnapshot)
+	mux.HandleFunc("/timeout-now", t.handleTimeoutNow)
+	mux.HandleFunc("/forward-propose", t.handleForwardPropose)
 	mux.HandleFunc("/health", t.handleHealth)
 
 	t.server = &http.Server{
@@ -81,6 +113,9 @@ func (t *HTTPTransport) Start() error {
 	if err != nil {
 		return fmt.Errorf("监听地址失败: %w", err)
 	}
+	if t.tls != nil {
+		listener = tls.NewListener(listener, t.tls.PeerServerConfig(t.isPeer))
+	}
 
 	go func() {
 		if err := t.server.Serve(listener); err != nil && err != http.ErrServerClosed {
@@ -121,54 +156,141 @@ func (t *HTTPTransport) LocalAddr() string {
 	return t.addr
 }
 
-// SendVoteRequest 发送投票请求
-func (t *HTTPTransport) SendVoteRequest(ctx context.Context, target raft.NodeID, req *raft.VoteRequest) (*raft.VoteResponse, error) {
+// AddPeer 添加或更新对端地址
+func (t *HTTPTransport) AddPeer(id raft.NodeID, addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.peers[id] = addr
+}
+
+// RemovePeer 移除对端地址
+func (t *HTTPTransport) RemovePeer(id raft.NodeID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.peers, id)
+}
+
+// isPeer 判断节点是否为已知的集群节点
+func (t *HTTPTransport) isPeer(id raft.NodeID) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	_, exists := t.peers[id]
+	return exists
+}
+
+// resolvePeer 根据地址查找节点ID，用于校验对端证书身份
+func (t *HTTPTransport) resolvePeer(addr string) (raft.NodeID, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for id, peerAddr := range t.peers {
+		if peerAddr == addr {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// peerURL 构造发往对端的请求地址
+func (t *HTTPTransport) peerURL(target raft.NodeID, path string) (string, error) {
 	t.mu.RLock()
 	addr, exists := t.peers[target]
+	scheme := "http"
+	if t.tls != nil {
+		scheme = "https"
+	}
 	t.mu.RUnlock()
 
 	if !exists {
-		return nil, fmt.Errorf("未找到节点 %s 的地址", target)
+		return "", fmt.Errorf("未找到节点 %s 的地址", target)
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, addr, path), nil
+}
+
+// SendVoteRequest 发送投票请求
+func (t *HTTPTransport) SendVoteRequest(ctx context.Context, target raft.NodeID, req *raft.VoteRequest) (*raft.VoteResponse, error) {
+	url, err := t.peerURL(target, "/vote")
+	if err != nil {
+		return nil, err
 	}
 
-	url := fmt.Sprintf("http://%s/vote", addr)
 	resp := &raft.VoteResponse{}
-	err := t.sendRequest(ctx, url, req, resp)
+	err = t.sendRequest(ctx, url, req, resp)
 	return resp, err
 }
 
 // SendAppendEntries 发送追加日志请求
 func (t *HTTPTransport) SendAppendEntries(ctx context.Context, target raft.NodeID, req *raft.AppendEntriesRequest) (*raft.AppendEntriesResponse, error) {
-	t.mu.RLock()
-	addr, exists := t.peers[target]
-	t.mu.RUnlock()
-
-	if !exists {
-		return nil, fmt.Errorf("未找到节点 %s 的地址", target)
+	url, err := t.peerURL(target, "/append")
+	if err != nil {
+		return nil, err
 	}
 
-	url := fmt.Sprintf("http://%s/append", addr)
 	resp := &raft.AppendEntriesResponse{}
-	err := t.sendRequest(ctx, url, req, resp)
+	err = t.sendRequest(ctx, url, req, resp)
 	return resp, err
 }
 
 // SendInstallSnapshot 发送安装快照请求
 func (t *HTTPTransport) SendInstallSnapshot(ctx context.Context, target raft.NodeID, req *raft.InstallSnapshotRequest) (*raft.InstallSnapshotResponse, error) {
-	t.mu.RLock()
-	addr, exists := t.peers[target]
-	t.mu.RUnlock()
-
-	if !exists {
-		return nil, fmt.Errorf("未找到节点 %s 的地址", target)
+	url, err := t.peerURL(target, "/snapshot")
+	if err != nil {
+		return nil, err
 	}
 
-	url := fmt.Sprintf("http://%s/snapshot", addr)
 	resp := &raft.InstallSnapshotResponse{}
-	err := t.sendRequest(ctx, url, req, resp)
+	err = t.sendRequest(ctx, url, req, resp)
+	return resp, err
+}
+
+// SendTimeoutNow 发送TimeoutNow请求
+func (t *HTTPTransport) SendTimeoutNow(ctx context.Context, target raft.NodeID, req *raft.TimeoutNowRequest) (*raft.TimeoutNowResponse, error) {
+	url, err := t.peerURL(target, "/timeout-now")
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &raft.TimeoutNowResponse{}
+	err = t.sendRequest(ctx, url, req, resp)
+	return resp, err
+}
+
+// ForwardPropose 把客户端写请求转发给领导者，等待命令被应用后返回
+func (t *HTTPTransport) ForwardPropose(ctx context.Context, target raft.NodeID, req *raft.ForwardProposeRequest) (*raft.ForwardProposeResponse, error) {
+	url, err := t.peerURL(target, "/forward-propose")
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &raft.ForwardProposeResponse{}
+	err = t.sendRequest(ctx, url, req, resp)
 	return resp, err
 }
 
+// Ping 请求对端的/health接口，DC健康检查用它探测节点并测量往返延迟
+func (t *HTTPTransport) Ping(ctx context.Context, target raft.NodeID) error {
+	url, err := t.peerURL(target, "/health")
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("创建HTTP请求失败: %w", err)
+	}
+
+	resp, err := t.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("发送HTTP请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP请求失败，状态码: %d", resp.StatusCode)
+	}
+	return nil
+}
+
 // sendRequest 发送HTTP请求的通用方法
 func (t *HTTPTransport) sendRequest(ctx context.Context, url string, reqData interface{}, respData interface{}) error {
 	// 序列化请求
@@ -289,6 +411,64 @@ func (t *HTTPTransport) handleInstallSnapshot(w http.ResponseWriter, r *http.Req
 	t.encodeResponse(w, resp)
 }
 
+// handleTimeoutNow 处理TimeoutNow请求
+func (t *HTTPTransport) handleTimeoutNow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req raft.TimeoutNowRequest
+	if err := t.decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t.mu.RLock()
+	handler := t.handler
+	t.mu.RUnlock()
+
+	if handler == nil {
+		http.Error(w, "处理器未设置", http.StatusInternalServerError)
+		return
+	}
+
+	resp := handler.HandleTimeoutNow(&req)
+	t.encodeResponse(w, resp)
+}
+
+// handleForwardPropose 处理跟随者转发的写请求，处理器未实现时返回501
+func (t *HTTPTransport) handleForwardPropose(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req raft.ForwardProposeRequest
+	if err := t.decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t.mu.RLock()
+	handler := t.handler
+	t.mu.RUnlock()
+
+	if handler == nil {
+		http.Error(w, "处理器未设置", http.StatusInternalServerError)
+		return
+	}
+
+	forwardHandler, ok := handler.(ForwardProposeHandler)
+	if !ok {
+		http.Error(w, "处理器不支持转发写请求", http.StatusNotImplemented)
+		return
+	}
+
+	resp := forwardHandler.HandleForwardPropose(r.Context(), &req)
+	t.encodeResponse(w, resp)
+}
+
 // handleHealth 处理健康检查请求
 func (t *HTTPTransport) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
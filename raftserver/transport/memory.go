@@ -0,0 +1,143 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-12 10:15:42
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-12 10:15:42
+* @Description: ConcordKV Raft consensus server - memory.go
+ */
+package transport
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"raftserver/raft"
+)
+
+// MemoryNetwork 进程内网络，RPC直接在目标节点的处理器上同步执行，用于测试和混沌实验
+type MemoryNetwork struct {
+	mu       sync.RWMutex
+	handlers map[raft.NodeID]TransportHandler
+	running  map[raft.NodeID]bool
+}
+
+// NewMemoryNetwork 创建进程内网络
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		handlers: make(map[raft.NodeID]TransportHandler),
+		running:  make(map[raft.NodeID]bool),
+	}
+}
+
+// Transport 创建绑定到网络的节点传输层，设置处理器并启动后其他节点才能访问该节点
+func (nw *MemoryNetwork) Transport(id raft.NodeID) *MemoryTransport {
+	return &MemoryTransport{id: id, network: nw}
+}
+
+// handler 获取已启动节点的处理器
+func (nw *MemoryNetwork) handler(target raft.NodeID) (TransportHandler, error) {
+	nw.mu.RLock()
+	defer nw.mu.RUnlock()
+
+	handler, ok := nw.handlers[target]
+	if !ok || !nw.running[target] {
+		return nil, fmt.Errorf("节点 %s 不可达", target)
+	}
+	return handler, nil
+}
+
+// MemoryTransport 进程内传输层，实现raft.Transport、raft.Pinger与raft.CompressedAppendEntriesSender
+type MemoryTransport struct {
+	id      raft.NodeID
+	network *MemoryNetwork
+}
+
+// SetHandler 设置传输处理器
+func (t *MemoryTransport) SetHandler(handler TransportHandler) {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+	t.network.handlers[t.id] = handler
+}
+
+// Start 启动传输层，之后其他节点的请求投递到本节点
+func (t *MemoryTransport) Start() error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+
+	if _, ok := t.network.handlers[t.id]; !ok {
+		return fmt.Errorf("节点 %s 未设置处理器", t.id)
+	}
+	t.network.running[t.id] = true
+	return nil
+}
+
+// Stop 停止传输层，之后发往本节点的请求返回不可达
+func (t *MemoryTransport) Stop() error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+	t.network.running[t.id] = false
+	return nil
+}
+
+// LocalAddr 进程内网络以节点ID为地址
+func (t *MemoryTransport) LocalAddr() string {
+	return string(t.id)
+}
+
+// SendVoteRequest 发送投票请求
+func (t *MemoryTransport) SendVoteRequest(ctx context.Context, target raft.NodeID, req *raft.VoteRequest) (*raft.VoteResponse, error) {
+	handler, err := t.network.handler(target)
+	if err != nil {
+		return nil, err
+	}
+	return handler.HandleVoteRequest(req), ctx.Err()
+}
+
+// SendAppendEntries 发送追加日志请求
+func (t *MemoryTransport) SendAppendEntries(ctx context.Context, target raft.NodeID, req *raft.AppendEntriesRequest) (*raft.AppendEntriesResponse, error) {
+	handler, err := t.network.handler(target)
+	if err != nil {
+		return nil, err
+	}
+	return handler.HandleAppendEntries(req), ctx.Err()
+}
+
+// SendInstallSnapshot 发送安装快照请求
+func (t *MemoryTransport) SendInstallSnapshot(ctx context.Context, target raft.NodeID, req *raft.InstallSnapshotRequest) (*raft.InstallSnapshotResponse, error) {
+	handler, err := t.network.handler(target)
+	if err != nil {
+		return nil, err
+	}
+	return handler.HandleInstallSnapshot(req), ctx.Err()
+}
+
+// SendTimeoutNow 发送TimeoutNow请求
+func (t *MemoryTransport) SendTimeoutNow(ctx context.Context, target raft.NodeID, req *raft.TimeoutNowRequest) (*raft.TimeoutNowResponse, error) {
+	handler, err := t.network.handler(target)
+	if err != nil {
+		return nil, err
+	}
+	return handler.HandleTimeoutNow(req), ctx.Err()
+}
+
+// SendCompressedAppendEntries 发送压缩的追加请求，目标处理器需实现CompressedAppendEntriesHandler
+func (t *MemoryTransport) SendCompressedAppendEntries(ctx context.Context, target raft.NodeID, req *raft.CompressedAppendEntriesRequest) (*raft.CompressedAppendEntriesResponse, error) {
+	handler, err := t.network.handler(target)
+	if err != nil {
+		return nil, err
+	}
+	compressedHandler, ok := handler.(CompressedAppendEntriesHandler)
+	if !ok {
+		return nil, fmt.Errorf("节点 %s 的处理器不支持压缩的追加请求", target)
+	}
+	return compressedHandler.HandleCompressedAppendEntries(req)
+}
+
+// Ping 目标节点已启动即可达
+func (t *MemoryTransport) Ping(ctx context.Context, target raft.NodeID) error {
+	if _, err := t.network.handler(target); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
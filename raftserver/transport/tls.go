@@ -0,0 +1,240 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - tls.go
+ */
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+
+	"raftserver/raft"
+)
+
+// ClientAuthMode API服务器校验客户端证书的模式
+type ClientAuthMode string
+
+const (
+	// ClientAuthNone 不要求客户端证书
+	ClientAuthNone ClientAuthMode = "none"
+	// ClientAuthRequest 客户端提供证书时校验，未提供时放行
+	ClientAuthRequest ClientAuthMode = "request"
+	// ClientAuthRequire 必须提供由CA签发的客户端证书
+	ClientAuthRequire ClientAuthMode = "require"
+)
+
+// ParseClientAuthMode 解析客户端证书校验模式，空字符串表示none
+func ParseClientAuthMode(s string) (ClientAuthMode, error) {
+	switch ClientAuthMode(s) {
+	case "", ClientAuthNone:
+		return ClientAuthNone, nil
+	case ClientAuthRequest, ClientAuthRequire:
+		return ClientAuthMode(s), nil
+	default:
+		return "", fmt.Errorf("不支持的客户端证书校验模式: %s（可选 none、request、require）", s)
+	}
+}
+
+// TLSConfig TLS配置，配置了证书后节点间通信与API服务器均启用TLS
+// 节点证书的CN或SAN必须包含节点ID，节点间连接双向校验证书
+type TLSConfig struct {
+	CertFile   string         `yaml:"certFile"`   // 节点证书
+	KeyFile    string         `yaml:"keyFile"`    // 节点私钥
+	CAFile     string         `yaml:"caFile"`     // 签发节点与客户端证书的CA
+	ClientAuth ClientAuthMode `yaml:"clientAuth"` // API服务器的客户端证书校验模式
+}
+
+// Enabled 是否启用TLS
+func (c *TLSConfig) Enabled() bool {
+	return c != nil && (c.CertFile != "" || c.KeyFile != "" || c.CAFile != "")
+}
+
+var (
+	// ErrPeerIdentityMismatch 对端证书与期望的节点身份不符
+	ErrPeerIdentityMismatch = errors.New("对端证书身份不匹配")
+	// ErrNoPeerCertificate 对端未提供证书
+	ErrNoPeerCertificate = errors.New("对端未提供证书")
+)
+
+// tlsState 一次加载得到的证书与CA
+type tlsState struct {
+	cert  *tls.Certificate
+	roots *x509.CertPool
+}
+
+// TLSCredentials 节点证书与CA，握手时读取最新加载的版本，支持运行时重新加载
+type TLSCredentials struct {
+	config TLSConfig
+	state  atomic.Pointer[tlsState]
+}
+
+// NewTLSCredentials 加载证书、私钥与CA
+func NewTLSCredentials(config TLSConfig) (*TLSCredentials, error) {
+	if config.CertFile == "" || config.KeyFile == "" || config.CAFile == "" {
+		return nil, fmt.Errorf("启用TLS需要同时指定证书、私钥与CA文件")
+	}
+	if _, err := ParseClientAuthMode(string(config.ClientAuth)); err != nil {
+		return nil, err
+	}
+
+	creds := &TLSCredentials{config: config}
+	if err := creds.Reload(); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// Reload 重新读取证书文件，已建立的连接不受影响，之后的握手使用新证书
+func (c *TLSCredentials) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.config.CertFile, c.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("加载证书失败: %w", err)
+	}
+
+	caPEM, err := os.ReadFile(c.config.CAFile)
+	if err != nil {
+		return fmt.Errorf("读取CA文件失败: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return fmt.Errorf("CA文件 %s 中没有有效的证书", c.config.CAFile)
+	}
+
+	c.state.Store(&tlsState{cert: &cert, roots: roots})
+	return nil
+}
+
+// ClientAuth 获取API服务器的客户端证书校验模式
+func (c *TLSCredentials) ClientAuth() ClientAuthMode {
+	mode, _ := ParseClientAuthMode(string(c.config.ClientAuth))
+	return mode
+}
+
+// getCertificate 返回当前的节点证书
+func (c *TLSCredentials) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.state.Load().cert, nil
+}
+
+// getClientCertificate 返回当前的节点证书，作为客户端时使用
+func (c *TLSCredentials) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return c.state.Load().cert, nil
+}
+
+// verifyChain 使用当前CA校验对端证书链
+func (c *TLSCredentials) verifyChain(certs []*x509.Certificate, usage x509.ExtKeyUsage) error {
+	if len(certs) == 0 {
+		return ErrNoPeerCertificate
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         c.state.Load().roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	if _, err := certs[0].Verify(opts); err != nil {
+		return fmt.Errorf("校验对端证书失败: %w", err)
+	}
+	return nil
+}
+
+// certIdentities 证书声明的身份：CN与DNS类型的SAN
+func certIdentities(cert *x509.Certificate) []string {
+	identities := make([]string, 0, len(cert.DNSNames)+1)
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	return append(identities, cert.DNSNames...)
+}
+
+// PeerServerConfig 节点间监听使用的TLS配置：要求对端提供CA签发的证书，且身份为已知节点
+func (c *TLSCredentials) PeerServerConfig(isPeer func(id raft.NodeID) bool) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.getCertificate,
+		// 证书链在VerifyConnection中使用最新加载的CA校验
+		ClientAuth: tls.RequireAnyClientCert,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if err := c.verifyChain(cs.PeerCertificates, x509.ExtKeyUsageClientAuth); err != nil {
+				return err
+			}
+			for _, identity := range certIdentities(cs.PeerCertificates[0]) {
+				if isPeer(raft.NodeID(identity)) {
+					return nil
+				}
+			}
+			return fmt.Errorf("%w: %v 不是集群节点", ErrPeerIdentityMismatch, certIdentities(cs.PeerCertificates[0]))
+		},
+	}
+}
+
+// PeerClientConfig 连接节点时使用的TLS配置：校验对端证书由CA签发且CN或SAN为期望的节点ID
+func (c *TLSCredentials) PeerClientConfig(expected raft.NodeID) *tls.Config {
+	return &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		GetClientCertificate: c.getClientCertificate,
+		// 按节点ID而非地址校验身份，证书链在VerifyConnection中校验
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if err := c.verifyChain(cs.PeerCertificates, x509.ExtKeyUsageServerAuth); err != nil {
+				return err
+			}
+			for _, identity := range certIdentities(cs.PeerCertificates[0]) {
+				if identity == string(expected) {
+					return nil
+				}
+			}
+			return fmt.Errorf("%w: 期望 %s，实际 %v", ErrPeerIdentityMismatch, expected, certIdentities(cs.PeerCertificates[0]))
+		},
+	}
+}
+
+// APIServerConfig API服务器使用的TLS配置，按ClientAuth模式校验客户端证书
+func (c *TLSCredentials) APIServerConfig() *tls.Config {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.getCertificate,
+	}
+
+	switch c.ClientAuth() {
+	case ClientAuthRequest:
+		config.ClientAuth = tls.RequestClientCert
+	case ClientAuthRequire:
+		config.ClientAuth = tls.RequireAnyClientCert
+	default:
+		return config
+	}
+
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 && c.ClientAuth() == ClientAuthRequest {
+			return nil
+		}
+		return c.verifyChain(cs.PeerCertificates, x509.ExtKeyUsageClientAuth)
+	}
+	return config
+}
+
+// DialTLSContext 返回供http.Transport使用的TLS拨号函数，按地址解析出期望的节点ID后校验对端身份
+func (c *TLSCredentials) DialTLSContext(resolve func(addr string) (raft.NodeID, bool)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		expected, ok := resolve(addr)
+		if !ok {
+			return nil, fmt.Errorf("地址 %s 不属于任何已知节点", addr)
+		}
+
+		dialer := &tls.Dialer{Config: c.PeerClientConfig(expected)}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
@@ -0,0 +1,316 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - tls_test.go
+ */
+package transport
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+)
+
+// testCA 测试用的自签名CA
+type testCA struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	pemPath string
+}
+
+// newTestCA 生成自签名CA并写入目录
+func newTestCA(t *testing.T, dir, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成CA私钥失败: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("生成CA证书失败: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	path := filepath.Join(dir, name+".pem")
+	writePEM(t, path, "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key, pemPath: path}
+}
+
+// issue 签发同时用于服务端与客户端认证的节点证书，返回TLS配置
+func (ca *testCA) issue(t *testing.T, dir, commonName string, dnsNames ...string) TLSConfig {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成私钥失败: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("签发证书失败: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("编码私钥失败: %v", err)
+	}
+
+	config := TLSConfig{
+		CertFile: filepath.Join(dir, commonName+".pem"),
+		KeyFile:  filepath.Join(dir, commonName+"-key.pem"),
+		CAFile:   ca.pemPath,
+	}
+	writePEM(t, config.CertFile, "CERTIFICATE", der)
+	writePEM(t, config.KeyFile, "EC PRIVATE KEY", keyDER)
+	return config
+}
+
+// writePEM 以PEM格式写入文件
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("写入 %s 失败: %v", path, err)
+	}
+}
+
+// loadCreds 加载TLS证书
+func loadCreds(t *testing.T, config TLSConfig) *TLSCredentials {
+	t.Helper()
+	creds, err := NewTLSCredentials(config)
+	if err != nil {
+		t.Fatalf("加载TLS证书失败: %v", err)
+	}
+	return creds
+}
+
+// tlsTransport 可启用TLS的传输层
+type tlsTransport interface {
+	raft.Transport
+	SetHandler(handler TransportHandler)
+	SetTLS(creds *TLSCredentials)
+}
+
+// newTLSPair 按类型创建启用TLS的服务端与客户端，客户端把服务端视为node2，服务端只接受node1
+func newTLSPair(t *testing.T, kind Kind, serverCreds, clientCreds *TLSCredentials) (client raft.Transport, stop func()) {
+	t.Helper()
+
+	addr := freeAddr(t)
+	var server, peer tlsTransport
+	switch kind {
+	case KindGRPC:
+		server = NewGRPCTransport(addr, map[raft.NodeID]string{"node1": "127.0.0.1:1"}, time.Second)
+		peer = NewGRPCTransport(freeAddr(t), map[raft.NodeID]string{"node2": addr}, time.Second)
+	default:
+		server = NewHTTPTransport(addr, map[raft.NodeID]string{"node1": "127.0.0.1:1"})
+		peer = NewHTTPTransport(freeAddr(t), map[raft.NodeID]string{"node2": addr})
+	}
+
+	server.SetHandler(&recordingHandler{discard: true})
+	server.SetTLS(serverCreds)
+	if err := server.Start(); err != nil {
+		t.Fatalf("启动传输层失败: %v", err)
+	}
+	if clientCreds != nil {
+		peer.SetTLS(clientCreds)
+	}
+
+	return peer, func() {
+		peer.Stop()
+		server.Stop()
+	}
+}
+
+// TestTLSMutualAuthentication 节点间连接双向校验证书：身份不符、CA不同或未使用TLS的连接被拒绝
+func TestTLSMutualAuthentication(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	otherCA := newTestCA(t, dir, "other-ca")
+
+	node1 := loadCreds(t, ca.issue(t, dir, "node1"))
+	node2 := loadCreds(t, ca.issue(t, dir, "server-cert", "node2"))
+	node3 := loadCreds(t, ca.issue(t, dir, "node3"))
+	forged := otherCA.issue(t, dir, "forged-node1", "node1")
+	forged.CAFile = ca.pemPath
+	forgedNode1 := loadCreds(t, forged)
+
+	cases := []struct {
+		name   string
+		server *TLSCredentials
+		client *TLSCredentials
+		ok     bool
+	}{
+		{"SAN匹配的双向认证", node2, node1, true},
+		{"服务端身份不符", node3, node1, false},
+		{"客户端不是集群节点", node2, node3, false},
+		{"客户端证书由其他CA签发", node2, forgedNode1, false},
+		{"客户端未启用TLS", node2, nil, false},
+	}
+
+	for _, kind := range []Kind{KindHTTP, KindGRPC} {
+		for _, c := range cases {
+			t.Run(string(kind)+"/"+c.name, func(t *testing.T) {
+				client, stop := newTLSPair(t, kind, c.server, c.client)
+				defer stop()
+
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				defer cancel()
+
+				_, err := client.SendAppendEntries(ctx, "node2", &raft.AppendEntriesRequest{Term: 1, LeaderID: "node1"})
+				if c.ok && err != nil {
+					t.Fatalf("请求应成功: %v", err)
+				}
+				if !c.ok && err == nil {
+					t.Fatalf("请求应被拒绝")
+				}
+			})
+		}
+	}
+}
+
+// TestTLSReload 重新加载后新建立的连接使用新证书
+func TestTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	config := ca.issue(t, dir, "node2")
+	creds := loadCreds(t, config)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", creds.APIServerConfig())
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	serial := func() *big.Int {
+		t.Helper()
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("握手失败: %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber
+	}
+
+	before := serial()
+
+	// 覆盖证书文件后重新加载
+	ca.issue(t, dir, "node2")
+	if err := creds.Reload(); err != nil {
+		t.Fatalf("重新加载失败: %v", err)
+	}
+	if after := serial(); after.Cmp(before) == 0 {
+		t.Errorf("重新加载后仍使用旧证书")
+	}
+
+	// 证书文件损坏时重新加载失败，继续使用已加载的证书
+	os.WriteFile(config.CertFile, []byte("broken"), 0600)
+	if err := creds.Reload(); err == nil {
+		t.Errorf("损坏的证书应加载失败")
+	}
+	serial()
+}
+
+// TestAPIServerClientAuth API服务器按模式校验客户端证书
+func TestAPIServerClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	otherCA := newTestCA(t, dir, "other-ca")
+
+	serverConfig := ca.issue(t, dir, "node1")
+	clientCert, err := tls.LoadX509KeyPair(ca.issue(t, dir, "client").CertFile, filepath.Join(dir, "client-key.pem"))
+	if err != nil {
+		t.Fatalf("加载客户端证书失败: %v", err)
+	}
+	foreign := otherCA.issue(t, dir, "foreign")
+	foreignCert, _ := tls.LoadX509KeyPair(foreign.CertFile, foreign.KeyFile)
+
+	cases := []struct {
+		mode   ClientAuthMode
+		cert   *tls.Certificate
+		wantOK bool
+	}{
+		{ClientAuthNone, nil, true},
+		{ClientAuthRequest, nil, true},
+		{ClientAuthRequest, &clientCert, true},
+		{ClientAuthRequest, &foreignCert, false},
+		{ClientAuthRequire, nil, false},
+		{ClientAuthRequire, &foreignCert, false},
+		{ClientAuthRequire, &clientCert, true},
+	}
+
+	for _, c := range cases {
+		config := serverConfig
+		config.ClientAuth = c.mode
+		creds := loadCreds(t, config)
+
+		listener, err := tls.Listen("tcp", "127.0.0.1:0", creds.APIServerConfig())
+		if err != nil {
+			t.Fatalf("监听失败: %v", err)
+		}
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("ok"))
+			conn.Close()
+		}()
+
+		clientConfig := &tls.Config{InsecureSkipVerify: true}
+		if c.cert != nil {
+			clientConfig.Certificates = []tls.Certificate{*c.cert}
+		}
+		conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+		if err == nil {
+			// TLS 1.3中服务端对客户端证书的拒绝在首次读取时才可见
+			_, err = conn.Read(make([]byte, 2))
+			conn.Close()
+		}
+		listener.Close()
+
+		if c.wantOK != (err == nil) {
+			t.Errorf("模式 %s，客户端证书 %v：期望成功=%v，实际错误 %v", c.mode, c.cert != nil, c.wantOK, err)
+		}
+	}
+}
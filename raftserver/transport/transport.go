@@ -0,0 +1,32 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - transport.go
+ */
+package transport
+
+import "fmt"
+
+// Kind 传输层类型，集群内所有节点必须使用同一种传输层
+type Kind string
+
+const (
+	// KindHTTP HTTP+JSON传输层
+	KindHTTP Kind = "http"
+	// KindGRPC gRPC+Protobuf传输层
+	KindGRPC Kind = "grpc"
+)
+
+// ParseKind 解析传输层类型，空字符串表示默认的HTTP传输层
+func ParseKind(s string) (Kind, error) {
+	switch Kind(s) {
+	case "", KindHTTP:
+		return KindHTTP, nil
+	case KindGRPC:
+		return KindGRPC, nil
+	default:
+		return "", fmt.Errorf("不支持的传输层类型: %s（可选 http、grpc）", s)
+	}
+}
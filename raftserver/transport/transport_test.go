@@ -0,0 +1,490 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-5-30 09:56:35
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-5-30 09:56:35
+* @Description: ConcordKV Raft consensus server - transport_test.go
+ */
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"raftserver/raft"
+)
+
+// recordingHandler 记录收到的请求并返回固定响应的处理器
+type recordingHandler struct {
+	mu       sync.Mutex
+	requests []interface{}
+	discard  bool          // 不记录请求，用于基准测试
+	block    chan struct{} // 非空时AppendEntries阻塞直到关闭
+	entered  chan struct{} // AppendEntries开始处理时通知
+}
+
+func (h *recordingHandler) record(req interface{}) {
+	if h.discard {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.requests = append(h.requests, req)
+}
+
+func (h *recordingHandler) last() interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.requests[len(h.requests)-1]
+}
+
+func (h *recordingHandler) HandleVoteRequest(req *raft.VoteRequest) *raft.VoteResponse {
+	h.record(req)
+	return &raft.VoteResponse{Term: req.Term, VoteGranted: true}
+}
+
+func (h *recordingHandler) HandleAppendEntries(req *raft.AppendEntriesRequest) *raft.AppendEntriesResponse {
+	h.record(req)
+	if h.entered != nil {
+		h.entered <- struct{}{}
+	}
+	if h.block != nil {
+		<-h.block
+	}
+	return &raft.AppendEntriesResponse{Term: req.Term, Success: true, ConflictIndex: 7, ConflictTerm: 2}
+}
+
+func (h *recordingHandler) HandleInstallSnapshot(req *raft.InstallSnapshotRequest) *raft.InstallSnapshotResponse {
+	h.record(req)
+	return &raft.InstallSnapshotResponse{Term: req.Term, Offset: req.Offset + int64(len(req.Data)), Installed: req.Done}
+}
+
+func (h *recordingHandler) HandleTimeoutNow(req *raft.TimeoutNowRequest) *raft.TimeoutNowResponse {
+	h.record(req)
+	return &raft.TimeoutNowResponse{Term: req.Term, Success: true}
+}
+
+func (h *recordingHandler) HandleCompressedAppendEntries(req *raft.CompressedAppendEntriesRequest) (*raft.CompressedAppendEntriesResponse, error) {
+	h.record(req)
+	return &raft.CompressedAppendEntriesResponse{Term: req.Term, Success: true, BatchID: req.BatchID, ProcessedCount: req.BatchSize, ProcessingTime: time.Millisecond}, nil
+}
+
+// freeAddr 获取一个空闲的本地监听地址
+func freeAddr(tb testing.TB) string {
+	tb.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("获取空闲端口失败: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// testEntries 构造测试用日志条目
+func testEntries(n, size int) []raft.LogEntry {
+	entries := make([]raft.LogEntry, n)
+	for i := range entries {
+		entries[i] = raft.LogEntry{
+			Index:     raft.LogIndex(i + 1),
+			Term:      3,
+			Timestamp: time.Unix(1700000000, int64(i)),
+			Type:      raft.EntryNormal,
+			Data:      make([]byte, size),
+		}
+	}
+	return entries
+}
+
+// TestGRPCTransportRoundTrip 各类RPC经gRPC传输后字段完整，且同一对端复用一个连接
+func TestGRPCTransportRoundTrip(t *testing.T) {
+	handler := &recordingHandler{}
+	server := NewGRPCTransport("127.0.0.1:0", nil, time.Second)
+	server.SetHandler(handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("启动传输层失败: %v", err)
+	}
+	defer server.Stop()
+
+	client := NewGRPCTransport("127.0.0.1:0", map[raft.NodeID]string{"node2": server.LocalAddr()}, time.Second)
+	defer client.Stop()
+	ctx := context.Background()
+
+	vote := &raft.VoteRequest{Term: 5, CandidateID: "node1", LastLogIndex: 10, LastLogTerm: 4, PreVote: true, LeadershipTransfer: true}
+	voteResp, err := client.SendVoteRequest(ctx, "node2", vote)
+	if err != nil || !voteResp.VoteGranted || voteResp.Term != 5 {
+		t.Fatalf("投票请求失败: %+v, %v", voteResp, err)
+	}
+	if !reflect.DeepEqual(handler.last(), vote) {
+		t.Errorf("投票请求字段丢失: %+v", handler.last())
+	}
+
+	appendReq := &raft.AppendEntriesRequest{Term: 5, LeaderID: "node1", PrevLogIndex: 9, PrevLogTerm: 4, Entries: testEntries(3, 16), LeaderCommit: 8}
+	appendResp, err := client.SendAppendEntries(ctx, "node2", appendReq)
+	if err != nil || !appendResp.Success || appendResp.ConflictIndex != 7 || appendResp.ConflictTerm != 2 {
+		t.Fatalf("追加日志请求失败: %+v, %v", appendResp, err)
+	}
+	if !reflect.DeepEqual(handler.last(), appendReq) {
+		t.Errorf("追加日志请求字段丢失: %+v", handler.last())
+	}
+
+	snapshotReq := &raft.InstallSnapshotRequest{
+		Term: 5, LeaderID: "node1", LastIncludedIndex: 100, LastIncludedTerm: 4,
+		Configuration: raft.Configuration{Servers: []raft.Server{
+			{ID: "node1", Address: "127.0.0.1:8080", DataCenter: "dc1", ReplicaType: raft.PrimaryReplica},
+			{ID: "node4", Address: "127.0.0.1:8083", DataCenter: "dc1", IsLearner: true},
+		}},
+		Offset: 1024, Data: []byte("chunk"), Done: true, TotalSize: 1029, ChunkChecksum: 42, SnapshotHash: "abc",
+	}
+	snapshotResp, err := client.SendInstallSnapshot(ctx, "node2", snapshotReq)
+	if err != nil || !snapshotResp.Installed || snapshotResp.Offset != 1029 {
+		t.Fatalf("安装快照请求失败: %+v, %v", snapshotResp, err)
+	}
+	if !reflect.DeepEqual(handler.last(), snapshotReq) {
+		t.Errorf("安装快照请求字段丢失: %+v", handler.last())
+	}
+
+	timeoutReq := &raft.TimeoutNowRequest{Term: 5, LeaderID: "node1"}
+	if resp, err := client.SendTimeoutNow(ctx, "node2", timeoutReq); err != nil || !resp.Success {
+		t.Fatalf("TimeoutNow请求失败: %+v, %v", resp, err)
+	}
+
+	compressedReq := &raft.CompressedAppendEntriesRequest{
+		Term: 5, LeaderID: "node1", IsCompressed: true, CompressedData: []byte{1, 2, 3}, OriginalSize: 3,
+		CompressionType: "gzip", Checksum: 7, BatchID: "dc2-1", BatchSize: 3, SequenceNum: 1,
+		SourceDC: "dc1", TargetDC: "dc2", Priority: 2,
+	}
+	compressedResp, err := client.SendCompressedAppendEntries(ctx, "node2", compressedReq)
+	if err != nil || compressedResp.BatchID != "dc2-1" || compressedResp.ProcessedCount != 3 || compressedResp.ProcessingTime != time.Millisecond {
+		t.Fatalf("压缩追加请求失败: %+v, %v", compressedResp, err)
+	}
+	if !reflect.DeepEqual(handler.last(), compressedReq) {
+		t.Errorf("压缩追加请求字段丢失: %+v", handler.last())
+	}
+
+	client.mu.RLock()
+	conns := len(client.conns)
+	client.mu.RUnlock()
+	if conns != 1 {
+		t.Errorf("同一对端应复用一个连接，实际 %d 个", conns)
+	}
+
+	if _, err := client.SendVoteRequest(ctx, "node3", vote); err == nil {
+		t.Errorf("未知节点应返回错误")
+	}
+}
+
+// TestGRPCTransportGracefulStop 关闭传输层时等待在途RPC完成，之后的请求失败
+func TestGRPCTransportGracefulStop(t *testing.T) {
+	handler := &recordingHandler{block: make(chan struct{}), entered: make(chan struct{}, 1)}
+	server := NewGRPCTransport("127.0.0.1:0", nil, time.Second)
+	server.SetHandler(handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("启动传输层失败: %v", err)
+	}
+
+	client := NewGRPCTransport("127.0.0.1:0", map[raft.NodeID]string{"node2": server.LocalAddr()}, time.Second)
+	defer client.Stop()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := client.SendAppendEntries(context.Background(), "node2", &raft.AppendEntriesRequest{Term: 1})
+		result <- err
+	}()
+	<-handler.entered
+
+	stopped := make(chan struct{})
+	go func() {
+		server.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatalf("在途RPC未完成时传输层不应停止")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(handler.block)
+	if err := <-result; err != nil {
+		t.Errorf("在途RPC应正常完成: %v", err)
+	}
+	<-stopped
+
+	if _, err := client.SendVoteRequest(context.Background(), "node2", &raft.VoteRequest{Term: 1}); err == nil {
+		t.Errorf("传输层停止后请求应失败")
+	}
+}
+
+// decodingHandler 解压并校验压缩追加请求的处理器，前corrupt个请求的负载在解码前被翻转一个字节
+type decodingHandler struct {
+	recordingHandler
+	mu       sync.Mutex
+	corrupt  int
+	rejected int
+	entries  []raft.LogEntry
+}
+
+func (h *decodingHandler) HandleCompressedAppendEntries(req *raft.CompressedAppendEntriesRequest) (*raft.CompressedAppendEntriesResponse, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.corrupt > 0 {
+		h.corrupt--
+		req.CompressedData = append([]byte(nil), req.CompressedData...)
+		req.CompressedData[len(req.CompressedData)/2] ^= 0xff
+	}
+
+	entries, err := raft.DecompressEntries(req)
+	if err != nil {
+		h.rejected++
+		return nil, err
+	}
+	h.entries = append(h.entries, entries...)
+	return &raft.CompressedAppendEntriesResponse{Term: req.Term, Success: true, BatchID: req.BatchID, ProcessedCount: len(entries)}, nil
+}
+
+// startCrossDCReplication 启动接收压缩批次的gRPC服务端，以及通过gRPC向其复制的跨DC复制管理器（dc1 -> dc2）
+func startCrossDCReplication(t *testing.T, handler *decodingHandler, compression string) (*raft.CrossDCReplicationManager, *GRPCTransport) {
+	t.Helper()
+
+	server := NewGRPCTransport("127.0.0.1:0", nil, time.Second)
+	server.SetHandler(handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("启动传输层失败: %v", err)
+	}
+	client := NewGRPCTransport("127.0.0.1:0", map[raft.NodeID]string{"node2": server.LocalAddr()}, time.Second)
+
+	config := &raft.Config{
+		NodeID: "node1",
+		Servers: []raft.Server{
+			{ID: "node1", DataCenter: "dc1"},
+			{ID: "node2", DataCenter: "dc2"},
+		},
+		MultiDC: &raft.MultiDCConfig{
+			Enabled:         true,
+			LocalDataCenter: &raft.DataCenterConfig{ID: "dc1", IsPrimary: true},
+			DataCenters: map[raft.DataCenterID]*raft.DataCenterConfig{
+				"dc1": {ID: "dc1", IsPrimary: true},
+				"dc2": {ID: "dc2"},
+			},
+			CrossDCCompression: compression,
+		},
+	}
+	manager := raft.NewCrossDCReplicationManager("node1", config, client)
+	if err := manager.Start(); err != nil {
+		t.Fatalf("启动跨DC复制管理器失败: %v", err)
+	}
+	t.Cleanup(func() {
+		manager.Stop()
+		client.Stop()
+		server.Stop()
+	})
+	return manager, client
+}
+
+// crossDCEntries 构造内容各不相同的日志条目
+func crossDCEntries(n int) []raft.LogEntry {
+	entries := testEntries(n, 0)
+	for i := range entries {
+		entries[i].Data = []byte(fmt.Sprintf("key-%d=value-%d", i, i*7919))
+	}
+	return entries
+}
+
+// waitReplicated 等待复制管理器确认复制了n个条目
+func waitReplicated(t *testing.T, manager *raft.CrossDCReplicationManager, n int64) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for manager.GetReplicationStats().TotalEntriesReplicated < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待复制 %d 个条目超时", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestCrossDCCompressedReplicationRoundTrip 一万条日志经压缩、gRPC发送、解压后与原始条目完全一致
+func TestCrossDCCompressedReplicationRoundTrip(t *testing.T) {
+	for _, compression := range []string{raft.CompressionGzip, raft.CompressionNone} {
+		t.Run(compression, func(t *testing.T) {
+			handler := &decodingHandler{}
+			manager, _ := startCrossDCReplication(t, handler, compression)
+
+			entries := crossDCEntries(10000)
+			if err := manager.ReplicateEntries(entries); err != nil {
+				t.Fatalf("复制日志失败: %v", err)
+			}
+			waitReplicated(t, manager, 10000)
+
+			handler.mu.Lock()
+			received := handler.entries
+			handler.mu.Unlock()
+			if !reflect.DeepEqual(received, entries) {
+				t.Fatalf("解压后的条目与原始条目不一致: 收到 %d 条", len(received))
+			}
+
+			stats := manager.GetReplicationStats()
+			if stats.CompressionErrors != 0 || stats.TotalEntriesReplicated != 10000 {
+				t.Errorf("统计不正确: %+v", stats)
+			}
+			if compression == raft.CompressionGzip && (stats.CompressionRatio <= 0 || stats.CompressionRatio >= 0.5) {
+				t.Errorf("gzip压缩比异常: %.3f", stats.CompressionRatio)
+			}
+			if compression == raft.CompressionNone && stats.CompressionRatio != 1 {
+				t.Errorf("不压缩时压缩比应为1，实际 %.3f", stats.CompressionRatio)
+			}
+			if status := manager.GetDCReplicationStatus()["dc2"]; status.LastReplicatedIndex != 10000 {
+				t.Errorf("复制进度应为10000，实际 %d", status.LastReplicatedIndex)
+			}
+		})
+	}
+}
+
+// TestCrossDCCorruptedBatchRejected 负载损坏的批次被接收方以ErrChecksumMismatch拒绝，发送方重发后成功
+func TestCrossDCCorruptedBatchRejected(t *testing.T) {
+	handler := &decodingHandler{corrupt: 1}
+	manager, client := startCrossDCReplication(t, handler, raft.CompressionGzip)
+
+	entries := crossDCEntries(100)
+	if err := manager.ReplicateEntries(entries); err != nil {
+		t.Fatalf("复制日志失败: %v", err)
+	}
+	waitReplicated(t, manager, 100)
+
+	handler.mu.Lock()
+	rejected, received := handler.rejected, handler.entries
+	handler.mu.Unlock()
+	if rejected != 1 {
+		t.Errorf("损坏的批次应被拒绝一次，实际 %d 次", rejected)
+	}
+	if !reflect.DeepEqual(received, entries) {
+		t.Errorf("重发后的条目与原始条目不一致: 收到 %d 条", len(received))
+	}
+	if stats := manager.GetReplicationStats(); stats.CompressionErrors != 1 {
+		t.Errorf("应记录一次压缩错误，实际 %d", stats.CompressionErrors)
+	}
+
+	// 未压缩负载的单字节损坏只能由校验和发现
+	req := &raft.CompressedAppendEntriesRequest{
+		Term: 1, LeaderID: "node1", CompressedData: []byte{0, 0, 0, 0}, OriginalSize: 4,
+		CompressionType: raft.CompressionNone, Checksum: 0x2144df1c, BatchID: "dc2-corrupt",
+	}
+	handler.mu.Lock()
+	handler.corrupt = 1
+	handler.mu.Unlock()
+	if _, err := client.SendCompressedAppendEntries(context.Background(), "node2", req); !errors.Is(err, raft.ErrChecksumMismatch) {
+		t.Errorf("损坏的批次应返回ErrChecksumMismatch，实际 %v", err)
+	}
+}
+
+// BenchmarkAppendEntriesRoundTrip 对比HTTP与gRPC传输层的AppendEntries往返延迟
+func BenchmarkAppendEntriesRoundTrip(b *testing.B) {
+	payloads := []struct {
+		name    string
+		entries int
+		size    int
+	}{
+		{"heartbeat", 0, 0},
+		{"64x256B", 64, 256},
+	}
+
+	kinds := []struct {
+		kind  Kind
+		start func(tb testing.TB, handler TransportHandler) (client raft.Transport, stop func())
+	}{
+		{KindHTTP, startHTTPPair},
+		{KindGRPC, startGRPCPair},
+	}
+
+	for _, payload := range payloads {
+		for _, k := range kinds {
+			b.Run(fmt.Sprintf("%s/%s", k.kind, payload.name), func(b *testing.B) {
+				client, stop := k.start(b, &recordingHandler{discard: true})
+				defer stop()
+
+				req := &raft.AppendEntriesRequest{Term: 1, LeaderID: "node1", Entries: testEntries(payload.entries, payload.size)}
+				ctx := context.Background()
+
+				// 预热连接
+				if _, err := client.SendAppendEntries(ctx, "node2", req); err != nil {
+					b.Fatalf("预热请求失败: %v", err)
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := client.SendAppendEntries(ctx, "node2", req); err != nil {
+						b.Fatalf("请求失败: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestTransportPing 两种传输层都支持探测对端，对端停止或地址未知时返回错误
+func TestTransportPing(t *testing.T) {
+	for name, start := range map[string]func(testing.TB, TransportHandler) (raft.Transport, func()){
+		"http": startHTTPPair,
+		"grpc": startGRPCPair,
+	} {
+		t.Run(name, func(t *testing.T) {
+			client, stop := start(t, &recordingHandler{})
+			pinger, ok := client.(raft.Pinger)
+			if !ok {
+				stop()
+				t.Fatalf("传输层没有实现raft.Pinger")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			if err := pinger.Ping(ctx, "node2"); err != nil {
+				stop()
+				t.Fatalf("探测对端失败: %v", err)
+			}
+			if err := pinger.Ping(ctx, "node3"); err == nil {
+				t.Errorf("未知节点应返回错误")
+			}
+
+			stop()
+			if err := pinger.Ping(ctx, "node2"); err == nil {
+				t.Errorf("对端停止后探测应失败")
+			}
+		})
+	}
+}
+
+// startHTTPPair 启动一对HTTP传输层，返回客户端
+func startHTTPPair(tb testing.TB, handler TransportHandler) (raft.Transport, func()) {
+	addr := freeAddr(tb)
+	server := NewHTTPTransport(addr, nil)
+	server.SetHandler(handler)
+	if err := server.Start(); err != nil {
+		tb.Fatalf("启动传输层失败: %v", err)
+	}
+
+	client := NewHTTPTransport(freeAddr(tb), map[raft.NodeID]string{"node2": addr})
+	return client, func() { server.Stop() }
+}
+
+// startGRPCPair 启动一对gRPC传输层，返回客户端
+func startGRPCPair(tb testing.TB, handler TransportHandler) (raft.Transport, func()) {
+	server := NewGRPCTransport("127.0.0.1:0", nil, 5*time.Second)
+	server.SetHandler(handler)
+	if err := server.Start(); err != nil {
+		tb.Fatalf("启动传输层失败: %v", err)
+	}
+
+	client := NewGRPCTransport("127.0.0.1:0", map[raft.NodeID]string{"node2": server.LocalAddr()}, 5*time.Second)
+	return client, func() {
+		client.Stop()
+		server.Stop()
+	}
+}
@@ -8,8 +8,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	// 修复导入路径
@@ -62,6 +64,7 @@ func RunTransactionIsolationDemo() {
 	demoReadCommitted(client)
 	demoRepeatableRead(client)
 	demoSerializable(client)
+	demoDeadlock(client)
 
 	fmt.Println("事务隔离级别演示完成.")
 }
@@ -81,6 +84,30 @@ func printAccounts(client *mockpkg.Client) {
 	fmt.Println("------------------------")
 }
 
+// 等待Watch通知，打印并发提交变为可见的时刻
+func awaitCommit(events <-chan mockpkg.WatchEvent) {
+	select {
+	case event, ok := <-events:
+		if !ok {
+			fmt.Println("Watch: 订阅已关闭")
+			return
+		}
+		fmt.Printf("Watch: 收到提交通知 %s=%s (revision %d)，此刻起提交对新读取可见\n", event.Key, event.Value, event.Revision)
+	case <-time.After(time.Second):
+		fmt.Println("Watch: 等待提交通知超时")
+	}
+}
+
+// 短暂等待，确认Watch没有收到通知
+func expectNoCommit(events <-chan mockpkg.WatchEvent, reason string) {
+	select {
+	case event := <-events:
+		fmt.Printf("Watch: 意外收到通知 %s=%s\n", event.Key, event.Value)
+	case <-time.After(50 * time.Millisecond):
+		fmt.Printf("Watch: 未收到通知 (%s)\n", reason)
+	}
+}
+
 // 演示读未提交隔离级别
 func demoReadUncommitted(client *mockpkg.Client) {
 	fmt.Println("\n=== 演示读未提交隔离级别 ===")
@@ -90,6 +117,10 @@ func demoReadUncommitted(client *mockpkg.Client) {
 	client.Set("account1", "1000")
 	client.Set("account2", "2000")
 
+	// 订阅account1的已提交变更
+	events, cancel := client.Watch("account1")
+	defer cancel()
+
 	// 创建第一个事务（写入者）
 	txWriter := client.NewTransaction().WithIsolation(mockpkg.IsolationReadUncommitted)
 
@@ -100,6 +131,7 @@ func demoReadUncommitted(client *mockpkg.Client) {
 	}
 
 	fmt.Println("事务1: 已修改account1为900但尚未提交")
+	expectNoCommit(events, "未提交的写入可能被回滚，不会通知订阅者")
 
 	// 创建第二个事务（读取者）
 	txReader := client.NewTransaction().WithIsolation(mockpkg.IsolationReadUncommitted)
@@ -115,6 +147,7 @@ func demoReadUncommitted(client *mockpkg.Client) {
 	// 回滚第一个事务
 	txWriter.Rollback()
 	fmt.Println("事务1: 已回滚")
+	expectNoCommit(events, "回滚的写入从未提交")
 
 	// 提交第二个事务
 	txReader.Commit()
@@ -144,19 +177,26 @@ func demoReadCommitted(client *mockpkg.Client) {
 		fmt.Printf("事务1: 第一次读取account1的值: %s\n", val1)
 	}
 
-	// 创建第二个事务（写入者）并修改数据
-	txWriter := client.NewTransaction().WithIsolation(mockpkg.IsolationReadCommitted)
-	err = txWriter.Set("account1", "800")
-	if err != nil {
-		fmt.Printf("修改数据失败: %v\n", err)
-	}
-
-	// 提交第二个事务
-	err = txWriter.Commit()
+	// 创建第二个事务（写入者），在另一个goroutine中稍后修改数据并提交
+	start := time.Now()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		txWriter := client.NewTransaction().WithIsolation(mockpkg.IsolationReadCommitted)
+		if err := txWriter.Set("account1", "800"); err != nil {
+			fmt.Printf("修改数据失败: %v\n", err)
+		}
+		if err := txWriter.Commit(); err != nil {
+			fmt.Printf("提交事务失败: %v\n", err)
+		}
+	}()
+
+	// 等待并发提交变为可见
+	err = client.WaitForValue("account1", "800", time.Second)
 	if err != nil {
-		fmt.Printf("提交事务失败: %v\n", err)
+		fmt.Printf("等待并发提交失败: %v\n", err)
+	} else {
+		fmt.Printf("事务2: 已修改account1为800并提交，WaitForValue在第%v观察到提交\n", time.Since(start).Round(10*time.Millisecond))
 	}
-	fmt.Println("事务2: 已修改account1为800并提交")
 
 	// 在第一个事务中再次读取
 	val2, err := txReader.Get("account1")
@@ -194,6 +234,10 @@ func demoRepeatableRead(client *mockpkg.Client) {
 		fmt.Printf("事务1: 第一次读取account1的值: %s\n", val1)
 	}
 
+	// 订阅account1的已提交变更
+	events, cancel := client.Watch("account1")
+	defer cancel()
+
 	// 创建第二个事务（写入者）并修改数据
 	txWriter := client.NewTransaction().WithIsolation(mockpkg.IsolationRepeatableRead)
 	err = txWriter.Set("account1", "700")
@@ -207,13 +251,14 @@ func demoRepeatableRead(client *mockpkg.Client) {
 		fmt.Printf("提交事务失败: %v\n", err)
 	}
 	fmt.Println("事务2: 已修改account1为700并提交")
+	awaitCommit(events)
 
 	// 在第一个事务中再次读取
 	val2, err := txReader.Get("account1")
 	if err != nil {
 		fmt.Printf("第二次读取失败: %v\n", err)
 	} else {
-		fmt.Printf("事务1: 第二次读取account1的值: %s (与第一次读取的值相同，即使数据已被其他事务修改)\n", val2)
+		fmt.Printf("事务1: 第二次读取account1的值: %s (与第一次读取的值相同，即使Watch已确认数据被其他事务修改)\n", val2)
 	}
 
 	// 提交第一个事务
@@ -259,6 +304,10 @@ func demoSerializable(client *mockpkg.Client) {
 
 	fmt.Printf("事务1: 读取两个账户的初始余额 - account1: %s, account2: %s\n", balance1, balance2)
 
+	// 订阅account1的已提交变更
+	events, cancel := client.Watch("account1")
+	defer cancel()
+
 	// 模拟并发事务干扰
 	fmt.Println("模拟并发干扰: 另一个事务修改account1")
 	interfereTx := client.NewTransaction()
@@ -272,6 +321,7 @@ func demoSerializable(client *mockpkg.Client) {
 	} else {
 		fmt.Println("干扰事务: 已修改account1为1200并提交")
 	}
+	awaitCommit(events)
 
 	// 在第一个事务中转账
 	fmt.Println("事务1: 尝试从account1转账300到account2")
@@ -306,3 +356,57 @@ func demoSerializable(client *mockpkg.Client) {
 	fmt.Println("这是最高的隔离级别，但会降低并发性能")
 	fmt.Println("------------------------")
 }
+
+// 演示串行化事务的死锁检测
+func demoDeadlock(client *mockpkg.Client) {
+	fmt.Println("\n=== 演示串行化事务的死锁检测 ===")
+	fmt.Println("串行化事务写入前会获取键的排他锁，以相反顺序写入两个键的事务会互相等待")
+
+	// 重置测试数据
+	client.Set("account1", "1000")
+	client.Set("account2", "2000")
+
+	// 事务1先写account1，事务2先写account2
+	tx1 := client.NewTransaction().WithIsolation(mockpkg.IsolationSerializable)
+	tx2 := client.NewTransaction().WithIsolation(mockpkg.IsolationSerializable)
+	if err := tx1.Set("account1", "900"); err != nil {
+		fmt.Printf("事务1修改account1失败: %v\n", err)
+		return
+	}
+	fmt.Println("事务1: 锁定并修改account1为900")
+	if err := tx2.Set("account2", "1900"); err != nil {
+		fmt.Printf("事务2修改account2失败: %v\n", err)
+		return
+	}
+	fmt.Println("事务2: 锁定并修改account2为1900")
+
+	// 两个事务再并发写入对方持有的键，形成等待环
+	fmt.Println("事务1请求account2，事务2请求account1...")
+	var wg sync.WaitGroup
+	transfer := func(name string, tx *mockpkg.Transaction, key, value string) {
+		defer wg.Done()
+		err := tx.Set(key, value)
+		if err == nil {
+			err = tx.Commit()
+		}
+		switch {
+		case errors.Is(err, mockpkg.ErrDeadlock):
+			fmt.Printf("%s: 被选为死锁牺牲者并中止，释放持有的锁 (%v)\n", name, err)
+		case err != nil:
+			fmt.Printf("%s: 失败: %v\n", name, err)
+		default:
+			fmt.Printf("%s: 获取%s的锁并提交成功\n", name, key)
+		}
+	}
+	wg.Add(2)
+	go transfer("事务1", tx1, "account2", "2100")
+	go transfer("事务2", tx2, "account1", "1100")
+	wg.Wait()
+
+	fmt.Println("当前账户余额:")
+	printAccounts(client)
+
+	fmt.Println("等待图中出现环时，请求方被选为牺牲者并返回ErrDeadlock，另一个事务得以继续")
+	fmt.Println("没有环但等待过久时返回ErrLockTimeout（默认5秒，可通过Config.LockWaitTimeout配置）")
+	fmt.Println("------------------------")
+}
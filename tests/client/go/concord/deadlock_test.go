@@ -0,0 +1,139 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-7 15:40:52
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-7 15:40:52
+* @Description: ConcordKV Go client - deadlock_test.go
+ */
+package concord
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	mockpkg "github.com/concordkv/tests/client/go/mock"
+)
+
+// setupLockTest 创建指定锁等待超时的模拟客户端
+func setupLockTest(t *testing.T, lockWaitTimeout time.Duration) *mockpkg.Client {
+	client, err := mockpkg.NewClient(mockpkg.Config{
+		Endpoints:       []string{"127.0.0.1:5001"},
+		LockWaitTimeout: lockWaitTimeout,
+	})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// runLockCycle 每个事务先锁定keys[i]，再并发请求keys[i+1]形成等待环
+// 请求成功的事务随即提交以释放锁，返回每个事务第二次写入的结果
+func runLockCycle(t *testing.T, client *mockpkg.Client, keys []string) []error {
+	txs := make([]*mockpkg.Transaction, len(keys))
+	for i, key := range keys {
+		txs[i] = client.NewTransaction().WithIsolation(mockpkg.IsolationSerializable)
+		if err := txs[i].Set(key, "first"); err != nil {
+			t.Fatalf("事务%d锁定 %s 失败: %v", i+1, key, err)
+		}
+	}
+
+	results := make([]chan error, len(keys))
+	for i := range txs {
+		results[i] = make(chan error, 1)
+		go func(i int) {
+			err := txs[i].Set(keys[(i+1)%len(keys)], "second")
+			if err == nil {
+				err = txs[i].Commit()
+			}
+			results[i] <- err
+		}(i)
+	}
+
+	errs := make([]error, len(keys))
+	for i := range results {
+		select {
+		case errs[i] = <-results[i]:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("事务%d一直在等待锁，死锁未被检测", i+1)
+		}
+	}
+	return errs
+}
+
+// expectOneVictim 检查恰好一个事务因死锁被中止，其余事务都提交成功
+func expectOneVictim(t *testing.T, errs []error) {
+	victims := 0
+	for i, err := range errs {
+		switch {
+		case errors.Is(err, mockpkg.ErrDeadlock):
+			victims++
+		case err != nil:
+			t.Fatalf("事务%d出现意外错误: %v", i+1, err)
+		}
+	}
+	if victims != 1 {
+		t.Fatalf("应恰好有一个牺牲者，实际 %d: %v", victims, errs)
+	}
+}
+
+// TestDeadlockABBA 测试两个串行化事务以相反顺序写入时检测到死锁
+func TestDeadlockABBA(t *testing.T) {
+	client := setupLockTest(t, 5*time.Second)
+
+	start := time.Now()
+	errs := runLockCycle(t, client, []string{"A", "B"})
+	expectOneVictim(t, errs)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("死锁应被立即检测，而不是等待锁超时: %v", elapsed)
+	}
+
+	// 幸存事务的两次写入都已提交
+	for _, key := range []string{"A", "B"} {
+		if val, _ := client.Get(key); val == "" {
+			t.Fatalf("幸存事务的写入未提交: %s", key)
+		}
+	}
+}
+
+// TestDeadlockThreeWayCycle 测试三个事务形成的等待环
+func TestDeadlockThreeWayCycle(t *testing.T) {
+	client := setupLockTest(t, 5*time.Second)
+
+	errs := runLockCycle(t, client, []string{"A", "B", "C"})
+	expectOneVictim(t, errs)
+}
+
+// TestLockWaitTimeout 测试等待锁超时，以及回滚释放持有的锁
+func TestLockWaitTimeout(t *testing.T) {
+	client := setupLockTest(t, 50*time.Millisecond)
+
+	holder := client.NewTransaction().WithIsolation(mockpkg.IsolationSerializable)
+	if err := holder.Set("A", "holder"); err != nil {
+		t.Fatalf("锁定A失败: %v", err)
+	}
+
+	waiter := client.NewTransaction().WithIsolation(mockpkg.IsolationSerializable)
+	start := time.Now()
+	if err := waiter.Set("A", "waiter"); !errors.Is(err, mockpkg.ErrLockTimeout) {
+		t.Fatalf("应返回ErrLockTimeout，实际 %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("应等待锁超时后返回: %v", elapsed)
+	}
+
+	// 回滚释放锁后，等待者可以继续写入并提交
+	if err := holder.Rollback(); err != nil {
+		t.Fatalf("回滚失败: %v", err)
+	}
+	if err := waiter.Set("A", "waiter"); err != nil {
+		t.Fatalf("持有者回滚后应能获取锁: %v", err)
+	}
+	if err := waiter.Commit(); err != nil {
+		t.Fatalf("提交失败: %v", err)
+	}
+	if val, _ := client.Get("A"); val != "waiter" {
+		t.Fatalf("A = %q，预期 waiter", val)
+	}
+}
@@ -0,0 +1,112 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-7 10:26:14
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-7 10:26:14
+* @Description: ConcordKV Go client - watch_test.go
+ */
+package concord
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	mockpkg "github.com/concordkv/tests/client/go/mock"
+)
+
+// TestWatchCommittedChanges 测试只有已提交的变更会通知订阅者
+func TestWatchCommittedChanges(t *testing.T) {
+	client, cleanup := setupBatchTest(t)
+	defer cleanup()
+
+	events, cancel := client.Watch("batch:1")
+	defer cancel()
+
+	// 读未提交事务中的写入尚未提交，不应产生通知
+	tx := client.NewTransaction().WithIsolation(mockpkg.IsolationReadUncommitted)
+	tx.Set("batch:1", "dirty")
+	tx.Set("batch:2", "dirty")
+	select {
+	case event := <-events:
+		t.Fatalf("未提交的写入不应产生通知: %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("提交事务失败: %v", err)
+	}
+	select {
+	case event := <-events:
+		if event.Key != "batch:1" || event.Value != "dirty" || event.Deleted || event.Revision == 0 {
+			t.Fatalf("通知内容不正确: %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("提交后未收到通知")
+	}
+
+	client.Delete("batch:1")
+	if event := <-events; !event.Deleted {
+		t.Fatalf("删除后应收到删除通知: %+v", event)
+	}
+
+	// 取消后通道被关闭
+	cancel()
+	if _, ok := <-events; ok {
+		t.Fatalf("取消订阅后通道应被关闭")
+	}
+}
+
+// TestWaitForValue 测试等待并发提交的值
+func TestWaitForValue(t *testing.T) {
+	client, cleanup := setupBatchTest(t)
+	defer cleanup()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		tx := client.NewTransaction().WithIsolation(mockpkg.IsolationReadCommitted)
+		tx.Set("batch:2", "updated")
+		tx.Commit()
+	}()
+	if err := client.WaitForValue("batch:2", "updated", time.Second); err != nil {
+		t.Fatalf("等待提交的值失败: %v", err)
+	}
+
+	// 当前值已满足时立即返回
+	if err := client.WaitForValue("batch:2", "updated", time.Millisecond); err != nil {
+		t.Fatalf("当前值已满足时应立即返回: %v", err)
+	}
+
+	if err := client.WaitForValue("batch:3", "never", 20*time.Millisecond); !errors.Is(err, mockpkg.ErrWatchTimeout) {
+		t.Fatalf("应返回ErrWatchTimeout，实际 %v", err)
+	}
+}
+
+// TestWatchCancelNoLeak 测试取消订阅后转发goroutine退出，即使消费者从未读取
+func TestWatchCancelNoLeak(t *testing.T) {
+	client, cleanup := setupBatchTest(t)
+	defer cleanup()
+
+	baseline := runtime.NumGoroutine()
+	cancels := make([]func(), 0, 100)
+	for i := 0; i < 100; i++ {
+		_, cancel := client.Watch("batch:3")
+		cancels = append(cancels, cancel)
+	}
+	for i := 0; i < 10; i++ {
+		client.Set("batch:3", "value")
+	}
+	for _, cancel := range cancels {
+		cancel()
+		cancel()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			t.Fatalf("取消订阅后goroutine未退出: 基线 %d，当前 %d", baseline, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
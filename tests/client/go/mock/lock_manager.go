@@ -0,0 +1,135 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-7 15:40:52
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-7 15:40:52
+* @Description: ConcordKV Go client - lock_manager.go
+ */
+package mock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 默认的锁等待超时时间
+const defaultLockWaitTimeout = 5 * time.Second
+
+// lockManager 串行化事务的键级排他锁表
+// 每个键最多有一个持有者，每个等待中的事务只等待一个键，
+// 因此等待图中每个事务最多有一条出边：事务 -> 等待的键 -> 键的持有者
+type lockManager struct {
+	mu       sync.Mutex
+	owners   map[string]string              // key -> 持有锁的事务ID
+	held     map[string]map[string]struct{} // 事务ID -> 持有的键
+	waiting  map[string]string              // 事务ID -> 正在等待的键
+	released map[string]chan struct{}       // key -> 锁释放时关闭的通道，有等待者时才创建
+}
+
+func newLockManager() *lockManager {
+	return &lockManager{
+		owners:   make(map[string]string),
+		held:     make(map[string]map[string]struct{}),
+		waiting:  make(map[string]string),
+		released: make(map[string]chan struct{}),
+	}
+}
+
+// acquire 为事务获取键的排他锁，已持有时直接返回
+// 等待会形成环时请求方作为牺牲者返回ErrDeadlock，等待超过timeout返回ErrLockTimeout
+func (lm *lockManager) acquire(txID, key string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		lm.mu.Lock()
+		owner, locked := lm.owners[key]
+		if !locked || owner == txID {
+			lm.owners[key] = txID
+			if lm.held[txID] == nil {
+				lm.held[txID] = make(map[string]struct{})
+			}
+			lm.held[txID][key] = struct{}{}
+			delete(lm.waiting, txID)
+			lm.mu.Unlock()
+			return nil
+		}
+
+		if cycle := lm.findCycleLocked(txID, owner); cycle != nil {
+			delete(lm.waiting, txID)
+			lm.mu.Unlock()
+			return fmt.Errorf("%w: 等待链 %v", ErrDeadlock, cycle)
+		}
+
+		lm.waiting[txID] = key
+		ch, ok := lm.released[key]
+		if !ok {
+			ch = make(chan struct{})
+			lm.released[key] = ch
+		}
+		lm.mu.Unlock()
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			lm.stopWaiting(txID)
+			return fmt.Errorf("%w: 键 %s 被事务 %s 持有", ErrLockTimeout, key, owner)
+		}
+		timer := time.NewTimer(remaining)
+		select {
+		case <-ch:
+			timer.Stop()
+		case <-timer.C:
+			lm.stopWaiting(txID)
+			return fmt.Errorf("%w: 键 %s 被事务 %s 持有", ErrLockTimeout, key, owner)
+		}
+	}
+}
+
+// findCycleLocked 沿等待图从owner出发，若回到txID则返回环上的事务（调用方需持有lm.mu）
+func (lm *lockManager) findCycleLocked(txID, owner string) []string {
+	path := []string{txID}
+	visited := map[string]bool{txID: true}
+	for cur := owner; ; {
+		if cur == txID {
+			return append(path, txID)
+		}
+		if visited[cur] {
+			// 环不经过txID，与本次请求无关，由环上的事务自行检测
+			return nil
+		}
+		visited[cur] = true
+		path = append(path, cur)
+
+		key, ok := lm.waiting[cur]
+		if !ok {
+			return nil
+		}
+		next, ok := lm.owners[key]
+		if !ok {
+			return nil
+		}
+		cur = next
+	}
+}
+
+// stopWaiting 移除事务的等待边
+func (lm *lockManager) stopWaiting(txID string) {
+	lm.mu.Lock()
+	delete(lm.waiting, txID)
+	lm.mu.Unlock()
+}
+
+// releaseAll 释放事务持有的所有锁并唤醒等待者
+func (lm *lockManager) releaseAll(txID string) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	for key := range lm.held[txID] {
+		delete(lm.owners, key)
+		if ch, ok := lm.released[key]; ok {
+			close(ch)
+			delete(lm.released, key)
+		}
+	}
+	delete(lm.held, txID)
+	delete(lm.waiting, txID)
+}
@@ -9,8 +9,10 @@ package mock
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,6 +21,8 @@ var (
 	ErrKeyNotFound          = errors.New("key not found")
 	ErrMockInternal         = errors.New("内部错误")
 	ErrOptimisticLockFailed = errors.New("乐观锁冲突")
+	ErrDeadlock             = errors.New("检测到死锁，事务被选为牺牲者")
+	ErrLockTimeout          = errors.New("等待锁超时")
 )
 
 // 隔离级别常量
@@ -44,6 +48,8 @@ type Config struct {
 	EnableCache bool          // 是否启用缓存
 	CacheSize   int           // 缓存大小
 	CacheTTL    time.Duration // 缓存过期时间
+
+	LockWaitTimeout time.Duration // 串行化事务等待键锁的超时时间，默认5秒
 }
 
 // KeyValue 定义键值对
@@ -78,19 +84,34 @@ type Client struct {
 	mu     sync.RWMutex
 	reads  map[string]map[string]string // 事务ID -> key -> 读取的值
 	txLock sync.Mutex
+
+	watchers map[string]map[*watcher]struct{} // key -> 订阅者，由mu保护
+	revision int64                            // 已提交变更的版本号，由mu保护
+
+	locks *lockManager // 串行化事务的键锁表
+	txSeq int64        // 事务序号，保证并发创建的事务ID唯一
 }
 
 // NewClient 创建新的客户端
 func NewClient(config Config) (*Client, error) {
+	if config.LockWaitTimeout <= 0 {
+		config.LockWaitTimeout = defaultLockWaitTimeout
+	}
+
 	return &Client{
 		config: config,
 		store:  make(map[string]string),
 		reads:  make(map[string]map[string]string),
+		locks:  newLockManager(),
 	}, nil
 }
 
 // Close 关闭客户端连接
 func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closeWatchersLocked()
 	return nil
 }
 
@@ -112,6 +133,8 @@ func (c *Client) Set(key, value string) error {
 	defer c.mu.Unlock()
 
 	c.store[key] = value
+	c.revision++
+	c.publishLocked(key, value, false, c.revision)
 	return nil
 }
 
@@ -120,7 +143,11 @@ func (c *Client) Delete(key string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	delete(c.store, key)
+	if _, ok := c.store[key]; ok {
+		delete(c.store, key)
+		c.revision++
+		c.publishLocked(key, "", true, c.revision)
+	}
 	return nil
 }
 
@@ -293,7 +320,7 @@ func (tx *Transaction) GetOptions() Options {
 
 // NewTransaction 创建一个新的事务
 func (c *Client) NewTransaction() *Transaction {
-	txID := "tx-" + time.Now().Format("20060102150405.000")
+	txID := fmt.Sprintf("tx-%s-%d", time.Now().Format("20060102150405.000"), atomic.AddInt64(&c.txSeq, 1))
 
 	return &Transaction{
 		client:         c,
@@ -343,6 +370,26 @@ func (tx *Transaction) isTimeout() bool {
 	return time.Since(tx.startTime) > time.Duration(tx.timeout)*time.Second
 }
 
+// abort 中止事务并释放持有的键锁
+func (tx *Transaction) abort() {
+	tx.status = "aborted"
+	tx.client.locks.releaseAll(tx.id)
+}
+
+// lockForWrite 串行化事务在写入前获取键的排他锁，直到提交或回滚才释放
+// 被选为死锁牺牲者时事务被中止；等待锁超时只使本次写入失败，事务仍可继续或回滚
+func (tx *Transaction) lockForWrite(key string) error {
+	if tx.isolationLevel != IsolationSerializable {
+		return nil
+	}
+
+	err := tx.client.locks.acquire(tx.id, key, tx.client.config.LockWaitTimeout)
+	if errors.Is(err, ErrDeadlock) {
+		tx.abort()
+	}
+	return err
+}
+
 // Get 在事务内获取键值
 func (tx *Transaction) Get(key string) (string, error) {
 	if tx.status != "active" {
@@ -350,7 +397,7 @@ func (tx *Transaction) Get(key string) (string, error) {
 	}
 
 	if tx.isTimeout() {
-		tx.status = "aborted"
+		tx.abort()
 		return "", errors.New("事务超时")
 	}
 
@@ -418,7 +465,7 @@ func (tx *Transaction) Set(key, value string) error {
 	}
 
 	if tx.isTimeout() {
-		tx.status = "aborted"
+		tx.abort()
 		return errors.New("事务超时")
 	}
 
@@ -426,6 +473,10 @@ func (tx *Transaction) Set(key, value string) error {
 		return errors.New("只读事务不允许写操作")
 	}
 
+	if err := tx.lockForWrite(key); err != nil {
+		return err
+	}
+
 	// 记录设置操作
 	tx.operations = append(tx.operations, txOperation{
 		Type:  txOpSet,
@@ -446,7 +497,7 @@ func (tx *Transaction) Delete(key string) error {
 	}
 
 	if tx.isTimeout() {
-		tx.status = "aborted"
+		tx.abort()
 		return errors.New("事务超时")
 	}
 
@@ -454,6 +505,10 @@ func (tx *Transaction) Delete(key string) error {
 		return errors.New("只读事务不允许写操作")
 	}
 
+	if err := tx.lockForWrite(key); err != nil {
+		return err
+	}
+
 	// 记录删除操作
 	tx.operations = append(tx.operations, txOperation{
 		Type: txOpDelete,
@@ -473,7 +528,7 @@ func (tx *Transaction) BatchGet(keys []string) (*BatchResult, error) {
 	}
 
 	if tx.isTimeout() {
-		tx.status = "aborted"
+		tx.abort()
 		return nil, errors.New("事务超时")
 	}
 
@@ -507,7 +562,7 @@ func (tx *Transaction) BatchSet(pairs []KeyValue) (*BatchResult, error) {
 	}
 
 	if tx.isTimeout() {
-		tx.status = "aborted"
+		tx.abort()
 		return nil, errors.New("事务超时")
 	}
 
@@ -544,7 +599,7 @@ func (tx *Transaction) BatchDelete(keys []string) (*BatchResult, error) {
 	}
 
 	if tx.isTimeout() {
-		tx.status = "aborted"
+		tx.abort()
 		return nil, errors.New("事务超时")
 	}
 
@@ -581,7 +636,7 @@ func (tx *Transaction) Commit() error {
 	}
 
 	if tx.isTimeout() {
-		tx.status = "aborted"
+		tx.abort()
 		return errors.New("事务超时")
 	}
 
@@ -601,27 +656,33 @@ func (tx *Transaction) Commit() error {
 			// 如果值已更改，则发生冲突
 			if !ok || currentValue != originalValue {
 				tx.client.mu.RUnlock()
-				tx.status = "aborted"
+				tx.abort()
 				return ErrOptimisticLockFailed
 			}
 		}
 		tx.client.mu.RUnlock()
 	}
 
-	// 准备批量写入请求
+	// 准备批量写入请求，同一事务的变更共用一个版本号，并在释放锁前通知订阅者
 	tx.client.mu.Lock()
+	if len(tx.writeSet) > 0 {
+		tx.client.revision++
+	}
 	for key, value := range tx.writeSet {
 		if value == "" {
 			// 这是删除操作
 			delete(tx.client.store, key)
+			tx.client.publishLocked(key, "", true, tx.client.revision)
 		} else {
 			// 这是设置操作
 			tx.client.store[key] = value
+			tx.client.publishLocked(key, value, false, tx.client.revision)
 		}
 	}
 	tx.client.mu.Unlock()
 
 	tx.status = "committed"
+	tx.client.locks.releaseAll(tx.id)
 	return nil
 }
 
@@ -631,7 +692,7 @@ func (tx *Transaction) Rollback() error {
 		return errors.New("事务已关闭")
 	}
 
-	tx.status = "aborted"
+	tx.abort()
 	log.Printf("事务 %s 已回滚", tx.id)
 	return nil
 }
@@ -0,0 +1,175 @@
+/*
+* @Author: Lzww0608
+* @Date: 2025-7-7 10:26:14
+* @LastEditors: Lzww0608
+* @LastEditTime: 2025-7-7 10:26:14
+* @Description: ConcordKV Go client - watch.go
+ */
+package mock
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrWatchTimeout 等待键变为期望值超时
+var ErrWatchTimeout = errors.New("等待键值超时")
+
+// WatchEvent 键的变更通知
+type WatchEvent struct {
+	Key      string
+	Value    string
+	Deleted  bool  // 键被删除时为true，此时Value为空
+	Revision int64 // 全局递增的提交版本，同一事务提交的所有变更版本相同
+}
+
+// watcher 单个键的订阅者
+// 发布方只把事件追加到pending，由转发goroutine按顺序投递到out，
+// 因此消费者读取缓慢不会阻塞写入路径，也不会丢失事件
+type watcher struct {
+	key string
+
+	mu      sync.Mutex
+	pending []WatchEvent
+
+	notify chan struct{} // 容量为1，pending非空时发出信号
+	done   chan struct{} // 取消订阅时关闭
+	out    chan WatchEvent
+	once   sync.Once
+}
+
+func newWatcher(key string) *watcher {
+	w := &watcher{
+		key:    key,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		out:    make(chan WatchEvent),
+	}
+	go w.forward()
+	return w
+}
+
+// push 追加事件并唤醒转发goroutine，不会阻塞
+func (w *watcher) push(event WatchEvent) {
+	w.mu.Lock()
+	w.pending = append(w.pending, event)
+	w.mu.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// forward 把pending中的事件依次投递给消费者，取消订阅后关闭out并退出
+func (w *watcher) forward() {
+	defer close(w.out)
+
+	for {
+		w.mu.Lock()
+		if len(w.pending) == 0 {
+			w.mu.Unlock()
+			select {
+			case <-w.notify:
+				continue
+			case <-w.done:
+				return
+			}
+		}
+		event := w.pending[0]
+		w.pending = w.pending[1:]
+		w.mu.Unlock()
+
+		select {
+		case w.out <- event:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// stop 停止转发，可重复调用
+func (w *watcher) stop() {
+	w.once.Do(func() { close(w.done) })
+}
+
+// Watch 订阅键的变更，返回事件通道与取消函数
+//
+// 只有已提交的变更会产生通知：客户端直接的Set/Delete，以及事务Commit写入的每个键。
+// 事务中尚未提交的Set/Delete（包括读未提交隔离级别下的写入）不会通知，
+// 因为这些写入可能被回滚；读未提交下的脏读只能通过事务内的Get观察到。
+// 这样Watch收到事件的时刻就是该变更对读已提交及以上隔离级别的新读取可见的时刻。
+//
+// 调用取消函数（或关闭客户端）后通道被关闭，转发goroutine随之退出；取消函数可重复调用
+func (c *Client) Watch(key string) (<-chan WatchEvent, func()) {
+	w := newWatcher(key)
+
+	c.mu.Lock()
+	if c.watchers == nil {
+		c.watchers = make(map[string]map[*watcher]struct{})
+	}
+	if c.watchers[key] == nil {
+		c.watchers[key] = make(map[*watcher]struct{})
+	}
+	c.watchers[key][w] = struct{}{}
+	c.mu.Unlock()
+
+	cancel := func() {
+		c.mu.Lock()
+		if set := c.watchers[key]; set != nil {
+			delete(set, w)
+			if len(set) == 0 {
+				delete(c.watchers, key)
+			}
+		}
+		c.mu.Unlock()
+		w.stop()
+	}
+	return w.out, cancel
+}
+
+// publishLocked 通知订阅了该键的watcher（调用方需持有c.mu写锁，以保证事件顺序与提交顺序一致）
+func (c *Client) publishLocked(key, value string, deleted bool, revision int64) {
+	for w := range c.watchers[key] {
+		w.push(WatchEvent{Key: key, Value: value, Deleted: deleted, Revision: revision})
+	}
+}
+
+// closeWatchersLocked 取消所有订阅（调用方需持有c.mu写锁）
+func (c *Client) closeWatchersLocked() {
+	for _, set := range c.watchers {
+		for w := range set {
+			w.stop()
+		}
+	}
+	c.watchers = nil
+}
+
+// WaitForValue 等待键的已提交值变为expected，超时返回ErrWatchTimeout
+// 先订阅再检查当前值，因此不会错过两者之间提交的变更
+func (c *Client) WaitForValue(key, expected string, timeout time.Duration) error {
+	events, cancel := c.Watch(key)
+	defer cancel()
+
+	if val, err := c.Get(key); err == nil && val == expected {
+		return nil
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return errors.New("客户端已关闭")
+			}
+			if !event.Deleted && event.Value == expected {
+				return nil
+			}
+		case <-timer.C:
+			return fmt.Errorf("%w: %s 未在 %v 内变为 %q", ErrWatchTimeout, key, timeout, expected)
+		}
+	}
+}
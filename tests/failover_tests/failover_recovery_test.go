@@ -256,6 +256,17 @@ func (mt *MockTransport) SendInstallSnapshot(ctx context.Context, target raft.No
 	}, nil
 }
 
+func (mt *MockTransport) SendTimeoutNow(ctx context.Context, target raft.NodeID, req *raft.TimeoutNowRequest) (*raft.TimeoutNowResponse, error) {
+	time.Sleep(mt.networkDelay)
+	if mt.isPartitioned(string(target)) {
+		return nil, fmt.Errorf("network partition")
+	}
+	return &raft.TimeoutNowResponse{
+		Term:    req.Term,
+		Success: true,
+	}, nil
+}
+
 func (mt *MockTransport) SetNetworkDelay(delay time.Duration) {
 	mt.mu.Lock()
 	defer mt.mu.Unlock()
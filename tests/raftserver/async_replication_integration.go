@@ -146,6 +146,16 @@ type DataCenterMetrics struct {
 	ErrorCount        uint64
 }
 
+// ReplicationBatch 发送给目标DC的复制批次
+type ReplicationBatch struct {
+	TargetDC   DataCenterID
+	Entries    []LogEntry
+	StartIndex LogIndex
+	EndIndex   LogIndex
+	CreatedAt  time.Time
+	RetryCount int
+}
+
 // AsyncReplicator 异步复制管理器
 type AsyncReplicator struct {
 	nodeID     NodeID
@@ -154,13 +164,24 @@ type AsyncReplicator struct {
 	transport  Transport
 	storage    Storage
 	targets    map[DataCenterID]*AsyncReplicationTarget
+	buffers    map[DataCenterID]*replicationBuffer
 	metrics    *AsyncReplicationMetrics
 	ctx        context.Context
 	cancel     context.CancelFunc
+	wg         sync.WaitGroup
 	isRunning  bool
 	mu         sync.RWMutex
 }
 
+// replicationBuffer 目标DC的待发送条目，由该DC的刷新协程消费
+type replicationBuffer struct {
+	entries     []LogEntry
+	queuedAt    []time.Time
+	lastQueued  LogIndex
+	nextNode    int
+	flushSignal chan struct{}
+}
+
 // NewAsyncReplicator 创建新的异步复制管理器
 func NewAsyncReplicator(nodeID NodeID, raftConfig *Config, transport Transport, storage Storage) *AsyncReplicator {
 	config := &AsyncReplicationConfig{
@@ -172,8 +193,6 @@ func NewAsyncReplicator(nodeID NodeID, raftConfig *Config, transport Transport,
 		MonitoringEnabled:  true,
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-
 	return &AsyncReplicator{
 		nodeID:     nodeID,
 		config:     config,
@@ -181,16 +200,15 @@ func NewAsyncReplicator(nodeID NodeID, raftConfig *Config, transport Transport,
 		transport:  transport,
 		storage:    storage,
 		targets:    make(map[DataCenterID]*AsyncReplicationTarget),
+		buffers:    make(map[DataCenterID]*replicationBuffer),
 		metrics: &AsyncReplicationMetrics{
 			DataCenterMetrics: make(map[DataCenterID]*DataCenterMetrics),
 		},
-		ctx:       ctx,
-		cancel:    cancel,
 		isRunning: false,
 	}
 }
 
-// Start 启动异步复制管理器
+// Start 启动异步复制管理器，为每个目标DC启动刷新协程
 func (ar *AsyncReplicator) Start() error {
 	ar.mu.Lock()
 	defer ar.mu.Unlock()
@@ -199,61 +217,227 @@ func (ar *AsyncReplicator) Start() error {
 		return nil
 	}
 
-	// 初始化复制目标
+	// 初始化复制目标，重新启动时保留已有的复制进度
 	for _, server := range ar.raftConfig.Servers {
-		if server.DataCenter != ar.raftConfig.MultiDC.LocalDataCenter.ID {
-			target := &AsyncReplicationTarget{
+		if server.DataCenter == ar.raftConfig.MultiDC.LocalDataCenter.ID {
+			continue
+		}
+		target, exists := ar.targets[server.DataCenter]
+		if !exists {
+			target = &AsyncReplicationTarget{
 				DataCenterID:    server.DataCenter,
-				NodeList:        []NodeID{server.ID},
 				IsHealthy:       true,
 				HealthCheckTime: time.Now(),
 			}
 			ar.targets[server.DataCenter] = target
+			ar.buffers[server.DataCenter] = &replicationBuffer{flushSignal: make(chan struct{}, 1)}
 			ar.metrics.DataCenterMetrics[server.DataCenter] = &DataCenterMetrics{}
 		}
+		known := false
+		for _, nodeID := range target.NodeList {
+			known = known || nodeID == server.ID
+		}
+		if !known {
+			target.NodeList = append(target.NodeList, server.ID)
+		}
+	}
+
+	ar.ctx, ar.cancel = context.WithCancel(context.Background())
+	for dcID := range ar.targets {
+		ar.wg.Add(1)
+		go ar.flushLoop(ar.ctx, dcID)
 	}
 
 	ar.isRunning = true
 	return nil
 }
 
-// Stop 停止异步复制管理器
+// Stop 停止异步复制管理器，刷新协程退出前发送缓冲区中剩余的条目
 func (ar *AsyncReplicator) Stop() error {
 	ar.mu.Lock()
-	defer ar.mu.Unlock()
-
 	if !ar.isRunning {
+		ar.mu.Unlock()
 		return nil
 	}
-
 	ar.cancel()
 	ar.isRunning = false
+	ar.mu.Unlock()
+
+	ar.wg.Wait()
 	return nil
 }
 
-// ReplicateAsync 执行异步复制
+// ReplicateAsync 把日志条目加入每个目标DC的缓冲区，由刷新协程按BatchSize和FlushInterval分批发送
 func (ar *AsyncReplicator) ReplicateAsync(entries []LogEntry) error {
-	ar.mu.RLock()
-	defer ar.mu.RUnlock()
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
 
 	if !ar.isRunning {
 		return fmt.Errorf("异步复制管理器未运行")
 	}
 
-	// 更新指标
-	ar.metrics.TotalEntriesReplicated += uint64(len(entries))
-	ar.metrics.TotalBatchesSent++
-
-	// 模拟异步复制处理
-	go func() {
-		for dcID := range ar.targets {
-			log.Printf("异步复制到DC %s: %d条日志条目", dcID, len(entries))
+	now := time.Now()
+	for dcID, buf := range ar.buffers {
+		for _, entry := range entries {
+			if entry.Index <= buf.lastQueued || entry.Index <= ar.targets[dcID].LastReplicatedIndex {
+				continue
+			}
+			buf.entries = append(buf.entries, entry)
+			buf.queuedAt = append(buf.queuedAt, now)
+			buf.lastQueued = entry.Index
+		}
+		if len(buf.entries) >= ar.config.BatchSize {
+			select {
+			case buf.flushSignal <- struct{}{}:
+			default:
+			}
 		}
-	}()
+	}
 
 	return nil
 }
 
+// flushLoop 目标DC的刷新协程
+func (ar *AsyncReplicator) flushLoop(ctx context.Context, dcID DataCenterID) {
+	defer ar.wg.Done()
+
+	ticker := time.NewTicker(ar.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ar.flush(ctx, dcID, true)
+		case <-ar.buffers[dcID].flushSignal:
+			ar.flush(ctx, dcID, false)
+		case <-ctx.Done():
+			ar.flush(ctx, dcID, true)
+			return
+		}
+	}
+}
+
+// flush 把缓冲区切成批次依次发送，force为false时只发送攒满的批次；发送失败的条目留在缓冲区等待下次刷新
+func (ar *AsyncReplicator) flush(ctx context.Context, dcID DataCenterID, force bool) {
+	for {
+		ar.mu.Lock()
+		buf := ar.buffers[dcID]
+		n := len(buf.entries)
+		if n == 0 || (!force && n < ar.config.BatchSize) {
+			ar.mu.Unlock()
+			return
+		}
+		if n > ar.config.BatchSize {
+			n = ar.config.BatchSize
+		}
+		batch := &ReplicationBatch{
+			TargetDC:   dcID,
+			Entries:    append([]LogEntry(nil), buf.entries[:n]...),
+			StartIndex: buf.entries[0].Index,
+			EndIndex:   buf.entries[n-1].Index,
+			CreatedAt:  buf.queuedAt[0],
+		}
+		ar.targets[dcID].PendingBatches++
+		ar.mu.Unlock()
+
+		err := ar.sendBatch(ctx, batch)
+
+		ar.mu.Lock()
+		target := ar.targets[dcID]
+		target.PendingBatches--
+		if err != nil {
+			target.IsHealthy = false
+			ar.mu.Unlock()
+			log.Printf("复制到DC %s 失败，条目 [%d, %d] 留在缓冲区: %v", dcID, batch.StartIndex, batch.EndIndex, err)
+			return
+		}
+		buf.entries = buf.entries[n:]
+		buf.queuedAt = buf.queuedAt[n:]
+		now := time.Now()
+		target.LastReplicatedIndex = batch.EndIndex
+		target.LastReplicatedTerm = batch.Entries[n-1].Term
+		target.LastReplicationTime = now
+		target.ReplicationLatency = now.Sub(batch.CreatedAt)
+		target.IsHealthy = true
+		target.HealthCheckTime = now
+		ar.mu.Unlock()
+	}
+}
+
+// sendBatch 轮询目标DC的节点发送批次，失败后等待RetryDelay重试，最多重试MaxRetries次
+func (ar *AsyncReplicator) sendBatch(ctx context.Context, batch *ReplicationBatch) error {
+	var err error
+	for attempt := 0; attempt <= ar.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			// 停止时仍然完成最后一次刷新，不再等待重试间隔
+			if ctx.Err() != nil {
+				break
+			}
+			batch.RetryCount++
+			select {
+			case <-time.After(ar.config.RetryDelay):
+			case <-ctx.Done():
+			}
+		}
+
+		ar.mu.Lock()
+		buf := ar.buffers[batch.TargetDC]
+		nodes := ar.targets[batch.TargetDC].NodeList
+		nodeID := nodes[buf.nextNode%len(nodes)]
+		buf.nextNode++
+		ar.mu.Unlock()
+
+		start := time.Now()
+		err = ar.transport.Send(nodeID, batch)
+		ar.recordAttempt(batch, attempt > 0, err, time.Since(start))
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// recordAttempt 记录一次发送尝试的结果
+func (ar *AsyncReplicator) recordAttempt(batch *ReplicationBatch, retry bool, err error, latency time.Duration) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	m := ar.metrics
+	dc := m.DataCenterMetrics[batch.TargetDC]
+	if retry {
+		m.RetryCount++
+	}
+
+	if err != nil {
+		m.ErrorCount++
+		m.LastErrorTime = time.Now()
+		dc.ErrorCount++
+	} else {
+		var bytes uint64
+		for _, entry := range batch.Entries {
+			bytes += uint64(len(entry.Data))
+		}
+		m.TotalBatchesSent++
+		m.TotalEntriesReplicated += uint64(len(batch.Entries))
+		m.TotalBytesTransferred += bytes
+		m.AverageLatency += (latency - m.AverageLatency) / time.Duration(m.TotalBatchesSent)
+		if latency > m.MaxLatency {
+			m.MaxLatency = latency
+		}
+		if m.MinLatency == 0 || latency < m.MinLatency {
+			m.MinLatency = latency
+		}
+
+		dc.BatchesSent++
+		dc.EntriesReplicated += uint64(len(batch.Entries))
+		dc.BytesTransferred += bytes
+		dc.AverageLatency += (latency - dc.AverageLatency) / time.Duration(dc.BatchesSent)
+	}
+
+	m.SuccessRate = float64(m.TotalBatchesSent) / float64(m.TotalBatchesSent+m.ErrorCount)
+	dc.SuccessRate = float64(dc.BatchesSent) / float64(dc.BatchesSent+dc.ErrorCount)
+}
+
 // GetReplicationStatus 获取复制状态
 func (ar *AsyncReplicator) GetReplicationStatus() map[DataCenterID]*AsyncReplicationTarget {
 	ar.mu.RLock()
@@ -275,6 +459,11 @@ func (ar *AsyncReplicator) GetMetrics() *AsyncReplicationMetrics {
 
 	// 返回指标的副本
 	metricsCopy := *ar.metrics
+	metricsCopy.DataCenterMetrics = make(map[DataCenterID]*DataCenterMetrics)
+	for dcID, dc := range ar.metrics.DataCenterMetrics {
+		dcCopy := *dc
+		metricsCopy.DataCenterMetrics[dcID] = &dcCopy
+	}
 	return &metricsCopy
 }
 
@@ -754,8 +943,8 @@ func (its *IntegrationTestSuite) TestMetricsIntegration() error {
 		return fmt.Errorf("指标测试异步复制失败: %v", err)
 	}
 
-	// 等待处理
-	time.Sleep(200 * time.Millisecond)
+	// 等待刷新协程发送
+	time.Sleep(its.replicator.config.FlushInterval + 200*time.Millisecond)
 
 	// 检查复制状态
 	status := its.replicator.GetReplicationStatus()
@@ -409,3 +409,10 @@ func (m *MockTransport) SendInstallSnapshot(ctx context.Context, target raft.Nod
 		Term: req.Term,
 	}, nil
 }
+
+func (m *MockTransport) SendTimeoutNow(ctx context.Context, target raft.NodeID, req *raft.TimeoutNowRequest) (*raft.TimeoutNowResponse, error) {
+	return &raft.TimeoutNowResponse{
+		Term:    req.Term,
+		Success: true,
+	}, nil
+}
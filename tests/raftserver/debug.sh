@@ -21,4 +21,4 @@ fi
 echo "文件列表:"
 ls -la concord_raft
 echo "运行服务器..."
-timeout 10s ./concord_raft -node node1 -listen :18080 -api :18081 
\ No newline at end of file
+timeout 10s ./concord_raft -node node1 -listen :18080 -api :18081 -allow-volatile
\ No newline at end of file
@@ -14,6 +14,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/concordkv/client/go/testutil"
 )
 
 // TestConnection_BasicOperations 测试连接基本操作
@@ -296,15 +298,37 @@ func TestConnectionPool_HealthCheck(t *testing.T) {
 	}
 }
 
-// TestConnectionPool_ConnectionLifecycle 测试连接生命周期管理
+// waitForPoolStats 等待连接池统计满足cond，超时返回最后一次的统计并失败
+func waitForPoolStats(t *testing.T, pool *ConnectionPool, cond func(stats *PoolStats) bool) *PoolStats {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := pool.GetStats()
+		if cond(stats) {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待连接池状态超时: %+v", stats)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestConnectionPool_ConnectionLifecycle 测试连接生命周期管理：每分钟的清理关闭空闲超过IdleTimeout
+// 或存在超过MaxLifetime的空闲连接，清理后补充到最小连接数；时间由FakeClock推进
 func TestConnectionPool_ConnectionLifecycle(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC))
+
 	config := DefaultPoolConfig()
 	config.MinConnections = 1
 	config.MaxConnections = 5
-	config.InitialSize = 1
-	config.IdleTimeout = 200 * time.Millisecond
-	config.MaxLifetime = 500 * time.Millisecond
+	config.InitialSize = 2
+	config.IdleTimeout = 3 * time.Minute
+	config.MaxLifetime = 5 * time.Minute
 	config.EnablePreWarm = false
+	config.EnableAutoScale = false
+	config.HealthCheckInterval = 0
+	config.Clock = clk
 
 	factory := NewMockConnectionFactory(5 * time.Second)
 	pool := NewConnectionPool(config, "node-1", "shard-001", "localhost:8080", factory)
@@ -317,20 +341,43 @@ func TestConnectionPool_ConnectionLifecycle(t *testing.T) {
 		t.Fatalf("启动连接池失败: %v", err)
 	}
 	defer pool.Stop()
+	// 等待清理循环创建定时器
+	clk.BlockUntil(1)
 
 	initialStats := pool.GetStats()
 	t.Logf("初始连接数: %d", initialStats.TotalConnections)
 
-	// 等待连接过期
-	time.Sleep(1 * time.Second)
+	// 第1.5分钟使用一个连接，第4分钟的清理只关闭另一个从未使用、空闲超过3分钟的连接
+	clk.Advance(90 * time.Second)
+	conn, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatalf("获取连接失败: %v", err)
+	}
+	pool.Put(conn)
+	clk.Advance(3 * time.Minute)
+	waitForPoolStats(t, pool, func(stats *PoolStats) bool {
+		return stats.ConnectionsDestroyed == 1 && stats.TotalConnections == 1
+	})
 
-	finalStats := pool.GetStats()
+	// 再次使用后不会空闲超时，但第6分钟的清理按MaxLifetime关闭它，并补充到最小连接数
+	conn, err = pool.Get(ctx)
+	if err != nil {
+		t.Fatalf("获取连接失败: %v", err)
+	}
+	pool.Put(conn)
+	clk.Advance(2 * time.Minute)
+	finalStats := waitForPoolStats(t, pool, func(stats *PoolStats) bool {
+		return stats.ConnectionsDestroyed == 2 && stats.ConnectionsCreated == 3
+	})
 	t.Logf("清理后连接数: %d", finalStats.TotalConnections)
 
 	// 验证过期连接被清理（但不低于最小连接数）
 	if finalStats.TotalConnections < int64(config.MinConnections) {
 		t.Errorf("清理后连接数不应该低于最小连接数，期望至少: %d, 实际: %d", config.MinConnections, finalStats.TotalConnections)
 	}
+	if finalStats.IdleConnections != 1 || finalStats.ActiveConnections != 0 {
+		t.Errorf("补充的连接应为空闲连接: %+v", finalStats)
+	}
 }
 
 // TestConnectionPool_Resize 测试连接池大小调整
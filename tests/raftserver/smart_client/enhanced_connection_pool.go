@@ -16,6 +16,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/concordkv/client/go/clock"
 )
 
 // ConnectionState 连接状态
@@ -68,6 +70,7 @@ type Connection struct {
 	isPreWarmed bool                   // 是否预热连接
 	metadata    map[string]interface{} // 元数据
 	pool        *ConnectionPool        // 所属连接池引用
+	clock       clock.Clock            // 记录创建与使用时间的时钟，加入连接池时换成连接池的时钟
 }
 
 // NewConnection 创建新连接
@@ -80,6 +83,7 @@ func NewConnection(id string, nodeID NodeID, shardID string, address string, tim
 		state:      ConnStateIdle,
 		createdAt:  time.Now(),
 		lastUsedAt: time.Now(),
+		clock:      clock.Real(),
 		maxErrors:  10,
 		timeout:    timeout,
 		keepAlive:  30 * time.Second,
@@ -87,6 +91,16 @@ func NewConnection(id string, nodeID NodeID, shardID string, address string, tim
 	}
 }
 
+// useClock 改用clk计时，创建与最后使用时间从clk的当前时间开始
+func (c *Connection) useClock(clk clock.Clock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.clock = clk
+	c.createdAt = clk.Now()
+	c.lastUsedAt = c.createdAt
+}
+
 // Connect 建立连接
 func (c *Connection) Connect(ctx context.Context) error {
 	c.mu.Lock()
@@ -113,7 +127,7 @@ func (c *Connection) Connect(ctx context.Context) error {
 
 	c.conn = conn
 	c.state = ConnStateActive
-	c.lastUsedAt = time.Now()
+	c.lastUsedAt = c.clock.Now()
 
 	return nil
 }
@@ -159,7 +173,7 @@ func (c *Connection) MarkUsed() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.lastUsedAt = time.Now()
+	c.lastUsedAt = c.clock.Now()
 	atomic.AddInt64(&c.usageCount, 1)
 	c.state = ConnStateActive
 }
@@ -189,7 +203,7 @@ func (c *Connection) GetStats() *ConnectionStats {
 		LastUsedAt:  c.lastUsedAt,
 		UsageCount:  atomic.LoadInt64(&c.usageCount),
 		ErrorCount:  len(c.errors),
-		IdleTime:    time.Since(c.lastUsedAt),
+		IdleTime:    c.clock.Now().Sub(c.lastUsedAt),
 		IsPreWarmed: c.isPreWarmed,
 	}
 }
@@ -252,6 +266,9 @@ type PoolConfig struct {
 	EnableBatching   bool          `json:"enableBatching"`   // 是否启用批量处理
 	BatchSize        int           `json:"batchSize"`        // 批量大小
 	BatchTimeout     time.Duration `json:"batchTimeout"`     // 批量超时
+
+	// Clock 连接池计时使用的时钟，包括健康检查、扩缩容与清理的定时器和连接的使用时间；为nil时使用真实时钟，测试中注入testutil.FakeClock
+	Clock clock.Clock `json:"-"`
 }
 
 // DefaultPoolConfig 默认连接池配置
@@ -300,6 +317,7 @@ type ConnectionPool struct {
 	isRunning       int64                  // 运行状态
 	waitQueue       chan chan *Connection  // 等待队列
 	factory         ConnectionFactory      // 连接工厂
+	clock           clock.Clock            // 计时使用的时钟，来自PoolConfig.Clock
 }
 
 // ConnectionFactory 连接工厂接口
@@ -365,6 +383,7 @@ func NewConnectionPool(config *PoolConfig, nodeID NodeID, shardID string, addres
 		stopChannel:     make(chan struct{}),
 		waitQueue:       make(chan chan *Connection, config.MaxConnections),
 		factory:         factory,
+		clock:           clock.Or(config.Clock),
 		stats: &PoolStats{
 			NodeID:  nodeID,
 			ShardID: shardID,
@@ -427,9 +446,9 @@ func (cp *ConnectionPool) Stop() error {
 
 // Get 获取连接
 func (cp *ConnectionPool) Get(ctx context.Context) (*Connection, error) {
-	start := time.Now()
+	start := cp.clock.Now()
 	defer func() {
-		waitTime := time.Since(start)
+		waitTime := cp.clock.Now().Sub(start)
 		cp.updateAverageWaitTime(waitTime)
 		atomic.AddInt64(&cp.stats.TotalRequests, 1)
 	}()
@@ -532,7 +551,7 @@ func (cp *ConnectionPool) GetStats() *PoolStats {
 	stats.TotalConnections = atomic.LoadInt64(&cp.totalCount)
 	stats.ActiveConnections = atomic.LoadInt64(&cp.activeCount)
 	stats.IdleConnections = int64(len(cp.idleConnections))
-	stats.LastUpdate = time.Now()
+	stats.LastUpdate = cp.clock.Now()
 
 	return &stats
 }
@@ -644,6 +663,7 @@ func (cp *ConnectionPool) createConnection(ctx context.Context) (*Connection, er
 	}
 
 	conn.pool = cp
+	conn.useClock(cp.clock)
 
 	// 检查是否是模拟连接工厂，如果是则跳过真实连接
 	if _, isMock := cp.factory.(*MockConnectionFactory); !isMock {
@@ -705,7 +725,7 @@ func (cp *ConnectionPool) scaleUp(count int) error {
 		cp.Put(conn)
 	}
 
-	cp.stats.LastScaleTime = time.Now()
+	cp.stats.LastScaleTime = cp.clock.Now()
 	return nil
 }
 
@@ -722,13 +742,13 @@ func (cp *ConnectionPool) scaleDown(count int) error {
 		removed++
 	}
 
-	cp.stats.LastScaleTime = time.Now()
+	cp.stats.LastScaleTime = cp.clock.Now()
 	return nil
 }
 
 // 内部方法：健康检查循环
 func (cp *ConnectionPool) healthCheckLoop(ctx context.Context) {
-	ticker := time.NewTicker(cp.config.HealthCheckInterval)
+	ticker := cp.clock.NewTicker(cp.config.HealthCheckInterval)
 	defer ticker.Stop()
 
 	for {
@@ -737,7 +757,7 @@ func (cp *ConnectionPool) healthCheckLoop(ctx context.Context) {
 			return
 		case <-cp.stopChannel:
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 			cp.performHealthCheck(ctx)
 		}
 	}
@@ -778,7 +798,7 @@ func (cp *ConnectionPool) checkConnectionHealth(ctx context.Context, conn *Conne
 
 // 内部方法：自动扩缩容循环
 func (cp *ConnectionPool) autoScaleLoop(ctx context.Context) {
-	ticker := time.NewTicker(cp.config.ScaleInterval)
+	ticker := cp.clock.NewTicker(cp.config.ScaleInterval)
 	defer ticker.Stop()
 
 	for {
@@ -787,7 +807,7 @@ func (cp *ConnectionPool) autoScaleLoop(ctx context.Context) {
 			return
 		case <-cp.stopChannel:
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 			cp.performAutoScale()
 		}
 	}
@@ -821,7 +841,7 @@ func (cp *ConnectionPool) performAutoScale() {
 
 // 内部方法：清理循环
 func (cp *ConnectionPool) cleanupLoop(ctx context.Context) {
-	ticker := time.NewTicker(1 * time.Minute) // 每分钟清理一次
+	ticker := cp.clock.NewTicker(1 * time.Minute) // 每分钟清理一次
 	defer ticker.Stop()
 
 	for {
@@ -830,7 +850,7 @@ func (cp *ConnectionPool) cleanupLoop(ctx context.Context) {
 			return
 		case <-cp.stopChannel:
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 			cp.performCleanup()
 		}
 	}
@@ -839,9 +859,8 @@ func (cp *ConnectionPool) cleanupLoop(ctx context.Context) {
 // 内部方法：执行清理
 func (cp *ConnectionPool) performCleanup() {
 	cp.mu.Lock()
-	defer cp.mu.Unlock()
 
-	now := time.Now()
+	now := cp.clock.Now()
 
 	// 清理过期的空闲连接
 	validIdle := make([]*Connection, 0, len(cp.idleConnections))
@@ -859,6 +878,24 @@ func (cp *ConnectionPool) performCleanup() {
 		validIdle = append(validIdle, conn)
 	}
 	cp.idleConnections = validIdle
+	cp.mu.Unlock()
+
+	// 清理后不足最小连接数时补充空闲连接
+	missing := cp.config.MinConnections - int(atomic.LoadInt64(&cp.totalCount))
+	if missing <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cp.config.ConnectionTimeout)
+	defer cancel()
+	for i := 0; i < missing; i++ {
+		conn, err := cp.createConnection(ctx)
+		if err != nil {
+			return
+		}
+		cp.mu.Lock()
+		cp.idleConnections = append(cp.idleConnections, conn)
+		cp.mu.Unlock()
+	}
 }
 
 // 内部方法：更新平均等待时间
@@ -1154,7 +1191,7 @@ func (mc *MockConnection) Connect(ctx context.Context) error {
 
 	// 模拟连接成功
 	mc.state = ConnStateActive
-	mc.lastUsedAt = time.Now()
+	mc.lastUsedAt = mc.clock.Now()
 
 	return nil
 }
@@ -1,3 +1,7 @@
 module smart_client_test
 
 go 1.23.4
+
+replace github.com/concordkv/client/go => ../../../client/go
+
+require github.com/concordkv/client/go v0.0.0-00010101000000-000000000000
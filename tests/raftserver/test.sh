@@ -17,7 +17,7 @@ fi
 
 # 启动服务器
 echo "启动服务器..."
-./concord_raft -node node1 -listen :21080 -api :21081 > server.log 2>&1 &
+./concord_raft -node node1 -listen :21080 -api :21081 -allow-volatile > server.log 2>&1 &
 SERVER_PID=$!
 
 # 等待服务器启动和选举完成
@@ -32,6 +32,7 @@ func main() {
 		MaxLogEntries:     100,
 		SnapshotThreshold: 1000,
 		Peers:             make(map[raft.NodeID]string),
+		AllowVolatile:     true,
 	}
 
 	// 添加自己到peers列表
@@ -165,7 +165,7 @@ main() {
     
     # 启动服务器
     log_info "启动服务器..."
-    ./concord_raft -node $NODE_ID -listen 127.0.0.1:$LISTEN_PORT -api 127.0.0.1:$API_PORT > server_test.log 2>&1 &
+    ./concord_raft -node $NODE_ID -listen 127.0.0.1:$LISTEN_PORT -api 127.0.0.1:$API_PORT -allow-volatile > server_test.log 2>&1 &
     SERVER_PID=$!
     
     # 等待服务器启动
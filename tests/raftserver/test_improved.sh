@@ -162,7 +162,7 @@ main() {
     
     # 启动服务器
     log_info "启动服务器..."
-    ./concord_raft -node $NODE_ID -listen :$LISTEN_PORT -api :$API_PORT > server_test.log 2>&1 &
+    ./concord_raft -node $NODE_ID -listen :$LISTEN_PORT -api :$API_PORT -allow-volatile > server_test.log 2>&1 &
     SERVER_PID=$!
     
     if [ -z "$SERVER_PID" ]; then